@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+)
+
+// conversationsArchiveCmd moves a dormant conversation into cold storage.
+func conversationsArchiveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "archive <peer>",
+		Short: "Move a conversation's session, ratchet state and history into an encrypted archive",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := appCtx.BackupService.ArchiveConversation(passphrase, historyPass(), args[0])
+			if err != nil {
+				return fmt.Errorf("archiving conversation with %s: %w", args[0], err)
+			}
+			fmt.Printf("Archived conversation with %s (%d history entries)\n", a.Peer, len(a.History))
+			fmt.Fprintf(os.Stderr, "Messages from %s are quarantined until you run `ciphera conversations unarchive %s`\n",
+				a.Peer, a.Peer)
+			recordAction(domain.Action{Kind: domain.ActionArchive, Peer: a.Peer})
+			return nil
+		},
+	}
+	addHistoryPassphraseFlag(cmd)
+	return cmd
+}
+
+// conversationsUnarchiveCmd restores an archived conversation.
+func conversationsUnarchiveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "unarchive <peer>",
+		Short: "Restore an archived conversation",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := appCtx.BackupService.UnarchiveConversation(passphrase, historyPass(), args[0])
+			if err != nil {
+				return fmt.Errorf("unarchiving conversation with %s: %w", args[0], err)
+			}
+			fmt.Printf("Restored conversation with %s, archived %s (%d history entries)\n",
+				a.Peer, time.Unix(a.ArchivedUTC, 0).UTC().Format(time.RFC3339), len(a.History))
+			fmt.Fprintln(os.Stderr, "Open messages held while it was archived with `ciphera quarantine retry -u <you>`")
+			return nil
+		},
+	}
+	addHistoryPassphraseFlag(cmd)
+	return cmd
+}
+
+// conversationsArchivedCmd lists archived conversations.
+func conversationsArchivedCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "archived",
+		Short: "List archived conversations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			as, err := appCtx.BackupService.ListArchives(passphrase)
+			if err != nil {
+				return fmt.Errorf("listing archives: %w", err)
+			}
+			if len(as) == 0 {
+				fmt.Println("No archived conversations")
+				return nil
+			}
+			for _, a := range as {
+				fmt.Printf("%s  archived %s, %d history entries\n",
+					a.Peer, time.Unix(a.ArchivedUTC, 0).UTC().Format(time.RFC3339), len(a.History))
+			}
+			return nil
+		},
+	}
+}
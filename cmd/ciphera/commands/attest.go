@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/crypto"
+)
+
+// attestCmd signs an attestation that a paired contact holds the identity key
+// received when pairing and sends it to them, and groups the list subcommand.
+func attestCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "attest <peer>",
+		Short: "Vouch for a paired contact's identity key to your other contacts",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := appCtx.MessageService.Attest(cmd.Context(), passphrase, username, args[0])
+			if err != nil {
+				return fmt.Errorf("attesting %q: %w", args[0], err)
+			}
+			fmt.Printf("Attested that %s holds %s; it is published when they next register\n",
+				a.Subject, crypto.Fingerprint(a.SubjectKey.Slice()))
+			return nil
+		},
+	}
+
+	// Username flag is local to this command.
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkFlagRequired("username")
+	cmd.AddCommand(attestListCmd())
+	return cmd
+}
+
+// attestListCmd prints the attestations contacts have sent about us.
+func attestListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List attestations contacts have made about you",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			atts, err := appCtx.MessageService.Attestations()
+			if err != nil {
+				return fmt.Errorf("listing attestations: %w", err)
+			}
+			if len(atts) == 0 {
+				fmt.Println("No attestations")
+				return nil
+			}
+			for _, a := range atts {
+				fmt.Printf("- %s vouches for %s  fingerprint %s  signed %s\n",
+					a.Attester,
+					a.Subject,
+					crypto.Fingerprint(a.SubjectKey.Slice()),
+					time.Unix(a.CreatedUTC, 0).UTC().Format(time.RFC3339),
+				)
+			}
+			return nil
+		},
+	}
+}
+
+// vouchers describes the contacts who attested a peer's identity key, or
+// returns "" if there are none.
+func vouchers(names []string) string {
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("verified by 1 contact you trust (%s)", names[0])
+	default:
+		return fmt.Sprintf("verified by %d contacts you trust (%s)", len(names), strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/crypto"
+)
+
+// backupCmd groups the push and restore subcommands for account backups kept
+// on the relay.
+func backupCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Keep an encrypted backup of your identity, sessions and contacts on the relay",
+	}
+
+	// Username flag is shared by both subcommands.
+	cmd.PersistentFlags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkPersistentFlagRequired("username")
+	cmd.AddCommand(backupPushCmd(), backupRestoreCmd())
+	return cmd
+}
+
+// backupPushCmd seals the account under the passphrase and stores it on the
+// relay, replacing any earlier backup.
+func backupPushCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "push",
+		Short: "Encrypt your account with your passphrase and store it on the relay",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := appCtx.BackupService.PushBackup(cmd.Context(), passphrase, username)
+			if err != nil {
+				return fmt.Errorf("pushing backup: %w", err)
+			}
+			fmt.Printf("Backed up %s (%d sessions, %d contacts) to the relay\n",
+				username, len(b.Sessions), len(b.Contacts))
+			return nil
+		},
+	}
+}
+
+// backupRestoreCmd fetches the account backup from the relay into an empty
+// home directory.
+func backupRestoreCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore",
+		Short: "Restore your account from the relay into an empty --home",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := appCtx.BackupService.RestoreBackup(cmd.Context(), passphrase, username)
+			if err != nil {
+				return fmt.Errorf("restoring backup: %w", err)
+			}
+			fmt.Printf("Restored %s from backup of %s (%d sessions, %d contacts)\n",
+				username,
+				time.Unix(b.CreatedUTC, 0).UTC().Format(time.RFC3339),
+				len(b.Sessions),
+				len(b.Contacts),
+			)
+			fmt.Printf("Fingerprint: %s\n", crypto.Fingerprint(b.Identity.XPub.Slice()))
+			fmt.Println("Run register to publish fresh prekeys, then ask each peer to run start-session --reset with you")
+			return nil
+		},
+	}
+}
@@ -0,0 +1,141 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+)
+
+// broadcastCmd groups the commands that manage broadcast lists. Messages are
+// sent to a list with `send @<list>`.
+func broadcastCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "broadcast",
+		Short: "Manage broadcast lists (send to one with send @<list>)",
+	}
+	cmd.AddCommand(
+		broadcastCreateCmd(),
+		broadcastListCmd(),
+		broadcastAddCmd(),
+		broadcastRemoveCmd(),
+		broadcastDeleteCmd(),
+	)
+	return cmd
+}
+
+// broadcastCreateCmd creates a list with its first members.
+func broadcastCreateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "create <name> <peer>...",
+		Short: "Create a broadcast list",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			l, err := appCtx.BroadcastService.Create(args[0], args[1:])
+			if err != nil {
+				return fmt.Errorf("creating list %q: %w", args[0], err)
+			}
+			printBroadcast(l)
+			return nil
+		},
+	}
+}
+
+// broadcastListCmd prints every list and its members.
+func broadcastListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List broadcast lists",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ls, err := appCtx.BroadcastService.List()
+			if err != nil {
+				return fmt.Errorf("listing broadcast lists: %w", err)
+			}
+			if len(ls) == 0 {
+				fmt.Println("No broadcast lists")
+				return nil
+			}
+			for _, l := range ls {
+				printBroadcast(l)
+			}
+			return nil
+		},
+	}
+}
+
+// broadcastAddCmd adds members to a list.
+func broadcastAddCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "add <name> <peer>...",
+		Short: "Add peers to a broadcast list",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			l, err := appCtx.BroadcastService.AddMembers(args[0], args[1:])
+			if err != nil {
+				return fmt.Errorf("adding to list %q: %w", args[0], err)
+			}
+			printBroadcast(l)
+			return nil
+		},
+	}
+}
+
+// broadcastRemoveCmd removes members from a list.
+func broadcastRemoveCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove <name> <peer>...",
+		Short: "Remove peers from a broadcast list",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			l, err := appCtx.BroadcastService.RemoveMembers(args[0], args[1:])
+			if err != nil {
+				return fmt.Errorf("removing from list %q: %w", args[0], err)
+			}
+			printBroadcast(l)
+			return nil
+		},
+	}
+}
+
+// broadcastDeleteCmd deletes a list. Conversations with its members are kept.
+func broadcastDeleteCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete <name>",
+		Short: "Delete a broadcast list",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := appCtx.BroadcastService.Delete(args[0]); err != nil {
+				return fmt.Errorf("deleting list %q: %w", args[0], err)
+			}
+			fmt.Printf("Deleted list %s\n", args[0])
+			return nil
+		},
+	}
+}
+
+// printBroadcast prints a list on one line: @name followed by its members.
+func printBroadcast(l domain.BroadcastList) {
+	fmt.Printf("@%s: %s\n", l.Name, strings.Join(l.Members, " "))
+}
+
+// printBroadcastResults prints one line per member and returns an error if
+// any delivery failed.
+func printBroadcastResults(name string, results []domain.BroadcastResult) error {
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			fmt.Printf("%s: failed: %v\n", r.Peer, r.Err)
+			continue
+		}
+		fmt.Printf("%s: sent\n", r.Peer)
+	}
+	if failed > 0 {
+		return fmt.Errorf("broadcast to @%s: %d of %d recipients failed", name, failed, len(results))
+	}
+	fmt.Printf("Message sent to %d recipients\n", len(results))
+	return nil
+}
@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"ciphera/internal/domain"
+)
+
+// challengeSolver answers a relay's token or CAPTCHA registration challenge
+// with answer if it is set, and otherwise asks through p.
+func challengeSolver(p *prompter, answer string) domain.ChallengeSolver {
+	return func(ctx context.Context, server string, c domain.RegistrationChallenge) (string, error) {
+		if answer != "" {
+			return answer, nil
+		}
+		switch c.Kind {
+		case domain.ChallengeToken:
+			if p.disabled {
+				return "", &InputRequiredError{
+					Input: "the registration token for " + server,
+					Hint:  "pass --challenge-answer",
+				}
+			}
+			fmt.Fprintf(p.out, "%s asks for an invitation token to register.\n", server)
+			return p.secret("Registration token")
+		case domain.ChallengeCAPTCHA:
+			if p.disabled {
+				return "", &InputRequiredError{
+					Input: "the CAPTCHA response for " + server,
+					Hint:  "solve it at " + c.URL + " and pass --challenge-answer",
+				}
+			}
+			fmt.Fprintf(p.out, "%s asks for a CAPTCHA to register. Solve it at %s\n", server, c.URL)
+			return p.line("CAPTCHA response token", "")
+		default:
+			return "", fmt.Errorf("unsupported registration challenge %q from %s", c.Kind, server)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+	draftsvc "ciphera/internal/services/draft"
+)
+
+// defaultEditor is run when neither $VISUAL nor $EDITOR is set.
+const defaultEditor = "vi"
+
+// composeCmd writes a message to <peer> in the user's editor and sends it
+// when the editor exits after a save. The draft is kept encrypted while the
+// editor runs and after any failure, so it can be resumed.
+func composeCmd() *cobra.Command {
+	var (
+		plain    bool
+		force    bool
+		expires  time.Duration
+		autosave time.Duration
+		saveOnly bool
+		list     bool
+		discard  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "compose <peer>",
+		Short: "Write a message in your editor (markdown, attachments, encrypted drafts) and send it",
+		Long: `Write a message to <peer> in $VISUAL or $EDITOR (vi if neither is set) and
+send it when you save and exit. The message is markdown unless --plain is
+given. A line of its own of the form
+
+    @attach <path>
+
+sends that file after the message, as a separate message carrying the file
+name; relative paths are taken from the current directory. Every file is
+read before anything is sent.
+
+The draft is saved, encrypted with your passphrase, whenever the editor
+writes the file and when it exits. Exiting without saving sends nothing, as
+does an editor that fails; run compose again to resume the draft. A send
+that fails keeps the draft too, less whatever was delivered. The editor
+works on a temporary file readable only by you, which is deleted
+afterwards.`,
+		Args: cobra.RangeArgs(0, 1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if list {
+				if len(args) != 0 {
+					return fmt.Errorf("--list takes no peer")
+				}
+				return listDrafts()
+			}
+			if len(args) != 1 {
+				return fmt.Errorf("compose needs a peer")
+			}
+			peer := args[0]
+			if strings.HasPrefix(peer, "@") {
+				return fmt.Errorf("compose writes to one peer; send to a list with `send %s`", peer)
+			}
+			if discard {
+				found, err := appCtx.DraftService.Discard(passphrase, peer)
+				if err != nil {
+					return fmt.Errorf("discarding draft to %q: %w", peer, err)
+				}
+				if !found {
+					return fmt.Errorf("discarding draft to %q: %w", peer, draftsvc.ErrNoDraft)
+				}
+				fmt.Println("Draft discarded")
+				return nil
+			}
+			if expires < 0 {
+				return fmt.Errorf("--expires must not be negative")
+			}
+			if autosave <= 0 {
+				return fmt.Errorf("--autosave must be positive")
+			}
+			if username == "" && !saveOnly {
+				return fmt.Errorf("--username is required to send; use --save-only to keep a draft")
+			}
+			if nonInteractive {
+				return &InputRequiredError{Input: "the message", Hint: "use `send`, which takes it as an argument or from stdin"}
+			}
+
+			d, found, err := appCtx.DraftService.Draft(passphrase, peer)
+			if err != nil {
+				return fmt.Errorf("opening draft to %q: %w", peer, err)
+			}
+			contentType := d.ContentType
+			if !found || cmd.Flags().Changed("plain") {
+				contentType = body.TypeMarkdown
+				if plain {
+					contentType = body.TypeText
+				}
+			}
+			save := func(text string) error {
+				_, err := appCtx.DraftService.Save(passphrase, peer, text, contentType)
+				return err
+			}
+
+			text, saved, editErr := editDraft(d.Text, contentType, autosave, save)
+			if saved && text != d.Text {
+				if err := save(text); err != nil {
+					return fmt.Errorf("saving draft to %q: %w", peer, err)
+				}
+			}
+			switch {
+			case editErr != nil:
+				return fmt.Errorf("editing message to %q: %w; nothing was sent", peer, editErr)
+			case !saved && found:
+				fmt.Println("Draft not saved; nothing sent. Run compose again to resume it")
+				return nil
+			case !saved:
+				fmt.Println("Message not saved; nothing sent")
+				return nil
+			case saveOnly:
+				fmt.Println("Draft saved; nothing sent")
+				return nil
+			}
+
+			// Ctrl-C in the editor, which vi uses to leave insert mode, also
+			// reaches this process and cancels the command's context. Only
+			// an interrupt from here on stops the send.
+			ctx, stop := signal.NotifyContext(context.WithoutCancel(cmd.Context()), os.Interrupt)
+			defer stop()
+			sent, err := appCtx.DraftService.Send(ctx, passphrase, username, peer, force, expires)
+			if errors.Is(err, draftsvc.ErrEmptyDraft) {
+				if _, err := appCtx.DraftService.Discard(passphrase, peer); err != nil {
+					return fmt.Errorf("discarding empty draft to %q: %w", peer, err)
+				}
+				fmt.Println("Message is empty; draft discarded, nothing sent")
+				return nil
+			}
+			printDraftSent(sent)
+			if err != nil {
+				return fmt.Errorf("sending message to %q: %w; the rest is kept as a draft", peer, err)
+			}
+			return nil
+		},
+	}
+
+	// Username flag is local to this command (others inherit from the root).
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	cmd.Flags().BoolVar(
+		&plain,
+		"plain",
+		false,
+		"send the message as text/plain instead of markdown",
+	)
+	cmd.Flags().BoolVar(
+		&force,
+		"force",
+		false,
+		"send even if the send policy requires a verified peer or the peer lacks a capability",
+	)
+	cmd.Flags().DurationVar(
+		&expires,
+		"expires",
+		0,
+		"have the relay drop the messages if still unfetched after this long, e.g. 1h",
+	)
+	cmd.Flags().DurationVar(
+		&autosave,
+		"autosave",
+		2*time.Second,
+		"how often to check the editor's file for changes to save to the draft",
+	)
+	cmd.Flags().BoolVar(
+		&saveOnly,
+		"save-only",
+		false,
+		"save the draft when the editor exits, without sending it",
+	)
+	cmd.Flags().BoolVar(
+		&list,
+		"list",
+		false,
+		"list your drafts instead of editing one",
+	)
+	cmd.Flags().BoolVar(
+		&discard,
+		"discard",
+		false,
+		"delete the draft to <peer> without sending it",
+	)
+	cmd.MarkFlagsMutuallyExclusive("list", "discard", "save-only")
+
+	return cmd
+}
+
+// editDraft runs the editor on a private temporary copy of text and returns
+// what the file holds when the editor exits, and whether it was written at
+// all. While the editor runs, the file is checked every interval and save is
+// called with each new version; failures are reported on stderr.
+func editDraft(text, contentType string, interval time.Duration, save func(string) error) (string, bool, error) {
+	dir, err := os.MkdirTemp("", "ciphera-compose-")
+	if err != nil {
+		return "", false, err
+	}
+	defer os.RemoveAll(dir)
+
+	// The extension lets the editor highlight markdown.
+	name := "message.txt"
+	if contentType == body.TypeMarkdown {
+		name = "message.md"
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+		return "", false, err
+	}
+	start, err := os.Stat(path)
+	if err != nil {
+		return "", false, err
+	}
+
+	argv := editorCommand()
+	// Not tied to the command's context: an interrupt must not kill the
+	// editor, which may use Ctrl-C itself.
+	editor := exec.Command(argv[0], append(argv[1:], path)...)
+	editor.Stdin, editor.Stdout, editor.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := editor.Start(); err != nil {
+		return "", false, fmt.Errorf("starting editor %q: %w", argv[0], err)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		last, lastText := start, text
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+			fi, err := os.Stat(path)
+			if err != nil || (fi.ModTime().Equal(last.ModTime()) && fi.Size() == last.Size()) {
+				continue
+			}
+			last = fi
+			b, err := os.ReadFile(path)
+			if err != nil || string(b) == lastText {
+				continue
+			}
+			if err := save(string(b)); err != nil {
+				fmt.Fprintf(os.Stderr, "Draft autosave failed: %v\n", err)
+				continue
+			}
+			lastText = string(b)
+		}
+	}()
+	runErr := editor.Wait()
+	close(done)
+	<-stopped
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, errors.Join(runErr, err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", false, errors.Join(runErr, err)
+	}
+	saved := string(b) != text || !fi.ModTime().Equal(start.ModTime())
+	if runErr != nil {
+		return string(b), saved, fmt.Errorf("editor %q: %w", argv[0], runErr)
+	}
+	return string(b), saved, nil
+}
+
+// editorCommand returns the user's editor as a program and its arguments,
+// from $VISUAL, then $EDITOR, then defaultEditor.
+func editorCommand() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if f := strings.Fields(os.Getenv(env)); len(f) > 0 {
+			return f
+		}
+	}
+	return []string{defaultEditor}
+}
+
+// printDraftSent says what a draft send delivered, if anything.
+func printDraftSent(sent domain.DraftSent) {
+	if sent.Body {
+		fmt.Println("Message sent")
+	}
+	for _, p := range sent.Attachments {
+		fmt.Printf("Attachment sent: %s\n", p)
+	}
+}
+
+// listDrafts prints every draft with when it was last saved.
+func listDrafts() error {
+	ds, err := appCtx.DraftService.List(passphrase)
+	if err != nil {
+		return fmt.Errorf("listing drafts: %w", err)
+	}
+	if len(ds) == 0 {
+		fmt.Println("No drafts")
+		return nil
+	}
+	for _, d := range ds {
+		fmt.Printf("%s\t%s\t%s\t%d bytes\n",
+			d.Peer,
+			time.Unix(d.UpdatedUTC, 0).UTC().Format(time.RFC3339),
+			d.ContentType,
+			len(d.Text),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,714 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+)
+
+// conversationsCmd groups the commands that manage local per-conversation
+// preferences (mute, notifications, previews, send policy, remote wipe, new
+// sessions and history retention), the rekey, resend, oversize, session and
+// watchdog policies, the preferred cipher suite, and archiving dormant
+// conversations.
+func conversationsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "conversations",
+		Short: "Manage per-conversation mute, notification and send preferences",
+	}
+	cmd.AddCommand(
+		conversationsListCmd(),
+		conversationsMuteCmd(),
+		conversationsUnmuteCmd(),
+		conversationsNotifyCmd(),
+		conversationsPreviewCmd(),
+		conversationsPolicyCmd(),
+		conversationsDefaultPolicyCmd(),
+		conversationsRemoteWipeCmd(),
+		conversationsSessionPolicyCmd(),
+		conversationsNewSessionCmd(),
+		conversationsRekeyCmd(),
+		conversationsResendCmd(),
+		conversationsWatchdogCmd(),
+		conversationsOversizeCmd(),
+		conversationsSuiteCmd(),
+		conversationsFiltersCmd(),
+		conversationsRetentionCmd(),
+		conversationsDefaultRetentionCmd(),
+		conversationsArchiveCmd(),
+		conversationsUnarchiveCmd(),
+		conversationsArchivedCmd(),
+	)
+	return cmd
+}
+
+// conversationsListCmd prints every conversation with saved preferences.
+func conversationsListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List conversation preferences",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ps, err := appCtx.ConversationService.ListPreferences()
+			if err != nil {
+				return fmt.Errorf("listing preferences: %w", err)
+			}
+			if len(ps) == 0 {
+				fmt.Println("No conversation preferences set")
+				return nil
+			}
+			for _, p := range ps {
+				printPrefs(p)
+			}
+			return nil
+		},
+	}
+}
+
+// conversationsMuteCmd mutes a peer, indefinitely or for --for.
+func conversationsMuteCmd() *cobra.Command {
+	var dur time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "mute <peer>",
+		Short: "Mute notifications from a peer",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if dur < 0 {
+				return fmt.Errorf("--for must not be negative")
+			}
+			p, err := appCtx.ConversationService.Mute(args[0], dur)
+			if err != nil {
+				return fmt.Errorf("muting %q: %w", args[0], err)
+			}
+			printPrefs(p)
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(
+		&dur,
+		"for",
+		0,
+		"mute for this long, e.g. 8h (default: until unmuted)",
+	)
+	return cmd
+}
+
+// conversationsUnmuteCmd lifts a mute.
+func conversationsUnmuteCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unmute <peer>",
+		Short: "Unmute a peer",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := appCtx.ConversationService.Unmute(args[0])
+			if err != nil {
+				return fmt.Errorf("unmuting %q: %w", args[0], err)
+			}
+			printPrefs(p)
+			return nil
+		},
+	}
+}
+
+// conversationsNotifyCmd sets whether a peer's messages raise notifications.
+func conversationsNotifyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:       "notify <peer> always|never",
+		Short:     "Choose whether a peer's messages raise notifications",
+		Args:      cobra.ExactArgs(2),
+		ValidArgs: []string{string(domain.NotifyAlways), string(domain.NotifyNever)},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := appCtx.ConversationService.SetNotify(args[0], domain.NotifyMode(args[1]))
+			if err != nil {
+				return fmt.Errorf("setting notify for %q: %w", args[0], err)
+			}
+			printPrefs(p)
+			return nil
+		},
+	}
+}
+
+// conversationsPreviewCmd sets whether notifications include the message text.
+func conversationsPreviewCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "preview <peer> on|off",
+		Short: "Show or hide message text in a peer's notifications",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var show bool
+			switch args[1] {
+			case "on":
+				show = true
+			case "off":
+				show = false
+			default:
+				return fmt.Errorf("preview must be on or off, got %q", args[1])
+			}
+			p, err := appCtx.ConversationService.SetPreview(args[0], show)
+			if err != nil {
+				return fmt.Errorf("setting preview for %q: %w", args[0], err)
+			}
+			printPrefs(p)
+			return nil
+		},
+	}
+}
+
+// conversationsPolicyCmd sets whether sending to a peer requires verification.
+func conversationsPolicyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "policy <peer> allow|require-verified|default",
+		Short: "Choose whether sending to a peer requires a verified identity",
+		Args:  cobra.ExactArgs(2),
+		ValidArgs: []string{
+			string(domain.SendPolicyAllow),
+			string(domain.SendPolicyRequireVerified),
+			"default",
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			policy := domain.SendPolicy(args[1])
+			if args[1] == "default" {
+				policy = domain.SendPolicyDefault
+			}
+			p, err := appCtx.ConversationService.SetSendPolicy(args[0], policy)
+			if err != nil {
+				return fmt.Errorf("setting send policy for %q: %w", args[0], err)
+			}
+			printPrefs(p)
+			return nil
+		},
+	}
+}
+
+// conversationsDefaultPolicyCmd shows or sets the policy for peers without their own.
+func conversationsDefaultPolicyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:       "default-policy [allow|require-verified]",
+		Short:     "Show or set the send policy for peers without their own",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{string(domain.SendPolicyAllow), string(domain.SendPolicyRequireVerified)},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				if err := appCtx.ConversationService.SetDefaultSendPolicy(domain.SendPolicy(args[0])); err != nil {
+					return fmt.Errorf("setting default send policy: %w", err)
+				}
+			}
+			policy, err := appCtx.ConversationService.DefaultSendPolicy()
+			if err != nil {
+				return fmt.Errorf("reading default send policy: %w", err)
+			}
+			fmt.Printf("Default send policy: %s\n", policy)
+			return nil
+		},
+	}
+}
+
+// conversationsRekeyCmd shows or sets when conversations we started re-run X3DH.
+func conversationsRekeyCmd() *cobra.Command {
+	var days, messages int
+	cmd := &cobra.Command{
+		Use:       "rekey [off]",
+		Short:     "Show or set when conversations you started move to a fresh root key",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			set := cmd.Flags().Changed("days") || cmd.Flags().Changed("messages")
+			if len(args) == 1 {
+				if args[0] != "off" || set {
+					return fmt.Errorf("use either off or --days/--messages, got %q", args[0])
+				}
+				days, messages, set = 0, 0, true
+			}
+			if set {
+				p := domain.RekeyPolicy{Days: days, Messages: messages}
+				if err := appCtx.ConversationService.SetRekeyPolicy(p); err != nil {
+					return fmt.Errorf("setting rekey policy: %w", err)
+				}
+			}
+			p, err := appCtx.ConversationService.RekeyPolicy()
+			if err != nil {
+				return fmt.Errorf("reading rekey policy: %w", err)
+			}
+			if !p.Enabled() {
+				fmt.Println("Rekey policy: off")
+				return nil
+			}
+			fmt.Printf("Rekey policy: days=%d messages=%d\n", p.Days, p.Messages)
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&days, "days", 0, "rekey once the root key is this many days old (0 = never)")
+	cmd.Flags().IntVar(&messages, "messages", 0, "rekey after this many messages (0 = never)")
+	return cmd
+}
+
+// conversationsResendCmd shows or sets how long a gap in a conversation lasts
+// before the peer is asked to resend the missing messages.
+func conversationsResendCmd() *cobra.Command {
+	var after time.Duration
+	cmd := &cobra.Command{
+		Use:       "resend [off]",
+		Short:     "Show or set how long missing messages wait before the peer is asked to resend them",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			set := cmd.Flags().Changed("after")
+			if len(args) == 1 {
+				if args[0] != "off" || set {
+					return fmt.Errorf("use either off or --after, got %q", args[0])
+				}
+				after, set = 0, true
+			}
+			if set {
+				if err := appCtx.ConversationService.SetResendAfter(after); err != nil {
+					return fmt.Errorf("setting resend delay: %w", err)
+				}
+			}
+			d, err := appCtx.ConversationService.ResendAfter()
+			if err != nil {
+				return fmt.Errorf("reading resend delay: %w", err)
+			}
+			if d == 0 {
+				fmt.Println("Resend requests: off")
+				return nil
+			}
+			fmt.Printf("Resend requests: after %s\n", d)
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&after, "after", 0, "ask for missing messages once they have been missing this long (0 = never)")
+	return cmd
+}
+
+// conversationsWatchdogCmd shows or sets when recv --follow warns about a
+// conversation whose peer stopped answering.
+func conversationsWatchdogCmd() *cobra.Command {
+	var days, messages int
+	cmd := &cobra.Command{
+		Use:       "watchdog [off]",
+		Short:     "Show or set when recv --follow warns that a peer has stopped answering",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			set := cmd.Flags().Changed("days") || cmd.Flags().Changed("messages")
+			if len(args) == 1 {
+				if args[0] != "off" || set {
+					return fmt.Errorf("use either off or --days/--messages, got %q", args[0])
+				}
+				days, messages, set = 0, 0, true
+			}
+			if set {
+				p := domain.WatchdogPolicy{Days: days, Messages: messages}
+				if err := appCtx.ConversationService.SetWatchdogPolicy(p); err != nil {
+					return fmt.Errorf("setting watchdog policy: %w", err)
+				}
+			}
+			p, err := appCtx.ConversationService.WatchdogPolicy()
+			if err != nil {
+				return fmt.Errorf("reading watchdog policy: %w", err)
+			}
+			if !p.Enabled() {
+				fmt.Println("Watchdog: off")
+				return nil
+			}
+			fmt.Printf("Watchdog: days=%d messages=%d\n", p.Days, p.Messages)
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&days, "days", 0, "warn once the first unanswered message is this many days old (0 = never)")
+	cmd.Flags().IntVar(&messages, "messages", 0, "warn after this many unanswered messages (0 = never)")
+	return cmd
+}
+
+// conversationsFiltersCmd shows or sets which received messages are held for review.
+func conversationsFiltersCmd() *cobra.Command {
+	var f domain.ReceiveFilters
+	cmd := &cobra.Command{
+		Use:       "filters [off]",
+		Short:     "Show or set which received messages are held for review",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"off"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+			set := flags.Changed("max-size") || flags.Changed("type") || flags.Changed("sender")
+			if len(args) == 1 && (args[0] != "off" || set) {
+				return fmt.Errorf("use either off or --max-size/--type/--sender, got %q", args[0])
+			}
+			cur, err := appCtx.ConversationService.ReceiveFilters()
+			if err != nil {
+				return fmt.Errorf("reading receive filters: %w", err)
+			}
+			before := cur
+			switch {
+			case len(args) == 1:
+				cur = domain.ReceiveFilters{}
+			case set:
+				// Flags left out keep their current setting.
+				if flags.Changed("max-size") {
+					cur.MaxSize = f.MaxSize
+				}
+				if flags.Changed("type") {
+					cur.ContentTypes = f.ContentTypes
+				}
+				if flags.Changed("sender") {
+					cur.Senders = f.Senders
+				}
+			}
+			if len(args) == 1 || set {
+				if err := appCtx.ConversationService.SetReceiveFilters(cur); err != nil {
+					return fmt.Errorf("setting receive filters: %w", err)
+				}
+				recordAction(domain.Action{Kind: domain.ActionFilters, Filters: &before})
+			}
+			if !cur.Enabled() {
+				fmt.Println("Receive filters: off")
+				return nil
+			}
+			fmt.Println("Receive filters:")
+			if cur.MaxSize > 0 {
+				fmt.Printf("  max size: %d bytes\n", cur.MaxSize)
+			}
+			if len(cur.ContentTypes) > 0 {
+				fmt.Printf("  types:    %s\n", strings.Join(cur.ContentTypes, ", "))
+			}
+			if len(cur.Senders) > 0 {
+				fmt.Printf("  senders:  %s\n", strings.Join(cur.Senders, ", "))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&f.MaxSize, "max-size", 0, "hold messages with a body larger than this many bytes (0 = no limit)")
+	cmd.Flags().StringSliceVar(&f.ContentTypes, "type", nil, "only let through these content types, e.g. text/plain (empty = any)")
+	cmd.Flags().StringSliceVar(&f.Senders, "sender", nil, "only let through messages from these peers (empty = anyone)")
+	return cmd
+}
+
+// conversationsOversizeCmd shows or sets what happens to messages too large for one envelope.
+func conversationsOversizeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:       "oversize [chunk|fail]",
+		Short:     "Show or set whether messages too large for one envelope are sent in chunks",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{string(domain.OversizeChunk), string(domain.OversizeFail)},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				if err := appCtx.ConversationService.SetOversizePolicy(domain.OversizePolicy(args[0])); err != nil {
+					return fmt.Errorf("setting oversize policy: %w", err)
+				}
+			}
+			policy, err := appCtx.ConversationService.OversizePolicy()
+			if err != nil {
+				return fmt.Errorf("reading oversize policy: %w", err)
+			}
+			fmt.Printf("Oversize policy: %s\n", policy)
+			return nil
+		},
+	}
+}
+
+// conversationsSuiteCmd lists the cipher suites and shows or sets the one
+// offered first in handshakes we start.
+func conversationsSuiteCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:       "suite [id|default]",
+		Short:     "List cipher suites and show or set the one new sessions prefer",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: append(crypto.SuiteIDs(), "default"),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				id := args[0]
+				if id == "default" {
+					id = ""
+				}
+				if err := appCtx.ConversationService.SetSuite(id); err != nil {
+					return fmt.Errorf("setting cipher suite: %w", err)
+				}
+			}
+			preferred, err := appCtx.ConversationService.Suite()
+			if err != nil {
+				return fmt.Errorf("reading cipher suite: %w", err)
+			}
+			if preferred == "" {
+				preferred = crypto.DefaultSuite
+			}
+			for _, s := range crypto.Suites() {
+				mark := " "
+				if s.ID == preferred {
+					mark = "*"
+				}
+				fmt.Printf("%s %s\tcurve=%s kdf=%s-%s aead=%s\n", mark, s.ID, s.Curve, s.KDF, s.Hash, s.AEAD)
+			}
+			return nil
+		},
+	}
+}
+
+// conversationsRemoteWipeCmd sets whether a peer's wipe requests are honoured.
+func conversationsRemoteWipeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:       "remote-wipe <peer> accept|refuse",
+		Short:     "Choose whether a peer may wipe your conversation with them",
+		Args:      cobra.ExactArgs(2),
+		ValidArgs: []string{"accept", "refuse"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var accept bool
+			switch args[1] {
+			case "accept":
+				accept = true
+			case "refuse":
+				accept = false
+			default:
+				return fmt.Errorf("remote-wipe must be accept or refuse, got %q", args[1])
+			}
+			p, err := appCtx.ConversationService.SetAcceptWipe(args[0], accept)
+			if err != nil {
+				return fmt.Errorf("setting remote wipe for %q: %w", args[0], err)
+			}
+			printPrefs(p)
+			return nil
+		},
+	}
+}
+
+// conversationsSessionPolicyCmd shows or sets how new sessions and our
+// prekeys are handled. Flags not given keep their current value.
+func conversationsSessionPolicyCmd() *cobra.Command {
+	var (
+		acceptNew   string
+		replenish   int
+		rotateDays  int
+		verifyReply string
+	)
+	cmd := &cobra.Command{
+		Use:   "session-policy",
+		Short: "Show or set how new sessions are accepted and prekeys kept fresh",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := appCtx.ConversationService.SessionPolicy()
+			if err != nil {
+				return fmt.Errorf("reading session policy: %w", err)
+			}
+			set := false
+			if cmd.Flags().Changed("accept-new") {
+				p.AcceptNew, set = domain.AcceptMode(acceptNew), true
+			}
+			if cmd.Flags().Changed("replenish-opks") {
+				p.ReplenishOPKs, set = replenish, true
+			}
+			if cmd.Flags().Changed("rotate-spk-days") {
+				p.RotateSPKDays, set = rotateDays, true
+			}
+			if cmd.Flags().Changed("verify-before-reply") {
+				switch verifyReply {
+				case "on":
+					p.VerifyBeforeReply = true
+				case "off":
+					p.VerifyBeforeReply = false
+				default:
+					return fmt.Errorf("--verify-before-reply must be on or off, got %q", verifyReply)
+				}
+				set = true
+			}
+			if set {
+				if err := appCtx.ConversationService.SetSessionPolicy(p); err != nil {
+					return fmt.Errorf("setting session policy: %w", err)
+				}
+				if p, err = appCtx.ConversationService.SessionPolicy(); err != nil {
+					return fmt.Errorf("reading session policy: %w", err)
+				}
+			}
+			verify := "off"
+			if p.VerifyBeforeReply {
+				verify = "on"
+			}
+			fmt.Printf("Session policy: accept-new=%s replenish-opks=%d rotate-spk-days=%d verify-before-reply=%s\n",
+				p.AcceptNew, p.ReplenishOPKs, p.RotateSPKDays, verify)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&acceptNew, "accept-new", "", "accept new sessions from unpaired peers: yes, no (drop them) or ask (quarantine them)")
+	cmd.Flags().IntVar(&replenish, "replenish-opks", 0, "republish prekeys once a relay offers fewer unused one-time prekeys, checked on a schedule (0 = never)")
+	cmd.Flags().IntVar(&rotateDays, "rotate-spk-days", 0, "republish with a fresh signed prekey once it is this many days old (0 = never)")
+	cmd.Flags().StringVar(&verifyReply, "verify-before-reply", "", "on: refuse to reply in conversations a peer started until you pair with them")
+	return cmd
+}
+
+// conversationsNewSessionCmd sets whether a peer's new sessions are accepted
+// whatever the session policy says.
+func conversationsNewSessionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:       "new-session <peer> accept|policy",
+		Short:     "Accept new sessions from a peer, or leave them to the session policy",
+		Args:      cobra.ExactArgs(2),
+		ValidArgs: []string{"accept", "policy"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var accept bool
+			switch args[1] {
+			case "accept":
+				accept = true
+			case "policy":
+				accept = false
+			default:
+				return fmt.Errorf("new-session must be accept or policy, got %q", args[1])
+			}
+			p, err := appCtx.ConversationService.SetAcceptSession(args[0], accept)
+			if err != nil {
+				return fmt.Errorf("setting new sessions for %q: %w", args[0], err)
+			}
+			printPrefs(p)
+			if accept {
+				fmt.Fprintln(os.Stderr, "Run `ciphera quarantine retry -u <you>` to open messages already held back")
+			}
+			return nil
+		},
+	}
+}
+
+// retentionFlags are the flags that describe a retention policy.
+type retentionFlags struct {
+	last, days int
+	none, all  bool
+}
+
+// add registers the retention flags on cmd.
+func (f *retentionFlags) add(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&f.last, "last", 0, "keep only the newest N messages")
+	cmd.Flags().IntVar(&f.days, "days", 0, "remove messages older than D days")
+	cmd.Flags().BoolVar(&f.none, "none", false, "keep no history at all")
+	cmd.Flags().BoolVar(&f.all, "all", false, "keep everything")
+}
+
+// policy returns the policy the flags describe, and whether any was given.
+func (f *retentionFlags) policy(cmd *cobra.Command) (domain.RetentionPolicy, bool, error) {
+	set := cmd.Flags().Changed("last") || cmd.Flags().Changed("days") || f.none
+	if f.all && set {
+		return domain.RetentionPolicy{}, false, fmt.Errorf("--all cannot be combined with other retention flags")
+	}
+	return domain.RetentionPolicy{KeepLast: f.last, KeepDays: f.days, KeepNone: f.none}, set || f.all, nil
+}
+
+// conversationsRetentionCmd sets how much history is kept for one peer.
+func conversationsRetentionCmd() *cobra.Command {
+	var (
+		flags retentionFlags
+		def   bool
+	)
+	cmd := &cobra.Command{
+		Use:   "retention <peer> --last N | --days D | --none | --all | --default",
+		Short: "Set how much local history is kept for a peer",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, set, err := flags.policy(cmd)
+			if err != nil {
+				return err
+			}
+			if set == def {
+				return fmt.Errorf("give a retention (--last, --days, --none or --all) or --default")
+			}
+			var override *domain.RetentionPolicy
+			if set {
+				override = &p
+			}
+			prefs, err := appCtx.ConversationService.SetRetention(args[0], override)
+			if err != nil {
+				return fmt.Errorf("setting retention for %q: %w", args[0], err)
+			}
+			printPrefs(prefs)
+			return nil
+		},
+	}
+	flags.add(cmd)
+	cmd.Flags().BoolVar(&def, "default", false, "use the default retention")
+	return cmd
+}
+
+// conversationsDefaultRetentionCmd shows or sets the retention for peers
+// without their own.
+func conversationsDefaultRetentionCmd() *cobra.Command {
+	var flags retentionFlags
+	cmd := &cobra.Command{
+		Use:   "default-retention [--last N] [--days D] [--none | --all]",
+		Short: "Show or set how much local history is kept for peers without their own setting",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, set, err := flags.policy(cmd)
+			if err != nil {
+				return err
+			}
+			if set {
+				if err := appCtx.ConversationService.SetDefaultRetention(p); err != nil {
+					return fmt.Errorf("setting default retention: %w", err)
+				}
+			}
+			p, err = appCtx.ConversationService.DefaultRetention()
+			if err != nil {
+				return fmt.Errorf("reading default retention: %w", err)
+			}
+			fmt.Printf("Default retention: %s\n", formatRetention(p))
+			return nil
+		},
+	}
+	flags.add(cmd)
+	return cmd
+}
+
+// formatRetention renders p as "all", "none" or its limits, e.g.
+// "last=100,days=30".
+func formatRetention(p domain.RetentionPolicy) string {
+	switch {
+	case p.KeepNone:
+		return "none"
+	case p.Unlimited():
+		return "all"
+	}
+	var parts []string
+	if p.KeepLast > 0 {
+		parts = append(parts, "last="+strconv.Itoa(p.KeepLast))
+	}
+	if p.KeepDays > 0 {
+		parts = append(parts, "days="+strconv.Itoa(p.KeepDays))
+	}
+	return strings.Join(parts, ",")
+}
+
+// printPrefs prints one conversation's preferences on a single line.
+func printPrefs(p domain.ConversationPrefs) {
+	muted := "unmuted"
+	switch {
+	case p.Muted && p.MutedUntilUTC == 0:
+		muted = "muted"
+	case p.Muted:
+		muted = "muted until " + time.Unix(p.MutedUntilUTC, 0).UTC().Format(time.RFC3339)
+	}
+	preview := "on"
+	if p.HidePreview {
+		preview = "off"
+	}
+	policy := string(p.SendPolicy)
+	if p.SendPolicy == domain.SendPolicyDefault {
+		policy = "default"
+	}
+	wipe := "refuse"
+	if p.AcceptWipe {
+		wipe = "accept"
+	}
+	session := "policy"
+	if p.AcceptSession {
+		session = "accept"
+	}
+	retention := "default"
+	if p.Retention != nil {
+		retention = formatRetention(*p.Retention)
+	}
+	fmt.Printf("%s\t%s\tnotify=%s\tpreview=%s\tpolicy=%s\tremote-wipe=%s\tnew-session=%s\tretention=%s\n",
+		p.Peer, muted, p.Notify, preview, policy, wipe, session, retention)
+}
@@ -0,0 +1,298 @@
+package commands
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/vectors"
+)
+
+// devtoolsCmd groups helpers for developers and third-party implementers.
+func devtoolsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "devtools",
+		Short: "Developer utilities",
+	}
+	cmd.AddCommand(devtoolsVectorsCmd(), devtoolsRatchetDebugCmd(), devtoolsRatchetReplayCmd(), devtoolsDiffStateCmd())
+	return cmd
+}
+
+// devtoolsVectorsCmd prints deterministic key-derivation test vectors as JSON.
+func devtoolsVectorsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "vectors",
+		Short: "Print deterministic X3DH and ratchet test vectors as JSON",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			v, err := vectors.Generate()
+			if err != nil {
+				return fmt.Errorf("generating vectors: %w", err)
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(v)
+		},
+	}
+}
+
+// devtoolsRatchetDebugCmd turns recording of ratchet steps on or off.
+func devtoolsRatchetDebugCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:       "ratchet-debug on|off|status",
+		Short:     "Record ratchet steps for ratchet-replay (opt-in; off deletes them)",
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"on", "off", "status"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch args[0] {
+			case "on", "off":
+				on := args[0] == "on"
+				if err := appCtx.RatchetDebugService.SetEnabled(on); err != nil {
+					return fmt.Errorf("updating ratchet debug setting: %w", err)
+				}
+				if on {
+					fmt.Println("Ratchet debugging on")
+				} else {
+					fmt.Println("Ratchet debugging off; recorded steps deleted")
+				}
+			case "status":
+				on, err := appCtx.RatchetDebugService.Enabled()
+				if err != nil {
+					return fmt.Errorf("reading ratchet debug setting: %w", err)
+				}
+				if on {
+					fmt.Println("Ratchet debugging on")
+				} else {
+					fmt.Println("Ratchet debugging off")
+				}
+			default:
+				return fmt.Errorf("expected on, off or status, got %q", args[0])
+			}
+			return nil
+		},
+	}
+}
+
+// devtoolsRatchetReplayCmd steps through the ratchet steps recorded with a
+// peer, showing what each one changed.
+func devtoolsRatchetReplayCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "ratchet-replay <peer>",
+		Short: "Step through the ratchet steps recorded with a peer",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			steps, err := appCtx.RatchetDebugService.Steps(passphrase, args[0])
+			if err != nil {
+				return fmt.Errorf("reading ratchet trace: %w", err)
+			}
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(steps)
+			}
+			if len(steps) == 0 {
+				fmt.Println("No ratchet steps recorded; turn recording on with: ciphera devtools ratchet-debug on")
+				return nil
+			}
+			var prev *domain.RatchetStepState
+			for i, st := range steps {
+				printRatchetStep(i+1, st, prev)
+				if st.After != nil {
+					prev = st.After
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the recorded steps as JSON")
+	return cmd
+}
+
+// devtoolsDiffStateCmd compares two snapshots of a conversation's state and
+// explains where they diverge.
+func devtoolsDiffStateCmd() *cobra.Command {
+	var (
+		backupPassphrase, otherPassphrase string
+		asJSON                            bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "diff-state <export> <export|peer>",
+		Short: "Explain how two conversation states diverge (exports, or an export and the current state)",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if backupPassphrase == "" {
+				backupPassphrase = passphrase
+			}
+			if otherPassphrase == "" {
+				otherPassphrase = backupPassphrase
+			}
+			a, err := loadStateSnapshot(args[0], backupPassphrase, false)
+			if err != nil {
+				return err
+			}
+			b, err := loadStateSnapshot(args[1], otherPassphrase, true)
+			if err != nil {
+				return err
+			}
+			d := appCtx.BackupService.DiffStates(a, b)
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(d)
+			}
+			printStateDiff(a, b, d)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(
+		&backupPassphrase,
+		"backup-passphrase",
+		"",
+		"passphrase the first export is encrypted with (default: --passphrase)",
+	)
+	cmd.Flags().StringVar(
+		&otherPassphrase,
+		"other-passphrase",
+		"",
+		"passphrase the second export is encrypted with (default: --backup-passphrase)",
+	)
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the comparison as JSON")
+	return cmd
+}
+
+// loadStateSnapshot opens the export at arg with backupPassphrase. With
+// orPeer, an arg that is not a file names a peer whose current state is
+// used instead.
+func loadStateSnapshot(arg, backupPassphrase string, orPeer bool) (domain.ConversationBackup, error) {
+	data, err := os.ReadFile(arg)
+	if orPeer && errors.Is(err, fs.ErrNotExist) {
+		b, err := appCtx.BackupService.Snapshot(passphrase, arg)
+		if err != nil {
+			return domain.ConversationBackup{}, fmt.Errorf("reading conversation with %s: %w", arg, err)
+		}
+		return b, nil
+	}
+	if err != nil {
+		return domain.ConversationBackup{}, fmt.Errorf("reading %s: %w", arg, err)
+	}
+	b, err := appCtx.BackupService.OpenExport(backupPassphrase, data)
+	if err != nil {
+		return domain.ConversationBackup{}, fmt.Errorf("opening %s: %w", arg, err)
+	}
+	return b, nil
+}
+
+// printStateDiff prints what A and B are, then each finding, problems
+// marked with "!".
+func printStateDiff(a, b domain.ConversationBackup, d domain.StateDiff) {
+	for _, s := range []struct {
+		name string
+		b    domain.ConversationBackup
+	}{{"A", a}, {"B", b}} {
+		fmt.Printf("%s: identity %s with %s, taken %s\n",
+			s.name, shortHex(s.b.OwnerIK.Slice()), s.b.Peer,
+			time.Unix(s.b.ExportedUTC, 0).Format(time.DateTime))
+	}
+	fmt.Printf("Relation: %s\n", d.Relation)
+	if len(d.Findings) == 0 {
+		fmt.Println("No divergence found")
+		return
+	}
+	for _, f := range d.Findings {
+		mark := " "
+		if f.Problem {
+			mark = "!"
+		}
+		fmt.Printf("%s %s", mark, f.Field)
+		if f.A != "" || f.B != "" {
+			fmt.Printf(": %s -> %s", orDash(f.A), orDash(f.B))
+		}
+		fmt.Printf("\n    %s\n", f.Explain)
+	}
+}
+
+// orDash returns s, or "-" if it is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// printRatchetStep prints step n and the fields it changed. The full state
+// is printed first when it is not the one the previous successful step left
+// behind: the first step, and after a reset, rekey, restore or a decrypt
+// whose state was not kept.
+func printRatchetStep(n int, st domain.RatchetStep, prev *domain.RatchetStepState) {
+	op := string(st.Op)
+	if st.Control {
+		op += " (control)"
+	}
+	if st.Stale {
+		op += " (stale state)"
+	}
+	fmt.Printf("Step %d  %s  %s n=%d pn=%d dh=%s\n",
+		n, time.Unix(st.TimeUTC, 0).Format(time.DateTime), op,
+		st.Header.N, st.Header.PN, shortHex(st.Header.DHPub))
+	if prev == nil || *prev != st.Before {
+		if prev != nil {
+			fmt.Println("  state differs from the previous step's result")
+		}
+		for _, f := range ratchetStepFields(st.Before) {
+			fmt.Printf("  %-10s %s\n", f[0], f[1])
+		}
+	}
+	if st.After == nil {
+		fmt.Printf("  failed: %s\n", st.Err)
+		return
+	}
+	before, after := ratchetStepFields(st.Before), ratchetStepFields(*st.After)
+	for i := range before {
+		if before[i][1] != after[i][1] {
+			fmt.Printf("  %-10s %s -> %s\n", before[i][0], before[i][1], after[i][1])
+		}
+	}
+}
+
+// ratchetStepFields returns st's fields as name and value pairs, in a fixed
+// order.
+func ratchetStepFields(st domain.RatchetStepState) [][2]string {
+	key := func(d string) string {
+		if d == "" {
+			return "-"
+		}
+		return d
+	}
+	return [][2]string{
+		{"root", key(st.RootKey)},
+		{"send ck", key(st.SendCK)},
+		{"recv ck", key(st.RecvCK)},
+		{"header key", key(st.HeaderKey)},
+		{"dh", shortHex(st.DHPub.Slice())},
+		{"peer dh", shortHex(st.PeerDHPub.Slice())},
+		{"ns", fmt.Sprint(st.Ns)},
+		{"nr", fmt.Sprint(st.Nr)},
+		{"pn", fmt.Sprint(st.PN)},
+		{"skipped", fmt.Sprint(st.Skipped)},
+	}
+}
+
+// shortHex returns the first 8 bytes of b in hex, or "-" if b is empty or
+// all zero.
+func shortHex(b []byte) string {
+	if strings.Trim(string(b), "\x00") == "" {
+		return "-"
+	}
+	return hex.EncodeToString(b[:min(len(b), 8)])
+}
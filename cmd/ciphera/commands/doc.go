@@ -2,16 +2,47 @@
 //
 // Commands
 //
-//   - init           Create or rotate the local identity
-//   - fingerprint    Print the identity fingerprint
-//   - register       Publish your prekey bundle to a relay
-//   - start-session  Establish an X3DH session with a peer
-//   - send           Encrypt and send a message
-//   - recv           Fetch and decrypt queued messages
+//   - setup               Interactive first-run wizard (identity, relay, registration)
+//   - init                Create or rotate the local identity
+//   - fingerprint         Print the identity fingerprint
+//   - rotate-signing-key  Replace the signing key and republish prekeys to every relay
+//   - register            Publish your prekey bundle to a relay (or all relays), or export it to a file
+//   - endpoints           Set failover endpoints for a relay; requests stick to the one that works
+//   - pair                Exchange identity keys with a peer using a short code, or add one by fingerprint
+//   - attest              Vouch for a paired contact's identity key to your other contacts
+//   - profile             Set the display name, emoji avatar or photo sent to your peers; show theirs
+//   - start-session       Establish an X3DH session with a peer (or user@host, discovering its relay, or fp:<fingerprint>, or from a bundle file)
+//   - send                Encrypt and send a message (text, markdown or another content type; stdin if no message)
+//   - compose             Write a message in $EDITOR (markdown, @attach file references) and send it on save; drafts are kept encrypted
+//   - broadcast           Create and edit broadcast lists; send @<list> messages each member separately
+//   - poll                Send a poll to a peer or list, vote in one, and show results tallied from history
+//   - recv                Fetch and decrypt queued messages (--raw writes bodies only; --verify-report says how each was authenticated)
+//   - ping                Ping a peer's client end to end and measure the round trip (signed ping and pong)
+//   - sent                Show which messages to a peer the relay accepted and which the peer has fetched
+//   - export-envelope     Encrypt a message as armored text for email or USB (optionally password-sealed)
+//   - import-envelope     Decrypt an envelope written by export-envelope
+//   - prekeys             Show prekey counts and ages and when they are next refilled or rotated
+//   - sessions            Show handshake confirmation, skipped-key and rekey counts; export, import or audit one conversation
+//   - backup              Push an encrypted account backup to the relay, or restore it on a new machine
+//   - usage               Show the bytes and envelopes a relay counted for you each month (signed request)
+//   - journal             Audit the relay's journal of your queue for dropped, altered or replayed envelopes (signed request)
+//   - presence            Choose who the relay shows your last activity to (nobody, contacts or everyone), and see when contacts were last seen
+//   - conversations       Mute a peer and set its notification, preview, send-policy, remote-wipe, new-session, rekey, resend, watchdog, oversize, cipher-suite, retention, receive-filter and session-policy preferences, and archive dormant conversations
+//   - wipe                Ask a peer to delete the conversation on both sides (signed, opt-in for the peer)
+//   - quarantine          List, retry or drop envelopes that failed to decrypt
+//   - held                Review, accept or drop messages the receive filters held back
+//   - undo                Revert the most recent register, archive or receive-filter change; --list shows the action log
+//   - history             Show, import, prune or summarise local message history, or lock it with its own passphrase
+//   - stats               Opt in to ratchet statistics and export them anonymised (CSV or JSON)
+//   - devtools            Developer utilities (key-derivation test vectors, ratchet step replay, state diffs)
+//   - version             Show version, commit, build date and protocol versions (--server for the relay's)
 //
 // # Implementation
 //
 // The root command constructs an HTTP client and builds a dependency graph
 // (stores, services, relay client) before any subcommand runs, so handlers can
-// use a shared app context with timeouts and connection pooling.
+// use a shared app context with timeouts and connection pooling. Commands
+// that talk to the relay first fetch its notice (maintenance window, message
+// of the day, minimum client version) and stop if the relay blocks this
+// client's version.
 package commands
@@ -0,0 +1,87 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+)
+
+// endpointsCmd lists the failover endpoints of each relay account and groups
+// the commands that change them.
+func endpointsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "endpoints",
+		Short: "Show or set failover endpoints for the relays you are registered on",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			accounts, err := appCtx.AccountService.ListAccounts()
+			if err != nil {
+				return fmt.Errorf("listing accounts: %w", err)
+			}
+			if len(accounts) == 0 {
+				fmt.Println("No accounts")
+				return nil
+			}
+			for _, a := range accounts {
+				printEndpoints(a)
+			}
+			return nil
+		},
+	}
+	cmd.AddCommand(endpointsSetCmd(), endpointsClearCmd())
+	return cmd
+}
+
+// endpointsSetCmd replaces a relay's failover endpoints.
+func endpointsSetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set <server> <url>...",
+		Short: "Set the endpoints tried, in order, when a relay is down",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			accounts, err := appCtx.AccountService.SetEndpoints(args[0], args[1:])
+			if err != nil {
+				return fmt.Errorf("setting endpoints for %s: %w", args[0], err)
+			}
+			for _, a := range accounts {
+				printEndpoints(a)
+			}
+			return nil
+		},
+	}
+}
+
+// endpointsClearCmd removes a relay's failover endpoints.
+func endpointsClearCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "clear <server>",
+		Short: "Remove a relay's failover endpoints",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			accounts, err := appCtx.AccountService.SetEndpoints(args[0], nil)
+			if err != nil {
+				return fmt.Errorf("clearing endpoints for %s: %w", args[0], err)
+			}
+			for _, a := range accounts {
+				printEndpoints(a)
+			}
+			return nil
+		},
+	}
+}
+
+// printEndpoints prints one account's relay, endpoints and the endpoint in use.
+func printEndpoints(a domain.Account) {
+	active := a.Active
+	if active == "" {
+		active = a.Server
+	}
+	endpoints := "-"
+	if len(a.Endpoints) > 0 {
+		endpoints = strings.Join(a.Endpoints, " ")
+	}
+	fmt.Printf("%s\t%s\tendpoints=%s\tactive=%s\n", a.Server, a.Username, endpoints, active)
+}
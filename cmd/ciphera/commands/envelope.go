@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+)
+
+// exportEnvelopeCmd encrypts a message for <peer> and writes the envelope as
+// armored text instead of posting it, for delivery by email, USB stick or any
+// other channel when no relay is reachable.
+func exportEnvelopeCmd() *cobra.Command {
+	var (
+		out      string
+		password string
+		force    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export-envelope <peer> <message>",
+		Short: "Encrypt a message as armored text for delivery without a relay",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			peer := args[0]
+			msg := domain.MessageBody{ContentType: body.TypeText, Body: []byte(args[1])}
+
+			armored, err := appCtx.CourierService.Export(passphrase, username, peer, msg, force, password)
+			if err != nil {
+				return fmt.Errorf("exporting message to %q: %w", peer, err)
+			}
+			if out == "-" {
+				_, err = os.Stdout.Write(armored)
+			} else {
+				err = os.WriteFile(out, armored, 0o600)
+			}
+			if err != nil {
+				return fmt.Errorf("writing %s: %w", out, err)
+			}
+			// Status goes to stderr so it never mixes with armor on stdout.
+			fmt.Fprintf(os.Stderr, "Envelope for %s written; deliver it with `ciphera import-envelope`\n", peer)
+			return nil
+		},
+	}
+
+	// Username flag is local to this command (others inherit from the root).
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkFlagRequired("username")
+	cmd.Flags().StringVarP(&out, "out", "o", "-", "file to write the envelope to (- for stdout)")
+	cmd.Flags().StringVar(
+		&password,
+		"password",
+		"",
+		"also seal the envelope under this password, hiding sender, recipient and header",
+	)
+	cmd.Flags().BoolVar(
+		&force,
+		"force",
+		false,
+		"export even if the send policy requires a verified peer",
+	)
+	return cmd
+}
+
+// importEnvelopeCmd decrypts an envelope written by export-envelope and prints
+// the message.
+func importEnvelopeCmd() *cobra.Command {
+	var password string
+
+	cmd := &cobra.Command{
+		Use:   "import-envelope <file>",
+		Short: "Decrypt an envelope written by export-envelope (- reads stdin)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var (
+				data []byte
+				err  error
+			)
+			if args[0] == "-" {
+				data, err = readStdin("envelope", "pipe it in or pass a file")
+			} else {
+				data, err = os.ReadFile(args[0])
+			}
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", args[0], err)
+			}
+
+			m, err := appCtx.CourierService.Import(cmd.Context(), passphrase, username, data, password)
+			if err != nil {
+				return fmt.Errorf("importing envelope: %w", err)
+			}
+			printMessage(m)
+			return nil
+		},
+	}
+
+	// Username flag is local to this command (others inherit from the root).
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkFlagRequired("username")
+	cmd.Flags().StringVar(&password, "password", "", "password the envelope was sealed with, if any")
+	return cmd
+}
@@ -4,9 +4,12 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
+
+	"ciphera/internal/protocol/address"
 )
 
-// fingerprintCmd prints the fingerprint of the stored identity.
+// fingerprintCmd prints the fingerprint of the stored identity, and the
+// address peers can reach it at without a username.
 func fingerprintCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "fingerprint",
@@ -18,6 +21,7 @@ func fingerprintCmd() *cobra.Command {
 				return fmt.Errorf("loading fingerprint: %w", err)
 			}
 			fmt.Printf("Fingerprint: %s\n", fp)
+			fmt.Printf("Address:     %s\n", address.FromFingerprint(fp))
 			return nil
 		},
 	}
@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	messagesvc "ciphera/internal/services/message"
+)
+
+// heldCmd groups the commands that review messages the receive filters held
+// back during recv.
+func heldCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "held",
+		Short: "Review messages held back by the receive filters",
+	}
+	cmd.AddCommand(
+		heldListCmd(),
+		heldShowCmd(),
+		heldAcceptCmd(),
+		heldDropCmd(),
+	)
+	return cmd
+}
+
+// heldListCmd prints every held message with the reason it was held, without
+// its content.
+func heldListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List held messages",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			hs, err := appCtx.MessageService.ListHeld(passphrase)
+			if err != nil {
+				return fmt.Errorf("listing held messages: %w", err)
+			}
+			if len(hs) == 0 {
+				fmt.Println("No held messages")
+				return nil
+			}
+			for _, h := range hs {
+				fmt.Printf("%s\t%s\t%s\t%s\t%s\n",
+					h.ID,
+					h.Message.From,
+					time.Unix(h.HeldUTC, 0).UTC().Format(time.RFC3339),
+					h.Message.Body.ContentType,
+					h.Reason,
+				)
+			}
+			return nil
+		},
+	}
+}
+
+// heldShowCmd prints one held message without releasing it.
+func heldShowCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show <id>",
+		Short: "Show a held message without accepting it",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			hs, err := appCtx.MessageService.ListHeld(passphrase)
+			if err != nil {
+				return fmt.Errorf("listing held messages: %w", err)
+			}
+			for _, h := range hs {
+				if h.ID == args[0] {
+					printMessage(h.Message)
+					return nil
+				}
+			}
+			return fmt.Errorf("showing %q: %w", args[0], messagesvc.ErrNotHeld)
+		},
+	}
+}
+
+// heldAcceptCmd releases a held message into the history and prints it.
+func heldAcceptCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "accept <id>",
+		Short: "Accept a held message into the history",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := appCtx.MessageService.AcceptHeld(passphrase, args[0])
+			if err != nil {
+				return fmt.Errorf("accepting %q: %w", args[0], err)
+			}
+			printMessage(m)
+			return nil
+		},
+	}
+}
+
+// heldDropCmd permanently discards a held message.
+func heldDropCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "drop <id>",
+		Short: "Discard a held message",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := appCtx.MessageService.DropHeld(passphrase, args[0]); err != nil {
+				return fmt.Errorf("dropping %q: %w", args[0], err)
+			}
+			fmt.Println("Message dropped")
+			return nil
+		},
+	}
+}
@@ -0,0 +1,317 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/poll"
+	"ciphera/internal/services/history"
+	identitysvc "ciphera/internal/services/identity"
+)
+
+// historyPassphrase opens a history locked with its own passphrase.
+var historyPassphrase string
+
+// historyPass returns the passphrase that opens the history: --history-passphrase
+// if given, else --passphrase.
+func historyPass() string {
+	if historyPassphrase != "" {
+		return historyPassphrase
+	}
+	return passphrase
+}
+
+// addHistoryPassphraseFlag adds --history-passphrase to cmd.
+func addHistoryPassphraseFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(
+		&historyPassphrase,
+		"history-passphrase",
+		"",
+		"passphrase of a history locked with its own (default: --passphrase)",
+	)
+}
+
+// historyCmd prints the local message history, with all peers or one, and
+// groups the import, prune, lock and unlock subcommands.
+func historyCmd() *cobra.Command {
+	var limit int
+
+	cmd := &cobra.Command{
+		Use:   "history [peer]",
+		Short: "Show the local message history",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			peer := ""
+			if len(args) == 1 {
+				peer = args[0]
+			}
+			entries, err := appCtx.HistoryService.History(historyPass(), peer, limit)
+			if err != nil {
+				return fmt.Errorf("reading history: %w", err)
+			}
+			if len(entries) == 0 {
+				fmt.Println("No history")
+				return nil
+			}
+			// Each poll's results follow its first appearance.
+			tallies, err := appCtx.HistoryService.Polls(historyPass())
+			if err != nil {
+				return fmt.Errorf("reading polls: %w", err)
+			}
+			polls := make(map[string]domain.PollTally, len(tallies))
+			for _, t := range tallies {
+				polls[t.ID] = t
+			}
+			for _, e := range entries {
+				printHistoryEntry(e)
+				if p, err := poll.Parse(e.Body); err == nil && e.Source == "" {
+					if t, ok := polls[p.ID]; ok {
+						printPollResults(t, "    ")
+						delete(polls, p.ID)
+					}
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVarP(&limit, "limit", "n", 0, "show only the last n messages")
+	cmd.PersistentFlags().StringVar(
+		&historyPassphrase,
+		"history-passphrase",
+		"",
+		"passphrase of a history locked with its own (default: --passphrase)",
+	)
+	cmd.AddCommand(historyImportCmd(), historyPruneCmd(), historyStatsCmd(), historyLockCmd(), historyUnlockCmd())
+	return cmd
+}
+
+// historyImportCmd imports a transcript exported from another messenger.
+func historyImportCmd() *cobra.Command {
+	var in domain.HistoryImport
+
+	cmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import a transcript exported from another messenger (- reads stdin)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if args[0] == "-" {
+				in.Data, err = readStdin("transcript", "pipe it in or pass a file")
+			} else {
+				in.Data, err = os.ReadFile(args[0])
+			}
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", args[0], err)
+			}
+
+			added, skipped, err := appCtx.HistoryService.Import(historyPass(), in)
+			if err != nil {
+				return fmt.Errorf("importing %s: %w", args[0], err)
+			}
+			fmt.Printf("Imported %d message(s), skipped %d\n", added, skipped)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(
+		&in.Format,
+		"format",
+		"",
+		fmt.Sprintf("export format: %s or %s", history.FormatJSON, history.FormatSignalBackup),
+	)
+	_ = cmd.MarkFlagRequired("format")
+	cmd.Flags().StringVar(
+		&in.Peer,
+		"peer",
+		"",
+		"file the messages under this peer (required for signal-backup)",
+	)
+	cmd.Flags().StringVar(
+		&in.Thread,
+		"thread",
+		"",
+		"signal-backup: import only this thread_id",
+	)
+	return cmd
+}
+
+// historyPruneCmd removes history past its retention. Writes prune as they go;
+// scheduling this command also expires messages by age between writes.
+func historyPruneCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "prune",
+		Short: "Remove history the retention settings no longer keep",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			removed, err := appCtx.HistoryService.Prune(historyPass())
+			if err != nil {
+				return fmt.Errorf("pruning history: %w", err)
+			}
+			fmt.Printf("Removed %d message(s)\n", removed)
+			return nil
+		},
+	}
+}
+
+// historyStatsCmd summarises the history with one peer.
+func historyStatsCmd() *cobra.Command {
+	var asJSON, utc bool
+
+	cmd := &cobra.Command{
+		Use:   "stats <peer>",
+		Short: "Summarise the history with a peer: activity by day and hour, reply times and sizes",
+		Long: `Summarise the history with a peer: messages each way per day, the busiest
+hours of the day, how quickly each of you replied, and how large the
+messages were. Everything is computed from the local history, which never
+leaves this device. Days and hours are in local time unless --utc is given.
+
+A reply time runs from the first message the other side sent since your
+last reply to your next message, so a conversation picked up again days
+later counts as one slow reply.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			loc := time.Local
+			if utc {
+				loc = time.UTC
+			}
+			st, err := appCtx.HistoryService.Stats(historyPass(), args[0], loc)
+			if err != nil {
+				return fmt.Errorf("reading history: %w", err)
+			}
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(st)
+			}
+			if st.Sent+st.Received == 0 {
+				fmt.Printf("No history with %s\n", peerLabel(st.Peer))
+				return nil
+			}
+			printHistoryStats(st, loc)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print as JSON")
+	cmd.Flags().BoolVar(&utc, "utc", false, "count days and hours in UTC instead of local time")
+	return cmd
+}
+
+// printHistoryStats prints st, computed in loc, for reading.
+func printHistoryStats(st domain.HistoryStats, loc *time.Location) {
+	fmt.Printf("%s: %d sent, %d received", peerLabel(st.Peer), st.Sent, st.Received)
+	if st.Imported > 0 {
+		fmt.Printf(" (%d imported)", st.Imported)
+	}
+	fmt.Println()
+	fmt.Printf("From %s to %s\n",
+		time.Unix(st.FirstUTC, 0).In(loc).Format(time.DateTime),
+		time.Unix(st.LastUTC, 0).In(loc).Format(time.DateTime))
+
+	fmt.Println("\nMessages per day (sent/received):")
+	for _, d := range st.Days {
+		fmt.Printf("  %s  %4d / %-4d %s\n", d.Day, d.Sent, d.Received, strings.Repeat("#", min(d.Sent+d.Received, 50)))
+	}
+
+	hours := make([]int, 0, 24)
+	for h, n := range st.Hours {
+		if n > 0 {
+			hours = append(hours, h)
+		}
+	}
+	slices.SortStableFunc(hours, func(a, b int) int { return st.Hours[b] - st.Hours[a] })
+	busiest := make([]string, 0, 3)
+	for _, h := range hours[:min(len(hours), 3)] {
+		busiest = append(busiest, fmt.Sprintf("%02d:00 (%d)", h, st.Hours[h]))
+	}
+	fmt.Printf("\nBusiest hours (%s): %s\n", loc, strings.Join(busiest, ", "))
+
+	fmt.Println("\nReply times:")
+	for _, r := range []struct {
+		who string
+		l   domain.ReplyLatency
+	}{{"You", st.OurReplies}, {peerLabel(st.Peer), st.PeerReplies}} {
+		if r.l.Count == 0 {
+			fmt.Printf("  %s: no replies\n", r.who)
+			continue
+		}
+		fmt.Printf("  %s: %d replies, mean %s, median %s\n", r.who, r.l.Count, r.l.Mean.Round(time.Second), r.l.Median.Round(time.Second))
+	}
+
+	fmt.Println("\nMessage sizes (sent/received):")
+	prev := -1
+	for _, b := range st.Sizes {
+		label := fmt.Sprintf("over %d B", prev)
+		if b.MaxBytes > 0 {
+			label = fmt.Sprintf("%d-%d B", prev+1, b.MaxBytes)
+			prev = b.MaxBytes
+		}
+		fmt.Printf("  %-14s %4d / %d\n", label, b.Sent, b.Received)
+	}
+}
+
+// historyLockCmd gives the history its own passphrase, or changes it.
+func historyLockCmd() *cobra.Command {
+	var next string
+
+	cmd := &cobra.Command{
+		Use:   "lock",
+		Short: "Protect the history with its own passphrase instead of your identity's",
+		Long: `Protect the history with its own passphrase instead of your identity's.
+
+Once locked, --passphrase no longer reads the history; pass
+--history-passphrase as well. Messages sent and received while locked are
+still recorded, and join the history the next time it is opened. Run lock
+again with --history-passphrase to change the history passphrase.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := identitysvc.ValidatePassphrase(next); err != nil {
+				return fmt.Errorf("new history passphrase: %w", err)
+			}
+			if err := appCtx.HistoryService.Lock(historyPass(), next); err != nil {
+				return fmt.Errorf("locking history: %w", err)
+			}
+			fmt.Println("History locked; read it with --history-passphrase")
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&next, "new-passphrase", "", "the history's own passphrase")
+	_ = cmd.MarkFlagRequired("new-passphrase")
+	return cmd
+}
+
+// historyUnlockCmd has the identity passphrase open the history again.
+func historyUnlockCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unlock",
+		Short: "Protect the history with your identity passphrase again",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := appCtx.HistoryService.Unlock(historyPass(), passphrase); err != nil {
+				return fmt.Errorf("unlocking history: %w", err)
+			}
+			fmt.Println("History unlocked; --passphrase reads it again")
+			return nil
+		},
+	}
+}
+
+// printHistoryEntry prints one history line. Imported messages are marked,
+// since Ciphera never authenticated them.
+func printHistoryEntry(e domain.HistoryEntry) {
+	arrow := "<-"
+	if e.Direction == domain.HistoryOut {
+		arrow = "->"
+	}
+	when := time.Unix(e.SentUTC, 0).UTC().Format(time.RFC3339)
+	mark := ""
+	if e.Source != "" {
+		mark = fmt.Sprintf(" (imported from %s, unauthenticated)", e.Source)
+	}
+	fmt.Printf("%s %s %s%s %s\n", when, arrow, peerLabel(e.Peer), mark, renderBody(e.Body))
+}
@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+)
+
+// journalCmd fetches the queue journal a relay keeps for the user and reports
+// envelopes it dropped, altered or handed out again.
+func journalCmd() *cobra.Command {
+	var events bool
+
+	cmd := &cobra.Command{
+		Use:   "journal [server]",
+		Short: "Audit the relay's journal of your queue for dropped, altered or replayed envelopes",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			server := ""
+			if len(args) == 1 {
+				server = args[0]
+			}
+			audit, err := appCtx.AccountService.AuditJournal(cmd.Context(), passphrase, username, server)
+			if errors.Is(err, domain.ErrNotFound) {
+				return fmt.Errorf("fetching journal: the relay keeps no journal (it needs --data-dir): %w", err)
+			}
+			if err != nil {
+				return fmt.Errorf("fetching journal: %w", err)
+			}
+
+			if events {
+				for _, ev := range audit.Events {
+					fmt.Printf("%d\t%s\t%-7s\t%s\t%s\n", ev.N, time.Unix(ev.TimeUTC, 0).UTC().Format(time.RFC3339),
+						ev.Kind, ev.ID, hex.EncodeToString(ev.Hash[:min(len(ev.Hash), 8)]))
+				}
+			}
+			fmt.Printf("%d events: %d queued, %d fetched, %d acked, %d dropped or expired\n",
+				len(audit.Events), audit.Enqueued, audit.Fetched, audit.Acked, audit.Dropped)
+			problems := 0
+			for _, f := range audit.Findings {
+				mark := " "
+				if f.Problem {
+					mark = "!"
+					problems++
+				}
+				if f.N == 0 {
+					fmt.Printf("%s %s\n", mark, f.Explain)
+				} else {
+					fmt.Printf("%s event %d, envelope %s: %s\n", mark, f.N, f.ID, f.Explain)
+				}
+			}
+			if problems > 0 {
+				return fmt.Errorf("the journal shows %d problem(s)", problems)
+			}
+			fmt.Println("No problems found")
+			return nil
+		},
+	}
+
+	// Username flag is local to this command.
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkFlagRequired("username")
+	cmd.Flags().BoolVar(&events, "events", false, "also list every event")
+	return cmd
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+
+package commands
+
+import "os"
+
+// disableEcho is unsupported on this platform; secrets are read with echo on.
+func disableEcho(*os.File) (func(), bool, error) {
+	return nil, false, nil
+}
+
+// isTerminal cannot tell terminals apart on this platform and assumes none.
+func isTerminal(*os.File) bool {
+	return false
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package commands
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// disableEcho turns off console echo on f and returns a func restoring the
+// previous mode. ok is false when f is not a console.
+func disableEcho(f *os.File) (restore func(), ok bool, err error) {
+	h := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return nil, false, nil
+	}
+	raw := mode&^windows.ENABLE_ECHO_INPUT | windows.ENABLE_PROCESSED_INPUT | windows.ENABLE_LINE_INPUT
+	if err := windows.SetConsoleMode(h, raw); err != nil {
+		return nil, true, err
+	}
+	return func() { _ = windows.SetConsoleMode(h, mode) }, true, nil
+}
+
+// isTerminal reports whether f is a console.
+func isTerminal(f *os.File) bool {
+	var mode uint32
+	return windows.GetConsoleMode(windows.Handle(f.Fd()), &mode) == nil
+}
@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/buildinfo"
+	"ciphera/internal/domain"
+)
+
+// relayNoticeTimeout bounds the GET /server-info made before a relay command,
+// so a slow relay delays the command itself rather than its notice.
+const relayNoticeTimeout = 3 * time.Second
+
+// relayNoticeAnnotation marks commands that check the relay's notice.
+const relayNoticeAnnotation = "ciphera/relay-notice"
+
+// checksRelay marks c as talking to the relay, so the relay's notice is shown
+// and its minimum client version enforced before c runs.
+func checksRelay(c *cobra.Command) *cobra.Command {
+	if c.Annotations == nil {
+		c.Annotations = make(map[string]string)
+	}
+	c.Annotations[relayNoticeAnnotation] = "true"
+	return c
+}
+
+// checkRelayNotice fetches the notice of the relay cmd talks to and prints it
+// to stderr. It fails if the relay blocks clients as old as this one. A relay
+// that cannot be asked, or announces nothing, is left for the command to
+// find; recorded and replayed relay traffic is never checked, so cassettes
+// hold only the command's own requests.
+func checkRelayNotice(cmd *cobra.Command) error {
+	if cmd.Annotations[relayNoticeAnnotation] == "" || relayRecord != "" || relayReplay != "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(cmd.Context(), relayNoticeTimeout)
+	defer cancel()
+	info, err := appCtx.RelayClient.ServerInfo(ctx)
+	if err != nil || info.Notice == nil {
+		return nil
+	}
+	version := buildinfo.Get().Version
+	lines, blocked := noticeLines(*info.Notice, version, time.Now())
+	for _, l := range lines {
+		fmt.Fprintln(os.Stderr, l)
+	}
+	if blocked {
+		return fmt.Errorf("ciphera %s is older than %s, the oldest version this relay supports; upgrade to continue",
+			version, info.Notice.MinClientVersion)
+	}
+	return nil
+}
+
+// noticeLines renders what n tells a client at version now: the maintenance
+// window unless it is over, the message of the day and whether the client is
+// too old. blocked reports that the relay refuses clients this old. A version
+// that cannot be compared, as for dev builds, is never too old.
+func noticeLines(n domain.RelayNotice, version string, now time.Time) (lines []string, blocked bool) {
+	at := func(utc int64) string { return time.Unix(utc, 0).UTC().Format(time.RFC3339) }
+	start, end := n.MaintenanceStartUTC, n.MaintenanceEndUTC
+	switch {
+	case end != 0 && now.Unix() >= end: // over
+	case start != 0 && now.Unix() < start && end != 0:
+		lines = append(lines, fmt.Sprintf("relay maintenance scheduled from %s to %s", at(start), at(end)))
+	case start != 0 && now.Unix() < start:
+		lines = append(lines, fmt.Sprintf("relay maintenance scheduled from %s", at(start)))
+	case end != 0:
+		lines = append(lines, fmt.Sprintf("relay under maintenance until %s", at(end)))
+	case start != 0:
+		lines = append(lines, fmt.Sprintf("relay under maintenance since %s", at(start)))
+	}
+	if n.MOTD != "" {
+		lines = append(lines, "relay: "+n.MOTD)
+	}
+	if older, ok := buildinfo.Older(version, n.MinClientVersion); ok && older {
+		if n.ClientGate == domain.GateBlock {
+			return lines, true
+		}
+		lines = append(lines, fmt.Sprintf("warning: ciphera %s is older than %s, the oldest version this relay supports; "+
+			"upgrade soon", version, n.MinClientVersion))
+	}
+	return lines, false
+}
@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// previewRunes caps the message text shown in a notification.
+const previewRunes = 40
+
+// notifyMessages writes one notification line to stderr per message whose
+// conversation is neither muted nor set to never notify. The text is left out
+// when the conversation hides previews.
+func notifyMessages(msgs []domain.DecryptedMessage) error {
+	now := time.Now().Unix()
+	prefs := make(map[string]domain.ConversationPrefs)
+	for _, m := range msgs {
+		p, ok := prefs[m.From]
+		if !ok {
+			var err error
+			if p, err = appCtx.ConversationService.Preferences(m.From); err != nil {
+				return err
+			}
+			prefs[m.From] = p
+		}
+		if !p.NotifiesAt(now) {
+			continue
+		}
+		if p.HidePreview {
+			fmt.Fprintf(os.Stderr, "\aNew message from %s\n", m.From)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "\aNew message from %s: %s\n", m.From, preview(renderBody(m.Body)))
+	}
+	return nil
+}
+
+// preview shortens s to previewRunes, marking the cut with an ellipsis.
+func preview(s string) string {
+	r := []rune(s)
+	if len(r) <= previewRunes {
+		return s
+	}
+	return string(r[:previewRunes-1]) + "…"
+}
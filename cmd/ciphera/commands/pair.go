@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	pairingsvc "ciphera/internal/services/pairing"
+)
+
+// pairTimeout matches how long the relay keeps an idle pairing mailbox.
+const pairTimeout = 10 * time.Minute
+
+// maxPairCodeAttempts bounds how often pair retries with a fresh code when
+// the generated code's mailbox is already taken.
+const maxPairCodeAttempts = 3
+
+// pairCmd generates a pairing code, waits for the peer to join with it, and
+// stores the peer's identity keys as a contact.
+func pairCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pair",
+		Short: "Pair with a peer using a short code and exchange identity keys",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), pairTimeout)
+			defer cancel()
+
+			for range maxPairCodeAttempts {
+				code, err := appCtx.PairingService.NewCode()
+				if err != nil {
+					return fmt.Errorf("generating pairing code: %w", err)
+				}
+				fmt.Printf("Pairing code: %s\n", code)
+				fmt.Println("On the other device run: ciphera pair join <code> -u <username>")
+				fmt.Println("Waiting for peer...")
+
+				c, err := appCtx.PairingService.Pair(ctx, passphrase, username, code, true)
+				if errors.Is(err, pairingsvc.ErrCodeInUse) {
+					fmt.Println("Code already in use; generating another")
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("pairing: %w", err)
+				}
+				printContact("Paired with", c)
+				return nil
+			}
+			return fmt.Errorf("pairing: %w", pairingsvc.ErrCodeInUse)
+		},
+	}
+	cmd.AddCommand(pairJoinCmd(), pairAddCmd(), pairListCmd())
+
+	addPairUsernameFlag(cmd)
+	return cmd
+}
+
+// pairJoinCmd joins a pairing started by the peer with their code.
+func pairJoinCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "join <code>",
+		Short: "Join a peer's pairing with the code they shared",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(cmd.Context(), pairTimeout)
+			defer cancel()
+
+			c, err := appCtx.PairingService.Pair(ctx, passphrase, username, args[0], false)
+			if err != nil {
+				return fmt.Errorf("pairing: %w", err)
+			}
+			printContact("Paired with", c)
+			return nil
+		},
+	}
+
+	addPairUsernameFlag(cmd)
+	return cmd
+}
+
+// pairAddCmd adds a contact from the fingerprint address they gave us, with
+// no pairing code: their keys are fetched from a relay and must match it.
+func pairAddCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "add <fp:fingerprint[@host]>",
+		Short: "Add a contact from the identity fingerprint they gave you",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := appCtx.SessionService.AddContact(cmd.Context(), args[0])
+			if err != nil {
+				return fmt.Errorf("adding contact: %w", err)
+			}
+			printContact("Added", c)
+			return nil
+		},
+	}
+}
+
+// pairListCmd prints every paired contact, and which other contacts attested
+// their identity key when the session with them was started.
+func pairListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List paired contacts",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cs, err := appCtx.PairingService.ListContacts()
+			if err != nil {
+				return fmt.Errorf("listing contacts: %w", err)
+			}
+			if len(cs) == 0 {
+				fmt.Println("No paired contacts")
+				return nil
+			}
+			for _, c := range cs {
+				printContact("-", c)
+				sess, ok, err := appCtx.SessionService.GetSession(c.Username)
+				if err != nil {
+					return fmt.Errorf("loading session with %s: %w", c.Username, err)
+				}
+				if v := vouchers(sess.VouchedBy); ok && sess.PeerIK == c.IdentityKey && v != "" {
+					fmt.Printf("    also %s\n", v)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// addPairUsernameFlag adds the required -u flag: the username sent to the peer.
+func addPairUsernameFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username, sent to the peer",
+	)
+	_ = cmd.MarkFlagRequired("username")
+}
+
+// printContact prints a contact's username (with their profile, see
+// peerLabel), fingerprint and pairing time.
+func printContact(prefix string, c domain.Contact) {
+	relay := ""
+	if c.Relay != "" {
+		relay = "  relay " + c.Relay
+	}
+	fmt.Printf("%s %s  fingerprint %s  paired %s%s\n",
+		prefix,
+		peerLabel(c.Username),
+		crypto.Fingerprint(c.IdentityKey.Slice()),
+		time.Unix(c.PairedUTC, 0).UTC().Format(time.RFC3339),
+		relay,
+	)
+}
@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+)
+
+// pingCmd sends a peer an encrypted, signed ping and waits for the pong their
+// client sends back, receiving queued messages meanwhile.
+func pingCmd() *cobra.Command {
+	var wait, interval time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "ping <peer>",
+		Short: "Check end to end that a peer's client answers, and measure the round trip",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			peer := args[0]
+			id, err := appCtx.MessageService.Ping(cmd.Context(), passphrase, username, peer)
+			if err != nil {
+				return fmt.Errorf("pinging %s: %w", peer, err)
+			}
+			if wait <= 0 {
+				fmt.Printf("Ping sent to %s; the pong is shown by `ciphera recv` once they answer\n", peer)
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "Ping sent to %s, waiting up to %s for the pong\n", peer, wait)
+
+			// Everything else received while waiting is shown as recv would,
+			// so nothing is missed.
+			deadline := time.Now().Add(wait)
+			for {
+				msgs, rep, err := appCtx.MessageService.ReceiveMessage(cmd.Context(), passphrase, username, 0)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "receiving messages: %v\n", err)
+				}
+				printUndelivered(rep.Undelivered)
+				for _, m := range msgs {
+					if m.From == peer && m.Body.ContentType == body.TypePong && m.Body.Metadata[body.MetaPingID] == id {
+						fmt.Printf("%s: %s\n", peerLabel(peer), pongSummary(m.Body))
+						return nil
+					}
+					printMessage(m)
+				}
+				if time.Now().Add(interval).After(deadline) {
+					return fmt.Errorf("no pong from %s within %s: their client is offline or has not fetched its messages", peer, wait)
+				}
+				select {
+				case <-cmd.Context().Done():
+					return cmd.Context().Err()
+				case <-time.After(interval):
+				}
+			}
+		},
+	}
+
+	// Username flag is local to this command.
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkFlagRequired("username")
+	cmd.Flags().DurationVar(&wait, "wait", 30*time.Second, "how long to wait for the pong (0: send and return)")
+	cmd.Flags().DurationVar(&interval, "interval", time.Second, "how often to check for the pong while waiting")
+	return cmd
+}
+
+// pongSummary describes the round trip a pong notice reports.
+func pongSummary(b domain.MessageBody) string {
+	rtt, _ := strconv.ParseInt(b.Metadata[body.MetaPingRTT], 10, 64)
+	took, _ := strconv.ParseInt(b.Metadata[body.MetaPingPeer], 10, 64)
+	s := fmt.Sprintf("pong: round trip %s, peer answered in %s",
+		time.Duration(rtt)*time.Millisecond, time.Duration(took)*time.Millisecond)
+	if b.Metadata[body.MetaPingSigned] != "true" {
+		s += " (signature not checked: pair with them to verify it)"
+	}
+	return s
+}
@@ -0,0 +1,164 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/poll"
+)
+
+// pollCmd groups the commands that create, answer and show polls. Polls and
+// votes are ordinary encrypted messages; results are tallied from the local
+// history.
+func pollCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "poll",
+		Short: "Send polls, vote in them and show their results",
+	}
+	cmd.AddCommand(pollCreateCmd(), pollVoteCmd(), pollShowCmd())
+	return cmd
+}
+
+// pollCreateCmd sends a new poll to a peer or a broadcast list.
+func pollCreateCmd() *cobra.Command {
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "create <peer|@list> <question> <option> <option>...",
+		Short: "Send a poll to a peer or to every member of a broadcast list",
+		Args:  cobra.RangeArgs(2+poll.MinOptions, 2+poll.MaxOptions),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			peer := args[0]
+			p, msg, err := poll.New(args[1], args[2:])
+			if err != nil {
+				return err
+			}
+
+			if list, ok := strings.CutPrefix(peer, "@"); ok {
+				results, err := appCtx.BroadcastService.Send(cmd.Context(), passphrase, username, list, msg, force, 0)
+				if err != nil {
+					return fmt.Errorf("sending poll to list %q: %w", list, err)
+				}
+				if err := printBroadcastResults(list, results); err != nil {
+					return err
+				}
+			} else {
+				err := appCtx.MessageService.SendMessage(cmd.Context(), passphrase, username, peer, msg, force, 0)
+				if err != nil {
+					return fmt.Errorf("sending poll to %q: %w", peer, err)
+				}
+			}
+			fmt.Printf("Poll %s sent; vote with: ciphera poll vote -u %s %s <option>\n", p.ID, username, p.ID)
+			return nil
+		},
+	}
+
+	addPollUsernameFlag(cmd)
+	cmd.Flags().BoolVar(
+		&force,
+		"force",
+		false,
+		"send even if the send policy requires a verified peer or the peer lacks poll support",
+	)
+	return cmd
+}
+
+// pollVoteCmd votes in a poll from the history.
+func pollVoteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "vote <poll-id> <option>",
+		Short: "Vote for an option (numbered from 1, as poll show lists them)",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("option must be a number from 1, as listed by poll show")
+			}
+			to, err := appCtx.MessageService.Vote(cmd.Context(), passphrase, username, args[0], n-1)
+			if err != nil {
+				return fmt.Errorf("voting in poll %s: %w", args[0], err)
+			}
+			fmt.Printf("Vote sent to %s\n", strings.Join(to, ", "))
+			return nil
+		},
+	}
+
+	addPollUsernameFlag(cmd)
+	return cmd
+}
+
+// pollShowCmd prints the results of one poll, or of every poll.
+func pollShowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show [poll-id]",
+		Short: "Show poll results as tallied from the votes you have seen",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tallies, err := appCtx.HistoryService.Polls(historyPass())
+			if err != nil {
+				return fmt.Errorf("reading polls: %w", err)
+			}
+			shown := 0
+			for _, t := range tallies {
+				if len(args) == 1 && t.ID != args[0] {
+					continue
+				}
+				printPollTally(t)
+				shown++
+			}
+			if shown == 0 {
+				fmt.Println("No polls")
+			}
+			return nil
+		},
+	}
+	addHistoryPassphraseFlag(cmd)
+	return cmd
+}
+
+// addPollUsernameFlag adds the required --username flag to a poll subcommand.
+func addPollUsernameFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkFlagRequired("username")
+}
+
+// printPollTally prints a poll's question, then each option with its votes
+// and voters.
+func printPollTally(t domain.PollTally) {
+	from := "you"
+	if t.Creator != "" {
+		from = t.Creator
+	}
+	fmt.Printf("Poll %s from %s: %s\n", t.ID, from, t.Question)
+	printPollResults(t, "  ")
+}
+
+// printPollResults prints each option of t, numbered from 1, with its vote
+// count and voters.
+func printPollResults(t domain.PollTally, indent string) {
+	voters := make([][]string, len(t.Options))
+	for _, v := range t.Votes {
+		name := v.Voter
+		if name == "" {
+			name = "you"
+		}
+		voters[v.Choice] = append(voters[v.Choice], name)
+	}
+	for i, o := range t.Options {
+		line := fmt.Sprintf("%s%d) %s: %d", indent, i+1, o, len(voters[i]))
+		if len(voters[i]) > 0 {
+			line += " (" + strings.Join(voters[i], ", ") + ")"
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+)
+
+// prekeyUpkeepAnnotation marks commands after which scheduled prekey upkeep
+// runs.
+const prekeyUpkeepAnnotation = "ciphera/prekey-upkeep"
+
+// keepsPrekeys marks c, and the commands under it, as running the session
+// policy's prekey upkeep once they succeed, so the persisted schedule is
+// kept however the client is used. Upkeep only talks to the relays when the
+// schedule says it is due.
+func keepsPrekeys(c *cobra.Command) *cobra.Command {
+	if c.Annotations == nil {
+		c.Annotations = make(map[string]string)
+	}
+	c.Annotations[prekeyUpkeepAnnotation] = "true"
+	return c
+}
+
+// runPrekeyUpkeep runs prekey upkeep after cmd if it, or a command above it,
+// is marked by keepsPrekeys and knows whose prekeys to keep.
+func runPrekeyUpkeep(cmd *cobra.Command) {
+	if username == "" {
+		return
+	}
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Annotations[prekeyUpkeepAnnotation] != "" {
+			maintainPrekeys(cmd.Context())
+			return
+		}
+	}
+}
+
+// prekeysCmd shows the prekeys we hold and when their upkeep next runs.
+func prekeysCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "prekeys",
+		Short: "Show the prekeys you hold and when they are next refilled or rotated",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show prekey counts and ages and the upkeep schedule",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			st, err := appCtx.PrekeyService.Status()
+			if err != nil {
+				return fmt.Errorf("reading prekeys: %w", err)
+			}
+			policy, err := appCtx.ConversationService.SessionPolicy()
+			if err != nil {
+				return fmt.Errorf("reading session policy: %w", err)
+			}
+			printPrekeyStatus(st, policy, time.Now())
+			return nil
+		},
+	})
+	return cmd
+}
+
+// printPrekeyStatus writes st, with its schedule read under policy, as of
+// now.
+func printPrekeyStatus(st domain.PrekeyStatus, policy domain.SessionPolicy, now time.Time) {
+	at := func(utc int64) string { return time.Unix(utc, 0).UTC().Format(time.RFC3339) }
+	age := func(utc int64) string {
+		return now.Sub(time.Unix(utc, 0)).Round(time.Minute).String()
+	}
+	until := func(utc int64) string {
+		if utc <= now.Unix() {
+			return at(utc) + " (due)"
+		}
+		return fmt.Sprintf("%s (in %s)", at(utc), time.Unix(utc, 0).Sub(now).Round(time.Minute))
+	}
+
+	switch {
+	case st.SignedPrekeyID == "":
+		fmt.Println("Signed prekey:      none; run `ciphera register`")
+	case st.SignedCreatedUTC == 0:
+		fmt.Printf("Signed prekey:      %s, generated before ages were recorded\n", st.SignedPrekeyID)
+	default:
+		fmt.Printf("Signed prekey:      %s, generated %s (%s old)\n",
+			st.SignedPrekeyID, at(st.SignedCreatedUTC), age(st.SignedCreatedUTC))
+	}
+
+	fmt.Printf("One-time prekeys:   %d held", st.OneTime)
+	if st.OneTimeOldestUTC != 0 {
+		fmt.Printf(", oldest generated %s (%s old), newest %s",
+			at(st.OneTimeOldestUTC), age(st.OneTimeOldestUTC), at(st.OneTimeNewestUTC))
+	}
+	fmt.Println()
+
+	fmt.Printf("Consumed by peers:  %d", st.Consumed)
+	if n := len(st.ConsumedUTC); n > 0 {
+		recent := 0
+		for _, t := range st.ConsumedUTC {
+			if now.Sub(time.Unix(t, 0)) < 14*24*time.Hour {
+				recent++
+			}
+		}
+		fmt.Printf(", last %s, %d in the last 14 days", at(st.ConsumedUTC[n-1]), recent)
+	}
+	fmt.Println()
+
+	sched := st.Schedule
+	if sched.LastRunUTC != 0 {
+		fmt.Printf("Last upkeep:        %s\n", at(sched.LastRunUTC))
+	}
+	switch {
+	case policy.ReplenishOPKs == 0:
+		fmt.Println("Next refill check:  off; set with `conversations session-policy --replenish-opks`")
+	case sched.NextCheckUTC == 0:
+		fmt.Println("Next refill check:  at the next recv, send, poll or ping")
+	default:
+		fmt.Printf("Next refill check:  %s, refilling below %d unused\n", until(sched.NextCheckUTC), policy.ReplenishOPKs)
+	}
+	switch {
+	case policy.RotateSPKDays == 0:
+		fmt.Println("Next rotation:      off; set with `conversations session-policy --rotate-spk-days`")
+	case sched.NextRotateUTC == 0:
+		fmt.Println("Next rotation:      at the next recv, send, poll or ping")
+	default:
+		fmt.Printf("Next rotation:      %s, every %d days\n", until(sched.NextRotateUTC), policy.RotateSPKDays)
+	}
+}
@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+)
+
+// presenceCmd groups the set and show subcommands for last-seen presence.
+func presenceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "presence",
+		Short: "Choose who sees when you were last active, and see when contacts were",
+	}
+
+	// Username flag is shared by both subcommands.
+	cmd.PersistentFlags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkPersistentFlagRequired("username")
+	cmd.AddCommand(presenceSetCmd(), presenceShowCmd())
+	return cmd
+}
+
+// presenceSetCmd publishes who the relay shows our last activity to.
+func presenceSetCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set nobody|contacts|everyone [server]",
+		Short: "Choose who the relay shows your last activity to",
+		Long: `Choose who the relay shows your last activity to: nobody (the default),
+contacts (peers you hold a conversation or session with on that relay) or
+everyone. The relay records when you last fetched your messages, to the
+minute, only while the choice is contacts or everyone. Run it again after
+starting conversations with new contacts so they are included.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			server := ""
+			if len(args) == 2 {
+				server = args[1]
+			}
+			p, err := appCtx.AccountService.SetPresence(cmd.Context(), passphrase, username, server,
+				domain.PresenceVisibility(args[0]))
+			if err != nil {
+				return fmt.Errorf("setting presence: %w", err)
+			}
+			switch p.Visibility {
+			case domain.PresenceNobody:
+				fmt.Println("Your last activity is shown to nobody")
+			case domain.PresenceContacts:
+				fmt.Printf("Your last activity is shown to %d contact(s)\n", len(p.Contacts))
+			default:
+				fmt.Println("Your last activity is shown to everyone")
+			}
+			return nil
+		},
+	}
+}
+
+// presenceShowCmd prints when peers were last seen, for those who allow it.
+func presenceShowCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show [peer...]",
+		Short: "Show when contacts were last seen, for those who allow it",
+		Args:  cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			seen, err := appCtx.AccountService.Presence(cmd.Context(), passphrase, username, args)
+			if err != nil {
+				return fmt.Errorf("fetching presence: %w", err)
+			}
+			shown := make(map[string]bool, len(seen))
+			for _, p := range seen {
+				shown[p.User] = true
+				fmt.Printf("%s\tlast seen %s\n", p.User, lastSeen(p.LastSeenUTC, time.Now()))
+			}
+			for _, peer := range args {
+				if !shown[peer] {
+					fmt.Printf("%s\tnot shown\n", peer)
+				}
+			}
+			if len(args) == 0 && len(seen) == 0 {
+				fmt.Println("No contacts show when they were last seen")
+			}
+			return nil
+		},
+	}
+}
+
+// lastSeen formats a last-seen time as of now.
+func lastSeen(utc int64, now time.Time) string {
+	t := time.Unix(utc, 0)
+	ago := now.Sub(t)
+	if ago < time.Minute {
+		return t.UTC().Format(time.RFC3339) + " (just now)"
+	}
+	return fmt.Sprintf("%s (%s ago)", t.UTC().Format(time.RFC3339), ago.Truncate(time.Minute))
+}
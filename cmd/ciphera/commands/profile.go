@@ -0,0 +1,195 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+)
+
+// profileCmd groups the commands that set our profile and show the ones
+// peers sent.
+func profileCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Set the display name and avatar your peers see, and show theirs",
+	}
+	cmd.AddCommand(profileSetCmd(), profileClearCmd(), profileShowCmd(), profilePhotoCmd())
+	return cmd
+}
+
+// profileSetCmd changes fields of our profile and sends it to our peers.
+// Fields whose flag is not given keep their current value.
+func profileSetCmd() *cobra.Command {
+	var (
+		name, avatar, photo string
+		noPhoto             bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "set",
+		Short: "Change your display name, avatar or photo and send the profile to your peers",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if photo != "" && noPhoto {
+				return errors.New("--photo and --no-photo are mutually exclusive")
+			}
+			p, _, err := appCtx.MessageService.Profile()
+			if err != nil {
+				return fmt.Errorf("loading profile: %w", err)
+			}
+			if cmd.Flags().Changed("name") {
+				p.DisplayName = name
+			}
+			if cmd.Flags().Changed("avatar") {
+				p.Avatar = avatar
+			}
+			switch {
+			case photo != "":
+				if p.Photo, err = os.ReadFile(photo); err != nil {
+					return fmt.Errorf("reading photo: %w", err)
+				}
+			case noPhoto:
+				p.Photo = nil
+			}
+			return shareProfile(cmd, p)
+		},
+	}
+
+	// Username flag is local to this command.
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkFlagRequired("username")
+	cmd.Flags().StringVar(&name, "name", "", "display name shown next to your username")
+	cmd.Flags().StringVar(&avatar, "avatar", "", "emoji or a few characters shown next to your name")
+	cmd.Flags().StringVar(&photo, "photo", "", "PNG, JPEG, GIF or WebP image of at most 16 KiB")
+	cmd.Flags().BoolVar(&noPhoto, "no-photo", false, "remove your photo")
+	return cmd
+}
+
+// profileClearCmd empties our profile and sends that to our peers, so they
+// show our username alone again.
+func profileClearCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "clear",
+		Short: "Remove your display name, avatar and photo, and tell your peers",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return shareProfile(cmd, domain.Profile{})
+		},
+	}
+
+	// Username flag is local to this command.
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkFlagRequired("username")
+	return cmd
+}
+
+// shareProfile saves p as our profile, sends it and reports who got it.
+func shareProfile(cmd *cobra.Command, p domain.Profile) error {
+	sent, err := appCtx.MessageService.SetProfile(cmd.Context(), passphrase, username, p)
+	if sent == nil && err != nil {
+		return fmt.Errorf("setting profile: %w", err)
+	}
+	fmt.Printf("Profile saved and sent to %d peer(s)\n", len(sent))
+	if err != nil {
+		return fmt.Errorf("sending profile: %w", err)
+	}
+	return nil
+}
+
+// profileShowCmd prints our profile and those peers sent, or one peer's.
+func profileShowCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show [peer]",
+		Short: "Show your profile and the profiles your peers sent",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			peers, err := appCtx.MessageService.PeerProfiles()
+			if err != nil {
+				return fmt.Errorf("listing profiles: %w", err)
+			}
+			if len(args) == 1 {
+				for _, p := range peers {
+					if p.Peer == args[0] {
+						printProfile(p.Peer, p)
+						return nil
+					}
+				}
+				return fmt.Errorf("%s has not sent a profile", args[0])
+			}
+
+			own, ok, err := appCtx.MessageService.Profile()
+			if err != nil {
+				return fmt.Errorf("loading profile: %w", err)
+			}
+			if ok && !own.Empty() {
+				printProfile("(you)", own)
+			} else {
+				fmt.Println("(you)\tno profile set")
+			}
+			for _, p := range peers {
+				printProfile(p.Peer, p)
+			}
+			return nil
+		},
+	}
+}
+
+// profilePhotoCmd writes the photo a peer sent to a file, since the terminal
+// cannot show it.
+func profilePhotoCmd() *cobra.Command {
+	var out string
+
+	cmd := &cobra.Command{
+		Use:   "photo <peer> -o <file>",
+		Short: "Save the photo a peer sent to a file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			peers, err := appCtx.MessageService.PeerProfiles()
+			if err != nil {
+				return fmt.Errorf("listing profiles: %w", err)
+			}
+			for _, p := range peers {
+				if p.Peer != args[0] {
+					continue
+				}
+				if len(p.Photo) == 0 {
+					break
+				}
+				if err := os.WriteFile(out, p.Photo, 0o600); err != nil {
+					return fmt.Errorf("writing photo: %w", err)
+				}
+				fmt.Printf("Wrote %s's photo (%d bytes, sha256 %s) to %s\n", p.Peer, len(p.Photo), p.PhotoHash, out)
+				return nil
+			}
+			return fmt.Errorf("%s has not sent a photo", args[0])
+		},
+	}
+	cmd.Flags().StringVarP(&out, "output", "o", "", "file to write the photo to")
+	_ = cmd.MarkFlagRequired("output")
+	return cmd
+}
+
+// printProfile prints one profile on a line, with who it belongs to first.
+func printProfile(who string, p domain.Profile) {
+	fmt.Printf("%s\tname=%q\tavatar=%q", who, p.DisplayName, p.Avatar)
+	if len(p.Photo) > 0 {
+		fmt.Printf("\tphoto=%d bytes sha256:%s", len(p.Photo), p.PhotoHash[:16])
+	}
+	fmt.Println()
+}
@@ -0,0 +1,159 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// errInputClosed is returned when stdin ends before a prompt is answered.
+var errInputClosed = errors.New("input closed before setup finished")
+
+// InputRequiredError is returned under --non-interactive when a command would
+// otherwise stop and wait for the user to type something.
+type InputRequiredError struct {
+	Input string // what would have been asked for
+	Hint  string // how to supply it without a terminal, if there is a way
+}
+
+func (e *InputRequiredError) Error() string {
+	msg := fmt.Sprintf("%s would be read from the terminal, but --non-interactive is set", e.Input)
+	if e.Hint != "" {
+		msg += "; " + e.Hint
+	}
+	return msg
+}
+
+// readStdin reads all of stdin as what. Piped input is always accepted; a
+// terminal is refused under --non-interactive, since reading it would wait
+// for the user.
+func readStdin(what, hint string) ([]byte, error) {
+	if nonInteractive && isTerminal(os.Stdin) {
+		return nil, &InputRequiredError{Input: what, Hint: hint}
+	}
+	return io.ReadAll(os.Stdin)
+}
+
+// prompter asks questions on a terminal (or any reader, for scripted input).
+//
+// Reads are abandoned when ctx is cancelled (e.g. Ctrl-C), so an interrupted
+// prompt returns promptly and terminal echo is restored. A disabled prompter
+// (--non-interactive) asks nothing and fails every question with an
+// InputRequiredError.
+type prompter struct {
+	ctx      context.Context
+	file     *os.File
+	in       *bufio.Reader
+	out      io.Writer
+	disabled bool
+}
+
+// newPrompter reads answers from in and writes questions to out.
+func newPrompter(ctx context.Context, in *os.File, out io.Writer) *prompter {
+	return &prompter{ctx: ctx, file: in, in: bufio.NewReader(in), out: out, disabled: nonInteractive}
+}
+
+// refuse returns an InputRequiredError for label if p is disabled.
+func (p *prompter) refuse(label string) error {
+	if p.disabled {
+		return &InputRequiredError{Input: label}
+	}
+	return nil
+}
+
+// line asks for a line of text, returning def if the answer is empty.
+func (p *prompter) line(label, def string) (string, error) {
+	if err := p.refuse(label); err != nil {
+		return "", err
+	}
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", label, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", label)
+	}
+	s, err := p.read()
+	if err != nil {
+		return "", err
+	}
+	if s = strings.TrimSpace(s); s == "" {
+		return def, nil
+	}
+	return s, nil
+}
+
+// confirm asks a yes/no question, returning def if the answer is empty.
+func (p *prompter) confirm(label string, def bool) (bool, error) {
+	if err := p.refuse(fmt.Sprintf("an answer to %q", label)); err != nil {
+		return false, err
+	}
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		fmt.Fprintf(p.out, "%s [%s]: ", label, hint)
+		s, err := p.read()
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		fmt.Fprintln(p.out, "Please answer y or n.")
+	}
+}
+
+// secret asks for a value without echoing it when stdin is a terminal.
+func (p *prompter) secret(label string) (string, error) {
+	if err := p.refuse(label); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(p.out, "%s: ", label)
+	restore, tty, err := disableEcho(p.file)
+	if err != nil {
+		return "", err
+	}
+	if !tty {
+		return p.read()
+	}
+	s, err := p.read()
+	restore()
+	fmt.Fprintln(p.out) // the user's Enter was not echoed
+	return s, err
+}
+
+// read returns the next line without its line ending. Other whitespace is
+// kept because it may be part of a passphrase.
+func (p *prompter) read() (string, error) {
+	type result struct {
+		s   string
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		s, err := p.in.ReadString('\n')
+		ch <- result{s, err}
+	}()
+
+	select {
+	case <-p.ctx.Done():
+		return "", p.ctx.Err()
+	case r := <-ch:
+		if errors.Is(r.err, io.EOF) && r.s == "" {
+			return "", errInputClosed
+		}
+		if r.err != nil && !errors.Is(r.err, io.EOF) {
+			return "", r.err
+		}
+		return strings.TrimRight(r.s, "\r\n"), nil
+	}
+}
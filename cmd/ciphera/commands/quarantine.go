@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// quarantineCmd groups the commands that inspect, retry or drop envelopes that
+// failed to decrypt during recv.
+func quarantineCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "quarantine",
+		Short: "Manage envelopes that failed to decrypt",
+	}
+	cmd.AddCommand(
+		quarantineListCmd(),
+		quarantineRetryCmd(),
+		quarantineDropCmd(),
+	)
+	return cmd
+}
+
+// quarantineListCmd prints every quarantined envelope with the reason it failed.
+func quarantineListCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List quarantined envelopes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			qs, err := appCtx.MessageService.ListQuarantined()
+			if err != nil {
+				return fmt.Errorf("listing quarantine: %w", err)
+			}
+			if len(qs) == 0 {
+				fmt.Println("Quarantine is empty")
+				return nil
+			}
+			for _, q := range qs {
+				fmt.Printf("%s\t%s\t%s\t%s\n",
+					q.ID,
+					q.Envelope.From,
+					time.Unix(q.QuarantinedUTC, 0).UTC().Format(time.RFC3339),
+					q.Reason,
+				)
+			}
+			return nil
+		},
+	}
+}
+
+// quarantineRetryCmd retries decryption of one (or all) quarantined envelopes.
+func quarantineRetryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "retry [id]",
+		Short: "Retry decrypting quarantined envelopes (all if no id is given)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id := ""
+			if len(args) == 1 {
+				id = args[0]
+			}
+			msgs, err := appCtx.MessageService.RetryQuarantined(
+				cmd.Context(),
+				passphrase,
+				username,
+				id,
+			)
+			if err != nil {
+				return fmt.Errorf("retrying quarantine: %w", err)
+			}
+			for _, m := range msgs {
+				printMessage(m)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkFlagRequired("username")
+
+	return cmd
+}
+
+// quarantineDropCmd permanently discards a quarantined envelope.
+func quarantineDropCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "drop <id>",
+		Short: "Discard a quarantined envelope",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := appCtx.MessageService.DropQuarantined(args[0]); err != nil {
+				return fmt.Errorf("dropping %q: %w", args[0], err)
+			}
+			fmt.Println("Envelope dropped")
+			return nil
+		},
+	}
+}
@@ -1,34 +1,157 @@
 package commands
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+	messagesvc "ciphera/internal/services/message"
 )
 
 // recvCmd fetches any queued ciphertexts, decrypts them, and prints them.
+//
+// --peer limits stdout to messages from one peer; messages from anyone else
+// are still received and go to stderr, so they are never silently dropped.
+// --raw writes the bodies from that peer to stdout byte for byte with no
+// sender prefix or separator, for use in a pipeline. Messages the relay
+// dropped because their sender's expiry passed are counted on stderr.
+//
+// --verify-report follows each message with a report of how it was
+// authenticated: the identity key its session was agreed with and whether
+// that is a paired contact's, when the session started, and whether opening
+// it took a DH ratchet step or a key kept for a message that arrived out of
+// order. The report goes wherever the message went, except with --raw, where
+// it goes to stderr so stdout stays byte for byte.
+//
+// --follow keeps receiving until interrupted. Fetches grow towards
+// --max-batch while the relay has a backlog and polls slow towards
+// --max-interval while it is idle; errors are printed and retried. With a
+// watchdog policy set (see conversations watchdog), it also warns about
+// conversations whose peer has stopped taking ratchet steps.
+//
+// After receiving, prekeys are republished if the session policy says they
+// are due (see conversations session-policy); with --follow, only after
+// batches that brought messages.
 func recvCmd() *cobra.Command {
+	var (
+		notify bool
+		raw    bool
+		report bool
+		peer   string
+		follow bool
+		pacing = messagesvc.DefaultPacing
+	)
+
 	cmd := &cobra.Command{
 		Use:   "recv",
 		Short: "Fetch and decrypt your queued messages",
 		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if raw && peer == "" {
+				return errors.New("--raw requires --peer")
+			}
+
+			if !follow {
+				for _, f := range []string{"min-batch", "max-batch", "min-interval", "max-interval"} {
+					if cmd.Flags().Changed(f) {
+						return fmt.Errorf("--%s requires --follow", f)
+					}
+				}
+			}
+
+			// show prints one batch, even if some envelopes were quarantined.
+			show := func(msgs []domain.DecryptedMessage, rep domain.ReceiveReport, err error) error {
+				forgetProfiles()
+				for _, m := range msgs {
+					out := io.Writer(os.Stderr)
+					switch {
+					case peer != "" && m.From != peer:
+						fmt.Fprintf(os.Stderr, "[%s] %s\n", peerLabel(m.From), renderBody(m.Body))
+					case raw && body.Local(m.Body.ContentType):
+						fmt.Fprintf(os.Stderr, "[%s] %s\n", peerLabel(m.From), renderBody(m.Body))
+					case raw:
+						if _, err := os.Stdout.Write(m.Body.Body); err != nil {
+							return fmt.Errorf("writing message body: %w", err)
+						}
+					default:
+						printMessage(m)
+						out = os.Stdout
+					}
+					if report {
+						printVerification(out, m)
+					}
+				}
+				if rep.Expired > 0 {
+					fmt.Fprintf(os.Stderr, "%d message(s) expired at the relay before they were fetched\n", rep.Expired)
+				}
+				printUndelivered(rep.Undelivered)
+				printStuck(rep.Stuck)
+				if notify {
+					if err := notifyMessages(msgs); err != nil {
+						return fmt.Errorf("notifications: %w", err)
+					}
+				}
+				if errors.Is(err, messagesvc.ErrQuarantined) {
+					fmt.Fprintln(os.Stderr, "See `ciphera quarantine list` for details")
+				}
+				if errors.Is(err, messagesvc.ErrHeld) {
+					fmt.Fprintln(os.Stderr, "See `ciphera held list` to review messages the receive filters held back")
+				}
+				if errors.Is(err, messagesvc.ErrSessionPending) {
+					fmt.Fprintln(os.Stderr, "Accept a new session with `ciphera conversations new-session <peer> accept`, "+
+						"then open its messages with `ciphera quarantine retry -u <you>`")
+				}
+				if errors.Is(err, domain.ErrArchived) {
+					fmt.Fprintln(os.Stderr, "Restore an archived conversation with `ciphera conversations unarchive <peer>`, "+
+						"then open its messages with `ciphera quarantine retry -u <you>`")
+				}
+				return nil
+			}
+
+			if follow {
+				// Errors while following are reported and retried; only
+				// failing to print stops the loop before Ctrl-C does.
+				return appCtx.MessageService.FollowMessages(
+					cmd.Context(),
+					passphrase,
+					username,
+					pacing,
+					func(msgs []domain.DecryptedMessage, rep domain.ReceiveReport, err error) error {
+						if err := show(msgs, rep, err); err != nil {
+							return err
+						}
+						if err != nil {
+							fmt.Fprintf(os.Stderr, "receiving messages: %v\n", err)
+						}
+						if len(msgs) > 0 {
+							maintainPrekeys(cmd.Context())
+						}
+						return nil
+					},
+				)
+			}
+
 			// 0 means no limit: fetch everything available.
-			msgs, err := appCtx.MessageService.ReceiveMessage(
+			msgs, rep, err := appCtx.MessageService.ReceiveMessage(
 				cmd.Context(),
 				passphrase,
 				username,
 				0,
 			)
+			if err := show(msgs, rep, err); err != nil {
+				return err
+			}
+			maintainPrekeys(cmd.Context())
 			if err != nil {
 				return fmt.Errorf("receiving messages: %w", err)
 			}
 
-			// Print messages.
-			for _, m := range msgs {
-				fmt.Printf("[%s] %s\n", m.From, string(m.Plaintext))
-			}
-
 			return nil
 		},
 	}
@@ -42,6 +165,77 @@ func recvCmd() *cobra.Command {
 		"your registered username",
 	)
 	_ = cmd.MarkFlagRequired("username")
+	cmd.Flags().BoolVar(
+		&notify,
+		"notify",
+		false,
+		"write a notification to stderr per message, honouring conversation preferences",
+	)
+	cmd.Flags().StringVar(
+		&peer,
+		"peer",
+		"",
+		"print only messages from this peer to stdout; others go to stderr",
+	)
+	cmd.Flags().BoolVar(
+		&raw,
+		"raw",
+		false,
+		"write only the message bodies from --peer to stdout, unmodified",
+	)
+	cmd.Flags().BoolVar(
+		&report,
+		"verify-report",
+		false,
+		"follow each message with how it was authenticated: identity key, session age, ratchet step, skipped keys",
+	)
+	cmd.Flags().BoolVarP(
+		&follow,
+		"follow",
+		"f",
+		false,
+		"keep receiving until interrupted, adapting batch size and polling to the queue",
+	)
+	cmd.Flags().IntVar(
+		&pacing.MinLimit,
+		"min-batch",
+		pacing.MinLimit,
+		"smallest fetch with --follow, used while the queue is idle",
+	)
+	cmd.Flags().IntVar(
+		&pacing.MaxLimit,
+		"max-batch",
+		pacing.MaxLimit,
+		"largest fetch with --follow, reached while working through a backlog",
+	)
+	cmd.Flags().DurationVar(
+		&pacing.MinInterval,
+		"min-interval",
+		pacing.MinInterval,
+		"shortest wait between polls with --follow",
+	)
+	cmd.Flags().DurationVar(
+		&pacing.MaxInterval,
+		"max-interval",
+		pacing.MaxInterval,
+		"longest wait between polls with --follow, reached while the queue stays empty",
+	)
 
 	return cmd
 }
+
+// maintainPrekeys republishes our prekeys if the session policy says they are
+// due, reporting what it did on stderr. Failing to is reported but does not
+// fail the command.
+func maintainPrekeys(ctx context.Context) {
+	m, err := appCtx.AccountService.MaintainPrekeys(ctx, passphrase, username)
+	for _, why := range m.Due {
+		fmt.Fprintf(os.Stderr, "Prekeys due for renewal: %s\n", why)
+	}
+	for _, a := range m.Accounts {
+		fmt.Fprintf(os.Stderr, "Republished prekeys to relay %s\n", a.Server)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "maintaining prekeys: %v\n", err)
+	}
+}
@@ -1,14 +1,26 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
 )
 
 // registerCmd generates a signed prekey and a batch of one-time keys, assembles them into a
-// PrekeyBundle, and publishes it to the relay.
+// PrekeyBundle, and publishes it to the relay (or to every relay with --all-relays). With
+// --export-bundle it also writes the bundle to a file for a peer to start a session from with
+// start-session --bundle-file; given no relay, it only writes the file.
 func registerCmd() *cobra.Command {
+	var (
+		allRelays       bool
+		challengeAnswer string
+		exportBundle    string
+	)
+
 	cmd := &cobra.Command{
 		Use:   "register <username>",
 		Short: "Publish your prekey bundle to the relay",
@@ -16,26 +28,116 @@ func registerCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			user := args[0]
 
-			// Generate and store a signed prekey plus N one-time prekeys.
-			_, _, err := appCtx.PrekeyService.GenerateAndStorePrekeys(passphrase, 10)
-			if err != nil {
-				return fmt.Errorf("generating prekeys: %w", err)
+			// Either the --relay given, or that plus every relay we already hold an account on.
+			var servers []string
+			if allRelays {
+				var err error
+				servers, err = appCtx.Relays.Servers()
+				if err != nil {
+					return fmt.Errorf("listing relays: %w", err)
+				}
+			} else if relayURL != "" {
+				servers = []string{relayURL}
 			}
 
-			// Build the public bundle (identity keys, signed prekey, one-time keys).
-			bundle, err := appCtx.PrekeyService.LoadPrekeyBundle(passphrase, user)
-			if err != nil {
-				return fmt.Errorf("loading bundle for %q: %w", user, err)
+			// Generates prekeys, checks for username conflicts and publishes per relay.
+			// Challenge prompts go to stderr so stdout stays the result.
+			if len(servers) > 0 || exportBundle == "" {
+				ctx := cmd.Context()
+				before, err := appCtx.PrekeyService.Status()
+				if err != nil {
+					return fmt.Errorf("reading prekey status: %w", err)
+				}
+				solve := challengeSolver(newPrompter(ctx, os.Stdin, os.Stderr), challengeAnswer)
+				accounts, err := appCtx.AccountService.Register(ctx, passphrase, user, servers, solve)
+				for _, a := range accounts {
+					fmt.Fprintf(statusOut(exportBundle), "Registered prekeys with relay %s\n", a.Server)
+				}
+				if len(accounts) > 0 {
+					recordRegister(user, accounts, before.SignedPrekeyID)
+				}
+				if err != nil {
+					return fmt.Errorf("registering bundle: %w", err)
+				}
 			}
-
-			// Publish the bundle to the relay.
-			if err := appCtx.RelayClient.RegisterPrekeyBundle(cmd.Context(), bundle); err != nil {
-				return fmt.Errorf("registering bundle: %w", err)
+			if exportBundle != "" {
+				return writeBundle(user, exportBundle)
 			}
-
-			fmt.Println("Registered prekeys with relay")
 			return nil
 		},
 	}
+
+	cmd.Flags().BoolVar(
+		&allRelays,
+		"all-relays",
+		false,
+		"register on --relay and every relay you already have an account on",
+	)
+	cmd.Flags().StringVar(
+		&challengeAnswer,
+		"challenge-answer",
+		"",
+		"invitation token or CAPTCHA response for a relay that asks for one (default: prompt)",
+	)
+	cmd.Flags().StringVar(
+		&exportBundle,
+		"export-bundle",
+		"",
+		"also write the bundle, without one-time prekeys, to this file (- for stdout) for start-session --bundle-file",
+	)
 	return cmd
 }
+
+// recordRegister records, for undo, that user's bundle was published to
+// accounts, replacing the signed prekey before.
+func recordRegister(user string, accounts []domain.Account, before domain.KeyID) {
+	after, err := appCtx.PrekeyService.Status()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: not recorded for undo: %v\n", err)
+		return
+	}
+	a := domain.Action{
+		Kind:     domain.ActionRegister,
+		Username: user,
+		Before:   string(before),
+		After:    string(after.SignedPrekeyID),
+	}
+	for _, acct := range accounts {
+		a.Servers = append(a.Servers, acct.Server)
+	}
+	recordAction(a)
+}
+
+// writeBundle writes user's exported prekey bundle as JSON to path, or to
+// stdout if path is "-".
+func writeBundle(user, path string) error {
+	bundle, err := appCtx.PrekeyService.ExportPrekeyBundle(passphrase, user)
+	if err != nil {
+		return fmt.Errorf("exporting bundle: %w", err)
+	}
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding bundle: %w", err)
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(path, data, 0o600)
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	// Status goes to stderr so it never mixes with the bundle on stdout.
+	fmt.Fprintf(os.Stderr, "Bundle for %s written; your peer starts a session with `ciphera start-session --bundle-file`\n", user)
+	return nil
+}
+
+// statusOut is where a command that may write data to stdout prints its
+// status: stderr if out is "-", else stdout.
+func statusOut(out string) *os.File {
+	if out == "-" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/relay"
+)
+
+var (
+	// relayRecord and relayReplay name cassette files for recording relay
+	// traffic or answering from it, for hermetic command-level tests.
+	relayRecord string
+	relayReplay string
+
+	recorder *relay.Recorder
+	replayer *relay.Replayer
+)
+
+// addRelayVCRFlags registers the hidden --relay-record and --relay-replay flags.
+func addRelayVCRFlags(root *cobra.Command) {
+	root.PersistentFlags().StringVar(
+		&relayRecord,
+		"relay-record",
+		"",
+		"record relay requests and responses to this cassette file (testing)",
+	)
+	root.PersistentFlags().StringVar(
+		&relayReplay,
+		"relay-replay",
+		"",
+		"answer relay requests from this cassette file instead of a relay (testing)",
+	)
+	_ = root.PersistentFlags().MarkHidden("relay-record")
+	_ = root.PersistentFlags().MarkHidden("relay-replay")
+}
+
+// relayTransport wraps base in a recorder, replaces it with a replayer, or
+// returns it unchanged, according to the flags.
+func relayTransport(base http.RoundTripper) (http.RoundTripper, error) {
+	switch {
+	case relayRecord != "" && relayReplay != "":
+		return nil, fmt.Errorf("--relay-record and --relay-replay are mutually exclusive")
+	case relayRecord != "":
+		recorder = relay.NewRecorder(base)
+		return recorder, nil
+	case relayReplay != "":
+		c, err := relay.LoadCassette(relayReplay)
+		if err != nil {
+			return nil, err
+		}
+		replayer = relay.NewReplayer(c)
+		return replayer, nil
+	default:
+		return base, nil
+	}
+}
+
+// finishRelayVCR saves the recording, or fails if the command left recorded
+// exchanges unreplayed, so a replayed test notices when a command stops
+// making a request it used to.
+func finishRelayVCR() error {
+	if recorder != nil {
+		if err := recorder.Save(relayRecord); err != nil {
+			return fmt.Errorf("saving relay recording: %w", err)
+		}
+	}
+	if replayer != nil {
+		if n := replayer.Remaining(); n > 0 {
+			return fmt.Errorf("relay replay: %d recorded exchange(s) not replayed", n)
+		}
+	}
+	return nil
+}
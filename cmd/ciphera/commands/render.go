@@ -0,0 +1,214 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+	"ciphera/internal/protocol/poll"
+)
+
+// renderBody returns how a message body is shown in the terminal. Text types
+// print as-is (markdown unrendered), under the file name of a file sent
+// inline; other types print a bracketed summary so unknown or binary content
+// never reaches the terminal raw.
+func renderBody(b domain.MessageBody) string {
+	switch {
+	case body.IsText(b) && b.Metadata[body.MetaFileName] != "":
+		return fmt.Sprintf("[file %s]\n%s", b.Metadata[body.MetaFileName], b.Body)
+	case body.IsText(b):
+		return string(b.Body)
+	case b.ContentType == body.TypeFile:
+		name := b.Metadata[body.MetaFileName]
+		if name == "" {
+			name = "unnamed"
+		}
+		if n, err := strconv.ParseInt(b.Metadata[body.MetaFileSize], 10, 64); err == nil {
+			return fmt.Sprintf("[file %s, %d bytes]", name, n)
+		}
+		return fmt.Sprintf("[file %s]", name)
+	case b.ContentType == body.TypeReceipt:
+		kind := b.Metadata[body.MetaReceiptKind]
+		if kind == "" {
+			kind = "delivery"
+		}
+		return fmt.Sprintf("[%s receipt for message %s]", kind, b.Metadata[body.MetaReceiptOf])
+	case b.ContentType == body.TypePoll:
+		p, err := poll.Parse(b)
+		if err != nil {
+			return "[malformed poll]"
+		}
+		opts := make([]string, len(p.Options))
+		for i, o := range p.Options {
+			opts[i] = fmt.Sprintf("%d) %s", i+1, o)
+		}
+		return fmt.Sprintf("[poll %s] %s %s", p.ID, p.Question, strings.Join(opts, " "))
+	case b.ContentType == body.TypeVote:
+		id, choice, err := poll.ParseVote(b)
+		if err != nil {
+			return "[malformed vote]"
+		}
+		return fmt.Sprintf("[vote for option %d in poll %s]", choice+1, id)
+	case b.ContentType == body.TypeWipe:
+		switch b.Metadata[body.MetaWipeResult] {
+		case body.WipeResultWiped:
+			return "[conversation wiped at the peer's request; start a new session to talk again]"
+		case body.WipeResultConfirmed:
+			return "[peer wiped the conversation; local copy deleted]"
+		case body.WipeResultRefused:
+			return "[peer refused the wipe request]"
+		case body.WipeResultUnverified:
+			return "[wipe request refused: pair with the peer to verify it]"
+		default:
+			return "[wipe request refused; allow with `conversations remote-wipe <peer> accept`]"
+		}
+	case b.ContentType == body.TypeAudit:
+		who := "state audit"
+		if b.Metadata[body.MetaAuditAsker] == body.AuditAskerPeer {
+			who = "state audit requested by peer"
+		}
+		if len(b.Body) == 0 {
+			return fmt.Sprintf("[%s: %s]", who, b.Metadata[body.MetaAuditResult])
+		}
+		return fmt.Sprintf("[%s: %s: %s]", who, b.Metadata[body.MetaAuditResult], b.Body)
+	case b.ContentType == body.TypePong:
+		return "[" + pongSummary(b) + "]"
+	case b.ContentType == body.TypeRetry:
+		return fmt.Sprintf("[peer lacked the one-time prekey of our handshake; started a new one from a fresh bundle. %s message(s) sent on the old one were not delivered; send them again]",
+			b.Metadata[body.MetaRetryLost])
+	case b.Metadata[body.MetaFileName] != "":
+		return fmt.Sprintf("[file %s, %s, %d bytes]", b.Metadata[body.MetaFileName], b.ContentType, len(b.Body))
+	default:
+		return fmt.Sprintf("[%s, %d bytes]", b.ContentType, len(b.Body))
+	}
+}
+
+// printMessage prints one received message.
+func printMessage(m domain.DecryptedMessage) {
+	fmt.Printf("[%s] %s\n", peerLabel(m.From), renderBody(m.Body))
+}
+
+// printUndelivered reports, on stderr, messages we sent that expired at the
+// relay before the peer fetched them.
+func printUndelivered(ms []domain.UndeliveredMessage) {
+	for _, m := range ms {
+		fmt.Fprintf(os.Stderr, "Your message to %s sent %s (seq %d) expired unfetched at %s; it was not delivered\n",
+			peerLabel(m.Peer),
+			time.Unix(m.SentUTC, 0).UTC().Format(time.RFC3339),
+			m.Seq,
+			time.Unix(m.ExpiresUTC, 0).UTC().Format(time.RFC3339),
+		)
+	}
+}
+
+// printStuck warns, on stderr, about conversations the watchdog flagged and
+// says how to recover each one. The advice only touches that conversation:
+// the rekey policy is global, so it is never suggested here.
+func printStuck(cs []domain.StuckConversation) {
+	for _, c := range cs {
+		fmt.Fprintf(os.Stderr, "Warning: %s has not answered your last %d message(s), the first sent %s; their state for this conversation may be broken\n",
+			peerLabel(c.Peer),
+			c.Unanswered,
+			time.Unix(c.SinceUTC, 0).UTC().Format(time.RFC3339),
+		)
+		fmt.Fprintf(os.Stderr, "  Try `ciphera start-session --reset %s`, which starts this conversation over and leaves others alone\n", c.Peer)
+	}
+}
+
+// printVerification writes m's authenticity report to w, indented under the
+// message, for recv --verify-report.
+func printVerification(w io.Writer, m domain.DecryptedMessage) {
+	v := m.Verification
+	if v == nil {
+		fmt.Fprintln(w, "    not read from an envelope; no report")
+		return
+	}
+	identity := "unknown (conversation predates recording it)"
+	if v.IdentityKey != (domain.X25519Public{}) {
+		identity = crypto.Fingerprint(v.IdentityKey.Slice())
+		switch {
+		case v.Paired:
+			identity += " (paired contact)"
+		case v.KeyChanged:
+			identity += " (DIFFERS from the paired contact's key)"
+		default:
+			identity += " (not paired)"
+		}
+	}
+	at := func(utc int64) string { return time.Unix(utc, 0).UTC().Format(time.RFC3339) }
+	session := "start unknown"
+	switch {
+	case v.NewSession:
+		session = "new, set up by this message"
+	case v.SessionStartedUTC != 0:
+		age := time.Since(time.Unix(v.SessionStartedUTC, 0)).Round(time.Second)
+		session = fmt.Sprintf("started %s (%s ago)", at(v.SessionStartedUTC), age)
+	}
+	if v.RekeyedUTC != 0 {
+		session += ", rekeyed " + at(v.RekeyedUTC)
+	}
+	if v.Stale {
+		session += ", opened with the peer's superseded handshake"
+	}
+	ratchet := "same chain"
+	if v.RatchetStep {
+		ratchet = "DH ratchet step"
+	}
+	keys := "current chain key"
+	switch {
+	case v.SkippedKeyUsed:
+		keys = "skipped key (arrived out of order)"
+	case v.KeysSkipped > 0:
+		keys = fmt.Sprintf("current chain key; %d kept for earlier messages not yet received", v.KeysSkipped)
+	}
+	header := "no MAC (peer predates header MACs)"
+	if v.HeaderMAC {
+		header = "MAC verified"
+	}
+	fmt.Fprintf(w, "    identity: %s\n", identity)
+	fmt.Fprintf(w, "    session:  %s\n", session)
+	fmt.Fprintf(w, "    ratchet:  message %d, previous chain %d, %s, suite %s\n", v.N, v.PN, ratchet, v.Suite)
+	fmt.Fprintf(w, "    keys:     %s\n", keys)
+	fmt.Fprintf(w, "    header:   %s\n", header)
+	if v.EnvelopeID != "" {
+		fmt.Fprintf(w, "    envelope: %s\n", v.EnvelopeID)
+	}
+}
+
+// profiles caches the profiles peers sent, by peer, for peerLabel. nil means
+// not loaded yet.
+var profiles map[string]domain.Profile
+
+// peerLabel returns how peer is shown: their avatar and display name, if
+// they sent a profile, then the username. The username is always shown,
+// since a peer can choose any display name, including someone else's.
+func peerLabel(peer string) string {
+	if profiles == nil {
+		profiles = make(map[string]domain.Profile)
+		ps, err := appCtx.MessageService.PeerProfiles()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "profiles not loaded: %v\n", err)
+		}
+		for _, p := range ps {
+			profiles[p.Peer] = p
+		}
+	}
+	p := profiles[peer]
+	label := strings.TrimSpace(p.Avatar + " " + p.DisplayName)
+	if label == "" {
+		return peer
+	}
+	return fmt.Sprintf("%s (%s)", label, peer)
+}
+
+// forgetProfiles makes the next peerLabel reload the profiles, which a
+// receive may have changed.
+func forgetProfiles() {
+	profiles = nil
+}
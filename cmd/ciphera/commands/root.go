@@ -2,7 +2,9 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
@@ -13,6 +15,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"ciphera/internal/app"
+	"ciphera/internal/relay"
 )
 
 var (
@@ -21,6 +24,15 @@ var (
 	relayURL   string
 	username   string
 	passphrase string
+	verbose    bool
+	useH2C     bool
+	useHTTP3   bool
+	trace      bool
+	prewarm    bool
+
+	// nonInteractive makes every command fail with an InputRequiredError
+	// instead of waiting for terminal input.
+	nonInteractive bool
 
 	// appCtx holds the wired dependencies after PersistentPreRunE.
 	appCtx *app.Wire
@@ -45,33 +57,80 @@ func Execute() error {
 			}
 
 			// Construct an HTTP client with sensible timeouts and connection pooling.
-			httpClient := &http.Client{
-				Timeout: 15 * time.Second,
-				Transport: &http.Transport{
-					Proxy: http.ProxyFromEnvironment,
-					DialContext: (&net.Dialer{
-						Timeout:   5 * time.Second,
-						KeepAlive: 30 * time.Second,
-					}).DialContext,
-					TLSHandshakeTimeout:   5 * time.Second,
-					ExpectContinueTimeout: 1 * time.Second,
-					IdleConnTimeout:       90 * time.Second,
-					MaxIdleConns:          100,
-					MaxIdleConnsPerHost:   10,
+			// HTTP/2 multiplexes concurrent requests over one connection per relay;
+			// pings keep idle connections alive and detect dead ones. Relay host
+			// names are resolved through a small cache, so redials in receive
+			// loops and failover checks skip the resolver.
+			dns := relay.NewDNSCache(nil, 0, 0)
+			tcp := &http.Transport{
+				Protocols: clientProtocols(useH2C),
+				HTTP2: &http.HTTP2Config{
+					SendPingTimeout: 30 * time.Second,
+					PingTimeout:     15 * time.Second,
 				},
+				ForceAttemptHTTP2: true,
+				Proxy:             http.ProxyFromEnvironment,
+				DialContext: dns.DialContext((&net.Dialer{
+					Timeout:   5 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).DialContext),
+				TLSHandshakeTimeout:   5 * time.Second,
+				ExpectContinueTimeout: 1 * time.Second,
+				IdleConnTimeout:       90 * time.Second,
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   10,
+			}
+			httpClient := &http.Client{Timeout: 15 * time.Second, Transport: tcp}
+			// With --http3, https:// relays are spoken to over QUIC instead.
+			if useHTTP3 {
+				httpClient.Transport = relay.NewHTTP3Transport(tcp)
+			}
+
+			transport, err := relayTransport(httpClient.Transport)
+			if err != nil {
+				return err
+			}
+			httpClient.Transport = transport
+
+			// Debug logs go to stderr so they never mix with command output.
+			var logger *slog.Logger
+			if verbose {
+				logger = slog.New(
+					slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}),
+				)
 			}
 
 			cfg := app.Config{
 				HomeDir:    homeDir,
 				RelayURL:   relayURL,
 				HTTPClient: httpClient,
+				Logger:     logger,
+
+				StoreTelemetry: storeTelemetry,
+				StoreFaults:    storeFaults,
 			}
-			var err error
 			appCtx, err = app.NewWire(cfg)
 			if err != nil {
 				return fmt.Errorf("initialising application: %w", err)
 			}
-			return nil
+			prewarmRelay(cmd, httpClient)
+
+			// Every relay request of this command joins one trace, whose ID
+			// goes to stderr for looking it up in the relay operator's tracing.
+			if trace {
+				ctx, id := relay.WithTrace(cmd.Context())
+				cmd.SetContext(ctx)
+				fmt.Fprintf(os.Stderr, "trace %s\n", id)
+			}
+
+			// Commands that talk to the relay first show what its operator
+			// announces, and stop if it no longer supports this client.
+			return checkRelayNotice(cmd)
+		},
+		// Commands marked by keepsPrekeys run scheduled prekey upkeep once
+		// they succeed.
+		PersistentPostRun: func(cmd *cobra.Command, args []string) {
+			runPrekeyUpkeep(cmd)
 		},
 	}
 
@@ -95,15 +154,83 @@ func Execute() error {
 		"",
 		"relay URL, e.g. http://127.0.0.1:8080",
 	)
+	root.PersistentFlags().BoolVarP(
+		&verbose,
+		"verbose",
+		"v",
+		false,
+		"log state transitions to stderr (never key material)",
+	)
+	root.PersistentFlags().BoolVar(
+		&useH2C,
+		"h2c",
+		false,
+		"speak HTTP/2 without TLS to http:// relays (relay must run with --h2c)",
+	)
+	root.PersistentFlags().BoolVar(
+		&useHTTP3,
+		"http3",
+		false,
+		"experimental: speak HTTP/3 over QUIC to https:// relays (relay must run with --http3)",
+	)
+	root.PersistentFlags().BoolVar(
+		&trace,
+		"trace",
+		false,
+		"send W3C trace context with relay requests and print the trace ID",
+	)
+	root.PersistentFlags().BoolVar(
+		&prewarm,
+		"prewarm",
+		false,
+		"connect to the relay in the background while the command starts, to hide dial and TLS latency",
+	)
+	root.PersistentFlags().BoolVar(
+		&nonInteractive,
+		"non-interactive",
+		false,
+		"never prompt or wait for terminal input; fail instead (for scripts)",
+	)
+	addRelayVCRFlags(root)
+	addStoreFaultFlags(root)
+	addStoreTelemetryFlag(root)
 
 	// Register sub-commands.
 	root.AddCommand(
+		setupCmd(),
 		initCmd(),
 		fingerprintCmd(),
-		registerCmd(),
-		startSessionCmd(),
-		sendCmd(),
-		recvCmd(),
+		rotateSigningKeyCmd(),
+		checksRelay(registerCmd()),
+		endpointsCmd(),
+		pairCmd(),
+		attestCmd(),
+		profileCmd(),
+		checksRelay(startSessionCmd()),
+		keepsPrekeys(checksRelay(sendCmd())),
+		keepsPrekeys(checksRelay(composeCmd())),
+		checksRelay(broadcastCmd()),
+		keepsPrekeys(checksRelay(pollCmd())),
+		checksRelay(recvCmd()),
+		keepsPrekeys(checksRelay(pingCmd())),
+		sentCmd(),
+		exportEnvelopeCmd(),
+		importEnvelopeCmd(),
+		sessionsCmd(),
+		prekeysCmd(),
+		backupCmd(),
+		usageCmd(),
+		journalCmd(),
+		presenceCmd(),
+		conversationsCmd(),
+		quarantineCmd(),
+		heldCmd(),
+		wipeCmd(),
+		undoCmd(),
+		historyCmd(),
+		statsCmd(),
+		devtoolsCmd(),
+		versionCmd(),
 	)
 
 	// Create a signal-aware context so Ctrl-C cancels in-flight HTTP calls.
@@ -111,5 +238,36 @@ func Execute() error {
 	defer stop()
 	root.SetContext(ctx)
 
-	return root.Execute()
+	err := root.Execute()
+	printStoreTelemetry()
+	return errors.Join(err, finishRelayVCR())
+}
+
+// prewarmRelay, with --prewarm, starts connecting to the default relay in the
+// background for a command that talks to it, so the connection is ready by
+// the time the command has unlocked its keys. Recorded and replayed runs are
+// left alone, as the extra request would change the cassette.
+func prewarmRelay(cmd *cobra.Command, client *http.Client) {
+	if !prewarm || cmd.Annotations[relayNoticeAnnotation] == "" || relayRecord != "" || relayReplay != "" {
+		return
+	}
+	servers, err := appCtx.Relays.Servers()
+	if err != nil || len(servers) == 0 {
+		return
+	}
+	relay.Prewarm(cmd.Context(), client, servers[0])
+}
+
+// clientProtocols returns the protocols the relay transport may use. HTTP/2 is
+// negotiated over TLS; with h2c, plain http:// relays are spoken to in HTTP/2
+// directly (prior knowledge) instead of HTTP/1.1.
+func clientProtocols(h2c bool) *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP2(true)
+	if h2c {
+		p.SetUnencryptedHTTP2(true)
+	} else {
+		p.SetHTTP1(true)
+	}
+	return p
 }
@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+)
+
+// rotateSigningKeyCmd replaces the Ed25519 signing key, keeping the identity key,
+// and republishes freshly signed prekeys to every relay we hold an account on.
+func rotateSigningKeyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rotate-signing-key",
+		Short: "Replace your signing key and republish your prekey bundles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			accounts, err := appCtx.AccountService.ListAccounts()
+			if err != nil {
+				return fmt.Errorf("listing accounts: %w", err)
+			}
+
+			id, err := appCtx.IdentityService.LoadIdentity(passphrase)
+			if err != nil {
+				return fmt.Errorf("loading identity: %w", err)
+			}
+			pub, err := appCtx.IdentityService.RotateSigningKey(passphrase)
+			if err != nil {
+				return fmt.Errorf("rotating signing key: %w", err)
+			}
+			fmt.Printf("New signing key: %s\n", hex.EncodeToString(pub[:]))
+			recordAction(domain.Action{
+				Kind:   domain.ActionRotateSigningKey,
+				Before: hex.EncodeToString(id.EdPub[:]),
+				After:  hex.EncodeToString(pub[:]),
+			})
+
+			if len(accounts) == 0 {
+				fmt.Println("No relay accounts recorded; run register to publish the new key.")
+				return nil
+			}
+
+			// Republish per username so each bundle is re-signed with the new key.
+			servers := make(map[string][]string)
+			var users []string
+			for _, a := range accounts {
+				if _, ok := servers[a.Username]; !ok {
+					users = append(users, a.Username)
+				}
+				servers[a.Username] = append(servers[a.Username], a.Server)
+			}
+			var errs []error
+			// These usernames already exist, so relays only ask for a signature by
+			// the old key's successor, which Register makes itself.
+			for _, user := range users {
+				registered, err := appCtx.AccountService.Register(
+					cmd.Context(), passphrase, user, servers[user], nil,
+				)
+				for _, a := range registered {
+					fmt.Printf("Republished prekeys for %s on relay %s\n", a.Username, a.Server)
+				}
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+			if err := errors.Join(errs...); err != nil {
+				return fmt.Errorf("republishing bundles: %w", err)
+			}
+			return nil
+		},
+	}
+}
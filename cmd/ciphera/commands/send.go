@@ -1,23 +1,83 @@
 package commands
 
 import (
+	"encoding/hex"
 	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
 )
 
 // sendCmd encrypts and sends a message to <peer>, after validating inputs.
+// Without a message argument the body is read from stdin, so the command can
+// sit at the end of a pipeline. With --dry-run it stops before posting and
+// prints the envelope instead. A peer of the form @<list> sends to every
+// member of a broadcast list. --expires asks the relay to drop the envelope
+// if the peer has not fetched it in time.
 func sendCmd() *cobra.Command {
+	var (
+		contentType string
+		meta        map[string]string
+		force       bool
+		dryRun      bool
+		expires     time.Duration
+	)
+
 	cmd := &cobra.Command{
-		Use:   "send <peer> <message>",
-		Short: "Encrypt and send a message to a peer",
-		Args:  cobra.ExactArgs(2),
+		Use:   "send <peer|@list> [message]",
+		Short: "Encrypt and send a message to a peer (stdin if no message is given)",
+		Args:  cobra.RangeArgs(1, 2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			peer := args[0]
-			msg := []byte(args[1])
+			if expires < 0 {
+				return fmt.Errorf("--expires must not be negative")
+			}
+			msg := domain.MessageBody{
+				ContentType: strings.ToLower(contentType),
+				Metadata:    meta,
+			}
+			if len(args) == 2 {
+				msg.Body = []byte(args[1])
+			} else {
+				data, err := readStdin("message body", "pass it as an argument or pipe it in")
+				if err != nil {
+					return fmt.Errorf("reading message from stdin: %w", err)
+				}
+				msg.Body = data
+				// Piped input may be binary; label it so the peer does not
+				// print it as text, unless the caller chose a type.
+				if !cmd.Flags().Changed("content-type") && !utf8.Valid(data) {
+					msg.ContentType = body.TypeBinary
+				}
+			}
+
+			if list, ok := strings.CutPrefix(peer, "@"); ok {
+				if dryRun {
+					return fmt.Errorf("--dry-run cannot be used with a broadcast list")
+				}
+				results, err := appCtx.BroadcastService.Send(cmd.Context(), passphrase, username, list, msg, force, expires)
+				if err != nil {
+					return fmt.Errorf("sending to list %q: %w", list, err)
+				}
+				return printBroadcastResults(list, results)
+			}
+
+			if dryRun {
+				p, err := appCtx.MessageService.PreviewMessage(passphrase, username, peer, msg, force)
+				if err != nil {
+					return fmt.Errorf("previewing message to %q: %w", peer, err)
+				}
+				printPreview(p)
+				return nil
+			}
 
 			// Handles unlocking keys, ratchet state, and HTTP post via appCtx.
-			err := appCtx.MessageService.SendMessage(cmd.Context(), passphrase, username, peer, msg)
+			err := appCtx.MessageService.SendMessage(cmd.Context(), passphrase, username, peer, msg, force, expires)
 			if err != nil {
 				return fmt.Errorf("sending message to %q: %w", peer, err)
 			}
@@ -36,6 +96,61 @@ func sendCmd() *cobra.Command {
 		"your registered username",
 	)
 	_ = cmd.MarkFlagRequired("username")
+	cmd.Flags().StringVar(
+		&contentType,
+		"content-type",
+		body.TypeText,
+		"content type of the message, e.g. text/markdown (stdin that is not UTF-8 defaults to application/octet-stream)",
+	)
+	cmd.Flags().StringToStringVar(
+		&meta,
+		"meta",
+		nil,
+		"metadata sent with the message as key=value pairs",
+	)
+	cmd.Flags().BoolVar(
+		&force,
+		"force",
+		false,
+		"send even if the send policy requires a verified peer or the peer lacks a capability",
+	)
+	cmd.Flags().BoolVar(
+		&dryRun,
+		"dry-run",
+		false,
+		"encrypt and print the envelope without sending it or saving state",
+	)
+	cmd.Flags().DurationVar(
+		&expires,
+		"expires",
+		0,
+		"have the relay drop the message if it is still unfetched after this long, e.g. 1h",
+	)
 
 	return cmd
 }
+
+// printPreview shows the envelope a send would post. The ciphertext itself is
+// never shown, only its size.
+func printPreview(p domain.MessagePreview) {
+	env := p.Envelope
+	fmt.Println("Dry run: nothing was sent and no state was saved")
+	fmt.Printf("relay:       %s\n", p.Relay)
+	fmt.Printf("from/to:     %s -> %s\n", env.From, env.To)
+	fmt.Printf("header:      dh_pub=%s pn=%d n=%d\n", hex.EncodeToString(env.Header.DHPub), env.Header.PN, env.Header.N)
+	if pk := env.Prekey; pk != nil {
+		opk := pk.OPKID
+		if opk == "" {
+			opk = "none"
+		}
+		fmt.Printf("prekey:      attached (spk=%s opk=%s ephemeral=%s)\n", pk.SPKID, opk, hex.EncodeToString(pk.Ephemeral[:]))
+	} else {
+		fmt.Println("prekey:      not attached (existing conversation)")
+	}
+	fmt.Printf("body:        %d bytes\n", p.BodyBytes)
+	fmt.Printf("ciphertext:  %d bytes\n", p.CipherBytes)
+	fmt.Printf("envelope:    %d bytes on the wire\n", p.WireBytes)
+	if p.Parts > 0 {
+		fmt.Printf("chunks:      %d envelopes; sizes above are for the first\n", p.Parts)
+	}
+}
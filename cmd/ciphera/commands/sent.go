@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+)
+
+// sentCmd lists the messages sent to a peer that the relay accepted, with the
+// sequence number it assigned and whether the peer has fetched each one or it
+// expired first.
+func sentCmd() *cobra.Command {
+	var limit int
+
+	cmd := &cobra.Command{
+		Use:   "sent <peer>",
+		Short: "Show which messages the relay accepted and which the peer has fetched",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sent, err := appCtx.MessageService.SentMessages(cmd.Context(), username, args[0])
+			if err != nil {
+				return fmt.Errorf("listing messages sent to %q: %w", args[0], err)
+			}
+			if limit > 0 && len(sent) > limit {
+				sent = sent[len(sent)-limit:]
+			}
+			if len(sent) == 0 {
+				fmt.Println("No sent messages")
+				return nil
+			}
+			for _, m := range sent {
+				seq := "-"
+				if m.Seq != 0 {
+					seq = fmt.Sprint(m.Seq)
+				}
+				fmt.Printf("%s\tseq=%s\t%s\t%s\t%d bytes",
+					time.Unix(m.SentUTC, 0).UTC().Format(time.RFC3339),
+					seq,
+					m.State,
+					m.ContentType,
+					m.Size,
+				)
+				switch {
+				case m.State == domain.SentQueued && m.ExpiresUTC != 0:
+					fmt.Printf("\texpires %s", time.Unix(m.ExpiresUTC, 0).UTC().Format(time.RFC3339))
+				case m.State == domain.SentExpired:
+					fmt.Printf("\texpired %s, not delivered", time.Unix(m.ExpiresUTC, 0).UTC().Format(time.RFC3339))
+				}
+				fmt.Println()
+			}
+			return nil
+		},
+	}
+
+	// Username flag is local to this command.
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkFlagRequired("username")
+	cmd.Flags().IntVarP(&limit, "limit", "n", 0, "show only the last n messages")
+	return cmd
+}
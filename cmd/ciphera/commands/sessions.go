@@ -0,0 +1,170 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/crypto"
+)
+
+// sessionsCmd lists conversations, whether each handshake has been confirmed by the peer, and
+// how many skipped message keys are stored for it, how often it has been rekeyed, its cipher suite
+// if that is not the default, and which contacts attested the peer, and groups the export, import
+// and audit subcommands.
+func sessionsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sessions",
+		Short: "Show handshake confirmation and skipped-key counts per session",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			statuses, err := appCtx.MessageService.SessionStatuses()
+			if err != nil {
+				return fmt.Errorf("listing sessions: %w", err)
+			}
+			if len(statuses) == 0 {
+				fmt.Println("No sessions")
+				return nil
+			}
+			for _, st := range statuses {
+				fmt.Printf("%s\t%s\tskipped=%d\trekeys=%d", st.Peer, st.Confirm, st.SkippedKeys, st.Rekeys)
+				if st.Suite != "" && st.Suite != crypto.DefaultSuite {
+					fmt.Printf("\tsuite=%s", st.Suite)
+				}
+				if v := vouchers(st.VouchedBy); v != "" {
+					fmt.Printf("\t%s", v)
+				}
+				fmt.Println()
+			}
+			return nil
+		},
+	}
+	cmd.AddCommand(sessionsExportCmd(), sessionsImportCmd(), sessionsAuditCmd())
+	return cmd
+}
+
+// sessionsExportCmd writes one conversation's session and ratchet state to a
+// passphrase-encrypted file.
+func sessionsExportCmd() *cobra.Command {
+	var (
+		out              string
+		remove           bool
+		backupPassphrase string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export <peer>",
+		Short: "Export one conversation's session state, encrypted (-o - writes stdout)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if backupPassphrase == "" {
+				backupPassphrase = passphrase
+			}
+			data, err := appCtx.BackupService.ExportConversation(passphrase, backupPassphrase, args[0], remove)
+			if err != nil {
+				return fmt.Errorf("exporting conversation with %s: %w", args[0], err)
+			}
+
+			// Status goes to stderr so it never mixes with an export on stdout.
+			if out == "-" {
+				_, err = os.Stdout.Write(data)
+			} else {
+				err = os.WriteFile(out, data, 0o600)
+			}
+			if err != nil {
+				return fmt.Errorf("writing %s: %w", out, err)
+			}
+			if remove {
+				fmt.Fprintf(os.Stderr, "Exported and removed conversation with %s\n", args[0])
+			} else {
+				fmt.Fprintf(os.Stderr, "Exported conversation with %s; do not keep using it here after importing it elsewhere\n", args[0])
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&out, "out", "o", "", "file to write the export to (- for stdout)")
+	_ = cmd.MarkFlagRequired("out")
+	cmd.Flags().BoolVar(&remove, "remove", false, "delete the local copy once exported")
+	cmd.Flags().StringVar(
+		&backupPassphrase,
+		"backup-passphrase",
+		"",
+		"passphrase to encrypt the export with (default: --passphrase)",
+	)
+	return cmd
+}
+
+// sessionsImportCmd restores a conversation written by sessions export.
+func sessionsImportCmd() *cobra.Command {
+	var (
+		replace          bool
+		backupPassphrase string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import a conversation written by sessions export (- reads stdin)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if backupPassphrase == "" {
+				backupPassphrase = passphrase
+			}
+			var (
+				data []byte
+				err  error
+			)
+			if args[0] == "-" {
+				data, err = readStdin("conversation export", "pipe it in or pass a file")
+			} else {
+				data, err = os.ReadFile(args[0])
+			}
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", args[0], err)
+			}
+
+			b, err := appCtx.BackupService.ImportConversation(passphrase, backupPassphrase, data, replace)
+			if err != nil {
+				return fmt.Errorf("importing %s: %w", args[0], err)
+			}
+			fmt.Printf("Imported conversation with %s (skipped=%d)\n", b.Peer, len(b.Conversation.State.Skipped))
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&replace, "replace", false, "overwrite an existing conversation with the same peer")
+	cmd.Flags().StringVar(
+		&backupPassphrase,
+		"backup-passphrase",
+		"",
+		"passphrase the export was encrypted with (default: --passphrase)",
+	)
+	return cmd
+}
+
+// sessionsAuditCmd asks the peer to compare conversation state with us. The
+// outcome arrives as a notice on a later recv, on both sides.
+func sessionsAuditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit <peer>",
+		Short: "Compare message counters and root key epoch with the peer to detect forked state",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := appCtx.MessageService.RequestAudit(cmd.Context(), passphrase, username, args[0]); err != nil {
+				return fmt.Errorf("auditing conversation with %s: %w", args[0], err)
+			}
+			fmt.Printf("Audit request sent to %s; the result is shown by `ciphera recv` once they reply\n", args[0])
+			return nil
+		},
+	}
+
+	// Username flag is local to this command.
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkFlagRequired("username")
+	return cmd
+}
@@ -0,0 +1,192 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	identitysvc "ciphera/internal/services/identity"
+)
+
+// defaultSetupRelay is offered when --relay is not given.
+const defaultSetupRelay = "http://127.0.0.1:8080"
+
+// maxUnlockAttempts bounds how often setup asks for an existing identity's passphrase.
+const maxUnlockAttempts = 3
+
+// setupCmd walks a new user through creating an identity and registering with a relay.
+func setupCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "setup",
+		Short: "Interactively create an identity and register with a relay",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if nonInteractive {
+				return &InputRequiredError{
+					Input: "setup answers",
+					Hint:  "use init and register instead",
+				}
+			}
+			ctx := cmd.Context()
+			return runSetup(ctx, newPrompter(ctx, os.Stdin, os.Stdout))
+		},
+	}
+}
+
+// runSetup performs the wizard steps in order: passphrase and identity, relay
+// URL, username, then registration (which generates and publishes prekeys).
+func runSetup(ctx context.Context, p *prompter) error {
+	fmt.Fprintf(p.out, "Welcome to Ciphera. Your keys will be stored in %s\n\n", homeDir)
+
+	pass, err := setupIdentity(p)
+	if err != nil {
+		return err
+	}
+
+	for {
+		relay, err := askRelayURL(p)
+		if err != nil {
+			return err
+		}
+		user, err := askNonEmpty(p, "Username")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(p.out, "Registering %q with %s...\n", user, relay)
+		_, err = appCtx.AccountService.Register(ctx, pass, user, []string{relay}, challengeSolver(p, ""))
+		if err == nil {
+			fmt.Fprintln(p.out)
+			fmt.Fprintln(p.out, "Setup complete. Your prekeys are published.")
+			fmt.Fprintln(p.out, "Next, start a session with a contact:")
+			fmt.Fprintf(p.out, "  ciphera start-session --relay %s <peer> --passphrase <pass>\n", relay)
+			fmt.Fprintln(p.out, "--relay may be omitted while this is your only relay.")
+			return nil
+		}
+		fmt.Fprintf(p.out, "Registration failed: %v\n", err)
+		retry, cerr := p.confirm("Try again with a different relay or username?", true)
+		if cerr != nil {
+			return cerr
+		}
+		if !retry {
+			return fmt.Errorf("registering: %w", err)
+		}
+	}
+}
+
+// setupIdentity returns the passphrase for a new or existing identity.
+//
+// An existing identity is kept unless the user explicitly asks to replace it.
+func setupIdentity(p *prompter) (string, error) {
+	_, err := appCtx.IdentityService.LoadIdentity("")
+	exists := !errors.Is(err, fs.ErrNotExist)
+
+	if exists {
+		replace, err := p.confirm("An identity already exists here. Replace it with a new one?", false)
+		if err != nil {
+			return "", err
+		}
+		if !replace {
+			return unlockIdentity(p)
+		}
+	}
+
+	pass, err := choosePassphrase(p)
+	if err != nil {
+		return "", err
+	}
+	_, fp, err := appCtx.IdentityService.GenerateIdentity(pass)
+	if err != nil {
+		return "", fmt.Errorf("creating identity: %w", err)
+	}
+	fmt.Fprintf(p.out, "Identity created. Fingerprint: %s\n\n", fp)
+	return pass, nil
+}
+
+// unlockIdentity asks for the existing identity's passphrase until it decrypts.
+func unlockIdentity(p *prompter) (string, error) {
+	for range maxUnlockAttempts {
+		pass, err := p.secret("Passphrase for existing identity")
+		if err != nil {
+			return "", err
+		}
+		fp, err := appCtx.IdentityService.FingerprintIdentity(pass)
+		if err == nil {
+			fmt.Fprintf(p.out, "Using existing identity. Fingerprint: %s\n\n", fp)
+			return pass, nil
+		}
+		fmt.Fprintln(p.out, "Could not unlock the identity with that passphrase.")
+	}
+	return "", errors.New("too many failed passphrase attempts")
+}
+
+// choosePassphrase asks for a new passphrase, shows its strength, and requires
+// it to meet the identity policy and be typed twice.
+func choosePassphrase(p *prompter) (string, error) {
+	fmt.Fprintln(p.out, "Choose a passphrase. It encrypts your keys on disk and cannot be recovered.")
+	fmt.Fprintln(p.out, "Use at least 12 characters with upper and lower case, a number and a symbol.")
+	for {
+		pass, err := p.secret("Passphrase")
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(p.out, "Strength: %s\n", strengthMeter(identitysvc.PassphraseStrength(pass)))
+		if err := identitysvc.ValidatePassphrase(pass); err != nil {
+			fmt.Fprintf(p.out, "%v. Please try again.\n", err)
+			continue
+		}
+		again, err := p.secret("Repeat passphrase")
+		if err != nil {
+			return "", err
+		}
+		if again != pass {
+			fmt.Fprintln(p.out, "Passphrases do not match. Please try again.")
+			continue
+		}
+		return pass, nil
+	}
+}
+
+// strengthMeter renders s as a five-cell bar followed by its label.
+func strengthMeter(s identitysvc.Strength) string {
+	filled := int(s) + 1
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", 5-filled) + "] " + s.String()
+}
+
+// askRelayURL asks for an http(s) relay base URL.
+func askRelayURL(p *prompter) (string, error) {
+	def := relayURL
+	if def == "" {
+		def = defaultSetupRelay
+	}
+	for {
+		s, err := p.line("Relay URL", def)
+		if err != nil {
+			return "", err
+		}
+		u, err := url.Parse(s)
+		if err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			return strings.TrimRight(s, "/"), nil
+		}
+		fmt.Fprintln(p.out, "Enter a URL such as http://relay.example:8080")
+	}
+}
+
+// askNonEmpty repeats a question until the answer is not empty.
+func askNonEmpty(p *prompter, label string) (string, error) {
+	for {
+		s, err := p.line(label, "")
+		if err != nil {
+			return "", err
+		}
+		if s != "" {
+			return s, nil
+		}
+	}
+}
@@ -1,31 +1,133 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/address"
 )
 
 // startSessionCmd performs the X3DH handshake against a peer's prekey bundle and persists a new
-// session for future messaging.
+// session for future messaging. With --reset it first drops the conversation's ratchet state, for
+// peers who restored their account from a backup. The peer may be a user@host address, whose relay is
+// discovered from host; the session is then kept under the bare username. With --bundle-file the
+// bundle is read from a file the peer exported with register --export-bundle instead of fetched from
+// a relay, and the peer defaults to the bundle's username.
 func startSessionCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "start-session <peer>",
+	var (
+		reset      bool
+		bundleFile string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "start-session <peer|user@host>",
 		Short: "Establish a secure session with a peer",
-		Args:  cobra.ExactArgs(1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if bundleFile != "" {
+				return cobra.MaximumNArgs(1)(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			peer := args[0]
+			var (
+				bundle domain.PrekeyBundle
+				peer   string
+			)
+			if len(args) > 0 {
+				peer = args[0]
+			}
+			if bundleFile != "" {
+				var err error
+				if bundle, err = readBundle(bundleFile); err != nil {
+					return err
+				}
+				if peer == "" {
+					peer = bundle.Username
+				}
+			}
+			if reset {
+				ok, err := appCtx.MessageService.ResetConversation(peer)
+				if err != nil {
+					return fmt.Errorf("resetting conversation with %q: %w", peer, err)
+				}
+				if ok {
+					fmt.Printf("Dropped the conversation state with %s; your next message starts a new handshake\n", peer)
+				}
+			}
 
 			// Initiate handshake and store session state.
-			_, err := appCtx.SessionService.InitiateSession(cmd.Context(), passphrase, peer)
+			var (
+				sess domain.Session
+				err  error
+			)
+			if bundleFile != "" {
+				sess, err = appCtx.SessionService.InitiateSessionWithBundle(passphrase, peer, bundle)
+			} else {
+				sess, err = appCtx.SessionService.InitiateSession(cmd.Context(), passphrase, peer)
+			}
 			if err != nil {
 				return fmt.Errorf("starting session with %q: %w", peer, err)
 			}
 
 			// Print confirmation only (do not leak secret material).
-			fmt.Printf("Session created with %s\n", peer)
+			if bundleFile != "" {
+				fmt.Printf("Session created with %s from their bundle; check their fingerprint is %s\n",
+					sess.Peer, crypto.Fingerprint(sess.PeerIK.Slice()))
+			} else if address.Is(peer) {
+				fmt.Printf("Session created with %s on %s\n", sess.Peer, sess.Relay)
+			} else {
+				fmt.Printf("Session created with %s\n", peer)
+			}
+			if len(sess.PeerCaps) > 0 {
+				fmt.Printf("Peer capabilities: %s\n", strings.Join(sess.PeerCaps, ", "))
+			}
+			if v := vouchers(sess.VouchedBy); v != "" {
+				fmt.Printf("Identity %s\n", v)
+			}
+			if len(sess.SharesIdentity) > 0 {
+				fmt.Fprintf(os.Stderr,
+					"Warning: %s presents the same identity key as %s. One person may hold several accounts, "+
+						"or one of them is impersonating the other; compare fingerprints out of band.\n",
+					sess.Peer, strings.Join(sess.SharesIdentity, ", "))
+			}
 
 			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&reset, "reset", false, "drop the conversation state first (for a peer who restored a backup)")
+	cmd.Flags().StringVar(
+		&bundleFile,
+		"bundle-file",
+		"",
+		"read the peer's bundle from a file written by register --export-bundle (- reads stdin) instead of a relay",
+	)
+	return cmd
+}
+
+// readBundle reads a prekey bundle written by register --export-bundle from
+// path, or from stdin if path is "-".
+func readBundle(path string) (domain.PrekeyBundle, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if path == "-" {
+		data, err = readStdin("bundle", "pipe it in or pass a file")
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return domain.PrekeyBundle{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+	var bundle domain.PrekeyBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return domain.PrekeyBundle{}, fmt.Errorf("reading %s: not a prekey bundle: %w", path, err)
+	}
+	return bundle, nil
 }
@@ -0,0 +1,139 @@
+package commands
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+)
+
+// statsCmd groups the commands that control opt-in ratchet statistics.
+func statsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Collect and export anonymised ratchet statistics (opt-in)",
+	}
+	cmd.AddCommand(
+		statsSwitchCmd("on", true),
+		statsSwitchCmd("off", false),
+		statsStatusCmd(),
+		statsExportCmd(),
+	)
+	return cmd
+}
+
+// statsSwitchCmd turns collection on or off.
+func statsSwitchCmd(use string, on bool) *cobra.Command {
+	short := "Start collecting ratchet statistics"
+	if !on {
+		short = "Stop collecting ratchet statistics and discard them"
+	}
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := appCtx.StatsService.SetEnabled(on); err != nil {
+				return fmt.Errorf("updating statistics setting: %w", err)
+			}
+			if on {
+				fmt.Println("Statistics collection on")
+			} else {
+				fmt.Println("Statistics collection off; collected statistics discarded")
+			}
+			return nil
+		},
+	}
+}
+
+// statsStatusCmd reports whether collection is on.
+func statsStatusCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show whether ratchet statistics are being collected",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			on, err := appCtx.StatsService.Enabled()
+			if err != nil {
+				return fmt.Errorf("reading statistics setting: %w", err)
+			}
+			if on {
+				fmt.Println("Statistics collection on")
+			} else {
+				fmt.Println("Statistics collection off")
+			}
+			return nil
+		},
+	}
+}
+
+// statsExportCmd writes the anonymised statistics to stdout.
+func statsExportCmd() *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write collected statistics to stdout without peers or content",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "csv" && format != "json" {
+				return fmt.Errorf("--format must be csv or json")
+			}
+			exp, err := appCtx.StatsService.Export()
+			if err != nil {
+				return fmt.Errorf("exporting statistics: %w", err)
+			}
+			if format == "json" {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(exp)
+			}
+			return writeStatsCSV(exp)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "csv", "output format: csv or json")
+	return cmd
+}
+
+// writeStatsCSV writes one row per conversation. The size histogram becomes
+// one column per bucket, named after its upper bound.
+func writeStatsCSV(exp domain.StatsExport) error {
+	w := csv.NewWriter(os.Stdout)
+	header := []string{
+		"conversation", "sent", "received", "dh_steps",
+		"out_of_order", "skipped_now", "skipped_max",
+	}
+	for _, b := range exp.SizeBounds {
+		header = append(header, fmt.Sprintf("size_le_%d", b))
+	}
+	if n := len(exp.SizeBounds); n > 0 {
+		header = append(header, fmt.Sprintf("size_gt_%d", exp.SizeBounds[n-1]))
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, c := range exp.Conversations {
+		row := []string{
+			c.Label,
+			strconv.Itoa(c.Sent),
+			strconv.Itoa(c.Received),
+			strconv.Itoa(c.DHSteps),
+			strconv.Itoa(c.OutOfOrder),
+			strconv.Itoa(c.SkippedNow),
+			strconv.Itoa(c.SkippedMax),
+		}
+		for _, n := range c.CipherSizes {
+			row = append(row, strconv.Itoa(n))
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/store/faulty"
+)
+
+// storeFaults programs the store fault injection of package faulty, for
+// resilience testing.
+var storeFaults faulty.Options
+
+// addStoreFaultFlags registers the hidden --store-fail-every,
+// --store-corrupt-every and --store-read-delay flags.
+func addStoreFaultFlags(root *cobra.Command) {
+	root.PersistentFlags().IntVar(
+		&storeFaults.FailEvery,
+		"store-fail-every",
+		0,
+		"fail every Nth store write without writing anything (testing)",
+	)
+	root.PersistentFlags().IntVar(
+		&storeFaults.CorruptEvery,
+		"store-corrupt-every",
+		0,
+		"flip a byte in the files every Nth store write changed (testing; destroys data)",
+	)
+	root.PersistentFlags().DurationVar(
+		&storeFaults.ReadDelay,
+		"store-read-delay",
+		time.Duration(0),
+		"delay every store read by this long (testing)",
+	)
+	for _, name := range []string{"store-fail-every", "store-corrupt-every", "store-read-delay"} {
+		_ = root.PersistentFlags().MarkHidden(name)
+	}
+}
@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/store"
+)
+
+// storeTelemetry turns on measuring the stores (see store.Telemetry).
+var storeTelemetry bool
+
+// addStoreTelemetryFlag registers the --store-telemetry flag.
+func addStoreTelemetryFlag(root *cobra.Command) {
+	root.PersistentFlags().BoolVar(
+		&storeTelemetry,
+		"store-telemetry",
+		false,
+		"print how long store reads, writes and lock waits took, per file, to stderr",
+	)
+}
+
+// printStoreTelemetry prints what the stores measured, per operation and
+// file, slowest first. It prints nothing unless --store-telemetry was given
+// and the command got as far as opening the stores.
+func printStoreTelemetry() {
+	if appCtx == nil || appCtx.StoreTelemetry == nil {
+		return
+	}
+	stats := appCtx.StoreTelemetry.Stats()
+	fmt.Fprintln(os.Stderr, "Store telemetry, slowest first:")
+	fmt.Fprintf(os.Stderr, "  %-5s %6s %10s %10s %10s  %s\n", "op", "count", "max bytes", "total", "max", "file")
+	for _, s := range stats {
+		size := "-"
+		if s.Op != store.OpLock {
+			size = strconv.FormatInt(s.MaxBytes, 10)
+		}
+		fmt.Fprintf(os.Stderr, "  %-5s %6d %10s %10s %10s  %s\n",
+			s.Op, s.Count, size, s.Time.Round(time.Microsecond), s.MaxTime.Round(time.Microsecond), s.File)
+	}
+}
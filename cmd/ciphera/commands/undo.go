@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/domain"
+)
+
+// undoCmd reverts the most recent state-changing command that can be
+// reverted, or with --list shows the action log.
+func undoCmd() *cobra.Command {
+	var list bool
+	cmd := &cobra.Command{
+		Use:   "undo",
+		Short: "Revert your most recent reversible command",
+		Long: `Revert your most recent reversible command: a register (within a week, by
+republishing the signed prekey it replaced), a conversations archive or a
+conversations filters change. Commands that cannot be reverted, such as
+rotate-signing-key, are passed over. --list shows the log of recorded
+commands and which of them can still be undone.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if list {
+				return listActions()
+			}
+			a, err := appCtx.UndoService.Undo(cmd.Context(), passphrase, historyPass())
+			if err != nil {
+				if a.ID != 0 {
+					return fmt.Errorf("undoing #%d (%s): %w", a.ID, describeAction(a), err)
+				}
+				return fmt.Errorf("undoing: %w", err)
+			}
+			fmt.Printf("Undid #%d: %s\n", a.ID, describeAction(a))
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&list, "list", false, "show recorded commands instead of undoing one")
+	addHistoryPassphraseFlag(cmd)
+	return cmd
+}
+
+// listActions prints the action log, newest first.
+func listActions() error {
+	log, err := appCtx.UndoService.Actions()
+	if err != nil {
+		return fmt.Errorf("reading action log: %w", err)
+	}
+	if len(log) == 0 {
+		fmt.Println("No commands recorded")
+		return nil
+	}
+	for _, a := range log {
+		why, err := appCtx.UndoService.Blocker(a)
+		if err != nil {
+			return fmt.Errorf("checking #%d: %w", a.ID, err)
+		}
+		state := "undoable"
+		if why != "" {
+			state = "not undoable: " + why
+		}
+		fmt.Printf("#%d\t%s\t%s\t%s\n",
+			a.ID, time.Unix(a.TimeUTC, 0).UTC().Format(time.RFC3339), describeAction(a), state)
+	}
+	return nil
+}
+
+// describeAction summarises a for the user.
+func describeAction(a domain.Action) string {
+	switch a.Kind {
+	case domain.ActionRegister:
+		return fmt.Sprintf("register %s on %s", a.Username, strings.Join(a.Servers, ", "))
+	case domain.ActionArchive:
+		return "archive conversation with " + a.Peer
+	case domain.ActionFilters:
+		return "change receive filters"
+	}
+	return string(a.Kind)
+}
+
+// recordAction adds a to the action log and, if it can be undone, says how.
+// A command that has already taken effect is not failed because it could
+// not be recorded; the user is warned instead.
+func recordAction(a domain.Action) {
+	a, err := appCtx.UndoService.Record(a)
+	if err == nil {
+		var why string
+		if why, err = appCtx.UndoService.Blocker(a); err == nil && why == "" {
+			fmt.Fprintln(os.Stderr, "Revert this with `ciphera undo`")
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: not recorded for undo: %v\n", err)
+	}
+}
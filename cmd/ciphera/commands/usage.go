@@ -0,0 +1,47 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// usageCmd shows the traffic a relay counted for the user, one line per
+// calendar month, newest first.
+func usageCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "usage [server]",
+		Short: "Show the bytes and envelopes a relay counted for you each month",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			server := ""
+			if len(args) == 1 {
+				server = args[0]
+			}
+			usage, err := appCtx.AccountService.Usage(cmd.Context(), passphrase, username, server)
+			if err != nil {
+				return fmt.Errorf("fetching usage: %w", err)
+			}
+			if len(usage) == 0 {
+				fmt.Println("No usage recorded")
+				return nil
+			}
+			for _, u := range usage {
+				fmt.Printf("%s\tin=%d bytes (%d envelopes)\tout=%d bytes (%d envelopes)\n",
+					u.Month, u.BytesIn, u.EnvelopesIn, u.BytesOut, u.EnvelopesOut)
+			}
+			return nil
+		},
+	}
+
+	// Username flag is local to this command.
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkFlagRequired("username")
+	return cmd
+}
@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"ciphera/internal/buildinfo"
+	"ciphera/internal/domain"
+)
+
+// versionCmd prints the client's build and protocol versions and, with
+// --server, the relay's and its notice, warning about protocols the two
+// disagree on.
+func versionCmd() *cobra.Command {
+	var (
+		server bool
+		asJSON bool
+	)
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Show version, commit, build date and protocol versions",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			info := buildinfo.Get()
+			var relayInfo *domain.BuildInfo
+			if server {
+				ri, err := appCtx.RelayClient.ServerInfo(cmd.Context())
+				if err != nil {
+					return fmt.Errorf("fetching relay server info: %w", err)
+				}
+				relayInfo = &ri
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(struct {
+					Client domain.BuildInfo  `json:"client"`
+					Relay  *domain.BuildInfo `json:"relay,omitempty"`
+				}{info, relayInfo})
+			}
+			buildinfo.Write(os.Stdout, "ciphera", info)
+			if relayInfo == nil {
+				return nil
+			}
+			buildinfo.Write(os.Stdout, "relay", *relayInfo)
+			if relayInfo.Notice != nil {
+				lines, blocked := noticeLines(*relayInfo.Notice, info.Version, time.Now())
+				for _, l := range lines {
+					fmt.Fprintf(os.Stdout, "  notice:       %s\n", l)
+				}
+				if n := relayInfo.Notice; n.MinClientVersion != "" {
+					fmt.Fprintf(os.Stdout, "  min client:   %s (%s)\n", n.MinClientVersion, cmp.Or(n.ClientGate, domain.GateWarn))
+				}
+				if blocked {
+					fmt.Fprintf(os.Stderr, "warning: the relay refuses clients older than %s\n", relayInfo.Notice.MinClientVersion)
+				}
+			}
+			for _, p := range buildinfo.Mismatched(info, *relayInfo) {
+				fmt.Fprintf(os.Stderr, "warning: %s is v%d here but v%d on the relay\n",
+					p, info.Protocols[p], relayInfo.Protocols[p])
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&server, "server", false, "also show the relay's version (GET /server-info)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print as JSON")
+	return cmd
+}
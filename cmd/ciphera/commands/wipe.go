@@ -0,0 +1,37 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// wipeCmd asks a peer to delete the conversation on both sides. The peer's
+// client must have opted in with `conversations remote-wipe <me> accept`;
+// the outcome arrives as a receipt on a later recv.
+func wipeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "wipe <peer>",
+		Short: "Ask a peer to delete your conversation on both sides",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := appCtx.MessageService.RequestWipe(cmd.Context(), passphrase, username, args[0])
+			if err != nil {
+				return fmt.Errorf("requesting wipe from %q: %w", args[0], err)
+			}
+			fmt.Printf("Wipe requested from %s; your copy is deleted when their receipt arrives (recv)\n", args[0])
+			return nil
+		},
+	}
+
+	// Username flag is local to this command.
+	cmd.Flags().StringVarP(
+		&username,
+		"username",
+		"u",
+		"",
+		"your registered username",
+	)
+	_ = cmd.MarkFlagRequired("username")
+	return cmd
+}
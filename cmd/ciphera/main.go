@@ -2,14 +2,26 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"os"
 
 	"ciphera/cmd/ciphera/commands"
 )
 
+// exitInputRequired is the exit status when --non-interactive stopped a
+// command that needed terminal input, so scripts can tell it apart from
+// other failures.
+const exitInputRequired = 3
+
 // Initialises and executes the command hierarchy.
 func main() {
 	if err := commands.Execute(); err != nil {
+		var need *commands.InputRequiredError
+		if errors.As(err, &need) {
+			log.Printf("Error: %v", err)
+			os.Exit(exitInputRequired)
+		}
 		log.Fatalf("Error: %v", err)
 	}
 }
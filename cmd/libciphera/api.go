@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"ciphera/internal/app"
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+)
+
+// Limits of a single call.
+const (
+	httpTimeout = 15 * time.Second // per relay request, as in the CLI
+	callTimeout = time.Minute      // per ABI call
+)
+
+var (
+	errNoHandle   = errors.New("unknown handle")
+	errNoBody     = errors.New("text or data required")
+	errBothBodies = errors.New("text and data are exclusive")
+)
+
+// client is an open home directory, the state behind one handle.
+type client struct {
+	mu         sync.Mutex // serialises calls on the handle
+	wire       *app.Wire
+	relay      string // the relay given to ciphera_init, if any
+	passphrase string
+}
+
+// Open handles, numbered from 1.
+var (
+	clientsMu  sync.Mutex
+	clients    = make(map[int]*client)
+	lastHandle int
+)
+
+// reply is the JSON returned by every ABI function.
+type reply struct {
+	Result any    `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handle decodes req for fn and encodes its result as a reply. A panic is
+// reported as an error, since it must not unwind into the caller's runtime.
+func handle(fn func([]byte) (any, error), req []byte) (out []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			out, _ = json.Marshal(reply{Error: fmt.Sprintf("internal error: %v", r)})
+		}
+	}()
+	res, err := fn(req)
+	rep := reply{Result: res}
+	if err != nil {
+		rep.Error = err.Error()
+	}
+	out, err = json.Marshal(rep)
+	if err != nil {
+		out, _ = json.Marshal(reply{Error: err.Error()})
+	}
+	return out
+}
+
+// decode parses req into v, refusing fields v does not have so a misspelt
+// option is not silently ignored.
+func decode(req []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(req))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("bad request: %w", err)
+	}
+	return nil
+}
+
+// withClient runs fn on the client behind h, holding its lock.
+func withClient(h int, fn func(ctx context.Context, c *client) (any, error)) (any, error) {
+	clientsMu.Lock()
+	c, ok := clients[h]
+	clientsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %d", errNoHandle, h)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+	return fn(ctx, c)
+}
+
+type initRequest struct {
+	Home       string `json:"home"`
+	Relay      string `json:"relay"`
+	Passphrase string `json:"passphrase"`
+}
+
+type initResult struct {
+	Handle      int    `json:"handle"`
+	Fingerprint string `json:"fingerprint"`
+	Created     bool   `json:"created"` // a new identity was generated
+}
+
+// initClient opens a home directory and returns a handle to it, generating
+// an identity if the directory has none.
+func initClient(req []byte) (any, error) {
+	var in initRequest
+	if err := decode(req, &in); err != nil {
+		return nil, err
+	}
+	if in.Home == "" {
+		h, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("home directory: %w", err)
+		}
+		in.Home = filepath.Join(h, ".ciphera")
+	}
+	if err := os.MkdirAll(in.Home, 0o700); err != nil {
+		return nil, fmt.Errorf("creating config dir: %w", err)
+	}
+	wire, err := app.NewWire(app.Config{
+		HomeDir:    in.Home,
+		RelayURL:   in.Relay,
+		HTTPClient: &http.Client{Timeout: httpTimeout},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("initialising application: %w", err)
+	}
+
+	out := initResult{}
+	out.Fingerprint, err = wire.IdentityService.FingerprintIdentity(in.Passphrase)
+	if errors.Is(err, fs.ErrNotExist) {
+		_, out.Fingerprint, err = wire.IdentityService.GenerateIdentity(in.Passphrase)
+		out.Created = true
+	}
+	if err != nil {
+		return nil, fmt.Errorf("identity: %w", err)
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	lastHandle++
+	out.Handle = lastHandle
+	clients[out.Handle] = &client{wire: wire, relay: in.Relay, passphrase: in.Passphrase}
+	return out, nil
+}
+
+type registerRequest struct {
+	Handle          int    `json:"handle"`
+	Username        string `json:"username"`
+	ChallengeAnswer string `json:"challenge_answer,omitempty"`
+}
+
+type registerResult struct {
+	Relays []string `json:"relays"`
+}
+
+// register publishes the identity's prekey bundle under username on the
+// handle's relay.
+func register(req []byte) (any, error) {
+	var in registerRequest
+	if err := decode(req, &in); err != nil {
+		return nil, err
+	}
+	return withClient(in.Handle, func(ctx context.Context, c *client) (any, error) {
+		var solve domain.ChallengeSolver
+		if in.ChallengeAnswer != "" {
+			solve = func(context.Context, string, domain.RegistrationChallenge) (string, error) {
+				return in.ChallengeAnswer, nil
+			}
+		}
+		var servers []string
+		if c.relay != "" {
+			servers = []string{c.relay}
+		}
+		accounts, err := c.wire.AccountService.Register(ctx, c.passphrase, in.Username, servers, solve)
+		out := registerResult{Relays: []string{}}
+		for _, a := range accounts {
+			out.Relays = append(out.Relays, a.Server)
+		}
+		return out, err
+	})
+}
+
+type startSessionRequest struct {
+	Handle int    `json:"handle"`
+	Peer   string `json:"peer"`
+}
+
+type startSessionResult struct {
+	Peer        string `json:"peer"`
+	Relay       string `json:"relay,omitempty"`
+	Fingerprint string `json:"fingerprint"` // of the peer's identity key
+	// Other peers known under the same identity key, to warn the user about.
+	SharesIdentity []string `json:"shares_identity,omitempty"`
+}
+
+// startSession runs X3DH against peer's published bundle.
+func startSession(req []byte) (any, error) {
+	var in startSessionRequest
+	if err := decode(req, &in); err != nil {
+		return nil, err
+	}
+	return withClient(in.Handle, func(ctx context.Context, c *client) (any, error) {
+		sess, err := c.wire.SessionService.InitiateSession(ctx, c.passphrase, in.Peer)
+		if err != nil {
+			return nil, err
+		}
+		return startSessionResult{
+			Peer:        sess.Peer,
+			Relay:       sess.Relay,
+			Fingerprint: crypto.Fingerprint(sess.PeerIK.Slice()),
+
+			SharesIdentity: sess.SharesIdentity,
+		}, nil
+	})
+}
+
+type sendRequest struct {
+	Handle      int    `json:"handle"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Text        string `json:"text,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+	Force       bool   `json:"force,omitempty"`
+}
+
+// send encrypts a text or binary message and posts it to the peer.
+func send(req []byte) (any, error) {
+	var in sendRequest
+	if err := decode(req, &in); err != nil {
+		return nil, err
+	}
+	var msg domain.MessageBody
+	switch {
+	case in.Text != "" && in.Data != nil:
+		return nil, errBothBodies
+	case in.Text != "":
+		msg = body.Text(in.Text)
+	case in.Data != nil:
+		msg = domain.MessageBody{Version: body.Version, ContentType: in.ContentType, Body: in.Data}
+		if msg.ContentType == "" {
+			msg.ContentType = body.TypeBinary
+		}
+	default:
+		return nil, errNoBody
+	}
+	return withClient(in.Handle, func(ctx context.Context, c *client) (any, error) {
+		err := c.wire.MessageService.SendMessage(ctx, c.passphrase, in.From, in.To, msg, in.Force, 0)
+		return struct{}{}, err
+	})
+}
+
+type recvRequest struct {
+	Handle   int    `json:"handle"`
+	Username string `json:"username"`
+	Limit    int    `json:"limit,omitempty"` // 0 fetches everything queued
+}
+
+type recvResult struct {
+	Messages    []domain.DecryptedMessage `json:"messages"`
+	Expired     int                       `json:"expired"` // dropped at the relay before they were fetched
+	Undelivered []undelivered             `json:"undelivered,omitempty"`
+}
+
+// undelivered is a message we sent that expired at the relay before the
+// peer fetched it.
+type undelivered struct {
+	Peer       string `json:"peer"`
+	Seq        uint64 `json:"seq"`
+	SentUTC    int64  `json:"sent_utc"`
+	ExpiresUTC int64  `json:"expires_utc"`
+}
+
+// recv fetches and decrypts queued messages for username.
+func recv(req []byte) (any, error) {
+	var in recvRequest
+	if err := decode(req, &in); err != nil {
+		return nil, err
+	}
+	return withClient(in.Handle, func(ctx context.Context, c *client) (any, error) {
+		msgs, rep, err := c.wire.MessageService.ReceiveMessage(ctx, c.passphrase, in.Username, in.Limit)
+		if msgs == nil {
+			msgs = []domain.DecryptedMessage{}
+		}
+		res := recvResult{Messages: msgs, Expired: rep.Expired}
+		for _, m := range rep.Undelivered {
+			res.Undelivered = append(res.Undelivered, undelivered{
+				Peer:       m.Peer,
+				Seq:        m.Seq,
+				SentUTC:    m.SentUTC,
+				ExpiresUTC: m.ExpiresUTC,
+			})
+		}
+		return res, err
+	})
+}
+
+type closeRequest struct {
+	Handle int `json:"handle"`
+}
+
+// closeClient forgets a handle and its passphrase.
+func closeClient(req []byte) (any, error) {
+	var in closeRequest
+	if err := decode(req, &in); err != nil {
+		return nil, err
+	}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if _, ok := clients[in.Handle]; !ok {
+		return nil, fmt.Errorf("%w %d", errNoHandle, in.Handle)
+	}
+	delete(clients, in.Handle)
+	return struct{}{}, nil
+}
@@ -0,0 +1,68 @@
+// Command libciphera builds Ciphera's client as a C shared library, so
+// bindings in other languages (Python's ctypes, Node's ffi-napi and the like)
+// can embed the protocol stack instead of running the ciphera binary:
+//
+//	go build -buildmode=c-shared -o bin/libciphera.so ./cmd/libciphera
+//
+// The build also writes libciphera.h with the declarations below.
+//
+// # ABI
+//
+// Every function takes a NUL-terminated UTF-8 JSON request and returns a
+// newly allocated JSON reply, which the caller must release with
+// ciphera_free:
+//
+//	char *ciphera_init(const char *req);          // {"home", "relay", "passphrase"} -> {"handle", "fingerprint", "created"}
+//	char *ciphera_register(const char *req);      // {"handle", "username", "challenge_answer"} -> {"relays"}
+//	char *ciphera_start_session(const char *req); // {"handle", "peer"} -> {"peer", "relay", "fingerprint", "shares_identity"}
+//	char *ciphera_send(const char *req);          // {"handle", "from", "to", "text" | "content_type" + "data", "force"} -> {}
+//	char *ciphera_recv(const char *req);          // {"handle", "username", "limit"} -> {"messages", "expired", "undelivered"}
+//	char *ciphera_close(const char *req);         // {"handle"} -> {}
+//	void  ciphera_free(char *reply);
+//
+// A reply is {"result": {...}} on success and carries "error" on failure.
+// ciphera_recv may return both: the messages it decrypted and, for example,
+// a note that some envelopes were quarantined. Binary fields ("data", and
+// message bodies) are base64, as in Go's encoding/json.
+//
+// ciphera_init opens a home directory like the CLI's --home, creating an
+// identity on first use, and returns a handle for the other calls. The
+// passphrase is held in memory until ciphera_close. Calls are safe from any
+// thread; calls on one handle are serialised.
+package main
+
+/*
+#include <stdlib.h>
+*/
+import "C"
+
+import "unsafe"
+
+//export ciphera_init
+func ciphera_init(req *C.char) *C.char { return call(initClient, req) }
+
+//export ciphera_register
+func ciphera_register(req *C.char) *C.char { return call(register, req) }
+
+//export ciphera_start_session
+func ciphera_start_session(req *C.char) *C.char { return call(startSession, req) }
+
+//export ciphera_send
+func ciphera_send(req *C.char) *C.char { return call(send, req) }
+
+//export ciphera_recv
+func ciphera_recv(req *C.char) *C.char { return call(recv, req) }
+
+//export ciphera_close
+func ciphera_close(req *C.char) *C.char { return call(closeClient, req) }
+
+//export ciphera_free
+func ciphera_free(reply *C.char) { C.free(unsafe.Pointer(reply)) }
+
+// call runs fn on req and returns its reply in C memory.
+func call(fn func([]byte) (any, error), req *C.char) *C.char {
+	return C.CString(string(handle(fn, []byte(C.GoString(req)))))
+}
+
+// main is required by -buildmode=c-shared and never runs.
+func main() {}
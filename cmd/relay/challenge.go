@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"ciphera/internal/relayserver"
+)
+
+// Registration challenge kinds accepted by --register-challenge.
+const (
+	challengeNone    = "none"
+	challengeToken   = "token"
+	challengePoW     = "pow"
+	challengeCAPTCHA = "captcha"
+)
+
+// registrationChallenge builds the challenge selected by --register-challenge,
+// or nil for none. Token lists and the CAPTCHA secret come from the
+// environment, like the relay's other secrets.
+func registrationChallenge() (relayserver.Challenge, error) {
+	switch challengeKind {
+	case "", challengeNone:
+		return nil, nil
+	case challengeToken:
+		tokens := os.Getenv(registerTokensEnv)
+		if tokens == "" {
+			return nil, fmt.Errorf("%s must be set for --register-challenge token", registerTokensEnv)
+		}
+		return relayserver.NewTokenChallenge(strings.Split(tokens, ","))
+	case challengePoW:
+		return relayserver.NewPoWChallenge(powBits)
+	case challengeCAPTCHA:
+		secret := os.Getenv(captchaSecretEnv)
+		if captchaVerifyURL == "" || secret == "" {
+			return nil, fmt.Errorf("--register-challenge captcha needs --captcha-verify-url and %s", captchaSecretEnv)
+		}
+		return relayserver.NewCAPTCHAChallenge(relayserver.CAPTCHAOptions{
+			VerifyURL: captchaVerifyURL,
+			Secret:    secret,
+			SiteKey:   captchaSiteKey,
+			PageURL:   captchaPageURL,
+		})
+	default:
+		return nil, fmt.Errorf("unknown --register-challenge %q: want none, token, pow or captcha", challengeKind)
+	}
+}
@@ -1,34 +1,64 @@
-// Package main runs the in-memory HTTP relay used by Ciphera during development
-// and tests. It stores published prekey bundles and queues encrypted envelopes
-// for recipients until they fetch them.
-//
-// HTTP API
-//
-//	POST /register
-//	    Store a user's PrekeyBundle (identity key, signed prekey + sig, OPKs).
-//
-//	GET /prekey/{username}
-//	    Return the latest published PrekeyBundle for {username}.
-//
-//	POST /msg/{user}
-//	    Enqueue an Envelope destined to {user}. If Timestamp is zero, the
-//	    server fills it with the current Unix time.
-//
-//	GET /msg/{user}?limit=N
-//	    Return up to N queued Envelopes for {user}. If limit is absent or
-//	    greater than the queue length, all queued envelopes are returned.
-//
-//	POST /msg/{user}/ack { "count": N }
-//	    Drop the first N queued envelopes for {user}. If N exceeds the queue
-//	    length, the queue is cleared.
+// Package main runs the HTTP relay used by Ciphera during development and
+// tests. The API, storage, webhooks and tracing are documented in package
+// relayserver; this command maps flags and environment variables onto
+// relayserver.Options and serves the result.
 //
 // Behaviour
 //
-//   - All state is held in memory and lost on process exit.
-//   - Responses are JSON. Non-2xx statuses carry a short error message.
-//   - A lightweight access log records method, path, remote, status, bytes and
-//     duration for each request.
-//   - The default listen address is :8080.
+//   - State is held in memory and lost on process exit, unless --data-dir is
+//     set. --repair drops bad stored records instead of refusing to start.
+//     RELAY_STORAGE_KEY seals queued envelopes on disk so that only their
+//     recipients show; set it on an existing --data-dir to seal its queues.
+//     RELAY_STORAGE_KEY_PREVIOUS, set to the key being replaced, reseals
+//     them under the new one in the background.
+//   - relay migrate --from <URL|file> --to <URL|file> copies every bundle,
+//     queued envelope, restriction, backup and presence policy to a relay
+//     holding nothing yet, or to or from a snapshot file, through the admin
+//     API (RELAY_ADMIN_TOKEN, or RELAY_TARGET_ADMIN_TOKEN for the target).
+//     It checks the snapshot, that the target's state matches it, and that
+//     a source relay did not change meanwhile.
+//   - --log turns on the access log. Admin audit lines, warnings and errors
+//     are logged either way.
+//   - --blob-backend fs or s3 enables attachments; the s3 backend signs with
+//     AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+//   - RELAY_ADMIN_TOKEN enables the admin API. --webhook-url posts events
+//     signed with RELAY_WEBHOOK_SECRET, which must be set. --otlp-endpoint
+//     (or OTEL_EXPORTER_OTLP_ENDPOINT) exports request traces, named after
+//     OTEL_SERVICE_NAME when it is set.
+//   - --register-challenge makes new usernames answer a challenge before they
+//     register: token (invitations listed, comma-separated, in
+//     RELAY_REGISTER_TOKENS), pow (difficulty --pow-bits) or captcha
+//     (--captcha-verify-url, --captcha-site-key and --captcha-page-url, with
+//     RELAY_CAPTCHA_SECRET). The default, none, leaves registration open.
+//   - GET /.well-known/ciphera-relay answers with --public-url (by default
+//     derived from the listen addresses), so clients resolve user@host
+//     addresses whose host is this relay's.
+//   - --maintenance-start and --maintenance-end (RFC 3339), --motd and
+//     --min-client-version are announced to clients in GET /server-info.
+//     Clients older than --min-client-version warn, or refuse to go on with
+//     --client-gate block. PUT /admin/notice replaces the notice until the
+//     relay restarts.
+//   - POST /register and PUT /backup/{user} handle at most 8 requests each at
+//     once, queueing up to 32 more for --route-limit-wait before answering
+//     503 busy. --route-limit "METHOD /path=MAX[:QUEUE]" changes a route's
+//     limit or limits another route; MAX 0 lifts it. GET /healthz is never
+//     limited.
+//   - POST /prekey/{user}/checkout hands out at most --checkout-limit (4) of
+//     a user's one-time prekeys per --checkout-window (1h), so no one can
+//     drain them; later checkouts get the bundle without one. 0 lifts the
+//     limit.
+//   - The default listen address is :8080. Repeated --listen flags replace it
+//     with explicit addresses: host:port, [::]:port for IPv6, or unix:/path for
+//     a Unix domain socket (mode 0660, for a reverse proxy on the same host).
+//     Each may add ",cert=FILE,key=FILE" for its own certificate, or ",tls=off"
+//     to stay plain HTTP when --tls-cert is set.
+//   - HTTP/1.1 is always served. With --tls-cert and --tls-key the relay serves
+//     HTTPS and negotiates HTTP/2 via ALPN; --h2c also accepts HTTP/2 over plain
+//     TCP. HTTP/2 connections multiplex streams and are kept alive with pings.
+//   - --http3 (experimental) also serves HTTP/3 over QUIC on the UDP port of
+//     each listener, which must then all have TLS. Push connections
+//     (GET /ws/{user}) stay on TCP, and 0-RTT is refused so requests cannot
+//     be replayed.
 //
 // AS of now, this relay is intended for local use or as an untrusted middleman
 // on a private network. It never sees plaintext or private keys; it only stores
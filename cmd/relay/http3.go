@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3Listener is an HTTP/3 server on the UDP side of a TLS listener.
+type http3Listener struct {
+	spec listenSpec
+	srv  *http3.Server
+	conn net.PacketConn
+}
+
+// listenHTTP3 opens the UDP port of l, a TCP listener with TLS, for an
+// HTTP/3 server handing requests to h. 0-RTT stays off: a request sent in
+// early data can be replayed by anyone who saw it, and sends and acks must
+// not be.
+func listenHTTP3(l listenSpec, h http.Handler) (*http3Listener, error) {
+	cert, err := tls.LoadX509KeyPair(l.cert, l.key)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp", l.addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http3.Server{
+		Handler:     h,
+		TLSConfig:   &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS13},
+		IdleTimeout: idleTO,
+		QUICConfig: &quic.Config{
+			MaxIdleTimeout:  idleTO,
+			KeepAlivePeriod: h2PingInterval,
+		},
+	}
+	return &http3Listener{spec: l, srv: srv, conn: conn}, nil
+}
+
+// serve serves HTTP/3 until Shutdown or Close.
+func (l *http3Listener) serve() error {
+	return l.srv.Serve(l.conn)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"ciphera/internal/relayserver"
+)
+
+// routeLimits builds the per-route concurrency limits from the defaults and
+// each --route-limit "METHOD /path=MAX[:QUEUE]", which replaces the route's
+// default; a MAX of 0 lifts the route's limit. --route-limit-wait applies to
+// every route.
+func routeLimits() (map[string]relayserver.RouteLimit, error) {
+	limits := relayserver.DefaultRouteLimits()
+	for _, v := range routeLimitFlags {
+		route, spec, ok := strings.Cut(v, "=")
+		route = strings.TrimSpace(route)
+		if !ok || route == "" {
+			return nil, fmt.Errorf("--route-limit %q: want \"METHOD /path=MAX[:QUEUE]\"", v)
+		}
+		maxStr, queueStr, hasQueue := strings.Cut(spec, ":")
+		n, err := strconv.Atoi(maxStr)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("--route-limit %q: MAX must be a whole number", v)
+		}
+		if n == 0 {
+			delete(limits, route)
+			continue
+		}
+		lim := relayserver.RouteLimit{Max: n, Queue: 4 * n}
+		if hasQueue {
+			if lim.Queue, err = strconv.Atoi(queueStr); err != nil || lim.Queue < 0 {
+				return nil, fmt.Errorf("--route-limit %q: QUEUE must be a whole number", v)
+			}
+		}
+		limits[route] = lim
+	}
+	for route, lim := range limits {
+		lim.Wait = routeLimitWait
+		limits[route] = lim
+	}
+	return limits, nil
+}
+
+// checkoutLimit is --checkout-limit per --checkout-window; a limit of 0
+// lifts the cap.
+func checkoutLimit() relayserver.CheckoutLimit {
+	if checkoutMax == 0 {
+		return relayserver.CheckoutLimit{}
+	}
+	return relayserver.CheckoutLimit{Max: checkoutMax, Window: checkoutWindow}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// unixPrefix marks a --listen address as a Unix domain socket path.
+const unixPrefix = "unix:"
+
+// unixSocketMode lets a reverse proxy in the relay's group connect.
+const unixSocketMode = 0o660
+
+// listenSpec is one --listen address with its TLS settings.
+type listenSpec struct {
+	network string // "tcp" or "unix"
+	addr    string // host:port or socket path
+	cert    string // TLS certificate file; empty for plain HTTP
+	key     string // TLS private key file
+}
+
+// tls reports whether the listener serves HTTPS.
+func (l listenSpec) tls() bool { return l.cert != "" }
+
+// String renders the spec for logs, without TLS file paths.
+func (l listenSpec) String() string {
+	if l.network == "unix" {
+		return unixPrefix + l.addr
+	}
+	return l.addr
+}
+
+// parseListen parses a --listen value:
+//
+//	ADDR[,cert=FILE,key=FILE][,tls=off]
+//
+// ADDR is host:port (IPv6 hosts in brackets, e.g. [::]:8080) or unix:/path.
+// A listener without cert and key uses --tls-cert and --tls-key when those are
+// set, unless tls=off is given.
+func parseListen(v, defCert, defKey string) (listenSpec, error) {
+	parts := strings.Split(v, ",")
+	l := listenSpec{network: "tcp", addr: parts[0]}
+	if path, ok := strings.CutPrefix(l.addr, unixPrefix); ok {
+		l.network, l.addr = "unix", path
+		if path == "" {
+			return listenSpec{}, fmt.Errorf("--listen %q: socket path required", v)
+		}
+	} else if _, _, err := net.SplitHostPort(l.addr); err != nil {
+		return listenSpec{}, fmt.Errorf("--listen %q: %w", v, err)
+	}
+
+	plain := false
+	for _, opt := range parts[1:] {
+		k, val, _ := strings.Cut(opt, "=")
+		switch {
+		case k == "cert" && val != "":
+			l.cert = val
+		case k == "key" && val != "":
+			l.key = val
+		case k == "tls" && val == "off":
+			plain = true
+		default:
+			return listenSpec{}, fmt.Errorf("--listen %q: unknown option %q", v, opt)
+		}
+	}
+	if (l.cert == "") != (l.key == "") {
+		return listenSpec{}, fmt.Errorf("--listen %q: cert and key must be given together", v)
+	}
+	if plain && l.cert != "" {
+		return listenSpec{}, fmt.Errorf("--listen %q: tls=off conflicts with cert and key", v)
+	}
+	if !plain && l.cert == "" {
+		l.cert, l.key = defCert, defKey
+	}
+	return l, nil
+}
+
+// listen opens the listener for l. A stale Unix socket left by an earlier run
+// is removed first; any other file at that path is an error.
+func (l listenSpec) listen() (net.Listener, error) {
+	if l.network == "unix" {
+		if fi, err := os.Lstat(l.addr); err == nil {
+			if fi.Mode().Type() != fs.ModeSocket {
+				return nil, fmt.Errorf("%s exists and is not a socket", l.addr)
+			}
+			if err := os.Remove(l.addr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	ln, err := net.Listen(l.network, l.addr)
+	if err != nil {
+		return nil, err
+	}
+	if l.network == "unix" {
+		if err := os.Chmod(l.addr, unixSocketMode); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// serve serves srv on ln, with TLS if l has a certificate. ServeTLS loads the
+// certificate per call, so listeners can present different names.
+func (l listenSpec) serve(srv *http.Server, ln net.Listener) error {
+	if l.tls() {
+		return srv.ServeTLS(ln, l.cert, l.key)
+	}
+	return srv.Serve(ln)
+}
+
+// publicBase returns a URL that reaches l from this host, for the default
+// --public-url. Unix sockets have none.
+func (l listenSpec) publicBase() (string, bool) {
+	if l.network == "unix" {
+		return "", false
+	}
+	host, port, _ := net.SplitHostPort(l.addr)
+	switch host {
+	case "", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::":
+		host = "::1"
+	}
+	scheme := "http"
+	if l.tls() {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port), true
+}
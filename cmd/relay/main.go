@@ -2,10 +2,6 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/subtle"
-	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -14,14 +10,14 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
-	"sync"
 	"syscall"
 	"time"
 
 	"github.com/spf13/pflag"
 
+	"ciphera/internal/buildinfo"
 	"ciphera/internal/domain"
+	"ciphera/internal/relayserver"
 )
 
 // --- Flags ---
@@ -29,440 +25,184 @@ import (
 var (
 	port          int  // listen port
 	enableLogging bool // logging toggle
-)
 
-// --- Constants ---
+	blobBackendName string        // attachment backend: none, fs or s3
+	blobDir         string        // fs backend root directory
+	blobMax         int64         // maximum attachment size in bytes
+	blobTTL         time.Duration // attachment lifetime before garbage collection
+	publicURL       string        // externally reachable base URL for pre-signed links
+	s3Endpoint      string        // S3-compatible endpoint, e.g. https://s3.amazonaws.com
+	s3Bucket        string        // S3 bucket name
+	s3Region        string        // S3 signing region
 
-// Networking and server limits.
-const (
-	defaultPort    = 8080
-	minPort        = 0
-	maxPort        = 65535
-	readHeaderTO   = 5 * time.Second
-	readTO         = 10 * time.Second
-	writeTO        = 10 * time.Second
-	idleTO         = 60 * time.Second
-	maxRequestBody = 1 << 20 // 1 MiB cap for incoming JSON bodies
-)
+	tlsCert string   // TLS certificate file; enables HTTPS and HTTP/2 (h2)
+	tlsKey  string   // TLS private key file
+	h2c     bool     // accept HTTP/2 without TLS (prior knowledge), for local use
+	listen  []string // explicit listen addresses; replace --port when set
 
-// Relay policy limits.
-const (
-	maxPerUserQueue = 1000             // cap messages kept per user
-	maxCipherBytes  = 64 << 10         // 64 KiB max cipher payload
-	maxOneTimeKeys  = 500              // max one-time prekeys in a bundle
-	maxFutureSkew   = 10 * time.Minute // reject timestamps too far in the future
-)
+	serveHTTP3 bool // experimental: also serve HTTP/3 over QUIC on each TLS listener's UDP port
 
-// Context key for request ID.
-type ctxKey string
+	webhookURLs      []string // endpoints that receive relay events
+	webhookEvents    []string // event types to deliver
+	webhookHighWater int      // queue length that triggers a high-water event
 
-const ctxKeyReqID ctxKey = "reqid"
+	dataDir string // directory for persistent state; empty keeps state in memory
+	repair  bool   // fix storage inconsistencies at startup instead of refusing to start
 
-// --- Types & Constructors ---
+	ackRetention time.Duration // how long acked envelopes can be fetched again; zero discards them
 
-// state holds registered prekey bundles and per-user message queues.
-type state struct {
-	mu      sync.RWMutex
-	bundles map[string]domain.PrekeyBundle
-	queues  map[string][]domain.Envelope
-}
+	chaosDrop      float64       // probability an accepted envelope is lost
+	chaosDelay     time.Duration // longest random delay before an envelope can be fetched
+	chaosDuplicate float64       // probability a fetched envelope is returned twice
 
-// newState initialises an empty relay state.
-func newState() *state {
-	return &state{
-		bundles: make(map[string]domain.PrekeyBundle),
-		queues:  make(map[string][]domain.Envelope),
-	}
-}
+	otlpEndpoint string // OTLP/HTTP collector for request traces; empty disables tracing
 
-// loggingResponseWriter captures status code and byte count for access logs.
-type loggingResponseWriter struct {
-	http.ResponseWriter
-	status int
-	bytes  int
-}
+	challengeKind    string // registration challenge for new usernames: none, token, pow or captcha
+	powBits          int    // proof-of-work difficulty in bits
+	captchaVerifyURL string // CAPTCHA provider's verification endpoint
+	captchaSiteKey   string // CAPTCHA site key shown to clients
+	captchaPageURL   string // page where a person solves the CAPTCHA
 
-// --- Middleware ---
-
-// withRecover wraps a handler to convert panics into 500 responses.
-func withRecover(h http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if rec := recover(); rec != nil {
-				writeErr(w, http.StatusInternalServerError, "internal error")
-				if enableLogging {
-					slog.Error("panic", "err", rec)
-				}
-			}
-		}()
-		h(w, r)
-	}
-}
+	maintenanceStart string // start of the announced maintenance window, RFC 3339
+	maintenanceEnd   string // end of the announced maintenance window, RFC 3339
+	motd             string // message of the day shown to clients
+	minClientVersion string // oldest client version supported
+	clientGate       string // what clients older than minClientVersion do: warn or block
 
-// withReqID ensures each request has an ID for tracing.
-func withReqID(h http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		id := r.Header.Get("X-Request-Id")
-		if id == "" {
-			id = genReqID()
-		}
-		w.Header().Set("X-Request-Id", id)
-		ctx := context.WithValue(r.Context(), ctxKeyReqID, id)
-		h(w, r.WithContext(ctx))
-	}
-}
+	routeLimitFlags []string      // per-route concurrency limits, "METHOD /path=MAX[:QUEUE]"
+	routeLimitWait  time.Duration // longest wait for a slot on a limited route
 
-// withLogging logs method, path, remote, status, bytes, duration and request ID.
-func withLogging(h http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if !enableLogging {
-			h(w, r)
-			return
-		}
-		start := time.Now()
-		lrw := &loggingResponseWriter{ResponseWriter: w}
-		h(lrw, r)
-		reqID := requestIDFromCtx(r.Context())
-		slog.Info("access",
-			"method", r.Method,
-			"path", r.URL.Path,
-			"remote", clientIP(r),
-			"status", lrw.status,
-			"bytes", lrw.bytes,
-			"dur", time.Since(start),
-			"reqid", reqID,
-		)
-	}
-}
-
-// chain composes middlewares in order.
-func chain(h http.HandlerFunc, mws ...func(http.HandlerFunc) http.HandlerFunc) http.HandlerFunc {
-	for i := len(mws) - 1; i >= 0; i-- {
-		h = mws[i](h)
-	}
-	return h
-}
-
-// --- Utilities ---
-
-// WriteHeader records the status code then forwards to the underlying writer.
-func (lrw *loggingResponseWriter) WriteHeader(code int) {
-	lrw.status = code
-	lrw.ResponseWriter.WriteHeader(code)
-}
-
-// Write records the bytes written and defaults status to 200 if unset.
-func (lrw *loggingResponseWriter) Write(p []byte) (int, error) {
-	if lrw.status == 0 {
-		lrw.status = http.StatusOK
-	}
-	n, err := lrw.ResponseWriter.Write(p)
-	lrw.bytes += n
-	return n, err
-}
+	checkoutMax    int           // one-time prekeys of a user handed out per checkoutWindow; 0 lifts the cap
+	checkoutWindow time.Duration // window checkoutMax applies to
 
-// isZero32 checks whether a 32-byte slice is all zeros in constant time.
-func isZero32(b []byte) bool {
-	if len(b) != 32 {
-		return false
-	}
-	var zero [32]byte
-	return subtle.ConstantTimeCompare(b, zero[:]) == 1
-}
+	showVersion bool // print build information and exit
+)
 
-// writeJSON encodes v as JSON with no HTML escaping.
-func writeJSON(w http.ResponseWriter, v any) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "no-store")
-	enc := json.NewEncoder(w)
-	enc.SetEscapeHTML(false)
-	if err := enc.Encode(v); err != nil {
-		// Best effort error path.
-		http.Error(w, fmt.Sprintf("encode error: %v", err), http.StatusInternalServerError)
-	}
-}
+// --- Constants ---
 
-// writeErr writes a JSON error object with a given status code.
-func writeErr(w http.ResponseWriter, code int, msg string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "no-store")
-	w.WriteHeader(code)
-	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
-}
+// Networking and server limits.
+const (
+	defaultPort  = 8080
+	minPort      = 0
+	maxPort      = 65535
+	readHeaderTO = 5 * time.Second
+	readTO       = 10 * time.Second
+	writeTO      = 10 * time.Second
+	idleTO       = 60 * time.Second
+
+	h2MaxStreams   = 250              // concurrent HTTP/2 streams per connection
+	h2PingInterval = 30 * time.Second // ping idle HTTP/2 connections to detect dead peers
+	h2PingTimeout  = 15 * time.Second // close the connection if a ping goes unanswered
+)
 
-// parseLimit parses the optional "limit" query parameter.
-func parseLimit(v string) (int, error) {
-	if v == "" {
-		return 0, nil
-	}
-	n, err := strconv.Atoi(v)
-	if err != nil || n < 0 {
-		return 0, fmt.Errorf("invalid limit")
-	}
-	return n, nil
-}
+// Environment variables for secrets and collector settings.
+const (
+	adminTokenEnv    = "RELAY_ADMIN_TOKEN"
+	webhookSecretEnv = "RELAY_WEBHOOK_SECRET"
+	traceEndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	traceServiceEnv  = "OTEL_SERVICE_NAME"
 
-// clientIP extracts the client IP from headers or RemoteAddr.
-func clientIP(r *http.Request) string {
-	// Respect common proxy headers. This is best-effort.
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// First hop is the client.
-		if i := indexByte(xff, ','); i >= 0 {
-			return trimSpace(xff[:i])
-		}
-		return trimSpace(xff)
-	}
-	if xr := r.Header.Get("X-Real-IP"); xr != "" {
-		return xr
-	}
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-	return host
-}
+	registerTokensEnv = "RELAY_REGISTER_TOKENS"
+	captchaSecretEnv  = "RELAY_CAPTCHA_SECRET"
 
-// requestIDFromCtx returns the request ID if present.
-func requestIDFromCtx(ctx context.Context) string {
-	if v, ok := ctx.Value(ctxKeyReqID).(string); ok {
-		return v
-	}
-	return ""
-}
+	storageKeyEnv         = "RELAY_STORAGE_KEY"
+	previousStorageKeyEnv = "RELAY_STORAGE_KEY_PREVIOUS"
+)
 
-// genReqID creates a simple 128-bit random hex ID.
-func genReqID() string {
-	var b [16]byte
-	if _, err := rand.Read(b[:]); err != nil {
-		// Fallback to timestamp based if rand fails.
-		return fmt.Sprintf("req-%d", time.Now().UnixNano())
-	}
-	return hex.EncodeToString(b[:])
-}
+// --- Main ---
 
-// Small helpers without extra imports.
-func indexByte(s string, c byte) int {
-	for i := 0; i < len(s); i++ {
-		if s[i] == c {
-			return i
-		}
-	}
-	return -1
-}
-func trimSpace(s string) string {
-	// Minimal trim to avoid extra import.
-	for len(s) > 0 && (s[0] == ' ' || s[0] == '\t') {
-		s = s[1:]
-	}
-	for len(s) > 0 && (s[len(s)-1] == ' ' || s[len(s)-1] == '\t') {
-		s = s[:len(s)-1]
+// main starts the HTTP server and registers handlers, or runs relay migrate
+// (see runMigrate).
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
 	}
-	return s
-}
-
-// --- Handlers ---
 
-// handleRegister stores an incoming PrekeyBundle (POST /register).
-func (s *state) handleRegister(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
-
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
+	pflag.IntVarP(&port, "port", "p", defaultPort, "port to listen on")
+	pflag.BoolVar(&enableLogging, "log", false, "enable access logging")
+	pflag.StringVar(&blobBackendName, "blob-backend", relayserver.BlobBackendNone, "attachment store: none, fs or s3")
+	pflag.StringVar(&blobDir, "blob-dir", "relay-blobs", "directory for the fs attachment store")
+	pflag.Int64Var(&blobMax, "blob-max-bytes", relayserver.DefaultBlobMax, "maximum attachment size in bytes")
+	pflag.DurationVar(&blobTTL, "blob-ttl", relayserver.DefaultBlobTTL, "attachment lifetime before garbage collection")
+	pflag.StringVar(&publicURL, "public-url", "", "externally reachable relay URL (default http://127.0.0.1:<port>)")
+	pflag.StringVar(&s3Endpoint, "s3-endpoint", "", "S3-compatible endpoint URL")
+	pflag.StringVar(&s3Bucket, "s3-bucket", "", "S3 bucket for attachments")
+	pflag.StringVar(&s3Region, "s3-region", "us-east-1", "S3 signing region")
+	pflag.StringVar(&tlsCert, "tls-cert", "", "TLS certificate file (enables HTTPS with HTTP/2)")
+	pflag.StringVar(&tlsKey, "tls-key", "", "TLS private key file")
+	pflag.BoolVar(&h2c, "h2c", false, "also accept HTTP/2 over plain TCP (prior knowledge)")
+	pflag.BoolVar(&serveHTTP3, "http3", false, "experimental: also serve HTTP/3 over QUIC on the UDP port of each TLS listener")
+	pflag.StringArrayVar(&listen, "listen", nil, "address to listen on: host:port, [::]:port or unix:/path, with optional ,cert=FILE,key=FILE or ,tls=off (repeatable)")
+	pflag.StringArrayVar(&webhookURLs, "webhook-url", nil, "POST relay events to this URL (repeatable)")
+	pflag.StringSliceVar(&webhookEvents, "webhook-events", relayserver.WebhookEvents(), "event types to deliver")
+	pflag.IntVar(&webhookHighWater, "webhook-high-water", relayserver.DefaultHighWater, "queue length reported as high water")
+	pflag.StringVar(&dataDir, "data-dir", "", "directory to persist bundles and queues in (default: memory only)")
+	pflag.DurationVar(&ackRetention, "ack-retention", 0, "keep acked envelopes this long for clients to fetch again with include_acked=1 (default: discard when acked)")
+	pflag.Float64Var(&chaosDrop, "chaos-drop", 0, "development only: probability that an accepted envelope is silently lost")
+	pflag.DurationVar(&chaosDelay, "chaos-delay", 0, "development only: hold each envelope back from fetches for a random time up to this")
+	pflag.Float64Var(&chaosDuplicate, "chaos-duplicate", 0, "development only: probability that a fetched envelope is returned twice")
+	pflag.BoolVar(&repair, "repair", false, "drop corrupt or inconsistent stored records at startup instead of refusing to start")
+	pflag.StringVar(&otlpEndpoint, "otlp-endpoint", os.Getenv(traceEndpointEnv), "export a trace span per request to this OTLP/HTTP collector, e.g. http://127.0.0.1:4318")
+	pflag.StringVar(&challengeKind, "register-challenge", challengeNone, "challenge new usernames must answer to register: none, token, pow or captcha")
+	pflag.IntVar(&powBits, "pow-bits", relayserver.DefaultPoWBits, "proof-of-work difficulty in bits for --register-challenge pow")
+	pflag.StringVar(&captchaVerifyURL, "captcha-verify-url", "", "CAPTCHA verification endpoint, e.g. https://hcaptcha.com/siteverify")
+	pflag.StringVar(&captchaSiteKey, "captcha-site-key", "", "CAPTCHA site key given to clients")
+	pflag.StringVar(&captchaPageURL, "captcha-page-url", "", "page where a person solves the CAPTCHA and copies the response token")
+	pflag.StringVar(&maintenanceStart, "maintenance-start", "", "announce a maintenance window starting at this RFC 3339 time")
+	pflag.StringVar(&maintenanceEnd, "maintenance-end", "", "announce a maintenance window ending at this RFC 3339 time")
+	pflag.StringVar(&motd, "motd", "", "message of the day shown to clients")
+	pflag.StringVar(&minClientVersion, "min-client-version", "", "oldest client version supported, e.g. v1.2.0")
+	pflag.StringVar(&clientGate, "client-gate", string(domain.GateWarn), "what clients older than --min-client-version do: warn or block")
+	pflag.StringArrayVar(&routeLimitFlags, "route-limit", nil, "handle at most MAX requests to a route at once, queueing QUEUE more, as \"METHOD /path=MAX[:QUEUE]\"; MAX 0 lifts a default limit (repeatable)")
+	pflag.DurationVar(&routeLimitWait, "route-limit-wait", relayserver.DefaultLimitWait, "longest a queued request waits for a slot before the relay answers 503")
+	pflag.IntVar(&checkoutMax, "checkout-limit", relayserver.DefaultCheckoutLimit().Max, "hand out at most this many of a user's one-time prekeys per --checkout-window; 0 lifts the limit")
+	pflag.DurationVar(&checkoutWindow, "checkout-window", relayserver.DefaultCheckoutLimit().Window, "window --checkout-limit applies to")
+	pflag.BoolVar(&showVersion, "version", false, "print version, commit, build date and protocol versions, then exit")
+	pflag.Parse()
 
-	var bundle domain.PrekeyBundle
-	if err := dec.Decode(&bundle); err != nil {
-		writeErr(w, http.StatusBadRequest, "bad request")
-		return
-	}
-	if bundle.Username == "" {
-		writeErr(w, http.StatusBadRequest, "username required")
-		return
-	}
-	if len(bundle.OneTime) > maxOneTimeKeys {
-		writeErr(w, http.StatusRequestEntityTooLarge, "too many one-time keys")
+	if showVersion {
+		buildinfo.Write(os.Stdout, "relay", relayserver.Info(blobBackendName != relayserver.BlobBackendNone))
 		return
 	}
 
-	s.mu.Lock()
-	s.bundles[bundle.Username] = bundle
-	s.mu.Unlock()
-
-	if enableLogging {
-		slog.Info("register",
-			"user", bundle.Username,
-			"identity_key_set", !isZero32(bundle.IdentityKey[:]),
-			"sign_key_set", !isZero32(bundle.SignKey[:]),
-			"spk_id", bundle.SPKID,
-			"one_time_count", len(bundle.OneTime),
-			"reqid", requestIDFromCtx(r.Context()),
-		)
-	}
-	w.WriteHeader(http.StatusNoContent)
-}
-
-// handleGet returns a stored PrekeyBundle (GET /prekey/{username}).
-func (s *state) handleGet(w http.ResponseWriter, r *http.Request) {
-	username := r.PathValue("username")
-	if username == "" {
-		writeErr(w, http.StatusBadRequest, "username required")
-		return
+	if port <= minPort || port > maxPort {
+		port = defaultPort
 	}
-
-	s.mu.RLock()
-	bundle, ok := s.bundles[username]
-	s.mu.RUnlock()
-	if !ok {
-		http.NotFound(w, r)
-		return
+	if (tlsCert == "") != (tlsKey == "") {
+		fmt.Fprintln(os.Stderr, "--tls-cert and --tls-key must be given together")
+		os.Exit(2)
 	}
-
-	if enableLogging {
-		slog.Info(
-			"prekey_fetch",
-			"user", username,
-			"spk_id", bundle.SPKID,
-			"one_time_count", len(bundle.OneTime),
-			"reqid", requestIDFromCtx(r.Context()),
-		)
+	if repair && dataDir == "" {
+		fmt.Fprintln(os.Stderr, "--repair needs --data-dir")
+		os.Exit(2)
 	}
-	writeJSON(w, bundle)
-}
-
-// handleEnqueue enqueues a new Envelope (POST /msg/{user}).
-func (s *state) handleEnqueue(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
 
-	user := r.PathValue("user")
-
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
-
-	var env domain.Envelope
-	if err := dec.Decode(&env); err != nil {
-		writeErr(w, http.StatusBadRequest, "bad request")
-		return
-	}
-	if env.To == "" {
-		writeErr(w, http.StatusBadRequest, "recipient required")
-		return
+	// Without --listen the relay listens on every interface at --port.
+	if len(listen) == 0 {
+		listen = []string{fmt.Sprintf(":%d", port)}
 	}
-	// Prevent route and payload mismatch.
-	if user == "" || user != env.To {
-		writeErr(w, http.StatusBadRequest, "recipient mismatch")
-		return
-	}
-	// Basic payload caps and sanity checks.
-	if len(env.Cipher) > maxCipherBytes {
-		writeErr(w, http.StatusRequestEntityTooLarge, "cipher too large")
-		return
-	}
-	if env.Timestamp == 0 {
-		env.Timestamp = time.Now().Unix()
-	} else {
-		now := time.Now()
-		ts := time.Unix(env.Timestamp, 0)
-		if ts.After(now.Add(maxFutureSkew)) {
-			writeErr(w, http.StatusBadRequest, "timestamp in future")
-			return
+	var specs []listenSpec
+	for _, v := range listen {
+		l, err := parseListen(v, tlsCert, tlsKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		specs = append(specs, l)
+	}
+	useTLS := false
+	for _, l := range specs {
+		useTLS = useTLS || l.tls()
+		if serveHTTP3 && (l.network != "tcp" || !l.tls()) {
+			fmt.Fprintf(os.Stderr, "--http3 needs TLS on every host:port listener; %s has none (drop it or add cert and key)\n", l)
+			os.Exit(2)
+		}
+		if base, ok := l.publicBase(); ok && publicURL == "" {
+			publicURL = base
 		}
 	}
-
-	// Append with per-user queue cap, drop oldest if needed.
-	s.mu.Lock()
-	q := append(s.queues[user], env)
-	if len(q) > maxPerUserQueue {
-		q = q[len(q)-maxPerUserQueue:]
-	}
-	s.queues[user] = q
-	qLen := len(q)
-	s.mu.Unlock()
-
-	if enableLogging {
-		slog.Info("enqueue",
-			"queue_user", user,
-			"from", env.From,
-			"to", env.To,
-			"cipher_bytes", len(env.Cipher),
-			"has_prekey", env.Prekey != nil,
-			"queue_len", qLen,
-			"reqid", requestIDFromCtx(r.Context()),
-		)
-	}
-	w.WriteHeader(http.StatusNoContent)
-}
-
-// handleFetch fetches queued Envelopes (GET /msg/{user}?limit=N).
-func (s *state) handleFetch(w http.ResponseWriter, r *http.Request) {
-	user := r.PathValue("user")
-
-	limit, err := parseLimit(r.URL.Query().Get("limit"))
-	if err != nil {
-		writeErr(w, http.StatusBadRequest, "bad limit")
-		return
-	}
-
-	// Copy under lock to avoid races with concurrent enqueue/ack.
-	s.mu.RLock()
-	queue := s.queues[user]
-	if limit == 0 || limit > len(queue) {
-		limit = len(queue)
-	}
-	out := make([]domain.Envelope, limit)
-	copy(out, queue[:limit])
-	available := len(queue)
-	s.mu.RUnlock()
-
-	writeJSON(w, out)
-
-	if enableLogging {
-		slog.Info("fetch", "user", user, "limit", limit, "available", available, "reqid", requestIDFromCtx(r.Context()))
-	}
-}
-
-// handleAck acknowledges and drops N messages (POST /msg/{user}/ack).
-func (s *state) handleAck(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
-
-	user := r.PathValue("user")
-
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields()
-
-	var ack struct {
-		Count int `json:"count"`
-	}
-	if err := dec.Decode(&ack); err != nil || ack.Count < 0 {
-		writeErr(w, http.StatusBadRequest, "bad request")
-		return
-	}
-
-	s.mu.Lock()
-	if ack.Count > len(s.queues[user]) {
-		ack.Count = len(s.queues[user])
-	}
-	s.queues[user] = s.queues[user][ack.Count:]
-	remaining := len(s.queues[user])
-	s.mu.Unlock()
-
-	if enableLogging {
-		slog.Info("ack", "user", user, "drop", ack.Count, "remaining", remaining, "reqid", requestIDFromCtx(r.Context()))
-	}
-	w.WriteHeader(http.StatusNoContent)
-}
-
-// --- Main ---
-
-// main starts the HTTP server and registers handlers.
-func main() {
-	pflag.IntVarP(&port, "port", "p", defaultPort, "port to listen on")
-	pflag.BoolVar(&enableLogging, "log", false, "enable access logging")
-	pflag.Parse()
-
-	if port <= minPort || port > maxPort {
-		port = defaultPort
+	if publicURL == "" {
+		publicURL = fmt.Sprintf("http://127.0.0.1:%d", port)
 	}
 
 	logger := slog.New(
@@ -470,37 +210,131 @@ func main() {
 	)
 	slog.SetDefault(logger)
 
-	s := newState()
-	mux := http.NewServeMux()
+	// Webhook bodies are signed with a secret from the environment.
+	if len(webhookURLs) > 0 && os.Getenv(webhookSecretEnv) == "" {
+		fmt.Fprintf(os.Stderr, "%s must be set when --webhook-url is given\n", webhookSecretEnv)
+		os.Exit(2)
+	}
 
-	// Register HTTP endpoints. Middlewares: recover -> reqid -> logging -> handler
-	mux.HandleFunc("POST /register", chain(s.handleRegister, withRecover, withReqID, withLogging))    // POST /register
-	mux.HandleFunc("GET /prekey/{username}", chain(s.handleGet, withRecover, withReqID, withLogging)) // GET  /prekey/{username}
-	mux.HandleFunc("POST /msg/{user}", chain(s.handleEnqueue, withRecover, withReqID, withLogging))   // POST /msg/{user}
-	mux.HandleFunc("GET /msg/{user}", chain(s.handleFetch, withRecover, withReqID, withLogging))      // GET  /msg/{user}
-	mux.HandleFunc("POST /msg/{user}/ack", chain(s.handleAck, withRecover, withReqID, withLogging))   // POST /msg/{user}/ack
+	challenge, err := registrationChallenge()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	notice, err := relayNotice()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	// Simple health check for readiness/liveness probes.
-	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusNoContent)
+	limits, err := routeLimits()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	relay, err := relayserver.NewServer(relayserver.Options{
+		Logger:             logger,
+		AccessLog:          enableLogging,
+		DataDir:            dataDir,
+		Repair:             repair,
+		AckRetention:       ackRetention,
+		StorageKey:         os.Getenv(storageKeyEnv),
+		PreviousStorageKey: os.Getenv(previousStorageKeyEnv),
+		AdminToken:         os.Getenv(adminTokenEnv),
+		WebhookURLs:        webhookURLs,
+		WebhookSecret:      os.Getenv(webhookSecretEnv),
+		WebhookEvents:      webhookEvents,
+		WebhookHighWater:   webhookHighWater,
+		OTLPEndpoint:       otlpEndpoint,
+		ServiceName:        os.Getenv(traceServiceEnv),
+		Challenge:          challenge,
+		DiscoveryURL:       publicURL,
+		Notice:             notice,
+		RouteLimits:        limits,
+		CheckoutLimit:      checkoutLimit(),
+		Blobs: relayserver.BlobOptions{
+			Backend:     blobBackendName,
+			Dir:         blobDir,
+			MaxBytes:    blobMax,
+			TTL:         blobTTL,
+			PublicURL:   publicURL,
+			S3Endpoint:  s3Endpoint,
+			S3Bucket:    s3Bucket,
+			S3Region:    s3Region,
+			S3AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			S3SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		},
+		Chaos: relayserver.ChaosOptions{
+			Drop:      chaosDrop,
+			Delay:     chaosDelay,
+			Duplicate: chaosDuplicate,
+		},
 	})
+	if err != nil {
+		slog.Error("Relay unavailable", "error", err)
+		os.Exit(1)
+	}
 
 	srv := &http.Server{
-		Addr:              fmt.Sprintf(":%d", port),
-		Handler:           mux,
+		Handler:           relay,
 		ReadHeaderTimeout: readHeaderTO,
 		ReadTimeout:       readTO,
 		WriteTimeout:      writeTO,
 		IdleTimeout:       idleTO,
+		Protocols:         serverProtocols(useTLS, h2c),
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: h2MaxStreams,
+			SendPingTimeout:      h2PingInterval,
+			PingTimeout:          h2PingTimeout,
+		},
+	}
+
+	// Fetches waiting for envelopes answer, and push connections close, as
+	// soon as shutdown begins, so the graceful shutdown need not wait out
+	// their timeouts.
+	srv.RegisterOnShutdown(relay.Drain)
+
+	// Open every listener before serving so a bad address fails at startup.
+	listeners := make([]net.Listener, len(specs))
+	for i, l := range specs {
+		ln, err := l.listen()
+		if err != nil {
+			slog.Error("Listen failed", "addr", l.String(), "error", err)
+			os.Exit(1)
+		}
+		listeners[i] = ln
+	}
+	var h3 []*http3Listener
+	if serveHTTP3 {
+		for _, l := range specs {
+			h, err := listenHTTP3(l, relay)
+			if err != nil {
+				slog.Error("Listen failed", "addr", l.String(), "http3", true, "error", err)
+				os.Exit(1)
+			}
+			h3 = append(h3, h)
+		}
 	}
 
-	// Graceful shutdown.
-	go func() {
-		slog.Info("Relay listening", "addr", srv.Addr)
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			slog.Error("Relay failed", "error", err)
-		}
-	}()
+	// Serve every listener; the graceful shutdown below closes them all.
+	for i, l := range specs {
+		go func() {
+			slog.Info("Relay listening", "addr", l.String(), "tls", l.tls(), "h2c", h2c)
+			if err := l.serve(srv, listeners[i]); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Relay failed", "addr", l.String(), "error", err)
+			}
+		}()
+	}
+	for _, l := range h3 {
+		go func() {
+			slog.Info("Relay listening", "addr", l.spec.String(), "http3", true)
+			if err := l.serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Relay failed", "addr", l.spec.String(), "http3", true, "error", err)
+			}
+		}()
+	}
 
 	// Wait for interrupt or terminate signal.
 	stop := make(chan os.Signal, 1)
@@ -513,4 +347,24 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		slog.Error("Graceful shutdown failed", "error", err)
 	}
+	for _, l := range h3 {
+		if err := l.srv.Shutdown(ctx); err != nil {
+			slog.Error("Graceful shutdown failed", "addr", l.spec.String(), "http3", true, "error", err)
+		}
+		l.conn.Close()
+	}
+	if err := relay.Close(); err != nil {
+		slog.Error("Closing storage failed", "error", err)
+	}
+}
+
+// serverProtocols returns the protocols to serve. HTTP/1.1 is always enabled;
+// HTTP/2 is negotiated via ALPN over TLS, and h2c adds HTTP/2 without TLS for
+// clients that use prior knowledge (such as "ciphera --h2c").
+func serverProtocols(useTLS, h2c bool) *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP1(true)
+	p.SetHTTP2(useTLS)
+	p.SetUnencryptedHTTP2(h2c)
+	return p
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/relayserver"
+)
+
+// targetAdminTokenEnv holds the target relay's admin token for relay
+// migrate, when it differs from the source's in RELAY_ADMIN_TOKEN.
+const targetAdminTokenEnv = "RELAY_TARGET_ADMIN_TOKEN"
+
+// migrateTimeout bounds each request relay migrate makes.
+const migrateTimeout = 15 * time.Minute
+
+// migrateUsage is printed for relay migrate --help and on bad arguments.
+const migrateUsage = `Usage: relay migrate --from <relay URL|file> --to <relay URL|file>
+
+Copy every bundle, queued envelope, restriction, backup and presence policy
+from one relay to another, for example from a relay kept in memory to one
+with --data-dir, or between data directories. Either side may be a snapshot
+file instead, to move the state in two steps. The target relay must hold
+nothing yet. Both relays need the admin API: RELAY_ADMIN_TOKEN is sent to
+both, or RELAY_TARGET_ADMIN_TOKEN to the target when it is set.
+
+The snapshot is checked before it is imported, and the target's state after
+the import must match it. A source relay is exported again at the end: if it
+changed, envelopes queued since are not on the target, so stop traffic to the
+source before migrating.
+
+`
+
+// runMigrate runs relay migrate with args and returns the exit status.
+func runMigrate(args []string) int {
+	fs := pflag.NewFlagSet("relay migrate", pflag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		fs.PrintDefaults()
+	}
+	from := fs.String("from", "", "relay URL or snapshot file to copy from")
+	to := fs.String("to", "", "relay URL or snapshot file to copy to; a file must not exist yet")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *from == "" || *to == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	m := migration{
+		client:      &http.Client{Timeout: migrateTimeout},
+		sourceToken: os.Getenv(adminTokenEnv),
+		targetToken: os.Getenv(targetAdminTokenEnv),
+	}
+	if m.targetToken == "" {
+		m.targetToken = m.sourceToken
+	}
+	if err := m.run(*from, *to); err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		return 1
+	}
+	return 0
+}
+
+// migration copies relay state between relays and snapshot files.
+type migration struct {
+	client      *http.Client
+	sourceToken string
+	targetToken string
+}
+
+// run copies the state at from to to, checking it on both sides.
+func (m migration) run(from, to string) error {
+	raw, err := m.read(from)
+	if err != nil {
+		return err
+	}
+	want, err := relayserver.CheckSnapshot(raw)
+	if err != nil {
+		return fmt.Errorf("%s: %w", from, err)
+	}
+	fmt.Printf("Exported %s from %s\n", describeSnapshot(want), from)
+
+	if !isRelayURL(to) {
+		f, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(raw); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote snapshot to %s (digest %s)\n", to, want.Digest)
+		return nil
+	}
+
+	var got relayserver.SnapshotSummary
+	if err := m.do(http.MethodPost, to, "/admin/import", m.targetToken, raw, &got); err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("%s holds %s after the import, want %s", to, describeSnapshot(got), describeSnapshot(want))
+	}
+	fmt.Printf("Imported into %s; its state matches (digest %s)\n", to, got.Digest)
+
+	if !isRelayURL(from) {
+		return nil
+	}
+	again, err := m.read(from)
+	if err != nil {
+		return fmt.Errorf("checking %s for changes: %w", from, err)
+	}
+	now, err := relayserver.CheckSnapshot(again)
+	if err != nil {
+		return fmt.Errorf("checking %s for changes: %w", from, err)
+	}
+	if now.Digest != want.Digest {
+		return fmt.Errorf("%s changed during the migration and now holds %s; what changed is not on %s", from, describeSnapshot(now), to)
+	}
+	return nil
+}
+
+// read returns the snapshot at from: exported by a relay, or a file.
+func (m migration) read(from string) ([]byte, error) {
+	if !isRelayURL(from) {
+		return os.ReadFile(from)
+	}
+	var raw json.RawMessage
+	if err := m.do(http.MethodGet, from, "/admin/export", m.sourceToken, nil, &raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// do sends an admin request to the relay at base and decodes the answer
+// into out.
+func (m migration) do(method, base, path, token string, body []byte, out any) error {
+	url := strings.TrimRight(base, "/") + path
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error domain.RelayError `json:"error"`
+		}
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(raw, &e) == nil && e.Error.Code != "" {
+			if p := e.Error.Details["problem"]; p != "" {
+				return fmt.Errorf("%s %s: %s: %v: %s", method, url, resp.Status, &e.Error, p)
+			}
+			return fmt.Errorf("%s %s: %s: %v", method, url, resp.Status, &e.Error)
+		}
+		return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// isRelayURL reports whether s names a relay rather than a file.
+func isRelayURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// describeSnapshot says what a snapshot holds.
+func describeSnapshot(v relayserver.SnapshotSummary) string {
+	return fmt.Sprintf("bundles=%d queued=%d restrictions=%d backups=%d presence=%d next_seq=%d",
+		v.Bundles, v.Queued, v.Restrictions, v.Backups, v.Presence, v.NextSeq)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// relayNotice builds the notice announced in GET /server-info from
+// --maintenance-start, --maintenance-end, --motd, --min-client-version and
+// --client-gate. The gate only applies with a minimum version.
+func relayNotice() (domain.RelayNotice, error) {
+	n := domain.RelayNotice{MOTD: motd, MinClientVersion: minClientVersion}
+	for _, t := range []struct {
+		flag string
+		val  string
+		out  *int64
+	}{
+		{"--maintenance-start", maintenanceStart, &n.MaintenanceStartUTC},
+		{"--maintenance-end", maintenanceEnd, &n.MaintenanceEndUTC},
+	} {
+		if t.val == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, t.val)
+		if err != nil {
+			return domain.RelayNotice{}, fmt.Errorf("%s: want an RFC 3339 time, e.g. 2026-01-02T22:00:00Z", t.flag)
+		}
+		*t.out = at.Unix()
+	}
+	if minClientVersion != "" {
+		gate := domain.ClientGate(clientGate)
+		if gate != domain.GateWarn && gate != domain.GateBlock {
+			return domain.RelayNotice{}, fmt.Errorf("--client-gate must be %s or %s", domain.GateWarn, domain.GateBlock)
+		}
+		n.ClientGate = gate
+	}
+	return n, nil
+}
@@ -3,12 +3,17 @@ module ciphera
 go 1.24.5
 
 require (
+	filippo.io/nistec v0.0.4
+	github.com/quic-go/quic-go v0.59.1
 	github.com/spf13/cobra v1.9.1
 	github.com/spf13/pflag v1.0.7
-	golang.org/x/crypto v0.40.0
+	golang.org/x/crypto v0.41.0
+	golang.org/x/sys v0.36.0
 )
 
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	golang.org/x/sys v0.34.0 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
+	golang.org/x/net v0.43.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
 )
@@ -1,7 +1,10 @@
 package app
 
 import (
+	"log/slog"
 	"net/http"
+
+	"ciphera/internal/store/faulty"
 )
 
 // Config holds settings for wiring up the application.
@@ -9,4 +12,13 @@ type Config struct {
 	HomeDir    string       // path to config directory
 	RelayURL   string       // base URL of the relay server
 	HTTPClient *http.Client // HTTP client (with timeouts) to use for network calls
+	Logger     *slog.Logger // optional structured logger; nil discards all output
+
+	// StoreTelemetry measures the stores' reads, writes and lock waits,
+	// logging each at debug level and adding them up in Wire.StoreTelemetry.
+	StoreTelemetry bool
+
+	// StoreFaults makes the stores fail, corrupt or stall on purpose, for
+	// resilience testing. Its Dir is set to HomeDir. Never set it for real use.
+	StoreFaults faulty.Options
 }
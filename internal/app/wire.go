@@ -1,35 +1,144 @@
 package app
 
 import (
+	"log/slog"
 	"net/http"
 
 	"ciphera/internal/domain"
 	"ciphera/internal/relay"
+	accountsvc "ciphera/internal/services/account"
+	backupsvc "ciphera/internal/services/backup"
+	broadcastsvc "ciphera/internal/services/broadcast"
+	conversationsvc "ciphera/internal/services/conversation"
+	couriersvc "ciphera/internal/services/courier"
+	draftsvc "ciphera/internal/services/draft"
+	historysvc "ciphera/internal/services/history"
 	identitysvc "ciphera/internal/services/identity"
 	messagesvc "ciphera/internal/services/message"
+	pairingsvc "ciphera/internal/services/pairing"
 	prekeysvc "ciphera/internal/services/prekey"
+	ratchetdebugsvc "ciphera/internal/services/ratchetdebug"
 	sessionsvc "ciphera/internal/services/session"
+	statssvc "ciphera/internal/services/stats"
+	undosvc "ciphera/internal/services/undo"
 	"ciphera/internal/store"
+	"ciphera/internal/store/faulty"
 )
 
 // Wire bundles all stores, services, and clients for the CLI.
 type Wire struct {
-	IdentityService domain.IdentityService
-	PrekeyService   domain.PrekeyService
-	SessionService  domain.SessionService
-	MessageService  domain.MessageService
-	RelayClient     domain.RelayClient
-	HTTPClient      *http.Client
+	IdentityService     domain.IdentityService
+	PrekeyService       domain.PrekeyService
+	AccountService      domain.AccountService
+	SessionService      domain.SessionService
+	MessageService      domain.MessageService
+	ConversationService domain.ConversationService
+	PairingService      domain.PairingService
+	HistoryService      domain.HistoryService
+	StatsService        domain.StatsService
+	RatchetDebugService domain.RatchetDebugService
+	BroadcastService    domain.BroadcastService
+	DraftService        domain.DraftService
+	BackupService       domain.BackupService
+	CourierService      domain.CourierService
+	UndoService         domain.UndoService
+	RelayClient         domain.RelayClient
+	Relays              domain.RelayDirectory
+	HTTPClient          *http.Client
+	StoreTelemetry      *store.Telemetry // nil unless Config.StoreTelemetry is set
 }
 
 // NewWire constructs the dependency graph from cfg.
 func NewWire(cfg Config) (*Wire, error) {
+	// Services log state transitions only; a nil logger discards them.
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+
+	// Measure the stores, migrations included, when asked to.
+	var telemetry *store.Telemetry
+	if cfg.StoreTelemetry {
+		telemetry = store.NewTelemetry(cfg.HomeDir, logger)
+	}
+	store.SetTelemetry(telemetry)
+
+	// Upgrade store files written by older versions before any store reads them.
+	applied, err := store.Migrate(cfg.HomeDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range applied {
+		logger.Debug("store migrated",
+			"file", m.File,
+			"from", m.From,
+			"to", m.To,
+			"migration", m.Name,
+			"backup", m.Backup,
+		)
+	}
+
 	// File-based stores
-	idStore := store.NewIdentityFileStore(cfg.HomeDir)
-	prekeyStore := store.NewPrekeyFileStore(cfg.HomeDir)
-	bundleStore := store.NewBundleFileStore(cfg.HomeDir)
-	sessionStore := store.NewSessionFileStore(cfg.HomeDir)
-	ratchetStore := store.NewRatchetFileStore(cfg.HomeDir)
+	var (
+		idStore         domain.IdentityStore     = store.NewIdentityFileStore(cfg.HomeDir)
+		prekeyStore     domain.PrekeyStore       = store.NewPrekeyFileStore(cfg.HomeDir)
+		bundleStore     domain.PrekeyBundleStore = store.NewBundleFileStore(cfg.HomeDir)
+		sessionStore    domain.SessionStore      = store.NewSessionFileStore(cfg.HomeDir)
+		ratchetStore    domain.RatchetStore      = store.NewRatchetFileStore(cfg.HomeDir)
+		quarantineStore domain.QuarantineStore   = store.NewQuarantineFileStore(cfg.HomeDir)
+		accountStore    domain.AccountStore      = store.NewAccountFileStore(cfg.HomeDir)
+		preferenceStore domain.PreferenceStore   = store.NewPreferenceFileStore(cfg.HomeDir)
+		contactStore    domain.ContactStore      = store.NewContactFileStore(cfg.HomeDir)
+		settingsStore   domain.SettingsStore     = store.NewSettingsFileStore(cfg.HomeDir)
+		historyStore    domain.HistoryStore      = store.NewHistoryFileStore(cfg.HomeDir)
+		broadcastStore  domain.BroadcastStore    = store.NewBroadcastFileStore(cfg.HomeDir)
+		attestStore     domain.AttestationStore  = store.NewAttestationFileStore(cfg.HomeDir)
+		outboxStore     domain.OutboxStore       = store.NewOutboxFileStore(cfg.HomeDir)
+		traceStore      domain.RatchetTraceStore = store.NewRatchetTraceFileStore(cfg.HomeDir)
+		relayCacheStore domain.RelayCacheStore   = store.NewRelayCacheFileStore(cfg.HomeDir)
+		chunkStore      domain.ChunkStore        = store.NewChunkFileStore(cfg.HomeDir)
+		heldStore       domain.HeldStore         = store.NewHeldFileStore(cfg.HomeDir)
+		draftStore      domain.DraftStore        = store.NewDraftFileStore(cfg.HomeDir)
+		profileStore    domain.ProfileStore      = store.NewProfileFileStore(cfg.HomeDir)
+		archiveStore    domain.ArchiveStore      = store.NewArchiveFileStore(cfg.HomeDir)
+		actionStore     domain.ActionStore       = store.NewActionFileStore(cfg.HomeDir)
+	)
+
+	// Stores fail, corrupt their files or stall on purpose when asked to,
+	// for resilience testing.
+	if cfg.StoreFaults.Enabled() {
+		opts := cfg.StoreFaults
+		opts.Dir = cfg.HomeDir
+		in, err := faulty.New(opts)
+		if err != nil {
+			return nil, err
+		}
+		idStore = in.IdentityStore(idStore)
+		prekeyStore = in.PrekeyStore(prekeyStore)
+		bundleStore = in.PrekeyBundleStore(bundleStore)
+		sessionStore = in.SessionStore(sessionStore)
+		ratchetStore = in.RatchetStore(ratchetStore)
+		quarantineStore = in.QuarantineStore(quarantineStore)
+		accountStore = in.AccountStore(accountStore)
+		preferenceStore = in.PreferenceStore(preferenceStore)
+		contactStore = in.ContactStore(contactStore)
+		settingsStore = in.SettingsStore(settingsStore)
+		historyStore = in.HistoryStore(historyStore)
+		broadcastStore = in.BroadcastStore(broadcastStore)
+		attestStore = in.AttestationStore(attestStore)
+		outboxStore = in.OutboxStore(outboxStore)
+		traceStore = in.RatchetTraceStore(traceStore)
+		relayCacheStore = in.RelayCacheStore(relayCacheStore)
+		chunkStore = in.ChunkStore(chunkStore)
+		heldStore = in.HeldStore(heldStore)
+		draftStore = in.DraftStore(draftStore)
+		profileStore = in.ProfileStore(profileStore)
+		archiveStore = in.ArchiveStore(archiveStore)
+		actionStore = in.ActionStore(actionStore)
+	}
+
+	// Looks up peers by identity key across the (possibly wrapped) stores.
+	identityIndex := store.NewIdentityKeyIndex(contactStore, sessionStore, ratchetStore)
 
 	// Ensure an HTTP client is available for outbound calls
 	httpClient := cfg.HTTPClient
@@ -37,21 +146,76 @@ func NewWire(cfg Config) (*Wire, error) {
 		httpClient = http.DefaultClient
 	}
 
-	// Relay client (uses provided HTTP client)
-	relayClient := relay.NewHTTP(cfg.RelayURL, httpClient)
+	// Relay clients (use provided HTTP client); cfg.RelayURL is the default relay.
+	relays := relay.NewDirectory(cfg.RelayURL, httpClient, accountStore)
+	relayClient := relays.Client("")
+	resolver := relay.NewResolver(httpClient, relayCacheStore)
 
 	// High-level services
-	idSvc := identitysvc.New(idStore)
-	prekeySvc := prekeysvc.New(idStore, prekeyStore, bundleStore)
-	sessionSvc := sessionsvc.New(idStore, bundleStore, sessionStore, relayClient)
-	messageSvc := messagesvc.New(idStore, prekeyStore, ratchetStore, sessionSvc, relayClient)
+	idSvc := identitysvc.New(idStore, logger)
+	prekeySvc := prekeysvc.New(idStore, prekeyStore, bundleStore, attestStore, logger)
+	conversationSvc := conversationsvc.New(preferenceStore, settingsStore, logger)
+	accountSvc := accountsvc.New(idStore, accountStore, sessionStore, ratchetStore, prekeySvc, conversationSvc, relays, logger)
+	sessionSvc := sessionsvc.New(idStore, bundleStore, sessionStore, contactStore, identityIndex, relays, resolver, conversationSvc, logger)
+	messageSvc := messagesvc.New(
+		idStore,
+		prekeyStore,
+		ratchetStore,
+		quarantineStore,
+		contactStore,
+		historyStore,
+		attestStore,
+		outboxStore,
+		traceStore,
+		chunkStore,
+		heldStore,
+		profileStore,
+		archiveStore,
+		sessionSvc,
+		conversationSvc,
+		relays,
+		logger,
+	)
+	pairingSvc := pairingsvc.New(idStore, contactStore, relayClient, logger)
+	historySvc := historysvc.New(historyStore, idStore, conversationSvc, logger)
+	statsSvc := statssvc.New(ratchetStore, conversationSvc, logger)
+	ratchetDebugSvc := ratchetdebugsvc.New(traceStore, conversationSvc, logger)
+	broadcastSvc := broadcastsvc.New(broadcastStore, messageSvc, logger)
+	draftSvc := draftsvc.New(draftStore, idStore, messageSvc, logger)
+	sealer := store.NewPassphraseSealer()
+	backupSvc := backupsvc.New(
+		idStore,
+		sessionStore,
+		ratchetStore,
+		contactStore,
+		historyStore,
+		archiveStore,
+		sealer,
+		relays,
+		logger,
+	)
+	courierSvc := couriersvc.New(messageSvc, sealer, logger)
+	undoSvc := undosvc.New(actionStore, archiveStore, prekeySvc, accountSvc, backupSvc, conversationSvc, logger)
 
 	return &Wire{
-		IdentityService: idSvc,
-		PrekeyService:   prekeySvc,
-		SessionService:  sessionSvc,
-		MessageService:  messageSvc,
-		RelayClient:     relayClient,
-		HTTPClient:      httpClient,
+		IdentityService:     idSvc,
+		PrekeyService:       prekeySvc,
+		AccountService:      accountSvc,
+		SessionService:      sessionSvc,
+		MessageService:      messageSvc,
+		ConversationService: conversationSvc,
+		PairingService:      pairingSvc,
+		HistoryService:      historySvc,
+		StatsService:        statsSvc,
+		RatchetDebugService: ratchetDebugSvc,
+		BroadcastService:    broadcastSvc,
+		DraftService:        draftSvc,
+		BackupService:       backupSvc,
+		CourierService:      courierSvc,
+		UndoService:         undoSvc,
+		RelayClient:         relayClient,
+		Relays:              relays,
+		HTTPClient:          httpClient,
+		StoreTelemetry:      telemetry,
 	}, nil
 }
@@ -0,0 +1,154 @@
+package buildinfo
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/armor"
+	"ciphera/internal/protocol/body"
+	"ciphera/internal/protocol/caps"
+)
+
+// Set with -ldflags "-X ciphera/internal/buildinfo.<Name>=<value>".
+var (
+	Version = "" // semantic version, e.g. v1.2.0
+	Commit  = "" // git commit the binary was built from
+	Date    = "" // build time, RFC 3339 UTC
+)
+
+// devVersion is reported when no version was set or embedded.
+const devVersion = "dev"
+
+// Protocols maps each protocol this build speaks to its version. Bumping one
+// is a wire change: peers and relays compare them for compatibility.
+var Protocols = map[string]int{
+	"x3dh":           1, // crypto.LabelX3DHRoot
+	"double-ratchet": 1,
+	"message-body":   body.Version,
+	"armor":          armor.Version,
+	"pairing":        1, // crypto.LabelPairCard
+	"relay-api":      1, // the HTTP API served by cmd/relay
+}
+
+// Get returns the build information of the running binary.
+func Get() domain.BuildInfo {
+	info := domain.BuildInfo{
+		Version:      Version,
+		Commit:       Commit,
+		BuildDate:    Date,
+		GoVersion:    runtime.Version(),
+		Platform:     runtime.GOOS + "/" + runtime.GOARCH,
+		Protocols:    Protocols,
+		Capabilities: caps.Supported,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = s.Value
+			case s.Key == "vcs.modified" && s.Value == "true":
+				info.Modified = true
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = devVersion
+	}
+	return info
+}
+
+// Write prints info for the binary called name, one field per line.
+func Write(w io.Writer, name string, info domain.BuildInfo) {
+	commit := info.Commit
+	if commit == "" {
+		commit = "unknown"
+	}
+	if info.Modified {
+		commit += " (modified)"
+	}
+	date := info.BuildDate
+	if date == "" {
+		date = "unknown"
+	}
+	fmt.Fprintf(w, "%s %s\n", name, info.Version)
+	fmt.Fprintf(w, "  commit:       %s\n", commit)
+	fmt.Fprintf(w, "  built:        %s\n", date)
+	fmt.Fprintf(w, "  go:           %s %s\n", info.GoVersion, info.Platform)
+	var protos []string
+	for _, p := range slices.Sorted(maps.Keys(info.Protocols)) {
+		protos = append(protos, fmt.Sprintf("%s v%d", p, info.Protocols[p]))
+	}
+	fmt.Fprintf(w, "  protocols:    %s\n", strings.Join(protos, ", "))
+	if len(info.Capabilities) > 0 {
+		fmt.Fprintf(w, "  capabilities: %s\n", strings.Join(info.Capabilities, ", "))
+	}
+}
+
+// Mismatched returns, sorted, the protocols a and b both speak at different
+// versions.
+func Mismatched(a, b domain.BuildInfo) []string {
+	var out []string
+	for _, p := range slices.Sorted(maps.Keys(a.Protocols)) {
+		if v, ok := b.Protocols[p]; ok && v != a.Protocols[p] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Older reports whether version is older than min, both semantic versions
+// such as v1.2.0. A pre-release (v1.2.0-rc.1) is older than its release and
+// build metadata is ignored. ok is false if either does not parse, as for dev
+// builds and those versioned by commit, or is a Go pseudo-version, which
+// names an untagged commit that may be newer than any release.
+func Older(version, min string) (older, ok bool) {
+	v, vpre, vok := parseSemver(version)
+	m, mpre, mok := parseSemver(min)
+	if !vok || !mok {
+		return false, false
+	}
+	if c := slices.Compare(v[:], m[:]); c != 0 {
+		return c < 0, true
+	}
+	return vpre != "" && (mpre == "" || vpre < mpre), true
+}
+
+// pseudoVersion matches the pre-release of a Go pseudo-version, such as
+// v0.0.0-20260102150405-abcdef123456: a commit time and hash.
+var pseudoVersion = regexp.MustCompile(`(^|\.)\d{14}-[0-9a-f]{12}$`)
+
+// parseSemver splits a version of the form [v]MAJOR.MINOR.PATCH[-PRE][+BUILD]
+// into its numbers and pre-release.
+func parseSemver(s string) (nums [3]int, pre string, ok bool) {
+	s = strings.TrimPrefix(s, "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, pre, _ = strings.Cut(s, "-")
+	if pseudoVersion.MatchString(pre) {
+		return nums, "", false
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return nums, "", false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || p[0] == '+' {
+			return nums, "", false
+		}
+		nums[i] = n
+	}
+	return nums, pre, true
+}
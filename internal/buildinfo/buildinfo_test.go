@@ -0,0 +1,34 @@
+package buildinfo_test
+
+import (
+	"testing"
+
+	"ciphera/internal/buildinfo"
+)
+
+func TestOlder(t *testing.T) {
+	tests := []struct {
+		version, min string
+		older, ok    bool
+	}{
+		{"v1.2.0", "v1.2.0", false, true},
+		{"v1.1.9", "v1.2.0", true, true},
+		{"v1.10.0", "v1.9.0", false, true},
+		{"v2.0.0", "v1.9.9", false, true},
+		{"1.2.0", "v1.2.0", false, true},
+		{"v1.2.0-rc.1", "v1.2.0", true, true},
+		{"v1.2.0", "v1.2.0-rc.1", false, true},
+		{"v1.2.0+linux", "v1.2.0", false, true},
+		{"dev", "v1.2.0", false, false},
+		{"1515c26-dirty", "v1.2.0", false, false},
+		{"v1.2.0", "v1.2", false, false},
+		{"v0.0.0-20261016075649-1515c2655e57+dirty", "v1.2.0", false, false},
+		{"v1.2.1-0.20261016075649-1515c2655e57", "v1.2.0", false, false},
+	}
+	for _, tt := range tests {
+		older, ok := buildinfo.Older(tt.version, tt.min)
+		if older != tt.older || ok != tt.ok {
+			t.Errorf("Older(%q, %q) = %v, %v; want %v, %v", tt.version, tt.min, older, ok, tt.older, tt.ok)
+		}
+	}
+}
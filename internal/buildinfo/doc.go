@@ -0,0 +1,13 @@
+// Package buildinfo reports the version of a ciphera or relay binary and the
+// protocol versions it speaks.
+//
+// Version, Commit and Date are set at link time:
+//
+//	go build -ldflags "-X ciphera/internal/buildinfo.Version=v1.2.0 \
+//	    -X ciphera/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	    -X ciphera/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/ciphera
+//
+// Any left unset are filled from the module and VCS information the Go
+// toolchain embeds, so plain go build and cross-compiles (GOOS/GOARCH) still
+// report a commit. The Makefile sets all three.
+package buildinfo
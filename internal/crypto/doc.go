@@ -4,8 +4,36 @@
 //
 //   - X25519 key generation, clamping and Diffie–Hellman (GenerateX25519, ClampX25519PrivateKey, DH)
 //   - Ed25519 key generation, signing and verification (GenerateEd25519, SignEd25519, VerifyEd25519)
+//   - Domain-separated Ed25519 signatures bound to a context label (SignContext, VerifyContext, ContextMessage)
+//   - HKDF-SHA256 extract and expand (HKDFExtract, HKDFExpand, HKDF)
+//   - The protocol's key derivations and their info labels (DeriveX3DHRoot,
+//     DeriveRootAndChain, DeriveMessageKey, DeriveMessageNonce, DeriveHeaderKey,
+//     Label*)
+//   - A registry of cipher suites the protocol derivations run with (Suite,
+//     LookupSuite, NegotiateSuite, SuiteIDs)
 //   - Best-effort memory wiping for sensitive byte slices (Wipe)
 //   - Short public-key fingerprints for display/logging (Fingerprint)
+//   - Deterministic key derivation from seeds for test vectors (X25519FromSeed, Ed25519FromSeed)
+//
+// # Key derivation
+//
+// Every HKDF call in Ciphera goes through this package, and every info label
+// is a Label constant here, so each derivation is domain-separated from the
+// others and documented in one place. x3dh and ratchet re-export their labels
+// for the test vectors printed by `ciphera devtools vectors`; kdf_test.go
+// checks each derivation against those vectors and HKDF against RFC 5869.
+//
+// # Cipher suites
+//
+// A Suite names the curve, KDF, hash and AEAD a conversation runs with. The
+// handshake and every ratchet step derive through the conversation's suite,
+// so adding one to the registry is all a new combination needs. Bundles list
+// the suites their client implements; the initiator picks one with
+// NegotiateSuite and names it in the prekey message, and both ratchet states
+// record it. Two suites are registered: SuiteSHA256, the original protocol
+// and DefaultSuite for everything that names none, and SuiteSHA512, which
+// uses SHA-512 for HKDF and header MACs. The package-level derivations above
+// are those of DefaultSuite.
 //
 // # Notes
 //
@@ -3,6 +3,8 @@ package crypto
 import (
 	"crypto/ed25519"
 	"crypto/rand"
+	"encoding/binary"
+	"math"
 
 	"ciphera/internal/domain"
 )
@@ -30,3 +32,50 @@ func SignEd25519(priv domain.Ed25519Private, msg []byte) []byte {
 func VerifyEd25519(pub domain.Ed25519Public, msg, sig []byte) bool {
 	return ed25519.Verify(ed25519.PublicKey(pub.Slice()), msg, sig)
 }
+
+// Ed25519FromSeed deterministically derives an Ed25519 key pair from a 32-byte
+// seed (RFC 8032). It is intended for test vectors only, never for real keys.
+func Ed25519FromSeed(seed [32]byte) (priv domain.Ed25519Private, pub domain.Ed25519Public) {
+	sk := ed25519.NewKeyFromSeed(seed[:])
+	copy(priv[:], sk)
+	copy(pub[:], sk.Public().(ed25519.PublicKey))
+	return priv, pub
+}
+
+// sigFrame prefixes every context-bound signature input. It is part of the
+// wire protocol and must never change; a new framing gets a new version byte.
+const (
+	sigFrame        = "ciphera-sig"
+	sigFrameVersion = 1
+)
+
+// ContextMessage returns the bytes SignContext signs for msg under the label
+// ctx:
+//
+//	"ciphera-sig" ‖ 0x01 ‖ len(ctx) (uint16) ‖ ctx ‖ len(msg) (uint32) ‖ msg
+//
+// Lengths are big-endian. The framing keeps a signature made for one purpose
+// from verifying for another, and keeps distinct (ctx, msg) pairs from
+// encoding to the same bytes. It panics if ctx or msg is too long to frame.
+func ContextMessage(ctx string, msg []byte) []byte {
+	if len(ctx) > math.MaxUint16 || uint64(len(msg)) > math.MaxUint32 {
+		panic("crypto: signature context or message too long")
+	}
+	out := make([]byte, 0, len(sigFrame)+1+2+len(ctx)+4+len(msg))
+	out = append(out, sigFrame...)
+	out = append(out, sigFrameVersion)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(ctx)))
+	out = append(out, ctx...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(msg)))
+	return append(out, msg...)
+}
+
+// SignContext signs msg with priv under the label ctx (see ContextMessage).
+func SignContext(priv domain.Ed25519Private, ctx string, msg []byte) []byte {
+	return SignEd25519(priv, ContextMessage(ctx, msg))
+}
+
+// VerifyContext verifies sig over msg under the label ctx with pub.
+func VerifyContext(pub domain.Ed25519Public, ctx string, msg, sig []byte) bool {
+	return VerifyEd25519(pub, ContextMessage(ctx, msg), sig)
+}
@@ -0,0 +1,101 @@
+package crypto
+
+import (
+	"fmt"
+	"io"
+)
+
+// HKDF info labels. Each derivation has its own label so a key derived for
+// one purpose can never equal a key derived for another. They are part of the
+// wire protocol and must never change.
+const (
+	// LabelX3DHRoot derives the initial root key from the X3DH DH transcript.
+	LabelX3DHRoot = "ciphera/x3dh-v1"
+	// LabelRatchetRoot derives a new root key and chain key at a DH ratchet step.
+	LabelRatchetRoot = "DR|rk"
+	// LabelRatchetChain derives the next chain key and a message key. The send
+	// and receive chains share it.
+	LabelRatchetChain = "DR|ck"
+	// LabelMessageNonce derives the AEAD nonce from a message key.
+	LabelMessageNonce = "DR|nonce"
+	// LabelHeaderKey derives the key that authenticates envelope headers
+	// from a conversation's initial root key.
+	LabelHeaderKey = "DR|hk"
+	// LabelPairCard derives the key that seals a pairing identity card; the
+	// mailbox side ("a" or "b") is appended.
+	LabelPairCard = "ciphera/pair-v1 card "
+	// LabelHistoryFile derives the key that encrypts the local history from
+	// the history key.
+	LabelHistoryFile = "ciphera/history-v1 file"
+	// LabelHistorySeal derives the key that seals history written while the
+	// history key is locked away, from an ephemeral X25519 exchange.
+	LabelHistorySeal = "ciphera/history-v1 seal"
+	// LabelHandshakeRetry derives the key that seals a responder's request to
+	// retry a handshake it could not complete, from the exchange between the
+	// two identity keys.
+	LabelHandshakeRetry = "ciphera/handshake-retry-v1"
+)
+
+// Derived key sizes.
+const (
+	KeySize   = 32 // root, chain and message keys
+	NonceSize = 12 // ChaCha20-Poly1305 nonce
+)
+
+// defaultSuite is DefaultSuite, which the functions below use.
+var defaultSuite, _ = LookupSuite(DefaultSuite)
+
+// HKDFExtract runs HKDF-Extract with SHA-256 and returns the pseudorandom
+// key. A nil salt is treated as 32 zero bytes (RFC 5869).
+func HKDFExtract(secret, salt []byte) []byte {
+	return defaultSuite.HKDFExtract(secret, salt)
+}
+
+// HKDFExpand runs HKDF-Expand with SHA-256 over prk and returns n bytes.
+func HKDFExpand(prk []byte, info string, n int) ([]byte, error) {
+	return defaultSuite.HKDFExpand(prk, info, n)
+}
+
+// HKDF runs HKDF-SHA256 extract-then-expand and returns n bytes.
+func HKDF(secret, salt []byte, info string, n int) ([]byte, error) {
+	return defaultSuite.HKDF(secret, salt, info, n)
+}
+
+// DeriveX3DHRoot is Suite.DeriveX3DHRoot with DefaultSuite: HKDF-SHA256 with
+// no salt and info=LabelX3DHRoot.
+func DeriveX3DHRoot(transcript []byte) ([]byte, error) {
+	return defaultSuite.DeriveX3DHRoot(transcript)
+}
+
+// DeriveRootAndChain is Suite.DeriveRootAndChain with DefaultSuite:
+// HKDF-SHA256 with salt=root, ikm=dhOutput and info=LabelRatchetRoot.
+func DeriveRootAndChain(root, dhOutput []byte) (newRoot, chainKey []byte, err error) {
+	return defaultSuite.DeriveRootAndChain(root, dhOutput)
+}
+
+// DeriveMessageKey is Suite.DeriveMessageKey with DefaultSuite: HKDF-SHA256
+// with ikm=chainKey, no salt and info=LabelRatchetChain.
+func DeriveMessageKey(chainKey []byte) (nextChainKey, messageKey []byte, err error) {
+	return defaultSuite.DeriveMessageKey(chainKey)
+}
+
+// DeriveMessageNonce is Suite.DeriveMessageNonce with DefaultSuite:
+// HKDF-SHA256 with ikm=messageKey, no salt and info=LabelMessageNonce.
+func DeriveMessageNonce(messageKey []byte) ([]byte, error) {
+	return defaultSuite.DeriveMessageNonce(messageKey)
+}
+
+// DeriveHeaderKey is Suite.DeriveHeaderKey with DefaultSuite: HKDF-SHA256
+// with ikm=root, no salt and info=LabelHeaderKey.
+func DeriveHeaderKey(root []byte) ([]byte, error) {
+	return defaultSuite.DeriveHeaderKey(root)
+}
+
+// readKDF reads n bytes from an HKDF stream.
+func readKDF(r io.Reader, n int) ([]byte, error) {
+	out := make([]byte, n)
+	if _, err := io.ReadFull(r, out); err != nil {
+		return nil, fmt.Errorf("hkdf: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,149 @@
+package crypto_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"ciphera/internal/crypto"
+)
+
+// unhex decodes s or fails the test.
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("hex decode %q: %v", s, err)
+	}
+	return b
+}
+
+// RFC 5869, Appendix A.1 (basic test case with SHA-256).
+func TestHKDF_RFC5869(t *testing.T) {
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	salt := unhex(t, "000102030405060708090a0b0c")
+	info := string(unhex(t, "f0f1f2f3f4f5f6f7f8f9"))
+	wantPRK := unhex(t, "077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5")
+	wantOKM := unhex(t, "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865")
+
+	prk := crypto.HKDFExtract(ikm, salt)
+	if !bytes.Equal(prk, wantPRK) {
+		t.Fatalf("PRK = %x, want %x", prk, wantPRK)
+	}
+	okm, err := crypto.HKDFExpand(prk, info, len(wantOKM))
+	if err != nil {
+		t.Fatalf("HKDFExpand: %v", err)
+	}
+	if !bytes.Equal(okm, wantOKM) {
+		t.Fatalf("OKM = %x, want %x", okm, wantOKM)
+	}
+	okm, err = crypto.HKDF(ikm, salt, info, len(wantOKM))
+	if err != nil {
+		t.Fatalf("HKDF: %v", err)
+	}
+	if !bytes.Equal(okm, wantOKM) {
+		t.Fatalf("HKDF = %x, want %x", okm, wantOKM)
+	}
+}
+
+func TestHKDFExpand_TooLong(t *testing.T) {
+	// HKDF-SHA256 can produce at most 255 blocks of 32 bytes.
+	if _, err := crypto.HKDFExpand(make([]byte, 32), "x", 255*32+1); err == nil {
+		t.Fatal("expected an error for output longer than 255 blocks")
+	}
+}
+
+// The derivations below are checked against values published by
+// `ciphera devtools vectors` (internal/protocol/vectors).
+
+func TestDeriveX3DHRoot_Vector(t *testing.T) {
+	var transcript []byte
+	for _, dh := range []string{
+		"7ee6b40f215ceeb89e4d43d4d78f81f1ac10dd25f088d4c431be857cac565e0f",
+		"6e48229193f61b51cc057471d6661c3e7dad932c7dc4579c799f66bd05d21800",
+		"d8412b5df09b446f06d06265290128c10c885a567a452b03940052752b126770",
+		"2afb851c016bfdb77f5fad9657fd925b45ddc61ba14362b1d0c242ef281d2e36",
+	} {
+		transcript = append(transcript, unhex(t, dh)...)
+	}
+	root, err := crypto.DeriveX3DHRoot(transcript)
+	if err != nil {
+		t.Fatalf("DeriveX3DHRoot: %v", err)
+	}
+	if want := unhex(t, "4f17e030dfe83efbaac8248d4629de55cf7a4fbd932215e7b6abeb9b728f1574"); !bytes.Equal(root, want) {
+		t.Fatalf("root = %x, want %x", root, want)
+	}
+}
+
+func TestDeriveRootAndChain_Vector(t *testing.T) {
+	root := unhex(t, "4f17e030dfe83efbaac8248d4629de55cf7a4fbd932215e7b6abeb9b728f1574")
+	dh := unhex(t, "a433c37f2330203d6369034a1c192f074af83396e644bba87f4c4d285267ae67")
+
+	newRoot, chain, err := crypto.DeriveRootAndChain(root, dh)
+	if err != nil {
+		t.Fatalf("DeriveRootAndChain: %v", err)
+	}
+	if want := unhex(t, "d5e4a80d483243ea033ed47e5aed959fe6c9df9abce74742f01fdfe694908524"); !bytes.Equal(newRoot, want) {
+		t.Fatalf("root = %x, want %x", newRoot, want)
+	}
+	if want := unhex(t, "2508bdf9da4ffc2ec2b8a562dd1a62af29bc3b788eec6448869bcb05e56c354b"); !bytes.Equal(chain, want) {
+		t.Fatalf("chain key = %x, want %x", chain, want)
+	}
+}
+
+func TestDeriveMessageKeyAndNonce_Vector(t *testing.T) {
+	chain := unhex(t, "2508bdf9da4ffc2ec2b8a562dd1a62af29bc3b788eec6448869bcb05e56c354b")
+
+	next, mk, err := crypto.DeriveMessageKey(chain)
+	if err != nil {
+		t.Fatalf("DeriveMessageKey: %v", err)
+	}
+	if want := unhex(t, "f28c19439304668cec3f24e9a97c82f92c18e4e05555a596eb6a2742efb3bdf0"); !bytes.Equal(next, want) {
+		t.Fatalf("next chain key = %x, want %x", next, want)
+	}
+	if want := unhex(t, "d41089fbe94ab7a07c16f6c4a08bd0566dee518d9a94f7c67783cfdb0b4fd8e2"); !bytes.Equal(mk, want) {
+		t.Fatalf("message key = %x, want %x", mk, want)
+	}
+
+	nonce, err := crypto.DeriveMessageNonce(mk)
+	if err != nil {
+		t.Fatalf("DeriveMessageNonce: %v", err)
+	}
+	if want := unhex(t, "c3c7bdfe4afb8215f95335e0"); !bytes.Equal(nonce, want) {
+		t.Fatalf("nonce = %x, want %x", nonce, want)
+	}
+}
+
+func TestDerive_KeysDoNotAlias(t *testing.T) {
+	// Appending to one half of a split derivation must not overwrite the other.
+	next, mk, err := crypto.DeriveMessageKey(make([]byte, crypto.KeySize))
+	if err != nil {
+		t.Fatalf("DeriveMessageKey: %v", err)
+	}
+	before := bytes.Clone(mk)
+	_ = append(next, 0xff)
+	if !bytes.Equal(mk, before) {
+		t.Fatal("appending to the chain key changed the message key")
+	}
+}
+
+func TestLabels_Distinct(t *testing.T) {
+	labels := []string{
+		crypto.LabelX3DHRoot,
+		crypto.LabelRatchetRoot,
+		crypto.LabelRatchetChain,
+		crypto.LabelMessageNonce,
+		crypto.LabelHeaderKey,
+		crypto.LabelPairCard,
+		crypto.LabelHistoryFile,
+		crypto.LabelHistorySeal,
+		crypto.LabelHandshakeRetry,
+	}
+	seen := map[string]bool{}
+	for _, l := range labels {
+		if seen[l] {
+			t.Fatalf("label %q used twice", l)
+		}
+		seen[l] = true
+	}
+}
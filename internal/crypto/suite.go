@@ -0,0 +1,198 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"slices"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Cipher suite IDs. They are part of the wire protocol and must never change.
+const (
+	// SuiteSHA256 is the suite Ciphera has always used.
+	SuiteSHA256 = "x25519-hkdf-sha256-chacha20poly1305"
+	// SuiteSHA512 swaps SHA-512 in for every HKDF and HMAC.
+	SuiteSHA512 = "x25519-hkdf-sha512-chacha20poly1305"
+)
+
+// DefaultSuite is the suite of bundles, handshakes and ratchet states that
+// name none, all of which predate suites, and of the derivations that are not
+// negotiated per conversation (pairing, relay storage, test vectors).
+const DefaultSuite = SuiteSHA256
+
+// ErrUnknownSuite indicates a suite ID this client does not implement.
+var ErrUnknownSuite = errors.New("unknown cipher suite")
+
+// Suite is a set of primitives a conversation is run with, named by ID. Every
+// derivation of the handshake and the ratchet goes through one, so a new
+// suite only needs adding to the registry.
+//
+// Key and nonce sizes are the same in every suite (KeySize, NonceSize), and
+// so is the curve: the identity and prekey types in package domain are
+// X25519 keys.
+type Suite struct {
+	ID    string
+	Curve string // key agreement
+	KDF   string // key derivation, with Hash
+	Hash  string // hash of the KDF and of header MACs
+	AEAD  string // message encryption
+
+	hash    func() hash.Hash
+	newAEAD func(key []byte) (cipher.AEAD, error)
+}
+
+// suites is the registry, in the order suites are offered to peers: the most
+// widely supported first.
+var suites = []*Suite{
+	{
+		ID:      SuiteSHA256,
+		Curve:   "X25519",
+		KDF:     "HKDF",
+		Hash:    "SHA-256",
+		AEAD:    "ChaCha20-Poly1305",
+		hash:    sha256.New,
+		newAEAD: chacha20poly1305.New,
+	},
+	{
+		ID:      SuiteSHA512,
+		Curve:   "X25519",
+		KDF:     "HKDF",
+		Hash:    "SHA-512",
+		AEAD:    "ChaCha20-Poly1305",
+		hash:    sha512.New,
+		newAEAD: chacha20poly1305.New,
+	},
+}
+
+// Suites returns every registered suite, in the order they are offered.
+func Suites() []*Suite {
+	return slices.Clone(suites)
+}
+
+// SuiteIDs returns the IDs of every registered suite, in the order they are
+// offered, as published in prekey bundles.
+func SuiteIDs() []string {
+	ids := make([]string, len(suites))
+	for i, s := range suites {
+		ids[i] = s.ID
+	}
+	return ids
+}
+
+// LookupSuite returns the suite named id. An empty id is DefaultSuite.
+func LookupSuite(id string) (*Suite, error) {
+	if id == "" {
+		id = DefaultSuite
+	}
+	for _, s := range suites {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownSuite, id)
+}
+
+// NegotiateSuite picks the suite for a handshake with a peer offering the
+// suites in offered: preferred if the peer offers it, else the first
+// registered suite the peer offers. A peer offering none predates suites and
+// gets DefaultSuite. ErrUnknownSuite is returned if preferred is not
+// registered or no offered suite is.
+func NegotiateSuite(preferred string, offered []string) (*Suite, error) {
+	if preferred != "" {
+		if _, err := LookupSuite(preferred); err != nil {
+			return nil, err
+		}
+	}
+	if len(offered) == 0 {
+		return LookupSuite(DefaultSuite)
+	}
+	if preferred != "" && slices.Contains(offered, preferred) {
+		return LookupSuite(preferred)
+	}
+	for _, s := range suites {
+		if slices.Contains(offered, s.ID) {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: the peer offers none of ours (%v)", ErrUnknownSuite, offered)
+}
+
+// HKDFExtract runs HKDF-Extract with the suite's hash and returns the
+// pseudorandom key. A nil salt is treated as a hash length of zero bytes
+// (RFC 5869).
+func (s *Suite) HKDFExtract(secret, salt []byte) []byte {
+	return hkdf.Extract(s.hash, secret, salt)
+}
+
+// HKDFExpand runs HKDF-Expand with the suite's hash over prk and returns n
+// bytes.
+func (s *Suite) HKDFExpand(prk []byte, info string, n int) ([]byte, error) {
+	return readKDF(hkdf.Expand(s.hash, prk, []byte(info)), n)
+}
+
+// HKDF runs HKDF extract-then-expand with the suite's hash and returns n
+// bytes.
+func (s *Suite) HKDF(secret, salt []byte, info string, n int) ([]byte, error) {
+	return readKDF(hkdf.New(s.hash, secret, salt, []byte(info)), n)
+}
+
+// MAC returns an HMAC under key with the suite's hash.
+func (s *Suite) MAC(key []byte) hash.Hash {
+	return hmac.New(s.hash, key)
+}
+
+// NewAEAD returns the suite's AEAD keyed with the first KeySize bytes of key.
+func (s *Suite) NewAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) < KeySize {
+		return nil, fmt.Errorf("aead key is %d bytes, want %d", len(key), KeySize)
+	}
+	return s.newAEAD(key[:KeySize])
+}
+
+// DeriveX3DHRoot derives the 32-byte session root key from the concatenated
+// X3DH DH outputs: HKDF with no salt and info=LabelX3DHRoot.
+func (s *Suite) DeriveX3DHRoot(transcript []byte) ([]byte, error) {
+	return s.HKDF(transcript, nil, LabelX3DHRoot, KeySize)
+}
+
+// DeriveRootAndChain performs a root-chain step: HKDF with salt=root,
+// ikm=dhOutput and info=LabelRatchetRoot, yielding 64 bytes split into the new
+// root key and a chain key.
+func (s *Suite) DeriveRootAndChain(root, dhOutput []byte) (newRoot, chainKey []byte, err error) {
+	out, err := s.HKDF(dhOutput, root, LabelRatchetRoot, 2*KeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out[:KeySize:KeySize], out[KeySize:], nil
+}
+
+// DeriveMessageKey performs a symmetric-chain step: HKDF with ikm=chainKey,
+// no salt and info=LabelRatchetChain, yielding 64 bytes split into the next
+// chain key and a message key.
+func (s *Suite) DeriveMessageKey(chainKey []byte) (nextChainKey, messageKey []byte, err error) {
+	out, err := s.HKDF(chainKey, nil, LabelRatchetChain, 2*KeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out[:KeySize:KeySize], out[KeySize:], nil
+}
+
+// DeriveMessageNonce derives the 12-byte AEAD nonce for messageKey: HKDF with
+// ikm=messageKey, no salt and info=LabelMessageNonce.
+func (s *Suite) DeriveMessageNonce(messageKey []byte) ([]byte, error) {
+	return s.HKDF(messageKey, nil, LabelMessageNonce, NonceSize)
+}
+
+// DeriveHeaderKey derives the 32-byte header authentication key for a
+// conversation from its initial root key: HKDF with ikm=root, no salt and
+// info=LabelHeaderKey.
+func (s *Suite) DeriveHeaderKey(root []byte) ([]byte, error) {
+	return s.HKDF(root, nil, LabelHeaderKey, KeySize)
+}
@@ -0,0 +1,102 @@
+package crypto_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"ciphera/internal/crypto"
+)
+
+func TestLookupSuite(t *testing.T) {
+	def, err := crypto.LookupSuite("")
+	if err != nil || def.ID != crypto.DefaultSuite {
+		t.Fatalf("LookupSuite(\"\") = %v, %v; want %s", def, err, crypto.DefaultSuite)
+	}
+	for _, id := range crypto.SuiteIDs() {
+		s, err := crypto.LookupSuite(id)
+		if err != nil || s.ID != id {
+			t.Fatalf("LookupSuite(%q) = %v, %v", id, s, err)
+		}
+	}
+	if _, err := crypto.LookupSuite("nope"); !errors.Is(err, crypto.ErrUnknownSuite) {
+		t.Fatalf("LookupSuite(nope) = %v, want ErrUnknownSuite", err)
+	}
+}
+
+func TestNegotiateSuite(t *testing.T) {
+	both := []string{crypto.SuiteSHA256, crypto.SuiteSHA512}
+	for _, tc := range []struct {
+		name      string
+		preferred string
+		offered   []string
+		want      string
+	}{
+		{"legacy peer", crypto.SuiteSHA512, nil, crypto.DefaultSuite},
+		{"no preference", "", both, crypto.SuiteSHA256},
+		{"preference offered", crypto.SuiteSHA512, both, crypto.SuiteSHA512},
+		{"preference not offered", crypto.SuiteSHA512, []string{crypto.SuiteSHA256}, crypto.SuiteSHA256},
+		{"unknown offers skipped", "", []string{"future", crypto.SuiteSHA512}, crypto.SuiteSHA512},
+	} {
+		s, err := crypto.NegotiateSuite(tc.preferred, tc.offered)
+		if err != nil || s.ID != tc.want {
+			t.Errorf("%s: NegotiateSuite = %v, %v; want %s", tc.name, s, err, tc.want)
+		}
+	}
+
+	if _, err := crypto.NegotiateSuite("", []string{"future"}); !errors.Is(err, crypto.ErrUnknownSuite) {
+		t.Errorf("no common suite: got %v, want ErrUnknownSuite", err)
+	}
+	if _, err := crypto.NegotiateSuite("nope", both); !errors.Is(err, crypto.ErrUnknownSuite) {
+		t.Errorf("unknown preference: got %v, want ErrUnknownSuite", err)
+	}
+}
+
+// The default suite must derive exactly what the package-level functions do,
+// so conversations that predate suites keep working.
+func TestSuite_DefaultMatchesPackageFunctions(t *testing.T) {
+	s, err := crypto.LookupSuite(crypto.SuiteSHA256)
+	if err != nil {
+		t.Fatalf("LookupSuite: %v", err)
+	}
+	secret := bytes.Repeat([]byte{0x11}, 32)
+	root := bytes.Repeat([]byte{0x22}, 32)
+
+	got, _ := s.DeriveX3DHRoot(secret)
+	want, _ := crypto.DeriveX3DHRoot(secret)
+	if !bytes.Equal(got, want) {
+		t.Fatal("DeriveX3DHRoot differs from the package function")
+	}
+	gotRK, gotCK, _ := s.DeriveRootAndChain(root, secret)
+	wantRK, wantCK, _ := crypto.DeriveRootAndChain(root, secret)
+	if !bytes.Equal(gotRK, wantRK) || !bytes.Equal(gotCK, wantCK) {
+		t.Fatal("DeriveRootAndChain differs from the package function")
+	}
+}
+
+// HKDF-SHA512 over the inputs of RFC 5869, Appendix A.1.
+func TestSuite_SHA512HKDF(t *testing.T) {
+	s, err := crypto.LookupSuite(crypto.SuiteSHA512)
+	if err != nil {
+		t.Fatalf("LookupSuite: %v", err)
+	}
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	salt := unhex(t, "000102030405060708090a0b0c")
+	info := string(unhex(t, "f0f1f2f3f4f5f6f7f8f9"))
+	want := unhex(t, "832390086cda71fb47625bb5ceb168e4c8e26a1a16ed34d9fc7fe92c1481579338da362cb8d9f925d7cb")
+
+	okm, err := s.HKDF(ikm, salt, info, len(want))
+	if err != nil {
+		t.Fatalf("HKDF: %v", err)
+	}
+	if !bytes.Equal(okm, want) {
+		t.Fatalf("OKM = %x, want %x", okm, want)
+	}
+
+	sha256, _ := crypto.LookupSuite(crypto.SuiteSHA256)
+	a, _ := sha256.DeriveMessageNonce(ikm)
+	b, _ := s.DeriveMessageNonce(ikm)
+	if bytes.Equal(a, b) {
+		t.Fatal("SHA-256 and SHA-512 suites derived the same nonce")
+	}
+}
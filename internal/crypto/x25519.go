@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
 
 	"golang.org/x/crypto/curve25519"
@@ -41,3 +42,16 @@ func ClampX25519PrivateKey(k *domain.X25519Private) {
 	kb[31] &= 127
 	kb[31] |= 64
 }
+
+// X25519FromSeed deterministically derives a clamped X25519 keypair from seed as
+// SHA-256(seed). It is intended for test vectors only, never for real keys.
+func X25519FromSeed(seed []byte) (priv domain.X25519Private, pub domain.X25519Public, err error) {
+	priv = sha256.Sum256(seed)
+	ClampX25519PrivateKey(&priv)
+	pubBytes, err := curve25519.X25519(priv.Slice(), curve25519.Basepoint)
+	if err != nil {
+		return priv, pub, fmt.Errorf("x25519: compute public key: %w", err)
+	}
+	copy(pub[:], pubBytes)
+	return priv, pub, nil
+}
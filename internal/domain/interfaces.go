@@ -1,6 +1,11 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
 
 // IdentityStore persists your long-term identity keys.
 type IdentityStore interface {
@@ -11,17 +16,23 @@ type IdentityStore interface {
 // PrekeyStore manages signed and one-time prekeys on disk.
 type PrekeyStore interface {
 	// Signed prekey
-	SaveSignedPrekey(id string, priv X25519Private, pub X25519Public, sig []byte) error
-	LoadSignedPrekey(id string) (priv X25519Private, pub X25519Public, sig []byte, ok bool, err error)
+	SaveSignedPrekey(id KeyID, priv X25519Private, pub X25519Public, sig []byte) error
+	LoadSignedPrekey(id KeyID) (priv X25519Private, pub X25519Public, sig []byte, ok bool, err error)
 
 	// One-time prekeys
 	SaveOneTimePrekeys(pairs []OneTimePair) error
-	ConsumeOneTimePrekey(id string) (priv X25519Private, pub X25519Public, ok bool, err error)
+	LoadOneTimePrekey(id KeyID) (priv X25519Private, pub X25519Public, ok bool, err error)
+	ConsumeOneTimePrekey(id KeyID) (priv X25519Private, pub X25519Public, ok bool, err error)
 	ListOneTimePrekeyPublics() ([]OneTimePub, error)
 
 	// Current signed prekey selection
-	SetCurrentSignedPrekeyID(id string) error
-	CurrentSignedPrekeyID() (string, bool, error)
+	SetCurrentSignedPrekeyID(id KeyID) error
+	CurrentSignedPrekeyID() (KeyID, bool, error)
+
+	// Upkeep: what is held, when it was generated and consumed, and when
+	// the session policy's upkeep next runs
+	PrekeyStatus() (PrekeyStatus, error)
+	SavePrekeySchedule(sched PrekeySchedule) error
 }
 
 // PrekeyBundleStore caches the last bundle you registered.
@@ -34,12 +45,197 @@ type PrekeyBundleStore interface {
 type SessionStore interface {
 	SaveSession(peer string, sess Session) error
 	LoadSession(peer string) (Session, bool, error)
+	ListSessions() ([]Session, error)
+	DeleteSession(peer string) (bool, error)
 }
 
 // RatchetStore keeps per-peer Double-Ratchet state.
+//
+// LockConversation serialises whole transactions on one peer's conversation,
+// across processes: whoever loads a conversation to take a ratchet step
+// holds it until the advanced state is saved (and, for a send, the envelope
+// posted), so two commands can never use the same message key.
 type RatchetStore interface {
+	LockConversation(peer string) (unlock func(), err error)
 	SaveConversation(peer string, conv Conversation) error
 	LoadConversation(peer string) (Conversation, bool, error)
+	ListConversations() ([]Conversation, error)
+	DeleteConversation(peer string) (bool, error)
+}
+
+// QuarantineStore keeps envelopes that failed to decrypt for later retry.
+type QuarantineStore interface {
+	SaveQuarantined(q QuarantinedEnvelope) error
+	ListQuarantined() ([]QuarantinedEnvelope, error)
+	DeleteQuarantined(id string) (bool, error)
+}
+
+// HistoryStore keeps sent, received and imported messages, encrypted at rest
+// under the identity passphrase.
+type HistoryStore interface {
+	// AppendHistory adds entries, skipping any whose ID is already stored,
+	// and returns how many were added.
+	AppendHistory(passphrase string, entries []HistoryEntry) (int, error)
+	// LoadHistory returns every entry, oldest first.
+	LoadHistory(passphrase string) ([]HistoryEntry, error)
+	// DeleteHistory removes every entry with peer and returns how many were
+	// removed.
+	DeleteHistory(passphrase, peer string) (int, error)
+	// PruneHistory removes the entries r no longer keeps at now and returns
+	// how many were removed.
+	PruneHistory(passphrase string, r Retention, now int64) (int, error)
+	// RewrapHistoryKey re-encrypts the key the history is encrypted with
+	// under next instead of current. locked records that next is the
+	// history's own passphrase rather than the identity's.
+	RewrapHistoryKey(current, next string, locked bool) error
+	// HistoryLocked reports whether the history key is encrypted under its
+	// own passphrase.
+	HistoryLocked() (bool, error)
+}
+
+// AccountStore records the relays we are registered on, keyed by (server, username).
+type AccountStore interface {
+	SaveAccount(a Account) error
+	ListAccounts() ([]Account, error)
+}
+
+// PreferenceStore persists per-conversation notification preferences.
+type PreferenceStore interface {
+	SavePreferences(p ConversationPrefs) error
+	LoadPreferences(peer string) (ConversationPrefs, bool, error)
+	ListPreferences() ([]ConversationPrefs, error)
+}
+
+// HeldStore keeps decrypted messages the receive filters refused, encrypted
+// under the passphrase.
+type HeldStore interface {
+	SaveHeld(passphrase string, h HeldMessage) error
+	ListHeld(passphrase string) ([]HeldMessage, error)
+	DeleteHeld(passphrase, id string) (bool, error)
+}
+
+// DraftStore keeps unsent messages written with compose, one per peer,
+// encrypted under the passphrase.
+type DraftStore interface {
+	SaveDraft(passphrase string, d Draft) error
+	LoadDraft(passphrase, peer string) (Draft, bool, error)
+	ListDrafts(passphrase string) ([]Draft, error)
+	DeleteDraft(passphrase, peer string) (bool, error)
+}
+
+// SettingsStore persists global client settings.
+type SettingsStore interface {
+	LoadSettings() (Settings, error)
+	SaveSettings(s Settings) error
+}
+
+// ContactStore persists contacts whose identity was verified by pairing.
+type ContactStore interface {
+	SaveContact(c Contact) error
+	LoadContact(username string) (Contact, bool, error)
+	ListContacts() ([]Contact, error)
+}
+
+// IdentityKeyIndex finds the peers stored under an identity key across
+// contacts, sessions and conversations.
+type IdentityKeyIndex interface {
+	PeersWithIdentity(ik X25519Public) ([]string, error)
+}
+
+// RelayCacheStore caches the relays discovered for address hosts, keyed by
+// host.
+type RelayCacheStore interface {
+	SaveResolvedRelay(r ResolvedRelay) error
+	LoadResolvedRelay(host string) (ResolvedRelay, bool, error)
+}
+
+// AttestationStore persists attestations about our own identity received
+// from contacts, keyed by the attester's identity key.
+type AttestationStore interface {
+	SaveAttestation(a Attestation) error
+	ListAttestations() ([]Attestation, error)
+}
+
+// ProfileStore persists our own profile and the latest profile each peer
+// sent, keyed by peer.
+type ProfileStore interface {
+	SaveOwnProfile(p Profile) error
+	LoadOwnProfile() (Profile, bool, error)
+	SavePeerProfile(p Profile) error
+	LoadPeerProfile(peer string) (Profile, bool, error)
+	ListPeerProfiles() ([]Profile, error)
+}
+
+// OutboxStore journals the messages the relay accepted, per peer. It holds
+// no plaintext, only the sealed envelopes of the newest entries.
+type OutboxStore interface {
+	AppendSent(peer string, m SentMessage) error
+	ListSent(peer string) ([]SentMessage, error)
+	// MarkExpired records at as when the entries numbered seqs by the relay
+	// known by any of the names relays expired unfetched, and returns the
+	// entries it found.
+	MarkExpired(relays []string, seqs []uint64, at int64) ([]UndeliveredMessage, error)
+	DeleteSent(peer string) (bool, error)
+}
+
+// RatchetTraceStore keeps the most recent ratchet steps per peer, encrypted at
+// rest under the identity passphrase, while ratchet debugging is on.
+type RatchetTraceStore interface {
+	// AppendRatchetStep adds step to peer's trace, dropping the oldest steps
+	// beyond the store's bound.
+	AppendRatchetStep(passphrase, peer string, step RatchetStep) error
+	// LoadRatchetSteps returns peer's trace, oldest first.
+	LoadRatchetSteps(passphrase, peer string) ([]RatchetStep, error)
+	// DeleteRatchetSteps removes peer's trace, or every trace if peer is
+	// empty, and reports how many traces were removed.
+	DeleteRatchetSteps(peer string) (int, error)
+}
+
+// ChunkStore keeps the parts of chunked messages received so far, per peer,
+// encrypted at rest under the identity passphrase.
+type ChunkStore interface {
+	// SaveChunk adds part to peer's partial messages and returns the parts of
+	// its message held so far, ordered by index.
+	SaveChunk(passphrase, peer string, part ChunkPart) ([]ChunkPart, error)
+	// DeleteChunks drops the parts of message id from peer.
+	DeleteChunks(passphrase, peer, id string) error
+}
+
+// ArchiveStore keeps archived conversations, one file per peer encrypted
+// under the identity passphrase, apart from the state loaded on every run.
+type ArchiveStore interface {
+	SaveArchive(passphrase string, a ConversationArchive) error
+	LoadArchive(passphrase, peer string) (ConversationArchive, bool, error)
+	// ListArchives decrypts every archive; it is only meant for listing.
+	ListArchives(passphrase string) ([]ConversationArchive, error)
+	DeleteArchive(peer string) (bool, error)
+	// Archived reports whether peer's conversation is archived, without
+	// decrypting anything.
+	Archived(peer string) (bool, error)
+}
+
+// BroadcastStore persists broadcast lists, keyed by name.
+type BroadcastStore interface {
+	SaveBroadcast(l BroadcastList) error
+	LoadBroadcast(name string) (BroadcastList, bool, error)
+	ListBroadcasts() ([]BroadcastList, error)
+	DeleteBroadcast(name string) (bool, error)
+}
+
+// ActionStore keeps the local log of state-changing commands, oldest first.
+type ActionStore interface {
+	// AppendAction records a under the next free ID and returns it.
+	AppendAction(a Action) (Action, error)
+	ListActions() ([]Action, error)
+	// MarkActionUndone records that action id was undone at utc.
+	MarkActionUndone(id int, utc int64) (bool, error)
+}
+
+// Sealer encrypts data under a passphrase, for files that leave the home
+// directory.
+type Sealer interface {
+	Seal(passphrase string, plain []byte) ([]byte, error)
+	Open(passphrase string, sealed []byte) ([]byte, error)
 }
 
 // IdentityService creates, retrieves, and inspects your identity keys.
@@ -47,32 +243,580 @@ type IdentityService interface {
 	GenerateIdentity(passphrase string) (Identity, string, error)
 	LoadIdentity(passphrase string) (Identity, error)
 	FingerprintIdentity(passphrase string) (string, error)
+	RotateSigningKey(passphrase string) (Ed25519Public, error)
 }
 
 // PrekeyService generates and assembles your prekey bundles.
 type PrekeyService interface {
 	GenerateAndStorePrekeys(passphrase string, n int) (X25519Public, []X25519Public, error)
 	LoadPrekeyBundle(passphrase, username string) (PrekeyBundle, error)
+	// ExportPrekeyBundle returns username's bundle without one-time prekeys,
+	// for a peer to start a session from without fetching it from a relay.
+	ExportPrekeyBundle(passphrase, username string) (PrekeyBundle, error)
+	// RestoreSignedPrekey makes the signed prekey id current again and adds
+	// n fresh one-time prekeys, returning their publics.
+	RestoreSignedPrekey(passphrase string, id KeyID, n int) ([]X25519Public, error)
+	// Status summarises the prekeys held and their upkeep schedule.
+	Status() (PrekeyStatus, error)
+	// SaveSchedule records when prekey upkeep next runs.
+	SaveSchedule(sched PrekeySchedule) error
+}
+
+// AccountService registers our identity on one or more relays.
+type AccountService interface {
+	// Register answers proof-of-work challenges itself and asks solve for
+	// any other; a nil solve leaves them unanswered.
+	Register(ctx context.Context, passphrase, username string, servers []string, solve ChallengeSolver) ([]Account, error)
+	// RestorePrekeys republishes username's bundle to servers with the
+	// signed prekey id, an earlier one, current again.
+	RestorePrekeys(ctx context.Context, passphrase, username string, servers []string, id KeyID) ([]Account, error)
+	ListAccounts() ([]Account, error)
+	// SetEndpoints replaces the failover endpoints of every account on server;
+	// an empty list removes them.
+	SetEndpoints(server string, endpoints []string) ([]Account, error)
+	// Usage asks server ("" for the default relay) for the traffic it
+	// counted for username, newest month first.
+	Usage(ctx context.Context, passphrase, username, server string) ([]RelayUsage, error)
+	// AuditJournal fetches the queue journal server ("" for the default
+	// relay) keeps for username and checks it for dropped, altered and
+	// replayed envelopes.
+	AuditJournal(ctx context.Context, passphrase, username, server string) (JournalAudit, error)
+	// MaintainPrekeys republishes username's prekeys to every relay it has
+	// an account on if the session policy says they are due.
+	MaintainPrekeys(ctx context.Context, passphrase, username string) (PrekeyMaintenance, error)
+	// SetPresence publishes who may see when username last fetched their
+	// messages on server ("" for the default relay); contacts are the
+	// peers we hold a conversation or session with there.
+	SetPresence(ctx context.Context, passphrase, username, server string, vis PresenceVisibility) (PresencePolicy, error)
+	// Presence asks when each of peers, or every peer we hold a
+	// conversation or session with if none are given, was last seen.
+	// Peers who do not show it are left out.
+	Presence(ctx context.Context, passphrase, username string, peers []string) ([]Presence, error)
 }
 
 // SessionService establishes or retrieves an X3DH session.
 type SessionService interface {
 	InitiateSession(ctx context.Context, passphrase, peer string) (Session, error)
+	// InitiateSessionWithBundle runs the handshake of InitiateSession against
+	// bundle, obtained out of band, instead of fetching one from a relay.
+	InitiateSessionWithBundle(passphrase, peer string, bundle PrekeyBundle) (Session, error)
+	// RenewSession runs X3DH again against peer's current bundle and replaces
+	// the stored session. The bundle must carry peerIK.
+	RenewSession(ctx context.Context, passphrase, peer string, peerIK X25519Public) (Session, error)
 	GetSession(peer string) (Session, bool, error)
+	DeleteSession(peer string) (bool, error)
+	// AddContact stores the peer at an fp:<fingerprint> address as a contact,
+	// with the identity and signing keys of the bundle the fingerprint names.
+	AddContact(ctx context.Context, peer string) (Contact, error)
+}
+
+// ConversationService manages local per-conversation preferences such as
+// muting, notification previews and send policies.
+type ConversationService interface {
+	Mute(peer string, d time.Duration) (ConversationPrefs, error)
+	Unmute(peer string) (ConversationPrefs, error)
+	SetNotify(peer string, mode NotifyMode) (ConversationPrefs, error)
+	SetPreview(peer string, show bool) (ConversationPrefs, error)
+	Preferences(peer string) (ConversationPrefs, error)
+	ListPreferences() ([]ConversationPrefs, error)
+
+	// SetSendPolicy sets peer's policy; SendPolicyDefault defers to the global one.
+	SetSendPolicy(peer string, policy SendPolicy) (ConversationPrefs, error)
+	// SetAcceptWipe sets whether peer's remote wipe requests are honoured.
+	SetAcceptWipe(peer string, accept bool) (ConversationPrefs, error)
+	SetDefaultSendPolicy(policy SendPolicy) error
+	DefaultSendPolicy() (SendPolicy, error)
+	// EffectiveSendPolicy returns peer's policy, falling back to the global one.
+	EffectiveSendPolicy(peer string) (SendPolicy, error)
+	// SetCollectStats turns local ratchet statistics collection on or off.
+	SetCollectStats(on bool) error
+	CollectStats() (bool, error)
+	// SetRatchetDebug turns local recording of ratchet steps on or off.
+	SetRatchetDebug(on bool) error
+	RatchetDebug() (bool, error)
+	// SetOversizePolicy sets what happens to messages too large for one
+	// relay envelope.
+	SetOversizePolicy(p OversizePolicy) error
+	OversizePolicy() (OversizePolicy, error)
+	// SetReceiveFilters sets which received messages are held for review.
+	SetReceiveFilters(f ReceiveFilters) error
+	ReceiveFilters() (ReceiveFilters, error)
+	// SetRekeyPolicy sets when conversations we initiated are rekeyed.
+	SetRekeyPolicy(p RekeyPolicy) error
+	RekeyPolicy() (RekeyPolicy, error)
+	// SetResendAfter sets how long a gap in a conversation lasts before the
+	// peer is asked to resend the missing messages; zero never asks.
+	SetResendAfter(d time.Duration) error
+	ResendAfter() (time.Duration, error)
+	// SetWatchdogPolicy sets when a conversation whose peer stopped taking
+	// ratchet steps is reported as stuck.
+	SetWatchdogPolicy(p WatchdogPolicy) error
+	WatchdogPolicy() (WatchdogPolicy, error)
+	// SetSuite sets the cipher suite offered first in handshakes we start;
+	// "" is the default suite.
+	SetSuite(id string) error
+	Suite() (string, error)
+	// SetRetention sets how much history is kept for peer; nil defers to the
+	// global policy.
+	SetRetention(peer string, p *RetentionPolicy) (ConversationPrefs, error)
+	SetDefaultRetention(p RetentionPolicy) error
+	DefaultRetention() (RetentionPolicy, error)
+	// Retention returns the global policy with every per-peer override.
+	Retention() (Retention, error)
+	// SetSessionPolicy sets how new sessions and our prekeys are handled.
+	SetSessionPolicy(p SessionPolicy) error
+	SessionPolicy() (SessionPolicy, error)
+	// SetAcceptSession sets whether a new session from peer is accepted
+	// whatever the session policy says.
+	SetAcceptSession(peer string, accept bool) (ConversationPrefs, error)
+}
+
+// PairingService exchanges identity cards with another client over a
+// short-code PAKE channel and records the result as a verified contact.
+type PairingService interface {
+	NewCode() (string, error)
+	Pair(ctx context.Context, passphrase, me, code string, opener bool) (Contact, error)
+	ListContacts() ([]Contact, error)
+}
+
+// HistoryService reads the local message history and imports transcripts
+// exported from other messengers.
+type HistoryService interface {
+	// History returns the last limit entries with peer (all peers if peer is
+	// empty; no limit if limit <= 0), oldest first.
+	History(passphrase, peer string, limit int) ([]HistoryEntry, error)
+	// Import parses an exported transcript and stores its messages as
+	// imported entries. Messages already imported are skipped.
+	Import(passphrase string, in HistoryImport) (added, skipped int, err error)
+	// Prune removes the entries the retention policies no longer keep.
+	Prune(passphrase string) (int, error)
+	// Stats summarises the history with peer, with days and hours counted
+	// in loc.
+	Stats(passphrase, peer string, loc *time.Location) (HistoryStats, error)
+	// Polls returns the polls in the history, oldest first, tallied from
+	// the votes in it.
+	Polls(passphrase string) ([]PollTally, error)
+	// Lock gives the history its own passphrase, next, so the identity
+	// passphrase no longer opens it. current opens the history now.
+	Lock(current, next string) error
+	// Unlock has the identity passphrase open the history again. current
+	// is the history's own passphrase.
+	Unlock(current, passphrase string) error
+	// Locked reports whether the history has its own passphrase.
+	Locked() (bool, error)
+}
+
+// StatsService manages opt-in ratchet statistics and their anonymised export.
+type StatsService interface {
+	// SetEnabled turns collection on or off. Turning it off discards the
+	// counters collected so far.
+	SetEnabled(on bool) error
+	Enabled() (bool, error)
+	Export() (StatsExport, error)
+}
+
+// RatchetDebugService manages opt-in recording of ratchet steps, for
+// diagnosing conversations whose state diverged from the peer's.
+type RatchetDebugService interface {
+	// SetEnabled turns recording on or off. Turning it off deletes the steps
+	// recorded so far.
+	SetEnabled(on bool) error
+	Enabled() (bool, error)
+	// Steps returns the steps recorded for peer, oldest first.
+	Steps(passphrase, peer string) ([]RatchetStep, error)
+}
+
+// BroadcastService manages broadcast lists and sends a message to every
+// member of one over the existing pairwise sessions.
+type BroadcastService interface {
+	Create(name string, members []string) (BroadcastList, error)
+	AddMembers(name string, members []string) (BroadcastList, error)
+	RemoveMembers(name string, members []string) (BroadcastList, error)
+	Get(name string) (BroadcastList, error)
+	List() ([]BroadcastList, error)
+	Delete(name string) error
+	// Send encrypts body separately for each member and returns one result
+	// per member, in list order. The error is only for failures that stop
+	// the broadcast before any member is tried.
+	Send(ctx context.Context, passphrase, from, name string, body MessageBody, force bool, expires time.Duration) ([]BroadcastResult, error)
+}
+
+// DraftService keeps messages being composed and sends them once done.
+type DraftService interface {
+	// Draft returns the draft for peer, if any, after checking passphrase
+	// against the identity.
+	Draft(passphrase, peer string) (Draft, bool, error)
+	// Save stores text as the draft for peer, replacing any earlier one.
+	Save(passphrase, peer, text, contentType string) (Draft, error)
+	List(passphrase string) ([]Draft, error)
+	Discard(passphrase, peer string) (bool, error)
+	// Send sends the draft for peer: its text, then each attachment it
+	// references. The draft is deleted once everything is sent; after a
+	// partial failure it keeps only what was not.
+	Send(ctx context.Context, passphrase, from, peer string, force bool, expires time.Duration) (DraftSent, error)
+}
+
+// BackupService moves single conversations between machines as
+// passphrase-encrypted files.
+type BackupService interface {
+	// ExportConversation seals the session and ratchet state with peer under
+	// backupPassphrase. With remove, the local copy is deleted afterwards.
+	ExportConversation(passphrase, backupPassphrase, peer string, remove bool) ([]byte, error)
+	// ImportConversation opens an exported conversation and stores it. An
+	// existing conversation with the same peer is only replaced if replace is
+	// set.
+	ImportConversation(passphrase, backupPassphrase string, data []byte, replace bool) (ConversationBackup, error)
+	// Snapshot returns the current state with peer as an unsealed export.
+	Snapshot(passphrase, peer string) (ConversationBackup, error)
+	// OpenExport opens an exported conversation without storing it.
+	OpenExport(backupPassphrase string, data []byte) (ConversationBackup, error)
+	// DiffStates explains how two snapshots of a conversation diverge.
+	DiffStates(a, b ConversationBackup) StateDiff
+
+	// ArchiveConversation moves the session, ratchet state and history with
+	// peer into an encrypted archive. historyPassphrase opens the history.
+	ArchiveConversation(passphrase, historyPassphrase, peer string) (ConversationArchive, error)
+	// UnarchiveConversation restores an archived conversation and removes the
+	// archive.
+	UnarchiveConversation(passphrase, historyPassphrase, peer string) (ConversationArchive, error)
+	// ListArchives returns every archived conversation, oldest first.
+	ListArchives(passphrase string) ([]ConversationArchive, error)
+
+	// PushBackup seals the identity, sessions and contacts under passphrase
+	// and stores them on the default relay as username's backup.
+	PushBackup(ctx context.Context, passphrase, username string) (AccountBackup, error)
+	// RestoreBackup fetches username's backup from the default relay, opens
+	// it with passphrase and saves it into an empty home directory.
+	RestoreBackup(ctx context.Context, passphrase, username string) (AccountBackup, error)
+}
+
+// UndoService keeps the action log and reverts the newest action that can
+// be.
+type UndoService interface {
+	// Record appends a, stamped with the current time, to the action log.
+	Record(a Action) (Action, error)
+	// Actions returns the action log, newest first.
+	Actions() ([]Action, error)
+	// Blocker says why a cannot be undone now, or "" if it can.
+	Blocker(a Action) (string, error)
+	// Undo reverts the newest action not yet undone that can be, passing
+	// over the others. historyPassphrase opens an archived history.
+	Undo(ctx context.Context, passphrase, historyPassphrase string) (Action, error)
+}
+
+// CourierService packs messages as armored text for delivery without a relay,
+// such as by email or USB stick.
+type CourierService interface {
+	// Export encrypts body for to and armors the envelope. A non-empty
+	// password also seals it, hiding the sender, recipient and header.
+	Export(passphrase, from, to string, body MessageBody, force bool, password string) ([]byte, error)
+	// Import decrypts an armored envelope addressed to me.
+	Import(ctx context.Context, passphrase, me string, armored []byte, password string) (DecryptedMessage, error)
 }
 
 // MessageService encrypts, sends, fetches and decrypts messages.
 type MessageService interface {
-	SendMessage(ctx context.Context, passphrase, from, to string, plaintext []byte) error
-	ReceiveMessage(ctx context.Context, passphrase, me string, limit int) ([]DecryptedMessage, error)
+	// SendMessage posts body to to. A non-zero expires asks the relay to
+	// drop the envelope if to has not fetched it by then.
+	SendMessage(ctx context.Context, passphrase, from, to string, body MessageBody, force bool, expires time.Duration) error
+	PreviewMessage(passphrase, from, to string, body MessageBody, force bool) (MessagePreview, error)
+	// ReceiveMessage also reports how many envelopes for me the relay dropped
+	// unfetched because they expired, and which of mine expired before the
+	// peer fetched them.
+	ReceiveMessage(ctx context.Context, passphrase, me string, limit int) ([]DecryptedMessage, ReceiveReport, error)
+	// FollowMessages receives in a loop until ctx is cancelled, pacing its
+	// fetches within pacing, and processes envelopes the relay pushes as
+	// they arrive. Each batch is passed to handle with its report
+	// and any error ReceiveMessage would have returned; the loop stops with
+	// the error handle returns, if any.
+	FollowMessages(ctx context.Context, passphrase, me string, pacing FetchPacing, handle func(msgs []DecryptedMessage, report ReceiveReport, err error) error) error
+	SessionStatuses() ([]SessionStatus, error)
+	// RequestWipe asks peer to delete the conversation on both sides. Local
+	// data is kept until the peer's receipt arrives.
+	RequestWipe(ctx context.Context, passphrase, me, peer string) error
+	// RequestAudit sends peer a summary of the conversation state and asks
+	// for theirs. Each side reports the comparison as a local notice when
+	// the other's summary arrives.
+	RequestAudit(ctx context.Context, passphrase, me, peer string) error
+	// Ping sends peer a signed ping their client answers at once. The pong
+	// arrives as a local notice naming the returned ping ID.
+	Ping(ctx context.Context, passphrase, me, peer string) (string, error)
+	// ResetConversation deletes the local ratchet state with peer so the next
+	// message starts a fresh handshake. It reports whether there was any.
+	ResetConversation(peer string) (bool, error)
+	// Attest signs an attestation that the paired contact peer holds the
+	// identity key received when pairing, and sends it to them.
+	Attest(ctx context.Context, passphrase, me, peer string) (Attestation, error)
+	// Attestations returns the attestations contacts have sent about us.
+	Attestations() ([]Attestation, error)
+	// SentMessages returns the messages me sent peer that the relay
+	// accepted, oldest first, and whether peer has fetched each one.
+	SentMessages(ctx context.Context, me, peer string) ([]SentStatus, error)
+
+	// SetProfile saves p as our profile and sends it to every peer with a
+	// confirmed session. It returns the peers it was sent to; the others
+	// are reported together in the error.
+	SetProfile(ctx context.Context, passphrase, me string, p Profile) ([]string, error)
+	// Profile returns our own profile, if one was set; PeerProfiles the
+	// profiles peers sent us, ordered by peer.
+	Profile() (Profile, bool, error)
+	PeerProfiles() ([]Profile, error)
+
+	// Vote sends me's vote for option choice (from 0) in poll id: to the
+	// poll's creator, or to every participant if me created it. It returns
+	// the peers the vote was sent to.
+	Vote(ctx context.Context, passphrase, me, id string, choice int) ([]string, error)
+
+	// ExportEnvelope encrypts a message like SendMessage but returns the
+	// envelope instead of posting it; ImportEnvelope decrypts one delivered
+	// without a relay.
+	ExportEnvelope(passphrase, from, to string, body MessageBody, force bool) (Envelope, error)
+	ImportEnvelope(ctx context.Context, passphrase, me string, env Envelope) (DecryptedMessage, error)
+
+	// Quarantine management for envelopes that failed to decrypt.
+	ListQuarantined() ([]QuarantinedEnvelope, error)
+	RetryQuarantined(ctx context.Context, passphrase, me, id string) ([]DecryptedMessage, error)
+	DropQuarantined(id string) error
+
+	// Review of messages the receive filters held back. AcceptHeld records
+	// the message in the history and returns it.
+	ListHeld(passphrase string) ([]HeldMessage, error)
+	AcceptHeld(passphrase, id string) (DecryptedMessage, error)
+	DropHeld(passphrase, id string) error
+}
+
+var (
+	// ErrNotFound is wrapped by RelayClient implementations when the relay reports
+	// that a user or resource does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is wrapped by RelayClient implementations when the relay
+	// rejects a request because the resource is already in use.
+	ErrConflict = errors.New("conflict")
+	// ErrSuspended is wrapped by RelayClient implementations when the relay
+	// refuses a message because the sender or recipient is suspended.
+	ErrSuspended = errors.New("account suspended by the relay")
+	// ErrChallengeRequired is matched by a *ChallengeError.
+	ErrChallengeRequired = errors.New("relay requires a registration challenge")
+	// ErrHistoryLocked is returned by HistoryStore implementations when the
+	// history has its own passphrase and was asked to open with another.
+	ErrHistoryLocked = errors.New("history is locked with its own passphrase")
+	// ErrArchived is returned for a peer whose conversation is archived
+	// until it is unarchived.
+	ErrArchived = errors.New("conversation with peer is archived")
+	// ErrPushUnsupported is wrapped by RelayClient.Subscribe when the relay,
+	// or the HTTP transport in use, cannot push envelopes.
+	ErrPushUnsupported = errors.New("relay push not supported")
+)
+
+// ChallengeError is returned by RegisterPrekeyBundle when the relay will not
+// register a new username until Challenge is answered. Failed reports that an
+// answer was sent and rejected.
+type ChallengeError struct {
+	Challenge RegistrationChallenge
+	Failed    bool
+}
+
+func (e *ChallengeError) Error() string {
+	if e.Failed {
+		return fmt.Sprintf("relay rejected the %s registration challenge answer", e.Challenge.Kind)
+	}
+	return fmt.Sprintf("%v (%s)", ErrChallengeRequired, e.Challenge.Kind)
+}
+
+// Is makes errors.Is(err, ErrChallengeRequired) match.
+func (e *ChallengeError) Is(target error) bool { return target == ErrChallengeRequired }
+
+// RelayErrorCode is the machine-readable cause of a relay error response.
+// Codes are part of the relay API: a code never changes meaning, and new ones
+// may be added, so clients fall back to the HTTP status for codes they do not
+// know.
+type RelayErrorCode string
+
+// Relay error codes, grouped by the HTTP status they are sent with. Details
+// name the keys of RelayError.Details each code may carry.
+const (
+	// 400 Bad Request.
+	RelayCodeBadRequest        RelayErrorCode = "bad_request"        // body or request malformed
+	RelayCodeMissingField      RelayErrorCode = "missing_field"      // details: field
+	RelayCodeInvalidParameter  RelayErrorCode = "invalid_parameter"  // details: param
+	RelayCodeUsernameReserved  RelayErrorCode = "username_reserved"  // fp: usernames cannot be registered
+	RelayCodeRecipientMismatch RelayErrorCode = "recipient_mismatch" // envelope To differs from the path
+	RelayCodeFutureTimestamp   RelayErrorCode = "future_timestamp"   // details: max_skew
+	RelayCodeExpired           RelayErrorCode = "envelope_expired"   // expires_utc has passed
+	RelayCodeInconsistent      RelayErrorCode = "inconsistent"       // imported snapshot fails its checks; details: problem
+
+	// 401 Unauthorized.
+	RelayCodeAuthRequired  RelayErrorCode = "auth_required"   // signature or admin token missing
+	RelayCodeBadSignature  RelayErrorCode = "bad_signature"   // request signature does not verify
+	RelayCodeStaleRequest  RelayErrorCode = "stale_request"   // signed time too far from the relay's
+	RelayCodeBadAdminToken RelayErrorCode = "bad_admin_token" // admin token missing or wrong
+
+	// 403 Forbidden.
+	RelayCodeSuspended  RelayErrorCode = "account_suspended" // sender or recipient suspended
+	RelayCodeURLExpired RelayErrorCode = "url_expired"       // pre-signed URL invalid or expired
+
+	// 404 Not Found.
+	RelayCodeUserNotFound       RelayErrorCode = "user_not_found"      // details: user
+	RelayCodeUnknownFingerprint RelayErrorCode = "unknown_fingerprint" // no registered key has it
+	RelayCodeNotFound           RelayErrorCode = "not_found"           // details: resource
+
+	// 409 Conflict.
+	RelayCodeMailboxInUse   RelayErrorCode = "mailbox_in_use"  // pairing mailbox already opened
+	RelayCodeMailboxFull    RelayErrorCode = "mailbox_full"    // pairing mailbox holds its limit
+	RelayCodeNewerBackup    RelayErrorCode = "newer_backup"    // a newer backup is stored
+	RelayCodeNewerPresence  RelayErrorCode = "newer_presence"  // a newer presence policy is stored
+	RelayCodeBlobIncomplete RelayErrorCode = "blob_incomplete" // parts still missing
+	RelayCodeNotEmpty       RelayErrorCode = "not_empty"       // import into a relay that holds data
+	RelayCodeIdentityTaken  RelayErrorCode = "identity_taken"  // another user registered the identity key
+
+	// 413 Content Too Large.
+	RelayCodeTooLarge RelayErrorCode = "too_large" // details: field, limit
+
+	// 428 Precondition Required (see ChallengeError).
+	RelayCodeChallengeRequired RelayErrorCode = "challenge_required"
+	RelayCodeChallengeFailed   RelayErrorCode = "challenge_failed"
+
+	// 500 Internal Server Error, 502 Bad Gateway and 503 Service Unavailable.
+	// All of these are retryable.
+	RelayCodeInternal    RelayErrorCode = "internal_error"
+	RelayCodeStorage     RelayErrorCode = "storage_error"
+	RelayCodeUnavailable RelayErrorCode = "upstream_unavailable" // details: service
+	RelayCodeBusy        RelayErrorCode = "busy"                 // too many requests of this kind in progress
+)
+
+// Retryable reports whether a request refused with c may succeed if sent
+// again unchanged later.
+func (c RelayErrorCode) Retryable() bool {
+	switch c {
+	case RelayCodeInternal, RelayCodeStorage, RelayCodeUnavailable, RelayCodeBusy:
+		return true
+	}
+	return false
+}
+
+// RelayError is a structured relay error response, sent as
+// {"error": RelayError}. RelayClient implementations wrap one for every
+// error a relay answers with a code; relays that predate codes only get the
+// sentinel errors above. errors.Is matches ErrNotFound for any 404,
+// ErrConflict for any 409 and ErrSuspended for RelayCodeSuspended.
+type RelayError struct {
+	Status    int               `json:"-"` // HTTP status, filled in by the client
+	Code      RelayErrorCode    `json:"code"`
+	Message   string            `json:"message"`
+	Retryable bool              `json:"retryable,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+func (e *RelayError) Error() string {
+	if e.Message == "" {
+		return string(e.Code)
+	}
+	return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+// Is makes errors.Is match the sentinel errors for e's status and code.
+func (e *RelayError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Status == 404
+	case ErrConflict:
+		return e.Status == 409
+	case ErrSuspended:
+		return e.Code == RelayCodeSuspended
+	}
+	return false
+}
+
+// RelayCode returns the code of the RelayError err wraps, or "" if it wraps
+// none.
+func RelayCode(err error) RelayErrorCode {
+	var re *RelayError
+	if errors.As(err, &re) {
+		return re.Code
+	}
+	return ""
 }
 
+// ChallengeSolver returns the answer to a token or CAPTCHA registration
+// challenge from server, typically by asking the user.
+type ChallengeSolver func(ctx context.Context, server string, c RegistrationChallenge) (string, error)
+
 // RelayClient is how we talk to the central relay server, all with context.
 type RelayClient interface {
-	RegisterPrekeyBundle(ctx context.Context, b PrekeyBundle) error
-	FetchPrekeyBundle(ctx context.Context, username string) (PrekeyBundle, error)
+	// RegisterPrekeyBundle sends ans with b when ans.Kind is set. A relay
+	// that wants a challenge answered fails it with a *ChallengeError.
+	RegisterPrekeyBundle(ctx context.Context, b PrekeyBundle, ans ChallengeAnswer) error
+	// FetchPrekeyBundle lists the one-time prekeys the relay still offers
+	// only when signed with auth by username's owner; a zero auth is sent
+	// unsigned and gets the bundle without them.
+	FetchPrekeyBundle(ctx context.Context, username string, auth RequestAuth) (PrekeyBundle, error)
+	// CheckoutPrekeyBundle is FetchPrekeyBundle for starting a session: the
+	// bundle carries at most one one-time prekey, which the relay removes
+	// so it is handed to no one else.
+	CheckoutPrekeyBundle(ctx context.Context, username string) (PrekeyBundle, error)
+
+	// SendMessage returns the sequence number the relay assigned env, or 0
+	// if the relay does not report one.
+	SendMessage(ctx context.Context, env Envelope) (uint64, error)
+	// FetchMessages also returns what the relay reported about expired
+	// envelopes to and from username. A fetch signed with auth counts as
+	// username's activity for presence; a zero auth is sent unsigned.
+	FetchMessages(ctx context.Context, username string, limit int, auth RequestAuth) ([]Envelope, FetchReport, error)
+	// WaitMessages is FetchMessages, but when nothing is queued a relay
+	// that supports it holds the request open until an envelope arrives or
+	// wait passes. Older relays answer at once.
+	WaitMessages(ctx context.Context, username string, limit int, wait time.Duration, auth RequestAuth) ([]Envelope, FetchReport, error)
+	AckMessages(ctx context.Context, username string, ids []string) error
+	// QueueStats reports username's queue, counting only envelopes from
+	// sender from unless it is empty.
+	QueueStats(ctx context.Context, username, from string) (QueueStats, error)
+	// Subscribe opens a push connection on which the relay delivers each
+	// envelope queued for username from then on; they still need acking.
+	// The channel is closed when ctx ends or the connection is lost. The
+	// upgrade is signed with auth, as for FetchMessages; the relay refuses
+	// it unsigned.
+	Subscribe(ctx context.Context, username string, auth RequestAuth) (<-chan Envelope, error)
+
+	// Pairing mailboxes carry short-code pairing messages between two clients.
+	PostPairMessage(ctx context.Context, box, side string, body []byte, open bool) error
+	FetchPairMessages(ctx context.Context, box, side string, after int) ([][]byte, error)
+	ClosePairMailbox(ctx context.Context, box string) error
+
+	// ServerInfo returns the relay's build and protocol versions.
+	ServerInfo(ctx context.Context) (BuildInfo, error)
+
+	// PutBackup stores b as username's backup; FetchBackup returns it.
+	PutBackup(ctx context.Context, username string, b RelayBackup) error
+	FetchBackup(ctx context.Context, username string) (RelayBackup, error)
+
+	// Usage returns the traffic the relay counted for username, newest
+	// month first.
+	Usage(ctx context.Context, username string, auth RequestAuth) ([]RelayUsage, error)
+	// Journal returns up to a page of the queue journal the relay keeps for
+	// username, the events numbered after after, oldest first.
+	Journal(ctx context.Context, username string, after uint64, auth RequestAuth) ([]JournalEvent, error)
+
+	// PutPresence stores p as username's presence policy. Presence returns
+	// when username was last seen, asking as viewer, signed with auth, when
+	// viewer is not empty; a user who does not show it to viewer yields an
+	// error wrapping ErrNotFound.
+	PutPresence(ctx context.Context, username string, p PresencePolicy) error
+	Presence(ctx context.Context, username, viewer string, auth RequestAuth) (Presence, error)
+}
+
+// RelayDirectory resolves relay clients by base URL so messages can be routed
+// to whichever relay a contact is registered on.
+type RelayDirectory interface {
+	// Client returns a client for server; "" selects the default relay.
+	Client(server string) RelayClient
+	// Servers lists the default relay (if set) followed by every other relay we
+	// hold an account on, without duplicates.
+	Servers() ([]string, error)
+}
 
-	SendMessage(ctx context.Context, env Envelope) error
-	FetchMessages(ctx context.Context, username string, limit int) ([]Envelope, error)
-	AckMessages(ctx context.Context, username string, count int) error
+// RelayResolver discovers the relay serving the host of a user@host address,
+// so peers on relays we hold no account on can be reached without --relay.
+type RelayResolver interface {
+	// ResolveRelay returns the base URL of host's relay.
+	ResolveRelay(ctx context.Context, host string) (string, error)
 }
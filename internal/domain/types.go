@@ -1,5 +1,7 @@
 package domain
 
+import "time"
+
 // X25519Public is a Curve25519 public key.
 type X25519Public [32]byte
 
@@ -25,36 +27,76 @@ type Ed25519Private [64]byte
 func (k Ed25519Private) Slice() []byte { return k[:] }
 
 // Identity holds your long-term X25519 and Ed25519 keys.
+//
+// The X25519 key is the identity; the Ed25519 signing key may be rotated
+// independently. SignChain records every rotation, oldest first, so peers who
+// pinned an earlier signing key can follow it to the current EdPub.
 type Identity struct {
-	XPub   X25519Public   `json:"xpub"`
-	XPriv  X25519Private  `json:"xpriv"`
-	EdPub  Ed25519Public  `json:"edpub"`
-	EdPriv Ed25519Private `json:"edpriv"`
+	XPub      X25519Public   `json:"xpub"`
+	XPriv     X25519Private  `json:"xpriv"`
+	EdPub     Ed25519Public  `json:"edpub"`
+	EdPriv    Ed25519Private `json:"edpriv"`
+	SignChain []SignKeyLink  `json:"sign_chain,omitempty"`
+}
+
+// SigVersion records how the input to an Ed25519 signature was built, so
+// signatures made before domain separation still verify.
+type SigVersion int
+
+const (
+	// SigRaw signs the message bytes as they are. Only older clients produce
+	// it; it is accepted for verification during migration, except from a
+	// peer already seen with SigContext (see Session.PeerSigV).
+	SigRaw SigVersion = 0
+	// SigContext signs crypto.ContextMessage under a per-purpose label.
+	SigContext SigVersion = 1
+	// SigSuites is SigContext over a signed prekey together with the
+	// bundle's cipher suites, so they cannot be stripped (see package x3dh).
+	SigSuites SigVersion = 2
+)
+
+// SignKeyLink is a cross-signature: Prev, the outgoing signing key, signs Next,
+// its replacement, bound to the X25519 identity key.
+type SignKeyLink struct {
+	Prev       Ed25519Public `json:"prev"`
+	Next       Ed25519Public `json:"next"`
+	CreatedUTC int64         `json:"created_utc"`
+	Sig        []byte        `json:"sig"`
+	SigV       SigVersion    `json:"sig_v,omitempty"`
 }
 
+// KeyID names a signed or one-time prekey. Package keyid generates and
+// checks them.
+type KeyID string
+
 // OneTimePair is the full (private+public) one-time prekey stored locally.
 type OneTimePair struct {
-	ID   string        `json:"id"`
+	ID   KeyID         `json:"id"`
 	Priv X25519Private `json:"priv"`
 	Pub  X25519Public  `json:"pub"`
 }
 
 // OneTimePub is only the public half (sent in bundles).
 type OneTimePub struct {
-	ID  string       `json:"id"`
+	ID  KeyID        `json:"id"`
 	Pub X25519Public `json:"pub"`
 }
 
 // PrekeyBundle is the set of public keys you register with the relay.
 // SignedPrekeySig is base64-encoded automatically.
 type PrekeyBundle struct {
-	Username        string        `json:"username"`
-	IdentityKey     X25519Public  `json:"identity_key"`
-	SignKey         Ed25519Public `json:"sign_key"`
-	SPKID           string        `json:"spk_id"`
-	SignedPrekey    X25519Public  `json:"signed_prekey"`
-	SignedPrekeySig []byte        `json:"signed_prekey_sig"`
-	OneTime         []OneTimePub  `json:"one_time,omitempty"`
+	Username         string        `json:"username"`
+	IdentityKey      X25519Public  `json:"identity_key"`
+	SignKey          Ed25519Public `json:"sign_key"`
+	SPKID            KeyID         `json:"spk_id"`
+	SignedPrekey     X25519Public  `json:"signed_prekey"`
+	SignedPrekeySig  []byte        `json:"signed_prekey_sig"`
+	SignedPrekeySigV SigVersion    `json:"signed_prekey_sig_v,omitempty"`
+	OneTime          []OneTimePub  `json:"one_time,omitempty"`
+	SignChain        []SignKeyLink `json:"sign_chain,omitempty"`   // rotations leading to SignKey
+	Capabilities     []string      `json:"capabilities,omitempty"` // optional features the client supports; see package caps
+	Attestations     []Attestation `json:"attestations,omitempty"` // contacts vouching for Username and IdentityKey; see package attest
+	Suites           []string      `json:"suites,omitempty"`       // cipher suite IDs the client supports, in its order; none for clients that predate suites
 }
 
 // PrekeyMessage carries the X3DH handshake parameters in your first
@@ -62,9 +104,10 @@ type PrekeyBundle struct {
 type PrekeyMessage struct {
 	InitiatorIK   X25519Public `json:"initiator_ik"`
 	Ephemeral     X25519Public `json:"ephemeral"`
-	SPKID         string       `json:"spk_id"`
-	OPKID         string       `json:"opk_id,omitempty"`
+	SPKID         KeyID        `json:"spk_id"`
+	OPKID         KeyID        `json:"opk_id,omitempty"`
 	TranscriptSHA []byte       `json:"transcript_sha,omitempty"`
+	Suite         string       `json:"suite,omitempty"` // cipher suite the initiator chose; "" for the default
 }
 
 // RatchetHeader is sent alongside every ciphertext.
@@ -75,40 +118,1092 @@ type RatchetHeader struct {
 }
 
 // Envelope is the wire-format message you post/get from the relay.
+//
+// ID is assigned by the relay when the envelope is queued and is used to
+// acknowledge it; any value set by the sender is replaced.
+//
+// HeaderMAC authenticates From, To, AD and Header under the conversation's
+// header key, so a recipient can discard junk before decrypting anything.
+//
+// ExpiresUTC, if set, asks the relay to drop the envelope when it is still
+// unfetched at that time. It sits outside the ciphertext and is not
+// authenticated: a relay may ignore it, just as it may drop any envelope.
 type Envelope struct {
-	From      string         `json:"from"`
-	To        string         `json:"to"`
-	Header    RatchetHeader  `json:"header"`
-	Cipher    []byte         `json:"cipher"`
-	AD        []byte         `json:"ad,omitempty"`
-	Prekey    *PrekeyMessage `json:"prekey,omitempty"`
-	Timestamp int64          `json:"timestamp"`
+	ID         string         `json:"id,omitempty"`
+	From       string         `json:"from"`
+	To         string         `json:"to"`
+	Header     RatchetHeader  `json:"header"`
+	Cipher     []byte         `json:"cipher"`
+	AD         []byte         `json:"ad,omitempty"`
+	Prekey     *PrekeyMessage `json:"prekey,omitempty"`
+	Timestamp  int64          `json:"timestamp"`
+	ExpiresUTC int64          `json:"expires_utc,omitempty"`
+	HeaderMAC  []byte         `json:"header_mac,omitempty"`
+	AckedUTC   int64          `json:"acked_utc,omitempty"` // set by the relay on envelopes fetched again after an ack
 }
 
 // Session holds the X3DH-derived root key and metadata for a peer.
 type Session struct {
-	Peer        string       `json:"peer"`
-	RootKey     []byte       `json:"root_key"`
-	PeerSPK     X25519Public `json:"peer_spk"`
-	PeerIK      X25519Public `json:"peer_ik"`
-	CreatedUTC  int64        `json:"created_utc"`
-	SPKID       string       `json:"spk_id"`
-	OPKID       string       `json:"opk_id"`
-	InitiatorEK X25519Public `json:"initiator_ek"`
+	Peer        string        `json:"peer"`
+	RootKey     []byte        `json:"root_key"`
+	PeerSPK     X25519Public  `json:"peer_spk"`
+	PeerIK      X25519Public  `json:"peer_ik"`
+	CreatedUTC  int64         `json:"created_utc"`
+	SPKID       KeyID         `json:"spk_id"`
+	OPKID       KeyID         `json:"opk_id"`
+	InitiatorEK X25519Public  `json:"initiator_ek"`
+	Relay       string        `json:"relay,omitempty"`      // relay the peer's bundle came from
+	PeerSignKey Ed25519Public `json:"peer_sign_key"`        // pinned; later bundles must chain to it
+	PeerSigV    SigVersion    `json:"peer_sig_v,omitempty"` // pinned; later bundles may not sign their SPK in an older form
+	PeerCaps    []string      `json:"peer_caps,omitempty"`  // capabilities from the peer's bundle
+	SpentOPKs   []KeyID       `json:"spent_opks,omitempty"` // peer OPKs used by earlier handshakes; rekeys skip them
+	VouchedBy   []string      `json:"vouched_by,omitempty"` // our contacts whose attestations in the bundle verified
+	Suite       string        `json:"suite,omitempty"`      // cipher suite chosen for the handshake; "" for the default
+
+	// SharesIdentity lists the other peers we knew under PeerIK when the
+	// session was made: the same person on several accounts, or one of
+	// them impersonating the other.
+	SharesIdentity []string `json:"shares_identity,omitempty"`
+}
+
+// Account records a username registered on a relay. Accounts are keyed by
+// (Server, Username), so one identity may be registered on several relays.
+//
+// Endpoints are other base URLs for the same relay, such as regional names
+// behind GeoDNS, that serve the same queues as Server. The client fails over
+// to them when Server is down; Active is the one that last answered.
+type Account struct {
+	Server        string       `json:"server"`
+	Username      string       `json:"username"`
+	IdentityKey   X25519Public `json:"identity_key"`
+	RegisteredUTC int64        `json:"registered_utc"`
+	Endpoints     []string     `json:"endpoints,omitempty"`
+	Active        string       `json:"active,omitempty"`
+}
+
+// ChallengeKind names a registration challenge a relay can set.
+type ChallengeKind string
+
+const (
+	// ChallengeToken asks for an invitation token from the relay operator.
+	ChallengeToken ChallengeKind = "token"
+	// ChallengePoW asks for a proof-of-work over Nonce (see package pow).
+	ChallengePoW ChallengeKind = "pow"
+	// ChallengeCAPTCHA asks for the response token of a CAPTCHA solved at URL.
+	ChallengeCAPTCHA ChallengeKind = "captcha"
+	// ChallengeSignature asks the owner of a registered username to sign
+	// Nonce and the new bundle (see package relayauth).
+	ChallengeSignature ChallengeKind = "signature"
+)
+
+// RegistrationChallenge is what a relay asks a client to answer before it
+// accepts the registration of a new username, or an update of a registered
+// one. Nonce and Bits are set for ChallengePoW; SiteKey and URL for
+// ChallengeCAPTCHA; Nonce for ChallengeSignature.
+type RegistrationChallenge struct {
+	Kind    ChallengeKind `json:"kind"`
+	Nonce   string        `json:"nonce,omitempty"`
+	Bits    int           `json:"bits,omitempty"`
+	SiteKey string        `json:"site_key,omitempty"`
+	URL     string        `json:"url,omitempty"`
+}
+
+// ChallengeAnswer answers a RegistrationChallenge. Nonce echoes the
+// challenge's, if any. The zero value is no answer.
+type ChallengeAnswer struct {
+	Kind   ChallengeKind
+	Nonce  string
+	Answer string
 }
 
+// ConfirmState records whether a conversation's handshake has been confirmed.
+type ConfirmState string
+
+const (
+	// ConfirmPending means no confirmation has been exchanged yet.
+	ConfirmPending ConfirmState = "pending"
+	// ConfirmOK means both parties agree on each other's identity fingerprints.
+	ConfirmOK ConfirmState = "confirmed"
+	// ConfirmMismatch means the peer reported fingerprints that differ from ours.
+	ConfirmMismatch ConfirmState = "mismatch"
+)
+
 // Conversation persists the ratchet state for a peer.
 type Conversation struct {
-	Peer  string       `json:"peer"`
-	State RatchetState `json:"state"`
+	Peer      string       `json:"peer"`
+	State     RatchetState `json:"state"`
+	Confirm   ConfirmState `json:"confirm,omitempty"`
+	Initiator bool         `json:"initiator,omitempty"` // we sent the PrekeyMessage
+	PeerIK    X25519Public `json:"peer_ik"`             // zero for conversations saved before it was recorded
+
+	// Stale is the responder state of the peer's own handshake after both
+	// sides initiated at once and ours won. It only decrypts messages the
+	// peer sent before switching, and is dropped once the peer confirms.
+	Stale *RatchetState `json:"stale,omitempty"`
+
+	// Stats counts protocol events while statistics collection is on. It is
+	// nil when collection is off.
+	Stats *RatchetStats `json:"stats,omitempty"`
+
+	// WipeRequested is the ID of a remote wipe we asked the peer for and
+	// have not yet had a receipt for.
+	WipeRequested string `json:"wipe_requested,omitempty"`
+
+	// Rekeying: when the root key was last replaced by a fresh X3DH, how many
+	// messages have been sent or received since, and how often it happened.
+	RekeyedUTC int64 `json:"rekeyed_utc,omitempty"` // zero: since the session was created
+	SinceRekey int   `json:"since_rekey,omitempty"`
+	Rekeys     int   `json:"rekeys,omitempty"`
+
+	// HeaderMACs is set once the peer has sent an envelope with a valid
+	// header MAC. From then on envelopes without one are rejected unread.
+	HeaderMACs bool `json:"header_macs,omitempty"`
+
+	// MessagesSent and MessagesReceived count every ratchet message, control
+	// messages included, since the conversation began. Unlike Stats they are
+	// always kept; state audits compare them with the peer's.
+	MessagesSent     int `json:"messages_sent,omitempty"`
+	MessagesReceived int `json:"messages_received,omitempty"`
+
+	// GapSinceUTC is when the state first held skipped keys for messages
+	// that have not arrived; zero while nothing is missing. ResendAskedUTC
+	// is when we last asked the peer to resend them.
+	GapSinceUTC    int64 `json:"gap_since_utc,omitempty"`
+	ResendAskedUTC int64 `json:"resend_asked_utc,omitempty"`
+
+	// StartedUTC is when the session was set up; zero for conversations
+	// saved before it was recorded.
+	StartedUTC int64 `json:"started_utc,omitempty"`
+
+	// Unanswered counts the ratchet messages we sent since the peer last
+	// took a DH ratchet step, which it only does once it has read one of
+	// ours; UnansweredSinceUTC is when the first of them was sent. Both are
+	// zero again once the peer steps (see WatchdogPolicy).
+	Unanswered         int   `json:"unanswered,omitempty"`
+	UnansweredSinceUTC int64 `json:"unanswered_since_utc,omitempty"`
+}
+
+// ConversationBackup is one conversation's session and ratchet state, as
+// moved between machines by `sessions export` and `sessions import`. It holds
+// live key material and is only ever written encrypted.
+type ConversationBackup struct {
+	Version      int          `json:"version"`
+	Peer         string       `json:"peer"`
+	OwnerIK      X25519Public `json:"owner_ik"` // identity the state belongs to
+	ExportedUTC  int64        `json:"exported_utc"`
+	Session      *Session     `json:"session,omitempty"` // nil if the peer initiated
+	Conversation Conversation `json:"conversation"`
+}
+
+// ConversationArchive is a dormant conversation moved out of the hot-path
+// state by `conversations archive`: its session, ratchet state and history,
+// kept in one file encrypted under the identity passphrase until
+// `conversations unarchive` restores them.
+type ConversationArchive struct {
+	Version      int            `json:"version"`
+	Peer         string         `json:"peer"`
+	OwnerIK      X25519Public   `json:"owner_ik"` // identity the state belongs to
+	ArchivedUTC  int64          `json:"archived_utc"`
+	Session      *Session       `json:"session,omitempty"` // nil if the peer initiated
+	Conversation Conversation   `json:"conversation"`
+	History      []HistoryEntry `json:"history,omitempty"`
+}
+
+// StateRelation says how the two snapshots compared by a StateDiff relate.
+type StateRelation string
+
+const (
+	// RelationSameSide: both snapshots are one identity's state with the
+	// same peer, such as an old export and the current state.
+	RelationSameSide StateRelation = "same-side"
+	// RelationPeers: the snapshots are the two ends of one conversation.
+	RelationPeers StateRelation = "peers"
+	// RelationUnrelated: the snapshots belong to different conversations.
+	RelationUnrelated StateRelation = "unrelated"
+)
+
+// StateDiff explains how two snapshots of a conversation's state diverge,
+// for debugging peers who cannot decrypt each other. It never holds key
+// material: keys are compared, not copied.
+type StateDiff struct {
+	Relation StateRelation  `json:"relation"`
+	Findings []StateFinding `json:"findings"` // empty when nothing diverges
+}
+
+// StateFinding is one divergence between snapshots A and B: the field it is
+// about, each side's value where that is safe to show, and what it means.
+type StateFinding struct {
+	Field   string `json:"field"`
+	A       string `json:"a,omitempty"`
+	B       string `json:"b,omitempty"`
+	Explain string `json:"explain"`
+	Problem bool   `json:"problem,omitempty"` // explains a decryption failure rather than normal progress
+}
+
+// AccountBackup is what `backup push` stores on a relay: what a new machine
+// needs to carry on as Username. Ratchet state and prekeys are left out; see
+// package backup. It holds the private identity keys and is only ever
+// written encrypted.
+type AccountBackup struct {
+	Version    int       `json:"version"`
+	Username   string    `json:"username"`
+	CreatedUTC int64     `json:"created_utc"`
+	Identity   Identity  `json:"identity"`
+	Sessions   []Session `json:"sessions,omitempty"`
+	Contacts   []Contact `json:"contacts,omitempty"`
+}
+
+// RelayBackup is a sealed AccountBackup as stored on a relay. Sig is the
+// owner's signature over the username, UpdatedUTC and Data with the signing
+// key in their published bundle (see package relayauth).
+type RelayBackup struct {
+	Data       []byte `json:"data"`
+	UpdatedUTC int64  `json:"updated_utc"`
+	Sig        []byte `json:"sig"`
+}
+
+// RequestAuth signs a relay request about the caller's own account: Sig is
+// the owner's signature over the method, path, query and TimeUTC with the
+// signing key in their published bundle (see package relayauth).
+type RequestAuth struct {
+	TimeUTC int64
+	Sig     []byte
+}
+
+// RelayUsage is the traffic a relay counted for one user in one calendar
+// month (UTC). In counts the envelopes, bundles and backups the user
+// uploaded; out counts the envelopes and backups they fetched.
+type RelayUsage struct {
+	Month        string `json:"month"` // e.g. "2026-10"
+	BytesIn      int64  `json:"bytes_in"`
+	BytesOut     int64  `json:"bytes_out"`
+	EnvelopesIn  int    `json:"envelopes_in"`
+	EnvelopesOut int    `json:"envelopes_out"`
+}
+
+// PresenceVisibility says who a relay shows a user's last activity to.
+type PresenceVisibility string
+
+const (
+	PresenceNobody   PresenceVisibility = "nobody"   // the relay records none (the default)
+	PresenceContacts PresenceVisibility = "contacts" // only the users in PresencePolicy.Contacts
+	PresenceEveryone PresenceVisibility = "everyone" // anyone who asks
+)
+
+// PresencePolicy is a user's choice, stored on a relay, of who may see when
+// they last fetched their messages there. Contacts are usernames on that
+// relay. Sig is the owner's signature over the username, UpdatedUTC,
+// Visibility and Contacts with the signing key in their published bundle
+// (see package relayauth).
+type PresencePolicy struct {
+	Visibility PresenceVisibility `json:"visibility"`
+	Contacts   []string           `json:"contacts,omitempty"`
+	UpdatedUTC int64              `json:"updated_utc"`
+	Sig        []byte             `json:"sig"`
+}
+
+// Presence is when a relay last saw User fetch their messages, to the
+// minute.
+type Presence struct {
+	User        string `json:"user"`
+	LastSeenUTC int64  `json:"last_seen_utc"`
+}
+
+// JournalKind is what happened to an envelope in a relay's queue journal.
+type JournalKind string
+
+const (
+	JournalEnqueue JournalKind = "enqueue" // queued for the user
+	JournalFetch   JournalKind = "fetch"   // handed to the user
+	JournalAck     JournalKind = "ack"     // acked by the user and removed
+	JournalDrop    JournalKind = "drop"    // removed unfetched to keep the queue within its limits
+	JournalExpire  JournalKind = "expire"  // removed unfetched when its expiry passed
+)
+
+// JournalEvent is one entry of the queue journal a relay with persistent
+// storage keeps for each user. It names the envelope by ID and by the hash of
+// its ciphertext only, so the journal shows nothing the queue did not.
+type JournalEvent struct {
+	N       uint64      `json:"n"` // position in the user's journal, from 1
+	TimeUTC int64       `json:"time_utc"`
+	Kind    JournalKind `json:"kind"`
+	ID      string      `json:"id"`   // envelope ID
+	Hash    []byte      `json:"hash"` // SHA-256 of the envelope's ciphertext
+}
+
+// JournalAudit is what a client made of the queue journal a relay keeps for
+// its account: the events, how many there were of each kind and where the
+// relay dropped, altered or replayed envelopes.
+type JournalAudit struct {
+	Events   []JournalEvent
+	Enqueued int
+	Fetched  int
+	Acked    int
+	Dropped  int // dropped or expired unfetched
+	Findings []JournalFinding
+}
+
+// JournalFinding is one thing a journal audit found.
+type JournalFinding struct {
+	N       uint64 // the event it is about; 0 for the journal as a whole
+	ID      string // the envelope it is about, if any
+	Explain string
+	Problem bool // the relay misbehaved, rather than applied its limits
+}
+
+// StatsSizeBounds are the upper bounds, in bytes, of the ciphertext size
+// buckets in RatchetStats.CipherSizes. The last bucket holds everything
+// larger than the final bound.
+var StatsSizeBounds = []int{256, 1024, 4096, 16384, 65536}
+
+// RatchetStats holds content-free counters about one conversation's ratchet,
+// collected locally for research export. It never records bodies, peers or
+// timestamps.
+type RatchetStats struct {
+	Sent        int   `json:"sent,omitempty"`
+	Received    int   `json:"received,omitempty"`
+	DHSteps     int   `json:"dh_steps,omitempty"`     // received messages that advanced the DH ratchet
+	OutOfOrder  int   `json:"out_of_order,omitempty"` // received messages decrypted with a skipped key
+	SkippedMax  int   `json:"skipped_max,omitempty"`  // most skipped keys held at once
+	CipherSizes []int `json:"cipher_sizes,omitempty"` // counts per StatsSizeBounds bucket
+}
+
+// StatsExport is the anonymised statistics export. Conversations are labelled
+// c1, c2, ... in an order unrelated to the peers they stand for.
+type StatsExport struct {
+	Version       int                 `json:"version"`
+	SizeBounds    []int               `json:"size_bounds"`
+	Conversations []ConversationStats `json:"conversations"`
+}
+
+// ConversationStats is one conversation's row in a StatsExport.
+type ConversationStats struct {
+	Label       string `json:"conversation"`
+	Sent        int    `json:"sent"`
+	Received    int    `json:"received"`
+	DHSteps     int    `json:"dh_steps"`
+	OutOfOrder  int    `json:"out_of_order"`
+	SkippedNow  int    `json:"skipped_now"`
+	SkippedMax  int    `json:"skipped_max"`
+	CipherSizes []int  `json:"cipher_sizes"`
+}
+
+// RatchetOp is the ratchet operation a RatchetStep records.
+type RatchetOp string
+
+const (
+	RatchetEncrypt RatchetOp = "encrypt"
+	RatchetDecrypt RatchetOp = "decrypt"
+)
+
+// RatchetStep is one ratchet operation recorded while ratchet debugging is
+// on, for replaying how a conversation's state evolved. Header is the header
+// sent or received. After is nil when the operation failed, and Err says why.
+type RatchetStep struct {
+	TimeUTC int64             `json:"time_utc"`
+	Op      RatchetOp         `json:"op"`
+	Stale   bool              `json:"stale,omitempty"` // decrypted with the losing handshake's state
+	Control bool              `json:"control,omitempty"`
+	Header  RatchetHeader     `json:"header"`
+	Before  RatchetStepState  `json:"before"`
+	After   *RatchetStepState `json:"after,omitempty"`
+	Err     string            `json:"err,omitempty"`
+}
+
+// RatchetStepState is a ratchet state as a RatchetStep records it. Secret
+// keys are replaced by a short SHA-256 digest, enough to compare with the
+// peer's trace but useless for decrypting anything.
+type RatchetStepState struct {
+	RootKey   string       `json:"root_key"`
+	SendCK    string       `json:"send_ck,omitempty"`
+	RecvCK    string       `json:"recv_ck,omitempty"`
+	HeaderKey string       `json:"header_key,omitempty"`
+	DHPub     X25519Public `json:"dh_pub"`
+	PeerDHPub X25519Public `json:"peer_dh_pub"`
+	Ns        uint32       `json:"ns"`
+	Nr        uint32       `json:"nr"`
+	PN        uint32       `json:"pn"`
+	Skipped   int          `json:"skipped"`
+}
+
+// IdentityCard is what a client sends about itself when pairing: the keys a
+// peer needs to recognise it later.
+type IdentityCard struct {
+	Username    string        `json:"username"`
+	IdentityKey X25519Public  `json:"identity_key"`
+	SignKey     Ed25519Public `json:"sign_key"`
+	SignChain   []SignKeyLink `json:"sign_chain,omitempty"`
+}
+
+// Contact is a peer whose identity keys were received over a pairing channel.
+// Sessions with the contact must use these keys.
+type Contact struct {
+	Username    string        `json:"username"`
+	IdentityKey X25519Public  `json:"identity_key"`
+	SignKey     Ed25519Public `json:"sign_key"`
+	PairedUTC   int64         `json:"paired_utc"`
+	Relay       string        `json:"relay,omitempty"` // relay discovered from the contact's user@host address
+}
+
+// Profile is how a user presents themselves to their peers: a display name
+// and an avatar, either an emoji or a small photo. Our own profile is sent to
+// each peer in a control message when a session with them is confirmed and
+// whenever it changes, so it is end-to-end encrypted like any message. The
+// relay never sees it.
+type Profile struct {
+	Peer        string `json:"peer,omitempty"`         // whose profile this is; set locally, "" for our own
+	DisplayName string `json:"display_name,omitempty"` // chosen by the peer; never trusted to identify them
+	Avatar      string `json:"avatar,omitempty"`       // an emoji or a few characters
+	Photo       []byte `json:"photo,omitempty"`        // small PNG, JPEG, GIF or WebP image
+	PhotoHash   string `json:"photo_hash,omitempty"`   // hex SHA-256 of Photo
+	UpdatedUTC  int64  `json:"updated_utc"`
+}
+
+// Empty reports whether p has nothing to show.
+func (p Profile) Empty() bool {
+	return p.DisplayName == "" && p.Avatar == "" && len(p.Photo) == 0
+}
+
+// ChunkPart is one received part of a chunked message (see package chunk).
+type ChunkPart struct {
+	ID          string `json:"id"`
+	Index       int    `json:"index"`
+	Total       int    `json:"total"`
+	Data        []byte `json:"data"`
+	ReceivedUTC int64  `json:"received_utc"`
+}
+
+// ResolvedRelay is a cached discovery of the relay serving the host of a
+// user@host address (see package address). Via is "well-known" or "srv".
+type ResolvedRelay struct {
+	Host        string `json:"host"`
+	Relay       string `json:"relay"`
+	Via         string `json:"via"`
+	ResolvedUTC int64  `json:"resolved_utc"`
+}
+
+// Attestation is a statement by Attester, signed with their signing key, that
+// the username Subject is bound to the identity key SubjectKey. AttesterChain
+// leads from the signing key the attester's contacts pinned to AttesterSignKey,
+// so attestations survive the attester rotating their signing key.
+type Attestation struct {
+	Subject         string        `json:"subject"`
+	SubjectKey      X25519Public  `json:"subject_key"`
+	Attester        string        `json:"attester"`
+	AttesterKey     X25519Public  `json:"attester_key"`
+	AttesterSignKey Ed25519Public `json:"attester_sign_key"`
+	AttesterChain   []SignKeyLink `json:"attester_chain,omitempty"`
+	CreatedUTC      int64         `json:"created_utc"`
+	Sig             []byte        `json:"sig"`
+}
+
+// SentMessage is the outbox journal entry for a message the relay accepted.
+// Seq is the sequence number the relay assigned, or zero if it reported none.
+// Envelope is the sealed envelope as posted, kept for the newest entries so
+// it can be posted again when the peer asks for a resend.
+type SentMessage struct {
+	Seq         uint64    `json:"seq,omitempty"`
+	Relay       string    `json:"relay,omitempty"` // "" for the default relay
+	SentUTC     int64     `json:"sent_utc"`
+	ExpiresUTC  int64     `json:"expires_utc,omitempty"`
+	ExpiredUTC  int64     `json:"expired_utc,omitempty"` // when the relay reported it expired unfetched
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"` // ciphertext bytes
+	Envelope    *Envelope `json:"envelope,omitempty"`
+}
+
+// FetchReport is what a relay reports with a fetch besides the envelopes.
+type FetchReport struct {
+	// Expired counts envelopes to the user that the relay dropped unfetched
+	// since the last fetch because they expired.
+	Expired int
+	// ExpiredSent lists the IDs of envelopes the user sent that expired
+	// before their recipient fetched them. Each is reported once.
+	ExpiredSent []string
+}
+
+// ReceiveReport is what a receive learned besides the messages.
+type ReceiveReport struct {
+	// Expired counts messages to us that expired at the relay unfetched.
+	Expired int
+	// Undelivered lists messages we sent that expired at the relay before
+	// the peer fetched them.
+	Undelivered []UndeliveredMessage
+	// Stuck lists conversations the watchdog newly flagged; only
+	// FollowMessages runs it.
+	Stuck []StuckConversation
+}
+
+// UndeliveredMessage is an outbox entry whose envelope expired at the relay
+// before Peer fetched it.
+type UndeliveredMessage struct {
+	Peer string
+	SentMessage
+}
+
+// SentState says whether the peer has fetched a sent message from the relay.
+type SentState string
+
+const (
+	// SentQueued means the message is still queued on the relay.
+	SentQueued SentState = "queued"
+	// SentFetched means the message has left the relay's queue, normally
+	// because the peer fetched it.
+	SentFetched SentState = "fetched"
+	// SentExpired means the relay dropped the message unfetched because it
+	// expired.
+	SentExpired SentState = "expired"
+	// SentUnknown means the relay reported no sequence number or could not
+	// be asked.
+	SentUnknown SentState = "unknown"
+)
+
+// SentStatus is an outbox entry with its state on the relay.
+type SentStatus struct {
+	SentMessage
+	State SentState
+}
+
+// Poll is the body of a poll message: a question and the options it may be
+// answered with. ID is chosen by the poll's creator and names the poll in
+// votes.
+type Poll struct {
+	ID       string   `json:"id"`
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+}
+
+// PollVote is one voter's current choice in a poll. Voter is empty for our
+// own vote.
+type PollVote struct {
+	Voter   string
+	Choice  int
+	SentUTC int64
+}
+
+// PollTally is a poll with the votes this client has seen for it. Creator is
+// empty for a poll we created; Participants are the peers it was exchanged
+// with.
+type PollTally struct {
+	Poll
+	Creator      string
+	CreatedUTC   int64
+	Participants []string
+	Votes        []PollVote
+}
+
+// Counts returns how many votes each option has.
+func (t PollTally) Counts() []int {
+	n := make([]int, len(t.Options))
+	for _, v := range t.Votes {
+		n[v.Choice]++
+	}
+	return n
+}
+
+// BroadcastList is a named set of peers a message can be sent to at once.
+// Lists are kept on this client only; each member receives an ordinary
+// pairwise message.
+type BroadcastList struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// BroadcastResult reports the delivery of a broadcast to one member. Err is
+// nil if the message was sent.
+type BroadcastResult struct {
+	Peer string
+	Err  error
+}
+
+// NotifyMode selects whether a conversation raises notifications.
+type NotifyMode string
+
+const (
+	// NotifyAlways notifies for every message unless the conversation is muted.
+	// It is the default when no mode is set.
+	NotifyAlways NotifyMode = "always"
+	// NotifyNever suppresses notifications; messages are still received.
+	NotifyNever NotifyMode = "never"
+)
+
+// SendPolicy decides whether messages may be sent to a peer whose identity has
+// not been verified.
+type SendPolicy string
+
+const (
+	// SendPolicyDefault defers to the global policy. It is only meaningful
+	// per peer.
+	SendPolicyDefault SendPolicy = ""
+	// SendPolicyAllow sends to any peer with a session. It is the global
+	// default.
+	SendPolicyAllow SendPolicy = "allow"
+	// SendPolicyRequireVerified only sends to paired contacts whose identity
+	// key still matches the session.
+	SendPolicyRequireVerified SendPolicy = "require-verified"
+)
+
+// OversizePolicy decides what happens to a message too large for one relay
+// envelope.
+type OversizePolicy string
+
+const (
+	// OversizeChunk sends it as chunk messages the peer reassembles (see
+	// package chunk). It is the default.
+	OversizeChunk OversizePolicy = "chunk"
+	// OversizeFail refuses to send it.
+	OversizeFail OversizePolicy = "fail"
+)
+
+// Settings holds local, global client preferences.
+type Settings struct {
+	SendPolicy   SendPolicy      `json:"send_policy,omitempty"`
+	Oversize     OversizePolicy  `json:"oversize,omitempty"`      // "" means OversizeChunk
+	CollectStats bool            `json:"collect_stats,omitempty"` // opt-in ratchet statistics
+	RatchetDebug bool            `json:"ratchet_debug,omitempty"` // opt-in ratchet step recording
+	Rekey        RekeyPolicy     `json:"rekey,omitempty"`
+	Retention    RetentionPolicy `json:"retention,omitempty"` // history kept for peers without their own
+	Filters      ReceiveFilters  `json:"filters,omitempty"`
+	ResendAfter  int             `json:"resend_after,omitempty"` // seconds a gap lasts before asking for a resend; 0 never asks
+	Suite        string          `json:"suite,omitempty"`        // cipher suite offered first in handshakes we start; "" for the default
+	Sessions     SessionPolicy   `json:"sessions,omitempty"`
+	Watchdog     WatchdogPolicy  `json:"watchdog,omitempty"`
+}
+
+// AcceptMode decides whether a new session a peer starts with us is accepted.
+type AcceptMode string
+
+const (
+	// AcceptYes accepts every new session. It is the default.
+	AcceptYes AcceptMode = "yes"
+	// AcceptNo drops the messages of a new session unread, unless the peer
+	// is a paired contact or was accepted (see ConversationPrefs).
+	AcceptNo AcceptMode = "no"
+	// AcceptAsk quarantines the messages of a new session, unless the peer
+	// is a paired contact or was accepted, until the user accepts it.
+	AcceptAsk AcceptMode = "ask"
+)
+
+// SessionPolicy says how sessions and prekeys are handled without asking.
+// The zero value keeps the defaults: every new session is accepted, prekeys
+// are only published by register, and replies need no verification.
+type SessionPolicy struct {
+	AcceptNew         AcceptMode `json:"accept_new,omitempty"`          // "" means AcceptYes
+	ReplenishOPKs     int        `json:"replenish_opks,omitempty"`      // republish once a relay offers fewer unused one-time prekeys; 0 never
+	RotateSPKDays     int        `json:"rotate_spk_days,omitempty"`     // republish with a fresh signed prekey once it is this many days old; 0 never
+	VerifyBeforeReply bool       `json:"verify_before_reply,omitempty"` // refuse to reply in conversations a peer started until they are paired
+}
+
+// Maintains reports whether p ever republishes prekeys by itself.
+func (p SessionPolicy) Maintains() bool {
+	return p.ReplenishOPKs > 0 || p.RotateSPKDays > 0
+}
+
+// PrekeyMaintenance reports a run of the session policy's prekey upkeep.
+type PrekeyMaintenance struct {
+	Due      []string  // why prekeys were republished; empty if nothing was due
+	Accounts []Account // relays the fresh prekeys were published to
+}
+
+// PrekeySchedule is when the session policy's prekey upkeep next runs. It is
+// kept across runs, so upkeep happens on time however rarely the client is
+// used, and relays are only asked about one-time prekeys when it is due.
+type PrekeySchedule struct {
+	LastRunUTC    int64 `json:"last_run_utc,omitempty"`    // when upkeep last ran
+	NextCheckUTC  int64 `json:"next_check_utc,omitempty"`  // when to next count the relays' one-time prekeys; zero: at the next run
+	NextRotateUTC int64 `json:"next_rotate_utc,omitempty"` // when the signed prekey is next due for rotation; zero: never
+}
+
+// PrekeyStatus summarises the prekeys we hold, for `prekeys status` and the
+// prekey upkeep. Generation times are zero for keys saved before they were
+// recorded.
+type PrekeyStatus struct {
+	SignedPrekeyID   KeyID // "" if none is current
+	SignedCreatedUTC int64
+
+	OneTime          int // one-time prekeys held and not yet consumed
+	OneTimeOldestUTC int64
+	OneTimeNewestUTC int64
+
+	// Consumed counts the one-time prekeys peers have used since consumption
+	// was first recorded; ConsumedUTC is when the most recent were, oldest
+	// first.
+	Consumed    int
+	ConsumedUTC []int64
+
+	Schedule PrekeySchedule
+}
+
+// ReceiveFilters decide which decrypted messages are shown and stored. A
+// message any filter refuses is held for review instead (see HeldMessage).
+// Zero fields are off; the zero value lets everything through.
+type ReceiveFilters struct {
+	MaxSize      int      `json:"max_size,omitempty"`      // largest body in bytes
+	ContentTypes []string `json:"content_types,omitempty"` // allowed body content types
+	Senders      []string `json:"senders,omitempty"`       // allowed senders
+}
+
+// Enabled reports whether any filter is on.
+func (f ReceiveFilters) Enabled() bool {
+	return f.MaxSize > 0 || len(f.ContentTypes) > 0 || len(f.Senders) > 0
+}
+
+// RekeyPolicy says when a conversation we initiated re-runs X3DH against the
+// peer's current prekeys and moves to the new root key. Zero fields are off;
+// the zero value never rekeys.
+type RekeyPolicy struct {
+	Days     int `json:"days,omitempty"`     // rekey once the root key is this many days old
+	Messages int `json:"messages,omitempty"` // rekey after this many messages in either direction
+}
+
+// Enabled reports whether p ever rekeys.
+func (p RekeyPolicy) Enabled() bool {
+	return p.Days > 0 || p.Messages > 0
+}
+
+// WatchdogPolicy says when recv --follow warns that a conversation may be
+// stuck: our messages keep reaching the relay, but the peer has not taken a
+// ratchet step since, as if its client could not read them. Zero fields are
+// off; the zero value never warns.
+type WatchdogPolicy struct {
+	Messages int `json:"messages,omitempty"` // warn after this many of our messages without a step
+	Days     int `json:"days,omitempty"`     // warn once the first of them is this many days old
+}
+
+// Enabled reports whether p ever warns.
+func (p WatchdogPolicy) Enabled() bool {
+	return p.Messages > 0 || p.Days > 0
+}
+
+// StuckConversation is a conversation the watchdog flagged (see
+// WatchdogPolicy): Unanswered messages sent to Peer since it last took a
+// ratchet step, the first at SinceUTC.
+type StuckConversation struct {
+	Peer       string
+	Unanswered int
+	SinceUTC   int64
+}
+
+// ConversationPrefs holds local, per-peer notification and send preferences.
+// They are never sent to the peer or the relay. The zero value means defaults:
+// not muted, always notify, previews shown, global send policy.
+type ConversationPrefs struct {
+	Peer          string     `json:"peer"`
+	Muted         bool       `json:"muted,omitempty"`
+	MutedUntilUTC int64      `json:"muted_until_utc,omitempty"` // 0 while muted means indefinitely
+	Notify        NotifyMode `json:"notify,omitempty"`
+	HidePreview   bool       `json:"hide_preview,omitempty"`
+	SendPolicy    SendPolicy `json:"send_policy,omitempty"`
+	AcceptWipe    bool       `json:"accept_wipe,omitempty"`    // honour the peer's remote wipe requests
+	AcceptSession bool       `json:"accept_session,omitempty"` // accept a new session from the peer whatever the session policy
+	// Retention overrides the global history retention for this peer; nil
+	// uses the global one.
+	Retention *RetentionPolicy `json:"retention,omitempty"`
+}
+
+// RetentionPolicy bounds the local history kept for a conversation. The zero
+// value keeps everything. With both limits set, a message is removed as soon
+// as either says so.
+type RetentionPolicy struct {
+	KeepLast int  `json:"keep_last,omitempty"` // keep only the newest N messages
+	KeepDays int  `json:"keep_days,omitempty"` // remove messages older than D days
+	KeepNone bool `json:"keep_none,omitempty"` // store nothing
+}
+
+// Unlimited reports whether p keeps everything.
+func (p RetentionPolicy) Unlimited() bool {
+	return !p.KeepNone && p.KeepLast <= 0 && p.KeepDays <= 0
+}
+
+// Expired returns the IDs of the entries p no longer keeps at now. entries
+// hold one conversation, oldest first.
+func (p RetentionPolicy) Expired(entries []HistoryEntry, now int64) []string {
+	first := 0 // index of the oldest entry KeepLast keeps
+	switch {
+	case p.KeepNone:
+		first = len(entries)
+	case p.KeepLast > 0 && len(entries) > p.KeepLast:
+		first = len(entries) - p.KeepLast
+	}
+	cutoff := now - int64(p.KeepDays)*24*60*60
+	var out []string
+	for i, e := range entries {
+		if i < first || (p.KeepDays > 0 && e.SentUTC < cutoff) {
+			out = append(out, e.ID)
+		}
+	}
+	return out
+}
+
+// Retention is the global retention policy with every per-peer override.
+type Retention struct {
+	Default RetentionPolicy
+	Peers   map[string]RetentionPolicy
+}
+
+// For returns the policy that applies to peer.
+func (r Retention) For(peer string) RetentionPolicy {
+	if p, ok := r.Peers[peer]; ok {
+		return p
+	}
+	return r.Default
+}
+
+// MutedAt reports whether the conversation is muted at now.
+func (p ConversationPrefs) MutedAt(now int64) bool {
+	return p.Muted && (p.MutedUntilUTC == 0 || now < p.MutedUntilUTC)
+}
+
+// NotifiesAt reports whether a message arriving at now should raise a notification.
+func (p ConversationPrefs) NotifiesAt(now int64) bool {
+	return p.Notify != NotifyNever && !p.MutedAt(now)
+}
+
+// ControlMessage is an encrypted, protocol-level message that is consumed by the
+// client rather than shown to the user.
+type ControlMessage struct {
+	Type        string `json:"type"`
+	InitiatorFP string `json:"initiator_fp,omitempty"`
+	ResponderFP string `json:"responder_fp,omitempty"`
+
+	// Rekeys. Prekey is a fresh X3DH handshake and RatchetPub the ratchet key
+	// the sender's first message on the new root will carry.
+	Prekey     *PrekeyMessage `json:"prekey,omitempty"`
+	RatchetPub []byte         `json:"ratchet_pub,omitempty"`
+
+	// Remote wipe requests and receipts. Sig is the sender's Ed25519
+	// signature over the request or receipt.
+	WipeID     string `json:"wipe_id,omitempty"`
+	WipeResult string `json:"wipe_result,omitempty"`
+	Sig        []byte `json:"sig,omitempty"`
+
+	// Attestation is an identity attestation about the recipient, signed by
+	// the sender.
+	Attestation *Attestation `json:"attestation,omitempty"`
+
+	// Summary is the sender's view of the conversation, for state audits.
+	Summary *StateSummary `json:"summary,omitempty"`
+
+	// Missing lists the messages a resend request asks the recipient to
+	// post again.
+	Missing []HeaderIndex `json:"missing,omitempty"`
+
+	// Profile is the sender's current profile.
+	Profile *Profile `json:"profile,omitempty"`
+
+	// Ping is an end-to-end ping or the pong answering it, signed in Sig.
+	Ping *Ping `json:"ping,omitempty"`
+}
+
+// Ping carries the timestamps of an end-to-end ping, in Unix milliseconds.
+// SentMilli is the pinger's clock and is echoed back in the pong; the others
+// are the answering peer's clock.
+type Ping struct {
+	ID            string `json:"id"`
+	SentMilli     int64  `json:"sent_ms"`
+	ReceivedMilli int64  `json:"received_ms,omitempty"` // when the peer decrypted the ping
+	RepliedMilli  int64  `json:"replied_ms,omitempty"`  // when the peer sent the pong
+}
+
+// HeaderIndex names one ratchet message by the sender's ratchet key and its
+// number in that chain, as given in its header.
+type HeaderIndex struct {
+	DHPub []byte `json:"dh_pub"`
+	N     uint32 `json:"n"`
+}
+
+// StateSummary is one side's view of a conversation, exchanged by state
+// audits to spot forked or replayed ratchet state.
+type StateSummary struct {
+	Sent     int    `json:"sent"`     // messages sent, including the one carrying this summary
+	Received int    `json:"received"` // messages received
+	Rekeys   int    `json:"rekeys"`
+	Epoch    string `json:"epoch"` // fingerprint of the current root key epoch; "" if unknown
+}
+
+// QuarantinedEnvelope is an envelope that failed to decrypt and was set aside
+// so the rest of the queue could be processed.
+type QuarantinedEnvelope struct {
+	ID             string   `json:"id"`
+	Envelope       Envelope `json:"envelope"`
+	Reason         string   `json:"reason"`
+	QuarantinedUTC int64    `json:"quarantined_utc"`
+}
+
+// HeldMessage is a decrypted message a receive filter refused, kept for the
+// user to review and accept or drop.
+type HeldMessage struct {
+	ID      string           `json:"id"`
+	Message DecryptedMessage `json:"message"`
+	Reason  string           `json:"reason"`
+	HeldUTC int64            `json:"held_utc"`
+}
+
+// Draft is a message being composed for Peer. Text is what the editor
+// holds: the body, with any attachment references on lines of their own.
+type Draft struct {
+	Peer        string `json:"peer"`
+	Text        string `json:"text"`
+	ContentType string `json:"content_type"`
+	UpdatedUTC  int64  `json:"updated_utc"`
+}
+
+// DraftSent reports what sending a draft delivered: whether it had a body,
+// and the attachments sent after it, by path.
+type DraftSent struct {
+	Body        bool
+	Attachments []string
+}
+
+// HistoryDirection says whether a history entry was sent or received.
+type HistoryDirection string
+
+const (
+	HistoryIn  HistoryDirection = "in"  // received from Peer
+	HistoryOut HistoryDirection = "out" // sent to Peer
+)
+
+// HistoryEntry is one message in the local encrypted history.
+//
+// Source is empty for messages sent or received by this client. Entries
+// imported from another messenger record the import format there; they were
+// never authenticated by Ciphera and must not be presented as if they were.
+type HistoryEntry struct {
+	ID        string           `json:"id"`
+	Peer      string           `json:"peer"`
+	Direction HistoryDirection `json:"dir"`
+	Body      MessageBody      `json:"body"`
+	SentUTC   int64            `json:"sent_utc"`
+	Source    string           `json:"source,omitempty"`
+}
+
+// HistoryImport is a transcript exported from another messenger.
+type HistoryImport struct {
+	Format string // e.g. "json" or "signal-backup"
+	Data   []byte
+	Peer   string // conversation to file messages under, if the format has none
+	Thread string // source thread to import, for formats holding several
+}
+
+// HistoryStats summarises the history with one peer. Days and hours are
+// counted in the time zone the statistics were computed for.
+type HistoryStats struct {
+	Peer     string `json:"peer"`
+	Sent     int    `json:"sent"`
+	Received int    `json:"received"`
+	Imported int    `json:"imported"` // of the above, imported from other messengers
+	FirstUTC int64  `json:"first_utc"`
+	LastUTC  int64  `json:"last_utc"`
+
+	Days  []HistoryDay `json:"days"`  // days with messages, oldest first
+	Hours [24]int      `json:"hours"` // messages by hour of the day
+
+	// OurReplies times our replies to the peer, PeerReplies theirs to us.
+	OurReplies  ReplyLatency `json:"our_replies"`
+	PeerReplies ReplyLatency `json:"peer_replies"`
+
+	Sizes []SizeBucket `json:"sizes"` // message bodies by size, smallest first
+}
+
+// HistoryDay counts the messages of one day ("2006-01-02").
+type HistoryDay struct {
+	Day      string `json:"day"`
+	Sent     int    `json:"sent"`
+	Received int    `json:"received"`
+}
+
+// ReplyLatency is how long one side took to reply: from the first message
+// the other side sent since its last reply, to the reply.
+type ReplyLatency struct {
+	Count  int           `json:"count"`
+	Mean   time.Duration `json:"mean"`
+	Median time.Duration `json:"median"`
+}
+
+// SizeBucket counts message bodies of up to MaxBytes bytes that are larger
+// than the previous bucket's; the last bucket has MaxBytes 0 and no limit.
+type SizeBucket struct {
+	MaxBytes int `json:"max_bytes"`
+	Sent     int `json:"sent"`
+	Received int `json:"received"`
+}
+
+// SessionStatus summarises the handshake confirmation state and skipped-key
+// count of a conversation.
+type SessionStatus struct {
+	Peer        string       `json:"peer"`
+	Confirm     ConfirmState `json:"confirm"`
+	SkippedKeys int          `json:"skipped_keys"` // stored keys for out-of-order messages
+	Rekeys      int          `json:"rekeys"`
+	VouchedBy   []string     `json:"vouched_by,omitempty"` // contacts who attested the peer's identity; see Session
+	Suite       string       `json:"suite,omitempty"`      // cipher suite of the conversation; "" for the default
+}
+
+// MessagePreview describes the envelope a send would post, for dry runs.
+// Envelope.Cipher is cleared; CipherBytes records its length.
+type MessagePreview struct {
+	Relay       string   `json:"relay"`           // relay the envelope would be posted to
+	Envelope    Envelope `json:"envelope"`        // as it would be posted, minus Cipher
+	BodyBytes   int      `json:"body_bytes"`      // encoded message body before encryption
+	CipherBytes int      `json:"cipher_bytes"`    // ciphertext including the AEAD tag
+	WireBytes   int      `json:"wire_bytes"`      // JSON-encoded envelope as posted
+	Parts       int      `json:"parts,omitempty"` // chunk messages sent for an oversize body; Envelope is the first
+}
+
+// MessageBody is the structured content encrypted inside every envelope.
+//
+// ContentType is a MIME-like type such as "text/plain" or
+// "application/vnd.ciphera.receipt"; Metadata holds type-specific fields
+// (e.g. a file name). Version 0 marks a raw payload from a client that predates
+// the schema.
+type MessageBody struct {
+	Version     int               `json:"v"`
+	ContentType string            `json:"type"`
+	Body        []byte            `json:"body,omitempty"`
+	Metadata    map[string]string `json:"meta,omitempty"`
 }
 
 // DecryptedMessage is what MessageService.Recv returns.
 type DecryptedMessage struct {
-	From      string `json:"from"`
-	To        string `json:"to"`
-	Plaintext []byte `json:"plaintext"`
-	Timestamp int64  `json:"timestamp"`
+	From      string      `json:"from"`
+	To        string      `json:"to"`
+	Body      MessageBody `json:"body"`
+	Timestamp int64       `json:"timestamp"`
+
+	// Verification says how the message was authenticated, for
+	// `recv --verify-report`. It is nil for messages not read from an
+	// envelope, such as local notices.
+	Verification *MessageVerification `json:"verification,omitempty"`
+}
+
+// MessageVerification records how a received message was authenticated: the
+// identity key its session was agreed with and what the ratchet did to open
+// it. For a message sent in parts it describes the last part.
+type MessageVerification struct {
+	EnvelopeID  string       `json:"envelope_id,omitempty"`
+	IdentityKey X25519Public `json:"identity_key"` // zero for conversations saved before it was recorded
+	// Paired is set when the peer is a paired contact whose identity key is
+	// IdentityKey; KeyChanged when it is paired under a different key.
+	Paired     bool `json:"paired,omitempty"`
+	KeyChanged bool `json:"key_changed,omitempty"`
+
+	Suite             string `json:"suite"`
+	SessionStartedUTC int64  `json:"session_started_utc,omitempty"` // zero if unknown
+	RekeyedUTC        int64  `json:"rekeyed_utc,omitempty"`         // zero if never rekeyed
+	NewSession        bool   `json:"new_session,omitempty"`         // the message carried the handshake
+	Stale             bool   `json:"stale,omitempty"`               // opened with the peer's losing handshake
+	HeaderMAC         bool   `json:"header_mac,omitempty"`          // the header MAC was checked
+
+	// N and PN are the message's ratchet header counters. RatchetStep is
+	// set when the message started a new peer chain (a DH ratchet step);
+	// SkippedKeyUsed when it was opened with a key kept for a message that
+	// arrived out of order. KeysSkipped counts the keys it made us keep for
+	// earlier messages that have not arrived.
+	N              uint32 `json:"n"`
+	PN             uint32 `json:"pn"`
+	RatchetStep    bool   `json:"ratchet_step,omitempty"`
+	SkippedKeyUsed bool   `json:"skipped_key_used,omitempty"`
+	KeysSkipped    int    `json:"keys_skipped,omitempty"`
 }
 
 // RatchetState contains all fields the Double Ratchet needs to track.
@@ -123,4 +1218,92 @@ type RatchetState struct {
 	Nr        uint32            `json:"nr"`
 	PN        uint32            `json:"pn"`
 	Skipped   map[string][]byte `json:"skipped"`
+	HeaderKey []byte            `json:"header_key,omitempty"` // authenticates envelope headers; nil for states that predate it
+	Suite     string            `json:"suite,omitempty"`      // cipher suite ID (see crypto.LookupSuite); "" for states that predate suites
+}
+
+// QueueStats describes a recipient's relay queue, optionally restricted to
+// one sender's envelopes. Every such envelope with a sequence number up to
+// FetchedSeq has left the queue.
+type QueueStats struct {
+	Queued     int    `json:"queued"`
+	FetchedSeq uint64 `json:"fetched_seq"`
+}
+
+// BuildInfo describes a ciphera or relay binary: its version, the commit and
+// time it was built from, and the protocol versions it speaks.
+type BuildInfo struct {
+	Version      string         `json:"version"`
+	Commit       string         `json:"commit,omitempty"`
+	BuildDate    string         `json:"build_date,omitempty"`
+	Modified     bool           `json:"modified,omitempty"` // built from a tree with uncommitted changes
+	GoVersion    string         `json:"go_version"`
+	Platform     string         `json:"platform"`               // GOOS/GOARCH
+	Protocols    map[string]int `json:"protocols"`              // protocol name to version
+	Capabilities []string       `json:"capabilities,omitempty"` // optional features supported
+	Notice       *RelayNotice   `json:"notice,omitempty"`       // relays only; nil when nothing is announced
+}
+
+// ClientGate is what a relay asks of clients older than its minimum version.
+type ClientGate string
+
+const (
+	GateWarn  ClientGate = "warn"  // carry on after a warning
+	GateBlock ClientGate = "block" // refuse to talk to the relay
+)
+
+// RelayNotice is what a relay operator announces to clients in
+// GET /server-info: an upcoming or ongoing maintenance window, a message of
+// the day and the oldest client version the relay supports. Every field is
+// optional.
+type RelayNotice struct {
+	MaintenanceStartUTC int64      `json:"maintenance_start_utc,omitempty"`
+	MaintenanceEndUTC   int64      `json:"maintenance_end_utc,omitempty"`
+	MOTD                string     `json:"motd,omitempty"`
+	MinClientVersion    string     `json:"min_client_version,omitempty"` // semantic version, e.g. v1.2.0
+	ClientGate          ClientGate `json:"client_gate,omitempty"`        // for older clients; empty means GateWarn
+}
+
+// Empty reports whether n announces nothing.
+func (n RelayNotice) Empty() bool {
+	return n == RelayNotice{}
+}
+
+// FetchPacing bounds a receive loop that adapts to the relay's queue. The
+// batch limit grows towards MaxLimit while fetches come back full and falls
+// back to MinLimit once the queue drains; the wait between polls grows from
+// MinInterval towards MaxInterval while the queue stays empty.
+type FetchPacing struct {
+	MinLimit    int
+	MaxLimit    int
+	MinInterval time.Duration
+	MaxInterval time.Duration
+}
+
+// ActionKind names a state-changing command kept in the action log.
+type ActionKind string
+
+const (
+	ActionRegister         ActionKind = "register"           // published a new signed prekey
+	ActionRotateSigningKey ActionKind = "rotate-signing-key" // replaced the signing key
+	ActionArchive          ActionKind = "archive"            // archived a conversation
+	ActionFilters          ActionKind = "filters"            // changed the receive filters
+)
+
+// Action is one entry of the local action log: a state-changing command and
+// what it replaced, so `ciphera undo` can put it back. Before and After are
+// the signed prekey IDs around a register and the hex signing keys around a
+// rotate-signing-key; Filters are the receive filters a filters change
+// replaced.
+type Action struct {
+	ID        int             `json:"id"`
+	Kind      ActionKind      `json:"kind"`
+	TimeUTC   int64           `json:"time_utc"`
+	Username  string          `json:"username,omitempty"`
+	Servers   []string        `json:"servers,omitempty"` // relays a register published to
+	Peer      string          `json:"peer,omitempty"`
+	Before    string          `json:"before,omitempty"`
+	After     string          `json:"after,omitempty"`
+	Filters   *ReceiveFilters `json:"filters,omitempty"`
+	UndoneUTC int64           `json:"undone_utc,omitempty"` // 0 until undone
 }
@@ -0,0 +1,149 @@
+package address
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// WellKnownPath is where a domain publishes the base URL of its relay.
+const WellKnownPath = "/.well-known/ciphera-relay"
+
+// SRVService and SRVProto name the DNS SRV record a domain may publish
+// instead: _ciphera._tcp.<host>.
+const (
+	SRVService = "ciphera"
+	SRVProto   = "tcp"
+)
+
+// maxHostLen is the longest DNS name.
+const maxHostLen = 253
+
+// FingerprintPrefix starts a peer named by the fingerprint of their identity
+// key rather than a username: fp:<fingerprint>.
+const FingerprintPrefix = "fp:"
+
+// fingerprintLen is the length of a fingerprint in hex (see
+// crypto.Fingerprint).
+const fingerprintLen = 20
+
+var (
+	// ErrBadAddress is returned by Parse for a string that is not user@host.
+	ErrBadAddress = errors.New("not a user@host address")
+	// ErrBadFingerprint is returned by ParseFingerprint for a string that is
+	// not fp: followed by a fingerprint.
+	ErrBadFingerprint = errors.New("not an fp:<fingerprint> address")
+)
+
+// Address is a username on the relay serving Host.
+type Address struct {
+	User string
+	Host string // a DNS name or IP literal, with an optional :port
+}
+
+// String returns a in its user@host form.
+func (a Address) String() string {
+	return a.User + "@" + a.Host
+}
+
+// Hostname returns Host without its port.
+func (a Address) Hostname() string {
+	if h, _, err := net.SplitHostPort(a.Host); err == nil {
+		return h
+	}
+	return a.Host
+}
+
+// Loopback reports whether Host names this machine, which is the only place
+// discovery falls back to plain HTTP.
+func (a Address) Loopback() bool {
+	h := a.Hostname()
+	if h == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(h)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Parse splits s at its last '@'. The host must be a DNS name with at least
+// one dot, localhost or an IP literal (IPv6 in brackets), optionally followed
+// by a port. Anything else, including a bare username, fails with
+// ErrBadAddress.
+func Parse(s string) (Address, error) {
+	i := strings.LastIndexByte(s, '@')
+	if i <= 0 || i == len(s)-1 {
+		return Address{}, ErrBadAddress
+	}
+	a := Address{User: s[:i], Host: strings.ToLower(s[i+1:])}
+	if !validHost(a.Host) {
+		return Address{}, ErrBadAddress
+	}
+	return a, nil
+}
+
+// Is reports whether s parses as an address.
+func Is(s string) bool {
+	_, err := Parse(s)
+	return err == nil
+}
+
+// ParseFingerprint returns the fingerprint s names. It must be FingerprintPrefix
+// followed by 20 lowercase hex digits, as crypto.Fingerprint prints them;
+// anything else fails with ErrBadFingerprint.
+func ParseFingerprint(s string) (string, error) {
+	fp, ok := strings.CutPrefix(s, FingerprintPrefix)
+	if !ok || len(fp) != fingerprintLen {
+		return "", ErrBadFingerprint
+	}
+	for _, c := range fp {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return "", ErrBadFingerprint
+		}
+	}
+	return fp, nil
+}
+
+// IsFingerprint reports whether s parses as a fingerprint address.
+func IsFingerprint(s string) bool {
+	_, err := ParseFingerprint(s)
+	return err == nil
+}
+
+// FromFingerprint returns the address of the identity key with fingerprint fp.
+func FromFingerprint(fp string) string {
+	return FingerprintPrefix + fp
+}
+
+// validHost checks host as Parse describes.
+func validHost(host string) bool {
+	name := host
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return false
+		}
+		name = h
+	} else if strings.HasPrefix(host, "[") {
+		return false // bracketed IPv6 without a port
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		return !strings.Contains(name, ":") || strings.HasPrefix(host, "[") // IPv6 only in brackets
+	}
+	if name == "localhost" {
+		return true
+	}
+	if len(name) > maxHostLen || !strings.Contains(name, ".") {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package address_test
+
+import (
+	"testing"
+
+	"ciphera/internal/protocol/address"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		user     string
+		host     string
+		hostname string
+	}{
+		{"alice@relay.example.org", "alice", "relay.example.org", "relay.example.org"},
+		{"alice@Relay.Example.ORG", "alice", "relay.example.org", "relay.example.org"},
+		{"alice@relay.example.org:8443", "alice", "relay.example.org:8443", "relay.example.org"},
+		{"a@b@example.org", "a@b", "example.org", "example.org"},
+		{"bob@localhost", "bob", "localhost", "localhost"},
+		{"bob@127.0.0.1:8080", "bob", "127.0.0.1:8080", "127.0.0.1"},
+		{"bob@[::1]:8080", "bob", "[::1]:8080", "::1"},
+	}
+	for _, tt := range tests {
+		a, err := address.Parse(tt.in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.in, err)
+		}
+		if a.User != tt.user || a.Host != tt.host || a.Hostname() != tt.hostname {
+			t.Fatalf("Parse(%q) = %+v (hostname %q), want %s@%s (hostname %q)",
+				tt.in, a, a.Hostname(), tt.user, tt.host, tt.hostname)
+		}
+	}
+}
+
+func TestParse_Rejects(t *testing.T) {
+	for _, in := range []string{
+		"alice",
+		"@example.org",
+		"alice@",
+		"alice@example",
+		"alice@-bad.example.org",
+		"alice@bad..example.org",
+		"alice@exa_mple.org",
+		"alice@example.org:0",
+		"alice@example.org:http",
+		"alice@::1",
+		"alice@[::1]",
+	} {
+		if address.Is(in) {
+			t.Fatalf("Parse(%q) accepted", in)
+		}
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	for in, want := range map[string]bool{
+		"bob@localhost:8080":    true,
+		"bob@127.0.0.1:8080":    true,
+		"bob@[::1]:8080":        true,
+		"bob@relay.example.org": false,
+		"bob@192.0.2.1":         false,
+	} {
+		a, err := address.Parse(in)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", in, err)
+		}
+		if got := a.Loopback(); got != want {
+			t.Fatalf("%s: Loopback() = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestParseFingerprint(t *testing.T) {
+	const fp = "0123456789abcdef0123"
+	got, err := address.ParseFingerprint(address.FromFingerprint(fp))
+	if err != nil || got != fp {
+		t.Fatalf("ParseFingerprint(fp:%s) = %q, %v", fp, got, err)
+	}
+	for _, in := range []string{
+		fp,
+		"fp:",
+		"fp:0123456789ABCDEF0123",
+		"fp:0123456789abcdef012",
+		"fp:0123456789abcdef01234",
+		"fp:0123456789abcdef012g",
+		"FP:" + fp,
+	} {
+		if address.IsFingerprint(in) {
+			t.Fatalf("ParseFingerprint(%q) accepted", in)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+// Package address parses peer addresses of the form user@host, which name a
+// username together with the domain whose relay serves it, so that a peer on
+// another relay can be reached without configuring that relay first.
+//
+// # Discovery
+//
+// A client resolves host to a relay base URL, trying in order:
+//
+//  1. GET https://host/.well-known/ciphera-relay, which answers with JSON
+//     {"relay": "<base URL>"}. Hosts on the loopback interface are asked
+//     over plain HTTP, for local testing.
+//  2. The DNS SRV record _ciphera._tcp.host, whose target and port give the
+//     base URL https://target:port.
+//
+// Host may carry a port (alice@relay.example.org:8443), which is kept for
+// the well-known request; SRV lookups use the name alone.
+//
+// # Fingerprint addresses
+//
+// A peer may also be named by the fingerprint of their identity key,
+// fp:<fingerprint>, instead of a username that its owner or the relay could
+// change. Relays resolve the fingerprint to the account that registered the
+// key, and clients check that the bundle they are handed has that
+// fingerprint, so the address is its own proof of identity. The user part of
+// an address may be one too: fp:<fingerprint>@host.
+package address
@@ -0,0 +1,53 @@
+package armor
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Block types. They are part of the wire format and must never change.
+const (
+	TypeEnvelope       = "CIPHERA ENVELOPE"        // JSON envelope
+	TypeSealedEnvelope = "CIPHERA SEALED ENVELOPE" // JSON envelope sealed under a passphrase
+)
+
+// Version is the armor version this package writes and reads.
+const Version = 1
+
+// versionHeader names the header carrying the version.
+const versionHeader = "Version"
+
+var (
+	// ErrNoBlock is returned when the input holds no armored block.
+	ErrNoBlock = errors.New("no armored block found")
+	// ErrUnknownType is returned for a block of a type this package does not know.
+	ErrUnknownType = errors.New("unknown armor type")
+	// ErrUnsupportedVersion is returned for a block written by a newer version.
+	ErrUnsupportedVersion = errors.New("armor version unsupported")
+)
+
+// Encode returns data armored as a block of type typ.
+func Encode(typ string, data []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    typ,
+		Headers: map[string]string{versionHeader: strconv.Itoa(Version)},
+		Bytes:   data,
+	})
+}
+
+// Decode returns the type and contents of the first block in text.
+func Decode(text []byte) (typ string, data []byte, err error) {
+	b, _ := pem.Decode(text)
+	if b == nil {
+		return "", nil, ErrNoBlock
+	}
+	if b.Type != TypeEnvelope && b.Type != TypeSealedEnvelope {
+		return "", nil, fmt.Errorf("%w: %q", ErrUnknownType, b.Type)
+	}
+	if v, err := strconv.Atoi(b.Headers[versionHeader]); err != nil || v < 1 || v > Version {
+		return "", nil, fmt.Errorf("%w: %q", ErrUnsupportedVersion, b.Headers[versionHeader])
+	}
+	return b.Type, b.Bytes, nil
+}
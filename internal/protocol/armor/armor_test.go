@@ -0,0 +1,64 @@
+package armor_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"ciphera/internal/protocol/armor"
+)
+
+func TestRoundTrip(t *testing.T) {
+	data := []byte(`{"from":"alice","to":"bob"}`)
+	for _, typ := range []string{armor.TypeEnvelope, armor.TypeSealedEnvelope} {
+		text := armor.Encode(typ, data)
+		if !bytes.HasPrefix(text, []byte("-----BEGIN "+typ+"-----\nVersion: 1\n")) {
+			t.Fatalf("unexpected armor:\n%s", text)
+		}
+		gotType, got, err := armor.Decode(text)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if gotType != typ || !bytes.Equal(got, data) {
+			t.Fatalf("Decode = %q %q, want %q %q", gotType, got, typ, data)
+		}
+	}
+}
+
+func TestDecode_SkipsSurroundingText(t *testing.T) {
+	text := "Hi Bob, message below.\n\n" +
+		string(armor.Encode(armor.TypeEnvelope, []byte("x"))) +
+		"\n-- \nAlice\n"
+	if _, got, err := armor.Decode([]byte(text)); err != nil || string(got) != "x" {
+		t.Fatalf("Decode = %q, %v", got, err)
+	}
+}
+
+func TestDecode_Rejects(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want error
+	}{
+		{"no block", "just some text", armor.ErrNoBlock},
+		{"other type", string(armor.Encode("PGP MESSAGE", []byte("x"))), armor.ErrUnknownType},
+		{
+			"newer version",
+			strings.Replace(string(armor.Encode(armor.TypeEnvelope, []byte("x"))), "Version: 1", "Version: 2", 1),
+			armor.ErrUnsupportedVersion,
+		},
+		{
+			"no version",
+			"-----BEGIN CIPHERA ENVELOPE-----\neA==\n-----END CIPHERA ENVELOPE-----\n",
+			armor.ErrUnsupportedVersion,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := armor.Decode([]byte(tt.text)); !errors.Is(err, tt.want) {
+				t.Fatalf("Decode error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
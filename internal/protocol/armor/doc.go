@@ -0,0 +1,20 @@
+// Package armor wraps binary data in a text block that survives email, chat
+// and copy-and-paste, for envelopes delivered without a relay.
+//
+// # Format
+//
+// A block is PEM (RFC 7468) with a Ciphera type and a Version header:
+//
+//	-----BEGIN CIPHERA ENVELOPE-----
+//	Version: 1
+//
+//	eyJmcm9tIjoiYWxpY2UiLCJ0byI6ImJvYiIs...
+//	-----END CIPHERA ENVELOPE-----
+//
+// TypeEnvelope holds an Envelope as JSON. TypeSealedEnvelope holds the same
+// JSON sealed under a passphrase, so that whoever carries the block learns
+// neither the sender, the recipient nor the ratchet header.
+//
+// Decode skips any text around the block, such as an email signature or
+// quoted reply, and rejects types and versions it does not know.
+package armor
@@ -0,0 +1,99 @@
+package attest
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+	"time"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/signchain"
+)
+
+// Context is the signature context for attestations. It is part of the wire
+// protocol and must never change.
+const Context = "ciphera/attestation-v1"
+
+// ErrBadSignature is returned when an attestation's signature does not verify.
+var ErrBadSignature = errors.New("attestation signature invalid")
+
+// Statement returns the bytes the attester signs for a, without the context.
+func Statement(a domain.Attestation) []byte {
+	var b []byte
+	b = binary.BigEndian.AppendUint16(b, uint16(len(a.Subject)))
+	b = append(b, a.Subject...)
+	b = append(b, a.SubjectKey[:]...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(a.Attester)))
+	b = append(b, a.Attester...)
+	b = append(b, a.AttesterKey[:]...)
+	return binary.BigEndian.AppendUint64(b, uint64(a.CreatedUTC))
+}
+
+// Sign returns an attestation by id, known as attester, that subject holds
+// subjectKey.
+func Sign(
+	id domain.Identity,
+	attester string,
+	subject string,
+	subjectKey domain.X25519Public,
+	now time.Time,
+) domain.Attestation {
+	a := domain.Attestation{
+		Subject:         subject,
+		SubjectKey:      subjectKey,
+		Attester:        attester,
+		AttesterKey:     id.XPub,
+		AttesterSignKey: id.EdPub,
+		AttesterChain:   slices.Clone(id.SignChain),
+		CreatedUTC:      now.Unix(),
+	}
+	a.Sig = crypto.SignContext(id.EdPriv, Context, Statement(a))
+	return a
+}
+
+// Check verifies a's signature and that its signing-key chain is consistent.
+// It says nothing about whether the attester is trusted; see Vouchers.
+func Check(a domain.Attestation) error {
+	if err := signchain.Check(a.AttesterKey, a.AttesterChain, a.AttesterSignKey); err != nil {
+		return err
+	}
+	if !crypto.VerifyContext(a.AttesterSignKey, Context, Statement(a), a.Sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Vouchers returns the usernames of the contacts who attest that subject
+// holds subjectKey, in contact order. An attestation counts only if it is
+// about subject and subjectKey, verifies, and comes from a contact's identity
+// key with a signing key that chains from the one received when pairing. A
+// contact counts once however many attestations they made, and never for
+// themselves.
+func Vouchers(
+	subject string,
+	subjectKey domain.X25519Public,
+	atts []domain.Attestation,
+	contacts []domain.Contact,
+) []string {
+	var out []string
+	for _, c := range contacts {
+		if c.Username == subject || c.IdentityKey == subjectKey {
+			continue
+		}
+		for _, a := range atts {
+			if a.Subject != subject || a.SubjectKey != subjectKey || a.AttesterKey != c.IdentityKey {
+				continue
+			}
+			if Check(a) != nil {
+				continue
+			}
+			if signchain.Verify(c.IdentityKey, a.AttesterChain, c.SignKey, a.AttesterSignKey) != nil {
+				continue
+			}
+			out = append(out, c.Username)
+			break
+		}
+	}
+	return out
+}
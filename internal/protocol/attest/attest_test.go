@@ -0,0 +1,103 @@
+package attest_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/attest"
+	"ciphera/internal/protocol/signchain"
+)
+
+// newIdentity returns an identity with fresh keys and no rotations.
+func newIdentity(t *testing.T) domain.Identity {
+	t.Helper()
+	xPriv, xPub, err := crypto.GenerateX25519()
+	if err != nil {
+		t.Fatalf("GenerateX25519: %v", err)
+	}
+	edPriv, edPub, err := crypto.GenerateEd25519()
+	if err != nil {
+		t.Fatalf("GenerateEd25519: %v", err)
+	}
+	return domain.Identity{XPub: xPub, XPriv: xPriv, EdPub: edPub, EdPriv: edPriv}
+}
+
+// contact returns the contact record a user pairing with id as name keeps.
+func contact(name string, id domain.Identity) domain.Contact {
+	return domain.Contact{Username: name, IdentityKey: id.XPub, SignKey: id.EdPub}
+}
+
+func TestSign_Check(t *testing.T) {
+	alice, bob := newIdentity(t), newIdentity(t)
+	a := attest.Sign(alice, "alice", "bob", bob.XPub, time.Now())
+	if err := attest.Check(a); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	// Every signed field is bound.
+	for name, mutate := range map[string]func(*domain.Attestation){
+		"subject":     func(a *domain.Attestation) { a.Subject = "mallory" },
+		"subject key": func(a *domain.Attestation) { a.SubjectKey[0] ^= 1 },
+		"attester":    func(a *domain.Attestation) { a.Attester = "carol" },
+		"created":     func(a *domain.Attestation) { a.CreatedUTC++ },
+	} {
+		b := a
+		mutate(&b)
+		if err := attest.Check(b); !errors.Is(err, attest.ErrBadSignature) {
+			t.Errorf("%s changed: Check = %v; want ErrBadSignature", name, err)
+		}
+	}
+}
+
+func TestVouchers(t *testing.T) {
+	alice, bob, carol := newIdentity(t), newIdentity(t), newIdentity(t)
+	byAlice := attest.Sign(alice, "alice", "bob", bob.XPub, time.Now())
+	byCarol := attest.Sign(carol, "carol", "bob", bob.XPub, time.Now())
+	atts := []domain.Attestation{byAlice, byCarol, byAlice}
+
+	// Only contacts count, each once.
+	got := attest.Vouchers("bob", bob.XPub, atts, []domain.Contact{contact("alice", alice)})
+	if !slices.Equal(got, []string{"alice"}) {
+		t.Fatalf("Vouchers = %v; want [alice]", got)
+	}
+	both := []domain.Contact{contact("alice", alice), contact("carol", carol)}
+	if got := attest.Vouchers("bob", bob.XPub, atts, both); !slices.Equal(got, []string{"alice", "carol"}) {
+		t.Fatalf("Vouchers = %v; want [alice carol]", got)
+	}
+
+	// Attestations about another username or key do not count.
+	if got := attest.Vouchers("bob", carol.XPub, atts, both); len(got) != 0 {
+		t.Fatalf("Vouchers for another key = %v; want none", got)
+	}
+	if got := attest.Vouchers("dave", bob.XPub, atts, both); len(got) != 0 {
+		t.Fatalf("Vouchers for another username = %v; want none", got)
+	}
+
+	// A contact's signing key must match the one received when pairing.
+	impostor := contact("alice", alice)
+	impostor.SignKey = carol.EdPub
+	if got := attest.Vouchers("bob", bob.XPub, atts, []domain.Contact{impostor}); len(got) != 0 {
+		t.Fatalf("Vouchers with a different signing key = %v; want none", got)
+	}
+}
+
+func TestVouchers_AttesterRotated(t *testing.T) {
+	alice, bob := newIdentity(t), newIdentity(t)
+	paired := contact("alice", alice)
+	if _, err := signchain.Rotate(&alice, time.Now()); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	a := attest.Sign(alice, "alice", "bob", bob.XPub, time.Now())
+
+	if got := attest.Vouchers("bob", bob.XPub, []domain.Attestation{a}, []domain.Contact{paired}); !slices.Equal(got, []string{"alice"}) {
+		t.Fatalf("Vouchers after rotation = %v; want [alice]", got)
+	}
+	a.AttesterChain = nil
+	if got := attest.Vouchers("bob", bob.XPub, []domain.Attestation{a}, []domain.Contact{paired}); len(got) != 0 {
+		t.Fatalf("Vouchers without the chain = %v; want none", got)
+	}
+}
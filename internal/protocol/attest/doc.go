@@ -0,0 +1,31 @@
+// Package attest lets a contact vouch for a peer's identity: a signed
+// statement that a username is bound to an identity key. It is a lightweight
+// web of trust on top of pairing. A user who has paired with Alice, and Alice
+// with Bob, can see that Bob's key is the one Alice verified, without having
+// compared fingerprints with Bob themselves.
+//
+// # Statements
+//
+// The attester signs, with their current Ed25519 signing key,
+//
+//	len(subject) ‖ subject ‖ subject identity key ‖
+//	len(attester) ‖ attester ‖ attester identity key ‖ created (uint64, big-endian)
+//
+// under the signature context Context (see crypto.SignContext), with each
+// length a big-endian uint16. The attester's signing-key chain (see package
+// signchain) travels with the attestation, so it still verifies for contacts
+// who pinned an older signing key.
+//
+// # Trust
+//
+// The subject publishes the attestations it has received in its prekey
+// bundle. Anyone can read them, but only attestations from the reader's own
+// contacts count: Vouchers matches each attester's identity key against a
+// contact and requires the attester's signing key to be, or chain from, the
+// one received when pairing. Attestations are not transitive; a contact of a
+// contact counts for nothing.
+//
+// An attestation is only as good as the attester's pairing with the subject.
+// It cannot be revoked, but it names one identity key, so it stops counting
+// once the subject's key changes.
+package attest
@@ -0,0 +1,175 @@
+package body
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"ciphera/internal/domain"
+)
+
+// Magic prefixes every encoded body. It is part of the wire protocol and must
+// never change.
+const Magic = "\x00cmb"
+
+// Version is the body schema version written by Encode.
+const Version = 1
+
+// Content types understood by this client.
+const (
+	TypeText     = "text/plain"
+	TypeMarkdown = "text/markdown"
+	TypeBinary   = "application/octet-stream"
+	TypeFile     = "application/vnd.ciphera.file"    // Body is empty; Meta* describe an attachment
+	TypeReceipt  = "application/vnd.ciphera.receipt" // Body is empty; MetaReceipt* name the message
+	TypeControl  = "application/vnd.ciphera.control" // Body is a JSON domain.ControlMessage
+	TypeWipe     = "application/vnd.ciphera.wipe"    // local notice only; MetaWipeResult says what happened
+	TypePoll     = "application/vnd.ciphera.poll"    // Body is a JSON domain.Poll
+	TypeVote     = "application/vnd.ciphera.vote"    // Body is empty; MetaVote* name the poll and choice
+	TypeChunk    = "application/vnd.ciphera.chunk"   // Body is part of a larger encoded body; MetaChunk* place it
+	TypeAudit    = "application/vnd.ciphera.audit"   // local notice only; Body lists findings, MetaAudit* the outcome
+	TypePong     = "application/vnd.ciphera.pong"    // local notice only; MetaPing* describe the round trip
+	TypeRetry    = "application/vnd.ciphera.retry"   // local notice only; MetaRetryLost counts the messages lost
+)
+
+// Metadata keys used by the content types above.
+const (
+	MetaFileName    = "name"     // TypeFile, or a file sent inline under its own type: display name
+	MetaFileSize    = "size"     // TypeFile: size in bytes, decimal
+	MetaFileBlob    = "blob"     // TypeFile: relay attachment ID
+	MetaFileKey     = "key"      // TypeFile: attachment key, hex
+	MetaReceiptKind = "receipt"  // TypeReceipt: "delivered" or "read"
+	MetaReceiptOf   = "envelope" // TypeReceipt: ID of the acknowledged envelope
+	MetaWipeResult  = "result"   // TypeWipe: one of the WipeResult* values
+	MetaVotePoll    = "poll"     // TypeVote: ID of the poll voted in
+	MetaVoteChoice  = "choice"   // TypeVote: index of the chosen option, decimal, from 0
+	MetaChunkID     = "chunk"    // TypeChunk: ID shared by the parts of one message
+	MetaChunkPart   = "part"     // TypeChunk: index of this part, decimal, from 0
+	MetaChunkParts  = "parts"    // TypeChunk: number of parts, decimal
+	MetaAuditResult = "result"   // TypeAudit: one of the AuditResult* values
+	MetaAuditAsker  = "asker"    // TypeAudit: AuditAskerUs or AuditAskerPeer
+	MetaPingID      = "ping"     // TypePong: ID of the ping answered
+	MetaPingRTT     = "rtt_ms"   // TypePong: milliseconds from sending the ping to decrypting the pong, decimal
+	MetaPingPeer    = "peer_ms"  // TypePong: milliseconds the peer took to answer, by its clock, decimal
+	MetaPingSigned  = "verified" // TypePong: "true" if the pong's signature was checked, else "false"
+	MetaRetryLost   = "lost"     // TypeRetry: messages sent on the failed handshake, decimal
+)
+
+// Outcomes of a remote wipe, as reported in a TypeWipe notice.
+const (
+	WipeResultWiped      = "wiped"      // we deleted the conversation at the peer's request
+	WipeResultConfirmed  = "confirmed"  // the peer wiped and so did we
+	WipeResultRefused    = "refused"    // the peer refused our request
+	WipeResultDeclined   = "declined"   // we refused the peer's request
+	WipeResultUnverified = "unverified" // we refused it: the peer's signing key is unknown
+)
+
+// Outcomes of a state audit, as reported in a TypeAudit notice.
+const (
+	AuditResultMatch    = "match"    // both sides agree
+	AuditResultPending  = "pending"  // counters lag only by messages still in flight
+	AuditResultDiverged = "diverged" // the state has forked or been replayed
+)
+
+// Which side asked for a state audit.
+const (
+	AuditAskerUs   = "us"
+	AuditAskerPeer = "peer"
+)
+
+// Limits on metadata, so a peer cannot make us keep arbitrarily large maps.
+const (
+	maxMetaEntries  = 32
+	maxMetaKeyLen   = 64
+	maxMetaValueLen = 1024
+	maxTypeLen      = 127
+)
+
+var (
+	// ErrBadContentType is returned for a content type that is not type/subtype.
+	ErrBadContentType = errors.New("content type must be of the form type/subtype")
+	// ErrBadMetadata is returned when metadata exceeds the schema limits.
+	ErrBadMetadata = errors.New("message metadata exceeds limits")
+	// ErrUnsupportedVersion is returned for a body written with a newer schema.
+	ErrUnsupportedVersion = errors.New("message body version unsupported")
+)
+
+// Local reports whether contentType is a notice this client makes for the
+// user, which a peer must never be able to send.
+func Local(contentType string) bool {
+	return contentType == TypeWipe || contentType == TypeAudit || contentType == TypePong ||
+		contentType == TypeRetry
+}
+
+// Text returns a plain-text body.
+func Text(s string) domain.MessageBody {
+	return domain.MessageBody{Version: Version, ContentType: TypeText, Body: []byte(s)}
+}
+
+// Encode validates b and serialises it for encryption. A zero Version is
+// written as the current Version.
+func Encode(b domain.MessageBody) ([]byte, error) {
+	if b.Version == 0 {
+		b.Version = Version
+	}
+	if err := validate(b); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(Magic), raw...), nil
+}
+
+// Decode parses a decrypted payload. Payloads without Magic are legacy raw
+// bytes and decode with Version 0.
+func Decode(p []byte) (domain.MessageBody, error) {
+	raw, ok := bytes.CutPrefix(p, []byte(Magic))
+	if !ok {
+		typ := TypeText
+		if !utf8.Valid(p) {
+			typ = TypeBinary
+		}
+		return domain.MessageBody{ContentType: typ, Body: p}, nil
+	}
+
+	var b domain.MessageBody
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return domain.MessageBody{}, fmt.Errorf("decode message body: %w", err)
+	}
+	if b.Version < 1 || b.Version > Version {
+		return domain.MessageBody{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, b.Version)
+	}
+	if err := validate(b); err != nil {
+		return domain.MessageBody{}, err
+	}
+	return b, nil
+}
+
+// IsText reports whether b's content can be shown as text.
+func IsText(b domain.MessageBody) bool {
+	major, _, _ := strings.Cut(b.ContentType, "/")
+	return major == "text" && utf8.Valid(b.Body)
+}
+
+// validate checks the content type syntax and metadata limits.
+func validate(b domain.MessageBody) error {
+	major, minor, ok := strings.Cut(b.ContentType, "/")
+	if !ok || major == "" || minor == "" || len(b.ContentType) > maxTypeLen ||
+		strings.ContainsAny(b.ContentType, " \t\r\n;") {
+		return fmt.Errorf("%w: %q", ErrBadContentType, b.ContentType)
+	}
+	if len(b.Metadata) > maxMetaEntries {
+		return fmt.Errorf("%w: %d entries", ErrBadMetadata, len(b.Metadata))
+	}
+	for k, v := range b.Metadata {
+		if k == "" || len(k) > maxMetaKeyLen || len(v) > maxMetaValueLen {
+			return fmt.Errorf("%w: key %q", ErrBadMetadata, k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package body_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+)
+
+func TestEncodeDecode_RoundTrip(t *testing.T) {
+	in := domain.MessageBody{
+		ContentType: body.TypeFile,
+		Metadata:    map[string]string{body.MetaFileName: "notes.txt", body.MetaFileSize: "42"},
+	}
+	enc, err := body.Encode(in)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if !bytes.HasPrefix(enc, []byte(body.Magic)) {
+		t.Fatal("encoded body lacks magic prefix")
+	}
+	out, err := body.Decode(enc)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if out.Version != body.Version || out.ContentType != in.ContentType {
+		t.Fatalf("got version %d type %q", out.Version, out.ContentType)
+	}
+	if out.Metadata[body.MetaFileName] != "notes.txt" || out.Metadata[body.MetaFileSize] != "42" {
+		t.Fatalf("metadata = %v", out.Metadata)
+	}
+}
+
+func TestDecode_LegacyRawPayload(t *testing.T) {
+	b, err := body.Decode([]byte("hello there"))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if b.Version != 0 || b.ContentType != body.TypeText || string(b.Body) != "hello there" {
+		t.Fatalf("legacy text decoded as %+v", b)
+	}
+
+	b, err = body.Decode([]byte{0xff, 0xfe, 0x01})
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if b.ContentType != body.TypeBinary || body.IsText(b) {
+		t.Fatalf("legacy binary decoded as %q", b.ContentType)
+	}
+}
+
+func TestDecode_RejectsNewerVersion(t *testing.T) {
+	enc := []byte(body.Magic + `{"v":99,"type":"text/plain"}`)
+	if _, err := body.Decode(enc); !errors.Is(err, body.ErrUnsupportedVersion) {
+		t.Fatalf("Decode = %v, want ErrUnsupportedVersion", err)
+	}
+}
+
+func TestEncode_Validates(t *testing.T) {
+	for _, typ := range []string{"", "text", "/plain", "text/", "text/plain; charset=utf-8"} {
+		_, err := body.Encode(domain.MessageBody{ContentType: typ})
+		if !errors.Is(err, body.ErrBadContentType) {
+			t.Errorf("Encode(type %q) = %v, want ErrBadContentType", typ, err)
+		}
+	}
+
+	meta := map[string]string{"k": strings.Repeat("x", 2000)}
+	_, err := body.Encode(domain.MessageBody{ContentType: body.TypeText, Metadata: meta})
+	if !errors.Is(err, body.ErrBadMetadata) {
+		t.Fatalf("Encode(long value) = %v, want ErrBadMetadata", err)
+	}
+}
+
+func TestIsText(t *testing.T) {
+	if !body.IsText(body.Text("hi")) {
+		t.Error("text/plain not text")
+	}
+	if !body.IsText(domain.MessageBody{ContentType: body.TypeMarkdown, Body: []byte("# hi")}) {
+		t.Error("text/markdown not text")
+	}
+	if body.IsText(domain.MessageBody{ContentType: body.TypeReceipt}) {
+		t.Error("receipt reported as text")
+	}
+}
+
+func TestLocal(t *testing.T) {
+	for _, typ := range []string{body.TypeWipe, body.TypeAudit, body.TypePong, body.TypeRetry} {
+		if !body.Local(typ) {
+			t.Errorf("Local(%q) = false", typ)
+		}
+	}
+	for _, typ := range []string{body.TypeText, body.TypeControl, body.TypeReceipt} {
+		if body.Local(typ) {
+			t.Errorf("Local(%q) = true", typ)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+// Package body encodes the structured message body carried inside every
+// Double Ratchet ciphertext, so text, markdown, files, receipts and control
+// messages share one format.
+//
+// # Encoding
+//
+// An encoded body is
+//
+//	Magic ‖ JSON { "v": 1, "type": "<content type>", "body": "<base64>", "meta": { ... } }
+//
+// Magic starts with a NUL byte, which never begins the UTF-8 text that older
+// clients sent as raw bytes. Decode treats anything without Magic as such a
+// legacy payload and returns it with Version 0: "text/plain" if it is valid
+// UTF-8, otherwise "application/octet-stream".
+//
+// # Content types
+//
+// Types are MIME-like "type/subtype" strings. Receivers must render unknown
+// types as opaque content rather than reject them, so newer clients can add
+// types without breaking older ones. Metadata keys are specific to each type;
+// see the Meta constants.
+package body
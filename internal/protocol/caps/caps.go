@@ -0,0 +1,94 @@
+package caps
+
+import (
+	"slices"
+
+	"ciphera/internal/protocol/body"
+)
+
+// Capability names. They are part of the wire protocol and must never change.
+const (
+	HeaderEncryption = "header-encryption" // ratchet headers encrypted under a header key
+	PQHybrid         = "pq-hybrid"         // post-quantum KEM mixed into X3DH
+	Attachments      = "attachments"       // body.TypeFile messages
+	Receipts         = "receipts"          // body.TypeReceipt messages
+	Polls            = "polls"             // body.TypePoll and body.TypeVote messages
+	Groups           = "groups"            // group conversations
+	Chunks           = "chunks"            // body.TypeChunk messages
+)
+
+// maxNameLen bounds a capability name.
+const maxNameLen = 64
+
+// Supported lists the capabilities this client implements, in the order it
+// advertises them.
+var Supported = []string{Attachments, Receipts, Polls, Chunks}
+
+// Known reports whether c is a capability this package names.
+func Known(c string) bool {
+	switch c {
+	case HeaderEncryption, PQHybrid, Attachments, Receipts, Polls, Groups, Chunks:
+		return true
+	}
+	return false
+}
+
+// Normalize returns list sorted with duplicates and malformed names removed.
+// Unknown but well-formed names are kept. It returns nil for an empty result.
+func Normalize(list []string) []string {
+	var out []string
+	for _, c := range list {
+		if valid(c) && !slices.Contains(out, c) {
+			out = append(out, c)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// Has reports whether list advertises c.
+func Has(list []string, c string) bool {
+	return slices.Contains(list, c)
+}
+
+// ForContentType returns the capability a receiver needs to handle a body
+// of the given content type, or "" if every client handles it.
+func ForContentType(contentType string) string {
+	switch contentType {
+	case body.TypeFile:
+		return Attachments
+	case body.TypeReceipt:
+		return Receipts
+	case body.TypePoll, body.TypeVote:
+		return Polls
+	case body.TypeChunk:
+		return Chunks
+	}
+	return ""
+}
+
+// Missing returns the capability a peer advertising list lacks for a body of
+// the given content type, or "" if nothing is missing. An empty list means
+// the peer predates capabilities, so nothing is reported missing.
+func Missing(list []string, contentType string) string {
+	need := ForContentType(contentType)
+	if need == "" || len(list) == 0 || Has(list, need) {
+		return ""
+	}
+	return need
+}
+
+// valid reports whether c is a well-formed name: lowercase ASCII letters,
+// digits and hyphens, starting with a letter.
+func valid(c string) bool {
+	if c == "" || len(c) > maxNameLen || c[0] < 'a' || c[0] > 'z' {
+		return false
+	}
+	for i := 1; i < len(c); i++ {
+		ch := c[i]
+		if (ch < 'a' || ch > 'z') && (ch < '0' || ch > '9') && ch != '-' {
+			return false
+		}
+	}
+	return true
+}
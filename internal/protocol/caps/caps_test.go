@@ -0,0 +1,57 @@
+package caps_test
+
+import (
+	"slices"
+	"testing"
+
+	"ciphera/internal/protocol/body"
+	"ciphera/internal/protocol/caps"
+)
+
+func TestNormalize_KeepsUnknownDropsMalformed(t *testing.T) {
+	in := []string{caps.Receipts, "future-thing", "Bad", "", "a b", caps.Attachments, caps.Receipts}
+	got := caps.Normalize(in)
+	want := []string{caps.Attachments, "future-thing", caps.Receipts}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Normalize = %v, want %v", got, want)
+	}
+	if caps.Known("future-thing") {
+		t.Fatal("future-thing reported as known")
+	}
+	if got := caps.Normalize([]string{"!"}); got != nil {
+		t.Fatalf("Normalize of only malformed names = %v, want nil", got)
+	}
+}
+
+func TestSupported_AreKnown(t *testing.T) {
+	for _, c := range caps.Supported {
+		if !caps.Known(c) {
+			t.Fatalf("Supported lists unknown capability %q", c)
+		}
+	}
+	if !slices.Equal(caps.Normalize(caps.Supported), slices.Sorted(slices.Values(caps.Supported))) {
+		t.Fatal("Supported contains malformed or duplicate names")
+	}
+}
+
+func TestMissing(t *testing.T) {
+	tests := []struct {
+		name string
+		peer []string
+		typ  string
+		want string
+	}{
+		{"plain text needs nothing", []string{caps.Receipts}, body.TypeText, ""},
+		{"file to peer with attachments", []string{caps.Attachments}, body.TypeFile, ""},
+		{"file to peer without attachments", []string{caps.Receipts}, body.TypeFile, caps.Attachments},
+		{"receipt to peer without receipts", []string{"future-thing"}, body.TypeReceipt, caps.Receipts},
+		{"peer predating capabilities", nil, body.TypeFile, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := caps.Missing(tc.peer, tc.typ); got != tc.want {
+				t.Fatalf("Missing = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+// Package caps names the optional features a client can advertise in its
+// prekey bundle, so a sender can tell what a peer handles before sending
+// rather than finding out when the message fails to render.
+//
+// # Names
+//
+// Capabilities are short lowercase names such as "attachments". A bundle
+// lists the ones its client supports; Supported is this client's list.
+// Some names are reserved for features not yet implemented here and are
+// never advertised by this client until they are.
+//
+// # Compatibility
+//
+// Names a client does not recognise are kept, not rejected, so newer peers
+// can advertise features older clients have never heard of. Malformed names
+// are dropped by Normalize. A bundle with no capabilities at all comes from
+// a client that predates them: nothing is known about it, so Missing never
+// reports a feature as absent.
+package caps
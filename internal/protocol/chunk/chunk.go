@@ -0,0 +1,112 @@
+package chunk
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+)
+
+// Limits on chunked messages.
+const (
+	// MaxPlaintext is the largest encoded body sent in a single envelope. It
+	// leaves room under the relay's 64 KiB cap for the AEAD tag.
+	MaxPlaintext = 63 << 10
+	// PartSize is the number of encoded bytes per part. Body bytes are base64
+	// in the encoded chunk, so a full part still fits under MaxPlaintext.
+	PartSize = 44 << 10
+	// MaxParts bounds a message at about 11 MiB.
+	MaxParts = 256
+
+	idLen    = 16
+	maxIDLen = 32
+)
+
+var (
+	// ErrTooLarge is returned for a body that would need more than MaxParts
+	// parts.
+	ErrTooLarge = errors.New("message too large to send in chunks")
+	// ErrBadChunk is returned for a chunk message that is malformed or
+	// does not fit with the parts received before it.
+	ErrBadChunk = errors.New("malformed chunk message")
+)
+
+// Oversize reports whether an encoded body must be sent in chunks.
+func Oversize(plaintext []byte) bool {
+	return len(plaintext) > MaxPlaintext
+}
+
+// Split cuts an encoded body into chunk message bodies sharing a fresh ID.
+func Split(plaintext []byte) ([]domain.MessageBody, error) {
+	n := (len(plaintext) + PartSize - 1) / PartSize
+	if n > MaxParts {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, len(plaintext))
+	}
+	id := rand.Text()[:idLen]
+	out := make([]domain.MessageBody, 0, n)
+	for i := range n {
+		end := min((i+1)*PartSize, len(plaintext))
+		out = append(out, domain.MessageBody{
+			ContentType: body.TypeChunk,
+			Body:        plaintext[i*PartSize : end],
+			Metadata: map[string]string{
+				body.MetaChunkID:    id,
+				body.MetaChunkPart:  strconv.Itoa(i),
+				body.MetaChunkParts: strconv.Itoa(n),
+			},
+		})
+	}
+	return out, nil
+}
+
+// Parse returns the part carried by a body.TypeChunk body.
+func Parse(b domain.MessageBody) (domain.ChunkPart, error) {
+	if b.ContentType != body.TypeChunk {
+		return domain.ChunkPart{}, ErrBadChunk
+	}
+	id := b.Metadata[body.MetaChunkID]
+	index, err1 := strconv.Atoi(b.Metadata[body.MetaChunkPart])
+	total, err2 := strconv.Atoi(b.Metadata[body.MetaChunkParts])
+	switch {
+	case id == "" || len(id) > maxIDLen || err1 != nil || err2 != nil:
+		return domain.ChunkPart{}, ErrBadChunk
+	case total < 1 || total > MaxParts || index < 0 || index >= total:
+		return domain.ChunkPart{}, fmt.Errorf("%w: part %d of %d", ErrBadChunk, index, total)
+	case len(b.Body) > PartSize:
+		return domain.ChunkPart{}, fmt.Errorf("%w: part of %d bytes", ErrBadChunk, len(b.Body))
+	}
+	return domain.ChunkPart{ID: id, Index: index, Total: total, Data: b.Body}, nil
+}
+
+// Complete reports whether parts, as a store returns them for one message,
+// hold every part of it.
+func Complete(parts []domain.ChunkPart) bool {
+	return len(parts) > 0 && len(parts) == parts[0].Total
+}
+
+// Join decodes the body carried by the complete, ordered parts of one
+// message.
+func Join(parts []domain.ChunkPart) (domain.MessageBody, error) {
+	if !Complete(parts) {
+		return domain.MessageBody{}, fmt.Errorf("%w: incomplete message", ErrBadChunk)
+	}
+	var buf bytes.Buffer
+	for i, p := range parts {
+		if p.ID != parts[0].ID || p.Index != i || p.Total != parts[0].Total {
+			return domain.MessageBody{}, fmt.Errorf("%w: parts do not match", ErrBadChunk)
+		}
+		buf.Write(p.Data)
+	}
+	b, err := body.Decode(buf.Bytes())
+	if err != nil {
+		return domain.MessageBody{}, err
+	}
+	if b.ContentType == body.TypeChunk {
+		return domain.MessageBody{}, fmt.Errorf("%w: nested chunk", ErrBadChunk)
+	}
+	return b, nil
+}
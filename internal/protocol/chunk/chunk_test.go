@@ -0,0 +1,117 @@
+package chunk_test
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+	"ciphera/internal/protocol/chunk"
+)
+
+// parse returns the parts carried by bodies.
+func parse(t *testing.T, bodies []domain.MessageBody) []domain.ChunkPart {
+	t.Helper()
+	parts := make([]domain.ChunkPart, len(bodies))
+	for i, b := range bodies {
+		p, err := chunk.Parse(b)
+		if err != nil {
+			t.Fatalf("Parse part %d: %v", i, err)
+		}
+		parts[i] = p
+	}
+	return parts
+}
+
+func TestSplitJoin_RoundTrip(t *testing.T) {
+	want := domain.MessageBody{
+		ContentType: body.TypeText,
+		Body:        bytes.Repeat([]byte("paste "), 30000),
+		Metadata:    map[string]string{"k": "v"},
+	}
+	plain, err := body.Encode(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !chunk.Oversize(plain) {
+		t.Fatalf("%d-byte body not oversize", len(plain))
+	}
+	bodies, err := chunk.Split(plain)
+	if err != nil {
+		t.Fatalf("Split: %v", err)
+	}
+	if n := (len(plain) + chunk.PartSize - 1) / chunk.PartSize; len(bodies) != n {
+		t.Fatalf("Split made %d parts, want %d", len(bodies), n)
+	}
+	for i, b := range bodies {
+		enc, err := body.Encode(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunk.Oversize(enc) {
+			t.Fatalf("part %d encodes to %d bytes, over MaxPlaintext", i, len(enc))
+		}
+	}
+
+	got, err := chunk.Join(parse(t, bodies))
+	if err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	if got.ContentType != want.ContentType || !bytes.Equal(got.Body, want.Body) || got.Metadata["k"] != "v" {
+		t.Fatalf("Join returned %s with %d bytes, want the original", got.ContentType, len(got.Body))
+	}
+}
+
+func TestJoin_RejectsIncompleteAndMixed(t *testing.T) {
+	plain := bytes.Repeat([]byte{'x'}, 3*chunk.PartSize)
+	a, _ := chunk.Split(plain)
+	b, _ := chunk.Split(plain)
+	pa, pb := parse(t, a), parse(t, b)
+
+	if _, err := chunk.Join(pa[:2]); !errors.Is(err, chunk.ErrBadChunk) {
+		t.Fatalf("Join of 2 of 3 parts = %v, want ErrBadChunk", err)
+	}
+	mixed := slices.Concat(pa[:2], pb[2:])
+	if _, err := chunk.Join(mixed); !errors.Is(err, chunk.ErrBadChunk) {
+		t.Fatalf("Join of parts of two messages = %v, want ErrBadChunk", err)
+	}
+}
+
+func TestParse_Rejects(t *testing.T) {
+	good := func() domain.MessageBody {
+		return domain.MessageBody{
+			ContentType: body.TypeChunk,
+			Body:        []byte("x"),
+			Metadata: map[string]string{
+				body.MetaChunkID:    "abc",
+				body.MetaChunkPart:  "0",
+				body.MetaChunkParts: "2",
+			},
+		}
+	}
+	if _, err := chunk.Parse(good()); err != nil {
+		t.Fatalf("Parse of a good part: %v", err)
+	}
+	for name, mutate := range map[string]func(*domain.MessageBody){
+		"text":          func(b *domain.MessageBody) { b.ContentType = body.TypeText },
+		"no id":         func(b *domain.MessageBody) { delete(b.Metadata, body.MetaChunkID) },
+		"index too big": func(b *domain.MessageBody) { b.Metadata[body.MetaChunkPart] = "2" },
+		"too many":      func(b *domain.MessageBody) { b.Metadata[body.MetaChunkParts] = strconv.Itoa(chunk.MaxParts + 1) },
+		"oversize part": func(b *domain.MessageBody) { b.Body = make([]byte, chunk.PartSize+1) },
+	} {
+		b := good()
+		mutate(&b)
+		if _, err := chunk.Parse(b); !errors.Is(err, chunk.ErrBadChunk) {
+			t.Fatalf("%s: Parse = %v, want ErrBadChunk", name, err)
+		}
+	}
+}
+
+func TestSplit_TooLarge(t *testing.T) {
+	if _, err := chunk.Split(make([]byte, chunk.MaxParts*chunk.PartSize+1)); !errors.Is(err, chunk.ErrTooLarge) {
+		t.Fatalf("Split = %v, want ErrTooLarge", err)
+	}
+}
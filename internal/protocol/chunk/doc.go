@@ -0,0 +1,20 @@
+// Package chunk splits a message body too large for one relay envelope into
+// chunk messages and reassembles them on receipt.
+//
+// # Messages
+//
+// The relay refuses envelopes with more than 64 KiB of ciphertext. A sender
+// whose encoded body (see package body) exceeds MaxPlaintext cuts the encoded
+// bytes into parts and sends each as a body.TypeChunk message: the part's
+// bytes in Body, and in Metadata the message ID (body.MetaChunkID), the
+// part's index from 0 (body.MetaChunkPart) and the number of parts
+// (body.MetaChunkParts). Every part travels inside the Double Ratchet like
+// any other message, so the relay sees only a run of full-size envelopes.
+//
+// # Reassembly
+//
+// The receiver keeps parts until it holds all of them, in any order, then
+// joins their bytes and decodes the result as the original body, with its
+// content type and metadata. A joined body that is itself a chunk is
+// refused. Parts that never complete are dropped by the store after a while.
+package chunk
@@ -0,0 +1,17 @@
+// Package keyid names signed and one-time prekeys.
+//
+// # Scheme
+//
+// An ID is the kind of key, the scheme version and 128 random bits in
+// lowercase unpadded base32, such as "opk1_" followed by 26 characters. IDs
+// are never derived from the time or a counter, so two batches of prekeys
+// generated in the same second cannot share one. The store still refuses an
+// ID it already holds for another key (see store.ErrKeyIDCollision).
+//
+// # Compatibility
+//
+// Clients before this scheme named keys "spk-<unix time>" and
+// "opk-<unix time>-<index>". Such IDs are still accepted in bundles, prekey
+// messages and stored keys, but never generated. Anything else is refused by
+// Check before it is used to look a key up.
+package keyid
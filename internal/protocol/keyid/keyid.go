@@ -0,0 +1,135 @@
+package keyid
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+
+	"ciphera/internal/domain"
+)
+
+// Kind is the kind of key an ID names.
+type Kind string
+
+// Key kinds. They are part of the wire protocol and must never change.
+const (
+	SignedPrekey  Kind = "spk"
+	OneTimePrekey Kind = "opk"
+)
+
+// version is the scheme version written after the kind.
+const version = "1"
+
+// randomBytes is how much randomness an ID carries.
+const randomBytes = 16
+
+// encoding writes the random part of an ID.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// maxLegacyDigits bounds the numbers in a legacy ID.
+const maxLegacyDigits = 19
+
+var (
+	// ErrInvalid indicates an ID that follows neither the scheme nor the
+	// legacy form for its kind.
+	ErrInvalid = errors.New("malformed key ID")
+	// ErrDuplicate indicates a bundle naming two one-time prekeys alike.
+	ErrDuplicate = errors.New("duplicate key ID")
+)
+
+// New returns a fresh random ID for a key of kind k.
+func New(k Kind) (domain.KeyID, error) {
+	var b [randomBytes]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return domain.KeyID(prefix(k) + strings.ToLower(encoding.EncodeToString(b[:]))), nil
+}
+
+// Check reports whether id names a key of kind k, in the current scheme or
+// the legacy one, and returns ErrInvalid if not.
+func Check(k Kind, id domain.KeyID) error {
+	if current(k, id) || Legacy(k, id) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s %.40q", ErrInvalid, k, string(id))
+}
+
+// Legacy reports whether id is in the form older clients generated for kind
+// k: "spk-<unix time>" or "opk-<unix time>-<index>".
+func Legacy(k Kind, id domain.KeyID) bool {
+	rest, ok := strings.CutPrefix(string(id), string(k)+"-")
+	if !ok {
+		return false
+	}
+	parts := strings.Split(rest, "-")
+	want := 1
+	if k == OneTimePrekey {
+		want = 2
+	}
+	if len(parts) != want {
+		return false
+	}
+	for _, p := range parts {
+		if !digits(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckBundle checks the IDs in b: its signed prekey's, and its one-time
+// prekeys' (see CheckOneTime).
+func CheckBundle(b domain.PrekeyBundle) error {
+	if err := Check(SignedPrekey, b.SPKID); err != nil {
+		return err
+	}
+	return CheckOneTime(b.OneTime)
+}
+
+// CheckOneTime checks the IDs of one-time prekeys keys, which must also
+// differ from each other.
+func CheckOneTime(keys []domain.OneTimePub) error {
+	seen := make(map[domain.KeyID]bool, len(keys))
+	for _, k := range keys {
+		if err := Check(OneTimePrekey, k.ID); err != nil {
+			return err
+		}
+		if seen[k.ID] {
+			return fmt.Errorf("%w: %s", ErrDuplicate, k.ID)
+		}
+		seen[k.ID] = true
+	}
+	return nil
+}
+
+// current reports whether id follows the current scheme for kind k.
+func current(k Kind, id domain.KeyID) bool {
+	rest, ok := strings.CutPrefix(string(id), prefix(k))
+	if !ok || len(rest) != encoding.EncodedLen(randomBytes) || rest != strings.ToLower(rest) {
+		return false
+	}
+	_, err := encoding.DecodeString(strings.ToUpper(rest))
+	return err == nil
+}
+
+// prefix returns the prefix of IDs of kind k in the current scheme.
+func prefix(k Kind) string {
+	return string(k) + version + "_"
+}
+
+// digits reports whether s is a non-empty run of at most maxLegacyDigits
+// decimal digits.
+func digits(s string) bool {
+	if s == "" || len(s) > maxLegacyDigits {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package keyid_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/keyid"
+)
+
+func TestNew_FixedLengthAndUnique(t *testing.T) {
+	seen := make(map[domain.KeyID]bool)
+	for range 1000 {
+		id, err := keyid.New(keyid.OneTimePrekey)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		if !strings.HasPrefix(string(id), "opk1_") || len(id) != len("opk1_")+26 {
+			t.Fatalf("New = %q, want opk1_ and 26 characters", id)
+		}
+		if err := keyid.Check(keyid.OneTimePrekey, id); err != nil {
+			t.Fatalf("Check(%q): %v", id, err)
+		}
+		if keyid.Legacy(keyid.OneTimePrekey, id) {
+			t.Fatalf("New generated a legacy ID %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("New repeated %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestCheck(t *testing.T) {
+	spk, err := keyid.New(keyid.SignedPrekey)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	tests := []struct {
+		name string
+		kind keyid.Kind
+		id   domain.KeyID
+		ok   bool
+	}{
+		{"current", keyid.SignedPrekey, spk, true},
+		{"other kind", keyid.OneTimePrekey, spk, false},
+		{"upper case", keyid.SignedPrekey, domain.KeyID(strings.ToUpper(string(spk))), false},
+		{"truncated", keyid.SignedPrekey, spk[:len(spk)-1], false},
+		{"unknown version", keyid.SignedPrekey, "spk2_" + spk[5:], false},
+		{"legacy spk", keyid.SignedPrekey, "spk-1700000000", true},
+		{"legacy opk", keyid.OneTimePrekey, "opk-1700000000-42", true},
+		{"legacy opk without index", keyid.OneTimePrekey, "opk-1700000000", false},
+		{"legacy spk with index", keyid.SignedPrekey, "spk-1700000000-1", false},
+		{"legacy non-digits", keyid.SignedPrekey, "spk-17x", false},
+		{"free-form", keyid.SignedPrekey, "spk-test", false},
+		{"empty", keyid.SignedPrekey, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := keyid.Check(tt.kind, tt.id)
+			if tt.ok && err != nil {
+				t.Fatalf("Check(%q): %v", tt.id, err)
+			}
+			if !tt.ok && !errors.Is(err, keyid.ErrInvalid) {
+				t.Fatalf("Check(%q) = %v, want ErrInvalid", tt.id, err)
+			}
+		})
+	}
+}
+
+func TestCheckBundle(t *testing.T) {
+	spk, _ := keyid.New(keyid.SignedPrekey)
+	opk, _ := keyid.New(keyid.OneTimePrekey)
+	b := domain.PrekeyBundle{SPKID: spk, OneTime: []domain.OneTimePub{{ID: opk}, {ID: "opk-1700000000-0"}}}
+	if err := keyid.CheckBundle(b); err != nil {
+		t.Fatalf("CheckBundle: %v", err)
+	}
+
+	b.OneTime = append(b.OneTime, domain.OneTimePub{ID: opk})
+	if err := keyid.CheckBundle(b); !errors.Is(err, keyid.ErrDuplicate) {
+		t.Fatalf("CheckBundle with a repeated OPK = %v, want ErrDuplicate", err)
+	}
+	b.OneTime = []domain.OneTimePub{{ID: spk}}
+	if err := keyid.CheckBundle(b); !errors.Is(err, keyid.ErrInvalid) {
+		t.Fatalf("CheckBundle with an SPK ID as OPK = %v, want ErrInvalid", err)
+	}
+}
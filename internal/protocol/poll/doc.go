@@ -0,0 +1,23 @@
+// Package poll encodes polls and votes as message bodies and tallies them
+// from the local history. Both travel inside the Double Ratchet like any
+// other message, so the relay never sees a question, an option or a vote.
+//
+// # Messages
+//
+// A poll is a body.TypePoll message whose body is a JSON domain.Poll. Its ID
+// is chosen by the creator, who may send the same poll to several peers
+// (for example to a broadcast list). A vote is a body.TypeVote message with
+// the poll's ID in body.MetaVotePoll and the index of the chosen option in
+// body.MetaVoteChoice.
+//
+// # Tallying
+//
+// There is no server to count votes, so each client tallies what it has seen:
+// the polls and votes in its own history. A voter's latest vote replaces any
+// earlier one. A vote only counts if it comes from the poll's creator or a
+// peer the poll was exchanged with, so a peer cannot vote in a poll it was
+// never shown by reusing its ID. Imported history is never counted.
+//
+// A creator therefore sees every vote cast, while a participant sees its own
+// vote and the creator's; participants' votes are sent to the creator only.
+package poll
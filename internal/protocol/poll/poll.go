@@ -0,0 +1,188 @@
+package poll
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+)
+
+// Limits on a poll, so a peer cannot make us store or print huge ones.
+const (
+	MinOptions     = 2
+	MaxOptions     = 10
+	maxQuestionLen = 300
+	maxOptionLen   = 100
+	maxIDLen       = 32
+	idLen          = 10
+)
+
+var (
+	// ErrBadPoll is returned for a poll that is malformed or exceeds the limits.
+	ErrBadPoll = errors.New("malformed poll")
+	// ErrBadVote is returned for a vote that is malformed.
+	ErrBadVote = errors.New("malformed vote")
+)
+
+// New returns a poll with a fresh ID and its message body. Surrounding space
+// is trimmed from the question and options.
+func New(question string, options []string) (domain.Poll, domain.MessageBody, error) {
+	p := domain.Poll{
+		ID:       rand.Text()[:idLen],
+		Question: strings.TrimSpace(question),
+	}
+	for _, o := range options {
+		p.Options = append(p.Options, strings.TrimSpace(o))
+	}
+	if err := validate(p); err != nil {
+		return domain.Poll{}, domain.MessageBody{}, err
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return domain.Poll{}, domain.MessageBody{}, err
+	}
+	return p, domain.MessageBody{ContentType: body.TypePoll, Body: data}, nil
+}
+
+// Parse returns the poll carried by a body.TypePoll body.
+func Parse(b domain.MessageBody) (domain.Poll, error) {
+	if b.ContentType != body.TypePoll {
+		return domain.Poll{}, ErrBadPoll
+	}
+	var p domain.Poll
+	if err := json.Unmarshal(b.Body, &p); err != nil {
+		return domain.Poll{}, fmt.Errorf("%w: %v", ErrBadPoll, err)
+	}
+	if err := validate(p); err != nil {
+		return domain.Poll{}, err
+	}
+	return p, nil
+}
+
+// Vote returns the body of a vote for option choice (from 0) in poll id.
+func Vote(id string, choice int) domain.MessageBody {
+	return domain.MessageBody{
+		ContentType: body.TypeVote,
+		Metadata: map[string]string{
+			body.MetaVotePoll:   id,
+			body.MetaVoteChoice: strconv.Itoa(choice),
+		},
+	}
+}
+
+// ParseVote returns the poll ID and choice of a body.TypeVote body.
+func ParseVote(b domain.MessageBody) (id string, choice int, err error) {
+	id = b.Metadata[body.MetaVotePoll]
+	choice, err = strconv.Atoi(b.Metadata[body.MetaVoteChoice])
+	if b.ContentType != body.TypeVote || err != nil || choice < 0 || choice >= MaxOptions || !validID(id) {
+		return "", 0, ErrBadVote
+	}
+	return id, choice, nil
+}
+
+// Tally returns every poll in entries, oldest first, with the latest vote of
+// each voter allowed to vote in it. Entries must be oldest first.
+func Tally(entries []domain.HistoryEntry) []domain.PollTally {
+	var (
+		tallies []*domain.PollTally
+		byID    = make(map[string]*domain.PollTally)
+	)
+	for _, e := range entries {
+		if e.Source != "" {
+			continue
+		}
+		p, err := Parse(e.Body)
+		if err != nil {
+			continue
+		}
+		creator := ""
+		if e.Direction == domain.HistoryIn {
+			creator = e.Peer
+		}
+		t, ok := byID[p.ID]
+		switch {
+		case !ok:
+			t = &domain.PollTally{Poll: p, Creator: creator, CreatedUTC: e.SentUTC}
+			byID[p.ID] = t
+			tallies = append(tallies, t)
+		case t.Creator != creator:
+			continue // someone else's poll under a reused ID
+		}
+		if !slices.Contains(t.Participants, e.Peer) {
+			t.Participants = append(t.Participants, e.Peer)
+		}
+	}
+
+	for _, e := range entries {
+		if e.Source != "" {
+			continue
+		}
+		id, choice, err := ParseVote(e.Body)
+		t := byID[id]
+		if err != nil || t == nil || choice >= len(t.Options) {
+			continue
+		}
+		voter := ""
+		if e.Direction == domain.HistoryIn {
+			voter = e.Peer
+			if !slices.Contains(t.Participants, voter) {
+				continue
+			}
+		}
+		v := domain.PollVote{Voter: voter, Choice: choice, SentUTC: e.SentUTC}
+		if i := slices.IndexFunc(t.Votes, func(v domain.PollVote) bool { return v.Voter == voter }); i >= 0 {
+			t.Votes[i] = v
+		} else {
+			t.Votes = append(t.Votes, v)
+		}
+	}
+
+	out := make([]domain.PollTally, len(tallies))
+	for i, t := range tallies {
+		out[i] = *t
+	}
+	return out
+}
+
+// validate checks p against the limits.
+func validate(p domain.Poll) error {
+	if !validID(p.ID) {
+		return fmt.Errorf("%w: bad ID", ErrBadPoll)
+	}
+	if p.Question == "" || utf8.RuneCountInString(p.Question) > maxQuestionLen {
+		return fmt.Errorf("%w: question must be 1 to %d characters", ErrBadPoll, maxQuestionLen)
+	}
+	if len(p.Options) < MinOptions || len(p.Options) > MaxOptions {
+		return fmt.Errorf("%w: want %d to %d options", ErrBadPoll, MinOptions, MaxOptions)
+	}
+	for i, o := range p.Options {
+		if o == "" || utf8.RuneCountInString(o) > maxOptionLen {
+			return fmt.Errorf("%w: options must be 1 to %d characters", ErrBadPoll, maxOptionLen)
+		}
+		if slices.Contains(p.Options[:i], o) {
+			return fmt.Errorf("%w: option %q given twice", ErrBadPoll, o)
+		}
+	}
+	return nil
+}
+
+// validID reports whether id is a plausible poll ID: short, printable ASCII
+// without spaces, so it can be typed on a command line.
+func validID(id string) bool {
+	if id == "" || len(id) > maxIDLen {
+		return false
+	}
+	for _, c := range []byte(id) {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package poll_test
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+	"ciphera/internal/protocol/poll"
+)
+
+func TestNew_Parse(t *testing.T) {
+	p, b, err := poll.New(" Lunch? ", []string{"pizza", " sushi "})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if b.ContentType != body.TypePoll || p.ID == "" {
+		t.Fatalf("New = %+v, %+v; want a poll body with an ID", p, b)
+	}
+	got, err := poll.Parse(b)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got.ID != p.ID || got.Question != "Lunch?" || !slices.Equal(got.Options, []string{"pizza", "sushi"}) {
+		t.Fatalf("Parse = %+v, want %+v trimmed", got, p)
+	}
+}
+
+func TestNew_Limits(t *testing.T) {
+	for name, opts := range map[string][]string{
+		"one option":   {"yes"},
+		"too many":     strings.Split("a b c d e f g h i j k", " "),
+		"empty option": {"yes", " "},
+		"duplicate":    {"yes", "yes"},
+		"long option":  {"yes", strings.Repeat("x", 101)},
+	} {
+		if _, _, err := poll.New("Q?", opts); !errors.Is(err, poll.ErrBadPoll) {
+			t.Errorf("%s: err = %v, want ErrBadPoll", name, err)
+		}
+	}
+	if _, _, err := poll.New("", []string{"a", "b"}); !errors.Is(err, poll.ErrBadPoll) {
+		t.Errorf("empty question: err = %v, want ErrBadPoll", err)
+	}
+}
+
+func TestVote_ParseVote(t *testing.T) {
+	id, choice, err := poll.ParseVote(poll.Vote("abc", 2))
+	if err != nil || id != "abc" || choice != 2 {
+		t.Fatalf("ParseVote = %q, %d, %v; want abc, 2", id, choice, err)
+	}
+	for name, b := range map[string]domain.MessageBody{
+		"negative": poll.Vote("abc", -1),
+		"no poll":  poll.Vote("", 0),
+		"spaced":   poll.Vote("a b", 0),
+		"text":     body.Text("abc"),
+	} {
+		if _, _, err := poll.ParseVote(b); !errors.Is(err, poll.ErrBadVote) {
+			t.Errorf("%s: err = %v, want ErrBadVote", name, err)
+		}
+	}
+}
+
+func TestTally(t *testing.T) {
+	p, pb, err := poll.New("Lunch?", []string{"pizza", "sushi", "salad"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	// Mallory's poll reuses the ID; it and her votes must not count.
+	forged := domain.MessageBody{ContentType: body.TypePoll, Body: []byte(`{"id":"` + p.ID + `","question":"x","options":["a","b"]}`)}
+
+	entry := func(peer string, dir domain.HistoryDirection, b domain.MessageBody, at int64) domain.HistoryEntry {
+		return domain.HistoryEntry{Peer: peer, Direction: dir, Body: b, SentUTC: at}
+	}
+	in, out := domain.HistoryIn, domain.HistoryOut
+	entries := []domain.HistoryEntry{
+		entry("alice", out, pb, 1),
+		entry("bob", out, pb, 1),
+		entry("mallory", in, forged, 2),
+		entry("alice", in, poll.Vote(p.ID, 0), 3),
+		entry("bob", in, poll.Vote(p.ID, 0), 4),
+		entry("bob", in, poll.Vote(p.ID, 1), 5), // bob changes his mind
+		entry("mallory", in, poll.Vote(p.ID, 2), 6),
+		entry("alice", out, poll.Vote(p.ID, 1), 7),
+		entry("bob", out, poll.Vote(p.ID, 1), 7),
+		entry("carol", in, poll.Vote(p.ID, 9), 8), // out of range
+	}
+	imported := entry("dave", in, poll.Vote(p.ID, 2), 9)
+	imported.Source = "signal-backup"
+	entries = append(entries, imported)
+
+	got := poll.Tally(entries)
+	if len(got) != 1 {
+		t.Fatalf("Tally returned %d polls, want 1: %+v", len(got), got)
+	}
+	tl := got[0]
+	if tl.Creator != "" || tl.Question != "Lunch?" || !slices.Equal(tl.Participants, []string{"alice", "bob"}) {
+		t.Fatalf("tally = %+v; want our poll with alice and bob", tl)
+	}
+	if c := tl.Counts(); !slices.Equal(c, []int{1, 2, 0}) {
+		t.Fatalf("Counts = %v, want [1 2 0] (alice pizza, bob and us sushi)", c)
+	}
+
+	// A participant counts the creator's vote and its own.
+	recv := []domain.HistoryEntry{
+		entry("carol", in, pb, 1),
+		entry("carol", out, poll.Vote(p.ID, 2), 2),
+		entry("carol", in, poll.Vote(p.ID, 2), 3),
+		entry("bob", in, poll.Vote(p.ID, 0), 4), // never shown this poll
+	}
+	got = poll.Tally(recv)
+	if len(got) != 1 || got[0].Creator != "carol" || !slices.Equal(got[0].Counts(), []int{0, 0, 2}) {
+		t.Fatalf("participant tally = %+v, want carol's poll with two salad votes", got)
+	}
+}
@@ -0,0 +1,17 @@
+// Package pow is the proof-of-work a relay may ask of a client registering a
+// new username, so that claiming names in bulk costs real computation.
+//
+// # Puzzle
+//
+// The relay hands out an opaque nonce and a difficulty in bits. The client
+// searches for an answer, a decimal counter, such that
+//
+//	SHA-256(Context ‖ len(nonce) ‖ nonce ‖ len(user) ‖ user ‖ answer)
+//
+// starts with at least that many zero bits, each length a big-endian uint16.
+// The username is bound so an answer cannot be spent on another name. Solving
+// takes about 2^bits hashes and checking takes one.
+//
+// The nonce's format is the relay's business: it may encode an expiry and a
+// MAC so that the relay need not remember the nonces it issued.
+package pow
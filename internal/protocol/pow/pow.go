@@ -0,0 +1,65 @@
+package pow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+	"strconv"
+)
+
+// Context separates registration proof-of-work hashes from any other use of
+// SHA-256. It is part of the wire protocol and must never change.
+const Context = "ciphera/register-pow-v1"
+
+// MaxBits is the most work a client agrees to do. At 32 bits a solve takes
+// minutes on a laptop; a relay asking for more is refused rather than obeyed.
+const MaxBits = 32
+
+// checkEvery is how many hashes Solve tries between looks at its context.
+const checkEvery = 1 << 14
+
+// Check reports whether answer solves nonce for user at the given difficulty.
+func Check(nonce, user, answer string, difficulty int) bool {
+	return leadingZeros(digest(nonce, user, answer)) >= difficulty
+}
+
+// Solve searches for an answer to nonce for user at the given difficulty. It
+// stops with ctx's error if ctx ends first.
+func Solve(ctx context.Context, nonce, user string, difficulty int) (string, error) {
+	for n := uint64(0); ; n++ {
+		if n%checkEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+		}
+		answer := strconv.FormatUint(n, 10)
+		if Check(nonce, user, answer, difficulty) {
+			return answer, nil
+		}
+	}
+}
+
+// digest hashes the puzzle input for answer.
+func digest(nonce, user, answer string) [sha256.Size]byte {
+	buf := make([]byte, 0, len(Context)+2+len(nonce)+2+len(user)+len(answer))
+	buf = append(buf, Context...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(nonce)))
+	buf = append(buf, nonce...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(user)))
+	buf = append(buf, user...)
+	buf = append(buf, answer...)
+	return sha256.Sum256(buf)
+}
+
+// leadingZeros counts the zero bits at the start of d.
+func leadingZeros(d [sha256.Size]byte) int {
+	n := 0
+	for _, b := range d {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,45 @@
+package pow_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"ciphera/internal/protocol/pow"
+)
+
+func TestSolve_Check(t *testing.T) {
+	const bits = 12
+	answer, err := pow.Solve(context.Background(), "nonce", "alice", bits)
+	if err != nil {
+		t.Fatalf("Solve: %v", err)
+	}
+	if !pow.Check("nonce", "alice", answer, bits) {
+		t.Fatalf("Check rejected Solve's answer %q", answer)
+	}
+
+	// The nonce and username are both bound. Solve is deterministic, so
+	// these cannot pass by chance on one run and fail on the next.
+	if pow.Check("nonce", "bob", answer, bits) {
+		t.Error("answer checked for another user")
+	}
+	if pow.Check("other", "alice", answer, bits) {
+		t.Error("answer checked for another nonce")
+	}
+
+	// Difficulty zero accepts anything; an impossible difficulty nothing.
+	if !pow.Check("nonce", "alice", "x", 0) {
+		t.Error("difficulty 0 rejected an answer")
+	}
+	if pow.Check("nonce", "alice", answer, 257) {
+		t.Error("difficulty beyond the hash size accepted an answer")
+	}
+}
+
+func TestSolve_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pow.Solve(ctx, "nonce", "alice", 64); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Solve with a cancelled context: err = %v, want context.Canceled", err)
+	}
+}
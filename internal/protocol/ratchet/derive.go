@@ -0,0 +1,44 @@
+package ratchet
+
+import (
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+)
+
+// This file exposes the individual derivation steps used by Encrypt and Decrypt
+// so that other implementations can be checked against Ciphera step by step.
+// The derivations themselves live in internal/crypto. None of these functions
+// touch RatchetState, and all use crypto.DefaultSuite.
+
+// defaultSuite is crypto.DefaultSuite, which the steps below use.
+var defaultSuite, _ = crypto.LookupSuite(crypto.DefaultSuite)
+
+// KDFRoot performs a root-chain step: HKDF-SHA256 with salt=root, ikm=dhOutput and
+// info=InfoRoot, yielding 64 bytes split into the new root key and a chain key.
+func KDFRoot(root, dhOutput []byte) (newRoot, chainKey []byte, err error) {
+	return kdfRK(defaultSuite, root, dhOutput)
+}
+
+// KDFChain performs a symmetric-chain step: HKDF-SHA256 with ikm=chainKey, no salt
+// and info=InfoChain, yielding 64 bytes split into the next chain key and a message key.
+func KDFChain(chainKey []byte) (nextChainKey, messageKey []byte, err error) {
+	return kdfCK(defaultSuite, chainKey)
+}
+
+// MessageNonce derives the 12-byte AEAD nonce for messageKey: HKDF-SHA256 with
+// ikm=messageKey, no salt and info=InfoNonce.
+func MessageNonce(messageKey []byte) ([]byte, error) {
+	return defaultSuite.DeriveMessageNonce(messageKey)
+}
+
+// HeaderAAD returns the AEAD associated data for a message:
+// associatedData || DHPub || PN (big-endian uint32) || N (big-endian uint32).
+func HeaderAAD(associatedData []byte, header domain.RatchetHeader) []byte {
+	return composeAAD(associatedData, header)
+}
+
+// SealMessage encrypts plaintext with ChaCha20-Poly1305 under messageKey, using
+// MessageNonce(messageKey) and aad. It is deterministic for fixed inputs.
+func SealMessage(messageKey, aad, plaintext []byte) ([]byte, error) {
+	return seal(defaultSuite, messageKey, aad, plaintext)
+}
@@ -5,6 +5,13 @@
 // changes its DH ratchet public key, both sides derive new chain keys from a new
 // root derived via DH.
 //
+// Every key, nonce and header MAC is derived with the cipher suite the state
+// was initialised with (RatchetState.Suite, see crypto.Suite). States that
+// predate suites name none and use crypto.DefaultSuite.
+//
+// Package ciphera/pkg/ratchet wraps this one in a stable API for programs
+// that embed the ratchet without the rest of the client.
+//
 // Concurrency: RatchetState is NOT safe for concurrent use. Callers must
 // serialise access per conversation.
 package ratchet
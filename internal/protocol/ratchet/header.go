@@ -0,0 +1,52 @@
+package ratchet
+
+import (
+	"crypto/hmac"
+	"encoding/binary"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+)
+
+// headerMACLabel prefixes the header MAC input. It is part of the wire
+// protocol and must never change.
+const headerMACLabel = "ciphera/header-mac-v1"
+
+// HeaderMAC returns an HMAC under headerKey, with the hash of the cipher suite
+// suiteID (SHA-256 for the default), over the envelope fields a forger would
+// have to choose: from, to, associatedData and the ratchet header. It returns
+// nil if headerKey is nil, as for states that predate header keys, or if the
+// suite is unknown.
+//
+// Checking it costs one HMAC, so a receiver can drop junk before any ratchet
+// step or AEAD open.
+func HeaderMAC(suiteID string, headerKey []byte, from, to string, associatedData []byte, header domain.RatchetHeader) []byte {
+	suite, err := crypto.LookupSuite(suiteID)
+	if headerKey == nil || err != nil {
+		return nil
+	}
+	m := suite.MAC(headerKey)
+	for _, field := range [][]byte{
+		[]byte(headerMACLabel),
+		[]byte(from),
+		[]byte(to),
+		associatedData,
+		headerBytes(header),
+	} {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(field)))
+		m.Write(n[:])
+		m.Write(field)
+	}
+	return m.Sum(nil)
+}
+
+// VerifyHeaderMAC reports whether mac is HeaderMAC for the given fields. It
+// is false if headerKey is nil or the suite is unknown.
+func VerifyHeaderMAC(suiteID string, headerKey, mac []byte, from, to string, associatedData []byte, header domain.RatchetHeader) bool {
+	want := HeaderMAC(suiteID, headerKey, from, to, associatedData, header)
+	if want == nil {
+		return false
+	}
+	return hmac.Equal(mac, want)
+}
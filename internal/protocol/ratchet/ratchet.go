@@ -2,24 +2,20 @@ package ratchet
 
 import (
 	"crypto/rand"
-	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
-	"io"
+	"maps"
+	"slices"
 
-	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/curve25519"
-	"golang.org/x/crypto/hkdf"
 
 	"ciphera/internal/crypto"
 	"ciphera/internal/domain"
 )
 
 const (
-	aeadKeySize       = chacha20poly1305.KeySize
-	nonceSize         = chacha20poly1305.NonceSize
 	maxSkippedMK      = 1000 // maximum number of skipped message keys to retain
 	maxGapWithinChain = 2000 // in-chain gap cap (Nr..N-1)
 	maxPrevChainGap   = 2000 // previous-chain gap cap (PN)
@@ -27,10 +23,12 @@ const (
 	headerIntsSize    = 8 // PN (4) + N (4)
 )
 
-var (
-	labelRK    = []byte("DR|rk")
-	labelCK    = []byte("DR|ck") // single label for both send and receive chains
-	labelNonce = []byte("DR|nonce")
+// HKDF info labels, defined in internal/crypto. They are part of the wire
+// protocol and must never change.
+const (
+	InfoRoot  = crypto.LabelRatchetRoot
+	InfoChain = crypto.LabelRatchetChain // single label for both send and receive chains
+	InfoNonce = crypto.LabelMessageNonce
 )
 
 var (
@@ -46,14 +44,21 @@ var (
 
 // InitAsInitiator initialises state for a sender.
 //
-// It derives only the send chain key from the supplied root and the peer's long-term identity key.
+// It derives only the send chain key from the supplied root and the peer's long-term identity key,
+// and the header key from the root alone, with the cipher suite suiteID (see crypto.LookupSuite),
+// which the state records for every later step.
 // The initiator creates a fresh Diffie-Hellman (DH) key pair for its ratchet key.
 func InitAsInitiator(
 	root []byte,
 	_ domain.X25519Private,
 	_ domain.X25519Public,
 	peerIdentity domain.X25519Public,
+	suiteID string,
 ) (domain.RatchetState, error) {
+	suite, err := crypto.LookupSuite(suiteID)
+	if err != nil {
+		return domain.RatchetState{}, err
+	}
 	var privateKey domain.X25519Private
 	if _, err := rand.Read(privateKey[:]); err != nil {
 		return domain.RatchetState{}, err
@@ -72,11 +77,15 @@ func InitAsInitiator(
 	if err != nil {
 		return domain.RatchetState{}, err
 	}
-	newRootKey, sendChainKey, err := kdfRK(root, diffieHellmanOutput[:])
+	newRootKey, sendChainKey, err := kdfRK(suite, root, diffieHellmanOutput[:])
 	if err != nil {
 		return domain.RatchetState{}, err
 	}
 	crypto.Wipe(diffieHellmanOutput[:])
+	headerKey, err := suite.DeriveHeaderKey(root)
+	if err != nil {
+		return domain.RatchetState{}, err
+	}
 
 	return domain.RatchetState{
 		RootKey:   append([]byte(nil), newRootKey...),
@@ -85,19 +94,27 @@ func InitAsInitiator(
 		PeerDHPub: peerIdentity,
 		SendCK:    append([]byte(nil), sendChainKey...),
 		Skipped:   make(map[string][]byte),
+		HeaderKey: headerKey,
+		Suite:     suite.ID,
 	}, nil
 }
 
 // InitAsResponder initialises state for a receiver.
 //
-// It derives only the receive chain key from the supplied root and the sender's ratchet public key.
+// It derives only the receive chain key from the supplied root and the sender's ratchet public key,
+// and the header key from the root alone, with the cipher suite suiteID as for InitAsInitiator.
 // The responder also creates a fresh ratchet key pair for its next send.
 func InitAsResponder(
 	root []byte,
 	ourIdentityPrivate domain.X25519Private,
 	_ domain.X25519Public,
 	senderRatchetPublic domain.X25519Public,
+	suiteID string,
 ) (domain.RatchetState, error) {
+	suite, err := crypto.LookupSuite(suiteID)
+	if err != nil {
+		return domain.RatchetState{}, err
+	}
 	var privateKey domain.X25519Private
 	if _, err := rand.Read(privateKey[:]); err != nil {
 		return domain.RatchetState{}, err
@@ -116,11 +133,15 @@ func InitAsResponder(
 	if err != nil {
 		return domain.RatchetState{}, err
 	}
-	newRootKey, receiveChainKey, err := kdfRK(root, diffieHellmanOutput[:])
+	newRootKey, receiveChainKey, err := kdfRK(suite, root, diffieHellmanOutput[:])
 	if err != nil {
 		return domain.RatchetState{}, err
 	}
 	crypto.Wipe(diffieHellmanOutput[:])
+	headerKey, err := suite.DeriveHeaderKey(root)
+	if err != nil {
+		return domain.RatchetState{}, err
+	}
 
 	return domain.RatchetState{
 		RootKey:   append([]byte(nil), newRootKey...),
@@ -129,6 +150,8 @@ func InitAsResponder(
 		PeerDHPub: senderRatchetPublic,
 		RecvCK:    append([]byte(nil), receiveChainKey...),
 		Skipped:   make(map[string][]byte),
+		HeaderKey: headerKey,
+		Suite:     suite.ID,
 	}, nil
 }
 
@@ -146,6 +169,10 @@ func Encrypt(
 	if state == nil {
 		return domain.RatchetHeader{}, nil, errors.New("ratchet state uninitialised")
 	}
+	suite, err := crypto.LookupSuite(state.Suite)
+	if err != nil {
+		return domain.RatchetHeader{}, nil, err
+	}
 
 	// First send by the responder: perform a sending ratchet step.
 	if state.SendCK == nil {
@@ -169,7 +196,7 @@ func Encrypt(
 		if err != nil {
 			return domain.RatchetHeader{}, nil, err
 		}
-		newRootKey, sendChainKey, err := kdfRK(state.RootKey, diffieHellmanOutput[:])
+		newRootKey, sendChainKey, err := kdfRK(suite, state.RootKey, diffieHellmanOutput[:])
 		if err != nil {
 			return domain.RatchetHeader{}, nil, err
 		}
@@ -183,7 +210,7 @@ func Encrypt(
 		wipeAndCopy(&state.SendCK, sendChainKey)
 	}
 
-	messageKey, err := kdfCKSend(state)
+	messageKey, err := kdfCKSend(suite, state)
 	if err != nil {
 		return domain.RatchetHeader{}, nil, err
 	}
@@ -197,7 +224,7 @@ func Encrypt(
 	// AAD binds the header to the ciphertext.
 	aad := composeAAD(associatedData, header)
 
-	ciphertext, err := seal(messageKey, header, aad, plaintext)
+	ciphertext, err := seal(suite, messageKey, aad, plaintext)
 	crypto.Wipe(messageKey)
 	if err != nil {
 		return domain.RatchetHeader{}, nil, err
@@ -222,6 +249,10 @@ func Decrypt(
 	if state == nil {
 		return nil, errors.New("ratchet state uninitialised")
 	}
+	suite, err := crypto.LookupSuite(state.Suite)
+	if err != nil {
+		return nil, err
+	}
 	// Quick header validation.
 	if len(header.DHPub) != x25519PubSize {
 		return nil, errors.New("invalid header: dh_pub length")
@@ -237,7 +268,7 @@ func Decrypt(
 	if messageKey, ok := state.Skipped[keyID]; ok {
 		aad := composeAAD(associatedData, header)
 
-		plaintext, err := open(messageKey, header, aad, ciphertext)
+		plaintext, err := open(suite, messageKey, aad, ciphertext)
 		crypto.Wipe(messageKey)
 		if err != nil {
 			return nil, err // Keep skipped key on failed auth for later correct packet.
@@ -269,7 +300,7 @@ func Decrypt(
 		}
 
 		// Stash remaining keys from the previous chain up to PN.
-		skipUntil(state, header.PN)
+		skipUntil(suite, state, header.PN)
 
 		peerPublicKey := headerPublicKey // by value
 
@@ -277,7 +308,7 @@ func Decrypt(
 		if err != nil {
 			return nil, err
 		}
-		newRootKey, receiveChainKey, err := kdfRK(state.RootKey, diffieHellmanOutput[:])
+		newRootKey, receiveChainKey, err := kdfRK(suite, state.RootKey, diffieHellmanOutput[:])
 		if err != nil {
 			return nil, err
 		}
@@ -300,7 +331,7 @@ func Decrypt(
 		if err != nil {
 			return nil, err
 		}
-		nextRootKey, sendChainKey, err := kdfRK(newRootKey, diffieHellmanOutput2[:])
+		nextRootKey, sendChainKey, err := kdfRK(suite, newRootKey, diffieHellmanOutput2[:])
 		if err != nil {
 			return nil, err
 		}
@@ -319,7 +350,7 @@ func Decrypt(
 
 	// 4) Derive and stash skipped keys for messages in (Nr..N-1).
 	for state.Nr < header.N {
-		skippedMessageKey, _ := kdfCKRecv(state) // RecvCK initialised; error not expected
+		skippedMessageKey, _ := kdfCKRecv(suite, state) // RecvCK initialised; error not expected
 		if len(state.Skipped) >= maxSkippedMK {
 			evictOldestForPeer(state.Skipped, state.PeerDHPub)
 		}
@@ -328,14 +359,14 @@ func Decrypt(
 	}
 
 	// 5) Decrypt the target message at N.
-	messageKey, err := kdfCKRecv(state)
+	messageKey, err := kdfCKRecv(suite, state)
 	if err != nil {
 		return nil, err
 	}
 
 	aad := composeAAD(associatedData, header)
 
-	plaintext, err := open(messageKey, header, aad, ciphertext)
+	plaintext, err := open(suite, messageKey, aad, ciphertext)
 	crypto.Wipe(messageKey)
 	if err != nil {
 		return nil, err
@@ -347,31 +378,22 @@ func Decrypt(
 /* ----------------------------------------- KDF helpers ---------------------------------------- */
 
 // kdfRK derives a new root key and a chain key from the previous root and a DH output.
-func kdfRK(root, diffieHellmanOutput []byte) (newRootKey, chainKey []byte, err error) {
-	hk := hkdf.New(sha256.New, diffieHellmanOutput, root, labelRK)
-	newRootKey = make([]byte, 32)
-	chainKey = make([]byte, 32)
-	if err = readFull(hk, newRootKey); err != nil {
-		return nil, nil, err
-	}
-	if err = readFull(hk, chainKey); err != nil {
-		return nil, nil, err
-	}
-	return
+func kdfRK(suite *crypto.Suite, root, diffieHellmanOutput []byte) (newRootKey, chainKey []byte, err error) {
+	return suite.DeriveRootAndChain(root, diffieHellmanOutput)
+}
+
+// kdfCK derives the next chain key and a message key from chainKey.
+func kdfCK(suite *crypto.Suite, chainKey []byte) (nextChainKey, messageKey []byte, err error) {
+	return suite.DeriveMessageKey(chainKey)
 }
 
 // kdfCKSend advances the send chain and returns the next message key.
-func kdfCKSend(state *domain.RatchetState) ([]byte, error) {
+func kdfCKSend(suite *crypto.Suite, state *domain.RatchetState) ([]byte, error) {
 	if state.SendCK == nil {
 		return nil, ErrChainUninitialised
 	}
-	hk := hkdf.New(sha256.New, state.SendCK, nil, labelCK)
-	nextChainKey := make([]byte, 32)
-	messageKey := make([]byte, 32)
-	if err := readFull(hk, nextChainKey); err != nil {
-		return nil, err
-	}
-	if err := readFull(hk, messageKey); err != nil {
+	nextChainKey, messageKey, err := kdfCK(suite, state.SendCK)
+	if err != nil {
 		return nil, err
 	}
 	crypto.Wipe(state.SendCK)
@@ -380,17 +402,12 @@ func kdfCKSend(state *domain.RatchetState) ([]byte, error) {
 }
 
 // kdfCKRecv advances the receive chain and returns the next message key.
-func kdfCKRecv(state *domain.RatchetState) ([]byte, error) {
+func kdfCKRecv(suite *crypto.Suite, state *domain.RatchetState) ([]byte, error) {
 	if state.RecvCK == nil {
 		return nil, ErrChainUninitialised
 	}
-	hk := hkdf.New(sha256.New, state.RecvCK, nil, labelCK)
-	nextChainKey := make([]byte, 32)
-	messageKey := make([]byte, 32)
-	if err := readFull(hk, nextChainKey); err != nil {
-		return nil, err
-	}
-	if err := readFull(hk, messageKey); err != nil {
+	nextChainKey, messageKey, err := kdfCK(suite, state.RecvCK)
+	if err != nil {
 		return nil, err
 	}
 	crypto.Wipe(state.RecvCK)
@@ -400,46 +417,38 @@ func kdfCKRecv(state *domain.RatchetState) ([]byte, error) {
 
 /* ------------------------------------- AEAD/nonce helpers ------------------------------------- */
 
-// deriveNonce deterministically derives a unique 12-byte nonce from the per-message key.
-func deriveNonce(messageKey []byte) ([]byte, error) {
-	hk := hkdf.New(sha256.New, messageKey, nil, labelNonce)
-	nonce := make([]byte, nonceSize)
-	if err := readFull(hk, nonce); err != nil {
-		return nil, err
-	}
-	return nonce, nil
-}
-
-// seal encrypts plaintext with the given per-message key and header-associated data.
+// seal encrypts plaintext with the suite's AEAD under the per-message key and
+// header-associated data, with a nonce derived from the key.
 func seal(
+	suite *crypto.Suite,
 	messageKey []byte,
-	_ domain.RatchetHeader,
 	associatedData []byte,
 	plaintext []byte,
 ) ([]byte, error) {
-	aead, err := chacha20poly1305.New(messageKey[:aeadKeySize])
+	aead, err := suite.NewAEAD(messageKey)
 	if err != nil {
 		return nil, err
 	}
-	nonce, err := deriveNonce(messageKey)
+	nonce, err := suite.DeriveMessageNonce(messageKey)
 	if err != nil {
 		return nil, err
 	}
 	return aead.Seal(nil, nonce, plaintext, associatedData), nil
 }
 
-// open decrypts ciphertext with the given per-message key and header-associated data.
+// open decrypts ciphertext with the suite's AEAD under the per-message key
+// and header-associated data.
 func open(
+	suite *crypto.Suite,
 	messageKey []byte,
-	_ domain.RatchetHeader,
 	associatedData []byte,
 	ciphertext []byte,
 ) ([]byte, error) {
-	aead, err := chacha20poly1305.New(messageKey[:aeadKeySize])
+	aead, err := suite.NewAEAD(messageKey)
 	if err != nil {
 		return nil, err
 	}
-	nonce, err := deriveNonce(messageKey)
+	nonce, err := suite.DeriveMessageNonce(messageKey)
 	if err != nil {
 		return nil, err
 	}
@@ -470,9 +479,9 @@ func composeAAD(associatedData []byte, header domain.RatchetHeader) []byte {
 
 // skipUntil derives and stashes skipped message keys from the current receive
 // chain until state.Nr reaches previousChainLength, evicting old entries if the cap is exceeded.
-func skipUntil(state *domain.RatchetState, previousChainLength uint32) {
+func skipUntil(suite *crypto.Suite, state *domain.RatchetState, previousChainLength uint32) {
 	for state.Nr < previousChainLength {
-		skippedMessageKey, _ := kdfCKRecv(state) // RecvCK initialised; error not expected
+		skippedMessageKey, _ := kdfCKRecv(suite, state) // RecvCK initialised; error not expected
 		if len(state.Skipped) >= maxSkippedMK {
 			evictOldestForPeer(state.Skipped, state.PeerDHPub)
 		}
@@ -489,6 +498,24 @@ func skippedKeyID(pub domain.X25519Public, n uint32) string {
 	return hex.EncodeToString(buf[:]) // Hex-encode to prevent non-UTF-8 keys from breaking JSON.
 }
 
+// Missing returns the messages state holds skipped keys for: those passed
+// over because a later message arrived first, and not received since. They
+// are ordered by ratchet key, then by N.
+func Missing(state *domain.RatchetState) []domain.HeaderIndex {
+	out := make([]domain.HeaderIndex, 0, len(state.Skipped))
+	for _, k := range slices.Sorted(maps.Keys(state.Skipped)) {
+		b, err := hex.DecodeString(k)
+		if err != nil || len(b) != x25519PubSize+4 {
+			continue
+		}
+		out = append(out, domain.HeaderIndex{
+			DHPub: b[:x25519PubSize],
+			N:     binary.BigEndian.Uint32(b[x25519PubSize:]),
+		})
+	}
+	return out
+}
+
 // wipeAndDelete zeroes the value for key in m (if present) and removes the entry.
 func wipeAndDelete(m map[string][]byte, key string) {
 	if v, ok := m[key]; ok && v != nil {
@@ -539,9 +566,3 @@ func wipeAndCopy(dst *[]byte, src []byte) {
 	}
 	*dst = append([]byte(nil), src...)
 }
-
-// readFull reads len(b) bytes, returning an error on short read.
-func readFull(r io.Reader, b []byte) error {
-	_, err := io.ReadFull(r, b)
-	return err
-}
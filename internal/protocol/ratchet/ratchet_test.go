@@ -26,6 +26,12 @@ func makeIdentity(t *testing.T) (priv domain.X25519Private, pub domain.X25519Pub
 
 // newPair returns an initiator and responder ratchet state, ready for use.
 func newPair(t *testing.T) (a, b domain.RatchetState) {
+	t.Helper()
+	return newSuitePair(t, "")
+}
+
+// newSuitePair is newPair for the cipher suite suiteID.
+func newSuitePair(t *testing.T, suiteID string) (a, b domain.RatchetState) {
 	t.Helper()
 	// Shared root key from a prior X3DH (simulate).
 	rk := bytes.Repeat([]byte{0x42}, 32)
@@ -35,13 +41,13 @@ func newPair(t *testing.T) (a, b domain.RatchetState) {
 	bPriv, bPub := makeIdentity(t)
 
 	// Initiator seeds SendCK using peer identity.
-	init, err := ratchet.InitAsInitiator(rk, aPriv, aPub, bPub)
+	init, err := ratchet.InitAsInitiator(rk, aPriv, aPub, bPub, suiteID)
 	if err != nil {
 		t.Fatalf("InitAsInitiator: %v", err)
 	}
 
 	// Responder seeds RecvCK using its identity and sender's current ratchet pub.
-	resp, err := ratchet.InitAsResponder(rk, bPriv, bPub, init.DHPub)
+	resp, err := ratchet.InitAsResponder(rk, bPriv, bPub, init.DHPub, suiteID)
 	if err != nil {
 		t.Fatalf("InitAsResponder: %v", err)
 	}
@@ -125,6 +131,33 @@ func TestDoubleRatchet_OutOfOrder_WithinChain_UsesSkippedKeys(t *testing.T) {
 	}
 }
 
+func TestMissing_ListsSkippedUntilReceived(t *testing.T) {
+	a, b := newPair(t)
+
+	h0, ct0 := send(t, &a, nil, []byte("zero"))
+	_, _ = send(t, &a, nil, []byte("one"))
+	h2, ct2 := send(t, &a, nil, []byte("two"))
+	if got := ratchet.Missing(&b); len(got) != 0 {
+		t.Fatalf("Missing before receiving = %v, want none", got)
+	}
+
+	recv(t, &b, nil, h2, ct2)
+	got := ratchet.Missing(&b)
+	if len(got) != 2 || got[0].N != 0 || got[1].N != 1 {
+		t.Fatalf("Missing = %v, want N 0 and 1", got)
+	}
+	for _, m := range got {
+		if !bytes.Equal(m.DHPub, h2.DHPub) {
+			t.Fatalf("Missing DHPub = %x, want %x", m.DHPub, h2.DHPub)
+		}
+	}
+
+	recv(t, &b, nil, h0, ct0)
+	if got := ratchet.Missing(&b); len(got) != 1 || got[0].N != 1 {
+		t.Fatalf("Missing after receiving N=0 = %v, want N 1", got)
+	}
+}
+
 func TestDoubleRatchet_OutOfOrderAndLoss(t *testing.T) {
 	a, b := newPair(t)
 
@@ -359,3 +392,69 @@ func TestDoubleRatchet_HeaderTamperSmallPNAndN(t *testing.T) {
 		t.Fatalf("want error on N tamper, got nil")
 	}
 }
+
+func TestHeaderMAC_SharedKeyAndTamper(t *testing.T) {
+	a, b := newPair(t)
+	if a.HeaderKey == nil || !bytes.Equal(a.HeaderKey, b.HeaderKey) {
+		t.Fatal("initiator and responder derived different header keys")
+	}
+
+	h, _ := send(t, &a, []byte("ad"), []byte("hi"))
+	mac := ratchet.HeaderMAC(a.Suite, a.HeaderKey, "alice", "bob", []byte("ad"), h)
+	if !ratchet.VerifyHeaderMAC(b.Suite, b.HeaderKey, mac, "alice", "bob", []byte("ad"), h) {
+		t.Fatal("valid header MAC rejected")
+	}
+
+	bad := h
+	bad.N++
+	for name, ok := range map[string]bool{
+		"tampered header": ratchet.VerifyHeaderMAC(b.Suite, b.HeaderKey, mac, "alice", "bob", []byte("ad"), bad),
+		"other sender":    ratchet.VerifyHeaderMAC(b.Suite, b.HeaderKey, mac, "mallory", "bob", []byte("ad"), h),
+		"other recipient": ratchet.VerifyHeaderMAC(b.Suite, b.HeaderKey, mac, "alice", "carol", []byte("ad"), h),
+		"other AD":        ratchet.VerifyHeaderMAC(b.Suite, b.HeaderKey, mac, "alice", "bob", nil, h),
+		"missing MAC":     ratchet.VerifyHeaderMAC(b.Suite, b.HeaderKey, nil, "alice", "bob", []byte("ad"), h),
+		"missing key":     ratchet.VerifyHeaderMAC(b.Suite, nil, mac, "alice", "bob", []byte("ad"), h),
+	} {
+		if ok {
+			t.Errorf("header MAC accepted: %s", name)
+		}
+	}
+	if ratchet.HeaderMAC("", nil, "alice", "bob", nil, h) != nil {
+		t.Error("HeaderMAC without a key is not nil")
+	}
+}
+
+func TestDoubleRatchet_Suites(t *testing.T) {
+	a256, b256 := newPair(t)
+	a512, b512 := newSuitePair(t, crypto.SuiteSHA512)
+	if a256.Suite != crypto.SuiteSHA256 || a512.Suite != crypto.SuiteSHA512 || b512.Suite != crypto.SuiteSHA512 {
+		t.Fatalf("states record suites %q, %q and %q", a256.Suite, a512.Suite, b512.Suite)
+	}
+	// The same root key must yield different keys under different suites.
+	if bytes.Equal(a256.HeaderKey, a512.HeaderKey) {
+		t.Fatal("SHA-256 and SHA-512 suites derived the same header key")
+	}
+
+	h, ct := send(t, &a512, []byte("ad"), []byte("hello"))
+	if got := recv(t, &b512, []byte("ad"), h, ct); string(got) != "hello" {
+		t.Fatalf("got %q, want %q", got, "hello")
+	}
+	mac := ratchet.HeaderMAC(a512.Suite, a512.HeaderKey, "alice", "bob", []byte("ad"), h)
+	if !ratchet.VerifyHeaderMAC(b512.Suite, b512.HeaderKey, mac, "alice", "bob", []byte("ad"), h) {
+		t.Fatal("valid SHA-512 header MAC rejected")
+	}
+	if ratchet.VerifyHeaderMAC(b256.Suite, b512.HeaderKey, mac, "alice", "bob", []byte("ad"), h) {
+		t.Fatal("header MAC accepted under the wrong suite")
+	}
+
+	// A message sealed under one suite does not open under the other.
+	b512.Suite = crypto.SuiteSHA256
+	h, ct = send(t, &a512, []byte("ad"), []byte("again"))
+	if _, err := ratchet.Decrypt(&b512, []byte("ad"), h, ct); err == nil {
+		t.Fatal("want error decrypting under the wrong suite, got nil")
+	}
+
+	if _, err := ratchet.InitAsInitiator(bytes.Repeat([]byte{1}, 32), domain.X25519Private{}, domain.X25519Public{}, a256.DHPub, "nope"); !errors.Is(err, crypto.ErrUnknownSuite) {
+		t.Fatalf("InitAsInitiator with unknown suite: got %v, want ErrUnknownSuite", err)
+	}
+}
@@ -0,0 +1,63 @@
+// Package relayauth signs requests a client makes about its own account on a
+// relay, so the relay can tell the account's owner from anyone else who knows
+// the username. The relay has no passwords: it checks each signature against
+// the signing key in the account's published prekey bundle.
+//
+// # Backups
+//
+// A backup stored with PUT /backup/{user} is signed, with the owner's current
+// Ed25519 signing key, over
+//
+//	len(user) ‖ user ‖ updated (uint64, big-endian) ‖ data
+//
+// under the signature context BackupContext (see crypto.SignContext), with the
+// length a big-endian uint16. The relay keeps a backup only if its updated
+// time is not older than the stored one's, so a captured upload cannot be
+// replayed over a newer backup.
+//
+// # Presence policies
+//
+// A presence policy stored with PUT /presence/{user} is signed the same way,
+// under PresenceContext, over
+//
+//	len(user) ‖ user ‖ updated (uint64) ‖ len(visibility) ‖ visibility ‖
+//	count ‖ (len(contact) ‖ contact)…
+//
+// with every length and the count a big-endian uint16, and the same rule
+// against replaying an older policy.
+//
+// # Re-registrations
+//
+// A POST /register for a username whose bundle carries a signing key must
+// answer the relay's signature challenge: a single-use nonce, signed under
+// RegisterContext over
+//
+//	len(user) ‖ user ‖ len(nonce) ‖ nonce ‖ SHA-256(bundle)
+//
+// with each length a big-endian uint16 and the bundle hashed as
+// encoding/json encodes a domain.PrekeyBundle, so the signature covers the
+// exact bundle stored. It is made with the registered signing key, or with
+// the new bundle's key when the bundle's sign chain leads to it from the
+// registered one (see package signchain), so a rotated key can take over
+// its account and nobody else's key can.
+//
+// # Signed requests
+//
+// A request about an account that has no body to sign, such as
+// GET /account/{user}/usage, carries the Unix time in TimeHeader and, in
+// SignatureHeader, the owner's signature over
+//
+//	len(user) ‖ user ‖ len(method) ‖ method ‖ len(path) ‖ path ‖
+//	len(query) ‖ query ‖ time (uint64)
+//
+// under RequestContext, each length a big-endian uint16, path the unescaped
+// URL path and query the URL query in canonical form (see CanonicalQuery),
+// empty when there is none. The relay refuses times more than MaxRequestSkew
+// from its clock, so a captured request can only be replayed for a few
+// minutes, and only to the same route with the same parameters.
+//
+// A request one account makes about another, such as GET /presence/{user}
+// from a contact, is signed the same way by the asking account, named in
+// UserHeader, with user in the statement the signer rather than the account
+// in the path.
+package relayauth
@@ -0,0 +1,148 @@
+package relayauth
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+)
+
+// Signature contexts. They are part of the wire protocol and must never
+// change.
+const (
+	// BackupContext is the signature context for relay backups.
+	BackupContext = "ciphera/relay-backup-v1"
+	// RequestContext is the signature context for signed requests. Version
+	// 1 statements left out the query.
+	RequestContext = "ciphera/relay-request-v2"
+	// PresenceContext is the signature context for presence policies.
+	PresenceContext = "ciphera/relay-presence-v1"
+	// RegisterContext is the signature context for re-registrations.
+	RegisterContext = "ciphera/relay-register-v1"
+)
+
+// Signed requests carry their time and signature in these headers.
+const (
+	TimeHeader      = "X-Ciphera-Auth-Time"
+	SignatureHeader = "X-Ciphera-Auth-Signature" // base64, standard encoding
+	// UserHeader names the signer of a request about another account.
+	UserHeader = "X-Ciphera-Auth-User"
+)
+
+// MaxRequestSkew is how far a signed request's time may be from the relay's
+// clock, either way.
+const MaxRequestSkew = 5 * time.Minute
+
+// BackupStatement returns the bytes signed for user's backup b, without the
+// context. b.Sig is not included.
+func BackupStatement(user string, b domain.RelayBackup) []byte {
+	out := make([]byte, 0, 2+len(user)+8+len(b.Data))
+	out = binary.BigEndian.AppendUint16(out, uint16(len(user)))
+	out = append(out, user...)
+	out = binary.BigEndian.AppendUint64(out, uint64(b.UpdatedUTC))
+	return append(out, b.Data...)
+}
+
+// SignBackup sets b.Sig to user's signature over b with priv.
+func SignBackup(priv domain.Ed25519Private, user string, b *domain.RelayBackup) {
+	b.Sig = crypto.SignContext(priv, BackupContext, BackupStatement(user, *b))
+}
+
+// VerifyBackup reports whether b carries user's signature by pub.
+func VerifyBackup(pub domain.Ed25519Public, user string, b domain.RelayBackup) bool {
+	return crypto.VerifyContext(pub, BackupContext, BackupStatement(user, b), b.Sig)
+}
+
+// PresenceStatement returns the bytes signed for user's presence policy p,
+// without the context. p.Sig is not included.
+func PresenceStatement(user string, p domain.PresencePolicy) []byte {
+	out := binary.BigEndian.AppendUint16(nil, uint16(len(user)))
+	out = append(out, user...)
+	out = binary.BigEndian.AppendUint64(out, uint64(p.UpdatedUTC))
+	out = binary.BigEndian.AppendUint16(out, uint16(len(p.Visibility)))
+	out = append(out, p.Visibility...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(p.Contacts)))
+	for _, c := range p.Contacts {
+		out = binary.BigEndian.AppendUint16(out, uint16(len(c)))
+		out = append(out, c...)
+	}
+	return out
+}
+
+// SignPresence sets p.Sig to user's signature over p with priv.
+func SignPresence(priv domain.Ed25519Private, user string, p *domain.PresencePolicy) {
+	p.Sig = crypto.SignContext(priv, PresenceContext, PresenceStatement(user, *p))
+}
+
+// VerifyPresence reports whether p carries user's signature by pub.
+func VerifyPresence(pub domain.Ed25519Public, user string, p domain.PresencePolicy) bool {
+	return crypto.VerifyContext(pub, PresenceContext, PresenceStatement(user, p), p.Sig)
+}
+
+// RequestStatement returns the bytes signed for user's request with method to
+// path and query at t, without the context. query is the raw URL query,
+// which is canonicalised (see CanonicalQuery).
+func RequestStatement(user, method, path, query string, t int64) []byte {
+	query = CanonicalQuery(query)
+	out := make([]byte, 0, 8+len(user)+len(method)+len(path)+len(query)+8)
+	for _, f := range []string{user, method, path, query} {
+		out = binary.BigEndian.AppendUint16(out, uint16(len(f)))
+		out = append(out, f...)
+	}
+	return binary.BigEndian.AppendUint64(out, uint64(t))
+}
+
+// CanonicalQuery returns the raw URL query with its parameters sorted by key
+// and encoded as url.Values.Encode writes them, so a client and relay that
+// order or escape the same parameters differently agree on what was signed.
+// A query that does not parse is returned as it is.
+func CanonicalQuery(query string) string {
+	v, err := url.ParseQuery(query)
+	if err != nil {
+		return query
+	}
+	return v.Encode()
+}
+
+// SignRequest returns user's signature with priv over a request with method
+// to path and query at t.
+func SignRequest(priv domain.Ed25519Private, user, method, path, query string, t int64) []byte {
+	return crypto.SignContext(priv, RequestContext, RequestStatement(user, method, path, query, t))
+}
+
+// VerifyRequest reports whether sig is user's signature by pub over a request
+// with method to path and query at t. The caller checks t against
+// MaxRequestSkew.
+func VerifyRequest(pub domain.Ed25519Public, user, method, path, query string, t int64, sig []byte) bool {
+	return crypto.VerifyContext(pub, RequestContext, RequestStatement(user, method, path, query, t), sig)
+}
+
+// RegisterStatement returns the bytes signed to re-register user with bundle
+// b in answer to the relay's nonce, without the context.
+func RegisterStatement(user, nonce string, b domain.PrekeyBundle) []byte {
+	// A PrekeyBundle holds nothing json.Marshal can fail on.
+	raw, _ := json.Marshal(b)
+	digest := sha256.Sum256(raw)
+	out := make([]byte, 0, 4+len(user)+len(nonce)+len(digest))
+	for _, f := range []string{user, nonce} {
+		out = binary.BigEndian.AppendUint16(out, uint16(len(f)))
+		out = append(out, f...)
+	}
+	return append(out, digest[:]...)
+}
+
+// SignRegister returns user's signature with priv over re-registering with
+// b in answer to nonce.
+func SignRegister(priv domain.Ed25519Private, user, nonce string, b domain.PrekeyBundle) []byte {
+	return crypto.SignContext(priv, RegisterContext, RegisterStatement(user, nonce, b))
+}
+
+// VerifyRegister reports whether sig is user's signature by pub over
+// re-registering with b in answer to nonce. The caller checks the nonce.
+func VerifyRegister(pub domain.Ed25519Public, user, nonce string, b domain.PrekeyBundle, sig []byte) bool {
+	return crypto.VerifyContext(pub, RegisterContext, RegisterStatement(user, nonce, b), sig)
+}
@@ -0,0 +1,148 @@
+package relayauth_test
+
+import (
+	"slices"
+	"testing"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/relayauth"
+)
+
+func TestSignBackup_Verify(t *testing.T) {
+	priv, pub, err := crypto.GenerateEd25519()
+	if err != nil {
+		t.Fatalf("GenerateEd25519: %v", err)
+	}
+	b := domain.RelayBackup{Data: []byte("sealed"), UpdatedUTC: 1700000000}
+	relayauth.SignBackup(priv, "alice", &b)
+	if !relayauth.VerifyBackup(pub, "alice", b) {
+		t.Fatal("VerifyBackup rejected a valid signature")
+	}
+
+	// The user, time and data are all bound.
+	if relayauth.VerifyBackup(pub, "bob", b) {
+		t.Error("signature verified for another user")
+	}
+	for name, mutate := range map[string]func(*domain.RelayBackup){
+		"updated": func(b *domain.RelayBackup) { b.UpdatedUTC++ },
+		"data":    func(b *domain.RelayBackup) { b.Data = []byte("Sealed") },
+	} {
+		c := b
+		mutate(&c)
+		if relayauth.VerifyBackup(pub, "alice", c) {
+			t.Errorf("%s changed: signature still verified", name)
+		}
+	}
+
+	// Another key's signature does not verify.
+	_, other, err := crypto.GenerateEd25519()
+	if err != nil {
+		t.Fatalf("GenerateEd25519: %v", err)
+	}
+	if relayauth.VerifyBackup(other, "alice", b) {
+		t.Error("signature verified under another key")
+	}
+}
+
+func TestSignRequest_Verify(t *testing.T) {
+	priv, pub, err := crypto.GenerateEd25519()
+	if err != nil {
+		t.Fatalf("GenerateEd25519: %v", err)
+	}
+	const ts = 1700000000
+	sig := relayauth.SignRequest(priv, "alice", "GET", "/account/alice/journal", "after=5&limit=10", ts)
+	if !relayauth.VerifyRequest(pub, "alice", "GET", "/account/alice/journal", "after=5&limit=10", ts, sig) {
+		t.Fatal("VerifyRequest rejected a valid signature")
+	}
+	// The query is compared in canonical form.
+	if !relayauth.VerifyRequest(pub, "alice", "GET", "/account/alice/journal", "limit=10&after=%35", ts, sig) {
+		t.Fatal("VerifyRequest rejected the same query reordered and escaped")
+	}
+	// The user, method, path, query and time are all bound, and the fields
+	// cannot be shifted into one another.
+	for name, c := range map[string]struct {
+		user, method, path, query string
+		ts                        int64
+	}{
+		"user":     {"bob", "GET", "/account/alice/journal", "after=5&limit=10", ts},
+		"method":   {"alice", "PUT", "/account/alice/journal", "after=5&limit=10", ts},
+		"path":     {"alice", "GET", "/account/bob/journal", "after=5&limit=10", ts},
+		"query":    {"alice", "GET", "/account/alice/journal", "after=0&limit=10", ts},
+		"no query": {"alice", "GET", "/account/alice/journal", "", ts},
+		"added":    {"alice", "GET", "/account/alice/journal", "after=5&limit=10&wait=60s", ts},
+		"time":     {"alice", "GET", "/account/alice/journal", "after=5&limit=10", ts + 1},
+		"shift":    {"aliceG", "ET", "/account/alice/journal", "after=5&limit=10", ts},
+	} {
+		if relayauth.VerifyRequest(pub, c.user, c.method, c.path, c.query, c.ts, sig) {
+			t.Errorf("%s changed: signature still verified", name)
+		}
+	}
+}
+
+func TestSignPresence_Verify(t *testing.T) {
+	priv, pub, err := crypto.GenerateEd25519()
+	if err != nil {
+		t.Fatalf("GenerateEd25519: %v", err)
+	}
+	p := domain.PresencePolicy{
+		Visibility: domain.PresenceContacts,
+		Contacts:   []string{"bob", "carol"},
+		UpdatedUTC: 1700000000,
+	}
+	relayauth.SignPresence(priv, "alice", &p)
+	if !relayauth.VerifyPresence(pub, "alice", p) {
+		t.Fatal("VerifyPresence rejected a valid signature")
+	}
+
+	// The user, time, visibility and every contact are bound, and contacts
+	// cannot be split or joined differently.
+	if relayauth.VerifyPresence(pub, "bob", p) {
+		t.Error("signature verified for another user")
+	}
+	for name, mutate := range map[string]func(*domain.PresencePolicy){
+		"updated":    func(p *domain.PresencePolicy) { p.UpdatedUTC++ },
+		"visibility": func(p *domain.PresencePolicy) { p.Visibility = domain.PresenceEveryone },
+		"added":      func(p *domain.PresencePolicy) { p.Contacts = append(p.Contacts, "mallory") },
+		"removed":    func(p *domain.PresencePolicy) { p.Contacts = p.Contacts[:1] },
+		"shift":      func(p *domain.PresencePolicy) { p.Contacts = []string{"bobc", "arol"} },
+	} {
+		c := p
+		c.Contacts = slices.Clone(p.Contacts)
+		mutate(&c)
+		if relayauth.VerifyPresence(pub, "alice", c) {
+			t.Errorf("%s changed: signature still verified", name)
+		}
+	}
+}
+
+func TestSignRegister_Verify(t *testing.T) {
+	priv, pub, err := crypto.GenerateEd25519()
+	if err != nil {
+		t.Fatalf("GenerateEd25519: %v", err)
+	}
+	b := domain.PrekeyBundle{Username: "alice", SignKey: pub, SPKID: "spk1", OneTime: []domain.OneTimePub{{ID: "opk1"}}}
+	sig := relayauth.SignRegister(priv, "alice", "nonce", b)
+	if !relayauth.VerifyRegister(pub, "alice", "nonce", b, sig) {
+		t.Fatal("VerifyRegister rejected a valid signature")
+	}
+
+	// The user, nonce and every part of the bundle are bound.
+	if relayauth.VerifyRegister(pub, "bob", "nonce", b, sig) {
+		t.Error("signature verified for another user")
+	}
+	if relayauth.VerifyRegister(pub, "alice", "nonce2", b, sig) {
+		t.Error("signature verified for another nonce")
+	}
+	for name, mutate := range map[string]func(*domain.PrekeyBundle){
+		"spk":      func(b *domain.PrekeyBundle) { b.SPKID = "spk2" },
+		"one_time": func(b *domain.PrekeyBundle) { b.OneTime = nil },
+		"suites":   func(b *domain.PrekeyBundle) { b.Suites = []string{"x"} },
+	} {
+		c := b
+		mutate(&c)
+		if relayauth.VerifyRegister(pub, "alice", "nonce", c, sig) {
+			t.Errorf("%s changed: signature still verified", name)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+// Package signchain lets an identity rotate its Ed25519 signing key while
+// keeping its X25519 identity key, so a long-lived identity can recover from a
+// signing-key compromise without re-verifying fingerprints.
+//
+// # Links
+//
+// Each rotation produces a SignKeyLink in which the outgoing key (Prev) signs
+// its replacement (Next). Prev signs
+//
+//	identity X25519 public ‖ Prev ‖ Next ‖ created (uint64, big-endian)
+//
+// under the signature context Context (see crypto.SignContext), so a link
+// cannot be replayed onto another identity or mistaken for another kind of
+// signature. Links written by older clients carry SigV 0 and sign
+// "ciphera/signkey-v1" followed by the same fields; Check still accepts them. The identity keeps every
+// link, oldest first, and publishes them in its prekey bundle.
+//
+// # Verification
+//
+// Peers pin the signing key they saw when they first started a session. When a
+// later bundle carries a different SignKey, Verify walks the chain from the
+// pinned key to the new one and rejects the bundle unless every link on that
+// path is signed by its predecessor.
+//
+// A chain cannot revoke a stolen key: whoever holds it can sign a link of their
+// own. Rotation heals against an attacker who loses access to the old key, and
+// an identity whose chain forks should be re-verified out of band.
+package signchain
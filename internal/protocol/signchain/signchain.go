@@ -0,0 +1,114 @@
+package signchain
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+)
+
+// Label prefixes the statement of links signed by older clients (SigRaw). It
+// is part of the wire protocol and must never change.
+const Label = "ciphera/signkey-v1"
+
+// Context is the signature context for links signed with SigContext. It is
+// part of the wire protocol and must never change.
+const Context = "ciphera/signkey-link-v1"
+
+var (
+	// ErrBadLink is returned when a link's signature does not verify.
+	ErrBadLink = errors.New("signing key link signature invalid")
+	// ErrBrokenChain is returned when the chain does not lead from the pinned
+	// signing key to the presented one.
+	ErrBrokenChain = errors.New("signing key not reachable from pinned key")
+)
+
+// Statement returns the bytes signed by link.Prev for identity, framed as
+// link.SigV says. It returns nil for an unknown version.
+func Statement(identity domain.X25519Public, link domain.SignKeyLink) []byte {
+	body := make([]byte, 0, len(Label)+3*32+8)
+	if link.SigV == domain.SigRaw {
+		body = append(body, Label...)
+	}
+	body = append(body, identity[:]...)
+	body = append(body, link.Prev[:]...)
+	body = append(body, link.Next[:]...)
+	body = binary.BigEndian.AppendUint64(body, uint64(link.CreatedUTC))
+
+	switch link.SigV {
+	case domain.SigRaw:
+		return body
+	case domain.SigContext:
+		return crypto.ContextMessage(Context, body)
+	default:
+		return nil
+	}
+}
+
+// Rotate replaces id's signing key with a fresh one, appending a link signed by
+// the old key. id is modified in place; callers persist it.
+func Rotate(id *domain.Identity, now time.Time) (domain.SignKeyLink, error) {
+	priv, pub, err := crypto.GenerateEd25519()
+	if err != nil {
+		return domain.SignKeyLink{}, err
+	}
+	link := domain.SignKeyLink{
+		Prev:       id.EdPub,
+		Next:       pub,
+		CreatedUTC: now.Unix(),
+		SigV:       domain.SigContext,
+	}
+	link.Sig = crypto.SignEd25519(id.EdPriv, Statement(id.XPub, link))
+
+	crypto.Wipe(id.EdPriv[:])
+	id.EdPriv, id.EdPub = priv, pub
+	id.SignChain = append(id.SignChain, link)
+	return link, nil
+}
+
+// Check verifies that every link in chain is validly signed and that the
+// links are contiguous and end at current. Links signed by older clients
+// (SigRaw) are still accepted; their keys are gone, so they cannot be
+// re-signed. An empty chain is valid: the
+// identity has never rotated.
+func Check(identity domain.X25519Public, chain []domain.SignKeyLink, current domain.Ed25519Public) error {
+	if len(chain) == 0 {
+		return nil
+	}
+	for i, l := range chain {
+		if i > 0 && l.Prev != chain[i-1].Next {
+			return ErrBrokenChain
+		}
+		msg := Statement(identity, l)
+		if msg == nil || !crypto.VerifyEd25519(l.Prev, msg, l.Sig) {
+			return ErrBadLink
+		}
+	}
+	if chain[len(chain)-1].Next != current {
+		return ErrBrokenChain
+	}
+	return nil
+}
+
+// Verify checks that current is pinned itself or is reachable from pinned
+// through chain. It also checks the whole chain with Check.
+func Verify(
+	identity domain.X25519Public,
+	chain []domain.SignKeyLink,
+	pinned, current domain.Ed25519Public,
+) error {
+	if err := Check(identity, chain, current); err != nil {
+		return err
+	}
+	if pinned == current {
+		return nil
+	}
+	for _, l := range chain {
+		if l.Prev == pinned {
+			return nil // Check guarantees the rest of the chain leads to current
+		}
+	}
+	return ErrBrokenChain
+}
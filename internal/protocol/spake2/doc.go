@@ -0,0 +1,28 @@
+// Package spake2 implements the SPAKE2 password-authenticated key exchange
+// (RFC 9382) over P-256 with SHA-256, HKDF and HMAC.
+//
+// Two parties who share a low-entropy password each send one group element and
+// derive the same shared key. An attacker on the wire, including the relay
+// that forwards the messages, gets exactly one password guess per run and
+// learns nothing that allows an offline dictionary attack.
+//
+// # Flow
+//
+//  1. Each side calls Start with its role (A or B), the password-derived bytes
+//     and the optional identities and AAD, and sends the returned share.
+//  2. On receiving the peer's share, Finish computes the shared key Ke and the
+//     key confirmation MACs.
+//  3. Each side sends Result.Confirm and checks the peer's with Result.Verify
+//     before using Ke. A failed check means the passwords differ (or the
+//     messages were tampered with).
+//
+// The password should be stretched with a memory-hard function before Start;
+// Start reduces it modulo the group order to obtain w.
+//
+// # Security notes
+//
+// M and N are the nothing-up-my-sleeve points from RFC 9382 section 6. Point
+// arithmetic uses filippo.io/nistec, whose P-256 scalar multiplications run
+// in constant time. Shares must use the uncompressed SEC1 encoding, and a
+// share that makes the shared point the identity is refused.
+package spake2
@@ -0,0 +1,220 @@
+package spake2
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+
+	"filippo.io/nistec"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Role identifies which side of the exchange a party plays. The two parties
+// must choose different roles.
+type Role int
+
+const (
+	// RoleA masks its share with M.
+	RoleA Role = iota
+	// RoleB masks its share with N.
+	RoleB
+)
+
+// confirmationInfo is the HKDF info for the key confirmation keys (RFC 9382).
+const confirmationInfo = "ConfirmationKeys"
+
+// shareLen is the length of an uncompressed SEC1 P-256 point, the only
+// encoding accepted for shares.
+const shareLen = 65
+
+var (
+	// ErrBadShare is returned when the peer's share is not a valid point.
+	ErrBadShare = errors.New("spake2: invalid peer share")
+	// ErrConfirmation is returned when the peer's confirmation MAC does not
+	// match, i.e. the passwords differ or a message was altered.
+	ErrConfirmation = errors.New("spake2: key confirmation failed")
+)
+
+var (
+	// order is the order of the P-256 group.
+	order, _ = new(big.Int).SetString("ffffffff00000000ffffffffffffffffbce6faada7179e84f3b9cac2fc632551", 16)
+
+	// M and N from RFC 9382, section 6 (SEC1 compressed encoding).
+	pointM = mustPoint("02886e2f97ace46e55ba9dd7242579f2993b64e16ef3dcab95afd497333d8fa12f")
+	pointN = mustPoint("03d8bbd6c639c62937b04d997f38c3770719c629d7014d49a24b4f98baa1292b49")
+)
+
+// State is one side of an exchange in progress. It must not be reused.
+type State struct {
+	role     Role
+	w        []byte // 32-byte big-endian scalars
+	x        []byte
+	share    []byte
+	idA, idB []byte
+	aad      []byte
+}
+
+// Result holds the outcome of a completed exchange.
+type Result struct {
+	// Key is the shared secret Ke. Use it only after Verify succeeds.
+	Key []byte
+	// Confirm is this side's confirmation MAC, to be sent to the peer.
+	Confirm []byte
+
+	expect []byte
+}
+
+// Start begins an exchange and returns the share to send to the peer.
+//
+// pw is the (stretched) password; idA, idB and aad are optional context that
+// both sides must agree on.
+func Start(role Role, pw, idA, idB, aad []byte) (*State, []byte, error) {
+	x, err := randScalar()
+	if err != nil {
+		return nil, nil, err
+	}
+	return start(role, passwordScalar(pw), x, idA, idB, aad)
+}
+
+// start is Start with the scalars w and x given.
+func start(role Role, w, x, idA, idB, aad []byte) (*State, []byte, error) {
+	// share = x·G + w·M (A) or x·G + w·N (B).
+	mask := pointM
+	if role == RoleB {
+		mask = pointN
+	}
+	gx, err := nistec.NewP256Point().ScalarBaseMult(x)
+	if err != nil {
+		return nil, nil, err
+	}
+	wm, err := nistec.NewP256Point().ScalarMult(mask, w)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &State{
+		role:  role,
+		w:     w,
+		x:     x,
+		share: gx.Add(gx, wm).Bytes(),
+		idA:   idA,
+		idB:   idB,
+		aad:   aad,
+	}
+	return s, s.share, nil
+}
+
+// Finish completes the exchange with the peer's share.
+func (s *State) Finish(peerShare []byte) (*Result, error) {
+	if len(peerShare) != shareLen || peerShare[0] != 4 {
+		return nil, ErrBadShare
+	}
+	peer, err := nistec.NewP256Point().SetBytes(peerShare)
+	if err != nil {
+		return nil, ErrBadShare
+	}
+
+	// Remove the peer's mask: K = x·(peer − w·N) for A, x·(peer − w·M) for B.
+	mask := pointN
+	if s.role == RoleB {
+		mask = pointM
+	}
+	wm, err := nistec.NewP256Point().ScalarMult(mask, s.w)
+	if err != nil {
+		return nil, err
+	}
+	u := peer.Add(peer, wm.Negate(wm))
+	kp, err := nistec.NewP256Point().ScalarMult(u, s.x)
+	if err != nil {
+		return nil, err
+	}
+	if kp.IsInfinity() == 1 {
+		return nil, ErrBadShare
+	}
+	k := kp.Bytes()
+
+	pA, pB := s.share, peerShare
+	if s.role == RoleB {
+		pA, pB = peerShare, s.share
+	}
+	tt := transcript(s.idA, s.idB, pA, pB, k, s.w)
+
+	sum := sha256.Sum256(tt)
+	ke, ka := sum[:16], sum[16:]
+
+	kc := make([]byte, 32)
+	info := append([]byte(confirmationInfo), s.aad...)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ka, nil, info), kc); err != nil {
+		return nil, err
+	}
+	cA, cB := mac(kc[:16], tt), mac(kc[16:], tt)
+
+	r := &Result{Key: append([]byte(nil), ke...), Confirm: cA, expect: cB}
+	if s.role == RoleB {
+		r.Confirm, r.expect = cB, cA
+	}
+	return r, nil
+}
+
+// Verify checks the peer's confirmation MAC in constant time.
+func (r *Result) Verify(peerConfirm []byte) error {
+	if !hmac.Equal(peerConfirm, r.expect) {
+		return ErrConfirmation
+	}
+	return nil
+}
+
+// transcript builds TT: each field prefixed by its length as a little-endian uint64.
+func transcript(fields ...[]byte) []byte {
+	var tt []byte
+	for _, f := range fields {
+		tt = binary.LittleEndian.AppendUint64(tt, uint64(len(f)))
+		tt = append(tt, f...)
+	}
+	return tt
+}
+
+// mac returns HMAC-SHA256(key, msg).
+func mac(key, msg []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// passwordScalar reduces the stretched password pw modulo the group order
+// to obtain w, as 32 big-endian bytes.
+func passwordScalar(pw []byte) []byte {
+	w := new(big.Int).SetBytes(pw)
+	return w.Mod(w, order).FillBytes(make([]byte, 32))
+}
+
+// randScalar returns a uniformly random non-zero scalar as 32 big-endian
+// bytes.
+func randScalar() ([]byte, error) {
+	for {
+		k, err := rand.Int(rand.Reader, order)
+		if err != nil {
+			return nil, err
+		}
+		if k.Sign() != 0 {
+			return k.FillBytes(make([]byte, 32)), nil
+		}
+	}
+}
+
+// mustPoint decodes a SEC1 point or panics.
+func mustPoint(s string) *nistec.P256Point {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	p, err := nistec.NewP256Point().SetBytes(b)
+	if err != nil {
+		panic("spake2: invalid constant point")
+	}
+	return p
+}
@@ -0,0 +1,84 @@
+package spake2_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"ciphera/internal/protocol/spake2"
+)
+
+// run performs a full exchange and returns both results.
+func run(t *testing.T, pwA, pwB []byte) (*spake2.Result, *spake2.Result) {
+	t.Helper()
+	aad := []byte("test")
+	a, shareA, err := spake2.Start(spake2.RoleA, pwA, []byte("alice"), []byte("bob"), aad)
+	if err != nil {
+		t.Fatalf("Start A: %v", err)
+	}
+	b, shareB, err := spake2.Start(spake2.RoleB, pwB, []byte("alice"), []byte("bob"), aad)
+	if err != nil {
+		t.Fatalf("Start B: %v", err)
+	}
+	ra, err := a.Finish(shareB)
+	if err != nil {
+		t.Fatalf("Finish A: %v", err)
+	}
+	rb, err := b.Finish(shareA)
+	if err != nil {
+		t.Fatalf("Finish B: %v", err)
+	}
+	return ra, rb
+}
+
+func TestExchange_SamePassword(t *testing.T) {
+	ra, rb := run(t, []byte("correct horse"), []byte("correct horse"))
+	if !bytes.Equal(ra.Key, rb.Key) {
+		t.Fatal("keys differ")
+	}
+	if err := ra.Verify(rb.Confirm); err != nil {
+		t.Fatalf("A verifies B: %v", err)
+	}
+	if err := rb.Verify(ra.Confirm); err != nil {
+		t.Fatalf("B verifies A: %v", err)
+	}
+	if bytes.Equal(ra.Confirm, rb.Confirm) {
+		t.Fatal("confirmation MACs must differ per role")
+	}
+}
+
+func TestExchange_WrongPassword(t *testing.T) {
+	ra, rb := run(t, []byte("correct horse"), []byte("battery staple"))
+	if bytes.Equal(ra.Key, rb.Key) {
+		t.Fatal("keys match with different passwords")
+	}
+	if err := ra.Verify(rb.Confirm); !errors.Is(err, spake2.ErrConfirmation) {
+		t.Fatalf("A verify = %v, want ErrConfirmation", err)
+	}
+	if err := rb.Verify(ra.Confirm); !errors.Is(err, spake2.ErrConfirmation) {
+		t.Fatalf("B verify = %v, want ErrConfirmation", err)
+	}
+}
+
+func TestExchange_FreshKeysPerRun(t *testing.T) {
+	r1, _ := run(t, []byte("pw"), []byte("pw"))
+	r2, _ := run(t, []byte("pw"), []byte("pw"))
+	if bytes.Equal(r1.Key, r2.Key) {
+		t.Fatal("two runs produced the same key")
+	}
+}
+
+func TestFinish_RejectsInvalidShare(t *testing.T) {
+	a, share, err := spake2.Start(spake2.RoleA, []byte("pw"), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	bad := append([]byte(nil), share...)
+	bad[len(bad)-1] ^= 1 // no longer on the curve
+	if _, err := a.Finish(bad); !errors.Is(err, spake2.ErrBadShare) {
+		t.Fatalf("Finish = %v, want ErrBadShare", err)
+	}
+	if _, err := a.Finish([]byte{4}); !errors.Is(err, spake2.ErrBadShare) {
+		t.Fatalf("Finish short = %v, want ErrBadShare", err)
+	}
+}
@@ -0,0 +1,69 @@
+package spake2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestRFC9382Vectors checks one exchange against the SPAKE2-P256-SHA256-
+// HKDF-HMAC test vector of RFC 9382, appendix B, with A="server",
+// B="client" and no AAD.
+func TestRFC9382Vectors(t *testing.T) {
+	h := func(s string) []byte {
+		t.Helper()
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	var (
+		idA = []byte("server")
+		idB = []byte("client")
+		w   = h("2ee57912099d31560b3a44b1184b9b4866e904c49d12ac5042c97dca461b1a5f")
+		x   = h("43dd0fd7215bdcb482879fca3220c6a968e66d70b1356cac18bb26c84a78d729")
+		y   = h("dcb60106f276b02606d8ef0a328c02e4b629f84f89786af5befb0bc75b6e66be")
+
+		wantPA = h("04a56fa807caaa53a4d28dbb9853b9815c61a411118a6fe516a8798434751470f9010153ac33d0d5f2047ffdb1a3e42c9b4e6be662766e1eeb4116988ede5f912c")
+		wantPB = h("0406557e482bd03097ad0cbaa5df82115460d951e3451962f1eaf4367a420676d09857ccbc522686c83d1852abfa8ed6e4a1155cf8f1543ceca528afb591a1e0b7")
+		wantKe = h("0e0672dc86f8e45565d338b0540abe69")
+		wantCA = h("58ad4aa88e0b60d5061eb6b5dd93e80d9c4f00d127c65b3b35b1b5281fee38f0")
+		wantCB = h("d3e2e547f1ae04f2dbdbf0fc4b79f8ecff2dff314b5d32fe9fcef2fb26dc459b")
+	)
+
+	a, pA, err := start(RoleA, w, x, idA, idB, nil)
+	if err != nil {
+		t.Fatalf("start A: %v", err)
+	}
+	b, pB, err := start(RoleB, w, y, idA, idB, nil)
+	if err != nil {
+		t.Fatalf("start B: %v", err)
+	}
+	if !bytes.Equal(pA, wantPA) {
+		t.Fatalf("pA = %x, want %x", pA, wantPA)
+	}
+	if !bytes.Equal(pB, wantPB) {
+		t.Fatalf("pB = %x, want %x", pB, wantPB)
+	}
+
+	ra, err := a.Finish(pB)
+	if err != nil {
+		t.Fatalf("Finish A: %v", err)
+	}
+	rb, err := b.Finish(pA)
+	if err != nil {
+		t.Fatalf("Finish B: %v", err)
+	}
+	for _, r := range []*Result{ra, rb} {
+		if !bytes.Equal(r.Key, wantKe) {
+			t.Fatalf("Ke = %x, want %x", r.Key, wantKe)
+		}
+	}
+	if !bytes.Equal(ra.Confirm, wantCA) {
+		t.Fatalf("A's confirmation = %x, want %x", ra.Confirm, wantCA)
+	}
+	if !bytes.Equal(rb.Confirm, wantCB) {
+		t.Fatalf("B's confirmation = %x, want %x", rb.Confirm, wantCB)
+	}
+}
@@ -0,0 +1,21 @@
+// Package vectors generates deterministic test vectors for Ciphera's key
+// derivations.
+//
+// # Overview
+//
+// Generate derives every key from fixed seeds and walks a short conversation:
+// an X3DH handshake with a one-time prekey, two messages from the initiator and
+// one reply from the responder. Each intermediate value is recorded as hex:
+//
+//   - X3DH DH outputs (DH1..DH4) and the resulting root key
+//   - Root-chain steps (DH output, root key in/out, chain key)
+//   - Symmetric-chain steps (chain key in/out, message key, nonce)
+//   - Headers, associated data and ciphertexts
+//
+// The HKDF info labels and the signed prekey's signature context are included so third-party implementations can check
+// each step of the derivation path independently.
+//
+// # Security notes
+//
+// Seeded keys are predictable by design. They must never be used outside tests.
+package vectors
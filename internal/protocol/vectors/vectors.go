@@ -0,0 +1,319 @@
+package vectors
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/ratchet"
+	"ciphera/internal/protocol/x3dh"
+)
+
+// Version identifies the vector layout; bump it when fields change meaning.
+const Version = "ciphera-vectors-v2"
+
+// seedPrefix namespaces every seed so vectors cannot collide with other uses.
+const seedPrefix = "ciphera/vectors/"
+
+var (
+	// ErrInconsistent indicates the generated vectors disagree with the protocol code.
+	ErrInconsistent = errors.New("vectors inconsistent with protocol implementation")
+)
+
+// Labels lists the HKDF info strings used along the derivation path, and the
+// signature context of the signed prekey.
+type Labels struct {
+	X3DH   string `json:"x3dh"`
+	Root   string `json:"root"`
+	Chain  string `json:"chain"`
+	Nonce  string `json:"nonce"`
+	SPKSig string `json:"spk_sig"`
+}
+
+// Key is a seeded key pair. Private halves are included because the keys are
+// public test fixtures.
+type Key struct {
+	Seed string `json:"seed"`
+	Priv string `json:"priv"`
+	Pub  string `json:"pub"`
+}
+
+// Handshake records the X3DH inputs and outputs.
+type Handshake struct {
+	AliceIdentity    Key    `json:"alice_identity"`
+	AliceEphemeral   Key    `json:"alice_ephemeral"`
+	BobIdentity      Key    `json:"bob_identity"`
+	BobSigning       Key    `json:"bob_signing"`
+	BobSignedPrekey  Key    `json:"bob_signed_prekey"`
+	BobSignedPreSig  string `json:"bob_signed_prekey_sig"`
+	BobOneTimePrekey Key    `json:"bob_one_time_prekey"`
+	DH1              string `json:"dh1"` // IK_A · SPK_B
+	DH2              string `json:"dh2"` // EK_A · IK_B
+	DH3              string `json:"dh3"` // EK_A · SPK_B
+	DH4              string `json:"dh4"` // EK_A · OPK_B
+	RootKey          string `json:"root_key"`
+}
+
+// RootStep records one DH ratchet step along the root chain.
+type RootStep struct {
+	Name       string `json:"name"`
+	RatchetKey Key    `json:"ratchet_key"`
+	PeerPub    string `json:"peer_pub"`
+	DHOutput   string `json:"dh_output"`
+	RootIn     string `json:"root_in"`
+	RootOut    string `json:"root_out"`
+	ChainKey   string `json:"chain_key"`
+}
+
+// Message records one symmetric-chain step and the resulting ciphertext.
+type Message struct {
+	Sender      string `json:"sender"`
+	ChainKeyIn  string `json:"chain_key_in"`
+	ChainKeyOut string `json:"chain_key_out"`
+	MessageKey  string `json:"message_key"`
+	Nonce       string `json:"nonce"`
+	HeaderDHPub string `json:"header_dh_pub"`
+	HeaderPN    uint32 `json:"header_pn"`
+	HeaderN     uint32 `json:"header_n"`
+	AAD         string `json:"aad"`
+	Plaintext   string `json:"plaintext"`
+	Ciphertext  string `json:"ciphertext"`
+}
+
+// Vectors is the complete, deterministic derivation trace.
+type Vectors struct {
+	Version   string     `json:"version"`
+	Labels    Labels     `json:"labels"`
+	Handshake Handshake  `json:"x3dh"`
+	RootSteps []RootStep `json:"root_steps"`
+	Messages  []Message  `json:"messages"`
+}
+
+// Generate builds the vectors from fixed seeds.
+//
+// The X3DH root is cross-checked against x3dh.ResponderRoot so the output always
+// reflects the code that ships.
+func Generate() (Vectors, error) {
+	v := Vectors{
+		Version: Version,
+		Labels: Labels{
+			X3DH:   x3dh.Info,
+			Root:   ratchet.InfoRoot,
+			Chain:  ratchet.InfoChain,
+			Nonce:  ratchet.InfoNonce,
+			SPKSig: x3dh.SPKContext,
+		},
+	}
+
+	// Long-term and prekey material.
+	aliceIK, aliceIKKey, err := seededX25519("alice/identity")
+	if err != nil {
+		return Vectors{}, err
+	}
+	aliceEK, aliceEKKey, err := seededX25519("alice/ephemeral")
+	if err != nil {
+		return Vectors{}, err
+	}
+	bobIK, bobIKKey, err := seededX25519("bob/identity")
+	if err != nil {
+		return Vectors{}, err
+	}
+	bobSPK, bobSPKKey, err := seededX25519("bob/signed_prekey")
+	if err != nil {
+		return Vectors{}, err
+	}
+	bobOPK, bobOPKKey, err := seededX25519("bob/one_time_prekey")
+	if err != nil {
+		return Vectors{}, err
+	}
+	edSeedLabel := seedPrefix + "bob/signing"
+	var edSeed [32]byte
+	copy(edSeed[:], padSeed(edSeedLabel))
+	bobEdPriv, bobEdPub := crypto.Ed25519FromSeed(edSeed)
+	spkSig := x3dh.SignSPK(bobEdPriv, bobSPK.pub)
+
+	// X3DH from the initiator's point of view.
+	dh1, err := crypto.DH(aliceIK.priv, bobSPK.pub)
+	if err != nil {
+		return Vectors{}, err
+	}
+	dh2, err := crypto.DH(aliceEK.priv, bobIK.pub)
+	if err != nil {
+		return Vectors{}, err
+	}
+	dh3, err := crypto.DH(aliceEK.priv, bobSPK.pub)
+	if err != nil {
+		return Vectors{}, err
+	}
+	dh4, err := crypto.DH(aliceEK.priv, bobOPK.pub)
+	if err != nil {
+		return Vectors{}, err
+	}
+	root, err := x3dh.DeriveRoot(dh1, dh2, dh3, dh4)
+	if err != nil {
+		return Vectors{}, err
+	}
+	responderRoot, err := x3dh.ResponderRoot(
+		domain.Identity{XPriv: bobIK.priv, XPub: bobIK.pub},
+		bobSPK.priv,
+		&bobOPK.priv,
+		domain.PrekeyMessage{InitiatorIK: aliceIK.pub, Ephemeral: aliceEK.pub},
+	)
+	if err != nil {
+		return Vectors{}, err
+	}
+	if !bytes.Equal(root, responderRoot) {
+		return Vectors{}, fmt.Errorf("%w: x3dh root", ErrInconsistent)
+	}
+
+	v.Handshake = Handshake{
+		AliceIdentity:    aliceIKKey,
+		AliceEphemeral:   aliceEKKey,
+		BobIdentity:      bobIKKey,
+		BobSigning:       Key{Seed: edSeedLabel, Priv: hex.EncodeToString(bobEdPriv[:]), Pub: hex.EncodeToString(bobEdPub[:])},
+		BobSignedPrekey:  bobSPKKey,
+		BobSignedPreSig:  hex.EncodeToString(spkSig),
+		BobOneTimePrekey: bobOPKKey,
+		DH1:              hex.EncodeToString(dh1[:]),
+		DH2:              hex.EncodeToString(dh2[:]),
+		DH3:              hex.EncodeToString(dh3[:]),
+		DH4:              hex.EncodeToString(dh4[:]),
+		RootKey:          hex.EncodeToString(root),
+	}
+
+	// Alice initialises as initiator: one root step against Bob's identity key.
+	aliceRK, aliceRKKey, err := seededX25519("alice/ratchet/0")
+	if err != nil {
+		return Vectors{}, err
+	}
+	step, rootA, aliceCK, err := rootStep("alice_init", aliceRK, aliceRKKey, bobIK.pub, root)
+	if err != nil {
+		return Vectors{}, err
+	}
+	v.RootSteps = append(v.RootSteps, step)
+
+	for n, text := range []string{"hello bob", "second message"} {
+		m, next, err := message("alice", aliceCK, aliceRK.pub, 0, uint32(n), []byte(text))
+		if err != nil {
+			return Vectors{}, err
+		}
+		v.Messages = append(v.Messages, m)
+		aliceCK = next
+	}
+
+	// Bob replies: a sending ratchet step from his fresh ratchet key.
+	bobRK, bobRKKey, err := seededX25519("bob/ratchet/0")
+	if err != nil {
+		return Vectors{}, err
+	}
+	step, _, bobCK, err := rootStep("bob_send", bobRK, bobRKKey, aliceRK.pub, rootA)
+	if err != nil {
+		return Vectors{}, err
+	}
+	v.RootSteps = append(v.RootSteps, step)
+
+	m, _, err := message("bob", bobCK, bobRK.pub, 0, 0, []byte("hi alice"))
+	if err != nil {
+		return Vectors{}, err
+	}
+	v.Messages = append(v.Messages, m)
+
+	return v, nil
+}
+
+/* ------------------------------------------- Helpers ------------------------------------------ */
+
+// pair is a seeded X25519 key pair in fixed-size form.
+type pair struct {
+	priv domain.X25519Private
+	pub  domain.X25519Public
+}
+
+// seededX25519 derives a key pair for label and its hex description.
+func seededX25519(label string) (pair, Key, error) {
+	seed := seedPrefix + label
+	priv, pub, err := crypto.X25519FromSeed([]byte(seed))
+	if err != nil {
+		return pair{}, Key{}, err
+	}
+	return pair{priv: priv, pub: pub}, Key{
+		Seed: seed,
+		Priv: hex.EncodeToString(priv[:]),
+		Pub:  hex.EncodeToString(pub[:]),
+	}, nil
+}
+
+// padSeed returns label truncated or zero-padded to 32 bytes.
+func padSeed(label string) []byte {
+	out := make([]byte, 32)
+	copy(out, label)
+	return out
+}
+
+// rootStep performs DH(ours, peer) and a KDFRoot step from root.
+func rootStep(
+	name string,
+	ours pair,
+	oursKey Key,
+	peer domain.X25519Public,
+	root []byte,
+) (RootStep, []byte, []byte, error) {
+	dh, err := crypto.DH(ours.priv, peer)
+	if err != nil {
+		return RootStep{}, nil, nil, err
+	}
+	newRoot, chainKey, err := ratchet.KDFRoot(root, dh[:])
+	if err != nil {
+		return RootStep{}, nil, nil, err
+	}
+	return RootStep{
+		Name:       name,
+		RatchetKey: oursKey,
+		PeerPub:    hex.EncodeToString(peer[:]),
+		DHOutput:   hex.EncodeToString(dh[:]),
+		RootIn:     hex.EncodeToString(root),
+		RootOut:    hex.EncodeToString(newRoot),
+		ChainKey:   hex.EncodeToString(chainKey),
+	}, newRoot, chainKey, nil
+}
+
+// message performs one KDFChain step from chainKey and seals plaintext.
+func message(
+	sender string,
+	chainKey []byte,
+	dhPub domain.X25519Public,
+	pn uint32,
+	n uint32,
+	plaintext []byte,
+) (Message, []byte, error) {
+	next, mk, err := ratchet.KDFChain(chainKey)
+	if err != nil {
+		return Message{}, nil, err
+	}
+	nonce, err := ratchet.MessageNonce(mk)
+	if err != nil {
+		return Message{}, nil, err
+	}
+	header := domain.RatchetHeader{DHPub: append([]byte(nil), dhPub[:]...), PN: pn, N: n}
+	aad := ratchet.HeaderAAD(nil, header)
+	ct, err := ratchet.SealMessage(mk, aad, plaintext)
+	if err != nil {
+		return Message{}, nil, err
+	}
+	return Message{
+		Sender:      sender,
+		ChainKeyIn:  hex.EncodeToString(chainKey),
+		ChainKeyOut: hex.EncodeToString(next),
+		MessageKey:  hex.EncodeToString(mk),
+		Nonce:       hex.EncodeToString(nonce),
+		HeaderDHPub: hex.EncodeToString(header.DHPub),
+		HeaderPN:    pn,
+		HeaderN:     n,
+		AAD:         hex.EncodeToString(aad),
+		Plaintext:   string(plaintext),
+		Ciphertext:  hex.EncodeToString(ct),
+	}, next, nil
+}
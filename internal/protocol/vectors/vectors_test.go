@@ -0,0 +1,118 @@
+package vectors_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/ratchet"
+	"ciphera/internal/protocol/vectors"
+)
+
+// unhex decodes s or fails the test.
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("hex decode %q: %v", s, err)
+	}
+	return b
+}
+
+// x25519 decodes a vector key into fixed-size types.
+func x25519(t *testing.T, k vectors.Key) (domain.X25519Private, domain.X25519Public) {
+	t.Helper()
+	var priv domain.X25519Private
+	var pub domain.X25519Public
+	copy(priv[:], unhex(t, k.Priv))
+	copy(pub[:], unhex(t, k.Pub))
+	return priv, pub
+}
+
+// header rebuilds the ratchet header of m.
+func header(t *testing.T, m vectors.Message) domain.RatchetHeader {
+	t.Helper()
+	return domain.RatchetHeader{DHPub: unhex(t, m.HeaderDHPub), PN: m.HeaderPN, N: m.HeaderN}
+}
+
+func TestGenerate_Deterministic(t *testing.T) {
+	a, err := vectors.Generate()
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	b, err := vectors.Generate()
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	if !bytes.Equal(ja, jb) {
+		t.Fatal("vectors differ between runs")
+	}
+}
+
+func TestGenerate_MatchesRatchet(t *testing.T) {
+	v, err := vectors.Generate()
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if len(v.RootSteps) != 2 || len(v.Messages) != 3 {
+		t.Fatalf("unexpected shape: %d root steps, %d messages", len(v.RootSteps), len(v.Messages))
+	}
+
+	// Bob decrypts Alice's messages with the real responder state.
+	bobPriv, bobPub := x25519(t, v.Handshake.BobIdentity)
+	_, aliceRatchetPub := x25519(t, v.RootSteps[0].RatchetKey)
+	bob, err := ratchet.InitAsResponder(unhex(t, v.Handshake.RootKey), bobPriv, bobPub, aliceRatchetPub, "")
+	if err != nil {
+		t.Fatalf("InitAsResponder: %v", err)
+	}
+	for i, m := range v.Messages[:2] {
+		pt, err := ratchet.Decrypt(&bob, nil, header(t, m), unhex(t, m.Ciphertext))
+		if err != nil {
+			t.Fatalf("bob Decrypt[%d]: %v", i, err)
+		}
+		if string(pt) != m.Plaintext {
+			t.Fatalf("bob Decrypt[%d] = %q, want %q", i, pt, m.Plaintext)
+		}
+	}
+
+	// Alice decrypts Bob's reply from the state implied by the vectors.
+	alicePriv, alicePub := x25519(t, v.RootSteps[0].RatchetKey)
+	alice := domain.RatchetState{
+		RootKey:   unhex(t, v.RootSteps[0].RootOut),
+		DHPriv:    alicePriv,
+		DHPub:     alicePub,
+		PeerDHPub: bobPub,
+		SendCK:    unhex(t, v.Messages[1].ChainKeyOut),
+		Ns:        2,
+		Skipped:   make(map[string][]byte),
+	}
+	reply := v.Messages[2]
+	pt, err := ratchet.Decrypt(&alice, nil, header(t, reply), unhex(t, reply.Ciphertext))
+	if err != nil {
+		t.Fatalf("alice Decrypt: %v", err)
+	}
+	if string(pt) != reply.Plaintext {
+		t.Fatalf("alice Decrypt = %q, want %q", pt, reply.Plaintext)
+	}
+	if got := hex.EncodeToString(alice.RecvCK); got != reply.ChainKeyOut {
+		t.Fatalf("recv chain after reply = %s, want %s", got, reply.ChainKeyOut)
+	}
+}
+
+func TestGenerate_SignedPrekey(t *testing.T) {
+	v, err := vectors.Generate()
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	var edPub domain.Ed25519Public
+	copy(edPub[:], unhex(t, v.Handshake.BobSigning.Pub))
+	spk := unhex(t, v.Handshake.BobSignedPrekey.Pub)
+	if !crypto.VerifyContext(edPub, v.Labels.SPKSig, spk, unhex(t, v.Handshake.BobSignedPreSig)) {
+		t.Fatal("signed prekey signature does not verify")
+	}
+}
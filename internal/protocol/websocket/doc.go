@@ -0,0 +1,18 @@
+// Package websocket implements the part of the WebSocket protocol (RFC 6455)
+// the relay's push channel needs: the opening handshake on both sides,
+// text and binary messages, fragmentation, ping, pong and close.
+// Extensions and subprotocols are not negotiated.
+//
+// The server side hijacks the HTTP/1.1 connection of an upgrade request
+// (Accept); HTTP/2 connections cannot be hijacked, so a client must ask over
+// HTTP/1.1. The client side (Dial) sends the upgrade through an http.Client
+// and speaks over the body of the 101 response, so proxies, TLS settings and
+// test transports configured on the client apply as to any other request.
+//
+// The framing is written here rather than taken from golang.org/x/net/websocket,
+// which is frozen and does not let the caller send pings or pick close codes;
+// FuzzReadMessage and FuzzFrameRoundTrip exercise the frame parser.
+//
+// A Conn allows one reader and any number of concurrent writers. Pings are
+// answered and close frames echoed as messages are read.
+package websocket
@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// pipe is a connection that reads from r and records what is written.
+type pipe struct {
+	r   io.Reader
+	out bytes.Buffer
+}
+
+func (p *pipe) Read(b []byte) (int, error)  { return p.r.Read(b) }
+func (p *pipe) Write(b []byte) (int, error) { return p.out.Write(b) }
+func (*pipe) Close() error                  { return nil }
+
+// fuzzConn returns a Conn that reads in from the peer; client picks the
+// side it plays.
+func fuzzConn(in []byte, client bool) *Conn {
+	p := &pipe{r: bytes.NewReader(in)}
+	c := newConn(p, bufio.NewReader(p), client)
+	c.ReadLimit = 1 << 12
+	return c
+}
+
+// FuzzReadMessage feeds arbitrary bytes to both sides of a connection: every
+// message read must fit the read limit, and every failure must be a protocol
+// error, an oversized message, a close or the end of the input.
+func FuzzReadMessage(f *testing.F) {
+	f.Add([]byte{0x81, 0x85, 1, 2, 3, 4, 'h' ^ 1, 'e' ^ 2, 'l' ^ 3, 'l' ^ 4, 'o' ^ 1})
+	f.Add([]byte{0x01, 0x02, 'h', 'e', 0x89, 0x00, 0x80, 0x03, 'l', 'l', 'o'})
+	f.Add([]byte{0x88, 0x80, 1, 2, 3, 4})
+	f.Add([]byte{0x88, 0x02, 0x03, 0xE8})
+	f.Add([]byte{0x82, 0x7E, 0x00, 0x04, 1, 2, 3, 4})
+	f.Add([]byte{0x82, 0xFF, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0})
+	f.Add([]byte{0x89, 0x7E, 0x00, 0x80})
+	f.Add([]byte{0xC1, 0x00})
+	f.Fuzz(func(t *testing.T, in []byte) {
+		for _, client := range []bool{false, true} {
+			c := fuzzConn(in, client)
+			for {
+				msg, _, err := c.ReadMessage()
+				if err != nil {
+					var ce *CloseError
+					if !errors.Is(err, ErrProtocol) && !errors.Is(err, ErrTooLarge) && !errors.As(err, &ce) &&
+						!errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+						t.Fatalf("ReadMessage(client=%v) = %v", client, err)
+					}
+					break
+				}
+				if len(msg) > c.ReadLimit {
+					t.Fatalf("ReadMessage(client=%v) returned %d bytes over a limit of %d", client, len(msg), c.ReadLimit)
+				}
+			}
+		}
+	})
+}
+
+// FuzzFrameRoundTrip checks that a message written by either side is read
+// back unchanged by the other.
+func FuzzFrameRoundTrip(f *testing.F) {
+	f.Add([]byte("hello"))
+	f.Add([]byte{})
+	f.Add(bytes.Repeat([]byte{0xAA}, 126))
+	f.Add(bytes.Repeat([]byte{0x55}, 1<<12))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > 1<<12 {
+			return
+		}
+		for _, client := range []bool{false, true} {
+			w := &pipe{}
+			if err := newConn(w, nil, client).WriteText(data); err != nil {
+				t.Fatalf("WriteText(client=%v): %v", client, err)
+			}
+			got, text, err := fuzzConn(w.out.Bytes(), !client).ReadMessage()
+			if err != nil || !text || !bytes.Equal(got, data) {
+				t.Fatalf("ReadMessage(client=%v) = %x, %v, %v; want %x", !client, got, text, err, data)
+			}
+		}
+	})
+}
@@ -0,0 +1,406 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// acceptGUID is appended to the client's key to derive the accept key.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Opcodes (RFC 6455, section 5.2).
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close codes (RFC 6455, section 7.4.1).
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseTooLarge      = 1009
+	CloseTryAgainLater = 1013
+
+	// CloseNoStatus reports a close frame that carried no code. It is
+	// reserved: it is never sent in a frame.
+	CloseNoStatus = 1005
+)
+
+// DefaultReadLimit bounds a message read when Conn.ReadLimit is not set.
+const DefaultReadLimit = 1 << 20
+
+var (
+	// ErrNotWebSocket is returned by Accept for a request that does not ask
+	// to upgrade to a WebSocket, and by Dial when the server refuses to.
+	ErrNotWebSocket = errors.New("not a websocket upgrade")
+	// ErrProtocol is returned for a frame that breaks the protocol.
+	ErrProtocol = errors.New("websocket protocol error")
+	// ErrTooLarge is returned for a message over the read limit.
+	ErrTooLarge = errors.New("websocket message too large")
+)
+
+// CloseError is returned by ReadMessage once the peer closed the connection,
+// with the code and reason it gave.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+// Conn is an open WebSocket connection.
+type Conn struct {
+	rw     io.ReadWriteCloser
+	br     *bufio.Reader
+	client bool // masks what it writes, and expects unmasked frames
+
+	// ReadLimit bounds a message's size; zero means DefaultReadLimit.
+	ReadLimit int
+
+	wmu      sync.Mutex
+	closed   bool
+	lastRead atomic.Int64 // unix nanoseconds of the last frame read
+}
+
+func newConn(rw io.ReadWriteCloser, br *bufio.Reader, client bool) *Conn {
+	c := &Conn{rw: rw, br: br, client: client}
+	c.lastRead.Store(time.Now().UnixNano())
+	return c
+}
+
+// AcceptKey returns the Sec-WebSocket-Accept value for key.
+func AcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// IsUpgrade reports whether r asks to upgrade to a WebSocket.
+func IsUpgrade(r *http.Request) bool {
+	return headerHas(r.Header, "Connection", "upgrade") && strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// Accept completes the opening handshake of r and takes over its
+// connection. On error nothing has been written to w, so the caller can
+// still answer with an HTTP error.
+func Accept(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		return nil, ErrNotWebSocket
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, fmt.Errorf("%w: version %q", ErrNotWebSocket, r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return nil, fmt.Errorf("%w: bad key", ErrNotWebSocket)
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// The server's read and write timeouts no longer apply.
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newConn(conn, brw.Reader, false), nil
+}
+
+// Dial opens a WebSocket by sending req, a GET to an http:// or https://
+// URL, through client, with the upgrade headers added. The client must speak
+// HTTP/1.1 to the server, and should have no Timeout, which would cut the
+// connection off.
+func Dial(client *http.Client, req *http.Request) (*Conn, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(raw[:])
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	rw, ok := resp.Body.(io.ReadWriteCloser)
+	if resp.StatusCode != http.StatusSwitchingProtocols || !ok {
+		resp.Body.Close()
+		return nil, &HandshakeError{Status: resp.StatusCode}
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != AcceptKey(key) {
+		rw.Close()
+		return nil, fmt.Errorf("%w: bad accept key", ErrNotWebSocket)
+	}
+	return newConn(rw, bufio.NewReader(rw), true), nil
+}
+
+// HandshakeError is returned by Dial when the server answers the upgrade
+// with anything but 101 Switching Protocols.
+type HandshakeError struct {
+	Status int
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("%s: HTTP %d", ErrNotWebSocket, e.Status)
+}
+
+func (e *HandshakeError) Unwrap() error { return ErrNotWebSocket }
+
+// ReadMessage returns the next text or binary message and whether it is
+// text. Pings are answered and a close frame is echoed on the way; once the
+// peer has closed, a *CloseError is returned.
+func (c *Conn) ReadMessage() (data []byte, text bool, err error) {
+	limit := c.ReadLimit
+	if limit <= 0 {
+		limit = DefaultReadLimit
+	}
+	var (
+		msg     []byte
+		started bool
+	)
+	for {
+		fin, op, payload, err := c.readFrame(limit - len(msg))
+		if err != nil {
+			if errors.Is(err, ErrTooLarge) {
+				c.CloseWith(CloseTooLarge, "message too large")
+			} else if errors.Is(err, ErrProtocol) {
+				c.CloseWith(CloseProtocolError, "")
+			}
+			return nil, false, err
+		}
+		switch op {
+		case opPing:
+			if err := c.write(opPong, payload); err != nil {
+				return nil, false, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			// Echo the code, or nothing if there was none: CloseNoStatus
+			// must not appear on the wire.
+			ce := &CloseError{Code: CloseNoStatus}
+			var echo []byte
+			if len(payload) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(payload))
+				ce.Reason = string(payload[2:])
+				echo = payload[:2]
+			}
+			c.closeWith(echo)
+			return nil, false, ce
+		case opText, opBinary:
+			if started {
+				return nil, false, fmt.Errorf("%w: new message inside a fragmented one", ErrProtocol)
+			}
+			started, text = true, op == opText
+		case opContinuation:
+			if !started {
+				return nil, false, fmt.Errorf("%w: continuation without a message", ErrProtocol)
+			}
+		default:
+			return nil, false, fmt.Errorf("%w: opcode %d", ErrProtocol, op)
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, text, nil
+		}
+	}
+}
+
+// readFrame reads one frame, unmasking its payload, which may be at most
+// limit bytes unless it is a control frame.
+func (c *Conn) readFrame(limit int) (fin bool, op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	c.lastRead.Store(time.Now().UnixNano())
+	fin, op = hdr[0]&0x80 != 0, hdr[0]&0x0F
+	if hdr[0]&0x70 != 0 {
+		return false, 0, nil, fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	}
+	masked := hdr[1]&0x80 != 0
+	if masked == c.client {
+		return false, 0, nil, fmt.Errorf("%w: wrong masking", ErrProtocol)
+	}
+	n := uint64(hdr[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(c.br, b[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	control := op&0x8 != 0
+	if control && (n > 125 || !fin) {
+		return false, 0, nil, fmt.Errorf("%w: bad control frame", ErrProtocol)
+	}
+	if !control && n > uint64(max(limit, 0)) {
+		return false, 0, nil, ErrTooLarge
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// WriteText sends data as one text message.
+func (c *Conn) WriteText(data []byte) error {
+	return c.write(opText, data)
+}
+
+// Ping sends a ping; the peer's pong is consumed by ReadMessage.
+func (c *Conn) Ping() error {
+	return c.write(opPing, nil)
+}
+
+// SetWriteDeadline sets the deadline for writes on the underlying
+// connection, which must support it, as net.Conn does; a write that misses
+// it fails, and the connection is then unusable.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	d, ok := c.rw.(interface{ SetWriteDeadline(time.Time) error })
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return d.SetWriteDeadline(t)
+}
+
+// Idle returns how long it has been since a frame, of any kind, was read.
+func (c *Conn) Idle() time.Duration {
+	return time.Since(time.Unix(0, c.lastRead.Load()))
+}
+
+// write sends one unfragmented frame.
+func (c *Conn) write(op byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return c.writeLocked(op, payload)
+}
+
+func (c *Conn) writeLocked(op byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|op)
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.rw.Write(frame)
+	return err
+}
+
+// CloseWith sends a close frame with code and reason, unless one was sent
+// already, and closes the connection.
+func (c *Conn) CloseWith(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	return c.closeWith(append(payload, reason...))
+}
+
+// closeWith sends a close frame carrying payload, which may be empty, unless
+// one was sent already, and closes the connection.
+func (c *Conn) closeWith(payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	werr := c.writeLocked(opClose, payload)
+	return errors.Join(werr, c.rw.Close())
+}
+
+// Close closes the connection normally.
+func (c *Conn) Close() error {
+	return c.CloseWith(CloseNormal, "")
+}
+
+// headerHas reports whether the comma-separated header name lists token.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
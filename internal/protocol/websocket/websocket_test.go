@@ -0,0 +1,214 @@
+package websocket_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ciphera/internal/protocol/websocket"
+)
+
+// echoServer accepts WebSockets and echoes each message back.
+func echoServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer c.Close()
+		c.ReadLimit = 1 << 16
+		for {
+			msg, _, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteText(msg); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dial opens a WebSocket to srv.
+func dial(t *testing.T, srv *httptest.Server) (*websocket.Conn, error) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	return websocket.Dial(srv.Client(), req)
+}
+
+func TestAcceptKey(t *testing.T) {
+	// The example of RFC 6455, section 1.3.
+	if got := websocket.AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("AcceptKey = %q", got)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	srv := echoServer(t)
+	c, err := dial(t, srv)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	// Sizes cover each of the three length encodings.
+	for _, n := range []int{0, 5, 125, 126, 1000, 65535, 65536} {
+		msg := bytes.Repeat([]byte{'x'}, n)
+		if err := c.WriteText(msg); err != nil {
+			t.Fatalf("WriteText(%d): %v", n, err)
+		}
+		got, text, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage(%d): %v", n, err)
+		}
+		if !text || !bytes.Equal(got, msg) {
+			t.Fatalf("echo of %d bytes: got %d bytes, text %v", n, len(got), text)
+		}
+	}
+
+	// A ping is answered without disturbing messages.
+	if err := c.Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if err := c.WriteText([]byte("after ping")); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	if got, _, err := c.ReadMessage(); err != nil || string(got) != "after ping" {
+		t.Fatalf("ReadMessage = %q, %v", got, err)
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	srv := echoServer(t)
+	c, err := dial(t, srv)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.WriteText(make([]byte, 1<<16+1)); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	_, _, err = c.ReadMessage()
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.CloseTooLarge {
+		t.Fatalf("ReadMessage err = %v, want close %d", err, websocket.CloseTooLarge)
+	}
+}
+
+func TestClose(t *testing.T) {
+	closed := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r)
+		if err != nil {
+			return
+		}
+		_, _, err = c.ReadMessage()
+		closed <- err
+	}))
+	defer srv.Close()
+
+	c, err := dial(t, srv)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if err := c.CloseWith(websocket.CloseGoingAway, "bye"); err != nil {
+		t.Fatalf("CloseWith: %v", err)
+	}
+	var ce *websocket.CloseError
+	if err := <-closed; !errors.As(err, &ce) || ce.Code != websocket.CloseGoingAway || ce.Reason != "bye" {
+		t.Fatalf("server read err = %v", err)
+	}
+	if err := c.WriteText([]byte("late")); err == nil {
+		t.Fatal("WriteText after close succeeded")
+	}
+}
+
+func TestClose_NoStatus(t *testing.T) {
+	closed := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r)
+		if err != nil {
+			return
+		}
+		_, _, err = c.ReadMessage()
+		closed <- err
+	}))
+	defer srv.Close()
+
+	// Upgrade by hand so the client can send a close frame without a code.
+	nc, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer nc.Close()
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	if _, err := io.WriteString(nc, "GET / HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+key+"\r\nSec-WebSocket-Version: 13\r\n\r\n"); err != nil {
+		t.Fatalf("write handshake: %v", err)
+	}
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: %v %v", resp, err)
+	}
+	// FIN|close, masked, empty payload.
+	if _, err := nc.Write([]byte{0x88, 0x80, 1, 2, 3, 4}); err != nil {
+		t.Fatalf("write close: %v", err)
+	}
+
+	var ce *websocket.CloseError
+	if err := <-closed; !errors.As(err, &ce) || ce.Code != websocket.CloseNoStatus {
+		t.Fatalf("server read err = %v", err)
+	}
+	// The echo must not carry the reserved CloseNoStatus code.
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		t.Fatalf("read echo: %v", err)
+	}
+	if hdr[0] != 0x88 || hdr[1] != 0 {
+		t.Fatalf("echoed close header = %x, want 8800", hdr)
+	}
+}
+
+func TestAccept_RejectsPlainRequest(t *testing.T) {
+	srv := echoServer(t)
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestDial_RefusedUpgrade(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	_, err := dial(t, srv)
+	var he *websocket.HandshakeError
+	if !errors.As(err, &he) || he.Status != http.StatusTooManyRequests || !errors.Is(err, websocket.ErrNotWebSocket) {
+		t.Fatalf("Dial err = %v", err)
+	}
+	if !strings.Contains(err.Error(), "429") {
+		t.Fatalf("error %q does not name the status", err)
+	}
+}
@@ -24,9 +24,31 @@
 //  3. Compute the symmetric DH set (SPKb·IKa, IKb·EKa, SPKb·EKa[, OPKb·EKa]).
 //  4. HKDF the same transcript to the identical root key.
 //
+// # Cipher suites
+//
+// The HKDF of step 4 runs with the cipher suite (see crypto.Suite) the
+// initiator picked from those the bundle offers. It names the suite in
+// PrekeyMessage.Suite so the responder derives the same root; an empty
+// suite is crypto.DefaultSuite. SignSPKSuites signs the offered suites with
+// the SPK, so a relay cannot strip the stronger ones and push a downgrade;
+// OfferedSuites trusts the list of no other bundle.
+//
 // # Errors
 //
-// ErrBadSPK is returned when the SPK signature fails verification.
+// ErrBadSPK is returned when the SPK signature fails verification, or when the
+// bundle names a signature version this client does not know.
+// crypto.ErrUnknownSuite is returned for a suite this client does not
+// implement.
+//
+// # Signed prekey signature
+//
+// SignSPK signs the SPK under the context SPKContext (see crypto.SignContext),
+// so the signature cannot be reused as any other Ciphera signature. Bundles
+// whose SignedPrekeySigV is SigRaw were signed over the bare SPK by older
+// clients and are still accepted while they migrate. The bundle names its own
+// version, so this package cannot stop whoever serves it from falling back to
+// SigRaw, or from SigSuites to an older version without suites; callers pin
+// the version per peer (see domain.Session.PeerSigV).
 // Other errors wrap lower-level crypto or storage failures.
 //
 // # Security notes
@@ -34,4 +56,7 @@
 // Only public material is sent over the wire. One-time prekeys, when present,
 // improve forward secrecy by ensuring the handshake mixes in a value that is
 // deleted after first use.
+//
+// Package ciphera/pkg/x3dh wraps this one in a stable API for programs that
+// embed the handshake without the rest of the client.
 package x3dh
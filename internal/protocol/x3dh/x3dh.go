@@ -1,34 +1,51 @@
 package x3dh
 
 import (
-	"crypto/sha256"
+	"encoding/binary"
 	"errors"
-	"io"
-
-	"golang.org/x/crypto/hkdf"
+	"slices"
 
 	"ciphera/internal/crypto"
 	"ciphera/internal/domain"
 )
 
-const x3dhLabel = "ciphera/x3dh-v1"
+// Info is the HKDF info label used to derive the root key, defined in
+// internal/crypto. It is part of the wire protocol and must never change.
+const Info = crypto.LabelX3DHRoot
+
+// SPKContext is the signature context for signed prekeys. It is part of the
+// wire protocol and must never change.
+const SPKContext = "ciphera/spk-v1"
+
+// SuitesContext is the signature context for a signed prekey together with
+// the cipher suites offered with it (domain.SigSuites). It is part of the
+// wire protocol and must never change.
+const SuitesContext = "ciphera/spk-suites-v1"
 
 var ErrBadSPK = errors.New("signed prekey verification failed")
 
-// InitiatorRoot performs the X3DH handshake as the initiator.
+// InitiatorRoot performs the X3DH handshake as the initiator, deriving the
+// root key with the cipher suite suiteID ("" for crypto.DefaultSuite). The
+// suite must be recorded in the PrekeyMessage so the responder derives the
+// same root.
 // Returns (rootKey, usedSPKID, usedOPKID, ephPub, error).
 func InitiatorRoot(
 	our domain.Identity,
 	b domain.PrekeyBundle,
+	suiteID string,
 ) (
 	root []byte,
-	spkID string,
-	opkID string,
+	spkID domain.KeyID,
+	opkID domain.KeyID,
 	ephPub domain.X25519Public,
 	err error,
 ) {
-	if !verifySPK(b) {
-		return nil, "", "", ephPub, ErrBadSPK
+	suite, err := crypto.LookupSuite(suiteID)
+	if err != nil {
+		return nil, "", "", ephPub, err
+	}
+	if err := VerifySPK(b); err != nil {
+		return nil, "", "", ephPub, err
 	}
 
 	ephPriv, ephPub, err := crypto.GenerateX25519()
@@ -61,20 +78,25 @@ func InitiatorRoot(
 		if derr != nil {
 			return nil, "", "", ephPub, derr
 		}
-		root, err = deriveRootFromShared(dh1, dh2, dh3, dh4)
+		root, err = deriveRootFromShared(suite, dh1, dh2, dh3, dh4)
 	} else {
-		root, err = deriveRootFromShared(dh1, dh2, dh3)
+		root, err = deriveRootFromShared(suite, dh1, dh2, dh3)
 	}
 	return root, spkID, opkID, ephPub, err
 }
 
-// ResponderRoot performs the X3DH handshake as the responder.
+// ResponderRoot performs the X3DH handshake as the responder, deriving the
+// root key with the cipher suite pm names.
 func ResponderRoot(
 	my domain.Identity,
 	spkPriv domain.X25519Private,
 	opkPriv *domain.X25519Private,
 	pm domain.PrekeyMessage,
 ) (root []byte, err error) {
+	suite, err := crypto.LookupSuite(pm.Suite)
+	if err != nil {
+		return nil, err
+	}
 	dh1, err := crypto.DH(spkPriv, pm.InitiatorIK)
 	if err != nil {
 		return nil, err
@@ -93,38 +115,90 @@ func ResponderRoot(
 		if derr != nil {
 			return nil, derr
 		}
-		root, err = deriveRootFromShared(dh1, dh2, dh3, dh4)
+		root, err = deriveRootFromShared(suite, dh1, dh2, dh3, dh4)
 	} else {
-		root, err = deriveRootFromShared(dh1, dh2, dh3)
+		root, err = deriveRootFromShared(suite, dh1, dh2, dh3)
 	}
 	return root, err
 }
 
+// DeriveRoot derives the 32-byte root key from DH outputs in transcript order
+// (DH1..DH3[, DH4]) using HKDF-SHA256 with no salt and info=Info, as
+// crypto.DefaultSuite does.
+func DeriveRoot(dhs ...[32]byte) ([]byte, error) {
+	suite, err := crypto.LookupSuite(crypto.DefaultSuite)
+	if err != nil {
+		return nil, err
+	}
+	return deriveRootFromShared(suite, dhs...)
+}
+
 // --- Helpers ---
 
-// verifySPK checks that bundle.SignedPrekey was signed by bundle.SignKey.
-func verifySPK(b domain.PrekeyBundle) bool {
-	return crypto.VerifyEd25519(
-		b.SignKey,
-		b.SignedPrekey[:],
-		b.SignedPrekeySig,
-	)
+// SignSPK signs a signed prekey with priv under SPKContext. The result goes
+// in PrekeyBundle.SignedPrekeySig with SignedPrekeySigV set to SigContext.
+func SignSPK(priv domain.Ed25519Private, spk domain.X25519Public) []byte {
+	return crypto.SignContext(priv, SPKContext, spk[:])
 }
 
-// deriveRootFromShared concatenates the DH outputs and runs HKDF to produce a 32-byte root key.
-// Uses x3dhLabel internally.
-func deriveRootFromShared(dhs ...[32]byte) ([]byte, error) {
+// SignSPKSuites signs spk together with suites, the cipher suite IDs
+// offered with it in their order, under SuitesContext. The result goes in
+// PrekeyBundle.SignedPrekeySig with SignedPrekeySigV set to SigSuites, so
+// whoever serves the bundle cannot drop or reorder the suites.
+func SignSPKSuites(priv domain.Ed25519Private, spk domain.X25519Public, suites []string) []byte {
+	return crypto.SignContext(priv, SuitesContext, suitesStatement(spk, suites))
+}
+
+// VerifySPK checks that b.SignedPrekey, and with SigSuites b.Suites, were
+// signed by b.SignKey, and returns ErrBadSPK if not. Bundles from clients
+// that predate signature contexts carry a raw signature (SigRaw), which is
+// still accepted.
+func VerifySPK(b domain.PrekeyBundle) error {
+	var ok bool
+	switch b.SignedPrekeySigV {
+	case domain.SigSuites:
+		ok = crypto.VerifyContext(b.SignKey, SuitesContext, suitesStatement(b.SignedPrekey, b.Suites), b.SignedPrekeySig)
+	case domain.SigContext:
+		ok = crypto.VerifyContext(b.SignKey, SPKContext, b.SignedPrekey[:], b.SignedPrekeySig)
+	case domain.SigRaw:
+		ok = crypto.VerifyEd25519(b.SignKey, b.SignedPrekey[:], b.SignedPrekeySig)
+	}
+	if !ok {
+		return ErrBadSPK
+	}
+	return nil
+}
+
+// OfferedSuites returns the cipher suites b offers, to pass to
+// crypto.NegotiateSuite once VerifySPK accepted b. Only a SigSuites
+// signature covers them; any other bundle counts as offering none, since
+// whoever served it could have cut its list down.
+func OfferedSuites(b domain.PrekeyBundle) []string {
+	if b.SignedPrekeySigV != domain.SigSuites {
+		return nil
+	}
+	return b.Suites
+}
+
+// suitesStatement is spk followed by each suite ID, length-prefixed.
+func suitesStatement(spk domain.X25519Public, suites []string) []byte {
+	out := slices.Clone(spk[:])
+	for _, id := range suites {
+		out = binary.BigEndian.AppendUint32(out, uint32(len(id)))
+		out = append(out, id...)
+	}
+	return out
+}
+
+// deriveRootFromShared concatenates the DH outputs and derives the 32-byte
+// root key from them with suite.
+func deriveRootFromShared(suite *crypto.Suite, dhs ...[32]byte) ([]byte, error) {
 	transcript := make([]byte, 0, len(dhs)*32)
 	for _, dh := range dhs {
 		transcript = append(transcript, dh[:]...)
 	}
 
-	hk := hkdf.New(sha256.New, transcript, nil, []byte(x3dhLabel))
-	root := make([]byte, 32)
-	if _, err := io.ReadFull(hk, root); err != nil {
-		return nil, err
-	}
-
+	root, err := suite.DeriveX3DHRoot(transcript)
 	crypto.Wipe(transcript)
-	return root, nil
+	return root, err
 }
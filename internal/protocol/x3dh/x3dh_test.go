@@ -3,6 +3,7 @@ package x3dh_test
 import (
 	"bytes"
 	"crypto/ed25519"
+	"errors"
 	"testing"
 
 	"ciphera/internal/crypto"
@@ -39,7 +40,7 @@ func TestInitiatorAndResponderRoot_NoOPK(t *testing.T) {
 	alice := makeIdentity(t)
 	bob := makeIdentity(t)
 
-	// Bob's signed prekey pair + sig.
+	// Bob's signed prekey pair + sig, signed raw as older clients did.
 	spkPriv, spkPub, err := crypto.GenerateX25519()
 	if err != nil {
 		t.Fatalf("GenerateX25519: %v", err)
@@ -58,7 +59,7 @@ func TestInitiatorAndResponderRoot_NoOPK(t *testing.T) {
 	}
 
 	// Alice derives RK and emits eph pub.
-	rkA, spkID, opkID, ephPub, err := x3dh.InitiatorRoot(alice, bundle)
+	rkA, spkID, opkID, ephPub, err := x3dh.InitiatorRoot(alice, bundle, "")
 	if err != nil {
 		t.Fatalf("InitiatorRoot: %v", err)
 	}
@@ -97,7 +98,7 @@ func TestInitiatorAndResponderRoot_WithOPK(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GenerateX25519: %v", err)
 	}
-	sig := crypto.SignEd25519(bob.EdPriv, spkPub[:])
+	sig := x3dh.SignSPK(bob.EdPriv, spkPub)
 
 	// Bob has a one-time prekey too.
 	opkPriv, opkPub, err := crypto.GenerateX25519()
@@ -106,19 +107,20 @@ func TestInitiatorAndResponderRoot_WithOPK(t *testing.T) {
 	}
 
 	bundle := domain.PrekeyBundle{
-		Username:        "bob",
-		IdentityKey:     bob.XPub,
-		SignKey:         bob.EdPub,
-		SPKID:           "spk-test",
-		SignedPrekey:    spkPub,
-		SignedPrekeySig: sig,
+		Username:         "bob",
+		IdentityKey:      bob.XPub,
+		SignKey:          bob.EdPub,
+		SPKID:            "spk-test",
+		SignedPrekey:     spkPub,
+		SignedPrekeySig:  sig,
+		SignedPrekeySigV: domain.SigContext,
 		OneTime: []domain.OneTimePub{
 			{ID: "opk-1", Pub: opkPub},
 		},
 	}
 
 	// Alice picks Bob's OPK and derives RK.
-	rkA, spkID, opkID, ephPub, err := x3dh.InitiatorRoot(alice, bundle)
+	rkA, spkID, opkID, ephPub, err := x3dh.InitiatorRoot(alice, bundle, "")
 	if err != nil {
 		t.Fatalf("InitiatorRoot: %v", err)
 	}
@@ -143,3 +145,147 @@ func TestInitiatorAndResponderRoot_WithOPK(t *testing.T) {
 		t.Fatal("root keys differ (with OPK)")
 	}
 }
+
+func TestInitiatorRoot_SPKSignatureVersions(t *testing.T) {
+	alice := makeIdentity(t)
+	bob := makeIdentity(t)
+	_, spkPub, err := crypto.GenerateX25519()
+	if err != nil {
+		t.Fatalf("GenerateX25519: %v", err)
+	}
+	raw := crypto.SignEd25519(bob.EdPriv, spkPub[:])
+	ctx := x3dh.SignSPK(bob.EdPriv, spkPub)
+	other := crypto.SignContext(bob.EdPriv, "ciphera/other", spkPub[:])
+
+	tests := []struct {
+		name string
+		sig  []byte
+		v    domain.SigVersion
+		ok   bool
+	}{
+		{"context", ctx, domain.SigContext, true},
+		{"legacy raw", raw, domain.SigRaw, true},
+		{"raw claimed as context", raw, domain.SigContext, false},
+		{"context claimed as raw", ctx, domain.SigRaw, false},
+		{"other context", other, domain.SigContext, false},
+		{"unknown version", ctx, domain.SigVersion(99), false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bundle := domain.PrekeyBundle{
+				Username:         "bob",
+				IdentityKey:      bob.XPub,
+				SignKey:          bob.EdPub,
+				SPKID:            "spk-test",
+				SignedPrekey:     spkPub,
+				SignedPrekeySig:  tc.sig,
+				SignedPrekeySigV: tc.v,
+			}
+			_, _, _, _, err := x3dh.InitiatorRoot(alice, bundle, "")
+			if tc.ok && err != nil {
+				t.Fatalf("InitiatorRoot: %v", err)
+			}
+			if !tc.ok && !errors.Is(err, x3dh.ErrBadSPK) {
+				t.Fatalf("InitiatorRoot = %v, want ErrBadSPK", err)
+			}
+		})
+	}
+}
+
+func TestInitiatorAndResponderRoot_Suites(t *testing.T) {
+	alice := makeIdentity(t)
+	bob := makeIdentity(t)
+	spkPriv, spkPub, err := crypto.GenerateX25519()
+	if err != nil {
+		t.Fatalf("GenerateX25519: %v", err)
+	}
+	bundle := domain.PrekeyBundle{
+		Username:         "bob",
+		IdentityKey:      bob.XPub,
+		SignKey:          bob.EdPub,
+		SPKID:            "spk-test",
+		SignedPrekey:     spkPub,
+		SignedPrekeySig:  x3dh.SignSPK(bob.EdPriv, spkPub),
+		SignedPrekeySigV: domain.SigContext,
+	}
+
+	rkA, _, _, ephPub, err := x3dh.InitiatorRoot(alice, bundle, crypto.SuiteSHA512)
+	if err != nil {
+		t.Fatalf("InitiatorRoot: %v", err)
+	}
+	pm := domain.PrekeyMessage{
+		InitiatorIK: alice.XPub,
+		Ephemeral:   ephPub,
+		SPKID:       "spk-test",
+		Suite:       crypto.SuiteSHA512,
+	}
+	rkB, err := x3dh.ResponderRoot(bob, spkPriv, nil, pm)
+	if err != nil {
+		t.Fatalf("ResponderRoot: %v", err)
+	}
+	if !bytes.Equal(rkA, rkB) {
+		t.Fatal("root keys differ (SHA-512 suite)")
+	}
+
+	// A responder deriving with another suite gets another root.
+	pm.Suite = crypto.SuiteSHA256
+	rkB, err = x3dh.ResponderRoot(bob, spkPriv, nil, pm)
+	if err != nil {
+		t.Fatalf("ResponderRoot: %v", err)
+	}
+	if bytes.Equal(rkA, rkB) {
+		t.Fatal("SHA-256 and SHA-512 suites derived the same root")
+	}
+
+	pm.Suite = "nope"
+	if _, err := x3dh.ResponderRoot(bob, spkPriv, nil, pm); !errors.Is(err, crypto.ErrUnknownSuite) {
+		t.Fatalf("ResponderRoot with unknown suite: got %v, want ErrUnknownSuite", err)
+	}
+}
+
+func TestVerifySPK_SignedSuites(t *testing.T) {
+	bob := makeIdentity(t)
+	_, spkPub, err := crypto.GenerateX25519()
+	if err != nil {
+		t.Fatalf("GenerateX25519: %v", err)
+	}
+	offered := []string{crypto.SuiteSHA512, crypto.SuiteSHA256}
+	signed := domain.PrekeyBundle{
+		SignKey:          bob.EdPub,
+		SignedPrekey:     spkPub,
+		SignedPrekeySig:  x3dh.SignSPKSuites(bob.EdPriv, spkPub, offered),
+		SignedPrekeySigV: domain.SigSuites,
+		Suites:           offered,
+	}
+	if err := x3dh.VerifySPK(signed); err != nil {
+		t.Fatalf("VerifySPK: %v", err)
+	}
+	if got := x3dh.OfferedSuites(signed); len(got) != 2 || got[0] != crypto.SuiteSHA512 {
+		t.Fatalf("OfferedSuites = %v, want %v", got, offered)
+	}
+
+	// Whoever serves the bundle cannot strip, reorder or add suites.
+	for name, suites := range map[string][]string{
+		"stripped":  {crypto.SuiteSHA256},
+		"none":      nil,
+		"reordered": {crypto.SuiteSHA256, crypto.SuiteSHA512},
+		"added":     {crypto.SuiteSHA512, crypto.SuiteSHA256, "extra"},
+	} {
+		b := signed
+		b.Suites = suites
+		if err := x3dh.VerifySPK(b); !errors.Is(err, x3dh.ErrBadSPK) {
+			t.Errorf("%s: VerifySPK = %v, want ErrBadSPK", name, err)
+		}
+	}
+
+	// A bundle whose signature does not cover its suites offers none.
+	legacy := signed
+	legacy.SignedPrekeySig = x3dh.SignSPK(bob.EdPriv, spkPub)
+	legacy.SignedPrekeySigV = domain.SigContext
+	if err := x3dh.VerifySPK(legacy); err != nil {
+		t.Fatalf("VerifySPK(SigContext): %v", err)
+	}
+	if got := x3dh.OfferedSuites(legacy); got != nil {
+		t.Fatalf("OfferedSuites(SigContext) = %v, want none", got)
+	}
+}
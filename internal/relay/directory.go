@@ -0,0 +1,143 @@
+package relay
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"ciphera/internal/domain"
+)
+
+// Directory hands out HTTP relay clients by base URL.
+//
+// The default relay is the one given on the command line; the others come from
+// the account store. Clients are created lazily and share one http.Client. A
+// relay whose accounts list failover endpoints gets a Failover client, and the
+// endpoint it settles on is saved back to those accounts so the next command
+// starts there.
+type Directory struct {
+	defaultBase string
+	client      *http.Client
+	accounts    domain.AccountStore
+
+	mu      sync.Mutex
+	clients map[string]domain.RelayClient
+}
+
+// NewDirectory returns a Directory whose default relay is defaultBase.
+//
+// If client is nil, http.DefaultClient is used.
+func NewDirectory(defaultBase string, client *http.Client, accounts domain.AccountStore) *Directory {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Directory{
+		defaultBase: normaliseBase(defaultBase),
+		client:      client,
+		accounts:    accounts,
+		clients:     make(map[string]domain.RelayClient),
+	}
+}
+
+// Client returns the client for server, or for the default relay if server is "".
+//
+// Without a configured default, the first relay we hold an account on is used,
+// so commands work without --relay once the user has registered somewhere.
+func (d *Directory) Client(server string) domain.RelayClient {
+	base := normaliseBase(server)
+	if base == "" {
+		base = d.defaultBase
+	}
+	if base == "" {
+		if servers, err := d.Servers(); err == nil && len(servers) > 0 {
+			base = servers[0]
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.clients[base]
+	if !ok {
+		c = d.newClient(base)
+		d.clients[base] = c
+	}
+	return c
+}
+
+// newClient returns a Failover client if any account on base lists endpoints,
+// and a plain HTTP client otherwise.
+func (d *Directory) newClient(base string) domain.RelayClient {
+	accounts, err := d.accounts.ListAccounts()
+	if err != nil {
+		return NewHTTP(base, d.client)
+	}
+	bases := []string{base}
+	active := ""
+	for _, a := range accounts {
+		if normaliseBase(a.Server) != base {
+			continue
+		}
+		for _, e := range a.Endpoints {
+			if e = normaliseBase(e); !slices.Contains(bases, e) {
+				bases = append(bases, e)
+			}
+		}
+		if a.Active != "" {
+			active = a.Active
+		}
+	}
+	if len(bases) == 1 {
+		return NewHTTP(base, d.client)
+	}
+	return NewFailover(bases, active, d.client, func(to string) { d.saveActive(base, to) })
+}
+
+// saveActive records to as the active endpoint of every account on base. It
+// is best effort: if it fails, the next command starts from base again.
+func (d *Directory) saveActive(base, to string) {
+	if to == base {
+		to = ""
+	}
+	accounts, err := d.accounts.ListAccounts()
+	if err != nil {
+		return
+	}
+	for _, a := range accounts {
+		if normaliseBase(a.Server) == base && a.Active != to {
+			a.Active = to
+			_ = d.accounts.SaveAccount(a)
+		}
+	}
+}
+
+// Servers lists the default relay followed by every relay in the account store.
+func (d *Directory) Servers() ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	add := func(s string) {
+		s = normaliseBase(s)
+		if s != "" && !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	add(d.defaultBase)
+
+	accounts, err := d.accounts.ListAccounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range accounts {
+		add(a.Server)
+	}
+	return out, nil
+}
+
+// normaliseBase trims trailing slashes so equivalent URLs compare equal.
+func normaliseBase(base string) string {
+	return strings.TrimRight(base, "/")
+}
+
+// Compile-time assertion that Directory implements domain.RelayDirectory.
+var _ domain.RelayDirectory = (*Directory)(nil)
@@ -0,0 +1,137 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// DNS cache defaults.
+const (
+	dnsTTL         = 5 * time.Minute  // how long a host's addresses are reused
+	dnsNegativeTTL = 30 * time.Second // how long a host found not to exist stays so
+	dnsMaxHosts    = 32               // hosts remembered; relays are few
+)
+
+// DNSCache resolves relay host names for a dialer and remembers the answers:
+// addresses for a while, and that a host does not exist for less. Other
+// lookup failures, such as a timeout, are never cached. It holds a handful of
+// hosts, enough for the relays and failover endpoints one client talks to,
+// so a receive loop or health check does not ask the resolver on every dial.
+type DNSCache struct {
+	lookup      func(ctx context.Context, host string) ([]string, error)
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+// dnsEntry is one cached answer: addrs, or err if the host was not found.
+type dnsEntry struct {
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+// NewDNSCache returns a DNSCache resolving names with lookup, keeping
+// addresses for ttl and not-found answers for negativeTTL.
+//
+// If lookup is nil, net.DefaultResolver.LookupHost is used; zero TTLs select
+// the defaults of five minutes and thirty seconds.
+func NewDNSCache(lookup func(ctx context.Context, host string) ([]string, error), ttl, negativeTTL time.Duration) *DNSCache {
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupHost
+	}
+	if ttl <= 0 {
+		ttl = dnsTTL
+	}
+	if negativeTTL <= 0 {
+		negativeTTL = dnsNegativeTTL
+	}
+	return &DNSCache{
+		lookup:      lookup,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		now:         time.Now,
+		entries:     make(map[string]dnsEntry),
+	}
+}
+
+// LookupHost returns host's addresses, from the cache while they are fresh.
+// An IP literal is returned as it is.
+func (c *DNSCache) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	now := c.now()
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.addrs, e.err
+	}
+
+	addrs, err := c.lookup(ctx, host)
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil:
+		c.store(host, dnsEntry{addrs: addrs, expires: now.Add(c.ttl)})
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		c.store(host, dnsEntry{err: err, expires: now.Add(c.negativeTTL)})
+	}
+	return addrs, err
+}
+
+// store records e for host, first making room by dropping the entry that
+// expires soonest if the cache is full.
+func (c *DNSCache) store(host string, e dnsEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[host]; !ok && len(c.entries) >= dnsMaxHosts {
+		var oldest string
+		for h, old := range c.entries {
+			if oldest == "" || old.expires.Before(c.entries[oldest].expires) {
+				oldest = h
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[host] = e
+}
+
+// DialContext wraps dial so the host of each address is resolved through
+// the cache. The addresses are tried in turn until one connects; the error
+// of the last is returned if none does.
+func (c *DNSCache) DialContext(
+	dial func(ctx context.Context, network, addr string) (net.Conn, error),
+) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := c.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var last error
+		for _, ip := range ips {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			last = err
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		if last == nil {
+			last = &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+		}
+		return nil, last
+	}
+}
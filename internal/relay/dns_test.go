@@ -0,0 +1,122 @@
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ciphera/internal/relay"
+)
+
+// countingLookup answers every host with addrs, or with a not-found error if
+// addrs is nil, counting the lookups it serves.
+func countingLookup(addrs []string) (func(context.Context, string) ([]string, error), *atomic.Int32) {
+	var n atomic.Int32
+	return func(_ context.Context, host string) ([]string, error) {
+		n.Add(1)
+		if addrs == nil {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return addrs, nil
+	}, &n
+}
+
+func TestDNSCache_Positive(t *testing.T) {
+	lookup, n := countingLookup([]string{"192.0.2.1"})
+	c := relay.NewDNSCache(lookup, time.Hour, 0)
+	for range 3 {
+		got, err := c.LookupHost(context.Background(), "relay.example.org")
+		if err != nil || len(got) != 1 || got[0] != "192.0.2.1" {
+			t.Fatalf("LookupHost = %v, %v; want [192.0.2.1]", got, err)
+		}
+	}
+	if n.Load() != 1 {
+		t.Fatalf("resolver asked %d times, want 1", n.Load())
+	}
+}
+
+func TestDNSCache_NegativeExpires(t *testing.T) {
+	lookup, n := countingLookup(nil)
+	c := relay.NewDNSCache(lookup, 0, 20*time.Millisecond)
+	for range 2 {
+		if _, err := c.LookupHost(context.Background(), "gone.example.org"); err == nil {
+			t.Fatal("LookupHost of a missing host succeeded")
+		}
+	}
+	if n.Load() != 1 {
+		t.Fatalf("resolver asked %d times within the negative TTL, want 1", n.Load())
+	}
+	time.Sleep(30 * time.Millisecond)
+	c.LookupHost(context.Background(), "gone.example.org")
+	if n.Load() != 2 {
+		t.Fatalf("resolver asked %d times after the negative TTL, want 2", n.Load())
+	}
+}
+
+func TestDNSCache_TransientNotCached(t *testing.T) {
+	var n atomic.Int32
+	c := relay.NewDNSCache(func(context.Context, string) ([]string, error) {
+		n.Add(1)
+		return nil, &net.DNSError{Err: "timeout", IsTimeout: true}
+	}, 0, 0)
+	for range 2 {
+		c.LookupHost(context.Background(), "slow.example.org")
+	}
+	if n.Load() != 2 {
+		t.Fatalf("resolver asked %d times, want 2: timeouts must not be cached", n.Load())
+	}
+}
+
+func TestDNSCache_DialTriesEachAddress(t *testing.T) {
+	lookup, _ := countingLookup([]string{"192.0.2.1", "192.0.2.2"})
+	c := relay.NewDNSCache(lookup, 0, 0)
+	var tried []string
+	dial := c.DialContext(func(_ context.Context, _, addr string) (net.Conn, error) {
+		tried = append(tried, addr)
+		if addr == "192.0.2.2:443" {
+			client, server := net.Pipe()
+			server.Close()
+			return client, nil
+		}
+		return nil, errors.New("refused")
+	})
+	conn, err := dial(context.Background(), "tcp", "relay.example.org:443")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.Close()
+	if strings.Join(tried, ",") != "192.0.2.1:443,192.0.2.2:443" {
+		t.Fatalf("dialed %v, want both addresses in order", tried)
+	}
+}
+
+func TestPrewarm_ConnectionReused(t *testing.T) {
+	var conns atomic.Int32
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	s.Start()
+	t.Cleanup(s.Close)
+
+	client := s.Client()
+	<-relay.Prewarm(context.Background(), client, s.URL+"/")
+	resp, err := client.Get(s.URL + "/healthz")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if n := conns.Load(); n != 1 {
+		t.Fatalf("%d connections opened, want 1 reused after prewarming", n)
+	}
+}
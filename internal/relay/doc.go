@@ -7,12 +7,43 @@
 //
 // Supported operations include:
 //   - Publishing our prekey bundle to the relay.
-//   - Fetching a peer's prekey bundle.
+//   - Fetching a peer's prekey bundle, or checking one out with a one-time
+//     prekey the relay hands to no one else.
 //   - Sending encrypted envelopes to a peer via the relay.
 //   - Fetching pending envelopes for a user.
 //   - Acknowledging received messages.
+//   - Subscribing to envelopes the relay pushes over a WebSocket.
+//   - Reading the relay's build and protocol versions.
 //
-// All requests are JSON over HTTP and accept a context for cancellation and
+// All requests but Subscribe are JSON over HTTP and accept a context for cancellation and
 // deadlines. Non-2xx statuses are returned as errors with the HTTP method,
-// full URL, and status text to aid diagnostics.
+// full URL, and status text to aid diagnostics, wrapping the relay's
+// *domain.RelayError so callers branch on its code (domain.RelayCode) rather
+// than on message text. A 404 still matches domain.ErrNotFound, a 409
+// domain.ErrConflict and a suspension domain.ErrSuspended, including from
+// older relays that answer with a bare message.
+//
+// Directory resolves clients by base URL for identities registered on more
+// than one relay. Failover is a client over several endpoints of one relay,
+// such as regional names behind GeoDNS; it health-checks them with
+// GET /healthz, sticks to the one that works, and never repeats a message
+// post the relay may already have queued.
+//
+// Resolver discovers the relay serving the host of a user@host address from
+// the host's well-known document or its DNS SRV record, and caches the result.
+//
+// DNSCache resolves relay host names for the client's dialer, keeping
+// addresses for a few minutes and not-found answers for less. Prewarm opens a
+// connection to a relay in the background so the next request reuses it.
+//
+// HTTP3Transport (experimental) talks to https:// relays over HTTP/3.
+//
+// WithTrace starts a trace on a context; requests made with it carry a W3C
+// traceparent header so a relay exporting traces groups them.
+//
+// For tests, Recorder and Replayer are http.RoundTrippers that record relay
+// exchanges to a JSON cassette and play them back without a relay. The
+// ciphera command takes hidden --relay-record and --relay-replay flags that
+// install them, so command-level tests can run hermetically against traffic
+// captured from a real relay. Golden cassettes live in testdata.
 package relay
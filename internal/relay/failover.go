@@ -0,0 +1,392 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// Failover settings.
+const (
+	probeTimeout = 2 * time.Second  // per /healthz probe
+	downFor      = 30 * time.Second // how long a failed endpoint is skipped
+)
+
+// errUnavailable is wrapped by HTTP when a relay answers 502, 503 or 504.
+var errUnavailable = errors.New("relay unavailable")
+
+// Failover is a RelayClient over several base URLs of one relay.
+//
+// Every endpoint must serve the same queues, so a request may go to any of
+// them. Requests go to the active endpoint, which stays in use until it
+// fails (sticky selection). On failure the other endpoints are probed with
+// GET /healthz in order and the request is repeated on the first healthy
+// one, which becomes active. An endpoint that fails is skipped for downFor
+// unless nothing else is left.
+//
+// A request is only repeated if the relay cannot have acted on it: the
+// connection was refused, or the relay answered 502, 503 or 504. Reads and
+// idempotent writes are also repeated after any other transport error, but a
+// message or pairing post is not, so it is never queued twice.
+type Failover struct {
+	bases    []string
+	clients  []*HTTP
+	probe    *http.Client
+	onSwitch func(base string)
+	now      func() time.Time
+
+	mu        sync.Mutex
+	active    int
+	downUntil []time.Time
+}
+
+// NewFailover returns a client over bases, starting with active if it is one
+// of them and with bases[0] otherwise. onSwitch, if not nil, is called with
+// the new base URL whenever another endpoint becomes active.
+//
+// If client is nil, http.DefaultClient is used.
+func NewFailover(bases []string, active string, client *http.Client, onSwitch func(base string)) *Failover {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	f := &Failover{
+		bases:     make([]string, len(bases)),
+		clients:   make([]*HTTP, len(bases)),
+		probe:     client,
+		onSwitch:  onSwitch,
+		now:       time.Now,
+		downUntil: make([]time.Time, len(bases)),
+	}
+	for i, b := range bases {
+		f.bases[i] = normaliseBase(b)
+		f.clients[i] = NewHTTP(f.bases[i], client)
+		if f.bases[i] == normaliseBase(active) {
+			f.active = i
+		}
+	}
+	return f
+}
+
+// Active returns the base URL requests currently go to.
+func (f *Failover) Active() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.bases[f.active]
+}
+
+// RegisterPrekeyBundle publishes b on the active endpoint. Publishing the same
+// bundle twice is harmless, so it fails over after any transport error.
+func (f *Failover) RegisterPrekeyBundle(ctx context.Context, b domain.PrekeyBundle, ans domain.ChallengeAnswer) error {
+	return f.call(ctx, true, func(c *HTTP) error { return c.RegisterPrekeyBundle(ctx, b, ans) })
+}
+
+// FetchPrekeyBundle fetches username's bundle from the active endpoint.
+func (f *Failover) FetchPrekeyBundle(ctx context.Context, username string, auth domain.RequestAuth) (domain.PrekeyBundle, error) {
+	var out domain.PrekeyBundle
+	err := f.call(ctx, true, func(c *HTTP) error {
+		var err error
+		out, err = c.FetchPrekeyBundle(ctx, username, auth)
+		return err
+	})
+	return out, err
+}
+
+// CheckoutPrekeyBundle checks out one of username's one-time prekeys on the
+// active endpoint. A retried checkout may take a second key, which is
+// harmless, so it fails over after any transport error.
+func (f *Failover) CheckoutPrekeyBundle(ctx context.Context, username string) (domain.PrekeyBundle, error) {
+	var out domain.PrekeyBundle
+	err := f.call(ctx, true, func(c *HTTP) error {
+		var err error
+		out, err = c.CheckoutPrekeyBundle(ctx, username)
+		return err
+	})
+	return out, err
+}
+
+// ServerInfo asks the active endpoint for the relay's build information.
+func (f *Failover) ServerInfo(ctx context.Context) (domain.BuildInfo, error) {
+	var out domain.BuildInfo
+	err := f.call(ctx, true, func(c *HTTP) error {
+		var err error
+		out, err = c.ServerInfo(ctx)
+		return err
+	})
+	return out, err
+}
+
+// PutBackup stores b on the active endpoint. Storing the same backup twice is
+// harmless, so it fails over after any transport error.
+func (f *Failover) PutBackup(ctx context.Context, username string, b domain.RelayBackup) error {
+	return f.call(ctx, true, func(c *HTTP) error { return c.PutBackup(ctx, username, b) })
+}
+
+// FetchBackup fetches username's backup from the active endpoint.
+func (f *Failover) FetchBackup(ctx context.Context, username string) (domain.RelayBackup, error) {
+	var out domain.RelayBackup
+	err := f.call(ctx, true, func(c *HTTP) error {
+		var err error
+		out, err = c.FetchBackup(ctx, username)
+		return err
+	})
+	return out, err
+}
+
+// Usage asks the active endpoint for username's traffic.
+func (f *Failover) Usage(ctx context.Context, username string, auth domain.RequestAuth) ([]domain.RelayUsage, error) {
+	var out []domain.RelayUsage
+	err := f.call(ctx, true, func(c *HTTP) error {
+		var err error
+		out, err = c.Usage(ctx, username, auth)
+		return err
+	})
+	return out, err
+}
+
+// Journal asks the active endpoint for a page of username's queue journal.
+func (f *Failover) Journal(
+	ctx context.Context,
+	username string,
+	after uint64,
+	auth domain.RequestAuth,
+) ([]domain.JournalEvent, error) {
+	var out []domain.JournalEvent
+	err := f.call(ctx, true, func(c *HTTP) error {
+		var err error
+		out, err = c.Journal(ctx, username, after, auth)
+		return err
+	})
+	return out, err
+}
+
+// PutPresence stores p on the active endpoint. Storing the same policy twice
+// is harmless, so it fails over after any transport error.
+func (f *Failover) PutPresence(ctx context.Context, username string, p domain.PresencePolicy) error {
+	return f.call(ctx, true, func(c *HTTP) error { return c.PutPresence(ctx, username, p) })
+}
+
+// Presence asks the active endpoint when username was last seen.
+func (f *Failover) Presence(ctx context.Context, username, viewer string, auth domain.RequestAuth) (domain.Presence, error) {
+	var out domain.Presence
+	err := f.call(ctx, true, func(c *HTTP) error {
+		var err error
+		out, err = c.Presence(ctx, username, viewer, auth)
+		return err
+	})
+	return out, err
+}
+
+// SendMessage posts env to the active endpoint. It only fails over if the
+// envelope cannot have been queued.
+func (f *Failover) SendMessage(ctx context.Context, env domain.Envelope) (uint64, error) {
+	var seq uint64
+	err := f.call(ctx, false, func(c *HTTP) error {
+		var err error
+		seq, err = c.SendMessage(ctx, env)
+		return err
+	})
+	return seq, err
+}
+
+// QueueStats reports username's queue on the active endpoint.
+func (f *Failover) QueueStats(ctx context.Context, username, from string) (domain.QueueStats, error) {
+	var out domain.QueueStats
+	err := f.call(ctx, true, func(c *HTTP) error {
+		var err error
+		out, err = c.QueueStats(ctx, username, from)
+		return err
+	})
+	return out, err
+}
+
+// FetchMessages fetches queued envelopes from the active endpoint.
+func (f *Failover) FetchMessages(ctx context.Context, username string, limit int, auth domain.RequestAuth) ([]domain.Envelope, domain.FetchReport, error) {
+	var (
+		out []domain.Envelope
+		rep domain.FetchReport
+	)
+	err := f.call(ctx, true, func(c *HTTP) error {
+		var err error
+		out, rep, err = c.FetchMessages(ctx, username, limit, auth)
+		return err
+	})
+	return out, rep, err
+}
+
+// WaitMessages fetches queued envelopes from the active endpoint, waiting
+// there up to wait for one to arrive.
+func (f *Failover) WaitMessages(ctx context.Context, username string, limit int, wait time.Duration, auth domain.RequestAuth) ([]domain.Envelope, domain.FetchReport, error) {
+	var (
+		out []domain.Envelope
+		rep domain.FetchReport
+	)
+	err := f.call(ctx, true, func(c *HTTP) error {
+		var err error
+		out, rep, err = c.WaitMessages(ctx, username, limit, wait, auth)
+		return err
+	})
+	return out, rep, err
+}
+
+// Subscribe opens a push connection to the active endpoint.
+func (f *Failover) Subscribe(ctx context.Context, username string, auth domain.RequestAuth) (<-chan domain.Envelope, error) {
+	var out <-chan domain.Envelope
+	err := f.call(ctx, true, func(c *HTTP) error {
+		var err error
+		out, err = c.Subscribe(ctx, username, auth)
+		return err
+	})
+	return out, err
+}
+
+// AckMessages acknowledges ids on the active endpoint. Acks name envelopes by
+// ID, so repeating one is harmless.
+func (f *Failover) AckMessages(ctx context.Context, username string, ids []string) error {
+	return f.call(ctx, true, func(c *HTTP) error { return c.AckMessages(ctx, username, ids) })
+}
+
+// PostPairMessage appends body to a pairing mailbox on the active endpoint.
+// It only fails over if the message cannot have been stored.
+func (f *Failover) PostPairMessage(ctx context.Context, box, side string, body []byte, open bool) error {
+	return f.call(ctx, false, func(c *HTTP) error { return c.PostPairMessage(ctx, box, side, body, open) })
+}
+
+// FetchPairMessages reads a pairing mailbox on the active endpoint.
+func (f *Failover) FetchPairMessages(ctx context.Context, box, side string, after int) ([][]byte, error) {
+	var out [][]byte
+	err := f.call(ctx, true, func(c *HTTP) error {
+		var err error
+		out, err = c.FetchPairMessages(ctx, box, side, after)
+		return err
+	})
+	return out, err
+}
+
+// ClosePairMailbox discards a pairing mailbox on the active endpoint.
+func (f *Failover) ClosePairMailbox(ctx context.Context, box string) error {
+	return f.call(ctx, true, func(c *HTTP) error { return c.ClosePairMailbox(ctx, box) })
+}
+
+// call runs fn against the active endpoint and fails over to the others in
+// order. idempotent says whether fn may be repeated after a transport error
+// that does not prove the request went unseen.
+func (f *Failover) call(ctx context.Context, idempotent bool, fn func(*HTTP) error) error {
+	var errs []error
+	for n, i := range f.order() {
+		if n > 0 {
+			if err := f.healthy(ctx, i); err != nil {
+				f.markDown(i)
+				errs = append(errs, fmt.Errorf("%s: %w", f.bases[i], err))
+				continue
+			}
+		}
+		err := fn(f.clients[i])
+		if answered(err) {
+			f.use(i)
+			return err
+		}
+		f.markDown(i)
+		if !canRetry(ctx, err, idempotent) {
+			return err
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("all endpoints failed: %w", errors.Join(errs...))
+}
+
+// order returns the endpoint indexes to try: the active one, then the others
+// in configured order, with endpoints that failed recently moved to the end.
+func (f *Failover) order() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	out := []int{f.active}
+	var down []int
+	for i := range f.bases {
+		switch {
+		case i == f.active:
+		case now.Before(f.downUntil[i]):
+			down = append(down, i)
+		default:
+			out = append(out, i)
+		}
+	}
+	return append(out, down...)
+}
+
+// use makes endpoint i active and clears its failure.
+func (f *Failover) use(i int) {
+	f.mu.Lock()
+	switched := f.active != i
+	f.active = i
+	f.downUntil[i] = time.Time{}
+	f.mu.Unlock()
+
+	if switched && f.onSwitch != nil {
+		f.onSwitch(f.bases[i])
+	}
+}
+
+// markDown skips endpoint i for downFor.
+func (f *Failover) markDown(i int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.downUntil[i] = f.now().Add(downFor)
+}
+
+// healthy probes GET /healthz on endpoint i.
+func (f *Failover) healthy(ctx context.Context, i int) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	fullURL, err := url.JoinPath(f.bases[i], "healthz")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.probe.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if !is2xx(resp.StatusCode) {
+		return fmt.Errorf("health check: %s", resp.Status)
+	}
+	return nil
+}
+
+// answered reports whether err (or success) came back from a working relay.
+// Errors such as 404 or 409 say nothing against the endpoint.
+func answered(err error) bool {
+	var urlErr *url.Error
+	return err == nil || !errors.Is(err, errUnavailable) && !errors.As(err, &urlErr)
+}
+
+// canRetry reports whether a request that failed with err, which answered
+// rejects, may be sent to another endpoint. Nothing is retried once ctx is
+// done.
+func canRetry(ctx context.Context, err error, idempotent bool) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, errUnavailable) || idempotent {
+		return true
+	}
+	// A refused connection never carried the request.
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Compile-time assertion that Failover implements domain.RelayClient.
+var _ domain.RelayClient = (*Failover)(nil)
@@ -0,0 +1,164 @@
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/relay"
+)
+
+// endpoint is a fake relay endpoint that counts the requests it serves, not
+// counting health checks.
+type endpoint struct {
+	*httptest.Server
+	hits atomic.Int32
+}
+
+// newEndpoint starts an endpoint answering every request with status, and
+// /healthz with healthz.
+func newEndpoint(t *testing.T, status, healthz int) *endpoint {
+	t.Helper()
+	e := &endpoint{}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(healthz)
+			return
+		}
+		e.hits.Add(1)
+		if status == http.StatusOK && r.Method == http.MethodGet {
+			w.Write([]byte("[]"))
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+// closedURL returns the address of a server that is no longer listening.
+func closedURL(t *testing.T) string {
+	t.Helper()
+	s := httptest.NewServer(http.NotFoundHandler())
+	s.Close()
+	return s.URL
+}
+
+func TestFailover_ConnectionRefused(t *testing.T) {
+	backup := newEndpoint(t, http.StatusOK, http.StatusNoContent)
+	var switched string
+	f := relay.NewFailover([]string{closedURL(t), backup.URL}, "", nil, func(b string) { switched = b })
+
+	if _, _, err := f.FetchMessages(context.Background(), "bob", 0, domain.RequestAuth{}); err != nil {
+		t.Fatalf("FetchMessages: %v", err)
+	}
+	if _, err := f.SendMessage(context.Background(), domain.Envelope{To: "alice"}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if switched != backup.URL || f.Active() != backup.URL {
+		t.Fatalf("switched to %q, active %q; want %q", switched, f.Active(), backup.URL)
+	}
+	if n := backup.hits.Load(); n != 2 {
+		t.Fatalf("backup served %d requests, want 2", n)
+	}
+}
+
+func TestFailover_Sticky(t *testing.T) {
+	primary := newEndpoint(t, http.StatusServiceUnavailable, http.StatusNoContent)
+	backup := newEndpoint(t, http.StatusOK, http.StatusNoContent)
+	f := relay.NewFailover([]string{primary.URL, backup.URL}, "", nil, nil)
+
+	for range 3 {
+		if _, _, err := f.FetchMessages(context.Background(), "bob", 0, domain.RequestAuth{}); err != nil {
+			t.Fatalf("FetchMessages: %v", err)
+		}
+	}
+	// Only the first request tries the primary; later ones stay on the backup.
+	if n := primary.hits.Load(); n != 1 {
+		t.Fatalf("primary served %d requests, want 1", n)
+	}
+	if n := backup.hits.Load(); n != 3 {
+		t.Fatalf("backup served %d requests, want 3", n)
+	}
+}
+
+func TestFailover_StartsAtActive(t *testing.T) {
+	primary := newEndpoint(t, http.StatusOK, http.StatusNoContent)
+	backup := newEndpoint(t, http.StatusOK, http.StatusNoContent)
+	f := relay.NewFailover([]string{primary.URL, backup.URL}, backup.URL+"/", nil, nil)
+
+	if _, _, err := f.FetchMessages(context.Background(), "bob", 0, domain.RequestAuth{}); err != nil {
+		t.Fatalf("FetchMessages: %v", err)
+	}
+	if primary.hits.Load() != 0 || backup.hits.Load() != 1 {
+		t.Fatalf("hits primary=%d backup=%d, want 0 and 1", primary.hits.Load(), backup.hits.Load())
+	}
+}
+
+func TestFailover_SkipsUnhealthy(t *testing.T) {
+	primary := newEndpoint(t, http.StatusBadGateway, http.StatusNoContent)
+	sick := newEndpoint(t, http.StatusOK, http.StatusInternalServerError)
+	good := newEndpoint(t, http.StatusOK, http.StatusNoContent)
+	f := relay.NewFailover([]string{primary.URL, sick.URL, good.URL}, "", nil, nil)
+
+	if err := f.AckMessages(context.Background(), "bob", []string{"1"}); err != nil {
+		t.Fatalf("AckMessages: %v", err)
+	}
+	if sick.hits.Load() != 0 || good.hits.Load() != 1 {
+		t.Fatalf("hits sick=%d good=%d, want 0 and 1", sick.hits.Load(), good.hits.Load())
+	}
+}
+
+func TestFailover_AnswerIsNotFailure(t *testing.T) {
+	primary := newEndpoint(t, http.StatusNotFound, http.StatusNoContent)
+	backup := newEndpoint(t, http.StatusOK, http.StatusNoContent)
+	f := relay.NewFailover([]string{primary.URL, backup.URL}, "", nil, nil)
+
+	_, err := f.FetchPrekeyBundle(context.Background(), "nobody", domain.RequestAuth{})
+	if !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", err)
+	}
+	if n := backup.hits.Load(); n != 0 {
+		t.Fatalf("backup served %d requests, want 0", n)
+	}
+}
+
+func TestFailover_SendNotRepeatedAfterBrokenConnection(t *testing.T) {
+	// The primary reads the request and drops the connection without
+	// answering, so the envelope may have been queued.
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	t.Cleanup(primary.Close)
+	backup := newEndpoint(t, http.StatusOK, http.StatusNoContent)
+	f := relay.NewFailover([]string{primary.URL, backup.URL}, "", nil, nil)
+
+	if _, err := f.SendMessage(context.Background(), domain.Envelope{To: "alice"}); err == nil {
+		t.Fatal("SendMessage succeeded, want the primary's error")
+	}
+	if n := backup.hits.Load(); n != 0 {
+		t.Fatalf("backup served %d requests, want 0", n)
+	}
+	// A fetch is safe to repeat and does fail over.
+	if _, _, err := f.FetchMessages(context.Background(), "bob", 0, domain.RequestAuth{}); err != nil {
+		t.Fatalf("FetchMessages: %v", err)
+	}
+}
+
+func TestFailover_AllDown(t *testing.T) {
+	f := relay.NewFailover([]string{closedURL(t), closedURL(t)}, "", nil, nil)
+	if _, _, err := f.FetchMessages(context.Background(), "bob", 0, domain.RequestAuth{}); err == nil {
+		t.Fatal("FetchMessages succeeded with every endpoint down")
+	}
+}
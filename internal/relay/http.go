@@ -3,13 +3,38 @@ package relay
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"ciphera/internal/domain"
+	"ciphera/internal/protocol/relayauth"
+)
+
+// maxErrorBody caps how much of an error response is read.
+const maxErrorBody = 64 << 10
+
+// Fetch responses carry in these headers how many envelopes to the user the
+// relay dropped unfetched since the previous fetch because they expired, and
+// the IDs of those the user sent that did.
+const (
+	expiredHeader     = "X-Ciphera-Expired"
+	expiredSentHeader = "X-Ciphera-Expired-Sent"
+)
+
+// Registration challenge answers travel in these request headers, so the
+// body stays the bundle the relay publishes.
+const (
+	challengeHeader       = "X-Ciphera-Challenge"
+	challengeNonceHeader  = "X-Ciphera-Challenge-Nonce"
+	challengeAnswerHeader = "X-Ciphera-Challenge-Answer"
 )
 
 // HTTP is a RelayClient over HTTP.
@@ -36,43 +61,169 @@ func NewHTTP(base string, client *http.Client) *HTTP {
 
 // RegisterPrekeyBundle publishes a PrekeyBundle to POST /register.
 //
-// The server expects a JSON body describing the caller's current prekeys.
-func (c *HTTP) RegisterPrekeyBundle(ctx context.Context, b domain.PrekeyBundle) error {
-	return c.postJSON(ctx, "/register", b, nil)
+// The server expects a JSON body describing the caller's current prekeys. A
+// set ans is sent in the X-Ciphera-Challenge headers; a 428 response becomes
+// a *domain.ChallengeError carrying the relay's challenge.
+func (c *HTTP) RegisterPrekeyBundle(ctx context.Context, b domain.PrekeyBundle, ans domain.ChallengeAnswer) error {
+	req, err := c.newJSONRequest(ctx, http.MethodPost, "/register", b)
+	if err != nil {
+		return err
+	}
+	if ans.Kind != "" {
+		req.Header.Set(challengeHeader, string(ans.Kind))
+		req.Header.Set(challengeNonceHeader, ans.Nonce)
+		req.Header.Set(challengeAnswerHeader, ans.Answer)
+	}
+	return c.do(req, nil)
 }
 
 // FetchPrekeyBundle retrieves the bundle for username via GET /prekey/{username}.
 //
-// The response body is JSON and is decoded into a domain.PrekeyBundle.
+// The response body is JSON and is decoded into a domain.PrekeyBundle. A
+// non-zero auth signs the request (see package relayauth); only then does
+// the relay include the one-time prekeys it still offers.
 func (c *HTTP) FetchPrekeyBundle(
 	ctx context.Context,
 	username string,
+	auth domain.RequestAuth,
 ) (domain.PrekeyBundle, error) {
+	fullURL, err := url.JoinPath(c.Base, "prekey", username)
+	if err != nil {
+		return domain.PrekeyBundle{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
+	if err != nil {
+		return domain.PrekeyBundle{}, err
+	}
+	if len(auth.Sig) > 0 {
+		setAuth(req, auth)
+	}
 	var out domain.PrekeyBundle
-	path := fmt.Sprintf("/prekey/%s", url.PathEscape(username))
-	if err := c.getJSON(ctx, path, &out); err != nil {
+	if err := c.do(req, &out); err != nil {
+		return domain.PrekeyBundle{}, err
+	}
+	return out, nil
+}
+
+// CheckoutPrekeyBundle retrieves the bundle for username with one one-time
+// prekey, which the relay hands out to no one else, via
+// POST /prekey/{username}/checkout. The bundle carries no one-time prekey
+// once they have run out or too many were checked out lately.
+func (c *HTTP) CheckoutPrekeyBundle(
+	ctx context.Context,
+	username string,
+) (domain.PrekeyBundle, error) {
+	path := fmt.Sprintf("/prekey/%s/checkout", url.PathEscape(username))
+	fullURL, err := url.JoinPath(c.Base, path)
+	if err != nil {
+		fullURL = c.Base + path
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fullURL, nil)
+	if err != nil {
+		return domain.PrekeyBundle{}, err
+	}
+
+	var out domain.PrekeyBundle
+	if err := c.do(req, &out); err != nil {
 		return domain.PrekeyBundle{}, err
 	}
 	return out, nil
 }
 
-// SendMessage posts an Envelope to POST /msg/{to}.
+// ServerInfo retrieves the relay's build information via GET /server-info.
+func (c *HTTP) ServerInfo(ctx context.Context) (domain.BuildInfo, error) {
+	var out domain.BuildInfo
+	if err := c.getJSON(ctx, "/server-info", &out); err != nil {
+		return domain.BuildInfo{}, err
+	}
+	return out, nil
+}
+
+// SendMessage posts an Envelope to POST /msg/{to} and returns the sequence
+// number the relay assigned it.
 //
 // The envelope is sent as JSON. A non-2xx status is treated as an error.
-func (c *HTTP) SendMessage(ctx context.Context, env domain.Envelope) error {
+// Relays that do not report sequence numbers answer 204, and SendMessage
+// returns 0.
+func (c *HTTP) SendMessage(ctx context.Context, env domain.Envelope) (uint64, error) {
 	path := fmt.Sprintf("/msg/%s", url.PathEscape(env.To))
-	return c.postJSON(ctx, path, env, nil)
+	var out struct {
+		Seq uint64 `json:"seq"`
+	}
+	if err := c.postJSON(ctx, path, env, &out); err != nil {
+		return 0, err
+	}
+	return out.Seq, nil
+}
+
+// QueueStats GETs /msg/{user}/stats, restricted to envelopes from sender from
+// unless it is empty.
+func (c *HTTP) QueueStats(ctx context.Context, username, from string) (domain.QueueStats, error) {
+	path := fmt.Sprintf("/msg/%s/stats", url.PathEscape(username))
+	fullURL, err := url.JoinPath(c.Base, path)
+	if err != nil {
+		fullURL = c.Base + path
+	}
+	u, err := url.Parse(fullURL)
+	if err != nil {
+		return domain.QueueStats{}, err
+	}
+	if from != "" {
+		q := u.Query()
+		q.Set("from", from)
+		u.RawQuery = q.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return domain.QueueStats{}, err
+	}
+	var out domain.QueueStats
+	if err := c.do(req, &out); err != nil {
+		return domain.QueueStats{}, err
+	}
+	return out, nil
 }
 
 // FetchMessages GETs up to limit envelopes from /msg/{user}?limit=N.
 //
 // If limit > 0, a query parameter is added to restrict the number of results.
-// The response is a JSON array decoded into []domain.Envelope.
+// The response is a JSON array decoded into []domain.Envelope. The count of
+// envelopes that expired unfetched comes from the X-Ciphera-Expired header,
+// and the IDs of envelopes username sent that expired unfetched from the
+// comma-separated X-Ciphera-Expired-Sent header; relays that do not send
+// them report none. A non-zero auth signs the request (see package
+// relayauth), so the relay counts it as username's activity.
 func (c *HTTP) FetchMessages(
 	ctx context.Context,
 	username string,
 	limit int,
-) ([]domain.Envelope, error) {
+	auth domain.RequestAuth,
+) ([]domain.Envelope, domain.FetchReport, error) {
+	return c.fetch(ctx, username, limit, 0, auth)
+}
+
+// WaitMessages is FetchMessages with wait=D added, so a relay that supports
+// it holds the request open until an envelope arrives or D passes. The
+// client's timeout is stretched by D for this request.
+func (c *HTTP) WaitMessages(
+	ctx context.Context,
+	username string,
+	limit int,
+	wait time.Duration,
+	auth domain.RequestAuth,
+) ([]domain.Envelope, domain.FetchReport, error) {
+	return c.fetch(ctx, username, limit, wait, auth)
+}
+
+// fetch GETs /msg/{user}, waiting up to wait if it is positive.
+func (c *HTTP) fetch(
+	ctx context.Context,
+	username string,
+	limit int,
+	wait time.Duration,
+	auth domain.RequestAuth,
+) ([]domain.Envelope, domain.FetchReport, error) {
 	// Build path using a URL-safe username, then combine with base.
 	path := fmt.Sprintf("/msg/%s", url.PathEscape(username))
 
@@ -86,39 +237,224 @@ func (c *HTTP) FetchMessages(
 	// Parse so we can add query parameters safely.
 	u, err := url.Parse(fullURL)
 	if err != nil {
-		return nil, err
+		return nil, domain.FetchReport{}, err
 	}
+	q := u.Query()
 	if limit > 0 {
-		q := u.Query()
 		q.Set("limit", strconv.Itoa(limit))
-		u.RawQuery = q.Encode()
 	}
+	if wait > 0 {
+		q.Set("wait", wait.String())
+	}
+	u.RawQuery = q.Encode()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
-		return nil, err
+		return nil, domain.FetchReport{}, err
+	}
+	if len(auth.Sig) > 0 {
+		setAuth(req, auth)
 	}
 
 	var envs []domain.Envelope
-	if err := c.do(req, &envs); err != nil {
-		return nil, err
+	cl := c
+	if wait > 0 && c.client.Timeout > 0 {
+		held := *c.client
+		held.Timeout += wait
+		cl = &HTTP{Base: c.Base, client: &held}
+	}
+	header, err := cl.doHeader(req, &envs)
+	if err != nil {
+		return nil, domain.FetchReport{}, err
 	}
-	return envs, nil
+	var rep domain.FetchReport
+	expired, _ := strconv.Atoi(header.Get(expiredHeader))
+	rep.Expired = max(expired, 0)
+	for id := range strings.SplitSeq(header.Get(expiredSentHeader), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			rep.ExpiredSent = append(rep.ExpiredSent, id)
+		}
+	}
+	return envs, rep, nil
 }
 
-// AckMessages sends an acknowledgment to POST /msg/{user}/ack with {count}.
+// AckMessages sends an acknowledgment to POST /msg/{user}/ack with {ids}.
 //
-// The payload is JSON: {"count": N}. Servers use this to delete or mark
-// messages as delivered.
-func (c *HTTP) AckMessages(ctx context.Context, username string, count int) error {
+// The payload is JSON: {"ids": [...]}, listing the relay-assigned envelope
+// IDs to drop. Envelopes queued after the fetch are never affected.
+func (c *HTTP) AckMessages(ctx context.Context, username string, ids []string) error {
 	payload := struct {
-		Count int `json:"count"`
-	}{Count: count}
+		IDs []string `json:"ids"`
+	}{IDs: ids}
 
 	path := fmt.Sprintf("/msg/%s/ack", url.PathEscape(username))
 	return c.postJSON(ctx, path, payload, nil)
 }
 
+// PostPairMessage appends body to pairing mailbox box via POST /pair/{box}.
+//
+// side is "a" or "b". With open set, the relay returns an error wrapping
+// domain.ErrConflict if the mailbox already exists.
+func (c *HTTP) PostPairMessage(ctx context.Context, box, side string, body []byte, open bool) error {
+	payload := struct {
+		Side string `json:"side"`
+		Body []byte `json:"body"`
+		Open bool   `json:"open,omitempty"`
+	}{Side: side, Body: body, Open: open}
+
+	path := fmt.Sprintf("/pair/%s", url.PathEscape(box))
+	return c.postJSON(ctx, path, payload, nil)
+}
+
+// FetchPairMessages returns the other side's messages in mailbox box, skipping
+// the first after, via GET /pair/{box}?side=S&after=N.
+func (c *HTTP) FetchPairMessages(ctx context.Context, box, side string, after int) ([][]byte, error) {
+	fullURL, err := url.JoinPath(c.Base, "pair", box)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(fullURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("side", side)
+	q.Set("after", strconv.Itoa(after))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var out [][]byte
+	if err := c.do(req, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ClosePairMailbox discards mailbox box via DELETE /pair/{box}.
+func (c *HTTP) ClosePairMailbox(ctx context.Context, box string) error {
+	fullURL, err := url.JoinPath(c.Base, "pair", box)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, fullURL, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, nil)
+}
+
+// PutBackup stores b as username's backup via PUT /backup/{user}. The relay
+// refuses it with domain.ErrConflict if it holds a newer backup.
+func (c *HTTP) PutBackup(ctx context.Context, username string, b domain.RelayBackup) error {
+	path := fmt.Sprintf("/backup/%s", url.PathEscape(username))
+	return c.sendJSON(ctx, http.MethodPut, path, b, nil)
+}
+
+// FetchBackup retrieves username's backup via GET /backup/{user}. A user
+// without one yields an error wrapping domain.ErrNotFound.
+func (c *HTTP) FetchBackup(ctx context.Context, username string) (domain.RelayBackup, error) {
+	var out domain.RelayBackup
+	path := fmt.Sprintf("/backup/%s", url.PathEscape(username))
+	if err := c.getJSON(ctx, path, &out); err != nil {
+		return domain.RelayBackup{}, err
+	}
+	return out, nil
+}
+
+// Usage retrieves username's traffic via GET /account/{user}/usage, signed
+// with auth (see package relayauth).
+func (c *HTTP) Usage(ctx context.Context, username string, auth domain.RequestAuth) ([]domain.RelayUsage, error) {
+	fullURL, err := url.JoinPath(c.Base, "account", username, "usage")
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	setAuth(req, auth)
+	var out []domain.RelayUsage
+	if err := c.do(req, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Journal retrieves a page of username's queue journal, the events numbered
+// after after, via GET /account/{user}/journal?after=N, signed with auth
+// (see package relayauth). A relay that keeps no journal yields an error
+// wrapping domain.ErrNotFound.
+func (c *HTTP) Journal(
+	ctx context.Context,
+	username string,
+	after uint64,
+	auth domain.RequestAuth,
+) ([]domain.JournalEvent, error) {
+	fullURL, err := url.JoinPath(c.Base, "account", username, "journal")
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(fullURL)
+	if err != nil {
+		return nil, err
+	}
+	if after > 0 {
+		u.RawQuery = url.Values{"after": {strconv.FormatUint(after, 10)}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	setAuth(req, auth)
+	var out []domain.JournalEvent
+	if err := c.do(req, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PutPresence stores p as username's presence policy via
+// PUT /presence/{user}. The relay refuses it with domain.ErrConflict if it
+// holds a newer policy.
+func (c *HTTP) PutPresence(ctx context.Context, username string, p domain.PresencePolicy) error {
+	path := fmt.Sprintf("/presence/%s", url.PathEscape(username))
+	return c.sendJSON(ctx, http.MethodPut, path, p, nil)
+}
+
+// Presence retrieves when username was last seen via GET /presence/{user},
+// asking as viewer with a request signed with auth (see package relayauth)
+// unless viewer is empty. A user who does not show it to viewer yields an
+// error wrapping domain.ErrNotFound.
+func (c *HTTP) Presence(ctx context.Context, username, viewer string, auth domain.RequestAuth) (domain.Presence, error) {
+	fullURL, err := url.JoinPath(c.Base, "presence", username)
+	if err != nil {
+		return domain.Presence{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
+	if err != nil {
+		return domain.Presence{}, err
+	}
+	if viewer != "" {
+		req.Header.Set(relayauth.UserHeader, viewer)
+		setAuth(req, auth)
+	}
+	var out domain.Presence
+	if err := c.do(req, &out); err != nil {
+		return domain.Presence{}, err
+	}
+	return out, nil
+}
+
+// setAuth adds the signature headers of auth to req.
+func setAuth(req *http.Request, auth domain.RequestAuth) {
+	req.Header.Set(relayauth.TimeHeader, strconv.FormatInt(auth.TimeUTC, 10))
+	req.Header.Set(relayauth.SignatureHeader, base64.StdEncoding.EncodeToString(auth.Sig))
+}
+
 // postJSON encodes in as JSON and POSTs to path, optionally decoding out.
 //
 // path is joined with the client's Base. A non-2xx status returns an error.
@@ -128,9 +464,31 @@ func (c *HTTP) postJSON(
 	in any,
 	out any,
 ) error {
+	return c.sendJSON(ctx, http.MethodPost, path, in, out)
+}
+
+// sendJSON encodes in as JSON and sends it to path with method, optionally
+// decoding out.
+func (c *HTTP) sendJSON(
+	ctx context.Context,
+	method string,
+	path string,
+	in any,
+	out any,
+) error {
+	req, err := c.newJSONRequest(ctx, method, path, in)
+	if err != nil {
+		return err
+	}
+	return c.do(req, out)
+}
+
+// newJSONRequest builds a request to path with method and in encoded as the
+// JSON body.
+func (c *HTTP) newJSONRequest(ctx context.Context, method, path string, in any) (*http.Request, error) {
 	buf := new(bytes.Buffer)
 	if err := json.NewEncoder(buf).Encode(in); err != nil {
-		return err
+		return nil, err
 	}
 
 	fullURL, err := url.JoinPath(c.Base, path)
@@ -138,13 +496,12 @@ func (c *HTTP) postJSON(
 		fullURL = c.Base + path
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fullURL, buf)
+	req, err := http.NewRequestWithContext(ctx, method, fullURL, buf)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-
-	return c.do(req, out)
+	return req, nil
 }
 
 // getJSON performs a GET to path and JSON-decodes the response into out.
@@ -170,20 +527,78 @@ func (c *HTTP) getJSON(
 // Errors include the HTTP method, full URL, and status text to aid debugging.
 // If out is nil, the response body is discarded after the status check.
 func (c *HTTP) do(req *http.Request, out any) error {
+	_, err := c.doHeader(req, out)
+	return err
+}
+
+// doHeader is do, also returning the response headers.
+func (c *HTTP) doHeader(req *http.Request, out any) (http.Header, error) {
+	setTraceParent(req)
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if !is2xx(resp.StatusCode) {
-		return fmt.Errorf("relay %s %s: %s", req.Method, req.URL.String(), resp.Status)
+		return nil, statusError(req, resp)
 	}
 
-	if out != nil {
-		return json.NewDecoder(resp.Body).Decode(out)
+	// Responses without a body (e.g. 204 from relays that predate a field)
+	// leave out untouched.
+	if out != nil && resp.StatusCode != http.StatusNoContent && resp.ContentLength != 0 {
+		return resp.Header, json.NewDecoder(resp.Body).Decode(out)
 	}
-	return nil
+	return resp.Header, nil
+}
+
+// statusError returns the error for resp, a non-2xx response to req. A
+// structured body, {"error": domain.RelayError}, is wrapped as a
+// *domain.RelayError; a 428 becomes a *domain.ChallengeError. Relays that
+// predate error codes get the sentinel for the status: domain.ErrNotFound for
+// 404, domain.ErrConflict for 409 and domain.ErrSuspended for 403.
+//
+// 502, 503 and 504 also wrap errUnavailable, with or without a code.
+func statusError(req *http.Request, resp *http.Response) error {
+	prefix := fmt.Sprintf("relay %s %s: %s", req.Method, req.URL.String(), resp.Status)
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return fmt.Errorf("%s: reading error: %w", prefix, err)
+	}
+
+	var body struct {
+		Error     json.RawMessage              `json:"error"`
+		Challenge domain.RegistrationChallenge `json:"challenge"`
+		Failed    bool                         `json:"failed"`
+	}
+	_ = json.Unmarshal(raw, &body)
+	var re *domain.RelayError
+	if e := (domain.RelayError{}); json.Unmarshal(body.Error, &e) == nil && e.Code != "" {
+		e.Status = resp.StatusCode
+		re = &e
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPreconditionRequired:
+		if body.Challenge.Kind == "" {
+			return fmt.Errorf("%s: decoding challenge: no challenge in the response", prefix)
+		}
+		ce := &domain.ChallengeError{Challenge: body.Challenge, Failed: body.Failed}
+		return fmt.Errorf("relay %s %s: %w", req.Method, req.URL.String(), ce)
+	case re != nil && isUnavailable(resp.StatusCode):
+		return fmt.Errorf("%s: %w: %w", prefix, re, errUnavailable)
+	case re != nil:
+		return fmt.Errorf("%s: %w", prefix, re)
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%s: %w", prefix, domain.ErrNotFound)
+	case resp.StatusCode == http.StatusConflict:
+		return fmt.Errorf("%s: %w", prefix, domain.ErrConflict)
+	case resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%s: %w", prefix, domain.ErrSuspended)
+	case isUnavailable(resp.StatusCode):
+		return fmt.Errorf("%s: %w", prefix, errUnavailable)
+	}
+	return errors.New(prefix)
 }
 
 // is2xx reports whether code is in the 2xx range.
@@ -191,5 +606,13 @@ func is2xx(code int) bool {
 	return code >= http.StatusOK && code < http.StatusMultipleChoices
 }
 
+// isUnavailable reports whether code says the request never reached a working
+// relay (502, 503 or 504), so it is safe to send it elsewhere.
+func isUnavailable(code int) bool {
+	return code == http.StatusBadGateway ||
+		code == http.StatusServiceUnavailable ||
+		code == http.StatusGatewayTimeout
+}
+
 // Compile-time assertion that HTTP implements domain.RelayClient.
 var _ domain.RelayClient = (*HTTP)(nil)
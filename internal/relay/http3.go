@@ -0,0 +1,55 @@
+package relay
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// HTTP/3 connection settings, matching the HTTP/2 pings of the TCP transport.
+const (
+	h3KeepAlive   = 30 * time.Second // ping idle QUIC connections so NATs keep them
+	h3IdleTimeout = 90 * time.Second // close a QUIC connection silent this long
+)
+
+// HTTP3Transport sends requests to https:// relays over HTTP/3 (QUIC) and
+// everything else, such as plain http:// relays, through a TCP transport.
+// Push connections, which HTTP/3 cannot upgrade, take the TCP transport too
+// (see HTTP.Subscribe). It is experimental: a relay that does not serve
+// HTTP/3 fails requests rather than being retried over TCP.
+type HTTP3Transport struct {
+	h3  *http3.Transport
+	tcp *http.Transport
+}
+
+// NewHTTP3Transport returns an HTTP3Transport falling back to tcp, whose TLS
+// settings it shares. QUIC dials do not go through tcp's dialer, so its
+// DNSCache is not used for them.
+func NewHTTP3Transport(tcp *http.Transport) *HTTP3Transport {
+	return &HTTP3Transport{
+		h3: &http3.Transport{
+			TLSClientConfig: tcp.TLSClientConfig.Clone(),
+			QUICConfig: &quic.Config{
+				KeepAlivePeriod: h3KeepAlive,
+				MaxIdleTimeout:  h3IdleTimeout,
+			},
+		},
+		tcp: tcp,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *HTTP3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "https" {
+		return t.h3.RoundTrip(req)
+	}
+	return t.tcp.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of both transports.
+func (t *HTTP3Transport) CloseIdleConnections() {
+	t.h3.CloseIdleConnections()
+	t.tcp.CloseIdleConnections()
+}
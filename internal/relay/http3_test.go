@@ -0,0 +1,97 @@
+package relay_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/websocket"
+	"ciphera/internal/relay"
+)
+
+func TestHTTP3Transport(t *testing.T) {
+	ctx := context.Background()
+	protos := make(chan string, 4)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsUpgrade(r) {
+			protos <- "ws " + r.Proto
+			c, err := websocket.Accept(w, r)
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			c.WriteText([]byte(`{"id":"2","from":"alice","to":"bob"}`))
+			c.ReadMessage()
+			return
+		}
+		protos <- r.Proto
+		w.Write([]byte(`[{"id":"1","from":"alice","to":"bob"}]`))
+	})
+
+	// The TLS server lends its certificate to the HTTP/3 one, and the
+	// client trusts it.
+	tcpSrv := httptest.NewTLSServer(handler)
+	defer tcpSrv.Close()
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer udp.Close()
+	h3Srv := &http3.Server{Handler: handler, TLSConfig: &tls.Config{Certificates: tcpSrv.TLS.Certificates}}
+	go h3Srv.Serve(udp)
+	defer h3Srv.Close()
+
+	tr := relay.NewHTTP3Transport(tcpSrv.Client().Transport.(*http.Transport).Clone())
+	defer tr.CloseIdleConnections()
+	client := &http.Client{Transport: tr, Timeout: 5 * time.Second}
+	want := func(what, proto string) {
+		t.Helper()
+		select {
+		case got := <-protos:
+			if got != proto {
+				t.Fatalf("%s went over %q, want %q", what, got, proto)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s never reached the server", what)
+		}
+	}
+
+	// https:// relays are fetched from over HTTP/3.
+	h3 := relay.NewHTTP("https://"+udp.LocalAddr().String(), client)
+	if envs, _, err := h3.FetchMessages(ctx, "bob", 0, domain.RequestAuth{}); err != nil || len(envs) != 1 {
+		t.Fatalf("FetchMessages over HTTP/3 = %+v, %v", envs, err)
+	}
+	want("fetch from an https:// relay", "HTTP/3.0")
+
+	// Plain http:// relays stay on TCP.
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	if _, _, err := relay.NewHTTP(plain.URL, client).FetchMessages(ctx, "bob", 0, domain.RequestAuth{}); err != nil {
+		t.Fatalf("FetchMessages over HTTP/1.1: %v", err)
+	}
+	want("fetch from an http:// relay", "HTTP/1.1")
+
+	// Push connections upgrade over TCP.
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	push, err := relay.NewHTTP(tcpSrv.URL, client).Subscribe(subCtx, "bob", domain.RequestAuth{})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	want("push upgrade", "ws HTTP/1.1")
+	select {
+	case env := <-push:
+		if env.ID != "2" {
+			t.Fatalf("pushed %+v, want envelope 2", env)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no envelope pushed")
+	}
+}
@@ -0,0 +1,128 @@
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/relay"
+)
+
+func TestFetchMessages_ExpiredCount(t *testing.T) {
+	for _, tc := range []struct {
+		header, sent string
+		want         int
+		wantSent     []string
+	}{
+		{"3", "7,9", 3, []string{"7", "9"}},
+		{"", "", 0, nil},
+		{"junk", " 4 ,", 0, []string{"4"}},
+		{"-1", "", 0, nil},
+	} {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tc.header != "" {
+				w.Header().Set("X-Ciphera-Expired", tc.header)
+			}
+			if tc.sent != "" {
+				w.Header().Set("X-Ciphera-Expired-Sent", tc.sent)
+			}
+			w.Write([]byte(`[{"id":"1","from":"alice","to":"bob"}]`))
+		}))
+		envs, rep, err := relay.NewHTTP(s.URL, s.Client()).FetchMessages(context.Background(), "bob", 0, domain.RequestAuth{})
+		s.Close()
+		if err != nil {
+			t.Fatalf("header %q: FetchMessages: %v", tc.header, err)
+		}
+		if len(envs) != 1 || rep.Expired != tc.want || !slices.Equal(rep.ExpiredSent, tc.wantSent) {
+			t.Fatalf("headers %q, %q: got %d envelope(s), %+v; want 1, %d expired, sent %v",
+				tc.header, tc.sent, len(envs), rep, tc.want, tc.wantSent)
+		}
+	}
+}
+
+func TestHTTP_ErrorCodes(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		status   int
+		body     string
+		code     domain.RelayErrorCode
+		sentinel error
+	}{
+		{
+			name:     "structured 404",
+			status:   http.StatusNotFound,
+			body:     `{"error":{"code":"user_not_found","message":"user not registered","details":{"user":"bob"}}}`,
+			code:     domain.RelayCodeUserNotFound,
+			sentinel: domain.ErrNotFound,
+		},
+		{
+			name:     "structured 403",
+			status:   http.StatusForbidden,
+			body:     `{"error":{"code":"account_suspended","message":"account suspended"}}`,
+			code:     domain.RelayCodeSuspended,
+			sentinel: domain.ErrSuspended,
+		},
+		{
+			name:     "structured 409",
+			status:   http.StatusConflict,
+			body:     `{"error":{"code":"mailbox_full","message":"mailbox full"}}`,
+			code:     domain.RelayCodeMailboxFull,
+			sentinel: domain.ErrConflict,
+		},
+		{
+			name:   "unknown code",
+			status: http.StatusBadRequest,
+			body:   `{"error":{"code":"from_the_future","message":"something new"}}`,
+			code:   "from_the_future",
+		},
+		{name: "legacy 404", status: http.StatusNotFound, body: "404 page not found\n", sentinel: domain.ErrNotFound},
+		{name: "legacy error string", status: http.StatusConflict, body: `{"error":"mailbox in use"}`, sentinel: domain.ErrConflict},
+		{name: "legacy 403", status: http.StatusForbidden, body: `{"error":"account suspended"}`, sentinel: domain.ErrSuspended},
+	} {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.status)
+			w.Write([]byte(tc.body))
+		}))
+		_, err := relay.NewHTTP(s.URL, s.Client()).FetchPrekeyBundle(context.Background(), "bob", domain.RequestAuth{})
+		s.Close()
+		if err == nil {
+			t.Fatalf("%s: FetchPrekeyBundle succeeded", tc.name)
+		}
+		if got := domain.RelayCode(err); got != tc.code {
+			t.Errorf("%s: code %q, want %q (%v)", tc.name, got, tc.code, err)
+		}
+		if tc.sentinel != nil && !errors.Is(err, tc.sentinel) {
+			t.Errorf("%s: %v does not match %v", tc.name, err, tc.sentinel)
+		}
+		var re *domain.RelayError
+		if errors.As(err, &re) && re.Status != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, re.Status, tc.status)
+		}
+	}
+}
+
+func TestCheckoutPrekeyBundle_NoFallback(t *testing.T) {
+	// A relay without checkouts answers them with a bare 404. The client
+	// does not fall back to GET /prekey/{username}, which would let a relay
+	// hand every initiator the same one-time prekey.
+	fetched := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /prekey/{username}", func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		w.Write([]byte(`{"username":"bob","one_time":[{"id":"opk-1700000000-0"}]}`))
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	c := relay.NewHTTP(s.URL, s.Client())
+	if _, err := c.CheckoutPrekeyBundle(context.Background(), "bob"); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("CheckoutPrekeyBundle = %v; want ErrNotFound", err)
+	}
+	if fetched {
+		t.Fatal("CheckoutPrekeyBundle fell back to GET /prekey/bob")
+	}
+}
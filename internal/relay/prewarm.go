@@ -0,0 +1,36 @@
+package relay
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// Prewarm opens a connection to the relay at base in the background, DNS
+// lookup and TLS handshake included, by asking GET /healthz, so the request
+// a command makes next takes it from client's pool instead of dialing. The
+// answer is read and discarded so the connection stays open; failures are
+// left for that next request to report. The returned channel is closed once
+// the request is done.
+//
+// If client is nil, http.DefaultClient is used.
+func Prewarm(ctx context.Context, client *http.Client, base string) <-chan struct{} {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, normaliseBase(base)+"/healthz", nil)
+		if err != nil {
+			return
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<10))
+	}()
+	return done
+}
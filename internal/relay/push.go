@@ -0,0 +1,128 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/websocket"
+)
+
+const (
+	// pushIdle is how long a push connection may stay silent before it is
+	// taken for dead; the relay pings every 30 seconds.
+	pushIdle = 75 * time.Second
+	// pushBacklog is how many pushed envelopes wait for the reader.
+	pushBacklog = 16
+)
+
+// Subscribe opens GET /ws/{user}, a WebSocket on which the relay pushes each
+// envelope queued for username from then on. Envelopes queued before are
+// fetched as usual, after subscribing so none fall between the two. auth
+// signs the upgrade, as for FetchMessages; the relay refuses it unsigned.
+//
+// The connection goes over HTTP/1.1, through a copy of the client's
+// transport (the TCP one of an HTTP3Transport) with no overall timeout. A client whose transport is not an
+// *http.Transport (such as a VCR Recorder or Replayer), or a relay without the
+// endpoint, fails with an error wrapping domain.ErrPushUnsupported.
+func (c *HTTP) Subscribe(ctx context.Context, username string, auth domain.RequestAuth) (<-chan domain.Envelope, error) {
+	client, err := c.pushClient()
+	if err != nil {
+		return nil, err
+	}
+	path := fmt.Sprintf("/ws/%s", url.PathEscape(username))
+	fullURL, err := url.JoinPath(c.Base, path)
+	if err != nil {
+		fullURL = c.Base + path
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	setTraceParent(req)
+	if len(auth.Sig) > 0 {
+		setAuth(req, auth)
+	}
+
+	conn, err := websocket.Dial(client, req)
+	var he *websocket.HandshakeError
+	switch {
+	case errors.As(err, &he) && isUnavailable(he.Status):
+		return nil, fmt.Errorf("relay GET %s: %w: %w", fullURL, err, errUnavailable)
+	case errors.As(err, &he):
+		return nil, fmt.Errorf("relay GET %s: %w: %w", fullURL, err, domain.ErrPushUnsupported)
+	case err != nil:
+		return nil, err
+	}
+
+	out := make(chan domain.Envelope, pushBacklog)
+	done := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	go watchIdle(conn, done)
+	go func() {
+		defer close(out)
+		defer close(done)
+		defer stop()
+		defer conn.Close()
+		for {
+			msg, _, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var env domain.Envelope
+			if err := json.Unmarshal(msg, &env); err != nil {
+				return
+			}
+			select {
+			case out <- env:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// watchIdle closes conn once nothing, not even a ping, has arrived for
+// pushIdle, until done is closed.
+func watchIdle(conn *websocket.Conn, done <-chan struct{}) {
+	t := time.NewTicker(pushIdle / 5)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			if conn.Idle() > pushIdle {
+				conn.CloseWith(websocket.CloseGoingAway, "relay silent")
+				return
+			}
+		}
+	}
+}
+
+// pushClient returns a client like c's for push connections: HTTP/1.1 only,
+// since an HTTP/2 connection cannot be upgraded, and without a timeout.
+func (c *HTTP) pushClient() (*http.Client, error) {
+	var tr *http.Transport
+	switch t := c.client.Transport.(type) {
+	case nil:
+		tr = http.DefaultTransport.(*http.Transport)
+	case *http.Transport:
+		tr = t
+	case *HTTP3Transport:
+		tr = t.tcp
+	default:
+		return nil, fmt.Errorf("%w: transport %T", domain.ErrPushUnsupported, t)
+	}
+	tr = tr.Clone()
+	tr.Protocols = new(http.Protocols)
+	tr.Protocols.SetHTTP1(true)
+	tr.ForceAttemptHTTP2 = false
+	return &http.Client{Transport: tr, Jar: c.client.Jar, CheckRedirect: c.client.CheckRedirect}, nil
+}
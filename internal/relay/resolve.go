@@ -0,0 +1,200 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/address"
+)
+
+// Discovery settings.
+const (
+	resolveTimeout  = 5 * time.Second // per well-known request or SRV lookup
+	resolveTTL      = 24 * time.Hour  // how long a discovered relay is trusted without asking again
+	maxWellKnownLen = 4 << 10         // largest well-known document read
+)
+
+// How a relay was discovered, as recorded in domain.ResolvedRelay.Via.
+const (
+	viaWellKnown = "well-known"
+	viaSRV       = "srv"
+)
+
+// ErrNoRelayFound is wrapped by ResolveRelay when a host publishes neither a
+// well-known document nor an SRV record.
+var ErrNoRelayFound = errors.New("no relay published")
+
+// Resolver discovers the relay serving an address host (see package address):
+// first from the host's well-known document, then from its SRV record.
+//
+// Results are kept for the life of the Resolver and in the cache store for
+// resolveTTL. When discovery fails, an expired cache entry is still used, so
+// a host whose web server is briefly down stays reachable.
+type Resolver struct {
+	client    *http.Client
+	cache     domain.RelayCacheStore
+	lookupSRV func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	now       func() time.Time
+
+	mu   sync.Mutex
+	seen map[string]string
+}
+
+// NewResolver returns a Resolver that fetches well-known documents with
+// client and caches its results in cache.
+//
+// If client is nil, http.DefaultClient is used.
+func NewResolver(client *http.Client, cache domain.RelayCacheStore) *Resolver {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Resolver{
+		client:    client,
+		cache:     cache,
+		lookupSRV: net.DefaultResolver.LookupSRV,
+		now:       time.Now,
+		seen:      make(map[string]string),
+	}
+}
+
+// ResolveRelay returns the base URL of host's relay.
+func (r *Resolver) ResolveRelay(ctx context.Context, host string) (string, error) {
+	host = strings.ToLower(host)
+
+	r.mu.Lock()
+	base, ok := r.seen[host]
+	r.mu.Unlock()
+	if ok {
+		return base, nil
+	}
+
+	cached, cachedOK, err := r.cache.LoadResolvedRelay(host)
+	if err != nil {
+		return "", err
+	}
+	if cachedOK && r.now().Before(time.Unix(cached.ResolvedUTC, 0).Add(resolveTTL)) {
+		r.remember(host, cached.Relay)
+		return cached.Relay, nil
+	}
+
+	base, via, err := r.discover(ctx, host)
+	if err != nil {
+		if cachedOK {
+			r.remember(host, cached.Relay)
+			return cached.Relay, nil
+		}
+		return "", err
+	}
+	r.remember(host, base)
+	// The cache only saves lookups; a failed write is not worth failing for.
+	_ = r.cache.SaveResolvedRelay(domain.ResolvedRelay{
+		Host:        host,
+		Relay:       base,
+		Via:         via,
+		ResolvedUTC: r.now().Unix(),
+	})
+	return base, nil
+}
+
+// remember keeps base as host's relay for the life of r.
+func (r *Resolver) remember(host, base string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seen[host] = base
+}
+
+// discover asks host's well-known endpoint, then its SRV record, and reports
+// which of them answered.
+func (r *Resolver) discover(ctx context.Context, host string) (string, string, error) {
+	base, wkErr := r.wellKnown(ctx, host)
+	if wkErr == nil {
+		return base, viaWellKnown, nil
+	}
+	base, srvErr := r.srv(ctx, host)
+	if srvErr == nil {
+		return base, viaSRV, nil
+	}
+	return "", "", fmt.Errorf("%w for %s: %w", ErrNoRelayFound, host, errors.Join(wkErr, srvErr))
+}
+
+// wellKnown fetches host's well-known document. Loopback hosts are asked over
+// plain HTTP.
+func (r *Resolver) wellKnown(ctx context.Context, host string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	scheme := "https"
+	if (address.Address{Host: host}).Loopback() {
+		scheme = "http"
+	}
+	u := url.URL{Scheme: scheme, Host: host, Path: address.WellKnownPath}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if !is2xx(resp.StatusCode) {
+		return "", fmt.Errorf("GET %s: %s", u.String(), resp.Status)
+	}
+
+	var doc struct {
+		Relay string `json:"relay"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxWellKnownLen)).Decode(&doc); err != nil {
+		return "", fmt.Errorf("GET %s: %w", u.String(), err)
+	}
+	return checkBase(doc.Relay)
+}
+
+// srv looks up the _ciphera._tcp SRV record of host's name and returns the
+// first target in the order the resolver sorted them.
+func (r *Resolver) srv(ctx context.Context, host string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	name := (address.Address{Host: host}).Hostname()
+	_, records, err := r.lookupSRV(ctx, address.SRVService, address.SRVProto, name)
+	if err != nil {
+		return "", err
+	}
+	for _, rec := range records {
+		target := strings.TrimSuffix(rec.Target, ".")
+		if target == "" || rec.Port == 0 {
+			continue // "." means the service is not offered
+		}
+		return "https://" + net.JoinHostPort(target, strconv.Itoa(int(rec.Port))), nil
+	}
+	return "", fmt.Errorf("SRV %s: no usable target", name)
+}
+
+// checkBase accepts an absolute http(s) URL and returns it without trailing
+// slashes.
+func checkBase(base string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("relay URL %q: %w", base, err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return "", fmt.Errorf("relay URL %q is not an absolute http(s) URL", base)
+	}
+	return normaliseBase(base), nil
+}
+
+// Compile-time assertion that Resolver implements domain.RelayResolver.
+var _ domain.RelayResolver = (*Resolver)(nil)
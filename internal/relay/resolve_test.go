@@ -0,0 +1,69 @@
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"ciphera/internal/protocol/address"
+	"ciphera/internal/relay"
+	"ciphera/internal/store"
+)
+
+// newWellKnown starts a loopback host whose well-known document names base,
+// counting the requests it serves.
+func newWellKnown(t *testing.T, base string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != address.WellKnownPath {
+			http.NotFound(w, r)
+			return
+		}
+		hits.Add(1)
+		w.Write([]byte(`{"relay": "` + base + `"}`))
+	}))
+	t.Cleanup(s.Close)
+	return s, &hits
+}
+
+func TestResolver_WellKnownAndCache(t *testing.T) {
+	s, hits := newWellKnown(t, "https://relay.example.org/")
+	host := strings.TrimPrefix(s.URL, "http://")
+	cache := store.NewRelayCacheFileStore(t.TempDir())
+
+	r := relay.NewResolver(nil, cache)
+	for range 2 {
+		got, err := r.ResolveRelay(context.Background(), host)
+		if err != nil {
+			t.Fatalf("ResolveRelay: %v", err)
+		}
+		if got != "https://relay.example.org" {
+			t.Fatalf("ResolveRelay = %q, want https://relay.example.org", got)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Fatalf("well-known fetched %d times, want 1", n)
+	}
+
+	// A later command answers from the cache without asking the host.
+	s.Close()
+	got, err := relay.NewResolver(nil, cache).ResolveRelay(context.Background(), host)
+	if err != nil || got != "https://relay.example.org" {
+		t.Fatalf("cached ResolveRelay = %q, %v", got, err)
+	}
+}
+
+func TestResolver_RejectsBadDocument(t *testing.T) {
+	s, _ := newWellKnown(t, "ftp://relay.example.org")
+	host := strings.TrimPrefix(s.URL, "http://")
+
+	_, err := relay.NewResolver(nil, store.NewRelayCacheFileStore(t.TempDir())).ResolveRelay(context.Background(), host)
+	if !errors.Is(err, relay.ErrNoRelayFound) {
+		t.Fatalf("ResolveRelay = %v, want ErrNoRelayFound", err)
+	}
+}
@@ -0,0 +1,38 @@
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// traceParentHeader is the W3C Trace Context header relays read.
+const traceParentHeader = "traceparent"
+
+type traceKey struct{}
+
+// WithTrace returns a context that carries a new trace and the trace's ID in
+// hex. Requests made with it send a W3C traceparent header naming the trace,
+// each with its own parent span ID, so a relay exporting traces shows the
+// requests of one command together.
+//
+// Without WithTrace no traceparent is sent: the header would let the relay
+// link requests that it otherwise could not.
+func WithTrace(ctx context.Context) (context.Context, string) {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return context.WithValue(ctx, traceKey{}, id), hex.EncodeToString(id[:])
+}
+
+// setTraceParent adds a traceparent header for the trace in req's context,
+// if there is one.
+func setTraceParent(req *http.Request) {
+	id, ok := req.Context().Value(traceKey{}).([16]byte)
+	if !ok {
+		return
+	}
+	var span [8]byte
+	_, _ = rand.Read(span[:])
+	req.Header.Set(traceParentHeader, "00-"+hex.EncodeToString(id[:])+"-"+hex.EncodeToString(span[:])+"-01")
+}
@@ -0,0 +1,57 @@
+package relay_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/relay"
+)
+
+var traceParent = regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`)
+
+// headerServer records the traceparent header of every request it serves.
+func headerServer(t *testing.T, got *[]string) *relay.HTTP {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*got = append(*got, r.Header.Get("traceparent"))
+		w.Write([]byte("[]"))
+	}))
+	t.Cleanup(s.Close)
+	return relay.NewHTTP(s.URL, s.Client())
+}
+
+func TestTrace_PropagatesOneTrace(t *testing.T) {
+	var got []string
+	c := headerServer(t, &got)
+	ctx, id := relay.WithTrace(context.Background())
+
+	for range 2 {
+		if _, _, err := c.FetchMessages(ctx, "bob", 0, domain.RequestAuth{}); err != nil {
+			t.Fatalf("FetchMessages: %v", err)
+		}
+	}
+	for _, h := range got {
+		if !traceParent.MatchString(h) || !strings.Contains(h, "-"+id+"-") {
+			t.Fatalf("traceparent %q does not name trace %s", h, id)
+		}
+	}
+	if got[0] == got[1] {
+		t.Fatalf("requests share a parent span ID: %q", got[0])
+	}
+}
+
+func TestTrace_NoneWithoutTrace(t *testing.T) {
+	var got []string
+	c := headerServer(t, &got)
+	if _, _, err := c.FetchMessages(context.Background(), "bob", 0, domain.RequestAuth{}); err != nil {
+		t.Fatalf("FetchMessages: %v", err)
+	}
+	if got[0] != "" {
+		t.Fatalf("traceparent sent without a trace: %q", got[0])
+	}
+}
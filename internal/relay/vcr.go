@@ -0,0 +1,218 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// CassetteVersion is the version of the cassette file layout.
+const CassetteVersion = 1
+
+// ErrReplayMismatch is returned by a Replayer for a request that does not
+// match the next recorded exchange, or that arrives after the last one.
+var ErrReplayMismatch = errors.New("relay replay: request does not match the recording")
+
+// Cassette is a recorded sequence of relay exchanges, stored as JSON.
+type Cassette struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one request to the relay and its response.
+//
+// Path holds the path and query without the relay's base URL, so a cassette
+// replays against any base. Bodies that are valid JSON are kept as JSON to
+// keep golden files readable; anything else is kept as text.
+type Interaction struct {
+	Method       string          `json:"method"`
+	Path         string          `json:"path"`
+	Request      json.RawMessage `json:"request,omitempty"`
+	Status       int             `json:"status"`
+	Response     json.RawMessage `json:"response,omitempty"`
+	ResponseText string          `json:"response_text,omitempty"`
+}
+
+// LoadCassette reads a cassette written by Recorder.Save.
+func LoadCassette(path string) (Cassette, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Cassette{}, err
+	}
+	var c Cassette
+	if err := json.Unmarshal(b, &c); err != nil {
+		return Cassette{}, fmt.Errorf("cassette %s: %w", path, err)
+	}
+	if c.Version != CassetteVersion {
+		return Cassette{}, fmt.Errorf("cassette %s: version %d, want %d", path, c.Version, CassetteVersion)
+	}
+	return c, nil
+}
+
+// Recorder is an http.RoundTripper that forwards requests to the relay and
+// records every exchange. It is safe for concurrent use.
+type Recorder struct {
+	next http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder returns a Recorder that sends requests through next.
+//
+// If next is nil, http.DefaultTransport is used.
+func NewRecorder(next http.RoundTripper) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{next: next, cassette: Cassette{Version: CassetteVersion}}
+}
+
+// RoundTrip sends req and records it with its response. Transport errors are
+// returned without being recorded.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = b
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	in := Interaction{
+		Method:  req.Method,
+		Path:    req.URL.RequestURI(),
+		Request: asJSON(reqBody),
+		Status:  resp.StatusCode,
+	}
+	if raw := asJSON(respBody); raw != nil {
+		in.Response = raw
+	} else {
+		in.ResponseText = string(respBody)
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, in)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+// Cassette returns a copy of what has been recorded so far.
+func (r *Recorder) Cassette() Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := r.cassette
+	c.Interactions = append([]Interaction(nil), c.Interactions...)
+	return c
+}
+
+// Save writes the recording to path as JSON, one exchange per line so golden
+// files diff cleanly.
+func (r *Recorder) Save(path string) error {
+	c := r.Cassette()
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "{\"version\":%d,\"interactions\":[", c.Version)
+	for i, in := range c.Interactions {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+		buf.Write(b)
+	}
+	buf.WriteString("\n]}\n")
+	return os.WriteFile(path, buf.Bytes(), 0o600)
+}
+
+// Replayer is an http.RoundTripper that answers requests from a cassette
+// without contacting a relay. Requests must arrive in the recorded order
+// with the recorded method and path; request bodies are not compared, since
+// they hold fresh keys and ciphertexts on every run. It is safe for
+// concurrent use.
+type Replayer struct {
+	mu       sync.Mutex
+	cassette Cassette
+	next     int
+}
+
+// NewReplayer returns a Replayer that plays back c from the start.
+func NewReplayer(c Cassette) *Replayer {
+	return &Replayer{cassette: c}
+}
+
+// RoundTrip returns the next recorded response, or an error wrapping
+// ErrReplayMismatch if req is not the next recorded request.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	got := req.Method + " " + req.URL.RequestURI()
+	if r.next >= len(r.cassette.Interactions) {
+		return nil, fmt.Errorf("%w: %s after the last recorded exchange", ErrReplayMismatch, got)
+	}
+	in := r.cassette.Interactions[r.next]
+	if want := in.Method + " " + in.Path; got != want {
+		return nil, fmt.Errorf("%w: got %s, want %s (exchange %d)", ErrReplayMismatch, got, want, r.next+1)
+	}
+	r.next++
+
+	body := []byte(in.ResponseText)
+	header := http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+	if in.Response != nil {
+		body = in.Response
+		header.Set("Content-Type", "application/json")
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.Status, http.StatusText(in.Status)),
+		StatusCode:    in.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// Remaining returns how many recorded exchanges have not been replayed.
+func (r *Replayer) Remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.cassette.Interactions) - r.next
+}
+
+// asJSON returns b as raw JSON, or nil if b is empty or not valid JSON.
+func asJSON(b []byte) json.RawMessage {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || !json.Valid(b) {
+		return nil
+	}
+	return json.RawMessage(bytes.Clone(b))
+}
@@ -0,0 +1,169 @@
+package relay_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/relay"
+)
+
+// replayClient returns a relay client answering from the named golden
+// cassette in testdata, and the replayer behind it.
+func replayClient(t *testing.T, name string) (*relay.HTTP, *relay.Replayer) {
+	t.Helper()
+	c, err := relay.LoadCassette(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("load cassette: %v", err)
+	}
+	rp := relay.NewReplayer(c)
+	return relay.NewHTTP("http://relay.invalid", &http.Client{Transport: rp}), rp
+}
+
+func TestReplay_CheckoutPrekeyBundle(t *testing.T) {
+	client, rp := replayClient(t, "start_session.json")
+
+	b, err := client.CheckoutPrekeyBundle(context.Background(), "bob")
+	if err != nil {
+		t.Fatalf("CheckoutPrekeyBundle: %v", err)
+	}
+	if b.Username != "bob" || b.SPKID == "" || len(b.OneTime) != 1 {
+		t.Fatalf("unexpected bundle: username=%q spk=%q one-time=%d", b.Username, b.SPKID, len(b.OneTime))
+	}
+	if !slices.Contains(b.Capabilities, "attachments") {
+		t.Fatalf("capabilities = %v, want attachments", b.Capabilities)
+	}
+	if n := rp.Remaining(); n != 0 {
+		t.Fatalf("Remaining = %d, want 0", n)
+	}
+}
+
+func TestReplay_ReceiveFlow(t *testing.T) {
+	client, rp := replayClient(t, "recv.json")
+	ctx := context.Background()
+
+	envs, _, err := client.FetchMessages(ctx, "bob", 0, domain.RequestAuth{})
+	if err != nil {
+		t.Fatalf("FetchMessages: %v", err)
+	}
+	if len(envs) != 1 || envs[0].From != "alice" || envs[0].Prekey == nil {
+		t.Fatalf("unexpected envelopes: %+v", envs)
+	}
+	// The session confirmation bob sent back; its body is not compared.
+	if _, err := client.SendMessage(ctx, domain.Envelope{From: "bob", To: "alice"}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if err := client.AckMessages(ctx, "bob", []string{envs[0].ID}); err != nil {
+		t.Fatalf("AckMessages: %v", err)
+	}
+	if n := rp.Remaining(); n != 0 {
+		t.Fatalf("Remaining = %d, want 0", n)
+	}
+}
+
+func TestReplay_NotFound(t *testing.T) {
+	client, _ := replayClient(t, "not_found.json")
+
+	_, err := client.CheckoutPrekeyBundle(context.Background(), "nobody")
+	if !errors.Is(err, domain.ErrNotFound) || domain.RelayCode(err) != domain.RelayCodeUserNotFound {
+		t.Fatalf("err = %v, want ErrNotFound with code %s", err, domain.RelayCodeUserNotFound)
+	}
+}
+
+func TestReplay_Mismatch(t *testing.T) {
+	cases := []struct {
+		name string
+		call func(*relay.HTTP) error
+	}{
+		{"wrong path", func(c *relay.HTTP) error {
+			_, err := c.CheckoutPrekeyBundle(context.Background(), "carol")
+			return err
+		}},
+		{"wrong method", func(c *relay.HTTP) error {
+			return c.RegisterPrekeyBundle(context.Background(), domain.PrekeyBundle{}, domain.ChallengeAnswer{})
+		}},
+		{"past the end", func(c *relay.HTTP) error {
+			if _, err := c.CheckoutPrekeyBundle(context.Background(), "bob"); err != nil {
+				return err
+			}
+			_, err := c.CheckoutPrekeyBundle(context.Background(), "bob")
+			return err
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, _ := replayClient(t, "start_session.json")
+			if err := tc.call(client); !errors.Is(err, relay.ErrReplayMismatch) {
+				t.Fatalf("err = %v, want ErrReplayMismatch", err)
+			}
+		})
+	}
+}
+
+func TestRecorder_RecordThenReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/msg/bob":
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodGet && r.URL.Path == "/msg/bob":
+			_ = json.NewEncoder(w).Encode([]domain.Envelope{{ID: "7", From: "alice", To: "bob"}})
+		default:
+			http.Error(w, "storage error", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	rec := relay.NewRecorder(nil)
+	live := relay.NewHTTP(srv.URL, &http.Client{Transport: rec})
+	ctx := context.Background()
+	if _, err := live.SendMessage(ctx, domain.Envelope{From: "alice", To: "bob"}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if _, _, err := live.FetchMessages(ctx, "bob", 5, domain.RequestAuth{}); err != nil {
+		t.Fatalf("FetchMessages: %v", err)
+	}
+	if err := live.AckMessages(ctx, "bob", []string{"7"}); err == nil {
+		t.Fatal("AckMessages succeeded against a failing handler")
+	}
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	if err := rec.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	c, err := relay.LoadCassette(path)
+	if err != nil {
+		t.Fatalf("LoadCassette: %v", err)
+	}
+	if len(c.Interactions) != 3 {
+		t.Fatalf("recorded %d exchanges, want 3", len(c.Interactions))
+	}
+	if got := c.Interactions[1].Path; got != "/msg/bob?limit=5" {
+		t.Fatalf("path = %q, want the query kept and the base dropped", got)
+	}
+	if got := c.Interactions[2].ResponseText; got != "storage error\n" {
+		t.Fatalf("response text = %q", got)
+	}
+
+	// Replay against a different base: the same calls give the same answers.
+	rp := relay.NewReplayer(c)
+	replay := relay.NewHTTP("http://elsewhere.invalid", &http.Client{Transport: rp})
+	if _, err := replay.SendMessage(ctx, domain.Envelope{From: "alice", To: "bob"}); err != nil {
+		t.Fatalf("replayed SendMessage: %v", err)
+	}
+	envs, _, err := replay.FetchMessages(ctx, "bob", 5, domain.RequestAuth{})
+	if err != nil || len(envs) != 1 || envs[0].ID != "7" {
+		t.Fatalf("replayed FetchMessages = %+v, %v", envs, err)
+	}
+	if err := replay.AckMessages(ctx, "bob", []string{"7"}); err == nil {
+		t.Fatal("replayed AckMessages lost the recorded failure")
+	}
+	if n := rp.Remaining(); n != 0 {
+		t.Fatalf("Remaining = %d, want 0", n)
+	}
+}
@@ -0,0 +1,200 @@
+package relayserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// maxReasonLen caps the reason stored with a restriction.
+const maxReasonLen = 256
+
+// Restriction modes.
+const (
+	restrictSuspend   = "suspend"    // enqueues to or from the user are refused
+	restrictShadowBan = "shadow_ban" // enqueues to or from the user are accepted and dropped
+)
+
+// restriction limits what an account may do on this relay until it is lifted
+// or expires.
+type restriction struct {
+	Mode       string `json:"mode"`
+	Reason     string `json:"reason,omitempty"`
+	CreatedUTC int64  `json:"created_utc"`
+	ExpiresUTC int64  `json:"expires_utc,omitempty"` // zero: until lifted
+}
+
+// active reports whether r is still in force at now.
+func (r restriction) active(now time.Time) bool {
+	return r.ExpiresUTC == 0 || now.Unix() < r.ExpiresUTC
+}
+
+// restrictionView is a restriction as the admin API returns it.
+type restrictionView struct {
+	User string `json:"user"`
+	restriction
+}
+
+// withAdminAuth rejects requests that do not carry "Authorization: Bearer
+// <token>". The token is compared in constant time.
+func (l logs) withAdminAuth(token string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				l.audit(r, "denied", r.PathValue("user"))
+				writeErr(w, http.StatusUnauthorized, domain.RelayCodeBadAdminToken, "admin token required")
+				return
+			}
+			h(w, r)
+		}
+	}
+}
+
+// handleRestrict suspends or shadow-bans a user (PUT /admin/users/{user}/restriction).
+//
+// The body is { "mode": "suspend"|"shadow_ban", "reason": "...", "duration": "24h" };
+// without a duration the restriction lasts until it is lifted. A new
+// restriction replaces the user's current one.
+func (s *state) handleRestrict(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+
+	user := r.PathValue("user")
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var req struct {
+		Mode     string `json:"mode"`
+		Reason   string `json:"reason"`
+		Duration string `json:"duration"`
+	}
+	if err := dec.Decode(&req); err != nil {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "bad request")
+		return
+	}
+	if user == "" {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeMissingField, "username required", "field", "username")
+		return
+	}
+	if req.Mode != restrictSuspend && req.Mode != restrictShadowBan {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeInvalidParameter, "mode must be suspend or shadow_ban", "param", "mode")
+		return
+	}
+	if len(req.Reason) > maxReasonLen {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeInvalidParameter, "reason too long", "param", "reason", "limit", strconv.Itoa(maxReasonLen))
+		return
+	}
+	now := time.Now()
+	res := restriction{Mode: req.Mode, Reason: req.Reason, CreatedUTC: now.Unix()}
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			writeErr(w, http.StatusBadRequest, domain.RelayCodeInvalidParameter, "bad duration", "param", "duration")
+			return
+		}
+		res.ExpiresUTC = now.Add(d).Unix()
+	}
+
+	s.mu.Lock()
+	_, existed := s.restrictions[user]
+	if err := s.store.restricted(user, res, existed); err != nil {
+		s.mu.Unlock()
+		writeErr(w, http.StatusInternalServerError, domain.RelayCodeStorage, "storage error")
+		s.logStorageErr(r, "restrict_store", err)
+		return
+	}
+	s.restrictions[user] = res
+	s.compactIfNeeded()
+	s.mu.Unlock()
+
+	s.audit(r, req.Mode, user,
+		"reason", res.Reason,
+		"expires_utc", res.ExpiresUTC,
+		"replaced", existed,
+	)
+	writeJSON(w, restrictionView{User: user, restriction: res})
+}
+
+// handleLift removes a user's restriction (DELETE /admin/users/{user}/restriction).
+func (s *state) handleLift(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+
+	s.mu.Lock()
+	if _, ok := s.restrictions[user]; !ok {
+		s.mu.Unlock()
+		writeErr(w, http.StatusNotFound, domain.RelayCodeNotFound, "no restriction", "resource", "restriction")
+		return
+	}
+	if err := s.store.unrestricted(user); err != nil {
+		s.mu.Unlock()
+		writeErr(w, http.StatusInternalServerError, domain.RelayCodeStorage, "storage error")
+		s.logStorageErr(r, "lift_store", err)
+		return
+	}
+	delete(s.restrictions, user)
+	s.compactIfNeeded()
+	s.mu.Unlock()
+
+	s.audit(r, "lift", user)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListRestrictions returns every restriction still in force, ordered by
+// user (GET /admin/restrictions).
+func (s *state) handleListRestrictions(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	out := []restrictionView{}
+
+	s.mu.RLock()
+	for _, user := range slices.Sorted(maps.Keys(s.restrictions)) {
+		if res := s.restrictions[user]; res.active(now) {
+			out = append(out, restrictionView{User: user, restriction: res})
+		}
+	}
+	s.mu.RUnlock()
+
+	s.audit(r, "list", "", "count", len(out))
+	writeJSON(w, out)
+}
+
+// restrictionMode returns the restriction to apply to an envelope from one
+// user to another, or "" if neither is restricted. A shadow-banned sender
+// wins over everything else, so a banned sender never learns whether a
+// recipient is suspended. The caller holds s.mu.
+func (s *state) restrictionMode(from, to string, now time.Time) string {
+	fromRes, fromOK := s.restrictions[from]
+	toRes, toOK := s.restrictions[to]
+	fromOK = fromOK && fromRes.active(now)
+	toOK = toOK && toRes.active(now)
+
+	switch {
+	case fromOK && fromRes.Mode == restrictShadowBan:
+		return restrictShadowBan
+	case fromOK && fromRes.Mode == restrictSuspend, toOK && toRes.Mode == restrictSuspend:
+		return restrictSuspend
+	case toOK:
+		return toRes.Mode
+	}
+	return ""
+}
+
+// audit records an admin action. Audit lines are written whether or not
+// access logging is on.
+func (l logs) audit(r *http.Request, action, user string, attrs ...any) {
+	args := append([]any{
+		"action", action,
+		"user", user,
+		"remote", clientIP(r),
+		"reqid", requestIDFromCtx(r.Context()),
+	}, attrs...)
+	l.log.Info("admin_audit", args...)
+}
@@ -0,0 +1,115 @@
+package relayserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/relayauth"
+)
+
+// maxBackupBytes caps the sealed data of a stored backup.
+const maxBackupBytes = 256 << 10
+
+// handlePutBackup stores a user's sealed backup (PUT /backup/{user}).
+//
+// The user must have published a bundle, and the backup must be signed with
+// its signing key (see package relayauth). A backup older than the stored
+// one is refused with 409, so an old upload cannot be replayed over a newer
+// one; the same backup may be stored again.
+func (s *state) handlePutBackup(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	body := &countingReader{ReadCloser: http.MaxBytesReader(w, r.Body, maxRequestBody)}
+	r.Body = body
+
+	user := r.PathValue("user")
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var b domain.RelayBackup
+	if err := dec.Decode(&b); err != nil {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "bad request")
+		return
+	}
+	if len(b.Data) == 0 {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeMissingField, "backup data required", "field", "data")
+		return
+	}
+	if len(b.Data) > maxBackupBytes {
+		writeErr(w, http.StatusRequestEntityTooLarge, domain.RelayCodeTooLarge, "backup too large", "field", "data", "limit", strconv.Itoa(maxBackupBytes))
+		return
+	}
+	now := time.Now()
+	if time.Unix(b.UpdatedUTC, 0).After(now.Add(maxFutureSkew)) {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeFutureTimestamp, "timestamp in future", "max_skew", maxFutureSkew.String())
+		return
+	}
+
+	s.mu.Lock()
+	bundle, registered := s.bundles[user]
+	if !registered {
+		s.mu.Unlock()
+		writeErr(w, http.StatusNotFound, domain.RelayCodeUserNotFound, "user not registered", "user", user)
+		return
+	}
+	if !relayauth.VerifyBackup(bundle.SignKey, user, b) {
+		s.mu.Unlock()
+		writeErr(w, http.StatusUnauthorized, domain.RelayCodeBadSignature, "bad signature")
+		s.accessLog.Info("backup_refused", "user", user, "reason", "signature", "reqid", requestIDFromCtx(r.Context()))
+		return
+	}
+	if s.restrictionMode(user, user, now) == restrictSuspend {
+		s.mu.Unlock()
+		writeErr(w, http.StatusForbidden, domain.RelayCodeSuspended, "account suspended")
+		s.accessLog.Info("backup_refused", "user", user, "reason", "suspended", "reqid", requestIDFromCtx(r.Context()))
+		return
+	}
+	old, existed := s.backups[user]
+	if b.UpdatedUTC < old.UpdatedUTC || (b.UpdatedUTC == old.UpdatedUTC && existed && !bytes.Equal(b.Data, old.Data)) {
+		s.mu.Unlock()
+		writeErr(w, http.StatusConflict, domain.RelayCodeNewerBackup, "a newer backup is stored")
+		return
+	}
+	if err := s.store.backedUp(user, b, existed); err != nil {
+		s.mu.Unlock()
+		writeErr(w, http.StatusInternalServerError, domain.RelayCodeStorage, "storage error")
+		s.logStorageErr(r, "backup_store", err)
+		return
+	}
+	s.backups[user] = b
+	s.compactIfNeeded()
+	s.mu.Unlock()
+	s.usage.countIn(user, body.n, 0, now)
+
+	s.accessLog.Info("backup_put",
+		"user", user,
+		"bytes", len(b.Data),
+		"replaced", existed,
+		"reqid", requestIDFromCtx(r.Context()),
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetBackup returns a user's sealed backup (GET /backup/{user}). It is
+// not authenticated: the new machine restoring it holds no keys yet, and the
+// data is sealed under the user's passphrase.
+func (s *state) handleGetBackup(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+
+	s.mu.RLock()
+	b, ok := s.backups[user]
+	s.mu.RUnlock()
+	if !ok {
+		writeErr(w, http.StatusNotFound, domain.RelayCodeNotFound, "no backup", "resource", "backup")
+		return
+	}
+
+	s.accessLog.Info("backup_fetch", "user", user, "bytes", len(b.Data), "reqid", requestIDFromCtx(r.Context()))
+	cw := &countingWriter{ResponseWriter: w}
+	writeJSON(cw, b)
+	s.usage.countOut(user, cw.n, 0, time.Now())
+}
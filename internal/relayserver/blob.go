@@ -0,0 +1,333 @@
+package relayserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// Blob (attachment) limits and defaults.
+const (
+	blobPartSize      = 5 << 20   // 5 MiB per part (S3 minimum for all but the last)
+	DefaultBlobMax    = 100 << 20 // 100 MiB per blob
+	DefaultBlobTTL    = 7 * 24 * time.Hour
+	blobURLTTL        = 15 * time.Minute // lifetime of pre-signed URLs
+	blobGCInterval    = time.Minute
+	maxBlobPartNumber = 10000 // S3 multipart upper bound
+)
+
+// Blob backend names for BlobOptions.Backend.
+const (
+	BlobBackendNone = "none"
+	BlobBackendFS   = "fs"
+	BlobBackendS3   = "s3"
+)
+
+var (
+	errBlobNotFound   = errors.New("blob not found")
+	errBlobIncomplete = errors.New("blob upload incomplete")
+)
+
+// blobMeta tracks an attachment upload. Clients only ever see ciphertext sizes.
+type blobMeta struct {
+	ID         string    `json:"id"`
+	Size       int64     `json:"size"`
+	PartSize   int64     `json:"part_size"`
+	Parts      int       `json:"parts"`
+	Complete   bool      `json:"complete"`
+	ExpiresUTC time.Time `json:"expires"`
+	UploadID   string    `json:"-"` // backend-specific multipart handle
+}
+
+// partSize returns the expected byte length of part n (1-based).
+func (m *blobMeta) partSize(n int) int64 {
+	if n < m.Parts {
+		return m.PartSize
+	}
+	return m.Size - int64(m.Parts-1)*m.PartSize
+}
+
+// blobBackend stores attachment bytes. Clients move data directly to and from the
+// backend using pre-signed URLs; the relay only brokers metadata.
+type blobBackend interface {
+	// Begin prepares storage for a new multipart upload and may set meta.UploadID.
+	Begin(ctx context.Context, meta *blobMeta) error
+	// PartURL returns a pre-signed URL that accepts a PUT of exactly meta.partSize(n) bytes.
+	PartURL(meta *blobMeta, n int, expires time.Time) (string, error)
+	// ReceivedParts lists the part numbers already stored, for resuming uploads.
+	ReceivedParts(ctx context.Context, meta *blobMeta) ([]int, error)
+	// Complete assembles the uploaded parts into the final object.
+	Complete(ctx context.Context, meta *blobMeta) error
+	// DownloadURL returns a pre-signed URL for a GET of the assembled object.
+	DownloadURL(meta *blobMeta, expires time.Time) (string, error)
+	// Delete removes the object and any partial upload.
+	Delete(ctx context.Context, meta *blobMeta) error
+}
+
+// blobService brokers attachment uploads and downloads and expires old blobs.
+type blobService struct {
+	mu      sync.Mutex
+	backend blobBackend
+	blobs   map[string]*blobMeta
+	maxSize int64
+	ttl     time.Duration
+
+	logs
+}
+
+// newBlobService returns a blobService over backend.
+func newBlobService(backend blobBackend, maxSize int64, ttl time.Duration, l logs) *blobService {
+	return &blobService{
+		backend: backend,
+		blobs:   make(map[string]*blobMeta),
+		maxSize: maxSize,
+		ttl:     ttl,
+		logs:    l,
+	}
+}
+
+// lookup returns a copy of the live blob with id.
+func (b *blobService) lookup(id string) (*blobMeta, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.blobs[id]
+	if !ok || time.Now().After(m.ExpiresUTC) {
+		return nil, false
+	}
+	cp := *m
+	return &cp, true
+}
+
+// blobPart is a pre-signed upload target for a single part.
+type blobPart struct {
+	N    int    `json:"n"`
+	Size int64  `json:"size"`
+	URL  string `json:"url"`
+}
+
+// blobStatus is returned when creating or resuming an upload.
+type blobStatus struct {
+	blobMeta
+	Received []int      `json:"received"`
+	Upload   []blobPart `json:"upload,omitempty"`
+}
+
+// status builds a blobStatus with fresh upload URLs for every missing part.
+func (b *blobService) status(ctx context.Context, m *blobMeta) (blobStatus, error) {
+	st := blobStatus{blobMeta: *m, Received: []int{}}
+	if m.Complete {
+		return st, nil
+	}
+	received, err := b.backend.ReceivedParts(ctx, m)
+	if err != nil {
+		return blobStatus{}, err
+	}
+	sort.Ints(received)
+	st.Received = append(st.Received, received...)
+
+	have := make(map[int]bool, len(received))
+	for _, n := range received {
+		have[n] = true
+	}
+	exp := time.Now().Add(blobURLTTL)
+	for n := 1; n <= m.Parts; n++ {
+		if have[n] {
+			continue
+		}
+		u, err := b.backend.PartURL(m, n, exp)
+		if err != nil {
+			return blobStatus{}, err
+		}
+		st.Upload = append(st.Upload, blobPart{N: n, Size: m.partSize(n), URL: u})
+	}
+	return st, nil
+}
+
+// handleCreate starts a new upload (POST /blob { "size": N }).
+func (b *blobService) handleCreate(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var req struct {
+		Size int64 `json:"size"`
+	}
+	if err := dec.Decode(&req); err != nil || req.Size <= 0 {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "bad request")
+		return
+	}
+	if req.Size > b.maxSize {
+		writeErr(w, http.StatusRequestEntityTooLarge, domain.RelayCodeTooLarge, "blob too large", "field", "size", "limit", strconv.FormatInt(b.maxSize, 10))
+		return
+	}
+	parts := int((req.Size + blobPartSize - 1) / blobPartSize)
+	if parts > maxBlobPartNumber {
+		writeErr(w, http.StatusRequestEntityTooLarge, domain.RelayCodeTooLarge, "blob too large", "field", "size", "limit", strconv.FormatInt(b.maxSize, 10))
+		return
+	}
+
+	m := &blobMeta{
+		ID:         genBlobID(),
+		Size:       req.Size,
+		PartSize:   blobPartSize,
+		Parts:      parts,
+		ExpiresUTC: time.Now().Add(b.ttl).UTC(),
+	}
+	if err := b.backend.Begin(r.Context(), m); err != nil {
+		writeErr(w, http.StatusBadGateway, domain.RelayCodeUnavailable, "blob backend unavailable", "service", "blobs")
+		b.logBlobErr(r, "blob_begin", m.ID, err)
+		return
+	}
+	b.mu.Lock()
+	cp := *m
+	b.blobs[m.ID] = &cp
+	b.mu.Unlock()
+
+	st, err := b.status(r.Context(), m)
+	if err != nil {
+		writeErr(w, http.StatusBadGateway, domain.RelayCodeUnavailable, "blob backend unavailable", "service", "blobs")
+		b.logBlobErr(r, "blob_status", m.ID, err)
+		return
+	}
+	b.accessLog.Info("blob_create",
+		"id", m.ID,
+		"size", m.Size,
+		"parts", m.Parts,
+		"reqid", requestIDFromCtx(r.Context()),
+	)
+	writeJSON(w, st)
+}
+
+// handleStatus reports received parts and re-issues upload URLs (GET /blob/{id}).
+func (b *blobService) handleStatus(w http.ResponseWriter, r *http.Request) {
+	m, ok := b.lookup(r.PathValue("id"))
+	if !ok {
+		writeErr(w, http.StatusNotFound, domain.RelayCodeNotFound, errBlobNotFound.Error(), "resource", "blob")
+		return
+	}
+	st, err := b.status(r.Context(), m)
+	if err != nil {
+		writeErr(w, http.StatusBadGateway, domain.RelayCodeUnavailable, "blob backend unavailable", "service", "blobs")
+		b.logBlobErr(r, "blob_status", m.ID, err)
+		return
+	}
+	writeJSON(w, st)
+}
+
+// handleComplete assembles an upload once every part is present (POST /blob/{id}/complete).
+func (b *blobService) handleComplete(w http.ResponseWriter, r *http.Request) {
+	m, ok := b.lookup(r.PathValue("id"))
+	if !ok {
+		writeErr(w, http.StatusNotFound, domain.RelayCodeNotFound, errBlobNotFound.Error(), "resource", "blob")
+		return
+	}
+	if m.Complete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	received, err := b.backend.ReceivedParts(r.Context(), m)
+	if err != nil {
+		writeErr(w, http.StatusBadGateway, domain.RelayCodeUnavailable, "blob backend unavailable", "service", "blobs")
+		b.logBlobErr(r, "blob_status", m.ID, err)
+		return
+	}
+	if len(received) != m.Parts {
+		writeErr(w, http.StatusConflict, domain.RelayCodeBlobIncomplete, errBlobIncomplete.Error())
+		return
+	}
+	if err := b.backend.Complete(r.Context(), m); err != nil {
+		writeErr(w, http.StatusBadGateway, domain.RelayCodeUnavailable, "blob backend unavailable", "service", "blobs")
+		b.logBlobErr(r, "blob_complete", m.ID, err)
+		return
+	}
+	b.mu.Lock()
+	if cur, ok := b.blobs[m.ID]; ok {
+		cur.Complete = true
+	}
+	b.mu.Unlock()
+
+	b.accessLog.Info("blob_complete", "id", m.ID, "size", m.Size, "reqid", requestIDFromCtx(r.Context()))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDownload returns a pre-signed download URL (GET /blob/{id}/download).
+func (b *blobService) handleDownload(w http.ResponseWriter, r *http.Request) {
+	m, ok := b.lookup(r.PathValue("id"))
+	if !ok {
+		writeErr(w, http.StatusNotFound, domain.RelayCodeNotFound, errBlobNotFound.Error(), "resource", "blob")
+		return
+	}
+	if !m.Complete {
+		writeErr(w, http.StatusConflict, domain.RelayCodeBlobIncomplete, errBlobIncomplete.Error())
+		return
+	}
+	exp := time.Now().Add(blobURLTTL)
+	u, err := b.backend.DownloadURL(m, exp)
+	if err != nil {
+		writeErr(w, http.StatusBadGateway, domain.RelayCodeUnavailable, "blob backend unavailable", "service", "blobs")
+		b.logBlobErr(r, "blob_download", m.ID, err)
+		return
+	}
+	writeJSON(w, map[string]any{"url": u, "size": m.Size, "expires": exp.UTC()})
+}
+
+// runGC deletes expired blobs until ctx is cancelled.
+func (b *blobService) runGC(ctx context.Context) {
+	t := time.NewTicker(blobGCInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			b.collect(ctx, now)
+		}
+	}
+}
+
+// collect removes every blob that expired before now.
+func (b *blobService) collect(ctx context.Context, now time.Time) {
+	b.mu.Lock()
+	var expired []*blobMeta
+	for id, m := range b.blobs {
+		if now.After(m.ExpiresUTC) {
+			expired = append(expired, m)
+			delete(b.blobs, id)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, m := range expired {
+		if err := b.backend.Delete(ctx, m); err != nil {
+			b.accessLog.Error("blob_gc", "id", m.ID, "error", err)
+		}
+	}
+	if len(expired) > 0 {
+		b.accessLog.Info("blob_gc", "expired", len(expired))
+	}
+}
+
+// genBlobID creates a random 128-bit hex identifier.
+func genBlobID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// logBlobErr records a backend failure without exposing details to the client.
+func (l logs) logBlobErr(r *http.Request, op, id string, err error) {
+	l.accessLog.Error(op, "id", id, "error", err, "reqid", requestIDFromCtx(r.Context()))
+}
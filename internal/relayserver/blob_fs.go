@@ -0,0 +1,228 @@
+package relayserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// fsBlobBackend stores blobs on the local filesystem and serves them from the
+// relay itself. URLs are pre-signed with an HMAC key generated at start-up, so
+// they stop working when the relay restarts.
+type fsBlobBackend struct {
+	dir       string
+	publicURL string
+	key       [32]byte
+	lookup    func(id string) (*blobMeta, bool)
+}
+
+// newFSBlobBackend returns a filesystem backend rooted at dir. publicURL is the
+// externally reachable base URL used when building pre-signed links.
+func newFSBlobBackend(dir, publicURL string) (*fsBlobBackend, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	f := &fsBlobBackend{dir: dir, publicURL: strings.TrimRight(publicURL, "/")}
+	if _, err := rand.Read(f.key[:]); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Begin creates the per-blob parts directory.
+func (f *fsBlobBackend) Begin(_ context.Context, meta *blobMeta) error {
+	return os.MkdirAll(f.partsDir(meta.ID), 0o700)
+}
+
+// PartURL returns a signed PUT /blob/{id}/part/{n} URL on the relay.
+func (f *fsBlobBackend) PartURL(meta *blobMeta, n int, expires time.Time) (string, error) {
+	path := fmt.Sprintf("/blob/%s/part/%d", meta.ID, n)
+	return f.sign(http.MethodPut, path, expires), nil
+}
+
+// ReceivedParts lists the part files present on disk.
+func (f *fsBlobBackend) ReceivedParts(_ context.Context, meta *blobMeta) ([]int, error) {
+	entries, err := os.ReadDir(f.partsDir(meta.ID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []int
+	for _, e := range entries {
+		n, err := strconv.Atoi(e.Name())
+		if err != nil || n < 1 || n > meta.Parts {
+			continue // skip temp files from interrupted uploads
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// Complete concatenates the parts in order into the final object.
+func (f *fsBlobBackend) Complete(_ context.Context, meta *blobMeta) error {
+	tmp, err := os.CreateTemp(f.dir, meta.ID+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	for n := 1; n <= meta.Parts; n++ {
+		p, err := os.Open(filepath.Join(f.partsDir(meta.ID), strconv.Itoa(n)))
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		_, err = io.Copy(tmp, p)
+		_ = p.Close()
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.objectPath(meta.ID)); err != nil {
+		return err
+	}
+	return os.RemoveAll(f.partsDir(meta.ID))
+}
+
+// DownloadURL returns a signed GET /blob/{id}/data URL on the relay.
+func (f *fsBlobBackend) DownloadURL(meta *blobMeta, expires time.Time) (string, error) {
+	return f.sign(http.MethodGet, fmt.Sprintf("/blob/%s/data", meta.ID), expires), nil
+}
+
+// Delete removes the object and any leftover parts.
+func (f *fsBlobBackend) Delete(_ context.Context, meta *blobMeta) error {
+	if err := os.RemoveAll(f.partsDir(meta.ID)); err != nil {
+		return err
+	}
+	err := os.Remove(f.objectPath(meta.ID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// handlePut stores one part (PUT /blob/{id}/part/{n}?expires=...&sig=...).
+//
+// The request must carry a Content-Length equal to the expected part size.
+func (f *fsBlobBackend) handlePut(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if !f.verify(r) {
+		writeErr(w, http.StatusForbidden, domain.RelayCodeURLExpired, "invalid or expired signature")
+		return
+	}
+	meta, ok := f.lookup(r.PathValue("id"))
+	if !ok {
+		writeErr(w, http.StatusNotFound, domain.RelayCodeNotFound, errBlobNotFound.Error(), "resource", "blob")
+		return
+	}
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || n < 1 || n > meta.Parts {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeInvalidParameter, "bad part number", "param", "n")
+		return
+	}
+	want := meta.partSize(n)
+	if r.ContentLength != want {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeInvalidParameter, "content length mismatch", "param", "content_length")
+		return
+	}
+
+	dir := f.partsDir(meta.ID)
+	tmp, err := os.CreateTemp(dir, "part.tmp-*")
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, domain.RelayCodeStorage, "storage error")
+		return
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	written, err := io.Copy(tmp, http.MaxBytesReader(w, r.Body, want))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || written != want {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "incomplete part")
+		return
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, strconv.Itoa(n))); err != nil {
+		writeErr(w, http.StatusInternalServerError, domain.RelayCodeStorage, "storage error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleData streams an assembled object (GET /blob/{id}/data?expires=...&sig=...).
+func (f *fsBlobBackend) handleData(w http.ResponseWriter, r *http.Request) {
+	if !f.verify(r) {
+		writeErr(w, http.StatusForbidden, domain.RelayCodeURLExpired, "invalid or expired signature")
+		return
+	}
+	meta, ok := f.lookup(r.PathValue("id"))
+	if !ok || !meta.Complete {
+		writeErr(w, http.StatusNotFound, domain.RelayCodeNotFound, errBlobNotFound.Error(), "resource", "blob")
+		return
+	}
+	file, err := os.Open(f.objectPath(meta.ID))
+	if err != nil {
+		writeErr(w, http.StatusNotFound, domain.RelayCodeNotFound, errBlobNotFound.Error(), "resource", "blob")
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, "", time.Time{}, file)
+}
+
+// sign builds an absolute URL for method+path that is valid until expires.
+func (f *fsBlobBackend) sign(method, path string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", exp)
+	q.Set("sig", f.mac(method, path, exp))
+	return f.publicURL + path + "?" + q.Encode()
+}
+
+// verify checks the signature and expiry on a pre-signed request.
+func (f *fsBlobBackend) verify(r *http.Request) bool {
+	exp := r.URL.Query().Get("expires")
+	sig := r.URL.Query().Get("sig")
+	ts, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > ts {
+		return false
+	}
+	want := f.mac(r.Method, r.URL.Path, exp)
+	return hmac.Equal([]byte(sig), []byte(want))
+}
+
+// mac returns hex(HMAC-SHA256(key, method "\n" path "\n" expires)).
+func (f *fsBlobBackend) mac(method, path, expires string) string {
+	m := hmac.New(sha256.New, f.key[:])
+	m.Write([]byte(method + "\n" + path + "\n" + expires))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func (f *fsBlobBackend) partsDir(id string) string   { return filepath.Join(f.dir, id+".parts") }
+func (f *fsBlobBackend) objectPath(id string) string { return filepath.Join(f.dir, id) }
+
+// Compile-time assertion that fsBlobBackend implements blobBackend.
+var _ blobBackend = (*fsBlobBackend)(nil)
@@ -0,0 +1,346 @@
+package relayserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm     = "AWS4-HMAC-SHA256"
+	s3Service       = "s3"
+	s3TimeFormat    = "20060102T150405Z"
+	s3DateFormat    = "20060102"
+	s3UnsignedBody  = "UNSIGNED-PAYLOAD"
+	s3MaxPresignTTL = 7 * 24 * time.Hour
+	s3ErrBodyLimit  = 4 << 10
+)
+
+// s3BlobBackend stores blobs in an S3-compatible bucket using path-style URLs.
+//
+// Requests from the relay and pre-signed client URLs are authenticated with
+// AWS Signature Version 4, so any S3-compatible service (AWS, MinIO, R2, ...)
+// can be used without an SDK. Part uploads sign the Content-Length header,
+// which makes the storage service enforce the expected size.
+type s3BlobBackend struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	prefix    string
+	client    *http.Client
+}
+
+// newS3BlobBackend returns an S3 backend for bucket at endpoint.
+func newS3BlobBackend(
+	endpoint string,
+	bucket string,
+	region string,
+	accessKey string,
+	secretKey string,
+	client *http.Client,
+) (*s3BlobBackend, error) {
+	u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", endpoint)
+	}
+	if bucket == "" {
+		return nil, errors.New("s3 bucket required")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("s3 credentials required")
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &s3BlobBackend{
+		endpoint:  u,
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		prefix:    "blobs/",
+		client:    client,
+	}, nil
+}
+
+// Begin starts a multipart upload and records its upload ID.
+func (s *s3BlobBackend) Begin(ctx context.Context, meta *blobMeta) error {
+	var out struct {
+		UploadID string `xml:"UploadId"`
+	}
+	q := url.Values{"uploads": {""}}
+	if err := s.call(ctx, http.MethodPost, meta.ID, q, nil, &out); err != nil {
+		return err
+	}
+	if out.UploadID == "" {
+		return errors.New("s3: missing upload id")
+	}
+	meta.UploadID = out.UploadID
+	return nil
+}
+
+// PartURL pre-signs an UploadPart request bound to the expected Content-Length.
+func (s *s3BlobBackend) PartURL(meta *blobMeta, n int, expires time.Time) (string, error) {
+	q := url.Values{
+		"partNumber": {strconv.Itoa(n)},
+		"uploadId":   {meta.UploadID},
+	}
+	headers := map[string]string{"content-length": strconv.FormatInt(meta.partSize(n), 10)}
+	return s.presign(http.MethodPut, meta.ID, q, headers, expires)
+}
+
+// ReceivedParts lists uploaded parts via ListParts.
+func (s *s3BlobBackend) ReceivedParts(ctx context.Context, meta *blobMeta) ([]int, error) {
+	parts, err := s.listParts(ctx, meta)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]int, 0, len(parts))
+	for _, p := range parts {
+		out = append(out, p.PartNumber)
+	}
+	return out, nil
+}
+
+// Complete finishes the multipart upload using the ETags reported by ListParts.
+func (s *s3BlobBackend) Complete(ctx context.Context, meta *blobMeta) error {
+	parts, err := s.listParts(ctx, meta)
+	if err != nil {
+		return err
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+
+	body := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts}
+	raw, err := xml.Marshal(body)
+	if err != nil {
+		return err
+	}
+	q := url.Values{"uploadId": {meta.UploadID}}
+	return s.call(ctx, http.MethodPost, meta.ID, q, raw, nil)
+}
+
+// DownloadURL pre-signs a GetObject request.
+func (s *s3BlobBackend) DownloadURL(meta *blobMeta, expires time.Time) (string, error) {
+	return s.presign(http.MethodGet, meta.ID, url.Values{}, nil, expires)
+}
+
+// Delete aborts any unfinished upload and removes the object.
+func (s *s3BlobBackend) Delete(ctx context.Context, meta *blobMeta) error {
+	if !meta.Complete && meta.UploadID != "" {
+		q := url.Values{"uploadId": {meta.UploadID}}
+		return s.call(ctx, http.MethodDelete, meta.ID, q, nil, nil)
+	}
+	return s.call(ctx, http.MethodDelete, meta.ID, url.Values{}, nil, nil)
+}
+
+// s3Part is a completed part as reported by ListParts and sent to CompleteMultipartUpload.
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// listParts returns every part uploaded so far, following pagination.
+func (s *s3BlobBackend) listParts(ctx context.Context, meta *blobMeta) ([]s3Part, error) {
+	var all []s3Part
+	marker := ""
+	for {
+		q := url.Values{"uploadId": {meta.UploadID}}
+		if marker != "" {
+			q.Set("part-number-marker", marker)
+		}
+		var out struct {
+			Parts       []s3Part `xml:"Part"`
+			IsTruncated bool     `xml:"IsTruncated"`
+			NextMarker  string   `xml:"NextPartNumberMarker"`
+		}
+		if err := s.call(ctx, http.MethodGet, meta.ID, q, nil, &out); err != nil {
+			return nil, err
+		}
+		all = append(all, out.Parts...)
+		if !out.IsTruncated || out.NextMarker == "" {
+			return all, nil
+		}
+		marker = out.NextMarker
+	}
+}
+
+// call sends a signed request for key and optionally XML-decodes the response.
+func (s *s3BlobBackend) call(
+	ctx context.Context,
+	method string,
+	key string,
+	query url.Values,
+	body []byte,
+	out any,
+) error {
+	u := s.objectURL(key)
+	u.RawQuery = s3Query(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 u.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           now.Format(s3TimeFormat),
+	}
+	signedHeaders, canonHeaders := s3CanonicalHeaders(headers)
+	canonReq := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		canonHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := s.scope(now)
+	sig := s.signature(now, scope, canonReq)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKey, scope, signedHeaders, sig,
+	))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, s3ErrBodyLimit))
+		return fmt.Errorf("s3 %s %s: %s: %s", method, u.Path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out != nil {
+		return xml.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// presign builds a query-authenticated URL valid until expires.
+func (s *s3BlobBackend) presign(
+	method string,
+	key string,
+	query url.Values,
+	extraHeaders map[string]string,
+	expires time.Time,
+) (string, error) {
+	now := time.Now().UTC()
+	ttl := expires.Sub(now)
+	if ttl <= 0 || ttl > s3MaxPresignTTL {
+		return "", errors.New("s3: presign expiry out of range")
+	}
+	u := s.objectURL(key)
+
+	headers := map[string]string{"host": u.Host}
+	for k, v := range extraHeaders {
+		headers[k] = v
+	}
+	signedHeaders, canonHeaders := s3CanonicalHeaders(headers)
+
+	scope := s.scope(now)
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("X-Amz-Algorithm", s3Algorithm)
+	q.Set("X-Amz-Credential", s.accessKey+"/"+scope)
+	q.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(ttl.Seconds())))
+	q.Set("X-Amz-SignedHeaders", signedHeaders)
+	u.RawQuery = s3Query(q)
+
+	canonReq := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		u.RawQuery,
+		canonHeaders,
+		signedHeaders,
+		s3UnsignedBody,
+	}, "\n")
+	sig := s.signature(now, scope, canonReq)
+	u.RawQuery += "&X-Amz-Signature=" + sig
+	return u.String(), nil
+}
+
+// objectURL returns the path-style URL for key.
+func (s *s3BlobBackend) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + s.bucket + "/" + s.prefix + key
+	return &u
+}
+
+// scope returns the credential scope for t.
+func (s *s3BlobBackend) scope(t time.Time) string {
+	return t.Format(s3DateFormat) + "/" + s.region + "/" + s3Service + "/aws4_request"
+}
+
+// signature computes the SigV4 signature of canonReq.
+func (s *s3BlobBackend) signature(t time.Time, scope, canonReq string) string {
+	toSign := strings.Join([]string{
+		s3Algorithm,
+		t.Format(s3TimeFormat),
+		scope,
+		sha256Hex([]byte(canonReq)),
+	}, "\n")
+	k := hmacSHA256([]byte("AWS4"+s.secretKey), t.Format(s3DateFormat))
+	k = hmacSHA256(k, s.region)
+	k = hmacSHA256(k, s3Service)
+	k = hmacSHA256(k, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(k, toSign))
+}
+
+// s3CanonicalHeaders returns the signed-headers list and canonical header block.
+func s3CanonicalHeaders(h map[string]string) (signed, canonical string) {
+	names := make([]string, 0, len(h))
+	for k := range h {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, k := range names {
+		b.WriteString(k + ":" + strings.TrimSpace(h[k]) + "\n")
+	}
+	return strings.Join(names, ";"), b.String()
+}
+
+// s3Query encodes q with keys sorted and spaces as %20, as SigV4 requires.
+func s3Query(q url.Values) string {
+	return strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(msg))
+	return m.Sum(nil)
+}
+
+// Compile-time assertion that s3BlobBackend implements blobBackend.
+var _ blobBackend = (*s3BlobBackend)(nil)
@@ -0,0 +1,283 @@
+package relayserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/pow"
+)
+
+// Registration challenge answers arrive in these request headers.
+const (
+	challengeHeader       = "X-Ciphera-Challenge"
+	challengeNonceHeader  = "X-Ciphera-Challenge-Nonce"
+	challengeAnswerHeader = "X-Ciphera-Challenge-Answer"
+)
+
+// DefaultPoWBits is the proof-of-work difficulty cmd/relay asks for by
+// default: about a million hashes, a second or so on a laptop.
+const DefaultPoWBits = 20
+
+// powNonceTTL is how long a proof-of-work nonce can be answered.
+const powNonceTTL = 10 * time.Minute
+
+// captchaVerifyTimeout bounds each request to a CAPTCHA verification endpoint.
+const captchaVerifyTimeout = 10 * time.Second
+
+// ErrChallengeFailed is wrapped by a Challenge's Verify when the answer is
+// wrong. Any other error means the challenge could not be checked at all.
+var ErrChallengeFailed = errors.New("registration challenge failed")
+
+// Challenge decides whether a new username may register, so a public relay
+// can choose its own defence against bulk sign-ups. Re-registrations of an
+// existing username (prekey refreshes) are never put to it; they must be
+// signed by the username's owner instead (see admitOwner). Implementations
+// must be safe for concurrent use.
+type Challenge interface {
+	// Issue returns the challenge a client registering username must answer.
+	Issue(username string) (domain.RegistrationChallenge, error)
+	// Verify checks ans for username; nil admits the registration.
+	Verify(ctx context.Context, username string, ans domain.ChallengeAnswer) error
+}
+
+// tokenChallenge admits registrations that carry one of a set of invitation
+// tokens handed out by the operator. Tokens may be used any number of times.
+type tokenChallenge struct {
+	tokens [][]byte
+}
+
+// NewTokenChallenge returns a Challenge that admits registrations carrying
+// any of tokens.
+func NewTokenChallenge(tokens []string) (Challenge, error) {
+	c := &tokenChallenge{}
+	for _, t := range tokens {
+		if t = strings.TrimSpace(t); t != "" {
+			c.tokens = append(c.tokens, []byte(t))
+		}
+	}
+	if len(c.tokens) == 0 {
+		return nil, errors.New("token challenge: no tokens")
+	}
+	return c, nil
+}
+
+func (c *tokenChallenge) Issue(string) (domain.RegistrationChallenge, error) {
+	return domain.RegistrationChallenge{Kind: domain.ChallengeToken}, nil
+}
+
+// Verify compares ans against every token in constant time.
+func (c *tokenChallenge) Verify(_ context.Context, _ string, ans domain.ChallengeAnswer) error {
+	ok := 0
+	for _, t := range c.tokens {
+		ok |= subtle.ConstantTimeCompare([]byte(ans.Answer), t)
+	}
+	if ans.Kind != domain.ChallengeToken || ok != 1 {
+		return ErrChallengeFailed
+	}
+	return nil
+}
+
+// powChallenge asks for a proof-of-work over a nonce it does not need to
+// remember: the nonce carries its expiry and a MAC under a key generated at
+// startup, so nonces issued before a restart are refused.
+type powChallenge struct {
+	bits int
+	key  [32]byte
+	now  func() time.Time
+}
+
+// NewPoWChallenge returns a Challenge that asks for a proof-of-work of the
+// given difficulty in bits (see package pow).
+func NewPoWChallenge(bits int) (Challenge, error) {
+	if bits < 1 || bits > pow.MaxBits {
+		return nil, fmt.Errorf("pow challenge: difficulty must be 1 to %d bits", pow.MaxBits)
+	}
+	c := &powChallenge{bits: bits, now: time.Now}
+	if _, err := rand.Read(c.key[:]); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Issue returns a nonce of expiry (uint64, big-endian) ‖ 16 random bytes ‖
+// MAC over username and both, base64url-encoded.
+func (c *powChallenge) Issue(username string) (domain.RegistrationChallenge, error) {
+	raw := binary.BigEndian.AppendUint64(nil, uint64(c.now().Add(powNonceTTL).Unix()))
+	raw = append(raw, make([]byte, 16)...)
+	if _, err := rand.Read(raw[8:]); err != nil {
+		return domain.RegistrationChallenge{}, err
+	}
+	raw = append(raw, c.mac(username, raw)...)
+	return domain.RegistrationChallenge{
+		Kind:  domain.ChallengePoW,
+		Nonce: base64.RawURLEncoding.EncodeToString(raw),
+		Bits:  c.bits,
+	}, nil
+}
+
+func (c *powChallenge) Verify(_ context.Context, username string, ans domain.ChallengeAnswer) error {
+	raw, err := base64.RawURLEncoding.DecodeString(ans.Nonce)
+	if ans.Kind != domain.ChallengePoW || err != nil || len(raw) != 8+16+sha256.Size {
+		return ErrChallengeFailed
+	}
+	body, tag := raw[:8+16], raw[8+16:]
+	if !hmac.Equal(tag, c.mac(username, body)) {
+		return ErrChallengeFailed
+	}
+	if c.now().Unix() >= int64(binary.BigEndian.Uint64(body)) {
+		return fmt.Errorf("%w: nonce expired", ErrChallengeFailed)
+	}
+	if !pow.Check(ans.Nonce, username, ans.Answer, c.bits) {
+		return ErrChallengeFailed
+	}
+	return nil
+}
+
+// mac binds a nonce body to username.
+func (c *powChallenge) mac(username string, body []byte) []byte {
+	m := hmac.New(sha256.New, c.key[:])
+	m.Write(binary.BigEndian.AppendUint16(nil, uint16(len(username))))
+	m.Write([]byte(username))
+	m.Write(body)
+	return m.Sum(nil)
+}
+
+// CAPTCHAOptions configures a CAPTCHA challenge checked by an external
+// verification endpoint, such as those of hCaptcha, reCAPTCHA or Turnstile.
+type CAPTCHAOptions struct {
+	// VerifyURL receives a form POST of secret and response and answers
+	// with JSON carrying "success"; Secret is the relay's key for it.
+	VerifyURL string
+	Secret    string
+	// SiteKey and PageURL tell clients which CAPTCHA to solve and where a
+	// person can solve it to obtain the response token.
+	SiteKey string
+	PageURL string
+	// Client makes the verification requests; nil uses one with a 10s timeout.
+	Client *http.Client
+}
+
+// captchaChallenge asks for a CAPTCHA response token and has the provider
+// verify it.
+type captchaChallenge struct {
+	opts CAPTCHAOptions
+}
+
+// NewCAPTCHAChallenge returns a Challenge that asks for a CAPTCHA response
+// token and checks it with opts.VerifyURL.
+func NewCAPTCHAChallenge(opts CAPTCHAOptions) (Challenge, error) {
+	if opts.VerifyURL == "" || opts.Secret == "" {
+		return nil, errors.New("captcha challenge: verify URL and secret are required")
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: captchaVerifyTimeout}
+	}
+	return &captchaChallenge{opts: opts}, nil
+}
+
+func (c *captchaChallenge) Issue(string) (domain.RegistrationChallenge, error) {
+	return domain.RegistrationChallenge{
+		Kind:    domain.ChallengeCAPTCHA,
+		SiteKey: c.opts.SiteKey,
+		URL:     c.opts.PageURL,
+	}, nil
+}
+
+func (c *captchaChallenge) Verify(ctx context.Context, _ string, ans domain.ChallengeAnswer) error {
+	if ans.Kind != domain.ChallengeCAPTCHA || ans.Answer == "" {
+		return ErrChallengeFailed
+	}
+	form := url.Values{"secret": {c.opts.Secret}, "response": {ans.Answer}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opts.VerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("captcha verify: %s", resp.Status)
+	}
+	var out struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return fmt.Errorf("captcha verify: %w", err)
+	}
+	if !out.Success {
+		return ErrChallengeFailed
+	}
+	return nil
+}
+
+// admitRegistration checks the challenge headers of a registration of a new
+// username. It reports whether the registration may go ahead; if not, it has
+// answered 428 with a fresh challenge, or 502 if the answer could not be
+// checked.
+func (s *state) admitRegistration(w http.ResponseWriter, r *http.Request, username string) bool {
+	ans := domain.ChallengeAnswer{
+		Kind:   domain.ChallengeKind(r.Header.Get(challengeHeader)),
+		Nonce:  r.Header.Get(challengeNonceHeader),
+		Answer: r.Header.Get(challengeAnswerHeader),
+	}
+	failed := false
+	if ans.Kind != "" {
+		err := s.challenge.Verify(r.Context(), username, ans)
+		if err == nil {
+			return true
+		}
+		if !errors.Is(err, ErrChallengeFailed) {
+			s.log.Warn("Registration challenge could not be checked",
+				"user", username, "error", err, "reqid", requestIDFromCtx(r.Context()))
+			writeErr(w, http.StatusBadGateway, domain.RelayCodeUnavailable, "challenge verification unavailable", "service", "challenge")
+			return false
+		}
+		failed = true
+	}
+
+	c, err := s.challenge.Issue(username)
+	if err != nil {
+		s.log.Error("Issuing registration challenge failed",
+			"user", username, "error", err, "reqid", requestIDFromCtx(r.Context()))
+		writeErr(w, http.StatusInternalServerError, domain.RelayCodeInternal, "challenge unavailable")
+		return false
+	}
+	s.writeChallenge(w, r, username, c, failed)
+	return false
+}
+
+// writeChallenge answers a registration of username with 428 and challenge
+// c; failed says an answer was sent and rejected.
+func (s *state) writeChallenge(w http.ResponseWriter, r *http.Request, username string, c domain.RegistrationChallenge, failed bool) {
+	s.accessLog.Info("register_challenge",
+		"user", username,
+		"kind", c.Kind,
+		"failed", failed,
+		"reqid", requestIDFromCtx(r.Context()),
+	)
+	e := newRelayError(domain.RelayCodeChallengeRequired, "registration challenge required", "kind", string(c.Kind))
+	if failed {
+		e = newRelayError(domain.RelayCodeChallengeFailed, "registration challenge failed", "kind", string(c.Kind))
+	}
+	extra := map[string]any{"challenge": c}
+	if failed {
+		extra["failed"] = true
+	}
+	writeErrBody(w, http.StatusPreconditionRequired, e, extra)
+}
@@ -0,0 +1,128 @@
+package relayserver
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// ChaosOptions injects adverse network conditions into queue delivery, so
+// client retries, deduplication and ordering can be exercised in
+// development. The zero value injects nothing. Never enable it on a relay
+// carrying real traffic.
+type ChaosOptions struct {
+	// Drop is the probability that an envelope is lost after the relay has
+	// accepted it: the sender gets a sequence number, the recipient never
+	// sees it.
+	Drop float64
+	// Delay holds each accepted envelope back from fetches for a random
+	// time up to Delay, so envelopes also arrive out of order.
+	Delay time.Duration
+	// Duplicate is the probability that a fetched envelope is returned
+	// twice in the same response.
+	Duplicate float64
+}
+
+// Enabled reports whether o injects anything.
+func (o ChaosOptions) Enabled() bool {
+	return o.Drop > 0 || o.Delay > 0 || o.Duplicate > 0
+}
+
+// validate checks o's probabilities and delay.
+func (o ChaosOptions) validate() error {
+	for name, p := range map[string]float64{"drop": o.Drop, "duplicate": o.Duplicate} {
+		if p < 0 || p > 1 {
+			return fmt.Errorf("chaos %s probability %v is outside [0, 1]", name, p)
+		}
+	}
+	if o.Delay < 0 {
+		return fmt.Errorf("negative chaos delay %v", o.Delay)
+	}
+	return nil
+}
+
+// chaos applies ChaosOptions. A nil *chaos injects nothing, so the handlers
+// call it unconditionally.
+type chaos struct {
+	opts ChaosOptions
+
+	mu      sync.Mutex
+	rng     *rand.Rand
+	release map[string]time.Time // envelope ID -> when fetches may return it
+}
+
+// newChaos returns a chaos for opts, or nil if opts injects nothing.
+func newChaos(opts ChaosOptions) *chaos {
+	if !opts.Enabled() {
+		return nil
+	}
+	return &chaos{
+		opts:    opts,
+		rng:     rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		release: make(map[string]time.Time),
+	}
+}
+
+// drop reports whether an envelope about to be queued should be lost.
+func (c *chaos) drop() bool {
+	if c == nil || c.opts.Drop == 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rng.Float64() < c.opts.Drop
+}
+
+// hold delays the envelope with id, queued at now, by a random time up to
+// the configured delay.
+func (c *chaos) hold(id string, now time.Time) {
+	if c == nil || c.opts.Delay == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.release[id] = now.Add(time.Duration(c.rng.Int64N(int64(c.opts.Delay) + 1)))
+}
+
+// visible returns the envelopes of q that may be fetched at now. Hold times
+// that have passed are forgotten.
+func (c *chaos) visible(q []domain.Envelope, now time.Time) []domain.Envelope {
+	if c == nil || c.opts.Delay == 0 {
+		return q
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, at := range c.release {
+		if !now.Before(at) {
+			delete(c.release, id)
+		}
+	}
+	out := make([]domain.Envelope, 0, len(q))
+	for _, env := range q {
+		if _, held := c.release[env.ID]; !held {
+			out = append(out, env)
+		}
+	}
+	return out
+}
+
+// duplicate returns envs with some envelopes repeated straight after
+// themselves.
+func (c *chaos) duplicate(envs []domain.Envelope) []domain.Envelope {
+	if c == nil || c.opts.Duplicate == 0 {
+		return envs
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]domain.Envelope, 0, len(envs))
+	for _, env := range envs {
+		out = append(out, env)
+		if c.rng.Float64() < c.opts.Duplicate {
+			out = append(out, env)
+		}
+	}
+	return out
+}
@@ -0,0 +1,380 @@
+// Package relayserver implements the Ciphera relay as an http.Handler. It
+// stores published prekey bundles and queues encrypted envelopes for
+// recipients until they fetch them. NewServer builds one from Options, so
+// tests and embedders can mount a relay in their own mux or httptest.Server;
+// cmd/relay serves it with flags, listeners and TLS.
+//
+// HTTP API
+//
+//	POST /register
+//	    Store a user's PrekeyBundle (identity key, signed prekey + sig, OPKs,
+//	    capabilities, cipher suites). The relay stores capabilities and
+//	    suites without interpreting them.
+//	    With Options.Challenge set, a username the relay has not seen must
+//	    answer a registration challenge (see below). Updating a registered
+//	    username must answer a signature challenge instead, proving the
+//	    request comes from the owner of the stored bundle's signing key
+//	    (see below). Usernames starting with fp: are refused (400),
+//	    as are prekey IDs that do not follow the key ID scheme or name two
+//	    one-time prekeys alike (see package keyid).
+//
+//	GET /prekey/{username}
+//	    Return the latest published PrekeyBundle for {username}, which may
+//	    be a fingerprint address (see below). Its one-time prekeys are left
+//	    out unless the request is signed by {username} (see
+//	    GET /account/{user}/usage); others check them out one at a time.
+//
+//	POST /prekey/{username}/checkout
+//	    Return {username}'s bundle with one one-time prekey, for starting a
+//	    session. The relay removes that key from the stored bundle, and
+//	    writes the removal to the state log, before answering, so no two
+//	    initiators are given the same key; once none are left the bundle
+//	    carries none. The relay remembers the keys it handed out that the
+//	    owner has not yet consumed, in memory only, and leaves them out of
+//	    bundles the owner registers again. With Options.CheckoutLimit set,
+//	    at most Max of a user's keys are handed out per Window, however
+//	    many ask; later checkouts get the bundle without one.
+//
+//	POST /msg/{user}
+//	    Enqueue an Envelope destined to {user} and return { "seq": N }, the
+//	    relay-wide sequence number assigned to it; the envelope's ID is N in
+//	    decimal. If Timestamp is zero, the server fills it with the current
+//	    Unix time. An envelope whose expires_utc has already passed is
+//	    refused (400). {user} may be a fingerprint address, and one no
+//	    registered key has is refused (404).
+//
+//	GET /msg/{user}?limit=N
+//	    Return up to N queued Envelopes for {user}. If limit is absent or
+//	    greater than the queue length, all queued envelopes are returned.
+//	    Envelopes are taken round-robin across senders, keeping each
+//	    sender's envelopes in arrival order. Envelopes whose expires_utc
+//	    has passed are dropped instead; the X-Ciphera-Expired header counts
+//	    those dropped since the last fetch. A background sweep also drops
+//	    them every minute. X-Ciphera-Expired-Sent lists, comma-separated,
+//	    the IDs of envelopes {user} sent that expired unfetched since
+//	    {user}'s last fetch, so senders learn which messages were never
+//	    delivered; at most 256 are kept per sender, in memory only. With include_acked=1, envelopes acked within
+//	    Options.AckRetention are returned among them, with acked_utc set.
+//	    With Options.Chaos set, envelopes may be lost when enqueued, held
+//	    back for a while, or returned twice.
+//	    With wait=D, a Go duration such as 30s, a fetch that finds nothing
+//	    to return (no envelopes and no expiry counts) is held open until an
+//	    envelope is queued for {user} or D passes, and then answers as
+//	    usual, empty if nothing came. Waits over 60s are cut to 60s, a bad
+//	    duration is refused (400), and the response may take D beyond the
+//	    server's write timeout. Waiting fetches answer at once when the
+//	    relay shuts down (see Server.Drain).
+//
+//	POST /msg/{user}/ack { "ids": ["...", ...] }
+//	    Drop the queued envelopes for {user} with the given IDs. Unknown IDs
+//	    are ignored, and envelopes queued after the fetch are never dropped.
+//	    With Options.AckRetention set, dropped envelopes are kept in memory
+//	    for that long (see include_acked above) and then purged.
+//
+//	PUT /backup/{user} { "data", "updated_utc", "sig" }
+//	    Store {user}'s encrypted account backup, replacing the previous one.
+//	    sig is an Ed25519 signature by the signing key of {user}'s published
+//	    bundle (401 otherwise, 404 if {user} never registered). data is
+//	    opaque to the relay and capped at 256 KiB (413); a backup older than
+//	    the stored one is refused (409).
+//
+//	GET /msg/{user}/stats?from=sender
+//	    Return { "queued", "fetched_seq" } for {user}'s queue, counting only
+//	    envelopes from sender if given. No such envelope numbered
+//	    fetched_seq or lower is still queued. A sender's envelopes are
+//	    fetched in order, so this tells a sender which of its messages have
+//	    been fetched (or dropped as expired or over quota).
+//
+//	GET /ws/{user}
+//	    Upgrade to a WebSocket (see package websocket) that receives, as a
+//	    text message of its JSON, each Envelope queued for {user} from then
+//	    on, so clients need not poll. The upgrade is signed as for usage by
+//	    {user} (401 otherwise, 404 if {user} never registered). Pushed
+//	    envelopes stay queued until acked as above; a client fetches once
+//	    connected for those queued before. A connection that falls 64
+//	    envelopes behind is closed (1013) and the client fetches what it
+//	    missed. The relay pings every 30 seconds. Each user may hold 8
+//	    connections; more are refused (503, busy). Envelopes Options.Chaos
+//	    holds back are only fetched.
+//
+//	GET /backup/{user}
+//	    Return {user}'s backup (404 if there is none). Anyone may fetch it;
+//	    only the client's passphrase protects the contents.
+//
+//	GET /account/{user}/usage
+//	    Return [{ "month", "bytes_in", "bytes_out", "envelopes_in",
+//	    "envelopes_out" }], newest month first, for the last twelve calendar
+//	    months (UTC). In counts request bodies {user} sent (envelopes by
+//	    their from field, bundles, backups); out counts fetch and backup
+//	    responses returned to {user}. The request must carry X-Ciphera-Auth-Time and
+//	    X-Ciphera-Auth-Signature, signed as package relayauth describes by
+//	    the signing key of {user}'s published bundle, with a time within five
+//	    minutes of the relay's (401 otherwise, 404 if {user} never
+//	    registered).
+//
+//	GET /account/{user}/journal?after=N
+//	    Return up to 1000 events of {user}'s queue journal numbered after N
+//	    (default 0), oldest first: [{ "n", "time_utc", "kind", "id", "hash" }],
+//	    where kind is enqueue, fetch, ack, drop (over quota) or expire and hash
+//	    is the SHA-256 of the envelope's ciphertext. Signed as for usage. Only
+//	    kept with DataDir (404 otherwise); see Storage.
+//
+//	PUT /presence/{user} { "visibility", "contacts", "updated_utc", "sig" }
+//	    Set who may see when {user} last fetched their messages: "nobody"
+//	    (the default; the relay records nothing), "contacts" (the usernames
+//	    listed) or "everyone". Signed and checked like a backup, and an
+//	    older policy is refused (409). At most 1000 contacts (413).
+//
+//	GET /presence/{user}
+//	    Return { "user", "last_seen_utc" }, the time of {user}'s last push
+//	    connection or fetch signed as for usage by {user} (unsigned fetches
+//	    are served but not counted), rounded down to the minute, if their policy
+//	    shows it to the asker.
+//	    A contact asks as itself: X-Ciphera-Auth-User names it and the
+//	    request is signed as for usage by its own key (401 if that fails).
+//	    A hidden, unseen or unknown user is 404 alike. Last-seen times are
+//	    kept in memory only; policies are stored like backups.
+//
+//	GET /server-info
+//	    Return the relay's version, commit, build date and protocol versions
+//	    (the same as relay --version), for client compatibility checks. An
+//	    operator's notice is added as "notice": { "maintenance_start_utc",
+//	    "maintenance_end_utc", "motd", "min_client_version", "client_gate" },
+//	    all optional; clients older than min_client_version warn, or with
+//	    client_gate "block" stop. See Options.Notice.
+//
+//	GET /.well-known/ciphera-relay
+//	    Return { "relay": Options.DiscoveryURL }, so clients resolving a
+//	    user@host address whose host is this relay's find it. Not served
+//	    when DiscoveryURL is empty.
+//
+// Fingerprint addresses (fp:<fingerprint>, see package address)
+//
+// Such a name stands for the user who
+// registered the identity key with that fingerprint; if several did, the
+// last to register while the relay runs. The index is rebuilt from the
+// bundles at startup. Envelopes to such a name are queued for that user with
+// To unchanged, and one from such a sender counts as the user's for
+// restrictions and usage. Clients check the fingerprint of the bundle they
+// get, so a relay cannot answer for a key it does not hold.
+//
+// Pairing mailboxes
+//
+//	POST /pair/{box} { "side": "a"|"b", "body": "<base64>", "open": bool }
+//	    Append a message from one side. With open set, the mailbox must not
+//	    exist yet (409 otherwise).
+//
+//	GET /pair/{box}?side=a&after=N
+//	    Return the other side's messages, skipping the first N.
+//
+//	DELETE /pair/{box}
+//	    Discard the mailbox.
+//
+// Mailboxes carry SPAKE2 shares and ciphertext only; the pairing code never
+// reaches the relay. They expire after ten minutes.
+//
+// Attachments (only when Options.Blobs selects the fs or s3 backend)
+//
+//	POST /blob { "size": N }
+//	    Start a multipart upload of an (already encrypted) attachment. The
+//	    response lists the parts and a pre-signed PUT URL for each one.
+//
+//	GET /blob/{id}
+//	    Report received parts and re-issue URLs for missing ones, so an
+//	    interrupted upload can resume where it stopped.
+//
+//	POST /blob/{id}/complete
+//	    Assemble the parts once all are present (409 otherwise).
+//
+//	GET /blob/{id}/download
+//	    Return a short-lived pre-signed download URL.
+//
+// Part uploads must carry a Content-Length equal to the advertised part size.
+// Blobs expire after BlobOptions.TTL and are garbage collected in the background.
+// The fs backend serves the pre-signed URLs itself (PUT /blob/{id}/part/{n},
+// GET /blob/{id}/data); the s3 backend signs URLs for any S3-compatible store
+// using BlobOptions.S3AccessKey and S3SecretKey.
+//
+// Registration challenges (only when Options.Challenge is set)
+//
+// A POST /register for a new username without a valid answer gets 428 and
+// { "error", "challenge": { "kind", ... }, "failed" }, the error coded
+// challenge_required, or challenge_failed with failed set when an answer was
+// sent and rejected. The client retries with the answer in
+// X-Ciphera-Challenge (the kind), X-Ciphera-Challenge-Nonce and
+// X-Ciphera-Challenge-Answer. NewTokenChallenge accepts operator-issued
+// invitation tokens; NewPoWChallenge issues a stateless, expiring nonce
+// for the proof-of-work of package pow; NewCAPTCHAChallenge has an external
+// provider verify a CAPTCHA response token. Any other Challenge may be
+// plugged in. When a verifier cannot be reached the relay answers 502.
+//
+// A POST /register for a registered username whose bundle has a signing key
+// gets the same 428 with a challenge of kind signature: a nonce, good for
+// five minutes and for one answer, that the owner signs together with the
+// new bundle (see package relayauth). The answer is the signature, base64.
+// It must verify under the stored signing key, or under the new bundle's
+// key when the bundle's sign chain leads to it from the stored one (see
+// package signchain); a different identity cannot take the username over.
+// Nonces are bound to the username under a key generated at startup, so a
+// restart only costs the client a retry. Bundles stored without a signing
+// key, by older clients, are replaced without proof.
+//
+// Admin API (only when Options.AdminToken is set)
+//
+//	PUT /admin/users/{user}/restriction { "mode", "reason", "duration" }
+//	    Suspend ("suspend") or shadow-ban ("shadow_ban") {user}, for
+//	    duration (e.g. "24h") or until lifted. Replaces any earlier
+//	    restriction.
+//
+//	DELETE /admin/users/{user}/restriction
+//	    Lift the restriction (404 if there is none).
+//
+//	GET /admin/restrictions
+//	    List the restrictions in force.
+//
+//	GET /admin/usage?month=YYYY-MM
+//	    List every user's usage in month (default: the current one), as
+//	    { "user", "month", ... }, heaviest first.
+//
+//	PUT /admin/notice { "maintenance_start_utc", "motd", ... }
+//	    Replace the notice GET /server-info announces; {} withdraws it. The
+//	    replacement is not persisted: a restart restores Options.Notice.
+//
+//	GET /admin/storage/rotation
+//	    Report a storage key rotation (see Storage) as { "state",
+//	    "remaining", "resealed", ... }; state is "none", "running" or
+//	    "complete".
+//
+//	GET /admin/export
+//	    Snapshot everything the relay stores for its users: bundles, queued
+//	    envelopes (opened, if sealed), restrictions, backups, presence
+//	    policies and the last sequence number, as { "version", "next_seq",
+//	    "bundles", "queues", ..., "digest" } (see Migration).
+//
+//	POST /admin/import <snapshot>
+//	    Load a snapshot from GET /admin/export into a relay holding no data
+//	    yet (409 not_empty otherwise), answering with the counts and digest
+//	    of its state afterwards. A snapshot that fails its checks is refused
+//	    whole (400 inconsistent).
+//
+// Requests must carry "Authorization: Bearer <AdminToken>" (401
+// otherwise). Enqueues to or from a suspended user fail with 403; those to or
+// from a shadow-banned user get a sequence number as usual and are dropped.
+// Every admin request,
+// including rejected ones, is logged as an admin_audit line even without
+// Options.AccessLog.
+//
+// Webhooks (only when Options.WebhookURLs is set)
+//
+// The relay POSTs a JSON event { "id", "type", "created_utc", "data" } to each
+// webhook URL when:
+//
+//	user.registered      a username publishes its first bundle
+//	queue.high_water     a user's queue grows to WebhookHighWater envelopes
+//	message.dead_letter  a full queue or sender quota drops an envelope
+//
+// Each request carries X-Ciphera-Event, X-Ciphera-Delivery (the event ID) and
+// X-Ciphera-Signature: "t=<unix>,v1=<hex HMAC-SHA256(secret, t + "." + body)>",
+// keyed with Options.WebhookSecret. Network errors, 429 and 5xx responses are
+// retried up to five times with exponential backoff. Events carry usernames and
+// envelope IDs only, never bundles or ciphertext.
+//
+// Tracing (only when Options.OTLPEndpoint is set)
+//
+// Each request is exported as an OTLP span, JSON over HTTP, to the collector's
+// /v1/traces. Spans are named after the route and carry the method, status,
+// user, request ID and, for /msg routes, the queue length; never ciphertext.
+// An incoming W3C traceparent header makes the span a child of the caller's,
+// and unsampled callers are not exported.
+//
+// Storage (only when Options.DataDir is set)
+//
+// Bundles, queues, backups and admin restrictions are appended to <DataDir>/state.log, one checksummed
+// JSON record per change, and replayed at startup. A torn final record or an
+// ack for an envelope that was never queued is dropped with a warning. A
+// corrupt record or a reused envelope ID makes NewServer fail unless Repair is
+// set, in which case the bad records are dropped. The log is compacted
+// into a snapshot when it is opened and once dead records outnumber live ones.
+// Usage counts are kept apart in <DataDir>/usage.json, saved every minute and
+// when the server closes, so a crash loses at most a minute of counting.
+//
+// Each user's queue journal is appended to its own file in <DataDir>/journal,
+// one JSON event per line, so they can audit whether the relay dropped or
+// replayed their envelopes. Events name envelopes by ID and ciphertext hash
+// only. Once a journal holds 20000 events it is trimmed to the newest 10000,
+// which keep their numbers. A torn final line is skipped, and a journal that
+// cannot be written is logged without failing the request.
+//
+// With Options.StorageKey, each queued envelope is stored sealed with
+// XChaCha20-Poly1305 under a key derived from it, bound to the recipient's
+// username, which is all a record shows. The sender, timestamps, envelope ID
+// and ratchet header stay hidden from anyone who copies the log without the
+// key. Envelopes already stored in the clear are sealed when the log is next
+// opened with a key. Envelopes the key cannot open make NewServer fail even
+// with Repair, since a wrong key would otherwise empty every queue.
+//
+// To rotate the key, reopen with the new StorageKey and the old one as
+// Options.PreviousStorageKey. Envelopes only the previous key opens are
+// resealed in the background, a batch at a time, while the server keeps
+// serving; each batch is logged, so a restart picks up where it stopped.
+// Once none are left the log is compacted and the rotation reports
+// complete, after which the previous key can be dropped. Bundles are public
+// and are never sealed, so they need no rotation.
+//
+// Migration (only when Options.AdminToken is set)
+//
+// GET /admin/export and POST /admin/import move a relay's state to another
+// storage backend: from memory to a data directory, between data
+// directories, or to a different storage key. The export is taken under the
+// state lock, so it is consistent, and carries the hex SHA-256 of its JSON
+// with the digest left empty. The import checks the digest, that every bundle
+// and envelope is filed under its own user, and that envelope IDs are unique
+// and no later than next_seq, before it changes anything; with a data
+// directory it then compacts the snapshot into the state log, sealed under
+// the relay's key. Envelopes queued at the source after the export, usage
+// counts, queue journals, acked envelopes, pairing mailboxes and
+// attachments are not carried over. An export shows every envelope's sender
+// and timestamps, however the source stores them.
+//
+// Behaviour
+//
+//   - State is held in memory and lost when the process exits, unless
+//     DataDir is set (see Storage).
+//   - A recipient's queue holds up to 1000 envelopes, and any one sender up
+//     to 250 of them. A sender over its share loses its own oldest envelope;
+//     a full queue drops the oldest envelope of the sender holding the most.
+//   - Responses are JSON. Non-2xx statuses carry a structured error (see
+//     Errors).
+//   - With Options.AccessLog, an access log line records method, path,
+//     remote, status, bytes and duration for each request.
+//   - Options.RouteLimits bounds the requests to a route handled at once.
+//     Requests over the limit wait in a short queue for a slot; a full
+//     queue, or a wait past RouteLimit.Wait, is answered 503 busy with
+//     Retry-After. GET /healthz bypasses every limit and middleware, so
+//     probes are answered however busy the relay is.
+//
+// Errors, for every non-2xx status:
+//
+//	{ "error": { "code", "message", "retryable", "details": { ... } } }
+//
+// code is a machine-readable domain.RelayErrorCode that clients branch on;
+// message is for people and may change. retryable is set when the same
+// request may succeed later unchanged. details holds string context such as
+// the missing "field", the "limit" exceeded or the unknown "user". Codes, by
+// status:
+//
+//	400  bad_request, missing_field, invalid_parameter, username_reserved,
+//	     recipient_mismatch, future_timestamp, envelope_expired
+//	401  auth_required, bad_signature, stale_request, bad_admin_token
+//	403  account_suspended, url_expired
+//	404  user_not_found, unknown_fingerprint, not_found
+//	409  mailbox_in_use, mailbox_full, newer_backup, blob_incomplete
+//	413  too_large
+//	428  challenge_required, challenge_failed
+//	5xx  internal_error, storage_error, upstream_unavailable, busy (retryable)
+//
+// New codes may be added; clients treat an unknown code by its status.
+//
+// The relay never sees plaintext or private keys; it only stores ciphertext
+// and public bundles.
+package relayserver
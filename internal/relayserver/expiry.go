@@ -0,0 +1,88 @@
+package relayserver
+
+import (
+	"context"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// Envelope expiry.
+const (
+	expirySweepInterval = time.Minute
+	expiredHeader       = "X-Ciphera-Expired"      // fetch response: envelopes expired since the last fetch
+	expiredSentHeader   = "X-Ciphera-Expired-Sent" // fetch response: IDs of the fetcher's envelopes that expired
+	// maxExpiredSent bounds the expired IDs held per sender; the oldest
+	// go first.
+	maxExpiredSent = 256
+)
+
+// expireLocked drops the envelopes in user's queue whose sender-set expiry
+// has passed at now, adds them to the user's expired count and notes their
+// IDs for their senders. The caller holds s.mu for writing.
+func (s *state) expireLocked(user string, now time.Time) error {
+	queue := s.queues[user]
+	kept := make([]domain.Envelope, 0, len(queue))
+	var (
+		gone    []string
+		expired []domain.Envelope
+	)
+	for _, env := range queue {
+		if env.ExpiresUTC != 0 && now.Unix() >= env.ExpiresUTC {
+			gone = append(gone, env.ID)
+			expired = append(expired, env)
+		} else {
+			kept = append(kept, env)
+		}
+	}
+	if len(gone) == 0 {
+		return nil
+	}
+	if err := s.store.dropped(user, gone); err != nil {
+		return err
+	}
+	s.queues[user] = kept
+	s.expired[user] += len(gone)
+	for _, env := range expired {
+		sender := env.From
+		if from, ok := s.resolve(env.From); ok {
+			sender = from
+		}
+		ids := append(s.expiredSent[sender], env.ID)
+		if n := len(ids) - maxExpiredSent; n > 0 {
+			ids = ids[n:]
+		}
+		s.expiredSent[sender] = ids
+	}
+	s.journal.add(user, domain.JournalExpire, expired, now)
+	s.compactIfNeeded()
+	s.accessLog.Info("expire", "user", user, "drop", len(gone), "remaining", len(kept))
+	return nil
+}
+
+// runExpiry drops expired envelopes from every queue, and tombstones whose
+// retention has passed, until ctx is cancelled, so they do not linger for
+// recipients who never fetch. Fetches also expire the caller's queue first,
+// so the sweep interval only bounds storage.
+func (s *state) runExpiry(ctx context.Context) {
+	t := time.NewTicker(expirySweepInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			s.mu.Lock()
+			for user := range s.queues {
+				if err := s.expireLocked(user, now); err != nil {
+					s.log.Error("expire_store", "user", user, "error", err)
+					break
+				}
+			}
+			for user := range s.acked {
+				s.purgeAckedLocked(user, now)
+			}
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package relayserver
+
+import (
+	"strings"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/address"
+)
+
+// resolve returns the user name stands for: the user who registered the
+// identity key of an fp: address, or name itself for any other name. ok is
+// false for an fp: address no registered key has. s.mu must be held.
+func (s *state) resolve(name string) (user string, ok bool) {
+	if !strings.HasPrefix(name, address.FingerprintPrefix) {
+		return name, true
+	}
+	fp, err := address.ParseFingerprint(name)
+	if err != nil {
+		return "", false
+	}
+	user, ok = s.fingerprints[fp]
+	return user, ok
+}
+
+// unindexFingerprint forgets the fingerprint of the identity key in bundle,
+// which its user has replaced, unless it is indexed to someone else. s.mu
+// must be held.
+func (s *state) unindexFingerprint(bundle domain.PrekeyBundle) {
+	fp := crypto.Fingerprint(bundle.IdentityKey.Slice())
+	if s.fingerprints[fp] == bundle.Username {
+		delete(s.fingerprints, fp)
+	}
+}
+
+// indexFingerprints maps the fingerprint of each bundle's identity key to its
+// user, as the relay starts. Registration refuses a key another user holds
+// (see fingerprintTaken), but data written before it did may hold one under
+// several users; the bundles do not record who was first, so such a key is
+// left out and its fp: address resolves to no one.
+func indexFingerprints(bundles map[string]domain.PrekeyBundle) map[string]string {
+	idx := make(map[string]string, len(bundles))
+	shared := make(map[string]bool)
+	for user, b := range bundles {
+		if isZero32(b.IdentityKey[:]) {
+			continue
+		}
+		fp := crypto.Fingerprint(b.IdentityKey.Slice())
+		if _, ok := idx[fp]; ok {
+			shared[fp] = true
+		}
+		idx[fp] = user
+	}
+	for fp := range shared {
+		delete(idx, fp)
+	}
+	return idx
+}
+
+// fingerprintTaken reports whether another user than bundle's registered its
+// identity key, so registering it would take over their fp: address. A
+// bundle without an identity key has no address. s.mu must be held.
+func (s *state) fingerprintTaken(bundle domain.PrekeyBundle) bool {
+	if isZero32(bundle.IdentityKey[:]) {
+		return false
+	}
+	user, ok := s.fingerprints[crypto.Fingerprint(bundle.IdentityKey.Slice())]
+	return ok && user != bundle.Username
+}
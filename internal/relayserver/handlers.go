@@ -0,0 +1,988 @@
+package relayserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ciphera/internal/buildinfo"
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/address"
+	"ciphera/internal/protocol/caps"
+	"ciphera/internal/protocol/keyid"
+)
+
+// Relay policy limits.
+const (
+	maxRequestBody  = 1 << 20          // 1 MiB cap for incoming JSON bodies
+	maxPerUserQueue = 1000             // cap messages kept per user
+	maxCipherBytes  = 64 << 10         // 64 KiB max cipher payload
+	maxOneTimeKeys  = 500              // max one-time prekeys in a bundle
+	maxCapabilities = 32               // max capability names in a bundle
+	maxSuites       = 16               // max cipher suite IDs in a bundle
+	maxAttestations = 64               // max identity attestations in a bundle
+	maxFutureSkew   = 10 * time.Minute // reject timestamps too far in the future
+
+	// DefaultHighWater is the queue length reported as high water when
+	// Options.WebhookHighWater is zero.
+	DefaultHighWater = maxPerUserQueue * 8 / 10
+)
+
+// Context key for request ID.
+type ctxKey string
+
+const ctxKeyReqID ctxKey = "reqid"
+
+// state holds registered prekey bundles and per-user message queues.
+type state struct {
+	mu      sync.RWMutex
+	bundles map[string]domain.PrekeyBundle
+	queues  map[string][]domain.Envelope
+	backups map[string]domain.RelayBackup // sealed client backups, by user
+	nextSeq uint64                        // last envelope sequence number handed out
+	hooks   *webhookService               // nil when no webhooks are configured
+	store   *diskStore                    // nil when state is kept in memory only
+
+	// challenge must be answered to register a new username; nil leaves
+	// registration open.
+	challenge Challenge
+
+	// owners issues and checks the nonces registered users sign to update
+	// their bundles.
+	owners *ownerProof
+
+	// restrictions holds suspended and shadow-banned users. Expired entries
+	// are ignored and dropped when the state log is compacted.
+	restrictions map[string]restriction
+
+	// expired counts, per user, envelopes dropped unfetched because their
+	// expiry passed. It is reported and reset on the next fetch, and kept in
+	// memory only.
+	expired map[string]int
+
+	// expiredSent lists, per sender, the IDs of envelopes it sent that
+	// expired unfetched, at most maxExpiredSent. They are reported and reset
+	// on the sender's next fetch, and kept in memory only.
+	expiredSent map[string][]string
+
+	// acked holds, per user, acked envelopes kept for ackRetention in
+	// arrival-of-ack order; zero retention discards envelopes when acked.
+	// Tombstones are kept in memory only.
+	acked        map[string][]tombstone
+	ackRetention time.Duration
+
+	// chaos injects drops, delays and duplicates into delivery; nil when
+	// off.
+	chaos *chaos
+
+	// usage counts each user's traffic by month.
+	usage *usageMeter
+
+	// journal records what happened to the envelopes in each queue, for
+	// the queue's owner to audit; nil when state is kept in memory only.
+	journal *queueJournal
+
+	// presence holds each user's presence policy, and lastSeen when users
+	// whose policy shows it last fetched their messages, to the minute.
+	// lastSeen is kept in memory only.
+	presence map[string]domain.PresencePolicy
+	lastSeen map[string]int64
+
+	// fingerprints maps the fingerprint of each registered identity key to
+	// the user who registered it, for fp: addresses (see resolve). It is
+	// rebuilt from the bundles on start.
+	fingerprints map[string]string
+
+	// handedOut holds, per user, the IDs of one-time prekeys checked out
+	// that the user's last registered bundle still offered (see
+	// withholdHandedOut). It is kept in memory only.
+	handedOut map[string]map[domain.KeyID]bool
+
+	// checkouts holds, per user, the times of the latest one-time prekeys
+	// handed out, at most checkoutLimit.Max (see checkoutAllowed). It is
+	// kept in memory only.
+	checkouts     map[string][]time.Time
+	checkoutLimit CheckoutLimit
+
+	// push hands newly queued envelopes to recipients' open push
+	// connections (see handlePush).
+	push *pushHub
+
+	// arrivals wakes fetches waiting for envelopes to each user (see
+	// arrivalLocked). Once draining, fetches no longer wait.
+	arrivals map[string]chan struct{}
+	draining bool
+
+	logs
+}
+
+// newState initialises an empty relay state that reports events to hooks.
+func newState(hooks *webhookService, l logs) *state {
+	return &state{
+		logs:         l,
+		bundles:      make(map[string]domain.PrekeyBundle),
+		queues:       make(map[string][]domain.Envelope),
+		backups:      make(map[string]domain.RelayBackup),
+		hooks:        hooks,
+		restrictions: make(map[string]restriction),
+		expired:      make(map[string]int),
+		expiredSent:  make(map[string][]string),
+		acked:        make(map[string][]tombstone),
+		usage:        newUsageMeter(),
+		presence:     make(map[string]domain.PresencePolicy),
+		lastSeen:     make(map[string]int64),
+		fingerprints: make(map[string]string),
+		handedOut:    make(map[string]map[domain.KeyID]bool),
+		checkouts:    make(map[string][]time.Time),
+		push:         newPushHub(),
+		arrivals:     make(map[string]chan struct{}),
+		owners:       newOwnerProof(),
+	}
+}
+
+// loggingResponseWriter captures status code and byte count for access logs.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+// --- Middleware ---
+
+// withRecover wraps a handler to convert panics into 500 responses.
+func (l logs) withRecover(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				writeErr(w, http.StatusInternalServerError, domain.RelayCodeInternal, "internal error")
+				l.log.Error("panic", "err", rec)
+			}
+		}()
+		h(w, r)
+	}
+}
+
+// withReqID ensures each request has an ID for tracing.
+func withReqID(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if id == "" {
+			id = genReqID()
+		}
+		w.Header().Set("X-Request-Id", id)
+		ctx := context.WithValue(r.Context(), ctxKeyReqID, id)
+		h(w, r.WithContext(ctx))
+	}
+}
+
+// withLogging logs method, path, remote, status, bytes, duration and request ID.
+func (l logs) withLogging(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if l.accessLog == discardLogger {
+			h(w, r)
+			return
+		}
+		start := time.Now()
+		lrw := &loggingResponseWriter{ResponseWriter: w}
+		h(lrw, r)
+		reqID := requestIDFromCtx(r.Context())
+		l.accessLog.Info("access",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"proto", r.Proto,
+			"remote", clientIP(r),
+			"status", lrw.status,
+			"bytes", lrw.bytes,
+			"dur", time.Since(start),
+			"reqid", reqID,
+		)
+	}
+}
+
+// chain composes middlewares in order.
+func chain(h http.HandlerFunc, mws ...func(http.HandlerFunc) http.HandlerFunc) http.HandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// --- Utilities ---
+
+// WriteHeader records the status code then forwards to the underlying writer.
+func (lrw *loggingResponseWriter) WriteHeader(code int) {
+	lrw.status = code
+	lrw.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap returns the underlying writer, so http.ResponseController reaches
+// its Hijack and Flush methods.
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
+// Write records the bytes written and defaults status to 200 if unset.
+func (lrw *loggingResponseWriter) Write(p []byte) (int, error) {
+	if lrw.status == 0 {
+		lrw.status = http.StatusOK
+	}
+	n, err := lrw.ResponseWriter.Write(p)
+	lrw.bytes += n
+	return n, err
+}
+
+// isZero32 checks whether a 32-byte slice is all zeros in constant time.
+func isZero32(b []byte) bool {
+	if len(b) != 32 {
+		return false
+	}
+	var zero [32]byte
+	return subtle.ConstantTimeCompare(b, zero[:]) == 1
+}
+
+// writeJSON encodes v as JSON with no HTML escaping.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		// Best effort error path.
+		http.Error(w, fmt.Sprintf("encode error: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// writeErr writes a structured error, {"error": domain.RelayError}, with the
+// given status. details are key/value pairs, as for slog, and an odd one out
+// is dropped.
+func writeErr(w http.ResponseWriter, status int, code domain.RelayErrorCode, msg string, details ...string) {
+	writeErrBody(w, status, newRelayError(code, msg, details...), nil)
+}
+
+// newRelayError builds the domain.RelayError for code, msg and the key/value
+// pairs in details.
+func newRelayError(code domain.RelayErrorCode, msg string, details ...string) domain.RelayError {
+	e := domain.RelayError{Code: code, Message: msg, Retryable: code.Retryable()}
+	for i := 0; i+1 < len(details); i += 2 {
+		if e.Details == nil {
+			e.Details = make(map[string]string, len(details)/2)
+		}
+		e.Details[details[i]] = details[i+1]
+	}
+	return e
+}
+
+// writeErrBody writes e with the given status, and the fields of extra, if
+// any, next to it.
+func writeErrBody(w http.ResponseWriter, status int, e domain.RelayError, extra map[string]any) {
+	body := map[string]any{"error": e}
+	for k, v := range extra {
+		body[k] = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// parseLimit parses the optional "limit" query parameter.
+func parseLimit(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid limit")
+	}
+	return n, nil
+}
+
+// clientIP extracts the client IP from headers or RemoteAddr.
+func clientIP(r *http.Request) string {
+	// Respect common proxy headers. This is best-effort.
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		// First hop is the client.
+		if i := indexByte(xff, ','); i >= 0 {
+			return trimSpace(xff[:i])
+		}
+		return trimSpace(xff)
+	}
+	if xr := r.Header.Get("X-Real-IP"); xr != "" {
+		return xr
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestIDFromCtx returns the request ID if present.
+func requestIDFromCtx(ctx context.Context) string {
+	if v, ok := ctx.Value(ctxKeyReqID).(string); ok {
+		return v
+	}
+	return ""
+}
+
+// genReqID creates a simple 128-bit random hex ID.
+func genReqID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// Fallback to timestamp based if rand fails.
+		return fmt.Sprintf("req-%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Small helpers without extra imports.
+func indexByte(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+func trimSpace(s string) string {
+	// Minimal trim to avoid extra import.
+	for len(s) > 0 && (s[0] == ' ' || s[0] == '\t') {
+		s = s[1:]
+	}
+	for len(s) > 0 && (s[len(s)-1] == ' ' || s[len(s)-1] == '\t') {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// checkKeyIDs reports whether the prekey IDs in b follow the key ID scheme
+// (see package keyid), and if not, the field at fault. The relay does not
+// require a signed prekey, but refuses one named malformed, and one-time
+// prekeys that are malformed or named alike.
+func checkKeyIDs(b domain.PrekeyBundle) (field string, ok bool) {
+	if b.SPKID != "" && keyid.Check(keyid.SignedPrekey, b.SPKID) != nil {
+		return "spk_id", false
+	}
+	return "one_time", keyid.CheckOneTime(b.OneTime) == nil
+}
+
+// --- Handlers ---
+
+// handleRegister stores an incoming PrekeyBundle (POST /register). A new
+// username must answer the relay's registration challenge, if it has one,
+// and an identity key another user registered is refused, so nobody can take
+// over its fp: address.
+func (s *state) handleRegister(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	body := &countingReader{ReadCloser: http.MaxBytesReader(w, r.Body, maxRequestBody)}
+	r.Body = body
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var bundle domain.PrekeyBundle
+	if err := dec.Decode(&bundle); err != nil {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "bad request")
+		return
+	}
+	if bundle.Username == "" {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeMissingField, "username required", "field", "username")
+		return
+	}
+	if strings.HasPrefix(bundle.Username, address.FingerprintPrefix) {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeUsernameReserved, "username reserved for fingerprint addresses")
+		return
+	}
+	if len(bundle.OneTime) > maxOneTimeKeys {
+		writeErr(w, http.StatusRequestEntityTooLarge, domain.RelayCodeTooLarge, "too many one-time keys", "field", "one_time", "limit", strconv.Itoa(maxOneTimeKeys))
+		return
+	}
+	if len(bundle.Capabilities) > maxCapabilities {
+		writeErr(w, http.StatusRequestEntityTooLarge, domain.RelayCodeTooLarge, "too many capabilities", "field", "capabilities", "limit", strconv.Itoa(maxCapabilities))
+		return
+	}
+	if len(bundle.Suites) > maxSuites {
+		writeErr(w, http.StatusRequestEntityTooLarge, domain.RelayCodeTooLarge, "too many suites", "field", "suites", "limit", strconv.Itoa(maxSuites))
+		return
+	}
+	if len(bundle.Attestations) > maxAttestations {
+		writeErr(w, http.StatusRequestEntityTooLarge, domain.RelayCodeTooLarge, "too many attestations", "field", "attestations", "limit", strconv.Itoa(maxAttestations))
+		return
+	}
+	if field, ok := checkKeyIDs(bundle); !ok {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeInvalidParameter, "malformed key ID", "param", field)
+		return
+	}
+
+	// Updates must be signed by the username's owner; new usernames must
+	// answer the relay's challenge, if it has one.
+	s.mu.RLock()
+	checked, known := s.bundles[bundle.Username]
+	s.mu.RUnlock()
+	switch {
+	case known:
+		if !s.admitOwner(w, r, checked, bundle) {
+			return
+		}
+	case s.challenge != nil:
+		if !s.admitRegistration(w, r, bundle.Username) {
+			return
+		}
+	}
+
+	s.mu.Lock()
+	prev, existed := s.bundles[bundle.Username]
+	if existed != known || prev.SignKey != checked.SignKey {
+		// Someone registered the username, or its owner rotated their
+		// key, since it was checked: prove ownership of what is there now.
+		s.mu.Unlock()
+		s.writeChallenge(w, r, bundle.Username, s.owners.issue(bundle.Username), false)
+		return
+	}
+	if s.fingerprintTaken(bundle) {
+		s.mu.Unlock()
+		writeErr(w, http.StatusConflict, domain.RelayCodeIdentityTaken, "identity key registered by another user")
+		return
+	}
+	withheld := s.withholdHandedOut(&bundle)
+	if err := s.store.registered(bundle, existed); err != nil {
+		s.mu.Unlock()
+		writeErr(w, http.StatusInternalServerError, domain.RelayCodeStorage, "storage error")
+		s.logStorageErr(r, "register_store", err)
+		return
+	}
+	if existed && prev.IdentityKey != bundle.IdentityKey {
+		s.unindexFingerprint(prev)
+	}
+	s.bundles[bundle.Username] = bundle
+	if !isZero32(bundle.IdentityKey[:]) {
+		s.fingerprints[crypto.Fingerprint(bundle.IdentityKey.Slice())] = bundle.Username
+	}
+	s.compactIfNeeded()
+	s.mu.Unlock()
+
+	if !existed {
+		s.hooks.registered(bundle.Username)
+	}
+	s.usage.countIn(bundle.Username, body.n, 0, time.Now())
+
+	s.accessLog.Info("register",
+		"user", bundle.Username,
+		"identity_key_set", !isZero32(bundle.IdentityKey[:]),
+		"sign_key_set", !isZero32(bundle.SignKey[:]),
+		"spk_id", bundle.SPKID,
+		"one_time_count", len(bundle.OneTime),
+		"one_time_withheld", withheld,
+		"reqid", requestIDFromCtx(r.Context()),
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGet returns a stored PrekeyBundle (GET /prekey/{username}). Its
+// one-time prekeys are left out unless the request is signed by the owner:
+// others get them one at a time from handleCheckout, which limits how fast
+// they go. The username may be an fp: address (see resolve).
+func (s *state) handleGet(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	if username == "" {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeMissingField, "username required", "field", "username")
+		return
+	}
+
+	s.mu.RLock()
+	username, ok := s.resolve(username)
+	bundle, registered := s.bundles[username]
+	s.mu.RUnlock()
+	if !ok {
+		writeErr(w, http.StatusNotFound, domain.RelayCodeUnknownFingerprint, "unknown fingerprint")
+		return
+	}
+	if !registered {
+		writeErr(w, http.StatusNotFound, domain.RelayCodeUserNotFound, "user not registered", "user", username)
+		return
+	}
+
+	owner := s.ownerSigned(r, username)
+	s.accessLog.Info(
+		"prekey_fetch",
+		"user", username,
+		"spk_id", bundle.SPKID,
+		"one_time_count", len(bundle.OneTime),
+		"owner", owner,
+		"reqid", requestIDFromCtx(r.Context()),
+	)
+	if !owner {
+		bundle.OneTime = nil
+	}
+	writeJSON(w, bundle)
+}
+
+// handleCheckout hands out one of {username}'s one-time prekeys
+// (POST /prekey/{username}/checkout): it removes the first from the stored
+// bundle and returns the bundle with that key alone, or with none once they
+// have run out or the checkout limit is reached (see checkoutAllowed). The
+// removal is persisted before the key is returned, so no two initiators are
+// given the same key. The username may be an fp: address (see resolve).
+func (s *state) handleCheckout(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	if username == "" {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeMissingField, "username required", "field", "username")
+		return
+	}
+
+	s.mu.Lock()
+	username, ok := s.resolve(username)
+	bundle, registered := s.bundles[username]
+	if !ok || !registered {
+		s.mu.Unlock()
+		if !ok {
+			writeErr(w, http.StatusNotFound, domain.RelayCodeUnknownFingerprint, "unknown fingerprint")
+			return
+		}
+		writeErr(w, http.StatusNotFound, domain.RelayCodeUserNotFound, "user not registered", "user", username)
+		return
+	}
+	var out []domain.OneTimePub
+	throttled := len(bundle.OneTime) > 0 && !s.checkoutAllowed(username, time.Now())
+	if len(bundle.OneTime) > 0 && !throttled {
+		out = bundle.OneTime[:1]
+		left := bundle
+		left.OneTime = slices.Clone(bundle.OneTime[1:])
+		if err := s.store.registered(left, true); err != nil {
+			s.mu.Unlock()
+			writeErr(w, http.StatusInternalServerError, domain.RelayCodeStorage, "storage error")
+			s.logStorageErr(r, "checkout_store", err)
+			return
+		}
+		s.bundles[username] = left
+		s.handOut(username, out[0].ID)
+		s.compactIfNeeded()
+	}
+	s.mu.Unlock()
+
+	var opkID domain.KeyID
+	if len(out) > 0 {
+		opkID = out[0].ID
+	}
+	s.accessLog.Info(
+		"prekey_checkout",
+		"user", username,
+		"spk_id", bundle.SPKID,
+		"opk_id", opkID,
+		"one_time_left", len(bundle.OneTime)-len(out),
+		"throttled", throttled,
+		"reqid", requestIDFromCtx(r.Context()),
+	)
+	bundle.OneTime = out
+	writeJSON(w, bundle)
+}
+
+// handOut records that the one-time prekey id of user was checked out, so
+// a bundle user registers later cannot offer it again. The caller holds
+// s.mu for writing.
+func (s *state) handOut(user string, id domain.KeyID) {
+	if s.handedOut[user] == nil {
+		s.handedOut[user] = make(map[domain.KeyID]bool)
+	}
+	s.handedOut[user][id] = true
+}
+
+// withholdHandedOut removes from bundle the one-time prekeys that were
+// checked out, which its owner may still hold if the initiators have not
+// sent their first messages yet, and returns how many it removed. IDs the
+// bundle no longer carries are forgotten: the owner has consumed those keys
+// and will not publish them again. The caller holds s.mu for writing.
+func (s *state) withholdHandedOut(bundle *domain.PrekeyBundle) int {
+	out := s.handedOut[bundle.Username]
+	if len(out) == 0 {
+		return 0
+	}
+	kept := make(map[domain.KeyID]bool)
+	bundle.OneTime = slices.DeleteFunc(slices.Clone(bundle.OneTime), func(k domain.OneTimePub) bool {
+		if out[k.ID] {
+			kept[k.ID] = true
+			return true
+		}
+		return false
+	})
+	if len(kept) == 0 {
+		delete(s.handedOut, bundle.Username)
+	} else {
+		s.handedOut[bundle.Username] = kept
+	}
+	return len(kept)
+}
+
+// discoveryHandler answers GET /.well-known/ciphera-relay with base.
+func discoveryHandler(base string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, struct {
+			Relay string `json:"relay"`
+		}{base})
+	}
+}
+
+// Info is the build information with the optional relay features an instance
+// serves as its capabilities, rather than the client's. attachments reports
+// whether a blob backend is configured.
+func Info(attachments bool) domain.BuildInfo {
+	info := buildinfo.Get()
+	info.Capabilities = nil
+	if attachments {
+		info.Capabilities = append(info.Capabilities, caps.Attachments)
+	}
+	return info
+}
+
+// handleEnqueue enqueues a new Envelope (POST /msg/{user}) and returns its
+// sequence number. The user may be an fp: address (see resolve).
+func (s *state) handleEnqueue(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	body := &countingReader{ReadCloser: http.MaxBytesReader(w, r.Body, maxRequestBody)}
+	r.Body = body
+
+	user := r.PathValue("user")
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var env domain.Envelope
+	if err := dec.Decode(&env); err != nil {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "bad request")
+		return
+	}
+	if env.To == "" {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeMissingField, "recipient required", "field", "to")
+		return
+	}
+	// Prevent route and payload mismatch.
+	if user == "" || user != env.To {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeRecipientMismatch, "recipient mismatch")
+		return
+	}
+	// Basic payload caps and sanity checks.
+	if len(env.Cipher) > maxCipherBytes {
+		writeErr(w, http.StatusRequestEntityTooLarge, domain.RelayCodeTooLarge, "cipher too large", "field", "cipher", "limit", strconv.Itoa(maxCipherBytes))
+		return
+	}
+	if env.Timestamp == 0 {
+		env.Timestamp = time.Now().Unix()
+	} else {
+		now := time.Now()
+		ts := time.Unix(env.Timestamp, 0)
+		if ts.After(now.Add(maxFutureSkew)) {
+			writeErr(w, http.StatusBadRequest, domain.RelayCodeFutureTimestamp, "timestamp in future", "max_skew", maxFutureSkew.String())
+			return
+		}
+	}
+	if env.ExpiresUTC != 0 && time.Now().Unix() >= env.ExpiresUTC {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeExpired, "envelope already expired")
+		return
+	}
+
+	// Fingerprint addresses are queued for the user who registered the key.
+	// The sender is resolved too, so its restrictions and usage apply.
+	s.mu.Lock()
+	user, ok := s.resolve(user)
+	if !ok {
+		s.mu.Unlock()
+		writeErr(w, http.StatusNotFound, domain.RelayCodeUnknownFingerprint, "unknown fingerprint")
+		return
+	}
+	sender := env.From
+	if from, ok := s.resolve(env.From); ok {
+		sender = from
+	}
+
+	// Suspended accounts are refused; shadow-banned ones are answered as if
+	// the envelope was queued (see restrictionMode).
+	switch s.restrictionMode(sender, user, time.Now()) {
+	case restrictSuspend:
+		s.mu.Unlock()
+		writeErr(w, http.StatusForbidden, domain.RelayCodeSuspended, "account suspended")
+		s.accessLog.Info("enqueue_refused", "from", env.From, "to", user, "reqid", requestIDFromCtx(r.Context()))
+		return
+	case restrictShadowBan:
+		// The sequence number is handed out and stored as for a real
+		// envelope, so the sender cannot tell and it is never reused after a
+		// restart.
+		s.dropEnqueue(w, r, "enqueue_shadow_dropped", env.From, user)
+		return
+	}
+	if s.chaos.drop() {
+		// Lost in transit: answered exactly like a shadow-banned sender.
+		s.dropEnqueue(w, r, "enqueue_chaos_dropped", env.From, user)
+		return
+	}
+
+	// Assign a relay-wide sequence ID (replacing any client-supplied one) and
+	// append under the per-user and per-sender caps (see enqueueFair).
+	// Dropped envelopes are reported as dead letters. The queue is only
+	// replaced once the change is stored.
+	env.ID = strconv.FormatUint(s.nextSeq+1, 10)
+	before := len(s.queues[user])
+	q, dead := enqueueFair(slices.Clone(s.queues[user]), env)
+	if err := s.store.enqueued(env, dead); err != nil {
+		s.mu.Unlock()
+		writeErr(w, http.StatusInternalServerError, domain.RelayCodeStorage, "storage error")
+		s.logStorageErr(r, "enqueue_store", err)
+		return
+	}
+	s.nextSeq++
+	seq := s.nextSeq
+	now := time.Now()
+	s.journal.add(user, domain.JournalEnqueue, []domain.Envelope{env}, now)
+	s.journal.add(user, domain.JournalDrop, pick(dead, s.queues[user], []domain.Envelope{env}), now)
+	s.queues[user] = q
+	s.arrivedLocked(user)
+	qLen := len(q)
+	s.compactIfNeeded()
+	s.mu.Unlock()
+	s.chaos.hold(env.ID, time.Now())
+	if len(s.chaos.visible([]domain.Envelope{env}, time.Now())) == 1 {
+		s.push.publish(user, env)
+	}
+	s.usage.countIn(sender, body.n, 1, time.Now())
+
+	s.hooks.queueGrew(user, before, qLen)
+	setSpanInt(r.Context(), spanQueueDepth, qLen)
+	s.hooks.deadLettered(user, dead)
+
+	s.accessLog.Info("enqueue",
+		"queue_user", user,
+		"id", env.ID,
+		"from", env.From,
+		"to", env.To,
+		"cipher_bytes", len(env.Cipher),
+		"has_prekey", env.Prekey != nil,
+		"queue_len", qLen,
+		"reqid", requestIDFromCtx(r.Context()),
+	)
+	writeJSON(w, enqueueResponse{Seq: seq})
+}
+
+// dropEnqueue answers an envelope that is dropped rather than queued. It
+// hands out and stores the next sequence number, as for a real envelope, so
+// the sender cannot tell the difference. It is called with s.mu held and
+// releases it.
+func (s *state) dropEnqueue(w http.ResponseWriter, r *http.Request, event, from, user string) {
+	if err := s.store.sequenced(s.nextSeq + 1); err != nil {
+		s.mu.Unlock()
+		writeErr(w, http.StatusInternalServerError, domain.RelayCodeStorage, "storage error")
+		s.logStorageErr(r, "enqueue_store", err)
+		return
+	}
+	s.nextSeq++
+	seq := s.nextSeq
+	s.mu.Unlock()
+	s.accessLog.Info(event, "from", from, "to", user, "reqid", requestIDFromCtx(r.Context()))
+	writeJSON(w, enqueueResponse{Seq: seq})
+}
+
+// enqueueResponse reports the sequence number assigned to an enqueued
+// envelope; its ID is the same number in decimal.
+type enqueueResponse struct {
+	Seq uint64 `json:"seq"`
+}
+
+// handleFetch fetches queued Envelopes (GET /msg/{user}?limit=N), round-robin
+// across senders.
+//
+// Expired envelopes are dropped first. The number dropped since the last
+// fetch is sent in the X-Ciphera-Expired header, and the IDs of envelopes
+// user sent that expired unfetched in X-Ciphera-Expired-Sent; both are then
+// reset. With
+// include_acked=1, envelopes acked within Options.AckRetention are returned
+// too, with acked_utc set. Options.Chaos may hold envelopes back or return
+// them twice. A fetch signed by the user (see package relayauth) counts as
+// their last activity if their presence policy shows it; anyone may fetch
+// unsigned, so an unsigned fetch does not.
+//
+// With wait=D (a duration such as 30s, at most maxFetchWait), a fetch that
+// finds nothing to return, envelopes or expiry reports, waits up to D for an
+// envelope to be queued before answering, empty if none was. Envelopes
+// Options.Chaos holds back do not end the wait.
+func (s *state) handleFetch(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	signed := s.ownerSigned(r, user)
+
+	limit, err := parseLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeInvalidParameter, "bad limit", "param", "limit")
+		return
+	}
+	includeAcked := false
+	if v := r.URL.Query().Get(includeAckedParam); v != "" {
+		if includeAcked, err = strconv.ParseBool(v); err != nil {
+			writeErr(w, http.StatusBadRequest, domain.RelayCodeInvalidParameter, "bad include_acked", "param", "include_acked")
+			return
+		}
+	}
+	wait, err := parseWait(r.URL.Query().Get(waitParam))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeInvalidParameter, "bad wait", "param", waitParam)
+		return
+	}
+	deadline := time.Now().Add(wait)
+	if wait > 0 {
+		holdWrite(w, wait)
+	}
+
+	// Copy under lock to avoid races with concurrent enqueue/ack. Senders
+	// are interleaved so one busy sender cannot fill every fetch. With
+	// nothing to return and time left to wait, wait for an arrival.
+	s.mu.Lock()
+	var ready []domain.Envelope
+	for {
+		if err := s.expireLocked(user, time.Now()); err != nil {
+			s.mu.Unlock()
+			writeErr(w, http.StatusInternalServerError, domain.RelayCodeStorage, "storage error")
+			s.logStorageErr(r, "expire_store", err)
+			return
+		}
+		queue := s.queues[user]
+		if includeAcked {
+			s.purgeAckedLocked(user, time.Now())
+			queue = s.withAcked(user)
+		}
+		ready = s.chaos.visible(queue, time.Now())
+		left := time.Until(deadline)
+		if len(ready) > 0 || s.expired[user] > 0 || len(s.expiredSent[user]) > 0 || left <= 0 || s.draining {
+			break
+		}
+		arrived := s.arrivalLocked(user)
+		s.mu.Unlock()
+		t := time.NewTimer(left)
+		select {
+		case <-arrived:
+		case <-t.C:
+		case <-r.Context().Done():
+			t.Stop()
+			return
+		}
+		t.Stop()
+		s.mu.Lock()
+	}
+	expired := s.expired[user]
+	delete(s.expired, user)
+	expiredSent := s.expiredSent[user]
+	delete(s.expiredSent, user)
+	out := s.chaos.duplicate(fairOrder(ready, limit))
+	available := len(s.queues[user])
+	s.journal.add(user, domain.JournalFetch, out, time.Now())
+	if signed {
+		s.seenLocked(user, time.Now())
+	}
+	s.mu.Unlock()
+	setSpanInt(r.Context(), spanQueueDepth, available)
+
+	if expired > 0 {
+		w.Header().Set(expiredHeader, strconv.Itoa(expired))
+	}
+	if len(expiredSent) > 0 {
+		w.Header().Set(expiredSentHeader, strings.Join(expiredSent, ","))
+	}
+	cw := &countingWriter{ResponseWriter: w}
+	writeJSON(cw, out)
+	s.usage.countOut(user, cw.n, len(out), time.Now())
+
+	s.accessLog.Info("fetch", "user", user, "limit", len(out), "available", available, "expired", expired, "expired_sent", len(expiredSent), "include_acked", includeAcked, "reqid", requestIDFromCtx(r.Context()))
+}
+
+// handleAck drops the listed envelopes (POST /msg/{user}/ack).
+//
+// Acks name envelopes by ID, so messages that arrive between a fetch and its
+// ack are never dropped by accident. Unknown IDs (e.g. already acked) are
+// ignored. With Options.AckRetention set, acked envelopes are tombstoned
+// rather than discarded (see tombstoneLocked).
+func (s *state) handleAck(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+
+	user := r.PathValue("user")
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var ack struct {
+		IDs []string `json:"ids"`
+	}
+	if err := dec.Decode(&ack); err != nil {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "bad request")
+		return
+	}
+	drop := make(map[string]struct{}, len(ack.IDs))
+	for _, id := range ack.IDs {
+		drop[id] = struct{}{}
+	}
+
+	s.mu.Lock()
+	queue := s.queues[user]
+	kept := make([]domain.Envelope, 0, len(queue))
+	var (
+		gone  []string
+		acked []domain.Envelope
+	)
+	for _, env := range queue {
+		if _, ok := drop[env.ID]; ok {
+			gone = append(gone, env.ID)
+			acked = append(acked, env)
+		} else {
+			kept = append(kept, env)
+		}
+	}
+	if err := s.store.dropped(user, gone); err != nil {
+		s.mu.Unlock()
+		writeErr(w, http.StatusInternalServerError, domain.RelayCodeStorage, "storage error")
+		s.logStorageErr(r, "ack_store", err)
+		return
+	}
+	s.queues[user] = kept
+	s.tombstoneLocked(user, acked, time.Now())
+	s.journal.add(user, domain.JournalAck, acked, time.Now())
+	dropped := len(gone)
+	remaining := len(kept)
+	s.compactIfNeeded()
+	s.mu.Unlock()
+	setSpanInt(r.Context(), spanQueueDepth, remaining)
+
+	s.accessLog.Info("ack",
+		"user", user,
+		"requested", len(ack.IDs),
+		"drop", dropped,
+		"remaining", remaining,
+		"reqid", requestIDFromCtx(r.Context()),
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleQueueStats reports how many envelopes are queued for a user and the
+// fetched watermark (GET /msg/{user}/stats?from=sender), both counting only
+// the sender's envelopes when from is given.
+func (s *state) handleQueueStats(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	from := r.URL.Query().Get("from")
+
+	s.mu.RLock()
+	q := s.queues[user]
+	out := domain.QueueStats{FetchedSeq: fetchedWatermark(q, from, s.nextSeq)}
+	for _, e := range q {
+		if from == "" || e.From == from {
+			out.Queued++
+		}
+	}
+	s.mu.RUnlock()
+
+	writeJSON(w, out)
+}
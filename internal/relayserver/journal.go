@@ -0,0 +1,263 @@
+package relayserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// Queue journal.
+const (
+	journalDir       = "journal"
+	journalSuffix    = ".jsonl"
+	journalMaxEvents = 10000 // events kept per user; older ones are trimmed
+	journalPageSize  = 1000  // events per journal response
+)
+
+// queueJournal keeps, per user, an append-only journal of what happened to
+// the envelopes in their queue: queued, fetched, acked, dropped or expired
+// (see domain.JournalEvent). The account owner reads it through a signed
+// request to audit whether the relay dropped, altered or replayed their
+// traffic. Envelopes are named by ID and ciphertext hash only.
+//
+// Each user's journal is a file of JSON lines in <DataDir>/journal, named
+// after the hex-encoded username. Once it holds twice journalMaxEvents
+// events, the oldest are trimmed down to journalMaxEvents; the rest keep
+// their numbers, so a client sees where its journal starts. Journaling never
+// fails a request. A nil *queueJournal keeps nothing, which is how the relay
+// runs without --data-dir.
+type queueJournal struct {
+	dir string
+
+	mu    sync.Mutex
+	users map[string]*journalFile // opened on first use
+
+	logs
+}
+
+// journalFile is one user's open journal.
+type journalFile struct {
+	f      *os.File
+	next   uint64 // number of the next event
+	events int    // events in the file
+}
+
+// openJournal returns a journal keeping its files in dir/journal.
+func openJournal(dir string, l logs) (*queueJournal, error) {
+	dir = filepath.Join(dir, journalDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &queueJournal{dir: dir, users: make(map[string]*journalFile), logs: l}, nil
+}
+
+// add appends an event of kind for each of envs to user's journal.
+func (j *queueJournal) add(user string, kind domain.JournalKind, envs []domain.Envelope, now time.Time) {
+	if j == nil || len(envs) == 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.addLocked(user, kind, envs, now); err != nil {
+		j.log.Error("journal_store", "user", user, "kind", kind, "error", err)
+	}
+}
+
+// addLocked appends the events and trims the journal if it has grown past
+// twice journalMaxEvents. The caller holds j.mu.
+func (j *queueJournal) addLocked(user string, kind domain.JournalKind, envs []domain.Envelope, now time.Time) error {
+	jf, err := j.fileLocked(user)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, env := range envs {
+		hash := sha256.Sum256(env.Cipher)
+		ev := domain.JournalEvent{N: jf.next, TimeUTC: now.Unix(), Kind: kind, ID: env.ID, Hash: hash[:]}
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+		jf.next++
+	}
+	if _, err := jf.f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	jf.events += len(envs)
+	if jf.events > 2*journalMaxEvents {
+		return j.trimLocked(user, jf)
+	}
+	return nil
+}
+
+// fileLocked returns user's journal, opening it and reading where it left
+// off if needed. The caller holds j.mu.
+func (j *queueJournal) fileLocked(user string) (*journalFile, error) {
+	if jf, ok := j.users[user]; ok {
+		return jf, nil
+	}
+	events, err := j.readLocked(user)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(j.path(user), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	jf := &journalFile{f: f, next: 1, events: len(events)}
+	if len(events) > 0 {
+		jf.next = events[len(events)-1].N + 1
+	}
+	j.users[user] = jf
+	return jf, nil
+}
+
+// trimLocked rewrites user's journal with only its newest journalMaxEvents
+// events. The file is replaced by rename, so a crash leaves the old or new
+// journal. The caller holds j.mu.
+func (j *queueJournal) trimLocked(user string, jf *journalFile) error {
+	events, err := j.readLocked(user)
+	if err != nil {
+		return err
+	}
+	events = events[max(len(events)-journalMaxEvents, 0):]
+	var buf bytes.Buffer
+	for _, ev := range events {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	path := j.path(user)
+	tmp, err := os.CreateTemp(j.dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	_ = jf.f.Close()
+	if jf.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+		delete(j.users, user)
+		return err
+	}
+	jf.events = len(events)
+	j.accessLog.Info("journal_trim", "user", user, "kept", len(events))
+	return nil
+}
+
+// readLocked returns every event in user's journal, oldest first. A torn or
+// corrupt line, left by a crash mid-write, is skipped. The caller holds j.mu.
+func (j *queueJournal) readLocked(user string) ([]domain.JournalEvent, error) {
+	raw, err := os.ReadFile(j.path(user))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var events []domain.JournalEvent
+	sc := bufio.NewScanner(bytes.NewReader(raw))
+	for sc.Scan() {
+		var ev domain.JournalEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil || ev.N == 0 {
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, sc.Err()
+}
+
+// page returns up to journalPageSize of user's events numbered after after.
+func (j *queueJournal) page(user string, after uint64) ([]domain.JournalEvent, error) {
+	j.mu.Lock()
+	events, err := j.readLocked(user)
+	j.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	out := []domain.JournalEvent{}
+	for _, ev := range events {
+		if ev.N > after && len(out) < journalPageSize {
+			out = append(out, ev)
+		}
+	}
+	return out, nil
+}
+
+// close closes every open journal file.
+func (j *queueJournal) close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var errs []error
+	for user, jf := range j.users {
+		errs = append(errs, jf.f.Close())
+		delete(j.users, user)
+	}
+	return errors.Join(errs...)
+}
+
+// path returns the file of user's journal.
+func (j *queueJournal) path(user string) string {
+	return filepath.Join(j.dir, hex.EncodeToString([]byte(user))+journalSuffix)
+}
+
+// handleJournal returns a page of a user's queue journal, oldest first
+// (GET /account/{user}/journal?after=N). The request must be signed as for
+// handleUsage. A relay without a data directory keeps no journal and answers
+// 404.
+func (s *state) handleJournal(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+
+	var after uint64
+	if v := r.URL.Query().Get("after"); v != "" {
+		var err error
+		if after, err = strconv.ParseUint(v, 10, 64); err != nil {
+			writeErr(w, http.StatusBadRequest, domain.RelayCodeInvalidParameter, "bad after", "param", "after")
+			return
+		}
+	}
+	if !s.authorizeAccount(w, r, user, "journal_refused") {
+		return
+	}
+	if s.journal == nil {
+		writeErr(w, http.StatusNotFound, domain.RelayCodeNotFound, "no journal kept")
+		return
+	}
+
+	out, err := s.journal.page(user, after)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, domain.RelayCodeStorage, "storage error")
+		s.logStorageErr(r, "journal_store", err)
+		return
+	}
+	s.accessLog.Info("journal", "user", user, "after", after, "events", len(out), "reqid", requestIDFromCtx(r.Context()))
+	writeJSON(w, out)
+}
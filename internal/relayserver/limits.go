@@ -0,0 +1,174 @@
+package relayserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// DefaultLimitWait is how long a request waits for a slot when its
+// RouteLimit sets no Wait.
+const DefaultLimitWait = 5 * time.Second
+
+// RouteLimit bounds how many requests to one route the relay handles at
+// once. Requests beyond Max wait, up to Queue of them and for at most Wait
+// each, for one in progress to finish; the rest are refused with 503 and
+// RelayCodeBusy, so a burst on one route cannot exhaust the relay for the
+// others.
+type RouteLimit struct {
+	Max   int           // requests handled at once
+	Queue int           // requests waiting for a slot
+	Wait  time.Duration // longest wait for a slot; zero means DefaultLimitWait
+}
+
+// DefaultRouteLimits returns the limits cmd/relay applies unless told
+// otherwise: registration, which verifies signatures and answers to the
+// registration challenge, and backup uploads, the largest bodies the relay
+// reads.
+func DefaultRouteLimits() map[string]RouteLimit {
+	return map[string]RouteLimit{
+		"POST /register":     {Max: 8, Queue: 32},
+		"PUT /backup/{user}": {Max: 8, Queue: 32},
+	}
+}
+
+// validate checks l's bounds.
+func (l RouteLimit) validate() error {
+	if l.Max < 1 {
+		return fmt.Errorf("at most %d requests at once; want at least 1", l.Max)
+	}
+	if l.Queue < 0 || l.Wait < 0 {
+		return fmt.Errorf("negative queue %d or wait %v", l.Queue, l.Wait)
+	}
+	return nil
+}
+
+// limiter enforces a RouteLimit: slots holds a token per request in
+// progress, and waiting counts those queued for one.
+type limiter struct {
+	route      string
+	registered bool // a route with this pattern exists
+	slots      chan struct{}
+	queue      int64
+	wait       time.Duration
+	waiting    atomic.Int64
+
+	logs
+}
+
+// newLimiter returns the limiter for route under lim.
+func newLimiter(route string, lim RouteLimit, l logs) *limiter {
+	wait := lim.Wait
+	if wait == 0 {
+		wait = DefaultLimitWait
+	}
+	return &limiter{
+		route: route,
+		slots: make(chan struct{}, lim.Max),
+		queue: int64(lim.Queue),
+		wait:  wait,
+		logs:  l,
+	}
+}
+
+// withLimit runs h once a slot is free, answering 503 if the queue is full
+// or no slot frees up in time. A request whose client gives up while queued
+// is dropped without a response.
+func (lim *limiter) withLimit(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case lim.slots <- struct{}{}:
+		default:
+			if !lim.await(w, r) {
+				return
+			}
+		}
+		defer func() { <-lim.slots }()
+		h(w, r)
+	}
+}
+
+// await queues r for a slot and reports whether it got one.
+func (lim *limiter) await(w http.ResponseWriter, r *http.Request) bool {
+	if lim.waiting.Add(1) > lim.queue {
+		lim.waiting.Add(-1)
+		lim.busy(w, r, "queue_full")
+		return false
+	}
+	defer lim.waiting.Add(-1)
+
+	t := time.NewTimer(lim.wait)
+	defer t.Stop()
+	select {
+	case lim.slots <- struct{}{}:
+		return true
+	case <-t.C:
+		lim.busy(w, r, "wait_expired")
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+// busy refuses r, asking the client to retry shortly.
+func (lim *limiter) busy(w http.ResponseWriter, r *http.Request, reason string) {
+	lim.accessLog.Info("route_busy",
+		"route", lim.route,
+		"reason", reason,
+		"in_progress", len(lim.slots),
+		"reqid", requestIDFromCtx(r.Context()),
+	)
+	w.Header().Set("Retry-After", strconv.Itoa(int(max(lim.wait/time.Second, 1))))
+	writeErr(w, http.StatusServiceUnavailable, domain.RelayCodeBusy, "too many requests of this kind in progress")
+}
+
+// CheckoutLimit caps how many of one user's one-time prekeys
+// POST /prekey/{user}/checkout hands out in any Window: checkouts beyond Max
+// still return the bundle, without a one-time prekey, as once they have run
+// out. Whoever asks, no one can drain a user's keys faster than that. The
+// zero value sets no cap.
+type CheckoutLimit struct {
+	Max    int
+	Window time.Duration
+}
+
+// DefaultCheckoutLimit is the CheckoutLimit cmd/relay applies unless told
+// otherwise.
+func DefaultCheckoutLimit() CheckoutLimit {
+	return CheckoutLimit{Max: 4, Window: time.Hour}
+}
+
+// validate checks l's bounds.
+func (l CheckoutLimit) validate() error {
+	if l.Max < 0 || l.Window < 0 {
+		return fmt.Errorf("negative checkout limit %d per %v", l.Max, l.Window)
+	}
+	if (l.Max == 0) != (l.Window == 0) {
+		return fmt.Errorf("checkout limit %d per %v: set both or neither", l.Max, l.Window)
+	}
+	return nil
+}
+
+// checkoutAllowed reports whether user may be handed another one-time
+// prekey at now under s.checkoutLimit, and if so counts the hand-out. It
+// remembers the times of the last Max hand-outs per user, in memory only.
+// The caller holds s.mu for writing.
+func (s *state) checkoutAllowed(user string, now time.Time) bool {
+	lim := s.checkoutLimit
+	if lim.Max == 0 {
+		return true
+	}
+	times := s.checkouts[user]
+	if len(times) >= lim.Max {
+		if now.Sub(times[0]) < lim.Window {
+			return false
+		}
+		times = times[1:]
+	}
+	s.checkouts[user] = append(times, now)
+	return true
+}
@@ -0,0 +1,288 @@
+package relayserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/address"
+)
+
+// Snapshots.
+const (
+	// snapshotVersion is the format of the snapshots GET /admin/export
+	// writes and POST /admin/import reads.
+	snapshotVersion = 1
+
+	// maxSnapshotBody caps an imported snapshot, which is decoded whole in
+	// memory before it is checked.
+	maxSnapshotBody = 1 << 30
+
+	// snapshotDeadline is how long reading or writing a snapshot may take,
+	// past the server's own timeouts, which suit ordinary requests.
+	snapshotDeadline = 10 * time.Minute
+)
+
+// snapshot is everything a relay stores for its users, in a form any
+// storage backend can be loaded from: every bundle, every queued envelope
+// (opened, if the store seals them), every restriction, backup and presence
+// policy, and the last envelope sequence number handed out, so IDs are not
+// reused. Digest is the hex SHA-256 of the snapshot's JSON with Digest
+// empty, so a copy can be checked end to end.
+//
+// Usage counts, queue journals, pairing mailboxes, attachments and acked
+// envelopes are not included.
+type snapshot struct {
+	Version      int                              `json:"version"`
+	NextSeq      uint64                           `json:"next_seq"`
+	Bundles      map[string]domain.PrekeyBundle   `json:"bundles"`
+	Queues       map[string][]domain.Envelope     `json:"queues"`
+	Restrictions map[string]restriction           `json:"restrictions"`
+	Backups      map[string]domain.RelayBackup    `json:"backups"`
+	Presence     map[string]domain.PresencePolicy `json:"presence"`
+	Digest       string                           `json:"digest"`
+}
+
+// SnapshotSummary counts what a snapshot holds. POST /admin/import answers
+// with the summary of the relay's state once the import is complete, which
+// matches the snapshot's.
+type SnapshotSummary struct {
+	Bundles      int    `json:"bundles"`
+	Queued       int    `json:"queued"`
+	Restrictions int    `json:"restrictions"`
+	Backups      int    `json:"backups"`
+	Presence     int    `json:"presence"`
+	NextSeq      uint64 `json:"next_seq"`
+	Digest       string `json:"digest"`
+}
+
+// digest returns the digest of sn as described on snapshot.
+func (sn snapshot) digest() string {
+	sn.Digest = ""
+	// A snapshot holds nothing json.Marshal can fail on; map keys are
+	// sorted, so equal snapshots hash alike.
+	raw, _ := json.Marshal(sn)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// summary counts what sn holds.
+func (sn snapshot) summary() SnapshotSummary {
+	v := SnapshotSummary{
+		Bundles:      len(sn.Bundles),
+		Restrictions: len(sn.Restrictions),
+		Backups:      len(sn.Backups),
+		Presence:     len(sn.Presence),
+		NextSeq:      sn.NextSeq,
+		Digest:       sn.Digest,
+	}
+	for _, q := range sn.Queues {
+		v.Queued += len(q)
+	}
+	return v
+}
+
+// check reports the first inconsistency in sn: a wrong version or digest, a
+// bundle filed under another username, an envelope that does not belong to
+// its queue, an envelope ID used twice or past NextSeq, or a restriction of
+// an unknown mode.
+func (sn snapshot) check() error {
+	if sn.Version != snapshotVersion {
+		return fmt.Errorf("version %d, want %d", sn.Version, snapshotVersion)
+	}
+	if sn.Digest != sn.digest() {
+		return errors.New("digest does not match the contents")
+	}
+	for user, b := range sn.Bundles {
+		if b.Username != user || strings.HasPrefix(user, address.FingerprintPrefix) {
+			return fmt.Errorf("bundle for %q filed under %q", b.Username, user)
+		}
+	}
+	ids := make(map[string]bool)
+	for user, q := range sn.Queues {
+		for _, env := range q {
+			if err := checkQueued(user, env); err != nil {
+				return fmt.Errorf("queue of %q: %w", user, err)
+			}
+			if ids[env.ID] {
+				return fmt.Errorf("queue of %q: envelope ID %s is already queued", user, env.ID)
+			}
+			ids[env.ID] = true
+			if id, _ := strconv.ParseUint(env.ID, 10, 64); id > sn.NextSeq {
+				return fmt.Errorf("queue of %q: envelope ID %s is past next_seq %d", user, env.ID, sn.NextSeq)
+			}
+		}
+	}
+	for user, res := range sn.Restrictions {
+		if res.Mode != restrictSuspend && res.Mode != restrictShadowBan {
+			return fmt.Errorf("restriction of %q: unknown mode %q", user, res.Mode)
+		}
+	}
+	return nil
+}
+
+// CheckSnapshot reads a snapshot written by GET /admin/export and checks it
+// as POST /admin/import would, without loading it anywhere.
+func CheckSnapshot(raw []byte) (SnapshotSummary, error) {
+	var sn snapshot
+	if err := decodeSnapshot(bytes.NewReader(raw), &sn); err != nil {
+		return SnapshotSummary{}, err
+	}
+	if err := sn.check(); err != nil {
+		return SnapshotSummary{}, fmt.Errorf("inconsistent snapshot: %w", err)
+	}
+	return sn.summary(), nil
+}
+
+// decodeSnapshot decodes one snapshot from r, refusing unknown fields.
+func decodeSnapshot(r io.Reader, sn *snapshot) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	return dec.Decode(sn)
+}
+
+// snapshotLocked returns the relay's state as a snapshot. Queues are copied,
+// since envelopes are removed from them in place. Expired restrictions are
+// left out. The caller holds s.mu.
+func (s *state) snapshotLocked(now time.Time) snapshot {
+	sn := snapshot{
+		Version:      snapshotVersion,
+		NextSeq:      s.nextSeq,
+		Bundles:      maps.Clone(s.bundles),
+		Queues:       make(map[string][]domain.Envelope, len(s.queues)),
+		Restrictions: make(map[string]restriction, len(s.restrictions)),
+		Backups:      maps.Clone(s.backups),
+		Presence:     maps.Clone(s.presence),
+	}
+	for user, q := range s.queues {
+		if len(q) > 0 {
+			sn.Queues[user] = slices.Clone(q)
+		}
+	}
+	for user, res := range s.restrictions {
+		if res.active(now) {
+			sn.Restrictions[user] = res
+		}
+	}
+	sn.Digest = sn.digest()
+	return sn
+}
+
+// emptyLocked reports whether the relay holds nothing a snapshot would
+// replace. The caller holds s.mu.
+func (s *state) emptyLocked() bool {
+	queued := 0
+	for _, q := range s.queues {
+		queued += len(q)
+	}
+	return len(s.bundles) == 0 && queued == 0 && len(s.restrictions) == 0 &&
+		len(s.backups) == 0 && len(s.presence) == 0
+}
+
+// handleExport writes a snapshot of the relay's state (GET /admin/export),
+// for POST /admin/import on a relay with another storage backend. It is
+// taken under the state lock, so it is consistent, but envelopes queued
+// after it are not in it.
+func (s *state) handleExport(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	sn := s.snapshotLocked(time.Now())
+	s.mu.RUnlock()
+
+	v := sn.summary()
+	s.audit(r, "export", "", "bundles", v.Bundles, "queued", v.Queued, "digest", v.Digest)
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(snapshotDeadline))
+	writeJSON(w, sn)
+}
+
+// handleImport loads a snapshot from GET /admin/export into a relay that
+// holds nothing yet (POST /admin/import). The snapshot is checked whole
+// before anything changes, and with a data directory it is written out as
+// the new state log before it is served. The answer summarises the relay's
+// state afterwards, re-read from it, so its digest matches the source's when
+// nothing was lost.
+func (s *state) handleImport(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Now().Add(snapshotDeadline))
+	_ = rc.SetWriteDeadline(time.Now().Add(snapshotDeadline))
+
+	var sn snapshot
+	if err := decodeSnapshot(http.MaxBytesReader(w, r.Body, maxSnapshotBody), &sn); err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			writeErr(w, http.StatusRequestEntityTooLarge, domain.RelayCodeTooLarge, "snapshot too large", "field", "body", "limit", strconv.Itoa(maxSnapshotBody))
+			return
+		}
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "bad request")
+		return
+	}
+	if err := sn.check(); err != nil {
+		s.audit(r, "import_refused", "", "problem", err.Error())
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeInconsistent, "inconsistent snapshot", "problem", err.Error())
+		return
+	}
+
+	data := relayData{
+		bundles:      sn.Bundles,
+		queues:       sn.Queues,
+		nextSeq:      sn.NextSeq,
+		restrictions: sn.Restrictions,
+		backups:      sn.Backups,
+		presence:     sn.Presence,
+	}
+	if data.bundles == nil {
+		data.bundles = make(map[string]domain.PrekeyBundle)
+	}
+	if data.queues == nil {
+		data.queues = make(map[string][]domain.Envelope)
+	}
+	if data.restrictions == nil {
+		data.restrictions = make(map[string]restriction)
+	}
+	if data.backups == nil {
+		data.backups = make(map[string]domain.RelayBackup)
+	}
+	if data.presence == nil {
+		data.presence = make(map[string]domain.PresencePolicy)
+	}
+
+	s.mu.Lock()
+	if !s.emptyLocked() {
+		s.mu.Unlock()
+		writeErr(w, http.StatusConflict, domain.RelayCodeNotEmpty, "relay already holds data")
+		return
+	}
+	// Sequence numbers this relay handed out before the import stay used.
+	data.nextSeq = max(data.nextSeq, s.nextSeq)
+	if s.store != nil {
+		if err := s.store.compact(data); err != nil {
+			s.mu.Unlock()
+			writeErr(w, http.StatusInternalServerError, domain.RelayCodeStorage, "storage error")
+			s.logStorageErr(r, "import_store", err)
+			return
+		}
+	}
+	s.bundles, s.queues, s.nextSeq = data.bundles, data.queues, data.nextSeq
+	s.restrictions, s.backups, s.presence = data.restrictions, data.backups, data.presence
+	s.fingerprints = indexFingerprints(s.bundles)
+	for user := range s.queues {
+		s.arrivedLocked(user)
+	}
+	after := s.snapshotLocked(time.Now())
+	s.mu.Unlock()
+
+	v := after.summary()
+	s.audit(r, "import", "", "bundles", v.Bundles, "queued", v.Queued, "digest", v.Digest)
+	writeJSON(w, v)
+}
@@ -0,0 +1,108 @@
+package relayserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"ciphera/internal/buildinfo"
+	"ciphera/internal/domain"
+)
+
+// maxMOTDLen caps the message of the day.
+const maxMOTDLen = 1024
+
+// noticeBoard holds what the relay announces to clients in GET /server-info.
+// It starts as Options.Notice and is replaced through PUT /admin/notice; a
+// replacement is kept in memory only, so a restart goes back to Options.
+type noticeBoard struct {
+	mu     sync.RWMutex
+	notice domain.RelayNotice
+}
+
+// get returns the current notice.
+func (b *noticeBoard) get() domain.RelayNotice {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.notice
+}
+
+// set replaces the notice.
+func (b *noticeBoard) set(n domain.RelayNotice) {
+	b.mu.Lock()
+	b.notice = n
+	b.mu.Unlock()
+}
+
+// checkNotice returns the field of n that is wrong, and why: a maintenance
+// window that ends before it starts, an overlong message of the day, an
+// unknown client gate or an unparseable minimum client version. param is ""
+// if n is fine.
+func checkNotice(n domain.RelayNotice) (param, msg string) {
+	switch {
+	case n.MaintenanceStartUTC < 0:
+		return "maintenance_start_utc", "negative maintenance start"
+	case n.MaintenanceEndUTC < 0:
+		return "maintenance_end_utc", "negative maintenance end"
+	case n.MaintenanceStartUTC != 0 && n.MaintenanceEndUTC != 0 && n.MaintenanceEndUTC < n.MaintenanceStartUTC:
+		return "maintenance_end_utc", "maintenance ends before it starts"
+	case len(n.MOTD) > maxMOTDLen:
+		return "motd", "motd too long"
+	case n.ClientGate != "" && n.ClientGate != domain.GateWarn && n.ClientGate != domain.GateBlock:
+		return "client_gate", "client_gate must be warn or block"
+	case n.ClientGate != "" && n.MinClientVersion == "":
+		return "client_gate", "client_gate needs min_client_version"
+	}
+	if n.MinClientVersion != "" {
+		if _, ok := buildinfo.Older(n.MinClientVersion, n.MinClientVersion); !ok {
+			return "min_client_version", "min_client_version is not a semantic version"
+		}
+	}
+	return "", ""
+}
+
+// handleServerInfo returns the relay's build information and any notice
+// (GET /server-info).
+func (srv *Server) handleServerInfo(w http.ResponseWriter, r *http.Request) {
+	info := Info(srv.blobs != nil)
+	if n := srv.notices.get(); !n.Empty() {
+		info.Notice = &n
+	}
+	writeJSON(w, info)
+}
+
+// handleSetNotice replaces what GET /server-info announces
+// (PUT /admin/notice). The body is a domain.RelayNotice; an empty one
+// withdraws the notice.
+func (srv *Server) handleSetNotice(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var n domain.RelayNotice
+	if err := dec.Decode(&n); err != nil {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "bad request")
+		return
+	}
+	if param, msg := checkNotice(n); param != "" {
+		details := []string{"param", param}
+		if param == "motd" {
+			details = append(details, "limit", strconv.Itoa(maxMOTDLen))
+		}
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeInvalidParameter, msg, details...)
+		return
+	}
+	srv.notices.set(n)
+
+	srv.audit(r, "notice", "",
+		"maintenance_start_utc", n.MaintenanceStartUTC,
+		"maintenance_end_utc", n.MaintenanceEndUTC,
+		"min_client_version", n.MinClientVersion,
+		"client_gate", n.ClientGate,
+		"motd_len", len(n.MOTD),
+	)
+	writeJSON(w, n)
+}
@@ -0,0 +1,137 @@
+package relayserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"sync"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/relayauth"
+	"ciphera/internal/protocol/signchain"
+)
+
+// ownerNonceTTL is how long a re-registration nonce can be answered.
+const ownerNonceTTL = 5 * time.Minute
+
+// ownerProof issues the nonces a registered user signs to update their
+// bundle (see package relayauth). Like the proof-of-work challenge's, a
+// nonce carries its expiry and a MAC under a key generated at startup, so
+// issuing one stores nothing and nobody can crowd out the owner's. Answered
+// nonces are remembered until they expire, so each is used once.
+type ownerProof struct {
+	key [32]byte
+	now func() time.Time
+
+	mu   sync.Mutex
+	used map[string]int64 // answered nonce -> expiry (Unix)
+}
+
+// newOwnerProof returns an ownerProof with a fresh key.
+func newOwnerProof() *ownerProof {
+	p := &ownerProof{now: time.Now, used: make(map[string]int64)}
+	_, _ = rand.Read(p.key[:]) // never fails
+	return p
+}
+
+// issue returns a signature challenge for user: a nonce of expiry (uint64,
+// big-endian) ‖ 16 random bytes ‖ MAC over user and both, base64url-encoded.
+func (p *ownerProof) issue(user string) domain.RegistrationChallenge {
+	raw := binary.BigEndian.AppendUint64(nil, uint64(p.now().Add(ownerNonceTTL).Unix()))
+	raw = append(raw, make([]byte, 16)...)
+	_, _ = rand.Read(raw[8:])
+	raw = append(raw, p.mac(user, raw)...)
+	return domain.RegistrationChallenge{
+		Kind:  domain.ChallengeSignature,
+		Nonce: base64.RawURLEncoding.EncodeToString(raw),
+	}
+}
+
+// answer reports whether ans is a signature by key over user re-registering
+// with b, in answer to an unexpired nonce issued to user and not answered
+// before. A valid answer uses up its nonce.
+func (p *ownerProof) answer(user string, key domain.Ed25519Public, b domain.PrekeyBundle, ans domain.ChallengeAnswer) bool {
+	raw, err := base64.RawURLEncoding.DecodeString(ans.Nonce)
+	if ans.Kind != domain.ChallengeSignature || err != nil || len(raw) != 8+16+sha256.Size {
+		return false
+	}
+	body, tag := raw[:8+16], raw[8+16:]
+	if !hmac.Equal(tag, p.mac(user, body)) {
+		return false
+	}
+	now := p.now().Unix()
+	expiry := int64(binary.BigEndian.Uint64(body))
+	if now >= expiry {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(ans.Answer)
+	if err != nil || !relayauth.VerifyRegister(key, user, ans.Nonce, b, sig) {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, seen := p.used[ans.Nonce]; seen {
+		return false
+	}
+	for n, exp := range p.used {
+		if now >= exp {
+			delete(p.used, n)
+		}
+	}
+	p.used[ans.Nonce] = expiry
+	return true
+}
+
+// mac binds a nonce body to user, apart from proof-of-work nonces.
+func (p *ownerProof) mac(user string, body []byte) []byte {
+	m := hmac.New(sha256.New, p.key[:])
+	m.Write([]byte(relayauth.RegisterContext))
+	m.Write(binary.BigEndian.AppendUint16(nil, uint16(len(user))))
+	m.Write([]byte(user))
+	m.Write(body)
+	return m.Sum(nil)
+}
+
+// ownerKey returns the key that must sign an update of prev to b: prev's
+// signing key, or b's when b's sign chain leads to it from prev's. It
+// returns false when b names a signing key prev's owner did not hand over
+// to, which no answer can admit.
+func ownerKey(prev, b domain.PrekeyBundle) (domain.Ed25519Public, bool) {
+	if b.SignKey == prev.SignKey {
+		return prev.SignKey, true
+	}
+	if signchain.Verify(b.IdentityKey, b.SignChain, prev.SignKey, b.SignKey) != nil {
+		return domain.Ed25519Public{}, false
+	}
+	return b.SignKey, true
+}
+
+// admitOwner checks that a re-registration replacing prev with b is signed
+// by prev's owner (see ownerKey), answering 428 with a fresh signature
+// challenge if not. Bundles registered without a signing key cannot be
+// proven and are replaced as before.
+func (s *state) admitOwner(w http.ResponseWriter, r *http.Request, prev, b domain.PrekeyBundle) bool {
+	if isZero32(prev.SignKey[:]) {
+		return true
+	}
+	ans := domain.ChallengeAnswer{
+		Kind:   domain.ChallengeKind(r.Header.Get(challengeHeader)),
+		Nonce:  r.Header.Get(challengeNonceHeader),
+		Answer: r.Header.Get(challengeAnswerHeader),
+	}
+	key, ok := ownerKey(prev, b)
+	if ok && ans.Kind == domain.ChallengeSignature && s.owners.answer(b.Username, key, b, ans) {
+		return true
+	}
+	failed := ans.Kind != ""
+	if failed {
+		s.accessLog.Info("register_refused", "user", b.Username, "reason", "signature", "reqid", requestIDFromCtx(r.Context()))
+	}
+	s.writeChallenge(w, r, b.Username, s.owners.issue(b.Username), failed)
+	return false
+}
@@ -0,0 +1,169 @@
+package relayserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// Pairing mailbox limits. A mailbox carries a handful of small PAKE and
+// key-confirmation messages between two clients and is then discarded.
+const (
+	pairTTL         = 10 * time.Minute
+	pairGCInterval  = time.Minute
+	maxPairBoxes    = 1024
+	maxPairMessages = 8     // per side
+	maxPairBody     = 16384 // bytes per message
+)
+
+// pairBox holds the messages each side has posted, in order.
+type pairBox struct {
+	created time.Time
+	msgs    map[string][][]byte // side ("a" or "b") -> messages
+}
+
+// pairService relays opaque pairing messages between two clients that share a
+// mailbox name. It never sees the pairing code: messages are SPAKE2 shares and
+// ciphertext.
+type pairService struct {
+	mu    sync.Mutex
+	boxes map[string]*pairBox
+
+	logs
+}
+
+// newPairService returns an empty pairService.
+func newPairService(l logs) *pairService {
+	return &pairService{boxes: make(map[string]*pairBox), logs: l}
+}
+
+// otherSide returns the peer of side, or "" if side is invalid.
+func otherSide(side string) string {
+	switch side {
+	case "a":
+		return "b"
+	case "b":
+		return "a"
+	}
+	return ""
+}
+
+// handlePost appends a message to a mailbox (POST /pair/{box}).
+//
+// Body: { "side": "a"|"b", "body": <base64>, "open": bool }. With open set the
+// mailbox must not exist yet, so two pairings never share one by accident.
+func (p *pairService) handlePost(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+
+	name := r.PathValue("box")
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var req struct {
+		Side string `json:"side"`
+		Body []byte `json:"body"`
+		Open bool   `json:"open"`
+	}
+	if err := dec.Decode(&req); err != nil || otherSide(req.Side) == "" {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "bad request")
+		return
+	}
+	if len(req.Body) > maxPairBody {
+		writeErr(w, http.StatusRequestEntityTooLarge, domain.RelayCodeTooLarge, "message too large", "field", "body", "limit", strconv.Itoa(maxPairBody))
+		return
+	}
+
+	p.mu.Lock()
+	box, ok := p.boxes[name]
+	if ok && time.Since(box.created) > pairTTL {
+		delete(p.boxes, name)
+		box, ok = nil, false
+	}
+	switch {
+	case ok && req.Open:
+		p.mu.Unlock()
+		writeErr(w, http.StatusConflict, domain.RelayCodeMailboxInUse, "mailbox in use")
+		return
+	case !ok && len(p.boxes) >= maxPairBoxes:
+		p.mu.Unlock()
+		writeErr(w, http.StatusServiceUnavailable, domain.RelayCodeBusy, "too many pairings in progress")
+		return
+	case !ok:
+		box = &pairBox{created: time.Now(), msgs: make(map[string][][]byte)}
+		p.boxes[name] = box
+	}
+	if len(box.msgs[req.Side]) >= maxPairMessages {
+		p.mu.Unlock()
+		writeErr(w, http.StatusConflict, domain.RelayCodeMailboxFull, "mailbox full", "limit", strconv.Itoa(maxPairMessages))
+		return
+	}
+	box.msgs[req.Side] = append(box.msgs[req.Side], req.Body)
+	n := len(box.msgs[req.Side])
+	p.mu.Unlock()
+
+	p.accessLog.Info("pair_post", "side", req.Side, "n", n, "reqid", requestIDFromCtx(r.Context()))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGet returns the other side's messages (GET /pair/{box}?side=a&after=N).
+//
+// Messages are numbered from 0; after=N skips the first N. A mailbox that does
+// not exist (yet) yields an empty list, so a joiner can wait for the opener.
+func (p *pairService) handleGet(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	peer := otherSide(q.Get("side"))
+	after, err := strconv.Atoi(q.Get("after"))
+	if q.Get("after") == "" {
+		after, err = 0, nil
+	}
+	if peer == "" || err != nil || after < 0 {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "bad request")
+		return
+	}
+
+	out := [][]byte{}
+	p.mu.Lock()
+	if box, ok := p.boxes[r.PathValue("box")]; ok && time.Since(box.created) <= pairTTL {
+		if msgs := box.msgs[peer]; after < len(msgs) {
+			out = append(out, msgs[after:]...)
+		}
+	}
+	p.mu.Unlock()
+
+	writeJSON(w, out)
+}
+
+// handleDelete discards a mailbox once pairing is over (DELETE /pair/{box}).
+func (p *pairService) handleDelete(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	delete(p.boxes, r.PathValue("box"))
+	p.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runGC drops expired mailboxes until ctx is cancelled.
+func (p *pairService) runGC(ctx context.Context) {
+	t := time.NewTicker(pairGCInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			p.mu.Lock()
+			for name, box := range p.boxes {
+				if now.Sub(box.created) > pairTTL {
+					delete(p.boxes, name)
+				}
+			}
+			p.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,773 @@
+package relayserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// stateFile is the append-only log of relay state inside --data-dir.
+const stateFile = "state.log"
+
+// compactMinRecords is how many records the log must hold before it is
+// compacted at run time; it is also compacted whenever dead records outnumber
+// live ones.
+const compactMinRecords = 1024
+
+// storageKeyLabel derives the key that seals queued envelopes in the state
+// log from Options.StorageKey.
+const storageKeyLabel = "ciphera/relay-storage-v1"
+
+// Record operations.
+const (
+	opSeq      = "seq"      // Seq: highest envelope ID handed out
+	opRegister = "register" // Bundle replaces the user's bundle
+	opEnqueue  = "enqueue"  // Env (or Sealed) appended to User's queue
+	opDrop     = "drop"     // IDs removed from User's queue (ack or quota)
+	opRestrict = "restrict" // Restriction replaces User's restriction
+	opLift     = "lift"     // User's restriction removed
+	opBackup   = "backup"   // Backup replaces User's backup
+	opReseal   = "reseal"   // Sealed replaces the stored form of a queued envelope of User
+	opPresence = "presence" // Presence replaces User's presence policy
+)
+
+// record is one line of the state log, written as
+//
+//	<crc32c of json, 8 hex digits> <json>\n
+//
+// With a storage key, an enqueue record carries its envelope in Sealed
+// rather than Env, so only the recipient is readable on disk. A reseal record
+// carries an envelope already queued, sealed again under a new storage key
+// (see runRotation).
+type record struct {
+	Op          string                 `json:"op"`
+	User        string                 `json:"user,omitempty"`
+	Bundle      *domain.PrekeyBundle   `json:"bundle,omitempty"`
+	Env         *domain.Envelope       `json:"env,omitempty"`
+	Sealed      []byte                 `json:"sealed,omitempty"`
+	IDs         []string               `json:"ids,omitempty"`
+	Seq         uint64                 `json:"seq,omitempty"`
+	Restriction *restriction           `json:"restriction,omitempty"`
+	Backup      *domain.RelayBackup    `json:"backup,omitempty"`
+	Presence    *domain.PresencePolicy `json:"presence,omitempty"`
+}
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// errInconsistent is returned at startup when the log has problems that
+	// lose or alter data and --repair was not given.
+	errInconsistent = errors.New("relay storage is inconsistent; restart with --repair to fix it")
+
+	// errSealed is returned at startup when queued envelopes cannot be
+	// opened with the storage key given, or no key was given. --repair does
+	// not help: the records are intact, the key is wrong.
+	errSealed = errors.New("relay storage holds envelopes sealed under a different storage key")
+)
+
+// relayData is the state restored from disk.
+type relayData struct {
+	bundles      map[string]domain.PrekeyBundle
+	queues       map[string][]domain.Envelope
+	nextSeq      uint64
+	restrictions map[string]restriction
+	backups      map[string]domain.RelayBackup
+	presence     map[string]domain.PresencePolicy
+
+	// stale maps the IDs of queued envelopes still sealed under the
+	// previous storage key to their recipients.
+	stale map[string]string
+}
+
+// recoveryReport summarises what startup found in the state log.
+type recoveryReport struct {
+	Records    int // records read
+	Bundles    int // bundles restored
+	Queued     int // envelopes restored
+	Restricted int // account restrictions restored
+	Backups    int // client backups restored
+	Presence   int // presence policies restored
+	Torn       bool
+	Corrupt    int // records that failed their checksum or did not parse
+	Dupes      int // envelopes whose ID was already used
+	Orphans    int // drop records naming envelopes that were not queued
+	Unsealed   int // envelopes stored in the clear, sealed on open when there is a key
+	Stale      int // queued envelopes still sealed under the previous storage key
+	Resealed   int // envelopes already resealed under the storage key by a rotation
+	Locked     int // sealed envelopes neither storage key could open
+	Problems   []string
+}
+
+// fatal reports whether the log has problems that need --repair. A torn final
+// record (the process died mid-write) and orphaned drops lose nothing, so
+// they are fixed without it.
+func (r recoveryReport) fatal() bool {
+	return r.Corrupt > 0 || r.Dupes > 0
+}
+
+// diskStore persists relay state as an append-only log of records and
+// compacts it into a fresh snapshot when dead records pile up.
+//
+// Callers hold the relay state lock around every call, so records are
+// written in the same order the state changes. A nil *diskStore keeps
+// nothing, which is how the relay runs without --data-dir.
+type diskStore struct {
+	mu      sync.Mutex
+	dir     string
+	f       *os.File
+	aead    cipher.AEAD // seals queued envelopes; nil stores them in the clear
+	records int         // records in the log
+	live    int         // bundles plus queued envelopes the log describes
+
+	// prev opens envelopes sealed under the storage key aead's replaced;
+	// nil when no key is being rotated out. stale and rotation track the
+	// rotation (see runRotation). They are guarded by the relay state lock,
+	// like the data they describe.
+	prev     cipher.AEAD
+	stale    map[string]string
+	rotation rotationProgress
+}
+
+// openDiskStore reads the state log in dir, checks it and returns the store
+// with the restored data.
+//
+// Without repair, problems that would lose or alter data (see
+// recoveryReport.fatal) fail with errInconsistent. With repair, bad records
+// are skipped. Either way the log is compacted when it holds anything dead,
+// which also writes out the repair.
+//
+// A non-empty key seals queued envelopes. Envelopes a key cannot open fail
+// with errSealed, with or without repair, and envelopes stored in the clear
+// are sealed by compacting the log, which is how an existing log migrates.
+//
+// A non-empty previous key is one key replaced: envelopes sealed under it
+// are opened with it and resealed under key in the background (see
+// runRotation). Until that is done the log is only compacted at open to
+// write out a repair, so a large log does not hold up startup; the
+// rotation compacts it when it finishes.
+func openDiskStore(dir string, repair bool, key, previous string) (*diskStore, relayData, recoveryReport, error) {
+	data := relayData{
+		bundles:      make(map[string]domain.PrekeyBundle),
+		queues:       make(map[string][]domain.Envelope),
+		restrictions: make(map[string]restriction),
+		backups:      make(map[string]domain.RelayBackup),
+		presence:     make(map[string]domain.PresencePolicy),
+		stale:        make(map[string]string),
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, relayData{}, recoveryReport{}, err
+	}
+	path := filepath.Join(dir, stateFile)
+	aead, err := newStorageAEAD(key)
+	if err != nil {
+		return nil, relayData{}, recoveryReport{}, err
+	}
+	prev, err := newStorageAEAD(previous)
+	if err != nil {
+		return nil, relayData{}, recoveryReport{}, err
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, relayData{}, recoveryReport{}, err
+	}
+	rep := replay(raw, &data, aead, prev)
+	if rep.Locked > 0 {
+		return nil, relayData{}, rep, errSealed
+	}
+	if rep.fatal() && !repair {
+		return nil, relayData{}, rep, errInconsistent
+	}
+
+	d := &diskStore{
+		dir:     dir,
+		aead:    aead,
+		records: rep.Records,
+		live:    rep.Bundles + rep.Queued + rep.Restricted + rep.Backups + rep.Presence,
+		prev:    prev,
+		stale:   data.stale,
+	}
+	if prev != nil {
+		d.rotation = rotationProgress{Resealed: rep.Resealed, StartedUTC: time.Now().Unix()}
+	}
+	rotating := len(d.stale) > 0
+	if (d.records > d.live && !rotating) || rep.Torn || rep.Problems != nil || (aead != nil && rep.Unsealed > 0) {
+		if err := d.compact(data); err != nil {
+			return nil, relayData{}, rep, err
+		}
+	} else if d.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600); err != nil {
+		return nil, relayData{}, rep, err
+	}
+	return d, data, rep, nil
+}
+
+// replay applies the records in raw to data, opening sealed envelopes with
+// aead or else prev, and reports what it found. Envelopes only prev opens,
+// and no reseal record has replaced, are listed in data.stale.
+func replay(raw []byte, data *relayData, aead, prev cipher.AEAD) recoveryReport {
+	var rep recoveryReport
+	problem := func(line int, format string, args ...any) {
+		rep.Problems = append(rep.Problems, fmt.Sprintf("record %d: ", line)+fmt.Sprintf(format, args...))
+	}
+
+	liveIDs := make(map[string]bool) // envelope IDs are unique relay-wide
+	r := bufio.NewReader(bytes.NewReader(raw))
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(b) > 0 {
+				rep.Torn = true
+				problem(line, "torn final record dropped")
+			}
+			break
+		}
+		rep.Records++
+
+		rec, err := decodeRecord(b)
+		if err != nil {
+			rep.Corrupt++
+			problem(line, "%v", err)
+			continue
+		}
+		switch rec.Op {
+		case opSeq:
+			data.nextSeq = max(data.nextSeq, rec.Seq)
+		case opRegister:
+			data.bundles[rec.Bundle.Username] = *rec.Bundle
+		case opEnqueue:
+			underPrev := false
+			if rec.Env == nil {
+				env, err := openEnvelope(aead, rec.User, rec.Sealed)
+				if err != nil && prev != nil {
+					if env, err = openEnvelope(prev, rec.User, rec.Sealed); err == nil {
+						underPrev = true
+					}
+				}
+				if err != nil {
+					rep.Locked++
+					problem(line, "envelope for %q: %v", rec.User, err)
+					continue
+				}
+				rec.Env = &env
+			} else {
+				rep.Unsealed++
+			}
+			if liveIDs[rec.Env.ID] {
+				rep.Dupes++
+				problem(line, "envelope ID %s for %q is already queued", rec.Env.ID, rec.User)
+				continue
+			}
+			id, _ := strconv.ParseUint(rec.Env.ID, 10, 64)
+			data.nextSeq = max(data.nextSeq, id)
+			liveIDs[rec.Env.ID] = true
+			data.queues[rec.User] = append(data.queues[rec.User], *rec.Env)
+			if underPrev {
+				data.stale[rec.Env.ID] = rec.User
+			}
+		case opReseal:
+			env, err := openEnvelope(aead, rec.User, rec.Sealed)
+			if err != nil {
+				rep.Locked++
+				problem(line, "resealed envelope for %q: %v", rec.User, err)
+				continue
+			}
+			if data.stale[env.ID] != rec.User {
+				rep.Orphans++
+				problem(line, "resealed envelope %s for %q was not queued under the previous key", env.ID, rec.User)
+				continue
+			}
+			delete(data.stale, env.ID)
+			rep.Resealed++
+		case opDrop:
+			removed := 0
+			kept := slices.DeleteFunc(data.queues[rec.User], func(e domain.Envelope) bool {
+				if !slices.Contains(rec.IDs, e.ID) {
+					return false
+				}
+				delete(liveIDs, e.ID)
+				delete(data.stale, e.ID)
+				removed++
+				return true
+			})
+			if n := len(rec.IDs) - removed; n > 0 {
+				rep.Orphans += n
+				problem(line, "%d dropped envelope(s) for %q were not queued", n, rec.User)
+			}
+			if len(kept) == 0 {
+				delete(data.queues, rec.User)
+			} else {
+				data.queues[rec.User] = kept
+			}
+		case opRestrict:
+			data.restrictions[rec.User] = *rec.Restriction
+		case opLift:
+			delete(data.restrictions, rec.User)
+		case opBackup:
+			data.backups[rec.User] = *rec.Backup
+		case opPresence:
+			data.presence[rec.User] = *rec.Presence
+		}
+	}
+
+	rep.Bundles = len(data.bundles)
+	for _, q := range data.queues {
+		rep.Queued += len(q)
+	}
+	rep.Restricted = len(data.restrictions)
+	rep.Backups = len(data.backups)
+	rep.Presence = len(data.presence)
+	rep.Stale = len(data.stale)
+	return rep
+}
+
+// decodeRecord checks a record's checksum and shape.
+func decodeRecord(line []byte) (record, error) {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	sum, body, ok := bytes.Cut(line, []byte(" "))
+	want, err := hex.DecodeString(string(sum))
+	if !ok || err != nil || len(want) != 4 {
+		return record{}, errors.New("missing checksum")
+	}
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(want) {
+		return record{}, errors.New("checksum mismatch")
+	}
+
+	var rec record
+	if err := json.Unmarshal(body, &rec); err != nil {
+		return record{}, fmt.Errorf("bad JSON: %w", err)
+	}
+	switch rec.Op {
+	case opSeq:
+	case opRegister:
+		if rec.Bundle == nil || rec.Bundle.Username == "" {
+			return record{}, errors.New("register record without a bundle")
+		}
+	case opEnqueue:
+		if rec.User == "" || (rec.Env == nil) == (rec.Sealed == nil) {
+			return record{}, errors.New("enqueue record without an envelope")
+		}
+		if rec.Env != nil {
+			if err := checkQueued(rec.User, *rec.Env); err != nil {
+				return record{}, err
+			}
+		}
+	case opReseal:
+		if rec.User == "" || rec.Sealed == nil {
+			return record{}, errors.New("reseal record without a sealed envelope")
+		}
+	case opDrop:
+		if rec.User == "" || len(rec.IDs) == 0 {
+			return record{}, errors.New("drop record without IDs")
+		}
+	case opRestrict:
+		if rec.User == "" || rec.Restriction == nil {
+			return record{}, errors.New("restrict record without a restriction")
+		}
+	case opLift:
+		if rec.User == "" {
+			return record{}, errors.New("lift record without a user")
+		}
+	case opBackup:
+		if rec.User == "" || rec.Backup == nil {
+			return record{}, errors.New("backup record without a backup")
+		}
+	case opPresence:
+		if rec.User == "" || rec.Presence == nil {
+			return record{}, errors.New("presence record without a policy")
+		}
+	default:
+		return record{}, fmt.Errorf("unknown operation %q", rec.Op)
+	}
+	return rec, nil
+}
+
+// checkQueued checks the shape of an envelope stored in user's queue.
+func checkQueued(user string, env domain.Envelope) error {
+	if env.To != user {
+		return errors.New("enqueue record without a matching envelope")
+	}
+	if _, err := strconv.ParseUint(env.ID, 10, 64); err != nil {
+		return fmt.Errorf("enqueue record with bad envelope ID %q", env.ID)
+	}
+	return nil
+}
+
+// newStorageAEAD derives the envelope sealing key from key with HKDF; an
+// empty key returns nil, which stores envelopes in the clear.
+func newStorageAEAD(key string) (cipher.AEAD, error) {
+	if key == "" {
+		return nil, nil
+	}
+	k, err := crypto.HKDF([]byte(key), nil, storageKeyLabel, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(k)
+}
+
+// enqueueRecord returns the record that appends env to its recipient's
+// queue, sealing the envelope when the store has a key. The sealed form is
+// a random nonce followed by the ciphertext of the envelope's JSON, bound to
+// the recipient, so the sender, timestamps and ID are all hidden.
+func (d *diskStore) enqueueRecord(env domain.Envelope) (record, error) {
+	if d.aead == nil {
+		return record{Op: opEnqueue, User: env.To, Env: &env}, nil
+	}
+	plain, err := json.Marshal(env)
+	if err != nil {
+		return record{}, err
+	}
+	nonce := make([]byte, d.aead.NonceSize(), d.aead.NonceSize()+len(plain)+d.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return record{}, err
+	}
+	return record{Op: opEnqueue, User: env.To, Sealed: d.aead.Seal(nonce, nonce, plain, []byte(env.To))}, nil
+}
+
+// openEnvelope opens an envelope sealed by enqueueRecord for user's queue.
+func openEnvelope(aead cipher.AEAD, user string, sealed []byte) (domain.Envelope, error) {
+	if aead == nil {
+		return domain.Envelope{}, errors.New("sealed, but no storage key was given")
+	}
+	if len(sealed) < aead.NonceSize() {
+		return domain.Envelope{}, errors.New("sealed envelope too short")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(user))
+	if err != nil {
+		return domain.Envelope{}, errors.New("storage key does not open the sealed envelope")
+	}
+	var env domain.Envelope
+	if err := json.Unmarshal(plain, &env); err != nil {
+		return domain.Envelope{}, fmt.Errorf("sealed envelope: bad JSON: %w", err)
+	}
+	if err := checkQueued(user, env); err != nil {
+		return domain.Envelope{}, err
+	}
+	return env, nil
+}
+
+// encodeRecord formats rec as one checksummed log line.
+func encodeRecord(rec record) ([]byte, error) {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	line := make([]byte, 0, 9+len(body)+1)
+	line = fmt.Appendf(line, "%08x ", crc32.Checksum(body, crcTable))
+	line = append(line, body...)
+	return append(line, '\n'), nil
+}
+
+// registered records bundle, replacing any earlier one for the user.
+func (d *diskStore) registered(bundle domain.PrekeyBundle, replaced bool) error {
+	if d == nil {
+		return nil
+	}
+	live := 1
+	if replaced {
+		live = 0
+	}
+	return d.append(live, record{Op: opRegister, Bundle: &bundle})
+}
+
+// enqueued records env and any envelopes the queue limits dropped for it.
+func (d *diskStore) enqueued(env domain.Envelope, dropped []string) error {
+	if d == nil {
+		return nil
+	}
+	rec, err := d.enqueueRecord(env)
+	if err != nil {
+		return err
+	}
+	recs := []record{rec}
+	if len(dropped) > 0 {
+		recs = append(recs, record{Op: opDrop, User: env.To, IDs: dropped})
+	}
+	if err := d.append(1-len(dropped), recs...); err != nil {
+		return err
+	}
+	d.forget(dropped)
+	return nil
+}
+
+// sequenced records n as the highest envelope ID handed out, for an
+// envelope that was answered but never queued. The record replaces any
+// earlier one, so it does not add to the live count.
+func (d *diskStore) sequenced(n uint64) error {
+	if d == nil {
+		return nil
+	}
+	return d.append(0, record{Op: opSeq, Seq: n})
+}
+
+// dropped records envelopes removed from user's queue.
+func (d *diskStore) dropped(user string, ids []string) error {
+	if d == nil || len(ids) == 0 {
+		return nil
+	}
+	if err := d.append(-len(ids), record{Op: opDrop, User: user, IDs: ids}); err != nil {
+		return err
+	}
+	d.forget(ids)
+	return nil
+}
+
+// restricted records res as user's restriction, replacing any earlier one.
+func (d *diskStore) restricted(user string, res restriction, replaced bool) error {
+	if d == nil {
+		return nil
+	}
+	live := 1
+	if replaced {
+		live = 0
+	}
+	return d.append(live, record{Op: opRestrict, User: user, Restriction: &res})
+}
+
+// backedUp records b as user's backup, replacing any earlier one.
+func (d *diskStore) backedUp(user string, b domain.RelayBackup, replaced bool) error {
+	if d == nil {
+		return nil
+	}
+	live := 1
+	if replaced {
+		live = 0
+	}
+	return d.append(live, record{Op: opBackup, User: user, Backup: &b})
+}
+
+// presenceSet records p as user's presence policy, replacing any earlier one.
+func (d *diskStore) presenceSet(user string, p domain.PresencePolicy, replaced bool) error {
+	if d == nil {
+		return nil
+	}
+	live := 1
+	if replaced {
+		live = 0
+	}
+	return d.append(live, record{Op: opPresence, User: user, Presence: &p})
+}
+
+// unrestricted records that user's restriction was lifted.
+func (d *diskStore) unrestricted(user string) error {
+	if d == nil {
+		return nil
+	}
+	return d.append(-1, record{Op: opLift, User: user})
+}
+
+// append writes recs in one write and adjusts the live count by delta.
+func (d *diskStore) append(delta int, recs ...record) error {
+	var buf []byte
+	for _, rec := range recs {
+		line, err := encodeRecord(rec)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, line...)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fi, err := d.f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := d.f.Write(buf); err != nil {
+		// Cut off a partial write so the log stays well formed.
+		_ = d.f.Truncate(fi.Size())
+		return err
+	}
+	d.records += len(recs)
+	d.live += delta
+	return nil
+}
+
+// needsCompaction reports whether dead records outweigh live ones in a log
+// big enough to be worth rewriting.
+func (d *diskStore) needsCompaction() bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.records >= compactMinRecords && d.records > 2*d.live
+}
+
+// compact rewrites the log as a snapshot of data: the sequence number, every
+// bundle, every queued envelope, every restriction that has not expired and
+// every backup and every presence policy. The new log is synced and renamed
+// over the old one, so a crash leaves one or the other intact. Every envelope is
+// sealed under the current storage key, which finishes any rotation.
+func (d *diskStore) compact(data relayData) error {
+	recs := []record{{Op: opSeq, Seq: data.nextSeq}}
+	for _, user := range slices.Sorted(maps.Keys(data.bundles)) {
+		b := data.bundles[user]
+		recs = append(recs, record{Op: opRegister, Bundle: &b})
+	}
+	for _, user := range slices.Sorted(maps.Keys(data.queues)) {
+		for _, env := range data.queues[user] {
+			rec, err := d.enqueueRecord(env)
+			if err != nil {
+				return err
+			}
+			recs = append(recs, rec)
+		}
+	}
+	now := time.Now()
+	for _, user := range slices.Sorted(maps.Keys(data.restrictions)) {
+		if res := data.restrictions[user]; res.active(now) {
+			recs = append(recs, record{Op: opRestrict, User: user, Restriction: &res})
+		}
+	}
+	for _, user := range slices.Sorted(maps.Keys(data.backups)) {
+		b := data.backups[user]
+		recs = append(recs, record{Op: opBackup, User: user, Backup: &b})
+	}
+	for _, user := range slices.Sorted(maps.Keys(data.presence)) {
+		p := data.presence[user]
+		recs = append(recs, record{Op: opPresence, User: user, Presence: &p})
+	}
+
+	tmp, err := os.CreateTemp(d.dir, stateFile+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	w := bufio.NewWriter(tmp)
+	for _, rec := range recs {
+		line, err := encodeRecord(rec)
+		if err == nil {
+			_, err = w.Write(line)
+		}
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	path := filepath.Join(d.dir, stateFile)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if d.f != nil {
+		_ = d.f.Close()
+	}
+	d.f = f
+	d.records = len(recs)
+	d.live = len(recs) - 1 // all but the sequence record
+	d.rotation.Resealed += len(d.stale)
+	clear(d.stale)
+	if d.prev != nil && d.rotation.CompletedUTC == 0 {
+		d.rotation.CompletedUTC = time.Now().Unix()
+	}
+	return nil
+}
+
+// close syncs and closes the log.
+func (d *diskStore) close() error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.f.Sync(); err != nil {
+		_ = d.f.Close()
+		return err
+	}
+	return d.f.Close()
+}
+
+// compactIfNeeded compacts the state log when needsCompaction says so. The
+// caller holds s.mu. A failed compaction leaves the old log in use.
+func (s *state) compactIfNeeded() {
+	if !s.store.needsCompaction() {
+		return
+	}
+	if err := s.store.compact(s.data()); err != nil {
+		s.accessLog.Error("compact", "error", err)
+	} else {
+		s.accessLog.Info("compact", "records", s.store.records)
+	}
+}
+
+// data returns the state the log describes, for compacting it. The caller
+// holds s.mu.
+func (s *state) data() relayData {
+	return relayData{
+		bundles:      s.bundles,
+		queues:       s.queues,
+		nextSeq:      s.nextSeq,
+		restrictions: s.restrictions,
+		backups:      s.backups,
+		presence:     s.presence,
+	}
+}
+
+// logStorageErr records a failed state write without exposing details to
+// the client.
+func (l logs) logStorageErr(r *http.Request, op string, err error) {
+	l.accessLog.Error(op, "error", err, "reqid", requestIDFromCtx(r.Context()))
+}
+
+// logRecovery writes the startup report to the log. Problems are fixed
+// unless they needed --repair and it was not given.
+func (l logs) logRecovery(dir string, rep recoveryReport, repair bool) {
+	for _, p := range rep.Problems {
+		l.log.Warn("Storage problem", "detail", p)
+	}
+	l.log.Info("Storage loaded",
+		"dir", dir,
+		"records", rep.Records,
+		"bundles", rep.Bundles,
+		"queued", rep.Queued,
+		"restricted", rep.Restricted,
+		"backups", rep.Backups,
+		"presence", rep.Presence,
+		"corrupt", rep.Corrupt,
+		"duplicates", rep.Dupes,
+		"orphans", rep.Orphans,
+		"unsealed", rep.Unsealed,
+		"stale", rep.Stale,
+		"torn", rep.Torn,
+		"fixed", rep.Problems != nil && (repair || !rep.fatal()),
+	)
+}
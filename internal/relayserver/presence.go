@@ -0,0 +1,138 @@
+package relayserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/relayauth"
+)
+
+// maxPresenceContacts caps the contacts a presence policy may list.
+const maxPresenceContacts = 1000
+
+// seenLocked records that user fetched their messages at now, if their
+// presence policy shows it to anyone. Times are kept to the minute. The
+// caller holds s.mu for writing.
+func (s *state) seenLocked(user string, now time.Time) {
+	switch s.presence[user].Visibility {
+	case domain.PresenceContacts, domain.PresenceEveryone:
+		s.lastSeen[user] = now.Truncate(time.Minute).Unix()
+	}
+}
+
+// handlePutPresence stores a user's presence policy (PUT /presence/{user}).
+//
+// The policy must be signed with the signing key of the user's published
+// bundle (see package relayauth). One older than the stored policy is
+// refused with 409, so an old policy cannot be replayed over a newer one.
+// A policy of domain.PresenceNobody forgets when the user was last seen.
+func (s *state) handlePutPresence(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+
+	user := r.PathValue("user")
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	var p domain.PresencePolicy
+	if err := dec.Decode(&p); err != nil {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "bad request")
+		return
+	}
+	switch p.Visibility {
+	case domain.PresenceNobody, domain.PresenceContacts, domain.PresenceEveryone:
+	default:
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "unknown visibility")
+		return
+	}
+	if len(p.Contacts) > maxPresenceContacts {
+		writeErr(w, http.StatusRequestEntityTooLarge, domain.RelayCodeTooLarge, "too many contacts", "field", "contacts", "limit", strconv.Itoa(maxPresenceContacts))
+		return
+	}
+	now := time.Now()
+	if time.Unix(p.UpdatedUTC, 0).After(now.Add(maxFutureSkew)) {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeFutureTimestamp, "timestamp in future", "max_skew", maxFutureSkew.String())
+		return
+	}
+
+	s.mu.Lock()
+	bundle, registered := s.bundles[user]
+	if !registered {
+		s.mu.Unlock()
+		writeErr(w, http.StatusNotFound, domain.RelayCodeUserNotFound, "user not registered", "user", user)
+		return
+	}
+	if !relayauth.VerifyPresence(bundle.SignKey, user, p) {
+		s.mu.Unlock()
+		writeErr(w, http.StatusUnauthorized, domain.RelayCodeBadSignature, "bad signature")
+		s.accessLog.Info("presence_refused", "user", user, "reason", "signature", "reqid", requestIDFromCtx(r.Context()))
+		return
+	}
+	old, existed := s.presence[user]
+	if p.UpdatedUTC < old.UpdatedUTC {
+		s.mu.Unlock()
+		writeErr(w, http.StatusConflict, domain.RelayCodeNewerPresence, "a newer presence policy is stored")
+		return
+	}
+	if err := s.store.presenceSet(user, p, existed); err != nil {
+		s.mu.Unlock()
+		writeErr(w, http.StatusInternalServerError, domain.RelayCodeStorage, "storage error")
+		s.logStorageErr(r, "presence_store", err)
+		return
+	}
+	s.presence[user] = p
+	if p.Visibility == domain.PresenceNobody {
+		delete(s.lastSeen, user)
+	}
+	s.compactIfNeeded()
+	s.mu.Unlock()
+
+	s.accessLog.Info("presence_put",
+		"user", user,
+		"visibility", p.Visibility,
+		"contacts", len(p.Contacts),
+		"reqid", requestIDFromCtx(r.Context()),
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePresence reports when a user last fetched their messages
+// (GET /presence/{user}), if their presence policy shows it to the asker.
+//
+// A contact asks with a request signed as its own account, named in
+// relayauth.UserHeader (see package relayauth); a request that names one
+// must verify whatever the policy. The answer is 404 alike for a user who
+// hides their presence from the asker, has not been seen since the relay
+// started, or does not exist, so the refusal says nothing about the policy.
+func (s *state) handlePresence(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	viewer := r.Header.Get(relayauth.UserHeader)
+	if viewer != "" && !s.authorizeAccount(w, r, viewer, "presence_refused") {
+		return
+	}
+
+	s.mu.RLock()
+	p := s.presence[user]
+	seen, ok := s.lastSeen[user]
+	s.mu.RUnlock()
+
+	shown := false
+	switch p.Visibility {
+	case domain.PresenceEveryone:
+		shown = true
+	case domain.PresenceContacts:
+		shown = viewer != "" && slices.Contains(p.Contacts, viewer)
+	}
+	if !ok || !shown {
+		writeErr(w, http.StatusNotFound, domain.RelayCodeNotFound, "presence not available", "resource", "presence")
+		return
+	}
+
+	s.accessLog.Info("presence", "user", user, "viewer", viewer, "reqid", requestIDFromCtx(r.Context()))
+	writeJSON(w, domain.Presence{User: user, LastSeenUTC: seen})
+}
@@ -0,0 +1,197 @@
+package relayserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/websocket"
+)
+
+const (
+	// maxPushConns caps the push connections open at once per user, and
+	// pushBuffer the envelopes each may fall behind by before it is closed.
+	maxPushConns = 8
+	pushBuffer   = 64
+
+	// pushPing is how often an idle push connection is pinged, so proxies
+	// keep it open and dead peers are noticed; pushWriteTimeout bounds each
+	// frame written to it.
+	pushPing         = 30 * time.Second
+	pushWriteTimeout = 10 * time.Second
+)
+
+// pushHub hands envelopes to the push connections of their recipients. A
+// subscriber too slow to take them has its channel closed, so its connection
+// ends and the client fetches what it missed from the queue.
+type pushHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan domain.Envelope]struct{} // by user
+}
+
+func newPushHub() *pushHub {
+	return &pushHub{subs: make(map[string]map[chan domain.Envelope]struct{})}
+}
+
+// subscribe returns a channel receiving envelopes queued for user from now
+// on, or false if user has maxPushConns open already.
+func (h *pushHub) subscribe(user string) (chan domain.Envelope, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subs[user]) >= maxPushConns {
+		return nil, false
+	}
+	ch := make(chan domain.Envelope, pushBuffer)
+	if h.subs[user] == nil {
+		h.subs[user] = make(map[chan domain.Envelope]struct{})
+	}
+	h.subs[user][ch] = struct{}{}
+	return ch, true
+}
+
+// unsubscribe stops ch receiving, if publish has not already.
+func (h *pushHub) unsubscribe(user string, ch chan domain.Envelope) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[user][ch]; ok {
+		h.dropLocked(user, ch)
+	}
+}
+
+// publish hands env to every subscriber of user without blocking.
+func (h *pushHub) publish(user string, env domain.Envelope) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[user] {
+		select {
+		case ch <- env:
+		default:
+			h.dropLocked(user, ch)
+		}
+	}
+}
+
+// closeAll ends every subscription, for Server.Close.
+func (h *pushHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for user, subs := range h.subs {
+		for ch := range subs {
+			h.dropLocked(user, ch)
+		}
+	}
+}
+
+func (h *pushHub) dropLocked(user string, ch chan domain.Envelope) {
+	delete(h.subs[user], ch)
+	if len(h.subs[user]) == 0 {
+		delete(h.subs, user)
+	}
+	close(ch)
+}
+
+// subscribers returns how many push connections user has open.
+func (h *pushHub) subscribers(user string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs[user])
+}
+
+// handlePush upgrades GET /ws/{user} to a WebSocket and sends each envelope
+// queued for user from then on as a text message holding its JSON, as
+// GET /msg/{user} returns it. Nothing is removed from the queue: the client
+// acks what it processed as after a fetch, and fetches once connected for
+// what was queued before. Envelopes Options.Chaos holds back are not pushed.
+//
+// The upgrade must be signed by user as for authorizeAccount, since the
+// connection reads their envelopes and counts against their maxPushConns;
+// more connections than that are refused with 503 and RelayCodeBusy.
+// Connecting counts as the user's last activity if their presence policy
+// shows it.
+func (s *state) handlePush(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	if !websocket.IsUpgrade(r) {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "websocket upgrade required")
+		return
+	}
+	if !s.authorizeAccount(w, r, user, "push_refused") {
+		return
+	}
+	ch, ok := s.push.subscribe(user)
+	if !ok {
+		writeErr(w, http.StatusServiceUnavailable, domain.RelayCodeBusy, "too many push connections", "limit", strconv.Itoa(maxPushConns))
+		return
+	}
+	defer s.push.unsubscribe(user, ch)
+
+	conn, err := websocket.Accept(w, r)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeBadRequest, "bad websocket handshake")
+		return
+	}
+	defer conn.Close()
+	reqID := requestIDFromCtx(r.Context())
+
+	s.mu.Lock()
+	s.seenLocked(user, time.Now())
+	s.mu.Unlock()
+	s.accessLog.Info("push_open", "user", user, "reqid", reqID)
+
+	// The client sends nothing but control frames; reading answers its
+	// pings and notices when it goes away.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	sent := 0
+	defer func() {
+		s.accessLog.Info("push_close", "user", user, "sent", sent, "reqid", reqID)
+	}()
+	ping := time.NewTicker(pushPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-gone:
+			return
+		case <-ping.C:
+			if err := writeWithin(conn, conn.Ping); err != nil {
+				return
+			}
+		case env, ok := <-ch:
+			if !ok {
+				conn.CloseWith(websocket.CloseTryAgainLater, "fell behind")
+				return
+			}
+			b, err := json.Marshal(env)
+			if err != nil {
+				return
+			}
+			if err := writeWithin(conn, func() error { return conn.WriteText(b) }); err != nil {
+				return
+			}
+			sent++
+			now := time.Now()
+			s.mu.Lock()
+			s.journal.add(user, domain.JournalFetch, []domain.Envelope{env}, now)
+			s.mu.Unlock()
+			s.usage.countOut(user, int64(len(b)), 1, now)
+		}
+	}
+}
+
+// writeWithin runs write with a deadline of pushWriteTimeout from now.
+func writeWithin(conn *websocket.Conn, write func() error) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(pushWriteTimeout)); err != nil {
+		return err
+	}
+	return write()
+}
@@ -0,0 +1,118 @@
+package relayserver
+
+import (
+	"slices"
+	"strconv"
+
+	"ciphera/internal/domain"
+)
+
+// maxPerSenderQueue caps how many envelopes one sender may hold in a single
+// recipient's queue, so a noisy sender cannot crowd out everyone else.
+const maxPerSenderQueue = maxPerUserQueue / 4
+
+// enqueueFair appends env to q and enforces the queue limits, returning the
+// new queue and the IDs of any envelopes it dropped.
+//
+// A sender over its sub-quota loses its own oldest envelope. A full queue
+// drops the oldest envelope of whichever sender holds the most, so quiet
+// senders keep their messages while a flood only displaces itself. Senders
+// are keyed by the envelope's From field as given by the client; the relay
+// does not authenticate it.
+func enqueueFair(q []domain.Envelope, env domain.Envelope) ([]domain.Envelope, []string) {
+	q = append(q, env)
+	counts := senderCounts(q)
+
+	var victim string
+	switch {
+	case counts[env.From] > maxPerSenderQueue:
+		victim = env.From
+	case len(q) > maxPerUserQueue:
+		// The oldest envelope of the busiest sender; ties go to the sender
+		// whose oldest envelope arrived first.
+		for _, e := range q {
+			if victim == "" || counts[e.From] > counts[victim] {
+				victim = e.From
+			}
+		}
+	default:
+		return q, nil
+	}
+
+	for i, e := range q {
+		if e.From == victim {
+			q = append(q[:i], q[i+1:]...)
+			return q, []string{e.ID}
+		}
+	}
+	return q, nil
+}
+
+// senderCounts returns how many envelopes each sender has in q.
+func senderCounts(q []domain.Envelope) map[string]int {
+	counts := make(map[string]int)
+	for _, e := range q {
+		counts[e.From]++
+	}
+	return counts
+}
+
+// fairOrder returns up to limit envelopes from q (all if limit is 0), taking
+// one envelope per sender in turn. Senders are visited in the order their
+// oldest queued envelope arrived, and each sender's envelopes stay in arrival
+// order, which the client's ratchet relies on.
+func fairOrder(q []domain.Envelope, limit int) []domain.Envelope {
+	if limit == 0 || limit > len(q) {
+		limit = len(q)
+	}
+	var senders []string
+	bySender := make(map[string][]domain.Envelope)
+	for _, e := range q {
+		if _, ok := bySender[e.From]; !ok {
+			senders = append(senders, e.From)
+		}
+		bySender[e.From] = append(bySender[e.From], e)
+	}
+
+	out := make([]domain.Envelope, 0, limit)
+	for round := 0; len(out) < limit; round++ {
+		for _, from := range senders {
+			if envs := bySender[from]; round < len(envs) && len(out) < limit {
+				out = append(out, envs[round])
+			}
+		}
+	}
+	return out
+}
+
+// fetchedWatermark returns the highest sequence number W such that no
+// envelope from sender from (any sender if from is "") numbered W or lower is
+// still in q; next is the last sequence number handed out. A sender's
+// envelopes are fetched in arrival order, so all of theirs up to W have left
+// the queue: fetched, or dropped as expired or over quota.
+func fetchedWatermark(q []domain.Envelope, from string, next uint64) uint64 {
+	w := next
+	for _, e := range q {
+		if from != "" && e.From != from {
+			continue
+		}
+		if id, err := strconv.ParseUint(e.ID, 10, 64); err == nil && id <= w {
+			w = id - 1
+		}
+	}
+	return w
+}
+
+// pick returns the envelopes with the given IDs from the queues qs, in
+// order.
+func pick(ids []string, qs ...[]domain.Envelope) []domain.Envelope {
+	var out []domain.Envelope
+	for _, q := range qs {
+		for _, e := range q {
+			if slices.Contains(ids, e.ID) {
+				out = append(out, e)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,144 @@
+package relayserver
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	// rotateBatch is how many envelopes one pass of a storage key rotation
+	// reseals, and rotateInterval the pause between passes, so requests get
+	// the state lock in between.
+	rotateBatch    = 256
+	rotateInterval = 100 * time.Millisecond
+)
+
+// Rotation states reported by the admin API.
+const (
+	rotationNone     = "none"     // no previous storage key was given
+	rotationRunning  = "running"  // envelopes are still sealed under the previous key
+	rotationComplete = "complete" // the log holds nothing the previous key is needed for
+)
+
+// rotationProgress tracks resealing queued envelopes under a new storage key.
+// Resealed counts from the start of the rotation, including passes made
+// before a restart, which the log records; StartedUTC is when this process
+// took it up.
+type rotationProgress struct {
+	Resealed     int   `json:"resealed"`
+	StartedUTC   int64 `json:"started_utc,omitempty"`
+	CompletedUTC int64 `json:"completed_utc,omitempty"`
+}
+
+// rotationView is a rotation as the admin API returns it.
+type rotationView struct {
+	State     string `json:"state"`
+	Remaining int    `json:"remaining"` // envelopes still sealed under the previous key
+	rotationProgress
+}
+
+// forget stops tracking the envelopes ids, which have left their queues, as
+// needing a reseal.
+func (d *diskStore) forget(ids []string) {
+	for _, id := range ids {
+		delete(d.stale, id)
+	}
+}
+
+// runRotation reseals envelopes still sealed under the previous storage key,
+// a batch at a time, until none are left, then compacts the log so the
+// previous key's ciphertexts are gone from disk too. Each pass appends a
+// reseal record per envelope, so a restart resumes where it stopped. A
+// failed pass is logged and retried.
+func (s *state) runRotation(ctx context.Context) {
+	if s.store == nil || s.store.prev == nil {
+		return
+	}
+	t := time.NewTicker(rotateInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.mu.Lock()
+			done, err := s.resealLocked()
+			s.mu.Unlock()
+			if err != nil {
+				s.log.Error("rotate_store", "error", err)
+			}
+			if done {
+				return
+			}
+		}
+	}
+}
+
+// resealLocked runs one pass of runRotation and reports whether the rotation
+// is complete. The caller holds s.mu.
+func (s *state) resealLocked() (bool, error) {
+	d := s.store
+	if d.rotation.CompletedUTC != 0 {
+		return true, nil
+	}
+	if len(d.stale) == 0 {
+		if err := d.compact(s.data()); err != nil {
+			return false, err
+		}
+		s.log.Info("Storage key rotation complete; the previous key is no longer needed",
+			"resealed", d.rotation.Resealed)
+		return true, nil
+	}
+
+	var (
+		recs []record
+		ids  []string
+	)
+	for id, user := range d.stale {
+		if len(recs) == rotateBatch {
+			break
+		}
+		for _, env := range s.queues[user] {
+			if env.ID != id {
+				continue
+			}
+			rec, err := d.enqueueRecord(env)
+			if err != nil {
+				return false, err
+			}
+			rec.Op = opReseal
+			recs = append(recs, rec)
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(recs) > 0 {
+		if err := d.append(0, recs...); err != nil {
+			return false, err
+		}
+	}
+	d.forget(ids)
+	d.rotation.Resealed += len(recs)
+	s.accessLog.Info("rotate", "resealed", len(recs), "remaining", len(d.stale))
+	return false, nil
+}
+
+// handleRotation reports the progress of a storage key rotation
+// (GET /admin/storage/rotation).
+func (s *state) handleRotation(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	v := rotationView{State: rotationNone}
+	if d := s.store; d != nil && d.prev != nil {
+		v.rotationProgress = d.rotation
+		v.Remaining = len(d.stale)
+		v.State = rotationRunning
+		if d.rotation.CompletedUTC != 0 {
+			v.State = rotationComplete
+		}
+	}
+	s.mu.RUnlock()
+
+	s.audit(r, "rotation", "", "state", v.State, "remaining", v.Remaining)
+	writeJSON(w, v)
+}
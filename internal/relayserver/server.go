@@ -0,0 +1,426 @@
+package relayserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/address"
+)
+
+// blobClientTimeout bounds each request the relay makes to an S3 endpoint.
+const blobClientTimeout = 10 * time.Second
+
+// discardLogger drops every record. It stands in for a nil Options.Logger and
+// for the access log when Options.AccessLog is off.
+var discardLogger = slog.New(slog.DiscardHandler)
+
+// logs carries the relay's loggers. log always receives warnings, errors,
+// audit lines and lifecycle messages; accessLog receives per-request and
+// housekeeping lines, and discards them unless access logging is on.
+type logs struct {
+	log       *slog.Logger
+	accessLog *slog.Logger
+}
+
+// Options configures a relay Server. The zero value is an in-memory relay
+// with no attachments, webhooks, tracing, admin API or logging.
+type Options struct {
+	// Logger receives the relay's log lines; nil discards them.
+	Logger *slog.Logger
+	// AccessLog also logs every request and housekeeping pass to Logger.
+	AccessLog bool
+
+	// DataDir persists bundles and queues in this directory; empty keeps
+	// state in memory only.
+	DataDir string
+	// Repair drops corrupt or inconsistent stored records when DataDir is
+	// opened, instead of failing.
+	Repair bool
+	// StorageKey seals queued envelopes in DataDir, so the log shows only
+	// who each envelope is for; empty stores them in the clear.
+	StorageKey string
+	// PreviousStorageKey is the key StorageKey replaces. Envelopes sealed
+	// under it are resealed under StorageKey in the background while the
+	// relay serves (see Storage); keep it set until the admin API reports
+	// the rotation complete.
+	PreviousStorageKey string
+
+	// AckRetention keeps acked envelopes for this long, so a client that
+	// crashed straight after acking can fetch them again with
+	// include_acked=1; zero discards them when acked.
+	AckRetention time.Duration
+
+	// Chaos injects drops, delays and duplicates into queue delivery, for
+	// development only.
+	Chaos ChaosOptions
+
+	// AdminToken enables the admin API for requests that carry it as a
+	// bearer token; empty disables the admin API.
+	AdminToken string
+
+	// WebhookURLs receive relay events, signed with WebhookSecret, which
+	// must then be set. WebhookEvents selects the event types (nil means
+	// all of WebhookEvents()) and WebhookHighWater the queue length reported
+	// as high water (zero means DefaultHighWater).
+	WebhookURLs      []string
+	WebhookSecret    string
+	WebhookEvents    []string
+	WebhookHighWater int
+
+	// OTLPEndpoint receives a trace span per request over OTLP/HTTP; empty
+	// disables tracing. ServiceName names the relay in spans and defaults to
+	// "ciphera-relay".
+	OTLPEndpoint string
+	ServiceName  string
+
+	// Challenge must be answered by every registration of a new username;
+	// nil leaves registration open. See NewTokenChallenge, NewPoWChallenge
+	// and NewCAPTCHAChallenge.
+	Challenge Challenge
+
+	// DiscoveryURL is the relay base URL served at
+	// GET /.well-known/ciphera-relay, so user@host addresses whose host is
+	// this relay's find it; empty leaves the endpoint unregistered.
+	DiscoveryURL string
+
+	// Blobs configures the optional attachment store.
+	Blobs BlobOptions
+
+	// Notice is announced to clients in GET /server-info until the admin
+	// API replaces it: a maintenance window, a message of the day and the
+	// oldest client version supported.
+	Notice domain.RelayNotice
+
+	// RouteLimits bounds the requests handled at once per route, keyed by
+	// the route's pattern, e.g. "POST /register" (see DefaultRouteLimits);
+	// routes without an entry are unbounded. GET /healthz is never limited.
+	RouteLimits map[string]RouteLimit
+
+	// CheckoutLimit caps how many of each user's one-time prekeys are
+	// checked out per window (see DefaultCheckoutLimit); the zero value sets
+	// no cap.
+	CheckoutLimit CheckoutLimit
+}
+
+// BlobOptions configures the attachment store.
+type BlobOptions struct {
+	// Backend is BlobBackendNone (or empty), BlobBackendFS or BlobBackendS3.
+	Backend string
+	// MaxBytes caps an attachment's size (zero means DefaultBlobMax) and TTL
+	// is its lifetime before garbage collection (zero means DefaultBlobTTL).
+	MaxBytes int64
+	TTL      time.Duration
+
+	// Dir is the fs backend's root directory and PublicURL the externally
+	// reachable relay URL its pre-signed links point at.
+	Dir       string
+	PublicURL string
+
+	// S3Endpoint, S3Bucket and S3Region locate the s3 backend's bucket;
+	// S3AccessKey and S3SecretKey sign its requests.
+	S3Endpoint  string
+	S3Bucket    string
+	S3Region    string
+	S3AccessKey string
+	S3SecretKey string
+}
+
+// Server is a relay as an http.Handler, so it can be mounted in another mux
+// or an httptest.Server as well as served by cmd/relay.
+type Server struct {
+	mux     *http.ServeMux
+	state   *state
+	blobs   *blobService // nil when no attachment store is configured
+	traces  *tracer      // nil when tracing is off
+	notices *noticeBoard
+	limits  map[string]*limiter // by route pattern
+
+	stopGC     context.CancelFunc
+	stopTraces context.CancelFunc
+
+	logs
+}
+
+// NewServer builds a relay from opts, loading any persisted state, and starts
+// its background work: garbage collection for pairing mailboxes, attachments
+// and expired envelopes, storage key rotation, webhook delivery and trace
+// export. Call Close once
+// the Server no longer serves requests.
+func NewServer(opts Options) (*Server, error) {
+	l := logs{log: opts.Logger, accessLog: discardLogger}
+	if l.log == nil {
+		l.log = discardLogger
+	}
+	if opts.AccessLog {
+		l.accessLog = l.log
+	}
+
+	// Optional event webhooks.
+	var hooks *webhookService
+	events, highWater := opts.WebhookEvents, opts.WebhookHighWater
+	if events == nil {
+		events = allEvents
+	}
+	if len(opts.WebhookURLs) > 0 {
+		if highWater == 0 {
+			highWater = DefaultHighWater
+		}
+		var err error
+		hooks, err = newWebhookService(opts.WebhookURLs, opts.WebhookSecret, events, highWater, l)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.PreviousStorageKey != "" && (opts.StorageKey == "" || opts.StorageKey == opts.PreviousStorageKey) {
+		return nil, errors.New("a previous storage key needs a different storage key to rotate to")
+	}
+	if opts.AckRetention < 0 {
+		return nil, fmt.Errorf("negative ack retention %v", opts.AckRetention)
+	}
+	if err := opts.Chaos.validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.CheckoutLimit.validate(); err != nil {
+		return nil, err
+	}
+
+	if param, msg := checkNotice(opts.Notice); param != "" {
+		return nil, fmt.Errorf("notice: %s", msg)
+	}
+
+	srv := &Server{
+		mux:     http.NewServeMux(),
+		state:   newState(hooks, l),
+		notices: &noticeBoard{notice: opts.Notice},
+		limits:  make(map[string]*limiter, len(opts.RouteLimits)),
+		logs:    l,
+	}
+	for route, lim := range opts.RouteLimits {
+		if err := lim.validate(); err != nil {
+			return nil, fmt.Errorf("route limit for %q: %w", route, err)
+		}
+		srv.limits[route] = newLimiter(route, lim, l)
+	}
+
+	// Optional request tracing to an OpenTelemetry collector.
+	if opts.OTLPEndpoint != "" {
+		var err error
+		srv.traces, err = newTracer(opts.OTLPEndpoint, opts.ServiceName, l)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Blobs.Backend != "" && opts.Blobs.Backend != BlobBackendNone {
+		if err := srv.setupBlobs(opts.Blobs); err != nil {
+			return nil, fmt.Errorf("blob store: %w", err)
+		}
+	}
+
+	s := srv.state
+	s.challenge = opts.Challenge
+	s.ackRetention = opts.AckRetention
+	s.checkoutLimit = opts.CheckoutLimit
+	s.chaos = newChaos(opts.Chaos)
+	if s.chaos != nil {
+		l.log.Warn("Chaos enabled: envelopes will be dropped, delayed and duplicated",
+			"drop", opts.Chaos.Drop, "delay", opts.Chaos.Delay, "duplicate", opts.Chaos.Duplicate)
+	}
+	if opts.DataDir != "" {
+		store, data, rep, err := openDiskStore(opts.DataDir, opts.Repair, opts.StorageKey, opts.PreviousStorageKey)
+		l.logRecovery(opts.DataDir, rep, opts.Repair)
+		if err != nil {
+			return nil, fmt.Errorf("storage: %w", err)
+		}
+		s.store = store
+		s.bundles, s.queues, s.nextSeq = data.bundles, data.queues, data.nextSeq
+		s.fingerprints = indexFingerprints(s.bundles)
+		s.restrictions, s.backups, s.presence = data.restrictions, data.backups, data.presence
+		if s.usage, err = openUsage(opts.DataDir); err != nil {
+			_ = store.close()
+			return nil, fmt.Errorf("storage: %w", err)
+		}
+		if s.journal, err = openJournal(opts.DataDir, l); err != nil {
+			_ = store.close()
+			return nil, fmt.Errorf("storage: %w", err)
+		}
+	}
+
+	// Register HTTP endpoints. Middlewares: recover -> reqid -> tracing -> logging -> handler
+	srv.handle("POST /register", s.handleRegister)                   // POST /register
+	srv.handle("GET /prekey/{username}", s.handleGet)                // GET  /prekey/{username}
+	srv.handle("POST /prekey/{username}/checkout", s.handleCheckout) // POST /prekey/{username}/checkout
+	srv.handle("POST /msg/{user}", s.handleEnqueue)                  // POST /msg/{user}
+	srv.handle("GET /msg/{user}", s.handleFetch)                     // GET  /msg/{user}
+	srv.handle("POST /msg/{user}/ack", s.handleAck)                  // POST /msg/{user}/ack
+	srv.handle("GET /msg/{user}/stats", s.handleQueueStats)          // GET  /msg/{user}/stats
+	srv.handle("GET /ws/{user}", s.handlePush)                       // GET  /ws/{user}
+
+	// Sealed client backups, signed by the account's signing key.
+	srv.handle("PUT /backup/{user}", s.handlePutBackup) // PUT  /backup/{user}
+	srv.handle("GET /backup/{user}", s.handleGetBackup) // GET  /backup/{user}
+
+	// Last-activity presence, shown only as each user's signed policy allows.
+	srv.handle("PUT /presence/{user}", s.handlePutPresence) // PUT  /presence/{user}
+	srv.handle("GET /presence/{user}", s.handlePresence)    // GET  /presence/{user}
+
+	// Traffic counts and the queue journal, for the account's owner only.
+	srv.handle("GET /account/{user}/usage", s.handleUsage)     // GET  /account/{user}/usage
+	srv.handle("GET /account/{user}/journal", s.handleJournal) // GET  /account/{user}/journal
+
+	// Admin API, only when a token is configured.
+	if opts.AdminToken != "" {
+		admin := l.withAdminAuth(opts.AdminToken)
+		srv.handle("PUT /admin/users/{user}/restriction", s.handleRestrict, admin) // PUT    /admin/users/{user}/restriction
+		srv.handle("DELETE /admin/users/{user}/restriction", s.handleLift, admin)  // DELETE /admin/users/{user}/restriction
+		srv.handle("GET /admin/restrictions", s.handleListRestrictions, admin)     // GET    /admin/restrictions
+		srv.handle("GET /admin/usage", s.handleUsageTotals, admin)                 // GET    /admin/usage
+		srv.handle("PUT /admin/notice", srv.handleSetNotice, admin)                // PUT    /admin/notice
+		srv.handle("GET /admin/storage/rotation", s.handleRotation, admin)         // GET    /admin/storage/rotation
+		srv.handle("GET /admin/export", s.handleExport, admin)                     // GET    /admin/export
+		srv.handle("POST /admin/import", s.handleImport, admin)                    // POST   /admin/import
+		l.log.Info("Admin API enabled")
+	}
+
+	// Pairing mailboxes for short-code device and contact pairing.
+	pairs := newPairService(l)
+	srv.handle("POST /pair/{box}", pairs.handlePost)     // POST   /pair/{box}
+	srv.handle("GET /pair/{box}", pairs.handleGet)       // GET    /pair/{box}
+	srv.handle("DELETE /pair/{box}", pairs.handleDelete) // DELETE /pair/{box}
+
+	// Build and protocol versions and the operator's notice, for clients
+	// checking compatibility.
+	srv.handle("GET /server-info", srv.handleServerInfo) // GET  /server-info
+
+	// Relay discovery for user@host addresses (see package address).
+	if opts.DiscoveryURL != "" {
+		srv.handle("GET "+address.WellKnownPath, discoveryHandler(opts.DiscoveryURL)) // GET  /.well-known/ciphera-relay
+	}
+
+	// Every limit must name a route that was registered.
+	for route, lim := range srv.limits {
+		if !lim.registered {
+			return nil, fmt.Errorf("route limit for unknown route %q", route)
+		}
+	}
+
+	// Simple health check for readiness/liveness probes. It bypasses the
+	// middleware and route limits, so it answers however busy the relay is.
+	srv.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Background garbage collection for pairing mailboxes, attachments and
+	// expired envelopes, and webhook delivery. Tracing has its own context
+	// so spans of the last requests are still sent while the rest stops.
+	gcCtx, stopGC := context.WithCancel(context.Background())
+	traceCtx, stopTraces := context.WithCancel(context.Background())
+	srv.stopGC, srv.stopTraces = stopGC, stopTraces
+	if srv.traces != nil {
+		go srv.traces.run(traceCtx)
+		l.log.Info("Tracing enabled", "collector", srv.traces.url, "service", srv.traces.service)
+	}
+	if hooks != nil {
+		go hooks.run(gcCtx)
+		l.log.Info("Webhooks enabled", "urls", len(opts.WebhookURLs), "events", events)
+	}
+	go pairs.runGC(gcCtx)
+	go s.runExpiry(gcCtx)
+	go s.runRotation(gcCtx)
+	go s.usage.run(gcCtx, l)
+	if srv.blobs != nil {
+		go srv.blobs.runGC(gcCtx)
+		l.log.Info("Blob store enabled", "backend", opts.Blobs.Backend)
+	}
+	return srv, nil
+}
+
+// ServeHTTP serves the relay API.
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mux.ServeHTTP(w, r)
+}
+
+// Close stops the background work, drains waiting fetches and push
+// connections (see Drain), waits for the last spans to be exported,
+// saves the usage counts and closes the store and queue journal. Requests
+// still in flight may fail once it returns.
+func (srv *Server) Close() error {
+	srv.stopGC()
+	srv.stopTraces()
+	srv.Drain()
+	if srv.traces != nil {
+		<-srv.traces.done
+	}
+	usageErr := srv.state.usage.save()
+	srv.state.mu.Lock()
+	defer srv.state.mu.Unlock()
+	return errors.Join(usageErr, srv.state.store.close(), srv.state.journal.close())
+}
+
+// handle registers h for pattern behind the standard middleware chain, the
+// route's concurrency limit if it has one, and any extra middlewares.
+func (srv *Server) handle(pattern string, h http.HandlerFunc, extra ...func(http.HandlerFunc) http.HandlerFunc) {
+	mws := []func(http.HandlerFunc) http.HandlerFunc{
+		srv.withRecover, withReqID, srv.traces.withTracing, srv.withLogging,
+	}
+	if lim := srv.limits[pattern]; lim != nil {
+		lim.registered = true
+		mws = append(mws, lim.withLimit)
+	}
+	mws = append(mws, extra...)
+	srv.mux.HandleFunc(pattern, chain(h, mws...))
+}
+
+// setupBlobs builds the configured blob backend and registers the attachment routes.
+func (srv *Server) setupBlobs(o BlobOptions) error {
+	var backend blobBackend
+	switch o.Backend {
+	case BlobBackendFS:
+		if o.PublicURL == "" {
+			return errors.New("the fs backend needs a public URL")
+		}
+		fs, err := newFSBlobBackend(o.Dir, o.PublicURL)
+		if err != nil {
+			return err
+		}
+		fs.lookup = func(id string) (*blobMeta, bool) { return srv.blobs.lookup(id) }
+		srv.handle("PUT /blob/{id}/part/{n}", fs.handlePut)
+		srv.handle("GET /blob/{id}/data", fs.handleData)
+		backend = fs
+	case BlobBackendS3:
+		s3, err := newS3BlobBackend(
+			o.S3Endpoint,
+			o.S3Bucket,
+			o.S3Region,
+			o.S3AccessKey,
+			o.S3SecretKey,
+			&http.Client{Timeout: blobClientTimeout},
+		)
+		if err != nil {
+			return err
+		}
+		backend = s3
+	default:
+		return fmt.Errorf("unknown blob backend %q", o.Backend)
+	}
+
+	maxBytes, ttl := o.MaxBytes, o.TTL
+	if maxBytes == 0 {
+		maxBytes = DefaultBlobMax
+	}
+	if ttl == 0 {
+		ttl = DefaultBlobTTL
+	}
+	srv.blobs = newBlobService(backend, maxBytes, ttl, srv.logs)
+	srv.handle("POST /blob", srv.blobs.handleCreate)                 // POST /blob
+	srv.handle("GET /blob/{id}", srv.blobs.handleStatus)             // GET  /blob/{id}
+	srv.handle("POST /blob/{id}/complete", srv.blobs.handleComplete) // POST /blob/{id}/complete
+	srv.handle("GET /blob/{id}/download", srv.blobs.handleDownload)  // GET  /blob/{id}/download
+	return nil
+}
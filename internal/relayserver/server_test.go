@@ -0,0 +1,1614 @@
+package relayserver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/address"
+	"ciphera/internal/protocol/pow"
+	"ciphera/internal/protocol/relayauth"
+	"ciphera/internal/protocol/signchain"
+	"ciphera/internal/relay"
+	"ciphera/internal/relayserver"
+)
+
+// newRelay serves a relay built from opts until the test ends.
+func newRelay(t *testing.T, opts relayserver.Options) *relay.HTTP {
+	t.Helper()
+	rs, err := relayserver.NewServer(opts)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	s := httptest.NewServer(rs)
+	t.Cleanup(func() {
+		s.Close()
+		if err := rs.Close(); err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	})
+	return relay.NewHTTP(s.URL, s.Client())
+}
+
+func TestNewServer_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	c := newRelay(t, relayserver.Options{})
+
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob"}, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle: %v", err)
+	}
+	if b, err := c.FetchPrekeyBundle(ctx, "bob", domain.RequestAuth{}); err != nil || b.Username != "bob" {
+		t.Fatalf("FetchPrekeyBundle = %+v, %v; want bob's bundle", b, err)
+	}
+	if _, err := c.SendMessage(ctx, domain.Envelope{From: "alice", To: "bob", Cipher: []byte("ct")}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	envs, _, err := c.FetchMessages(ctx, "bob", 0, domain.RequestAuth{})
+	if err != nil || len(envs) != 1 || envs[0].ID == "" || string(envs[0].Cipher) != "ct" {
+		t.Fatalf("FetchMessages = %+v, %v; want one envelope with an ID", envs, err)
+	}
+	if err := c.AckMessages(ctx, "bob", []string{envs[0].ID}); err != nil {
+		t.Fatalf("AckMessages: %v", err)
+	}
+	if envs, _, err := c.FetchMessages(ctx, "bob", 0, domain.RequestAuth{}); err != nil || len(envs) != 0 {
+		t.Fatalf("FetchMessages after ack = %+v, %v; want none", envs, err)
+	}
+
+	info, err := c.ServerInfo(ctx)
+	if err != nil {
+		t.Fatalf("ServerInfo: %v", err)
+	}
+	if len(info.Capabilities) != 0 || info.Notice != nil {
+		t.Fatalf("ServerInfo = %+v; want no capabilities without a blob backend and no notice", info)
+	}
+}
+
+func TestNewServer_Notice(t *testing.T) {
+	ctx := context.Background()
+	start := domain.RelayNotice{MaintenanceStartUTC: 1700000000, MaintenanceEndUTC: 1700003600, MOTD: "hello"}
+	rs, err := relayserver.NewServer(relayserver.Options{AdminToken: "t0ken", Notice: start})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	s := httptest.NewServer(rs)
+	t.Cleanup(func() {
+		s.Close()
+		_ = rs.Close()
+	})
+	c := relay.NewHTTP(s.URL, s.Client())
+
+	if info, err := c.ServerInfo(ctx); err != nil || info.Notice == nil || *info.Notice != start {
+		t.Fatalf("ServerInfo = %+v, %v; want the notice from Options", info, err)
+	}
+
+	// put replaces the notice through the admin API.
+	put := func(token, body string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPut, s.URL+"/admin/notice", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := s.Client().Do(req)
+		if err != nil {
+			t.Fatalf("PUT /admin/notice: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := put("wrong", `{}`); code != http.StatusUnauthorized {
+		t.Fatalf("PUT /admin/notice with a wrong token = %d; want 401", code)
+	}
+	for _, body := range []string{
+		`{"min_client_version":"1.2"}`,
+		`{"client_gate":"block"}`,
+		`{"min_client_version":"v1.2.0","client_gate":"nag"}`,
+		`{"maintenance_start_utc":1700003600,"maintenance_end_utc":1700000000}`,
+	} {
+		if code := put("t0ken", body); code != http.StatusBadRequest {
+			t.Errorf("PUT /admin/notice %s = %d; want 400", body, code)
+		}
+	}
+	if code := put("t0ken", `{"min_client_version":"v1.2.0","client_gate":"block"}`); code != http.StatusOK {
+		t.Fatalf("PUT /admin/notice = %d; want 200", code)
+	}
+	want := domain.RelayNotice{MinClientVersion: "v1.2.0", ClientGate: domain.GateBlock}
+	if info, err := c.ServerInfo(ctx); err != nil || info.Notice == nil || *info.Notice != want {
+		t.Fatalf("ServerInfo after PUT = %+v, %v; want %+v", info.Notice, err, want)
+	}
+	if code := put("t0ken", `{}`); code != http.StatusOK {
+		t.Fatalf("PUT /admin/notice {} = %d; want 200", code)
+	}
+	if info, err := c.ServerInfo(ctx); err != nil || info.Notice != nil {
+		t.Fatalf("ServerInfo after withdrawing = %+v, %v; want no notice", info.Notice, err)
+	}
+}
+
+func TestNewServer_QueueStats(t *testing.T) {
+	ctx := context.Background()
+	c := newRelay(t, relayserver.Options{})
+
+	var seqs []uint64
+	for _, from := range []string{"alice", "carol", "alice"} {
+		seq, err := c.SendMessage(ctx, domain.Envelope{From: from, To: "bob"})
+		if err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		seqs = append(seqs, seq)
+	}
+	if seqs[0] == 0 || seqs[1] <= seqs[0] || seqs[2] <= seqs[1] {
+		t.Fatalf("sequence numbers = %v; want increasing from 1", seqs)
+	}
+
+	// Bob fetches one envelope: Alice's first. Her second stays queued, and
+	// Carol's is untouched.
+	envs, _, err := c.FetchMessages(ctx, "bob", 1, domain.RequestAuth{})
+	if err != nil || len(envs) != 1 {
+		t.Fatalf("FetchMessages = %+v, %v; want one envelope", envs, err)
+	}
+	if err := c.AckMessages(ctx, "bob", []string{envs[0].ID}); err != nil {
+		t.Fatalf("AckMessages: %v", err)
+	}
+	st, err := c.QueueStats(ctx, "bob", "alice")
+	if err != nil {
+		t.Fatalf("QueueStats: %v", err)
+	}
+	if st.Queued != 1 || st.FetchedSeq < seqs[0] || st.FetchedSeq >= seqs[2] {
+		t.Fatalf("QueueStats for alice = %+v; want 1 queued, watermark in [%d, %d)", st, seqs[0], seqs[2])
+	}
+	if st, err := c.QueueStats(ctx, "bob", ""); err != nil || st.Queued != 2 || st.FetchedSeq >= seqs[1] {
+		t.Fatalf("QueueStats for everyone = %+v, %v; want 2 queued, watermark below %d", st, err, seqs[1])
+	}
+	if st, err := c.QueueStats(ctx, "bob", "dave"); err != nil || st.Queued != 0 || st.FetchedSeq < seqs[2] {
+		t.Fatalf("QueueStats for a sender with nothing queued = %+v, %v; want watermark %d", st, err, seqs[2])
+	}
+}
+
+// An envelope that expires unfetched is reported to its sender, once, on the
+// sender's next fetch.
+func TestNewServer_ExpiredSent(t *testing.T) {
+	ctx := context.Background()
+	c := newRelay(t, relayserver.Options{})
+
+	deadline := time.Now().Unix() + 1
+	seq, err := c.SendMessage(ctx, domain.Envelope{From: "alice", To: "bob", ExpiresUTC: deadline})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if _, err := c.SendMessage(ctx, domain.Envelope{From: "alice", To: "bob"}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	time.Sleep(time.Until(time.Unix(deadline, 0)))
+
+	envs, rep, err := c.FetchMessages(ctx, "bob", 0, domain.RequestAuth{})
+	if err != nil || len(envs) != 1 || rep.Expired != 1 || len(rep.ExpiredSent) != 0 {
+		t.Fatalf("bob's fetch = %d envelope(s), %+v, %v; want 1, 1 expired, none sent", len(envs), rep, err)
+	}
+	want := []string{fmt.Sprint(seq)}
+	if _, rep, err := c.FetchMessages(ctx, "alice", 0, domain.RequestAuth{}); err != nil || rep.Expired != 0 || !slices.Equal(rep.ExpiredSent, want) {
+		t.Fatalf("alice's fetch = %+v, %v; want sent %v expired", rep, err, want)
+	}
+	if _, rep, err := c.FetchMessages(ctx, "alice", 0, domain.RequestAuth{}); err != nil || len(rep.ExpiredSent) != 0 {
+		t.Fatalf("alice's second fetch = %+v, %v; want nothing reported again", rep, err)
+	}
+}
+
+func TestNewServer_AckRetention(t *testing.T) {
+	ctx := context.Background()
+	rs, err := relayserver.NewServer(relayserver.Options{AckRetention: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	s := httptest.NewServer(rs)
+	t.Cleanup(func() {
+		s.Close()
+		_ = rs.Close()
+	})
+	c := relay.NewHTTP(s.URL, s.Client())
+
+	// fetchAll fetches bob's queue with acked envelopes included.
+	fetchAll := func() []domain.Envelope {
+		t.Helper()
+		resp, err := s.Client().Get(s.URL + "/msg/bob?include_acked=1")
+		if err != nil {
+			t.Fatalf("GET include_acked: %v", err)
+		}
+		defer resp.Body.Close()
+		var envs []domain.Envelope
+		if err := json.NewDecoder(resp.Body).Decode(&envs); err != nil {
+			t.Fatalf("decoding fetch: %v", err)
+		}
+		return envs
+	}
+
+	for _, ct := range []string{"one", "two"} {
+		if _, err := c.SendMessage(ctx, domain.Envelope{From: "alice", To: "bob", Cipher: []byte(ct)}); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+	envs, _, err := c.FetchMessages(ctx, "bob", 1, domain.RequestAuth{})
+	if err != nil || len(envs) != 1 {
+		t.Fatalf("FetchMessages = %+v, %v; want one envelope", envs, err)
+	}
+	if err := c.AckMessages(ctx, "bob", []string{envs[0].ID}); err != nil {
+		t.Fatalf("AckMessages: %v", err)
+	}
+
+	// A plain fetch no longer sees the acked envelope; include_acked does,
+	// in arrival order and marked as acked.
+	if envs, _, err := c.FetchMessages(ctx, "bob", 0, domain.RequestAuth{}); err != nil || len(envs) != 1 || string(envs[0].Cipher) != "two" {
+		t.Fatalf("FetchMessages after ack = %+v, %v; want only the unacked envelope", envs, err)
+	}
+	all := fetchAll()
+	if len(all) != 2 || string(all[0].Cipher) != "one" || all[0].AckedUTC == 0 || all[1].AckedUTC != 0 {
+		t.Fatalf("include_acked fetch = %+v; want the acked envelope first, then the queued one", all)
+	}
+
+	// Once the retention has passed the tombstone is purged.
+	time.Sleep(300 * time.Millisecond)
+	if all := fetchAll(); len(all) != 1 || string(all[0].Cipher) != "two" {
+		t.Fatalf("include_acked fetch after retention = %+v; want only the queued envelope", all)
+	}
+}
+
+func TestNewServer_Chaos(t *testing.T) {
+	ctx := context.Background()
+	send := func(c *relay.HTTP) {
+		t.Helper()
+		if _, err := c.SendMessage(ctx, domain.Envelope{From: "alice", To: "bob", Cipher: []byte("ct")}); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+
+	// A lost envelope is still given a sequence number.
+	c := newRelay(t, relayserver.Options{Chaos: relayserver.ChaosOptions{Drop: 1}})
+	send(c)
+	if envs, _, err := c.FetchMessages(ctx, "bob", 0, domain.RequestAuth{}); err != nil || len(envs) != 0 {
+		t.Fatalf("FetchMessages with drop 1 = %+v, %v; want none", envs, err)
+	}
+
+	c = newRelay(t, relayserver.Options{Chaos: relayserver.ChaosOptions{Duplicate: 1}})
+	send(c)
+	envs, _, err := c.FetchMessages(ctx, "bob", 0, domain.RequestAuth{})
+	if err != nil || len(envs) != 2 || envs[0].ID != envs[1].ID {
+		t.Fatalf("FetchMessages with duplicate 1 = %+v, %v; want one envelope twice", envs, err)
+	}
+
+	// Delayed envelopes turn up once their hold has passed.
+	c = newRelay(t, relayserver.Options{Chaos: relayserver.ChaosOptions{Delay: 50 * time.Millisecond}})
+	send(c)
+	time.Sleep(60 * time.Millisecond)
+	if envs, _, err := c.FetchMessages(ctx, "bob", 0, domain.RequestAuth{}); err != nil || len(envs) != 1 {
+		t.Fatalf("FetchMessages after the delay = %+v, %v; want one envelope", envs, err)
+	}
+}
+
+func TestNewServer_Push(t *testing.T) {
+	c := newRelay(t, relayserver.Options{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	keys := map[string]domain.Ed25519Private{}
+	for _, user := range []string{"bob", "mallory"} {
+		priv, pub, err := crypto.GenerateEd25519()
+		if err != nil {
+			t.Fatalf("GenerateEd25519: %v", err)
+		}
+		keys[user] = priv
+		if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: user, SignKey: pub}, domain.ChallengeAnswer{}); err != nil {
+			t.Fatalf("RegisterPrekeyBundle: %v", err)
+		}
+	}
+	// sign signs an upgrade to bob's push connection as signer.
+	sign := func(signer string) domain.RequestAuth {
+		now := time.Now().Unix()
+		return domain.RequestAuth{TimeUTC: now, Sig: relayauth.SignRequest(keys[signer], "bob", "GET", "/ws/bob", "", now)}
+	}
+
+	// A plain GET is no upgrade.
+	resp, err := http.Get(c.Base + "/ws/bob")
+	if err != nil {
+		t.Fatalf("GET /ws/bob: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("GET /ws/bob without upgrade = %d, want 400", resp.StatusCode)
+	}
+
+	// Only bob may connect, and refused attempts take none of his 8
+	// connections.
+	for range 9 {
+		if _, err := c.Subscribe(ctx, "bob", domain.RequestAuth{}); err == nil {
+			t.Fatal("Subscribe unsigned succeeded")
+		}
+		if _, err := c.Subscribe(ctx, "bob", sign("mallory")); err == nil {
+			t.Fatal("Subscribe signed by another user succeeded")
+		}
+	}
+
+	push, err := c.Subscribe(ctx, "bob", sign("bob"))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	seq, err := c.SendMessage(ctx, domain.Envelope{From: "alice", To: "bob", Cipher: []byte("ct")})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	select {
+	case env := <-push:
+		if env.ID != fmt.Sprint(seq) || string(env.Cipher) != "ct" {
+			t.Fatalf("pushed %+v, want envelope %d", env, seq)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no envelope pushed")
+	}
+
+	// Pushed envelopes stay queued until acked.
+	if envs, _, err := c.FetchMessages(ctx, "bob", 0, domain.RequestAuth{}); err != nil || len(envs) != 1 {
+		t.Fatalf("FetchMessages after push = %+v, %v; want the envelope", envs, err)
+	}
+
+	// Each user may hold a limited number of connections.
+	for range 7 {
+		if _, err := c.Subscribe(ctx, "bob", sign("bob")); err != nil {
+			t.Fatalf("Subscribe within the limit: %v", err)
+		}
+	}
+	if _, err := c.Subscribe(ctx, "bob", sign("bob")); err == nil || errors.Is(err, domain.ErrPushUnsupported) {
+		t.Fatalf("Subscribe over the limit = %v; want busy", err)
+	}
+
+	// The channel closes once ctx ends.
+	cancel()
+	select {
+	case _, ok := <-push:
+		if ok {
+			t.Fatal("envelope pushed after cancel")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("push channel not closed after cancel")
+	}
+}
+
+func TestNewServer_WaitFetch(t *testing.T) {
+	c := newRelay(t, relayserver.Options{})
+	ctx := context.Background()
+
+	resp, err := http.Get(c.Base + "/msg/bob?wait=soon")
+	if err != nil {
+		t.Fatalf("GET /msg/bob?wait=soon: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("GET /msg/bob?wait=soon = %d, want 400", resp.StatusCode)
+	}
+
+	// With nothing queued, the fetch answers empty once the wait is over.
+	start := time.Now()
+	envs, _, err := c.WaitMessages(ctx, "bob", 0, 300*time.Millisecond, domain.RequestAuth{})
+	if err != nil || len(envs) != 0 {
+		t.Fatalf("WaitMessages on empty queue = %+v, %v; want none", envs, err)
+	}
+	if took := time.Since(start); took < 250*time.Millisecond {
+		t.Fatalf("WaitMessages on empty queue answered after %v, want the wait", took)
+	}
+
+	// An envelope sent while the fetch waits is returned at once.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = c.SendMessage(ctx, domain.Envelope{From: "alice", To: "bob", Cipher: []byte("ct")})
+	}()
+	start = time.Now()
+	envs, _, err = c.WaitMessages(ctx, "bob", 0, 10*time.Second, domain.RequestAuth{})
+	if err != nil || len(envs) != 1 || string(envs[0].Cipher) != "ct" {
+		t.Fatalf("WaitMessages = %+v, %v; want the envelope", envs, err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Fatalf("WaitMessages answered after %v, want on arrival", took)
+	}
+
+	// A queued envelope is returned without waiting.
+	start = time.Now()
+	if envs, _, err = c.WaitMessages(ctx, "bob", 0, 10*time.Second, domain.RequestAuth{}); err != nil || len(envs) != 1 {
+		t.Fatalf("WaitMessages with a queued envelope = %+v, %v", envs, err)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Fatalf("WaitMessages with a queued envelope answered after %v", took)
+	}
+}
+
+func TestNewServer_DataDirSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	rs, err := relayserver.NewServer(relayserver.Options{DataDir: dir})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	s := httptest.NewServer(rs)
+	_, err = relay.NewHTTP(s.URL, s.Client()).SendMessage(ctx, domain.Envelope{From: "alice", To: "bob"})
+	s.Close()
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if err := rs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	envs, _, err := newRelay(t, relayserver.Options{DataDir: dir}).FetchMessages(ctx, "bob", 0, domain.RequestAuth{})
+	if err != nil || len(envs) != 1 {
+		t.Fatalf("FetchMessages after restart = %+v, %v; want the queued envelope", envs, err)
+	}
+}
+
+func TestNewServer_DroppedSeqSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Every envelope is lost in transit, yet its sequence number is stored.
+	rs, err := relayserver.NewServer(relayserver.Options{DataDir: dir, Chaos: relayserver.ChaosOptions{Drop: 1}})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	s := httptest.NewServer(rs)
+	dropped, err := relay.NewHTTP(s.URL, s.Client()).SendMessage(ctx, domain.Envelope{From: "alice", To: "bob"})
+	s.Close()
+	if err != nil || dropped == 0 {
+		t.Fatalf("SendMessage = %d, %v", dropped, err)
+	}
+	if err := rs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	seq, err := newRelay(t, relayserver.Options{DataDir: dir}).SendMessage(ctx, domain.Envelope{From: "alice", To: "bob"})
+	if err != nil || seq <= dropped {
+		t.Fatalf("SendMessage after restart = %d, %v; want more than %d", seq, err, dropped)
+	}
+}
+
+func TestNewServer_StorageKeySealsQueues(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	send := func(opts relayserver.Options, from string) {
+		t.Helper()
+		rs, err := relayserver.NewServer(opts)
+		if err != nil {
+			t.Fatalf("NewServer: %v", err)
+		}
+		s := httptest.NewServer(rs)
+		_, err = relay.NewHTTP(s.URL, s.Client()).SendMessage(ctx, domain.Envelope{From: from, To: "bob", Timestamp: 1700000000})
+		s.Close()
+		if err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		if err := rs.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+
+	// An envelope stored in the clear is sealed once a key is given, along
+	// with the ones sent after.
+	send(relayserver.Options{DataDir: dir}, "alice")
+	sealed := relayserver.Options{DataDir: dir, StorageKey: "correct horse"}
+	send(sealed, "carol")
+	raw, err := os.ReadFile(filepath.Join(dir, "state.log"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	for _, leak := range []string{"alice", "carol", "1700000000"} {
+		if bytes.Contains(raw, []byte(leak)) {
+			t.Errorf("state log contains %q; want it sealed", leak)
+		}
+	}
+
+	for name, key := range map[string]string{"no key": "", "wrong key": "battery staple"} {
+		if _, err := relayserver.NewServer(relayserver.Options{DataDir: dir, Repair: true, StorageKey: key}); err == nil {
+			t.Errorf("%s: NewServer succeeded; want an error", name)
+		}
+	}
+
+	envs, _, err := newRelay(t, sealed).FetchMessages(ctx, "bob", 0, domain.RequestAuth{})
+	if err != nil || len(envs) != 2 || envs[0].From != "alice" || envs[1].From != "carol" || envs[1].Timestamp != 1700000000 {
+		t.Fatalf("FetchMessages after restart = %+v, %v; want both envelopes", envs, err)
+	}
+}
+
+func TestNewServer_StorageKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	const n = 300 // more than one rotation batch
+
+	// start runs a server on dir until the returned stop is called.
+	start := func(opts relayserver.Options) (*httptest.Server, func()) {
+		t.Helper()
+		rs, err := relayserver.NewServer(opts)
+		if err != nil {
+			t.Fatalf("NewServer: %v", err)
+		}
+		s := httptest.NewServer(rs)
+		return s, func() {
+			s.Close()
+			if err := rs.Close(); err != nil {
+				t.Errorf("Close: %v", err)
+			}
+		}
+	}
+
+	old := relayserver.Options{DataDir: dir, StorageKey: "correct horse"}
+	s, stop := start(old)
+	for i := range n {
+		env := domain.Envelope{From: fmt.Sprintf("s%d", i%3), To: "bob", Timestamp: int64(i)}
+		if _, err := relay.NewHTTP(s.URL, s.Client()).SendMessage(ctx, env); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+	stop()
+
+	rotating := relayserver.Options{
+		DataDir:            dir,
+		StorageKey:         "battery staple",
+		PreviousStorageKey: "correct horse",
+		AdminToken:         "t0ken",
+	}
+	for _, bad := range []relayserver.Options{
+		{DataDir: dir, PreviousStorageKey: "correct horse"},
+		{DataDir: dir, StorageKey: "correct horse", PreviousStorageKey: "correct horse"},
+	} {
+		if _, err := relayserver.NewServer(bad); err == nil {
+			t.Errorf("NewServer(%+v) succeeded; want an error", bad)
+		}
+	}
+
+	// A rotation cut short by a restart resumes from the log.
+	_, stop = start(rotating)
+	stop()
+	s, stop = start(rotating)
+	var v struct {
+		State     string `json:"state"`
+		Remaining int    `json:"remaining"`
+		Resealed  int    `json:"resealed"`
+	}
+	for deadline := time.Now().Add(10 * time.Second); v.State != "complete"; {
+		if time.Now().After(deadline) {
+			t.Fatalf("rotation = %+v after 10s; want complete", v)
+		}
+		time.Sleep(20 * time.Millisecond)
+		req, err := http.NewRequest(http.MethodGet, s.URL+"/admin/storage/rotation", nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer t0ken")
+		resp, err := s.Client().Do(req)
+		if err != nil {
+			t.Fatalf("GET /admin/storage/rotation: %v", err)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&v)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("decode rotation: %v", err)
+		}
+	}
+	if v.Remaining != 0 || v.Resealed != n {
+		t.Fatalf("rotation = %+v; want %d resealed and none remaining", v, n)
+	}
+	stop()
+
+	if _, err := relayserver.NewServer(old); err == nil {
+		t.Error("NewServer with the previous key succeeded after the rotation; want an error")
+	}
+	envs, _, err := newRelay(t, relayserver.Options{DataDir: dir, StorageKey: "battery staple"}).FetchMessages(ctx, "bob", 0, domain.RequestAuth{})
+	if err != nil || len(envs) != n || envs[n-1].Timestamp != n-1 {
+		t.Fatalf("FetchMessages after rotation = %d envelopes, %v; want %d", len(envs), err, n)
+	}
+}
+
+func TestNewServer_ExportImport(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// admin sends an admin request to s and returns the status and body.
+	admin := func(s *httptest.Server, method, path string, body []byte) (int, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(method, s.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer t0ken")
+		resp, err := s.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		raw, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("%s %s: reading body: %v", method, path, err)
+		}
+		return resp.StatusCode, raw
+	}
+	serve := func(opts relayserver.Options) (*httptest.Server, *relayserver.Server) {
+		t.Helper()
+		opts.AdminToken = "t0ken"
+		rs, err := relayserver.NewServer(opts)
+		if err != nil {
+			t.Fatalf("NewServer: %v", err)
+		}
+		return httptest.NewServer(rs), rs
+	}
+
+	// A relay kept in memory, with a bundle, queued envelopes and a
+	// restriction.
+	src, srcRS := serve(relayserver.Options{})
+	defer func() { src.Close(); _ = srcRS.Close() }()
+	c := relay.NewHTTP(src.URL, src.Client())
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob", SPKID: "spk-1700000000"}, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle: %v", err)
+	}
+	for i := range 3 {
+		if _, err := c.SendMessage(ctx, domain.Envelope{From: "alice", To: "bob", Timestamp: int64(i)}); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+	if err := c.AckMessages(ctx, "bob", []string{"1"}); err != nil {
+		t.Fatalf("AckMessages: %v", err)
+	}
+	if code, raw := admin(src, http.MethodPut, "/admin/users/mallory/restriction", []byte(`{"mode":"suspend"}`)); code != http.StatusOK {
+		t.Fatalf("PUT restriction = %d %s", code, raw)
+	}
+
+	code, snap := admin(src, http.MethodGet, "/admin/export", nil)
+	if code != http.StatusOK {
+		t.Fatalf("GET /admin/export = %d %s", code, snap)
+	}
+	want, err := relayserver.CheckSnapshot(snap)
+	if err != nil {
+		t.Fatalf("CheckSnapshot: %v", err)
+	}
+	if want.Bundles != 1 || want.Queued != 2 || want.Restrictions != 1 || want.NextSeq != 3 {
+		t.Fatalf("exported %+v; want 1 bundle, 2 queued, 1 restriction, next_seq 3", want)
+	}
+
+	// Tampering shows in the digest, and nothing is imported.
+	dst, dstRS := serve(relayserver.Options{DataDir: dir, StorageKey: "correct horse"})
+	tampered := bytes.Replace(snap, []byte(`"from":"alice"`), []byte(`"from":"carol"`), 1)
+	if code, raw := admin(dst, http.MethodPost, "/admin/import", tampered); code != http.StatusBadRequest || !bytes.Contains(raw, []byte("inconsistent")) {
+		t.Fatalf("POST /admin/import tampered = %d %s; want 400 inconsistent", code, raw)
+	}
+
+	// Into a sealed data directory, which then holds the same state.
+	code, raw := admin(dst, http.MethodPost, "/admin/import", snap)
+	if code != http.StatusOK {
+		t.Fatalf("POST /admin/import = %d %s", code, raw)
+	}
+	var got relayserver.SnapshotSummary
+	if err := json.Unmarshal(raw, &got); err != nil || got != want {
+		t.Fatalf("import summary = %+v, %v; want %+v", got, err, want)
+	}
+	if code, raw := admin(dst, http.MethodPost, "/admin/import", snap); code != http.StatusConflict || !bytes.Contains(raw, []byte("not_empty")) {
+		t.Fatalf("POST /admin/import again = %d %s; want 409 not_empty", code, raw)
+	}
+	dst.Close()
+	if err := dstRS.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// The import survives a restart, and sequence numbers carry on.
+	c = newRelay(t, relayserver.Options{DataDir: dir, StorageKey: "correct horse"})
+	envs, _, err := c.FetchMessages(ctx, "bob", 0, domain.RequestAuth{})
+	if err != nil || len(envs) != 2 || envs[0].ID != "2" || envs[1].Timestamp != 2 {
+		t.Fatalf("FetchMessages after import = %+v, %v; want envelopes 2 and 3", envs, err)
+	}
+	if got, err := c.FetchPrekeyBundle(ctx, "bob", domain.RequestAuth{}); err != nil || got.SPKID != "spk-1700000000" {
+		t.Fatalf("FetchPrekeyBundle after import = %+v, %v", got, err)
+	}
+	seq, err := c.SendMessage(ctx, domain.Envelope{From: "alice", To: "bob"})
+	if err != nil || seq != 4 {
+		t.Fatalf("SendMessage after import = %d, %v; want sequence 4", seq, err)
+	}
+	if _, err := c.SendMessage(ctx, domain.Envelope{From: "mallory", To: "bob"}); !errors.Is(err, domain.ErrSuspended) {
+		t.Fatalf("SendMessage from a suspended user after import: err = %v, want ErrSuspended", err)
+	}
+}
+
+func TestNewServer_Backup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	priv, pub, err := crypto.GenerateEd25519()
+	if err != nil {
+		t.Fatalf("GenerateEd25519: %v", err)
+	}
+	c := newRelay(t, relayserver.Options{DataDir: dir})
+
+	b := domain.RelayBackup{Data: []byte("sealed"), UpdatedUTC: 100}
+	relayauth.SignBackup(priv, "bob", &b)
+	if err := c.PutBackup(ctx, "bob", b); err == nil {
+		t.Fatal("PutBackup before registering succeeded; want an error")
+	}
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob", SignKey: pub}, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle: %v", err)
+	}
+	if err := c.PutBackup(ctx, "bob", b); err != nil {
+		t.Fatalf("PutBackup: %v", err)
+	}
+
+	forged := b
+	forged.Data = []byte("other")
+	if err := c.PutBackup(ctx, "bob", forged); err == nil {
+		t.Fatal("PutBackup with a bad signature succeeded; want an error")
+	}
+	stale := domain.RelayBackup{Data: []byte("older"), UpdatedUTC: 99}
+	relayauth.SignBackup(priv, "bob", &stale)
+	if err := c.PutBackup(ctx, "bob", stale); !errors.Is(err, domain.ErrConflict) {
+		t.Fatalf("PutBackup of an older backup = %v; want ErrConflict", err)
+	}
+	if _, err := c.FetchBackup(ctx, "alice"); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("FetchBackup of a user without one = %v; want ErrNotFound", err)
+	}
+
+	got, err := newRelay(t, relayserver.Options{DataDir: dir}).FetchBackup(ctx, "bob")
+	if err != nil || !bytes.Equal(got.Data, b.Data) || !relayauth.VerifyBackup(pub, "bob", got) {
+		t.Fatalf("FetchBackup after restart = %+v, %v; want the stored backup", got, err)
+	}
+}
+
+func TestNewServer_Presence(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keys := map[string]domain.Ed25519Private{}
+	c := newRelay(t, relayserver.Options{DataDir: dir})
+	for _, user := range []string{"alice", "bob", "mallory"} {
+		priv, pub, err := crypto.GenerateEd25519()
+		if err != nil {
+			t.Fatalf("GenerateEd25519: %v", err)
+		}
+		keys[user] = priv
+		if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: user, SignKey: pub}, domain.ChallengeAnswer{}); err != nil {
+			t.Fatalf("RegisterPrekeyBundle: %v", err)
+		}
+	}
+	// ask asks c when alice was last seen, as viewer ("" for a stranger).
+	ask := func(c *relay.HTTP, viewer string) (domain.Presence, error) {
+		t.Helper()
+		var auth domain.RequestAuth
+		if viewer != "" {
+			now := time.Now().Unix()
+			auth = domain.RequestAuth{TimeUTC: now, Sig: relayauth.SignRequest(keys[viewer], viewer, "GET", "/presence/alice", "", now)}
+		}
+		return c.Presence(ctx, "alice", viewer, auth)
+	}
+	set := func(vis domain.PresenceVisibility, updated int64, contacts ...string) error {
+		p := domain.PresencePolicy{Visibility: vis, Contacts: contacts, UpdatedUTC: updated}
+		relayauth.SignPresence(keys["alice"], "alice", &p)
+		return c.PutPresence(ctx, "alice", p)
+	}
+	// fetch fetches alice's messages from c, signed as signer ("" for none).
+	fetch := func(c *relay.HTTP, signer string) {
+		t.Helper()
+		var auth domain.RequestAuth
+		if signer != "" {
+			now := time.Now().Unix()
+			auth = domain.RequestAuth{TimeUTC: now, Sig: relayauth.SignRequest(keys[signer], "alice", "GET", "/msg/alice", "", now)}
+		}
+		if _, _, err := c.FetchMessages(ctx, "alice", 0, auth); err != nil {
+			t.Fatalf("FetchMessages: %v", err)
+		}
+	}
+
+	// Nothing is recorded until alice opts in.
+	fetch(c, "alice")
+	if err := set(domain.PresenceEveryone, 100); err != nil {
+		t.Fatalf("PutPresence: %v", err)
+	}
+	if _, err := ask(c, ""); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("Presence before a fetch = %v; want ErrNotFound", err)
+	}
+
+	// Anyone may fetch, but only a fetch alice signed moves her last-seen
+	// time.
+	fetch(c, "")
+	fetch(c, "mallory")
+	if _, err := ask(c, ""); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("Presence after fetches alice did not sign = %v; want ErrNotFound", err)
+	}
+	fetch(c, "alice")
+	p, err := ask(c, "")
+	if err != nil || p.User != "alice" || p.LastSeenUTC%60 != 0 || time.Since(time.Unix(p.LastSeenUTC, 0)) > time.Minute {
+		t.Fatalf("Presence = %+v, %v; want alice's last fetch to the minute", p, err)
+	}
+
+	// Contacts only: bob sees it, a stranger and mallory do not, and
+	// mallory cannot pass as bob.
+	if err := set(domain.PresenceContacts, 101, "bob"); err != nil {
+		t.Fatalf("PutPresence: %v", err)
+	}
+	if _, err := ask(c, "bob"); err != nil {
+		t.Fatalf("Presence as a contact: %v", err)
+	}
+	for _, viewer := range []string{"", "mallory"} {
+		if _, err := ask(c, viewer); !errors.Is(err, domain.ErrNotFound) {
+			t.Errorf("Presence as %q = %v; want ErrNotFound", viewer, err)
+		}
+	}
+	now := time.Now().Unix()
+	forged := domain.RequestAuth{TimeUTC: now, Sig: relayauth.SignRequest(keys["mallory"], "bob", "GET", "/presence/alice", "", now)}
+	if _, err := c.Presence(ctx, "alice", "bob", forged); err == nil {
+		t.Error("Presence with a forged signature succeeded; want an error")
+	}
+
+	// Policies must be signed by alice and cannot be rolled back.
+	forgedPolicy := domain.PresencePolicy{Visibility: domain.PresenceEveryone, UpdatedUTC: 102}
+	relayauth.SignPresence(keys["mallory"], "alice", &forgedPolicy)
+	if err := c.PutPresence(ctx, "alice", forgedPolicy); err == nil {
+		t.Error("PutPresence with a bad signature succeeded; want an error")
+	}
+	if err := set(domain.PresenceEveryone, 100); !errors.Is(err, domain.ErrConflict) {
+		t.Errorf("PutPresence of an older policy = %v; want ErrConflict", err)
+	}
+
+	// Opting out forgets the time, and the policy survives a restart.
+	if err := set(domain.PresenceNobody, 103); err != nil {
+		t.Fatalf("PutPresence: %v", err)
+	}
+	if _, err := ask(c, "bob"); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("Presence after opting out = %v; want ErrNotFound", err)
+	}
+	if err := set(domain.PresenceContacts, 104, "bob"); err != nil {
+		t.Fatalf("PutPresence: %v", err)
+	}
+	restarted := newRelay(t, relayserver.Options{DataDir: dir})
+	fetch(restarted, "alice")
+	if _, err := ask(restarted, "bob"); err != nil {
+		t.Fatalf("Presence after restart: %v", err)
+	}
+	if _, err := ask(restarted, "mallory"); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("Presence as mallory after restart = %v; want ErrNotFound", err)
+	}
+}
+
+func TestNewServer_Usage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	priv, pub, err := crypto.GenerateEd25519()
+	if err != nil {
+		t.Fatalf("GenerateEd25519: %v", err)
+	}
+	signed := func(ts int64) domain.RequestAuth {
+		return domain.RequestAuth{TimeUTC: ts, Sig: relayauth.SignRequest(priv, "bob", "GET", "/account/bob/usage", "", ts)}
+	}
+
+	rs, err := relayserver.NewServer(relayserver.Options{DataDir: dir})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	s := httptest.NewServer(rs)
+	c := relay.NewHTTP(s.URL, s.Client())
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob", SignKey: pub}, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle: %v", err)
+	}
+	for _, env := range []domain.Envelope{
+		{From: "bob", To: "alice", Cipher: []byte("ct")},
+		{From: "bob", To: "alice", Cipher: []byte("ct")},
+		{From: "alice", To: "bob", Cipher: []byte("ct")},
+	} {
+		if _, err := c.SendMessage(ctx, env); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+	if _, _, err := c.FetchMessages(ctx, "bob", 0, domain.RequestAuth{}); err != nil {
+		t.Fatalf("FetchMessages: %v", err)
+	}
+
+	now := time.Now().Unix()
+	for name, auth := range map[string]domain.RequestAuth{
+		"unsigned": {TimeUTC: now},
+		"stale":    signed(now - 3600),
+		"forged":   {TimeUTC: now, Sig: relayauth.SignRequest(priv, "bob", "GET", "/account/alice/usage", "", now)},
+	} {
+		if _, err := c.Usage(ctx, "bob", auth); err == nil {
+			t.Errorf("Usage %s succeeded; want an error", name)
+		}
+	}
+	usage, err := c.Usage(ctx, "bob", signed(now))
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if len(usage) != 1 || usage[0].Month != time.Now().UTC().Format("2006-01") ||
+		usage[0].EnvelopesIn != 2 || usage[0].EnvelopesOut != 1 || usage[0].BytesIn == 0 || usage[0].BytesOut == 0 {
+		t.Fatalf("Usage = %+v; want this month with 2 envelopes sent and 1 fetched", usage)
+	}
+
+	s.Close()
+	if err := rs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	again, err := newRelay(t, relayserver.Options{DataDir: dir}).Usage(ctx, "bob", signed(now))
+	if err != nil || len(again) != 1 || again[0] != usage[0] {
+		t.Fatalf("Usage after restart = %+v, %v; want %+v", again, err, usage)
+	}
+}
+
+func TestNewServer_Journal(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	priv, pub, err := crypto.GenerateEd25519()
+	if err != nil {
+		t.Fatalf("GenerateEd25519: %v", err)
+	}
+	now := time.Now().Unix()
+	auth := domain.RequestAuth{TimeUTC: now, Sig: relayauth.SignRequest(priv, "bob", "GET", "/account/bob/journal", "", now)}
+
+	rs, err := relayserver.NewServer(relayserver.Options{DataDir: dir})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	s := httptest.NewServer(rs)
+	c := relay.NewHTTP(s.URL, s.Client())
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob", SignKey: pub}, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle: %v", err)
+	}
+	for _, ct := range []string{"one", "two"} {
+		if _, err := c.SendMessage(ctx, domain.Envelope{From: "alice", To: "bob", Cipher: []byte(ct)}); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+	envs, _, err := c.FetchMessages(ctx, "bob", 1, domain.RequestAuth{})
+	if err != nil || len(envs) != 1 {
+		t.Fatalf("FetchMessages = %d, %v; want 1 envelope", len(envs), err)
+	}
+	if err := c.AckMessages(ctx, "bob", []string{envs[0].ID}); err != nil {
+		t.Fatalf("AckMessages: %v", err)
+	}
+
+	forged := domain.RequestAuth{TimeUTC: now, Sig: relayauth.SignRequest(priv, "bob", "GET", "/account/bob/usage", "", now)}
+	if _, err := c.Journal(ctx, "bob", 0, forged); err == nil {
+		t.Fatal("Journal signed for another path succeeded; want an error")
+	}
+	events, err := c.Journal(ctx, "bob", 0, auth)
+	if err != nil {
+		t.Fatalf("Journal: %v", err)
+	}
+	var kinds []domain.JournalKind
+	for i, ev := range events {
+		if ev.N != uint64(i+1) || len(ev.Hash) != 32 {
+			t.Fatalf("event %d = %+v; want number %d and a SHA-256 hash", i, ev, i+1)
+		}
+		kinds = append(kinds, ev.Kind)
+	}
+	want := []domain.JournalKind{domain.JournalEnqueue, domain.JournalEnqueue, domain.JournalFetch, domain.JournalAck}
+	if fmt.Sprint(kinds) != fmt.Sprint(want) || events[2].ID != envs[0].ID || !bytes.Equal(events[2].Hash, events[0].Hash) {
+		t.Fatalf("Journal = %+v; want %v with the first envelope fetched and acked", events, want)
+	}
+
+	// The journal survives a restart and carries on numbering.
+	s.Close()
+	if err := rs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	c = newRelay(t, relayserver.Options{DataDir: dir})
+	if _, _, err := c.FetchMessages(ctx, "bob", 0, domain.RequestAuth{}); err != nil {
+		t.Fatalf("FetchMessages after restart: %v", err)
+	}
+	// The signature covers the query, so one made for another page is
+	// refused.
+	if _, err := c.Journal(ctx, "bob", 4, auth); domain.RelayCode(err) != domain.RelayCodeBadSignature {
+		t.Fatalf("Journal(after=4) signed without the query = %v; want %s", err, domain.RelayCodeBadSignature)
+	}
+	paged := domain.RequestAuth{TimeUTC: now, Sig: relayauth.SignRequest(priv, "bob", "GET", "/account/bob/journal", "after=4", now)}
+	events, err = c.Journal(ctx, "bob", 4, paged)
+	if err != nil || len(events) != 1 || events[0].N != 5 || events[0].Kind != domain.JournalFetch {
+		t.Fatalf("Journal after restart = %+v, %v; want event 5, a fetch", events, err)
+	}
+
+	// A relay without a data directory keeps no journal.
+	mem := newRelay(t, relayserver.Options{})
+	if err := mem.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob", SignKey: pub}, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle: %v", err)
+	}
+	if _, err := mem.Journal(ctx, "bob", 0, auth); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("Journal in memory = %v; want ErrNotFound", err)
+	}
+}
+
+func TestNewServer_FingerprintAddress(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	ik := domain.X25519Public{1, 2, 3}
+	fp := address.FromFingerprint(crypto.Fingerprint(ik.Slice()))
+
+	rs, err := relayserver.NewServer(relayserver.Options{DataDir: dir})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	s := httptest.NewServer(rs)
+	c := relay.NewHTTP(s.URL, s.Client())
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob", IdentityKey: ik}, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle: %v", err)
+	}
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: fp}, domain.ChallengeAnswer{}); err == nil {
+		t.Fatal("registering an fp: username succeeded")
+	}
+	b, err := c.FetchPrekeyBundle(ctx, fp, domain.RequestAuth{})
+	if err != nil || b.Username != "bob" {
+		t.Fatalf("FetchPrekeyBundle(%s) = %q, %v; want bob's bundle", fp, b.Username, err)
+	}
+	if _, err := c.FetchPrekeyBundle(ctx, "fp:00000000000000000000", domain.RequestAuth{}); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("FetchPrekeyBundle(unknown fingerprint) = %v; want ErrNotFound", err)
+	}
+	if _, err := c.SendMessage(ctx, domain.Envelope{From: "alice", To: fp, Cipher: []byte("ct")}); err != nil {
+		t.Fatalf("SendMessage(%s): %v", fp, err)
+	}
+	if _, err := c.SendMessage(ctx, domain.Envelope{From: "alice", To: "fp:00000000000000000000", Cipher: []byte("ct")}); err == nil {
+		t.Fatal("SendMessage to an unknown fingerprint succeeded")
+	}
+	envs, _, err := c.FetchMessages(ctx, "bob", 0, domain.RequestAuth{})
+	if err != nil || len(envs) != 1 || envs[0].To != fp {
+		t.Fatalf("FetchMessages(bob) = %+v, %v; want the envelope sent to %s", envs, err, fp)
+	}
+
+	// Replacing the identity key moves the fingerprint; the index is rebuilt
+	// from disk on restart.
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob", IdentityKey: domain.X25519Public{4}}, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle: %v", err)
+	}
+	if _, err := c.FetchPrekeyBundle(ctx, fp, domain.RequestAuth{}); !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("FetchPrekeyBundle(old fingerprint) = %v; want ErrNotFound", err)
+	}
+	s.Close()
+	if err := rs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	newFP := address.FromFingerprint(crypto.Fingerprint(domain.X25519Public{4}.Slice()))
+	if b, err := newRelay(t, relayserver.Options{DataDir: dir}).FetchPrekeyBundle(ctx, newFP, domain.RequestAuth{}); err != nil || b.Username != "bob" {
+		t.Fatalf("FetchPrekeyBundle(%s) after restart = %q, %v; want bob's bundle", newFP, b.Username, err)
+	}
+}
+
+func TestNewServer_FingerprintHijack(t *testing.T) {
+	ctx := context.Background()
+	ik := domain.X25519Public{1, 2, 3}
+	fp := address.FromFingerprint(crypto.Fingerprint(ik.Slice()))
+	c := newRelay(t, relayserver.Options{})
+
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob", IdentityKey: ik}, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle(bob): %v", err)
+	}
+	// Mallory registers Bob's identity key to take over his fp: address.
+	err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "mallory", IdentityKey: ik}, domain.ChallengeAnswer{})
+	if !errors.Is(err, domain.ErrConflict) || domain.RelayCode(err) != domain.RelayCodeIdentityTaken {
+		t.Fatalf("RegisterPrekeyBundle(mallory, bob's key) = %v; want %s", err, domain.RelayCodeIdentityTaken)
+	}
+	if b, err := c.FetchPrekeyBundle(ctx, fp, domain.RequestAuth{}); err != nil || b.Username != "bob" {
+		t.Fatalf("FetchPrekeyBundle(%s) = %q, %v; want bob's bundle", fp, b.Username, err)
+	}
+	if _, err := c.SendMessage(ctx, domain.Envelope{From: "alice", To: fp, Cipher: []byte("ct")}); err != nil {
+		t.Fatalf("SendMessage(%s): %v", fp, err)
+	}
+	if envs, _, err := c.FetchMessages(ctx, "bob", 0, domain.RequestAuth{}); err != nil || len(envs) != 1 {
+		t.Fatalf("FetchMessages(bob) = %d envelope(s), %v; want the one sent to %s", len(envs), err, fp)
+	}
+
+	// Bob can register his key again, and Mallory a key of her own.
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob", IdentityKey: ik}, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle(bob) again: %v", err)
+	}
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "mallory", IdentityKey: domain.X25519Public{9}}, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle(mallory, own key): %v", err)
+	}
+}
+
+func TestNewServer_Checkout(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	const keys = 20
+
+	rs, err := relayserver.NewServer(relayserver.Options{DataDir: dir})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	s := httptest.NewServer(rs)
+	c := relay.NewHTTP(s.URL, s.Client())
+	b := domain.PrekeyBundle{Username: "bob", IdentityKey: domain.X25519Public{1}, SPKID: "spk-1700000000"}
+	for i := range keys {
+		b.OneTime = append(b.OneTime, domain.OneTimePub{ID: domain.KeyID(fmt.Sprintf("opk-1700000000-%d", i))})
+	}
+	if err := c.RegisterPrekeyBundle(ctx, b, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle: %v", err)
+	}
+	if _, err := c.CheckoutPrekeyBundle(ctx, "carol"); domain.RelayCode(err) != domain.RelayCodeUserNotFound {
+		t.Fatalf("CheckoutPrekeyBundle(unregistered) = %v; want %s", err, domain.RelayCodeUserNotFound)
+	}
+
+	// Concurrent initiators are each given a key of their own, and those
+	// after the last are given none.
+	type result struct {
+		b   domain.PrekeyBundle
+		err error
+	}
+	results := make(chan result)
+	for range keys + 5 {
+		go func() {
+			b, err := c.CheckoutPrekeyBundle(ctx, "bob")
+			results <- result{b, err}
+		}()
+	}
+	seen := make(map[domain.KeyID]bool)
+	none := 0
+	for range keys + 5 {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("CheckoutPrekeyBundle: %v", r.err)
+		}
+		if r.b.SPKID != b.SPKID || r.b.IdentityKey != b.IdentityKey {
+			t.Fatalf("CheckoutPrekeyBundle = %+v; want bob's bundle", r.b)
+		}
+		switch len(r.b.OneTime) {
+		case 0:
+			none++
+		case 1:
+			if seen[r.b.OneTime[0].ID] {
+				t.Fatalf("one-time prekey %s was checked out twice", r.b.OneTime[0].ID)
+			}
+			seen[r.b.OneTime[0].ID] = true
+		default:
+			t.Fatalf("CheckoutPrekeyBundle returned %d one-time prekeys, want at most 1", len(r.b.OneTime))
+		}
+	}
+	if len(seen) != keys || none != 5 {
+		t.Fatalf("checked out %d keys and %d bundles without one; want %d and 5", len(seen), none, keys)
+	}
+
+	// Republishing keys already handed out does not offer them again.
+	b.OneTime = append(b.OneTime, domain.OneTimePub{ID: "opk-1700000001-0"})
+	if err := c.RegisterPrekeyBundle(ctx, b, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle again: %v", err)
+	}
+	got, err := c.CheckoutPrekeyBundle(ctx, "bob")
+	if err != nil || len(got.OneTime) != 1 || got.OneTime[0].ID != "opk-1700000001-0" {
+		t.Fatalf("CheckoutPrekeyBundle after republishing = %+v, %v; want only the new key", got.OneTime, err)
+	}
+	s.Close()
+	if err := rs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Checkouts are persisted.
+	got, err = newRelay(t, relayserver.Options{DataDir: dir}).CheckoutPrekeyBundle(ctx, "bob")
+	if err != nil || len(got.OneTime) != 0 {
+		t.Fatalf("CheckoutPrekeyBundle after restart = %+v, %v; want no one-time prekeys left", got.OneTime, err)
+	}
+}
+
+func TestNewServer_CheckoutLimit(t *testing.T) {
+	ctx := context.Background()
+	const window = 300 * time.Millisecond
+	c := newRelay(t, relayserver.Options{CheckoutLimit: relayserver.CheckoutLimit{Max: 3, Window: window}})
+	for _, user := range []string{"bob", "carol"} {
+		b := domain.PrekeyBundle{Username: user, SPKID: "spk-1700000000"}
+		for i := range 10 {
+			b.OneTime = append(b.OneTime, domain.OneTimePub{ID: domain.KeyID(fmt.Sprintf("opk-1700000000-%d", i))})
+		}
+		if err := c.RegisterPrekeyBundle(ctx, b, domain.ChallengeAnswer{}); err != nil {
+			t.Fatalf("RegisterPrekeyBundle(%s): %v", user, err)
+		}
+	}
+	checkedOut := func(user string, n int) int {
+		got := 0
+		for range n {
+			b, err := c.CheckoutPrekeyBundle(ctx, user)
+			if err != nil || b.SPKID != "spk-1700000000" {
+				t.Fatalf("CheckoutPrekeyBundle(%s) = %+v, %v; want the bundle", user, b, err)
+			}
+			got += len(b.OneTime)
+		}
+		return got
+	}
+
+	// However often bob's keys are asked for, at most three go per window,
+	// and carol's are counted apart.
+	if got := checkedOut("bob", 10); got != 3 {
+		t.Fatalf("checked out %d of bob's keys in a burst; want 3", got)
+	}
+	if got := checkedOut("carol", 1); got != 1 {
+		t.Fatalf("checked out %d of carol's keys; want 1", got)
+	}
+	time.Sleep(window)
+	if got := checkedOut("bob", 10); got != 3 {
+		t.Fatalf("checked out %d of bob's keys in the next window; want 3", got)
+	}
+
+	if _, err := relayserver.NewServer(relayserver.Options{CheckoutLimit: relayserver.CheckoutLimit{Max: 3}}); err == nil {
+		t.Fatal("NewServer accepted a checkout limit without a window")
+	}
+}
+
+func TestNewServer_PrekeyFetch(t *testing.T) {
+	ctx := context.Background()
+	priv, pub, err := crypto.GenerateEd25519()
+	if err != nil {
+		t.Fatalf("GenerateEd25519: %v", err)
+	}
+	c := newRelay(t, relayserver.Options{})
+	b := domain.PrekeyBundle{
+		Username: "bob",
+		SignKey:  pub,
+		SPKID:    "spk-1700000000",
+		OneTime:  []domain.OneTimePub{{ID: "opk-1700000000-0"}, {ID: "opk-1700000000-1"}},
+	}
+	if err := c.RegisterPrekeyBundle(ctx, b, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle: %v", err)
+	}
+	now := time.Now().Unix()
+
+	// Only bob sees the one-time prekeys; others check them out one by one.
+	for name, tc := range map[string]struct {
+		auth domain.RequestAuth
+		want int
+	}{
+		"unsigned": {domain.RequestAuth{}, 0},
+		"owner":    {domain.RequestAuth{TimeUTC: now, Sig: relayauth.SignRequest(priv, "bob", "GET", "/prekey/bob", "", now)}, 2},
+		"forged":   {domain.RequestAuth{TimeUTC: now, Sig: relayauth.SignRequest(priv, "bob", "GET", "/prekey/carol", "", now)}, 0},
+	} {
+		got, err := c.FetchPrekeyBundle(ctx, "bob", tc.auth)
+		if err != nil || got.SPKID != b.SPKID || len(got.OneTime) != tc.want {
+			t.Errorf("%s: FetchPrekeyBundle = %+v, %v; want bob's bundle with %d one-time prekeys", name, got, err, tc.want)
+		}
+	}
+}
+
+func TestNewServer_ErrorCodes(t *testing.T) {
+	ctx := context.Background()
+	c := newRelay(t, relayserver.Options{})
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob"}, domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle: %v", err)
+	}
+
+	_, err := c.FetchPrekeyBundle(ctx, "carol", domain.RequestAuth{})
+	var re *domain.RelayError
+	if !errors.As(err, &re) || re.Code != domain.RelayCodeUserNotFound || re.Status != http.StatusNotFound ||
+		re.Retryable || re.Details["user"] != "carol" || !errors.Is(err, domain.ErrNotFound) {
+		t.Fatalf("FetchPrekeyBundle(carol) = %v; want user_not_found for carol", err)
+	}
+	_, err = c.FetchPrekeyBundle(ctx, "fp:00000000000000000000", domain.RequestAuth{})
+	if code := domain.RelayCode(err); code != domain.RelayCodeUnknownFingerprint {
+		t.Fatalf("FetchPrekeyBundle(unknown fingerprint) code = %q (%v); want unknown_fingerprint", code, err)
+	}
+
+	suites := make([]string, 17)
+	err = c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob", Suites: suites}, domain.ChallengeAnswer{})
+	if !errors.As(err, &re) || re.Code != domain.RelayCodeTooLarge || re.Details["field"] != "suites" || re.Details["limit"] != "16" {
+		t.Fatalf("RegisterPrekeyBundle(17 suites) = %v; want too_large naming the suites limit", err)
+	}
+	err = c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "fp:00000000000000000000"}, domain.ChallengeAnswer{})
+	if code := domain.RelayCode(err); code != domain.RelayCodeUsernameReserved {
+		t.Fatalf("RegisterPrekeyBundle(fp:) code = %q (%v); want username_reserved", code, err)
+	}
+	err = c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob", SPKID: "spk-test"}, domain.ChallengeAnswer{})
+	if !errors.As(err, &re) || re.Code != domain.RelayCodeInvalidParameter || re.Details["param"] != "spk_id" {
+		t.Fatalf("RegisterPrekeyBundle(malformed SPK ID) = %v; want invalid_parameter naming spk_id", err)
+	}
+	twice := []domain.OneTimePub{{ID: "opk-1700000000-0"}, {ID: "opk-1700000000-0"}}
+	err = c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob", OneTime: twice}, domain.ChallengeAnswer{})
+	if !errors.As(err, &re) || re.Code != domain.RelayCodeInvalidParameter || re.Details["param"] != "one_time" {
+		t.Fatalf("RegisterPrekeyBundle(repeated OPK ID) = %v; want invalid_parameter naming one_time", err)
+	}
+
+	_, err = c.SendMessage(ctx, domain.Envelope{From: "alice", To: "bob", Cipher: []byte("ct"), ExpiresUTC: 1})
+	if code := domain.RelayCode(err); code != domain.RelayCodeExpired {
+		t.Fatalf("SendMessage(expired) code = %q (%v); want envelope_expired", code, err)
+	}
+}
+
+func TestNewServer_RegisterChallenge(t *testing.T) {
+	ctx := context.Background()
+	verify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok := r.FormValue("secret") == "s3cret" && r.FormValue("response") == "human"
+		fmt.Fprintf(w, `{"success":%t}`, ok)
+	}))
+	t.Cleanup(verify.Close)
+
+	powC, err := relayserver.NewPoWChallenge(8)
+	if err != nil {
+		t.Fatalf("NewPoWChallenge: %v", err)
+	}
+	tokenC, err := relayserver.NewTokenChallenge([]string{"invite-1", "invite-2"})
+	if err != nil {
+		t.Fatalf("NewTokenChallenge: %v", err)
+	}
+	captchaC, err := relayserver.NewCAPTCHAChallenge(relayserver.CAPTCHAOptions{
+		VerifyURL: verify.URL, Secret: "s3cret", PageURL: "https://captcha.example/",
+	})
+	if err != nil {
+		t.Fatalf("NewCAPTCHAChallenge: %v", err)
+	}
+
+	for _, tc := range []struct {
+		challenge relayserver.Challenge
+		kind      domain.ChallengeKind
+		answer    func(domain.RegistrationChallenge) string
+		wrong     func(domain.RegistrationChallenge) string
+	}{
+		{powC, domain.ChallengePoW, func(c domain.RegistrationChallenge) string {
+			a, err := pow.Solve(ctx, c.Nonce, "bob", c.Bits)
+			if err != nil {
+				t.Fatalf("Solve: %v", err)
+			}
+			return a
+		}, func(c domain.RegistrationChallenge) string {
+			// The nonce is random, so find an answer that does not solve it.
+			for i := 0; ; i++ {
+				if a := fmt.Sprint("wrong", i); !pow.Check(c.Nonce, "bob", a, c.Bits) {
+					return a
+				}
+			}
+		}},
+		{tokenC, domain.ChallengeToken, constAnswer("invite-2"), constAnswer("invite-3")},
+		{captchaC, domain.ChallengeCAPTCHA, constAnswer("human"), constAnswer("bot")},
+	} {
+		t.Run(string(tc.kind), func(t *testing.T) {
+			c := newRelay(t, relayserver.Options{Challenge: tc.challenge})
+			bob := domain.PrekeyBundle{Username: "bob"}
+
+			// Unanswered, the relay hands out the challenge.
+			err := c.RegisterPrekeyBundle(ctx, bob, domain.ChallengeAnswer{})
+			var ce *domain.ChallengeError
+			if !errors.As(err, &ce) || ce.Failed || ce.Challenge.Kind != tc.kind {
+				t.Fatalf("RegisterPrekeyBundle unanswered: err = %v, want a %s challenge", err, tc.kind)
+			}
+			ch := ce.Challenge
+
+			// A wrong answer fails and nothing is registered.
+			err = c.RegisterPrekeyBundle(ctx, bob, domain.ChallengeAnswer{Kind: tc.kind, Nonce: ch.Nonce, Answer: tc.wrong(ch)})
+			if !errors.As(err, &ce) || !ce.Failed {
+				t.Fatalf("RegisterPrekeyBundle with a wrong answer: err = %v, want a failed challenge", err)
+			}
+			if _, err := c.FetchPrekeyBundle(ctx, "bob", domain.RequestAuth{}); !errors.Is(err, domain.ErrNotFound) {
+				t.Fatalf("FetchPrekeyBundle after a failed challenge: err = %v, want ErrNotFound", err)
+			}
+
+			// The right answer registers, and the existing name then
+			// refreshes its bundle without being challenged again.
+			ans := domain.ChallengeAnswer{Kind: tc.kind, Nonce: ch.Nonce, Answer: tc.answer(ch)}
+			if err := c.RegisterPrekeyBundle(ctx, bob, ans); err != nil {
+				t.Fatalf("RegisterPrekeyBundle answered: %v", err)
+			}
+			if err := c.RegisterPrekeyBundle(ctx, bob, domain.ChallengeAnswer{}); err != nil {
+				t.Fatalf("RegisterPrekeyBundle refresh: %v", err)
+			}
+		})
+	}
+
+	// A proof-of-work is bound to the username it was issued for.
+	c := newRelay(t, relayserver.Options{Challenge: powC})
+	var ce *domain.ChallengeError
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "carol"}, domain.ChallengeAnswer{}); !errors.As(err, &ce) {
+		t.Fatalf("RegisterPrekeyBundle unanswered: err = %v, want a challenge", err)
+	}
+	a, err := pow.Solve(ctx, ce.Challenge.Nonce, "carol", ce.Challenge.Bits)
+	if err != nil {
+		t.Fatalf("Solve: %v", err)
+	}
+	ans := domain.ChallengeAnswer{Kind: domain.ChallengePoW, Nonce: ce.Challenge.Nonce, Answer: a}
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "dave"}, ans); !errors.As(err, &ce) || !ce.Failed {
+		t.Fatalf("RegisterPrekeyBundle with carol's proof for dave: err = %v, want a failed challenge", err)
+	}
+}
+
+// constAnswer answers every challenge with a.
+func constAnswer(a string) func(domain.RegistrationChallenge) string {
+	return func(domain.RegistrationChallenge) string { return a }
+}
+
+// blockingChallenge holds every answered registration in Verify until
+// release is closed, signalling entered as each arrives.
+type blockingChallenge struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (c blockingChallenge) Issue(string) (domain.RegistrationChallenge, error) {
+	return domain.RegistrationChallenge{Kind: domain.ChallengeToken}, nil
+}
+
+func (c blockingChallenge) Verify(ctx context.Context, _ string, _ domain.ChallengeAnswer) error {
+	c.entered <- struct{}{}
+	select {
+	case <-c.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestNewServer_RegisterOwner(t *testing.T) {
+	ctx := context.Background()
+	c := newRelay(t, relayserver.Options{})
+
+	newID := func() domain.Identity {
+		priv, pub, err := crypto.GenerateEd25519()
+		if err != nil {
+			t.Fatalf("GenerateEd25519: %v", err)
+		}
+		return domain.Identity{XPub: domain.X25519Public{byte(len(priv))}, EdPriv: priv, EdPub: pub}
+	}
+	bundleOf := func(id domain.Identity, spk string) domain.PrekeyBundle {
+		return domain.PrekeyBundle{
+			Username:    "bob",
+			IdentityKey: id.XPub,
+			SignKey:     id.EdPub,
+			SPKID:       domain.KeyID(spk),
+			SignChain:   id.SignChain,
+		}
+	}
+	// update registers b, signing the relay's nonce with id.
+	update := func(id domain.Identity, b domain.PrekeyBundle) (domain.ChallengeAnswer, error) {
+		err := c.RegisterPrekeyBundle(ctx, b, domain.ChallengeAnswer{})
+		var ce *domain.ChallengeError
+		if !errors.As(err, &ce) || ce.Challenge.Kind != domain.ChallengeSignature || ce.Challenge.Nonce == "" {
+			t.Fatalf("RegisterPrekeyBundle unsigned update: err = %v, want a signature challenge", err)
+		}
+		ans := domain.ChallengeAnswer{
+			Kind:   domain.ChallengeSignature,
+			Nonce:  ce.Challenge.Nonce,
+			Answer: base64.StdEncoding.EncodeToString(relayauth.SignRegister(id.EdPriv, "bob", ce.Challenge.Nonce, b)),
+		}
+		return ans, c.RegisterPrekeyBundle(ctx, b, ans)
+	}
+	spkOf := func() domain.KeyID {
+		got, err := c.FetchPrekeyBundle(ctx, "bob", domain.RequestAuth{})
+		if err != nil {
+			t.Fatalf("FetchPrekeyBundle: %v", err)
+		}
+		return got.SPKID
+	}
+
+	owner := newID()
+	if err := c.RegisterPrekeyBundle(ctx, bundleOf(owner, "spk-1700000000"), domain.ChallengeAnswer{}); err != nil {
+		t.Fatalf("RegisterPrekeyBundle new: %v", err)
+	}
+
+	// Someone else cannot take the name over, signed or not.
+	intruder := newID()
+	var ce *domain.ChallengeError
+	if _, err := update(intruder, bundleOf(intruder, "spk-1700000001")); !errors.As(err, &ce) || !ce.Failed {
+		t.Fatalf("RegisterPrekeyBundle by another key: err = %v, want a failed challenge", err)
+	}
+	if got := spkOf(); got != "spk-1700000000" {
+		t.Fatalf("SPK after a refused update = %q, want the owner's", got)
+	}
+
+	// The owner can, once per nonce.
+	b := bundleOf(owner, "spk-1700000002")
+	ans, err := update(owner, b)
+	if err != nil {
+		t.Fatalf("RegisterPrekeyBundle signed by the owner: %v", err)
+	}
+	if got := spkOf(); got != "spk-1700000002" {
+		t.Fatalf("SPK after the owner's update = %q, want spk-1700000002", got)
+	}
+	if err := c.RegisterPrekeyBundle(ctx, b, ans); !errors.As(err, &ce) || !ce.Failed {
+		t.Fatalf("RegisterPrekeyBundle replayed: err = %v, want a failed challenge", err)
+	}
+
+	// A signature covers the bundle it was made for.
+	err = c.RegisterPrekeyBundle(ctx, b, domain.ChallengeAnswer{})
+	if !errors.As(err, &ce) {
+		t.Fatalf("RegisterPrekeyBundle unsigned: err = %v, want a challenge", err)
+	}
+	sig := relayauth.SignRegister(owner.EdPriv, "bob", ce.Challenge.Nonce, b)
+	other := bundleOf(intruder, "spk-1700000003")
+	err = c.RegisterPrekeyBundle(ctx, other, domain.ChallengeAnswer{
+		Kind: domain.ChallengeSignature, Nonce: ce.Challenge.Nonce, Answer: base64.StdEncoding.EncodeToString(sig),
+	})
+	if !errors.As(err, &ce) || !ce.Failed {
+		t.Fatalf("RegisterPrekeyBundle with another bundle's signature: err = %v, want a failed challenge", err)
+	}
+
+	// After a rotation the new key signs, and the chain vouches for it.
+	rotated := owner
+	rotated.SignChain = slices.Clone(owner.SignChain)
+	if _, err := signchain.Rotate(&rotated, time.Now()); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if _, err := update(rotated, bundleOf(rotated, "spk-1700000004")); err != nil {
+		t.Fatalf("RegisterPrekeyBundle after rotation: %v", err)
+	}
+	if _, err := update(owner, bundleOf(owner, "spk-1700000005")); !errors.As(err, &ce) || !ce.Failed {
+		t.Fatalf("RegisterPrekeyBundle with the key rotated away: err = %v, want a failed challenge", err)
+	}
+	if got := spkOf(); got != "spk-1700000004" {
+		t.Fatalf("SPK after rotation = %q, want spk-1700000004", got)
+	}
+}
+
+func TestNewServer_RouteLimits(t *testing.T) {
+	ctx := context.Background()
+	ch := blockingChallenge{entered: make(chan struct{}, 4), release: make(chan struct{})}
+	rs, err := relayserver.NewServer(relayserver.Options{
+		Challenge: ch,
+		RouteLimits: map[string]relayserver.RouteLimit{
+			"POST /register": {Max: 1, Queue: 1, Wait: 100 * time.Millisecond},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	s := httptest.NewServer(rs)
+	t.Cleanup(func() {
+		s.Close()
+		_ = rs.Close()
+	})
+	c := relay.NewHTTP(s.URL, s.Client())
+	ans := domain.ChallengeAnswer{Kind: domain.ChallengeToken, Answer: "x"}
+
+	// One registration holds the only slot.
+	first := make(chan error, 1)
+	go func() { first <- c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "bob"}, ans) }()
+	<-ch.entered
+
+	// The next waits its turn, then is refused as busy with a hint to retry.
+	req, err := http.NewRequest(http.MethodPost, s.URL+"/register", bytes.NewReader([]byte(`{"username":"carol"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatalf("POST /register: %v", err)
+	}
+	var body struct {
+		Error domain.RelayError `json:"error"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || body.Error.Code != domain.RelayCodeBusy ||
+		resp.Header.Get("Retry-After") != "1" {
+		t.Fatalf("POST /register while busy = %d %+v (Retry-After %q); want 503 busy",
+			resp.StatusCode, body.Error, resp.Header.Get("Retry-After"))
+	}
+
+	// Other routes and the health check are unaffected.
+	if _, err := c.ServerInfo(ctx); err != nil {
+		t.Fatalf("ServerInfo while register is busy: %v", err)
+	}
+	if resp, err := s.Client().Get(s.URL + "/healthz"); err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("GET /healthz while register is busy = %v, %v", resp, err)
+	}
+
+	// Once the slot frees up, registrations go through again.
+	close(ch.release)
+	if err := <-first; err != nil {
+		t.Fatalf("first RegisterPrekeyBundle: %v", err)
+	}
+	if err := c.RegisterPrekeyBundle(ctx, domain.PrekeyBundle{Username: "carol"}, ans); err != nil {
+		t.Fatalf("RegisterPrekeyBundle after the slot freed: %v", err)
+	}
+}
+
+func TestNewServer_BadOptions(t *testing.T) {
+	for name, opts := range map[string]relayserver.Options{
+		"unknown blob backend":    {Blobs: relayserver.BlobOptions{Backend: "tape"}},
+		"webhook without secret":  {WebhookURLs: []string{"http://127.0.0.1:1/hook"}},
+		"bad otlp endpoint":       {OTLPEndpoint: "collector:4318"},
+		"negative ack retention":  {AckRetention: -time.Second},
+		"chaos drop over 1":       {Chaos: relayserver.ChaosOptions{Drop: 1.5}},
+		"negative chaos delay":    {Chaos: relayserver.ChaosOptions{Delay: -time.Second}},
+		"notice with bad version": {Notice: domain.RelayNotice{MinClientVersion: "latest"}},
+		"route limit of zero":     {RouteLimits: map[string]relayserver.RouteLimit{"POST /register": {}}},
+		"limit on unknown route":  {RouteLimits: map[string]relayserver.RouteLimit{"POST /nowhere": {Max: 1}}},
+	} {
+		if _, err := relayserver.NewServer(opts); err == nil {
+			t.Errorf("%s: NewServer succeeded; want an error", name)
+		}
+	}
+}
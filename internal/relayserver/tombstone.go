@@ -0,0 +1,76 @@
+package relayserver
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// includeAckedParam asks a fetch to return tombstoned envelopes as well.
+const includeAckedParam = "include_acked"
+
+// tombstone is an acked envelope kept for Options.AckRetention, so a client
+// that crashed straight after acking can fetch it again.
+type tombstone struct {
+	env     domain.Envelope // AckedUTC is set
+	ackedAt time.Time
+}
+
+// tombstoneLocked keeps the envelopes acked at now for s.ackRetention,
+// dropping user's oldest tombstones beyond maxPerUserQueue. It does nothing
+// when retention is off. The caller holds s.mu for writing.
+func (s *state) tombstoneLocked(user string, envs []domain.Envelope, now time.Time) {
+	if s.ackRetention <= 0 || len(envs) == 0 {
+		return
+	}
+	ts := s.acked[user]
+	for _, env := range envs {
+		env.AckedUTC = now.Unix()
+		ts = append(ts, tombstone{env: env, ackedAt: now})
+	}
+	if n := len(ts) - maxPerUserQueue; n > 0 {
+		ts = ts[n:]
+	}
+	s.acked[user] = ts
+}
+
+// purgeAckedLocked permanently drops user's tombstones whose retention has
+// passed at now. The caller holds s.mu for writing.
+func (s *state) purgeAckedLocked(user string, now time.Time) {
+	ts := s.acked[user]
+	i := 0
+	for i < len(ts) && !now.Before(ts[i].ackedAt.Add(s.ackRetention)) {
+		i++
+	}
+	if i == 0 {
+		return
+	}
+	if i == len(ts) {
+		delete(s.acked, user)
+	} else {
+		s.acked[user] = slices.Clone(ts[i:])
+	}
+	s.accessLog.Info("purge_acked", "user", user, "drop", i, "remaining", len(ts)-i)
+}
+
+// withAcked returns user's queue with their tombstoned envelopes merged in,
+// in arrival order. The caller holds s.mu.
+func (s *state) withAcked(user string) []domain.Envelope {
+	q := slices.Clone(s.queues[user])
+	for _, t := range s.acked[user] {
+		q = append(q, t.env)
+	}
+	slices.SortStableFunc(q, func(a, b domain.Envelope) int {
+		return cmp.Compare(envelopeSeq(a), envelopeSeq(b))
+	})
+	return q
+}
+
+// envelopeSeq returns the sequence number in env's ID, or 0 if it has none.
+func envelopeSeq(env domain.Envelope) uint64 {
+	n, _ := strconv.ParseUint(env.ID, 10, 64)
+	return n
+}
@@ -0,0 +1,279 @@
+package relayserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trace export limits.
+const (
+	traceQueueSize     = 2048             // spans buffered before new ones are dropped
+	traceBatchSize     = 256              // spans per export request
+	traceFlushInterval = 5 * time.Second  // export a partial batch after this long
+	traceTimeout       = 10 * time.Second // per export request
+	traceServiceName   = "ciphera-relay"
+	traceParentHeader  = "traceparent"
+	spanQueueDepth     = "ciphera.queue.depth"
+	otlpTracesPath     = "/v1/traces"
+	otlpSpanKindServer = 2
+	otlpStatusError    = 2
+)
+
+// span is one finished request, ready for export.
+type span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte // zero for a request without a traceparent
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]any // string or int64 values
+	failed   bool           // 5xx response
+}
+
+// ctxKeySpan carries the request's *span so handlers can add attributes.
+const ctxKeySpan ctxKey = "span"
+
+// tracer exports a span per request to an OpenTelemetry collector using
+// OTLP/HTTP with JSON encoding, so no SDK is needed.
+//
+// Spans carry the route, method, status, user and queue depth. They never
+// carry envelopes, ciphertext or bundles. Spans are queued and exported in
+// batches by a single background worker so handlers never wait on the
+// collector; when the queue is full new spans are dropped.
+type tracer struct {
+	url     string
+	service string
+	client  *http.Client
+	queue   chan span
+	done    chan struct{} // closed once run has exported its last batch
+
+	logs
+}
+
+// newTracer validates the collector endpoint. A base URL such as
+// http://collector:4318 gets the standard /v1/traces path appended.
+func newTracer(endpoint, service string, l logs) (*tracer, error) {
+	u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("otlp endpoint %q: must be an http(s) URL", endpoint)
+	}
+	if !strings.HasSuffix(u.Path, otlpTracesPath) {
+		u.Path += otlpTracesPath
+	}
+	if service == "" {
+		service = traceServiceName
+	}
+	return &tracer{
+		url:     u.String(),
+		service: service,
+		client:  &http.Client{Timeout: traceTimeout},
+		queue:   make(chan span, traceQueueSize),
+		done:    make(chan struct{}),
+		logs:    l,
+	}, nil
+}
+
+// withTracing records a span for each request when tracing is enabled, that
+// is when t is not nil. An incoming W3C traceparent makes the span a child of
+// the caller's; otherwise it starts a new trace. Callers that mark their trace
+// as not sampled are not exported.
+func (t *tracer) withTracing(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if t == nil {
+			h(w, r)
+			return
+		}
+		sp := &span{start: time.Now(), attrs: map[string]any{}}
+		sampled := true
+		if tid, pid, ok, s := parseTraceParent(r.Header.Get(traceParentHeader)); ok {
+			sp.traceID, sp.parentID, sampled = tid, pid, s
+		} else {
+			_, _ = rand.Read(sp.traceID[:])
+		}
+		_, _ = rand.Read(sp.spanID[:])
+
+		lrw := &loggingResponseWriter{ResponseWriter: w}
+		h(lrw, r.WithContext(context.WithValue(r.Context(), ctxKeySpan, sp)))
+		if !sampled {
+			return
+		}
+
+		sp.end = time.Now()
+		sp.name = r.Pattern
+		sp.attrs["http.route"] = r.Pattern
+		sp.attrs["http.request.method"] = r.Method
+		status := lrw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		sp.attrs["http.response.status_code"] = int64(status)
+		sp.failed = status >= http.StatusInternalServerError
+		if user := r.PathValue("user"); user != "" {
+			sp.attrs["ciphera.user"] = user
+		} else if user := r.PathValue("username"); user != "" {
+			sp.attrs["ciphera.user"] = user
+		}
+		if id := requestIDFromCtx(r.Context()); id != "" {
+			sp.attrs["ciphera.request_id"] = id
+		}
+		t.record(*sp)
+	}
+}
+
+// setSpanInt adds an integer attribute to the request's span, if it has one.
+func setSpanInt(ctx context.Context, key string, v int) {
+	if sp, ok := ctx.Value(ctxKeySpan).(*span); ok {
+		sp.attrs[key] = int64(v)
+	}
+}
+
+// record queues a finished span. It never blocks.
+func (t *tracer) record(sp span) {
+	select {
+	case t.queue <- sp:
+	default:
+		t.log.Warn("trace queue full, span dropped", "route", sp.name)
+	}
+}
+
+// run exports queued spans until ctx is cancelled, then exports what is left.
+func (t *tracer) run(ctx context.Context) {
+	defer close(t.done)
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	var batch []span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.export(batch); err != nil {
+			t.log.Warn("trace export failed", "spans", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case sp := <-t.queue:
+			batch = append(batch, sp)
+			if len(batch) >= traceBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case sp := <-t.queue:
+					batch = append(batch, sp)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// export POSTs spans to the collector as one OTLP ExportTraceServiceRequest.
+func (t *tracer) export(spans []span) error {
+	body, err := json.Marshal(otlpRequest(t.service, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// otlpRequest builds the OTLP/JSON body for spans. IDs are hex and 64-bit
+// integers are decimal strings, as the OTLP JSON mapping requires.
+func otlpRequest(service string, spans []span) map[string]any {
+	out := make([]map[string]any, 0, len(spans))
+	for _, sp := range spans {
+		s := map[string]any{
+			"traceId":           hex.EncodeToString(sp.traceID[:]),
+			"spanId":            hex.EncodeToString(sp.spanID[:]),
+			"name":              sp.name,
+			"kind":              otlpSpanKindServer,
+			"startTimeUnixNano": strconv.FormatInt(sp.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(sp.end.UnixNano(), 10),
+			"attributes":        otlpAttributes(sp.attrs),
+		}
+		if sp.parentID != ([8]byte{}) {
+			s["parentSpanId"] = hex.EncodeToString(sp.parentID[:])
+		}
+		if sp.failed {
+			s["status"] = map[string]any{"code": otlpStatusError}
+		}
+		out = append(out, s)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]any{"service.name": service}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "ciphera/relay"},
+				"spans": out,
+			}},
+		}},
+	}
+}
+
+// otlpAttributes converts attrs to OTLP KeyValues.
+func otlpAttributes(attrs map[string]any) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for k, v := range attrs {
+		var val map[string]any
+		switch v := v.(type) {
+		case int64:
+			val = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		default:
+			val = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": k, "value": val})
+	}
+	return out
+}
+
+// parseTraceParent parses a version 00 W3C traceparent header:
+// "00-<32 hex trace id>-<16 hex parent id>-<2 hex flags>".
+func parseTraceParent(v string) (traceID [16]byte, parentID [8]byte, ok, sampled bool) {
+	parts := strings.Split(v, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, parentID, false, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil {
+		return traceID, parentID, false, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || traceID == ([16]byte{}) || parentID == ([8]byte{}) {
+		return traceID, parentID, false, false
+	}
+	return traceID, parentID, true, flags[0]&1 == 1
+}
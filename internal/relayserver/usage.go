@@ -0,0 +1,320 @@
+package relayserver
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/relayauth"
+)
+
+// Usage accounting.
+const (
+	usageFile         = "usage.json"
+	usageMonths       = 12 // calendar months kept per user, the current one included
+	usageSaveInterval = time.Minute
+	usageMonthLayout  = "2006-01"
+)
+
+// usageMeter counts, per user and calendar month (UTC), the bytes and
+// envelopes the relay took in and handed out for them (see
+// domain.RelayUsage). With a data directory the counts are saved to
+// usage.json every usageSaveInterval and when the server closes, so a crash
+// loses at most that much traffic. Counting never fails a request.
+type usageMeter struct {
+	path string // "" keeps the counts in memory only
+
+	mu    sync.Mutex
+	users map[string]map[string]*domain.RelayUsage // user -> month -> counts
+	dirty bool                                     // changed since the last save
+}
+
+// usageView is one user's usage as the admin API returns it.
+type usageView struct {
+	User string `json:"user"`
+	domain.RelayUsage
+}
+
+// newUsageMeter returns a meter that keeps its counts in memory only.
+func newUsageMeter() *usageMeter {
+	return &usageMeter{users: make(map[string]map[string]*domain.RelayUsage)}
+}
+
+// openUsage returns a meter saving to usage.json in dir, loaded with the
+// counts saved there.
+func openUsage(dir string) (*usageMeter, error) {
+	m := newUsageMeter()
+	m.path = filepath.Join(dir, usageFile)
+	b, err := os.ReadFile(m.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved map[string][]domain.RelayUsage
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %w", usageFile, err)
+	}
+	for user, months := range saved {
+		m.users[user] = make(map[string]*domain.RelayUsage, len(months))
+		for _, u := range months {
+			m.users[user][u.Month] = &u
+		}
+	}
+	return m, nil
+}
+
+// countIn adds bytes and envelopes taken in for user at now.
+func (m *usageMeter) countIn(user string, bytes int64, envelopes int, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.monthLocked(user, now)
+	u.BytesIn += bytes
+	u.EnvelopesIn += envelopes
+	m.dirty = true
+}
+
+// countOut adds bytes and envelopes handed out to user at now.
+func (m *usageMeter) countOut(user string, bytes int64, envelopes int, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := m.monthLocked(user, now)
+	u.BytesOut += bytes
+	u.EnvelopesOut += envelopes
+	m.dirty = true
+}
+
+// monthLocked returns user's counts for the month of now, starting a month
+// and dropping those beyond usageMonths as needed. The caller holds m.mu.
+func (m *usageMeter) monthLocked(user string, now time.Time) *domain.RelayUsage {
+	month := now.UTC().Format(usageMonthLayout)
+	months := m.users[user]
+	if months == nil {
+		months = make(map[string]*domain.RelayUsage)
+		m.users[user] = months
+	}
+	if u, ok := months[month]; ok {
+		return u
+	}
+	oldest := now.UTC().AddDate(0, 1-usageMonths, 0).Format(usageMonthLayout)
+	for k := range months {
+		if k < oldest {
+			delete(months, k)
+		}
+	}
+	u := &domain.RelayUsage{Month: month}
+	months[month] = u
+	return u
+}
+
+// report returns user's counts, newest month first.
+func (m *usageMeter) report(user string) []domain.RelayUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	months := m.users[user]
+	out := make([]domain.RelayUsage, 0, len(months))
+	for _, k := range slices.Backward(slices.Sorted(maps.Keys(months))) {
+		out = append(out, *months[k])
+	}
+	return out
+}
+
+// totals returns every user's counts for month, heaviest first.
+func (m *usageMeter) totals(month string) []usageView {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []usageView{}
+	for user, months := range m.users {
+		if u, ok := months[month]; ok {
+			out = append(out, usageView{User: user, RelayUsage: *u})
+		}
+	}
+	slices.SortFunc(out, func(a, b usageView) int {
+		return cmp.Or(
+			cmp.Compare(b.BytesIn+b.BytesOut, a.BytesIn+a.BytesOut),
+			cmp.Compare(a.User, b.User),
+		)
+	})
+	return out
+}
+
+// save writes the counts to usage.json if they changed since the last save.
+// The file is replaced by rename, so a crash leaves the old or new counts.
+func (m *usageMeter) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.path == "" || !m.dirty {
+		return nil
+	}
+	saved := make(map[string][]domain.RelayUsage, len(m.users))
+	for user, months := range m.users {
+		for _, k := range slices.Sorted(maps.Keys(months)) {
+			saved[user] = append(saved[user], *months[k])
+		}
+	}
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), usageFile+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), m.path); err != nil {
+		return err
+	}
+	m.dirty = false
+	return nil
+}
+
+// run saves the counts every usageSaveInterval until ctx is cancelled.
+func (m *usageMeter) run(ctx context.Context, l logs) {
+	if m.path == "" {
+		return
+	}
+	t := time.NewTicker(usageSaveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := m.save(); err != nil {
+				l.log.Error("usage_store", "error", err)
+			}
+		}
+	}
+}
+
+// handleUsage returns a user's traffic, newest month first
+// (GET /account/{user}/usage).
+//
+// The request must be signed with the signing key of the user's published
+// bundle, with a time within relayauth.MaxRequestSkew of ours (see package
+// relayauth).
+func (s *state) handleUsage(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+	if !s.authorizeAccount(w, r, user, "usage_refused") {
+		return
+	}
+
+	out := s.usage.report(user)
+	s.accessLog.Info("usage", "user", user, "months", len(out), "reqid", requestIDFromCtx(r.Context()))
+	writeJSON(w, out)
+}
+
+// authorizeAccount checks that r, a request about user's own account, is
+// signed with the signing key of user's published bundle at a time within
+// relayauth.MaxRequestSkew of ours (see package relayauth). If not, it writes
+// the error, logs a forged signature as refused, and returns false.
+func (s *state) authorizeAccount(w http.ResponseWriter, r *http.Request, user, refused string) bool {
+	t, terr := strconv.ParseInt(r.Header.Get(relayauth.TimeHeader), 10, 64)
+	sig, serr := base64.StdEncoding.DecodeString(r.Header.Get(relayauth.SignatureHeader))
+	if terr != nil || serr != nil || len(sig) == 0 {
+		writeErr(w, http.StatusUnauthorized, domain.RelayCodeAuthRequired, "signature required")
+		return false
+	}
+	if skew := time.Since(time.Unix(t, 0)); skew > relayauth.MaxRequestSkew || skew < -relayauth.MaxRequestSkew {
+		writeErr(w, http.StatusUnauthorized, domain.RelayCodeStaleRequest, "request time out of range", "max_skew", relayauth.MaxRequestSkew.String())
+		return false
+	}
+
+	s.mu.RLock()
+	bundle, registered := s.bundles[user]
+	s.mu.RUnlock()
+	if !registered {
+		writeErr(w, http.StatusNotFound, domain.RelayCodeUserNotFound, "user not registered", "user", user)
+		return false
+	}
+	if !relayauth.VerifyRequest(bundle.SignKey, user, r.Method, r.URL.Path, r.URL.RawQuery, t, sig) {
+		writeErr(w, http.StatusUnauthorized, domain.RelayCodeBadSignature, "bad signature")
+		s.accessLog.Info(refused, "user", user, "reqid", requestIDFromCtx(r.Context()))
+		return false
+	}
+	return true
+}
+
+// ownerSigned reports whether r carries a signature by user's owner that
+// authorizeAccount would accept, for requests that work unsigned but only
+// count as the user's activity when signed. It writes nothing.
+func (s *state) ownerSigned(r *http.Request, user string) bool {
+	t, err := strconv.ParseInt(r.Header.Get(relayauth.TimeHeader), 10, 64)
+	if err != nil {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(r.Header.Get(relayauth.SignatureHeader))
+	if err != nil || len(sig) == 0 {
+		return false
+	}
+	if skew := time.Since(time.Unix(t, 0)); skew > relayauth.MaxRequestSkew || skew < -relayauth.MaxRequestSkew {
+		return false
+	}
+	s.mu.RLock()
+	bundle, registered := s.bundles[user]
+	s.mu.RUnlock()
+	return registered && relayauth.VerifyRequest(bundle.SignKey, user, r.Method, r.URL.Path, r.URL.RawQuery, t, sig)
+}
+
+// handleUsageTotals lists every user's traffic in a month, heaviest first
+// (GET /admin/usage?month=YYYY-MM). The month defaults to the current one.
+func (s *state) handleUsageTotals(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().UTC().Format(usageMonthLayout)
+	} else if _, err := time.Parse(usageMonthLayout, month); err != nil {
+		writeErr(w, http.StatusBadRequest, domain.RelayCodeInvalidParameter, "month must be YYYY-MM", "param", "month")
+		return
+	}
+	out := s.usage.totals(month)
+	s.audit(r, "usage", "", "month", month, "count", len(out))
+	writeJSON(w, out)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+// Read reads from the underlying reader and counts what it returned.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the body bytes written through it.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+// Write writes to the underlying writer and counts what it accepted.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,81 @@
+package relayserver
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// waitParam asks a fetch to wait for envelopes when none are ready.
+	waitParam = "wait"
+	// maxFetchWait caps how long a fetch waits; longer waits are cut to it.
+	maxFetchWait = 60 * time.Second
+	// waitWriteSlack is how long after its wait a held fetch may take to
+	// write its response, past the server's own write timeout.
+	waitWriteSlack = 10 * time.Second
+)
+
+// parseWait reads the wait parameter of a fetch, a Go duration such as
+// "30s", capped at maxFetchWait.
+func parseWait(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid wait")
+	}
+	return min(d, maxFetchWait), nil
+}
+
+// arrivalLocked returns a channel closed when the next envelope is queued
+// for user, or when the relay drains. The caller holds s.mu.
+func (s *state) arrivalLocked(user string) <-chan struct{} {
+	if s.draining {
+		return closedChan
+	}
+	ch, ok := s.arrivals[user]
+	if !ok {
+		ch = make(chan struct{})
+		s.arrivals[user] = ch
+	}
+	return ch
+}
+
+// arrivedLocked wakes the fetches waiting for envelopes to user. The caller
+// holds s.mu for writing.
+func (s *state) arrivedLocked(user string) {
+	if ch, ok := s.arrivals[user]; ok {
+		close(ch)
+		delete(s.arrivals, user)
+	}
+}
+
+// closedChan is returned to fetches that must not wait.
+var closedChan = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// holdWrite extends the write deadline of a fetch that may wait for d, so
+// the server's write timeout does not cut it off. Writers that cannot
+// extend it are left alone.
+func holdWrite(w http.ResponseWriter, d time.Duration) {
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d + waitWriteSlack))
+}
+
+// Drain has fetches waiting for envelopes answer now, and any fetch after
+// it answer at once, and ends push connections, so clients move on before
+// the server shuts down. cmd/relay runs it when shutdown begins.
+func (srv *Server) Drain() {
+	s := srv.state
+	s.mu.Lock()
+	s.draining = true
+	for user := range s.arrivals {
+		s.arrivedLocked(user)
+	}
+	s.mu.Unlock()
+	s.push.closeAll()
+}
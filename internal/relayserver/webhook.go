@@ -0,0 +1,243 @@
+package relayserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook event types.
+const (
+	eventRegistration = "user.registered"     // first bundle published for a username
+	eventHighWater    = "queue.high_water"    // a user's queue crossed the high-water mark
+	eventDeadLetter   = "message.dead_letter" // envelopes dropped because a queue was full
+)
+
+// allEvents lists every event type, in the order shown in help text.
+var allEvents = []string{eventRegistration, eventHighWater, eventDeadLetter}
+
+// WebhookEvents returns every webhook event type, for Options.WebhookEvents.
+func WebhookEvents() []string {
+	return slices.Clone(allEvents)
+}
+
+// Webhook delivery limits.
+const (
+	webhookQueueSize      = 256              // events buffered before new ones are dropped
+	webhookTimeout        = 10 * time.Second // per delivery attempt
+	webhookAttempts       = 5                // attempts per event and endpoint
+	webhookBackoff        = time.Second      // delay before the first retry; doubles after each
+	webhookSigHeader      = "X-Ciphera-Signature"
+	webhookEventHeader    = "X-Ciphera-Event"
+	webhookDeliveryHeader = "X-Ciphera-Delivery"
+)
+
+// webhookEvent is the JSON body POSTed to every endpoint.
+type webhookEvent struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	CreatedUTC int64  `json:"created_utc"`
+	Data       any    `json:"data"`
+}
+
+// webhookService delivers relay events to operator-configured endpoints.
+//
+// Events are queued and sent by a single background worker so handlers never
+// wait on a slow endpoint. Each body is signed with HMAC-SHA256 over
+// "<unix time>.<body>"; the signature and time travel in the X-Ciphera-Signature
+// header as "t=<unix>,v1=<hex>". Failed deliveries (network errors, 429 and
+// 5xx) are retried with exponential backoff; other statuses are final.
+//
+// A nil *webhookService discards every event, so callers need not check
+// whether webhooks are configured.
+type webhookService struct {
+	urls      []string
+	secret    []byte
+	events    map[string]bool
+	highWater int
+	client    *http.Client
+	queue     chan webhookEvent
+
+	mu     sync.Mutex
+	nextID uint64
+
+	logs
+}
+
+// newWebhookService validates the endpoint URLs and event names.
+func newWebhookService(urls []string, secret string, events []string, highWater int, l logs) (*webhookService, error) {
+	if secret == "" {
+		return nil, errors.New("webhook secret must be set when webhook URLs are given")
+	}
+	for _, u := range urls {
+		p, err := url.Parse(u)
+		if err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+			return nil, fmt.Errorf("webhook url %q: must be an http(s) URL", u)
+		}
+	}
+	enabled := make(map[string]bool, len(events))
+	for _, e := range events {
+		if !slices.Contains(allEvents, e) {
+			return nil, fmt.Errorf("unknown webhook event %q (want one of %s)", e, strings.Join(allEvents, ", "))
+		}
+		enabled[e] = true
+	}
+	if highWater <= 0 || highWater > maxPerUserQueue {
+		return nil, fmt.Errorf("webhook high water must be between 1 and %d", maxPerUserQueue)
+	}
+	return &webhookService{
+		urls:      urls,
+		secret:    []byte(secret),
+		events:    enabled,
+		highWater: highWater,
+		client:    &http.Client{Timeout: webhookTimeout},
+		queue:     make(chan webhookEvent, webhookQueueSize),
+		logs:      l,
+	}, nil
+}
+
+// emit queues an event of type typ. It never blocks: if the queue is full the
+// event is dropped and logged.
+func (h *webhookService) emit(typ string, data any) {
+	if h == nil || !h.events[typ] {
+		return
+	}
+	h.mu.Lock()
+	h.nextID++
+	id := strconv.FormatUint(h.nextID, 10)
+	h.mu.Unlock()
+
+	ev := webhookEvent{ID: id, Type: typ, CreatedUTC: time.Now().Unix(), Data: data}
+	select {
+	case h.queue <- ev:
+	default:
+		h.log.Warn("webhook queue full, event dropped", "event", typ, "id", id)
+	}
+}
+
+// registered reports a username's first published bundle.
+func (h *webhookService) registered(user string) {
+	h.emit(eventRegistration, struct {
+		User string `json:"user"`
+	}{user})
+}
+
+// queueGrew reports a queue that grew from before to after envelopes, if it
+// crossed the high-water mark. It fires once per crossing, not for every
+// message while the queue stays above the mark.
+func (h *webhookService) queueGrew(user string, before, after int) {
+	if h == nil || before >= h.highWater || after < h.highWater {
+		return
+	}
+	h.emit(eventHighWater, struct {
+		User      string `json:"user"`
+		QueueLen  int    `json:"queue_len"`
+		HighWater int    `json:"high_water"`
+		Capacity  int    `json:"capacity"`
+	}{user, after, h.highWater, maxPerUserQueue})
+}
+
+// deadLettered reports envelopes dropped from the front of a full queue.
+func (h *webhookService) deadLettered(user string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	h.emit(eventDeadLetter, struct {
+		User  string   `json:"user"`
+		Count int      `json:"count"`
+		IDs   []string `json:"ids"`
+	}{user, len(ids), ids})
+}
+
+// run delivers queued events until ctx is cancelled.
+func (h *webhookService) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-h.queue:
+			body, err := json.Marshal(ev)
+			if err != nil {
+				h.log.Error("webhook encode failed", "event", ev.Type, "id", ev.ID, "error", err)
+				continue
+			}
+			for _, u := range h.urls {
+				h.deliver(ctx, u, ev, body)
+			}
+		}
+	}
+}
+
+// deliver POSTs body to endpoint, retrying transient failures.
+func (h *webhookService) deliver(ctx context.Context, endpoint string, ev webhookEvent, body []byte) {
+	delay := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		status, err := h.post(ctx, endpoint, ev, body)
+		if err == nil {
+			h.accessLog.Info("webhook", "event", ev.Type, "id", ev.ID, "url", endpoint, "status", status, "attempt", attempt)
+			return
+		}
+		retry := status == 0 || status == http.StatusTooManyRequests || status >= 500
+		if !retry || attempt == webhookAttempts {
+			h.log.Warn("webhook delivery failed",
+				"event", ev.Type,
+				"id", ev.ID,
+				"url", endpoint,
+				"attempts", attempt,
+				"error", err,
+			)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post sends one signed delivery attempt. status is 0 if no response arrived.
+func (h *webhookService) post(ctx context.Context, endpoint string, ev webhookEvent, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, ev.Type)
+	req.Header.Set(webhookDeliveryHeader, ev.ID)
+	req.Header.Set(webhookSigHeader, h.sign(time.Now().Unix(), body))
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.New(resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// sign returns the signature header value for body sent at unix time ts.
+// Receivers recompute HMAC-SHA256(secret, "<ts>.<body>") and compare.
+func (h *webhookService) sign(ts int64, body []byte) string {
+	t := strconv.FormatInt(ts, 10)
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write([]byte(t))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
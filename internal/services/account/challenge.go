@@ -0,0 +1,57 @@
+package account
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/pow"
+	"ciphera/internal/protocol/relayauth"
+)
+
+// ErrTooMuchWork indicates a relay asked for a proof-of-work harder than
+// pow.MaxBits.
+var ErrTooMuchWork = errors.New("relay asks for too much proof-of-work")
+
+// publish registers b on server, answering one registration challenge if the
+// relay sets one: proof-of-work, and the signature that proves id owns a
+// username already registered, are made here; anything else is asked of
+// solve. A rejected answer is not retried.
+func (s *Service) publish(
+	ctx context.Context,
+	server string,
+	id domain.Identity,
+	b domain.PrekeyBundle,
+	solve domain.ChallengeSolver,
+) error {
+	client := s.relays.Client(server)
+	err := client.RegisterPrekeyBundle(ctx, b, domain.ChallengeAnswer{})
+	var ce *domain.ChallengeError
+	if !errors.As(err, &ce) {
+		return err
+	}
+
+	ans := domain.ChallengeAnswer{Kind: ce.Challenge.Kind, Nonce: ce.Challenge.Nonce}
+	switch {
+	case ce.Challenge.Kind == domain.ChallengeSignature:
+		s.logger.Debug("signing re-registration", "server", server, "user", b.Username)
+		sig := relayauth.SignRegister(id.EdPriv, b.Username, ce.Challenge.Nonce, b)
+		ans.Answer, err = base64.StdEncoding.EncodeToString(sig), nil
+	case ce.Challenge.Kind == domain.ChallengePoW:
+		if ce.Challenge.Bits > pow.MaxBits {
+			return fmt.Errorf("%w: %d bits", ErrTooMuchWork, ce.Challenge.Bits)
+		}
+		s.logger.Debug("solving registration proof-of-work", "server", server, "bits", ce.Challenge.Bits)
+		ans.Answer, err = pow.Solve(ctx, ce.Challenge.Nonce, b.Username, ce.Challenge.Bits)
+	case solve != nil:
+		ans.Answer, err = solve(ctx, server, ce.Challenge)
+	default:
+		return ce
+	}
+	if err != nil {
+		return err
+	}
+	return client.RegisterPrekeyBundle(ctx, b, ans)
+}
@@ -0,0 +1,30 @@
+// Package account registers the local identity on one or more relays.
+//
+// Each registration is recorded in the domain.AccountStore keyed by
+// (server, username), which is how the CLI finds every relay for
+// "register --all-relays" and how the relay directory learns which relays
+// to search for contacts. Before publishing, the service checks that the
+// username is not already bound to a different identity key on that relay.
+//
+// A relay may make a new username answer a registration challenge. The
+// service solves proof-of-work challenges itself and hands invitation-token
+// and CAPTCHA challenges to the caller's domain.ChallengeSolver. Updating a
+// username already registered means answering the relay's nonce with a
+// signature by the identity's signing key, which the service also makes
+// itself.
+//
+// Usage fetches the traffic a relay counted for the account, in a request
+// signed with the identity's signing key (see package relayauth).
+// AuditJournal fetches the relay's journal of the account's queue the same
+// way and checks it for dropped, altered and replayed envelopes.
+//
+// SetPresence publishes a signed policy saying who a relay may show the
+// account's last activity to: nobody, the peers we hold conversations with
+// there, or everyone. Presence asks peers' relays when they were last seen,
+// signing each request as the account so a relay can tell a contact from a
+// stranger.
+//
+// MaintainPrekeys republishes the account's prekeys when the session policy
+// says they are due: the signed prekey has grown too old, or a relay offers
+// too few one-time prekeys that have not been used.
+package account
@@ -0,0 +1,162 @@
+package account
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/relayauth"
+)
+
+// AuditJournal fetches the queue journal server ("" for the default relay)
+// keeps for username, page by page, and checks it (see auditJournal). The
+// requests are signed as for Usage. A relay that keeps no journal yields an
+// error wrapping domain.ErrNotFound.
+func (s *Service) AuditJournal(ctx context.Context, passphrase, username, server string) (domain.JournalAudit, error) {
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return domain.JournalAudit{}, err
+	}
+	client := s.relays.Client(server)
+	var (
+		events []domain.JournalEvent
+		after  uint64
+	)
+	for {
+		// The signature covers the query Journal sends for after.
+		var query string
+		if after > 0 {
+			query = url.Values{"after": {strconv.FormatUint(after, 10)}}.Encode()
+		}
+		now := time.Now().Unix()
+		auth := domain.RequestAuth{
+			TimeUTC: now,
+			Sig:     relayauth.SignRequest(id.EdPriv, username, http.MethodGet, "/account/"+username+"/journal", query, now),
+		}
+		page, err := client.Journal(ctx, username, after, auth)
+		if err != nil {
+			return domain.JournalAudit{}, err
+		}
+		if len(page) == 0 || page[len(page)-1].N <= after {
+			break
+		}
+		events = append(events, page...)
+		after = page[len(page)-1].N
+	}
+
+	audit := auditJournal(events)
+	s.logger.Debug("relay journal audited",
+		"user", username,
+		"server", server,
+		"events", len(events),
+		"findings", len(audit.Findings),
+	)
+	return audit, nil
+}
+
+// journalEntry is what the events so far say about one envelope.
+type journalEntry struct {
+	hash    []byte             // ciphertext hash when queued
+	queued  uint64             // event that queued it; 0 if the journal does not show it
+	fetched int                // times handed out
+	gone    domain.JournalKind // how it left the queue; "" while queued
+}
+
+// auditJournal counts the events in a user's queue journal, oldest first,
+// and finds where the relay:
+//   - left events out, as a gap in their numbers;
+//   - handed out, acked or removed an envelope it never queued;
+//   - handed out an envelope with other ciphertext than it queued;
+//   - handed out an envelope again after it left the queue (a fetch with
+//     include_acked does this too, legitimately);
+//   - reused an envelope ID.
+//
+// Envelopes dropped over quota or expired unfetched are reported too, but not
+// as problems: the relay applied its limits. If the journal's oldest events
+// were trimmed, envelopes queued before it starts are not checked.
+func auditJournal(events []domain.JournalEvent) domain.JournalAudit {
+	a := domain.JournalAudit{Events: events, Findings: []domain.JournalFinding{}}
+	add := func(ev domain.JournalEvent, problem bool, explain string, args ...any) {
+		a.Findings = append(a.Findings, domain.JournalFinding{
+			N:       ev.N,
+			ID:      ev.ID,
+			Explain: fmt.Sprintf(explain, args...),
+			Problem: problem,
+		})
+	}
+
+	complete := len(events) == 0 || events[0].N == 1
+	if !complete {
+		a.Findings = append(a.Findings, domain.JournalFinding{
+			Explain: fmt.Sprintf("the journal starts at event %d; older events were trimmed, so envelopes "+
+				"queued before it are not checked", events[0].N),
+		})
+	}
+
+	envs := make(map[string]*journalEntry)
+	var prev uint64
+	for _, ev := range events {
+		switch {
+		case prev == 0 || ev.N == prev+1:
+		case ev.N == prev+2:
+			add(ev, true, "event %d is missing from the journal", prev+1)
+		default:
+			add(ev, true, "events %d to %d are missing from the journal", prev+1, ev.N-1)
+		}
+		prev = ev.N
+
+		e := envs[ev.ID]
+		if ev.Kind == domain.JournalEnqueue {
+			a.Enqueued++
+			if e != nil && e.queued != 0 {
+				add(ev, true, "the envelope ID was already used by event %d", e.queued)
+			}
+			envs[ev.ID] = &journalEntry{hash: ev.Hash, queued: ev.N}
+			continue
+		}
+		if e == nil {
+			if complete {
+				add(ev, true, "the envelope was never queued (%s)", ev.Kind)
+			}
+			e = &journalEntry{hash: ev.Hash}
+			envs[ev.ID] = e
+		}
+		if !bytes.Equal(ev.Hash, e.hash) {
+			add(ev, true, "the ciphertext differs from the one queued (%s)", ev.Kind)
+		}
+
+		switch ev.Kind {
+		case domain.JournalFetch:
+			a.Fetched++
+			if e.gone != "" {
+				add(ev, true, "handed out again after it left the queue (%s); only a fetch with include_acked "+
+					"should do that", e.gone)
+			}
+			e.fetched++
+		case domain.JournalAck:
+			a.Acked++
+			if e.fetched == 0 && e.queued != 0 {
+				add(ev, true, "acked without having been handed out")
+			}
+			e.gone = ev.Kind
+		case domain.JournalDrop, domain.JournalExpire:
+			a.Dropped++
+			if e.fetched == 0 {
+				reason := "the queue was full or the sender over its share"
+				if ev.Kind == domain.JournalExpire {
+					reason = "its expiry passed"
+				}
+				add(ev, false, "removed before it was ever fetched: %s", reason)
+			}
+			e.gone = ev.Kind
+		default:
+			add(ev, false, "unknown event %q", ev.Kind)
+		}
+	}
+	return a
+}
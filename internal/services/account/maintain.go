@@ -0,0 +1,229 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/relayauth"
+)
+
+// Prekey upkeep counts the relays' one-time prekeys no more often than
+// minPrekeyCheck, unless peers consumed some since, and no less often than
+// maxPrekeyCheck, as relays hand prekeys out for every bundle fetched even
+// if no message follows. The rate of consumption is taken over
+// consumptionWindow.
+const (
+	minPrekeyCheck    = time.Hour
+	maxPrekeyCheck    = 24 * time.Hour
+	consumptionWindow = 14 * 24 * time.Hour
+)
+
+// MaintainPrekeys applies the prekey half of the session policy to the
+// relays username has an account on. Prekeys are republished to all of them,
+// as register --all-relays would, when:
+//   - RotateSPKDays is set and the signed prekey is at least that old. Keys
+//     generated before their age was recorded count from the newest
+//     registration, which made them;
+//   - ReplenishOPKs is set and a relay offers fewer one-time prekeys we still
+//     hold. Relays keep offering prekeys peers have already used, so only
+//     those not yet consumed count.
+//
+// The relays are only asked about one-time prekeys when the persisted
+// schedule says so (see nextCheck), or once a peer consumed one that left us
+// holding fewer than ReplenishOPKs, so upkeep can run after every command.
+// Whenever it asks or republishes, it records when to next ask and when the
+// signed prekey is due.
+//
+// Nothing is fetched or published if the policy maintains nothing or there
+// is no account. A relay that cannot be checked is reported in the error but
+// does not stop the others, and is asked again after minPrekeyCheck;
+// republishing needs no solver, as the relays only ask an existing username
+// to sign the update, which is done without asking the user.
+func (s *Service) MaintainPrekeys(ctx context.Context, passphrase, username string) (domain.PrekeyMaintenance, error) {
+	policy, err := s.conversations.SessionPolicy()
+	if err != nil || !policy.Maintains() {
+		return domain.PrekeyMaintenance{}, err
+	}
+	all, err := s.accountStore.ListAccounts()
+	if err != nil {
+		return domain.PrekeyMaintenance{}, err
+	}
+	var (
+		servers []string
+		newest  int64
+	)
+	for _, a := range all {
+		if a.Username == username {
+			servers = append(servers, a.Server)
+			newest = max(newest, a.RegisteredUTC)
+		}
+	}
+	if len(servers) == 0 {
+		return domain.PrekeyMaintenance{}, nil
+	}
+	st, err := s.prekeySvc.Status()
+	if err != nil {
+		return domain.PrekeyMaintenance{}, err
+	}
+
+	now := time.Now()
+	rotateAfter := time.Duration(policy.RotateSPKDays) * 24 * time.Hour
+	var due []string
+	if policy.RotateSPKDays > 0 {
+		age := now.Sub(time.Unix(spkCreated(st, newest), 0))
+		if age >= rotateAfter {
+			due = append(due, fmt.Sprintf("signed prekey is %d days old", int(age.Hours()/24)))
+		}
+	}
+	var (
+		errs      []error
+		checked   bool
+		checkFail bool
+	)
+	if policy.ReplenishOPKs > 0 && len(due) == 0 && checkDue(st, policy.ReplenishOPKs, now) {
+		low, err := s.lowOneTime(ctx, passphrase, username, servers, policy.ReplenishOPKs)
+		due = append(due, low...)
+		errs = append(errs, err)
+		checked, checkFail = true, err != nil
+	}
+	var accounts []domain.Account
+	if len(due) > 0 {
+		accounts, err = s.Register(ctx, passphrase, username, servers, nil)
+		errs = append(errs, err)
+		s.logger.Debug("prekeys maintained",
+			"user", username,
+			"due", len(due),
+			"republished", len(accounts),
+		)
+		if len(accounts) > 0 {
+			newest = now.Unix()
+		}
+		if st, err = s.prekeySvc.Status(); err != nil {
+			return domain.PrekeyMaintenance{Due: due, Accounts: accounts}, errors.Join(append(errs, err)...)
+		}
+	}
+
+	// The schedule is rewritten when upkeep asked or republished, and when
+	// the policy changed what it should say; otherwise it is left alone.
+	sched := st.Schedule
+	if checked || len(due) > 0 || sched.LastRunUTC == 0 {
+		sched.LastRunUTC = now.Unix()
+		sched.NextCheckUTC = 0
+		if policy.ReplenishOPKs > 0 {
+			next := nextCheck(st, policy.ReplenishOPKs, now)
+			if checkFail {
+				next = minPrekeyCheck
+			}
+			sched.NextCheckUTC = now.Add(next).Unix()
+		}
+	}
+	if policy.ReplenishOPKs == 0 {
+		sched.NextCheckUTC = 0
+	}
+	sched.NextRotateUTC = 0
+	if policy.RotateSPKDays > 0 {
+		sched.NextRotateUTC = time.Unix(spkCreated(st, newest), 0).Add(rotateAfter).Unix()
+	}
+	if sched != st.Schedule {
+		errs = append(errs, s.prekeySvc.SaveSchedule(sched))
+	}
+	return domain.PrekeyMaintenance{Due: due, Accounts: accounts}, errors.Join(errs...)
+}
+
+// spkCreated returns when the current signed prekey was generated, or, if
+// that was not recorded, registered, the newest registration.
+func spkCreated(st domain.PrekeyStatus, registered int64) int64 {
+	if st.SignedCreatedUTC != 0 {
+		return st.SignedCreatedUTC
+	}
+	return registered
+}
+
+// checkDue reports whether the relays' one-time prekeys should be counted
+// now: the schedule says so, there is no schedule yet, or a peer consumed one
+// since upkeep last ran and we hold fewer than limit.
+func checkDue(st domain.PrekeyStatus, limit int, now time.Time) bool {
+	sched := st.Schedule
+	if sched.NextCheckUTC == 0 || now.Unix() >= sched.NextCheckUTC {
+		return true
+	}
+	n := len(st.ConsumedUTC)
+	return n > 0 && st.ConsumedUTC[n-1] >= sched.LastRunUTC && st.OneTime < limit
+}
+
+// nextCheck returns how long until the relays' one-time prekeys should be
+// counted again: at the rate peers consumed them over consumptionWindow, the
+// time until half of those held beyond limit are gone, within minPrekeyCheck
+// and maxPrekeyCheck. Without consumption it is maxPrekeyCheck.
+func nextCheck(st domain.PrekeyStatus, limit int, now time.Time) time.Duration {
+	recent := 0
+	for _, t := range st.ConsumedUTC {
+		if now.Sub(time.Unix(t, 0)) < consumptionWindow {
+			recent++
+		}
+	}
+	spare := st.OneTime - limit
+	switch {
+	case spare <= 0:
+		return minPrekeyCheck
+	case recent == 0:
+		return maxPrekeyCheck
+	}
+	each := consumptionWindow / time.Duration(recent)
+	return min(max(each*time.Duration(spare)/2, minPrekeyCheck), maxPrekeyCheck)
+}
+
+// lowOneTime returns, for each of servers offering fewer than limit of
+// username's one-time prekeys that we have not consumed, why it needs more.
+func (s *Service) lowOneTime(
+	ctx context.Context,
+	passphrase string,
+	username string,
+	servers []string,
+	limit int,
+) ([]string, error) {
+	local, err := s.prekeySvc.LoadPrekeyBundle(passphrase, username)
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[domain.KeyID]bool, len(local.OneTime))
+	for _, k := range local.OneTime {
+		held[k.ID] = true
+	}
+	// Relays list a bundle's one-time prekeys only to its owner.
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		low  []string
+		errs []error
+	)
+	for _, server := range servers {
+		now := time.Now().Unix()
+		auth := domain.RequestAuth{
+			TimeUTC: now,
+			Sig:     relayauth.SignRequest(id.EdPriv, username, http.MethodGet, "/prekey/"+username, "", now),
+		}
+		b, err := s.relays.Client(server).FetchPrekeyBundle(ctx, username, auth)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", server, err))
+			continue
+		}
+		unused := 0
+		for _, k := range b.OneTime {
+			if held[k.ID] {
+				unused++
+			}
+		}
+		if unused < limit {
+			low = append(low, fmt.Sprintf("%s offers %d unused one-time prekeys", server, unused))
+		}
+	}
+	return low, errors.Join(errs...)
+}
@@ -0,0 +1,148 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/address"
+	"ciphera/internal/protocol/relayauth"
+)
+
+// ErrBadVisibility indicates a presence visibility other than nobody,
+// contacts or everyone.
+var ErrBadVisibility = errors.New("visibility must be nobody, contacts or everyone")
+
+// SetPresence publishes, to server ("" for the default relay), who may see
+// when username last fetched their messages there. With
+// domain.PresenceContacts the policy lists every peer we hold a conversation
+// or session with on that relay (see peers), so peers met later see nothing
+// until it is set again. The policy is signed with the identity's signing
+// key.
+func (s *Service) SetPresence(
+	ctx context.Context,
+	passphrase string,
+	username string,
+	server string,
+	vis domain.PresenceVisibility,
+) (domain.PresencePolicy, error) {
+	switch vis {
+	case domain.PresenceNobody, domain.PresenceContacts, domain.PresenceEveryone:
+	default:
+		return domain.PresencePolicy{}, ErrBadVisibility
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return domain.PresencePolicy{}, err
+	}
+	client := s.relays.Client(server)
+
+	p := domain.PresencePolicy{Visibility: vis, UpdatedUTC: time.Now().Unix()}
+	if vis == domain.PresenceContacts {
+		peers, err := s.peers()
+		if err != nil {
+			return domain.PresencePolicy{}, err
+		}
+		for peer, relay := range peers {
+			// The directory hands out one client per relay, so the same
+			// client means the same relay.
+			if name, ok := relayName(peer); ok && s.relays.Client(relay) == client {
+				p.Contacts = append(p.Contacts, name)
+			}
+		}
+		slices.Sort(p.Contacts)
+		p.Contacts = slices.Compact(p.Contacts)
+	}
+	relayauth.SignPresence(id.EdPriv, username, &p)
+	if err := client.PutPresence(ctx, username, p); err != nil {
+		return domain.PresencePolicy{}, err
+	}
+	s.logger.Debug("presence policy set",
+		"user", username,
+		"server", server,
+		"visibility", vis,
+		"contacts", len(p.Contacts),
+	)
+	return p, nil
+}
+
+// Presence asks the relay of each of peers (every peer we hold a
+// conversation or session with if none are given) when they were last seen,
+// as username, in requests signed as for Usage. Peers who do not show it to
+// us, or whose relay cannot be asked, are left out; the latter are logged.
+func (s *Service) Presence(ctx context.Context, passphrase, username string, peers []string) ([]domain.Presence, error) {
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	known, err := s.peers()
+	if err != nil {
+		return nil, err
+	}
+	if len(peers) == 0 {
+		peers = slices.Sorted(maps.Keys(known))
+	}
+
+	var out []domain.Presence
+	for _, peer := range peers {
+		name, ok := relayName(peer)
+		if !ok {
+			continue
+		}
+		now := time.Now().Unix()
+		auth := domain.RequestAuth{
+			TimeUTC: now,
+			Sig:     relayauth.SignRequest(id.EdPriv, username, http.MethodGet, "/presence/"+name, "", now),
+		}
+		p, err := s.relays.Client(known[peer]).Presence(ctx, name, username, auth)
+		if errors.Is(err, domain.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			s.logger.Warn("presence not fetched", "peer", peer, "err", err)
+			continue
+		}
+		p.User = peer
+		out = append(out, p)
+	}
+	s.logger.Debug("presence fetched", "asked", len(peers), "shown", len(out))
+	return out, nil
+}
+
+// peers maps every peer we hold a conversation or session with to the relay
+// serving them: the one their bundle came from, or "" for the default relay
+// when we only answered their handshake, as messages to them are routed.
+func (s *Service) peers() (map[string]string, error) {
+	convs, err := s.ratchets.ListConversations()
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := s.sessions.ListSessions()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(convs)+len(sessions))
+	for _, c := range convs {
+		out[c.Peer] = ""
+	}
+	for _, sess := range sessions {
+		out[sess.Peer] = sess.Relay
+	}
+	return out, nil
+}
+
+// relayName returns the username peer has on their relay. A peer known only
+// by fingerprint has none we can name.
+func relayName(peer string) (string, bool) {
+	if address.IsFingerprint(peer) {
+		return "", false
+	}
+	if a, err := address.Parse(peer); err == nil {
+		return a.User, true
+	}
+	return peer, true
+}
@@ -0,0 +1,308 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/relayauth"
+)
+
+// oneTimePerRelay is how many fresh one-time prekeys each relay receives.
+const oneTimePerRelay = 10
+
+var (
+	// ErrNoRelays indicates no relay was given and none is on record.
+	ErrNoRelays = errors.New("no relay configured")
+	// ErrIdentityConflict indicates a username is bound to a different identity key on a relay.
+	ErrIdentityConflict = errors.New("username registered with a different identity key")
+	// ErrNoAccount indicates endpoints were given for a relay we hold no account on.
+	ErrNoAccount = errors.New("no account on relay")
+	// ErrBadEndpoint indicates an endpoint that is not an absolute http(s) URL.
+	ErrBadEndpoint = errors.New("endpoint must be an http:// or https:// URL")
+)
+
+// Service publishes prekey bundles to relays and records the resulting accounts.
+type Service struct {
+	idStore       domain.IdentityStore
+	accountStore  domain.AccountStore
+	sessions      domain.SessionStore
+	ratchets      domain.RatchetStore
+	prekeySvc     domain.PrekeyService
+	conversations domain.ConversationService
+	relays        domain.RelayDirectory
+	logger        *slog.Logger
+}
+
+// New constructs an Account Service.
+//
+// If logger is nil, log output is discarded.
+func New(
+	idStore domain.IdentityStore,
+	accountStore domain.AccountStore,
+	sessions domain.SessionStore,
+	ratchets domain.RatchetStore,
+	prekeySvc domain.PrekeyService,
+	conversations domain.ConversationService,
+	relays domain.RelayDirectory,
+	logger *slog.Logger,
+) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	return &Service{
+		idStore:       idStore,
+		accountStore:  accountStore,
+		sessions:      sessions,
+		ratchets:      ratchets,
+		prekeySvc:     prekeySvc,
+		conversations: conversations,
+		relays:        relays,
+		logger:        logger,
+	}
+}
+
+// Register publishes a fresh prekey bundle for username to every server.
+//
+// Steps:
+//  1. Check each relay for an existing bundle under username; a bundle with a
+//     different identity key is a conflict and that relay is skipped.
+//  2. Generate one signed prekey and oneTimePerRelay one-time prekeys per relay.
+//  3. Publish the same signed prekey everywhere, but give each relay its own
+//     one-time prekeys so no OPK can be handed out twice.
+//  4. Record an account for every relay that accepted the bundle.
+//
+// A relay may ask a new username to answer a registration challenge first.
+// Proof-of-work is solved here; token and CAPTCHA challenges are passed to
+// solve, and fail the relay when solve is nil.
+//
+// Relays are handled independently: the accounts that succeeded are returned
+// together with a joined error describing the ones that did not.
+func (s *Service) Register(
+	ctx context.Context,
+	passphrase string,
+	username string,
+	servers []string,
+	solve domain.ChallengeSolver,
+) ([]domain.Account, error) {
+	if len(servers) == 0 {
+		return nil, ErrNoRelays
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	targets := make([]string, 0, len(servers))
+	for _, server := range servers {
+		existing, err := s.relays.Client(server).FetchPrekeyBundle(ctx, username, domain.RequestAuth{})
+		switch {
+		case errors.Is(err, domain.ErrNotFound):
+			targets = append(targets, server)
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", server, err))
+		case existing.IdentityKey != id.XPub:
+			errs = append(errs, fmt.Errorf("%s: %w: %q", server, ErrIdentityConflict, username))
+		default:
+			targets = append(targets, server)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	_, fresh, err := s.prekeySvc.GenerateAndStorePrekeys(passphrase, oneTimePerRelay*len(targets))
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := s.publishTo(ctx, passphrase, id, username, targets, fresh, solve)
+	return accounts, errors.Join(append(errs, err)...)
+}
+
+// RestorePrekeys makes the signed prekey id, one an earlier Register
+// replaced, current again and republishes username's bundle with it to
+// servers, each with its own fresh one-time prekeys as in Register. Peers
+// who fetched the newer bundle meanwhile still complete their handshakes, as
+// the newer signed prekey is kept too.
+func (s *Service) RestorePrekeys(
+	ctx context.Context,
+	passphrase string,
+	username string,
+	servers []string,
+	spkID domain.KeyID,
+) ([]domain.Account, error) {
+	if len(servers) == 0 {
+		return nil, ErrNoRelays
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	fresh, err := s.prekeySvc.RestoreSignedPrekey(passphrase, spkID, oneTimePerRelay*len(servers))
+	if err != nil {
+		return nil, err
+	}
+	return s.publishTo(ctx, passphrase, id, username, servers, fresh, nil)
+}
+
+// publishTo publishes username's current bundle to every target, dealing
+// each its share of the fresh one-time prekeys, and records an account for
+// every relay that accepted it. Failed relays are joined into the error.
+func (s *Service) publishTo(
+	ctx context.Context,
+	passphrase string,
+	id domain.Identity,
+	username string,
+	targets []string,
+	fresh []domain.X25519Public,
+	solve domain.ChallengeSolver,
+) ([]domain.Account, error) {
+	bundle, err := s.prekeySvc.LoadPrekeyBundle(passphrase, username)
+	if err != nil {
+		return nil, err
+	}
+	shares := splitOneTime(bundle.OneTime, fresh, len(targets))
+
+	// Re-registering keeps the failover endpoints already configured.
+	previous, err := s.accountStore.ListAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	accounts := make([]domain.Account, 0, len(targets))
+	for i, server := range targets {
+		b := bundle
+		b.OneTime = shares[i]
+		if err := s.publish(ctx, server, id, b, solve); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", server, err))
+			continue
+		}
+		acct := domain.Account{
+			Server:        server,
+			Username:      username,
+			IdentityKey:   id.XPub,
+			RegisteredUTC: time.Now().Unix(),
+		}
+		for _, p := range previous {
+			if p.Server == server && p.Username == username {
+				acct.Endpoints, acct.Active = p.Endpoints, p.Active
+			}
+		}
+		if err := s.accountStore.SaveAccount(acct); err != nil {
+			return accounts, err
+		}
+		accounts = append(accounts, acct)
+		s.logger.Debug("registered on relay",
+			"server", server,
+			"user", username,
+			"spk_id", b.SPKID,
+			"one_time_count", len(b.OneTime),
+		)
+	}
+	return accounts, errors.Join(errs...)
+}
+
+// ListAccounts returns every recorded relay account.
+func (s *Service) ListAccounts() ([]domain.Account, error) {
+	return s.accountStore.ListAccounts()
+}
+
+// SetEndpoints replaces the failover endpoints of every account on server.
+//
+// Endpoints must serve the same queues as server, for example regional names
+// of one relay behind GeoDNS; a different relay needs its own account. They
+// are tried in the order given, after server. An empty list removes them.
+func (s *Service) SetEndpoints(server string, endpoints []string) ([]domain.Account, error) {
+	server = strings.TrimRight(server, "/")
+	var clean []string
+	for _, e := range endpoints {
+		e = strings.TrimRight(e, "/")
+		u, err := url.Parse(e)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%w: %q", ErrBadEndpoint, e)
+		}
+		if e != server && !slices.Contains(clean, e) {
+			clean = append(clean, e)
+		}
+	}
+
+	all, err := s.accountStore.ListAccounts()
+	if err != nil {
+		return nil, err
+	}
+	var updated []domain.Account
+	for _, a := range all {
+		if strings.TrimRight(a.Server, "/") != server {
+			continue
+		}
+		a.Endpoints = clean
+		if !slices.Contains(clean, a.Active) {
+			a.Active = ""
+		}
+		if err := s.accountStore.SaveAccount(a); err != nil {
+			return updated, err
+		}
+		updated = append(updated, a)
+	}
+	if len(updated) == 0 {
+		return nil, fmt.Errorf("%w %s", ErrNoAccount, server)
+	}
+	s.logger.Debug("relay endpoints set",
+		"server", server,
+		"endpoints", len(clean),
+		"accounts", len(updated),
+	)
+	return updated, nil
+}
+
+// Usage asks server ("" for the default relay) for the traffic it counted for
+// username, newest month first. The request is signed with the identity's
+// signing key, which the relay checks against username's published bundle.
+func (s *Service) Usage(ctx context.Context, passphrase, username, server string) ([]domain.RelayUsage, error) {
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	auth := domain.RequestAuth{
+		TimeUTC: now,
+		Sig:     relayauth.SignRequest(id.EdPriv, username, http.MethodGet, "/account/"+username+"/usage", "", now),
+	}
+	usage, err := s.relays.Client(server).Usage(ctx, username, auth)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Debug("relay usage fetched", "user", username, "server", server, "months", len(usage))
+	return usage, nil
+}
+
+// splitOneTime deals the freshly generated OPKs in all round-robin into n shares.
+// Older OPKs may already be published elsewhere, so they are left out.
+func splitOneTime(all []domain.OneTimePub, fresh []domain.X25519Public, n int) [][]domain.OneTimePub {
+	isFresh := make(map[domain.X25519Public]bool, len(fresh))
+	for _, pub := range fresh {
+		isFresh[pub] = true
+	}
+	shares := make([][]domain.OneTimePub, n)
+	i := 0
+	for _, opk := range all {
+		if !isFresh[opk.Pub] {
+			continue
+		}
+		shares[i%n] = append(shares[i%n], opk)
+		i++
+	}
+	return shares
+}
+
+// Compile-time assertion that Service implements domain.AccountService.
+var _ domain.AccountService = (*Service)(nil)
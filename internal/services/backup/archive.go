@@ -0,0 +1,169 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+
+	"ciphera/internal/domain"
+)
+
+// ArchiveVersion is the version of the ConversationArchive layout.
+const ArchiveVersion = 1
+
+var (
+	// ErrNotArchived is returned when unarchiving a peer whose conversation
+	// is not archived.
+	ErrNotArchived = errors.New("conversation with peer is not archived")
+	// ErrActive is returned when unarchiving a peer we already hold a live
+	// session or conversation with, such as one started since archiving.
+	ErrActive = errors.New("a live conversation with peer exists; move it aside with `sessions export --remove` before unarchiving")
+)
+
+// ArchiveConversation moves the session, ratchet state and history with peer
+// into an archive encrypted under passphrase, which must unlock the
+// identity; historyPassphrase opens the history. The archive is written
+// before anything is removed, so a failure leaves the conversation where it
+// was. Preferences, contacts and ratchet traces stay in place.
+func (s *Service) ArchiveConversation(passphrase, historyPassphrase, peer string) (domain.ConversationArchive, error) {
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return domain.ConversationArchive{}, err
+	}
+	archived, err := s.archiveStore.Archived(peer)
+	if err != nil {
+		return domain.ConversationArchive{}, err
+	}
+	if archived {
+		return domain.ConversationArchive{}, fmt.Errorf("%w: %q", domain.ErrArchived, peer)
+	}
+	unlock, err := s.ratchetStore.LockConversation(peer)
+	if err != nil {
+		return domain.ConversationArchive{}, err
+	}
+	defer unlock()
+
+	conv, found, err := s.ratchetStore.LoadConversation(peer)
+	if err != nil {
+		return domain.ConversationArchive{}, err
+	}
+	if !found {
+		return domain.ConversationArchive{}, fmt.Errorf("%w %q", ErrNoConversation, peer)
+	}
+	a := domain.ConversationArchive{
+		Version:      ArchiveVersion,
+		Peer:         peer,
+		OwnerIK:      id.XPub,
+		ArchivedUTC:  s.now().Unix(),
+		Conversation: conv,
+	}
+	sess, found, err := s.sessionStore.LoadSession(peer)
+	if err != nil {
+		return domain.ConversationArchive{}, err
+	}
+	if found {
+		a.Session = &sess
+	}
+	history, err := s.historyStore.LoadHistory(historyPassphrase)
+	if err != nil {
+		return domain.ConversationArchive{}, fmt.Errorf("reading history: %w", err)
+	}
+	for _, e := range history {
+		if e.Peer == peer {
+			a.History = append(a.History, e)
+		}
+	}
+
+	if err := s.archiveStore.SaveArchive(passphrase, a); err != nil {
+		return domain.ConversationArchive{}, err
+	}
+	if _, err := s.ratchetStore.DeleteConversation(peer); err != nil {
+		return domain.ConversationArchive{}, fmt.Errorf("remove conversation: %w", err)
+	}
+	if _, err := s.sessionStore.DeleteSession(peer); err != nil {
+		return domain.ConversationArchive{}, fmt.Errorf("remove session: %w", err)
+	}
+	if len(a.History) > 0 {
+		if _, err := s.historyStore.DeleteHistory(historyPassphrase, peer); err != nil {
+			return domain.ConversationArchive{}, fmt.Errorf("remove history: %w", err)
+		}
+	}
+	s.logger.Debug("conversation archived",
+		"peer", peer,
+		"session", a.Session != nil,
+		"skipped_keys", len(conv.State.Skipped),
+		"history", len(a.History),
+	)
+	return a, nil
+}
+
+// UnarchiveConversation restores the session, ratchet state and history
+// archived with peer, then removes the archive. The archive must belong to
+// the identity passphrase unlocks, and there must be no live session or
+// conversation with peer: ratchet state is only ever used in one place.
+// History entries already present are skipped, so an unarchive that fails
+// part way can simply be run again.
+func (s *Service) UnarchiveConversation(passphrase, historyPassphrase, peer string) (domain.ConversationArchive, error) {
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return domain.ConversationArchive{}, err
+	}
+	a, found, err := s.archiveStore.LoadArchive(passphrase, peer)
+	if err != nil {
+		return domain.ConversationArchive{}, err
+	}
+	if !found {
+		return domain.ConversationArchive{}, fmt.Errorf("%w: %q", ErrNotArchived, peer)
+	}
+	if a.Version < 1 || a.Version > ArchiveVersion {
+		return domain.ConversationArchive{}, fmt.Errorf("%w: archive version %d", ErrBadExport, a.Version)
+	}
+	if a.OwnerIK != id.XPub {
+		return domain.ConversationArchive{}, ErrOtherIdentity
+	}
+	unlock, err := s.ratchetStore.LockConversation(peer)
+	if err != nil {
+		return domain.ConversationArchive{}, err
+	}
+	defer unlock()
+
+	if _, found, err := s.ratchetStore.LoadConversation(peer); err != nil || found {
+		if err == nil {
+			err = fmt.Errorf("%w: %q", ErrActive, peer)
+		}
+		return domain.ConversationArchive{}, err
+	}
+	if _, found, err := s.sessionStore.LoadSession(peer); err != nil || found {
+		if err == nil {
+			err = fmt.Errorf("%w: %q", ErrActive, peer)
+		}
+		return domain.ConversationArchive{}, err
+	}
+
+	if _, err := s.historyStore.AppendHistory(historyPassphrase, a.History); err != nil {
+		return domain.ConversationArchive{}, fmt.Errorf("restoring history: %w", err)
+	}
+	if a.Session != nil {
+		if err := s.sessionStore.SaveSession(peer, *a.Session); err != nil {
+			return domain.ConversationArchive{}, err
+		}
+	}
+	if err := s.ratchetStore.SaveConversation(peer, a.Conversation); err != nil {
+		return domain.ConversationArchive{}, err
+	}
+	if _, err := s.archiveStore.DeleteArchive(peer); err != nil {
+		return domain.ConversationArchive{}, fmt.Errorf("remove archive: %w", err)
+	}
+	s.logger.Debug("conversation unarchived",
+		"peer", peer,
+		"session", a.Session != nil,
+		"history", len(a.History),
+		"archived_utc", a.ArchivedUTC,
+	)
+	return a, nil
+}
+
+// ListArchives returns every archived conversation, oldest first. passphrase
+// decrypts the archives.
+func (s *Service) ListArchives(passphrase string) ([]domain.ConversationArchive, error) {
+	return s.archiveStore.ListArchives(passphrase)
+}
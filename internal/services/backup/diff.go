@@ -0,0 +1,246 @@
+package backup
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+)
+
+// skippedIDSize is the length of a decoded skipped-key ID: the sender's
+// ratchet public key and the big-endian message number (see package ratchet).
+const skippedIDSize = 32 + 4
+
+// DiffStates compares snapshots a and b of conversation state and explains
+// where they diverge. Two snapshots of one side are compared field by field,
+// a taken to be the older; the two ends of a conversation are checked for
+// what decryption needs, that each side's sending chain is the chain the
+// other receives on.
+func (s *Service) DiffStates(a, b domain.ConversationBackup) domain.StateDiff {
+	d := domain.StateDiff{Relation: relation(a, b)}
+	var f findings
+	switch d.Relation {
+	case domain.RelationSameSide:
+		f.sameSide(a.Conversation, b.Conversation)
+	case domain.RelationPeers:
+		f.peers(a.Conversation, b.Conversation)
+	default:
+		f.add("identity", shortKey(a.OwnerIK)+" with "+a.Peer, shortKey(b.OwnerIK)+" with "+b.Peer, true,
+			"the snapshots belong to different conversations, so their states cannot be compared")
+	}
+	d.Findings = f
+	if d.Findings == nil {
+		d.Findings = []domain.StateFinding{}
+	}
+	return d
+}
+
+// relation works out whether a and b are the same side of one conversation,
+// its two ends, or unrelated. Conversations saved before the peer's identity
+// was recorded match any peer.
+func relation(a, b domain.ConversationBackup) domain.StateRelation {
+	knows := func(c domain.Conversation, ik domain.X25519Public) bool {
+		return c.PeerIK == (domain.X25519Public{}) || c.PeerIK == ik
+	}
+	switch {
+	case a.OwnerIK == b.OwnerIK && a.Peer == b.Peer:
+		return domain.RelationSameSide
+	case a.OwnerIK != b.OwnerIK && knows(a.Conversation, b.OwnerIK) && knows(b.Conversation, a.OwnerIK):
+		return domain.RelationPeers
+	}
+	return domain.RelationUnrelated
+}
+
+// findings collects the divergences found, in the order they were checked.
+type findings []domain.StateFinding
+
+// add records a finding, formatting explain with args.
+func (f *findings) add(field, a, b string, problem bool, explain string, args ...any) {
+	*f = append(*f, domain.StateFinding{
+		Field:   field,
+		A:       a,
+		B:       b,
+		Explain: fmt.Sprintf(explain, args...),
+		Problem: problem,
+	})
+}
+
+// sameSide compares a and b, two snapshots of our state with one peer.
+func (f *findings) sameSide(a, b domain.Conversation) {
+	sa, sb := a.State, b.State
+	if suiteID(sa) != suiteID(sb) {
+		f.add("suite", suiteID(sa), suiteID(sb), false,
+			"the conversation was re-established with another cipher suite in between")
+	}
+	if !keyEqual(sa.HeaderKey, sb.HeaderKey) {
+		f.add("handshake", "", "", false,
+			"the header keys differ, so the handshake was redone in between (reset, rekey or simultaneous "+
+				"initiation; %d rekey(s) in A, %d in B) and the chains below are not comparable", a.Rekeys, b.Rekeys)
+		return
+	}
+	if a.Confirm != b.Confirm {
+		f.add("confirm", string(a.Confirm), string(b.Confirm), b.Confirm == domain.ConfirmMismatch,
+			"the handshake confirmation changed")
+	}
+
+	if sa.DHPub != sb.DHPub {
+		f.add("ratchet key", shortKey(sa.DHPub), shortKey(sb.DHPub), false,
+			"B has started a new sending chain since A")
+	} else {
+		f.drift("send index", sa.Ns, sb.Ns,
+			"B sent %d more message(s) on the same chain",
+			"B is %d message(s) behind A on the same sending chain: it is an older copy, or was restored from "+
+				"one; sending from B reuses message keys the peer has already used, and the peer drops those messages")
+	}
+	if sa.PeerDHPub != sb.PeerDHPub {
+		f.add("peer ratchet key", shortKey(sa.PeerDHPub), shortKey(sb.PeerDHPub), false,
+			"B has received messages on a newer chain from the peer")
+	} else {
+		f.drift("receive index", sa.Nr, sb.Nr,
+			"B received %d more message(s) on the same chain",
+			"B is %d message(s) behind A on the same receiving chain: it will decrypt messages A already had "+
+				"again, and the peer may have moved on to chains B never sees the start of")
+	}
+
+	gone, added := 0, 0
+	for id := range sa.Skipped {
+		if _, ok := sb.Skipped[id]; !ok {
+			gone++
+		}
+	}
+	for id := range sb.Skipped {
+		if _, ok := sa.Skipped[id]; !ok {
+			added++
+		}
+	}
+	if gone > 0 {
+		f.add("skipped keys", strconv.Itoa(len(sa.Skipped)), strconv.Itoa(len(sb.Skipped)), false,
+			"%d skipped message key(s) in A are missing from B: the late messages they were kept for arrived, or "+
+				"the keys were dropped; such a message arriving at B cannot be decrypted", gone)
+	}
+	if added > 0 {
+		f.add("skipped keys", strconv.Itoa(len(sa.Skipped)), strconv.Itoa(len(sb.Skipped)), false,
+			"B holds %d skipped message key(s) A does not, for messages it is still waiting for", added)
+	}
+	if (a.Stale != nil) != (b.Stale != nil) {
+		f.add("stale state", strconv.FormatBool(a.Stale != nil), strconv.FormatBool(b.Stale != nil), false,
+			"a simultaneous initiation was resolved in between")
+	}
+	if a.HeaderMACs && !b.HeaderMACs {
+		f.add("header MACs", "required", "not required", true,
+			"B accepts envelopes without a header MAC again, as it did before the peer first sent one")
+	}
+}
+
+// peers checks that a and b, the two ends of one conversation, can decrypt
+// each other's messages.
+func (f *findings) peers(a, b domain.Conversation) {
+	sa, sb := a.State, b.State
+	if a.PeerIK == (domain.X25519Public{}) || b.PeerIK == (domain.X25519Public{}) {
+		f.add("identity", "", "", false,
+			"a snapshot predates recording the peer's identity, so the two are assumed to be one conversation")
+	}
+	if suiteID(sa) != suiteID(sb) {
+		f.add("suite", suiteID(sa), suiteID(sb), true,
+			"the sides use different cipher suites, so nothing decrypts: one side re-established the "+
+				"conversation and the other never processed its handshake")
+	}
+	if !keyEqual(sa.HeaderKey, sb.HeaderKey) {
+		f.add("handshake", "", "", true,
+			"the header keys differ, so the sides hold state from different handshakes: one side reset or "+
+				"rekeyed and the other never processed it; both should run start-session --reset")
+		return
+	}
+
+	aToB, bToA := sa.DHPub == sb.PeerDHPub, sb.DHPub == sa.PeerDHPub
+	if !aToB && !bToA {
+		f.add("ratchet keys", shortKey(sa.DHPub), shortKey(sb.DHPub), true,
+			"neither side's sending chain is the one the other last received on; both may have ratcheted with "+
+				"messages in flight each way, but more often one side's state was reset or restored from an old copy")
+		return
+	}
+	f.chain("A", "B", sa, sb, aToB)
+	f.chain("B", "A", sb, sa, bToA)
+}
+
+// chain checks the chain from sender to receiver. same reports whether the
+// receiver last received on the sender's current sending chain.
+func (f *findings) chain(sender, receiver string, s, r domain.RatchetState, same bool) {
+	if !same {
+		f.add(sender+" sending chain", shortKey(s.DHPub), shortKey(r.PeerDHPub), false,
+			"%s has started a new sending chain %s has not received on yet (%d message(s) sent on it)",
+			sender, receiver, s.Ns)
+		return
+	}
+	switch {
+	case s.Ns > r.Nr:
+		pending := s.Ns - r.Nr
+		f.add(sender+" to "+receiver+" index", strconv.Itoa(int(s.Ns)), strconv.Itoa(int(r.Nr)), false,
+			"%d of %s's latest message(s) have not reached %s: queued on the relay, in flight or lost",
+			pending, sender, receiver)
+	case s.Ns < r.Nr:
+		f.add(sender+" to "+receiver+" index", strconv.Itoa(int(s.Ns)), strconv.Itoa(int(r.Nr)), true,
+			"%s has received %d message(s) beyond what %s has sent on this chain: %s's state is older than the "+
+				"one that sent them, e.g. restored from a backup, and its next messages reuse keys %s has used",
+			receiver, r.Nr-s.Ns, sender, sender, receiver)
+	}
+	if n := skippedBeyond(r.Skipped, s.DHPub, s.Ns); n > 0 {
+		f.add(receiver+" skipped keys", "", strconv.Itoa(n), true,
+			"%s holds %d skipped key(s) for messages %s has not sent on this chain, so %s's state is older "+
+				"than the one that sent them", receiver, n, sender, sender)
+	}
+}
+
+// drift adds a finding for an index that moved from a to b, explained with
+// ahead or behind and the distance.
+func (f *findings) drift(field string, a, b uint32, ahead, behind string) {
+	switch {
+	case b > a:
+		f.add(field, strconv.Itoa(int(a)), strconv.Itoa(int(b)), false, ahead, b-a)
+	case b < a:
+		f.add(field, strconv.Itoa(int(a)), strconv.Itoa(int(b)), true, behind, a-b)
+	}
+}
+
+// skippedBeyond counts the skipped keys for chain pub with a message number
+// of at least n.
+func skippedBeyond(skipped map[string][]byte, pub domain.X25519Public, n uint32) int {
+	count := 0
+	for id := range skipped {
+		raw, err := hex.DecodeString(id)
+		if err != nil || len(raw) != skippedIDSize || [32]byte(raw[:32]) != pub {
+			continue
+		}
+		if binary.BigEndian.Uint32(raw[32:]) >= n {
+			count++
+		}
+	}
+	return count
+}
+
+// suiteID returns the suite st runs, naming the default for states that
+// predate suites.
+func suiteID(st domain.RatchetState) string {
+	if st.Suite == "" {
+		return crypto.DefaultSuite
+	}
+	return st.Suite
+}
+
+// keyEqual compares secret keys in constant time.
+func keyEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// shortKey returns the first 8 bytes of a public key in hex, or "-" if it is
+// zero.
+func shortKey(k domain.X25519Public) string {
+	if k == (domain.X25519Public{}) {
+		return "-"
+	}
+	return hex.EncodeToString(k[:8])
+}
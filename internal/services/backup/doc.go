@@ -0,0 +1,34 @@
+// Package backup moves a single conversation between machines, and keeps an
+// encrypted copy of the whole account on the relay.
+//
+// An export holds the X3DH session (if we initiated it) and the Double
+// Ratchet state with its skipped message keys, sealed under a passphrase.
+// Nothing else is included: the identity, contacts, preferences and history
+// stay where they are. An import only accepts an export made for the same
+// identity, since the peer knows us by its key.
+//
+// Ratchet state must only ever be used in one place. Two copies sending on
+// the same chain reuse message keys and nonces, so an export is meant to be
+// moved (see the remove flag), not kept as a standing backup, and an import
+// never silently replaces a conversation that already exists.
+//
+// An archive moves a dormant conversation out of the state read on every
+// run: its session, ratchet state and history go into one file under the
+// home directory, encrypted under the identity passphrase, until it is
+// unarchived. Unlike an export it is the only copy, and the message service
+// holds back envelopes from the archived peer rather than starting a second
+// conversation alongside it.
+//
+// DiffStates compares two exports, or an export and the current state, for
+// debugging peers who cannot decrypt each other: the same side at two times,
+// or the two ends of a conversation, whose sending and receiving chains must
+// line up.
+//
+// An account backup holds the identity, X3DH sessions and contacts, sealed
+// under the account passphrase and signed with the identity's signing key so
+// the relay only accepts it from the bundle's owner. It leaves out ratchet
+// state for the same reason, and prekeys, which are published afresh: after
+// a restore, register again and have each peer reset its conversation. Restoring needs
+// only the username, relay and passphrase, and refuses to overwrite an
+// existing identity.
+package backup
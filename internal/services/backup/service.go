@@ -0,0 +1,334 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/relayauth"
+)
+
+const (
+	// Version is the version of the ConversationBackup layout.
+	Version = 1
+	// AccountVersion is the version of the AccountBackup layout.
+	AccountVersion = 1
+)
+
+var (
+	// ErrNoConversation is returned when exporting a peer we have no
+	// conversation with.
+	ErrNoConversation = errors.New("no conversation with peer")
+	// ErrOtherIdentity is returned when importing an export made for another
+	// identity.
+	ErrOtherIdentity = errors.New("export belongs to a different identity")
+	// ErrExists is returned when importing over an existing conversation
+	// without replace.
+	ErrExists = errors.New("conversation with peer already exists; use --replace to overwrite it")
+	// ErrBadExport is returned for an export that decrypts but is malformed.
+	ErrBadExport = errors.New("malformed conversation export")
+	// ErrIdentityExists is returned when restoring an account backup into a
+	// home directory that already holds an identity.
+	ErrIdentityExists = errors.New("an identity already exists here; restore into an empty --home")
+	// ErrBadBackup is returned for an account backup that decrypts but is
+	// malformed or belongs to another username.
+	ErrBadBackup = errors.New("malformed account backup")
+)
+
+// Service exports and imports single conversations, archives dormant ones,
+// and pushes and restores account backups kept on a relay.
+type Service struct {
+	idStore      domain.IdentityStore
+	sessionStore domain.SessionStore
+	ratchetStore domain.RatchetStore
+	contactStore domain.ContactStore
+	historyStore domain.HistoryStore
+	archiveStore domain.ArchiveStore
+	sealer       domain.Sealer
+	relays       domain.RelayDirectory
+	now          func() time.Time
+	logger       *slog.Logger
+}
+
+// New returns a backup service over the given stores, sealing exports and
+// backups with sealer and storing backups on the default relay of relays.
+//
+// If logger is nil, log output is discarded.
+func New(
+	idStore domain.IdentityStore,
+	sessionStore domain.SessionStore,
+	ratchetStore domain.RatchetStore,
+	contactStore domain.ContactStore,
+	historyStore domain.HistoryStore,
+	archiveStore domain.ArchiveStore,
+	sealer domain.Sealer,
+	relays domain.RelayDirectory,
+	logger *slog.Logger,
+) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	return &Service{
+		idStore:      idStore,
+		sessionStore: sessionStore,
+		ratchetStore: ratchetStore,
+		contactStore: contactStore,
+		historyStore: historyStore,
+		archiveStore: archiveStore,
+		sealer:       sealer,
+		relays:       relays,
+		now:          time.Now,
+		logger:       logger,
+	}
+}
+
+// ExportConversation seals the session and ratchet state with peer under
+// backupPassphrase. passphrase unlocks the identity, whose key is recorded
+// so the export cannot be imported into another one. With remove, the local
+// conversation and session are deleted once the export is sealed.
+func (s *Service) ExportConversation(passphrase, backupPassphrase, peer string, remove bool) ([]byte, error) {
+	unlock, err := s.ratchetStore.LockConversation(peer)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	b, err := s.Snapshot(passphrase, peer)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.sealer.Seal(backupPassphrase, raw)
+	if err != nil {
+		return nil, err
+	}
+	if remove {
+		if _, err := s.ratchetStore.DeleteConversation(peer); err != nil {
+			return nil, fmt.Errorf("remove conversation: %w", err)
+		}
+		if _, err := s.sessionStore.DeleteSession(peer); err != nil {
+			return nil, fmt.Errorf("remove session: %w", err)
+		}
+	}
+	s.logger.Debug("conversation exported",
+		"peer", peer,
+		"session", b.Session != nil,
+		"skipped_keys", len(b.Conversation.State.Skipped),
+		"removed", remove,
+	)
+	return sealed, nil
+}
+
+// Snapshot returns the current session and ratchet state with peer as an
+// unsealed export. passphrase unlocks the identity the export records.
+func (s *Service) Snapshot(passphrase, peer string) (domain.ConversationBackup, error) {
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return domain.ConversationBackup{}, err
+	}
+	conv, found, err := s.ratchetStore.LoadConversation(peer)
+	if err != nil {
+		return domain.ConversationBackup{}, err
+	}
+	if !found {
+		return domain.ConversationBackup{}, fmt.Errorf("%w %q", ErrNoConversation, peer)
+	}
+	b := domain.ConversationBackup{
+		Version:      Version,
+		Peer:         peer,
+		OwnerIK:      id.XPub,
+		ExportedUTC:  s.now().Unix(),
+		Conversation: conv,
+	}
+	sess, found, err := s.sessionStore.LoadSession(peer)
+	if err != nil {
+		return domain.ConversationBackup{}, err
+	}
+	if found {
+		b.Session = &sess
+	}
+	return b, nil
+}
+
+// OpenExport opens an export sealed under backupPassphrase and checks its
+// layout, without storing it or checking whose it is.
+func (s *Service) OpenExport(backupPassphrase string, data []byte) (domain.ConversationBackup, error) {
+	raw, err := s.sealer.Open(backupPassphrase, data)
+	if err != nil {
+		return domain.ConversationBackup{}, err
+	}
+	var b domain.ConversationBackup
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return domain.ConversationBackup{}, fmt.Errorf("%w: %v", ErrBadExport, err)
+	}
+	if b.Version < 1 || b.Version > Version {
+		return domain.ConversationBackup{}, fmt.Errorf("%w: version %d", ErrBadExport, b.Version)
+	}
+	if b.Peer == "" || b.Conversation.Peer != b.Peer || (b.Session != nil && b.Session.Peer != b.Peer) {
+		return domain.ConversationBackup{}, fmt.Errorf("%w: peer mismatch", ErrBadExport)
+	}
+	return b, nil
+}
+
+// ImportConversation opens an export sealed under backupPassphrase and stores
+// its session and ratchet state. The export must belong to the identity
+// passphrase unlocks. An existing conversation with the peer is replaced only
+// with replace; its session is then replaced too, or removed if the export
+// has none.
+func (s *Service) ImportConversation(
+	passphrase string,
+	backupPassphrase string,
+	data []byte,
+	replace bool,
+) (domain.ConversationBackup, error) {
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return domain.ConversationBackup{}, err
+	}
+	b, err := s.OpenExport(backupPassphrase, data)
+	if err != nil {
+		return domain.ConversationBackup{}, err
+	}
+	if b.OwnerIK != id.XPub {
+		return domain.ConversationBackup{}, ErrOtherIdentity
+	}
+
+	unlock, err := s.ratchetStore.LockConversation(b.Peer)
+	if err != nil {
+		return domain.ConversationBackup{}, err
+	}
+	defer unlock()
+
+	_, exists, err := s.ratchetStore.LoadConversation(b.Peer)
+	if err != nil {
+		return domain.ConversationBackup{}, err
+	}
+	if exists && !replace {
+		return domain.ConversationBackup{}, ErrExists
+	}
+	if b.Session != nil {
+		err = s.sessionStore.SaveSession(b.Peer, *b.Session)
+	} else {
+		_, err = s.sessionStore.DeleteSession(b.Peer)
+	}
+	if err != nil {
+		return domain.ConversationBackup{}, err
+	}
+	if err := s.ratchetStore.SaveConversation(b.Peer, b.Conversation); err != nil {
+		return domain.ConversationBackup{}, err
+	}
+	s.logger.Debug("conversation imported",
+		"peer", b.Peer,
+		"session", b.Session != nil,
+		"replaced", exists,
+	)
+	return b, nil
+}
+
+// PushBackup seals the identity, sessions and contacts under passphrase and
+// stores them as username's backup on the default relay, signed with the
+// identity's signing key. The relay checks the signature against username's
+// published bundle, so username must be registered there with this identity.
+func (s *Service) PushBackup(ctx context.Context, passphrase, username string) (domain.AccountBackup, error) {
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return domain.AccountBackup{}, err
+	}
+	sessions, err := s.sessionStore.ListSessions()
+	if err != nil {
+		return domain.AccountBackup{}, err
+	}
+	contacts, err := s.contactStore.ListContacts()
+	if err != nil {
+		return domain.AccountBackup{}, err
+	}
+	b := domain.AccountBackup{
+		Version:    AccountVersion,
+		Username:   username,
+		CreatedUTC: s.now().Unix(),
+		Identity:   id,
+		Sessions:   sessions,
+		Contacts:   contacts,
+	}
+
+	raw, err := json.Marshal(b)
+	if err != nil {
+		return domain.AccountBackup{}, err
+	}
+	sealed, err := s.sealer.Seal(passphrase, raw)
+	if err != nil {
+		return domain.AccountBackup{}, err
+	}
+	rb := domain.RelayBackup{Data: sealed, UpdatedUTC: b.CreatedUTC}
+	relayauth.SignBackup(id.EdPriv, username, &rb)
+	if err := s.relays.Client("").PutBackup(ctx, username, rb); err != nil {
+		return domain.AccountBackup{}, err
+	}
+	s.logger.Debug("account backup pushed",
+		"user", username,
+		"sessions", len(sessions),
+		"contacts", len(contacts),
+		"bytes", len(sealed),
+	)
+	return b, nil
+}
+
+// RestoreBackup fetches username's backup from the default relay, opens it
+// with passphrase and saves its identity, sessions and contacts. The home
+// directory must not hold an identity yet. The identity is saved last, so a
+// restore that fails part way can simply be run again.
+func (s *Service) RestoreBackup(ctx context.Context, passphrase, username string) (domain.AccountBackup, error) {
+	if _, err := s.idStore.LoadIdentity(passphrase); !errors.Is(err, fs.ErrNotExist) {
+		return domain.AccountBackup{}, ErrIdentityExists
+	}
+	rb, err := s.relays.Client("").FetchBackup(ctx, username)
+	if err != nil {
+		return domain.AccountBackup{}, err
+	}
+	raw, err := s.sealer.Open(passphrase, rb.Data)
+	if err != nil {
+		return domain.AccountBackup{}, err
+	}
+	var b domain.AccountBackup
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return domain.AccountBackup{}, fmt.Errorf("%w: %v", ErrBadBackup, err)
+	}
+	if b.Version < 1 || b.Version > AccountVersion {
+		return domain.AccountBackup{}, fmt.Errorf("%w: version %d", ErrBadBackup, b.Version)
+	}
+	if b.Username != username || !relayauth.VerifyBackup(b.Identity.EdPub, username, rb) {
+		return domain.AccountBackup{}, fmt.Errorf("%w: not %q's backup", ErrBadBackup, username)
+	}
+
+	for _, c := range b.Contacts {
+		if err := s.contactStore.SaveContact(c); err != nil {
+			return domain.AccountBackup{}, err
+		}
+	}
+	for _, sess := range b.Sessions {
+		if err := s.sessionStore.SaveSession(sess.Peer, sess); err != nil {
+			return domain.AccountBackup{}, err
+		}
+	}
+	if err := s.idStore.SaveIdentity(passphrase, b.Identity); err != nil {
+		return domain.AccountBackup{}, err
+	}
+	s.logger.Debug("account backup restored",
+		"user", username,
+		"created_utc", b.CreatedUTC,
+		"sessions", len(b.Sessions),
+		"contacts", len(b.Contacts),
+	)
+	return b, nil
+}
+
+// Compile-time assertion that Service implements domain.BackupService.
+var _ domain.BackupService = (*Service)(nil)
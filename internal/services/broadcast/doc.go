@@ -0,0 +1,9 @@
+// Package broadcast manages broadcast lists: named sets of peers kept on this
+// client only.
+//
+// Sending to a list is not a group conversation. The message is encrypted
+// separately for every member over the pairwise session with that peer, so
+// members cannot tell it was a broadcast and do not learn who else received
+// it. A failure to one member does not stop delivery to the others; Send
+// reports the outcome for each member.
+package broadcast
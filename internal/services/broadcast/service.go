@@ -0,0 +1,208 @@
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// maxNameLen bounds the length of a list name.
+const maxNameLen = 32
+
+var (
+	// ErrBadName is returned for a list name that is not lowercase letters,
+	// digits and hyphens, starting with a letter.
+	ErrBadName = errors.New("list name must be lowercase letters, digits and hyphens, starting with a letter")
+	// ErrNoMembers is returned when a list would be left without members.
+	ErrNoMembers = errors.New("list needs at least one member")
+	// ErrExists is returned when creating a list whose name is taken.
+	ErrExists = errors.New("list already exists")
+	// ErrNotFound is returned for an unknown list.
+	ErrNotFound = errors.New("no such list")
+)
+
+// Service stores broadcast lists and sends messages to their members.
+type Service struct {
+	store    domain.BroadcastStore
+	messages domain.MessageService
+	logger   *slog.Logger
+}
+
+// New returns a broadcast service keeping lists in store and sending through
+// messages.
+//
+// If logger is nil, log output is discarded.
+func New(store domain.BroadcastStore, messages domain.MessageService, logger *slog.Logger) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	return &Service{store: store, messages: messages, logger: logger}
+}
+
+// Create stores a new list called name with the given members. Duplicate
+// members are dropped; order is otherwise kept.
+func (s *Service) Create(name string, members []string) (domain.BroadcastList, error) {
+	if !validName(name) {
+		return domain.BroadcastList{}, ErrBadName
+	}
+	members, err := cleanMembers(members)
+	if err != nil {
+		return domain.BroadcastList{}, err
+	}
+	if len(members) == 0 {
+		return domain.BroadcastList{}, ErrNoMembers
+	}
+	_, found, err := s.store.LoadBroadcast(name)
+	if err != nil {
+		return domain.BroadcastList{}, err
+	}
+	if found {
+		return domain.BroadcastList{}, ErrExists
+	}
+	l := domain.BroadcastList{Name: name, Members: members}
+	if err := s.store.SaveBroadcast(l); err != nil {
+		return domain.BroadcastList{}, err
+	}
+	s.logger.Debug("broadcast list created", "name", name, "members", len(members))
+	return l, nil
+}
+
+// AddMembers appends members not already on the list called name.
+func (s *Service) AddMembers(name string, members []string) (domain.BroadcastList, error) {
+	l, err := s.Get(name)
+	if err != nil {
+		return domain.BroadcastList{}, err
+	}
+	members, err = cleanMembers(members)
+	if err != nil {
+		return domain.BroadcastList{}, err
+	}
+	for _, m := range members {
+		if !slices.Contains(l.Members, m) {
+			l.Members = append(l.Members, m)
+		}
+	}
+	if err := s.store.SaveBroadcast(l); err != nil {
+		return domain.BroadcastList{}, err
+	}
+	return l, nil
+}
+
+// RemoveMembers drops members from the list called name. A list cannot be
+// emptied this way; delete it instead.
+func (s *Service) RemoveMembers(name string, members []string) (domain.BroadcastList, error) {
+	l, err := s.Get(name)
+	if err != nil {
+		return domain.BroadcastList{}, err
+	}
+	l.Members = slices.DeleteFunc(l.Members, func(m string) bool {
+		return slices.Contains(members, m)
+	})
+	if len(l.Members) == 0 {
+		return domain.BroadcastList{}, ErrNoMembers
+	}
+	if err := s.store.SaveBroadcast(l); err != nil {
+		return domain.BroadcastList{}, err
+	}
+	return l, nil
+}
+
+// Get returns the list called name.
+func (s *Service) Get(name string) (domain.BroadcastList, error) {
+	l, found, err := s.store.LoadBroadcast(name)
+	if err != nil {
+		return domain.BroadcastList{}, err
+	}
+	if !found {
+		return domain.BroadcastList{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return l, nil
+}
+
+// List returns every list ordered by name.
+func (s *Service) List() ([]domain.BroadcastList, error) {
+	return s.store.ListBroadcasts()
+}
+
+// Delete removes the list called name. Conversations with its members are
+// not affected.
+func (s *Service) Delete(name string) error {
+	found, err := s.store.DeleteBroadcast(name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return nil
+}
+
+// Send encrypts body for each member of the list called name over the
+// pairwise session with that member.
+// Members are tried in list order and a failure does not stop the rest; the
+// results say which were sent.
+func (s *Service) Send(
+	ctx context.Context,
+	passphrase string,
+	from string,
+	name string,
+	body domain.MessageBody,
+	force bool,
+	expires time.Duration,
+) ([]domain.BroadcastResult, error) {
+	l, err := s.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]domain.BroadcastResult, 0, len(l.Members))
+	failed := 0
+	for _, peer := range l.Members {
+		err := ctx.Err()
+		if err == nil {
+			err = s.messages.SendMessage(ctx, passphrase, from, peer, body, force, expires)
+		}
+		if err != nil {
+			failed++
+		}
+		results = append(results, domain.BroadcastResult{Peer: peer, Err: err})
+	}
+	s.logger.Debug("broadcast sent", "name", name, "members", len(l.Members), "failed", failed)
+	return results, nil
+}
+
+// validName reports whether name is a well-formed list name.
+func validName(name string) bool {
+	if name == "" || len(name) > maxNameLen || name[0] < 'a' || name[0] > 'z' {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		ch := name[i]
+		if (ch < 'a' || ch > 'z') && (ch < '0' || ch > '9') && ch != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// cleanMembers drops duplicates from members and rejects empty names and
+// names of other lists.
+func cleanMembers(members []string) ([]string, error) {
+	out := make([]string, 0, len(members))
+	for _, m := range members {
+		if m == "" || m[0] == '@' {
+			return nil, fmt.Errorf("invalid member %q", m)
+		}
+		if !slices.Contains(out, m) {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+// Compile-time assertion that Service implements domain.BroadcastService.
+var _ domain.BroadcastService = (*Service)(nil)
@@ -0,0 +1,10 @@
+// Package conversation manages local per-conversation preferences: muting
+// (indefinitely or for a duration), whether a conversation notifies at all, and
+// whether notifications include a message preview.
+//
+// Preferences only affect how the client surfaces messages. Muted
+// conversations are still fetched, decrypted and acknowledged, and nothing
+// about them is shared with the peer or the relay. Callers that raise
+// notifications (such as "recv --notify") ask domain.ConversationPrefs
+// whether to notify and whether to show the text.
+package conversation
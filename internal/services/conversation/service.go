@@ -0,0 +1,562 @@
+package conversation
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+)
+
+var (
+	// ErrNoPeer is returned when a preference is set without a peer name.
+	ErrNoPeer = errors.New("peer required")
+	// ErrBadNotifyMode is returned for a notify mode other than always or never.
+	ErrBadNotifyMode = errors.New("notify mode must be always or never")
+	// ErrBadSendPolicy is returned for an unknown send policy.
+	ErrBadSendPolicy = errors.New("send policy must be allow or require-verified")
+	// ErrBadOversizePolicy is returned for an unknown oversize policy.
+	ErrBadOversizePolicy = errors.New("oversize policy must be chunk or fail")
+	// ErrBadFilters is returned for a negative size limit or an empty
+	// content type or sender.
+	ErrBadFilters = errors.New("filter size must not be negative and types and senders must not be empty")
+	// ErrBadRekeyPolicy is returned for a negative rekey interval.
+	ErrBadRekeyPolicy = errors.New("rekey days and messages must not be negative")
+	// ErrBadWatchdogPolicy is returned for a negative watchdog threshold.
+	ErrBadWatchdogPolicy = errors.New("watchdog days and messages must not be negative")
+	// ErrBadResendAfter is returned for a negative resend delay or one
+	// that is not a whole number of seconds.
+	ErrBadResendAfter = errors.New("resend delay must be a non-negative whole number of seconds")
+	// ErrBadRetention is returned for a negative retention limit, or limits
+	// combined with keeping nothing.
+	ErrBadRetention = errors.New("retention limits must not be negative or combined with keeping nothing")
+	// ErrBadSessionPolicy is returned for an unknown accept mode or a
+	// negative prekey limit.
+	ErrBadSessionPolicy = errors.New("accept mode must be yes, no or ask, and prekey limits must not be negative")
+)
+
+// Service reads and updates per-conversation preferences and the global
+// settings they fall back to.
+type Service struct {
+	store    domain.PreferenceStore
+	settings domain.SettingsStore
+	now      func() time.Time
+	logger   *slog.Logger
+}
+
+// New returns a conversation service backed by the given stores.
+//
+// If logger is nil, log output is discarded.
+func New(store domain.PreferenceStore, settings domain.SettingsStore, logger *slog.Logger) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	return &Service{store: store, settings: settings, now: time.Now, logger: logger}
+}
+
+// Mute silences notifications from peer. A zero or negative d mutes
+// indefinitely; otherwise the mute lifts by itself after d.
+func (s *Service) Mute(peer string, d time.Duration) (domain.ConversationPrefs, error) {
+	return s.update(peer, func(p *domain.ConversationPrefs) {
+		p.Muted = true
+		p.MutedUntilUTC = 0
+		if d > 0 {
+			p.MutedUntilUTC = s.now().Add(d).Unix()
+		}
+	})
+}
+
+// Unmute lifts any mute on peer.
+func (s *Service) Unmute(peer string) (domain.ConversationPrefs, error) {
+	return s.update(peer, func(p *domain.ConversationPrefs) {
+		p.Muted = false
+		p.MutedUntilUTC = 0
+	})
+}
+
+// SetNotify sets whether peer's messages raise notifications.
+func (s *Service) SetNotify(peer string, mode domain.NotifyMode) (domain.ConversationPrefs, error) {
+	if mode != domain.NotifyAlways && mode != domain.NotifyNever {
+		return domain.ConversationPrefs{}, fmt.Errorf("%w: %q", ErrBadNotifyMode, mode)
+	}
+	return s.update(peer, func(p *domain.ConversationPrefs) { p.Notify = mode })
+}
+
+// SetPreview sets whether notifications for peer include the message text.
+func (s *Service) SetPreview(peer string, show bool) (domain.ConversationPrefs, error) {
+	return s.update(peer, func(p *domain.ConversationPrefs) { p.HidePreview = !show })
+}
+
+// SetAcceptWipe sets whether peer's remote wipe requests are honoured. The
+// default is to refuse them.
+func (s *Service) SetAcceptWipe(peer string, accept bool) (domain.ConversationPrefs, error) {
+	return s.update(peer, func(p *domain.ConversationPrefs) { p.AcceptWipe = accept })
+}
+
+// SetSendPolicy sets peer's send policy. SendPolicyDefault removes the
+// override so the global policy applies.
+func (s *Service) SetSendPolicy(peer string, policy domain.SendPolicy) (domain.ConversationPrefs, error) {
+	if policy != domain.SendPolicyDefault && !validSendPolicy(policy) {
+		return domain.ConversationPrefs{}, fmt.Errorf("%w: %q", ErrBadSendPolicy, policy)
+	}
+	return s.update(peer, func(p *domain.ConversationPrefs) { p.SendPolicy = policy })
+}
+
+// SetDefaultSendPolicy sets the policy for peers without their own.
+func (s *Service) SetDefaultSendPolicy(policy domain.SendPolicy) error {
+	if !validSendPolicy(policy) {
+		return fmt.Errorf("%w: %q", ErrBadSendPolicy, policy)
+	}
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return err
+	}
+	st.SendPolicy = policy
+	if err := s.settings.SaveSettings(st); err != nil {
+		return err
+	}
+	s.logger.Debug("default send policy updated", "policy", policy)
+	return nil
+}
+
+// DefaultSendPolicy returns the policy for peers without their own.
+func (s *Service) DefaultSendPolicy() (domain.SendPolicy, error) {
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return "", err
+	}
+	if st.SendPolicy == domain.SendPolicyDefault {
+		return domain.SendPolicyAllow, nil
+	}
+	return st.SendPolicy, nil
+}
+
+// EffectiveSendPolicy returns peer's own policy if set, else the default.
+func (s *Service) EffectiveSendPolicy(peer string) (domain.SendPolicy, error) {
+	p, _, err := s.store.LoadPreferences(peer)
+	if err != nil {
+		return "", err
+	}
+	if p.SendPolicy != domain.SendPolicyDefault {
+		return p.SendPolicy, nil
+	}
+	return s.DefaultSendPolicy()
+}
+
+// SetCollectStats turns local ratchet statistics collection on or off.
+func (s *Service) SetCollectStats(on bool) error {
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return err
+	}
+	st.CollectStats = on
+	if err := s.settings.SaveSettings(st); err != nil {
+		return err
+	}
+	s.logger.Debug("statistics collection updated", "on", on)
+	return nil
+}
+
+// CollectStats reports whether ratchet statistics are being collected.
+func (s *Service) CollectStats() (bool, error) {
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return false, err
+	}
+	return st.CollectStats, nil
+}
+
+// SetRatchetDebug turns local recording of ratchet steps on or off.
+func (s *Service) SetRatchetDebug(on bool) error {
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return err
+	}
+	st.RatchetDebug = on
+	if err := s.settings.SaveSettings(st); err != nil {
+		return err
+	}
+	s.logger.Debug("ratchet debugging updated", "on", on)
+	return nil
+}
+
+// RatchetDebug reports whether ratchet steps are being recorded.
+func (s *Service) RatchetDebug() (bool, error) {
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return false, err
+	}
+	return st.RatchetDebug, nil
+}
+
+// SetOversizePolicy sets what happens to messages too large for one relay
+// envelope.
+func (s *Service) SetOversizePolicy(p domain.OversizePolicy) error {
+	if p != domain.OversizeChunk && p != domain.OversizeFail {
+		return fmt.Errorf("%w: %q", ErrBadOversizePolicy, p)
+	}
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return err
+	}
+	st.Oversize = p
+	if err := s.settings.SaveSettings(st); err != nil {
+		return err
+	}
+	s.logger.Debug("oversize policy updated", "policy", p)
+	return nil
+}
+
+// OversizePolicy returns what happens to messages too large for one relay
+// envelope.
+func (s *Service) OversizePolicy() (domain.OversizePolicy, error) {
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return "", err
+	}
+	if st.Oversize == "" {
+		return domain.OversizeChunk, nil
+	}
+	return st.Oversize, nil
+}
+
+// SetReceiveFilters sets which received messages are held for review. The
+// zero value turns filtering off.
+func (s *Service) SetReceiveFilters(f domain.ReceiveFilters) error {
+	if f.MaxSize < 0 || slices.Contains(f.ContentTypes, "") || slices.Contains(f.Senders, "") {
+		return ErrBadFilters
+	}
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return err
+	}
+	st.Filters = f
+	if err := s.settings.SaveSettings(st); err != nil {
+		return err
+	}
+	s.logger.Debug("receive filters updated",
+		"max_size", f.MaxSize,
+		"content_types", len(f.ContentTypes),
+		"senders", len(f.Senders),
+	)
+	return nil
+}
+
+// ReceiveFilters returns which received messages are held for review.
+func (s *Service) ReceiveFilters() (domain.ReceiveFilters, error) {
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return domain.ReceiveFilters{}, err
+	}
+	return st.Filters, nil
+}
+
+// SetRekeyPolicy sets when conversations we initiated are rekeyed. The zero
+// policy turns rekeying off.
+func (s *Service) SetRekeyPolicy(p domain.RekeyPolicy) error {
+	if p.Days < 0 || p.Messages < 0 {
+		return ErrBadRekeyPolicy
+	}
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return err
+	}
+	st.Rekey = p
+	if err := s.settings.SaveSettings(st); err != nil {
+		return err
+	}
+	s.logger.Debug("rekey policy updated", "days", p.Days, "messages", p.Messages)
+	return nil
+}
+
+// RekeyPolicy returns when conversations we initiated are rekeyed.
+func (s *Service) RekeyPolicy() (domain.RekeyPolicy, error) {
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return domain.RekeyPolicy{}, err
+	}
+	return st.Rekey, nil
+}
+
+// SetResendAfter sets how long a gap in a conversation lasts before the peer
+// is asked to resend the missing messages. Zero never asks.
+func (s *Service) SetResendAfter(d time.Duration) error {
+	if d < 0 || d%time.Second != 0 {
+		return ErrBadResendAfter
+	}
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return err
+	}
+	st.ResendAfter = int(d / time.Second)
+	if err := s.settings.SaveSettings(st); err != nil {
+		return err
+	}
+	s.logger.Debug("resend delay updated", "after", d)
+	return nil
+}
+
+// ResendAfter returns how long a gap lasts before a resend is requested, or
+// zero if resends are never requested.
+func (s *Service) ResendAfter() (time.Duration, error) {
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(st.ResendAfter) * time.Second, nil
+}
+
+// SetWatchdogPolicy sets when recv --follow reports a conversation as stuck.
+// The zero policy turns the watchdog off.
+func (s *Service) SetWatchdogPolicy(p domain.WatchdogPolicy) error {
+	if p.Days < 0 || p.Messages < 0 {
+		return ErrBadWatchdogPolicy
+	}
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return err
+	}
+	st.Watchdog = p
+	if err := s.settings.SaveSettings(st); err != nil {
+		return err
+	}
+	s.logger.Debug("watchdog policy updated", "days", p.Days, "messages", p.Messages)
+	return nil
+}
+
+// WatchdogPolicy returns when conversations are reported as stuck.
+func (s *Service) WatchdogPolicy() (domain.WatchdogPolicy, error) {
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return domain.WatchdogPolicy{}, err
+	}
+	return st.Watchdog, nil
+}
+
+// SetSuite sets the cipher suite offered first in handshakes we start. It is
+// used with peers whose bundle offers it; others get the first registered
+// suite they offer. "" restores crypto.DefaultSuite. Conversations already
+// running keep their suite until they are rekeyed or restarted.
+func (s *Service) SetSuite(id string) error {
+	if id != "" {
+		if _, err := crypto.LookupSuite(id); err != nil {
+			return err
+		}
+	}
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return err
+	}
+	st.Suite = id
+	if err := s.settings.SaveSettings(st); err != nil {
+		return err
+	}
+	s.logger.Debug("preferred cipher suite updated", "suite", id)
+	return nil
+}
+
+// Suite returns the cipher suite offered first in handshakes we start, or ""
+// for crypto.DefaultSuite.
+func (s *Service) Suite() (string, error) {
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return "", err
+	}
+	return st.Suite, nil
+}
+
+// SetRetention sets how much of peer's history is kept. nil removes the
+// override so the global policy applies.
+func (s *Service) SetRetention(peer string, p *domain.RetentionPolicy) (domain.ConversationPrefs, error) {
+	if p != nil && !validRetention(*p) {
+		return domain.ConversationPrefs{}, ErrBadRetention
+	}
+	return s.update(peer, func(prefs *domain.ConversationPrefs) { prefs.Retention = p })
+}
+
+// SetDefaultRetention sets how much history is kept for peers without their
+// own policy. The zero policy keeps everything.
+func (s *Service) SetDefaultRetention(p domain.RetentionPolicy) error {
+	if !validRetention(p) {
+		return ErrBadRetention
+	}
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return err
+	}
+	st.Retention = p
+	if err := s.settings.SaveSettings(st); err != nil {
+		return err
+	}
+	s.logger.Debug("default retention updated",
+		"keep_last", p.KeepLast,
+		"keep_days", p.KeepDays,
+		"keep_none", p.KeepNone,
+	)
+	return nil
+}
+
+// DefaultRetention returns how much history is kept for peers without their
+// own policy.
+func (s *Service) DefaultRetention() (domain.RetentionPolicy, error) {
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return domain.RetentionPolicy{}, err
+	}
+	return st.Retention, nil
+}
+
+// Retention returns the global retention policy with every per-peer override.
+func (s *Service) Retention() (domain.Retention, error) {
+	def, err := s.DefaultRetention()
+	if err != nil {
+		return domain.Retention{}, err
+	}
+	ps, err := s.store.ListPreferences()
+	if err != nil {
+		return domain.Retention{}, err
+	}
+	r := domain.Retention{Default: def, Peers: map[string]domain.RetentionPolicy{}}
+	for _, p := range ps {
+		if p.Retention != nil {
+			r.Peers[p.Peer] = *p.Retention
+		}
+	}
+	return r, nil
+}
+
+// SetSessionPolicy sets how new sessions and our prekeys are handled. It is
+// evaluated as it is used: by the message service when a new session arrives
+// or a reply is sent, and by the account service when it maintains prekeys.
+func (s *Service) SetSessionPolicy(p domain.SessionPolicy) error {
+	switch p.AcceptNew {
+	case "", domain.AcceptYes, domain.AcceptNo, domain.AcceptAsk:
+	default:
+		return fmt.Errorf("%w: %q", ErrBadSessionPolicy, p.AcceptNew)
+	}
+	if p.ReplenishOPKs < 0 || p.RotateSPKDays < 0 {
+		return ErrBadSessionPolicy
+	}
+	if p.AcceptNew == domain.AcceptYes {
+		p.AcceptNew = ""
+	}
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return err
+	}
+	st.Sessions = p
+	if err := s.settings.SaveSettings(st); err != nil {
+		return err
+	}
+	s.logger.Debug("session policy updated",
+		"accept_new", p.AcceptNew,
+		"replenish_opks", p.ReplenishOPKs,
+		"rotate_spk_days", p.RotateSPKDays,
+		"verify_before_reply", p.VerifyBeforeReply,
+	)
+	return nil
+}
+
+// SessionPolicy returns how new sessions and our prekeys are handled, with
+// AcceptNew filled in.
+func (s *Service) SessionPolicy() (domain.SessionPolicy, error) {
+	st, err := s.settings.LoadSettings()
+	if err != nil {
+		return domain.SessionPolicy{}, err
+	}
+	p := st.Sessions
+	if p.AcceptNew == "" {
+		p.AcceptNew = domain.AcceptYes
+	}
+	return p, nil
+}
+
+// SetAcceptSession sets whether a new session from peer is accepted even
+// when the session policy would refuse it or ask first.
+func (s *Service) SetAcceptSession(peer string, accept bool) (domain.ConversationPrefs, error) {
+	return s.update(peer, func(p *domain.ConversationPrefs) { p.AcceptSession = accept })
+}
+
+// Preferences returns peer's preferences, or the defaults if none are saved.
+// An expired timed mute is reported as unmuted.
+func (s *Service) Preferences(peer string) (domain.ConversationPrefs, error) {
+	p, _, err := s.store.LoadPreferences(peer)
+	if err != nil {
+		return domain.ConversationPrefs{}, err
+	}
+	p.Peer = peer
+	return s.normalise(p), nil
+}
+
+// ListPreferences returns every conversation with saved preferences.
+func (s *Service) ListPreferences() ([]domain.ConversationPrefs, error) {
+	ps, err := s.store.ListPreferences()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ps {
+		ps[i] = s.normalise(ps[i])
+	}
+	return ps, nil
+}
+
+// update loads peer's preferences, applies fn and saves the result.
+func (s *Service) update(
+	peer string,
+	fn func(p *domain.ConversationPrefs),
+) (domain.ConversationPrefs, error) {
+	if peer == "" {
+		return domain.ConversationPrefs{}, ErrNoPeer
+	}
+	p, err := s.Preferences(peer)
+	if err != nil {
+		return domain.ConversationPrefs{}, err
+	}
+	fn(&p)
+	if err := s.store.SavePreferences(p); err != nil {
+		return domain.ConversationPrefs{}, err
+	}
+	s.logger.Debug("conversation preferences updated",
+		"peer", peer,
+		"muted", p.Muted,
+		"muted_until", p.MutedUntilUTC,
+		"notify", p.Notify,
+		"hide_preview", p.HidePreview,
+		"send_policy", p.SendPolicy,
+		"accept_wipe", p.AcceptWipe,
+		"accept_session", p.AcceptSession,
+		"retention", p.Retention != nil,
+	)
+	return p, nil
+}
+
+// normalise fills in the default notify mode and clears an expired mute.
+func (s *Service) normalise(p domain.ConversationPrefs) domain.ConversationPrefs {
+	if p.Notify == "" {
+		p.Notify = domain.NotifyAlways
+	}
+	if p.Muted && !p.MutedAt(s.now().Unix()) {
+		p.Muted, p.MutedUntilUTC = false, 0
+	}
+	return p
+}
+
+// validSendPolicy reports whether policy is a concrete (non-default) policy.
+func validSendPolicy(policy domain.SendPolicy) bool {
+	return policy == domain.SendPolicyAllow || policy == domain.SendPolicyRequireVerified
+}
+
+// validRetention reports whether p has no negative limits and does not set
+// limits alongside keeping nothing.
+func validRetention(p domain.RetentionPolicy) bool {
+	if p.KeepLast < 0 || p.KeepDays < 0 {
+		return false
+	}
+	return !p.KeepNone || (p.KeepLast == 0 && p.KeepDays == 0)
+}
+
+// Compile-time assertion that Service implements domain.ConversationService.
+var _ domain.ConversationService = (*Service)(nil)
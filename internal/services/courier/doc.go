@@ -0,0 +1,13 @@
+// Package courier carries single messages without a relay.
+//
+// Export encrypts a message on the ordinary Double Ratchet conversation and
+// wraps the envelope as armored text (see internal/protocol/armor) that can
+// be sent by email, chat or on a USB stick. Import reverses it on the
+// recipient's machine. A first message carries its prekey message as usual,
+// so a conversation can start out of band; the recipient's session
+// confirmation is posted to the relay if one is reachable.
+//
+// The envelope is end-to-end encrypted either way. An optional password seals
+// the whole envelope as well, so the courier cannot read who it is from, who
+// it is for or where it sits in the ratchet.
+package courier
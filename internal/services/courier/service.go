@@ -0,0 +1,103 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/armor"
+)
+
+var (
+	// ErrPasswordRequired is returned when importing a sealed envelope
+	// without a password.
+	ErrPasswordRequired = errors.New("envelope is password protected; pass --password")
+	// ErrBadEnvelope is returned for an armored block that does not hold an
+	// envelope.
+	ErrBadEnvelope = errors.New("malformed envelope")
+)
+
+// Service exports and imports armored envelopes.
+type Service struct {
+	messages domain.MessageService
+	sealer   domain.Sealer
+	logger   *slog.Logger
+}
+
+// New returns a courier service that encrypts with messages and seals
+// password-protected envelopes with sealer.
+//
+// If logger is nil, log output is discarded.
+func New(messages domain.MessageService, sealer domain.Sealer, logger *slog.Logger) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	return &Service{messages: messages, sealer: sealer, logger: logger}
+}
+
+// Export encrypts msg for to and returns the envelope as armored text. With a
+// password, the envelope is sealed under it first.
+//
+// The ratchet advances as for a send, so every exported envelope must reach
+// the peer.
+func (s *Service) Export(
+	passphrase string,
+	from string,
+	to string,
+	msg domain.MessageBody,
+	force bool,
+	password string,
+) ([]byte, error) {
+	env, err := s.messages.ExportEnvelope(passphrase, from, to, msg, force)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	typ := armor.TypeEnvelope
+	if password != "" {
+		if raw, err = s.sealer.Seal(password, raw); err != nil {
+			return nil, err
+		}
+		typ = armor.TypeSealedEnvelope
+	}
+	s.logger.Debug("envelope armored", "peer", to, "sealed", password != "", "bytes", len(raw))
+	return armor.Encode(typ, raw), nil
+}
+
+// Import decodes an armored envelope, opens it with password if it is sealed,
+// and decrypts it for me.
+func (s *Service) Import(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	armored []byte,
+	password string,
+) (domain.DecryptedMessage, error) {
+	typ, raw, err := armor.Decode(armored)
+	if err != nil {
+		return domain.DecryptedMessage{}, err
+	}
+	if typ == armor.TypeSealedEnvelope {
+		if password == "" {
+			return domain.DecryptedMessage{}, ErrPasswordRequired
+		}
+		if raw, err = s.sealer.Open(password, raw); err != nil {
+			return domain.DecryptedMessage{}, err
+		}
+	}
+	var env domain.Envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return domain.DecryptedMessage{}, fmt.Errorf("%w: %v", ErrBadEnvelope, err)
+	}
+	s.logger.Debug("envelope unarmored", "from", env.From, "sealed", typ == armor.TypeSealedEnvelope)
+	return s.messages.ImportEnvelope(ctx, passphrase, me, env)
+}
+
+// Compile-time assertion that Service implements domain.CourierService.
+var _ domain.CourierService = (*Service)(nil)
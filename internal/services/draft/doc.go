@@ -0,0 +1,17 @@
+// Package draft keeps messages being composed, one per peer, and sends them
+// once they are done.
+//
+// A draft is the text the user edits: the message body, in markdown unless
+// another content type was chosen, with attachment references on lines of
+// their own:
+//
+//	@attach ~/notes/plan.pdf
+//
+// Drafts are kept encrypted under the passphrase, like the history, so an
+// unfinished message never sits in the clear in the home directory. Send
+// reads every referenced file before sending anything, so a missing or
+// oversized file costs nothing. The body goes first, then each attachment as
+// its own message carrying the file name. The relay has no attachment store
+// on this path: files travel inline, split into chunks when large (see
+// package chunk).
+package draft
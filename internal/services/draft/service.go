@@ -0,0 +1,233 @@
+package draft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+	"ciphera/internal/protocol/chunk"
+)
+
+// attachKeyword starts a line naming a file to send after the body.
+const attachKeyword = "@attach"
+
+var (
+	// ErrNoDraft is returned when sending a peer's draft and there is none.
+	ErrNoDraft = errors.New("no draft for this peer")
+	// ErrEmptyDraft is returned when sending a draft with neither a body nor
+	// attachments.
+	ErrEmptyDraft = errors.New("draft is empty")
+	// ErrTooLarge is returned for an attachment too large to send.
+	ErrTooLarge = errors.New("attachment too large to send")
+)
+
+// Service stores drafts and sends them through the message service.
+type Service struct {
+	store    domain.DraftStore
+	idStore  domain.IdentityStore
+	messages domain.MessageService
+	logger   *slog.Logger
+}
+
+// New returns a draft service keeping drafts in store and sending through
+// messages. idStore checks the passphrase before a draft is opened.
+//
+// If logger is nil, log output is discarded.
+func New(
+	store domain.DraftStore,
+	idStore domain.IdentityStore,
+	messages domain.MessageService,
+	logger *slog.Logger,
+) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	return &Service{store: store, idStore: idStore, messages: messages, logger: logger}
+}
+
+// Draft returns the draft for peer, if any. The passphrase is checked
+// against the identity first, so a draft is never saved under a wrong one.
+func (s *Service) Draft(passphrase, peer string) (domain.Draft, bool, error) {
+	if _, err := s.idStore.LoadIdentity(passphrase); err != nil {
+		return domain.Draft{}, false, err
+	}
+	return s.store.LoadDraft(passphrase, peer)
+}
+
+// Save stores text as the draft for peer. An empty contentType means
+// markdown.
+func (s *Service) Save(passphrase, peer, text, contentType string) (domain.Draft, error) {
+	if contentType == "" {
+		contentType = body.TypeMarkdown
+	}
+	d := domain.Draft{
+		Peer:        peer,
+		Text:        text,
+		ContentType: contentType,
+		UpdatedUTC:  time.Now().Unix(),
+	}
+	if err := s.store.SaveDraft(passphrase, d); err != nil {
+		return domain.Draft{}, err
+	}
+	s.logger.Debug("draft saved", "peer", peer, "bytes", len(text))
+	return d, nil
+}
+
+// List returns every draft, by peer.
+func (s *Service) List(passphrase string) ([]domain.Draft, error) {
+	return s.store.ListDrafts(passphrase)
+}
+
+// Discard deletes the draft for peer and reports whether there was one.
+func (s *Service) Discard(passphrase, peer string) (bool, error) {
+	found, err := s.store.DeleteDraft(passphrase, peer)
+	if err == nil && found {
+		s.logger.Debug("draft discarded", "peer", peer)
+	}
+	return found, err
+}
+
+// Send sends the draft for peer: the body, if any, then every attachment it
+// references, in order. Relative attachment paths are taken from the
+// current directory. All files are read before anything is sent. The draft
+// is deleted once everything is sent; if an attachment fails, the draft
+// keeps the references not yet sent, without the body already delivered.
+func (s *Service) Send(
+	ctx context.Context,
+	passphrase string,
+	from string,
+	peer string,
+	force bool,
+	expires time.Duration,
+) (domain.DraftSent, error) {
+	var sent domain.DraftSent
+	d, ok, err := s.store.LoadDraft(passphrase, peer)
+	if err != nil {
+		return sent, err
+	}
+	if !ok {
+		return sent, ErrNoDraft
+	}
+	text, paths := parse(d.Text)
+	if text == "" && len(paths) == 0 {
+		return sent, ErrEmptyDraft
+	}
+	files := make([]domain.MessageBody, len(paths))
+	for i, p := range paths {
+		if files[i], err = readAttachment(p); err != nil {
+			return sent, err
+		}
+	}
+
+	if text != "" {
+		msg := domain.MessageBody{ContentType: d.ContentType, Body: []byte(text)}
+		if err := s.messages.SendMessage(ctx, passphrase, from, peer, msg, force, expires); err != nil {
+			return sent, err
+		}
+		sent.Body = true
+	}
+	for i, f := range files {
+		if err := s.messages.SendMessage(ctx, passphrase, from, peer, f, force, expires); err != nil {
+			s.keepUnsent(passphrase, d, paths[i:])
+			return sent, fmt.Errorf("sending attachment %s: %w", paths[i], err)
+		}
+		sent.Attachments = append(sent.Attachments, paths[i])
+	}
+	if _, err := s.store.DeleteDraft(passphrase, peer); err != nil {
+		return sent, fmt.Errorf("sent, but the draft was not deleted: %w", err)
+	}
+	s.logger.Debug("draft sent", "peer", peer, "body", sent.Body, "attachments", len(sent.Attachments))
+	return sent, nil
+}
+
+// keepUnsent rewrites d to reference only the attachments in unsent, so
+// sending it again does not repeat what was delivered. A failure is logged:
+// the caller is already reporting the send error.
+func (s *Service) keepUnsent(passphrase string, d domain.Draft, unsent []string) {
+	var b strings.Builder
+	for _, p := range unsent {
+		b.WriteString(attachKeyword + " " + p + "\n")
+	}
+	if _, err := s.Save(passphrase, d.Peer, b.String(), d.ContentType); err != nil {
+		s.logger.Warn("draft not updated after partial send", "peer", d.Peer, "err", err)
+	}
+}
+
+// parse splits draft text into the body and the attachment paths. Blank
+// lines around the body are dropped.
+func parse(text string) (string, []string) {
+	var (
+		lines []string
+		paths []string
+	)
+	for line := range strings.Lines(text) {
+		if f := strings.Fields(line); len(f) > 1 && f[0] == attachKeyword {
+			_, p, _ := strings.Cut(line, attachKeyword)
+			paths = append(paths, strings.TrimSpace(p))
+			continue
+		}
+		lines = append(lines, line)
+	}
+	msg := strings.TrimRight(strings.Join(lines, ""), " \t\r\n")
+	for {
+		first, rest, ok := strings.Cut(msg, "\n")
+		if !ok || strings.TrimSpace(first) != "" {
+			break
+		}
+		msg = rest
+	}
+	if strings.TrimSpace(msg) == "" {
+		msg = ""
+	}
+	return msg, paths
+}
+
+// readAttachment reads the file at path into a message named after it,
+// typed by its extension, or as text or binary by its content.
+func readAttachment(path string) (domain.MessageBody, error) {
+	full := path
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return domain.MessageBody{}, fmt.Errorf("attachment %s: %w", path, err)
+		}
+		full = filepath.Join(home, rest)
+	}
+	data, err := os.ReadFile(full)
+	if err != nil {
+		return domain.MessageBody{}, fmt.Errorf("attachment %s: %w", path, err)
+	}
+	typ, _, _ := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(full)))
+	switch {
+	case typ != "":
+	case utf8.Valid(data):
+		typ = body.TypeText
+	default:
+		typ = body.TypeBinary
+	}
+	msg := domain.MessageBody{
+		ContentType: typ,
+		Body:        data,
+		Metadata:    map[string]string{body.MetaFileName: filepath.Base(full)},
+	}
+	plain, err := body.Encode(msg)
+	if err != nil {
+		return domain.MessageBody{}, fmt.Errorf("attachment %s: %w", path, err)
+	}
+	if len(plain) > chunk.MaxParts*chunk.PartSize {
+		return domain.MessageBody{}, fmt.Errorf("%w: %s is %d bytes", ErrTooLarge, path, len(data))
+	}
+	return msg, nil
+}
+
+// Compile-time assertion that Service implements domain.DraftService.
+var _ domain.DraftService = (*Service)(nil)
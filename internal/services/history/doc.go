@@ -0,0 +1,24 @@
+// Package history reads the local message history, summarises conversations
+// in it (see Stats), and imports transcripts exported from other messengers.
+//
+// The message service appends every message it sends or receives to the
+// history store, which is encrypted under a history key of its own. The key
+// is encrypted under the identity passphrase until Lock gives the history a
+// passphrase of its own, so history can be handed over without the identity
+// or the other way round; messages keep being added while it is locked and
+// are merged in when it is next opened. Unlock goes back to the identity
+// passphrase. Imported
+// messages are stored alongside them but carry their source format in
+// domain.HistoryEntry.Source: Ciphera never authenticated them, so callers
+// must mark them as imported wherever they are shown.
+//
+// Supported import formats:
+//
+//	json           a JSON array of {"peer", "direction", "time", "text",
+//	               "content_type", "meta"} objects; see FormatJSON
+//	signal-backup  a CSV export of the message table from a decrypted Signal
+//	               backup; see FormatSignalBackup
+//
+// Each imported entry gets an ID derived from its contents, so importing the
+// same file twice adds nothing the second time.
+package history
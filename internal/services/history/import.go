@@ -0,0 +1,209 @@
+package history
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+)
+
+// Import formats.
+const (
+	// FormatJSON is a JSON array of objects:
+	//
+	//	[{"peer": "bob", "direction": "in", "time": "2024-05-01T09:30:00Z",
+	//	  "text": "hi", "content_type": "text/plain", "meta": {}}]
+	//
+	// direction is "in" or "out" and time is RFC 3339. peer may be omitted
+	// when a peer is given for the whole import; content_type defaults to
+	// text/plain and meta is optional.
+	FormatJSON = "json"
+
+	// FormatSignalBackup is the message table of a decrypted Signal backup
+	// exported as CSV with a header row, as produced by backup tools such as
+	// signalbackup-tools. The date_sent (milliseconds), type and body columns
+	// are read; thread_id, if present, selects one conversation, and _id keeps
+	// re-imports idempotent. Signal does not store the peer's name per
+	// message, so a peer must be given.
+	FormatSignalBackup = "signal-backup"
+)
+
+// Signal message type values. The low five bits of the type column hold the
+// base type; the rest are flags.
+const (
+	signalBaseTypeMask = 0x1f
+	signalInboxType    = 20 // received
+	signalOutboxFirst  = 21 // outbox, sending, sent, failed and SMS fallback
+	signalOutboxLast   = 26
+)
+
+// ErrMultipleThreads is returned when a Signal export holds several
+// conversations and no thread was chosen.
+var ErrMultipleThreads = errors.New("export holds several threads; choose one")
+
+// jsonRecord is one message in FormatJSON.
+type jsonRecord struct {
+	Peer        string            `json:"peer"`
+	Direction   string            `json:"direction"`
+	Time        time.Time         `json:"time"`
+	Text        string            `json:"text"`
+	ContentType string            `json:"content_type"`
+	Meta        map[string]string `json:"meta"`
+}
+
+// parseJSON reads FormatJSON.
+func parseJSON(in domain.HistoryImport) ([]domain.HistoryEntry, error) {
+	var recs []jsonRecord
+	if err := json.Unmarshal(in.Data, &recs); err != nil {
+		return nil, fmt.Errorf("parse json import: %w", err)
+	}
+	out := make([]domain.HistoryEntry, 0, len(recs))
+	for i, r := range recs {
+		peer := r.Peer
+		if peer == "" {
+			peer = in.Peer
+		}
+		if peer == "" {
+			return nil, fmt.Errorf("record %d: %w", i, ErrNoPeer)
+		}
+		dir := domain.HistoryDirection(r.Direction)
+		if dir != domain.HistoryIn && dir != domain.HistoryOut {
+			return nil, fmt.Errorf("record %d: direction must be in or out, got %q", i, r.Direction)
+		}
+		if r.Time.IsZero() {
+			return nil, fmt.Errorf("record %d: time required", i)
+		}
+		typ := r.ContentType
+		if typ == "" {
+			typ = body.TypeText
+		}
+		b := domain.MessageBody{
+			Version:     body.Version,
+			ContentType: strings.ToLower(typ),
+			Body:        []byte(r.Text),
+			Metadata:    r.Meta,
+		}
+		if _, err := body.Encode(b); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		out = append(out, imported(in.Format, peer, r.Time.Format(time.RFC3339Nano), dir, r.Time.Unix(), b))
+	}
+	return out, nil
+}
+
+// parseSignalBackup reads FormatSignalBackup. ignored counts rows that are
+// not text messages.
+func parseSignalBackup(in domain.HistoryImport) (out []domain.HistoryEntry, ignored int, err error) {
+	if in.Peer == "" {
+		return nil, 0, ErrNoPeer
+	}
+	r := csv.NewReader(bytes.NewReader(in.Data))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, 0, fmt.Errorf("parse signal export header: %w", err)
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"date_sent", "type", "body"} {
+		if _, ok := col[name]; !ok {
+			return nil, 0, fmt.Errorf("signal export has no %q column", name)
+		}
+	}
+	field := func(rec []string, name string) string {
+		i, ok := col[name]
+		if !ok || i >= len(rec) {
+			return ""
+		}
+		return rec[i]
+	}
+
+	var threads []string
+	for line := 2; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("parse signal export: %w", err)
+		}
+		thread := field(rec, "thread_id")
+		if !slices.Contains(threads, thread) {
+			threads = append(threads, thread)
+		}
+		if in.Thread != "" && thread != in.Thread {
+			continue
+		}
+
+		typ, err := strconv.ParseInt(field(rec, "type"), 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("line %d: bad type: %w", line, err)
+		}
+		var dir domain.HistoryDirection
+		switch base := typ & signalBaseTypeMask; {
+		case base == signalInboxType:
+			dir = domain.HistoryIn
+		case base >= signalOutboxFirst && base <= signalOutboxLast:
+			dir = domain.HistoryOut
+		default:
+			ignored++ // calls, drafts and group or safety-number events
+			continue
+		}
+		text := field(rec, "body")
+		if text == "" {
+			ignored++ // attachments only; the files are not in the export
+			continue
+		}
+		ms, err := strconv.ParseInt(field(rec, "date_sent"), 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("line %d: bad date_sent: %w", line, err)
+		}
+		ref := field(rec, "_id")
+		if ref == "" {
+			ref = strconv.FormatInt(ms, 10)
+		}
+		out = append(out, imported(in.Format, in.Peer, ref, dir, ms/1000, body.Text(text)))
+	}
+	if in.Thread == "" && len(threads) > 1 {
+		return nil, 0, fmt.Errorf("%w (thread_id %s)", ErrMultipleThreads, strings.Join(threads, ", "))
+	}
+	return out, ignored, nil
+}
+
+// imported builds a history entry from an imported message. The ID hashes
+// the message and ref, a stable reference to it in the source (a row ID or
+// exact timestamp), so the same message imported again is recognised while
+// identical messages sent in the same second are not merged.
+func imported(
+	format string,
+	peer string,
+	ref string,
+	dir domain.HistoryDirection,
+	sent int64,
+	b domain.MessageBody,
+) domain.HistoryEntry {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s\x00", format, peer, ref, dir, b.ContentType)
+	h.Write(b.Body)
+	return domain.HistoryEntry{
+		ID:        "imp-" + hex.EncodeToString(h.Sum(nil)[:16]),
+		Peer:      peer,
+		Direction: dir,
+		Body:      b,
+		SentUTC:   sent,
+		Source:    format,
+	}
+}
@@ -0,0 +1,208 @@
+package history
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/poll"
+)
+
+var (
+	// ErrUnknownFormat is returned for an import format this client cannot read.
+	ErrUnknownFormat = errors.New("unknown import format; want json or signal-backup")
+	// ErrNoPeer is returned when an imported message has no conversation to
+	// be filed under.
+	ErrNoPeer = errors.New("import needs a peer; pass one for this format")
+	// ErrSamePassphrase is returned when the history would be locked with
+	// the identity passphrase, which would protect nothing.
+	ErrSamePassphrase = errors.New("the history passphrase must differ from the identity passphrase")
+	// ErrNotLocked is returned when unlocking a history that has no
+	// passphrase of its own.
+	ErrNotLocked = errors.New("history is not locked")
+)
+
+// Service reads, imports and prunes local message history.
+type Service struct {
+	store         domain.HistoryStore
+	idStore       domain.IdentityStore
+	conversations domain.ConversationService
+	now           func() time.Time
+	logger        *slog.Logger
+}
+
+// New returns a history service backed by store, keeping what the retention
+// policies of conversations allow. idStore checks identity passphrases when
+// the history is locked or unlocked.
+//
+// If logger is nil, log output is discarded.
+func New(
+	store domain.HistoryStore,
+	idStore domain.IdentityStore,
+	conversations domain.ConversationService,
+	logger *slog.Logger,
+) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	return &Service{store: store, idStore: idStore, conversations: conversations, now: time.Now, logger: logger}
+}
+
+// History returns the last limit entries with peer, oldest first. An empty
+// peer selects every conversation; limit <= 0 returns everything. Entries
+// past their retention are left out even before a prune removes them.
+func (s *Service) History(passphrase, peer string, limit int) ([]domain.HistoryEntry, error) {
+	all, err := s.store.LoadHistory(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := s.conversations.Retention()
+	if err != nil {
+		return nil, err
+	}
+	expired := expiredIDs(all, ret, s.now().Unix())
+	out := all[:0]
+	for _, e := range all {
+		if (peer == "" || e.Peer == peer) && !expired[e.ID] {
+			out = append(out, e)
+		}
+	}
+	if limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out, nil
+}
+
+// Polls returns the polls in the history, oldest first, each with the votes
+// seen for it. Entries past their retention are left out, as in History.
+func (s *Service) Polls(passphrase string) ([]domain.PollTally, error) {
+	entries, err := s.History(passphrase, "", 0)
+	if err != nil {
+		return nil, err
+	}
+	return poll.Tally(entries), nil
+}
+
+// Import parses in and appends its messages to the history. skipped counts
+// rows that were not messages (calls, system events, empty bodies) and
+// messages already present from an earlier import.
+func (s *Service) Import(passphrase string, in domain.HistoryImport) (added, skipped int, err error) {
+	var (
+		entries []domain.HistoryEntry
+		ignored int
+	)
+	switch in.Format {
+	case FormatJSON:
+		entries, err = parseJSON(in)
+	case FormatSignalBackup:
+		entries, ignored, err = parseSignalBackup(in)
+	default:
+		return 0, 0, fmt.Errorf("%w: %q", ErrUnknownFormat, in.Format)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	added, err = s.store.AppendHistory(passphrase, entries)
+	if err != nil {
+		return 0, 0, err
+	}
+	skipped = ignored + len(entries) - added
+	s.logger.Debug("history imported",
+		"format", in.Format,
+		"added", added,
+		"skipped", skipped,
+	)
+	if added > 0 {
+		if _, err := s.Prune(passphrase); err != nil {
+			return added, skipped, err
+		}
+	}
+	return added, skipped, nil
+}
+
+// Prune removes the entries the retention policies no longer keep. It is run
+// after every write and can be scheduled to expire old messages in between.
+func (s *Service) Prune(passphrase string) (int, error) {
+	ret, err := s.conversations.Retention()
+	if err != nil {
+		return 0, err
+	}
+	removed, err := s.store.PruneHistory(passphrase, ret, s.now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	s.logger.Debug("history pruned", "removed", removed)
+	return removed, nil
+}
+
+// Lock gives the history its own passphrase, next. current opens the
+// history now: the identity passphrase, or the history's own passphrase to
+// change it. Once locked, the identity passphrase no longer reads the
+// history, though messages sent and received are still added to it.
+func (s *Service) Lock(current, next string) error {
+	locked, err := s.store.HistoryLocked()
+	if err != nil {
+		return err
+	}
+	if !locked {
+		if _, err := s.idStore.LoadIdentity(current); err != nil {
+			return err
+		}
+	}
+	if _, err := s.idStore.LoadIdentity(next); err == nil {
+		return ErrSamePassphrase
+	}
+	if err := s.store.RewrapHistoryKey(current, next, true); err != nil {
+		return err
+	}
+	s.logger.Debug("history locked", "was_locked", locked)
+	return nil
+}
+
+// Unlock has passphrase, the identity's, open the history again instead of
+// current, the history's own. passphrase must open the identity, so a typo
+// cannot lock the history away.
+func (s *Service) Unlock(current, passphrase string) error {
+	locked, err := s.store.HistoryLocked()
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return ErrNotLocked
+	}
+	if _, err := s.idStore.LoadIdentity(passphrase); err != nil {
+		return err
+	}
+	if err := s.store.RewrapHistoryKey(current, passphrase, false); err != nil {
+		return err
+	}
+	s.logger.Debug("history unlocked")
+	return nil
+}
+
+// Locked reports whether the history has its own passphrase.
+func (s *Service) Locked() (bool, error) {
+	return s.store.HistoryLocked()
+}
+
+// expiredIDs returns the IDs of the entries in all, oldest first, that ret no
+// longer keeps at now.
+func expiredIDs(all []domain.HistoryEntry, ret domain.Retention, now int64) map[string]bool {
+	byPeer := make(map[string][]domain.HistoryEntry)
+	for _, e := range all {
+		byPeer[e.Peer] = append(byPeer[e.Peer], e)
+	}
+	out := make(map[string]bool)
+	for peer, entries := range byPeer {
+		for _, id := range ret.For(peer).Expired(entries, now) {
+			out[id] = true
+		}
+	}
+	return out
+}
+
+// Compile-time assertion that Service implements domain.HistoryService.
+var _ domain.HistoryService = (*Service)(nil)
@@ -0,0 +1,119 @@
+package history
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// sizeBuckets are the upper bounds, in bytes, of the body size buckets in
+// HistoryStats; bodies larger than the last fall in an unbounded bucket.
+var sizeBuckets = []int{64, 256, 1024, 4096, 16384}
+
+// Stats summarises the history with peer: messages each way, per day and by
+// hour of the day in loc, how quickly each side replied, and how large the
+// bodies were. It reads only the local history, as History does, so entries
+// past their retention are left out. A peer with no history yields stats
+// with every count zero.
+func (s *Service) Stats(passphrase, peer string, loc *time.Location) (domain.HistoryStats, error) {
+	entries, err := s.History(passphrase, peer, 0)
+	if err != nil {
+		return domain.HistoryStats{}, err
+	}
+	// Imported messages may have been appended after newer ones.
+	slices.SortStableFunc(entries, func(a, b domain.HistoryEntry) int {
+		return cmp.Compare(a.SentUTC, b.SentUTC)
+	})
+
+	st := domain.HistoryStats{Peer: peer}
+	for _, limit := range sizeBuckets {
+		st.Sizes = append(st.Sizes, domain.SizeBucket{MaxBytes: limit})
+	}
+	st.Sizes = append(st.Sizes, domain.SizeBucket{})
+
+	var (
+		ours, theirs []time.Duration
+		// Since when each side has been waiting for a reply; zero if not.
+		waitingOnUs, waitingOnPeer int64
+	)
+	for _, e := range entries {
+		out := e.Direction == domain.HistoryOut
+		if out {
+			st.Sent++
+		} else {
+			st.Received++
+		}
+		if e.Source != "" {
+			st.Imported++
+		}
+		if st.FirstUTC == 0 {
+			st.FirstUTC = e.SentUTC
+		}
+		st.LastUTC = e.SentUTC
+
+		t := time.Unix(e.SentUTC, 0).In(loc)
+		day := t.Format(time.DateOnly)
+		if n := len(st.Days); n == 0 || st.Days[n-1].Day != day {
+			st.Days = append(st.Days, domain.HistoryDay{Day: day})
+		}
+		d := &st.Days[len(st.Days)-1]
+		st.Hours[t.Hour()]++
+
+		b := &st.Sizes[sizeBucket(len(e.Body.Body))]
+		if out {
+			d.Sent++
+			b.Sent++
+			if waitingOnUs != 0 {
+				ours = append(ours, time.Duration(e.SentUTC-waitingOnUs)*time.Second)
+				waitingOnUs = 0
+			}
+			if waitingOnPeer == 0 {
+				waitingOnPeer = e.SentUTC
+			}
+		} else {
+			d.Received++
+			b.Received++
+			if waitingOnPeer != 0 {
+				theirs = append(theirs, time.Duration(e.SentUTC-waitingOnPeer)*time.Second)
+				waitingOnPeer = 0
+			}
+			if waitingOnUs == 0 {
+				waitingOnUs = e.SentUTC
+			}
+		}
+	}
+	st.OurReplies, st.PeerReplies = latency(ours), latency(theirs)
+
+	s.logger.Debug("history stats", "peer", peer, "messages", len(entries), "days", len(st.Days))
+	return st, nil
+}
+
+// sizeBucket returns the index of the bucket in HistoryStats.Sizes for a
+// body of n bytes.
+func sizeBucket(n int) int {
+	for i, limit := range sizeBuckets {
+		if n <= limit {
+			return i
+		}
+	}
+	return len(sizeBuckets)
+}
+
+// latency returns the mean and median of ds.
+func latency(ds []time.Duration) domain.ReplyLatency {
+	if len(ds) == 0 {
+		return domain.ReplyLatency{}
+	}
+	var sum time.Duration
+	for _, d := range ds {
+		sum += d
+	}
+	sorted := slices.Sorted(slices.Values(ds))
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + median) / 2
+	}
+	return domain.ReplyLatency{Count: len(ds), Mean: sum / time.Duration(len(ds)), Median: median}
+}
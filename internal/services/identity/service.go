@@ -2,10 +2,13 @@ package identity
 
 import (
 	"fmt"
+	"log/slog"
+	"time"
 	"unicode"
 
 	"ciphera/internal/crypto"
 	"ciphera/internal/domain"
+	"ciphera/internal/protocol/signchain"
 )
 
 const (
@@ -26,13 +29,22 @@ var (
 //
 // The identity contains:
 //   - X25519 key pair for Diffie-Hellman (X3DH and Double Ratchet).
-//   - Ed25519 key pair for signing (e.g., signing the SPK).
+//   - Ed25519 key pair for signing (e.g., signing the SPK), which can be
+//     rotated independently; see RotateSigningKey.
 type Service struct {
-	store domain.IdentityStore
+	store  domain.IdentityStore
+	logger *slog.Logger
 }
 
 // New returns an identity service backed by the given store.
-func New(s domain.IdentityStore) *Service { return &Service{store: s} }
+//
+// If logger is nil, log output is discarded.
+func New(s domain.IdentityStore, logger *slog.Logger) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	return &Service{store: s, logger: logger}
+}
 
 // GenerateIdentity creates a new identity, saves it encrypted with the passphrase,
 // and returns the identity plus a short fingerprint of the X25519 public key.
@@ -63,7 +75,9 @@ func (s *Service) GenerateIdentity(
 	if err := s.store.SaveIdentity(passphrase, id); err != nil {
 		return domain.Identity{}, "", err
 	}
-	return id, crypto.Fingerprint(id.XPub.Slice()), nil
+	fp := crypto.Fingerprint(id.XPub.Slice())
+	s.logger.Debug("identity generated", "fingerprint", fp)
+	return id, fp, nil
 }
 
 // LoadIdentity decrypts and returns the local identity.
@@ -80,6 +94,33 @@ func (s *Service) FingerprintIdentity(passphrase string) (string, error) {
 	return crypto.Fingerprint(id.XPub.Slice()), nil
 }
 
+// RotateSigningKey replaces the Ed25519 signing key with a fresh one, signed
+// over by the old key, and returns the new public key. The X25519 identity key
+// and fingerprint are unchanged.
+//
+// Existing signed prekeys still carry the old key's signature, so callers must
+// generate and publish new prekeys afterwards.
+func (s *Service) RotateSigningKey(passphrase string) (domain.Ed25519Public, error) {
+	id, err := s.store.LoadIdentity(passphrase)
+	if err != nil {
+		return domain.Ed25519Public{}, err
+	}
+	if err := signchain.Check(id.XPub, id.SignChain, id.EdPub); err != nil {
+		return domain.Ed25519Public{}, fmt.Errorf("existing signing key chain: %w", err)
+	}
+	if _, err := signchain.Rotate(&id, time.Now()); err != nil {
+		return domain.Ed25519Public{}, err
+	}
+	if err := s.store.SaveIdentity(passphrase, id); err != nil {
+		return domain.Ed25519Public{}, err
+	}
+	s.logger.Debug("signing key rotated",
+		"fingerprint", crypto.Fingerprint(id.XPub.Slice()),
+		"rotations", len(id.SignChain),
+	)
+	return id.EdPub, nil
+}
+
 // isSecurePassphrase enforces a basic strength policy.
 func isSecurePassphrase(passphrase string) bool {
 	var hasUpper, hasLower, hasDigit, hasSymbol bool
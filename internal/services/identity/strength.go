@@ -0,0 +1,94 @@
+package identity
+
+import (
+	"math"
+	"unicode"
+)
+
+// Strength is a coarse rating of a passphrase, used for interactive feedback.
+type Strength int
+
+// Strength ratings, weakest first.
+const (
+	StrengthVeryWeak Strength = iota
+	StrengthWeak
+	StrengthFair
+	StrengthGood
+	StrengthStrong
+)
+
+// String returns a human-readable label for s.
+func (s Strength) String() string {
+	switch s {
+	case StrengthVeryWeak:
+		return "very weak"
+	case StrengthWeak:
+		return "weak"
+	case StrengthFair:
+		return "fair"
+	case StrengthGood:
+		return "good"
+	default:
+		return "strong"
+	}
+}
+
+// PassphraseStrength estimates the strength of passphrase from its length and
+// the character classes it uses.
+//
+// This is a brute-force entropy estimate, not a dictionary check; it exists to
+// give feedback while typing and does not replace the policy enforced by
+// ValidatePassphrase.
+func PassphraseStrength(passphrase string) Strength {
+	var lower, upper, digit, symbol, other bool
+	n := 0
+	for _, r := range passphrase {
+		n++
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r), unicode.IsSymbol(r), r == ' ':
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, c := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if c.used {
+			pool += c.size
+		}
+	}
+	if pool == 0 {
+		return StrengthVeryWeak
+	}
+	bits := float64(n) * math.Log2(float64(pool))
+	switch {
+	case bits < 28:
+		return StrengthVeryWeak
+	case bits < 40:
+		return StrengthWeak
+	case bits < 60:
+		return StrengthFair
+	case bits < 80:
+		return StrengthGood
+	default:
+		return StrengthStrong
+	}
+}
+
+// ValidatePassphrase returns ErrWeakPassphrase if passphrase fails the policy
+// required by GenerateIdentity.
+func ValidatePassphrase(passphrase string) error {
+	if !isSecurePassphrase(passphrase) {
+		return ErrWeakPassphrase
+	}
+	return nil
+}
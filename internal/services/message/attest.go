@@ -0,0 +1,102 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/attest"
+)
+
+// controlAttestation carries an attestation about the recipient, signed by
+// the sender.
+const controlAttestation = "attestation"
+
+// ErrNotContact indicates the peer has not been paired, so there is no
+// verified identity key to attest.
+var ErrNotContact = errors.New("peer is not a paired contact; pair with them before attesting")
+
+// Attest signs an attestation that peer holds the identity key received when
+// pairing with them and sends it to peer as a control message, so they can
+// publish it in their bundle. The conversation with peer must use that key.
+func (s *Service) Attest(ctx context.Context, passphrase, me, peer string) (domain.Attestation, error) {
+	c, paired, err := s.contactStore.LoadContact(peer)
+	if err != nil {
+		return domain.Attestation{}, err
+	}
+	if !paired {
+		return domain.Attestation{}, ErrNotContact
+	}
+	unlock, err := s.ratchetStore.LockConversation(peer)
+	if err != nil {
+		return domain.Attestation{}, err
+	}
+	defer unlock()
+
+	conv, found, err := s.ratchetStore.LoadConversation(peer)
+	if err != nil {
+		return domain.Attestation{}, err
+	}
+	if !found {
+		return domain.Attestation{}, ErrNoConversation
+	}
+	peerIK, _, _, err := s.peerKeys(conv)
+	if err != nil {
+		return domain.Attestation{}, err
+	}
+	if peerIK != c.IdentityKey {
+		return domain.Attestation{}, ErrVerificationChanged
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return domain.Attestation{}, err
+	}
+
+	a := attest.Sign(id, me, peer, c.IdentityKey, time.Now())
+	if err := s.sendControl(ctx, passphrase, me, &conv, domain.ControlMessage{Type: controlAttestation, Attestation: &a}); err != nil {
+		return domain.Attestation{}, err
+	}
+	s.logger.Debug("attestation sent", "peer", peer)
+	return a, nil
+}
+
+// Attestations returns the attestations contacts have sent about us.
+func (s *Service) Attestations() ([]domain.Attestation, error) {
+	return s.attestStore.ListAttestations()
+}
+
+// handleAttestation stores an attestation from conv.Peer about us. It must be
+// signed by the peer under their own username and the identity key the
+// conversation is with, and name our username and identity key; anything
+// else is logged and ignored.
+func (s *Service) handleAttestation(passphrase, me string, conv *domain.Conversation, msg domain.ControlMessage) error {
+	a := msg.Attestation
+	if a == nil {
+		s.logger.Warn("ignoring empty attestation", "peer", conv.Peer)
+		return nil
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return err
+	}
+	peerIK, _, _, err := s.peerKeys(*conv)
+	if err != nil {
+		return err
+	}
+	var zero domain.X25519Public
+	if peerIK == zero || a.Attester != conv.Peer || a.AttesterKey != peerIK ||
+		a.Subject != me || a.SubjectKey != id.XPub {
+		s.logger.Warn("ignoring attestation for another identity", "peer", conv.Peer)
+		return nil
+	}
+	if err := attest.Check(*a); err != nil {
+		s.logger.Warn("ignoring attestation that does not verify", "peer", conv.Peer, "err", err)
+		return nil
+	}
+	if err := s.attestStore.SaveAttestation(*a); err != nil {
+		return err
+	}
+	s.logger.Debug("attestation received", "peer", conv.Peer)
+	return nil
+}
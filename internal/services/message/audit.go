@@ -0,0 +1,158 @@
+package message
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+)
+
+const (
+	// controlAuditRequest carries the sender's state summary and asks for
+	// the recipient's.
+	controlAuditRequest = "audit_request"
+	// controlAuditReply answers an audit request with the sender's summary.
+	controlAuditReply = "audit_reply"
+
+	// epochContext separates epoch fingerprints from other header key digests.
+	epochContext = "ciphera/audit-epoch-v1"
+	// epochSize is the number of digest bytes in an epoch fingerprint.
+	epochSize = 8
+)
+
+// RequestAudit sends peer our summary of the conversation and asks for
+// theirs. Both sides compare when the other's summary arrives and report the
+// outcome as a local notice (see auditNotice).
+func (s *Service) RequestAudit(ctx context.Context, passphrase, me, peer string) error {
+	unlock, err := s.ratchetStore.LockConversation(peer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	conv, found, err := s.ratchetStore.LoadConversation(peer)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNoConversation
+	}
+	if err := s.sendSummary(ctx, passphrase, me, &conv, controlAuditRequest); err != nil {
+		return err
+	}
+	s.logger.Debug("state audit requested", "peer", peer)
+	return nil
+}
+
+// sendSummary sends conv's summary in a control message of type typ. The
+// summary counts the message carrying it.
+func (s *Service) sendSummary(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	conv *domain.Conversation,
+	typ string,
+) error {
+	sum := summarise(*conv)
+	sum.Sent++
+	return s.sendControl(ctx, passphrase, me, conv, domain.ControlMessage{Type: typ, Summary: &sum})
+}
+
+// handleAudit compares the peer's summary with conv and returns the notice
+// reporting it. A request is answered with our own summary first.
+func (s *Service) handleAudit(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	conv *domain.Conversation,
+	msg domain.ControlMessage,
+) (*domain.MessageBody, error) {
+	if msg.Summary == nil || len(msg.Summary.Epoch) > 2*epochSize {
+		s.logger.Debug("ignoring malformed state summary", "peer", conv.Peer)
+		return nil, nil
+	}
+	result, findings := compareSummaries(summarise(*conv), *msg.Summary)
+	s.logger.Debug("state audit", "peer", conv.Peer, "type", msg.Type, "result", result)
+
+	asker := body.AuditAskerUs
+	if msg.Type == controlAuditRequest {
+		asker = body.AuditAskerPeer
+		if err := s.sendSummary(ctx, passphrase, me, conv, controlAuditReply); err != nil {
+			return nil, fmt.Errorf("answer state audit: %w", err)
+		}
+	}
+	notice := auditNotice(result, asker, findings)
+	return &notice, nil
+}
+
+// summarise returns our summary of conv.
+func summarise(conv domain.Conversation) domain.StateSummary {
+	return domain.StateSummary{
+		Sent:     conv.MessagesSent,
+		Received: conv.MessagesReceived,
+		Rekeys:   conv.Rekeys,
+		Epoch:    epochFingerprint(conv.State.HeaderKey),
+	}
+}
+
+// epochFingerprint identifies a root key epoch by its header key, which both
+// sides derive from the same root and keep until the next rekey. It is "" for
+// states without a header key.
+func epochFingerprint(headerKey []byte) string {
+	if len(headerKey) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	h.Write([]byte(epochContext))
+	h.Write(headerKey)
+	return hex.EncodeToString(h.Sum(nil)[:epochSize])
+}
+
+// compareSummaries compares our summary with the peer's, taken when they sent
+// it. Messages still in flight make the counters lag without anything being
+// wrong, so a shortfall only makes the result pending; seeing more than the
+// other side sent, or different root keys in the same epoch, means the state
+// has forked or been replayed.
+func compareSummaries(ours, theirs domain.StateSummary) (string, []string) {
+	var diverged, pending []string
+	switch {
+	case ours.Rekeys != theirs.Rekeys:
+		pending = append(pending, fmt.Sprintf("rekey in progress (we have rekeyed %d times, they %d)", ours.Rekeys, theirs.Rekeys))
+	case ours.Epoch != "" && theirs.Epoch != "" && ours.Epoch != theirs.Epoch:
+		diverged = append(diverged, fmt.Sprintf("root keys differ (ours %s, theirs %s)", ours.Epoch, theirs.Epoch))
+	}
+	switch d := ours.Received - theirs.Sent; {
+	case d > 0:
+		diverged = append(diverged, fmt.Sprintf("we received %d more message(s) than they sent", d))
+	case d < 0:
+		pending = append(pending, fmt.Sprintf("%d of their message(s) not received yet", -d))
+	}
+	switch d := theirs.Received - ours.Sent; {
+	case d > 0:
+		diverged = append(diverged, fmt.Sprintf("they received %d more message(s) than we sent", d))
+	case d < 0:
+		pending = append(pending, fmt.Sprintf("%d of our message(s) not received by them yet", -d))
+	}
+	switch {
+	case len(diverged) > 0:
+		return body.AuditResultDiverged, append(diverged, pending...)
+	case len(pending) > 0:
+		return body.AuditResultPending, pending
+	}
+	return body.AuditResultMatch, nil
+}
+
+// auditNotice is the local message body reporting a state audit to the user.
+// It is never sent or stored in the history.
+func auditNotice(result, asker string, findings []string) domain.MessageBody {
+	return domain.MessageBody{
+		Version:     body.Version,
+		ContentType: body.TypeAudit,
+		Metadata:    map[string]string{body.MetaAuditResult: result, body.MetaAuditAsker: asker},
+		Body:        []byte(strings.Join(findings, "; ")),
+	}
+}
@@ -0,0 +1,44 @@
+package message
+
+import (
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+	"ciphera/internal/protocol/chunk"
+)
+
+// collectChunk stores msg if it is a part of a chunked message from peer and
+// returns the joined message once every part is held. Any other body is
+// returned as it is, complete.
+//
+// A malformed part, or parts that do not join into a body, are quarantined
+// like a decrypt failure; the parts held for that message are dropped.
+func (s *Service) collectChunk(passphrase, peer string, msg domain.MessageBody) (domain.MessageBody, bool, error) {
+	if msg.ContentType != body.TypeChunk {
+		return msg, true, nil
+	}
+	p, err := chunk.Parse(msg)
+	if err != nil {
+		return domain.MessageBody{}, false, &decryptError{peer: peer, err: err}
+	}
+	p.ReceivedUTC = time.Now().Unix()
+	parts, err := s.chunkStore.SaveChunk(passphrase, peer, p)
+	if err != nil {
+		return domain.MessageBody{}, false, err
+	}
+	if len(parts) < p.Total {
+		s.logger.Debug("chunk held", "peer", peer, "chunk", p.ID, "held", len(parts), "parts", p.Total)
+		return domain.MessageBody{}, false, nil
+	}
+
+	joined, joinErr := chunk.Join(parts)
+	if err := s.chunkStore.DeleteChunks(passphrase, peer, p.ID); err != nil {
+		return domain.MessageBody{}, false, err
+	}
+	if joinErr != nil {
+		return domain.MessageBody{}, false, &decryptError{peer: peer, err: joinErr}
+	}
+	s.logger.Debug("chunked message joined", "peer", peer, "chunk", p.ID, "parts", p.Total)
+	return joined, true, nil
+}
@@ -0,0 +1,232 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+	"ciphera/internal/protocol/ratchet"
+)
+
+const (
+	// controlSessionConfirm is sent by the responder after bootstrapping from a
+	// PrekeyMessage. It carries both identity fingerprints as the responder sees them.
+	controlSessionConfirm = "session_confirm"
+)
+
+var (
+	// controlAD marks a ciphertext as a control message. It is bound as associated
+	// data, so a relay cannot turn user content into a control message or vice versa.
+	controlAD = []byte("ciphera/control-v1")
+
+	// ErrConfirmMismatch indicates the peer reported identity fingerprints that differ from ours.
+	ErrConfirmMismatch = errors.New("session confirmation fingerprint mismatch")
+)
+
+// isControl reports whether an envelope carries a control message.
+func isControl(env domain.Envelope) bool {
+	return bytes.Equal(env.AD, controlAD)
+}
+
+// sendControl encrypts msg on conv, persists the advanced state, and posts it
+// to the peer. The caller holds the peer's conversation lock and loaded conv
+// under it.
+func (s *Service) sendControl(
+	ctx context.Context,
+	passphrase string,
+	from string,
+	conv *domain.Conversation,
+	msg domain.ControlMessage,
+) error {
+	from, err := s.sender(passphrase, from, conv.Peer)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	raw, err = body.Encode(domain.MessageBody{ContentType: body.TypeControl, Body: raw})
+	if err != nil {
+		return err
+	}
+	before := stepState(conv.State)
+	header, ct, err := ratchet.Encrypt(&conv.State, controlAD, raw)
+	if err != nil {
+		return err
+	}
+	s.observeSent(conv, len(ct))
+	if err := s.ratchetStore.SaveConversation(conv.Peer, *conv); err != nil {
+		return err
+	}
+	after := stepState(conv.State)
+	s.recordStep(passphrase, conv.Peer, domain.RatchetStep{
+		Op:      domain.RatchetEncrypt,
+		Control: true,
+		Header:  header,
+		Before:  before,
+		After:   &after,
+	})
+	env := domain.Envelope{
+		From:      from,
+		To:        conv.Peer,
+		Header:    header,
+		Cipher:    ct,
+		AD:        controlAD,
+		Timestamp: time.Now().Unix(),
+		HeaderMAC: ratchet.HeaderMAC(conv.State.Suite, conv.State.HeaderKey, from, conv.Peer, controlAD, header),
+	}
+	relay, err := s.relayFor(conv.Peer)
+	if err != nil {
+		return err
+	}
+	if _, err := relay.SendMessage(ctx, env); err != nil {
+		return err
+	}
+	s.saveUnanswered(conv)
+	return nil
+}
+
+// handleControl applies a decrypted control message to conv. It returns the
+// local notice to show the user, such as the outcome of a remote wipe (see
+// handleWipe) or a state audit, or nil for other messages.
+//
+// The payload is a body of type body.TypeControl, or the bare JSON sent by
+// clients that predate the body schema.
+//
+// For a session confirmation the initiator checks that the responder saw our
+// identity key and that we saw theirs; the outcome is recorded on conv, and a
+// new session that checks out is sent our profile.
+func (s *Service) handleControl(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	conv *domain.Conversation,
+	plain []byte,
+) (*domain.MessageBody, error) {
+	b, err := body.Decode(plain)
+	if err != nil {
+		return nil, err
+	}
+	if b.Version != 0 && b.ContentType != body.TypeControl {
+		return nil, fmt.Errorf("control message has content type %q", b.ContentType)
+	}
+	var msg domain.ControlMessage
+	if err := json.Unmarshal(b.Body, &msg); err != nil {
+		return nil, fmt.Errorf("decode control message: %w", err)
+	}
+
+	switch msg.Type {
+	case controlSessionConfirm:
+		sess, ok, err := s.sessionService.GetSession(conv.Peer)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrNoSession
+		}
+		id, err := s.idStore.LoadIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		first := conv.Confirm != domain.ConfirmOK && conv.Rekeys == 0
+		if msg.InitiatorFP == crypto.Fingerprint(id.XPub.Slice()) &&
+			msg.ResponderFP == crypto.Fingerprint(sess.PeerIK.Slice()) {
+			conv.Confirm = domain.ConfirmOK
+			conv.Stale = nil // the peer has switched to our handshake
+		} else {
+			conv.Confirm = domain.ConfirmMismatch
+		}
+		s.logger.Debug("session confirmation received", "peer", conv.Peer, "confirm", conv.Confirm)
+		if first && conv.Confirm == domain.ConfirmOK {
+			s.shareProfile(ctx, passphrase, me, conv)
+		}
+		return nil, nil
+	case controlWipeRequest, controlWipeReceipt:
+		result, err := s.handleWipe(ctx, passphrase, me, conv, msg)
+		if err != nil || result == "" {
+			return nil, err
+		}
+		notice := wipeNotice(result)
+		return &notice, nil
+	case controlAuditRequest, controlAuditReply:
+		return s.handleAudit(ctx, passphrase, me, conv, msg)
+	case controlRekey:
+		return nil, s.handleRekey(ctx, passphrase, me, conv, msg)
+	case controlAttestation:
+		return nil, s.handleAttestation(passphrase, me, conv, msg)
+	case controlResendRequest:
+		return nil, s.handleResend(ctx, conv, msg)
+	case controlProfile:
+		return nil, s.handleProfile(conv, msg)
+	case controlPing, controlPong:
+		return s.handlePing(ctx, passphrase, me, conv, msg)
+	case controlHandshakeRetry:
+		return s.handleRekeyRetry(ctx, passphrase, me, conv, msg)
+	default:
+		// Unknown control types are ignored so newer peers can extend the set.
+		s.logger.Debug("ignoring unknown control message", "peer", conv.Peer, "type", msg.Type)
+		return nil, nil
+	}
+}
+
+// SessionStatuses reports the handshake confirmation state, number of stored
+// skipped message keys and attesting contacts for every conversation.
+func (s *Service) SessionStatuses() ([]domain.SessionStatus, error) {
+	convs, err := s.ratchetStore.ListConversations()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]domain.SessionStatus, 0, len(convs))
+	for _, c := range convs {
+		confirm := c.Confirm
+		if confirm == "" {
+			confirm = domain.ConfirmPending
+		}
+		sess, _, err := s.sessionService.GetSession(c.Peer)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, domain.SessionStatus{
+			Peer:        c.Peer,
+			Confirm:     confirm,
+			SkippedKeys: len(c.State.Skipped),
+			Rekeys:      c.Rekeys,
+			VouchedBy:   sess.VouchedBy,
+			Suite:       c.State.Suite,
+		})
+	}
+	return out, nil
+}
+
+// confirmSession marks conv as confirmed on the responder side and sends a
+// session confirmation to the initiator.
+func (s *Service) confirmSession(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	conv *domain.Conversation,
+	pm domain.PrekeyMessage,
+) error {
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return err
+	}
+	conv.Confirm = domain.ConfirmOK
+	err = s.sendControl(ctx, passphrase, me, conv, domain.ControlMessage{
+		Type:        controlSessionConfirm,
+		InitiatorFP: crypto.Fingerprint(pm.InitiatorIK.Slice()),
+		ResponderFP: crypto.Fingerprint(id.XPub.Slice()),
+	})
+	if err != nil {
+		return err
+	}
+	s.logger.Debug("session confirmation sent", "peer", conv.Peer)
+	return nil
+}
@@ -1,5 +1,50 @@
 // Package message sends and receives encrypted messages.
 //
 // It derives message keys from Double Ratchet state, updates per-message
-// state, and exchanges ciphertexts via the RelayClient.
+// state, and exchanges ciphertexts via the relay directory: envelopes go to
+// the relay recorded on the peer's session and are fetched from the default
+// relay. Messages sent and received are appended to the local history store.
+//
+// When both peers initiate at once, a deterministic tie-break on identity keys
+// picks one handshake for both sides (see resolveCrossInitiation).
+//
+// A handshake naming a one-time prekey the responder no longer holds is not
+// completed with a root the initiator does not share: the responder
+// quarantines it and asks the initiator, in a request sealed between their
+// identity keys, to run X3DH again against a fresh bundle (see
+// requestHandshakeRetry and handleHandshakeRetry).
+//
+// A peer may ask for the conversation to be wiped on both sides with a signed
+// control message. It is honoured only if the local user opted in for that
+// peer, and answered with a signed receipt (see RequestWipe and handleWipe).
+//
+// Every envelope carries a MAC over its sender, recipient, associated data
+// and ratchet header under the conversation's header key. ReceiveMessage
+// checks it before any ratchet step and drops envelopes that fail (see
+// authenticHeader).
+//
+// A paired contact can be sent a signed attestation of their identity key,
+// which they store and publish in their bundle (see Attest and package
+// attest).
+//
+// Under a rekey policy the initiator re-runs X3DH on send and moves the
+// conversation to the new root with a control message (see maybeRekey and
+// handleRekey).
+//
+// A body too large for one relay envelope is sent as chunk messages, which
+// the receiver holds until every part has arrived and then delivers as the
+// original message (see package chunk and collectChunk).
+//
+// Decrypted messages pass the user's receive filters (size, content type and
+// sender allowlists) before they are recorded or returned; refused ones are
+// held, encrypted, until the user accepts or drops them (see screen).
+//
+// The session policy may quarantine or drop the messages of a new session
+// from a peer the user has not paired with or accepted (see acceptMode), and
+// refuse replies to such a peer (see replyNeedsVerification).
+//
+// FollowMessages receives in a loop, sizing each fetch and the wait before the
+// next from how full the previous batch was and how long it took (see pacer).
+// Where the relay pushes envelopes over a WebSocket, it processes them as
+// they arrive and fetches only to catch up.
 package message
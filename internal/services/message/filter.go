@@ -0,0 +1,121 @@
+package message
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+)
+
+var (
+	// ErrHeld indicates one or more messages were held back by the receive
+	// filters.
+	ErrHeld = errors.New("messages held by receive filters")
+	// ErrNotHeld indicates no held message has the requested ID.
+	ErrNotHeld = errors.New("no held message with that id")
+)
+
+// screen returns the messages in msgs the receive filters let through and
+// holds the rest for review, before any of them is recorded or shown. It
+// also returns how many were held.
+//
+// A message that cannot be saved for review is let through rather than
+// lost, since its envelope has already been consumed.
+func (s *Service) screen(
+	passphrase string,
+	f domain.ReceiveFilters,
+	msgs []domain.DecryptedMessage,
+) ([]domain.DecryptedMessage, int) {
+	if !f.Enabled() {
+		return msgs, 0
+	}
+	out := msgs[:0:0]
+	held := 0
+	for _, m := range msgs {
+		reason := refused(f, m)
+		if reason == "" {
+			out = append(out, m)
+			continue
+		}
+		h := domain.HeldMessage{
+			ID:      rand.Text(),
+			Message: m,
+			Reason:  reason,
+			HeldUTC: time.Now().Unix(),
+		}
+		if err := s.heldStore.SaveHeld(passphrase, h); err != nil {
+			s.logger.Warn("message not held", "peer", m.From, "reason", reason, "error", err)
+			out = append(out, m)
+			continue
+		}
+		s.logger.Debug("message held", "id", h.ID, "peer", m.From, "reason", reason)
+		held++
+	}
+	return out, held
+}
+
+// refused returns why f holds back m, or "" if it lets m through. Local
+// notices always pass.
+func refused(f domain.ReceiveFilters, m domain.DecryptedMessage) string {
+	switch {
+	case body.Local(m.Body.ContentType):
+		return ""
+	case len(f.Senders) > 0 && !slices.Contains(f.Senders, m.From):
+		return "sender not allowed"
+	case len(f.ContentTypes) > 0 && !slices.Contains(f.ContentTypes, m.Body.ContentType):
+		return fmt.Sprintf("content type %s not allowed", m.Body.ContentType)
+	case f.MaxSize > 0 && len(m.Body.Body) > f.MaxSize:
+		return fmt.Sprintf("%d bytes is over the %d byte limit", len(m.Body.Body), f.MaxSize)
+	}
+	return ""
+}
+
+// ListHeld returns the messages the receive filters held back, oldest first.
+func (s *Service) ListHeld(passphrase string) ([]domain.HeldMessage, error) {
+	return s.heldStore.ListHeld(passphrase)
+}
+
+// AcceptHeld releases the held message with id: it is recorded in the
+// history like any received message and returned.
+func (s *Service) AcceptHeld(passphrase, id string) (domain.DecryptedMessage, error) {
+	h, err := s.findHeld(passphrase, id)
+	if err != nil {
+		return domain.DecryptedMessage{}, err
+	}
+	if _, err := s.heldStore.DeleteHeld(passphrase, id); err != nil {
+		return domain.DecryptedMessage{}, err
+	}
+	s.recordReceived(passphrase, []domain.DecryptedMessage{h.Message})
+	s.logger.Debug("held message accepted", "id", id, "peer", h.Message.From)
+	return h.Message, nil
+}
+
+// DropHeld permanently discards the held message with id.
+func (s *Service) DropHeld(passphrase, id string) error {
+	ok, err := s.heldStore.DeleteHeld(passphrase, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+// findHeld returns the held message with id.
+func (s *Service) findHeld(passphrase, id string) (domain.HeldMessage, error) {
+	hs, err := s.heldStore.ListHeld(passphrase)
+	if err != nil {
+		return domain.HeldMessage{}, err
+	}
+	for _, h := range hs {
+		if h.ID == id {
+			return h, nil
+		}
+	}
+	return domain.HeldMessage{}, ErrNotHeld
+}
@@ -0,0 +1,323 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/relayauth"
+)
+
+// DefaultPacing is the FetchPacing FollowMessages starts from when a field is
+// zero: batches of 10 to 500 envelopes, polled every 1 to 30 seconds.
+var DefaultPacing = domain.FetchPacing{
+	MinLimit:    10,
+	MaxLimit:    500,
+	MinInterval: time.Second,
+	MaxInterval: 30 * time.Second,
+}
+
+// batchBudget is how long processing one batch may take before the limit
+// stops growing. Past it the limit shrinks again, so one large batch does not
+// hold back the messages at its end or keep an ack waiting too long.
+const batchBudget = 2 * time.Second
+
+// pacer adapts the fetch limit and poll interval of a receive loop.
+//
+// A full batch means the relay still has a backlog: the limit doubles, unless
+// the batch took longer than batchBudget to process, and the next fetch
+// follows at once. A partial batch means the queue drained: the limit halves
+// and the loop polls again after MinInterval. An empty batch or a failed
+// fetch doubles the wait, up to MaxInterval, and drops the limit to MinLimit.
+type pacer struct {
+	domain.FetchPacing
+	limit    int
+	interval time.Duration
+}
+
+// newPacer returns a pacer at the bottom of p, filling zero fields from
+// DefaultPacing. It fails if a minimum exceeds its maximum.
+func newPacer(p domain.FetchPacing) (*pacer, error) {
+	if p.MinLimit == 0 {
+		p.MinLimit = DefaultPacing.MinLimit
+	}
+	if p.MaxLimit == 0 {
+		p.MaxLimit = max(DefaultPacing.MaxLimit, p.MinLimit)
+	}
+	if p.MinInterval == 0 {
+		p.MinInterval = DefaultPacing.MinInterval
+	}
+	if p.MaxInterval == 0 {
+		p.MaxInterval = max(DefaultPacing.MaxInterval, p.MinInterval)
+	}
+	switch {
+	case p.MinLimit < 1 || p.MaxLimit < p.MinLimit:
+		return nil, fmt.Errorf("fetch limits must satisfy 1 <= min <= max, got %d and %d", p.MinLimit, p.MaxLimit)
+	case p.MinInterval < 0 || p.MaxInterval < p.MinInterval:
+		return nil, fmt.Errorf("poll intervals must satisfy 0 <= min <= max, got %s and %s", p.MinInterval, p.MaxInterval)
+	}
+	return &pacer{FetchPacing: p, limit: p.MinLimit, interval: p.MinInterval}, nil
+}
+
+// next records a batch in which processed envelopes were handled in took, and
+// returns how long to wait before the next fetch. failed reports a fetch or
+// batch that processed nothing because of an error.
+func (p *pacer) next(processed int, took time.Duration, failed bool) time.Duration {
+	switch {
+	case failed || processed == 0:
+		p.limit = p.MinLimit
+		p.interval = min(max(2*p.interval, p.MinInterval, time.Millisecond), p.MaxInterval)
+		return p.interval
+	case processed >= p.limit:
+		if took <= batchBudget {
+			p.limit = min(2*p.limit, p.MaxLimit)
+		} else {
+			p.limit = max(p.limit/2, p.MinLimit)
+		}
+		p.interval = p.MinInterval
+		return 0
+	default:
+		p.limit = max(p.limit/2, p.MinLimit)
+		p.interval = p.MinInterval
+		return p.interval
+	}
+}
+
+// followSeen is how many processed envelope IDs FollowMessages remembers, so
+// an envelope both pushed and fetched before its ack is processed once.
+const followSeen = 1024
+
+// recentIDs remembers the last n envelope IDs added to it.
+type recentIDs struct {
+	n     int
+	order []string
+	set   map[string]struct{}
+}
+
+func newRecentIDs(n int) *recentIDs {
+	return &recentIDs{n: n, set: make(map[string]struct{}, n)}
+}
+
+// add remembers ids, forgetting the oldest beyond n.
+func (r *recentIDs) add(ids []string) {
+	for _, id := range ids {
+		if _, ok := r.set[id]; ok {
+			continue
+		}
+		r.set[id] = struct{}{}
+		r.order = append(r.order, id)
+	}
+	for len(r.order) > r.n {
+		delete(r.set, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// unseen returns the envelopes of envs whose IDs are not remembered.
+func (r *recentIDs) unseen(envs []domain.Envelope) []domain.Envelope {
+	out := envs[:0:0]
+	for _, env := range envs {
+		if _, ok := r.set[env.ID]; !ok {
+			out = append(out, env)
+		}
+	}
+	return out
+}
+
+// FollowMessages fetches and processes messages for me until ctx is
+// cancelled, like repeated calls to ReceiveMessage with a limit and a wait
+// chosen by a pacer within pacing (see pacer). Each batch, including an empty
+// one and a failed fetch, is passed to handle; errors do not stop the loop
+// unless handle returns one. It returns nil once ctx is cancelled.
+//
+// While the default relay holds a push connection open (see
+// RelayClient.Subscribe), envelopes are processed as they are pushed, and the
+// queue is only fetched after a full batch, after the connection drops, and
+// every MaxInterval for what push cannot deliver, such as envelopes queued
+// before it opened. A lost connection is reopened on the next round.
+// Without push, each fetch waits at the relay for up to the pacer's wait (see
+// RelayClient.WaitMessages), so messages still arrive as they are queued,
+// and relays that cannot wait are polled as before.
+//
+// With a watchdog policy set, conversations are checked every watchdogEvery
+// for a peer that stopped taking ratchet steps, and those newly flagged are
+// reported in the next fetched batch's report (see checkStuck).
+func (s *Service) FollowMessages(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	pacing domain.FetchPacing,
+	handle func(msgs []domain.DecryptedMessage, report domain.ReceiveReport, err error) error,
+) error {
+	p, err := newPacer(pacing)
+	if err != nil {
+		return err
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		push      <-chan domain.Envelope
+		pushRetry time.Time     // when to try subscribing again
+		pushOff   bool          // the relay cannot push
+		hold      time.Duration // how long the next fetch may wait at the relay
+		seen      = newRecentIDs(followSeen)
+		dog       = newWatchdog()
+	)
+	// process receives envs not seen yet and remembers those it processed.
+	process := func(envs []domain.Envelope) ([]domain.DecryptedMessage, int, error) {
+		envs = seen.unseen(envs)
+		if len(envs) == 0 {
+			return nil, 0, nil
+		}
+		msgs, processed, err := s.receive(ctx, passphrase, me, envs)
+		seen.add(envelopeIDs(envs[:processed]))
+		return msgs, processed, err
+	}
+
+	for {
+		// Subscribe before fetching, so nothing queued in between is missed.
+		if push == nil && !pushOff && !time.Now().Before(pushRetry) {
+			push, err = s.relays.Client("").Subscribe(ctx, me, signFetch(id, me, "/ws/"+me, ""))
+			switch {
+			case err == nil:
+				s.logger.Debug("push connected", "user", me)
+			case errors.Is(err, domain.ErrPushUnsupported):
+				pushOff = true
+				s.logger.Debug("push not supported; polling", "user", me, "err", err)
+			default:
+				pushRetry = time.Now().Add(p.MaxInterval)
+				s.logger.Debug("push not connected; polling", "user", me, "err", err)
+			}
+		}
+
+		if push != nil {
+			hold = 0
+		}
+		start := time.Now()
+		var (
+			msgs      []domain.DecryptedMessage
+			processed int
+		)
+		envs, fetched, err := s.relays.Client("").WaitMessages(ctx, me, p.limit, hold, signFetch(id, me, "/msg/"+me, fetchQuery(p.limit, hold)))
+		got := time.Now()
+		rep := domain.ReceiveReport{Expired: fetched.Expired}
+		if err == nil {
+			rep.Undelivered = s.noteUndelivered(fetched.ExpiredSent)
+			msgs, processed, err = process(envs)
+		}
+		rep.Stuck = s.checkStuck(dog, time.Now())
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := handle(msgs, rep, err); err != nil {
+			return err
+		}
+
+		// A full batch is fetched again at once; otherwise, with push, the
+		// next fetch is only a safety net. Without push, the next fetch
+		// spends the wait at the relay instead, which answers as soon as an
+		// envelope arrives. A relay that does not wait answers at once, as
+		// does one holding envelopes we cannot process yet; what it did not
+		// wait is slept here.
+		wait := p.next(processed, time.Since(got), err != nil && processed == 0)
+		switch {
+		case push != nil && wait > 0:
+			wait = p.MaxInterval
+		case push == nil:
+			var idle time.Duration
+			if processed == 0 {
+				idle = hold - got.Sub(start)
+			}
+			hold, wait = wait, max(idle, 0)
+		}
+		s.logger.Debug("follow pacing",
+			"user", me,
+			"fetched", len(envs),
+			"processed", processed,
+			"limit", p.limit,
+			"wait", wait,
+			"hold", hold,
+			"push", push != nil,
+		)
+		timer := time.NewTimer(wait)
+	waiting:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case <-timer.C:
+				break waiting
+			case env, ok := <-push:
+				if !ok {
+					// Fetch what the connection may have missed, then
+					// reconnect.
+					s.logger.Debug("push connection lost", "user", me)
+					push = nil
+					timer.Stop()
+					break waiting
+				}
+				batch := drain(push, env, p.MaxLimit)
+				msgs, _, err := process(batch)
+				if ctx.Err() != nil {
+					return nil
+				}
+				if err := handle(msgs, domain.ReceiveReport{}, err); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// drain returns first and whatever else push holds already, up to limit
+// envelopes in all, so a burst is processed and acked as one batch.
+func drain(push <-chan domain.Envelope, first domain.Envelope, limit int) []domain.Envelope {
+	batch := []domain.Envelope{first}
+	for len(batch) < limit {
+		select {
+		case env, ok := <-push:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, env)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// signFetch signs a GET of path with query, one of me's fetch or push
+// endpoints, with id's signing key, so the relay counts it as me's activity
+// for presence (see package relayauth).
+func signFetch(id domain.Identity, me, path, query string) domain.RequestAuth {
+	now := time.Now().Unix()
+	return domain.RequestAuth{
+		TimeUTC: now,
+		Sig:     relayauth.SignRequest(id.EdPriv, me, http.MethodGet, path, query, now),
+	}
+}
+
+// fetchQuery is the query a fetch of up to limit envelopes, waiting up to
+// wait, is sent with, which its signature must cover.
+func fetchQuery(limit int, wait time.Duration) string {
+	q := url.Values{}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	if wait > 0 {
+		q.Set("wait", wait.String())
+	}
+	return q.Encode()
+}
@@ -0,0 +1,163 @@
+package message
+
+import (
+	"bytes"
+	"fmt"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/address"
+	"ciphera/internal/protocol/keyid"
+	"ciphera/internal/protocol/ratchet"
+	"ciphera/internal/protocol/x3dh"
+)
+
+// bootstrapResponder derives the responder's Double Ratchet state from pm, a
+// PrekeyMessage from peer, and senderDH, the ratchet key on the peer's first
+// message (the header of a prekey envelope, or a rekey's RatchetPub).
+//
+// Steps:
+//  1. Check a paired contact sent it from the identity key received when
+//     pairing, and a peer at a fingerprint address from a key with that
+//     fingerprint.
+//  2. Load our identity.
+//  3. Resolve the sender's ratchet public.
+//  4. Check the prekey IDs follow the key ID scheme (see package keyid), so
+//     a malformed one is quarantined with its envelope rather than looked
+//     up; then load our signed prekey by ID and the one-time prekey, if the
+//     handshake names one.
+//  5. Derive the root key (X3DH) and initialise Double Ratchet as responder.
+//
+// The one-time prekey is not consumed here; the caller consumes it once the
+// first message decrypts. If the handshake names one we no longer hold (our
+// prekey store has diverged from the bundle the peer fetched, e.g. after
+// restoring a backup), the root could only be derived without it and would
+// differ from the initiator's, so ErrMissingOPK is returned instead (see
+// requestHandshakeRetry).
+func (s *Service) bootstrapResponder(
+	passphrase string,
+	peer string,
+	pm domain.PrekeyMessage,
+	senderDH []byte,
+) (domain.RatchetState, error) {
+	contact, paired, err := s.contactStore.LoadContact(peer)
+	if err != nil {
+		return domain.RatchetState{}, err
+	}
+	if paired && contact.IdentityKey != pm.InitiatorIK {
+		return domain.RatchetState{}, &decryptError{peer: peer, err: ErrContactMismatch}
+	}
+	if fp, err := address.ParseFingerprint(peer); err == nil && crypto.Fingerprint(pm.InitiatorIK.Slice()) != fp {
+		return domain.RatchetState{}, &decryptError{peer: peer, err: ErrFingerprintMismatch}
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return domain.RatchetState{}, err
+	}
+	var senderPub domain.X25519Public
+	copy(senderPub[:], senderDH)
+
+	if pm.SPKID == "" {
+		return domain.RatchetState{}, fmt.Errorf("missing SPKID in prekey message")
+	}
+	if err := keyid.Check(keyid.SignedPrekey, pm.SPKID); err != nil {
+		return domain.RatchetState{}, &decryptError{peer: peer, err: err}
+	}
+	if pm.OPKID != "" {
+		if err := keyid.Check(keyid.OneTimePrekey, pm.OPKID); err != nil {
+			return domain.RatchetState{}, &decryptError{peer: peer, err: err}
+		}
+	}
+	spkPriv, _, _, okSPK, err := s.prekeyStore.LoadSignedPrekey(pm.SPKID)
+	if err != nil {
+		return domain.RatchetState{}, err
+	}
+	if !okSPK {
+		return domain.RatchetState{}, fmt.Errorf("signed prekey %q not found", pm.SPKID)
+	}
+
+	// The one-time prekey is only consumed after the first message decrypts, so
+	// a corrupted prekey message does not burn it and can be retried.
+	var opkPriv *domain.X25519Private
+	if pm.OPKID != "" {
+		p, _, okOPK, err := s.prekeyStore.LoadOneTimePrekey(pm.OPKID)
+		if err != nil {
+			return domain.RatchetState{}, err
+		}
+		if !okOPK {
+			return domain.RatchetState{}, &decryptError{peer: peer, err: fmt.Errorf("%w: %s", ErrMissingOPK, pm.OPKID)}
+		}
+		opkPriv = &p
+	}
+
+	rk, err := x3dh.ResponderRoot(id, spkPriv, opkPriv, pm)
+	if err != nil {
+		return domain.RatchetState{}, fmt.Errorf("x3dh responder root: %w", err)
+	}
+	st, err := ratchet.InitAsResponder(rk, id.XPriv, id.XPub, senderPub, pm.Suite)
+	if err != nil {
+		return domain.RatchetState{}, err
+	}
+	s.logger.Debug("conversation initialised as responder",
+		"peer", peer,
+		"spk_id", pm.SPKID,
+		"opk_id", pm.OPKID,
+		"suite", st.Suite,
+	)
+	return st, nil
+}
+
+// pendingInitiator reports whether we started conv and the peer has not yet
+// confirmed it.
+func pendingInitiator(conv domain.Conversation) bool {
+	return conv.Initiator && (conv.Confirm == "" || conv.Confirm == domain.ConfirmPending)
+}
+
+// resolveCrossInitiation decides which handshake survives when a prekey
+// message arrives for a conversation we initiated and the peer has not yet
+// confirmed: both sides ran X3DH at once and hold different root keys.
+//
+// Both clients apply the same rule, so they agree without another round
+// trip: the handshake started by the side with the lower identity key (byte
+// order) wins. The loser discards its own state and becomes the responder of
+// the winner's handshake, confirming it as usual. The winner keeps its state
+// and builds the responder side of the losing handshake as Conversation.Stale,
+// only to decrypt what the loser sent before switching; it is dropped once
+// the loser's confirmation arrives.
+//
+// ok is false if the prekey message does not carry the identity key pinned
+// for the peer's session, so a third party cannot use a pending handshake to
+// displace it.
+func (s *Service) resolveCrossInitiation(passphrase string, env domain.Envelope) (keepOurs, ok bool, err error) {
+	if len(env.Header.DHPub) != 32 {
+		return false, false, nil
+	}
+	sess, found, err := s.sessionService.GetSession(env.From)
+	if err != nil {
+		return false, false, err
+	}
+	if !found || sess.PeerIK != env.Prekey.InitiatorIK {
+		return false, false, nil
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return false, false, err
+	}
+	keepOurs = bytes.Compare(id.XPub[:], env.Prekey.InitiatorIK[:]) < 0
+	s.logger.Debug("simultaneous session initiation", "peer", env.From, "keep_ours", keepOurs)
+	return keepOurs, true, nil
+}
+
+// sender returns the name we send to peer as. A peer at a fingerprint address
+// is sent ours, so replies reach us by fingerprint too and neither side's
+// username is involved; any other peer is sent me.
+func (s *Service) sender(passphrase, me, peer string) (string, error) {
+	if !address.IsFingerprint(peer) {
+		return me, nil
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return "", err
+	}
+	return address.FromFingerprint(crypto.Fingerprint(id.XPub.Slice())), nil
+}
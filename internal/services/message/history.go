@@ -0,0 +1,78 @@
+package message
+
+import (
+	"crypto/rand"
+	"errors"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+)
+
+// record appends messages sent or received by this client to the local
+// history, as far as each conversation's retention policy allows. The message
+// itself has already been delivered or decrypted, so a history write failure
+// is logged rather than returned.
+func (s *Service) record(passphrase string, entries ...domain.HistoryEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	ret, err := s.conversations.Retention()
+	if err != nil {
+		s.logger.Warn("history not saved", "count", len(entries), "error", err)
+		return
+	}
+
+	// Conversations that keep nothing are never written; ones with limits
+	// are pruned straight after the write.
+	kept := entries[:0]
+	limited := false
+	for _, e := range entries {
+		p := ret.For(e.Peer)
+		if p.KeepNone {
+			continue
+		}
+		limited = limited || !p.Unlimited()
+		e.ID = rand.Text()
+		kept = append(kept, e)
+	}
+	if len(kept) == 0 {
+		return
+	}
+	if _, err := s.historyStore.AppendHistory(passphrase, kept); err != nil {
+		s.logger.Warn("history not saved", "count", len(kept), "error", err)
+		return
+	}
+	if limited {
+		removed, err := s.historyStore.PruneHistory(passphrase, ret, time.Now().Unix())
+		if errors.Is(err, domain.ErrHistoryLocked) {
+			// The entries were sealed for later; the next write made with
+			// the history's own passphrase prunes.
+			s.logger.Debug("history locked; prune deferred")
+			return
+		}
+		if err != nil {
+			s.logger.Warn("history not pruned", "error", err)
+			return
+		}
+		s.logger.Debug("history pruned", "removed", removed)
+	}
+}
+
+// recordReceived records decrypted messages in one history write. Local
+// notices are left out.
+func (s *Service) recordReceived(passphrase string, msgs []domain.DecryptedMessage) {
+	entries := make([]domain.HistoryEntry, 0, len(msgs))
+	for _, m := range msgs {
+		if body.Local(m.Body.ContentType) {
+			continue
+		}
+		entries = append(entries, domain.HistoryEntry{
+			Peer:      m.From,
+			Direction: domain.HistoryIn,
+			Body:      m.Body,
+			SentUTC:   m.Timestamp,
+		})
+	}
+	s.record(passphrase, entries...)
+}
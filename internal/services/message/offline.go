@@ -0,0 +1,122 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+)
+
+var (
+	// ErrNotForMe indicates an imported envelope is addressed to someone else.
+	ErrNotForMe = errors.New("envelope is addressed to another user")
+	// ErrControlImport indicates an imported envelope carries a control
+	// message; only user content is delivered out of band.
+	ErrControlImport = errors.New("control messages cannot be imported")
+	// ErrNoPrekey indicates an imported envelope continues a
+	// conversation we do not have and carries no prekey message to start one.
+	ErrNoPrekey = errors.New("no conversation with sender and no prekey message to start one")
+)
+
+// ExportEnvelope encrypts msg for toUsername like SendMessage, but returns the
+// envelope instead of posting it, for delivery without a relay. The ratchet
+// state is saved and the message recorded in the history, exactly as for a
+// send: the envelope must be delivered, or the next message's first key is
+// one the peer never sees.
+func (s *Service) ExportEnvelope(
+	passphrase string,
+	fromUsername string,
+	toUsername string,
+	msg domain.MessageBody,
+	force bool,
+) (domain.Envelope, error) {
+	plaintext, err := body.Encode(msg)
+	if err != nil {
+		return domain.Envelope{}, err
+	}
+	unlock, err := s.ratchetStore.LockConversation(toUsername)
+	if err != nil {
+		return domain.Envelope{}, err
+	}
+	defer unlock()
+
+	_, conv, env, step, err := s.seal(passphrase, fromUsername, toUsername, msg.ContentType, plaintext, force)
+	if err != nil {
+		return domain.Envelope{}, err
+	}
+	s.observeSent(&conv, len(env.Cipher))
+	markUnanswered(&conv)
+	if err := s.ratchetStore.SaveConversation(toUsername, conv); err != nil {
+		return domain.Envelope{}, err
+	}
+	s.recordStep(passphrase, toUsername, step)
+
+	s.logger.Debug("exported envelope",
+		"peer", toUsername,
+		"n", env.Header.N,
+		"pn", env.Header.PN,
+		"has_prekey", env.Prekey != nil,
+	)
+	if msg.Version == 0 {
+		msg.Version = body.Version // as Encode wrote it
+	}
+	s.record(passphrase, domain.HistoryEntry{
+		Peer:      toUsername,
+		Direction: domain.HistoryOut,
+		Body:      msg,
+		SentUTC:   env.Timestamp,
+	})
+	return env, nil
+}
+
+// ImportEnvelope decrypts an envelope delivered without a relay, such as one
+// written by ExportEnvelope, and records it in the history.
+//
+// It bootstraps a conversation from the envelope's prekey message like
+// ReceiveMessage. The session confirmation that follows is posted to the
+// relay if one is reachable; otherwise the sender's side stays pending. An
+// envelope that fails to decrypt, including one already imported, is
+// returned as an error and not quarantined, since the caller still holds it.
+// A message the receive filters refuse is held and ErrHeld returned.
+func (s *Service) ImportEnvelope(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	env domain.Envelope,
+) (domain.DecryptedMessage, error) {
+	if env.To != me {
+		return domain.DecryptedMessage{}, fmt.Errorf("%w: %q", ErrNotForMe, env.To)
+	}
+	if isControl(env) {
+		return domain.DecryptedMessage{}, ErrControlImport
+	}
+	env.ID = "" // relay-assigned; meaningless here
+
+	msg, res, err := s.processEnvelope(ctx, passphrase, me, env, true)
+	if err != nil {
+		return domain.DecryptedMessage{}, err
+	}
+	switch res {
+	case resultDeferred:
+		return domain.DecryptedMessage{}, ErrNoPrekey
+	case resultRejected:
+		return domain.DecryptedMessage{}, ErrHeaderMAC
+	case resultPending:
+		return domain.DecryptedMessage{}, ErrSessionPending
+	case resultRefused:
+		return domain.DecryptedMessage{}, ErrSessionRefused
+	case resultArchived:
+		return domain.DecryptedMessage{}, fmt.Errorf("%w: %q", domain.ErrArchived, env.From)
+	}
+	filters, err := s.conversations.ReceiveFilters()
+	if err != nil {
+		return domain.DecryptedMessage{}, err
+	}
+	if _, held := s.screen(passphrase, filters, []domain.DecryptedMessage{msg}); held > 0 {
+		return domain.DecryptedMessage{}, ErrHeld
+	}
+	s.recordReceived(passphrase, []domain.DecryptedMessage{msg})
+	return msg, nil
+}
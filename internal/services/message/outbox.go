@@ -0,0 +1,102 @@
+package message
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// journal records a message the relay accepted in the outbox journal. The
+// message has already been sent, so a write failure is logged rather than
+// returned.
+func (s *Service) journal(peer string, m domain.SentMessage) {
+	if err := s.outboxStore.AppendSent(peer, m); err != nil {
+		s.logger.Warn("outbox not saved", "peer", peer, "error", err)
+		return
+	}
+	s.logger.Debug("message journalled", "peer", peer, "seq", m.Seq, "server", m.Relay)
+}
+
+// SentMessages returns the messages me sent peer that the relay accepted,
+// oldest first, with whether peer has fetched each one.
+//
+// Each relay in the journal is asked once for peer's queue, counting only our
+// envelopes. Since a sender's envelopes are fetched in order, a message is
+// fetched if its sequence number is at or below the relay's fetched
+// watermark. Entries the relay reported as expired unfetched, and queued
+// ones past their deadline, are SentExpired. Entries without a sequence number, and those on a relay
+// that cannot be asked, are SentUnknown.
+func (s *Service) SentMessages(ctx context.Context, me, peer string) ([]domain.SentStatus, error) {
+	sent, err := s.outboxStore.ListSent(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	watermarks := make(map[string]uint64) // relay to fetched watermark
+	asked := make(map[string]bool)
+	out := make([]domain.SentStatus, 0, len(sent))
+	for _, m := range sent {
+		st := domain.SentStatus{SentMessage: m, State: domain.SentUnknown}
+		switch {
+		case m.ExpiredUTC != 0:
+			st.State = domain.SentExpired
+		case m.Seq != 0:
+			if !asked[m.Relay] {
+				asked[m.Relay] = true
+				qs, err := s.relays.Client(m.Relay).QueueStats(ctx, peer, me)
+				if err != nil {
+					s.logger.Debug("queue stats failed", "peer", peer, "server", m.Relay, "err", err)
+				} else {
+					watermarks[m.Relay] = qs.FetchedSeq
+				}
+			}
+			if w, ok := watermarks[m.Relay]; ok {
+				st.State = domain.SentQueued
+				switch {
+				case m.Seq <= w:
+					st.State = domain.SentFetched
+				case m.ExpiresUTC != 0 && now >= m.ExpiresUTC:
+					// Past its deadline, the relay drops it rather than
+					// deliver it, even if it has not swept it yet.
+					st.State = domain.SentExpired
+				}
+			}
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// noteUndelivered marks the outbox entries for the envelopes the default
+// relay reported as expired unfetched, ids, and returns them. Unknown IDs are
+// ignored: the relay reports every envelope sent under our name, including
+// those sent from another device. A failure to update the journal is logged,
+// since the relay reports each ID only once and receiving must go on.
+func (s *Service) noteUndelivered(ids []string) []domain.UndeliveredMessage {
+	if len(ids) == 0 {
+		return nil
+	}
+	seqs := make([]uint64, 0, len(ids))
+	for _, id := range ids {
+		if seq, err := strconv.ParseUint(id, 10, 64); err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	// The journal names the default relay by "" or by its URL.
+	relays := []string{""}
+	if servers, err := s.relays.Servers(); err == nil && len(servers) > 0 {
+		relays = append(relays, servers[0])
+	}
+	out, err := s.outboxStore.MarkExpired(relays, seqs, time.Now().Unix())
+	if err != nil {
+		s.logger.Warn("undelivered messages not recorded", "count", len(seqs), "error", err)
+		return nil
+	}
+	for _, m := range out {
+		s.logger.Debug("sent message expired undelivered", "peer", m.Peer, "seq", m.Seq)
+	}
+	return out
+}
@@ -0,0 +1,143 @@
+package message
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+)
+
+const (
+	// controlPing asks the peer's client to answer with a pong at once.
+	controlPing = "ping"
+	// controlPong answers a ping, echoing its ID and sent time.
+	controlPong = "pong"
+
+	// pingContext separates ping signatures from every other Ed25519
+	// signature.
+	pingContext = "ciphera/ping-v1"
+
+	// maxPingIDLen bounds the ping ID a peer may send.
+	maxPingIDLen = 64
+)
+
+// Ping sends peer a signed ping, which their client answers with a signed
+// pong as soon as it receives it. The pong is reported as a local notice of
+// type body.TypePong carrying the returned ID.
+func (s *Service) Ping(ctx context.Context, passphrase, me, peer string) (string, error) {
+	unlock, err := s.ratchetStore.LockConversation(peer)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	conv, found, err := s.ratchetStore.LoadConversation(peer)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", ErrNoConversation
+	}
+	peerIK, _, _, err := s.peerKeys(conv)
+	if err != nil {
+		return "", err
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	p := domain.Ping{ID: rand.Text(), SentMilli: time.Now().UnixMilli()}
+	msg := domain.ControlMessage{Type: controlPing, Ping: &p}
+	msg.Sig = crypto.SignContext(id.EdPriv, pingContext, pingStatement(msg.Type, p, id.XPub, peerIK))
+	if err := s.sendControl(ctx, passphrase, me, &conv, msg); err != nil {
+		return "", err
+	}
+	s.logger.Debug("ping sent", "peer", peer)
+	return p.ID, nil
+}
+
+// handlePing answers a ping from conv.Peer with a pong, or reports a pong as
+// a notice. Both must carry a valid signature if the peer's signing key is
+// known (see peerKeys); malformed or badly signed ones are logged and
+// ignored. A pong whose signature could not be checked is reported as
+// unverified.
+func (s *Service) handlePing(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	conv *domain.Conversation,
+	msg domain.ControlMessage,
+) (*domain.MessageBody, error) {
+	now := time.Now().UnixMilli()
+	p := msg.Ping
+	if p == nil || p.ID == "" || len(p.ID) > maxPingIDLen {
+		s.logger.Warn("ignoring malformed ping", "peer", conv.Peer, "type", msg.Type)
+		return nil, nil
+	}
+	peerIK, peerSK, known, err := s.peerKeys(*conv)
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if known && !crypto.VerifyContext(peerSK, pingContext, pingStatement(msg.Type, *p, peerIK, id.XPub), msg.Sig) {
+		s.logger.Warn("ignoring ping with a bad signature", "peer", conv.Peer, "type", msg.Type)
+		return nil, nil
+	}
+
+	if msg.Type == controlPong {
+		rtt := max(now-p.SentMilli, 0)
+		took := max(p.RepliedMilli-p.ReceivedMilli, 0)
+		s.logger.Debug("pong received", "peer", conv.Peer, "rtt_ms", rtt, "verified", known)
+		notice := pongNotice(p.ID, rtt, took, known)
+		return &notice, nil
+	}
+
+	pong := domain.Ping{ID: p.ID, SentMilli: p.SentMilli, ReceivedMilli: now, RepliedMilli: time.Now().UnixMilli()}
+	reply := domain.ControlMessage{Type: controlPong, Ping: &pong}
+	reply.Sig = crypto.SignContext(id.EdPriv, pingContext, pingStatement(reply.Type, pong, id.XPub, peerIK))
+	if err := s.sendControl(ctx, passphrase, me, conv, reply); err != nil {
+		return nil, err
+	}
+	s.logger.Debug("ping answered", "peer", conv.Peer, "verified", known)
+	return nil, nil
+}
+
+// pingStatement returns the bytes a ping or pong signs: its type, ID and
+// timestamps, bound to the sender's and recipient's identity keys so it
+// cannot be replayed into another conversation.
+func pingStatement(typ string, p domain.Ping, from, to domain.X25519Public) []byte {
+	var b []byte
+	for _, f := range []string{typ, p.ID} {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(f)))
+		b = append(b, f...)
+	}
+	for _, t := range []int64{p.SentMilli, p.ReceivedMilli, p.RepliedMilli} {
+		b = binary.BigEndian.AppendUint64(b, uint64(t))
+	}
+	b = append(b, from[:]...)
+	return append(b, to[:]...)
+}
+
+// pongNotice is the local message body reporting a pong to the user. It is
+// never sent or stored in the history.
+func pongNotice(id string, rtt, took int64, verified bool) domain.MessageBody {
+	return domain.MessageBody{
+		Version:     body.Version,
+		ContentType: body.TypePong,
+		Metadata: map[string]string{
+			body.MetaPingID:     id,
+			body.MetaPingRTT:    strconv.FormatInt(rtt, 10),
+			body.MetaPingPeer:   strconv.FormatInt(took, 10),
+			body.MetaPingSigned: strconv.FormatBool(verified),
+		},
+	}
+}
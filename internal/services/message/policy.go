@@ -0,0 +1,59 @@
+package message
+
+import (
+	"errors"
+
+	"ciphera/internal/domain"
+)
+
+var (
+	// ErrSessionPending indicates new sessions were quarantined until the
+	// user accepts them, as the session policy asks.
+	ErrSessionPending = errors.New("new session awaits acceptance")
+	// ErrSessionRefused indicates envelopes starting new sessions were
+	// dropped unread, as the session policy refuses them.
+	ErrSessionRefused = errors.New("new session refused by policy")
+)
+
+// acceptMode returns how the session policy treats a new session peer starts
+// with us. Paired contacts and peers the user accepted are always let in.
+func (s *Service) acceptMode(peer string) (domain.AcceptMode, error) {
+	policy, err := s.conversations.SessionPolicy()
+	if err != nil {
+		return "", err
+	}
+	if policy.AcceptNew == domain.AcceptYes {
+		return domain.AcceptYes, nil
+	}
+	_, paired, err := s.contactStore.LoadContact(peer)
+	if err != nil {
+		return "", err
+	}
+	if paired {
+		return domain.AcceptYes, nil
+	}
+	prefs, err := s.conversations.Preferences(peer)
+	if err != nil {
+		return "", err
+	}
+	if prefs.AcceptSession {
+		return domain.AcceptYes, nil
+	}
+	s.logger.Debug("new session held back by policy", "peer", peer, "accept_new", policy.AcceptNew)
+	return policy.AcceptNew, nil
+}
+
+// replyNeedsVerification reports whether the session policy requires peer to
+// be verified before we send to them: it does for a conversation the peer
+// started, under VerifyBeforeReply.
+func (s *Service) replyNeedsVerification(peer string) (bool, error) {
+	policy, err := s.conversations.SessionPolicy()
+	if err != nil || !policy.VerifyBeforeReply {
+		return false, err
+	}
+	conv, found, err := s.ratchetStore.LoadConversation(peer)
+	if err != nil {
+		return false, err
+	}
+	return found && !conv.Initiator, nil
+}
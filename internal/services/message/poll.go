@@ -0,0 +1,57 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/poll"
+)
+
+var (
+	// ErrUnknownPoll indicates a vote in a poll that is not in the history.
+	ErrUnknownPoll = errors.New("no such poll in the history")
+	// ErrBadChoice indicates a vote for an option the poll does not have.
+	ErrBadChoice = errors.New("poll has no such option")
+)
+
+// Vote sends me's vote for option choice in poll id.
+//
+// Votes go to the poll's creator; the creator's own vote goes to every peer
+// the poll was sent to, so they can count it too. Each vote is an ordinary
+// message and is recorded in the history like one, which is where the tally
+// comes from.
+func (s *Service) Vote(ctx context.Context, passphrase, me, id string, choice int) ([]string, error) {
+	entries, err := s.historyStore.LoadHistory(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	var t *domain.PollTally
+	for _, p := range poll.Tally(entries) {
+		if p.ID == id {
+			t = &p
+			break
+		}
+	}
+	if t == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPoll, id)
+	}
+	if choice < 0 || choice >= len(t.Options) {
+		return nil, fmt.Errorf("%w: %d of %d", ErrBadChoice, choice+1, len(t.Options))
+	}
+
+	to := t.Participants
+	if t.Creator != "" {
+		to = []string{t.Creator}
+	}
+	var sent []string
+	for _, peer := range to {
+		if err := s.SendMessage(ctx, passphrase, me, peer, poll.Vote(id, choice), false, 0); err != nil {
+			return sent, fmt.Errorf("sending vote to %q: %w", peer, err)
+		}
+		sent = append(sent, peer)
+	}
+	s.logger.Debug("poll vote sent", "poll", id, "recipients", len(sent))
+	return sent, nil
+}
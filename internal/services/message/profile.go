@@ -0,0 +1,214 @@
+package message
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"ciphera/internal/domain"
+)
+
+const (
+	// controlProfile carries the sender's profile.
+	controlProfile = "profile"
+
+	maxDisplayName = 64       // runes
+	maxAvatar      = 64       // bytes; enough for any emoji sequence
+	maxPhoto       = 16 << 10 // bytes, so a profile fits in one envelope
+)
+
+// ErrBadProfile indicates a profile with a field that is too long, holds
+// control characters, or a photo that is not a supported image.
+var ErrBadProfile = errors.New("invalid profile")
+
+// Image types a profile photo may have, as sniffed by http.DetectContentType.
+var photoTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// SetProfile saves p as our profile and sends it to every peer whose session
+// is confirmed. Peers still waiting on a handshake get it once the session is
+// confirmed (see shareProfile). Every peer is tried; those that fail are
+// reported together in the error.
+func (s *Service) SetProfile(ctx context.Context, passphrase, me string, p domain.Profile) ([]string, error) {
+	p.Peer = ""
+	p.PhotoHash = ""
+	if len(p.Photo) > 0 {
+		p.PhotoHash = photoHash(p.Photo)
+	}
+	p.UpdatedUTC = time.Now().Unix()
+	if err := checkProfile(p); err != nil {
+		return nil, err
+	}
+	if err := s.profileStore.SaveOwnProfile(p); err != nil {
+		return nil, err
+	}
+
+	convs, err := s.ratchetStore.ListConversations()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		sent []string
+		errs []error
+	)
+	for _, conv := range convs {
+		if conv.Confirm != domain.ConfirmOK {
+			continue
+		}
+		ok, err := s.profileTo(ctx, passphrase, me, conv.Peer, p)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sending profile to %q: %w", conv.Peer, err))
+			continue
+		}
+		if ok {
+			sent = append(sent, conv.Peer)
+		}
+	}
+	s.logger.Debug("profile shared", "recipients", len(sent), "failed", len(errs))
+	return sent, errors.Join(errs...)
+}
+
+// Profile returns our own profile, if one was set.
+func (s *Service) Profile() (domain.Profile, bool, error) {
+	return s.profileStore.LoadOwnProfile()
+}
+
+// PeerProfiles returns the profiles peers sent us, ordered by peer.
+func (s *Service) PeerProfiles() ([]domain.Profile, error) {
+	return s.profileStore.ListPeerProfiles()
+}
+
+// shareProfile sends our profile, if we have one, to the peer of a session
+// that has just been confirmed. It runs while a message is being received,
+// whose result it must not change, so failures are logged rather than
+// returned.
+func (s *Service) shareProfile(ctx context.Context, passphrase, me string, conv *domain.Conversation) {
+	p, ok, err := s.profileStore.LoadOwnProfile()
+	if err != nil || !ok || p.Empty() {
+		if err != nil {
+			s.logger.Debug("profile not read", "err", err)
+		}
+		return
+	}
+	if err := s.sendProfile(ctx, passphrase, me, conv, p); err != nil {
+		s.logger.Debug("profile not sent", "peer", conv.Peer, "err", err)
+	}
+}
+
+// sendProfile sends p to conv's peer.
+func (s *Service) sendProfile(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	conv *domain.Conversation,
+	p domain.Profile,
+) error {
+	if err := s.sendControl(ctx, passphrase, me, conv, domain.ControlMessage{Type: controlProfile, Profile: &p}); err != nil {
+		return err
+	}
+	s.logger.Debug("profile sent", "peer", conv.Peer)
+	return nil
+}
+
+// profileTo sends p to peer if their conversation is still confirmed once
+// loaded under its lock, and reports whether it did.
+func (s *Service) profileTo(ctx context.Context, passphrase, me, peer string, p domain.Profile) (bool, error) {
+	unlock, err := s.ratchetStore.LockConversation(peer)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	conv, found, err := s.ratchetStore.LoadConversation(peer)
+	if err != nil || !found || conv.Confirm != domain.ConfirmOK {
+		return false, err
+	}
+	return true, s.sendProfile(ctx, passphrase, me, &conv, p)
+}
+
+// handleProfile stores the profile conv.Peer sent, replacing an older one.
+// Invalid profiles, and ones older than the stored profile (a resend or a
+// reordered envelope), are logged and ignored.
+func (s *Service) handleProfile(conv *domain.Conversation, msg domain.ControlMessage) error {
+	p := msg.Profile
+	if p == nil {
+		s.logger.Warn("ignoring empty profile", "peer", conv.Peer)
+		return nil
+	}
+	if err := checkProfile(*p); err != nil {
+		s.logger.Warn("ignoring profile", "peer", conv.Peer, "err", err)
+		return nil
+	}
+	old, found, err := s.profileStore.LoadPeerProfile(conv.Peer)
+	if err != nil {
+		return err
+	}
+	if found && p.UpdatedUTC < old.UpdatedUTC {
+		s.logger.Debug("ignoring outdated profile", "peer", conv.Peer)
+		return nil
+	}
+	p.Peer = conv.Peer
+	if err := s.profileStore.SavePeerProfile(*p); err != nil {
+		return err
+	}
+	s.logger.Debug("profile received", "peer", conv.Peer, "photo", len(p.Photo) > 0)
+	return nil
+}
+
+// checkProfile checks p's field lengths and characters, and that its photo
+// is a supported image matching PhotoHash.
+func checkProfile(p domain.Profile) error {
+	switch {
+	case utf8.RuneCountInString(p.DisplayName) > maxDisplayName:
+		return fmt.Errorf("%w: display name longer than %d characters", ErrBadProfile, maxDisplayName)
+	case !printable(p.DisplayName, false):
+		return fmt.Errorf("%w: display name has control characters", ErrBadProfile)
+	case len(p.Avatar) > maxAvatar:
+		return fmt.Errorf("%w: avatar longer than %d bytes", ErrBadProfile, maxAvatar)
+	case !printable(p.Avatar, true):
+		return fmt.Errorf("%w: avatar has control characters", ErrBadProfile)
+	case len(p.Photo) > maxPhoto:
+		return fmt.Errorf("%w: photo larger than %d KiB", ErrBadProfile, maxPhoto>>10)
+	case len(p.Photo) == 0 && p.PhotoHash != "":
+		return fmt.Errorf("%w: photo hash without a photo", ErrBadProfile)
+	}
+	if len(p.Photo) == 0 {
+		return nil
+	}
+	typ, _, _ := strings.Cut(http.DetectContentType(p.Photo), ";")
+	if !slices.Contains(photoTypes, typ) {
+		return fmt.Errorf("%w: photo is %s, not PNG, JPEG, GIF or WebP", ErrBadProfile, typ)
+	}
+	if p.PhotoHash != photoHash(p.Photo) {
+		return fmt.Errorf("%w: photo does not match its hash", ErrBadProfile)
+	}
+	return nil
+}
+
+// printable reports whether s is valid UTF-8 without control or formatting
+// characters, which could rewrite the terminal or reorder the text around a
+// name. Emoji sequences need the zero-width joiner, so joiner allows it.
+func printable(s string, joiner bool) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if unicode.Is(unicode.Cc, r) || (unicode.Is(unicode.Cf, r) && !(joiner && r == '\u200d')) {
+			return false
+		}
+	}
+	return true
+}
+
+// photoHash returns the hex SHA-256 of photo.
+func photoHash(photo []byte) string {
+	sum := sha256.Sum256(photo)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,114 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+)
+
+var (
+	// ErrQuarantined indicates one or more envelopes failed to decrypt and were quarantined.
+	ErrQuarantined = errors.New("envelopes failed to decrypt and were quarantined")
+	// ErrNotQuarantined indicates no quarantined envelope has the requested ID.
+	ErrNotQuarantined = errors.New("no quarantined envelope with that id")
+)
+
+// quarantine sets env aside locally so the rest of the queue can be acked.
+//
+// The ID is derived from the ciphertext, so quarantining the same envelope twice
+// overwrites the earlier record instead of duplicating it.
+func (s *Service) quarantine(env domain.Envelope, cause error) error {
+	q := domain.QuarantinedEnvelope{
+		ID:             crypto.Fingerprint(env.Cipher),
+		Envelope:       env,
+		Reason:         cause.Error(),
+		QuarantinedUTC: time.Now().Unix(),
+	}
+	if err := s.quarantineStore.SaveQuarantined(q); err != nil {
+		return err
+	}
+	s.logger.Debug("envelope quarantined", "id", q.ID, "peer", env.From, "n", env.Header.N)
+	return nil
+}
+
+// ListQuarantined returns the envelopes currently held in quarantine.
+func (s *Service) ListQuarantined() ([]domain.QuarantinedEnvelope, error) {
+	return s.quarantineStore.ListQuarantined()
+}
+
+// RetryQuarantined attempts to decrypt quarantined envelopes against the current
+// conversation state. If id is empty, every quarantined envelope is retried.
+//
+// Envelopes that now decrypt are removed from quarantine and returned, unless
+// the receive filters hold them; those that still fail stay quarantined with
+// an updated reason.
+func (s *Service) RetryQuarantined(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	id string,
+) ([]domain.DecryptedMessage, error) {
+	all, err := s.quarantineStore.ListQuarantined()
+	if err != nil {
+		return nil, err
+	}
+	filters, err := s.conversations.ReceiveFilters()
+	if err != nil {
+		return nil, err
+	}
+
+	var todo []domain.QuarantinedEnvelope
+	for _, q := range all {
+		if id == "" || q.ID == id {
+			todo = append(todo, q)
+		}
+	}
+	if id != "" && len(todo) == 0 {
+		return nil, ErrNotQuarantined
+	}
+
+	var out []domain.DecryptedMessage
+	for _, q := range todo {
+		msg, res, err := s.processEnvelope(ctx, passphrase, me, q.Envelope, false)
+		var derr *decryptError
+		if errors.As(err, &derr) {
+			q.Reason = derr.Error()
+			if err := s.quarantineStore.SaveQuarantined(q); err != nil {
+				return out, err
+			}
+			continue
+		}
+		if err != nil {
+			return out, err
+		}
+		if res == resultDeferred || res == resultRejected || res == resultPending || res == resultRefused ||
+			res == resultArchived {
+			continue
+		}
+		if _, err := s.quarantineStore.DeleteQuarantined(q.ID); err != nil {
+			return out, err
+		}
+		s.logger.Debug("quarantined envelope recovered", "id", q.ID, "peer", q.Envelope.From)
+		if res == resultMessage {
+			out = append(out, msg)
+		}
+	}
+	out, _ = s.screen(passphrase, filters, out)
+	s.recordReceived(passphrase, out)
+	return out, nil
+}
+
+// DropQuarantined permanently discards the quarantined envelope with id.
+func (s *Service) DropQuarantined(id string) error {
+	ok, err := s.quarantineStore.DeleteQuarantined(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotQuarantined
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/ratchet"
+)
+
+// controlRekey moves the conversation to the root key of a fresh X3DH
+// handshake. It carries the handshake and is sent on the current root.
+const controlRekey = "rekey"
+
+// maybeRekey rekeys the conversation with peer if the rekey policy says it is
+// due. Only the side that initiated the conversation rekeys, so the two sides
+// never start one at once, and never while its last handshake is unconfirmed.
+func (s *Service) maybeRekey(ctx context.Context, passphrase, from, peer string) error {
+	policy, err := s.conversations.RekeyPolicy()
+	if err != nil || !policy.Enabled() {
+		return err
+	}
+	unlock, err := s.ratchetStore.LockConversation(peer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	conv, found, err := s.ratchetStore.LoadConversation(peer)
+	if err != nil {
+		return err
+	}
+	if !found || !conv.Initiator || pendingInitiator(conv) || conv.WipeRequested != "" {
+		return nil
+	}
+	sess, ok, err := s.sessionService.GetSession(peer)
+	if err != nil || !ok {
+		return err
+	}
+	if !rekeyDue(conv, sess, policy, time.Now()) {
+		return nil
+	}
+	return s.rekey(ctx, passphrase, from, conv, sess)
+}
+
+// rekeyDue reports whether conv has reached either limit of p at now. The age
+// of a conversation never rekeyed is taken from its session.
+func rekeyDue(conv domain.Conversation, sess domain.Session, p domain.RekeyPolicy, now time.Time) bool {
+	if p.Messages > 0 && conv.SinceRekey >= p.Messages {
+		return true
+	}
+	since := conv.RekeyedUTC
+	if since == 0 {
+		since = sess.CreatedUTC
+	}
+	return p.Days > 0 && now.Unix()-since >= int64(p.Days)*24*60*60
+}
+
+// rekey runs X3DH against the peer's current bundle, announces the handshake
+// on the current root and switches conv to the new one.
+//
+// The peer keeps sending on the old root until the announcement arrives, so
+// the old state is kept as conv.Stale to decrypt those messages. The peer's
+// session confirmation, sent on the new root, drops it.
+func (s *Service) rekey(
+	ctx context.Context,
+	passphrase string,
+	from string,
+	conv domain.Conversation,
+	old domain.Session,
+) error {
+	peerIK := conv.PeerIK
+	if peerIK == (domain.X25519Public{}) {
+		peerIK = old.PeerIK
+	}
+	sess, err := s.sessionService.RenewSession(ctx, passphrase, conv.Peer, peerIK)
+	if err != nil {
+		return err
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return err
+	}
+	next, err := ratchet.InitAsInitiator(sess.RootKey, id.XPriv, id.XPub, sess.PeerIK, sess.Suite)
+	if err != nil {
+		return err
+	}
+
+	// Sent on the current root, so the peer only takes a handshake from
+	// whoever already holds the conversation.
+	err = s.sendControl(ctx, passphrase, from, &conv, domain.ControlMessage{
+		Type: controlRekey,
+		Prekey: &domain.PrekeyMessage{
+			InitiatorIK: id.XPub,
+			Ephemeral:   sess.InitiatorEK,
+			SPKID:       sess.SPKID,
+			OPKID:       sess.OPKID,
+			Suite:       sess.Suite,
+		},
+		RatchetPub: next.DHPub.Slice(),
+	})
+	if err != nil {
+		return err
+	}
+
+	prev := conv.State
+	conv.Stale = &prev
+	conv.State = next
+	conv.Confirm = domain.ConfirmPending
+	conv.RekeyedUTC = time.Now().Unix()
+	conv.SinceRekey = 0
+	conv.Rekeys++
+	if err := s.ratchetStore.SaveConversation(conv.Peer, conv); err != nil {
+		return err
+	}
+	s.logger.Debug("conversation rekeyed",
+		"peer", conv.Peer,
+		"spk_id", sess.SPKID,
+		"opk_id", sess.OPKID,
+		"rekeys", conv.Rekeys,
+	)
+	return nil
+}
+
+// handleRekey switches conv to the root key of the handshake in msg and
+// confirms it to the peer on the new root. A rekey from the side that did not
+// initiate the conversation, or under a different identity key, is ignored:
+// identities never change in a rekey. A rekey naming a one-time prekey we no
+// longer hold is answered with a handshake retry (see handleRekeyRetry).
+func (s *Service) handleRekey(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	conv *domain.Conversation,
+	msg domain.ControlMessage,
+) error {
+	if msg.Prekey == nil || len(msg.RatchetPub) != 32 {
+		s.logger.Debug("ignoring malformed rekey", "peer", conv.Peer)
+		return nil
+	}
+	if conv.Initiator || (conv.PeerIK != domain.X25519Public{} && conv.PeerIK != msg.Prekey.InitiatorIK) {
+		s.logger.Debug("ignoring rekey", "peer", conv.Peer, "initiator", conv.Initiator)
+		return nil
+	}
+	st, err := s.bootstrapResponder(passphrase, conv.Peer, *msg.Prekey, msg.RatchetPub)
+	if errors.Is(err, ErrMissingOPK) {
+		// Switching would leave the two sides on different roots: stay on
+		// this one and ask the peer to rekey again.
+		s.logger.Info("asked peer to retry a rekey naming a missing one-time prekey",
+			"peer", conv.Peer,
+			"opk_id", msg.Prekey.OPKID,
+		)
+		return s.sendControl(ctx, passphrase, me, conv, domain.ControlMessage{
+			Type:   controlHandshakeRetry,
+			Prekey: msg.Prekey,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	conv.State = st
+	conv.Stale = nil
+	conv.PeerIK = msg.Prekey.InitiatorIK
+	conv.RekeyedUTC = time.Now().Unix()
+	conv.SinceRekey = 0
+	conv.Rekeys++
+	if err := s.confirmSession(ctx, passphrase, me, conv, *msg.Prekey); err != nil {
+		return err
+	}
+	if msg.Prekey.OPKID != "" {
+		if _, _, _, err := s.prekeyStore.ConsumeOneTimePrekey(msg.Prekey.OPKID); err != nil {
+			return err
+		}
+	}
+	s.logger.Debug("conversation rekeyed by peer",
+		"peer", conv.Peer,
+		"spk_id", msg.Prekey.SPKID,
+		"opk_id", msg.Prekey.OPKID,
+		"rekeys", conv.Rekeys,
+	)
+	return nil
+}
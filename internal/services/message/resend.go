@@ -0,0 +1,140 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/ratchet"
+)
+
+const (
+	// controlResendRequest names messages the sender is missing and asks the
+	// recipient to post them again from its outbox journal.
+	controlResendRequest = "resend_request"
+
+	// maxResendIndices bounds how many messages one resend request names.
+	maxResendIndices = 64
+)
+
+// trackGap records when conv's state started holding skipped keys, and
+// forgets that and the last resend request once nothing is missing.
+func trackGap(conv *domain.Conversation, now time.Time) {
+	if len(conv.State.Skipped) == 0 {
+		conv.GapSinceUTC, conv.ResendAskedUTC = 0, 0
+		return
+	}
+	if conv.GapSinceUTC == 0 {
+		conv.GapSinceUTC = now.Unix()
+	}
+}
+
+// resendDue reports whether conv has been missing messages for at least
+// after, and was not asked to resend them within after, at now.
+func resendDue(conv domain.Conversation, after time.Duration, now time.Time) bool {
+	if conv.GapSinceUTC == 0 || len(conv.State.Skipped) == 0 {
+		return false
+	}
+	cutoff := now.Add(-after).Unix()
+	return conv.GapSinceUTC <= cutoff && conv.ResendAskedUTC <= cutoff
+}
+
+// requestResends asks every peer whose conversation is due (see resendDue)
+// to post the missing messages again. It runs after a receive, whose result
+// it must not change, so failures are logged rather than returned.
+func (s *Service) requestResends(ctx context.Context, passphrase, me string) {
+	after, err := s.conversations.ResendAfter()
+	if err != nil || after == 0 {
+		if err != nil {
+			s.logger.Debug("resend delay not read", "err", err)
+		}
+		return
+	}
+	convs, err := s.ratchetStore.ListConversations()
+	if err != nil {
+		s.logger.Debug("conversations not listed for resends", "err", err)
+		return
+	}
+	now := time.Now()
+	for _, conv := range convs {
+		if !resendDue(conv, after, now) {
+			continue
+		}
+		if err := s.requestResend(ctx, passphrase, me, conv.Peer, after, now); err != nil {
+			s.logger.Debug("resend request not sent", "peer", conv.Peer, "err", err)
+		}
+	}
+}
+
+// requestResend asks peer to post its missing messages again if the
+// conversation is still due once loaded under its lock.
+func (s *Service) requestResend(ctx context.Context, passphrase, me, peer string, after time.Duration, now time.Time) error {
+	unlock, err := s.ratchetStore.LockConversation(peer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	conv, found, err := s.ratchetStore.LoadConversation(peer)
+	if err != nil || !found || !resendDue(conv, after, now) {
+		return err
+	}
+	missing := ratchet.Missing(&conv.State)
+	if len(missing) > maxResendIndices {
+		missing = missing[:maxResendIndices]
+	}
+	conv.ResendAskedUTC = now.Unix()
+	err = s.sendControl(ctx, passphrase, me, &conv, domain.ControlMessage{
+		Type:    controlResendRequest,
+		Missing: missing,
+	})
+	if err != nil {
+		return err
+	}
+	s.logger.Debug("resend requested", "peer", peer, "missing", len(missing))
+	return nil
+}
+
+// handleResend posts again each message the peer lists as missing whose
+// envelope is still in the outbox journal. Envelopes are posted unchanged,
+// so the peer decrypts them with the skipped keys it kept; those past their
+// expiry are left lost. A relay that refuses one is logged and the rest are
+// still tried.
+func (s *Service) handleResend(ctx context.Context, conv *domain.Conversation, msg domain.ControlMessage) error {
+	missing := msg.Missing
+	if len(missing) > maxResendIndices {
+		missing = missing[:maxResendIndices]
+	}
+	sent, err := s.outboxStore.ListSent(conv.Peer)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	resent := 0
+	for _, want := range missing {
+		m, ok := findSent(sent, want)
+		if !ok || (m.ExpiresUTC != 0 && now >= m.ExpiresUTC) {
+			continue
+		}
+		env := *m.Envelope
+		env.ID = ""
+		if _, err := s.relays.Client(m.Relay).SendMessage(ctx, env); err != nil {
+			s.logger.Debug("resend failed", "peer", conv.Peer, "n", want.N, "err", err)
+			continue
+		}
+		resent++
+	}
+	s.logger.Debug("resend request answered", "peer", conv.Peer, "asked", len(missing), "resent", resent)
+	return nil
+}
+
+// findSent returns the journalled message whose envelope header is idx.
+func findSent(sent []domain.SentMessage, idx domain.HeaderIndex) (domain.SentMessage, bool) {
+	for _, m := range sent {
+		if m.Envelope != nil && m.Envelope.Header.N == idx.N && bytes.Equal(m.Envelope.Header.DHPub, idx.DHPub) {
+			return m, true
+		}
+	}
+	return domain.SentMessage{}, false
+}
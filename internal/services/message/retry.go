@@ -0,0 +1,289 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+)
+
+// controlHandshakeRetry asks the initiator of a handshake we could not
+// complete, because it names a one-time prekey we no longer hold, to run it
+// again against a fresh bundle. It carries the failed handshake. A failed
+// rekey is answered on the root both sides still hold; a failed first
+// handshake leaves no root, so the request is sealed between the two identity
+// keys instead (see retryCipher).
+const controlHandshakeRetry = "handshake_retry"
+
+// retryAD marks an envelope as a sealed handshake retry rather than a ratchet
+// message.
+var retryAD = []byte("ciphera/handshake-retry-v1")
+
+// isHandshakeRetry reports whether env carries a sealed handshake retry.
+func isHandshakeRetry(env domain.Envelope) bool {
+	return bytes.Equal(env.AD, retryAD)
+}
+
+// retryCipher derives the AEAD sealing handshake retries between priv and
+// peerIK, bound to the failed handshake: the initiator's ephemeral key and
+// both identity keys. Only the two parties can derive it, so a retry is
+// authenticated as well as confidential, and one for an older handshake does
+// not open against the current one.
+func retryCipher(
+	priv domain.X25519Private,
+	peerIK domain.X25519Public,
+	ephemeral, initiatorIK, responderIK domain.X25519Public,
+) (cipher.AEAD, error) {
+	shared, err := crypto.DH(priv, peerIK)
+	if err != nil {
+		return nil, err
+	}
+	salt := slices.Concat(ephemeral[:], initiatorIK[:], responderIK[:])
+	k, err := crypto.HKDF(shared[:], salt, crypto.LabelHandshakeRetry, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(k)
+}
+
+// retryData is the associated data of a sealed retry from one name to
+// another, so a relay cannot redirect it.
+func retryData(from, to string) []byte {
+	return slices.Concat(retryAD, []byte(from), []byte{0}, []byte(to))
+}
+
+// requestHandshakeRetry answers env, the first message of a handshake from
+// env.From naming a one-time prekey we no longer hold, by asking the sender
+// to run the handshake again. The envelope itself can never be decrypted and
+// stays quarantined, as do the rest of the peer's messages on that handshake
+// (see failedHandshake).
+func (s *Service) requestHandshakeRetry(ctx context.Context, passphrase, me string, env domain.Envelope) error {
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return err
+	}
+	from, err := s.sender(passphrase, me, env.From)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(domain.ControlMessage{Type: controlHandshakeRetry, Prekey: env.Prekey})
+	if err != nil {
+		return err
+	}
+	pm := env.Prekey
+	aead, err := retryCipher(id.XPriv, pm.InitiatorIK, pm.Ephemeral, pm.InitiatorIK, id.XPub)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	relay, err := s.relayFor(env.From)
+	if err != nil {
+		return err
+	}
+	_, err = relay.SendMessage(ctx, domain.Envelope{
+		From:      from,
+		To:        env.From,
+		Cipher:    aead.Seal(nonce, nonce, raw, retryData(from, env.From)),
+		AD:        retryAD,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	s.logger.Info("asked peer to retry a handshake naming a missing one-time prekey",
+		"peer", env.From,
+		"opk_id", pm.OPKID,
+	)
+	return nil
+}
+
+// answerMissingOPK asks env's sender to run its handshake again if err, from
+// bootstrapping it, wraps ErrMissingOPK, and returns err. An envelope read
+// without the relay (offline) is only quarantined. A request that cannot be
+// posted is logged; retrying the quarantined envelope posts it again.
+func (s *Service) answerMissingOPK(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	env domain.Envelope,
+	offline bool,
+	err error,
+) error {
+	if offline || !errors.Is(err, ErrMissingOPK) {
+		return err
+	}
+	if rerr := s.requestHandshakeRetry(ctx, passphrase, me, env); rerr != nil {
+		s.logger.Warn("handshake retry not requested", "peer", env.From, "err", rerr)
+	}
+	return err
+}
+
+// failedHandshake reports whether env, from a peer we hold no conversation
+// with, continues a handshake quarantined because it named a one-time prekey
+// we no longer hold: only the first message of a handshake carries it, and
+// the rest share its ratchet key until we reply, which we never will.
+func (s *Service) failedHandshake(env domain.Envelope) (bool, error) {
+	all, err := s.quarantineStore.ListQuarantined()
+	if err != nil {
+		return false, err
+	}
+	for _, q := range all {
+		if q.Envelope.From == env.From && q.Envelope.Prekey != nil &&
+			bytes.Equal(q.Envelope.Header.DHPub, env.Header.DHPub) &&
+			strings.Contains(q.Reason, ErrMissingOPK.Error()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// handleHandshakeRetry handles env, a sealed handshake retry, for conv, the
+// conversation with env.From if found. If it answers the handshake we started
+// and the peer has not confirmed, we run X3DH again against the peer's
+// current bundle, skipping the one-time prekey it lacks, and drop the
+// conversation, so our next message carries the new handshake. The messages
+// sent on the failed one are lost; a local notice of type body.TypeRetry
+// says how many.
+//
+// A retry for any other handshake, or one that does not open, is dropped: it
+// is stale or forged. If the session cannot be renewed the envelope is
+// quarantined, to be retried once the peer's bundle can be fetched.
+func (s *Service) handleHandshakeRetry(
+	ctx context.Context,
+	passphrase string,
+	conv domain.Conversation,
+	found bool,
+	env domain.Envelope,
+) (domain.DecryptedMessage, envelopeResult, error) {
+	ignore := func(reason string) (domain.DecryptedMessage, envelopeResult, error) {
+		s.logger.Debug("ignoring handshake retry", "peer", env.From, "reason", reason)
+		return domain.DecryptedMessage{}, resultControl, nil
+	}
+	if !found || !pendingInitiator(conv) || conv.Rekeys > 0 {
+		return ignore("no handshake of ours is pending")
+	}
+	sess, ok, err := s.sessionService.GetSession(env.From)
+	if err != nil {
+		return domain.DecryptedMessage{}, 0, err
+	}
+	if !ok {
+		return ignore("no session")
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return domain.DecryptedMessage{}, 0, err
+	}
+	aead, err := retryCipher(id.XPriv, sess.PeerIK, sess.InitiatorEK, id.XPub, sess.PeerIK)
+	if err != nil {
+		return domain.DecryptedMessage{}, 0, err
+	}
+	n := aead.NonceSize()
+	if len(env.Cipher) < n {
+		return ignore("malformed")
+	}
+	raw, err := aead.Open(nil, env.Cipher[:n], env.Cipher[n:], retryData(env.From, env.To))
+	if err != nil {
+		return ignore("does not open under the pending handshake")
+	}
+	var msg domain.ControlMessage
+	if err := json.Unmarshal(raw, &msg); err != nil || msg.Type != controlHandshakeRetry || msg.Prekey == nil {
+		return ignore("malformed")
+	}
+
+	if _, err := s.sessionService.RenewSession(ctx, passphrase, env.From, sess.PeerIK); err != nil {
+		return domain.DecryptedMessage{}, 0,
+			&decryptError{peer: env.From, err: fmt.Errorf("renew session for handshake retry: %w", err)}
+	}
+	if _, err := s.ratchetStore.DeleteConversation(env.From); err != nil {
+		return domain.DecryptedMessage{}, 0, err
+	}
+	s.logger.Info("peer could not complete our handshake; renewed the session",
+		"peer", env.From,
+		"opk_id", sess.OPKID,
+		"lost", conv.State.Ns,
+	)
+	return domain.DecryptedMessage{
+		From:      env.From,
+		To:        env.To,
+		Body:      retryNotice(conv.State.Ns),
+		Timestamp: env.Timestamp,
+	}, resultMessage, nil
+}
+
+// handleRekeyRetry runs our rekey of conv again against a fresh bundle after
+// the peer answered, on the root it still holds, that the rekey named a
+// one-time prekey it no longer holds. The peer never switched, so conv goes
+// back to that root first; a retry for any other handshake is ignored. The
+// messages sent on the failed root are lost and reported in the returned
+// notice.
+//
+// If the rekey cannot be run again, conv stays on the old root until the
+// rekey policy next calls for one.
+func (s *Service) handleRekeyRetry(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	conv *domain.Conversation,
+	msg domain.ControlMessage,
+) (*domain.MessageBody, error) {
+	if msg.Prekey == nil || !conv.Initiator || conv.Stale == nil || conv.Confirm != domain.ConfirmPending {
+		s.logger.Debug("ignoring handshake retry", "peer", conv.Peer, "reason", "no rekey of ours is pending")
+		return nil, nil
+	}
+	sess, ok, err := s.sessionService.GetSession(conv.Peer)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || sess.InitiatorEK != msg.Prekey.Ephemeral {
+		s.logger.Debug("ignoring handshake retry", "peer", conv.Peer, "reason", "not our last rekey")
+		return nil, nil
+	}
+
+	lost := conv.State.Ns
+	conv.State = *conv.Stale
+	conv.Stale = nil
+	conv.Confirm = domain.ConfirmOK
+	conv.Rekeys--
+	// rekey saves the conversation as it goes, so save the restored state
+	// first and take back whatever it saved.
+	if err := s.ratchetStore.SaveConversation(conv.Peer, *conv); err != nil {
+		return nil, err
+	}
+	if err := s.rekey(ctx, passphrase, me, *conv, sess); err != nil {
+		s.logger.Warn("rekey retry failed; staying on the current root", "peer", conv.Peer, "err", err)
+	}
+	next, _, err := s.ratchetStore.LoadConversation(conv.Peer)
+	if err != nil {
+		return nil, err
+	}
+	*conv = next
+	notice := retryNotice(lost)
+	return &notice, nil
+}
+
+// retryNotice is the local message body reporting that a handshake of ours
+// was run again and lost messages were sent on it. It is never sent or
+// stored in the history.
+func retryNotice(lost uint32) domain.MessageBody {
+	return domain.MessageBody{
+		Version:     body.Version,
+		ContentType: body.TypeRetry,
+		Metadata:    map[string]string{body.MetaRetryLost: strconv.FormatUint(uint64(lost), 10)},
+	}
+}
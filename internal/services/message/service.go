@@ -2,13 +2,18 @@ package message
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
 	"time"
 
 	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+	"ciphera/internal/protocol/caps"
+	"ciphera/internal/protocol/chunk"
 	"ciphera/internal/protocol/ratchet"
-	"ciphera/internal/protocol/x3dh"
 )
 
 // Service sends and receives messages over the relay using Double Ratchet.
@@ -18,60 +23,349 @@ import (
 //     bootstrap a session, then encrypt with Double Ratchet and post via the relay.
 //   - Receive: fetch envelopes, bootstrap a session if needed using the sender's
 //     PrekeyMessage, decrypt in order, persist ratchet state, then ack processed
-//     messages.
+//     messages. Envelopes that fail to decrypt are quarantined locally.
 type Service struct {
-	idStore        domain.IdentityStore
-	prekeyStore    domain.PrekeyStore
-	ratchetStore   domain.RatchetStore
-	sessionService domain.SessionService
-	relayClient    domain.RelayClient
+	idStore         domain.IdentityStore
+	prekeyStore     domain.PrekeyStore
+	ratchetStore    domain.RatchetStore
+	quarantineStore domain.QuarantineStore
+	contactStore    domain.ContactStore
+	historyStore    domain.HistoryStore
+	attestStore     domain.AttestationStore
+	outboxStore     domain.OutboxStore
+	traceStore      domain.RatchetTraceStore
+	chunkStore      domain.ChunkStore
+	heldStore       domain.HeldStore
+	profileStore    domain.ProfileStore
+	archiveStore    domain.ArchiveStore
+	sessionService  domain.SessionService
+	conversations   domain.ConversationService
+	relays          domain.RelayDirectory
+	logger          *slog.Logger
 }
 
 var (
 	// ErrNoSession indicates there is no stored session with the peer.
 	ErrNoSession = errors.New("no session with peer; run Initiate first")
+	// ErrContactMismatch indicates a first message from a paired contact was
+	// sent from an identity key other than the one received when pairing.
+	ErrContactMismatch = errors.New("sender identity key does not match paired contact")
+	// ErrFingerprintMismatch indicates a first message from a fingerprint
+	// address was sent from an identity key without that fingerprint.
+	ErrFingerprintMismatch = errors.New("sender identity key does not match its fingerprint address")
+	// ErrMissingOPK indicates a handshake named a one-time prekey we no
+	// longer hold; the peer is asked to retry with a fresh bundle.
+	ErrMissingOPK = errors.New("one-time prekey named by the handshake is no longer held")
+	// ErrUnverified indicates the send policy requires a verified peer and the
+	// peer has not been paired.
+	ErrUnverified = errors.New("peer identity not verified; pair with them or use --force")
+	// ErrVerificationChanged indicates the peer's session identity key differs
+	// from the one verified when pairing.
+	ErrVerificationChanged = errors.New("peer identity changed since verification; pair again or use --force")
+	// ErrMissingCapability indicates the peer's bundle does not advertise a
+	// capability the message's content type needs.
+	ErrMissingCapability = errors.New("peer lacks a capability this content type needs")
+	// ErrHeaderMAC indicates an envelope's header MAC did not verify, so it
+	// was rejected without being decrypted.
+	ErrHeaderMAC = errors.New("envelope header failed authentication")
+	// ErrTooLarge indicates a message too large for one envelope under the
+	// fail oversize policy.
+	ErrTooLarge = errors.New("message too large for one relay envelope")
 )
 
-// New constructs a Message Service with the given stores and relay client.
+// errNoRatchet is the quarantine reason for an envelope that continues a
+// conversation we hold a session for but no ratchet state.
+var errNoRatchet = errors.New("no ratchet state for this conversation; ask the peer to run start-session --reset")
+
+// New constructs a Message Service with the given stores and relay directory.
+//
+// If logger is nil, log output is discarded.
 func New(
 	idStore domain.IdentityStore,
 	prekeyStore domain.PrekeyStore,
 	ratchetStore domain.RatchetStore,
+	quarantineStore domain.QuarantineStore,
+	contactStore domain.ContactStore,
+	historyStore domain.HistoryStore,
+	attestStore domain.AttestationStore,
+	outboxStore domain.OutboxStore,
+	traceStore domain.RatchetTraceStore,
+	chunkStore domain.ChunkStore,
+	heldStore domain.HeldStore,
+	profileStore domain.ProfileStore,
+	archiveStore domain.ArchiveStore,
 	sessionService domain.SessionService,
-	relayClient domain.RelayClient,
+	conversations domain.ConversationService,
+	relays domain.RelayDirectory,
+	logger *slog.Logger,
 ) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
 	return &Service{
-		idStore:        idStore,
-		prekeyStore:    prekeyStore,
-		ratchetStore:   ratchetStore,
-		sessionService: sessionService,
-		relayClient:    relayClient,
+		idStore:         idStore,
+		prekeyStore:     prekeyStore,
+		ratchetStore:    ratchetStore,
+		quarantineStore: quarantineStore,
+		contactStore:    contactStore,
+		historyStore:    historyStore,
+		attestStore:     attestStore,
+		outboxStore:     outboxStore,
+		traceStore:      traceStore,
+		chunkStore:      chunkStore,
+		heldStore:       heldStore,
+		profileStore:    profileStore,
+		archiveStore:    archiveStore,
+		sessionService:  sessionService,
+		conversations:   conversations,
+		relays:          relays,
+		logger:          logger,
 	}
 }
 
-// Send encrypts and posts plaintext.
+// Send encodes body (see package body), encrypts it and posts it.
+//
+// The peer's send policy is checked first (see checkSendPolicy), then that
+// the peer advertises any capability the content type needs (see
+// checkPeerCaps); force overrides both.
 //
 // If this is the first message to a peer (no stored conversation), a PrekeyMessage
 // is attached so the receiver can establish a Double Ratchet session using X3DH.
 // Subsequent messages omit PrekeyMessage and use the existing ratchet state.
+// The envelope is posted to the relay the peer's bundle was fetched from, and
+// the message is then recorded in the local history and, with the sequence
+// number the relay assigned, in the outbox journal. A non-zero expires tags
+// the envelope so the relay drops it if the peer has not fetched it in time.
+//
+// A body too large for one envelope is sent as chunk messages (see package
+// chunk) unless the oversize policy is OversizeFail; the peer must then
+// advertise the chunks capability. It is recorded in the history once.
 func (s *Service) SendMessage(
 	ctx context.Context,
 	passphrase string,
 	fromUsername string,
 	toUsername string,
-	plaintext []byte,
+	msg domain.MessageBody,
+	force bool,
+	expires time.Duration,
 ) error {
-	sess, ok, err := s.sessionService.GetSession(toUsername)
+	plaintext, err := body.Encode(msg)
 	if err != nil {
 		return err
 	}
+	parts, err := s.split(msg.ContentType, plaintext)
+	if err != nil {
+		return err
+	}
+	// A failed rekey leaves the current root in use; it is retried on the
+	// next send.
+	if err := s.maybeRekey(ctx, passphrase, fromUsername, toUsername); err != nil {
+		s.logger.Debug("rekey failed", "peer", toUsername, "err", err)
+	}
+	var env domain.Envelope
+	for i, p := range parts {
+		if env, err = s.post(ctx, passphrase, fromUsername, toUsername, p, force, expires); err != nil {
+			if len(parts) > 1 {
+				return fmt.Errorf("sending part %d of %d: %w", i+1, len(parts), err)
+			}
+			return err
+		}
+	}
+	if msg.Version == 0 {
+		msg.Version = body.Version // as Encode wrote it
+	}
+	s.record(passphrase, domain.HistoryEntry{
+		Peer:      toUsername,
+		Direction: domain.HistoryOut,
+		Body:      msg,
+		SentUTC:   env.Timestamp,
+	})
+	return nil
+}
+
+// part is one envelope's worth of a message: its content type and encoded
+// body.
+type part struct {
+	contentType string
+	plaintext   []byte
+}
+
+// split returns the envelopes needed to send plaintext: itself, or its chunk
+// messages if it is too large for one and the oversize policy allows.
+func (s *Service) split(contentType string, plaintext []byte) ([]part, error) {
+	if !chunk.Oversize(plaintext) {
+		return []part{{contentType, plaintext}}, nil
+	}
+	policy, err := s.conversations.OversizePolicy()
+	if err != nil {
+		return nil, err
+	}
+	if policy == domain.OversizeFail {
+		return nil, fmt.Errorf("%w: %d bytes (oversize policy is fail)", ErrTooLarge, len(plaintext))
+	}
+	bodies, err := chunk.Split(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]part, len(bodies))
+	for i, b := range bodies {
+		if out[i].plaintext, err = body.Encode(b); err != nil {
+			return nil, err
+		}
+		out[i].contentType = b.ContentType
+	}
+	s.logger.Debug("message split into chunks", "bytes", len(plaintext), "parts", len(out))
+	return out, nil
+}
+
+// post seals p for toUsername, saves the advanced conversation, posts the
+// envelope to the peer's relay and journals it, all under the peer's
+// conversation lock.
+func (s *Service) post(
+	ctx context.Context,
+	passphrase string,
+	fromUsername string,
+	toUsername string,
+	p part,
+	force bool,
+	expires time.Duration,
+) (domain.Envelope, error) {
+	// Held until the envelope is posted, so a concurrent send or receive
+	// cannot take the same ratchet step and reuse its message key.
+	unlock, err := s.ratchetStore.LockConversation(toUsername)
+	if err != nil {
+		return domain.Envelope{}, err
+	}
+	defer unlock()
+
+	sess, conv, env, step, err := s.seal(passphrase, fromUsername, toUsername, p.contentType, p.plaintext, force)
+	if err != nil {
+		return domain.Envelope{}, err
+	}
+	s.observeSent(&conv, len(env.Cipher))
+	if expires > 0 {
+		env.ExpiresUTC = time.Unix(env.Timestamp, 0).Add(expires).Unix()
+	}
+
+	// Persist updated ratchet state before sending to avoid message loss if we crash.
+	if err := s.ratchetStore.SaveConversation(toUsername, conv); err != nil {
+		return domain.Envelope{}, err
+	}
+	s.recordStep(passphrase, toUsername, step)
+
+	s.logger.Debug("sending message",
+		"peer", toUsername,
+		"n", env.Header.N,
+		"pn", env.Header.PN,
+		"has_prekey", env.Prekey != nil,
+		"server", sess.Relay,
+	)
+	seq, err := s.relays.Client(sess.Relay).SendMessage(ctx, env)
+	if err != nil {
+		return domain.Envelope{}, err
+	}
+	s.saveUnanswered(&conv)
+	s.journal(toUsername, domain.SentMessage{
+		Seq:         seq,
+		Relay:       sess.Relay,
+		SentUTC:     env.Timestamp,
+		ExpiresUTC:  env.ExpiresUTC,
+		ContentType: p.contentType,
+		Size:        len(env.Cipher),
+		Envelope:    &env,
+	})
+	return env, nil
+}
+
+// PreviewMessage runs SendMessage up to the point of posting: it checks the
+// send policy, encrypts body and builds the envelope, then discards the
+// advanced ratchet state instead of saving it. Nothing is sent.
+//
+// Because the state is discarded, the next real send reuses the message key
+// used here. The preview therefore reports the ciphertext size only and
+// clears Envelope.Cipher, so no two ciphertexts under one key ever leave the
+// process. A body that would be sent in chunks is previewed by its first
+// chunk, with Parts set.
+func (s *Service) PreviewMessage(
+	passphrase string,
+	fromUsername string,
+	toUsername string,
+	msg domain.MessageBody,
+	force bool,
+) (domain.MessagePreview, error) {
+	plaintext, err := body.Encode(msg)
+	if err != nil {
+		return domain.MessagePreview{}, err
+	}
+	parts, err := s.split(msg.ContentType, plaintext)
+	if err != nil {
+		return domain.MessagePreview{}, err
+	}
+	sess, _, env, _, err := s.seal(passphrase, fromUsername, toUsername, parts[0].contentType, parts[0].plaintext, force)
+	if err != nil {
+		return domain.MessagePreview{}, err
+	}
+	wire, err := json.Marshal(env)
+	if err != nil {
+		return domain.MessagePreview{}, err
+	}
+
+	p := domain.MessagePreview{
+		Relay:       sess.Relay,
+		BodyBytes:   len(plaintext),
+		CipherBytes: len(env.Cipher),
+		WireBytes:   len(wire),
+	}
+	if len(parts) > 1 {
+		p.Parts = len(parts)
+	}
+	env.Cipher = nil
+	p.Envelope = env
+	return p, nil
+}
+
+// seal checks the send policy and the peer's capabilities for contentType,
+// then encrypts plaintext for toUsername, returning the session, the advanced
+// conversation, the envelope to post and the ratchet step taken. The caller
+// decides whether to persist the conversation, and records the step if it
+// does.
+func (s *Service) seal(
+	passphrase string,
+	fromUsername string,
+	toUsername string,
+	contentType string,
+	plaintext []byte,
+	force bool,
+) (domain.Session, domain.Conversation, domain.Envelope, domain.RatchetStep, error) {
+	sess, ok, err := s.sessionService.GetSession(toUsername)
+	if err != nil {
+		return domain.Session{}, domain.Conversation{}, domain.Envelope{}, domain.RatchetStep{}, err
+	}
 	if !ok {
-		return ErrNoSession
+		archived, err := s.archiveStore.Archived(toUsername)
+		if err != nil {
+			return domain.Session{}, domain.Conversation{}, domain.Envelope{}, domain.RatchetStep{}, err
+		}
+		if archived {
+			return domain.Session{}, domain.Conversation{}, domain.Envelope{}, domain.RatchetStep{},
+				fmt.Errorf("%w: %q", domain.ErrArchived, toUsername)
+		}
+		return domain.Session{}, domain.Conversation{}, domain.Envelope{}, domain.RatchetStep{}, ErrNoSession
+	}
+	if err := s.checkSendPolicy(sess, force); err != nil {
+		return domain.Session{}, domain.Conversation{}, domain.Envelope{}, domain.RatchetStep{}, err
+	}
+	if err := s.checkPeerCaps(sess, contentType, force); err != nil {
+		return domain.Session{}, domain.Conversation{}, domain.Envelope{}, domain.RatchetStep{}, err
 	}
 
 	conv, found, err := s.ratchetStore.LoadConversation(toUsername)
 	if err != nil {
-		return err
+		return domain.Session{}, domain.Conversation{}, domain.Envelope{}, domain.RatchetStep{}, err
+	}
+	from, err := s.sender(passphrase, fromUsername, toUsername)
+	if err != nil {
+		return domain.Session{}, domain.Conversation{}, domain.Envelope{}, domain.RatchetStep{}, err
 	}
 
 	var prekey *domain.PrekeyMessage
@@ -84,44 +378,53 @@ func (s *Service) SendMessage(
 		//   - InitiatorIK: our identity public key so the receiver can authenticate us.
 		//   - Ephemeral: our X25519 ephemeral public used during X3DH.
 		//   - SPKID/OPKID: which signed/one-time prekey we target on the receiver.
+		//   - Suite: the cipher suite the session was negotiated with.
 		id, err := s.idStore.LoadIdentity(passphrase)
 		if err != nil {
-			return err
+			return domain.Session{}, domain.Conversation{}, domain.Envelope{}, domain.RatchetStep{}, err
 		}
-		st, err := ratchet.InitAsInitiator(sess.RootKey, id.XPriv, id.XPub, sess.PeerIK)
+		st, err := ratchet.InitAsInitiator(sess.RootKey, id.XPriv, id.XPub, sess.PeerIK, sess.Suite)
 		if err != nil {
-			return err
+			return domain.Session{}, domain.Conversation{}, domain.Envelope{}, domain.RatchetStep{}, err
 		}
-		conv = domain.Conversation{Peer: toUsername, State: st}
+		conv = domain.Conversation{
+			Peer:       toUsername,
+			State:      st,
+			Initiator:  true,
+			PeerIK:     sess.PeerIK,
+			StartedUTC: time.Now().Unix(),
+		}
+		s.logger.Debug("conversation initialised as initiator", "peer", toUsername)
 
 		prekey = &domain.PrekeyMessage{
 			InitiatorIK: id.XPub,
 			Ephemeral:   sess.InitiatorEK,
 			SPKID:       sess.SPKID,
 			OPKID:       sess.OPKID,
+			Suite:       sess.Suite,
 		}
 	}
 
 	// Encrypt the payload using the current ratchet state.
+	before := stepState(conv.State)
 	header, ct, err := ratchet.Encrypt(&conv.State, nil, plaintext)
 	if err != nil {
-		return err
-	}
-
-	// Persist updated ratchet state before sending to avoid message loss if we crash.
-	if err := s.ratchetStore.SaveConversation(toUsername, conv); err != nil {
-		return err
+		return domain.Session{}, domain.Conversation{}, domain.Envelope{}, domain.RatchetStep{}, err
 	}
+	conv.SinceRekey++
 
 	env := domain.Envelope{
-		From:      fromUsername,
+		From:      from,
 		To:        toUsername,
 		Header:    header,
 		Cipher:    ct,
 		Prekey:    prekey, // present only for the first message of a conversation
 		Timestamp: time.Now().Unix(),
+		HeaderMAC: ratchet.HeaderMAC(conv.State.Suite, conv.State.HeaderKey, from, toUsername, nil, header),
 	}
-	return s.relayClient.SendMessage(ctx, env)
+	after := stepState(conv.State)
+	step := domain.RatchetStep{Op: domain.RatchetEncrypt, Header: header, Before: before, After: &after}
+	return sess, conv, env, step, nil
 }
 
 // Receive fetches pending messages and decrypts them.
@@ -131,109 +434,539 @@ func (s *Service) SendMessage(
 // If bootstrapping prerequisites are not met, processing stops and remaining
 // envelopes are left queued.
 //
-// We track how many envelopes were processed successfully and ack only that
-// count. This avoids acknowledging messages we did not handle (for example,
-// if a mid-stream decrypt error occurs).
+// We track which envelopes were processed and ack only those IDs. An
+// envelope that fails to decrypt is quarantined locally rather than blocking
+// the queue: the ratchet state is only persisted after a successful decrypt,
+// so later envelopes from the same peer can still be processed safely. The
+// decrypted messages are still returned alongside an ErrQuarantined error.
+//
+// Messages the receive filters refuse are held for review before they are
+// recorded or returned (see screen); the error then wraps ErrHeld.
+//
+// Control messages (such as session confirmations) are consumed here and not
+// returned. After bootstrapping as responder we send a confirmation back to
+// the initiator carrying both identity fingerprints.
+//
+// An envelope whose header MAC does not verify is dropped without being
+// decrypted or quarantined (see authenticHeader); the error then wraps
+// ErrHeaderMAC.
+//
+// A new session the session policy does not accept outright (see acceptMode)
+// is quarantined until the user accepts it, and the error wraps
+// ErrSessionPending; or it is dropped unread, and the error wraps
+// ErrSessionRefused.
+//
+// Envelopes from a peer whose conversation is archived are quarantined until
+// it is unarchived, and the error wraps domain.ErrArchived.
+//
+// The count of envelopes the relay dropped unfetched because they expired is
+// reported alongside the messages; their contents are gone. Messages we sent
+// that the relay reports expired before the peer fetched them are marked in
+// the outbox journal and reported too (see noteUndelivered).
 func (s *Service) ReceiveMessage(
 	ctx context.Context,
 	passphrase string,
 	me string,
 	limit int,
-) ([]domain.DecryptedMessage, error) {
-	envs, err := s.relayClient.FetchMessages(ctx, me, limit)
+) ([]domain.DecryptedMessage, domain.ReceiveReport, error) {
+	id, err := s.idStore.LoadIdentity(passphrase)
 	if err != nil {
-		return nil, err
+		return nil, domain.ReceiveReport{}, err
 	}
+	envs, fetched, err := s.relays.Client("").FetchMessages(ctx, me, limit, signFetch(id, me, "/msg/"+me, fetchQuery(limit, 0)))
+	if err != nil {
+		return nil, domain.ReceiveReport{}, err
+	}
+	s.logger.Debug("fetched envelopes",
+		"user", me,
+		"count", len(envs),
+		"expired", fetched.Expired,
+		"expired_sent", len(fetched.ExpiredSent),
+	)
+	rep := domain.ReceiveReport{Expired: fetched.Expired, Undelivered: s.noteUndelivered(fetched.ExpiredSent)}
+	out, _, err := s.receive(ctx, passphrase, me, envs)
+	return out, rep, err
+}
+
+// receive processes fetched envelopes in order and acks those it processed,
+// as described for ReceiveMessage. It also returns how many envelopes were
+// processed; the rest stay queued at the relay.
+func (s *Service) receive(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	envs []domain.Envelope,
+) ([]domain.DecryptedMessage, int, error) {
+	filters, err := s.conversations.ReceiveFilters()
+	if err != nil {
+		return nil, 0, err
+	}
+
 	out := make([]domain.DecryptedMessage, 0, len(envs))
 	processed := 0
+	quarantined := 0
+	rejected := 0
+	refused := 0
+	var mismatched, pending, archived []string
 
 	for i, env := range envs {
-		conv, found, err := s.ratchetStore.LoadConversation(env.From)
-		if err != nil {
-			return out, err
-		}
-
-		if !found {
-			// First message from this peer: bootstrap using the PrekeyMessage.
-			//
-			// Steps:
-			//   1) Validate prerequisites (Prekey present and DH header present).
-			//   2) Load our identity.
-			//   3) Resolve the sender's public from the header.
-			//   4) Load our signed prekey by ID; optionally consume a one-time prekey.
-			//   5) Derive the root key (X3DH) and initialise Double Ratchet as responder.
-			//
-			// If prerequisites are missing, break and leave remaining envelopes queued.
-			if env.Prekey == nil || len(env.Header.DHPub) != 32 {
-				break // leave the rest queued
+		msg, res, err := s.processEnvelope(ctx, passphrase, me, env, false)
+		var derr *decryptError
+		if errors.As(err, &derr) {
+			if err := s.quarantine(env, derr); err != nil {
+				return out, processed, err
 			}
-			id, err := s.idStore.LoadIdentity(passphrase)
-			if err != nil {
-				return out, err
-			}
-			var senderPub domain.X25519Public
-			copy(senderPub[:], env.Header.DHPub)
+			quarantined++
+			processed = i + 1
+			continue
+		}
+		if err != nil {
+			return out, processed, err
+		}
 
-			if env.Prekey.SPKID == "" {
-				return out, fmt.Errorf("missing SPKID in prekey message")
+		if res == resultDeferred {
+			s.logger.Debug("no conversation and no prekey message; leaving queued",
+				"peer", env.From,
+				"remaining", len(envs)-i,
+			)
+			break // leave the rest queued
+		}
+		switch res {
+		case resultMessage:
+			out = append(out, msg)
+		case resultMismatch:
+			mismatched = append(mismatched, env.From)
+		case resultRejected:
+			rejected++
+		case resultPending:
+			if err := s.quarantine(env, ErrSessionPending); err != nil {
+				return out, processed, err
 			}
-			spkPriv, _, _, okSPK, err := s.prekeyStore.LoadSignedPrekey(env.Prekey.SPKID)
-			if err != nil {
-				return out, err
+			if !slices.Contains(pending, env.From) {
+				pending = append(pending, env.From)
 			}
-			if !okSPK {
-				return out, fmt.Errorf("signed prekey %q not found", env.Prekey.SPKID)
+		case resultRefused:
+			refused++
+		case resultArchived:
+			if err := s.quarantine(env, domain.ErrArchived); err != nil {
+				return out, processed, err
 			}
-
-			var opkPriv *domain.X25519Private
-			if env.Prekey.OPKID != "" {
-				p, _, okOPK, err := s.prekeyStore.ConsumeOneTimePrekey(env.Prekey.OPKID)
-				if err != nil {
-					return out, err
-				}
-				if okOPK {
-					opkPriv = &p
-				}
+			if !slices.Contains(archived, env.From) {
+				archived = append(archived, env.From)
 			}
+		}
+		processed = i + 1
+	}
+
+	out, held := s.screen(passphrase, filters, out)
+	s.recordReceived(passphrase, out)
+
+	// Ack only what we processed, by ID. If nothing, do nothing.
+	if ids := envelopeIDs(envs[:processed]); len(ids) > 0 {
+		if err := s.relays.Client("").AckMessages(ctx, me, ids); err != nil {
+			return out, processed, fmt.Errorf("ack %d messages: %w", len(ids), err)
+		}
+		s.logger.Debug("acknowledged envelopes", "user", me, "count", len(ids))
+	}
+	s.requestResends(ctx, passphrase, me)
+	var errs []error
+	if quarantined > 0 {
+		errs = append(errs, fmt.Errorf("%w: %d envelope(s)", ErrQuarantined, quarantined))
+	}
+	if held > 0 {
+		errs = append(errs, fmt.Errorf("%w: %d message(s)", ErrHeld, held))
+	}
+	if rejected > 0 {
+		errs = append(errs, fmt.Errorf("%w: %d envelope(s) dropped", ErrHeaderMAC, rejected))
+	}
+	if len(mismatched) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %v", ErrConfirmMismatch, mismatched))
+	}
+	if len(pending) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %v", ErrSessionPending, pending))
+	}
+	if refused > 0 {
+		errs = append(errs, fmt.Errorf("%w: %d envelope(s) dropped", ErrSessionRefused, refused))
+	}
+	if len(archived) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %v", domain.ErrArchived, archived))
+	}
+	return out, processed, errors.Join(errs...)
+}
+
+// authenticHeader reports whether env's header MAC verifies under the header
+// key of conv's state or of its stale state. An envelope without a MAC is
+// accepted until the peer has sent one that verified, so peers whose clients
+// predate header MACs keep working.
+func authenticHeader(conv domain.Conversation, env domain.Envelope) bool {
+	if env.HeaderMAC == nil {
+		return !conv.HeaderMACs
+	}
+	for _, st := range []*domain.RatchetState{&conv.State, conv.Stale} {
+		if st != nil && ratchet.VerifyHeaderMAC(st.Suite, st.HeaderKey, env.HeaderMAC, env.From, env.To, env.AD, env.Header) {
+			return true
+		}
+	}
+	return false
+}
+
+// envelopeIDs returns the relay-assigned IDs of envs, skipping envelopes
+// without one.
+func envelopeIDs(envs []domain.Envelope) []string {
+	ids := make([]string, 0, len(envs))
+	for _, env := range envs {
+		if env.ID != "" {
+			ids = append(ids, env.ID)
+		}
+	}
+	return ids
+}
 
-			rk, err := x3dh.ResponderRoot(id, spkPriv, opkPriv, *env.Prekey)
+// envelopeResult describes what processEnvelope did with an envelope.
+type envelopeResult int
+
+const (
+	resultMessage  envelopeResult = iota // user content was decrypted
+	resultControl                        // a control message was consumed
+	resultMismatch                       // a session confirmation did not match
+	resultDeferred                       // no conversation yet; leave the envelope queued
+	resultRejected                       // the header MAC did not verify; drop the envelope unread
+	resultPending                        // a new session awaits acceptance; quarantine the envelope
+	resultRefused                        // the session policy refuses a new session; drop the envelope unread
+	resultArchived                       // the conversation is archived; quarantine the envelope
+)
+
+// decryptError wraps a ratchet failure for a single envelope. The conversation
+// state is left untouched, so the envelope can be quarantined and skipped.
+type decryptError struct {
+	peer string
+	err  error
+}
+
+func (e *decryptError) Error() string {
+	return fmt.Sprintf("decrypt from %q failed: %v", e.peer, e.err)
+}
+
+func (e *decryptError) Unwrap() error { return e.err }
+
+// processEnvelope bootstraps (if needed), decrypts and persists a single envelope.
+//
+// The sender's conversation lock is held throughout, including for any
+// control message sent in reply. Ratchet state is saved only after a
+// successful decrypt. Decrypt failures are
+// returned as *decryptError; any other error means local state could not be
+// read or written and processing should stop. offline means env arrived
+// without the relay, so failing to post a session confirmation is logged
+// rather than returned.
+func (s *Service) processEnvelope(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	env domain.Envelope,
+	offline bool,
+) (domain.DecryptedMessage, envelopeResult, error) {
+	unlock, err := s.ratchetStore.LockConversation(env.From)
+	if err != nil {
+		return domain.DecryptedMessage{}, 0, err
+	}
+	defer unlock()
+
+	conv, found, err := s.ratchetStore.LoadConversation(env.From)
+	if err != nil {
+		return domain.DecryptedMessage{}, 0, err
+	}
+	bootstrapped := false // state was built from env.Prekey
+	useStale := false     // decrypt with conv.Stale, the peer's losing handshake
+
+	if isHandshakeRetry(env) {
+		return s.handleHandshakeRetry(ctx, passphrase, conv, found, env)
+	}
+
+	if !found {
+		// The peer's conversation is archived rather than new: hold whatever
+		// it sends until it is unarchived instead of leaving it to block the
+		// queue or starting a conversation alongside the archived one.
+		archived, err := s.archiveStore.Archived(env.From)
+		if err != nil {
+			return domain.DecryptedMessage{}, 0, err
+		}
+		if archived {
+			return domain.DecryptedMessage{}, resultArchived, nil
+		}
+	}
+
+	switch {
+	case !found:
+		// First message from this peer: bootstrap using the PrekeyMessage. If
+		// prerequisites are missing, defer and leave the envelope queued,
+		// unless we already hold a session with the peer: then the envelope
+		// continues a ratchet this home never had (e.g. one left behind by a
+		// restored backup) and would block the queue forever.
+		if env.Prekey == nil || len(env.Header.DHPub) != 32 {
+			failed, err := s.failedHandshake(env)
 			if err != nil {
-				return out, fmt.Errorf("x3dh responder root: %w", err)
+				return domain.DecryptedMessage{}, 0, err
+			}
+			if failed {
+				return domain.DecryptedMessage{}, 0, &decryptError{peer: env.From, err: ErrMissingOPK}
 			}
-			st, err := ratchet.InitAsResponder(rk, id.XPriv, id.XPub, senderPub)
+			_, hasSession, err := s.sessionService.GetSession(env.From)
 			if err != nil {
-				return out, err
+				return domain.DecryptedMessage{}, 0, err
+			}
+			if hasSession {
+				return domain.DecryptedMessage{}, 0, &decryptError{peer: env.From, err: errNoRatchet}
 			}
-			conv = domain.Conversation{Peer: env.From, State: st}
+			return domain.DecryptedMessage{}, resultDeferred, nil
 		}
+		// The session policy may hold back sessions from peers we have not
+		// accepted (see acceptMode).
+		switch mode, err := s.acceptMode(env.From); {
+		case err != nil:
+			return domain.DecryptedMessage{}, 0, err
+		case mode == domain.AcceptAsk:
+			return domain.DecryptedMessage{}, resultPending, nil
+		case mode == domain.AcceptNo:
+			return domain.DecryptedMessage{}, resultRefused, nil
+		}
+		st, err := s.bootstrapResponder(passphrase, env.From, *env.Prekey, env.Header.DHPub)
+		if err != nil {
+			return domain.DecryptedMessage{}, 0, s.answerMissingOPK(ctx, passphrase, me, env, offline, err)
+		}
+		conv = domain.Conversation{
+			Peer:       env.From,
+			State:      st,
+			PeerIK:     env.Prekey.InitiatorIK,
+			StartedUTC: time.Now().Unix(),
+		}
+		bootstrapped = true
 
-		// Decrypt using the ratchet state and associated data.
-		plain, err := ratchet.Decrypt(&conv.State, env.AD, env.Header, env.Cipher)
+	case env.Prekey != nil && pendingInitiator(conv):
+		// Both sides initiated at once (see resolveCrossInitiation).
+		keepOurs, ok, err := s.resolveCrossInitiation(passphrase, env)
 		if err != nil {
-			return out, fmt.Errorf("decrypt from %q failed: %w", env.From, err)
+			return domain.DecryptedMessage{}, 0, err
 		}
+		if !ok {
+			break // not the peer we started with; decrypt below fails and quarantines
+		}
+		st, err := s.bootstrapResponder(passphrase, env.From, *env.Prekey, env.Header.DHPub)
+		if err != nil {
+			return domain.DecryptedMessage{}, 0, s.answerMissingOPK(ctx, passphrase, me, env, offline, err)
+		}
+		if keepOurs {
+			conv.Stale = &st
+			useStale = true
+		} else {
+			conv = domain.Conversation{
+				Peer:       env.From,
+				State:      st,
+				PeerIK:     env.Prekey.InitiatorIK,
+				Stats:      conv.Stats,
+				StartedUTC: time.Now().Unix(),
 
-		// Persist updated ratchet state after successful decrypt to advance chains.
+				MessagesSent:     conv.MessagesSent,
+				MessagesReceived: conv.MessagesReceived,
+			}
+		}
+		bootstrapped = true
+	}
+
+	// Junk costs one HMAC: it is dropped before any ratchet step or AEAD open.
+	if !authenticHeader(conv, env) {
+		s.logger.Debug("header authentication failed", "peer", env.From, "n", env.Header.N)
+		return domain.DecryptedMessage{}, resultRejected, nil
+	}
+
+	// Decrypt using the ratchet state and associated data. After winning a
+	// cross-initiation, messages the peer sent on its own handshake before
+	// switching decrypt with the stale state instead.
+	state := &conv.State
+	if useStale {
+		state = conv.Stale
+	}
+	before := snapshot(conv.State)
+	traced := stepState(*state)
+	plain, err := ratchet.Decrypt(state, env.AD, env.Header, env.Cipher)
+	if err != nil && !bootstrapped && conv.Stale != nil {
+		s.recordStep(passphrase, env.From, decryptStep(env, traced, nil, useStale, err))
+		// Reload so a failed attempt cannot leave the main state half-advanced.
+		if conv, _, err = s.ratchetStore.LoadConversation(env.From); err != nil {
+			return domain.DecryptedMessage{}, 0, err
+		}
+		if conv.Stale.Skipped == nil {
+			conv.Stale.Skipped = make(map[string][]byte)
+		}
+		useStale = true
+		state = conv.Stale
+		traced = stepState(*state)
+		plain, err = ratchet.Decrypt(state, env.AD, env.Header, env.Cipher)
+	}
+	// Recorded before the body is handled, so steps taken for a control
+	// message (such as a wipe receipt) follow the decrypt that caused them.
+	s.recordStep(passphrase, env.From, decryptStep(env, traced, state, useStale, err))
+	if err != nil {
+		s.logger.Debug("decrypt failed", "peer", env.From, "n", env.Header.N, "err", err)
+		return domain.DecryptedMessage{}, 0, &decryptError{peer: env.From, err: err}
+	}
+	s.logger.Debug("decrypted message",
+		"peer", env.From,
+		"n", env.Header.N,
+		"pn", env.Header.PN,
+		"control", isControl(env),
+		"stale", useStale,
+		"skipped_keys", len(conv.State.Skipped),
+	)
+	s.observeReceived(&conv, len(env.Cipher), before, useStale)
+	verified := s.verification(conv, env, traced, *state, bootstrapped, useStale)
+	trackGap(&conv, time.Now())
+	if env.HeaderMAC != nil {
+		conv.HeaderMACs = true
+	}
+
+	// User content is a structured body; raw payloads from older clients
+	// decode as legacy text or binary. A body we cannot parse (e.g. a newer
+	// schema version) is quarantined like a decrypt failure so it can be
+	// retried after upgrading.
+	var (
+		msg      domain.MessageBody
+		notice   *domain.MessageBody
+		wiped    bool // the conversation was deleted; there is nothing to save
+		complete bool // msg is not a chunk still waiting for other parts
+	)
+	res := resultMessage
+	if isControl(env) {
+		if notice, err = s.handleControl(ctx, passphrase, me, &conv, plain); err != nil {
+			return domain.DecryptedMessage{}, 0,
+				fmt.Errorf("control message from %q: %w", env.From, err)
+		}
+		res = resultControl
+		switch {
+		case conv.Confirm == domain.ConfirmMismatch:
+			res = resultMismatch
+		case notice != nil:
+			res = resultMessage
+			msg = *notice
+			wiped = msg.ContentType == body.TypeWipe &&
+				(msg.Metadata[body.MetaWipeResult] == body.WipeResultWiped ||
+					msg.Metadata[body.MetaWipeResult] == body.WipeResultConfirmed)
+		}
+	} else if msg, err = body.Decode(plain); err != nil {
+		return domain.DecryptedMessage{}, 0, &decryptError{peer: env.From, err: err}
+	} else if msg, complete, err = s.collectChunk(passphrase, env.From, msg); err != nil {
+		return domain.DecryptedMessage{}, 0, err
+	} else if !complete {
+		// Held until the other parts arrive; there is nothing to show yet.
+		res = resultControl
+	} else if body.Local(msg.ContentType) {
+		// Notices are made locally; a peer must not be able to fake one.
+		return domain.DecryptedMessage{}, 0, &decryptError{peer: env.From, err: ErrLocalContentType}
+	} else {
+		conv.SinceRekey++
+	}
+
+	// Persist updated ratchet state after successful decrypt to advance chains.
+	if !wiped {
 		if err := s.ratchetStore.SaveConversation(env.From, conv); err != nil {
-			return out, fmt.Errorf("save conversation %q: %w", env.From, err)
+			return domain.DecryptedMessage{}, 0, fmt.Errorf("save conversation %q: %w", env.From, err)
 		}
+	}
 
-		out = append(out, domain.DecryptedMessage{
-			From:      env.From,
-			To:        env.To,
-			Plaintext: plain,
-			Timestamp: env.Timestamp,
-		})
-		processed = i + 1
+	if bootstrapped && env.Prekey.OPKID != "" {
+		if _, _, _, err := s.prekeyStore.ConsumeOneTimePrekey(env.Prekey.OPKID); err != nil {
+			return domain.DecryptedMessage{}, 0, err
+		}
 	}
 
-	// Ack only what we processed successfully. If zero, do nothing.
-	if processed > 0 {
-		if err := s.relayClient.AckMessages(ctx, me, processed); err != nil {
-			return out, fmt.Errorf("ack %d messages: %w", processed, err)
+	if bootstrapped && !useStale && !wiped {
+		// A successful decrypt of the prekey message proves we derived the same
+		// root key. Tell the initiator which identities we used so they can
+		// check for a divergent handshake before sending real content.
+		err := s.confirmSession(ctx, passphrase, me, &conv, *env.Prekey)
+		if err != nil && !offline {
+			return domain.DecryptedMessage{}, 0,
+				fmt.Errorf("confirm session with %q: %w", env.From, err)
+		}
+		if err != nil {
+			s.logger.Debug("session confirmation not sent", "peer", env.From, "err", err)
+		} else {
+			s.shareProfile(ctx, passphrase, me, &conv)
 		}
 	}
-	return out, nil
+
+	return domain.DecryptedMessage{
+		From:      env.From,
+		To:        env.To,
+		Body:      msg,
+		Timestamp: env.Timestamp,
+
+		Verification: verified,
+	}, res, nil
+}
+
+// checkSendPolicy enforces the peer's send policy. Under
+// SendPolicyRequireVerified, or when replying in a conversation the peer
+// started under the session policy's VerifyBeforeReply, the peer must be a
+// paired contact whose identity key matches the session's. force skips the
+// check but is logged.
+func (s *Service) checkSendPolicy(sess domain.Session, force bool) error {
+	policy, err := s.conversations.EffectiveSendPolicy(sess.Peer)
+	if err != nil {
+		return err
+	}
+	if policy != domain.SendPolicyRequireVerified {
+		reply, err := s.replyNeedsVerification(sess.Peer)
+		if err != nil || !reply {
+			return err
+		}
+		policy = domain.SendPolicyRequireVerified
+	}
+	contact, paired, err := s.contactStore.LoadContact(sess.Peer)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !paired:
+		err = ErrUnverified
+	case contact.IdentityKey != sess.PeerIK:
+		err = ErrVerificationChanged
+	default:
+		return nil
+	}
+	if force {
+		s.logger.Debug("send policy overridden", "peer", sess.Peer, "policy", policy, "reason", err)
+		return nil
+	}
+	return err
+}
+
+// checkPeerCaps refuses a body the peer's bundle says it cannot handle, for
+// example an attachment to a client without the attachments capability.
+// Peers whose bundle predates capabilities are not checked. force skips the
+// check but is logged.
+func (s *Service) checkPeerCaps(sess domain.Session, contentType string, force bool) error {
+	need := caps.Missing(sess.PeerCaps, contentType)
+	if need == "" {
+		return nil
+	}
+	if force {
+		s.logger.Debug("peer capability check overridden", "peer", sess.Peer, "capability", need)
+		return nil
+	}
+	return fmt.Errorf("%w: %s (use --force to send anyway)", ErrMissingCapability, need)
+}
+
+// relayFor returns the client used to reach peer: the relay recorded on our
+// session with them, or the default relay if we have no session.
+func (s *Service) relayFor(peer string) (domain.RelayClient, error) {
+	sess, ok, err := s.sessionService.GetSession(peer)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.relays.Client(""), nil
+	}
+	return s.relays.Client(sess.Relay), nil
 }
 
 // Compile-time assertion that Service implements domain.MessageService.
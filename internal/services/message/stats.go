@@ -0,0 +1,114 @@
+package message
+
+import (
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// ratchetSnapshot is the part of a ratchet state observeReceived compares
+// against after a decrypt.
+type ratchetSnapshot struct {
+	peerDH  domain.X25519Public
+	skipped int
+}
+
+func snapshot(st domain.RatchetState) ratchetSnapshot {
+	return ratchetSnapshot{peerDH: st.PeerDHPub, skipped: len(st.Skipped)}
+}
+
+// statsFor returns conv's counters if statistics collection is on, creating
+// them on first use, or nil if it is off. A failure to read the setting is
+// logged and treated as off, so statistics never block messaging.
+func (s *Service) statsFor(conv *domain.Conversation) *domain.RatchetStats {
+	on, err := s.conversations.CollectStats()
+	if err != nil {
+		s.logger.Warn("reading statistics setting", "err", err)
+		return nil
+	}
+	if !on {
+		return nil
+	}
+	if conv.Stats == nil {
+		conv.Stats = &domain.RatchetStats{}
+	}
+	return conv.Stats
+}
+
+// observeSent counts one outgoing ratchet message of size ciphertext bytes.
+// The conversation's message totals are always kept; Stats only while
+// collection is on. The unanswered count is left to markUnanswered.
+func (s *Service) observeSent(conv *domain.Conversation, size int) {
+	conv.MessagesSent++
+	st := s.statsFor(conv)
+	if st == nil {
+		return
+	}
+	st.Sent++
+	countSize(st, size)
+	st.SkippedMax = max(st.SkippedMax, len(conv.State.Skipped))
+}
+
+// markUnanswered counts one message to conv's peer towards the watchdog's
+// unanswered count. Senders call it only once the relay has accepted the
+// message, so sends that fail while the relay is down or refusing them never
+// make the peer look stuck.
+func markUnanswered(conv *domain.Conversation) {
+	conv.Unanswered++
+	if conv.UnansweredSinceUTC == 0 {
+		conv.UnansweredSinceUTC = time.Now().Unix()
+	}
+}
+
+// saveUnanswered marks conv unanswered after a post the relay accepted and
+// saves it. The message is already out, so a failed save only loses the
+// count and is logged rather than returned.
+func (s *Service) saveUnanswered(conv *domain.Conversation) {
+	markUnanswered(conv)
+	if err := s.ratchetStore.SaveConversation(conv.Peer, *conv); err != nil {
+		s.logger.Warn("unanswered count not saved", "peer", conv.Peer, "error", err)
+	}
+}
+
+// observeReceived counts one message decrypted with conv.State; before is
+// that state as it was ahead of the decrypt. Messages read with the stale
+// state of a lost cross-initiation only count as received. As in observeSent,
+// the message totals are counted whether or not collection is on, and a DH
+// ratchet step clears the unanswered count.
+func (s *Service) observeReceived(conv *domain.Conversation, size int, before ratchetSnapshot, stale bool) {
+	conv.MessagesReceived++
+	stepped := !stale && conv.State.PeerDHPub != before.peerDH
+	if stepped {
+		conv.Unanswered, conv.UnansweredSinceUTC = 0, 0
+	}
+	st := s.statsFor(conv)
+	if st == nil {
+		return
+	}
+	st.Received++
+	countSize(st, size)
+	if stale {
+		return
+	}
+	if stepped {
+		st.DHSteps++
+	}
+	// Decrypting with a stored skipped key removes it; every other path only
+	// adds keys.
+	if len(conv.State.Skipped) < before.skipped {
+		st.OutOfOrder++
+	}
+	st.SkippedMax = max(st.SkippedMax, len(conv.State.Skipped))
+}
+
+// countSize adds one ciphertext of size bytes to the size histogram.
+func countSize(st *domain.RatchetStats, size int) {
+	if len(st.CipherSizes) != len(domain.StatsSizeBounds)+1 {
+		st.CipherSizes = make([]int, len(domain.StatsSizeBounds)+1)
+	}
+	i := 0
+	for i < len(domain.StatsSizeBounds) && size > domain.StatsSizeBounds[i] {
+		i++
+	}
+	st.CipherSizes[i]++
+}
@@ -0,0 +1,75 @@
+package message
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// stepDigestSize is how many bytes of a key's SHA-256 a ratchet step keeps:
+// enough to tell keys apart, far too little to recover one.
+const stepDigestSize = 8
+
+// stepState returns st as a ratchet step records it, with secret keys
+// replaced by digests.
+func stepState(st domain.RatchetState) domain.RatchetStepState {
+	return domain.RatchetStepState{
+		RootKey:   keyDigest(st.RootKey),
+		SendCK:    keyDigest(st.SendCK),
+		RecvCK:    keyDigest(st.RecvCK),
+		HeaderKey: keyDigest(st.HeaderKey),
+		DHPub:     st.DHPub,
+		PeerDHPub: st.PeerDHPub,
+		Ns:        st.Ns,
+		Nr:        st.Nr,
+		PN:        st.PN,
+		Skipped:   len(st.Skipped),
+	}
+}
+
+// keyDigest returns a short hex digest of k, or "" if k is empty.
+func keyDigest(k []byte) string {
+	if len(k) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(k)
+	return hex.EncodeToString(sum[:stepDigestSize])
+}
+
+// recordStep adds step to peer's ratchet trace if ratchet debugging is on.
+// Like statistics, the trace never blocks messaging: failures are logged.
+func (s *Service) recordStep(passphrase, peer string, step domain.RatchetStep) {
+	on, err := s.conversations.RatchetDebug()
+	if err != nil {
+		s.logger.Warn("reading ratchet debug setting", "err", err)
+		return
+	}
+	if !on {
+		return
+	}
+	step.TimeUTC = time.Now().Unix()
+	if err := s.traceStore.AppendRatchetStep(passphrase, peer, step); err != nil {
+		s.logger.Warn("ratchet step not recorded", "peer", peer, "op", step.Op, "err", err)
+	}
+}
+
+// decryptStep returns the step for a decrypt of env that started from
+// before and left state as after, or failed with err.
+func decryptStep(env domain.Envelope, before domain.RatchetStepState, after *domain.RatchetState, stale bool, err error) domain.RatchetStep {
+	step := domain.RatchetStep{
+		Op:      domain.RatchetDecrypt,
+		Stale:   stale,
+		Control: isControl(env),
+		Header:  env.Header,
+		Before:  before,
+	}
+	if err != nil {
+		step.Err = err.Error()
+		return step
+	}
+	st := stepState(*after)
+	step.After = &st
+	return step
+}
@@ -0,0 +1,57 @@
+package message
+
+import (
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+)
+
+// verification describes how env was authenticated, for the message's
+// report. before is state, the ratchet state env was opened with, as it was
+// ahead of the decrypt. A failure to read the peer's contact is logged and
+// leaves the report unpaired, so the report never blocks receiving. Without
+// a recorded identity key there is nothing to compare a contact's with.
+func (s *Service) verification(
+	conv domain.Conversation,
+	env domain.Envelope,
+	before domain.RatchetStepState,
+	state domain.RatchetState,
+	bootstrapped, stale bool,
+) *domain.MessageVerification {
+	v := &domain.MessageVerification{
+		EnvelopeID:        env.ID,
+		IdentityKey:       conv.PeerIK,
+		Suite:             state.Suite,
+		SessionStartedUTC: conv.StartedUTC,
+		RekeyedUTC:        conv.RekeyedUTC,
+		NewSession:        bootstrapped,
+		Stale:             stale,
+		HeaderMAC:         env.HeaderMAC != nil,
+		N:                 env.Header.N,
+		PN:                env.Header.PN,
+		RatchetStep:       state.PeerDHPub != before.PeerDHPub && !bootstrapped,
+	}
+	if v.Suite == "" {
+		v.Suite = crypto.DefaultSuite
+	}
+	// As in observeReceived: opening with a stored skipped key removes it,
+	// and every other path only adds keys.
+	if after := len(state.Skipped); after < before.Skipped {
+		v.SkippedKeyUsed = true
+	} else {
+		v.KeysSkipped = after - before.Skipped
+	}
+
+	if conv.PeerIK == (domain.X25519Public{}) {
+		return v
+	}
+	contact, paired, err := s.contactStore.LoadContact(env.From)
+	switch {
+	case err != nil:
+		s.logger.Warn("reading contact for verification report", "peer", env.From, "err", err)
+	case paired && contact.IdentityKey == conv.PeerIK:
+		v.Paired = true
+	case paired:
+		v.KeyChanged = true
+	}
+	return v
+}
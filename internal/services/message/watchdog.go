@@ -0,0 +1,81 @@
+package message
+
+import (
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// watchdogEvery is how often FollowMessages checks for stuck conversations.
+const watchdogEvery = time.Minute
+
+// watchdog flags conversations whose peer has not taken a ratchet step for
+// as long as the watchdog policy allows, which hints that its state for the
+// conversation is broken: our sends succeed, but nothing we send is read.
+// Each episode is reported once; it ends when the peer steps again.
+type watchdog struct {
+	next   time.Time        // when to check again
+	warned map[string]int64 // peer -> UnansweredSinceUTC already reported
+}
+
+func newWatchdog() *watchdog {
+	return &watchdog{warned: make(map[string]int64)}
+}
+
+// stuckDue reports whether conv has reached either limit of p at now.
+func stuckDue(conv domain.Conversation, p domain.WatchdogPolicy, now time.Time) bool {
+	if conv.Unanswered == 0 {
+		return false
+	}
+	if p.Messages > 0 && conv.Unanswered >= p.Messages {
+		return true
+	}
+	return p.Days > 0 && conv.UnansweredSinceUTC != 0 &&
+		now.Unix()-conv.UnansweredSinceUTC >= int64(p.Days)*24*60*60
+}
+
+// checkStuck returns the conversations newly found stuck, logging a warning
+// for each, if a check is due. Failures are logged and skip the check, so
+// the watchdog never stops a receive loop.
+func (s *Service) checkStuck(w *watchdog, now time.Time) []domain.StuckConversation {
+	if now.Before(w.next) {
+		return nil
+	}
+	w.next = now.Add(watchdogEvery)
+	policy, err := s.conversations.WatchdogPolicy()
+	if err != nil {
+		s.logger.Warn("reading watchdog policy", "err", err)
+		return nil
+	}
+	if !policy.Enabled() {
+		return nil
+	}
+	convs, err := s.ratchetStore.ListConversations()
+	if err != nil {
+		s.logger.Warn("listing conversations for the watchdog", "err", err)
+		return nil
+	}
+	var out []domain.StuckConversation
+	for _, c := range convs {
+		if !stuckDue(c, policy, now) {
+			delete(w.warned, c.Peer)
+			continue
+		}
+		if w.warned[c.Peer] == c.UnansweredSinceUTC {
+			continue
+		}
+		w.warned[c.Peer] = c.UnansweredSinceUTC
+		s.logger.Warn("conversation may be stuck",
+			"peer", c.Peer,
+			"unanswered", c.Unanswered,
+			"since_utc", c.UnansweredSinceUTC,
+			"initiator", c.Initiator,
+		)
+		out = append(out, domain.StuckConversation{
+			Peer:       c.Peer,
+			Unanswered: c.Unanswered,
+			SinceUTC:   c.UnansweredSinceUTC,
+		})
+	}
+	return out
+}
@@ -0,0 +1,271 @@
+package message
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/body"
+)
+
+const (
+	// controlWipeRequest asks the peer to delete the conversation.
+	controlWipeRequest = "wipe_request"
+	// controlWipeReceipt answers a wipe request with WipeResult wiped or refused.
+	controlWipeReceipt = "wipe_receipt"
+
+	// wipeContext separates wipe signatures from every other Ed25519 signature.
+	wipeContext = "ciphera/wipe-v1"
+
+	// maxWipeIDLen bounds the wipe ID a peer may send.
+	maxWipeIDLen = 64
+)
+
+var (
+	// ErrNoConversation indicates there is no ratchet state with the peer yet.
+	ErrNoConversation = errors.New("no conversation with peer; send a message first")
+	// ErrWipeUnverified indicates the peer's signing key is unknown, so their
+	// wipe receipt could not be checked.
+	ErrWipeUnverified = errors.New("peer's signing key is unknown; pair with them before requesting a wipe")
+	// ErrLocalContentType indicates a peer sent a body of a type only this
+	// client may create, such as a wipe notice.
+	ErrLocalContentType = errors.New("content type is local only")
+)
+
+// RequestWipe sends peer a signed request to delete the conversation: the
+// shared history, ratchet state and session. Our own copy is deleted once the
+// peer's receipt says it wiped too; a refusal leaves both sides untouched.
+func (s *Service) RequestWipe(ctx context.Context, passphrase, me, peer string) error {
+	unlock, err := s.ratchetStore.LockConversation(peer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	conv, found, err := s.ratchetStore.LoadConversation(peer)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNoConversation
+	}
+	peerIK, _, ok, err := s.peerKeys(conv)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrWipeUnverified
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return err
+	}
+
+	msg := domain.ControlMessage{Type: controlWipeRequest, WipeID: rand.Text()}
+	msg.Sig = crypto.SignContext(id.EdPriv, wipeContext, wipeStatement(msg, id.XPub, peerIK))
+	conv.WipeRequested = msg.WipeID
+	if err := s.sendControl(ctx, passphrase, me, &conv, msg); err != nil {
+		return err
+	}
+	s.logger.Debug("wipe requested", "peer", peer)
+	return nil
+}
+
+// ResetConversation deletes the ratchet state with peer, keeping the session,
+// history and preferences, so the next message starts a fresh handshake. The
+// peer must have lost its own state (e.g. restored from a backup), since it
+// refuses a new handshake while it still holds the old one. It reports whether
+// there was a conversation to delete.
+func (s *Service) ResetConversation(peer string) (bool, error) {
+	unlock, err := s.ratchetStore.LockConversation(peer)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	ok, err := s.ratchetStore.DeleteConversation(peer)
+	if err != nil || !ok {
+		return ok, err
+	}
+	s.logger.Debug("conversation reset", "peer", peer)
+	return true, nil
+}
+
+// handleWipe applies a wipe request or receipt from conv.Peer and returns the
+// outcome to report, or "" if the message was ignored. After
+// body.WipeResultWiped or body.WipeResultConfirmed the conversation no longer
+// exists and conv must not be saved.
+//
+// Requests and receipts must carry a valid signature from the peer's signing
+// key (see peerKeys); anything else is logged and ignored. A request from a
+// peer whose signing key we do not know is refused.
+func (s *Service) handleWipe(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	conv *domain.Conversation,
+	msg domain.ControlMessage,
+) (string, error) {
+	peerIK, peerSK, known, err := s.peerKeys(*conv)
+	if err != nil {
+		return "", err
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return "", err
+	}
+	if msg.WipeID == "" || len(msg.WipeID) > maxWipeIDLen || len(msg.WipeResult) > maxWipeIDLen {
+		s.logger.Warn("ignoring malformed wipe message", "peer", conv.Peer, "type", msg.Type)
+		return "", nil
+	}
+	if known && !crypto.VerifyContext(peerSK, wipeContext, wipeStatement(msg, peerIK, id.XPub), msg.Sig) {
+		s.logger.Warn("ignoring wipe message with a bad signature", "peer", conv.Peer, "type", msg.Type)
+		return "", nil
+	}
+
+	if msg.Type == controlWipeReceipt {
+		if !known || msg.WipeID != conv.WipeRequested {
+			s.logger.Debug("ignoring receipt for unknown wipe", "peer", conv.Peer)
+			return "", nil
+		}
+		if msg.WipeResult != body.WipeResultWiped {
+			conv.WipeRequested = ""
+			s.logger.Debug("wipe refused", "peer", conv.Peer)
+			return body.WipeResultRefused, nil
+		}
+		if err := s.wipeLocal(passphrase, conv.Peer); err != nil {
+			return "", err
+		}
+		return body.WipeResultConfirmed, nil
+	}
+
+	prefs, err := s.conversations.Preferences(conv.Peer)
+	if err != nil {
+		return "", err
+	}
+	accept := known && prefs.AcceptWipe
+	result := body.WipeResultRefused
+	if accept {
+		result = body.WipeResultWiped
+	}
+	receipt := domain.ControlMessage{Type: controlWipeReceipt, WipeID: msg.WipeID, WipeResult: result}
+	receipt.Sig = crypto.SignContext(id.EdPriv, wipeContext, wipeStatement(receipt, id.XPub, peerIK))
+	if err := s.sendControl(ctx, passphrase, me, conv, receipt); err != nil {
+		return "", fmt.Errorf("send wipe receipt: %w", err)
+	}
+	if !accept {
+		s.logger.Debug("wipe request declined", "peer", conv.Peer, "verified", known)
+		if !known {
+			return body.WipeResultUnverified, nil
+		}
+		return body.WipeResultDeclined, nil
+	}
+	if err := s.wipeLocal(passphrase, conv.Peer); err != nil {
+		return "", err
+	}
+	return body.WipeResultWiped, nil
+}
+
+// peerKeys returns the identity key and signing key signed control messages
+// from conv.Peer, such as wipes and pongs, are checked against. They come from our session with the peer if
+// we initiated one, else from pairing, whose identity key must match the one
+// the conversation was started with. ok is false if neither is available;
+// peerIK is then the conversation's own record, which may be zero.
+func (s *Service) peerKeys(conv domain.Conversation) (
+	peerIK domain.X25519Public,
+	peerSK domain.Ed25519Public,
+	ok bool,
+	err error,
+) {
+	sess, found, err := s.sessionService.GetSession(conv.Peer)
+	if err != nil {
+		return peerIK, peerSK, false, err
+	}
+	if found {
+		return sess.PeerIK, sess.PeerSignKey, true, nil
+	}
+	c, paired, err := s.contactStore.LoadContact(conv.Peer)
+	if err != nil {
+		return peerIK, peerSK, false, err
+	}
+	if paired && c.IdentityKey == conv.PeerIK {
+		return c.IdentityKey, c.SignKey, true, nil
+	}
+	return conv.PeerIK, peerSK, false, nil
+}
+
+// wipeLocal deletes everything held about the conversation with peer: ratchet
+// state and skipped keys, the session, history, the outbox journal, the
+// ratchet trace, quarantined envelopes and held messages.
+// Contacts and preferences are kept.
+func (s *Service) wipeLocal(passphrase, peer string) error {
+	if _, err := s.ratchetStore.DeleteConversation(peer); err != nil {
+		return fmt.Errorf("wipe conversation: %w", err)
+	}
+	if _, err := s.sessionService.DeleteSession(peer); err != nil {
+		return fmt.Errorf("wipe session: %w", err)
+	}
+	n, err := s.historyStore.DeleteHistory(passphrase, peer)
+	if err != nil {
+		return fmt.Errorf("wipe history: %w", err)
+	}
+	if _, err := s.outboxStore.DeleteSent(peer); err != nil {
+		return fmt.Errorf("wipe outbox: %w", err)
+	}
+	if _, err := s.traceStore.DeleteRatchetSteps(peer); err != nil {
+		return fmt.Errorf("wipe ratchet trace: %w", err)
+	}
+	qs, err := s.quarantineStore.ListQuarantined()
+	if err != nil {
+		return err
+	}
+	for _, q := range qs {
+		if q.Envelope.From != peer {
+			continue
+		}
+		if _, err := s.quarantineStore.DeleteQuarantined(q.ID); err != nil {
+			return fmt.Errorf("wipe quarantine: %w", err)
+		}
+	}
+	hs, err := s.heldStore.ListHeld(passphrase)
+	if err != nil {
+		return err
+	}
+	for _, h := range hs {
+		if h.Message.From != peer {
+			continue
+		}
+		if _, err := s.heldStore.DeleteHeld(passphrase, h.ID); err != nil {
+			return fmt.Errorf("wipe held messages: %w", err)
+		}
+	}
+	s.logger.Debug("conversation wiped", "peer", peer, "history_entries", n)
+	return nil
+}
+
+// wipeStatement returns the bytes a wipe request or receipt signs: its type,
+// wipe ID and result, bound to the sender's and recipient's identity keys so
+// it cannot be replayed into another conversation.
+func wipeStatement(msg domain.ControlMessage, from, to domain.X25519Public) []byte {
+	var b []byte
+	for _, f := range []string{msg.Type, msg.WipeID, msg.WipeResult} {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(f)))
+		b = append(b, f...)
+	}
+	b = append(b, from[:]...)
+	return append(b, to[:]...)
+}
+
+// wipeNotice is the local message body reporting a wipe outcome to the user.
+// It is never sent or stored in the history.
+func wipeNotice(result string) domain.MessageBody {
+	return domain.MessageBody{
+		Version:     body.Version,
+		ContentType: body.TypeWipe,
+		Metadata:    map[string]string{body.MetaWipeResult: result},
+	}
+}
@@ -0,0 +1,85 @@
+package pairing
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// codeWords is the number of words in a pairing code.
+	codeWords = 6
+	// mailboxWords is how many leading words name the relay mailbox. The relay
+	// can learn these; the remaining words (32 bits) are the secret.
+	mailboxWords = 2
+
+	mailboxLabel = "ciphera/pair-box-v1"
+	stretchLabel = "ciphera/pair-v1"
+)
+
+// scrypt cost for stretching the code before SPAKE2. Only online guessing is
+// possible, so this is a speed bump rather than the main defence.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// ErrBadCode is returned for a code that is not six words from the wordlist.
+var ErrBadCode = errors.New("pairing code must be six words from the pairing word list")
+
+// wordIndex maps each word to its position in wordlist.
+var wordIndex = func() map[string]int {
+	m := make(map[string]int, len(wordlist))
+	for i, w := range wordlist {
+		m[w] = i
+	}
+	return m
+}()
+
+// newCode returns a random code of codeWords words joined by "-".
+func newCode() (string, error) {
+	var b [codeWords]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	words := make([]string, codeWords)
+	for i, c := range b {
+		words[i] = wordlist[c]
+	}
+	return strings.Join(words, "-"), nil
+}
+
+// parseCode normalises a code typed by the user: case and separators (dashes,
+// spaces) do not matter.
+func parseCode(code string) (string, error) {
+	words := strings.FieldsFunc(strings.ToLower(code), func(r rune) bool {
+		return r == '-' || r == ' ' || r == '\t'
+	})
+	if len(words) != codeWords {
+		return "", ErrBadCode
+	}
+	for _, w := range words {
+		if _, ok := wordIndex[w]; !ok {
+			return "", fmt.Errorf("%w: unknown word %q", ErrBadCode, w)
+		}
+	}
+	return strings.Join(words, "-"), nil
+}
+
+// mailboxFor derives the relay mailbox name from the code's leading words.
+func mailboxFor(code string) string {
+	words := strings.SplitN(code, "-", mailboxWords+1)
+	sum := sha256.Sum256([]byte(mailboxLabel + "\x00" + strings.Join(words[:mailboxWords], "-")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// stretch derives the SPAKE2 password input from the whole code.
+func stretch(code, mailbox string) ([]byte, error) {
+	return scrypt.Key([]byte(code), []byte(stretchLabel+"\x00"+mailbox), scryptN, scryptR, scryptP, 40)
+}
@@ -0,0 +1,22 @@
+// Package pairing exchanges identity cards between two clients using a short
+// six-word code instead of comparing fingerprints or scanning QR codes.
+//
+// # Protocol
+//
+// One side (the opener) generates a code and reads it to the other (the
+// joiner). The first two words name a mailbox on the relay; the whole code,
+// stretched with scrypt, is the SPAKE2 password. Both sides then:
+//
+//  1. Post a SPAKE2 share and read the peer's.
+//  2. Post a key confirmation MAC and check the peer's. A wrong code fails
+//     here, and each attempt gives an attacker a single guess.
+//  3. Send their identity card (username, identity key, signing key and its
+//     rotation chain) sealed with ChaCha20-Poly1305 under a key derived from
+//     the SPAKE2 output, one key per direction.
+//
+// The peer's card is stored as a domain.Contact. Session setup refuses
+// bundles for a contact whose identity key differs from the paired one, and
+// uses the paired signing key as the pin for rotation checks.
+//
+// The relay only sees the mailbox name, SPAKE2 shares and ciphertext.
+package pairing
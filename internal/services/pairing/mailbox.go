@@ -0,0 +1,57 @@
+package pairing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// mailbox is one side's view of a relay pairing mailbox. Messages are read in
+// order; read counts how many of the peer's messages were consumed.
+type mailbox struct {
+	relay domain.RelayClient
+	box   string
+	side  string
+	read  int
+}
+
+// send posts a frame. open asks the relay to create the mailbox and fail if
+// it already exists.
+func (m *mailbox) send(ctx context.Context, typ string, data []byte, open bool) error {
+	b, err := json.Marshal(frame{Type: typ, Data: data})
+	if err != nil {
+		return err
+	}
+	return m.relay.PostPairMessage(ctx, m.box, m.side, b, open)
+}
+
+// recv waits for the peer's next frame, which must have type typ.
+func (m *mailbox) recv(ctx context.Context, typ string) ([]byte, error) {
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+	for {
+		msgs, err := m.relay.FetchPairMessages(ctx, m.box, m.side, m.read)
+		if err != nil {
+			return nil, err
+		}
+		if len(msgs) > 0 {
+			m.read++
+			var f frame
+			if err := json.Unmarshal(msgs[0], &f); err != nil {
+				return nil, fmt.Errorf("pairing message: %w", err)
+			}
+			if f.Type != typ {
+				return nil, fmt.Errorf("pairing message: got %q, want %q", f.Type, typ)
+			}
+			return f.Data, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
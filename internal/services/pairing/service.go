@@ -0,0 +1,251 @@
+package pairing
+
+import (
+	"context"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/signchain"
+	"ciphera/internal/protocol/spake2"
+)
+
+// pollInterval is how often the mailbox is checked for the peer's next message.
+const pollInterval = 500 * time.Millisecond
+
+// Frame types exchanged through the mailbox.
+const (
+	frameShare   = "share"
+	frameConfirm = "confirm"
+	frameCard    = "card"
+)
+
+var (
+	// ErrWrongCode is returned when key confirmation fails: the two sides typed
+	// different codes, or someone tried to guess it.
+	ErrWrongCode = errors.New("pairing failed: codes do not match")
+	// ErrCodeInUse is returned when the opener's mailbox is already taken.
+	ErrCodeInUse = errors.New("pairing code already in use; generate a new one")
+	// ErrBadCard is returned when the peer's identity card is malformed.
+	ErrBadCard = errors.New("peer sent an invalid identity card")
+)
+
+// frame is one mailbox message.
+type frame struct {
+	Type string `json:"type"`
+	Data []byte `json:"data"`
+}
+
+// Service runs the pairing protocol over the default relay.
+type Service struct {
+	idStore      domain.IdentityStore
+	contactStore domain.ContactStore
+	relay        domain.RelayClient
+	logger       *slog.Logger
+}
+
+// New constructs a pairing service.
+//
+// If logger is nil, log output is discarded.
+func New(
+	idStore domain.IdentityStore,
+	contactStore domain.ContactStore,
+	relay domain.RelayClient,
+	logger *slog.Logger,
+) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	return &Service{
+		idStore:      idStore,
+		contactStore: contactStore,
+		relay:        relay,
+		logger:       logger,
+	}
+}
+
+// NewCode returns a fresh random pairing code for the opener to share.
+func (s *Service) NewCode() (string, error) {
+	return newCode()
+}
+
+// Pair runs the protocol with code and stores the peer as a contact.
+//
+// The opener must call Pair with opener set; the joiner without. Pair blocks
+// until the peer completes or ctx is done.
+func (s *Service) Pair(
+	ctx context.Context,
+	passphrase string,
+	me string,
+	code string,
+	opener bool,
+) (domain.Contact, error) {
+	code, err := parseCode(code)
+	if err != nil {
+		return domain.Contact{}, err
+	}
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return domain.Contact{}, err
+	}
+
+	box := mailboxFor(code)
+	pw, err := stretch(code, box)
+	if err != nil {
+		return domain.Contact{}, err
+	}
+	ch := &mailbox{relay: s.relay, box: box, side: "b"}
+	role := spake2.RoleB
+	if opener {
+		ch.side, role = "a", spake2.RoleA
+	}
+
+	// 1. SPAKE2 shares.
+	st, share, err := spake2.Start(role, pw, []byte("ciphera-pair-a"), []byte("ciphera-pair-b"), []byte(box))
+	if err != nil {
+		return domain.Contact{}, err
+	}
+	if err := ch.send(ctx, frameShare, share, opener); err != nil {
+		// Relays that predate error codes answer every conflict the same.
+		code := domain.RelayCode(err)
+		if code == domain.RelayCodeMailboxInUse || code == "" && errors.Is(err, domain.ErrConflict) {
+			return domain.Contact{}, ErrCodeInUse
+		}
+		return domain.Contact{}, err
+	}
+	s.logger.Debug("pairing started", "mailbox", box, "side", ch.side)
+	peerShare, err := ch.recv(ctx, frameShare)
+	if err != nil {
+		return domain.Contact{}, err
+	}
+	res, err := st.Finish(peerShare)
+	if err != nil {
+		return domain.Contact{}, err
+	}
+
+	// 2. Key confirmation.
+	if err := ch.send(ctx, frameConfirm, res.Confirm, false); err != nil {
+		return domain.Contact{}, err
+	}
+	peerConfirm, err := ch.recv(ctx, frameConfirm)
+	if err != nil {
+		return domain.Contact{}, err
+	}
+	if err := res.Verify(peerConfirm); err != nil {
+		return domain.Contact{}, ErrWrongCode
+	}
+	s.logger.Debug("pairing key confirmed", "mailbox", box)
+
+	// 3. Identity cards. The opener sends first, so by the time it reads the
+	// joiner's card the joiner already has its card and the mailbox can go.
+	mine := domain.IdentityCard{
+		Username:    me,
+		IdentityKey: id.XPub,
+		SignKey:     id.EdPub,
+		SignChain:   id.SignChain,
+	}
+	var peer domain.IdentityCard
+	if opener {
+		if err := s.sendCard(ctx, ch, res.Key, mine); err != nil {
+			return domain.Contact{}, err
+		}
+		if peer, err = s.recvCard(ctx, ch, res.Key); err != nil {
+			return domain.Contact{}, err
+		}
+		if err := s.relay.ClosePairMailbox(ctx, box); err != nil {
+			s.logger.Debug("closing pairing mailbox failed", "mailbox", box, "error", err)
+		}
+	} else {
+		if peer, err = s.recvCard(ctx, ch, res.Key); err != nil {
+			return domain.Contact{}, err
+		}
+		if err := s.sendCard(ctx, ch, res.Key, mine); err != nil {
+			return domain.Contact{}, err
+		}
+	}
+
+	c := domain.Contact{
+		Username:    peer.Username,
+		IdentityKey: peer.IdentityKey,
+		SignKey:     peer.SignKey,
+		PairedUTC:   time.Now().Unix(),
+	}
+	if err := s.contactStore.SaveContact(c); err != nil {
+		return domain.Contact{}, err
+	}
+	s.logger.Debug("paired", "peer", c.Username)
+	return c, nil
+}
+
+// ListContacts returns every paired contact.
+func (s *Service) ListContacts() ([]domain.Contact, error) {
+	return s.contactStore.ListContacts()
+}
+
+// sendCard seals card for the peer and posts it.
+func (s *Service) sendCard(ctx context.Context, ch *mailbox, key []byte, card domain.IdentityCard) error {
+	raw, err := json.Marshal(card)
+	if err != nil {
+		return err
+	}
+	aead, err := cardCipher(key, ch.side, ch.box)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize()) // one message per key
+	return ch.send(ctx, frameCard, aead.Seal(nil, nonce, raw, []byte(ch.box)), false)
+}
+
+// recvCard reads, opens and validates the peer's card.
+func (s *Service) recvCard(ctx context.Context, ch *mailbox, key []byte) (domain.IdentityCard, error) {
+	sealed, err := ch.recv(ctx, frameCard)
+	if err != nil {
+		return domain.IdentityCard{}, err
+	}
+	aead, err := cardCipher(key, otherSide(ch.side), ch.box)
+	if err != nil {
+		return domain.IdentityCard{}, err
+	}
+	raw, err := aead.Open(nil, make([]byte, aead.NonceSize()), sealed, []byte(ch.box))
+	if err != nil {
+		return domain.IdentityCard{}, fmt.Errorf("%w: %v", ErrBadCard, err)
+	}
+	var card domain.IdentityCard
+	if err := json.Unmarshal(raw, &card); err != nil {
+		return domain.IdentityCard{}, fmt.Errorf("%w: %v", ErrBadCard, err)
+	}
+	if card.Username == "" {
+		return domain.IdentityCard{}, fmt.Errorf("%w: no username", ErrBadCard)
+	}
+	if err := signchain.Check(card.IdentityKey, card.SignChain, card.SignKey); err != nil {
+		return domain.IdentityCard{}, fmt.Errorf("%w: %v", ErrBadCard, err)
+	}
+	return card, nil
+}
+
+// cardCipher derives the AEAD for cards sent by side.
+func cardCipher(key []byte, side, box string) (cipher.AEAD, error) {
+	k, err := crypto.HKDF(key, []byte(box), crypto.LabelPairCard+side, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(k)
+}
+
+// otherSide returns the mailbox side opposite to side.
+func otherSide(side string) string {
+	if side == "a" {
+		return "b"
+	}
+	return "a"
+}
+
+// Compile-time assertion that Service implements domain.PairingService.
+var _ domain.PairingService = (*Service)(nil)
@@ -0,0 +1,40 @@
+package pairing
+
+// wordlist holds the 256 words pairing codes are made of, one byte of entropy
+// each. Words are short, lower case and distinct, so a code can be read aloud
+// or typed without ambiguity. The list is part of the protocol: changing it
+// breaks pairing with older clients.
+var wordlist = [256]string{
+	"acid", "acorn", "actor", "adapt", "agent", "alarm", "album", "alert",
+	"alley", "alpha", "amber", "angle", "ankle", "apple", "april", "apron",
+	"arena", "armor", "arrow", "atlas", "attic", "audio", "award", "bacon",
+	"badge", "bagel", "baker", "bamboo", "banjo", "barn", "basil", "basin",
+	"beach", "beard", "berry", "bison", "blade", "blaze", "blend", "bloom",
+	"board", "bonus", "boost", "brave", "bread", "brick", "bride", "brook",
+	"brush", "cabin", "cable", "cactus", "camel", "candy", "canoe", "canyon",
+	"cargo", "carol", "cedar", "chalk", "charm", "chess", "chief", "chili",
+	"cider", "cigar", "cinema", "civic", "claim", "cliff", "clock", "cloud",
+	"clover", "coast", "cobra", "cocoa", "comet", "coral", "couch", "crane",
+	"crate", "cream", "crest", "crown", "cubic", "curry", "cycle", "daisy",
+	"dance", "delta", "denim", "depot", "diary", "disco", "dodge", "dough",
+	"draft", "dragon", "drama", "dream", "drift", "drum", "eagle", "easel",
+	"elbow", "ember", "empty", "epoch", "equal", "essay", "event", "fable",
+	"fairy", "falcon", "fancy", "feast", "fence", "ferry", "fever", "fiber",
+	"field", "flame", "flint", "flora", "flute", "focus", "forge", "fossil",
+	"frame", "frost", "fruit", "fudge", "gala", "gamma", "garden", "gecko",
+	"ghost", "giant", "ginger", "glade", "globe", "glove", "grain", "grape",
+	"gravy", "guest", "guide", "guitar", "habit", "harbor", "hazel", "heart",
+	"hedge", "helmet", "hero", "honey", "horse", "hotel", "humor", "igloo",
+	"image", "index", "inlet", "iris", "ivory", "jacket", "jaguar", "jelly",
+	"jewel", "jockey", "judge", "juice", "jungle", "kayak", "kernel", "kettle",
+	"kiosk", "kitten", "koala", "label", "ladder", "lagoon", "lemon", "lever",
+	"lilac", "linen", "lion", "lotus", "lunar", "magnet", "mango", "maple",
+	"marble", "meadow", "medal", "melon", "mercy", "metal", "mint", "model",
+	"motor", "mural", "music", "nectar", "needle", "noble", "north", "novel",
+	"oasis", "ocean", "olive", "omega", "onion", "opera", "orbit", "otter",
+	"oxide", "paddle", "palace", "panda", "paper", "parade", "pastel", "peach",
+	"pearl", "pepper", "piano", "pilot", "pixel", "plaza", "pocket", "polar",
+	"pony", "poppy", "prism", "pulse", "puzzle", "quartz", "quest", "quilt",
+	"radar", "radio", "raven", "razor", "relay", "rhythm", "ribbon", "rider",
+	"river", "robin", "rocket", "rodeo", "royal", "ruby", "saddle", "salad",
+}
@@ -1,4 +1,5 @@
 // Package prekey manages signed prekeys and one-time prekeys for X3DH bootstrap.
 //
 // It rotates the current SPK, uploads bundles and tracks OPK usage in the store.
+// New prekeys are named with random IDs from package keyid.
 package prekey
@@ -1,12 +1,16 @@
 package prekey
 
 import (
+	"cmp"
 	"errors"
-	"fmt"
-	"time"
+	"log/slog"
+	"slices"
 
 	"ciphera/internal/crypto"
 	"ciphera/internal/domain"
+	"ciphera/internal/protocol/caps"
+	"ciphera/internal/protocol/keyid"
+	"ciphera/internal/protocol/x3dh"
 )
 
 // Service manages prekey pairs and builds the public bundle.
@@ -18,23 +22,38 @@ type Service struct {
 	idStore     domain.IdentityStore
 	prekeyStore domain.PrekeyStore
 	bundleStore domain.PrekeyBundleStore
+	attestStore domain.AttestationStore
+	logger      *slog.Logger
 }
 
+// maxAttestations bounds the attestations published in a bundle, as relays
+// refuse bundles with more; the newest are kept.
+const maxAttestations = 64
+
 var (
 	// ErrNoSignedPrekey indicates there is no signed prekey available to build a bundle.
 	ErrNoSignedPrekey = errors.New("no signed prekey available")
 )
 
 // New constructs a prekey service wired to the given stores.
+//
+// If logger is nil, log output is discarded.
 func New(
 	idStore domain.IdentityStore,
 	prekeyStore domain.PrekeyStore,
 	bundleStore domain.PrekeyBundleStore,
+	attestStore domain.AttestationStore,
+	logger *slog.Logger,
 ) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
 	return &Service{
 		idStore:     idStore,
 		prekeyStore: prekeyStore,
 		bundleStore: bundleStore,
+		attestStore: attestStore,
+		logger:      logger,
 	}
 }
 
@@ -62,8 +81,11 @@ func (s *Service) GenerateAndStorePrekeys(
 	if err != nil {
 		return domain.X25519Public{}, nil, err
 	}
-	spkID := fmt.Sprintf("spk-%d", time.Now().Unix())
-	sig := crypto.SignEd25519(id.EdPriv, spkPub[:])
+	spkID, err := keyid.New(keyid.SignedPrekey)
+	if err != nil {
+		return domain.X25519Public{}, nil, err
+	}
+	sig := x3dh.SignSPK(id.EdPriv, spkPub)
 	if err := s.prekeyStore.SaveSignedPrekey(spkID, spkPriv, spkPub, sig); err != nil {
 		return domain.X25519Public{}, nil, err
 	}
@@ -71,23 +93,63 @@ func (s *Service) GenerateAndStorePrekeys(
 		return domain.X25519Public{}, nil, err
 	}
 
-	// One-time prekeys: generate n pairs and persist them in a batch.
+	publics, err := s.generateOneTime(n)
+	if err != nil {
+		return domain.X25519Public{}, nil, err
+	}
+
+	s.logger.Debug("prekeys generated", "spk_id", spkID, "one_time_count", len(publics))
+	return spkPub, publics, nil
+}
+
+// RestoreSignedPrekey marks the stored signed prekey id, one replaced
+// earlier, as current again and generates n one-time prekeys to publish
+// with it. Signed prekeys are never deleted, so any earlier one can be
+// brought back; its signature is renewed by LoadPrekeyBundle if the signing
+// key has rotated since.
+func (s *Service) RestoreSignedPrekey(passphrase string, id domain.KeyID, n int) ([]domain.X25519Public, error) {
+	if _, err := s.idStore.LoadIdentity(passphrase); err != nil {
+		return nil, err
+	}
+	_, _, _, found, err := s.prekeyStore.LoadSignedPrekey(id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNoSignedPrekey
+	}
+	if err := s.prekeyStore.SetCurrentSignedPrekeyID(id); err != nil {
+		return nil, err
+	}
+	publics, err := s.generateOneTime(n)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Debug("signed prekey restored", "spk_id", id, "one_time_count", len(publics))
+	return publics, nil
+}
+
+// generateOneTime generates n one-time prekey pairs, persists them in a
+// batch and returns their publics.
+func (s *Service) generateOneTime(n int) ([]domain.X25519Public, error) {
 	pairs := make([]domain.OneTimePair, 0, n)
 	publics := make([]domain.X25519Public, 0, n)
-	for i := range n {
+	for range n {
 		priv, pub, err := crypto.GenerateX25519()
 		if err != nil {
-			return domain.X25519Public{}, nil, err
+			return nil, err
+		}
+		id, err := keyid.New(keyid.OneTimePrekey)
+		if err != nil {
+			return nil, err
 		}
-		id := fmt.Sprintf("opk-%d-%d", time.Now().Unix(), i)
 		pairs = append(pairs, domain.OneTimePair{ID: id, Priv: priv, Pub: pub})
 		publics = append(publics, pub)
 	}
 	if err := s.prekeyStore.SaveOneTimePrekeys(pairs); err != nil {
-		return domain.X25519Public{}, nil, err
+		return nil, err
 	}
-
-	return spkPub, publics, nil
+	return publics, nil
 }
 
 // LoadPrekeyBundle assembles the public bundle from the current SPK and the
@@ -97,6 +159,12 @@ func (s *Service) GenerateAndStorePrekeys(
 //   - Identity keys (X25519 and Ed25519).
 //   - Current SPK and its signature over the SPK.
 //   - Zero or more OPK publics.
+//   - The signing-key rotation chain, so peers can follow rotations.
+//   - The capabilities this client supports (see package caps).
+//   - The cipher suites this client implements, in the order it offers them
+//     (see crypto.Suite).
+//   - Attestations contacts made that username holds our identity key (see
+//     package attest); ones about another username or key are left out.
 func (s *Service) LoadPrekeyBundle(
 	passphrase string,
 	username string,
@@ -114,33 +182,112 @@ func (s *Service) LoadPrekeyBundle(
 		return domain.PrekeyBundle{}, ErrNoSignedPrekey
 	}
 
-	_, spkPub, sig, found, err := s.prekeyStore.LoadSignedPrekey(spkID)
+	spkPriv, spkPub, sig, found, err := s.prekeyStore.LoadSignedPrekey(spkID)
 	if err != nil {
 		return domain.PrekeyBundle{}, err
 	}
 	if !found {
 		return domain.PrekeyBundle{}, ErrNoSignedPrekey
 	}
+	// Prekeys signed by older clients carry a raw signature; re-sign them
+	// under the SPK context so the published bundle uses the current format.
+	if !crypto.VerifyContext(id.EdPub, x3dh.SPKContext, spkPub[:], sig) {
+		sig = x3dh.SignSPK(id.EdPriv, spkPub)
+		if err := s.prekeyStore.SaveSignedPrekey(spkID, spkPriv, spkPub, sig); err != nil {
+			return domain.PrekeyBundle{}, err
+		}
+		s.logger.Debug("signed prekey re-signed with context", "spk_id", spkID)
+	}
 
 	oneTime, err := s.prekeyStore.ListOneTimePrekeyPublics()
 	if err != nil {
 		return domain.PrekeyBundle{}, err
 	}
 
+	atts, err := s.attestStore.ListAttestations()
+	if err != nil {
+		return domain.PrekeyBundle{}, err
+	}
+	atts = slices.DeleteFunc(atts, func(a domain.Attestation) bool {
+		return a.Subject != username || a.SubjectKey != id.XPub
+	})
+	if len(atts) > maxAttestations {
+		slices.SortFunc(atts, func(a, b domain.Attestation) int { return cmp.Compare(b.CreatedUTC, a.CreatedUTC) })
+		atts = atts[:maxAttestations]
+	}
+
+	// The published signature also covers the suites, so they cannot be
+	// stripped; the stored one covers the SPK alone.
+	suites := crypto.SuiteIDs()
 	bundle := domain.PrekeyBundle{
-		Username:        username,
-		IdentityKey:     id.XPub,
-		SignKey:         id.EdPub,
-		SPKID:           spkID,
-		SignedPrekey:    spkPub,
-		SignedPrekeySig: sig,
-		OneTime:         oneTime,
+		Username:         username,
+		IdentityKey:      id.XPub,
+		SignKey:          id.EdPub,
+		SPKID:            spkID,
+		SignedPrekey:     spkPub,
+		SignedPrekeySig:  x3dh.SignSPKSuites(id.EdPriv, spkPub, suites),
+		SignedPrekeySigV: domain.SigSuites,
+		OneTime:          oneTime,
+		SignChain:        id.SignChain,
+		Capabilities:     slices.Clone(caps.Supported),
+		Suites:           suites,
+		Attestations:     atts,
 	}
 	if err := s.bundleStore.SavePrekeyBundle(bundle); err != nil {
 		return domain.PrekeyBundle{}, err
 	}
+	s.logger.Debug("prekey bundle assembled",
+		"user", username,
+		"spk_id", spkID,
+		"one_time_count", len(oneTime),
+		"attestations", len(atts),
+	)
+	return bundle, nil
+}
+
+// ExportPrekeyBundle returns the bundle for username to hand to a peer out of
+// band, e.g. as a file carried to a machine that cannot reach our relay. A
+// signed prekey is generated first if there is none yet.
+//
+// The one-time prekeys are left out: the same file may reach several peers,
+// and a one-time prekey used twice would give two sessions one key. X3DH runs
+// on the signed prekey alone without them.
+func (s *Service) ExportPrekeyBundle(
+	passphrase string,
+	username string,
+) (domain.PrekeyBundle, error) {
+	bundle, err := s.LoadPrekeyBundle(passphrase, username)
+	if errors.Is(err, ErrNoSignedPrekey) {
+		if _, _, err = s.GenerateAndStorePrekeys(passphrase, 0); err != nil {
+			return domain.PrekeyBundle{}, err
+		}
+		bundle, err = s.LoadPrekeyBundle(passphrase, username)
+	}
+	if err != nil {
+		return domain.PrekeyBundle{}, err
+	}
+	bundle.OneTime = nil
+	s.logger.Debug("prekey bundle exported", "user", username, "spk_id", bundle.SPKID)
 	return bundle, nil
 }
 
+// Status summarises the prekeys held, when they were generated and consumed,
+// and when their upkeep next runs.
+func (s *Service) Status() (domain.PrekeyStatus, error) {
+	return s.prekeyStore.PrekeyStatus()
+}
+
+// SaveSchedule records when prekey upkeep next runs.
+func (s *Service) SaveSchedule(sched domain.PrekeySchedule) error {
+	if err := s.prekeyStore.SavePrekeySchedule(sched); err != nil {
+		return err
+	}
+	s.logger.Debug("prekey schedule saved",
+		"next_check_utc", sched.NextCheckUTC,
+		"next_rotate_utc", sched.NextRotateUTC,
+	)
+	return nil
+}
+
 // Compile-time assertion that Service implements domain.PrekeyService.
 var _ domain.PrekeyService = (*Service)(nil)
@@ -0,0 +1,14 @@
+// Package ratchetdebug manages opt-in recording of ratchet steps, for
+// diagnosing conversations whose state has diverged from the peer's.
+//
+// Recording is off by default. While it is on, the message service records a
+// domain.RatchetStep for every encrypt and decrypt, including failed
+// decrypts: the header, the state before the operation and, if it succeeded,
+// the state after. Secret keys are recorded only as short digests, so a
+// trace cannot decrypt past messages, but two peers can compare their traces
+// to find the step where a sending chain and the matching receiving chain
+// stopped agreeing. Each peer's most recent steps are kept, encrypted under
+// the identity passphrase.
+//
+// Turning recording off deletes every trace.
+package ratchetdebug
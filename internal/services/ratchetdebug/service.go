@@ -0,0 +1,60 @@
+package ratchetdebug
+
+import (
+	"fmt"
+	"log/slog"
+
+	"ciphera/internal/domain"
+)
+
+// Service turns ratchet step recording on and off and reads what was
+// recorded.
+type Service struct {
+	traceStore    domain.RatchetTraceStore
+	conversations domain.ConversationService
+	logger        *slog.Logger
+}
+
+// New returns a ratchet debugging service over the traces in traceStore,
+// with the recording setting kept by conversations.
+//
+// If logger is nil, log output is discarded.
+func New(
+	traceStore domain.RatchetTraceStore,
+	conversations domain.ConversationService,
+	logger *slog.Logger,
+) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	return &Service{traceStore: traceStore, conversations: conversations, logger: logger}
+}
+
+// SetEnabled turns recording on or off. Turning it off also deletes the
+// traces already recorded.
+func (s *Service) SetEnabled(on bool) error {
+	if err := s.conversations.SetRatchetDebug(on); err != nil {
+		return err
+	}
+	if on {
+		return nil
+	}
+	n, err := s.traceStore.DeleteRatchetSteps("")
+	if err != nil {
+		return fmt.Errorf("delete ratchet traces: %w", err)
+	}
+	s.logger.Debug("ratchet traces deleted", "peers", n)
+	return nil
+}
+
+// Enabled reports whether ratchet steps are being recorded.
+func (s *Service) Enabled() (bool, error) {
+	return s.conversations.RatchetDebug()
+}
+
+// Steps returns the steps recorded for peer, oldest first.
+func (s *Service) Steps(passphrase, peer string) ([]domain.RatchetStep, error) {
+	return s.traceStore.LoadRatchetSteps(passphrase, peer)
+}
+
+var _ domain.RatchetDebugService = (*Service)(nil)
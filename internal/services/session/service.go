@@ -2,9 +2,20 @@ package session
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
 	"time"
 
+	"ciphera/internal/crypto"
 	"ciphera/internal/domain"
+	"ciphera/internal/protocol/address"
+	"ciphera/internal/protocol/attest"
+	"ciphera/internal/protocol/caps"
+	"ciphera/internal/protocol/keyid"
+	"ciphera/internal/protocol/signchain"
 	"ciphera/internal/protocol/x3dh"
 )
 
@@ -14,28 +25,76 @@ import (
 // for establishing a Double Ratchet conversation with a peer.
 // This service handles:
 //   - Retrieving our own identity keys.
-//   - Fetching the peer's prekey bundle from the relay.
+//   - Fetching the peer's prekey bundle from every known relay, or from the
+//     relay discovered for a user@host address.
 //   - Running the X3DH key agreement as the initiator.
 //   - Persisting the resulting session for later message encryption.
 type Service struct {
-	idStore      domain.IdentityStore
-	prekeyStore  domain.PrekeyBundleStore
-	sessionStore domain.SessionStore
-	relayClient  domain.RelayClient
+	idStore       domain.IdentityStore
+	prekeyStore   domain.PrekeyBundleStore
+	sessionStore  domain.SessionStore
+	contactStore  domain.ContactStore
+	identities    domain.IdentityKeyIndex
+	relays        domain.RelayDirectory
+	resolver      domain.RelayResolver
+	conversations domain.ConversationService
+	logger        *slog.Logger
 }
 
-// New constructs a Session Service with the given stores and relay client.
+var (
+	// ErrNoRelays indicates there is no relay to look the peer up on.
+	ErrNoRelays = errors.New("no relay configured")
+	// ErrIdentityConflict indicates relays disagree on the peer's identity key.
+	ErrIdentityConflict = errors.New("peer has different identity keys on different relays")
+	// ErrContactMismatch indicates the relay's bundle does not match the
+	// identity key received when pairing with the peer.
+	ErrContactMismatch = errors.New("peer identity key does not match paired contact")
+	// ErrRekeyIdentity indicates the peer's bundle carries a different
+	// identity key from the conversation being rekeyed.
+	ErrRekeyIdentity = errors.New("peer identity key changed; run start-session to accept the new key")
+	// ErrFingerprintMismatch indicates the relay returned a bundle whose
+	// identity key does not have the fingerprint the peer was addressed by.
+	ErrFingerprintMismatch = errors.New("peer identity key does not match the fingerprint address")
+	// ErrSigDowngrade indicates the bundle's signed prekey is signed in an
+	// older form than an earlier session with the same identity saw.
+	ErrSigDowngrade = errors.New("peer's signed prekey signature is in an older form than before")
+	// ErrBadBundle indicates a bundle handed over out of band lacks the keys a
+	// handshake needs.
+	ErrBadBundle = errors.New("prekey bundle is incomplete")
+	// ErrBundleUser indicates a bundle handed over out of band belongs to
+	// another username than the peer named.
+	ErrBundleUser = errors.New("prekey bundle is for another user")
+)
+
+// New constructs a Session Service with the given stores, the index of
+// identity keys across them, relay directory, resolver for address hosts,
+// and the conversation settings naming the cipher suite we prefer.
+//
+// If logger is nil, log output is discarded.
 func New(
 	idStore domain.IdentityStore,
 	prekeyStore domain.PrekeyBundleStore,
 	sessionStore domain.SessionStore,
-	relayClient domain.RelayClient,
+	contactStore domain.ContactStore,
+	identities domain.IdentityKeyIndex,
+	relays domain.RelayDirectory,
+	resolver domain.RelayResolver,
+	conversations domain.ConversationService,
+	logger *slog.Logger,
 ) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
 	return &Service{
-		idStore:      idStore,
-		prekeyStore:  prekeyStore,
-		sessionStore: sessionStore,
-		relayClient:  relayClient,
+		idStore:       idStore,
+		prekeyStore:   prekeyStore,
+		sessionStore:  sessionStore,
+		contactStore:  contactStore,
+		identities:    identities,
+		relays:        relays,
+		resolver:      resolver,
+		conversations: conversations,
+		logger:        logger,
 	}
 }
 
@@ -43,16 +102,71 @@ func New(
 //
 // Steps:
 //  1. Load our own identity key pair from the identity store.
-//  2. Fetch the peer's prekey bundle from the relay (contains identity key,
-//     signed prekey, and optionally a one-time prekey).
-//  3. Run X3DH as the initiator to derive the root key and record which prekeys
+//  2. Fetch the peer's prekey bundle (contains identity key, signed prekey,
+//     and optionally a one-time prekey); see locate and fetchBundle for which
+//     relays are asked.
+//  3. Check the bundle against a paired contact, if any, and its signing key
+//     against the one pinned by an earlier session or by pairing; see
+//     verifySignKey.
+//  4. Record which of our contacts attest the peer's identity key in the
+//     bundle (see package attest).
+//  5. Pick the cipher suite: the preferred one if the bundle offers it, else
+//     the first registered suite it offers (see crypto.NegotiateSuite).
+//  6. Run X3DH as the initiator to derive the root key and record which prekeys
 //     were used.
-//  4. Create a Session record and persist it to the session store for future
+//  7. Create a Session record and persist it to the session store for future
 //     message exchanges.
+//
+// peer may be a user@host address (see package address). The session is then
+// stored under the username alone, so later commands name the peer as usual,
+// and a paired contact with that username records the discovered relay.
+//
+// peer may also be an fp:<fingerprint> address, which any relay may answer
+// for; the bundle's identity key must have that fingerprint, else
+// ErrFingerprintMismatch is returned. The session is stored under the
+// address.
 func (s *Service) InitiateSession(
 	ctx context.Context,
 	passphrase string,
 	peer string,
+) (domain.Session, error) {
+	sess, err := s.handshake(ctx, passphrase, peer, nil)
+	if err != nil {
+		return domain.Session{}, err
+	}
+	s.logger.Debug("session established", "peer", peer, "spk_id", sess.SPKID, "opk_id", sess.OPKID)
+	return sess, nil
+}
+
+// RenewSession runs InitiateSession again for a conversation that is being
+// rekeyed. The peer's identity must not change in a rekey, so a bundle with
+// an identity key other than peerIK is refused with ErrRekeyIdentity and the
+// stored session is left as it was.
+//
+// Relays hand out the same one-time prekeys until the peer registers again,
+// so one-time prekeys used by earlier handshakes with peer are skipped.
+func (s *Service) RenewSession(
+	ctx context.Context,
+	passphrase string,
+	peer string,
+	peerIK domain.X25519Public,
+) (domain.Session, error) {
+	sess, err := s.handshake(ctx, passphrase, peer, &peerIK)
+	if err != nil {
+		return domain.Session{}, err
+	}
+	s.logger.Debug("session renewed", "peer", peer, "spk_id", sess.SPKID, "opk_id", sess.OPKID)
+	return sess, nil
+}
+
+// handshake fetches and verifies the peer's bundle, runs X3DH as the
+// initiator and stores the session. If wantIK is set, the bundle must carry
+// that identity key.
+func (s *Service) handshake(
+	ctx context.Context,
+	passphrase string,
+	peer string,
+	wantIK *domain.X25519Public,
 ) (domain.Session, error) {
 	// Load our identity from secure storage.
 	id, err := s.idStore.LoadIdentity(passphrase)
@@ -60,15 +174,89 @@ func (s *Service) InitiateSession(
 		return domain.Session{}, err
 	}
 
-	// Get the peer's current prekey bundle from the relay.
-	bundle, err := s.relayClient.FetchPrekeyBundle(ctx, peer)
+	// Get the peer's current prekey bundle and the relay it lives on,
+	// checking out a one-time prekey no other initiator is given.
+	peer, server, err := s.locate(ctx, peer)
+	if err != nil {
+		return domain.Session{}, err
+	}
+	bundle, server, err := s.fetchAddressed(ctx, peer, server, true)
+	if err != nil {
+		return domain.Session{}, err
+	}
+	s.logger.Debug("peer bundle fetched",
+		"peer", peer,
+		"server", server,
+		"spk_id", bundle.SPKID,
+		"one_time_count", len(bundle.OneTime),
+	)
+	return s.establish(id, peer, server, bundle, wantIK)
+}
+
+// establish verifies bundle, the bundle of peer found on server ("" for one
+// obtained out of band), runs X3DH as the initiator and stores the session.
+// If wantIK is set, the bundle must carry that identity key. Prekey IDs that
+// do not follow the key ID scheme (see package keyid) are refused before they
+// are echoed back in the prekey message.
+func (s *Service) establish(
+	id domain.Identity,
+	peer string,
+	server string,
+	bundle domain.PrekeyBundle,
+	wantIK *domain.X25519Public,
+) (domain.Session, error) {
+	var (
+		spent []domain.KeyID
+		err   error
+	)
+	if err := keyid.CheckBundle(bundle); err != nil {
+		return domain.Session{}, fmt.Errorf("%q: %w", peer, err)
+	}
+	if wantIK != nil {
+		if bundle.IdentityKey != *wantIK {
+			return domain.Session{}, fmt.Errorf("%w: %q", ErrRekeyIdentity, peer)
+		}
+		if spent, err = s.skipSpentOPKs(peer, &bundle); err != nil {
+			return domain.Session{}, err
+		}
+	}
+
+	if err := s.verifySignKey(peer, bundle); err != nil {
+		return domain.Session{}, err
+	}
+	contacts, err := s.contactStore.ListContacts()
+	if err != nil {
+		return domain.Session{}, err
+	}
+	vouched := attest.Vouchers(peer, bundle.IdentityKey, bundle.Attestations, contacts)
+	s.logger.Debug("peer attestations checked",
+		"peer", peer,
+		"attestations", len(bundle.Attestations),
+		"vouched_by", len(vouched),
+	)
+	shared, err := s.sharedIdentity(peer, bundle.IdentityKey)
+	if err != nil {
+		return domain.Session{}, err
+	}
+
+	// The suites are only trusted once the signature over them checks out.
+	if err := x3dh.VerifySPK(bundle); err != nil {
+		return domain.Session{}, fmt.Errorf("%q: %w", peer, err)
+	}
+	preferred, err := s.conversations.Suite()
 	if err != nil {
 		return domain.Session{}, err
 	}
+	offered := x3dh.OfferedSuites(bundle)
+	suite, err := crypto.NegotiateSuite(preferred, offered)
+	if err != nil {
+		return domain.Session{}, fmt.Errorf("%q: %w", peer, err)
+	}
+	s.logger.Debug("cipher suite negotiated", "peer", peer, "suite", suite.ID, "offered", len(offered))
 
 	// Perform X3DH as the initiator to derive the shared root key and identify
 	// which SPK/OPK were used.
-	rk, spkID, opkID, ephPub, err := x3dh.InitiatorRoot(id, bundle)
+	rk, spkID, opkID, ephPub, err := x3dh.InitiatorRoot(id, bundle, suite.ID)
 	if err != nil {
 		return domain.Session{}, err
 	}
@@ -83,19 +271,378 @@ func (s *Service) InitiateSession(
 		SPKID:       spkID,
 		OPKID:       opkID,
 		InitiatorEK: ephPub,
+		Relay:       server,
+		PeerSignKey: bundle.SignKey,
+		PeerSigV:    bundle.SignedPrekeySigV,
+		PeerCaps:    caps.Normalize(bundle.Capabilities),
+		SpentOPKs:   spent,
+		VouchedBy:   vouched,
+		Suite:       suite.ID,
+
+		SharesIdentity: shared,
 	}
 
 	// Persist the session for later retrieval.
 	if err := s.sessionStore.SaveSession(peer, sess); err != nil {
 		return domain.Session{}, err
 	}
+	if server == "" {
+		return sess, nil
+	}
+	if err := s.recordContactRelay(peer, server); err != nil {
+		return domain.Session{}, err
+	}
 	return sess, nil
 }
 
+// InitiateSessionWithBundle runs the handshake of InitiateSession against
+// bundle, which the peer handed over out of band (see
+// domain.PrekeyService.ExportPrekeyBundle) instead of publishing it on a
+// relay we can reach. The bundle is checked as a fetched one would be: against
+// a paired contact and the pinned signing key, and against the fingerprint if
+// peer is an fp:<fingerprint> address.
+//
+// peer defaults to the bundle's username and must otherwise name it, so
+// messages are later addressed to the account the bundle belongs to. The
+// session records no relay; messages go through the default relay or are
+// carried as exported envelopes.
+func (s *Service) InitiateSessionWithBundle(
+	passphrase string,
+	peer string,
+	bundle domain.PrekeyBundle,
+) (domain.Session, error) {
+	if bundle.IdentityKey == (domain.X25519Public{}) || bundle.SignedPrekey == (domain.X25519Public{}) {
+		return domain.Session{}, ErrBadBundle
+	}
+	if a, err := address.Parse(peer); err == nil {
+		peer = a.User
+	}
+	switch {
+	case peer == "":
+		peer = bundle.Username
+	case strings.HasPrefix(peer, address.FingerprintPrefix):
+		fp, err := address.ParseFingerprint(peer)
+		if err != nil {
+			return domain.Session{}, fmt.Errorf("%q: %w", peer, err)
+		}
+		if got := crypto.Fingerprint(bundle.IdentityKey.Slice()); got != fp {
+			return domain.Session{}, fmt.Errorf("%w: %s has %s in the bundle", ErrFingerprintMismatch, peer, got)
+		}
+	case bundle.Username != "" && peer != bundle.Username:
+		return domain.Session{}, fmt.Errorf("%w: %q, not %q", ErrBundleUser, bundle.Username, peer)
+	}
+	if peer == "" {
+		return domain.Session{}, fmt.Errorf("%w: no username", ErrBadBundle)
+	}
+
+	id, err := s.idStore.LoadIdentity(passphrase)
+	if err != nil {
+		return domain.Session{}, err
+	}
+	sess, err := s.establish(id, peer, "", bundle, nil)
+	if err != nil {
+		return domain.Session{}, err
+	}
+	s.logger.Debug("session established from bundle", "peer", peer, "spk_id", sess.SPKID, "opk_id", sess.OPKID)
+	return sess, nil
+}
+
+// locate returns the username to look peer up as and the relay to ask, or ""
+// to ask every known relay.
+//
+// For a user@host address the relay is discovered from host. A bare username
+// is asked on the relay recorded for it by an earlier address, if any: the
+// relay on its contact, else that of an earlier session on a relay we hold no
+// account on.
+func (s *Service) locate(ctx context.Context, peer string) (string, string, error) {
+	if a, err := address.Parse(peer); err == nil {
+		server, err := s.resolver.ResolveRelay(ctx, a.Host)
+		if err != nil {
+			return "", "", fmt.Errorf("finding the relay for %s: %w", a, err)
+		}
+		s.logger.Debug("peer relay resolved", "peer", a.User, "host", a.Host, "server", server)
+		return a.User, server, nil
+	}
+
+	contact, paired, err := s.contactStore.LoadContact(peer)
+	if err != nil {
+		return "", "", err
+	}
+	if paired && contact.Relay != "" {
+		return peer, contact.Relay, nil
+	}
+	prev, ok, err := s.sessionStore.LoadSession(peer)
+	if err != nil || !ok || prev.Relay == "" {
+		return peer, "", err
+	}
+	servers, err := s.relays.Servers()
+	if err != nil {
+		return "", "", err
+	}
+	if !slices.Contains(servers, prev.Relay) {
+		return peer, prev.Relay, nil
+	}
+	return peer, "", nil
+}
+
+// recordContactRelay saves server as the relay of the paired contact peer,
+// if there is one, when it is not one of our own relays.
+func (s *Service) recordContactRelay(peer, server string) error {
+	contact, paired, err := s.contactStore.LoadContact(peer)
+	if err != nil || !paired || contact.Relay == server {
+		return err
+	}
+	servers, err := s.relays.Servers()
+	if err != nil || slices.Contains(servers, server) {
+		return err
+	}
+	contact.Relay = server
+	s.logger.Debug("contact relay recorded", "peer", peer, "server", server)
+	return s.contactStore.SaveContact(contact)
+}
+
+// skipSpentOPKs removes from bundle the one-time prekeys that the stored
+// session with peer, or the ones before it, already used. It returns the
+// spent IDs for the next session to carry.
+func (s *Service) skipSpentOPKs(peer string, bundle *domain.PrekeyBundle) ([]domain.KeyID, error) {
+	old, ok, err := s.sessionStore.LoadSession(peer)
+	if err != nil || !ok {
+		return nil, err
+	}
+	spent := old.SpentOPKs
+	if old.OPKID != "" && !slices.Contains(spent, old.OPKID) {
+		spent = append(spent, old.OPKID)
+	}
+	bundle.OneTime = slices.DeleteFunc(slices.Clone(bundle.OneTime), func(k domain.OneTimePub) bool {
+		return slices.Contains(spent, k.ID)
+	})
+	return spent, nil
+}
+
+// fetchBundle looks peer up on server, or on every known relay if server is
+// empty. With checkout set, the bundle from the relay used is checked out
+// (see domain.RelayClient.CheckoutPrekeyBundle), so its one-time prekey is
+// ours alone; the others are only fetched.
+//
+// The first relay that has the peer (the default relay comes first) is used for
+// the session and for routing messages. If another relay returns a bundle with
+// a different identity key, the username is ambiguous and ErrIdentityConflict
+// is returned rather than guessing which one is genuine.
+func (s *Service) fetchBundle(
+	ctx context.Context,
+	peer string,
+	server string,
+	checkout bool,
+) (domain.PrekeyBundle, string, error) {
+	fetch := func(server string) (domain.PrekeyBundle, error) {
+		c := s.relays.Client(server)
+		if checkout {
+			return c.CheckoutPrekeyBundle(ctx, peer)
+		}
+		return c.FetchPrekeyBundle(ctx, peer, domain.RequestAuth{})
+	}
+	if server != "" {
+		b, err := fetch(server)
+		return b, server, err
+	}
+
+	servers, err := s.relays.Servers()
+	if err != nil {
+		return domain.PrekeyBundle{}, "", err
+	}
+	if len(servers) == 0 {
+		return domain.PrekeyBundle{}, "", ErrNoRelays
+	}
+
+	var (
+		found    domain.PrekeyBundle
+		foundOn  string
+		firstErr error
+	)
+	for _, server := range servers {
+		var (
+			b   domain.PrekeyBundle
+			err error
+		)
+		if foundOn == "" {
+			b, err = fetch(server)
+		} else {
+			b, err = s.relays.Client(server).FetchPrekeyBundle(ctx, peer, domain.RequestAuth{})
+		}
+		if err != nil {
+			if firstErr == nil || errors.Is(firstErr, domain.ErrNotFound) {
+				firstErr = err
+			}
+			continue
+		}
+		if foundOn == "" {
+			found, foundOn = b, server
+			continue
+		}
+		if b.IdentityKey != found.IdentityKey {
+			return domain.PrekeyBundle{}, "", fmt.Errorf("%w: %q on %s and %s",
+				ErrIdentityConflict, peer, foundOn, server)
+		}
+	}
+	if foundOn == "" {
+		return domain.PrekeyBundle{}, "", firstErr
+	}
+	return found, foundOn, nil
+}
+
+// fetchAddressed runs fetchBundle and, if peer is a fingerprint address,
+// checks the bundle's identity key has that fingerprint.
+func (s *Service) fetchAddressed(
+	ctx context.Context,
+	peer string,
+	server string,
+	checkout bool,
+) (domain.PrekeyBundle, string, error) {
+	var fp string
+	if strings.HasPrefix(peer, address.FingerprintPrefix) {
+		var err error
+		if fp, err = address.ParseFingerprint(peer); err != nil {
+			return domain.PrekeyBundle{}, "", fmt.Errorf("%q: %w", peer, err)
+		}
+	}
+	bundle, server, err := s.fetchBundle(ctx, peer, server, checkout)
+	if err != nil || fp == "" {
+		return bundle, server, err
+	}
+	if got := crypto.Fingerprint(bundle.IdentityKey.Slice()); got != fp {
+		return domain.PrekeyBundle{}, "", fmt.Errorf("%w: %s has %s on %s", ErrFingerprintMismatch, peer, got, server)
+	}
+	return bundle, server, nil
+}
+
+// AddContact records the peer at the fingerprint address peer as a contact,
+// as pairing would, without a pairing code: the fingerprint, given out of
+// band, already names their identity key. Their bundle is fetched to learn
+// the full identity and signing keys, which must match the fingerprint and
+// chain as in InitiateSession, and both are then pinned. peer may carry a
+// host (fp:<fingerprint>@host) whose relay is recorded on the contact.
+func (s *Service) AddContact(ctx context.Context, peer string) (domain.Contact, error) {
+	peer, server, err := s.locate(ctx, peer)
+	if err != nil {
+		return domain.Contact{}, err
+	}
+	if !address.IsFingerprint(peer) {
+		return domain.Contact{}, fmt.Errorf("%q: %w", peer, address.ErrBadFingerprint)
+	}
+	bundle, server, err := s.fetchAddressed(ctx, peer, server, false)
+	if err != nil {
+		return domain.Contact{}, err
+	}
+	if err := s.verifySignKey(peer, bundle); err != nil {
+		return domain.Contact{}, err
+	}
+
+	c := domain.Contact{
+		Username:    peer,
+		IdentityKey: bundle.IdentityKey,
+		SignKey:     bundle.SignKey,
+		PairedUTC:   time.Now().Unix(),
+	}
+	servers, err := s.relays.Servers()
+	if err != nil {
+		return domain.Contact{}, err
+	}
+	if !slices.Contains(servers, server) {
+		c.Relay = server
+	}
+	if err := s.contactStore.SaveContact(c); err != nil {
+		return domain.Contact{}, err
+	}
+	s.logger.Debug("contact added by fingerprint", "peer", peer, "server", server)
+	return c, nil
+}
+
+// sharedIdentity returns the other peers stored under ik, the identity key
+// peer presents. An fp:<fingerprint> address naming ik is the same identity
+// by construction, not another account, and is left out.
+func (s *Service) sharedIdentity(peer string, ik domain.X25519Public) ([]string, error) {
+	peers, err := s.identities.PeersWithIdentity(ik)
+	if err != nil {
+		return nil, err
+	}
+	fp := crypto.Fingerprint(ik.Slice())
+	peers = slices.DeleteFunc(peers, func(p string) bool {
+		if p == peer {
+			return true
+		}
+		got, err := address.ParseFingerprint(p)
+		return err == nil && got == fp
+	})
+	if len(peers) > 0 {
+		s.logger.Debug("peer identity key shared", "peer", peer, "with", len(peers))
+	}
+	return peers, nil
+}
+
+// verifySignKey checks the bundle's identity and signing-key chain.
+//
+// If the peer is a paired contact, the bundle's identity key must be the one
+// received when pairing. The signing key must then be the pinned key or
+// reachable from it through the chain. The pin is the key seen by an earlier
+// session with the same identity, else the one received when pairing. With
+// neither, the chain only has to be internally consistent (trust on first use).
+//
+// The signed prekey signature may not be in an older form (see
+// domain.SigVersion) than the earlier session with the same identity saw, so
+// whoever serves the bundle cannot fall back to raw signatures once the peer
+// has moved on from them.
+func (s *Service) verifySignKey(peer string, bundle domain.PrekeyBundle) error {
+	contact, paired, err := s.contactStore.LoadContact(peer)
+	if err != nil {
+		return err
+	}
+	if paired && contact.IdentityKey != bundle.IdentityKey {
+		return fmt.Errorf("%w: %q", ErrContactMismatch, peer)
+	}
+
+	prev, ok, err := s.sessionStore.LoadSession(peer)
+	if err != nil {
+		return err
+	}
+	var zero, pin domain.Ed25519Public
+	switch {
+	case ok && prev.PeerIK == bundle.IdentityKey && prev.PeerSignKey != zero:
+		pin = prev.PeerSignKey
+	case paired:
+		pin = contact.SignKey
+	}
+	if ok && prev.PeerIK == bundle.IdentityKey && bundle.SignedPrekeySigV < prev.PeerSigV {
+		return fmt.Errorf("%w: %q", ErrSigDowngrade, peer)
+	}
+	if pin == zero {
+		err = signchain.Check(bundle.IdentityKey, bundle.SignChain, bundle.SignKey)
+	} else {
+		err = signchain.Verify(bundle.IdentityKey, bundle.SignChain, pin, bundle.SignKey)
+	}
+	if err != nil {
+		return fmt.Errorf("peer %q: %w", peer, err)
+	}
+	if pin != zero && pin != bundle.SignKey {
+		s.logger.Debug("peer signing key rotated", "peer", peer, "rotations", len(bundle.SignChain))
+	}
+	return nil
+}
+
 // Get retrieves a stored session for the given peer from the session store.
 func (s *Service) GetSession(peer string) (domain.Session, bool, error) {
 	return s.sessionStore.LoadSession(peer)
 }
 
+// DeleteSession forgets the session with peer. A new one must be initiated
+// before messaging the peer again.
+func (s *Service) DeleteSession(peer string) (bool, error) {
+	ok, err := s.sessionStore.DeleteSession(peer)
+	if err != nil {
+		return false, err
+	}
+	s.logger.Debug("session deleted", "peer", peer, "existed", ok)
+	return ok, nil
+}
+
 // Compile-time assertion that Service implements domain.SessionService.
 var _ domain.SessionService = (*Service)(nil)
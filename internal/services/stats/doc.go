@@ -0,0 +1,13 @@
+// Package stats manages opt-in ratchet statistics and exports them for
+// research.
+//
+// Collection is off by default. While it is on, the message service keeps a
+// domain.RatchetStats on each conversation: messages sent and received, DH
+// ratchet steps, messages decrypted out of order with a skipped key, the
+// most skipped keys held at once, and a histogram of ciphertext sizes. No
+// bodies, peers or timestamps are recorded.
+//
+// Export replaces each peer with a label (c1, c2, ...) assigned in random
+// order, so rows cannot be matched to peers by position. Turning collection
+// off discards every counter.
+package stats
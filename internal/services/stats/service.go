@@ -0,0 +1,125 @@
+package stats
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+
+	"ciphera/internal/domain"
+)
+
+// ExportVersion is the version of the StatsExport layout.
+const ExportVersion = 1
+
+// Service turns statistics collection on and off and exports what was
+// collected.
+type Service struct {
+	ratchetStore  domain.RatchetStore
+	conversations domain.ConversationService
+	logger        *slog.Logger
+}
+
+// New returns a stats service over the conversations in ratchetStore, with
+// the collection setting kept by conversations.
+//
+// If logger is nil, log output is discarded.
+func New(
+	ratchetStore domain.RatchetStore,
+	conversations domain.ConversationService,
+	logger *slog.Logger,
+) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	return &Service{ratchetStore: ratchetStore, conversations: conversations, logger: logger}
+}
+
+// SetEnabled turns collection on or off. Turning it off also discards the
+// counters already collected.
+func (s *Service) SetEnabled(on bool) error {
+	if err := s.conversations.SetCollectStats(on); err != nil {
+		return err
+	}
+	if on {
+		return nil
+	}
+	convs, err := s.ratchetStore.ListConversations()
+	if err != nil {
+		return err
+	}
+	discarded := 0
+	for _, c := range convs {
+		if c.Stats == nil {
+			continue
+		}
+		if err := s.discard(c.Peer); err != nil {
+			return fmt.Errorf("discard statistics: %w", err)
+		}
+		discarded++
+	}
+	s.logger.Debug("statistics discarded", "conversations", discarded)
+	return nil
+}
+
+// discard clears the statistics of peer's conversation, reloading it under
+// its lock so a send or receive running alongside is not rolled back.
+func (s *Service) discard(peer string) error {
+	unlock, err := s.ratchetStore.LockConversation(peer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	c, found, err := s.ratchetStore.LoadConversation(peer)
+	if err != nil || !found || c.Stats == nil {
+		return err
+	}
+	c.Stats = nil
+	return s.ratchetStore.SaveConversation(peer, c)
+}
+
+// Enabled reports whether statistics are being collected.
+func (s *Service) Enabled() (bool, error) {
+	return s.conversations.CollectStats()
+}
+
+// Export returns the collected statistics with peers replaced by labels.
+// Conversations without counters are left out.
+func (s *Service) Export() (domain.StatsExport, error) {
+	convs, err := s.ratchetStore.ListConversations()
+	if err != nil {
+		return domain.StatsExport{}, err
+	}
+	rows := []domain.ConversationStats{}
+	for _, c := range convs {
+		if c.Stats == nil {
+			continue
+		}
+		sizes := make([]int, len(domain.StatsSizeBounds)+1)
+		copy(sizes, c.Stats.CipherSizes)
+		rows = append(rows, domain.ConversationStats{
+			Sent:        c.Stats.Sent,
+			Received:    c.Stats.Received,
+			DHSteps:     c.Stats.DHSteps,
+			OutOfOrder:  c.Stats.OutOfOrder,
+			SkippedNow:  len(c.State.Skipped),
+			SkippedMax:  c.Stats.SkippedMax,
+			CipherSizes: sizes,
+		})
+	}
+
+	// The store lists conversations by peer; shuffle so labels do not leak
+	// that order.
+	rand.Shuffle(len(rows), func(i, j int) { rows[i], rows[j] = rows[j], rows[i] })
+	for i := range rows {
+		rows[i].Label = fmt.Sprintf("c%d", i+1)
+	}
+	return domain.StatsExport{
+		Version:       ExportVersion,
+		SizeBounds:    slices.Clone(domain.StatsSizeBounds),
+		Conversations: rows,
+	}, nil
+}
+
+var _ domain.StatsService = (*Service)(nil)
@@ -0,0 +1,12 @@
+// Package undo keeps a local log of the state-changing commands run on this
+// client and reverts the newest one that can be.
+//
+// Each entry records what the command replaced: the signed prekey before a
+// register, the receive filters before a change, the peer of an archived
+// conversation. Undo puts that back: it republishes the earlier signed
+// prekey within a grace period, restores the filters, or unarchives the
+// conversation. Some commands cannot be reverted, such as a signing key
+// rotation, which peers only follow forward; Undo passes over those and says
+// why through Blocker. Nothing here reaches the relay except through the
+// services it calls.
+package undo
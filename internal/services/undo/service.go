@@ -0,0 +1,188 @@
+package undo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+// registerGrace is how long after a register its signed prekey may be
+// swapped back for the one before. Older prekeys should not come back into
+// use; past this, register again instead.
+const registerGrace = 7 * 24 * time.Hour
+
+var (
+	// ErrNothingToUndo is returned when no action in the log can be undone.
+	ErrNothingToUndo = errors.New("nothing to undo")
+	// ErrBadAction is returned for an action missing what undoing it needs.
+	ErrBadAction = errors.New("malformed action")
+)
+
+// Service records actions in the action log and reverts them.
+type Service struct {
+	actions       domain.ActionStore
+	archives      domain.ArchiveStore
+	prekeys       domain.PrekeyService
+	accounts      domain.AccountService
+	backups       domain.BackupService
+	conversations domain.ConversationService
+	logger        *slog.Logger
+}
+
+// New returns an undo service keeping its log in actions and reverting
+// actions through the given services.
+//
+// If logger is nil, log output is discarded.
+func New(
+	actions domain.ActionStore,
+	archives domain.ArchiveStore,
+	prekeys domain.PrekeyService,
+	accounts domain.AccountService,
+	backups domain.BackupService,
+	conversations domain.ConversationService,
+	logger *slog.Logger,
+) *Service {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	return &Service{
+		actions:       actions,
+		archives:      archives,
+		prekeys:       prekeys,
+		accounts:      accounts,
+		backups:       backups,
+		conversations: conversations,
+		logger:        logger,
+	}
+}
+
+// Record appends a to the log, stamped with the current time.
+func (s *Service) Record(a domain.Action) (domain.Action, error) {
+	switch a.Kind {
+	case domain.ActionRegister:
+		if a.Username == "" || len(a.Servers) == 0 || a.After == "" {
+			return domain.Action{}, fmt.Errorf("%w: register needs a username, relays and a signed prekey", ErrBadAction)
+		}
+	case domain.ActionArchive:
+		if a.Peer == "" {
+			return domain.Action{}, fmt.Errorf("%w: archive needs a peer", ErrBadAction)
+		}
+	case domain.ActionFilters:
+		if a.Filters == nil {
+			return domain.Action{}, fmt.Errorf("%w: filters change needs the filters it replaced", ErrBadAction)
+		}
+	case domain.ActionRotateSigningKey:
+	default:
+		return domain.Action{}, fmt.Errorf("%w: unknown kind %q", ErrBadAction, a.Kind)
+	}
+	a.ID, a.UndoneUTC = 0, 0
+	a.TimeUTC = time.Now().Unix()
+	a, err := s.actions.AppendAction(a)
+	if err != nil {
+		return domain.Action{}, err
+	}
+	s.logger.Debug("action recorded", "id", a.ID, "kind", a.Kind)
+	return a, nil
+}
+
+// Actions returns the log, newest first.
+func (s *Service) Actions() ([]domain.Action, error) {
+	log, err := s.actions.ListActions()
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(log)
+	return log, nil
+}
+
+// Blocker says why a cannot be undone now, or "" if it can:
+//   - a register only within registerGrace, while the signed prekey it
+//     published is still current, and not the first, which replaced none;
+//   - an archive only while the conversation is still archived;
+//   - a signing key rotation never, as peers follow rotations forward only.
+func (s *Service) Blocker(a domain.Action) (string, error) {
+	if a.UndoneUTC != 0 {
+		return "already undone", nil
+	}
+	switch a.Kind {
+	case domain.ActionRegister:
+		if a.Before == "" {
+			return "it published the first signed prekey", nil
+		}
+		if time.Since(time.Unix(a.TimeUTC, 0)) > registerGrace {
+			return fmt.Sprintf("past the %s grace period; register again instead", registerGrace), nil
+		}
+		st, err := s.prekeys.Status()
+		if err != nil {
+			return "", err
+		}
+		// Current is Before again if an earlier undo published to only
+		// some relays; trying again finishes it.
+		if cur := string(st.SignedPrekeyID); cur != a.After && cur != a.Before {
+			return "the signed prekey has been rotated since", nil
+		}
+	case domain.ActionArchive:
+		archived, err := s.archives.Archived(a.Peer)
+		if err != nil {
+			return "", err
+		}
+		if !archived {
+			return "the conversation is no longer archived", nil
+		}
+	case domain.ActionRotateSigningKey:
+		return "peers follow signing key rotations forward only", nil
+	}
+	return "", nil
+}
+
+// Undo reverts the newest action in the log that Blocker allows and marks
+// it undone, passing over actions that cannot be undone. historyPassphrase
+// opens the history of an archived conversation.
+func (s *Service) Undo(ctx context.Context, passphrase, historyPassphrase string) (domain.Action, error) {
+	log, err := s.Actions()
+	if err != nil {
+		return domain.Action{}, err
+	}
+	for _, a := range log {
+		why, err := s.Blocker(a)
+		if err != nil {
+			return domain.Action{}, err
+		}
+		if why != "" {
+			continue
+		}
+		if err := s.revert(ctx, passphrase, historyPassphrase, a); err != nil {
+			return a, err
+		}
+		a.UndoneUTC = time.Now().Unix()
+		if _, err := s.actions.MarkActionUndone(a.ID, a.UndoneUTC); err != nil {
+			return a, err
+		}
+		s.logger.Debug("action undone", "id", a.ID, "kind", a.Kind)
+		return a, nil
+	}
+	return domain.Action{}, ErrNothingToUndo
+}
+
+// revert puts back what a replaced.
+func (s *Service) revert(ctx context.Context, passphrase, historyPassphrase string, a domain.Action) error {
+	switch a.Kind {
+	case domain.ActionRegister:
+		_, err := s.accounts.RestorePrekeys(ctx, passphrase, a.Username, a.Servers, domain.KeyID(a.Before))
+		return err
+	case domain.ActionArchive:
+		_, err := s.backups.UnarchiveConversation(passphrase, historyPassphrase, a.Peer)
+		return err
+	case domain.ActionFilters:
+		return s.conversations.SetReceiveFilters(*a.Filters)
+	}
+	return fmt.Errorf("%w: %q", ErrBadAction, a.Kind)
+}
+
+// Compile-time assertion that Service implements domain.UndoService.
+var _ domain.UndoService = (*Service)(nil)
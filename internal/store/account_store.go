@@ -0,0 +1,71 @@
+package store
+
+import (
+	"path/filepath"
+	"sort"
+
+	"ciphera/internal/domain"
+)
+
+const accountsFilename = "accounts.json"
+
+// AccountFileStore persists the relays we have registered on.
+type AccountFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewAccountFileStore returns an AccountFileStore rooted at dir.
+func NewAccountFileStore(dir string) *AccountFileStore {
+	return &AccountFileStore{dir: dir, mu: storeLock{path: lockPath(dir, accountsFilename)}}
+}
+
+// SaveAccount records a, replacing any entry for the same (server, username).
+func (s *AccountFileStore) SaveAccount(a domain.Account) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, accountsFilename)
+	m := map[string]domain.Account{}
+	_ = readJSON(path, &m)
+	m[accountKey(a.Server, a.Username)] = a
+	return writeJSON(path, m, 0o600)
+}
+
+// ListAccounts returns all accounts ordered by server, then username.
+func (s *AccountFileStore) ListAccounts() ([]domain.Account, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, accountsFilename)
+	m := map[string]domain.Account{}
+	if err := readJSON(path, &m); err != nil {
+		return nil, err
+	}
+	out := make([]domain.Account, 0, len(m))
+	for _, a := range m {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Server != out[j].Server {
+			return out[i].Server < out[j].Server
+		}
+		return out[i].Username < out[j].Username
+	})
+	return out, nil
+}
+
+// accountKey is the map key for (server, username). A relay URL cannot contain
+// a raw space, so the first space always separates the two parts.
+func accountKey(server, username string) string {
+	return server + " " + username
+}
+
+// Compile-time assertion that AccountFileStore implements domain.AccountStore.
+var _ domain.AccountStore = (*AccountFileStore)(nil)
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"path/filepath"
+
+	"ciphera/internal/domain"
+)
+
+const actionsFilename = "actions.json"
+
+// maxActions bounds the action log; the oldest entries are dropped first.
+const maxActions = 100
+
+// ActionFileStore persists the log of state-changing commands, oldest first.
+type ActionFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewActionFileStore returns an ActionFileStore rooted at dir.
+func NewActionFileStore(dir string) *ActionFileStore {
+	return &ActionFileStore{dir: dir, mu: storeLock{path: lockPath(dir, actionsFilename)}}
+}
+
+// AppendAction records a under the ID after the newest entry's and returns
+// it. IDs are never reused, also once old entries are dropped.
+func (s *ActionFileStore) AppendAction(a domain.Action) (domain.Action, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return domain.Action{}, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, actionsFilename)
+	var log []domain.Action
+	if err := readJSON(path, &log); err != nil {
+		return domain.Action{}, err
+	}
+	a.ID = 1
+	if n := len(log); n > 0 {
+		a.ID = log[n-1].ID + 1
+	}
+	log = append(log, a)
+	if len(log) > maxActions {
+		log = log[len(log)-maxActions:]
+	}
+	return a, writeJSON(path, log, 0o600)
+}
+
+// ListActions returns the action log, oldest first.
+func (s *ActionFileStore) ListActions() ([]domain.Action, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var log []domain.Action
+	if err := readJSON(filepath.Join(s.dir, actionsFilename), &log); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// MarkActionUndone records that action id was undone at utc and reports
+// whether the log holds it.
+func (s *ActionFileStore) MarkActionUndone(id int, utc int64) (bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, actionsFilename)
+	var log []domain.Action
+	if err := readJSON(path, &log); err != nil {
+		return false, err
+	}
+	for i := range log {
+		if log[i].ID == id {
+			log[i].UndoneUTC = utc
+			return true, writeJSON(path, log, 0o600)
+		}
+	}
+	return false, nil
+}
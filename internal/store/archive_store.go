@@ -0,0 +1,154 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"ciphera/internal/domain"
+)
+
+const (
+	// archiveDirname holds one encrypted archived conversation per peer,
+	// named like the peer's conversation record.
+	archiveDirname = "archive"
+	archiveExt     = ".json.enc"
+)
+
+// ArchiveFileStore persists archived conversations, encrypted under the
+// identity passphrase in the same format as the history file. Nothing here
+// is read unless a conversation is archived, restored or listed.
+type ArchiveFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewArchiveFileStore returns an ArchiveFileStore rooted at dir.
+func NewArchiveFileStore(dir string) *ArchiveFileStore {
+	return &ArchiveFileStore{dir: dir, mu: storeLock{path: lockPath(dir, archiveDirname)}}
+}
+
+// SaveArchive encrypts and writes a, replacing any archive for its peer.
+func (s *ArchiveFileStore) SaveArchive(passphrase string, a domain.ConversationArchive) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	raw, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	N, r, p := scryptParamsDefault()
+	ct, err := encrypt(passphrase, raw, N, r, p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, archiveDirname), 0o700); err != nil {
+		return err
+	}
+	return writeFile(s.path(a.Peer), ct, 0o600)
+}
+
+// LoadArchive decrypts peer's archive. A missing file is not an error.
+func (s *ArchiveFileStore) LoadArchive(passphrase, peer string) (domain.ConversationArchive, bool, error) {
+	b, err := readFile(s.path(peer))
+	if err != nil || b == nil {
+		return domain.ConversationArchive{}, false, err
+	}
+	a, err := openArchive(passphrase, b)
+	if err != nil {
+		return domain.ConversationArchive{}, false, err
+	}
+	if a.Peer != peer {
+		return domain.ConversationArchive{}, false, fmt.Errorf("archive for %q holds %q", peer, a.Peer)
+	}
+	return a, true, nil
+}
+
+// ListArchives decrypts every archive, ordered by when it was archived.
+func (s *ArchiveFileStore) ListArchives(passphrase string) ([]domain.ConversationArchive, error) {
+	des, err := os.ReadDir(filepath.Join(s.dir, archiveDirname))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []domain.ConversationArchive
+	for _, de := range des {
+		if de.IsDir() || !strings.HasSuffix(de.Name(), archiveExt) {
+			continue
+		}
+		b, err := readFile(filepath.Join(s.dir, archiveDirname, de.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			continue
+		}
+		a, err := openArchive(passphrase, b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", de.Name(), err)
+		}
+		out = append(out, a)
+	}
+	slices.SortFunc(out, func(a, b domain.ConversationArchive) int {
+		if a.ArchivedUTC != b.ArchivedUTC {
+			return int(a.ArchivedUTC - b.ArchivedUTC)
+		}
+		return strings.Compare(a.Peer, b.Peer)
+	})
+	return out, nil
+}
+
+// DeleteArchive removes peer's archive and reports whether there was one.
+func (s *ArchiveFileStore) DeleteArchive(peer string) (bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	err = os.Remove(s.path(peer))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Archived reports whether peer has an archive.
+func (s *ArchiveFileStore) Archived(peer string) (bool, error) {
+	_, err := os.Stat(s.path(peer))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// path returns peer's archive file.
+func (s *ArchiveFileStore) path(peer string) string {
+	return filepath.Join(s.dir, archiveDirname, peerFilename(peer)+archiveExt)
+}
+
+// openArchive decrypts and decodes an archive file.
+func openArchive(passphrase string, b []byte) (domain.ConversationArchive, error) {
+	pt, err := decrypt(passphrase, b)
+	if err != nil {
+		return domain.ConversationArchive{}, err
+	}
+	var a domain.ConversationArchive
+	if err := json.Unmarshal(pt, &a); err != nil {
+		return domain.ConversationArchive{}, err
+	}
+	return a, nil
+}
+
+// Compile-time assertion that ArchiveFileStore implements domain.ArchiveStore.
+var _ domain.ArchiveStore = (*ArchiveFileStore)(nil)
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"sort"
+
+	"ciphera/internal/domain"
+)
+
+const attestationsFilename = "attestations.json"
+
+// AttestationFileStore persists attestations about our identity, keyed by the
+// attester's identity key (hex).
+type AttestationFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewAttestationFileStore returns an AttestationFileStore rooted at dir.
+func NewAttestationFileStore(dir string) *AttestationFileStore {
+	return &AttestationFileStore{dir: dir, mu: storeLock{path: lockPath(dir, attestationsFilename)}}
+}
+
+// SaveAttestation records a, replacing any earlier attestation by the same
+// attester.
+func (s *AttestationFileStore) SaveAttestation(a domain.Attestation) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, attestationsFilename)
+	m := map[string]domain.Attestation{}
+	if err := readJSON(path, &m); err != nil {
+		return err
+	}
+	m[hex.EncodeToString(a.AttesterKey[:])] = a
+	return writeJSON(path, m, 0o600)
+}
+
+// ListAttestations returns all attestations ordered by attester username.
+func (s *AttestationFileStore) ListAttestations() ([]domain.Attestation, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, attestationsFilename)
+	m := map[string]domain.Attestation{}
+	if err := readJSON(path, &m); err != nil {
+		return nil, err
+	}
+	out := make([]domain.Attestation, 0, len(m))
+	for _, a := range m {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Attester < out[j].Attester })
+	return out, nil
+}
+
+// Compile-time assertion that AttestationFileStore implements domain.AttestationStore.
+var _ domain.AttestationStore = (*AttestationFileStore)(nil)
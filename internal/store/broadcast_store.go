@@ -0,0 +1,99 @@
+package store
+
+import (
+	"path/filepath"
+	"sort"
+
+	"ciphera/internal/domain"
+)
+
+const broadcastsFilename = "broadcasts.json"
+
+// BroadcastFileStore persists broadcast lists, keyed by name.
+type BroadcastFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewBroadcastFileStore returns a BroadcastFileStore rooted at dir.
+func NewBroadcastFileStore(dir string) *BroadcastFileStore {
+	return &BroadcastFileStore{dir: dir, mu: storeLock{path: lockPath(dir, broadcastsFilename)}}
+}
+
+// SaveBroadcast records l, replacing any list with the same name.
+func (s *BroadcastFileStore) SaveBroadcast(l domain.BroadcastList) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, broadcastsFilename)
+	m := map[string]domain.BroadcastList{}
+	if err := readJSON(path, &m); err != nil {
+		return err
+	}
+	m[l.Name] = l
+	return writeJSON(path, m, 0o600)
+}
+
+// LoadBroadcast returns the list called name, if there is one.
+func (s *BroadcastFileStore) LoadBroadcast(name string) (domain.BroadcastList, bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return domain.BroadcastList{}, false, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, broadcastsFilename)
+	m := map[string]domain.BroadcastList{}
+	if err := readJSON(path, &m); err != nil {
+		return domain.BroadcastList{}, false, err
+	}
+	l, ok := m[name]
+	return l, ok, nil
+}
+
+// ListBroadcasts returns all lists ordered by name.
+func (s *BroadcastFileStore) ListBroadcasts() ([]domain.BroadcastList, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, broadcastsFilename)
+	m := map[string]domain.BroadcastList{}
+	if err := readJSON(path, &m); err != nil {
+		return nil, err
+	}
+	out := make([]domain.BroadcastList, 0, len(m))
+	for _, l := range m {
+		out = append(out, l)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// DeleteBroadcast removes the list called name and reports whether it existed.
+func (s *BroadcastFileStore) DeleteBroadcast(name string) (bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, broadcastsFilename)
+	m := map[string]domain.BroadcastList{}
+	if err := readJSON(path, &m); err != nil {
+		return false, err
+	}
+	if _, ok := m[name]; !ok {
+		return false, nil
+	}
+	delete(m, name)
+	return true, writeJSON(path, m, 0o600)
+}
+
+// Compile-time assertion that BroadcastFileStore implements domain.BroadcastStore.
+var _ domain.BroadcastStore = (*BroadcastFileStore)(nil)
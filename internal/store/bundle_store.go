@@ -2,7 +2,6 @@ package store
 
 import (
 	"path/filepath"
-	"sync"
 
 	"ciphera/internal/domain"
 )
@@ -12,18 +11,21 @@ const bundleFile = "bundle.json"
 // BundleFileStore caches the last prekey bundle you registered.
 type BundleFileStore struct {
 	dir string
-	mu  sync.Mutex
+	mu  storeLock
 }
 
 // NewBundleFileStore returns a BundleFileStore rooted at dir.
 func NewBundleFileStore(dir string) *BundleFileStore {
-	return &BundleFileStore{dir: dir}
+	return &BundleFileStore{dir: dir, mu: storeLock{path: lockPath(dir, bundleFile)}}
 }
 
 // SavePrekeyBundle writes the bundle to disk.
 func (s *BundleFileStore) SavePrekeyBundle(b domain.PrekeyBundle) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	path := filepath.Join(s.dir, bundleFile)
 	return writeJSON(path, b, 0o600)
@@ -33,8 +35,11 @@ func (s *BundleFileStore) SavePrekeyBundle(b domain.PrekeyBundle) error {
 //
 // Parameter username is accepted for interface compatibility but not used for the local cache.
 func (s *BundleFileStore) LoadPrekeyBundle(username string) (domain.PrekeyBundle, bool, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return domain.PrekeyBundle{}, false, err
+	}
+	defer unlock()
 
 	path := filepath.Join(s.dir, bundleFile)
 
@@ -0,0 +1,240 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// A minimal CBOR (RFC 8949) codec for the binary store records. It covers
+// only what the records use: unsigned and negative integers, byte and text
+// strings, arrays, maps and booleans, all with definite lengths. Writers emit
+// the shortest head for every length, so encodings are deterministic as long
+// as callers write map keys in a fixed order.
+const (
+	cborUint  = 0
+	cborNeg   = 1
+	cborBytes = 2
+	cborText  = 3
+	cborArray = 4
+	cborMap   = 5
+	cborOther = 7
+
+	cborFalse = cborOther<<5 | 20
+	cborTrue  = cborOther<<5 | 21
+)
+
+var errCBOR = errors.New("malformed CBOR")
+
+// cborWriter appends CBOR items to b.
+type cborWriter struct {
+	b []byte
+}
+
+// head appends the initial byte and argument of an item of the given major type.
+func (w *cborWriter) head(major byte, n uint64) {
+	m := major << 5
+	switch {
+	case n < 24:
+		w.b = append(w.b, m|byte(n))
+	case n <= math.MaxUint8:
+		w.b = append(w.b, m|24, byte(n))
+	case n <= math.MaxUint16:
+		w.b = binary.BigEndian.AppendUint16(append(w.b, m|25), uint16(n))
+	case n <= math.MaxUint32:
+		w.b = binary.BigEndian.AppendUint32(append(w.b, m|26), uint32(n))
+	default:
+		w.b = binary.BigEndian.AppendUint64(append(w.b, m|27), n)
+	}
+}
+
+func (w *cborWriter) uint(n uint64) { w.head(cborUint, n) }
+
+func (w *cborWriter) int(n int64) {
+	if n < 0 {
+		w.head(cborNeg, uint64(-1-n))
+		return
+	}
+	w.head(cborUint, uint64(n))
+}
+
+func (w *cborWriter) bytes(b []byte) {
+	w.head(cborBytes, uint64(len(b)))
+	w.b = append(w.b, b...)
+}
+
+func (w *cborWriter) text(s string) {
+	w.head(cborText, uint64(len(s)))
+	w.b = append(w.b, s...)
+}
+
+func (w *cborWriter) bool(v bool) {
+	if v {
+		w.b = append(w.b, cborTrue)
+	} else {
+		w.b = append(w.b, cborFalse)
+	}
+}
+
+func (w *cborWriter) array(n int) { w.head(cborArray, uint64(n)) }
+
+// cborMapWriter collects the entries of a map with unsigned integer keys, so
+// optional entries can be skipped without knowing the count up front.
+type cborMapWriter struct {
+	w cborWriter
+	n int
+}
+
+// key starts the entry k and returns the writer for its value.
+func (m *cborMapWriter) key(k uint64) *cborWriter {
+	m.n++
+	m.w.uint(k)
+	return &m.w
+}
+
+// writeMap appends the map collected in m.
+func (w *cborWriter) writeMap(m *cborMapWriter) {
+	w.head(cborMap, uint64(m.n))
+	w.b = append(w.b, m.w.b...)
+}
+
+// cborReader decodes CBOR items from b. The first error sticks: later reads
+// return zero values, so a decoder checks err once at the end.
+type cborReader struct {
+	b   []byte
+	err error
+}
+
+// head reads the initial byte and argument of the next item, which must be of
+// the given major type.
+func (r *cborReader) head(major byte) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.b) == 0 || r.b[0]>>5 != major {
+		r.err = errCBOR
+		return 0
+	}
+	info := r.b[0] & 0x1f
+	r.b = r.b[1:]
+	size := 0
+	switch {
+	case info < 24:
+		return uint64(info)
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default: // indefinite lengths and reserved values are not used
+		r.err = errCBOR
+		return 0
+	}
+	if len(r.b) < size {
+		r.err = errCBOR
+		return 0
+	}
+	var n uint64
+	for _, c := range r.b[:size] {
+		n = n<<8 | uint64(c)
+	}
+	r.b = r.b[size:]
+	return n
+}
+
+func (r *cborReader) uint() uint64 { return r.head(cborUint) }
+
+// uint32 reads an unsigned integer that must fit in 32 bits.
+func (r *cborReader) uint32() uint32 {
+	n := r.uint()
+	if n > math.MaxUint32 {
+		r.fail()
+	}
+	return uint32(n)
+}
+
+func (r *cborReader) int() int64 {
+	if r.err == nil && len(r.b) > 0 && r.b[0]>>5 == cborNeg {
+		n := r.head(cborNeg)
+		if n > math.MaxInt64 {
+			r.fail()
+			return 0
+		}
+		return -1 - int64(n)
+	}
+	n := r.uint()
+	if n > math.MaxInt64 {
+		r.fail()
+		return 0
+	}
+	return int64(n)
+}
+
+// length reads the head of a string, array or map whose items take at least
+// one byte each, so a corrupt length cannot make the caller allocate more
+// than the input could hold.
+func (r *cborReader) length(major byte) int {
+	n := r.head(major)
+	if n > uint64(len(r.b)) {
+		r.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (r *cborReader) bytes() []byte {
+	n := r.length(cborBytes)
+	if r.err != nil {
+		return nil
+	}
+	b := append([]byte(nil), r.b[:n]...)
+	r.b = r.b[n:]
+	return b
+}
+
+// fixed reads a byte string of exactly len(dst) bytes into dst.
+func (r *cborReader) fixed(dst []byte) {
+	b := r.bytes()
+	if r.err == nil && len(b) != len(dst) {
+		r.fail()
+		return
+	}
+	copy(dst, b)
+}
+
+func (r *cborReader) text() string {
+	n := r.length(cborText)
+	if r.err != nil {
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+func (r *cborReader) bool() bool {
+	if r.err != nil {
+		return false
+	}
+	if len(r.b) == 0 || (r.b[0] != cborTrue && r.b[0] != cborFalse) {
+		r.fail()
+		return false
+	}
+	v := r.b[0] == cborTrue
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *cborReader) array() int { return r.length(cborArray) }
+
+func (r *cborReader) mapLen() int { return r.length(cborMap) }
+
+// fail records errCBOR unless an error is already recorded.
+func (r *cborReader) fail() {
+	if r.err == nil {
+		r.err = errCBOR
+	}
+}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+const (
+	// chunkDirname holds one encrypted file of partial messages per peer,
+	// named like the peer's conversation record.
+	chunkDirname = "chunks"
+	chunkExt     = ".json.enc"
+	// maxPartialMessages bounds the partial messages kept per peer; the one
+	// whose last part arrived longest ago goes first.
+	maxPartialMessages = 16
+	// maxChunkAge is how long a partial message waits for its missing parts.
+	maxChunkAge = 7 * 24 * time.Hour
+)
+
+// ChunkFileStore persists received parts of chunked messages, encrypted under
+// the identity passphrase in the same format as the history file.
+type ChunkFileStore struct {
+	dir string
+	mu  storeLock
+	now func() time.Time
+}
+
+// NewChunkFileStore returns a ChunkFileStore rooted at dir.
+func NewChunkFileStore(dir string) *ChunkFileStore {
+	return &ChunkFileStore{dir: dir, mu: storeLock{path: lockPath(dir, chunkDirname)}, now: time.Now}
+}
+
+// SaveChunk adds part to peer's partial messages, replacing a part with the
+// same index, and returns the parts of its message held so far. Partial
+// messages older than maxChunkAge, and the oldest beyond maxPartialMessages,
+// are dropped.
+func (s *ChunkFileStore) SaveChunk(passphrase, peer string, part domain.ChunkPart) ([]domain.ChunkPart, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	m, err := s.load(passphrase, peer)
+	if err != nil {
+		return nil, err
+	}
+	parts := slices.DeleteFunc(m[part.ID], func(p domain.ChunkPart) bool { return p.Index == part.Index })
+	parts = append(parts, part)
+	slices.SortFunc(parts, func(a, b domain.ChunkPart) int { return a.Index - b.Index })
+	m[part.ID] = parts
+	s.expire(m)
+	if err := s.save(passphrase, peer, m); err != nil {
+		return nil, err
+	}
+	return m[part.ID], nil
+}
+
+// DeleteChunks drops the parts of message id from peer.
+func (s *ChunkFileStore) DeleteChunks(passphrase, peer, id string) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m, err := s.load(passphrase, peer)
+	if err != nil {
+		return err
+	}
+	if _, ok := m[id]; !ok {
+		return nil
+	}
+	delete(m, id)
+	if len(m) == 0 {
+		err := os.Remove(s.path(peer))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return s.save(passphrase, peer, m)
+}
+
+// expire drops the partial messages in m that are too old or too many.
+func (s *ChunkFileStore) expire(m map[string][]domain.ChunkPart) {
+	last := func(id string) int64 {
+		var t int64
+		for _, p := range m[id] {
+			t = max(t, p.ReceivedUTC)
+		}
+		return t
+	}
+	cutoff := s.now().Add(-maxChunkAge).Unix()
+	var ids []string
+	for id := range m {
+		if last(id) < cutoff {
+			delete(m, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) <= maxPartialMessages {
+		return
+	}
+	slices.SortFunc(ids, func(a, b string) int { return int(last(a) - last(b)) })
+	for _, id := range ids[:len(ids)-maxPartialMessages] {
+		delete(m, id)
+	}
+}
+
+// path returns peer's file of partial messages.
+func (s *ChunkFileStore) path(peer string) string {
+	return filepath.Join(s.dir, chunkDirname, peerFilename(peer)+chunkExt)
+}
+
+// load decrypts peer's partial messages. A missing file holds none.
+func (s *ChunkFileStore) load(passphrase, peer string) (map[string][]domain.ChunkPart, error) {
+	m := map[string][]domain.ChunkPart{}
+	b, err := readFile(s.path(peer))
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return m, nil
+	}
+	pt, err := decrypt(passphrase, b)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(pt, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// save encrypts and writes peer's partial messages.
+func (s *ChunkFileStore) save(passphrase, peer string, m map[string][]domain.ChunkPart) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	N, r, p := scryptParamsDefault()
+	ct, err := encrypt(passphrase, raw, N, r, p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, chunkDirname), 0o700); err != nil {
+		return err
+	}
+	return writeFile(s.path(peer), ct, 0o600)
+}
+
+// Compile-time assertion that ChunkFileStore implements domain.ChunkStore.
+var _ domain.ChunkStore = (*ChunkFileStore)(nil)
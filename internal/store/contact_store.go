@@ -0,0 +1,77 @@
+package store
+
+import (
+	"path/filepath"
+	"sort"
+
+	"ciphera/internal/domain"
+)
+
+const contactsFilename = "contacts.json"
+
+// ContactFileStore persists contacts verified by pairing, keyed by username.
+type ContactFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewContactFileStore returns a ContactFileStore rooted at dir.
+func NewContactFileStore(dir string) *ContactFileStore {
+	return &ContactFileStore{dir: dir, mu: storeLock{path: lockPath(dir, contactsFilename)}}
+}
+
+// SaveContact records c, replacing any entry for the same username.
+func (s *ContactFileStore) SaveContact(c domain.Contact) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, contactsFilename)
+	m := map[string]domain.Contact{}
+	_ = readJSON(path, &m)
+	m[c.Username] = c
+	return writeJSON(path, m, 0o600)
+}
+
+// LoadContact returns the contact for username, if one was paired.
+func (s *ContactFileStore) LoadContact(username string) (domain.Contact, bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return domain.Contact{}, false, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, contactsFilename)
+	m := map[string]domain.Contact{}
+	if err := readJSON(path, &m); err != nil {
+		return domain.Contact{}, false, err
+	}
+	c, ok := m[username]
+	return c, ok, nil
+}
+
+// ListContacts returns all contacts ordered by username.
+func (s *ContactFileStore) ListContacts() ([]domain.Contact, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, contactsFilename)
+	m := map[string]domain.Contact{}
+	if err := readJSON(path, &m); err != nil {
+		return nil, err
+	}
+	out := make([]domain.Contact, 0, len(m))
+	for _, c := range m {
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Username < out[j].Username })
+	return out, nil
+}
+
+// Compile-time assertion that ContactFileStore implements domain.ContactStore.
+var _ domain.ContactStore = (*ContactFileStore)(nil)
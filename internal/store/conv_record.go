@@ -0,0 +1,470 @@
+package store
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ciphera/internal/domain"
+)
+
+// Each conversation lives in its own binary record, so loading or saving one
+// peer's state never touches the others.
+//
+// Layout:
+//
+//	magic   "CCNV"
+//	version uint8 (6; 1 lacks the message totals, 2 the gap times, 3 the
+//	        ratchet suites, 4 the start time, 5 the unanswered count)
+//	flags   uint8 (bit 0: body is DEFLATE-compressed)
+//	body    CBOR map of the conversation (see encodeConversation)
+//
+// The body is compressed only when that makes it smaller. Skipped message
+// keys are not part of the record; they stay in their side file.
+const (
+	convDirname      = "conversations"
+	convRecordExt    = ".cbor"
+	convMagic        = "CCNV"
+	convVersion      = 6
+	convFlagDeflate  = 1 << 0
+	convHeaderSize   = len(convMagic) + 2
+	convMaxBodyBytes = 1 << 20
+)
+
+var errConvCorrupt = errors.New("conversation record corrupt")
+
+// Map keys of the CBOR body. Never reuse or renumber a key. Readers reject
+// keys they do not know, so adding one needs a new record version.
+const (
+	convKeyPeer = iota + 1
+	convKeyState
+	convKeyConfirm
+	convKeyInitiator
+	convKeyPeerIK
+	convKeyStale
+	convKeyStats
+	convKeyWipeRequested
+	convKeyRekeyedUTC
+	convKeySinceRekey
+	convKeyRekeys
+	convKeyHeaderMACs
+	convKeyMessagesSent     // version 2
+	convKeyMessagesReceived // version 2
+	convKeyGapSinceUTC      // version 3
+	convKeyResendAskedUTC   // version 3
+	convKeyStartedUTC       // version 5
+	convKeyUnanswered       // version 6
+	convKeyUnansweredSince  // version 6
+)
+
+const (
+	stateKeyRootKey = iota + 1
+	stateKeyDHPriv
+	stateKeyDHPub
+	stateKeyPeerDHPub
+	stateKeySendCK
+	stateKeyRecvCK
+	stateKeyNs
+	stateKeyNr
+	stateKeyPN
+	stateKeyHeaderKey
+	stateKeySuite // version 4
+)
+
+const (
+	statsKeySent = iota + 1
+	statsKeyReceived
+	statsKeyDHSteps
+	statsKeyOutOfOrder
+	statsKeySkippedMax
+	statsKeyCipherSizes
+)
+
+// convPath returns the record file for peer, named like its skipped-key side
+// file.
+func convPath(dir, peer string) string {
+	return filepath.Join(dir, convDirname, peerFilename(peer)+convRecordExt)
+}
+
+// encodeConversation serialises c, without its skipped keys, into the record
+// format. Zero fields are left out; Stale and Stats are kept whenever they are
+// non-nil, since their presence carries meaning. Output is deterministic.
+func encodeConversation(c domain.Conversation) ([]byte, error) {
+	var m cborMapWriter
+	m.key(convKeyPeer).text(c.Peer)
+	writeRatchetState(m.key(convKeyState), c.State)
+	if c.Confirm != "" {
+		m.key(convKeyConfirm).text(string(c.Confirm))
+	}
+	if c.Initiator {
+		m.key(convKeyInitiator).bool(true)
+	}
+	if c.PeerIK != (domain.X25519Public{}) {
+		m.key(convKeyPeerIK).bytes(c.PeerIK.Slice())
+	}
+	if c.Stale != nil {
+		writeRatchetState(m.key(convKeyStale), *c.Stale)
+	}
+	if c.Stats != nil {
+		writeRatchetStats(m.key(convKeyStats), *c.Stats)
+	}
+	if c.WipeRequested != "" {
+		m.key(convKeyWipeRequested).text(c.WipeRequested)
+	}
+	if c.RekeyedUTC != 0 {
+		m.key(convKeyRekeyedUTC).int(c.RekeyedUTC)
+	}
+	if c.SinceRekey != 0 {
+		m.key(convKeySinceRekey).int(int64(c.SinceRekey))
+	}
+	if c.Rekeys != 0 {
+		m.key(convKeyRekeys).int(int64(c.Rekeys))
+	}
+	if c.HeaderMACs {
+		m.key(convKeyHeaderMACs).bool(true)
+	}
+	if c.MessagesSent != 0 {
+		m.key(convKeyMessagesSent).int(int64(c.MessagesSent))
+	}
+	if c.MessagesReceived != 0 {
+		m.key(convKeyMessagesReceived).int(int64(c.MessagesReceived))
+	}
+	if c.GapSinceUTC != 0 {
+		m.key(convKeyGapSinceUTC).int(c.GapSinceUTC)
+	}
+	if c.ResendAskedUTC != 0 {
+		m.key(convKeyResendAskedUTC).int(c.ResendAskedUTC)
+	}
+	if c.StartedUTC != 0 {
+		m.key(convKeyStartedUTC).int(c.StartedUTC)
+	}
+	if c.Unanswered != 0 {
+		m.key(convKeyUnanswered).int(int64(c.Unanswered))
+	}
+	if c.UnansweredSinceUTC != 0 {
+		m.key(convKeyUnansweredSince).int(c.UnansweredSinceUTC)
+	}
+	var body cborWriter
+	body.writeMap(&m)
+
+	flags := byte(0)
+	payload := body.b
+	var z bytes.Buffer
+	fw, err := flate.NewWriter(&z, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = fw.Write(body.b)
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	if z.Len() < len(body.b) {
+		flags |= convFlagDeflate
+		payload = z.Bytes()
+	}
+
+	out := make([]byte, 0, convHeaderSize+len(payload))
+	out = append(out, convMagic...)
+	out = append(out, convVersion, flags)
+	return append(out, payload...), nil
+}
+
+// decodeConversation parses a record back into a conversation with no skipped
+// keys attached. A record that decodes but fails checkConversation is
+// reported as a *RecordError.
+func decodeConversation(b []byte) (domain.Conversation, error) {
+	if len(b) < convHeaderSize || string(b[:len(convMagic)]) != convMagic {
+		return domain.Conversation{}, errConvCorrupt
+	}
+	version := b[len(convMagic)]
+	if version < 1 || version > convVersion {
+		return domain.Conversation{}, fmt.Errorf("conversation record version %d unsupported", version)
+	}
+	flags, body := b[len(convMagic)+1], b[convHeaderSize:]
+	if flags&^convFlagDeflate != 0 {
+		return domain.Conversation{}, errConvCorrupt
+	}
+	if flags&convFlagDeflate != 0 {
+		fr := flate.NewReader(bytes.NewReader(body))
+		raw, err := io.ReadAll(io.LimitReader(fr, convMaxBodyBytes+1))
+		if err != nil || len(raw) > convMaxBodyBytes {
+			return domain.Conversation{}, errConvCorrupt
+		}
+		body = raw
+	}
+
+	var c domain.Conversation
+	r := cborReader{b: body}
+	for range r.mapLen() {
+		switch r.uint() {
+		case convKeyPeer:
+			c.Peer = r.text()
+		case convKeyState:
+			c.State = readRatchetState(&r, version)
+		case convKeyConfirm:
+			c.Confirm = domain.ConfirmState(r.text())
+		case convKeyInitiator:
+			c.Initiator = r.bool()
+		case convKeyPeerIK:
+			r.fixed(c.PeerIK[:])
+		case convKeyStale:
+			st := readRatchetState(&r, version)
+			c.Stale = &st
+		case convKeyStats:
+			st := readRatchetStats(&r)
+			c.Stats = &st
+		case convKeyWipeRequested:
+			c.WipeRequested = r.text()
+		case convKeyRekeyedUTC:
+			c.RekeyedUTC = r.int()
+		case convKeySinceRekey:
+			c.SinceRekey = int(r.int())
+		case convKeyRekeys:
+			c.Rekeys = int(r.int())
+		case convKeyHeaderMACs:
+			c.HeaderMACs = r.bool()
+		case convKeyMessagesSent:
+			if version < 2 {
+				r.fail()
+			}
+			c.MessagesSent = int(r.int())
+		case convKeyMessagesReceived:
+			if version < 2 {
+				r.fail()
+			}
+			c.MessagesReceived = int(r.int())
+		case convKeyGapSinceUTC:
+			if version < 3 {
+				r.fail()
+			}
+			c.GapSinceUTC = r.int()
+		case convKeyResendAskedUTC:
+			if version < 3 {
+				r.fail()
+			}
+			c.ResendAskedUTC = r.int()
+		case convKeyStartedUTC:
+			if version < 5 {
+				r.fail()
+			}
+			c.StartedUTC = r.int()
+		case convKeyUnanswered:
+			if version < 6 {
+				r.fail()
+			}
+			c.Unanswered = int(r.int())
+		case convKeyUnansweredSince:
+			if version < 6 {
+				r.fail()
+			}
+			c.UnansweredSinceUTC = r.int()
+		default:
+			r.fail()
+		}
+	}
+	if r.err != nil || len(r.b) != 0 || c.Peer == "" {
+		return domain.Conversation{}, errConvCorrupt
+	}
+	if err := checkConversation(c); err != nil {
+		err.File = filepath.Join(convDirname, peerFilename(c.Peer)+convRecordExt)
+		return domain.Conversation{}, err
+	}
+	return c, nil
+}
+
+// writeRatchetState writes st, without its skipped keys, as a CBOR map.
+func writeRatchetState(w *cborWriter, st domain.RatchetState) {
+	var m cborMapWriter
+	m.key(stateKeyRootKey).bytes(st.RootKey)
+	m.key(stateKeyDHPriv).bytes(st.DHPriv.Slice())
+	m.key(stateKeyDHPub).bytes(st.DHPub.Slice())
+	m.key(stateKeyPeerDHPub).bytes(st.PeerDHPub.Slice())
+	if len(st.SendCK) > 0 {
+		m.key(stateKeySendCK).bytes(st.SendCK)
+	}
+	if len(st.RecvCK) > 0 {
+		m.key(stateKeyRecvCK).bytes(st.RecvCK)
+	}
+	if st.Ns != 0 {
+		m.key(stateKeyNs).uint(uint64(st.Ns))
+	}
+	if st.Nr != 0 {
+		m.key(stateKeyNr).uint(uint64(st.Nr))
+	}
+	if st.PN != 0 {
+		m.key(stateKeyPN).uint(uint64(st.PN))
+	}
+	if len(st.HeaderKey) > 0 {
+		m.key(stateKeyHeaderKey).bytes(st.HeaderKey)
+	}
+	if st.Suite != "" {
+		m.key(stateKeySuite).text(st.Suite)
+	}
+	w.writeMap(&m)
+}
+
+// readRatchetState reads a state written by writeRatchetState into a record
+// of the given version.
+func readRatchetState(r *cborReader, version byte) domain.RatchetState {
+	var st domain.RatchetState
+	for range r.mapLen() {
+		switch r.uint() {
+		case stateKeyRootKey:
+			st.RootKey = r.bytes()
+		case stateKeyDHPriv:
+			r.fixed(st.DHPriv[:])
+		case stateKeyDHPub:
+			r.fixed(st.DHPub[:])
+		case stateKeyPeerDHPub:
+			r.fixed(st.PeerDHPub[:])
+		case stateKeySendCK:
+			st.SendCK = r.bytes()
+		case stateKeyRecvCK:
+			st.RecvCK = r.bytes()
+		case stateKeyNs:
+			st.Ns = r.uint32()
+		case stateKeyNr:
+			st.Nr = r.uint32()
+		case stateKeyPN:
+			st.PN = r.uint32()
+		case stateKeyHeaderKey:
+			st.HeaderKey = r.bytes()
+		case stateKeySuite:
+			if version < 4 {
+				r.fail()
+			}
+			st.Suite = r.text()
+		default:
+			r.fail()
+		}
+	}
+	return st
+}
+
+// writeRatchetStats writes s as a CBOR map.
+func writeRatchetStats(w *cborWriter, s domain.RatchetStats) {
+	var m cborMapWriter
+	for _, f := range []struct {
+		key uint64
+		n   int
+	}{
+		{statsKeySent, s.Sent},
+		{statsKeyReceived, s.Received},
+		{statsKeyDHSteps, s.DHSteps},
+		{statsKeyOutOfOrder, s.OutOfOrder},
+		{statsKeySkippedMax, s.SkippedMax},
+	} {
+		if f.n != 0 {
+			m.key(f.key).int(int64(f.n))
+		}
+	}
+	if len(s.CipherSizes) > 0 {
+		w := m.key(statsKeyCipherSizes)
+		w.array(len(s.CipherSizes))
+		for _, n := range s.CipherSizes {
+			w.int(int64(n))
+		}
+	}
+	w.writeMap(&m)
+}
+
+func readRatchetStats(r *cborReader) domain.RatchetStats {
+	var s domain.RatchetStats
+	for range r.mapLen() {
+		switch r.uint() {
+		case statsKeySent:
+			s.Sent = int(r.int())
+		case statsKeyReceived:
+			s.Received = int(r.int())
+		case statsKeyDHSteps:
+			s.DHSteps = int(r.int())
+		case statsKeyOutOfOrder:
+			s.OutOfOrder = int(r.int())
+		case statsKeySkippedMax:
+			s.SkippedMax = int(r.int())
+		case statsKeyCipherSizes:
+			n := r.array()
+			s.CipherSizes = make([]int, 0, n)
+			for range n {
+				s.CipherSizes = append(s.CipherSizes, int(r.int()))
+			}
+		default:
+			r.fail()
+		}
+	}
+	return s
+}
+
+// saveConvRecord writes c's record. The file is only rewritten when its
+// contents change.
+func saveConvRecord(dir string, c domain.Conversation) error {
+	b, err := encodeConversation(c)
+	if err != nil {
+		return err
+	}
+	path := convPath(dir, c.Peer)
+	if cur, err := readFile(path); err == nil && bytes.Equal(cur, b) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return writeFile(path, b, 0o600)
+}
+
+// loadConvRecord reads peer's record. ok is false if there is none.
+func loadConvRecord(dir, peer string) (domain.Conversation, bool, error) {
+	b, err := readFile(convPath(dir, peer))
+	if err != nil || b == nil {
+		return domain.Conversation{}, false, err
+	}
+	c, err := decodeConversation(b)
+	if err != nil {
+		return domain.Conversation{}, false, fmt.Errorf("conversation with %q: %w", peer, err)
+	}
+	if c.Peer != peer {
+		return domain.Conversation{}, false, fmt.Errorf("conversation with %q: %w", peer, errConvCorrupt)
+	}
+	return c, true, nil
+}
+
+// listConvRecords reads every record in dir, in no particular order.
+func listConvRecords(dir string) ([]domain.Conversation, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, convDirname))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []domain.Conversation
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), convRecordExt) {
+			continue // e.g. a temp file left by a crash
+		}
+		b, err := readFile(filepath.Join(dir, convDirname, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		c, err := decodeConversation(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// deleteConvRecord removes peer's record and reports whether it existed.
+func deleteConvRecord(dir, peer string) (bool, error) {
+	err := os.Remove(convPath(dir, peer))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -1,14 +1,58 @@
 // Package store provides file-based persistence for Ciphera’s core data.
 //
 // It contains concrete implementations of the domain storage interfaces,
-// serialising data as JSON on disk. All methods are concurrency-safe via
-// internal locking. Stored files typically live under the user’s configured
-// home directory.
+// serialising data as JSON on disk, except for ratchet state: each
+// conversation is a compact CBOR record of its own and its skipped message
+// keys a binary side file. All methods are concurrency-safe, also
+// across processes: each store holds an advisory lock on a file beside its
+// data for the whole of every call, and gives up with ErrLockTimeout if
+// another process keeps it too long (see storeLock). RatchetFileStore also
+// locks each peer's conversation across a whole ratchet step (see
+// LockConversation). Stored files typically
+// live under the user’s configured home directory.
 //
 // The package includes stores for:
 //   - Identity keys (IdentityFileStore)
-//   - Prekeys (PrekeyFileStore)
+//   - Prekeys, when they were generated and consumed, and the schedule of
+//     their upkeep (PrekeyFileStore)
 //   - Prekey bundles (BundleFileStore)
 //   - X3DH sessions (SessionFileStore)
 //   - Double Ratchet conversation state (RatchetFileStore)
+//   - Envelopes that failed to decrypt (QuarantineFileStore)
+//   - Relay accounts keyed by (server, username), with failover endpoints (AccountFileStore)
+//   - Per-conversation notification and send preferences (PreferenceFileStore)
+//   - Contacts verified by short-code pairing (ContactFileStore)
+//   - Attestations contacts made about our identity (AttestationFileStore)
+//   - Our profile and the profiles peers sent (ProfileFileStore)
+//   - Named broadcast lists of peers (BroadcastFileStore)
+//   - A journal of sent messages and their relay sequence numbers (OutboxFileStore)
+//   - Relays discovered for user@host addresses (RelayCacheFileStore)
+//   - Global client settings such as the send policy (SettingsFileStore)
+//   - Message history, encrypted under a history key that the passphrase,
+//     or the history's own, unwraps (HistoryFileStore)
+//   - Parts of chunked messages still being received, encrypted under the
+//     passphrase (ChunkFileStore)
+//   - Messages the receive filters held for review, encrypted under the
+//     passphrase (HeldFileStore)
+//   - Messages being composed, one per peer, encrypted under the
+//     passphrase (DraftFileStore)
+//   - Archived conversations with their session, ratchet state and history,
+//     encrypted under the passphrase (ArchiveFileStore)
+//   - A log of state-changing commands for undo (ActionFileStore)
+//
+// IdentityKeyIndex looks up the peers stored under an identity key across the
+// contact, session and ratchet stores, without a file of its own.
+//
+// Sessions, conversation records and prekeys are validated as they are
+// loaded: required fields, key lengths, prekey IDs and cross-references. A
+// malformed record is reported as a *RecordError wrapping ErrMalformed, and
+// the store refuses to use or rewrite the file. A prekey is never replaced by
+// another key under the same ID (ErrKeyIDCollision).
+//
+// Telemetry, once installed with SetTelemetry, measures every file read and
+// write, its size, and every wait for a store's lock.
+//
+// JSON files carry a schema version. Migrate upgrades files written by older
+// versions through an ordered registry of migrations, keeping a backup of each
+// original and appending an audit entry to migrations.log.
 package store
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"ciphera/internal/domain"
+)
+
+const draftsFilename = "drafts.json.enc"
+
+// DraftFileStore persists compose drafts, one per peer, encrypted under the
+// identity passphrase in the same format as the history file.
+type DraftFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewDraftFileStore returns a DraftFileStore rooted at dir.
+func NewDraftFileStore(dir string) *DraftFileStore {
+	return &DraftFileStore{dir: dir, mu: storeLock{path: lockPath(dir, draftsFilename)}}
+}
+
+// SaveDraft records d, replacing any draft for the same peer.
+func (s *DraftFileStore) SaveDraft(passphrase string, d domain.Draft) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m, err := s.load(passphrase)
+	if err != nil {
+		return err
+	}
+	m[d.Peer] = d
+	return s.save(passphrase, m)
+}
+
+// LoadDraft returns the draft for peer and whether there is one.
+func (s *DraftFileStore) LoadDraft(passphrase, peer string) (domain.Draft, bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return domain.Draft{}, false, err
+	}
+	defer unlock()
+
+	m, err := s.load(passphrase)
+	if err != nil {
+		return domain.Draft{}, false, err
+	}
+	d, ok := m[peer]
+	return d, ok, nil
+}
+
+// ListDrafts returns every draft, by peer.
+func (s *DraftFileStore) ListDrafts(passphrase string) ([]domain.Draft, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	m, err := s.load(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]domain.Draft, 0, len(m))
+	for _, d := range m {
+		out = append(out, d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Peer < out[j].Peer })
+	return out, nil
+}
+
+// DeleteDraft removes the draft for peer and reports whether it existed.
+// The file goes with the last draft.
+func (s *DraftFileStore) DeleteDraft(passphrase, peer string) (bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	m, err := s.load(passphrase)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := m[peer]; !ok {
+		return false, nil
+	}
+	delete(m, peer)
+	if len(m) == 0 {
+		err := os.Remove(filepath.Join(s.dir, draftsFilename))
+		if errors.Is(err, fs.ErrNotExist) {
+			return true, nil
+		}
+		return true, err
+	}
+	return true, s.save(passphrase, m)
+}
+
+// load decrypts the drafts. A missing file holds none.
+func (s *DraftFileStore) load(passphrase string) (map[string]domain.Draft, error) {
+	m := map[string]domain.Draft{}
+	b, err := readFile(filepath.Join(s.dir, draftsFilename))
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return m, nil
+	}
+	pt, err := decrypt(passphrase, b)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(pt, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// save encrypts and writes the drafts.
+func (s *DraftFileStore) save(passphrase string, m map[string]domain.Draft) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	N, r, p := scryptParamsDefault()
+	ct, err := encrypt(passphrase, raw, N, r, p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(s.dir, draftsFilename), ct, 0o600)
+}
+
+// Compile-time assertion that DraftFileStore implements domain.DraftStore.
+var _ domain.DraftStore = (*DraftFileStore)(nil)
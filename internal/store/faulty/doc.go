@@ -0,0 +1,20 @@
+// Package faulty wraps the domain store interfaces with programmable
+// failures, so the services' handling of a store that misbehaves can be
+// exercised on purpose rather than waited for.
+//
+// An Injector is built from Options and wraps each store with the method
+// named after its interface (Injector.RatchetStore and so on). It can:
+//   - fail every Nth write with ErrInjected before anything is written;
+//   - let every Nth write through and then flip a byte in each file it
+//     changed, as a torn write or failing disk would;
+//   - delay every read.
+//
+// Writes are the calls that change a store (Save, Append, Delete, Consume,
+// Set and Prune); the rest are reads. One Injector counts writes across all
+// the stores it wraps, so FailEvery: 3 fails the third write of a command
+// whichever store it goes to. Counts reports what was injected.
+//
+// The CLI enables it with the hidden --store-fail-every, --store-corrupt-every
+// and --store-read-delay flags. Never use it on a home directory you care
+// about: corrupted files are left corrupted.
+package faulty
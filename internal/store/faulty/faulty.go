@@ -0,0 +1,174 @@
+package faulty
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInjected is returned by writes that Options.FailEvery makes fail.
+var ErrInjected = errors.New("injected store failure")
+
+// lockSuffix marks the store lock files, which are never corrupted.
+const lockSuffix = ".lock"
+
+// Options programs the faults an Injector injects. The zero value injects
+// none.
+type Options struct {
+	// FailEvery makes every Nth write, counted across all wrapped stores,
+	// fail with ErrInjected before it reaches the store, so nothing is
+	// written.
+	FailEvery int
+	// CorruptEvery lets every Nth write succeed and then flips one byte in
+	// the middle of each file it changed under Dir, as a torn write or bad
+	// disk would. Later reads of the file see the damage.
+	CorruptEvery int
+	// ReadDelay makes every read sleep this long before it reaches the
+	// store.
+	ReadDelay time.Duration
+	// Dir is the home directory the stores write to. CorruptEvery needs it
+	// to find the files a write changed.
+	Dir string
+}
+
+// Enabled reports whether o injects anything.
+func (o Options) Enabled() bool {
+	return o.FailEvery > 0 || o.CorruptEvery > 0 || o.ReadDelay > 0
+}
+
+// validate checks o's counts, delay and directory.
+func (o Options) validate() error {
+	switch {
+	case o.FailEvery < 0 || o.CorruptEvery < 0:
+		return fmt.Errorf("negative fault interval (fail every %d, corrupt every %d)", o.FailEvery, o.CorruptEvery)
+	case o.ReadDelay < 0:
+		return fmt.Errorf("negative read delay %v", o.ReadDelay)
+	case o.CorruptEvery > 0 && o.Dir == "":
+		return errors.New("corrupting writes needs the store directory")
+	}
+	return nil
+}
+
+// Injector decides which store calls fail, corrupt or stall. One Injector is
+// shared by every wrapped store, so writes are counted across all of them.
+type Injector struct {
+	opts Options
+
+	mu        sync.Mutex
+	writes    int // writes attempted so far
+	failed    int // writes failed with ErrInjected
+	corrupted int // files corrupted
+}
+
+// New returns an Injector for opts.
+func New(opts Options) (*Injector, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return &Injector{opts: opts}, nil
+}
+
+// Counts returns how many writes were attempted and failed, and how many
+// files were corrupted, so tests can check the faults they asked for
+// happened.
+func (in *Injector) Counts() (writes, failed, corrupted int) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.writes, in.failed, in.corrupted
+}
+
+// read delays a read by Options.ReadDelay.
+func (in *Injector) read() {
+	if in.opts.ReadDelay > 0 {
+		time.Sleep(in.opts.ReadDelay)
+	}
+}
+
+// write runs fn, the write op, unless it is due to fail, and corrupts the
+// files it changed if it is due for that.
+func (in *Injector) write(op string, fn func() error) error {
+	in.mu.Lock()
+	in.writes++
+	n := in.writes
+	fail := in.opts.FailEvery > 0 && n%in.opts.FailEvery == 0
+	if fail {
+		in.failed++
+	}
+	in.mu.Unlock()
+	if fail {
+		return fmt.Errorf("%s: %w", op, ErrInjected)
+	}
+
+	corrupt := in.opts.CorruptEvery > 0 && n%in.opts.CorruptEvery == 0
+	// Modification times are compared with the start of the write; the
+	// margin covers filesystems that truncate them.
+	start := time.Now().Add(-10 * time.Millisecond)
+	if err := fn(); err != nil || !corrupt {
+		return err
+	}
+	n, err := corruptSince(in.opts.Dir, start)
+	in.mu.Lock()
+	in.corrupted += n
+	in.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("%s: corrupting files: %w", op, err)
+	}
+	return nil
+}
+
+// corruptSince flips the middle byte of every non-empty file under dir
+// modified at or after since, except lock files, and returns how many it
+// changed.
+func corruptSince(dir string, since time.Time) (int, error) {
+	n := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, lockSuffix) {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(since) || info.Size() == 0 || !info.Mode().IsRegular() {
+			return nil
+		}
+		if err := flipByte(path, info.Size()/2); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// flipByte inverts the byte at off in the file at path, keeping its
+// modification time so the next write is not confused by it.
+func flipByte(path string, off int64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 1)
+	if _, err := f.ReadAt(b, off); err != nil {
+		_ = f.Close()
+		return err
+	}
+	b[0] ^= 0xff
+	if _, err := f.WriteAt(b, off); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
+}
@@ -0,0 +1,691 @@
+package faulty
+
+import "ciphera/internal/domain"
+
+// identityStore injects faults into a domain.IdentityStore.
+type identityStore struct {
+	in    *Injector
+	inner domain.IdentityStore
+}
+
+// IdentityStore wraps s so its calls are subject to in's faults.
+func (in *Injector) IdentityStore(s domain.IdentityStore) domain.IdentityStore {
+	return &identityStore{in: in, inner: s}
+}
+
+func (s *identityStore) SaveIdentity(passphrase string, id domain.Identity) error {
+	return s.in.write("SaveIdentity", func() error { return s.inner.SaveIdentity(passphrase, id) })
+}
+
+func (s *identityStore) LoadIdentity(passphrase string) (domain.Identity, error) {
+	s.in.read()
+	return s.inner.LoadIdentity(passphrase)
+}
+
+// prekeyStore injects faults into a domain.PrekeyStore.
+type prekeyStore struct {
+	in    *Injector
+	inner domain.PrekeyStore
+}
+
+// PrekeyStore wraps s so its calls are subject to in's faults.
+func (in *Injector) PrekeyStore(s domain.PrekeyStore) domain.PrekeyStore {
+	return &prekeyStore{in: in, inner: s}
+}
+
+func (s *prekeyStore) SaveSignedPrekey(id domain.KeyID, priv domain.X25519Private, pub domain.X25519Public, sig []byte) error {
+	return s.in.write("SaveSignedPrekey", func() error { return s.inner.SaveSignedPrekey(id, priv, pub, sig) })
+}
+
+func (s *prekeyStore) LoadSignedPrekey(id domain.KeyID) (domain.X25519Private, domain.X25519Public, []byte, bool, error) {
+	s.in.read()
+	return s.inner.LoadSignedPrekey(id)
+}
+
+func (s *prekeyStore) SaveOneTimePrekeys(pairs []domain.OneTimePair) error {
+	return s.in.write("SaveOneTimePrekeys", func() error { return s.inner.SaveOneTimePrekeys(pairs) })
+}
+
+func (s *prekeyStore) LoadOneTimePrekey(id domain.KeyID) (domain.X25519Private, domain.X25519Public, bool, error) {
+	s.in.read()
+	return s.inner.LoadOneTimePrekey(id)
+}
+
+func (s *prekeyStore) ConsumeOneTimePrekey(id domain.KeyID) (priv domain.X25519Private, pub domain.X25519Public, ok bool, err error) {
+	err = s.in.write("ConsumeOneTimePrekey", func() (err error) {
+		priv, pub, ok, err = s.inner.ConsumeOneTimePrekey(id)
+		return err
+	})
+	return priv, pub, ok, err
+}
+
+func (s *prekeyStore) ListOneTimePrekeyPublics() ([]domain.OneTimePub, error) {
+	s.in.read()
+	return s.inner.ListOneTimePrekeyPublics()
+}
+
+func (s *prekeyStore) SetCurrentSignedPrekeyID(id domain.KeyID) error {
+	return s.in.write("SetCurrentSignedPrekeyID", func() error { return s.inner.SetCurrentSignedPrekeyID(id) })
+}
+
+func (s *prekeyStore) CurrentSignedPrekeyID() (domain.KeyID, bool, error) {
+	s.in.read()
+	return s.inner.CurrentSignedPrekeyID()
+}
+
+func (s *prekeyStore) PrekeyStatus() (domain.PrekeyStatus, error) {
+	s.in.read()
+	return s.inner.PrekeyStatus()
+}
+
+func (s *prekeyStore) SavePrekeySchedule(sched domain.PrekeySchedule) error {
+	return s.in.write("SavePrekeySchedule", func() error { return s.inner.SavePrekeySchedule(sched) })
+}
+
+// prekeyBundleStore injects faults into a domain.PrekeyBundleStore.
+type prekeyBundleStore struct {
+	in    *Injector
+	inner domain.PrekeyBundleStore
+}
+
+// PrekeyBundleStore wraps s so its calls are subject to in's faults.
+func (in *Injector) PrekeyBundleStore(s domain.PrekeyBundleStore) domain.PrekeyBundleStore {
+	return &prekeyBundleStore{in: in, inner: s}
+}
+
+func (s *prekeyBundleStore) SavePrekeyBundle(b domain.PrekeyBundle) error {
+	return s.in.write("SavePrekeyBundle", func() error { return s.inner.SavePrekeyBundle(b) })
+}
+
+func (s *prekeyBundleStore) LoadPrekeyBundle(username string) (domain.PrekeyBundle, bool, error) {
+	s.in.read()
+	return s.inner.LoadPrekeyBundle(username)
+}
+
+// sessionStore injects faults into a domain.SessionStore.
+type sessionStore struct {
+	in    *Injector
+	inner domain.SessionStore
+}
+
+// SessionStore wraps s so its calls are subject to in's faults.
+func (in *Injector) SessionStore(s domain.SessionStore) domain.SessionStore {
+	return &sessionStore{in: in, inner: s}
+}
+
+func (s *sessionStore) SaveSession(peer string, sess domain.Session) error {
+	return s.in.write("SaveSession", func() error { return s.inner.SaveSession(peer, sess) })
+}
+
+func (s *sessionStore) LoadSession(peer string) (domain.Session, bool, error) {
+	s.in.read()
+	return s.inner.LoadSession(peer)
+}
+
+func (s *sessionStore) ListSessions() ([]domain.Session, error) {
+	s.in.read()
+	return s.inner.ListSessions()
+}
+
+func (s *sessionStore) DeleteSession(peer string) (found bool, err error) {
+	err = s.in.write("DeleteSession", func() (err error) {
+		found, err = s.inner.DeleteSession(peer)
+		return err
+	})
+	return found, err
+}
+
+// ratchetStore injects faults into a domain.RatchetStore.
+type ratchetStore struct {
+	in    *Injector
+	inner domain.RatchetStore
+}
+
+// RatchetStore wraps s so its calls are subject to in's faults.
+func (in *Injector) RatchetStore(s domain.RatchetStore) domain.RatchetStore {
+	return &ratchetStore{in: in, inner: s}
+}
+
+func (s *ratchetStore) LockConversation(peer string) (func(), error) {
+	return s.inner.LockConversation(peer)
+}
+
+func (s *ratchetStore) SaveConversation(peer string, conv domain.Conversation) error {
+	return s.in.write("SaveConversation", func() error { return s.inner.SaveConversation(peer, conv) })
+}
+
+func (s *ratchetStore) LoadConversation(peer string) (domain.Conversation, bool, error) {
+	s.in.read()
+	return s.inner.LoadConversation(peer)
+}
+
+func (s *ratchetStore) ListConversations() ([]domain.Conversation, error) {
+	s.in.read()
+	return s.inner.ListConversations()
+}
+
+func (s *ratchetStore) DeleteConversation(peer string) (found bool, err error) {
+	err = s.in.write("DeleteConversation", func() (err error) {
+		found, err = s.inner.DeleteConversation(peer)
+		return err
+	})
+	return found, err
+}
+
+// quarantineStore injects faults into a domain.QuarantineStore.
+type quarantineStore struct {
+	in    *Injector
+	inner domain.QuarantineStore
+}
+
+// QuarantineStore wraps s so its calls are subject to in's faults.
+func (in *Injector) QuarantineStore(s domain.QuarantineStore) domain.QuarantineStore {
+	return &quarantineStore{in: in, inner: s}
+}
+
+func (s *quarantineStore) SaveQuarantined(q domain.QuarantinedEnvelope) error {
+	return s.in.write("SaveQuarantined", func() error { return s.inner.SaveQuarantined(q) })
+}
+
+func (s *quarantineStore) ListQuarantined() ([]domain.QuarantinedEnvelope, error) {
+	s.in.read()
+	return s.inner.ListQuarantined()
+}
+
+func (s *quarantineStore) DeleteQuarantined(id string) (found bool, err error) {
+	err = s.in.write("DeleteQuarantined", func() (err error) {
+		found, err = s.inner.DeleteQuarantined(id)
+		return err
+	})
+	return found, err
+}
+
+// historyStore injects faults into a domain.HistoryStore.
+type historyStore struct {
+	in    *Injector
+	inner domain.HistoryStore
+}
+
+// HistoryStore wraps s so its calls are subject to in's faults.
+func (in *Injector) HistoryStore(s domain.HistoryStore) domain.HistoryStore {
+	return &historyStore{in: in, inner: s}
+}
+
+func (s *historyStore) AppendHistory(passphrase string, entries []domain.HistoryEntry) (n int, err error) {
+	err = s.in.write("AppendHistory", func() (err error) {
+		n, err = s.inner.AppendHistory(passphrase, entries)
+		return err
+	})
+	return n, err
+}
+
+func (s *historyStore) LoadHistory(passphrase string) ([]domain.HistoryEntry, error) {
+	s.in.read()
+	return s.inner.LoadHistory(passphrase)
+}
+
+func (s *historyStore) DeleteHistory(passphrase, peer string) (n int, err error) {
+	err = s.in.write("DeleteHistory", func() (err error) {
+		n, err = s.inner.DeleteHistory(passphrase, peer)
+		return err
+	})
+	return n, err
+}
+
+func (s *historyStore) PruneHistory(passphrase string, r domain.Retention, now int64) (n int, err error) {
+	err = s.in.write("PruneHistory", func() (err error) {
+		n, err = s.inner.PruneHistory(passphrase, r, now)
+		return err
+	})
+	return n, err
+}
+
+func (s *historyStore) RewrapHistoryKey(current, next string, locked bool) error {
+	return s.in.write("RewrapHistoryKey", func() error { return s.inner.RewrapHistoryKey(current, next, locked) })
+}
+
+func (s *historyStore) HistoryLocked() (bool, error) {
+	s.in.read()
+	return s.inner.HistoryLocked()
+}
+
+// accountStore injects faults into a domain.AccountStore.
+type accountStore struct {
+	in    *Injector
+	inner domain.AccountStore
+}
+
+// AccountStore wraps s so its calls are subject to in's faults.
+func (in *Injector) AccountStore(s domain.AccountStore) domain.AccountStore {
+	return &accountStore{in: in, inner: s}
+}
+
+func (s *accountStore) SaveAccount(a domain.Account) error {
+	return s.in.write("SaveAccount", func() error { return s.inner.SaveAccount(a) })
+}
+
+func (s *accountStore) ListAccounts() ([]domain.Account, error) {
+	s.in.read()
+	return s.inner.ListAccounts()
+}
+
+// preferenceStore injects faults into a domain.PreferenceStore.
+type preferenceStore struct {
+	in    *Injector
+	inner domain.PreferenceStore
+}
+
+// PreferenceStore wraps s so its calls are subject to in's faults.
+func (in *Injector) PreferenceStore(s domain.PreferenceStore) domain.PreferenceStore {
+	return &preferenceStore{in: in, inner: s}
+}
+
+func (s *preferenceStore) SavePreferences(p domain.ConversationPrefs) error {
+	return s.in.write("SavePreferences", func() error { return s.inner.SavePreferences(p) })
+}
+
+func (s *preferenceStore) LoadPreferences(peer string) (domain.ConversationPrefs, bool, error) {
+	s.in.read()
+	return s.inner.LoadPreferences(peer)
+}
+
+func (s *preferenceStore) ListPreferences() ([]domain.ConversationPrefs, error) {
+	s.in.read()
+	return s.inner.ListPreferences()
+}
+
+// heldStore injects faults into a domain.HeldStore.
+type heldStore struct {
+	in    *Injector
+	inner domain.HeldStore
+}
+
+// HeldStore wraps s so its calls are subject to in's faults.
+func (in *Injector) HeldStore(s domain.HeldStore) domain.HeldStore {
+	return &heldStore{in: in, inner: s}
+}
+
+func (s *heldStore) SaveHeld(passphrase string, h domain.HeldMessage) error {
+	return s.in.write("SaveHeld", func() error { return s.inner.SaveHeld(passphrase, h) })
+}
+
+func (s *heldStore) ListHeld(passphrase string) ([]domain.HeldMessage, error) {
+	s.in.read()
+	return s.inner.ListHeld(passphrase)
+}
+
+func (s *heldStore) DeleteHeld(passphrase, id string) (found bool, err error) {
+	err = s.in.write("DeleteHeld", func() (err error) {
+		found, err = s.inner.DeleteHeld(passphrase, id)
+		return err
+	})
+	return found, err
+}
+
+// draftStore injects faults into a domain.DraftStore.
+type draftStore struct {
+	in    *Injector
+	inner domain.DraftStore
+}
+
+// DraftStore wraps s so its calls are subject to in's faults.
+func (in *Injector) DraftStore(s domain.DraftStore) domain.DraftStore {
+	return &draftStore{in: in, inner: s}
+}
+
+func (s *draftStore) SaveDraft(passphrase string, d domain.Draft) error {
+	return s.in.write("SaveDraft", func() error { return s.inner.SaveDraft(passphrase, d) })
+}
+
+func (s *draftStore) LoadDraft(passphrase, peer string) (domain.Draft, bool, error) {
+	s.in.read()
+	return s.inner.LoadDraft(passphrase, peer)
+}
+
+func (s *draftStore) ListDrafts(passphrase string) ([]domain.Draft, error) {
+	s.in.read()
+	return s.inner.ListDrafts(passphrase)
+}
+
+func (s *draftStore) DeleteDraft(passphrase, peer string) (found bool, err error) {
+	err = s.in.write("DeleteDraft", func() (err error) {
+		found, err = s.inner.DeleteDraft(passphrase, peer)
+		return err
+	})
+	return found, err
+}
+
+// settingsStore injects faults into a domain.SettingsStore.
+type settingsStore struct {
+	in    *Injector
+	inner domain.SettingsStore
+}
+
+// SettingsStore wraps s so its calls are subject to in's faults.
+func (in *Injector) SettingsStore(s domain.SettingsStore) domain.SettingsStore {
+	return &settingsStore{in: in, inner: s}
+}
+
+func (s *settingsStore) LoadSettings() (domain.Settings, error) {
+	s.in.read()
+	return s.inner.LoadSettings()
+}
+
+func (s *settingsStore) SaveSettings(settings domain.Settings) error {
+	return s.in.write("SaveSettings", func() error { return s.inner.SaveSettings(settings) })
+}
+
+// contactStore injects faults into a domain.ContactStore.
+type contactStore struct {
+	in    *Injector
+	inner domain.ContactStore
+}
+
+// ContactStore wraps s so its calls are subject to in's faults.
+func (in *Injector) ContactStore(s domain.ContactStore) domain.ContactStore {
+	return &contactStore{in: in, inner: s}
+}
+
+func (s *contactStore) SaveContact(c domain.Contact) error {
+	return s.in.write("SaveContact", func() error { return s.inner.SaveContact(c) })
+}
+
+func (s *contactStore) LoadContact(username string) (domain.Contact, bool, error) {
+	s.in.read()
+	return s.inner.LoadContact(username)
+}
+
+func (s *contactStore) ListContacts() ([]domain.Contact, error) {
+	s.in.read()
+	return s.inner.ListContacts()
+}
+
+// relayCacheStore injects faults into a domain.RelayCacheStore.
+type relayCacheStore struct {
+	in    *Injector
+	inner domain.RelayCacheStore
+}
+
+// RelayCacheStore wraps s so its calls are subject to in's faults.
+func (in *Injector) RelayCacheStore(s domain.RelayCacheStore) domain.RelayCacheStore {
+	return &relayCacheStore{in: in, inner: s}
+}
+
+func (s *relayCacheStore) SaveResolvedRelay(r domain.ResolvedRelay) error {
+	return s.in.write("SaveResolvedRelay", func() error { return s.inner.SaveResolvedRelay(r) })
+}
+
+func (s *relayCacheStore) LoadResolvedRelay(host string) (domain.ResolvedRelay, bool, error) {
+	s.in.read()
+	return s.inner.LoadResolvedRelay(host)
+}
+
+// attestationStore injects faults into a domain.AttestationStore.
+type attestationStore struct {
+	in    *Injector
+	inner domain.AttestationStore
+}
+
+// AttestationStore wraps s so its calls are subject to in's faults.
+func (in *Injector) AttestationStore(s domain.AttestationStore) domain.AttestationStore {
+	return &attestationStore{in: in, inner: s}
+}
+
+func (s *attestationStore) SaveAttestation(a domain.Attestation) error {
+	return s.in.write("SaveAttestation", func() error { return s.inner.SaveAttestation(a) })
+}
+
+func (s *attestationStore) ListAttestations() ([]domain.Attestation, error) {
+	s.in.read()
+	return s.inner.ListAttestations()
+}
+
+// profileStore injects faults into a domain.ProfileStore.
+type profileStore struct {
+	in    *Injector
+	inner domain.ProfileStore
+}
+
+// ProfileStore wraps s so its calls are subject to in's faults.
+func (in *Injector) ProfileStore(s domain.ProfileStore) domain.ProfileStore {
+	return &profileStore{in: in, inner: s}
+}
+
+func (s *profileStore) SaveOwnProfile(p domain.Profile) error {
+	return s.in.write("SaveOwnProfile", func() error { return s.inner.SaveOwnProfile(p) })
+}
+
+func (s *profileStore) LoadOwnProfile() (domain.Profile, bool, error) {
+	s.in.read()
+	return s.inner.LoadOwnProfile()
+}
+
+func (s *profileStore) SavePeerProfile(p domain.Profile) error {
+	return s.in.write("SavePeerProfile", func() error { return s.inner.SavePeerProfile(p) })
+}
+
+func (s *profileStore) LoadPeerProfile(peer string) (domain.Profile, bool, error) {
+	s.in.read()
+	return s.inner.LoadPeerProfile(peer)
+}
+
+func (s *profileStore) ListPeerProfiles() ([]domain.Profile, error) {
+	s.in.read()
+	return s.inner.ListPeerProfiles()
+}
+
+// outboxStore injects faults into a domain.OutboxStore.
+type outboxStore struct {
+	in    *Injector
+	inner domain.OutboxStore
+}
+
+// OutboxStore wraps s so its calls are subject to in's faults.
+func (in *Injector) OutboxStore(s domain.OutboxStore) domain.OutboxStore {
+	return &outboxStore{in: in, inner: s}
+}
+
+func (s *outboxStore) AppendSent(peer string, m domain.SentMessage) error {
+	return s.in.write("AppendSent", func() error { return s.inner.AppendSent(peer, m) })
+}
+
+func (s *outboxStore) ListSent(peer string) ([]domain.SentMessage, error) {
+	s.in.read()
+	return s.inner.ListSent(peer)
+}
+
+func (s *outboxStore) MarkExpired(relays []string, seqs []uint64, at int64) (out []domain.UndeliveredMessage, err error) {
+	err = s.in.write("MarkExpired", func() (err error) {
+		out, err = s.inner.MarkExpired(relays, seqs, at)
+		return err
+	})
+	return out, err
+}
+
+func (s *outboxStore) DeleteSent(peer string) (found bool, err error) {
+	err = s.in.write("DeleteSent", func() (err error) {
+		found, err = s.inner.DeleteSent(peer)
+		return err
+	})
+	return found, err
+}
+
+// ratchetTraceStore injects faults into a domain.RatchetTraceStore.
+type ratchetTraceStore struct {
+	in    *Injector
+	inner domain.RatchetTraceStore
+}
+
+// RatchetTraceStore wraps s so its calls are subject to in's faults.
+func (in *Injector) RatchetTraceStore(s domain.RatchetTraceStore) domain.RatchetTraceStore {
+	return &ratchetTraceStore{in: in, inner: s}
+}
+
+func (s *ratchetTraceStore) AppendRatchetStep(passphrase, peer string, step domain.RatchetStep) error {
+	return s.in.write("AppendRatchetStep", func() error { return s.inner.AppendRatchetStep(passphrase, peer, step) })
+}
+
+func (s *ratchetTraceStore) LoadRatchetSteps(passphrase, peer string) ([]domain.RatchetStep, error) {
+	s.in.read()
+	return s.inner.LoadRatchetSteps(passphrase, peer)
+}
+
+func (s *ratchetTraceStore) DeleteRatchetSteps(peer string) (n int, err error) {
+	err = s.in.write("DeleteRatchetSteps", func() (err error) {
+		n, err = s.inner.DeleteRatchetSteps(peer)
+		return err
+	})
+	return n, err
+}
+
+// chunkStore injects faults into a domain.ChunkStore.
+type chunkStore struct {
+	in    *Injector
+	inner domain.ChunkStore
+}
+
+// ChunkStore wraps s so its calls are subject to in's faults.
+func (in *Injector) ChunkStore(s domain.ChunkStore) domain.ChunkStore {
+	return &chunkStore{in: in, inner: s}
+}
+
+func (s *chunkStore) SaveChunk(passphrase, peer string, part domain.ChunkPart) (parts []domain.ChunkPart, err error) {
+	err = s.in.write("SaveChunk", func() (err error) {
+		parts, err = s.inner.SaveChunk(passphrase, peer, part)
+		return err
+	})
+	return parts, err
+}
+
+func (s *chunkStore) DeleteChunks(passphrase, peer, id string) error {
+	return s.in.write("DeleteChunks", func() error { return s.inner.DeleteChunks(passphrase, peer, id) })
+}
+
+// archiveStore injects faults into a domain.ArchiveStore.
+type archiveStore struct {
+	in    *Injector
+	inner domain.ArchiveStore
+}
+
+// ArchiveStore wraps s so its calls are subject to in's faults.
+func (in *Injector) ArchiveStore(s domain.ArchiveStore) domain.ArchiveStore {
+	return &archiveStore{in: in, inner: s}
+}
+
+func (s *archiveStore) SaveArchive(passphrase string, a domain.ConversationArchive) error {
+	return s.in.write("SaveArchive", func() error { return s.inner.SaveArchive(passphrase, a) })
+}
+
+func (s *archiveStore) LoadArchive(passphrase, peer string) (domain.ConversationArchive, bool, error) {
+	s.in.read()
+	return s.inner.LoadArchive(passphrase, peer)
+}
+
+func (s *archiveStore) ListArchives(passphrase string) ([]domain.ConversationArchive, error) {
+	s.in.read()
+	return s.inner.ListArchives(passphrase)
+}
+
+func (s *archiveStore) DeleteArchive(peer string) (found bool, err error) {
+	err = s.in.write("DeleteArchive", func() (err error) {
+		found, err = s.inner.DeleteArchive(peer)
+		return err
+	})
+	return found, err
+}
+
+func (s *archiveStore) Archived(peer string) (bool, error) {
+	s.in.read()
+	return s.inner.Archived(peer)
+}
+
+// broadcastStore injects faults into a domain.BroadcastStore.
+type broadcastStore struct {
+	in    *Injector
+	inner domain.BroadcastStore
+}
+
+// BroadcastStore wraps s so its calls are subject to in's faults.
+func (in *Injector) BroadcastStore(s domain.BroadcastStore) domain.BroadcastStore {
+	return &broadcastStore{in: in, inner: s}
+}
+
+func (s *broadcastStore) SaveBroadcast(l domain.BroadcastList) error {
+	return s.in.write("SaveBroadcast", func() error { return s.inner.SaveBroadcast(l) })
+}
+
+func (s *broadcastStore) LoadBroadcast(name string) (domain.BroadcastList, bool, error) {
+	s.in.read()
+	return s.inner.LoadBroadcast(name)
+}
+
+func (s *broadcastStore) ListBroadcasts() ([]domain.BroadcastList, error) {
+	s.in.read()
+	return s.inner.ListBroadcasts()
+}
+
+func (s *broadcastStore) DeleteBroadcast(name string) (found bool, err error) {
+	err = s.in.write("DeleteBroadcast", func() (err error) {
+		found, err = s.inner.DeleteBroadcast(name)
+		return err
+	})
+	return found, err
+}
+
+// actionStore injects faults into a domain.ActionStore.
+type actionStore struct {
+	in    *Injector
+	inner domain.ActionStore
+}
+
+// ActionStore wraps s so its calls are subject to in's faults.
+func (in *Injector) ActionStore(s domain.ActionStore) domain.ActionStore {
+	return &actionStore{in: in, inner: s}
+}
+
+func (s *actionStore) AppendAction(a domain.Action) (out domain.Action, err error) {
+	err = s.in.write("AppendAction", func() (err error) {
+		out, err = s.inner.AppendAction(a)
+		return err
+	})
+	return out, err
+}
+
+func (s *actionStore) ListActions() ([]domain.Action, error) {
+	s.in.read()
+	return s.inner.ListActions()
+}
+
+func (s *actionStore) MarkActionUndone(id int, utc int64) (found bool, err error) {
+	err = s.in.write("MarkActionUndone", func() (err error) {
+		found, err = s.inner.MarkActionUndone(id, utc)
+		return err
+	})
+	return found, err
+}
+
+// Compile-time assertions that the wrappers implement the store interfaces.
+var (
+	_ domain.IdentityStore     = (*identityStore)(nil)
+	_ domain.PrekeyStore       = (*prekeyStore)(nil)
+	_ domain.PrekeyBundleStore = (*prekeyBundleStore)(nil)
+	_ domain.SessionStore      = (*sessionStore)(nil)
+	_ domain.RatchetStore      = (*ratchetStore)(nil)
+	_ domain.QuarantineStore   = (*quarantineStore)(nil)
+	_ domain.HistoryStore      = (*historyStore)(nil)
+	_ domain.AccountStore      = (*accountStore)(nil)
+	_ domain.PreferenceStore   = (*preferenceStore)(nil)
+	_ domain.HeldStore         = (*heldStore)(nil)
+	_ domain.DraftStore        = (*draftStore)(nil)
+	_ domain.SettingsStore     = (*settingsStore)(nil)
+	_ domain.ContactStore      = (*contactStore)(nil)
+	_ domain.RelayCacheStore   = (*relayCacheStore)(nil)
+	_ domain.AttestationStore  = (*attestationStore)(nil)
+	_ domain.ProfileStore      = (*profileStore)(nil)
+	_ domain.OutboxStore       = (*outboxStore)(nil)
+	_ domain.RatchetTraceStore = (*ratchetTraceStore)(nil)
+	_ domain.ChunkStore        = (*chunkStore)(nil)
+	_ domain.BroadcastStore    = (*broadcastStore)(nil)
+	_ domain.ArchiveStore      = (*archiveStore)(nil)
+	_ domain.ActionStore       = (*actionStore)(nil)
+)
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"ciphera/internal/domain"
+)
+
+const (
+	heldFilename = "held.json.enc"
+	// maxHeld bounds the messages kept for review; the oldest go first, so
+	// an unwanted sender cannot fill the disk.
+	maxHeld = 256
+)
+
+// HeldFileStore persists messages the receive filters held back, encrypted
+// under the identity passphrase in the same format as the history file.
+type HeldFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewHeldFileStore returns a HeldFileStore rooted at dir.
+func NewHeldFileStore(dir string) *HeldFileStore {
+	return &HeldFileStore{dir: dir, mu: storeLock{path: lockPath(dir, heldFilename)}}
+}
+
+// SaveHeld records h, replacing any entry with the same ID and dropping the
+// oldest entries beyond maxHeld.
+func (s *HeldFileStore) SaveHeld(passphrase string, h domain.HeldMessage) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m, err := s.load(passphrase)
+	if err != nil {
+		return err
+	}
+	m[h.ID] = h
+	if n := len(m) - maxHeld; n > 0 {
+		for _, old := range sortHeld(m)[:n] {
+			delete(m, old.ID)
+		}
+	}
+	return s.save(passphrase, m)
+}
+
+// ListHeld returns every held message, oldest first.
+func (s *HeldFileStore) ListHeld(passphrase string) ([]domain.HeldMessage, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	m, err := s.load(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return sortHeld(m), nil
+}
+
+// DeleteHeld removes the entry with id and reports whether it existed.
+func (s *HeldFileStore) DeleteHeld(passphrase, id string) (bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	m, err := s.load(passphrase)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := m[id]; !ok {
+		return false, nil
+	}
+	delete(m, id)
+	if len(m) == 0 {
+		err := os.Remove(filepath.Join(s.dir, heldFilename))
+		if errors.Is(err, fs.ErrNotExist) {
+			return true, nil
+		}
+		return true, err
+	}
+	return true, s.save(passphrase, m)
+}
+
+// sortHeld returns the entries of m, oldest first.
+func sortHeld(m map[string]domain.HeldMessage) []domain.HeldMessage {
+	out := make([]domain.HeldMessage, 0, len(m))
+	for _, h := range m {
+		out = append(out, h)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].HeldUTC != out[j].HeldUTC {
+			return out[i].HeldUTC < out[j].HeldUTC
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// load decrypts the held messages. A missing file holds none.
+func (s *HeldFileStore) load(passphrase string) (map[string]domain.HeldMessage, error) {
+	m := map[string]domain.HeldMessage{}
+	b, err := readFile(filepath.Join(s.dir, heldFilename))
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return m, nil
+	}
+	pt, err := decrypt(passphrase, b)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(pt, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// save encrypts and writes the held messages.
+func (s *HeldFileStore) save(passphrase string, m map[string]domain.HeldMessage) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	N, r, p := scryptParamsDefault()
+	ct, err := encrypt(passphrase, raw, N, r, p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(s.dir, heldFilename), ct, 0o600)
+}
+
+// Compile-time assertion that HeldFileStore implements domain.HeldStore.
+var _ domain.HeldStore = (*HeldFileStore)(nil)
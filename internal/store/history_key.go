@@ -0,0 +1,241 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+)
+
+const (
+	historyKeyFilename     = "history-key.json"
+	historyPendingFilename = "history-pending.json"
+
+	// historyFormatVersion is the format of a history file encrypted under
+	// the history key; version 1 was encrypted under the passphrase itself.
+	historyFormatVersion = 2
+)
+
+// historyKeyRecord is the history key as stored. The key is an X25519 pair:
+// the history file is encrypted under a key derived from its private half,
+// which is kept encrypted under the passphrase that opens the history, in the
+// same format as the identity file. The public half is kept in the clear, so
+// history can be sealed for later while that passphrase is not at hand.
+type historyKeyRecord struct {
+	Locked  bool                `json:"locked"` // encrypted under the history's own passphrase
+	Public  domain.X25519Public `json:"public"`
+	Wrapped []byte              `json:"wrapped"`
+}
+
+// historyBlob is the on-disk history file: entries sealed with
+// XChaCha20-Poly1305 under the file key.
+type historyBlob struct {
+	V      int    `json:"v"`
+	Nonce  []byte `json:"nonce"`
+	Cipher []byte `json:"cipher"`
+}
+
+// historyOp is a change to the history made while its key was locked away:
+// entries to add, or a peer whose entries to remove.
+type historyOp struct {
+	Append     []domain.HistoryEntry `json:"append,omitempty"`
+	DeletePeer string                `json:"delete_peer,omitempty"`
+}
+
+// sealedOp is a historyOp sealed to the history key's public half under a
+// key from an exchange with the ephemeral key Ephemeral. Each key seals one
+// op, so the nonce is zero.
+type sealedOp struct {
+	Ephemeral domain.X25519Public `json:"ephemeral"`
+	Cipher    []byte              `json:"cipher"`
+}
+
+// readHistoryKey returns the stored history key, and false if there is none
+// yet.
+func (s *HistoryFileStore) readHistoryKey() (historyKeyRecord, bool, error) {
+	var rec historyKeyRecord
+	path := filepath.Join(s.dir, historyKeyFilename)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return rec, false, nil
+	}
+	if err := readJSON(path, &rec); err != nil {
+		return rec, false, err
+	}
+	if len(rec.Wrapped) == 0 {
+		return rec, false, fmt.Errorf("%s: missing wrapped key", historyKeyFilename)
+	}
+	return rec, true, nil
+}
+
+// newHistoryKey makes a history key encrypted under passphrase, stores it and
+// returns its file key.
+func (s *HistoryFileStore) newHistoryKey(passphrase string) ([]byte, error) {
+	priv, pub, err := crypto.GenerateX25519()
+	if err != nil {
+		return nil, err
+	}
+	defer crypto.Wipe(priv[:])
+	N, r, p := scryptParamsDefault()
+	wrapped, err := encrypt(passphrase, priv[:], N, r, p)
+	if err != nil {
+		return nil, err
+	}
+	rec := historyKeyRecord{Public: pub, Wrapped: wrapped}
+	if err := writeJSON(filepath.Join(s.dir, historyKeyFilename), rec, 0o600); err != nil {
+		return nil, err
+	}
+	return historyFileKey(priv)
+}
+
+// openHistoryKey decrypts rec's private half with passphrase. A locked key
+// that passphrase does not open yields domain.ErrHistoryLocked.
+func openHistoryKey(rec historyKeyRecord, passphrase string) (domain.X25519Private, error) {
+	var priv domain.X25519Private
+	pt, err := decrypt(passphrase, rec.Wrapped)
+	if errors.Is(err, errWrongPassphrase) && rec.Locked {
+		return priv, domain.ErrHistoryLocked
+	}
+	if err != nil {
+		return priv, err
+	}
+	defer crypto.Wipe(pt)
+	if len(pt) != len(priv) {
+		return priv, fmt.Errorf("%s: history key must be %d bytes, got %d", historyKeyFilename, len(priv), len(pt))
+	}
+	copy(priv[:], pt)
+	return priv, nil
+}
+
+// historyFileKey derives the key the history file is encrypted under.
+func historyFileKey(priv domain.X25519Private) ([]byte, error) {
+	return crypto.HKDF(priv[:], nil, crypto.LabelHistoryFile, chacha20poly1305.KeySize)
+}
+
+// sealHistory encrypts raw under the file key.
+func sealHistory(key, raw []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.Marshal(historyBlob{V: historyFormatVersion, Nonce: nonce, Cipher: aead.Seal(nil, nonce, raw, nil)})
+}
+
+// openHistory decrypts a history file sealed by sealHistory.
+func openHistory(key, b []byte) ([]byte, error) {
+	var bl historyBlob
+	if err := json.Unmarshal(b, &bl); err != nil {
+		return nil, err
+	}
+	if bl.V != historyFormatVersion {
+		return nil, fmt.Errorf("unsupported history version %d", bl.V)
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(bl.Nonce) != aead.NonceSize() {
+		return nil, errors.New("corrupted history")
+	}
+	pt, err := aead.Open(nil, bl.Nonce, bl.Cipher, nil)
+	if err != nil {
+		return nil, errors.New("corrupted history")
+	}
+	return pt, nil
+}
+
+// sealKey derives the key sealing an op from the exchange between the
+// ephemeral and history keys, bound to both public halves.
+func sealKey(shared [32]byte, ephemeral, public domain.X25519Public) ([]byte, error) {
+	salt := append(ephemeral[:], public[:]...)
+	return crypto.HKDF(shared[:], salt, crypto.LabelHistorySeal, chacha20poly1305.KeySize)
+}
+
+// addPending seals op to the history key's public half and adds it to the
+// changes waiting for the history to be opened.
+func (s *HistoryFileStore) addPending(public domain.X25519Public, op historyOp) error {
+	raw, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	eph, ephPub, err := crypto.GenerateX25519()
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(eph[:])
+	shared, err := crypto.DH(eph, public)
+	if err != nil {
+		return err
+	}
+	key, err := sealKey(shared, ephPub, public)
+	if err != nil {
+		return err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return err
+	}
+	var nonce [chacha20poly1305.NonceSize]byte
+	sealed := sealedOp{Ephemeral: ephPub, Cipher: aead.Seal(nil, nonce[:], raw, nil)}
+
+	var pending []sealedOp
+	path := filepath.Join(s.dir, historyPendingFilename)
+	if err := readJSON(path, &pending); err != nil {
+		return err
+	}
+	return writeJSON(path, append(pending, sealed), 0o600)
+}
+
+// readPending opens the changes made while the history key was locked away,
+// oldest first.
+func (s *HistoryFileStore) readPending(priv domain.X25519Private, public domain.X25519Public) ([]historyOp, error) {
+	var pending []sealedOp
+	if err := readJSON(filepath.Join(s.dir, historyPendingFilename), &pending); err != nil {
+		return nil, err
+	}
+	ops := make([]historyOp, 0, len(pending))
+	for i, sealed := range pending {
+		shared, err := crypto.DH(priv, sealed.Ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		key, err := sealKey(shared, sealed.Ephemeral, public)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, err
+		}
+		var nonce [chacha20poly1305.NonceSize]byte
+		raw, err := aead.Open(nil, nonce[:], sealed.Cipher, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: change %d: corrupted", historyPendingFilename, i+1)
+		}
+		var op historyOp
+		if err := json.Unmarshal(raw, &op); err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// clearPending removes the changes once they are in the history file.
+func (s *HistoryFileStore) clearPending() error {
+	err := os.Remove(filepath.Join(s.dir, historyPendingFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,315 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sort"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+)
+
+const historyFilename = "history.json.enc"
+
+// HistoryFileStore persists message history encrypted under a history key of
+// its own, which is in turn encrypted under the identity passphrase or, once
+// the history is locked, under a passphrase of the history's own (see
+// historyKeyRecord). The whole history is one blob, so every append
+// re-encrypts it; callers batch entries where they can.
+//
+// A locked history still takes appends and deletions made with the identity
+// passphrase: they are sealed to the key's public half and applied the next
+// time the history is opened. Files from before history keys, encrypted
+// under the passphrase itself, get a key the first time they are opened.
+type HistoryFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewHistoryFileStore returns a HistoryFileStore rooted at dir.
+func NewHistoryFileStore(dir string) *HistoryFileStore {
+	return &HistoryFileStore{dir: dir, mu: storeLock{path: lockPath(dir, historyFilename)}}
+}
+
+// AppendHistory adds entries whose IDs are not already stored. On a locked
+// history they are sealed for later (see deferLocked) and all count as added.
+func (s *HistoryFileStore) AppendHistory(passphrase string, entries []domain.HistoryEntry) (int, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	all, key, err := s.load(passphrase)
+	if errors.Is(err, domain.ErrHistoryLocked) {
+		return len(entries), s.deferLocked(historyOp{Append: entries})
+	}
+	if err != nil {
+		return 0, err
+	}
+	n := len(all)
+	all = appendNew(all, entries)
+	added := len(all) - n
+	if added == 0 {
+		return 0, nil
+	}
+	return added, s.save(passphrase, key, all)
+}
+
+// LoadHistory returns every entry, oldest first.
+func (s *HistoryFileStore) LoadHistory(passphrase string) ([]domain.HistoryEntry, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	all, _, err := s.load(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].SentUTC < all[j].SentUTC })
+	return all, nil
+}
+
+// DeleteHistory removes every entry with peer, imported ones included. On a
+// locked history the removal is applied when it is next opened, and 0 is
+// returned.
+func (s *HistoryFileStore) DeleteHistory(passphrase, peer string) (int, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	all, key, err := s.load(passphrase)
+	if errors.Is(err, domain.ErrHistoryLocked) {
+		return 0, s.deferLocked(historyOp{DeletePeer: peer})
+	}
+	if err != nil {
+		return 0, err
+	}
+	kept := deletePeer(all, peer)
+	removed := len(all) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.save(passphrase, key, kept)
+}
+
+// PruneHistory removes the entries r no longer keeps at now, judging each
+// conversation oldest first.
+func (s *HistoryFileStore) PruneHistory(passphrase string, r domain.Retention, now int64) (int, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	all, key, err := s.load(passphrase)
+	if err != nil {
+		return 0, err
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].SentUTC < all[j].SentUTC })
+	byPeer := make(map[string][]domain.HistoryEntry)
+	for _, e := range all {
+		byPeer[e.Peer] = append(byPeer[e.Peer], e)
+	}
+	drop := make(map[string]bool)
+	for peer, entries := range byPeer {
+		for _, id := range r.For(peer).Expired(entries, now) {
+			drop[id] = true
+		}
+	}
+	if len(drop) == 0 {
+		return 0, nil
+	}
+	kept := all[:0]
+	for _, e := range all {
+		if !drop[e.ID] {
+			kept = append(kept, e)
+		}
+	}
+	removed := len(all) - len(kept)
+	return removed, s.save(passphrase, key, kept)
+}
+
+// RewrapHistoryKey re-encrypts the history key under next instead of
+// current, marking it locked or not. A history without a key yet, legacy or
+// empty, is given one under current first.
+func (s *HistoryFileStore) RewrapHistoryKey(current, next string, locked bool) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	rec, found, err := s.readHistoryKey()
+	if err != nil {
+		return err
+	}
+	if !found {
+		all, key, err := s.load(current)
+		if err != nil {
+			return err
+		}
+		if err := s.save(current, key, all); err != nil {
+			return err
+		}
+		if rec, _, err = s.readHistoryKey(); err != nil {
+			return err
+		}
+	}
+	priv, err := openHistoryKey(rec, current)
+	if err != nil {
+		return err
+	}
+	defer crypto.Wipe(priv[:])
+	N, r, p := scryptParamsDefault()
+	if rec.Wrapped, err = encrypt(next, priv[:], N, r, p); err != nil {
+		return err
+	}
+	rec.Locked = locked
+	return writeJSON(filepath.Join(s.dir, historyKeyFilename), rec, 0o600)
+}
+
+// HistoryLocked reports whether the history key is encrypted under the
+// history's own passphrase.
+func (s *HistoryFileStore) HistoryLocked() (bool, error) {
+	rec, _, err := s.readHistoryKey()
+	return rec.Locked, err
+}
+
+// load decrypts the history file and applies the changes made while it was
+// locked, returning the entries and the file key. A missing file is an empty
+// history, with a nil key until save makes one. A legacy file is moved under
+// a new history key. The caller holds s.mu.
+func (s *HistoryFileStore) load(passphrase string) ([]domain.HistoryEntry, []byte, error) {
+	b, err := readFile(filepath.Join(s.dir, historyFilename))
+	if err != nil {
+		return nil, nil, err
+	}
+	rec, found, err := s.readHistoryKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !found {
+		return s.loadLegacy(passphrase, b)
+	}
+
+	priv, err := openHistoryKey(rec, passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer crypto.Wipe(priv[:])
+	key, err := historyFileKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	var all []domain.HistoryEntry
+	if b != nil {
+		pt, err := openHistory(key, b)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(pt, &all); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ops, err := s.readPending(priv, rec.Public)
+	if err != nil || len(ops) == 0 {
+		return all, key, err
+	}
+	for _, op := range ops {
+		all = appendNew(all, op.Append)
+		if op.DeletePeer != "" {
+			all = deletePeer(all, op.DeletePeer)
+		}
+	}
+	if err := s.save(passphrase, key, all); err != nil {
+		return nil, nil, err
+	}
+	return all, key, s.clearPending()
+}
+
+// loadLegacy decrypts b, a history file encrypted under passphrase itself,
+// and saves it again under a new history key.
+func (s *HistoryFileStore) loadLegacy(passphrase string, b []byte) ([]domain.HistoryEntry, []byte, error) {
+	if b == nil {
+		return nil, nil, nil
+	}
+	pt, err := decrypt(passphrase, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	var all []domain.HistoryEntry
+	if err := json.Unmarshal(pt, &all); err != nil {
+		return nil, nil, err
+	}
+	key, err := s.newHistoryKey(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	return all, key, s.save(passphrase, key, all)
+}
+
+// save encrypts all under key, making a history key encrypted under
+// passphrase if there is none yet, and replaces the history file.
+func (s *HistoryFileStore) save(passphrase string, key []byte, all []domain.HistoryEntry) error {
+	if key == nil {
+		var err error
+		if key, err = s.newHistoryKey(passphrase); err != nil {
+			return err
+		}
+	}
+	raw, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	ct, err := sealHistory(key, raw)
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(s.dir, historyFilename), ct, 0o600)
+}
+
+// deferLocked seals op for the locked history to apply when it is next
+// opened.
+func (s *HistoryFileStore) deferLocked(op historyOp) error {
+	rec, _, err := s.readHistoryKey()
+	if err != nil {
+		return err
+	}
+	return s.addPending(rec.Public, op)
+}
+
+// appendNew appends the entries of add whose IDs all does not hold.
+func appendNew(all, add []domain.HistoryEntry) []domain.HistoryEntry {
+	seen := make(map[string]bool, len(all))
+	for _, e := range all {
+		seen[e.ID] = true
+	}
+	for _, e := range add {
+		if !seen[e.ID] {
+			seen[e.ID] = true
+			all = append(all, e)
+		}
+	}
+	return all
+}
+
+// deletePeer returns all without the entries with peer, reusing its array.
+func deletePeer(all []domain.HistoryEntry, peer string) []domain.HistoryEntry {
+	kept := all[:0]
+	for _, e := range all {
+		if e.Peer != peer {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// Compile-time assertion that HistoryFileStore implements domain.HistoryStore.
+var _ domain.HistoryStore = (*HistoryFileStore)(nil)
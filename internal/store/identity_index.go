@@ -0,0 +1,69 @@
+package store
+
+import (
+	"slices"
+
+	"ciphera/internal/domain"
+)
+
+// IdentityKeyIndex finds the peers stored under an identity key, across
+// contacts, sessions and conversations. It keeps no file of its own: each
+// lookup indexes the stores it was built on, so it never disagrees with
+// them, and takes each store's lock in turn rather than all at once.
+type IdentityKeyIndex struct {
+	contacts domain.ContactStore
+	sessions domain.SessionStore
+	ratchets domain.RatchetStore
+}
+
+// NewIdentityKeyIndex returns an IdentityKeyIndex over the given stores.
+func NewIdentityKeyIndex(
+	contacts domain.ContactStore,
+	sessions domain.SessionStore,
+	ratchets domain.RatchetStore,
+) *IdentityKeyIndex {
+	return &IdentityKeyIndex{contacts: contacts, sessions: sessions, ratchets: ratchets}
+}
+
+// PeersWithIdentity returns every peer stored with identity key ik, sorted
+// and without duplicates. Conversations saved before their peer's identity
+// key was recorded are not indexed.
+func (x *IdentityKeyIndex) PeersWithIdentity(ik domain.X25519Public) ([]string, error) {
+	index, err := x.index()
+	if err != nil {
+		return nil, err
+	}
+	peers := index[ik]
+	slices.Sort(peers)
+	return slices.Compact(peers), nil
+}
+
+// index maps every identity key in the stores to the peers stored with it.
+func (x *IdentityKeyIndex) index() (map[domain.X25519Public][]string, error) {
+	contacts, err := x.contacts.ListContacts()
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := x.sessions.ListSessions()
+	if err != nil {
+		return nil, err
+	}
+	convs, err := x.ratchets.ListConversations()
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[domain.X25519Public][]string)
+	for _, c := range contacts {
+		index[c.IdentityKey] = append(index[c.IdentityKey], c.Username)
+	}
+	for _, sess := range sessions {
+		index[sess.PeerIK] = append(index[sess.PeerIK], sess.Peer)
+	}
+	for _, c := range convs {
+		if c.PeerIK != (domain.X25519Public{}) {
+			index[c.PeerIK] = append(index[c.PeerIK], c.Peer)
+		}
+	}
+	return index, nil
+}
@@ -2,9 +2,7 @@ package store
 
 import (
 	"encoding/json"
-	"os"
 	"path/filepath"
-	"sync"
 
 	"ciphera/internal/domain"
 )
@@ -14,18 +12,21 @@ const idFilename = "identity.json.enc"
 // IdentityFileStore persists the local identity to disk.
 type IdentityFileStore struct {
 	dir string
-	mu  sync.Mutex
+	mu  storeLock
 }
 
 // NewIdentityFileStore returns an IdentityFileStore rooted at dir.
 func NewIdentityFileStore(dir string) *IdentityFileStore {
-	return &IdentityFileStore{dir: dir}
+	return &IdentityFileStore{dir: dir, mu: storeLock{path: lockPath(dir, idFilename)}}
 }
 
 // SaveIdentity writes the encrypted identity to disk.
 func (s *IdentityFileStore) SaveIdentity(passphrase string, id domain.Identity) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	raw, err := json.Marshal(id)
 	if err != nil {
@@ -42,12 +43,15 @@ func (s *IdentityFileStore) SaveIdentity(passphrase string, id domain.Identity)
 
 // LoadIdentity reads and decrypts the identity.
 func (s *IdentityFileStore) LoadIdentity(passphrase string) (domain.Identity, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return domain.Identity{}, err
+	}
+	defer unlock()
 
 	path := filepath.Join(s.dir, idFilename)
 
-	b, err := os.ReadFile(path)
+	b, err := readExisting(path)
 	if err != nil {
 		return domain.Identity{}, err
 	}
@@ -3,11 +3,15 @@ package store
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // readJSON best-effort reads path into out; a missing file is not an error.
+//
+// Versioned store files are unwrapped from their schema envelope (see schema.go).
 func readJSON(path string, out any) error {
 	b, err := readFile(path)
 	if err != nil {
@@ -16,12 +20,16 @@ func readJSON(path string, out any) error {
 	if b == nil { // file didn’t exist
 		return nil
 	}
-	return json.Unmarshal(b, out)
+	version, data := unwrapSchema(b)
+	if err := checkSchema(path, version); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return json.Unmarshal(data, out)
 }
 
 // readFile reads the file at path into b; a missing file is not an error.
 func readFile(path string) ([]byte, error) {
-	b, err := os.ReadFile(path)
+	b, err := readExisting(path)
 	if errors.Is(err, os.ErrNotExist) {
 		return nil, nil
 	}
@@ -31,8 +39,28 @@ func readFile(path string) ([]byte, error) {
 	return b, nil
 }
 
-// writeJSON writes JSON via a temp file then rename.
+// readExisting reads the file at path, which must exist, and reports the read
+// to the store telemetry.
+func readExisting(path string) ([]byte, error) {
+	start := time.Now()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	observe(OpRead, path, len(b), start)
+	return b, nil
+}
+
+// writeJSON writes JSON via a temp file then rename. Versioned store files are
+// wrapped in a schema envelope carrying the current version.
 func writeJSON(path string, v any, mode os.FileMode) error {
+	if version, ok := currentSchema(path); ok {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		v = schemaEnvelope{Schema: version, Data: data}
+	}
 	b, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
 		return err
@@ -42,6 +70,7 @@ func writeJSON(path string, v any, mode os.FileMode) error {
 
 // writeFile writes bytes via a temp file, then atomically replaces the target.
 func writeFile(path string, b []byte, mode os.FileMode) error {
+	start := time.Now()
 	dir := filepath.Dir(path)
 	base := filepath.Base(path)
 
@@ -66,5 +95,9 @@ func writeFile(path string, b []byte, mode os.FileMode) error {
 		return err
 	}
 
-	return os.Rename(tmp, path)
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	observe(OpWrite, path, len(b), start)
+	return nil
 }
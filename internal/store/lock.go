@@ -0,0 +1,76 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	lockSuffix  = ".lock"
+	lockTimeout = 10 * time.Second      // wait for another process before giving up
+	lockPoll    = 20 * time.Millisecond // delay between attempts while waiting
+)
+
+// ErrLockTimeout is returned when another process held a store's lock for
+// longer than the lock timeout, usually because another ciphera command is
+// still running against the same home directory.
+var ErrLockTimeout = errors.New("store is locked by another ciphera process")
+
+// storeLock serialises a store's reads and writes: within this process
+// through mu, and across processes through an advisory lock on the file at
+// path (flock on Unix, LockFileEx on Windows). The lock is held for a whole
+// read-modify-write, so concurrent commands cannot lose each other's updates.
+type storeLock struct {
+	mu   sync.Mutex
+	path string
+}
+
+// lockPath returns the lock file for the store called name in dir.
+func lockPath(dir, name string) string {
+	return filepath.Join(dir, name+lockSuffix)
+}
+
+// lock takes the lock, waiting up to lockTimeout for other processes. The
+// returned function releases it. Without the store's directory there is
+// nothing to protect yet, so only the in-process lock is taken.
+func (l *storeLock) lock() (func(), error) {
+	start := time.Now()
+	l.mu.Lock()
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0o600)
+	if errors.Is(err, os.ErrNotExist) {
+		return l.mu.Unlock, nil
+	}
+	if err != nil {
+		l.mu.Unlock()
+		return nil, err
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		ok, err := tryLockFile(f)
+		if err != nil {
+			_ = f.Close()
+			l.mu.Unlock()
+			return nil, fmt.Errorf("locking %s: %w", filepath.Base(l.path), err)
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			_ = f.Close()
+			l.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s", ErrLockTimeout, filepath.Base(l.path))
+		}
+		time.Sleep(lockPoll)
+	}
+	observe(OpLock, l.path, 0, start)
+	return func() {
+		_ = unlockFile(f)
+		_ = f.Close()
+		l.mu.Unlock()
+	}, nil
+}
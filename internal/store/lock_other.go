@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+
+package store
+
+import "os"
+
+// tryLockFile always succeeds: this platform has no advisory file locks, so
+// stores are only locked within a process.
+func tryLockFile(*os.File) (bool, error) { return true, nil }
+
+// unlockFile does nothing.
+func unlockFile(*os.File) error { return nil }
@@ -0,0 +1,270 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"ciphera/internal/domain"
+)
+
+const (
+	backupsDirname    = "backups"
+	migrationsLogFile = "migrations.log"
+	migrationLockName = "migrations"
+)
+
+// migration upgrades one store file from schema version From to From+1.
+//
+// apply receives the file's contents without the schema envelope and returns
+// the upgraded contents. dir is the home directory, for migrations that move
+// data into other files.
+type migration struct {
+	File  string
+	From  int
+	Name  string
+	apply func(dir string, data json.RawMessage) (json.RawMessage, error)
+}
+
+// migrations is the ordered registry of schema upgrades. Append new steps at
+// the end and bump the file's entry in schemaVersions to match; never edit or
+// remove a released step.
+var migrations = []migration{
+	adoptSchema(accountsFilename),
+	adoptSchema(broadcastsFilename),
+	adoptSchema(bundleFile),
+	adoptSchema(contactsFilename),
+	adoptSchema(convFilename),
+	adoptSchema(opkPairsFile),
+	adoptSchema(preferencesFilename),
+	adoptSchema(prekeyMetaFile),
+	adoptSchema(quarantineFilename),
+	adoptSchema(sessionsFilename),
+	adoptSchema(settingsFilename),
+	adoptSchema(spkPairsFile),
+	{
+		File:  convFilename,
+		From:  1,
+		Name:  "move inline skipped message keys to side files",
+		apply: migrateInlineSkipped,
+	},
+	{
+		File:  convFilename,
+		From:  2,
+		Name:  "move conversations to per-peer binary records",
+		apply: migrateConvRecords,
+	},
+	{
+		File:  opkPairsFile,
+		From:  1,
+		Name:  "record one-time prekey generation times",
+		apply: keepData,
+	},
+	{
+		File:  spkPairsFile,
+		From:  1,
+		Name:  "record signed prekey generation times",
+		apply: keepData,
+	},
+}
+
+// MigrationRecord is the audit entry written for each applied migration.
+type MigrationRecord struct {
+	File       string `json:"file"`
+	From       int    `json:"from"`
+	To         int    `json:"to"`
+	Name       string `json:"name"`
+	Backup     string `json:"backup"`
+	AppliedUTC int64  `json:"applied_utc"`
+}
+
+// Migrate upgrades every store file in dir to its current schema version.
+//
+// Before a file is changed it is copied to backups/<file>.v<N>.<unix time>.
+// The upgraded file is written atomically, and each applied step is appended
+// to migrations.log as one JSON line. Files that are missing or already
+// current are left alone. Migrate must run before any store is used. It
+// holds a lock while it runs, so a command started at the same time waits and
+// then finds the files already current.
+func Migrate(dir string) ([]MigrationRecord, error) {
+	l := storeLock{path: lockPath(dir, migrationLockName)}
+	unlock, err := l.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	files := make([]string, 0, len(schemaVersions))
+	for f := range schemaVersions {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	var applied []MigrationRecord
+	for _, f := range files {
+		recs, err := migrateFile(dir, f)
+		applied = append(applied, recs...)
+		if err != nil {
+			return applied, fmt.Errorf("migrating %s: %w", f, err)
+		}
+	}
+	return applied, nil
+}
+
+// migrateFile applies the pending migrations for one file.
+func migrateFile(dir, file string) ([]MigrationRecord, error) {
+	path := filepath.Join(dir, file)
+	b, err := readFile(path)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	version, raw := unwrapSchema(b)
+	if err := checkSchema(path, version); err != nil {
+		return nil, err
+	}
+	data := json.RawMessage(raw)
+	target, _ := currentSchema(path)
+	if version == target {
+		return nil, nil
+	}
+
+	backup, err := backupFile(dir, file, version, b)
+	if err != nil {
+		return nil, err
+	}
+
+	var recs []MigrationRecord
+	for version < target {
+		m, ok := findMigration(file, version)
+		if !ok {
+			return recs, fmt.Errorf("no migration from schema version %d", version)
+		}
+		if data, err = m.apply(dir, data); err != nil {
+			return recs, fmt.Errorf("%s: %w", m.Name, err)
+		}
+		version++
+		recs = append(recs, MigrationRecord{
+			File:       file,
+			From:       m.From,
+			To:         version,
+			Name:       m.Name,
+			Backup:     backup,
+			AppliedUTC: time.Now().Unix(),
+		})
+	}
+
+	if err := writeJSON(path, data, 0o600); err != nil {
+		return recs, err
+	}
+	return recs, appendMigrationLog(dir, recs)
+}
+
+// findMigration returns the registered step for file starting at version from.
+func findMigration(file string, from int) (migration, bool) {
+	for _, m := range migrations {
+		if m.File == file && m.From == from {
+			return m, true
+		}
+	}
+	return migration{}, false
+}
+
+// backupFile copies the original bytes of file into the backups directory and
+// returns the backup path relative to dir.
+func backupFile(dir, file string, version int, b []byte) (string, error) {
+	if err := os.MkdirAll(filepath.Join(dir, backupsDirname), 0o700); err != nil {
+		return "", err
+	}
+	name := filepath.Join(backupsDirname, fmt.Sprintf("%s.v%d.%d", file, version, time.Now().Unix()))
+	if err := writeFile(filepath.Join(dir, name), b, 0o600); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// appendMigrationLog appends recs to migrations.log, one JSON object per line.
+func appendMigrationLog(dir string, recs []MigrationRecord) error {
+	f, err := os.OpenFile(
+		filepath.Join(dir, migrationsLogFile),
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND,
+		0o600,
+	)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, r := range recs {
+		if err := enc.Encode(r); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// adoptSchema is the first step for every file: the contents are unchanged and
+// only gain the schema envelope when written back.
+func adoptSchema(file string) migration {
+	return migration{
+		File:  file,
+		From:  0,
+		Name:  "add schema version",
+		apply: keepData,
+	}
+}
+
+// keepData is the step for a version that only adds optional fields: the
+// contents stay as they are, and the version keeps older clients, which
+// refuse fields they do not know, from reading the file.
+func keepData(_ string, data json.RawMessage) (json.RawMessage, error) {
+	return data, nil
+}
+
+// migrateInlineSkipped moves skipped message keys stored inside
+// conversations.json into per-conversation side files (conversations v1 → v2).
+func migrateInlineSkipped(dir string, data json.RawMessage) (json.RawMessage, error) {
+	m := map[string]domain.Conversation{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	for peer, c := range m {
+		if len(c.State.Skipped) == 0 {
+			continue
+		}
+		// An existing side file is authoritative (see attachSkipped): the
+		// inline copy is stale and may still hold keys that were since used.
+		_, ok, err := loadSkipped(dir, peer)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if err := saveSkipped(dir, peer, c.State.Skipped); err != nil {
+				return nil, err
+			}
+		}
+		c.State.Skipped = nil
+		m[peer] = c
+	}
+	return json.Marshal(m)
+}
+
+// migrateConvRecords moves every conversation in conversations.json into its
+// own binary record (conversations v2 → v3) and leaves the file empty.
+func migrateConvRecords(dir string, data json.RawMessage) (json.RawMessage, error) {
+	m := map[string]domain.Conversation{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	for peer, c := range m {
+		// Records left by an interrupted run hold the same state, since
+		// nothing writes records before the migration completes.
+		c.Peer = peer
+		if err := saveConvRecord(dir, c); err != nil {
+			return nil, err
+		}
+	}
+	return json.RawMessage("{}"), nil
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"cmp"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"ciphera/internal/domain"
+)
+
+const (
+	outboxFilename = "outbox.json"
+	// maxOutboxPerPeer bounds the journal; the oldest entries go first.
+	maxOutboxPerPeer = 500
+	// maxResendablePerPeer bounds how many of the newest entries keep their
+	// envelope for resends.
+	maxResendablePerPeer = 64
+)
+
+// OutboxFileStore journals the messages the relay accepted, keyed by peer.
+type OutboxFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewOutboxFileStore returns an OutboxFileStore rooted at dir.
+func NewOutboxFileStore(dir string) *OutboxFileStore {
+	return &OutboxFileStore{dir: dir, mu: storeLock{path: lockPath(dir, outboxFilename)}}
+}
+
+// AppendSent adds m to peer's journal, dropping the oldest entries beyond
+// maxOutboxPerPeer and the envelopes of all but the newest
+// maxResendablePerPeer.
+func (s *OutboxFileStore) AppendSent(peer string, m domain.SentMessage) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, outboxFilename)
+	j := map[string][]domain.SentMessage{}
+	if err := readJSON(path, &j); err != nil {
+		return err
+	}
+	sent := append(j[peer], m)
+	if n := len(sent) - maxOutboxPerPeer; n > 0 {
+		sent = sent[n:]
+	}
+	if n := len(sent) - maxResendablePerPeer; n > 0 {
+		for i := range sent[:n] {
+			sent[i].Envelope = nil
+		}
+	}
+	j[peer] = sent
+	return writeJSON(path, j, 0o600)
+}
+
+// ListSent returns peer's journal, oldest first.
+func (s *OutboxFileStore) ListSent(peer string) ([]domain.SentMessage, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, outboxFilename)
+	j := map[string][]domain.SentMessage{}
+	if err := readJSON(path, &j); err != nil {
+		return nil, err
+	}
+	return j[peer], nil
+}
+
+// MarkExpired records at as the expiry of the entries numbered seqs by the
+// relay named by any of relays, and returns them with their peers. Relay
+// URLs are compared without trailing slashes. Seqs with no entry are
+// ignored, as are entries already marked.
+func (s *OutboxFileStore) MarkExpired(relays []string, seqs []uint64, at int64) ([]domain.UndeliveredMessage, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, outboxFilename)
+	j := map[string][]domain.SentMessage{}
+	if err := readJSON(path, &j); err != nil {
+		return nil, err
+	}
+	var out []domain.UndeliveredMessage
+	for peer, sent := range j {
+		for i, m := range sent {
+			if m.Seq == 0 || m.ExpiredUTC != 0 || !slices.Contains(seqs, m.Seq) ||
+				!slices.Contains(relays, strings.TrimRight(m.Relay, "/")) {
+				continue
+			}
+			sent[i].ExpiredUTC = at
+			out = append(out, domain.UndeliveredMessage{Peer: peer, SentMessage: sent[i]})
+		}
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	slices.SortFunc(out, func(a, b domain.UndeliveredMessage) int { return cmp.Compare(a.Seq, b.Seq) })
+	return out, writeJSON(path, j, 0o600)
+}
+
+// DeleteSent removes peer's journal and reports whether it existed.
+func (s *OutboxFileStore) DeleteSent(peer string) (bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, outboxFilename)
+	j := map[string][]domain.SentMessage{}
+	if err := readJSON(path, &j); err != nil {
+		return false, err
+	}
+	if _, ok := j[peer]; !ok {
+		return false, nil
+	}
+	delete(j, peer)
+	return true, writeJSON(path, j, 0o600)
+}
+
+// Compile-time assertion that OutboxFileStore implements domain.OutboxStore.
+var _ domain.OutboxStore = (*OutboxFileStore)(nil)
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"path/filepath"
+	"sort"
+
+	"ciphera/internal/domain"
+)
+
+const preferencesFilename = "preferences.json"
+
+// PreferenceFileStore persists per-conversation notification preferences.
+type PreferenceFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewPreferenceFileStore returns a PreferenceFileStore rooted at dir.
+func NewPreferenceFileStore(dir string) *PreferenceFileStore {
+	return &PreferenceFileStore{dir: dir, mu: storeLock{path: lockPath(dir, preferencesFilename)}}
+}
+
+// SavePreferences records p, replacing any entry for the same peer.
+func (s *PreferenceFileStore) SavePreferences(p domain.ConversationPrefs) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, preferencesFilename)
+	m := map[string]domain.ConversationPrefs{}
+	_ = readJSON(path, &m)
+	m[p.Peer] = p
+	return writeJSON(path, m, 0o600)
+}
+
+// LoadPreferences returns the preferences for peer, if any were saved.
+func (s *PreferenceFileStore) LoadPreferences(peer string) (domain.ConversationPrefs, bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return domain.ConversationPrefs{}, false, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, preferencesFilename)
+	m := map[string]domain.ConversationPrefs{}
+	if err := readJSON(path, &m); err != nil {
+		return domain.ConversationPrefs{}, false, err
+	}
+	p, ok := m[peer]
+	return p, ok, nil
+}
+
+// ListPreferences returns all saved preferences ordered by peer.
+func (s *PreferenceFileStore) ListPreferences() ([]domain.ConversationPrefs, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, preferencesFilename)
+	m := map[string]domain.ConversationPrefs{}
+	if err := readJSON(path, &m); err != nil {
+		return nil, err
+	}
+	out := make([]domain.ConversationPrefs, 0, len(m))
+	for _, p := range m {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Peer < out[j].Peer })
+	return out, nil
+}
+
+// Compile-time assertion that PreferenceFileStore implements domain.PreferenceStore.
+var _ domain.PreferenceStore = (*PreferenceFileStore)(nil)
@@ -1,8 +1,11 @@
 package store
 
 import (
+	"errors"
+	"fmt"
 	"path/filepath"
-	"sync"
+	"slices"
+	"time"
 
 	"ciphera/internal/domain"
 )
@@ -11,55 +14,84 @@ const (
 	spkPairsFile   = "spk_pairs.json"
 	opkPairsFile   = "opk_pairs.json"
 	prekeyMetaFile = "prekey_meta.json"
+	prekeyLockName = "prekeys" // one lock covers all three files
 )
 
+// maxConsumedTimes bounds the consumption times prekey_meta.json keeps; the
+// oldest go first.
+const maxConsumedTimes = 64
+
+// ErrKeyIDCollision indicates a prekey saved under an ID the store already
+// holds for another key. Keys are never overwritten, as the old key may
+// still be needed to answer a handshake.
+var ErrKeyIDCollision = errors.New("prekey ID already in use")
+
 // PrekeyFileStore persists SPK and OPK state to disk.
 type PrekeyFileStore struct {
 	dir string
-	mu  sync.Mutex
+	mu  storeLock
 }
 
 // NewPrekeyFileStore returns a PrekeyFileStore rooted at dir.
 func NewPrekeyFileStore(dir string) *PrekeyFileStore {
-	return &PrekeyFileStore{dir: dir}
+	return &PrekeyFileStore{dir: dir, mu: storeLock{path: lockPath(dir, prekeyLockName)}}
 }
 
-// Internal record types.
+// Internal record types. CreatedUTC is zero for keys saved before schema 2.
 type spkPair struct {
-	Priv [32]byte `json:"priv"`
-	Pub  [32]byte `json:"pub"`
-	Sig  []byte   `json:"sig"`
+	Priv       [32]byte `json:"priv"`
+	Pub        [32]byte `json:"pub"`
+	Sig        []byte   `json:"sig"`
+	CreatedUTC int64    `json:"created_utc,omitempty"`
 }
 
 type opkPair struct {
-	Priv [32]byte `json:"priv"`
-	Pub  [32]byte `json:"pub"`
+	Priv       [32]byte `json:"priv"`
+	Pub        [32]byte `json:"pub"`
+	CreatedUTC int64    `json:"created_utc,omitempty"`
 }
 
 type prekeyMeta struct {
-	CurrentSPKID string `json:"current_spk_id"`
+	CurrentSPKID domain.KeyID          `json:"current_spk_id"`
+	Consumed     int                   `json:"consumed,omitempty"`
+	ConsumedUTC  []int64               `json:"consumed_utc,omitempty"`
+	Schedule     domain.PrekeySchedule `json:"schedule"`
 }
 
-// SaveSignedPrekey stores a signed prekey by id.
+// SaveSignedPrekey stores a signed prekey by id. Saving the same key again,
+// such as to re-sign it, is allowed; saving another key under an id already
+// in use fails with ErrKeyIDCollision.
 func (s *PrekeyFileStore) SaveSignedPrekey(
-	id string,
+	id domain.KeyID,
 	priv domain.X25519Private,
 	pub domain.X25519Public,
 	sig []byte,
 ) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	path := filepath.Join(s.dir, spkPairsFile)
-	m := map[string]spkPair{}
-	_ = readJSON(path, &m)
-	m[id] = spkPair{Priv: priv, Pub: pub, Sig: sig}
+	m, err := readRecords(path, spkKeyFields, checkSPK)
+	if err != nil {
+		return err
+	}
+	created := time.Now().Unix()
+	if old, ok := m[string(id)]; ok {
+		if old.Pub != pub {
+			return fmt.Errorf("%w: signed prekey %s", ErrKeyIDCollision, id)
+		}
+		created = old.CreatedUTC
+	}
+	m[string(id)] = spkPair{Priv: priv, Pub: pub, Sig: sig, CreatedUTC: created}
 	return writeJSON(path, m, 0o600)
 }
 
 // LoadSignedPrekey retrieves a signed prekey by id.
 func (s *PrekeyFileStore) LoadSignedPrekey(
-	id string,
+	id domain.KeyID,
 ) (
 	priv domain.X25519Private,
 	pub domain.X25519Public,
@@ -67,15 +99,17 @@ func (s *PrekeyFileStore) LoadSignedPrekey(
 	ok bool,
 	err error,
 ) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return priv, pub, nil, false, err
+	}
+	defer unlock()
 
-	path := filepath.Join(s.dir, spkPairsFile)
-	m := map[string]spkPair{}
-	if err = readJSON(path, &m); err != nil {
+	m, err := readRecords(filepath.Join(s.dir, spkPairsFile), spkKeyFields, checkSPK)
+	if err != nil {
 		return priv, pub, nil, false, err
 	}
-	p, ok := m[id]
+	p, ok := m[string(id)]
 	if !ok {
 		return priv, pub, nil, false, nil
 	}
@@ -83,79 +117,148 @@ func (s *PrekeyFileStore) LoadSignedPrekey(
 }
 
 // SaveOneTimePrekeys merges the provided one-time prekey pairs into the store.
+// If any pair's ID is already stored, or repeats within pairs, none are saved
+// and ErrKeyIDCollision is returned.
 func (s *PrekeyFileStore) SaveOneTimePrekeys(pairs []domain.OneTimePair) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	path := filepath.Join(s.dir, opkPairsFile)
-	m := map[string]opkPair{}
-	_ = readJSON(path, &m)
+	m, err := readRecords(path, opkKeyFields, checkOPK)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
 	for _, p := range pairs {
-		m[p.ID] = opkPair{Priv: p.Priv, Pub: p.Pub}
+		if _, ok := m[string(p.ID)]; ok {
+			return fmt.Errorf("%w: one-time prekey %s", ErrKeyIDCollision, p.ID)
+		}
+		m[string(p.ID)] = opkPair{Priv: p.Priv, Pub: p.Pub, CreatedUTC: now}
 	}
 	return writeJSON(path, m, 0o600)
 }
 
-// ConsumeOneTimePrekey removes and returns a single one-time prekey by id.
+// LoadOneTimePrekey returns a single one-time prekey by id without removing it.
+func (s *PrekeyFileStore) LoadOneTimePrekey(
+	id domain.KeyID,
+) (
+	priv domain.X25519Private,
+	pub domain.X25519Public,
+	ok bool,
+	err error,
+) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return priv, pub, false, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, opkPairsFile)
+	m, err := readRecords(path, opkKeyFields, checkOPK)
+	if err != nil {
+		return priv, pub, false, err
+	}
+	p, ok := m[string(id)]
+	if !ok {
+		return priv, pub, false, nil
+	}
+	return p.Priv, p.Pub, true, nil
+}
+
+// ConsumeOneTimePrekey removes and returns a single one-time prekey by id,
+// recording when it was consumed.
 func (s *PrekeyFileStore) ConsumeOneTimePrekey(
-	id string,
+	id domain.KeyID,
 ) (
 	priv domain.X25519Private,
 	pub domain.X25519Public,
 	ok bool,
 	err error,
 ) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return priv, pub, false, err
+	}
+	defer unlock()
 
 	path := filepath.Join(s.dir, opkPairsFile)
-	m := map[string]opkPair{}
-	if err = readJSON(path, &m); err != nil {
+	m, err := readRecords(path, opkKeyFields, checkOPK)
+	if err != nil {
 		return priv, pub, false, err
 	}
-	p, ok := m[id]
+	p, ok := m[string(id)]
 	if !ok {
 		return priv, pub, false, nil
 	}
-	delete(m, id)
+	delete(m, string(id))
 	if err = writeJSON(path, m, 0o600); err != nil {
 		return priv, pub, false, err
 	}
+
+	metaPath := filepath.Join(s.dir, prekeyMetaFile)
+	var meta prekeyMeta
+	if err := readJSON(metaPath, &meta); err != nil {
+		return priv, pub, false, err
+	}
+	meta.Consumed++
+	meta.ConsumedUTC = append(meta.ConsumedUTC, time.Now().Unix())
+	if n := len(meta.ConsumedUTC); n > maxConsumedTimes {
+		meta.ConsumedUTC = slices.Delete(meta.ConsumedUTC, 0, n-maxConsumedTimes)
+	}
+	if err := writeJSON(metaPath, meta, 0o600); err != nil {
+		return priv, pub, false, err
+	}
 	return p.Priv, p.Pub, true, nil
 }
 
 // ListOneTimePrekeyPublics exposes only the public halves for bundling.
 func (s *PrekeyFileStore) ListOneTimePrekeyPublics() ([]domain.OneTimePub, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
-	path := filepath.Join(s.dir, opkPairsFile)
-	m := map[string]opkPair{}
-	if err := readJSON(path, &m); err != nil {
+	m, err := readRecords(filepath.Join(s.dir, opkPairsFile), opkKeyFields, checkOPK)
+	if err != nil {
 		return nil, err
 	}
 
 	out := make([]domain.OneTimePub, 0, len(m))
 	for id, p := range m {
-		out = append(out, domain.OneTimePub{ID: id, Pub: p.Pub})
+		out = append(out, domain.OneTimePub{ID: domain.KeyID(id), Pub: p.Pub})
 	}
 	return out, nil
 }
 
 // SetCurrentSignedPrekeyID records which signed prekey id is current.
-func (s *PrekeyFileStore) SetCurrentSignedPrekeyID(id string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *PrekeyFileStore) SetCurrentSignedPrekeyID(id domain.KeyID) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	path := filepath.Join(s.dir, prekeyMetaFile)
-	meta := prekeyMeta{CurrentSPKID: id}
+	var meta prekeyMeta
+	if err := readJSON(path, &meta); err != nil {
+		return err
+	}
+	meta.CurrentSPKID = id
 	return writeJSON(path, meta, 0o600)
 }
 
-// CurrentSignedPrekeyID returns the recorded current signed prekey id.
-func (s *PrekeyFileStore) CurrentSignedPrekeyID() (string, bool, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// CurrentSignedPrekeyID returns the recorded current signed prekey id, which
+// must name a stored signed prekey.
+func (s *PrekeyFileStore) CurrentSignedPrekeyID() (domain.KeyID, bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return "", false, err
+	}
+	defer unlock()
 
 	path := filepath.Join(s.dir, prekeyMetaFile)
 	var meta prekeyMeta
@@ -165,8 +268,80 @@ func (s *PrekeyFileStore) CurrentSignedPrekeyID() (string, bool, error) {
 	if meta.CurrentSPKID == "" {
 		return "", false, nil
 	}
+	spks, err := readRecords(filepath.Join(s.dir, spkPairsFile), spkKeyFields, checkSPK)
+	if err != nil {
+		return "", false, err
+	}
+	if _, ok := spks[string(meta.CurrentSPKID)]; !ok {
+		return "", false, &RecordError{
+			File:   prekeyMetaFile,
+			Field:  "current_spk_id",
+			Reason: fmt.Sprintf("names %q, which is not in %s", meta.CurrentSPKID, spkPairsFile),
+		}
+	}
 	return meta.CurrentSPKID, true, nil
 }
 
+// PrekeyStatus summarises the prekeys held, when they were generated and
+// consumed, and the recorded upkeep schedule.
+func (s *PrekeyFileStore) PrekeyStatus() (domain.PrekeyStatus, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return domain.PrekeyStatus{}, err
+	}
+	defer unlock()
+
+	var meta prekeyMeta
+	if err := readJSON(filepath.Join(s.dir, prekeyMetaFile), &meta); err != nil {
+		return domain.PrekeyStatus{}, err
+	}
+	st := domain.PrekeyStatus{
+		Consumed:    meta.Consumed,
+		ConsumedUTC: meta.ConsumedUTC,
+		Schedule:    meta.Schedule,
+	}
+	if meta.CurrentSPKID != "" {
+		spks, err := readRecords(filepath.Join(s.dir, spkPairsFile), spkKeyFields, checkSPK)
+		if err != nil {
+			return domain.PrekeyStatus{}, err
+		}
+		if p, ok := spks[string(meta.CurrentSPKID)]; ok {
+			st.SignedPrekeyID, st.SignedCreatedUTC = meta.CurrentSPKID, p.CreatedUTC
+		}
+	}
+	opks, err := readRecords(filepath.Join(s.dir, opkPairsFile), opkKeyFields, checkOPK)
+	if err != nil {
+		return domain.PrekeyStatus{}, err
+	}
+	st.OneTime = len(opks)
+	for _, p := range opks {
+		if p.CreatedUTC == 0 {
+			continue
+		}
+		if st.OneTimeOldestUTC == 0 || p.CreatedUTC < st.OneTimeOldestUTC {
+			st.OneTimeOldestUTC = p.CreatedUTC
+		}
+		st.OneTimeNewestUTC = max(st.OneTimeNewestUTC, p.CreatedUTC)
+	}
+	return st, nil
+}
+
+// SavePrekeySchedule records when prekey upkeep next runs.
+func (s *PrekeyFileStore) SavePrekeySchedule(sched domain.PrekeySchedule) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, prekeyMetaFile)
+	var meta prekeyMeta
+	if err := readJSON(path, &meta); err != nil {
+		return err
+	}
+	meta.Schedule = sched
+	return writeJSON(path, meta, 0o600)
+}
+
 // Compile-time assertion that PrekeyFileStore implements domain.PrekeyStore.
 var _ domain.PrekeyStore = (*PrekeyFileStore)(nil)
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"path/filepath"
+	"sort"
+
+	"ciphera/internal/domain"
+)
+
+const profilesFilename = "profiles.json"
+
+// ProfileFileStore persists our own profile and the profiles peers sent us.
+type ProfileFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// profilesFile is the contents of profiles.json.
+type profilesFile struct {
+	Own   *domain.Profile           `json:"own,omitempty"`
+	Peers map[string]domain.Profile `json:"peers,omitempty"`
+}
+
+// NewProfileFileStore returns a ProfileFileStore rooted at dir.
+func NewProfileFileStore(dir string) *ProfileFileStore {
+	return &ProfileFileStore{dir: dir, mu: storeLock{path: lockPath(dir, profilesFilename)}}
+}
+
+// SaveOwnProfile records p as our profile.
+func (s *ProfileFileStore) SaveOwnProfile(p domain.Profile) error {
+	return s.update(func(f *profilesFile) {
+		p.Peer = ""
+		f.Own = &p
+	})
+}
+
+// LoadOwnProfile returns our profile, if one was saved.
+func (s *ProfileFileStore) LoadOwnProfile() (domain.Profile, bool, error) {
+	f, err := s.load()
+	if err != nil || f.Own == nil {
+		return domain.Profile{}, false, err
+	}
+	return *f.Own, true, nil
+}
+
+// SavePeerProfile records p, replacing any profile from the same peer.
+func (s *ProfileFileStore) SavePeerProfile(p domain.Profile) error {
+	return s.update(func(f *profilesFile) {
+		if f.Peers == nil {
+			f.Peers = make(map[string]domain.Profile)
+		}
+		f.Peers[p.Peer] = p
+	})
+}
+
+// LoadPeerProfile returns the profile peer sent, if any.
+func (s *ProfileFileStore) LoadPeerProfile(peer string) (domain.Profile, bool, error) {
+	f, err := s.load()
+	if err != nil {
+		return domain.Profile{}, false, err
+	}
+	p, ok := f.Peers[peer]
+	return p, ok, nil
+}
+
+// ListPeerProfiles returns the profiles peers sent, ordered by peer.
+func (s *ProfileFileStore) ListPeerProfiles() ([]domain.Profile, error) {
+	f, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]domain.Profile, 0, len(f.Peers))
+	for _, p := range f.Peers {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Peer < out[j].Peer })
+	return out, nil
+}
+
+// load reads profiles.json under the lock.
+func (s *ProfileFileStore) load() (profilesFile, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return profilesFile{}, err
+	}
+	defer unlock()
+
+	var f profilesFile
+	err = readJSON(filepath.Join(s.dir, profilesFilename), &f)
+	return f, err
+}
+
+// update applies fn to profiles.json under the lock and writes it back.
+func (s *ProfileFileStore) update(fn func(f *profilesFile)) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, profilesFilename)
+	var f profilesFile
+	if err := readJSON(path, &f); err != nil {
+		return err
+	}
+	fn(&f)
+	return writeJSON(path, f, 0o600)
+}
+
+// Compile-time assertion that ProfileFileStore implements domain.ProfileStore.
+var _ domain.ProfileStore = (*ProfileFileStore)(nil)
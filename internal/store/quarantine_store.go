@@ -0,0 +1,85 @@
+package store
+
+import (
+	"path/filepath"
+	"sort"
+
+	"ciphera/internal/domain"
+)
+
+const quarantineFilename = "quarantine.json"
+
+// QuarantineFileStore persists envelopes that failed to decrypt.
+type QuarantineFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewQuarantineFileStore returns a QuarantineFileStore rooted at dir.
+func NewQuarantineFileStore(dir string) *QuarantineFileStore {
+	return &QuarantineFileStore{dir: dir, mu: storeLock{path: lockPath(dir, quarantineFilename)}}
+}
+
+// SaveQuarantined records q, replacing any entry with the same ID.
+func (s *QuarantineFileStore) SaveQuarantined(q domain.QuarantinedEnvelope) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, quarantineFilename)
+	m := map[string]domain.QuarantinedEnvelope{}
+	_ = readJSON(path, &m)
+	m[q.ID] = q
+	return writeJSON(path, m, 0o600)
+}
+
+// ListQuarantined returns all quarantined envelopes, oldest first.
+func (s *QuarantineFileStore) ListQuarantined() ([]domain.QuarantinedEnvelope, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, quarantineFilename)
+	m := map[string]domain.QuarantinedEnvelope{}
+	if err := readJSON(path, &m); err != nil {
+		return nil, err
+	}
+	out := make([]domain.QuarantinedEnvelope, 0, len(m))
+	for _, q := range m {
+		out = append(out, q)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].QuarantinedUTC != out[j].QuarantinedUTC {
+			return out[i].QuarantinedUTC < out[j].QuarantinedUTC
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+// DeleteQuarantined removes the entry with id and reports whether it existed.
+func (s *QuarantineFileStore) DeleteQuarantined(id string) (bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, quarantineFilename)
+	m := map[string]domain.QuarantinedEnvelope{}
+	if err := readJSON(path, &m); err != nil {
+		return false, err
+	}
+	if _, ok := m[id]; !ok {
+		return false, nil
+	}
+	delete(m, id)
+	return true, writeJSON(path, m, 0o600)
+}
+
+// Compile-time assertion that QuarantineFileStore implements domain.QuarantineStore.
+var _ domain.QuarantineStore = (*QuarantineFileStore)(nil)
@@ -1,49 +1,157 @@
 package store
 
 import (
+	"errors"
+	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 
 	"ciphera/internal/domain"
 )
 
+// convFilename is the JSON file conversations were kept in before schema
+// version 3. Migrate moves them into per-peer records and leaves the file
+// behind empty, so older clients refuse the home instead of seeing no
+// conversations. Its name still keys the store's lock.
 const convFilename = "conversations.json"
 
+// convLockDirname holds one lock file per peer (see LockConversation). It is
+// kept apart from the records so archiving a conversation empties their
+// directory.
+const convLockDirname = "conversation-locks"
+
 // RatchetFileStore persists per-peer Double-Ratchet state to disk.
+//
+// Each conversation is a compact binary record of its own (see
+// conv_record.go), and its skipped message keys are kept in a binary side
+// file (see skipped_keys.go), so neither grows with the number of peers.
 type RatchetFileStore struct {
 	dir string
-	mu  sync.Mutex
+	mu  storeLock
+
+	convMu sync.Mutex            // guards convs
+	convs  map[string]*storeLock // per-peer conversation locks, by peer
 }
 
 // NewRatchetFileStore returns a RatchetFileStore rooted at dir.
 func NewRatchetFileStore(dir string) *RatchetFileStore {
-	return &RatchetFileStore{dir: dir}
+	return &RatchetFileStore{
+		dir:   dir,
+		mu:    storeLock{path: lockPath(dir, convFilename)},
+		convs: make(map[string]*storeLock),
+	}
+}
+
+// LockConversation takes peer's conversation lock and returns the function
+// that releases it. Each peer has a lock file of its own, so a send or
+// receive holds it from loading the conversation until the advanced state is
+// saved and the envelope posted, without blocking other peers. It is taken
+// before the store's lock, never while holding it.
+func (s *RatchetFileStore) LockConversation(peer string) (func(), error) {
+	dir := filepath.Join(s.dir, convLockDirname)
+	if err := os.Mkdir(dir, 0o700); err != nil && !errors.Is(err, os.ErrExist) && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	s.convMu.Lock()
+	l, ok := s.convs[peer]
+	if !ok {
+		l = &storeLock{path: lockPath(dir, peerFilename(peer))}
+		s.convs[peer] = l
+	}
+	s.convMu.Unlock()
+	return l.lock()
 }
 
 // SaveConversation writes the Conversation for peer.
+//
+// The skipped keys are written first: if we crash in between, the side file
+// holds a superset of the keys the record expects, which is harmless.
 func (s *RatchetFileStore) SaveConversation(peer string, conv domain.Conversation) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	path := filepath.Join(s.dir, convFilename)
-	m := map[string]domain.Conversation{}
-	_ = readJSON(path, &m)
-	m[peer] = conv
-	return writeJSON(path, m, 0o600)
+	if err := saveSkipped(s.dir, peer, conv.State.Skipped); err != nil {
+		return err
+	}
+	conv.Peer = peer
+	return saveConvRecord(s.dir, conv)
 }
 
 // LoadConversation retrieves the Conversation for peer.
 func (s *RatchetFileStore) LoadConversation(peer string) (domain.Conversation, bool, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return domain.Conversation{}, false, err
+	}
+	defer unlock()
 
-	path := filepath.Join(s.dir, convFilename)
-	m := map[string]domain.Conversation{}
-	if err := readJSON(path, &m); err != nil {
+	c, ok, err := loadConvRecord(s.dir, peer)
+	if err != nil || !ok {
 		return domain.Conversation{}, false, err
 	}
-	c, ok := m[peer]
-	return c, ok, nil
+	if err := s.attachSkipped(peer, &c); err != nil {
+		return domain.Conversation{}, false, err
+	}
+	return c, true, nil
+}
+
+// ListConversations returns every stored Conversation, sorted by peer.
+func (s *RatchetFileStore) ListConversations() ([]domain.Conversation, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	out, err := listConvRecords(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out {
+		if err := s.attachSkipped(out[i].Peer, &out[i]); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Peer < out[j].Peer })
+	return out, nil
+}
+
+// DeleteConversation removes peer's Conversation and its skipped keys, and
+// reports whether the conversation existed.
+//
+// The record goes first, so a crash in between leaves an orphaned side file
+// rather than a conversation missing its keys.
+func (s *RatchetFileStore) DeleteConversation(peer string) (bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	ok, err := deleteConvRecord(s.dir, peer)
+	if err != nil {
+		return false, err
+	}
+	return ok, saveSkipped(s.dir, peer, nil)
+}
+
+// attachSkipped loads peer's skipped keys into c.
+func (s *RatchetFileStore) attachSkipped(peer string, c *domain.Conversation) error {
+	skipped, ok, err := loadSkipped(s.dir, peer)
+	if err != nil {
+		return err
+	}
+	if ok {
+		c.State.Skipped = skipped
+	}
+	if c.State.Skipped == nil {
+		c.State.Skipped = make(map[string][]byte)
+	}
+	return nil
 }
 
 // Compile-time assertion that RatchetFileStore implements domain.RatchetStore.
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ciphera/internal/domain"
+)
+
+const (
+	// traceDirname holds one encrypted trace per peer, named like the
+	// peer's conversation record.
+	traceDirname = "ratchet-trace"
+	traceExt     = ".json.enc"
+	// maxTraceSteps bounds each trace; the oldest steps go first.
+	maxTraceSteps = 64
+)
+
+// RatchetTraceFileStore persists recorded ratchet steps, encrypted under the
+// identity passphrase in the same format as the history file. Every append
+// re-encrypts the peer's trace, so recording costs a key derivation per
+// ratchet step; it is meant to be switched on only while debugging.
+type RatchetTraceFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewRatchetTraceFileStore returns a RatchetTraceFileStore rooted at dir.
+func NewRatchetTraceFileStore(dir string) *RatchetTraceFileStore {
+	return &RatchetTraceFileStore{dir: dir, mu: storeLock{path: lockPath(dir, traceDirname)}}
+}
+
+// AppendRatchetStep adds step to peer's trace, dropping the oldest steps
+// beyond maxTraceSteps.
+func (s *RatchetTraceFileStore) AppendRatchetStep(passphrase, peer string, step domain.RatchetStep) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	steps, err := s.load(passphrase, peer)
+	if err != nil {
+		return err
+	}
+	steps = append(steps, step)
+	if n := len(steps) - maxTraceSteps; n > 0 {
+		steps = steps[n:]
+	}
+	raw, err := json.Marshal(steps)
+	if err != nil {
+		return err
+	}
+	N, r, p := scryptParamsDefault()
+	ct, err := encrypt(passphrase, raw, N, r, p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, traceDirname), 0o700); err != nil {
+		return err
+	}
+	return writeFile(s.path(peer), ct, 0o600)
+}
+
+// LoadRatchetSteps returns peer's trace, oldest first. A missing trace is
+// empty.
+func (s *RatchetTraceFileStore) LoadRatchetSteps(passphrase, peer string) ([]domain.RatchetStep, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return s.load(passphrase, peer)
+}
+
+// DeleteRatchetSteps removes peer's trace, or every trace if peer is empty,
+// and reports how many were removed.
+func (s *RatchetTraceFileStore) DeleteRatchetSteps(peer string) (int, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if peer != "" {
+		err := os.Remove(s.path(peer))
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	dir := filepath.Join(s.dir, traceDirname)
+	ents, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range ents {
+		if !strings.HasSuffix(e.Name(), traceExt) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// path returns peer's trace file.
+func (s *RatchetTraceFileStore) path(peer string) string {
+	return filepath.Join(s.dir, traceDirname, peerFilename(peer)+traceExt)
+}
+
+// load decrypts peer's trace. A missing file is an empty trace.
+func (s *RatchetTraceFileStore) load(passphrase, peer string) ([]domain.RatchetStep, error) {
+	b, err := readFile(s.path(peer))
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, nil
+	}
+	pt, err := decrypt(passphrase, b)
+	if err != nil {
+		return nil, err
+	}
+	var steps []domain.RatchetStep
+	if err := json.Unmarshal(pt, &steps); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// Compile-time assertion that RatchetTraceFileStore implements
+// domain.RatchetTraceStore.
+var _ domain.RatchetTraceStore = (*RatchetTraceFileStore)(nil)
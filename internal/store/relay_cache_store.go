@@ -0,0 +1,58 @@
+package store
+
+import (
+	"path/filepath"
+
+	"ciphera/internal/domain"
+)
+
+const relayCacheFilename = "relays.json"
+
+// RelayCacheFileStore caches the relays discovered for address hosts, keyed
+// by host.
+type RelayCacheFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewRelayCacheFileStore returns a RelayCacheFileStore rooted at dir.
+func NewRelayCacheFileStore(dir string) *RelayCacheFileStore {
+	return &RelayCacheFileStore{dir: dir, mu: storeLock{path: lockPath(dir, relayCacheFilename)}}
+}
+
+// SaveResolvedRelay records r, replacing any entry for the same host.
+func (s *RelayCacheFileStore) SaveResolvedRelay(r domain.ResolvedRelay) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, relayCacheFilename)
+	m := map[string]domain.ResolvedRelay{}
+	if err := readJSON(path, &m); err != nil {
+		return err
+	}
+	m[r.Host] = r
+	return writeJSON(path, m, 0o600)
+}
+
+// LoadResolvedRelay returns the cached relay for host, if any.
+func (s *RelayCacheFileStore) LoadResolvedRelay(host string) (domain.ResolvedRelay, bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return domain.ResolvedRelay{}, false, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, relayCacheFilename)
+	m := map[string]domain.ResolvedRelay{}
+	if err := readJSON(path, &m); err != nil {
+		return domain.ResolvedRelay{}, false, err
+	}
+	r, ok := m[host]
+	return r, ok, nil
+}
+
+// Compile-time assertion that RelayCacheFileStore implements domain.RelayCacheStore.
+var _ domain.RelayCacheStore = (*RelayCacheFileStore)(nil)
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+// JSON store files carry a schema version in a small envelope:
+//
+//	{"ciphera_schema": N, "data": <store contents>}
+//
+// Files written before versioning are bare JSON and count as version 0.
+// Migrate upgrades old files when the application opens its home directory;
+// readJSON accepts any version up to the current one and refuses newer files
+// rather than silently dropping fields it does not understand.
+//
+// The encrypted identity and history files, the conversation records and the
+// skipped-key side files have their own format versions and are not listed
+// here.
+var schemaVersions = map[string]int{
+	accountsFilename:       1,
+	actionsFilename:        1,
+	attestationsFilename:   1,
+	broadcastsFilename:     1,
+	bundleFile:             1,
+	contactsFilename:       1,
+	convFilename:           3,
+	historyKeyFilename:     1,
+	historyPendingFilename: 1,
+	opkPairsFile:           2,
+	outboxFilename:         1,
+	preferencesFilename:    1,
+	prekeyMetaFile:         1,
+	profilesFilename:       1,
+	quarantineFilename:     1,
+	relayCacheFilename:     1,
+	sessionsFilename:       1,
+	settingsFilename:       1,
+	spkPairsFile:           2,
+}
+
+// schemaEnvelope is the on-disk wrapper of a versioned store file.
+type schemaEnvelope struct {
+	Schema int             `json:"ciphera_schema"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// currentSchema returns the schema version for the store file at path, and
+// false if the file is not versioned.
+func currentSchema(path string) (int, bool) {
+	v, ok := schemaVersions[filepath.Base(path)]
+	return v, ok
+}
+
+// unwrapSchema splits b into its schema version and contents. Bare (legacy)
+// JSON is version 0.
+func unwrapSchema(b []byte) (int, []byte) {
+	var env struct {
+		Schema *int            `json:"ciphera_schema"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(b, &env); err != nil || env.Schema == nil || env.Data == nil {
+		return 0, b
+	}
+	return *env.Schema, env.Data
+}
+
+// checkSchema reports an error if the file at path was written by a newer
+// version of the application.
+func checkSchema(path string, version int) error {
+	cur, ok := currentSchema(path)
+	if ok && version > cur {
+		return fmt.Errorf("schema version %d is newer than supported version %d", version, cur)
+	}
+	return nil
+}
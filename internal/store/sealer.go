@@ -0,0 +1,39 @@
+package store
+
+import (
+	"errors"
+
+	"ciphera/internal/domain"
+)
+
+// errBadSeal is returned by PassphraseSealer.Open when the passphrase is
+// wrong or the data was modified.
+var errBadSeal = errors.New("wrong export passphrase or corrupted export")
+
+// PassphraseSealer encrypts exported files in the same format as the
+// identity store: a key derived from the passphrase with scrypt seals the
+// data with ChaCha20-Poly1305.
+type PassphraseSealer struct{}
+
+// NewPassphraseSealer returns a PassphraseSealer.
+func NewPassphraseSealer() PassphraseSealer {
+	return PassphraseSealer{}
+}
+
+// Seal encrypts plain under passphrase.
+func (PassphraseSealer) Seal(passphrase string, plain []byte) ([]byte, error) {
+	N, r, p := scryptParamsDefault()
+	return encrypt(passphrase, plain, N, r, p)
+}
+
+// Open decrypts data sealed by Seal.
+func (PassphraseSealer) Open(passphrase string, sealed []byte) ([]byte, error) {
+	plain, err := decrypt(passphrase, sealed)
+	if errors.Is(err, errWrongPassphrase) {
+		return nil, errBadSeal
+	}
+	return plain, err
+}
+
+// Compile-time assertion that PassphraseSealer implements domain.Sealer.
+var _ domain.Sealer = PassphraseSealer{}
@@ -2,7 +2,7 @@ package store
 
 import (
 	"path/filepath"
-	"sync"
+	"sort"
 
 	"ciphera/internal/domain"
 )
@@ -12,39 +12,93 @@ const sessionsFilename = "sessions.json"
 // SessionFileStore persists established X3DH sessions to disk.
 type SessionFileStore struct {
 	dir string
-	mu  sync.Mutex
+	mu  storeLock
 }
 
 // NewSessionFileStore returns a SessionFileStore rooted at dir.
 func NewSessionFileStore(dir string) *SessionFileStore {
-	return &SessionFileStore{dir: dir}
+	return &SessionFileStore{dir: dir, mu: storeLock{path: lockPath(dir, sessionsFilename)}}
 }
 
 // SaveSession writes a session record for peer.
 func (s *SessionFileStore) SaveSession(peer string, sess domain.Session) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	path := filepath.Join(s.dir, sessionsFilename)
-	m := map[string]domain.Session{}
-	_ = readJSON(path, &m)
+	m, err := readSessions(path)
+	if err != nil {
+		return err
+	}
 	m[peer] = sess
 	return writeJSON(path, m, 0o600)
 }
 
 // LoadSession retrieves a stored session for peer.
 func (s *SessionFileStore) LoadSession(peer string) (domain.Session, bool, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return domain.Session{}, false, err
+	}
+	defer unlock()
 
 	path := filepath.Join(s.dir, sessionsFilename)
-	m := map[string]domain.Session{}
-	if err := readJSON(path, &m); err != nil {
+	m, err := readSessions(path)
+	if err != nil {
 		return domain.Session{}, false, err
 	}
 	sess, ok := m[peer]
 	return sess, ok, nil
 }
 
+// ListSessions returns all sessions ordered by peer.
+func (s *SessionFileStore) ListSessions() ([]domain.Session, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, sessionsFilename)
+	m, err := readSessions(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]domain.Session, 0, len(m))
+	for _, sess := range m {
+		out = append(out, sess)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Peer < out[j].Peer })
+	return out, nil
+}
+
+// DeleteSession removes the session for peer and reports whether it existed.
+func (s *SessionFileStore) DeleteSession(peer string) (bool, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	path := filepath.Join(s.dir, sessionsFilename)
+	m, err := readSessions(path)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := m[peer]; !ok {
+		return false, nil
+	}
+	delete(m, peer)
+	return true, writeJSON(path, m, 0o600)
+}
+
+// readSessions reads and validates the sessions in path (see checkSession).
+func readSessions(path string) (map[string]domain.Session, error) {
+	return readRecords(path, sessionKeyFields, checkSession)
+}
+
 // Compile-time assertion that SessionFileStore implements domain.SessionStore.
 var _ domain.SessionStore = (*SessionFileStore)(nil)
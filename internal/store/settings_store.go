@@ -0,0 +1,49 @@
+package store
+
+import (
+	"path/filepath"
+
+	"ciphera/internal/domain"
+)
+
+const settingsFilename = "settings.json"
+
+// SettingsFileStore persists global client settings.
+type SettingsFileStore struct {
+	dir string
+	mu  storeLock
+}
+
+// NewSettingsFileStore returns a SettingsFileStore rooted at dir.
+func NewSettingsFileStore(dir string) *SettingsFileStore {
+	return &SettingsFileStore{dir: dir, mu: storeLock{path: lockPath(dir, settingsFilename)}}
+}
+
+// LoadSettings returns the saved settings, or the zero value if none were saved.
+func (s *SettingsFileStore) LoadSettings() (domain.Settings, error) {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return domain.Settings{}, err
+	}
+	defer unlock()
+
+	var out domain.Settings
+	if err := readJSON(filepath.Join(s.dir, settingsFilename), &out); err != nil {
+		return domain.Settings{}, err
+	}
+	return out, nil
+}
+
+// SaveSettings replaces the saved settings with v.
+func (s *SettingsFileStore) SaveSettings(v domain.Settings) error {
+	unlock, err := s.mu.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return writeJSON(filepath.Join(s.dir, settingsFilename), v, 0o600)
+}
+
+// Compile-time assertion that SettingsFileStore implements domain.SettingsStore.
+var _ domain.SettingsStore = (*SettingsFileStore)(nil)
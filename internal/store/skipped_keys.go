@@ -0,0 +1,150 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Skipped message keys live outside conversations.json, one binary side file
+// per conversation, so the shared JSON stays small no matter how many keys a
+// single peer has skipped.
+//
+// Layout (all integers big-endian):
+//
+//	magic   "CSKP"
+//	version uint8 (1)
+//	count   uint32
+//	index   count × { id [36]byte | offset uint32 | length uint16 }, sorted by id
+//	data    concatenated message keys
+//
+// An id is the ratchet's (DH public key ‖ N) pair, i.e. the hex-decoded
+// skipped-key map key. Offsets are relative to the start of the data section.
+const (
+	skippedDirname     = "skipped"
+	skippedMagic       = "CSKP"
+	skippedVersion     = 1
+	skippedIDSize      = 36
+	skippedIndexSize   = skippedIDSize + 4 + 2
+	skippedHeaderSize  = len(skippedMagic) + 1 + 4
+	skippedMaxKeyBytes = 1<<16 - 1
+)
+
+var errSkippedCorrupt = errors.New("skipped key file corrupt")
+
+// skippedPath returns the side file for peer.
+func skippedPath(dir, peer string) string {
+	return filepath.Join(dir, skippedDirname, peerFilename(peer)+".bin")
+}
+
+// peerFilename returns the base name of peer's per-conversation files. The
+// peer name is hashed so it can never escape the directory or collide with
+// reserved names.
+func peerFilename(peer string) string {
+	sum := sha256.Sum256([]byte(peer))
+	return hex.EncodeToString(sum[:16])
+}
+
+// encodeSkipped serialises m into the side-file format. Output is deterministic.
+func encodeSkipped(m map[string][]byte) ([]byte, error) {
+	ids := make([]string, 0, len(m))
+	for k := range m {
+		ids = append(ids, k)
+	}
+	sort.Strings(ids) // hex order equals byte order of the decoded ids
+
+	var index, data bytes.Buffer
+	for _, k := range ids {
+		id, err := hex.DecodeString(k)
+		if err != nil || len(id) != skippedIDSize {
+			return nil, fmt.Errorf("skipped key id %q: unexpected format", k)
+		}
+		v := m[k]
+		if len(v) > skippedMaxKeyBytes {
+			return nil, fmt.Errorf("skipped key %q: too long", k)
+		}
+		index.Write(id)
+		_ = binary.Write(&index, binary.BigEndian, uint32(data.Len()))
+		_ = binary.Write(&index, binary.BigEndian, uint16(len(v)))
+		data.Write(v)
+	}
+
+	out := make([]byte, 0, skippedHeaderSize+index.Len()+data.Len())
+	out = append(out, skippedMagic...)
+	out = append(out, skippedVersion)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(ids)))
+	out = append(out, index.Bytes()...)
+	out = append(out, data.Bytes()...)
+	return out, nil
+}
+
+// decodeSkipped parses a side file back into the ratchet's skipped-key map.
+func decodeSkipped(b []byte) (map[string][]byte, error) {
+	if len(b) < skippedHeaderSize || string(b[:len(skippedMagic)]) != skippedMagic {
+		return nil, errSkippedCorrupt
+	}
+	if b[len(skippedMagic)] != skippedVersion {
+		return nil, fmt.Errorf("skipped key file version %d unsupported", b[len(skippedMagic)])
+	}
+	count := int(binary.BigEndian.Uint32(b[len(skippedMagic)+1:]))
+	rest := b[skippedHeaderSize:]
+	if count > len(rest)/skippedIndexSize {
+		return nil, errSkippedCorrupt
+	}
+	index, data := rest[:count*skippedIndexSize], rest[count*skippedIndexSize:]
+
+	m := make(map[string][]byte, count)
+	for i := range count {
+		e := index[i*skippedIndexSize : (i+1)*skippedIndexSize]
+		off := int(binary.BigEndian.Uint32(e[skippedIDSize:]))
+		n := int(binary.BigEndian.Uint16(e[skippedIDSize+4:]))
+		if off+n > len(data) {
+			return nil, errSkippedCorrupt
+		}
+		m[hex.EncodeToString(e[:skippedIDSize])] = append([]byte(nil), data[off:off+n]...)
+	}
+	return m, nil
+}
+
+// saveSkipped writes m to peer's side file, or removes the file when m is empty.
+// The file is only rewritten when its contents change.
+func saveSkipped(dir, peer string, m map[string][]byte) error {
+	path := skippedPath(dir, peer)
+	if len(m) == 0 {
+		err := os.Remove(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	b, err := encodeSkipped(m)
+	if err != nil {
+		return err
+	}
+	if cur, err := readFile(path); err == nil && bytes.Equal(cur, b) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return writeFile(path, b, 0o600)
+}
+
+// loadSkipped reads peer's side file. ok is false if there is none.
+func loadSkipped(dir, peer string) (map[string][]byte, bool, error) {
+	b, err := readFile(skippedPath(dir, peer))
+	if err != nil || b == nil {
+		return nil, false, err
+	}
+	m, err := decodeSkipped(b)
+	if err != nil {
+		return nil, false, fmt.Errorf("skipped keys for %q: %w", peer, err)
+	}
+	return m, true, nil
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"cmp"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Op is a kind of persistence operation measured by Telemetry.
+type Op string
+
+const (
+	OpRead  Op = "read"  // a file read, whole
+	OpWrite Op = "write" // a file written through a temp file and renamed
+	OpLock  Op = "lock"  // waiting for a store's lock
+)
+
+// OpStats aggregates the operations of one kind on one file.
+type OpStats struct {
+	Op       Op
+	File     string        // relative to the home directory; a lock is named after the file it guards
+	Count    int           // operations measured
+	Bytes    int64         // bytes read or written in all; zero for locks
+	MaxBytes int64         // largest single read or write
+	Time     time.Duration // time taken (for locks, waited) in all
+	MaxTime  time.Duration // longest single operation
+}
+
+// opKey identifies an OpStats.
+type opKey struct {
+	op   Op
+	file string
+}
+
+// Telemetry measures how long the stores take to read and write their files,
+// how large the files are and how long each call waits for a store's lock.
+// Each operation is logged at debug level as it happens, and Stats adds them
+// up per file, so the files behind slow commands stand out.
+type Telemetry struct {
+	dir    string
+	logger *slog.Logger
+
+	mu    sync.Mutex
+	stats map[opKey]*OpStats
+}
+
+// telemetry is the Telemetry every store reports to, if any. It is
+// process-wide because the stores share the file helpers that measure.
+var telemetry atomic.Pointer[Telemetry]
+
+// NewTelemetry returns a Telemetry for the stores of home directory dir,
+// logging each operation to logger. If logger is nil, operations are only
+// added up.
+func NewTelemetry(dir string, logger *slog.Logger) *Telemetry {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	return &Telemetry{dir: dir, logger: logger, stats: map[opKey]*OpStats{}}
+}
+
+// SetTelemetry makes every store report to t from now on. A nil t turns
+// measuring off.
+func SetTelemetry(t *Telemetry) {
+	telemetry.Store(t)
+}
+
+// Stats returns the operations measured so far, per kind and file, those
+// that took longest in all first.
+func (t *Telemetry) Stats() []OpStats {
+	t.mu.Lock()
+	out := make([]OpStats, 0, len(t.stats))
+	for _, s := range t.stats {
+		out = append(out, *s)
+	}
+	t.mu.Unlock()
+	slices.SortFunc(out, func(a, b OpStats) int {
+		return cmp.Or(
+			cmp.Compare(b.Time, a.Time),
+			cmp.Compare(a.File, b.File),
+			cmp.Compare(a.Op, b.Op),
+		)
+	})
+	return out
+}
+
+// record adds an operation on path that moved n bytes and took d.
+func (t *Telemetry) record(op Op, path string, n int, d time.Duration) {
+	file := path
+	if rel, err := filepath.Rel(t.dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		file = filepath.ToSlash(rel)
+	}
+	if op == OpLock {
+		file = strings.TrimSuffix(file, lockSuffix)
+		t.logger.Debug("store lock", "file", file, "wait", d)
+	} else {
+		t.logger.Debug("store "+string(op), "file", file, "bytes", n, "duration", d)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := opKey{op, file}
+	s := t.stats[k]
+	if s == nil {
+		s = &OpStats{Op: op, File: file}
+		t.stats[k] = s
+	}
+	s.Count++
+	s.Bytes += int64(n)
+	s.MaxBytes = max(s.MaxBytes, int64(n))
+	s.Time += d
+	s.MaxTime = max(s.MaxTime, d)
+}
+
+// observe reports an operation on path that started at start and moved n
+// bytes to the installed Telemetry, if any.
+func observe(op Op, path string, n int, start time.Time) {
+	if t := telemetry.Load(); t != nil {
+		t.record(op, path, n, time.Since(start))
+	}
+}
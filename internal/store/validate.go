@@ -0,0 +1,241 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/keyid"
+)
+
+// Sessions, conversations and prekeys are validated as they are loaded. A
+// record that is missing a required field, has a key of the wrong length or
+// inconsistent indices is reported as a RecordError, and the store refuses
+// to use (or rewrite) the file instead of carrying on with zeroed fields.
+
+// Sizes of the keys and signatures records hold.
+const (
+	keySize = 32 // X25519 keys; ratchet root, chain and header keys
+	sigSize = 64 // Ed25519 signatures
+)
+
+// ErrMalformed is wrapped by every RecordError.
+var ErrMalformed = errors.New("malformed store record")
+
+// RecordError reports a stored record that failed validation on load.
+type RecordError struct {
+	File   string // file name relative to the home directory, e.g. sessions.json
+	Record string // key of the record in the file, e.g. the peer; "" for the whole file
+	Field  string // JSON or record field; "" for the whole record
+	Reason string
+}
+
+// Error returns the location and reason, e.g.
+// "sessions.json: bob: root_key: must be 32 bytes, got 31".
+func (e *RecordError) Error() string {
+	parts := []string{e.File}
+	if e.Record != "" {
+		parts = append(parts, e.Record)
+	}
+	if e.Field != "" {
+		parts = append(parts, e.Field)
+	}
+	return strings.Join(append(parts, e.Reason), ": ")
+}
+
+// Unwrap returns ErrMalformed.
+func (e *RecordError) Unwrap() error { return ErrMalformed }
+
+// Fixed-size key fields of each JSON record kind, by field name. Encoding
+// them as arrays means a short array would otherwise decode zero-filled.
+var (
+	sessionKeyFields = map[string]int{"peer_spk": keySize, "peer_ik": keySize, "initiator_ek": keySize, "peer_sign_key": keySize}
+	spkKeyFields     = map[string]int{"priv": keySize, "pub": keySize}
+	opkKeyFields     = map[string]int{"priv": keySize, "pub": keySize}
+)
+
+// readRecords reads a JSON object of records keyed by ID from path. Unknown
+// fields are rejected, as are key fields (see sessionKeyFields) whose arrays
+// are not exactly their size; check then validates each record. A missing
+// file reads as no records.
+func readRecords[T any](
+	path string,
+	keyFields map[string]int,
+	check func(id string, rec T) *RecordError,
+) (map[string]T, error) {
+	b, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]T)
+	if b == nil {
+		return out, nil
+	}
+	name := filepath.Base(path)
+	version, data := unwrapSchema(b)
+	if err := checkSchema(path, version); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, &RecordError{File: name, Reason: err.Error()}
+	}
+	for _, id := range slices.Sorted(maps.Keys(raw)) {
+		rec := raw[id]
+		if err := checkKeyFields(rec, keyFields); err != nil {
+			err.File, err.Record = name, id
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(rec))
+		dec.DisallowUnknownFields()
+		var v T
+		if err := dec.Decode(&v); err != nil {
+			return nil, &RecordError{File: name, Record: id, Reason: err.Error()}
+		}
+		if err := check(id, v); err != nil {
+			err.File, err.Record = name, id
+			return nil, err
+		}
+		out[id] = v
+	}
+	return out, nil
+}
+
+// checkKeyFields checks that each field of rec named in sizes, if present, is
+// an array of exactly that many elements.
+func checkKeyFields(rec json.RawMessage, sizes map[string]int) *RecordError {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(rec, &fields); err != nil {
+		return &RecordError{Reason: err.Error()}
+	}
+	for name, n := range sizes {
+		v, ok := fields[name]
+		if !ok {
+			continue // required fields are checked on the decoded record
+		}
+		var elems []json.RawMessage
+		if err := json.Unmarshal(v, &elems); err != nil {
+			return &RecordError{Field: name, Reason: "must be an array of bytes"}
+		}
+		if len(elems) != n {
+			return &RecordError{Field: name, Reason: fmt.Sprintf("must be %d bytes, got %d", n, len(elems))}
+		}
+	}
+	return nil
+}
+
+// checkSession validates the session with peer.
+func checkSession(peer string, s domain.Session) *RecordError {
+	switch {
+	case s.Peer != peer:
+		return &RecordError{Field: "peer", Reason: fmt.Sprintf("names %q", s.Peer)}
+	case len(s.RootKey) != keySize:
+		return &RecordError{Field: "root_key", Reason: fmt.Sprintf("must be %d bytes, got %d", keySize, len(s.RootKey))}
+	case s.PeerIK == (domain.X25519Public{}):
+		return &RecordError{Field: "peer_ik", Reason: "missing"}
+	case s.PeerSPK == (domain.X25519Public{}):
+		return &RecordError{Field: "peer_spk", Reason: "missing"}
+	case s.SPKID == "":
+		return &RecordError{Field: "spk_id", Reason: "missing"}
+	case s.CreatedUTC <= 0:
+		return &RecordError{Field: "created_utc", Reason: "missing"}
+	}
+	return nil
+}
+
+// checkSPK validates the signed prekey with id.
+func checkSPK(id string, p spkPair) *RecordError {
+	if err := checkKeyPair(keyid.SignedPrekey, id, p.Priv, p.Pub); err != nil {
+		return err
+	}
+	if len(p.Sig) != sigSize {
+		return &RecordError{Field: "sig", Reason: fmt.Sprintf("must be %d bytes, got %d", sigSize, len(p.Sig))}
+	}
+	return nil
+}
+
+// checkOPK validates the one-time prekey with id.
+func checkOPK(id string, p opkPair) *RecordError {
+	return checkKeyPair(keyid.OneTimePrekey, id, p.Priv, p.Pub)
+}
+
+// checkKeyPair checks that a prekey's ID is well-formed for its kind (see
+// package keyid) and that pub is priv's public key.
+func checkKeyPair(kind keyid.Kind, id string, priv, pub [32]byte) *RecordError {
+	if id == "" {
+		return &RecordError{Reason: "empty prekey ID"}
+	}
+	if err := keyid.Check(kind, domain.KeyID(id)); err != nil {
+		return &RecordError{Reason: err.Error()}
+	}
+	want, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil || !bytes.Equal(want, pub[:]) {
+		return &RecordError{Field: "pub", Reason: "does not match priv"}
+	}
+	return nil
+}
+
+// checkConversation validates a decoded conversation record: key lengths,
+// the confirmation state and counters that can never be negative.
+func checkConversation(c domain.Conversation) *RecordError {
+	if err := checkRatchetState("state", c.State); err != nil {
+		return err
+	}
+	if c.Stale != nil {
+		if err := checkRatchetState("stale", *c.Stale); err != nil {
+			return err
+		}
+	}
+	switch c.Confirm {
+	case "", domain.ConfirmPending, domain.ConfirmOK, domain.ConfirmMismatch:
+	default:
+		return &RecordError{Field: "confirm", Reason: fmt.Sprintf("unknown state %q", c.Confirm)}
+	}
+	for field, n := range map[string]int{
+		"since_rekey":       c.SinceRekey,
+		"rekeys":            c.Rekeys,
+		"messages_sent":     c.MessagesSent,
+		"messages_received": c.MessagesReceived,
+		"unanswered":        c.Unanswered,
+	} {
+		if n < 0 {
+			return &RecordError{Field: field, Reason: fmt.Sprintf("negative count %d", n)}
+		}
+	}
+	if c.Stats != nil && len(c.Stats.CipherSizes) != 0 && len(c.Stats.CipherSizes) != len(domain.StatsSizeBounds)+1 {
+		return &RecordError{Field: "stats.cipher_sizes", Reason: fmt.Sprintf("has %d buckets", len(c.Stats.CipherSizes))}
+	}
+	return nil
+}
+
+// checkRatchetState checks the keys of st, reported under prefix. Chain and
+// header keys may be absent (no chain yet, or a record from before header
+// keys) but must otherwise be keySize bytes. The suite must be one this client
+// implements, or empty for states from before suites.
+func checkRatchetState(prefix string, st domain.RatchetState) *RecordError {
+	if len(st.RootKey) != keySize {
+		return &RecordError{Field: prefix + ".root_key", Reason: fmt.Sprintf("must be %d bytes, got %d", keySize, len(st.RootKey))}
+	}
+	if st.DHPub == (domain.X25519Public{}) {
+		return &RecordError{Field: prefix + ".dh_pub", Reason: "missing"}
+	}
+	for field, k := range map[string][]byte{"send_ck": st.SendCK, "recv_ck": st.RecvCK, "header_key": st.HeaderKey} {
+		if len(k) != 0 && len(k) != keySize {
+			return &RecordError{Field: prefix + "." + field, Reason: fmt.Sprintf("must be %d bytes, got %d", keySize, len(k))}
+		}
+	}
+	if _, err := crypto.LookupSuite(st.Suite); err != nil {
+		return &RecordError{Field: prefix + ".suite", Reason: err.Error()}
+	}
+	return nil
+}
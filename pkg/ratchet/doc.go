@@ -0,0 +1,28 @@
+// Package ratchet is the public face of Ciphera's Double Ratchet, for
+// programs that want the protocol without the rest of the stack: no stores,
+// relay, identities or envelopes.
+//
+// A Session is one side of a conversation. The initiator makes one with
+// NewInitiator from the root key an X3DH handshake agreed (see package
+// ciphera/pkg/x3dh) and the responder's identity key; the responder makes
+// one with NewResponder once the first message arrives, from the same root
+// and that message's header. Each message is then sealed with
+// Session.Encrypt and opened with Session.Decrypt, which steps the ratchet,
+// keeps keys for messages that arrive out of order and refuses replays.
+//
+// Sessions are wire-compatible with the ciphera client: the same root key
+// and cipher suite give the same keys, headers and ciphertexts. Persist one
+// with MarshalBinary and restore it with UnmarshalBinary; the encoding is
+// versioned and carries secret keys, so store it as carefully as they
+// deserve.
+//
+// # Stability
+//
+// The types and functions in this package are a stable API: they use only
+// fixed-size arrays, byte slices and the Options struct, never the client's
+// internal types, and new options are added as fields whose zero value keeps
+// the current behaviour. Errors are comparable with errors.Is.
+//
+// Concurrency: a Session is NOT safe for concurrent use. Callers must
+// serialise access per conversation.
+package ratchet
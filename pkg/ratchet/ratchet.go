@@ -0,0 +1,242 @@
+package ratchet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/ratchet"
+)
+
+// DefaultSuite is the cipher suite used when Options names none.
+const DefaultSuite = crypto.DefaultSuite
+
+// HeaderSize is the length of an encoded Header.
+const HeaderSize = 32 + 4 + 4
+
+// sessionVersion is the format of an encoded Session.
+const sessionVersion = 1
+
+var (
+	// ErrGapTooLarge indicates a message too far ahead of the session to
+	// catch up with; a new session is needed.
+	ErrGapTooLarge = ratchet.ErrGapTooLarge
+	// ErrOldOrReplay indicates a message older than the session that it holds
+	// no key for: a replay, or one already received.
+	ErrOldOrReplay = ratchet.ErrOldOrReplay
+	// ErrUnknownSuite indicates a cipher suite this package does not
+	// implement.
+	ErrUnknownSuite = crypto.ErrUnknownSuite
+	// ErrBadHeader indicates an encoded header of the wrong length.
+	ErrBadHeader = errors.New("ratchet header must be 40 bytes")
+	// ErrBadSession indicates an encoded session this package cannot read.
+	ErrBadSession = errors.New("malformed ratchet session")
+)
+
+// Options configure a new Session. The zero value is the default.
+type Options struct {
+	// Suite is the cipher suite ID, as listed by Suites; "" is DefaultSuite.
+	// Both sides must use the suite their X3DH handshake used.
+	Suite string
+}
+
+// Suites returns the IDs of the cipher suites a session can use, the most
+// widely supported first.
+func Suites() []string {
+	return crypto.SuiteIDs()
+}
+
+// Header is sent in the clear with every ciphertext: the sender's current
+// ratchet public key, the length of its previous sending chain, and the
+// message's number in the current one. It is authenticated as part of the
+// ciphertext's associated data.
+type Header struct {
+	DHPub [32]byte
+	PN    uint32
+	N     uint32
+}
+
+// MarshalBinary encodes h as DHPub || PN || N, big-endian, HeaderSize bytes.
+// It is the layout the ciphertext authenticates.
+func (h Header) MarshalBinary() ([]byte, error) {
+	out := make([]byte, HeaderSize)
+	copy(out, h.DHPub[:])
+	binary.BigEndian.PutUint32(out[32:], h.PN)
+	binary.BigEndian.PutUint32(out[36:], h.N)
+	return out, nil
+}
+
+// UnmarshalBinary decodes a header encoded by MarshalBinary.
+func (h *Header) UnmarshalBinary(b []byte) error {
+	if len(b) != HeaderSize {
+		return ErrBadHeader
+	}
+	copy(h.DHPub[:], b)
+	h.PN = binary.BigEndian.Uint32(b[32:])
+	h.N = binary.BigEndian.Uint32(b[36:])
+	return nil
+}
+
+// Session is one side of a Double Ratchet conversation.
+type Session struct {
+	state domain.RatchetState
+}
+
+// NewInitiator starts the session of the side that ran the X3DH handshake as
+// initiator, from the agreed root key and the responder's identity public
+// key. It can send at once.
+func NewInitiator(root []byte, peerIdentity [32]byte, opts Options) (*Session, error) {
+	st, err := ratchet.InitAsInitiator(root, domain.X25519Private{}, domain.X25519Public{}, peerIdentity, opts.Suite)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{state: st}, nil
+}
+
+// NewResponder starts the session of the X3DH responder, from the agreed root
+// key, its identity private key and the header of the initiator's first
+// message, which it then decrypts with Decrypt.
+func NewResponder(root []byte, identity [32]byte, first Header, opts Options) (*Session, error) {
+	st, err := ratchet.InitAsResponder(root, identity, domain.X25519Public{}, first.DHPub, opts.Suite)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{state: st}, nil
+}
+
+// Suite returns the ID of the cipher suite s runs with.
+func (s *Session) Suite() string {
+	if s.state.Suite == "" {
+		return DefaultSuite
+	}
+	return s.state.Suite
+}
+
+// Encrypt seals plaintext as the next message, binding associatedData and the
+// header to the ciphertext. The peer must pass the same associatedData to
+// Decrypt.
+func (s *Session) Encrypt(associatedData, plaintext []byte) (Header, []byte, error) {
+	h, ct, err := ratchet.Encrypt(&s.state, associatedData, plaintext)
+	if err != nil {
+		return Header{}, nil, err
+	}
+	return fromDomain(h), ct, nil
+}
+
+// Decrypt opens a message sealed by the peer's Encrypt. A message that fails
+// to authenticate leaves the chains where they were, so a forged or
+// corrupted message does not stop later ones decrypting.
+func (s *Session) Decrypt(associatedData []byte, h Header, ciphertext []byte) ([]byte, error) {
+	// Decrypt mutates the state before authenticating the message; work on a
+	// copy so a failure leaves s untouched.
+	next := cloneState(s.state)
+	pt, err := ratchet.Decrypt(&next, associatedData, toDomain(h), ciphertext)
+	if err != nil {
+		wipeState(&next)
+		return nil, err
+	}
+	wipeState(&s.state)
+	s.state = next
+	return pt, nil
+}
+
+// Skipped returns the headers of the messages s holds keys for because a
+// later message arrived first, ordered by ratchet key, then by N.
+func (s *Session) Skipped() []Header {
+	missing := ratchet.Missing(&s.state)
+	out := make([]Header, 0, len(missing))
+	for _, m := range missing {
+		var h Header
+		copy(h.DHPub[:], m.DHPub)
+		h.N = m.N
+		out = append(out, h)
+	}
+	return out
+}
+
+// Wipe zeroes the keys s holds. s must not be used afterwards.
+func (s *Session) Wipe() {
+	wipeState(&s.state)
+}
+
+// sessionRecord is an encoded Session.
+type sessionRecord struct {
+	V     int                 `json:"v"`
+	State domain.RatchetState `json:"state"`
+}
+
+// MarshalBinary encodes s, secret keys included, for UnmarshalBinary.
+func (s *Session) MarshalBinary() ([]byte, error) {
+	return json.Marshal(sessionRecord{V: sessionVersion, State: s.state})
+}
+
+// UnmarshalBinary restores a session encoded by MarshalBinary.
+func (s *Session) UnmarshalBinary(b []byte) error {
+	var rec sessionRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadSession, err)
+	}
+	if rec.V != sessionVersion {
+		return fmt.Errorf("%w: version %d", ErrBadSession, rec.V)
+	}
+	if _, err := crypto.LookupSuite(rec.State.Suite); err != nil {
+		return err
+	}
+	if len(rec.State.RootKey) == 0 || (rec.State.SendCK == nil && rec.State.RecvCK == nil) {
+		return fmt.Errorf("%w: missing keys", ErrBadSession)
+	}
+	if rec.State.Skipped == nil {
+		rec.State.Skipped = make(map[string][]byte)
+	}
+	s.state = rec.State
+	return nil
+}
+
+// fromDomain converts a header from the internal ratchet.
+func fromDomain(h domain.RatchetHeader) Header {
+	out := Header{PN: h.PN, N: h.N}
+	copy(out.DHPub[:], h.DHPub)
+	return out
+}
+
+// toDomain converts a header for the internal ratchet.
+func toDomain(h Header) domain.RatchetHeader {
+	return domain.RatchetHeader{DHPub: append([]byte(nil), h.DHPub[:]...), PN: h.PN, N: h.N}
+}
+
+// cloneState returns a deep copy of st.
+func cloneState(st domain.RatchetState) domain.RatchetState {
+	out := st
+	out.RootKey = cloneBytes(st.RootKey)
+	out.SendCK = cloneBytes(st.SendCK)
+	out.RecvCK = cloneBytes(st.RecvCK)
+	out.HeaderKey = cloneBytes(st.HeaderKey)
+	out.Skipped = make(map[string][]byte, len(st.Skipped))
+	for k, v := range st.Skipped {
+		out.Skipped[k] = cloneBytes(v)
+	}
+	return out
+}
+
+// cloneBytes copies b, keeping nil as nil.
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+// wipeState zeroes the secrets in st.
+func wipeState(st *domain.RatchetState) {
+	crypto.Wipe(st.RootKey)
+	crypto.Wipe(st.SendCK)
+	crypto.Wipe(st.RecvCK)
+	crypto.Wipe(st.HeaderKey)
+	crypto.Wipe(st.DHPriv[:])
+	for _, v := range st.Skipped {
+		crypto.Wipe(v)
+	}
+}
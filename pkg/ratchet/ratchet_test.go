@@ -0,0 +1,195 @@
+package ratchet_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	internal "ciphera/internal/protocol/ratchet"
+	"ciphera/pkg/ratchet"
+)
+
+var ad = []byte("alice->bob")
+
+// newPair returns the initiator's and responder's sessions from a shared
+// root, with the first message already delivered so both can send.
+func newPair(t *testing.T, opts ratchet.Options) (a, b *ratchet.Session) {
+	t.Helper()
+	root := bytes.Repeat([]byte{0x42}, 32)
+	bPriv, bPub, err := crypto.GenerateX25519()
+	if err != nil {
+		t.Fatalf("GenerateX25519: %v", err)
+	}
+
+	a, err = ratchet.NewInitiator(root, bPub, opts)
+	if err != nil {
+		t.Fatalf("NewInitiator: %v", err)
+	}
+	h, ct := send(t, a, "hello")
+	b, err = ratchet.NewResponder(root, bPriv, h, opts)
+	if err != nil {
+		t.Fatalf("NewResponder: %v", err)
+	}
+	if got := recv(t, b, h, ct); got != "hello" {
+		t.Fatalf("first message = %q", got)
+	}
+	return a, b
+}
+
+// send encrypts msg from s.
+func send(t *testing.T, s *ratchet.Session, msg string) (ratchet.Header, []byte) {
+	t.Helper()
+	h, ct, err := s.Encrypt(ad, []byte(msg))
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	return h, ct
+}
+
+// recv decrypts a message to s.
+func recv(t *testing.T, s *ratchet.Session, h ratchet.Header, ct []byte) string {
+	t.Helper()
+	pt, err := s.Decrypt(ad, h, ct)
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	return string(pt)
+}
+
+func TestSession_Conversation(t *testing.T) {
+	for _, suite := range ratchet.Suites() {
+		t.Run(suite, func(t *testing.T) {
+			a, b := newPair(t, ratchet.Options{Suite: suite})
+			if a.Suite() != suite || b.Suite() != suite {
+				t.Fatalf("suites = %q, %q; want %q", a.Suite(), b.Suite(), suite)
+			}
+			for i, msg := range []string{"one", "two", "three"} {
+				from, to := a, b
+				if i%2 == 1 {
+					from, to = b, a
+				}
+				h, ct := send(t, from, msg)
+				if got := recv(t, to, h, ct); got != msg {
+					t.Fatalf("got %q, want %q", got, msg)
+				}
+			}
+		})
+	}
+}
+
+func TestSession_OutOfOrderAndReplay(t *testing.T) {
+	a, b := newPair(t, ratchet.Options{})
+	h1, ct1 := send(t, a, "first")
+	h2, ct2 := send(t, a, "second")
+
+	if got := recv(t, b, h2, ct2); got != "second" {
+		t.Fatalf("got %q", got)
+	}
+	if skipped := b.Skipped(); len(skipped) != 1 || skipped[0].N != h1.N || skipped[0].DHPub != h1.DHPub {
+		t.Fatalf("Skipped = %+v, want the first message", skipped)
+	}
+	if got := recv(t, b, h1, ct1); got != "first" {
+		t.Fatalf("got %q", got)
+	}
+	if _, err := b.Decrypt(ad, h1, ct1); !errors.Is(err, ratchet.ErrOldOrReplay) {
+		t.Fatalf("replay: err = %v, want ErrOldOrReplay", err)
+	}
+}
+
+// A message that fails to authenticate leaves the session able to open the
+// genuine one, even for a key it held back.
+func TestSession_ForgeryLeavesState(t *testing.T) {
+	a, b := newPair(t, ratchet.Options{})
+	h1, ct1 := send(t, a, "first")
+	h2, ct2 := send(t, a, "second")
+	recv(t, b, h2, ct2)
+
+	forged := append([]byte(nil), ct1...)
+	forged[0] ^= 1
+	if _, err := b.Decrypt(ad, h1, forged); err == nil {
+		t.Fatal("forged message decrypted")
+	}
+	if got := recv(t, b, h1, ct1); got != "first" {
+		t.Fatalf("got %q", got)
+	}
+
+	h3, ct3 := send(t, b, "reply")
+	h3.N += 5
+	if _, err := a.Decrypt(ad, h3, ct3); err == nil {
+		t.Fatal("message with altered header decrypted")
+	}
+	h3.N -= 5
+	if got := recv(t, a, h3, ct3); got != "reply" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestSession_MarshalBinary(t *testing.T) {
+	a, b := newPair(t, ratchet.Options{Suite: crypto.SuiteSHA512})
+	raw, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	var restored ratchet.Session
+	if err := restored.UnmarshalBinary(raw); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if restored.Suite() != crypto.SuiteSHA512 {
+		t.Fatalf("Suite = %q after restore", restored.Suite())
+	}
+	h, ct := send(t, a, "after restore")
+	if got := recv(t, &restored, h, ct); got != "after restore" {
+		t.Fatalf("got %q", got)
+	}
+
+	for name, bad := range map[string][]byte{
+		"not json":     []byte("nope"),
+		"version":      []byte(`{"v":9,"state":{}}`),
+		"missing keys": []byte(`{"v":1,"state":{}}`),
+	} {
+		if err := new(ratchet.Session).UnmarshalBinary(bad); !errors.Is(err, ratchet.ErrBadSession) {
+			t.Errorf("%s: err = %v, want ErrBadSession", name, err)
+		}
+	}
+}
+
+func TestHeader_Binary(t *testing.T) {
+	h := ratchet.Header{PN: 7, N: 1 << 20}
+	h.DHPub[0], h.DHPub[31] = 1, 2
+	raw, err := h.MarshalBinary()
+	if err != nil || len(raw) != ratchet.HeaderSize {
+		t.Fatalf("MarshalBinary = %d bytes, %v", len(raw), err)
+	}
+	var got ratchet.Header
+	if err := got.UnmarshalBinary(raw); err != nil || got != h {
+		t.Fatalf("UnmarshalBinary = %+v, %v; want %+v", got, err, h)
+	}
+	if err := got.UnmarshalBinary(raw[1:]); !errors.Is(err, ratchet.ErrBadHeader) {
+		t.Fatalf("short header: err = %v, want ErrBadHeader", err)
+	}
+}
+
+// The client's ratchet opens what a Session seals, so the two interoperate.
+func TestSession_MatchesClient(t *testing.T) {
+	root := bytes.Repeat([]byte{0x24}, 32)
+	bPriv, bPub, err := crypto.GenerateX25519()
+	if err != nil {
+		t.Fatalf("GenerateX25519: %v", err)
+	}
+	a, err := ratchet.NewInitiator(root, bPub, ratchet.Options{})
+	if err != nil {
+		t.Fatalf("NewInitiator: %v", err)
+	}
+	h, ct := send(t, a, "to the client")
+
+	st, err := internal.InitAsResponder(root, bPriv, bPub, h.DHPub, "")
+	if err != nil {
+		t.Fatalf("client InitAsResponder: %v", err)
+	}
+	pt, err := internal.Decrypt(&st, ad, domain.RatchetHeader{DHPub: h.DHPub[:], PN: h.PN, N: h.N}, ct)
+	if err != nil || string(pt) != "to the client" {
+		t.Fatalf("client Decrypt = %q, %v", pt, err)
+	}
+}
@@ -0,0 +1,31 @@
+// Package x3dh is the public face of Ciphera's X3DH key agreement, for
+// programs that want the handshake without the rest of the stack: no stores,
+// relay or identity files.
+//
+// The responder publishes a Bundle: its identity key, a signed prekey signed
+// with SignPrekey, and optionally a one-time prekey. The initiator runs
+// Initiate on the bundle, which checks the signature and returns the root key
+// with the Message the responder needs; the responder runs Respond on that
+// message with the matching private keys and gets the same root. The root
+// starts a Double Ratchet session (see package ciphera/pkg/ratchet).
+//
+// Handshakes are wire-compatible with the ciphera client: a bundle the client
+// publishes, converted field by field, can be initiated with here and the
+// client derives the same root, and the other way round. Identifiers of
+// prekeys are carried but not interpreted.
+//
+// # Stability
+//
+// The types and functions in this package are a stable API: they use only
+// fixed-size arrays, crypto/ed25519 keys, strings and the Options struct,
+// never the client's internal types, and new options are added as fields
+// whose zero value keeps the current behaviour. Errors are comparable with
+// errors.Is.
+//
+// # Security notes
+//
+// Prekey signatures are made under a context label, so they cannot be reused
+// as any other Ciphera signature; bare signatures from older clients are not
+// accepted here. A one-time prekey must be used for one handshake only: the
+// responder should delete its private half once the first message decrypts.
+package x3dh
@@ -0,0 +1,133 @@
+package x3dh
+
+import (
+	"crypto/ed25519"
+	"errors"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	"ciphera/internal/protocol/x3dh"
+)
+
+// DefaultSuite is the cipher suite used when Options names none.
+const DefaultSuite = crypto.DefaultSuite
+
+var (
+	// ErrBadSPK indicates a signed prekey whose signature does not verify
+	// under the bundle's signing key.
+	ErrBadSPK = x3dh.ErrBadSPK
+	// ErrUnknownSuite indicates a cipher suite this package does not
+	// implement.
+	ErrUnknownSuite = crypto.ErrUnknownSuite
+	// ErrBadSignKey indicates an Ed25519 key of the wrong length.
+	ErrBadSignKey = errors.New("ed25519 key has the wrong length")
+)
+
+// Options configure a handshake. The zero value is the default.
+type Options struct {
+	// Suite is the cipher suite ID the root is derived with; "" is
+	// DefaultSuite. It is sent in the Message, so the responder follows.
+	Suite string
+}
+
+// Bundle is the responder's published keys, as far as the handshake needs
+// them.
+type Bundle struct {
+	IdentityKey     [32]byte          // X25519 identity public key
+	SignKey         ed25519.PublicKey // key SignedPrekeySig verifies under
+	SignedPrekeyID  string
+	SignedPrekey    [32]byte
+	SignedPrekeySig []byte    // from SignPrekey
+	OneTimePrekeyID string    // "" if there is no one-time prekey
+	OneTimePrekey   *[32]byte // nil if there is none
+}
+
+// Message is what the responder needs from the initiator to derive the same
+// root. It goes with the first ratchet message.
+type Message struct {
+	InitiatorIdentity [32]byte // the initiator's X25519 identity public key
+	Ephemeral         [32]byte
+	SignedPrekeyID    string
+	OneTimePrekeyID   string // "" if none was used
+	Suite             string // the suite the root was derived with
+}
+
+// KeyPair is an X25519 key pair.
+type KeyPair struct {
+	Private [32]byte
+	Public  [32]byte
+}
+
+// GenerateKeyPair returns a fresh X25519 key pair, for an identity or a
+// prekey.
+func GenerateKeyPair() (KeyPair, error) {
+	priv, pub, err := crypto.GenerateX25519()
+	if err != nil {
+		return KeyPair{}, err
+	}
+	return KeyPair{Private: priv, Public: pub}, nil
+}
+
+// SignPrekey signs the signed prekey spk with priv, for Bundle.SignedPrekeySig.
+func SignPrekey(priv ed25519.PrivateKey, spk [32]byte) ([]byte, error) {
+	if len(priv) != ed25519.PrivateKeySize {
+		return nil, ErrBadSignKey
+	}
+	return x3dh.SignSPK(domain.Ed25519Private(priv), spk), nil
+}
+
+// Initiate runs the handshake as the initiator with identity, our X25519
+// identity key pair, against the responder's bundle b. It returns the root
+// key and the Message to send the responder.
+func Initiate(identity KeyPair, b Bundle, opts Options) ([]byte, Message, error) {
+	if len(b.SignKey) != ed25519.PublicKeySize {
+		return nil, Message{}, ErrBadSignKey
+	}
+	suite, err := crypto.LookupSuite(opts.Suite)
+	if err != nil {
+		return nil, Message{}, err
+	}
+	bundle := domain.PrekeyBundle{
+		IdentityKey:      b.IdentityKey,
+		SignKey:          domain.Ed25519Public(b.SignKey),
+		SPKID:            domain.KeyID(b.SignedPrekeyID),
+		SignedPrekey:     b.SignedPrekey,
+		SignedPrekeySig:  b.SignedPrekeySig,
+		SignedPrekeySigV: domain.SigContext,
+	}
+	if b.OneTimePrekey != nil {
+		bundle.OneTime = []domain.OneTimePub{{ID: domain.KeyID(b.OneTimePrekeyID), Pub: *b.OneTimePrekey}}
+	}
+	our := domain.Identity{XPub: identity.Public, XPriv: identity.Private}
+	root, spkID, opkID, eph, err := x3dh.InitiatorRoot(our, bundle, suite.ID)
+	if err != nil {
+		return nil, Message{}, err
+	}
+	return root, Message{
+		InitiatorIdentity: identity.Public,
+		Ephemeral:         eph,
+		SignedPrekeyID:    string(spkID),
+		OneTimePrekeyID:   string(opkID),
+		Suite:             suite.ID,
+	}, nil
+}
+
+// Respond runs the handshake as the responder with identity, our X25519
+// identity key pair, the private half of the signed prekey m names, and that
+// of its one-time prekey (nil if m names none). It returns the root key.
+func Respond(identity KeyPair, signedPrekey [32]byte, oneTimePrekey *[32]byte, m Message) ([]byte, error) {
+	my := domain.Identity{XPub: identity.Public, XPriv: identity.Private}
+	pm := domain.PrekeyMessage{
+		InitiatorIK: m.InitiatorIdentity,
+		Ephemeral:   m.Ephemeral,
+		SPKID:       domain.KeyID(m.SignedPrekeyID),
+		OPKID:       domain.KeyID(m.OneTimePrekeyID),
+		Suite:       m.Suite,
+	}
+	var opk *domain.X25519Private
+	if oneTimePrekey != nil {
+		p := domain.X25519Private(*oneTimePrekey)
+		opk = &p
+	}
+	return x3dh.ResponderRoot(my, signedPrekey, opk, pm)
+}
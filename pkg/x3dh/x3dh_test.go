@@ -0,0 +1,159 @@
+package x3dh_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"ciphera/internal/crypto"
+	"ciphera/internal/domain"
+	internal "ciphera/internal/protocol/x3dh"
+	"ciphera/pkg/x3dh"
+)
+
+// responder is the key material behind a published bundle.
+type responder struct {
+	identity x3dh.KeyPair
+	signPriv ed25519.PrivateKey
+	spk      x3dh.KeyPair
+	opk      x3dh.KeyPair
+	bundle   x3dh.Bundle
+}
+
+// newResponder returns fresh keys and their bundle, with a one-time prekey
+// if withOPK.
+func newResponder(t *testing.T, withOPK bool) responder {
+	t.Helper()
+	var r responder
+	var err error
+	for _, kp := range []*x3dh.KeyPair{&r.identity, &r.spk, &r.opk} {
+		if *kp, err = x3dh.GenerateKeyPair(); err != nil {
+			t.Fatalf("GenerateKeyPair: %v", err)
+		}
+	}
+	signPub, signPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey: %v", err)
+	}
+	r.signPriv = signPriv
+	sig, err := x3dh.SignPrekey(signPriv, r.spk.Public)
+	if err != nil {
+		t.Fatalf("SignPrekey: %v", err)
+	}
+	r.bundle = x3dh.Bundle{
+		IdentityKey:     r.identity.Public,
+		SignKey:         signPub,
+		SignedPrekeyID:  "spk-1",
+		SignedPrekey:    r.spk.Public,
+		SignedPrekeySig: sig,
+	}
+	if withOPK {
+		r.bundle.OneTimePrekeyID = "opk-1"
+		r.bundle.OneTimePrekey = &r.opk.Public
+	}
+	return r
+}
+
+func TestHandshake(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		withOP bool
+		suite  string
+	}{
+		{"no one-time prekey", false, ""},
+		{"one-time prekey", true, ""},
+		{"sha512 suite", true, crypto.SuiteSHA512},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			alice, err := x3dh.GenerateKeyPair()
+			if err != nil {
+				t.Fatalf("GenerateKeyPair: %v", err)
+			}
+			bob := newResponder(t, tc.withOP)
+
+			rootA, m, err := x3dh.Initiate(alice, bob.bundle, x3dh.Options{Suite: tc.suite})
+			if err != nil {
+				t.Fatalf("Initiate: %v", err)
+			}
+			if m.SignedPrekeyID != "spk-1" {
+				t.Fatalf("SignedPrekeyID = %q, want spk-1", m.SignedPrekeyID)
+			}
+			var opk *[32]byte
+			if tc.withOP {
+				if m.OneTimePrekeyID != "opk-1" {
+					t.Fatalf("OneTimePrekeyID = %q, want opk-1", m.OneTimePrekeyID)
+				}
+				opk = &bob.opk.Private
+			}
+			if tc.suite == "" && m.Suite != x3dh.DefaultSuite {
+				t.Fatalf("Suite = %q, want the default", m.Suite)
+			}
+
+			rootB, err := x3dh.Respond(bob.identity, bob.spk.Private, opk, m)
+			if err != nil {
+				t.Fatalf("Respond: %v", err)
+			}
+			if len(rootA) != 32 || !bytes.Equal(rootA, rootB) {
+				t.Fatalf("roots differ:\n%x\n%x", rootA, rootB)
+			}
+		})
+	}
+}
+
+func TestInitiate_Refused(t *testing.T) {
+	alice, err := x3dh.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+
+	bob := newResponder(t, false)
+	bob.bundle.SignedPrekeySig[0] ^= 1
+	if _, _, err := x3dh.Initiate(alice, bob.bundle, x3dh.Options{}); !errors.Is(err, x3dh.ErrBadSPK) {
+		t.Fatalf("tampered signature: err = %v, want ErrBadSPK", err)
+	}
+
+	bob = newResponder(t, false)
+	if _, _, err := x3dh.Initiate(alice, bob.bundle, x3dh.Options{Suite: "rot13"}); !errors.Is(err, x3dh.ErrUnknownSuite) {
+		t.Fatalf("unknown suite: err = %v, want ErrUnknownSuite", err)
+	}
+
+	bob.bundle.SignKey = bob.bundle.SignKey[:16]
+	if _, _, err := x3dh.Initiate(alice, bob.bundle, x3dh.Options{}); !errors.Is(err, x3dh.ErrBadSignKey) {
+		t.Fatalf("short signing key: err = %v, want ErrBadSignKey", err)
+	}
+}
+
+// The client's responder derives the same root from a handshake this package
+// initiated, so the two interoperate.
+func TestInitiate_MatchesClient(t *testing.T) {
+	alice, err := x3dh.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	bob := newResponder(t, true)
+
+	root, m, err := x3dh.Initiate(alice, bob.bundle, x3dh.Options{})
+	if err != nil {
+		t.Fatalf("Initiate: %v", err)
+	}
+	opk := domain.X25519Private(bob.opk.Private)
+	want, err := internal.ResponderRoot(
+		domain.Identity{XPub: bob.identity.Public, XPriv: bob.identity.Private},
+		bob.spk.Private,
+		&opk,
+		domain.PrekeyMessage{
+			InitiatorIK: m.InitiatorIdentity,
+			Ephemeral:   m.Ephemeral,
+			SPKID:       domain.KeyID(m.SignedPrekeyID),
+			OPKID:       domain.KeyID(m.OneTimePrekeyID),
+			Suite:       m.Suite,
+		},
+	)
+	if err != nil {
+		t.Fatalf("client ResponderRoot: %v", err)
+	}
+	if !bytes.Equal(root, want) {
+		t.Fatalf("root differs from the client's:\n%x\n%x", root, want)
+	}
+}
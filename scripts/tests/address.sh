@@ -0,0 +1,93 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+ALICE_RELAY="http://127.0.0.1:8080"
+BOB_RELAY="http://127.0.0.1:8081"
+ALICE_HOME="/tmp/alice-ciphera-address-alice"
+BOB_HOME="/tmp/bob-ciphera-address-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-address.log"
+
+cleanup() {
+  for pid in "${ALICE_RELAY_PID:-}" "${BOB_RELAY_PID:-}"; do
+    if [[ -n "${pid}" ]]; then
+      kill "${pid}" >/dev/null 2>&1 || true
+      wait "${pid}" >/dev/null 2>&1 || true
+    fi
+  done
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start one relay each; each serves its own well-known document.
+"${RELAY_BIN}" --port 8080 >"${RELAY_LOG}" 2>&1 & ALICE_RELAY_PID=$!
+"${RELAY_BIN}" --port 8081 >>"${RELAY_LOG}" 2>&1 & BOB_RELAY_PID=$!
+for url in "${ALICE_RELAY}" "${BOB_RELAY}"; do
+  for _ in {1..50}; do
+    curl -s "${url}/healthz" >/dev/null 2>&1 && break
+    sleep 0.1
+  done
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Alice and Bob only ever name their own relay.
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${ALICE_RELAY}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${BOB_RELAY}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+
+# Bob is not on Alice's relay, so a bare username fails.
+if alice start-session "${BOB_USER}" >/dev/null 2>&1; then
+  echo "[-] Alice found Bob without his address"
+  exit 1
+fi
+
+# His address leads Alice to his relay.
+OUT="$(alice start-session "${BOB_USER}@127.0.0.1:8081")"
+if ! grep -q "Session created with ${BOB_USER} on ${BOB_RELAY}" <<<"${OUT}"; then
+  echo "[-] start-session did not discover Bob's relay: ${OUT}"
+  exit 1
+fi
+if ! grep -q "${BOB_RELAY}" "${ALICE_HOME}/relays.json"; then
+  echo "[-] discovered relay was not cached"
+  exit 1
+fi
+
+# Messages to bob now go to his relay.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello across relays" >/dev/null
+OUT="$(bob recv --username "${BOB_USER}")"
+if ! grep -q "hello across relays" <<<"${OUT}"; then
+  echo "[-] Bob did not receive the message: ${OUT}"
+  exit 1
+fi
+
+echo "[+] An address found the peer's relay without configuring it."
@@ -0,0 +1,135 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-archive-alice"
+BOB_HOME="/tmp/bob-ciphera-archive-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-archive.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/parchive/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both, and start sessions.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+bob start-session "${ALICE_USER}" >/dev/null
+
+# A short conversation, recorded in both histories.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "before archiving" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+bob send --username "${BOB_USER}" "${ALICE_USER}" "reply before archiving" >/dev/null
+alice recv --username "${ALICE_USER}" >/dev/null
+
+# Archiving moves the state out of the hot files.
+OUT="$(alice conversations archive "${BOB_USER}" 2>/dev/null)"
+if ! grep -q "Archived conversation with ${BOB_USER} (2 history entries)" <<<"${OUT}"; then
+  echo "[-] Archive did not report the conversation"
+  echo "${OUT}"
+  exit 1
+fi
+if [[ "$(ls "${ALICE_HOME}/archive" | wc -l)" -ne 1 ]] || ls "${ALICE_HOME}/conversations" 2>/dev/null | grep -q .; then
+  echo "[-] The conversation was not moved into archive/"
+  ls -R "${ALICE_HOME}"
+  exit 1
+fi
+if alice history | grep -q "before archiving"; then
+  echo "[-] The archived history is still in the history"
+  exit 1
+fi
+if ! alice conversations archived | grep -q "^${BOB_USER}  archived .*, 2 history entries$"; then
+  echo "[-] The archive is not listed"
+  alice conversations archived
+  exit 1
+fi
+if alice conversations archive "${BOB_USER}" >/dev/null 2>&1; then
+  echo "[-] Archiving twice was allowed"
+  exit 1
+fi
+
+# Sending to an archived peer is refused, and what the peer sends waits in
+# quarantine rather than blocking the queue or starting a new conversation.
+if OUT="$(alice send --username "${ALICE_USER}" "${BOB_USER}" "while archived" 2>&1)" \
+  || ! grep -q "archived" <<<"${OUT}"; then
+  echo "[-] Sending to an archived peer was not refused"
+  echo "${OUT}"
+  exit 1
+fi
+bob send --username "${BOB_USER}" "${ALICE_USER}" "while archived" >/dev/null
+OUT="$(alice recv --username "${ALICE_USER}" 2>&1 || true)"
+if ! grep -q "conversations unarchive" <<<"${OUT}" || [[ "$(alice quarantine list | grep -c "archived")" -ne 1 ]]; then
+  echo "[-] A message from an archived peer was not quarantined"
+  echo "${OUT}"
+  alice quarantine list
+  exit 1
+fi
+
+# Unarchiving restores everything, and the held message then opens.
+alice conversations unarchive "${BOB_USER}" >/dev/null 2>&1
+if [[ -n "$(ls "${ALICE_HOME}/archive")" ]] || [[ "$(alice history | grep -c "archiving")" -ne 2 ]]; then
+  echo "[-] Unarchive did not restore the history"
+  alice history
+  exit 1
+fi
+if ! alice quarantine retry --username "${ALICE_USER}" | grep -q "while archived"; then
+  echo "[-] The quarantined message did not open after unarchiving"
+  exit 1
+fi
+alice send --username "${ALICE_USER}" "${BOB_USER}" "after unarchiving" >/dev/null
+if ! bob recv --username "${BOB_USER}" | grep -q "after unarchiving"; then
+  echo "[-] Bob cannot read a message sent after unarchiving"
+  exit 1
+fi
+if alice conversations unarchive "${BOB_USER}" >/dev/null 2>&1; then
+  echo "[-] Unarchiving a live conversation was allowed"
+  exit 1
+fi
+
+echo "[+] conversations archive moved the conversation aside, held the peer's messages and unarchive restored it."
@@ -0,0 +1,148 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-attest-alice"
+BOB_HOME="/tmp/bob-ciphera-attest-bob"
+CAROL_HOME="/tmp/carol-ciphera-attest-carol"
+DAVE_HOME="/tmp/dave-ciphera-attest-dave"
+ALICE_USER="alice"
+BOB_USER="bob"
+CAROL_USER="carol"
+DAVE_USER="dave"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+CAROL_PASS="Carol-pass1234"
+DAVE_PASS="Dave-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-attestation.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}" "${DAVE_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}" "${DAVE_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}" "${DAVE_HOME}"
+
+# Run ciphera as Alice, Bob, Carol or Dave
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+carol() {
+  "${CIPHERA_BIN}" --home "${CAROL_HOME}" --relay "${RELAY_URL}" --passphrase "${CAROL_PASS}" "$@"
+}
+dave() {
+  "${CIPHERA_BIN}" --home "${DAVE_HOME}" --relay "${RELAY_URL}" --passphrase "${DAVE_PASS}" "$@"
+}
+
+# pair A B pairs user function A with user function B over a short code.
+pair() {
+  local out code
+  out="$(mktemp)"
+  "$1" pair --username "$1" >"${out}" 2>&1 &
+  local pid=$!
+  for _ in {1..50}; do
+    code="$(sed -n 's/^Pairing code: //p' "${out}")"
+    [[ -n "${code}" ]] && break
+    sleep 0.1
+  done
+  "$2" pair join "${code}" --username "$2" >/dev/null
+  wait "${pid}"
+  rm -f "${out}"
+}
+
+# Initialise and register everyone
+for who in alice bob carol dave; do
+  ${who} init >/dev/null
+  ${who} register "${who}" >/dev/null
+done
+
+# Alice has verified Bob and Carol in person; Dave knows nobody.
+pair alice bob
+pair alice carol
+
+# Alice vouches for Bob over their conversation.
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+alice attest --username "${ALICE_USER}" "${BOB_USER}" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+if ! grep -q "${ALICE_USER} vouches for ${BOB_USER}" <<<"$(bob attest list)"; then
+  echo "[-] Bob did not store Alice's attestation"
+  exit 1
+fi
+
+# Attesting someone you have not paired with is refused.
+if alice attest --username "${ALICE_USER}" "${DAVE_USER}" >/dev/null 2>&1; then
+  echo "[-] Attesting an unpaired peer succeeded"
+  exit 1
+fi
+
+# Once Bob republishes his bundle, Carol sees Alice's attestation; Dave,
+# who has not paired with Alice, does not.
+bob register "${BOB_USER}" >/dev/null
+CAROL_OUT="$(carol start-session "${BOB_USER}")"
+if ! grep -q "verified by 1 contact you trust (${ALICE_USER})" <<<"${CAROL_OUT}"; then
+  echo "[-] Carol did not see Alice's attestation: ${CAROL_OUT}"
+  exit 1
+fi
+carol send --username "${CAROL_USER}" "${BOB_USER}" "hi bob" >/dev/null
+if ! grep -q "verified by 1 contact you trust (${ALICE_USER})" <<<"$(carol sessions)"; then
+  echo "[-] carol sessions does not show Alice's attestation"
+  exit 1
+fi
+DAVE_OUT="$(dave start-session "${BOB_USER}")"
+if grep -q "verified by" <<<"${DAVE_OUT}"; then
+  echo "[-] Dave counted an attestation from a stranger"
+  exit 1
+fi
+
+# A tampered attestation in the bundle is not counted. Only Bob can replace
+# his bundle, so serve the forgery from a fresh relay, as a hostile one would.
+BUNDLE="$(curl -s "${RELAY_URL}/prekey/${BOB_USER}")"
+FORGED="$(python3 -c 'import json,sys; b=json.load(sys.stdin); b["attestations"][0]["created_utc"]+=1; print(json.dumps(b))' <<<"${BUNDLE}")"
+kill "${RELAY_PID}" && wait "${RELAY_PID}" || true
+"${RELAY_BIN}" >>"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+curl -sf -X POST -H 'Content-Type: application/json' -d "${FORGED}" "${RELAY_URL}/register" >/dev/null
+CAROL_OUT="$(carol start-session "${BOB_USER}")"
+if grep -q "verified by" <<<"${CAROL_OUT}"; then
+  echo "[-] Carol counted a tampered attestation"
+  exit 1
+fi
+
+echo "[+] Attestations from contacts are published and counted; others are not."
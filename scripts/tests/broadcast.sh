@@ -0,0 +1,118 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-broadcast-alice"
+BOB_HOME="/tmp/bob-ciphera-broadcast-bob"
+CAROL_HOME="/tmp/carol-ciphera-broadcast-carol"
+ALICE_USER="alice"
+BOB_USER="bob"
+CAROL_USER="carol"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+CAROL_PASS="Carol-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-broadcast.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+
+# Run ciphera as Alice, Bob or Carol
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+carol() {
+  "${CIPHERA_BIN}" --home "${CAROL_HOME}" --relay "${RELAY_URL}" --passphrase "${CAROL_PASS}" "$@"
+}
+
+# Initialise and register everyone; Alice has sessions with Bob and Carol.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+carol init >/dev/null
+carol register "${CAROL_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice start-session "${CAROL_USER}" >/dev/null
+
+# Every member gets the message over its own session.
+alice broadcast create friends "${BOB_USER}" "${CAROL_USER}" >/dev/null
+OUT="$(alice send --username "${ALICE_USER}" @friends "hello friends")"
+if ! grep -qx "${BOB_USER}: sent" <<<"${OUT}" || ! grep -qx "${CAROL_USER}: sent" <<<"${OUT}"; then
+  echo "[-] Broadcast did not report delivery to every member"
+  echo "${OUT}"
+  exit 1
+fi
+for who in bob carol; do
+  user="$(tr '[:lower:]' '[:upper:]' <<<"${who}")_USER"
+  if ! grep -qx "\[${ALICE_USER}\] hello friends" <<<"$("${who}" recv --username "${!user}")"; then
+    echo "[-] ${who} did not receive the broadcast"
+    exit 1
+  fi
+done
+
+# A member without a session fails on its own; the others still get the message.
+alice broadcast add friends dave >/dev/null
+set +e
+OUT="$(alice send --username "${ALICE_USER}" @friends "second" 2>/dev/null)"
+STATUS=$?
+set -e
+if [[ ${STATUS} -eq 0 ]] || ! grep -q "^dave: failed: " <<<"${OUT}" \
+  || ! grep -qx "${BOB_USER}: sent" <<<"${OUT}"; then
+  echo "[-] Partial broadcast failure was not reported per recipient"
+  echo "${OUT}"
+  exit 1
+fi
+if ! grep -qx "\[${ALICE_USER}\] second" <<<"$(carol recv --username "${CAROL_USER}")"; then
+  echo "[-] Carol missed the broadcast after another member failed"
+  exit 1
+fi
+
+# Lists can be edited and deleted.
+alice broadcast remove friends dave >/dev/null
+if ! grep -qx "@friends: ${BOB_USER} ${CAROL_USER}" <<<"$(alice broadcast list)"; then
+  echo "[-] Broadcast list members are wrong after remove"
+  exit 1
+fi
+alice broadcast delete friends >/dev/null
+if alice send --username "${ALICE_USER}" @friends "gone" >/dev/null 2>&1; then
+  echo "[-] Send to a deleted list succeeded"
+  exit 1
+fi
+
+echo "[+] Broadcast list delivered to each member and reported failures per recipient."
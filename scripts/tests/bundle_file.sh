@@ -0,0 +1,101 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+ALICE_HOME="/tmp/alice-ciphera-bundle-file-alice"
+BOB_HOME="/tmp/bob-ciphera-bundle-file-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+BUNDLE_FILE="/tmp/ciphera-bundle-file.json"
+ARMOR_FILE="/tmp/ciphera-bundle-file.txt"
+
+cleanup() {
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${BUNDLE_FILE}" "${ARMOR_FILE}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+)
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob; no relay is ever started.
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+bob init >/dev/null
+
+# Without a relay, register only exports the bundle, and leaves out one-time prekeys.
+bob register "${BOB_USER}" --export-bundle "${BUNDLE_FILE}" >/dev/null 2>&1
+if ! grep -q "\"username\": \"${BOB_USER}\"" "${BUNDLE_FILE}" || grep -q '"one_time"' "${BUNDLE_FILE}"; then
+  echo "[-] Exported bundle is not Bob's, or carries one-time prekeys"
+  exit 1
+fi
+
+# The bundle must belong to the peer named.
+if alice start-session --bundle-file "${BUNDLE_FILE}" carol >/dev/null 2>&1; then
+  echo "[-] Session started with a bundle for another user"
+  exit 1
+fi
+
+# A tampered bundle fails the signed prekey check.
+sed -E '/"signed_prekey_sig": "A/{s//"signed_prekey_sig": "B/;b};s/("signed_prekey_sig": ")./\1A/' \
+  "${BUNDLE_FILE}" >"${ARMOR_FILE}"
+if alice start-session --bundle-file "${ARMOR_FILE}" >/dev/null 2>&1; then
+  echo "[-] Session started from a bundle with a bad signature"
+  exit 1
+fi
+
+# The peer defaults to the bundle's username, and its fingerprint is shown for checking.
+BOB_FP="$(bob fingerprint | tail -n 1)"
+OUT="$(alice start-session --bundle-file - <"${BUNDLE_FILE}")"
+if ! grep -q "Session created with ${BOB_USER}" <<<"${OUT}" || ! grep -qF "${BOB_FP##*fp:}" <<<"${OUT}"; then
+  echo "[-] Session from the bundle file was not created or did not show Bob's fingerprint"
+  echo "${OUT}"
+  exit 1
+fi
+
+# The first envelope carries the prekey message.
+alice export-envelope --username "${ALICE_USER}" "${BOB_USER}" "hello offline" -o "${ARMOR_FILE}" 2>/dev/null
+if ! grep -qx "\[${ALICE_USER}\] hello offline" <<<"$(bob import-envelope --username "${BOB_USER}" "${ARMOR_FILE}")"; then
+  echo "[-] Bob could not read the first message of the session started from his bundle"
+  exit 1
+fi
+
+# Once Bob's bundle was seen signed in the new form, one that falls back to a
+# raw signature is refused before the signature is even checked.
+jq 'del(.signed_prekey_sig_v)' "${BUNDLE_FILE}" >"${ARMOR_FILE}"
+if OUT="$(alice start-session --bundle-file "${ARMOR_FILE}" 2>&1)" || ! grep -q "older form" <<<"${OUT}"; then
+  echo "[-] A bundle downgraded to a raw signature was not refused: ${OUT}"
+  exit 1
+fi
+
+# Bob answers after starting his side from Alice's bundle, written to stdout.
+alice register "${ALICE_USER}" --export-bundle - 2>/dev/null >"${BUNDLE_FILE}"
+bob start-session --bundle-file "${BUNDLE_FILE}" "${ALICE_USER}" >/dev/null
+bob export-envelope --username "${BOB_USER}" "${ALICE_USER}" "hello back" -o "${ARMOR_FILE}" 2>/dev/null
+if ! grep -qx "\[${BOB_USER}\] hello back" <<<"$(alice import-envelope --username "${ALICE_USER}" "${ARMOR_FILE}")"; then
+  echo "[-] Alice could not read Bob's reply"
+  exit 1
+fi
+
+echo "[+] Session bootstrapped from an exported bundle file with no relay."
@@ -0,0 +1,88 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-chunks-alice"
+BOB_HOME="/tmp/bob-ciphera-chunks-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+BIG="/tmp/ciphera-chunks-big.bin"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-chunks.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${BIG}" "${BIG}.out"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+
+# 300 KiB of random bytes is far over the 64 KiB envelope cap.
+head -c 307200 /dev/urandom >"${BIG}"
+
+OUT="$(alice send --username "${ALICE_USER}" --dry-run "${BOB_USER}" <"${BIG}")"
+if ! grep -q "chunks: .* envelopes" <<<"${OUT}"; then
+  echo "[-] dry run did not report chunks: ${OUT}"
+  exit 1
+fi
+
+# Under the fail policy the send is refused.
+alice conversations oversize fail >/dev/null
+if alice send --username "${ALICE_USER}" "${BOB_USER}" <"${BIG}" >/dev/null 2>&1; then
+  echo "[-] oversize message sent under the fail policy"
+  exit 1
+fi
+alice conversations oversize chunk >/dev/null
+
+# Sent in chunks, it arrives whole, byte for byte, as one message.
+alice send --username "${ALICE_USER}" "${BOB_USER}" <"${BIG}" >/dev/null
+bob recv --username "${BOB_USER}" --peer "${ALICE_USER}" --raw >"${BIG}.out"
+if ! cmp -s "${BIG}" "${BIG}.out"; then
+  echo "[-] Bob did not receive the message intact ($(wc -c <"${BIG}.out") bytes)"
+  exit 1
+fi
+
+echo "[+] An oversize message was sent in chunks and reassembled."
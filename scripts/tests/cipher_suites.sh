@@ -0,0 +1,142 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-suites-alice"
+BOB_HOME="/tmp/bob-ciphera-suites-bob"
+CAROL_HOME="/tmp/carol-ciphera-suites-carol"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+CAROL_PASS="Carol-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-suites.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+carol() {
+  "${CIPHERA_BIN}" --home "${CAROL_HOME}" --relay "${RELAY_URL}" --passphrase "${CAROL_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+
+# Both suites are listed, and the default is preferred until Alice changes it.
+OUT="$(alice conversations suite)"
+if ! grep -q '^\* x25519-hkdf-sha256-chacha20poly1305' <<<"${OUT}" \
+  || ! grep -q '^  x25519-hkdf-sha512-chacha20poly1305' <<<"${OUT}"; then
+  echo "[-] unexpected suite list: ${OUT}"
+  exit 1
+fi
+if alice conversations suite no-such-suite >/dev/null 2>&1; then
+  echo "[-] an unknown suite was accepted"
+  exit 1
+fi
+OUT="$(alice conversations suite x25519-hkdf-sha512-chacha20poly1305)"
+if ! grep -q '^\* x25519-hkdf-sha512' <<<"${OUT}"; then
+  echo "[-] the SHA-512 suite was not preferred: ${OUT}"
+  exit 1
+fi
+
+# Bob's bundle offers SHA-512, so the conversation Alice starts runs on it.
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello over sha-512" >/dev/null
+OUT="$(bob recv --username "${BOB_USER}")"
+if ! grep -qF "[${ALICE_USER}] hello over sha-512" <<<"${OUT}"; then
+  echo "[-] Bob did not get Alice's message: ${OUT}"
+  exit 1
+fi
+bob start-session "${ALICE_USER}" >/dev/null
+bob send --username "${BOB_USER}" "${ALICE_USER}" "hello back" >/dev/null
+OUT="$(alice recv --username "${ALICE_USER}")"
+if ! grep -qF "[${BOB_USER}] hello back" <<<"${OUT}"; then
+  echo "[-] Alice did not get Bob's reply: ${OUT}"
+  exit 1
+fi
+for side in alice bob; do
+  OUT="$("${side}" sessions)"
+  if ! grep -q 'suite=x25519-hkdf-sha512-chacha20poly1305' <<<"${OUT}"; then
+    echo "[-] ${side}'s conversation is not on the SHA-512 suite: ${OUT}"
+    exit 1
+  fi
+done
+
+# Going back to the default leaves the running conversation on its suite.
+OUT="$(alice conversations suite default)"
+if ! grep -q '^\* x25519-hkdf-sha256' <<<"${OUT}"; then
+  echo "[-] the default suite was not preferred again: ${OUT}"
+  exit 1
+fi
+alice send --username "${ALICE_USER}" "${BOB_USER}" "still sha-512" >/dev/null
+OUT="$(bob recv --username "${BOB_USER}")"
+if ! grep -qF "[${ALICE_USER}] still sha-512" <<<"${OUT}"; then
+  echo "[-] Bob did not get Alice's message on the running suite: ${OUT}"
+  exit 1
+fi
+
+# Bob's signature covers his suites, so a relay cannot strip SHA-512 to
+# push Carol onto the default. Only Bob can replace his bundle, so serve the
+# stripped one from a fresh relay, as a hostile one would.
+carol init >/dev/null
+carol conversations suite x25519-hkdf-sha512-chacha20poly1305 >/dev/null
+BUNDLE="$(curl -sf "${RELAY_URL}/prekey/${BOB_USER}")"
+STRIPPED="$(jq -c '.suites = ["x25519-hkdf-sha256-chacha20poly1305"]' <<<"${BUNDLE}")"
+kill "${RELAY_PID}" && wait "${RELAY_PID}" || true
+"${RELAY_BIN}" >>"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+curl -sf -X POST -H 'Content-Type: application/json' -d "${STRIPPED}" "${RELAY_URL}/register" >/dev/null
+if OUT="$(carol start-session "${BOB_USER}" 2>&1)"; then
+  echo "[-] Carol started a session from a bundle with its suites stripped: ${OUT}"
+  exit 1
+fi
+if ! grep -q "signed prekey verification failed" <<<"${OUT}"; then
+  echo "[-] Carol refused the stripped bundle for another reason: ${OUT}"
+  exit 1
+fi
+
+echo "[+] Peers negotiated the SHA-512 cipher suite and exchanged messages on it, and its offer could not be stripped."
@@ -0,0 +1,171 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-compose-alice"
+BOB_HOME="/tmp/bob-ciphera-compose-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-compose.log"
+WORK_DIR="/tmp/ciphera-compose-work"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${WORK_DIR}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" --log >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${WORK_DIR}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}" "${WORK_DIR}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both; Alice starts the session.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+
+# Editors are scripts run with the message file as their argument.
+editor() {
+  local name="$1"
+  shift
+  printf '#!/usr/bin/env bash\n%s\n' "$*" >"${WORK_DIR}/${name}"
+  chmod +x "${WORK_DIR}/${name}"
+}
+compose() {
+  EDITOR="${WORK_DIR}/$1" alice compose --username "${ALICE_USER}" --autosave 100ms "${BOB_USER}" "${@:2}"
+}
+
+printf 'line one\nline two\n' >"${WORK_DIR}/notes.txt"
+head -c 3000 /dev/urandom >"${WORK_DIR}/blob.bin"
+
+# The first editor writes a draft, waits for it to be autosaved, and fails.
+editor write-and-fail "cat >\"\$1\" <<'MSG'
+# Plan
+
+Meet at **noon**.
+@attach ${WORK_DIR}/notes.txt
+@attach ${WORK_DIR}/blob.bin
+MSG
+sleep 1
+[[ -f '${ALICE_HOME}/drafts.json.enc' ]] && touch '${WORK_DIR}/autosaved'
+exit 1"
+if compose write-and-fail >/dev/null 2>&1; then
+  echo "[-] compose succeeded although the editor failed"
+  exit 1
+fi
+if [[ ! -f "${WORK_DIR}/autosaved" ]]; then
+  echo "[-] The draft was not autosaved while the editor ran"
+  exit 1
+fi
+if grep -q "noon" "${ALICE_HOME}/drafts.json.enc"; then
+  echo "[-] The draft was stored in the clear"
+  exit 1
+fi
+if ! alice compose --list | grep -q "^${BOB_USER}.*text/markdown"; then
+  echo "[-] compose --list does not show the draft"
+  exit 1
+fi
+if [[ "$(bob recv --username "${BOB_USER}")" == *noon* ]]; then
+  echo "[-] A message was sent although the editor failed"
+  exit 1
+fi
+
+# Exiting without saving resumes the draft but sends nothing.
+editor look "cp \"\$1\" '${WORK_DIR}/seen'"
+if ! compose look | grep -q "Draft not saved; nothing sent"; then
+  echo "[-] compose did not report the unsaved draft"
+  exit 1
+fi
+if ! grep -q "Meet at \*\*noon\*\*" "${WORK_DIR}/seen"; then
+  echo "[-] The editor was not given the saved draft"
+  exit 1
+fi
+
+# Saving and exiting sends the message and then each attachment.
+editor finish "echo 'See you there.' >>\"\$1\""
+out="$(compose finish)"
+if [[ "${out}" != *"Message sent"* || "$(grep -c "Attachment sent" <<<"${out}")" != "2" ]]; then
+  echo "[-] compose did not send the message and both attachments"
+  echo "${out}"
+  exit 1
+fi
+if ! alice compose --list | grep -q "No drafts"; then
+  echo "[-] The draft was kept after sending"
+  exit 1
+fi
+got="$(bob recv --username "${BOB_USER}")"
+for want in "Meet at \*\*noon\*\*" "See you there." "\[file notes.txt\]" "line two" \
+  "\[file blob.bin, application/octet-stream, 3000 bytes\]"; do
+  if ! grep -q "${want}" <<<"${got}"; then
+    echo "[-] Bob did not receive ${want}"
+    echo "${got}"
+    exit 1
+  fi
+done
+if [[ "${got}" == *"@attach"* ]]; then
+  echo "[-] Attachment references were sent as text"
+  exit 1
+fi
+
+# A missing attachment stops the send before anything goes out.
+editor missing "printf 'hello\\n@attach ${WORK_DIR}/nope.txt\\n' >\"\$1\""
+if compose missing >/dev/null 2>&1; then
+  echo "[-] compose sent a message with a missing attachment"
+  exit 1
+fi
+if [[ -n "$(bob recv --username "${BOB_USER}" | grep hello || true)" ]]; then
+  echo "[-] The body was sent although an attachment was missing"
+  exit 1
+fi
+alice compose --discard "${BOB_USER}" >/dev/null
+if [[ -f "${ALICE_HOME}/drafts.json.enc" ]]; then
+  echo "[-] compose --discard left the draft"
+  exit 1
+fi
+
+# An empty message is discarded rather than sent.
+editor empty "printf '\\n\\n' >\"\$1\""
+if ! compose empty | grep -q "draft discarded, nothing sent"; then
+  echo "[-] compose did not discard the empty message"
+  exit 1
+fi
+
+echo "[+] compose autosaved an encrypted draft and sent it with its attachments on save."
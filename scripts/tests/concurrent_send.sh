@@ -0,0 +1,105 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Sends started at once to the same peer each take their own ratchet step:
+# the conversation lock is held from loading the state until the envelope is
+# posted, so no two messages share a message number or key, and the peer
+# decrypts them all.
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-concurrent-alice"
+BOB_HOME="/tmp/bob-ciphera-concurrent-bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+SENDS=8
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-concurrent-send.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register alice >/dev/null
+bob init >/dev/null
+bob register bob >/dev/null
+alice start-session bob >/dev/null
+alice devtools ratchet-debug on >/dev/null
+
+# The first send creates the conversation, so it races too.
+PIDS=()
+for i in $(seq 1 "${SENDS}"); do
+  alice send -u alice bob "concurrent ${i}" >/dev/null & PIDS+=($!)
+done
+for pid in "${PIDS[@]}"; do
+  if ! wait "${pid}"; then
+    echo "[-] A concurrent send failed"
+    exit 1
+  fi
+done
+
+# Every send took its own step: distinct message numbers, and distinct
+# sending chain keys to derive the message keys from.
+STEPS="$(alice devtools ratchet-replay --json bob)"
+NUMBERS="$(jq -r '.[] | select(.op == "encrypt") | .header.n' <<<"${STEPS}" | sort -u | wc -l)"
+KEYS="$(jq -r '.[] | select(.op == "encrypt") | .before.send_ck' <<<"${STEPS}" | sort -u | wc -l)"
+if (( NUMBERS != SENDS || KEYS != SENDS )); then
+  echo "[-] ${SENDS} concurrent sends used ${NUMBERS} message number(s) and ${KEYS} key(s)"
+  alice devtools ratchet-replay bob
+  exit 1
+fi
+
+# Bob decrypts every one of them.
+if ! OUT="$(bob recv -u bob 2>&1)"; then
+  echo "[-] Bob could not receive every message"
+  echo "${OUT}"
+  exit 1
+fi
+for i in $(seq 1 "${SENDS}"); do
+  if ! grep -q "concurrent ${i}\$" <<<"${OUT}"; then
+    echo "[-] Bob did not receive message ${i}"
+    echo "${OUT}"
+    exit 1
+  fi
+done
+
+echo "[+] Concurrent sends took distinct ratchet steps and all decrypted."
@@ -0,0 +1,111 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-diff-state-alice"
+BOB_HOME="/tmp/bob-ciphera-diff-state-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-diff-state.log"
+ALICE_OLD="/tmp/ciphera-diff-state-alice-old.export"
+BOB_EXPORT="/tmp/ciphera-diff-state-bob.export"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${ALICE_OLD}" "${BOB_EXPORT}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+
+# Alice keeps an old copy of her state, then sends two more messages.
+alice sessions export "${BOB_USER}" -o "${ALICE_OLD}" --backup-passphrase "diff-pass" 2>/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "one" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "two" >/dev/null
+
+# The old copy against her current state: same side, two messages sent since.
+out="$(alice devtools diff-state "${ALICE_OLD}" "${BOB_USER}" --backup-passphrase "diff-pass")"
+if ! grep -q "Relation: same-side" <<<"${out}" || ! grep -q "send index: 1 -> 3" <<<"${out}"; then
+  echo "[-] Old and current state were not compared as one side two messages apart:"
+  echo "${out}"
+  exit 1
+fi
+if grep -q "^!" <<<"${out}"; then
+  echo "[-] Normal progress was reported as a problem:"
+  echo "${out}"
+  exit 1
+fi
+
+# Before Bob fetches, the two ends differ only by messages in flight.
+bob sessions export "${ALICE_USER}" -o "${BOB_EXPORT}" --backup-passphrase "bob-pass" 2>/dev/null
+out="$(alice devtools diff-state "${ALICE_OLD}" "${BOB_EXPORT}" --backup-passphrase "diff-pass" --other-passphrase "bob-pass" --json)"
+if ! grep -q '"relation": "peers"' <<<"${out}" || grep -q '"problem": true' <<<"${out}"; then
+  echo "[-] Alice's old state and Bob's were not recognised as two healthy ends:"
+  echo "${out}"
+  exit 1
+fi
+
+# Once Bob has read both, Alice's old copy is behind what he received: a
+# restored copy that would reuse message keys.
+bob recv --username "${BOB_USER}" >/dev/null
+bob sessions export "${ALICE_USER}" -o "${BOB_EXPORT}" --backup-passphrase "bob-pass" 2>/dev/null
+out="$(alice devtools diff-state "${ALICE_OLD}" "${BOB_EXPORT}" --backup-passphrase "diff-pass" --other-passphrase "bob-pass")"
+if ! grep -q "^! A to B index: 1 -> 3" <<<"${out}"; then
+  echo "[-] Index drift between the ends was not reported as a problem:"
+  echo "${out}"
+  exit 1
+fi
+
+# Secret keys are never printed.
+if grep -qi "root_key\|send_ck\|skipped\":" <<<"$(alice devtools diff-state "${ALICE_OLD}" "${BOB_USER}" --backup-passphrase "diff-pass" --json)"; then
+  echo "[-] diff-state printed key material"
+  exit 1
+fi
+
+echo "[+] diff-state explained how conversation states diverged."
@@ -0,0 +1,104 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-envelope-export-alice"
+BOB_HOME="/tmp/bob-ciphera-envelope-export-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-envelope-export.log"
+ARMOR_FILE="/tmp/ciphera-envelope-export.txt"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${ARMOR_FILE}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Both register and Alice fetches Bob's bundle while the relay is up.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+
+# From here on there is no relay.
+kill "${RELAY_PID}" >/dev/null 2>&1 || true
+wait "${RELAY_PID}" >/dev/null 2>&1 || true
+unset RELAY_PID
+
+# The first envelope carries the prekey message and starts the conversation.
+alice export-envelope --username "${ALICE_USER}" "${BOB_USER}" "hello by usb" -o "${ARMOR_FILE}" 2>/dev/null
+if ! grep -qx -- "-----BEGIN CIPHERA ENVELOPE-----" "${ARMOR_FILE}"; then
+  echo "[-] Export did not write an armored envelope"
+  exit 1
+fi
+if ! grep -qx "\[${ALICE_USER}\] hello by usb" <<<"$(bob import-envelope --username "${BOB_USER}" "${ARMOR_FILE}")"; then
+  echo "[-] Bob could not import the first envelope without a relay"
+  exit 1
+fi
+
+# An envelope can only be imported once.
+if bob import-envelope --username "${BOB_USER}" "${ARMOR_FILE}" >/dev/null 2>&1; then
+  echo "[-] The same envelope was imported twice"
+  exit 1
+fi
+
+# A password-protected envelope hides the header and needs the password.
+alice export-envelope --username "${ALICE_USER}" "${BOB_USER}" "sealed one" --password "Courier-pw1" >"${ARMOR_FILE}" 2>/dev/null
+if ! grep -qx -- "-----BEGIN CIPHERA SEALED ENVELOPE-----" "${ARMOR_FILE}"; then
+  echo "[-] Password-protected export was not sealed"
+  exit 1
+fi
+if bob import-envelope --username "${BOB_USER}" "${ARMOR_FILE}" >/dev/null 2>&1 \
+  || bob import-envelope --username "${BOB_USER}" --password "wrong" "${ARMOR_FILE}" >/dev/null 2>&1; then
+  echo "[-] Sealed envelope was imported without the right password"
+  exit 1
+fi
+OUT="$(bob import-envelope --username "${BOB_USER}" --password "Courier-pw1" - <"${ARMOR_FILE}")"
+if ! grep -qx "\[${ALICE_USER}\] sealed one" <<<"${OUT}"; then
+  echo "[-] Bob could not import the sealed envelope"
+  exit 1
+fi
+
+echo "[+] Envelopes delivered without a relay, with and without a password."
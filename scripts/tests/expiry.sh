@@ -0,0 +1,127 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-expiry-alice"
+BOB_HOME="/tmp/bob-ciphera-expiry-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-expiry.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both; Alice starts the session.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+
+# A message Bob fetches in time is delivered as usual.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello" >/dev/null
+if ! grep -q "hello" <<<"$(bob recv --username "${BOB_USER}" 2>&1)"; then
+  echo "[-] Bob did not receive the first message"
+  exit 1
+fi
+
+# One message expires before Bob fetches it; the other is still waiting.
+alice send --username "${ALICE_USER}" --expires 1s "${BOB_USER}" "gone" >/dev/null
+alice send --username "${ALICE_USER}" --expires 1h "${BOB_USER}" "kept" >/dev/null
+sleep 2
+OUT="$(bob recv --username "${BOB_USER}" 2>&1)"
+if grep -q "gone" <<<"${OUT}" || ! grep -q "kept" <<<"${OUT}"; then
+  echo "[-] The relay did not drop only the expired message"
+  echo "${OUT}"
+  exit 1
+fi
+if ! grep -qx "1 message(s) expired at the relay before they were fetched" <<<"${OUT}"; then
+  echo "[-] Bob was not told about the expired message"
+  echo "${OUT}"
+  exit 1
+fi
+
+# Alice learns on her next fetch that "gone" was never delivered, and
+# `sent` marks it expired.
+OUT="$(alice recv --username "${ALICE_USER}" 2>&1)"
+if ! grep -q "Your message to ${BOB_USER} sent .* expired unfetched at .*; it was not delivered" <<<"${OUT}" \
+  || [[ "$(grep -c "not delivered" <<<"${OUT}")" -ne 1 ]]; then
+  echo "[-] Alice was not told her message expired undelivered"
+  echo "${OUT}"
+  exit 1
+fi
+if [[ "$(alice sent --username "${ALICE_USER}" "${BOB_USER}" | grep -c $'\texpired ')" -ne 1 ]]; then
+  echo "[-] sent does not mark the expired message"
+  alice sent --username "${ALICE_USER}" "${BOB_USER}"
+  exit 1
+fi
+if grep -q "not delivered" <<<"$(alice recv --username "${ALICE_USER}" 2>&1)"; then
+  echo "[-] Alice was told about the expired message twice"
+  exit 1
+fi
+
+# The count is reported once.
+if grep -q "expired" <<<"$(bob recv --username "${BOB_USER}" 2>&1)"; then
+  echo "[-] The expired count was reported twice"
+  exit 1
+fi
+
+# The ratchet carries on past the dropped message.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "after" >/dev/null
+if ! grep -q "after" <<<"$(bob recv --username "${BOB_USER}" 2>&1)"; then
+  echo "[-] Bob could not decrypt a message sent after the expired one"
+  exit 1
+fi
+
+# Envelopes that have already expired are refused.
+CODE="$(curl -s -o /dev/null -w '%{http_code}' -X POST "${RELAY_URL}/msg/${BOB_USER}" \
+  -d '{"from":"alice","to":"bob","header":{"dh_pub":null,"pn":0,"n":0},"cipher":"","expires_utc":1}')"
+if [[ "${CODE}" != "400" ]]; then
+  echo "[-] The relay accepted an already-expired envelope (HTTP ${CODE})"
+  exit 1
+fi
+
+echo "[+] The relay dropped the expired message unfetched, and both Bob and Alice were told."
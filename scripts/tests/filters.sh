@@ -0,0 +1,124 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-filters-alice"
+BOB_HOME="/tmp/bob-ciphera-filters-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+CAROL_HOME="/tmp/carol-ciphera-filters-carol"
+CAROL_USER="carol"
+CAROL_PASS="Carol-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-filters.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+carol() {
+  "${CIPHERA_BIN}" --home "${CAROL_HOME}" --relay "${RELAY_URL}" --passphrase "${CAROL_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+carol init >/dev/null
+carol register "${CAROL_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+# Fresh one-time prekeys, so Carol's handshake does not reuse Alice's.
+bob register "${BOB_USER}" >/dev/null
+carol start-session "${BOB_USER}" >/dev/null
+
+# Bob only takes short text from Alice.
+bob conversations filters --sender "${ALICE_USER}" --type text/plain --max-size 64 >/dev/null
+
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello bob" >/dev/null
+alice send --username "${ALICE_USER}" --content-type text/markdown "${BOB_USER}" "# heading" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "$(printf 'x%.0s' {1..100})" >/dev/null
+carol send --username "${CAROL_USER}" "${BOB_USER}" "buy now" >/dev/null
+
+OUT="$(bob recv --username "${BOB_USER}" 2>&1 || true)"
+if ! grep -q "hello bob" <<<"${OUT}" || grep -q "heading\|buy now\|xxxx" <<<"${OUT}"; then
+  echo "[-] filters did not hold the right messages: ${OUT}"
+  exit 1
+fi
+if ! grep -q "ciphera held list" <<<"${OUT}"; then
+  echo "[-] recv did not point at the held messages: ${OUT}"
+  exit 1
+fi
+
+HELD="$(bob held list)"
+if [[ "$(wc -l <<<"${HELD}")" -ne 3 ]] \
+  || ! grep -q "sender not allowed" <<<"${HELD}" \
+  || ! grep -q "content type text/markdown not allowed" <<<"${HELD}" \
+  || ! grep -q "over the 64 byte limit" <<<"${HELD}"; then
+  echo "[-] unexpected held list: ${HELD}"
+  exit 1
+fi
+if bob history | grep -q "buy now"; then
+  echo "[-] a held message reached the history"
+  exit 1
+fi
+
+# Accepting one releases it into the history; dropping discards it.
+CAROL_ID="$(grep "${CAROL_USER}" <<<"${HELD}" | cut -f1)"
+MD_ID="$(grep "markdown" <<<"${HELD}" | cut -f1)"
+bob held show "${CAROL_ID}" | grep -q "buy now"
+bob held accept "${MD_ID}" | grep -q "heading"
+bob held drop "${CAROL_ID}" >/dev/null
+if ! bob history | grep -q "heading"; then
+  echo "[-] the accepted message is not in the history"
+  exit 1
+fi
+if [[ "$(bob held list | wc -l)" -ne 1 ]]; then
+  echo "[-] held list not updated: $(bob held list)"
+  exit 1
+fi
+
+# With the filters off, everything comes through.
+bob conversations filters off | grep -q "off"
+carol send --username "${CAROL_USER}" "${BOB_USER}" "second try" >/dev/null
+bob recv --username "${BOB_USER}" | grep -q "second try"
+
+echo "[+] Receive filters held unwanted messages for review."
@@ -0,0 +1,97 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-fpaddr-alice"
+BOB_HOME="/tmp/bob-ciphera-fpaddr-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-fpaddr.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+
+ALICE_FP="$(alice fingerprint | awk '/^Address:/ {print $2}')"
+BOB_FP="$(bob fingerprint | awk '/^Address:/ {print $2}')"
+
+# Bob gave Alice his fingerprint; she adds him as a contact without a code.
+alice pair add "${BOB_FP}" | grep -qF "Added ${BOB_FP}"
+if alice pair add "fp:00000000000000000000" >/dev/null 2>&1; then
+  echo "[-] a fingerprint no one registered was added as a contact"
+  exit 1
+fi
+
+# Alice messages Bob by fingerprint; neither side uses the other's username.
+alice start-session "${BOB_FP}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_FP}" "hello by fingerprint" >/dev/null
+OUT="$(bob recv --username "${BOB_USER}")"
+if ! grep -qF "[${ALICE_FP}] hello by fingerprint" <<<"${OUT}"; then
+  echo "[-] Bob did not get Alice's message from her fingerprint: ${OUT}"
+  exit 1
+fi
+bob start-session "${ALICE_FP}" >/dev/null
+bob send --username "${BOB_USER}" "${ALICE_FP}" "hello back" >/dev/null
+OUT="$(alice recv --username "${ALICE_USER}")"
+if ! grep -qF "[${BOB_FP}] hello back" <<<"${OUT}"; then
+  echo "[-] Alice did not get Bob's reply from his fingerprint: ${OUT}"
+  exit 1
+fi
+if ! alice sessions | grep -F "${BOB_FP}" | grep -q confirmed; then
+  echo "[-] the session with ${BOB_FP} was not confirmed: $(alice sessions)"
+  exit 1
+fi
+
+# The relay will not hand out fp: usernames.
+if bob register "${ALICE_FP}" >/dev/null 2>&1; then
+  echo "[-] the relay registered an fp: username"
+  exit 1
+fi
+
+echo "[+] Peers added and messaged each other by identity fingerprint alone."
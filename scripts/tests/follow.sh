@@ -0,0 +1,133 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-follow-alice"
+BOB_HOME="/tmp/bob-ciphera-follow-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-follow.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  if [[ -n "${FOLLOW_PID:-}" ]]; then
+    kill "${FOLLOW_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${BOB_OUT}" "${BOB_ERR}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both; Alice starts the session.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+
+BOB_OUT="/tmp/ciphera-follow-bob.out"
+BOB_ERR="/tmp/ciphera-follow-bob.err"
+
+# Pacing bounds only make sense while following.
+if bob recv --username "${BOB_USER}" --max-batch 5 >/dev/null 2>&1; then
+  echo "[-] --max-batch was accepted without --follow"
+  exit 1
+fi
+
+# A backlog builds up before Bob starts following.
+for i in $(seq 1 30); do
+  alice send --username "${ALICE_USER}" "${BOB_USER}" "backlog ${i}" >/dev/null
+done
+
+# Run the binary directly so FOLLOW_PID is ciphera, not a subshell.
+"${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" \
+  --verbose recv --username "${BOB_USER}" --follow \
+  --min-batch 2 --max-batch 8 --min-interval 100ms --max-interval 1s \
+  >"${BOB_OUT}" 2>"${BOB_ERR}" & FOLLOW_PID=$!
+
+wait_for() {
+  for _ in {1..100}; do
+    grep -q "$1" "${BOB_OUT}" && return 0
+    sleep 0.1
+  done
+  return 1
+}
+
+if ! wait_for "backlog 30"; then
+  echo "[-] Bob did not work through the backlog"
+  cat "${BOB_OUT}" "${BOB_ERR}"
+  exit 1
+fi
+if [[ "$(grep -c "backlog" "${BOB_OUT}")" != "30" ]]; then
+  echo "[-] Bob did not receive every backlogged message exactly once"
+  cat "${BOB_OUT}"
+  exit 1
+fi
+
+# Fetches grew to the upper bound while the backlog lasted.
+if ! grep -q "follow pacing.*limit=8" "${BOB_ERR}"; then
+  echo "[-] The fetch limit never grew to --max-batch"
+  cat "${BOB_ERR}"
+  exit 1
+fi
+
+# Once idle, polling slows down but new messages still arrive.
+sleep 2
+alice send --username "${ALICE_USER}" "${BOB_USER}" "later" >/dev/null
+if ! wait_for "later"; then
+  echo "[-] Bob did not receive a message sent while idle"
+  exit 1
+fi
+if ! grep -q "follow pacing.*processed=0.*limit=2 wait=1s" "${BOB_ERR}"; then
+  echo "[-] Idle polling did not back off to --max-interval"
+  cat "${BOB_ERR}"
+  exit 1
+fi
+
+# Ctrl-C stops following cleanly.
+kill -INT "${FOLLOW_PID}"
+if ! wait "${FOLLOW_PID}"; then
+  echo "[-] recv --follow did not exit cleanly on interrupt"
+  exit 1
+fi
+
+echo "[+] recv --follow drained the backlog in growing batches and backed off when idle."
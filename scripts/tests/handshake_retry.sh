@@ -0,0 +1,151 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-handshake-retry-alice"
+BOB_HOME="/tmp/bob-ciphera-handshake-retry-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-handshake-retry.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both; only Alice starts a session.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+
+# Bob's prekey store diverges from his bundle: the one-time prekey Alice
+# took is gone.
+OPK="$(jq -r --arg u "${BOB_USER}" '.data[$u].opk_id' "${ALICE_HOME}/sessions.json")"
+if [[ -z "${OPK}" || "${OPK}" == "null" ]]; then
+  echo "[-] Alice's session names no one-time prekey"
+  exit 1
+fi
+jq --arg id "${OPK}" 'del(.data[$id])' "${BOB_HOME}/opk_pairs.json" >"${BOB_HOME}/opks.tmp"
+mv "${BOB_HOME}/opks.tmp" "${BOB_HOME}/opk_pairs.json"
+
+alice send --username "${ALICE_USER}" "${BOB_USER}" "lost on the first handshake" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "also lost" >/dev/null
+
+# Bob cannot derive Alice's root: both envelopes are quarantined rather than
+# blocking his queue, and Alice is asked to retry.
+OUT="$(bob recv --username "${BOB_USER}" 2>&1 || true)"
+if ! grep -q "quarantined: 2 envelope" <<<"${OUT}"; then
+  echo "[-] Bob did not quarantine the failed handshake"
+  echo "${OUT}"
+  exit 1
+fi
+OUT="$(bob quarantine list)"
+if ! grep -q "no longer held" <<<"${OUT}"; then
+  echo "[-] Quarantine does not name the missing one-time prekey"
+  echo "${OUT}"
+  exit 1
+fi
+
+# Alice renews the session from a fresh bundle and is told what was lost.
+OUT="$(alice recv --username "${ALICE_USER}")"
+if ! grep -q "started a new one from a fresh bundle. 2 message(s)" <<<"${OUT}"; then
+  echo "[-] Alice did not report the handshake retry"
+  echo "${OUT}"
+  exit 1
+fi
+NEW="$(jq -r --arg u "${BOB_USER}" '.data[$u].opk_id' "${ALICE_HOME}/sessions.json")"
+if [[ "${NEW}" == "${OPK}" ]]; then
+  echo "[-] Renewed session reuses the missing one-time prekey"
+  exit 1
+fi
+
+# The new handshake goes through.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "after the retry" >/dev/null
+OUT="$(bob recv --username "${BOB_USER}")"
+if ! grep -q "after the retry" <<<"${OUT}"; then
+  echo "[-] Bob did not receive the message on the new handshake"
+  echo "${OUT}"
+  exit 1
+fi
+alice recv --username "${ALICE_USER}" >/dev/null
+bob start-session "${ALICE_USER}" >/dev/null
+bob send --username "${BOB_USER}" "${ALICE_USER}" "reply" >/dev/null
+if ! grep -q "reply" <<<"$(alice recv --username "${ALICE_USER}")"; then
+  echo "[-] Alice did not receive Bob's reply"
+  exit 1
+fi
+
+# A rekey naming a missing one-time prekey is retried on the old root.
+alice conversations rekey --messages 2 >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "lost on the rekey" >/dev/null
+if ! grep -q "pending.*rekeys=1" <<<"$(alice sessions)"; then
+  echo "[-] Alice did not rekey"
+  alice sessions
+  exit 1
+fi
+OPK="$(jq -r --arg u "${BOB_USER}" '.data[$u].opk_id' "${ALICE_HOME}/sessions.json")"
+jq --arg id "${OPK}" 'del(.data[$id])' "${BOB_HOME}/opk_pairs.json" >"${BOB_HOME}/opks.tmp"
+mv "${BOB_HOME}/opks.tmp" "${BOB_HOME}/opk_pairs.json"
+bob recv --username "${BOB_USER}" >/dev/null 2>&1 || true
+OUT="$(alice recv --username "${ALICE_USER}")"
+if ! grep -q "started a new one from a fresh bundle. 1 message(s)" <<<"${OUT}"; then
+  echo "[-] Alice did not report the rekey retry"
+  echo "${OUT}"
+  exit 1
+fi
+alice send --username "${ALICE_USER}" "${BOB_USER}" "after the rekey retry" >/dev/null
+if ! grep -q "after the rekey retry" <<<"$(bob recv --username "${BOB_USER}")"; then
+  echo "[-] Bob did not follow the retried rekey"
+  exit 1
+fi
+alice recv --username "${ALICE_USER}" >/dev/null
+if ! grep -q "confirmed.*rekeys=1" <<<"$(alice sessions)"; then
+  echo "[-] Retried rekey was not confirmed"
+  alice sessions
+  exit 1
+fi
+
+echo "[+] Handshake naming a missing one-time prekey retried with a fresh bundle"
@@ -0,0 +1,103 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-header-mac-alice"
+BOB_HOME="/tmp/bob-ciphera-header-mac-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-header-mac.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both; Alice starts the session.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+
+# Forge envelopes from Alice: one far ahead in the chain with a made-up MAC,
+# one with no MAC at all. Neither may reach the ratchet or the quarantine.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "real" >/dev/null
+ENV="$(curl -s "${RELAY_URL}/msg/${BOB_USER}" | jq '.[0]')"
+JUNK="$(head -c 32 /dev/urandom | base64)"
+jq --arg m "${JUNK}" '.header.n = 1500 | .header_mac = $m' <<<"${ENV}" \
+  | curl -sS -X POST -H 'Content-Type: application/json' -d @- "${RELAY_URL}/msg/${BOB_USER}" >/dev/null
+jq 'del(.header_mac) | .header.n = 1501' <<<"${ENV}" \
+  | curl -sS -X POST -H 'Content-Type: application/json' -d @- "${RELAY_URL}/msg/${BOB_USER}" >/dev/null
+
+set +e
+OUT="$(bob recv --username "${BOB_USER}" 2>&1)"
+RC=$?
+set -e
+if [[ ${RC} -eq 0 ]] || ! grep -q "header failed authentication: 2 envelope(s) dropped" <<<"${OUT}"; then
+  echo "[-] Forged envelopes were not rejected by their header MAC"
+  echo "${OUT}"
+  exit 1
+fi
+if ! grep -q "\[alice\] real" <<<"${OUT}"; then
+  echo "[-] The genuine message was not delivered alongside the forgeries"
+  echo "${OUT}"
+  exit 1
+fi
+if ! grep -qx "Quarantine is empty" <<<"$(bob quarantine list)"; then
+  echo "[-] Forged envelopes were quarantined instead of dropped"
+  exit 1
+fi
+
+# The conversation carries on.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "after" >/dev/null
+if ! grep -q "after" <<<"$(bob recv --username "${BOB_USER}" 2>&1)"; then
+  echo "[-] Bob could not receive after the forgeries"
+  exit 1
+fi
+
+echo "[+] Forged envelopes were dropped by their header MAC before decryption."
@@ -0,0 +1,114 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-history-lock-alice"
+BOB_HOME="/tmp/bob-ciphera-history-lock-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-history-lock.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/phistory-lock/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both; Alice starts the session.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "before lock" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+
+HIST_PASS="Bob-history-5678"
+
+# The history cannot be locked with the identity passphrase.
+if bob history lock --new-passphrase "${BOB_PASS}" >/dev/null 2>&1; then
+  echo "[-] History was locked with the identity passphrase"
+  exit 1
+fi
+bob history lock --new-passphrase "${HIST_PASS}" >/dev/null
+
+# Once locked, the identity passphrase no longer reads the history.
+if OUT="$(bob history 2>&1)" || ! grep -q "history is locked with its own passphrase" <<<"${OUT}"; then
+  echo "[-] Identity passphrase still reads a locked history"
+  echo "${OUT}"
+  exit 1
+fi
+
+# Messages received while locked are recorded and join the history on open.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "while locked" >/dev/null
+if ! grep -q "while locked" <<<"$(bob recv --username "${BOB_USER}")"; then
+  echo "[-] Bob could not receive while his history was locked"
+  exit 1
+fi
+if [[ ! -s "${BOB_HOME}/history-pending.json" ]]; then
+  echo "[-] Message received while locked was not kept for later"
+  exit 1
+fi
+OUT="$(bob history --history-passphrase "${HIST_PASS}")"
+if ! grep -q "before lock" <<<"${OUT}" || ! grep -q "while locked" <<<"${OUT}"; then
+  echo "[-] Locked history is missing messages"
+  echo "${OUT}"
+  exit 1
+fi
+if [[ -e "${BOB_HOME}/history-pending.json" ]]; then
+  echo "[-] Pending history was not merged"
+  exit 1
+fi
+
+# Unlocking goes back to the identity passphrase.
+if bob history unlock --history-passphrase "wrong-Pass1234" >/dev/null 2>&1; then
+  echo "[-] History unlocked with a wrong history passphrase"
+  exit 1
+fi
+bob history unlock --history-passphrase "${HIST_PASS}" >/dev/null
+if [[ "$(bob history "${ALICE_USER}" | wc -l)" -ne 2 ]]; then
+  echo "[-] Identity passphrase does not read the unlocked history"
+  exit 1
+fi
+
+echo "[+] History locked with its own passphrase, recorded while locked and unlocked again."
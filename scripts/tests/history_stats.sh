@@ -0,0 +1,124 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-history-stats-alice"
+BOB_HOME="/tmp/bob-ciphera-history-stats-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-history-stats.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${TRANSCRIPT:-}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both; Alice starts the session.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello bob" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+bob start-session "${ALICE_USER}" >/dev/null
+bob send --username "${BOB_USER}" "${ALICE_USER}" "hello alice" >/dev/null
+
+
+# Messages exchanged through the relay are counted.
+OUT="$(bob history stats "${ALICE_USER}")"
+if ! grep -q "^${ALICE_USER}: 1 sent, 1 received$" <<<"${OUT}"; then
+  echo "[-] Stats did not count the messages exchanged with Alice"
+  echo "${OUT}"
+  exit 1
+fi
+
+# An imported transcript with Carol has known times: Bob replies after 10
+# minutes and after 35h18m, Carol once after 32 minutes, counted from
+# the first of Bob's two messages.
+TRANSCRIPT="$(mktemp)"
+LONG="$(printf 'x%.0s' {1..300})"
+cat >"${TRANSCRIPT}" <<JSON
+[
+  {"peer": "carol", "direction": "in",  "time": "2024-05-01T09:00:00Z", "text": "hi"},
+  {"peer": "carol", "direction": "out", "time": "2024-05-01T09:10:00Z", "text": "hello"},
+  {"peer": "carol", "direction": "out", "time": "2024-05-01T09:12:00Z", "text": "how are you?"},
+  {"peer": "carol", "direction": "in",  "time": "2024-05-01T09:42:00Z", "text": "fine"},
+  {"peer": "carol", "direction": "out", "time": "2024-05-02T21:00:00Z", "text": "${LONG}"}
+]
+JSON
+bob history import --format json "${TRANSCRIPT}" >/dev/null
+
+OUT="$(bob history stats carol --utc)"
+for want in \
+  "^carol: 3 sent, 2 received (5 imported)$" \
+  "2024-05-01 *2 / 2" \
+  "2024-05-02 *1 / 0" \
+  "Busiest hours (UTC): 09:00 (4), 21:00 (1)$" \
+  "You: 2 replies, mean 17h44m0s, median 17h44m0s$" \
+  "carol: 1 replies, mean 32m0s, median 32m0s$" \
+  "0-64 B *2 / 2" \
+  "257-1024 B *1 / 0"; do
+  if ! grep -q "${want}" <<<"${OUT}"; then
+    echo "[-] Stats for Carol lack: ${want}"
+    echo "${OUT}"
+    exit 1
+  fi
+done
+
+# The same figures are available as JSON.
+JSON_OUT="$(bob history stats carol --utc --json)"
+if ! grep -q '"sent": 3' <<<"${JSON_OUT}" || ! grep -q '"day": "2024-05-02"' <<<"${JSON_OUT}"; then
+  echo "[-] JSON stats are incomplete"
+  echo "${JSON_OUT}"
+  exit 1
+fi
+
+# A peer with no history says so.
+if ! bob history stats dave | grep -q "^No history with dave$"; then
+  echo "[-] Stats for an unknown peer did not say there is no history"
+  exit 1
+fi
+
+echo "[+] history stats summarised conversations from the local history."
@@ -0,0 +1,113 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# A relay run with --http3 also serves HTTP/3 over QUIC on its TLS port, and
+# clients run with --http3 send and receive over it. Without the flag they
+# keep using TCP.
+
+RELAY_URL="https://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-http3-alice"
+BOB_HOME="/tmp/bob-ciphera-http3-bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+TLS_DIR="/tmp/ciphera-http3-tls"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-http3.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${TLS_DIR}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# A self-signed certificate for 127.0.0.1, trusted by the clients below.
+rm -rf "${TLS_DIR}"
+mkdir -p "${TLS_DIR}"
+openssl req -x509 -newkey ec -pkeyopt ec_paramgen_curve:P-256 -nodes -days 1 \
+  -subj "/CN=127.0.0.1" -addext "subjectAltName=IP:127.0.0.1" \
+  -keyout "${TLS_DIR}/key.pem" -out "${TLS_DIR}/cert.pem" >/dev/null 2>&1
+export SSL_CERT_FILE="${TLS_DIR}/cert.pem"
+
+# --http3 refuses a listener without TLS.
+if timeout 5 "${RELAY_BIN}" --http3 --listen 127.0.0.1:8080 >/dev/null 2>&1; then
+  echo "[-] The relay served --http3 without TLS"
+  exit 1
+fi
+
+# Start relay
+"${RELAY_BIN}" --log --http3 --listen 127.0.0.1:8080 \
+  --tls-cert "${TLS_DIR}/cert.pem" --tls-key "${TLS_DIR}/key.pem" \
+  >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s --cacert "${SSL_CERT_FILE}" "${RELAY_URL}/healthz" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice (over HTTP/3) or Bob (over TCP)
+alice() {
+  "${CIPHERA_BIN}" --http3 --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register alice >/dev/null
+bob init >/dev/null
+bob register bob >/dev/null
+
+alice start-session bob >/dev/null
+alice send -u alice bob "hello over quic" >/dev/null
+OUT="$(bob recv -u bob)"
+if ! grep -qF "[alice] hello over quic" <<<"${OUT}"; then
+  echo "[-] Bob did not receive Alice's message: ${OUT}"
+  exit 1
+fi
+bob start-session alice >/dev/null
+bob send -u bob alice "hello over tcp" >/dev/null
+OUT="$(alice recv -u alice)"
+if ! grep -qF "[bob] hello over tcp" <<<"${OUT}"; then
+  echo "[-] Alice did not receive Bob's reply: ${OUT}"
+  exit 1
+fi
+
+# Alice's send and fetch went over HTTP/3, Bob's over TCP.
+if ! grep -q 'method=POST path=/msg/bob proto=HTTP/3.0' "${RELAY_LOG}" \
+  || ! grep -q 'method=GET path=/msg/alice proto=HTTP/3.0' "${RELAY_LOG}"; then
+  echo "[-] Alice's requests did not use HTTP/3"
+  cat "${RELAY_LOG}"
+  exit 1
+fi
+if grep -q 'method=GET path=/msg/bob proto=HTTP/3.0' "${RELAY_LOG}"; then
+  echo "[-] Bob's fetch used HTTP/3 without --http3"
+  exit 1
+fi
+if ! grep -q 'method=GET path=/msg/bob proto=HTTP/2.0' "${RELAY_LOG}"; then
+  echo "[-] Bob's fetch did not use HTTP/2 over TCP"
+  cat "${RELAY_LOG}"
+  exit 1
+fi
+
+echo "[+] A client with --http3 exchanged messages over QUIC with a client on TCP."
@@ -0,0 +1,99 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALIAS_RELAY_URL="http://127.0.0.1:8081"
+ALICE_HOME="/tmp/alice-ciphera-identity-correlation-alice"
+BOB_HOME="/tmp/bob-ciphera-identity-correlation-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+BOB_ALIAS="robert"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-identity-correlation.log"
+
+cleanup() {
+  for pid in "${RELAY_PID:-}" "${ALIAS_RELAY_PID:-}"; do
+    if [[ -n "${pid}" ]]; then
+      kill "${pid}" >/dev/null 2>&1 || true
+      wait "${pid}" >/dev/null 2>&1 || true
+    fi
+  done
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relays
+"${RELAY_BIN}" --port 8080 >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+"${RELAY_BIN}" --port 8081 >>"${RELAY_LOG}" 2>&1 & ALIAS_RELAY_PID=$!
+for url in "${RELAY_URL}" "${ALIAS_RELAY_URL}"; do
+  for _ in {1..50}; do
+    curl -s "${url}/healthz" >/dev/null 2>&1 && break
+    sleep 0.1
+  done
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+# A relay refuses a second username for an identity key, so Bob's identity
+# answers to another one on a second relay.
+if bob register "${BOB_ALIAS}" >/dev/null 2>&1; then
+  echo "[-] The relay registered ${BOB_USER}'s identity key under a second username"
+  exit 1
+fi
+bob --relay "${ALIAS_RELAY_URL}" register "${BOB_ALIAS}" >/dev/null 2>&1
+
+WARN="$(alice start-session "${BOB_USER}" 2>&1 >/dev/null)"
+if grep -q "same identity key" <<<"${WARN}"; then
+  echo "[-] first session with ${BOB_USER} warned about a shared identity key"
+  exit 1
+fi
+
+WARN="$(alice start-session "${BOB_ALIAS}@127.0.0.1:8081" 2>&1 >/dev/null)"
+if ! grep -q "${BOB_ALIAS} presents the same identity key as ${BOB_USER}" <<<"${WARN}"; then
+  echo "[-] session with ${BOB_ALIAS} did not warn that ${BOB_USER} has the same identity key"
+  echo "${WARN}"
+  exit 1
+fi
+if ! grep -q "\"shares_identity\"" "${ALICE_HOME}/sessions.json"; then
+  echo "[-] session did not record the other names of the identity key"
+  exit 1
+fi
+
+# Restarting the first session names the second in turn.
+WARN="$(alice start-session --reset "${BOB_USER}" 2>&1 >/dev/null)"
+if ! grep -q "${BOB_USER} presents the same identity key as ${BOB_ALIAS}" <<<"${WARN}"; then
+  echo "[-] restarted session with ${BOB_USER} did not warn about ${BOB_ALIAS}"
+  exit 1
+fi
+
+echo "[+] Sessions warned when two usernames presented the same identity key."
@@ -0,0 +1,102 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-lib-alice"
+BOB_HOME="/tmp/bob-ciphera-lib-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-lib.log"
+LIB="${BIN_DIR}/libciphera.so"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+  go build -buildmode=c-shared -o "${LIB}" ./cmd/libciphera
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+
+# Alice and Bob are two handles in one Python process using the C ABI.
+OUT="$(
+  LIB="${LIB}" RELAY_URL="${RELAY_URL}" ALICE_HOME="${ALICE_HOME}" BOB_HOME="${BOB_HOME}" python3 - <<'PY'
+import ctypes, json, os
+
+lib = ctypes.CDLL(os.environ["LIB"])
+for name in ("init", "register", "start_session", "send", "recv", "close"):
+    f = getattr(lib, "ciphera_" + name)
+    f.argtypes, f.restype = [ctypes.c_char_p], ctypes.c_void_p
+lib.ciphera_free.argtypes = [ctypes.c_void_p]
+
+def call(name, **req):
+    p = getattr(lib, "ciphera_" + name)(json.dumps(req).encode())
+    try:
+        rep = json.loads(ctypes.string_at(p).decode())
+    finally:
+        lib.ciphera_free(p)
+    if "error" in rep:
+        raise SystemExit(f"{name}: {rep['error']}")
+    return rep["result"]
+
+relay = os.environ["RELAY_URL"]
+a = call("init", home=os.environ["ALICE_HOME"], relay=relay, passphrase="Alice-pass1234")
+b = call("init", home=os.environ["BOB_HOME"], relay=relay, passphrase="Bob-pass1234")
+assert a["created"] and b["created"] and a["handle"] != b["handle"]
+call("register", handle=a["handle"], username="alice")
+call("register", handle=b["handle"], username="bob")
+s = call("start_session", handle=a["handle"], peer="bob")
+assert s["fingerprint"] == b["fingerprint"], s
+call("send", handle=a["handle"], **{"from": "alice"}, to="bob", text="hello from python")
+msgs = call("recv", handle=b["handle"], username="bob")["messages"]
+print(msgs[0]["from"], msgs[0]["body"]["type"])
+import base64
+print(base64.b64decode(msgs[0]["body"]["body"]).decode())
+call("close", handle=a["handle"])
+call("close", handle=b["handle"])
+PY
+)"
+
+if ! grep -q "alice text/plain" <<<"${OUT}" || ! grep -q "hello from python" <<<"${OUT}"; then
+  echo "[-] message did not round-trip through the C ABI: ${OUT}"
+  exit 1
+fi
+
+# The CLI reads the same home directory the library wrote.
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "Bob-pass1234" "$@"
+}
+if ! bob history | grep -q "hello from python"; then
+  echo "[-] the library did not record the message in Bob's history"
+  exit 1
+fi
+
+echo "[+] A message was sent and received through libciphera."
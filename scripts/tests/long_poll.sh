@@ -0,0 +1,111 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-long-poll-alice"
+BOB_HOME="/tmp/bob-ciphera-long-poll-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-long-poll.log"
+FETCH_OUT="/tmp/ciphera-long-poll-fetch.out"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  if [[ -n "${CURL_PID:-}" ]]; then
+    kill "${CURL_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${FETCH_OUT}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" --log >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both; Alice starts the session.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+
+# A wait that is not a duration is refused.
+status="$(curl -s -o /dev/null -w '%{http_code}' "${RELAY_URL}/msg/${BOB_USER}?wait=soon")"
+if [[ "${status}" != "400" ]]; then
+  echo "[-] GET /msg/${BOB_USER}?wait=soon returned ${status}, want 400"
+  exit 1
+fi
+
+# With nothing queued, the fetch answers empty once the wait is over.
+start=$(date +%s%N)
+body="$(curl -s "${RELAY_URL}/msg/${BOB_USER}?wait=1s")"
+took=$(( ($(date +%s%N) - start) / 1000000 ))
+if [[ "${body}" != "[]" || "${took}" -lt 900 ]]; then
+  echo "[-] Waiting fetch on an empty queue returned ${body} after ${took}ms, want [] after 1s"
+  exit 1
+fi
+
+# A fetch waiting 20 seconds answers as soon as Alice's message is queued.
+start=$(date +%s%N)
+curl -s "${RELAY_URL}/msg/${BOB_USER}?wait=20s" >"${FETCH_OUT}" & CURL_PID=$!
+sleep 0.5
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello" >/dev/null
+if ! wait "${CURL_PID}"; then
+  echo "[-] Waiting fetch failed"
+  exit 1
+fi
+CURL_PID=""
+took=$(( ($(date +%s%N) - start) / 1000000 ))
+if [[ "${took}" -ge 10000 ]]; then
+  echo "[-] Waiting fetch answered after ${took}ms, want on arrival"
+  exit 1
+fi
+if ! grep -q '"from":"alice"' "${FETCH_OUT}"; then
+  echo "[-] Waiting fetch did not return Alice's envelope"
+  cat "${FETCH_OUT}"
+  exit 1
+fi
+
+# The envelope is still queued, and Bob reads it as usual.
+if ! bob recv --username "${BOB_USER}" | grep -q "hello"; then
+  echo "[-] Bob did not receive the message"
+  exit 1
+fi
+
+echo "[+] Fetches with wait= were held until a message arrived or the wait ended."
@@ -122,7 +122,7 @@ if [[ "${COUNT}" -ne 2 ]]; then
   exit 1
 fi
 curl -sSf -X POST -H 'Content-Type: application/json' \
-  -d "{\"count\": ${COUNT}}" \
+  -d "$(jq -c '{ids: map(.id)}' <<<"${ENVS}")" \
   "${RELAY_URL}/msg/${BOB_USER}/ack" >/dev/null
 
 # Reverse the order and re-post as two separate envelopes
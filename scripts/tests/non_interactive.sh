@@ -0,0 +1,74 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Checks that --non-interactive never waits for terminal input: commands that
+# would ask fail at once with exit status 3, and piped stdin still works.
+
+ALICE_HOME="/tmp/alice-ciphera-noninteractive"
+ALICE_PASS="Alice-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+
+cleanup() {
+  rm -rf "${ALICE_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+)
+
+# Fresh home
+rm -rf "${ALICE_HOME}"
+mkdir -p "${ALICE_HOME}"
+
+# Run ciphera as Alice, never interactively
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --passphrase "${ALICE_PASS}" --non-interactive "$@"
+}
+
+alice init >/dev/null
+
+# The setup wizard only asks questions, so it is refused even with input piped in.
+set +e
+OUT="$(printf 'y\n' | alice setup 2>&1)"
+RC=$?
+set -e
+if [[ ${RC} -ne 3 ]] || ! grep -q "non-interactive" <<<"${OUT}"; then
+  echo "[-] setup was not refused (status ${RC}): ${OUT}"
+  exit 1
+fi
+
+# Piped stdin is not a prompt: send reads it and gets as far as the session.
+set +e
+OUT="$(echo hello | alice send -u alice bob 2>&1)"
+RC=$?
+set -e
+if [[ ${RC} -ne 1 ]] || ! grep -q "no session" <<<"${OUT}"; then
+  echo "[-] send did not read piped stdin (status ${RC}): ${OUT}"
+  exit 1
+fi
+
+# With stdin on a terminal, send fails instead of waiting for a message.
+if command -v script >/dev/null 2>&1; then
+  set +e
+  OUT="$(timeout 10 script -qec "'${CIPHERA_BIN}' --home '${ALICE_HOME}' --non-interactive send -u alice bob" /dev/null 2>&1)"
+  RC=$?
+  set -e
+  if [[ ${RC} -ne 3 ]] || ! grep -q "message body would be read from the terminal" <<<"${OUT}"; then
+    echo "[-] send waited for terminal input (status ${RC}): ${OUT}"
+    exit 1
+  fi
+else
+  echo "[*] script(1) not found; skipping the terminal check"
+fi
+
+echo "[+] --non-interactive failed instead of prompting."
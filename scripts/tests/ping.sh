@@ -0,0 +1,103 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-ping-alice"
+BOB_HOME="/tmp/bob-ciphera-ping-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-ping.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+alice recv --username "${ALICE_USER}" >/dev/null
+
+# Alice pings while Bob's client is running: his next fetch answers at once.
+alice ping --username "${ALICE_USER}" "${BOB_USER}" --wait 20s --interval 200ms >/tmp/ciphera-ping.out 2>/dev/null & PING_PID=$!
+sleep 1
+bob_out="$(bob recv --username "${BOB_USER}")"
+if ! wait "${PING_PID}"; then
+  echo "[-] Alice got no pong"
+  exit 1
+fi
+out="$(cat /tmp/ciphera-ping.out)"
+rm -f /tmp/ciphera-ping.out
+if ! grep -Eq "^${BOB_USER}: pong: round trip [0-9.]+m?s, peer answered in [0-9.]+m?s$" <<<"${out}"; then
+  echo "[-] Alice's ping did not report a verified round trip:"
+  echo "${out}"
+  exit 1
+fi
+if [[ -n "${bob_out}" ]]; then
+  echo "[-] Answering a ping showed Bob something:"
+  echo "${bob_out}"
+  exit 1
+fi
+
+# Without waiting, the pong turns up in a later recv. Bob answered
+# Alice's first message without fetching her bundle, so he cannot check
+# her signature and says so.
+bob ping --username "${BOB_USER}" "${ALICE_USER}" --wait 0 >/dev/null
+alice recv --username "${ALICE_USER}" >/dev/null
+if ! grep -q "^\[${ALICE_USER}\] \[pong: round trip .* (signature not checked" <<<"$(bob recv --username "${BOB_USER}")"; then
+  echo "[-] Bob's pong was not shown by recv as unverified"
+  exit 1
+fi
+
+# Nobody answers: the ping times out.
+if alice ping --username "${ALICE_USER}" "${BOB_USER}" --wait 1s --interval 200ms >/dev/null 2>&1; then
+  echo "[-] A ping nobody answered succeeded"
+  exit 1
+fi
+
+echo "[+] Pings were answered end to end with signed pongs."
@@ -0,0 +1,120 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-polls-alice"
+BOB_HOME="/tmp/bob-ciphera-polls-bob"
+CAROL_HOME="/tmp/carol-ciphera-polls-carol"
+ALICE_USER="alice"
+BOB_USER="bob"
+CAROL_USER="carol"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+CAROL_PASS="Carol-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-polls.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+
+# Run ciphera as Alice, Bob or Carol
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+carol() {
+  "${CIPHERA_BIN}" --home "${CAROL_HOME}" --relay "${RELAY_URL}" --passphrase "${CAROL_PASS}" "$@"
+}
+
+# Initialise and register everyone; Alice has sessions with Bob and Carol.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+carol init >/dev/null
+carol register "${CAROL_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice start-session "${CAROL_USER}" >/dev/null
+
+# Alice asks both friends the same poll.
+alice broadcast create friends "${BOB_USER}" "${CAROL_USER}" >/dev/null
+OUT="$(alice poll create --username "${ALICE_USER}" @friends "Lunch?" pizza sushi salad)"
+POLL_ID="$(sed -n 's/^Poll \([^ ]*\) sent.*/\1/p' <<<"${OUT}")"
+if [[ -z "${POLL_ID}" ]]; then
+  echo "[-] poll create printed no ID: ${OUT}"
+  exit 1
+fi
+
+# Each friend sees it and votes; Bob changes his mind.
+OUT="$(bob recv --username "${BOB_USER}")"
+if ! grep -q "\[poll ${POLL_ID}\] Lunch? 1) pizza 2) sushi 3) salad" <<<"${OUT}"; then
+  echo "[-] Bob did not receive the poll: ${OUT}"
+  exit 1
+fi
+carol recv --username "${CAROL_USER}" >/dev/null
+bob start-session "${ALICE_USER}" >/dev/null
+carol start-session "${ALICE_USER}" >/dev/null
+bob poll vote --username "${BOB_USER}" "${POLL_ID}" 1 >/dev/null
+bob poll vote --username "${BOB_USER}" "${POLL_ID}" 2 >/dev/null
+carol poll vote --username "${CAROL_USER}" "${POLL_ID}" 2 >/dev/null
+if bob poll vote --username "${BOB_USER}" "${POLL_ID}" 4 >/dev/null 2>&1; then
+  echo "[-] Bob voted for an option the poll does not have"
+  exit 1
+fi
+
+# Alice tallies every vote, counting Bob's latest only.
+alice recv --username "${ALICE_USER}" >/dev/null
+OUT="$(alice poll show "${POLL_ID}")"
+if ! grep -q "2) sushi: 2 (bob, carol)" <<<"${OUT}" || ! grep -q "1) pizza: 0" <<<"${OUT}"; then
+  echo "[-] Alice's tally is wrong: ${OUT}"
+  exit 1
+fi
+
+# Alice's own vote reaches both friends; each sees it next to their own.
+OUT="$(alice poll vote --username "${ALICE_USER}" "${POLL_ID}" 3)"
+if ! grep -q "Vote sent to bob, carol" <<<"${OUT}"; then
+  echo "[-] Alice's vote was not sent to every participant: ${OUT}"
+  exit 1
+fi
+bob recv --username "${BOB_USER}" >/dev/null
+OUT="$(bob history "${ALICE_USER}")"
+if ! grep -q "2) sushi: 1 (you)" <<<"${OUT}" || ! grep -q "3) salad: 1 (alice)" <<<"${OUT}"; then
+  echo "[-] Bob's history does not show the results: ${OUT}"
+  exit 1
+fi
+
+echo "[+] Polls were answered and tallied end to end."
@@ -0,0 +1,126 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Each one-time prekey is handed to one initiator only: two peers starting
+# sessions with Bob at once are given different keys, and the relay's bundle
+# shrinks by both. Only Bob sees his keys in a fetched bundle, and checking
+# them out in a loop takes at most the relay's --checkout-limit (4) an hour.
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-checkout-alice"
+BOB_HOME="/tmp/bob-ciphera-checkout-bob"
+CAROL_HOME="/tmp/carol-ciphera-checkout-carol"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+CAROL_PASS="Carol-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-prekey_checkout.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+
+# Start the relay with access logging
+"${RELAY_BIN}" --log >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Run ciphera as Alice, Bob or Carol
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+carol() {
+  "${CIPHERA_BIN}" --home "${CAROL_HOME}" --relay "${RELAY_URL}" --passphrase "${CAROL_PASS}" "$@"
+}
+# one_time_left prints how many one-time prekeys the relay last said Bob's
+# bundle had left.
+one_time_left() {
+  grep -E 'msg=(register|prekey_checkout) user=bob ' "${RELAY_LOG}" | tail -n 1 \
+    | grep -oE 'one_time_(count|left)=[0-9]+' | cut -d= -f2
+}
+
+alice init >/dev/null
+bob init >/dev/null
+carol init >/dev/null
+bob register bob >/dev/null
+alice register alice >/dev/null
+carol register carol >/dev/null
+BEFORE="$(one_time_left)"
+
+# Alice and Carol start sessions with Bob at the same time.
+alice start-session bob >/dev/null & ALICE_PID=$!
+carol start-session bob >/dev/null & CAROL_PID=$!
+wait "${ALICE_PID}"
+wait "${CAROL_PID}"
+
+AFTER="$(one_time_left)"
+if [[ "${AFTER}" != "$((BEFORE - 2))" ]]; then
+  echo "[-] Bob's bundle offers ${AFTER} one-time prekeys after two checkouts, want $((BEFORE - 2))"
+  exit 1
+fi
+OPKS="$(grep "prekey_checkout" "${RELAY_LOG}" | grep -o 'opk_id=[^ ]*' | sort -u)"
+if [[ "$(wc -l <<<"${OPKS}")" != "2" ]] || grep -q 'opk_id=$' <<<"${OPKS}"; then
+  echo "[-] Alice and Carol were not given two different one-time prekeys: ${OPKS}"
+  exit 1
+fi
+
+# Both handshakes complete.
+alice send -u alice bob "from alice" >/dev/null
+carol send -u carol bob "from carol" >/dev/null
+OUT="$(bob recv -u bob)"
+if ! grep -q "from alice" <<<"${OUT}" || ! grep -q "from carol" <<<"${OUT}"; then
+  echo "[-] Bob did not receive both messages: ${OUT}"
+  exit 1
+fi
+
+# A fetched bundle shows the one-time prekeys to no one but Bob.
+OUT="$(curl -sf "${RELAY_URL}/prekey/bob")"
+if [[ "$(jq '.one_time // [] | length' <<<"${OUT}")" != "0" ]]; then
+  echo "[-] An unsigned fetch listed Bob's one-time prekeys: ${OUT}"
+  exit 1
+fi
+
+# Checking out in a loop takes two more keys, the rest of this hour's four.
+for _ in {1..10}; do
+  curl -sf -X POST "${RELAY_URL}/prekey/bob/checkout" >/dev/null
+done
+DRAINED="$(grep -c 'msg=prekey_checkout user=bob .*throttled=false' "${RELAY_LOG}")"
+if [[ "${DRAINED}" != "4" ]]; then
+  echo "[-] ${DRAINED} of Bob's one-time prekeys were checked out in a burst, want 4"
+  exit 1
+fi
+if [[ "$(one_time_left)" != "$((BEFORE - 4))" ]]; then
+  echo "[-] Bob's bundle has $(one_time_left) one-time prekeys left after the burst, want $((BEFORE - 4))"
+  exit 1
+fi
+
+echo "[+] Each one-time prekey was handed out once, and no faster than the checkout limit."
@@ -0,0 +1,120 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-prekey-schedule-alice"
+BOB_HOME="/tmp/bob-ciphera-prekey-schedule-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-prekey-schedule.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/pprekey-schedule/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both; Alice starts the session.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+
+# A fresh registration holds ten one-time prekeys, each with its age, and
+# schedules nothing until the session policy asks for upkeep.
+OUT="$(bob prekeys status)"
+if ! grep -q "^One-time prekeys:   10 held, oldest generated" <<<"${OUT}" \
+  || ! grep -q "^Signed prekey:      .*, generated .* old)$" <<<"${OUT}" \
+  || ! grep -q "^Next refill check:  off" <<<"${OUT}"; then
+  echo "[-] Bob's fresh prekeys were not reported"
+  echo "${OUT}"
+  exit 1
+fi
+
+# The first upkeep counts the relay's prekeys and schedules the next count
+# and the signed prekey's rotation. With no prekeys to spare beyond the ten
+# asked for, the next count is an hour away.
+bob conversations session-policy --replenish-opks 10 --rotate-spk-days 30 >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+OUT="$(bob prekeys status)"
+if ! grep -q "^Next refill check:  .* (in 1h0m0s), refilling below 10 unused$" <<<"${OUT}" \
+  || ! grep -q "^Next rotation:      .* (in 720h0m0s), every 30 days$" <<<"${OUT}"; then
+  echo "[-] The upkeep schedule was not recorded"
+  echo "${OUT}"
+  exit 1
+fi
+
+# Alice's first message consumes one of them, which brings the count forward.
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello" >/dev/null
+OUT="$(bob recv --username "${BOB_USER}" 2>&1)"
+if ! grep -q "offers 9 unused one-time prekeys" <<<"${OUT}" || ! grep -q "Republished prekeys" <<<"${OUT}"; then
+  echo "[-] Consuming a one-time prekey did not bring the refill forward"
+  echo "${OUT}"
+  exit 1
+fi
+if ! grep -q "^Consumed by peers:  1, last " <<<"$(bob prekeys status)"; then
+  echo "[-] The consumed one-time prekey was not recorded"
+  exit 1
+fi
+
+# Upkeep that is not due never asks the relay, whatever the command.
+bob start-session "${ALICE_USER}" >/dev/null
+before="$(jq -c '.data.schedule' "${BOB_HOME}/prekey_meta.json")"
+bob send --username "${BOB_USER}" "${ALICE_USER}" "hi" >/dev/null
+if [[ "$(jq -c '.data.schedule' "${BOB_HOME}/prekey_meta.json")" != "${before}" ]]; then
+  echo "[-] Upkeep ran before it was due"
+  exit 1
+fi
+
+# Once the schedule comes due, even a send runs it and reschedules.
+jq -c '.data.schedule.next_check_utc = 1' "${BOB_HOME}/prekey_meta.json" >"${BOB_HOME}/meta.tmp"
+mv "${BOB_HOME}/meta.tmp" "${BOB_HOME}/prekey_meta.json"
+bob send --username "${BOB_USER}" "${ALICE_USER}" "again" >/dev/null
+if [[ "$(jq '.data.schedule.next_check_utc' "${BOB_HOME}/prekey_meta.json")" -le "$(date +%s)" ]]; then
+  echo "[-] A due refill check did not run after send"
+  jq '.data.schedule' "${BOB_HOME}/prekey_meta.json"
+  exit 1
+fi
+
+echo "[+] Prekey ages, consumption and the upkeep schedule were kept across runs."
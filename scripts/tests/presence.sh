@@ -0,0 +1,124 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-presence-alice"
+BOB_HOME="/tmp/bob-ciphera-presence-bob"
+CAROL_HOME="/tmp/carol-ciphera-presence-carol"
+ALICE_USER="alice"
+BOB_USER="bob"
+CAROL_USER="carol"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+CAROL_PASS="Carol-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-presence.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+carol() {
+  "${CIPHERA_BIN}" --home "${CAROL_HOME}" --relay "${RELAY_URL}" --passphrase "${CAROL_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+carol init >/dev/null
+carol register "${CAROL_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello bob" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+
+# Nothing is shown until Alice opts in.
+alice recv --username "${ALICE_USER}" >/dev/null
+OUT="$(bob presence show --username "${BOB_USER}" "${ALICE_USER}")"
+if [[ "${OUT}" != "${ALICE_USER}"$'\t'"not shown" ]]; then
+  echo "[-] Alice's presence was shown before she opted in: ${OUT}"
+  exit 1
+fi
+
+# Contacts only: Bob, whom Alice holds a session with, sees it; Carol does not.
+OUT="$(alice presence set contacts --username "${ALICE_USER}")"
+if [[ "${OUT}" != *"1 contact(s)"* ]]; then
+  echo "[-] Alice's contacts policy should list Bob: ${OUT}"
+  exit 1
+fi
+alice recv --username "${ALICE_USER}" >/dev/null
+OUT="$(bob presence show --username "${BOB_USER}")"
+if ! grep -q "^${ALICE_USER}"$'\t'"last seen $(date -u +%Y-%m-%d)" <<<"${OUT}"; then
+  echo "[-] Bob should see when Alice was last seen: ${OUT}"
+  exit 1
+fi
+OUT="$(carol presence show --username "${CAROL_USER}" "${ALICE_USER}")"
+if [[ "${OUT}" != *"not shown"* ]]; then
+  echo "[-] Carol saw Alice's presence: ${OUT}"
+  exit 1
+fi
+STATUS="$(curl -s -o /dev/null -w '%{http_code}' "${RELAY_URL}/presence/${ALICE_USER}")"
+if [[ "${STATUS}" != "404" ]]; then
+  echo "[-] anonymous presence request got ${STATUS}, want 404"
+  exit 1
+fi
+
+# Everyone: Carol and anonymous requests see it too.
+alice presence set everyone --username "${ALICE_USER}" >/dev/null
+OUT="$(carol presence show --username "${CAROL_USER}" "${ALICE_USER}")"
+if [[ "${OUT}" != *"last seen"* ]]; then
+  echo "[-] Carol should see Alice's presence once it is public: ${OUT}"
+  exit 1
+fi
+if ! curl -sf "${RELAY_URL}/presence/${ALICE_USER}" | jq -e '.last_seen_utc > 0' >/dev/null; then
+  echo "[-] anonymous presence request should show Alice's last activity"
+  exit 1
+fi
+
+# Nobody: the relay forgets the time.
+alice presence set nobody --username "${ALICE_USER}" >/dev/null
+OUT="$(bob presence show --username "${BOB_USER}" "${ALICE_USER}")"
+if [[ "${OUT}" != *"not shown"* ]]; then
+  echo "[-] Bob still saw Alice's presence after she opted out: ${OUT}"
+  exit 1
+fi
+
+echo "[+] Last-seen presence was shown only as the user's signed policy allowed."
@@ -0,0 +1,101 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-profiles-alice"
+BOB_HOME="/tmp/bob-ciphera-profiles-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-profiles.log"
+PHOTO="/tmp/ciphera-profiles-photo.png"
+SAVED="/tmp/ciphera-profiles-saved.png"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${PHOTO}" "${SAVED}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+
+# A PNG signature is all the photo check looks at.
+{ printf '\x89PNG\r\n\x1a\n'; head -c 512 /dev/urandom; } >"${PHOTO}"
+alice profile set --username "${ALICE_USER}" --name "Alice" --avatar "🐇" --photo "${PHOTO}" \
+  | grep -q "sent to 0 peer(s)"
+if alice profile set --username "${ALICE_USER}" --name $'\e[2JAlice' >/dev/null 2>&1; then
+  echo "[-] a display name with control characters was accepted"
+  exit 1
+fi
+
+# Alice's profile goes to Bob once he confirms the session.
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello bob" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+alice recv --username "${ALICE_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "second" >/dev/null
+OUT="$(bob recv --username "${BOB_USER}")"
+if ! grep -qF "[🐇 Alice (${ALICE_USER})] second" <<<"${OUT}"; then
+  echo "[-] Bob does not show Alice's profile: ${OUT}"
+  exit 1
+fi
+bob profile photo "${ALICE_USER}" -o "${SAVED}" >/dev/null
+cmp -s "${PHOTO}" "${SAVED}"
+
+# A change reaches peers with a confirmed session straight away.
+bob profile set --username "${BOB_USER}" --name "Bob" | grep -q "sent to 1 peer(s)"
+alice recv --username "${ALICE_USER}" >/dev/null
+if ! grep -qF -- "-> Bob (${BOB_USER})" <<<"$(alice history)"; then
+  echo "[-] Alice's history does not show Bob's profile"
+  exit 1
+fi
+
+alice profile clear --username "${ALICE_USER}" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+if ! grep -qF "${ALICE_USER}	name=\"\"	avatar=\"\"" <<<"$(bob profile show)"; then
+  echo "[-] Bob kept Alice's cleared profile: $(bob profile show)"
+  exit 1
+fi
+
+echo "[+] Profiles were sent on session confirmation and on change, and shown next to usernames."
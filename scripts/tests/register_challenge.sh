@@ -0,0 +1,108 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Registers against relays that challenge new usernames: a proof-of-work the
+# client solves by itself, and invitation tokens it must be given.
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-challenge-alice"
+BOB_HOME="/tmp/bob-ciphera-challenge-bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-register_challenge.log"
+
+stop_relay() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+    RELAY_PID=""
+  fi
+}
+
+cleanup() {
+  stop_relay
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Start a fresh relay with the given flags
+start_relay() {
+  "${RELAY_BIN}" "$@" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+  for _ in {1..50}; do
+    curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+    sleep 0.1
+  done
+}
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob, never interactively
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" --non-interactive "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" --non-interactive "$@"
+}
+
+alice init >/dev/null
+bob init >/dev/null
+
+# The relay refuses to start on a challenge it cannot check.
+if "${RELAY_BIN}" --register-challenge token >/dev/null 2>&1; then
+  echo "[-] Relay started with a token challenge and no tokens"
+  exit 1
+fi
+
+# Proof-of-work is solved without help, and a refresh is not challenged.
+start_relay --register-challenge pow --pow-bits 12
+alice register alice >/dev/null
+if ! grep -q "Registered prekeys" <<<"$(alice register alice)"; then
+  echo "[-] Re-registration under proof-of-work failed"
+  exit 1
+fi
+stop_relay
+
+# Tokens must be given: none fails without prompting, a wrong one is
+# rejected, and the right one registers.
+RELAY_REGISTER_TOKENS="first-invite,second-invite" start_relay --register-challenge token
+set +e
+OUT="$(bob register bob 2>&1)"
+RC=$?
+set -e
+if [[ ${RC} -ne 3 ]] || ! grep -q "registration token" <<<"${OUT}"; then
+  echo "[-] Register without a token did not ask for one (status ${RC}): ${OUT}"
+  exit 1
+fi
+if OUT="$(bob register bob --challenge-answer third-invite 2>&1)"; then
+  echo "[-] Register with a wrong token succeeded"
+  exit 1
+fi
+if ! grep -q "rejected the token" <<<"${OUT}"; then
+  echo "[-] Wrong token was not reported: ${OUT}"
+  exit 1
+fi
+bob register bob --challenge-answer second-invite >/dev/null
+if ! curl -sf "${RELAY_URL}/prekey/bob" >/dev/null; then
+  echo "[-] Bob's bundle was not published"
+  exit 1
+fi
+
+echo "[+] Registration challenges were answered and enforced."
@@ -0,0 +1,130 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Only a username's owner can replace its bundle: another identity is
+# refused, while the owner re-registers, before and after rotating the
+# signing key, without being asked anything.
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-owner-alice"
+BOB_HOME="/tmp/bob-ciphera-owner-bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-register_owner.log"
+
+stop_relay() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+    RELAY_PID=""
+  fi
+}
+
+cleanup() {
+  stop_relay
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Start a fresh relay with the given flags
+start_relay() {
+  "${RELAY_BIN}" "$@" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+  for _ in {1..50}; do
+    curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+    sleep 0.1
+  done
+}
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob (who will try to pass as Alice), never interactively
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" --non-interactive "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" --non-interactive "$@"
+}
+
+alice init >/dev/null
+bob init >/dev/null
+start_relay --log
+
+alice register alice >/dev/null 2>&1
+BEFORE="$(curl -sf "${RELAY_URL}/prekey/alice")"
+
+# Bob cannot take Alice's username over: not through the client, which
+# knows the name is taken, nor by posting his own bundle under her name.
+bob register bob >/dev/null 2>&1
+if OUT="$(bob register alice 2>&1)"; then
+  echo "[-] Bob replaced Alice's bundle: ${OUT}"
+  exit 1
+fi
+FORGED="$(curl -sf "${RELAY_URL}/prekey/bob" | jq -c '.username = "alice"')"
+STATUS="$(curl -s -o /dev/null -w '%{http_code}' -X POST -d "${FORGED}" "${RELAY_URL}/register")"
+if [[ "${STATUS}" != "428" ]]; then
+  echo "[-] Unsigned takeover got status ${STATUS}, want 428"
+  exit 1
+fi
+NONCE="$(curl -s -X POST -d "${FORGED}" "${RELAY_URL}/register" | jq -r '.challenge.nonce')"
+STATUS="$(curl -s -o /dev/null -w '%{http_code}' -X POST -d "${FORGED}" \
+  -H "X-Ciphera-Challenge: signature" -H "X-Ciphera-Challenge-Nonce: ${NONCE}" \
+  -H "X-Ciphera-Challenge-Answer: $(head -c 64 /dev/urandom | base64 -w0)" \
+  "${RELAY_URL}/register")"
+if [[ "${STATUS}" != "428" ]]; then
+  echo "[-] Takeover with a bad signature got status ${STATUS}, want 428"
+  exit 1
+fi
+if [[ "$(curl -sf "${RELAY_URL}/prekey/alice" | jq -r '.spk_id')" != "$(jq -r '.spk_id' <<<"${BEFORE}")" ]]; then
+  echo "[-] Alice's bundle changed after a refused registration"
+  exit 1
+fi
+if ! grep -q "register_refused" "${RELAY_LOG}"; then
+  echo "[-] Relay did not log the refused registration"
+  exit 1
+fi
+
+# Alice refreshes her prekeys without being asked for anything.
+if ! grep -q "Registered prekeys" <<<"$(alice register alice 2>/dev/null)"; then
+  echo "[-] Alice could not re-register"
+  exit 1
+fi
+if [[ "$(curl -sf "${RELAY_URL}/prekey/alice" | jq -r '.spk_id')" == "$(jq -r '.spk_id' <<<"${BEFORE}")" ]]; then
+  echo "[-] Alice's re-registration did not replace her bundle"
+  exit 1
+fi
+
+# A rotated signing key is accepted through the sign chain, and then signs
+# the updates that follow.
+alice rotate-signing-key >/dev/null 2>&1
+if ! grep -q "Registered prekeys" <<<"$(alice register alice 2>/dev/null)"; then
+  echo "[-] Alice could not re-register after rotating her signing key"
+  exit 1
+fi
+
+# Bob can still start a session with Alice under the bundle she kept.
+bob start-session alice >/dev/null
+bob send -u bob alice "owner check" >/dev/null
+if ! grep -q "owner check" <<<"$(alice recv -u alice)"; then
+  echo "[-] Alice did not receive Bob's message"
+  exit 1
+fi
+
+echo "[+] Only the owner could update a registered username."
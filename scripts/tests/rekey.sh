@@ -0,0 +1,122 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-rekey-alice"
+BOB_HOME="/tmp/bob-ciphera-rekey-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-rekey.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both; Alice starts the session and rekeys every two messages.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+if ! grep -qx "Rekey policy: days=0 messages=2" <<<"$(alice conversations rekey --messages 2)"; then
+  echo "[-] Rekey policy was not saved"
+  exit 1
+fi
+
+# No rekey happens until Bob has confirmed the first handshake.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "one" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "two" >/dev/null
+OUT="$(bob recv --username "${BOB_USER}")"
+if ! grep -qx "\[${ALICE_USER}\] one" <<<"${OUT}" || ! grep -qx "\[${ALICE_USER}\] two" <<<"${OUT}"; then
+  echo "[-] Bob missed messages before the rekey"
+  echo "${OUT}"
+  exit 1
+fi
+alice recv --username "${ALICE_USER}" >/dev/null
+if ! grep -q "confirmed.*rekeys=0" <<<"$(alice sessions)"; then
+  echo "[-] Session rekeyed too early"
+  alice sessions
+  exit 1
+fi
+
+# The third send rekeys first; Bob follows onto the new root and confirms it.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "three" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "four" >/dev/null
+if ! grep -q "pending.*rekeys=1" <<<"$(alice sessions)"; then
+  echo "[-] Alice did not rekey after two messages"
+  alice sessions
+  exit 1
+fi
+OUT="$(bob recv --username "${BOB_USER}")"
+if ! grep -qx "\[${ALICE_USER}\] three" <<<"${OUT}" || ! grep -qx "\[${ALICE_USER}\] four" <<<"${OUT}"; then
+  echo "[-] Bob missed messages after the rekey"
+  echo "${OUT}"
+  exit 1
+fi
+alice recv --username "${ALICE_USER}" >/dev/null
+if ! grep -q "confirmed.*rekeys=1" <<<"$(alice sessions)"; then
+  echo "[-] Bob did not confirm the new root"
+  alice sessions
+  exit 1
+fi
+
+# The next rekey must not reuse the one-time prekey the relay still lists.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "five" >/dev/null
+if ! grep -qx "\[${ALICE_USER}\] five" <<<"$(bob recv --username "${BOB_USER}")"; then
+  echo "[-] Bob could not decrypt after the second rekey"
+  exit 1
+fi
+alice recv --username "${ALICE_USER}" >/dev/null
+if ! grep -q "confirmed.*rekeys=2" <<<"$(alice sessions)"; then
+  echo "[-] Second rekey was not confirmed"
+  alice sessions
+  exit 1
+fi
+if ! grep -qx "Rekey policy: off" <<<"$(alice conversations rekey off)"; then
+  echo "[-] Rekey policy was not turned off"
+  exit 1
+fi
+
+echo "[+] Conversation moved to a fresh X3DH root and both sides kept talking."
@@ -0,0 +1,145 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-admin-alice"
+BOB_HOME="/tmp/bob-ciphera-admin-bob"
+DATA_DIR="/tmp/ciphera-relay-admin-data"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+ADMIN_TOKEN="admin-token-for-tests"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-relay-admin.log"
+
+stop_relay() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+    RELAY_PID=""
+  fi
+}
+cleanup() {
+  stop_relay
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${DATA_DIR}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start the relay with the admin API enabled and persistent storage.
+start_relay() {
+  RELAY_ADMIN_TOKEN="${ADMIN_TOKEN}" "${RELAY_BIN}" --data-dir "${DATA_DIR}" >>"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+  for _ in {1..50}; do
+    curl -s "${RELAY_URL}/healthz" >/dev/null 2>&1 && return 0
+    sleep 0.1
+  done
+  echo "[-] Relay did not start"
+  exit 1
+}
+
+# admin calls the admin API and prints the HTTP status.
+admin() {
+  local method="$1" path="$2" body="${3:-}"
+  curl -s -o /dev/null -w '%{http_code}' -X "${method}" \
+    -H "Authorization: Bearer ${ADMIN_TOKEN}" \
+    ${body:+-d "${body}"} "${RELAY_URL}${path}"
+}
+
+# Fresh homes and storage
+rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${DATA_DIR}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+: >"${RELAY_LOG}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+start_relay
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+
+# 1. The admin API needs the token.
+status="$(curl -s -o /dev/null -w '%{http_code}' "${RELAY_URL}/admin/restrictions")"
+if [[ "${status}" != "401" ]]; then
+  echo "[-] Admin API answered without a token (${status})"
+  exit 1
+fi
+echo "[+] Admin API requires the token"
+
+# 2. A suspended sender is refused with a clear error.
+admin PUT "/admin/users/${ALICE_USER}/restriction" '{"mode":"suspend","reason":"test"}' >/dev/null
+if ERR="$(alice send --username "${ALICE_USER}" "${BOB_USER}" "blocked" 2>&1)"; then
+  echo "[-] Suspended sender could send"
+  exit 1
+fi
+if ! grep -q "account suspended" <<<"${ERR}"; then
+  echo "[-] Suspension was not reported: ${ERR}"
+  exit 1
+fi
+echo "[+] Suspended sender refused"
+
+# 3. Restrictions survive a restart; a shadow-ban replaces the suspension and
+# drops messages while telling the sender they were sent.
+stop_relay
+start_relay
+if ! grep -q "\"user\":\"${ALICE_USER}\",\"mode\":\"suspend\"" <<<"$(curl -s -H "Authorization: Bearer ${ADMIN_TOKEN}" "${RELAY_URL}/admin/restrictions")"; then
+  echo "[-] Suspension lost across a restart"
+  exit 1
+fi
+admin PUT "/admin/users/${ALICE_USER}/restriction" '{"mode":"shadow_ban"}' >/dev/null
+if ! alice send --username "${ALICE_USER}" "${BOB_USER}" "dropped" >/dev/null; then
+  echo "[-] Shadow-banned sender saw an error"
+  exit 1
+fi
+if [[ -n "$(bob recv --username "${BOB_USER}")" ]]; then
+  echo "[-] Shadow-banned message was delivered"
+  exit 1
+fi
+echo "[+] Shadow-banned message accepted and dropped"
+
+# 4. Lifting the restriction restores delivery; an expired one is ignored.
+# The dropped message left Bob behind in the ratchet, which skipped keys cover.
+if [[ "$(admin DELETE "/admin/users/${ALICE_USER}/restriction")" != "204" ]]; then
+  echo "[-] Lift failed"
+  exit 1
+fi
+admin PUT "/admin/users/${BOB_USER}/restriction" '{"mode":"suspend","duration":"1s"}' >/dev/null
+sleep 2
+alice send --username "${ALICE_USER}" "${BOB_USER}" "back" >/dev/null
+if ! grep -qx "\[${ALICE_USER}\] back" <<<"$(bob recv --username "${BOB_USER}")"; then
+  echo "[-] Delivery did not resume after lift and expiry"
+  exit 1
+fi
+echo "[+] Lifted and expired restrictions no longer apply"
+
+# 5. Every admin action is audited.
+for action in denied suspend shadow_ban lift list; do
+  if ! grep -q "admin_audit.*action=${action}" "${RELAY_LOG}"; then
+    echo "[-] No audit line for ${action}"
+    exit 1
+  fi
+done
+echo "[+] Relay admin suspension and shadow-ban work."
@@ -0,0 +1,98 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-relay-journal-alice"
+BOB_HOME="/tmp/bob-ciphera-relay-journal-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-journal.log"
+DATA_DIR="/tmp/ciphera-relay-journal-data"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${DATA_DIR}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay with persistent storage, which keeps the journal.
+rm -rf "${DATA_DIR}"
+"${RELAY_BIN}" --data-dir "${DATA_DIR}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/healthz" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello bob" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "again" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+
+# Both envelopes were queued, fetched and acked, and nothing is amiss.
+OUT="$(bob journal --username "${BOB_USER}" --events)"
+if ! grep -q "^6 events: 2 queued, 2 fetched, 2 acked, 0 dropped or expired$" <<<"${OUT}" \
+  || ! grep -qx "No problems found" <<<"${OUT}"; then
+  echo "[-] Bob's journal should show two envelopes queued, fetched and acked: ${OUT}"
+  exit 1
+fi
+
+# Only the account's own signing key can read its journal.
+STATUS="$(curl -s -o /dev/null -w '%{http_code}' "${RELAY_URL}/account/${BOB_USER}/journal")"
+if [[ "${STATUS}" != "401" ]]; then
+  echo "[-] unsigned journal request got ${STATUS}, want 401"
+  exit 1
+fi
+if alice journal --username "${BOB_USER}" >/dev/null 2>&1; then
+  echo "[-] Alice read Bob's journal"
+  exit 1
+fi
+
+# A relay that hides an envelope's fetch leaves a gap the audit reports.
+JOURNAL="${DATA_DIR}/journal/$(printf '%s' "${BOB_USER}" | od -An -tx1 | tr -d ' \n').jsonl"
+sed -i '/"kind":"fetch"/{x;/^$/{x;h;d};x}' "${JOURNAL}"
+if OUT="$(bob journal --username "${BOB_USER}" 2>&1)"; then
+  echo "[-] Audit of a journal with a missing event succeeded: ${OUT}"
+  exit 1
+fi
+if ! grep -q "^! event 4, envelope .*: event 3 is missing from the journal" <<<"${OUT}"; then
+  echo "[-] Audit did not report the missing event: ${OUT}"
+  exit 1
+fi
+
+echo "[+] Relay journal audited by its owner, and a removed event was reported."
@@ -0,0 +1,155 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# Moves a relay kept in memory to one with a sealed data directory without
+# losing queued messages, and checks snapshot files on the way.
+
+OLD_URL="http://127.0.0.1:8080"
+NEW_URL="http://127.0.0.1:8081"
+ALICE_HOME="/tmp/alice-ciphera-migrate-alice"
+BOB_HOME="/tmp/bob-ciphera-migrate-bob"
+DATA_DIR="/tmp/ciphera-relay-migrate-data"
+SNAPSHOT="/tmp/ciphera-relay-migrate-snapshot.json"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+export RELAY_ADMIN_TOKEN="migrate-t0ken"
+export RELAY_STORAGE_KEY="correct horse battery staple"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-relay-migrate.log"
+
+stop_relays() {
+  for pid in "${OLD_PID:-}" "${NEW_PID:-}"; do
+    if [[ -n "${pid}" ]]; then
+      kill "${pid}" >/dev/null 2>&1 || true
+      wait "${pid}" >/dev/null 2>&1 || true
+    fi
+  done
+  OLD_PID=""
+  NEW_PID=""
+}
+cleanup() {
+  stop_relays
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${DATA_DIR}" "${SNAPSHOT}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# wait_relay waits for the relay at $1 to answer.
+wait_relay() {
+  for _ in {1..50}; do
+    curl -s "$1/healthz" >/dev/null 2>&1 && return 0
+    sleep 0.1
+  done
+  echo "[-] Relay at $1 did not start"
+  exit 1
+}
+
+# Fresh homes and storage
+rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${DATA_DIR}" "${SNAPSHOT}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+: >"${RELAY_LOG}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# The old relay keeps everything in memory.
+"${RELAY_BIN}" --port 8080 >>"${RELAY_LOG}" 2>&1 & OLD_PID=$!
+wait_relay "${OLD_URL}"
+alice init >/dev/null
+alice register --relay "${OLD_URL}" "${ALICE_USER}" >/dev/null 2>&1
+bob init >/dev/null
+bob register --relay "${OLD_URL}" "${BOB_USER}" >/dev/null 2>&1
+alice start-session --relay "${OLD_URL}" "${BOB_USER}" >/dev/null
+alice send --relay "${OLD_URL}" --username "${ALICE_USER}" "${BOB_USER}" "before the move" >/dev/null
+
+# Migration needs the admin token.
+if RELAY_ADMIN_TOKEN="wrong" "${RELAY_BIN}" migrate --from "${OLD_URL}" --to "${SNAPSHOT}" >/dev/null 2>&1; then
+  echo "[-] Export succeeded with a wrong admin token"
+  exit 1
+fi
+
+# Export to a file, then check a tampered copy is refused.
+OUT="$("${RELAY_BIN}" migrate --from "${OLD_URL}" --to "${SNAPSHOT}")"
+if ! grep -q "bundles=2 queued=1" <<<"${OUT}"; then
+  echo "[-] Export did not report the relay's state: ${OUT}"
+  exit 1
+fi
+if [[ "$(stat -c %a "${SNAPSHOT}")" != "600" ]]; then
+  echo "[-] Snapshot file is readable by others"
+  exit 1
+fi
+if "${RELAY_BIN}" migrate --from "${OLD_URL}" --to "${SNAPSHOT}" >/dev/null 2>&1; then
+  echo "[-] Export overwrote an existing snapshot file"
+  exit 1
+fi
+TAMPERED="${SNAPSHOT}.tampered"
+jq -c '.next_seq = 0' "${SNAPSHOT}" >"${TAMPERED}"
+if OUT="$("${RELAY_BIN}" migrate --from "${TAMPERED}" --to "${NEW_URL}" 2>&1)"; then
+  echo "[-] A tampered snapshot was accepted"
+  exit 1
+fi
+rm -f "${TAMPERED}"
+if ! grep -q "digest does not match" <<<"${OUT}"; then
+  echo "[-] Tampering was not reported: ${OUT}"
+  exit 1
+fi
+
+# The new relay seals its queues on disk. Migrate straight into it.
+"${RELAY_BIN}" --port 8081 --data-dir "${DATA_DIR}" >>"${RELAY_LOG}" 2>&1 & NEW_PID=$!
+wait_relay "${NEW_URL}"
+OUT="$("${RELAY_BIN}" migrate --from "${OLD_URL}" --to "${NEW_URL}")"
+if ! grep -q "its state matches" <<<"${OUT}"; then
+  echo "[-] Import did not confirm the new relay's state: ${OUT}"
+  exit 1
+fi
+if grep -q "before the move\|\"from\":\"${ALICE_USER}\"" "${DATA_DIR}/state.log"; then
+  echo "[-] Imported envelopes are not sealed on disk"
+  exit 1
+fi
+
+# A relay already holding data refuses a second import.
+if OUT="$("${RELAY_BIN}" migrate --from "${SNAPSHOT}" --to "${NEW_URL}" 2>&1)"; then
+  echo "[-] A second import was accepted"
+  exit 1
+fi
+if ! grep -q "not_empty" <<<"${OUT}"; then
+  echo "[-] Second import was not refused as not empty: ${OUT}"
+  exit 1
+fi
+
+# The new relay takes over the old one's address, and clients carry on.
+stop_relays
+"${RELAY_BIN}" --port 8080 --data-dir "${DATA_DIR}" >>"${RELAY_LOG}" 2>&1 & NEW_PID=$!
+wait_relay "${OLD_URL}"
+if ! grep -q "before the move" <<<"$(bob recv --relay "${OLD_URL}" --username "${BOB_USER}")"; then
+  echo "[-] Bob did not receive the message queued before the move"
+  exit 1
+fi
+alice send --relay "${OLD_URL}" --username "${ALICE_USER}" "${BOB_USER}" "after the move" >/dev/null
+if ! grep -q "after the move" <<<"$(bob recv --relay "${OLD_URL}" --username "${BOB_USER}")"; then
+  echo "[-] Bob did not receive a message sent after the move"
+  exit 1
+fi
+
+echo "[+] Relay state moved between backends without losing queued messages."
@@ -0,0 +1,129 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-relay-notice-alice"
+ALICE_USER="alice"
+ALICE_PASS="Alice-pass1234"
+ADMIN_TOKEN="notice-admin-token"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-relay-notice.log"
+
+# A client stamped with a released version, so the relay's minimum applies.
+OLD_BIN="/tmp/ciphera-relay-notice-v1.0.0"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${OLD_BIN}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+  go build -ldflags "-X ciphera/internal/buildinfo.Version=v1.0.0" -o "${OLD_BIN}" ./cmd/ciphera
+)
+
+# Start relay, announcing maintenance next year and a message of the day.
+NEXT_YEAR="$(( $(date -u +%Y) + 1 ))"
+RELAY_ADMIN_TOKEN="${ADMIN_TOKEN}" "${RELAY_BIN}" \
+  --maintenance-start "${NEXT_YEAR}-01-02T22:00:00Z" \
+  --maintenance-end "${NEXT_YEAR}-01-02T23:00:00Z" \
+  --motd "Welcome to the test relay" \
+  --min-client-version v1.2.0 \
+  >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}"
+mkdir -p "${ALICE_HOME}"
+
+# Run ciphera as Alice, with the current build or the old one
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+old_alice() {
+  "${OLD_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+
+alice init >/dev/null
+
+# Relay commands show the maintenance window and the message of the day; a
+# development build is never held to the minimum version.
+OUT="$(alice register "${ALICE_USER}" 2>&1)"
+if ! grep -q "relay maintenance scheduled from ${NEXT_YEAR}-01-02T22:00:00Z to ${NEXT_YEAR}-01-02T23:00:00Z" <<<"${OUT}" \
+  || ! grep -q "relay: Welcome to the test relay" <<<"${OUT}" || grep -q "older than" <<<"${OUT}"; then
+  echo "[-] register did not show the relay's notice"
+  echo "${OUT}"
+  exit 1
+fi
+
+# Local commands do not ask the relay.
+if alice fingerprint 2>&1 | grep -q "relay:"; then
+  echo "[-] a local command showed the relay's notice"
+  exit 1
+fi
+
+# version --server lists the notice and the minimum client version.
+OUT="$(alice version --server 2>&1)"
+if ! grep -q "notice: .*relay: Welcome to the test relay" <<<"${OUT}" || ! grep -q "min client: *v1.2.0 (warn)" <<<"${OUT}"; then
+  echo "[-] ciphera version --server did not show the relay's notice"
+  echo "${OUT}"
+  exit 1
+fi
+
+# An older client is warned but carries on.
+if ! OUT="$(old_alice recv --username "${ALICE_USER}" 2>&1)"; then
+  echo "[-] recv with an old client failed under a warning gate"
+  echo "${OUT}"
+  exit 1
+fi
+if ! grep -q "warning: ciphera v1.0.0 is older than v1.2.0" <<<"${OUT}"; then
+  echo "[-] recv with an old client was not warned"
+  echo "${OUT}"
+  exit 1
+fi
+
+# The operator blocks old clients and ends the maintenance announcement
+# without a restart; older clients then stop before talking to the relay.
+STATUS="$(curl -s -o /dev/null -w '%{http_code}' -X PUT \
+  -H "Authorization: Bearer ${ADMIN_TOKEN}" \
+  -d '{"min_client_version":"v1.2.0","client_gate":"block"}' \
+  "${RELAY_URL}/admin/notice")"
+if [[ "${STATUS}" != "200" ]]; then
+  echo "[-] PUT /admin/notice answered ${STATUS}"
+  exit 1
+fi
+if OUT="$(old_alice recv --username "${ALICE_USER}" 2>&1)"; then
+  echo "[-] recv with an old client succeeded under a blocking gate"
+  echo "${OUT}"
+  exit 1
+fi
+if ! grep -q "oldest version this relay supports; upgrade to continue" <<<"${OUT}" || grep -q "Welcome" <<<"${OUT}"; then
+  echo "[-] recv with an old client did not explain why it stopped"
+  echo "${OUT}"
+  exit 1
+fi
+if ! OUT="$(alice recv --username "${ALICE_USER}" 2>&1)"; then
+  echo "[-] recv with a development build failed under a blocking gate"
+  echo "${OUT}"
+  exit 1
+fi
+
+echo "[+] Relay notices reach clients, and clients older than the relay's minimum are warned or blocked."
@@ -0,0 +1,158 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-persist-alice"
+BOB_HOME="/tmp/bob-ciphera-persist-bob"
+DATA_DIR="/tmp/ciphera-relay-persist-data"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-relay-persistence.log"
+
+stop_relay() {
+  local sig="${1:-TERM}"
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "-${sig}" "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+    RELAY_PID=""
+  fi
+}
+cleanup() {
+  stop_relay
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${DATA_DIR}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start the relay on the data directory, with any extra flags.
+start_relay() {
+  "${RELAY_BIN}" --data-dir "${DATA_DIR}" "$@" >>"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+  for _ in {1..50}; do
+    curl -s "${RELAY_URL}/healthz" >/dev/null 2>&1 && return 0
+    sleep 0.1
+  done
+  echo "[-] Relay did not start"
+  exit 1
+}
+
+# Fresh homes and storage
+rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${DATA_DIR}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+: >"${RELAY_LOG}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# expect_recv checks that Bob receives exactly the listed messages.
+expect_recv() {
+  local out want
+  out="$(bob recv --username "${BOB_USER}")"
+  want="$(printf "[${ALICE_USER}] %s\n" "$@")"
+  if [[ "${out}" != "${want}" ]]; then
+    echo "[-] Bob received:"
+    echo "${out}"
+    echo "[-] expected:"
+    echo "${want}"
+    exit 1
+  fi
+}
+
+start_relay
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "m1" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "m2" >/dev/null
+
+# 1. Bundles and queued envelopes survive a restart.
+stop_relay
+start_relay
+expect_recv m1 m2
+echo "[+] Queued messages survived a restart"
+
+# 2. A record torn by a crash mid-write is dropped without --repair.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "m3" >/dev/null
+stop_relay KILL
+printf '0badc0de {"op":"enq' >>"${DATA_DIR}/state.log"
+start_relay
+expect_recv m3
+echo "[+] Torn final record recovered"
+
+# 3. A corrupt record in the middle of the log stops the relay from starting
+# until --repair drops it; later records are kept.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "m4" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "m5" >/dev/null
+stop_relay
+lines="$(wc -l <"${DATA_DIR}/state.log")"
+sed -i "$((lines - 1))s/\"op\"/\"OP\"/" "${DATA_DIR}/state.log"
+
+set +e
+timeout 5 "${RELAY_BIN}" --data-dir "${DATA_DIR}" >>"${RELAY_LOG}" 2>&1
+status=$?
+set -e
+if [[ ${status} -ne 1 ]]; then
+  echo "[-] Relay started on a corrupt log (exit ${status})"
+  exit 1
+fi
+if ! grep -q "restart with --repair" "${RELAY_LOG}"; then
+  echo "[-] Relay did not explain how to recover"
+  exit 1
+fi
+
+start_relay --repair
+expect_recv m5
+if ! grep -q "Storage loaded.*corrupt=1.*fixed=true" "${RELAY_LOG}"; then
+  echo "[-] Repair was not reported"
+  exit 1
+fi
+echo "[+] Corrupt record reported and repaired"
+
+# 4. A storage key seals envelopes already queued in the clear, the relay
+# refuses to start with a different key, and the right key opens them.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "m6" >/dev/null
+stop_relay
+RELAY_STORAGE_KEY="s3cret-storage-key" start_relay
+alice send --username "${ALICE_USER}" "${BOB_USER}" "m7" >/dev/null
+stop_relay
+if grep -q '"from"' "${DATA_DIR}/state.log"; then
+  echo "[-] Queued envelopes were stored in the clear"
+  exit 1
+fi
+
+set +e
+RELAY_STORAGE_KEY="wrong-key" timeout 5 "${RELAY_BIN}" --data-dir "${DATA_DIR}" --repair >>"${RELAY_LOG}" 2>&1
+status=$?
+set -e
+if [[ ${status} -ne 1 ]]; then
+  echo "[-] Relay started with the wrong storage key (exit ${status})"
+  exit 1
+fi
+
+RELAY_STORAGE_KEY="s3cret-storage-key" start_relay
+expect_recv m6 m7
+echo "[+] Queued envelopes sealed with the storage key"
+
+echo "[+] Relay storage persists and recovers."
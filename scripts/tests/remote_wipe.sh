@@ -0,0 +1,112 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-wipe-alice"
+BOB_HOME="/tmp/bob-ciphera-wipe-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-remote-wipe.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both peers
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+
+# Alice starts the conversation. Bob also fetches Alice's bundle, so he
+# holds her signing key and can verify her wipe request.
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+bob start-session "${ALICE_USER}" >/dev/null
+bob send --username "${BOB_USER}" "${ALICE_USER}" "hi" >/dev/null
+alice recv --username "${ALICE_USER}" >/dev/null
+
+# Bob has not opted in, so the first request is refused and nothing is lost.
+alice wipe --username "${ALICE_USER}" "${BOB_USER}" >/dev/null
+BOB_OUT="$(bob recv --username "${BOB_USER}")"
+ALICE_OUT="$(alice recv --username "${ALICE_USER}")"
+if ! grep -q "wipe request refused" <<<"${BOB_OUT}" \
+  || ! grep -q "peer refused the wipe request" <<<"${ALICE_OUT}"; then
+  echo "[-] Refused wipe was not reported on both sides"
+  exit 1
+fi
+if ! grep -q "hello" <<<"$(bob history "${ALICE_USER}")"; then
+  echo "[-] Bob lost history after refusing a wipe"
+  exit 1
+fi
+
+# After Bob opts in, the request wipes both sides.
+bob conversations remote-wipe "${ALICE_USER}" accept >/dev/null
+alice wipe --username "${ALICE_USER}" "${BOB_USER}" >/dev/null
+BOB_OUT="$(bob recv --username "${BOB_USER}")"
+ALICE_OUT="$(alice recv --username "${ALICE_USER}")"
+if ! grep -q "conversation wiped" <<<"${BOB_OUT}" \
+  || ! grep -q "peer wiped the conversation" <<<"${ALICE_OUT}"; then
+  echo "[-] Wipe was not confirmed on both sides"
+  exit 1
+fi
+for who in alice bob; do
+  if [[ "$(${who} history)" != "No history" ]] || [[ "$(${who} sessions)" != "No sessions" ]]; then
+    echo "[-] ${who} still holds conversation data after the wipe"
+    exit 1
+  fi
+done
+
+# A fresh session works once Bob publishes new one-time prekeys.
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "again" >/dev/null
+if ! grep -qx "\[${ALICE_USER}\] again" <<<"$(bob recv --username "${BOB_USER}")"; then
+  echo "[-] New session after the wipe failed"
+  exit 1
+fi
+
+echo "[+] Remote wipe refused without opt-in and wiped both sides with it."
@@ -0,0 +1,108 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-resend-alice"
+BOB_HOME="/tmp/bob-ciphera-resend-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-resend.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${BOB_HOME}.copy"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${BOB_HOME}.copy"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello bob" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+alice recv --username "${ALICE_USER}" >/dev/null
+
+# A copy of Bob's home takes the next message off the relay, so Bob never
+# sees it.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "lost message" >/dev/null
+cp -a "${BOB_HOME}" "${BOB_HOME}.copy"
+"${CIPHERA_BIN}" --home "${BOB_HOME}.copy" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" \
+  recv --username "${BOB_USER}" >/dev/null
+rm -rf "${BOB_HOME}.copy"
+
+alice send --username "${ALICE_USER}" "${BOB_USER}" "after the gap" >/dev/null
+OUT="$(bob recv --username "${BOB_USER}")"
+if ! grep -q "after the gap" <<<"${OUT}" || grep -q "lost message" <<<"${OUT}"; then
+  echo "[-] Bob should only have the message after the gap: ${OUT}"
+  exit 1
+fi
+
+# Off by default: the gap stays open.
+sleep 2
+bob recv --username "${BOB_USER}" >/dev/null
+alice recv --username "${ALICE_USER}" >/dev/null
+if bob recv --username "${BOB_USER}" | grep -q "lost message"; then
+  echo "[-] a message was resent without resend requests on"
+  exit 1
+fi
+
+bob conversations resend --after 1s | grep -q "Resend requests: after 1s"
+bob recv --username "${BOB_USER}" >/dev/null # asks Alice for the missing message
+alice recv --username "${ALICE_USER}" >/dev/null # posts it again
+OUT="$(bob recv --username "${BOB_USER}")"
+if ! grep -q "lost message" <<<"${OUT}"; then
+  echo "[-] Bob did not get the resent message: ${OUT}"
+  exit 1
+fi
+
+# The gap is closed, so nothing more is asked for.
+sleep 2
+bob recv --username "${BOB_USER}" >/dev/null
+alice recv --username "${ALICE_USER}" >/dev/null
+if bob recv --username "${BOB_USER}" | grep -q "lost message"; then
+  echo "[-] the message was resent after the gap closed"
+  exit 1
+fi
+bob conversations resend off | grep -q "Resend requests: off"
+
+echo "[+] A lost message was resent on request and the gap closed."
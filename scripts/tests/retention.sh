@@ -0,0 +1,111 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-retention-alice"
+BOB_HOME="/tmp/bob-ciphera-retention-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-retention.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/pretention/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both; Alice starts the session.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+
+# A per-peer limit keeps only the newest messages.
+if ! grep -q "retention=last=2$" <<<"$(alice conversations retention "${BOB_USER}" --last 2)"; then
+  echo "[-] Per-peer retention was not saved"
+  exit 1
+fi
+for m in one two three; do
+  alice send --username "${ALICE_USER}" "${BOB_USER}" "${m}" >/dev/null
+done
+OUT="$(alice history "${BOB_USER}")"
+if [[ "$(wc -l <<<"${OUT}")" -ne 2 ]] || grep -q " one$" <<<"${OUT}" || ! grep -q " three$" <<<"${OUT}"; then
+  echo "[-] Alice's history was not trimmed to the last two messages"
+  echo "${OUT}"
+  exit 1
+fi
+
+# Keeping nothing hides what is stored, writes nothing new and prunes the rest.
+alice conversations retention "${BOB_USER}" --none >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "four" >/dev/null
+if ! grep -qx "No history" <<<"$(alice history "${BOB_USER}")"; then
+  echo "[-] History is shown for a conversation that keeps nothing"
+  exit 1
+fi
+if ! grep -qx "Removed 2 message(s)" <<<"$(alice history prune)"; then
+  echo "[-] Prune did not remove the stored messages"
+  exit 1
+fi
+
+# The default applies to imports too: messages older than a day are dropped.
+if ! grep -qx "Default retention: days=1" <<<"$(bob conversations default-retention --days 1)"; then
+  echo "[-] Default retention was not saved"
+  exit 1
+fi
+bob recv --username "${BOB_USER}" >/dev/null
+bob history import --format json - >/dev/null <<<'[{"peer":"alice","direction":"in","time":"2020-01-01T00:00:00Z","text":"ancient"}]'
+OUT="$(bob history "${ALICE_USER}")"
+if grep -q "ancient" <<<"${OUT}" || [[ "$(wc -l <<<"${OUT}")" -ne 4 ]]; then
+  echo "[-] Bob's history does not match the default retention"
+  echo "${OUT}"
+  exit 1
+fi
+
+# Per-peer --default falls back to the global policy.
+if ! grep -q "retention=default$" <<<"$(alice conversations retention "${BOB_USER}" --default)"; then
+  echo "[-] Per-peer retention was not cleared"
+  exit 1
+fi
+
+echo "[+] History kept to the retention limits on write, on import and on prune."
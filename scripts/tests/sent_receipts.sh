@@ -0,0 +1,94 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-sent-alice"
+BOB_HOME="/tmp/bob-ciphera-sent-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-sent_receipts.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise, register and start a session
+alice init >/dev/null
+bob init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+
+if ! grep -q "No sent messages" <<<"$(alice sent --username "${ALICE_USER}" "${BOB_USER}")"; then
+  echo "[-] Outbox not empty before sending"
+  exit 1
+fi
+
+# Both messages are accepted with sequence numbers and still queued.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "first" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "second" >/dev/null
+SENT_OUT="$(alice sent --username "${ALICE_USER}" "${BOB_USER}")"
+if [[ "$(grep -c $'seq=[0-9]*\tqueued' <<<"${SENT_OUT}")" != 2 ]]; then
+  echo "[-] Expected two queued messages with sequence numbers: ${SENT_OUT}"
+  exit 1
+fi
+
+# Once Bob fetches them, both show as fetched.
+bob recv --username "${BOB_USER}" >/dev/null
+SENT_OUT="$(alice sent --username "${ALICE_USER}" "${BOB_USER}")"
+if [[ "$(grep -c $'\tfetched\t' <<<"${SENT_OUT}")" != 2 ]]; then
+  echo "[-] Expected two fetched messages: ${SENT_OUT}"
+  exit 1
+fi
+
+# A later message is queued again while the earlier ones stay fetched.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "third" >/dev/null
+SENT_OUT="$(alice sent --username "${ALICE_USER}" "${BOB_USER}" -n 1)"
+if ! grep -q $'\tqueued\t' <<<"${SENT_OUT}"; then
+  echo "[-] The newest message is not queued: ${SENT_OUT}"
+  exit 1
+fi
+
+echo "[+] The outbox records relay sequence numbers and shows which messages the peer fetched."
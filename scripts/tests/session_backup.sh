@@ -0,0 +1,115 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-session-backup-alice"
+BOB_HOME="/tmp/bob-ciphera-session-backup-bob"
+ALICE2_HOME="/tmp/alice2-ciphera-session-backup-alice2"
+MALLORY_HOME="/tmp/mallory-ciphera-session-backup-mallory"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE2_USER="alice"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+ALICE2_PASS="Alice-pass1234"
+MALLORY_PASS="Mallory-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-session-backup.log"
+EXPORT_FILE="/tmp/ciphera-session-backup.export"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${ALICE2_HOME}" "${MALLORY_HOME}" "${EXPORT_FILE}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${ALICE2_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}" "${ALICE2_HOME}"
+
+# Run ciphera as Alice, Bob or Alice on a second machine
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+alice2() {
+  "${CIPHERA_BIN}" --home "${ALICE2_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE2_PASS}" "$@"
+}
+
+# Alice opens a conversation with Bob.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+
+# Move the conversation to a second machine that holds the same identity.
+cp "${ALICE_HOME}/identity.json.enc" "${ALICE2_HOME}/"
+alice sessions export "${BOB_USER}" -o "${EXPORT_FILE}" --backup-passphrase "move-pass" --remove 2>/dev/null
+if grep -q "${BOB_USER}" <<<"$(alice sessions)"; then
+  echo "[-] Export with --remove left the conversation in place"
+  exit 1
+fi
+if alice2 sessions import "${EXPORT_FILE}" --backup-passphrase "wrong-pass" >/dev/null 2>&1; then
+  echo "[-] Import succeeded with the wrong backup passphrase"
+  exit 1
+fi
+alice2 sessions import "${EXPORT_FILE}" --backup-passphrase "move-pass" >/dev/null
+
+# The conversation carries on from the new machine.
+alice2 send --username "${ALICE_USER}" "${BOB_USER}" "from the new machine" >/dev/null
+if ! grep -qx "\[${ALICE_USER}\] from the new machine" <<<"$(bob recv --username "${BOB_USER}")"; then
+  echo "[-] Bob could not decrypt a message sent after the import"
+  exit 1
+fi
+alice2 send --username "${ALICE_USER}" "${BOB_USER}" "and again" >/dev/null
+if ! grep -qx "\[${ALICE_USER}\] and again" <<<"$(bob recv --username "${BOB_USER}")"; then
+  echo "[-] Bob could not decrypt a second message after the import"
+  exit 1
+fi
+
+# Importing over the live conversation needs --replace.
+if alice2 sessions import "${EXPORT_FILE}" --backup-passphrase "move-pass" >/dev/null 2>&1; then
+  echo "[-] Import overwrote an existing conversation without --replace"
+  exit 1
+fi
+
+# An export only imports into the identity it was made for.
+mkdir -p "${MALLORY_HOME}"
+"${CIPHERA_BIN}" --home "${MALLORY_HOME}" --passphrase "${MALLORY_PASS}" init >/dev/null
+if "${CIPHERA_BIN}" --home "${MALLORY_HOME}" --passphrase "${MALLORY_PASS}" \
+  sessions import "${EXPORT_FILE}" --backup-passphrase "move-pass" >/dev/null 2>&1; then
+  echo "[-] Export was imported into a different identity"
+  exit 1
+fi
+
+echo "[+] Conversation moved between machines and kept working."
@@ -0,0 +1,145 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-session-policy-alice"
+BOB_HOME="/tmp/bob-ciphera-session-policy-bob"
+CAROL_HOME="/tmp/carol-ciphera-session-policy-carol"
+ALICE_USER="alice"
+BOB_USER="bob"
+CAROL_USER="carol"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+CAROL_PASS="Carol-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-session-policy.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}" "${CAROL_HOME}"
+
+# Run ciphera as Alice, Bob or Carol
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+carol() {
+  "${CIPHERA_BIN}" --home "${CAROL_HOME}" --relay "${RELAY_URL}" --passphrase "${CAROL_PASS}" "$@"
+}
+
+# Initialise and register everyone. Bob asks before accepting new sessions,
+# wants ten unused one-time prekeys on the relay and verifies before replying.
+for who in alice bob carol; do
+  "${who}" init >/dev/null
+done
+alice register "${ALICE_USER}" >/dev/null
+bob register "${BOB_USER}" >/dev/null
+carol register "${CAROL_USER}" >/dev/null
+OUT="$(bob conversations session-policy --accept-new ask --replenish-opks 10 --verify-before-reply on)"
+if ! grep -qx "Session policy: accept-new=ask replenish-opks=10 rotate-spk-days=0 verify-before-reply=on" <<<"${OUT}"; then
+  echo "[-] Session policy was not saved"
+  echo "${OUT}"
+  exit 1
+fi
+
+# Alice's new session is quarantined until Bob accepts it.
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello bob" >/dev/null
+if OUT="$(bob recv --username "${BOB_USER}" 2>&1)"; then
+  echo "[-] recv did not report the pending session"
+  exit 1
+fi
+if ! grep -q "new session awaits acceptance: \[${ALICE_USER}\]" <<<"${OUT}" || grep -q "hello bob" <<<"${OUT}"; then
+  echo "[-] Alice's session was not held back"
+  echo "${OUT}"
+  exit 1
+fi
+
+# Alice's session took one of Bob's ten one-time prekeys off the relay, so
+# that recv republished.
+if ! grep -q "Republished prekeys to relay ${RELAY_URL}" <<<"${OUT}" || ! grep -q "offers 9 unused one-time prekeys" <<<"${OUT}"; then
+  echo "[-] Bob's one-time prekeys were not replenished"
+  echo "${OUT}"
+  exit 1
+fi
+if ! grep -q "new session awaits acceptance" <<<"$(bob quarantine list)"; then
+  echo "[-] Alice's message was not quarantined"
+  exit 1
+fi
+if ! grep -q "new-session=accept" <<<"$(bob conversations new-session "${ALICE_USER}" accept 2>/dev/null)"; then
+  echo "[-] Bob could not accept Alice"
+  exit 1
+fi
+if ! grep -qx "\[${ALICE_USER}\] hello bob" <<<"$(bob quarantine retry --username "${BOB_USER}")"; then
+  echo "[-] Alice's message did not open once accepted"
+  exit 1
+fi
+
+# Using the key Alice took leaves Bob enough, so nothing more is due.
+if grep -q "Republished" <<<"$(bob recv --username "${BOB_USER}" 2>&1)"; then
+  echo "[-] Bob republished prekeys that were not due"
+  exit 1
+fi
+
+# Alice started the conversation, so Bob may not reply until he verifies her.
+bob start-session "${ALICE_USER}" >/dev/null
+if OUT="$(bob send --username "${BOB_USER}" "${ALICE_USER}" "hi alice" 2>&1)" \
+  || ! grep -q "peer identity not verified" <<<"${OUT}"; then
+  echo "[-] Bob replied to an unverified peer"
+  echo "${OUT}"
+  exit 1
+fi
+bob send --username "${BOB_USER}" "${ALICE_USER}" "hi alice" --force >/dev/null
+if ! grep -qx "\[${BOB_USER}\] hi alice" <<<"$(alice recv --username "${ALICE_USER}")"; then
+  echo "[-] Alice did not get Bob's forced reply"
+  exit 1
+fi
+
+# Refused sessions are dropped unread; Alice, accepted earlier, is unaffected.
+bob conversations session-policy --accept-new no >/dev/null
+carol start-session "${BOB_USER}" >/dev/null
+carol send --username "${CAROL_USER}" "${BOB_USER}" "hello from carol" >/dev/null
+if OUT="$(bob recv --username "${BOB_USER}" 2>&1)" || ! grep -q "new session refused by policy: 1 envelope(s) dropped" <<<"${OUT}"; then
+  echo "[-] Carol's session was not refused"
+  echo "${OUT}"
+  exit 1
+fi
+if grep -q "${CAROL_USER}" <<<"$(bob quarantine list)"; then
+  echo "[-] Carol's refused message was kept"
+  exit 1
+fi
+
+echo "[+] Session policy held back, refused and accepted sessions, replenished prekeys and required verification."
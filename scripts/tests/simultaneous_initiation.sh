@@ -0,0 +1,117 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-race-alice"
+BOB_HOME="/tmp/bob-ciphera-race-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-simultaneous-initiation.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both peers
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+
+# Both start a session and send before either has received anything, so each
+# side holds its own X3DH handshake with a different root key.
+alice start-session "${BOB_USER}" >/dev/null
+bob start-session "${ALICE_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "a1" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "a2" >/dev/null
+bob send --username "${BOB_USER}" "${ALICE_USER}" "b1" >/dev/null
+bob send --username "${BOB_USER}" "${ALICE_USER}" "b2" >/dev/null
+
+# Each side receives the other's handshake; one handshake wins the tie-break
+# and both must still read every message.
+ALICE_OUT="$(alice recv --username "${ALICE_USER}")"
+BOB_OUT="$(bob recv --username "${BOB_USER}")"
+
+# After the race both sides talk on the surviving handshake.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "a3" >/dev/null
+bob send --username "${BOB_USER}" "${ALICE_USER}" "b3" >/dev/null
+ALICE_OUT+=$'\n'"$(alice recv --username "${ALICE_USER}")"
+BOB_OUT+=$'\n'"$(bob recv --username "${BOB_USER}")"
+
+echo "[*] Alice received:"
+echo "${ALICE_OUT}"
+echo "[*] Bob received:"
+echo "${BOB_OUT}"
+
+for msg in b1 b2 b3; do
+  if ! grep -qx "\[${BOB_USER}\] ${msg}" <<<"${ALICE_OUT}"; then
+    echo "[-] Alice did not receive '${msg}'"
+    exit 1
+  fi
+done
+for msg in a1 a2 a3; do
+  if ! grep -qx "\[${ALICE_USER}\] ${msg}" <<<"${BOB_OUT}"; then
+    echo "[-] Bob did not receive '${msg}'"
+    exit 1
+  fi
+done
+
+# Both sides agree the surviving handshake is confirmed and nothing was
+# quarantined.
+if ! alice sessions | grep -q "^${BOB_USER}[[:space:]]confirmed"; then
+  echo "[-] Alice's session with Bob is not confirmed"
+  exit 1
+fi
+if ! bob sessions | grep -q "^${ALICE_USER}[[:space:]]confirmed"; then
+  echo "[-] Bob's session with Alice is not confirmed"
+  exit 1
+fi
+if [[ "$(alice quarantine list)" != "Quarantine is empty" ]] \
+  || [[ "$(bob quarantine list)" != "Quarantine is empty" ]]; then
+  echo "[-] Envelopes were quarantined during the race"
+  exit 1
+fi
+
+echo "[+] Simultaneous session initiation resolved to one handshake."
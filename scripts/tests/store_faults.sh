@@ -0,0 +1,95 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-store-faults-alice"
+BOB_HOME="/tmp/bob-ciphera-store-faults-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-store-faults.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello bob" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+alice recv --username "${ALICE_USER}" >/dev/null
+
+# A send whose first store write fails posts nothing, and the next send
+# still reaches Bob.
+if OUT="$(alice --store-fail-every 1 send --username "${ALICE_USER}" "${BOB_USER}" "never sent" 2>&1)"; then
+  echo "[-] a send succeeded although its store writes failed"
+  exit 1
+fi
+grep -q "injected store failure" <<<"${OUT}"
+alice send --username "${ALICE_USER}" "${BOB_USER}" "after the failure" >/dev/null
+OUT="$(bob recv --username "${BOB_USER}")"
+if ! grep -q "after the failure" <<<"${OUT}" || grep -q "never sent" <<<"${OUT}"; then
+  echo "[-] Bob should only get the message after the failed send: ${OUT}"
+  exit 1
+fi
+
+# Reads are delayed.
+START="$(date +%s%N)"
+alice --store-read-delay 100ms sessions >/dev/null
+if (( ($(date +%s%N) - START) / 1000000 < 100 )); then
+  echo "[-] store reads were not delayed"
+  exit 1
+fi
+
+# A corrupted encrypted history is refused rather than read as garbage.
+alice --store-corrupt-every 1 send --username "${ALICE_USER}" "${BOB_USER}" "corrupted" >/dev/null
+if OUT="$(alice history 2>&1)"; then
+  echo "[-] a corrupted history was read: ${OUT}"
+  exit 1
+fi
+grep -q "reading history" <<<"${OUT}"
+
+echo "[+] Injected store failures, delays and corruption were handled."
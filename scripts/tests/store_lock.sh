@@ -0,0 +1,78 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+HOME_DIR="/tmp/ciphera-store-lock"
+PEERS=20
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+
+cleanup() {
+  if [[ -n "${HOLDER_PID:-}" ]]; then
+    kill "${HOLDER_PID}" >/dev/null 2>&1 || true
+    wait "${HOLDER_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${HOME_DIR}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+)
+
+# Fresh home
+rm -rf "${HOME_DIR}"
+mkdir -p "${HOME_DIR}"
+
+ciphera() {
+  "${CIPHERA_BIN}" --home "${HOME_DIR}" "$@"
+}
+
+# Concurrent commands each add a peer to preferences.json. Without a lock
+# across processes, some would overwrite the others' updates.
+PIDS=()
+for i in $(seq 1 "${PEERS}"); do
+  ciphera conversations mute "peer${i}" >/dev/null & PIDS+=($!)
+done
+for pid in "${PIDS[@]}"; do
+  if ! wait "${pid}"; then
+    echo "[-] A concurrent mute failed"
+    exit 1
+  fi
+done
+
+COUNT="$(ciphera conversations list | grep -c "^peer")"
+if [[ "${COUNT}" != "${PEERS}" ]]; then
+  echo "[-] Only ${COUNT} of ${PEERS} concurrent updates were kept"
+  ciphera conversations list
+  exit 1
+fi
+
+# A command that cannot get the lock gives up with a clear error.
+if command -v flock >/dev/null 2>&1; then
+  flock "${HOME_DIR}/preferences.json.lock" sleep 30 & HOLDER_PID=$!
+  sleep 0.5
+  START=${SECONDS}
+  if OUT="$(ciphera conversations mute late 2>&1)"; then
+    echo "[-] mute succeeded while another process held the lock"
+    exit 1
+  fi
+  if ! grep -q "store is locked by another ciphera process" <<<"${OUT}"; then
+    echo "[-] Unexpected error while the store was locked"
+    echo "${OUT}"
+    exit 1
+  fi
+  if (( SECONDS - START > 20 )); then
+    echo "[-] The lock wait did not time out"
+    exit 1
+  fi
+fi
+
+echo "[+] Concurrent commands kept every update and a held lock timed out cleanly."
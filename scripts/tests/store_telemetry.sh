@@ -0,0 +1,80 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+HOME_DIR="/tmp/ciphera-store-telemetry"
+PASS="Telemetry-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+
+cleanup() {
+  if [[ -n "${HOLDER_PID:-}" ]]; then
+    kill "${HOLDER_PID}" >/dev/null 2>&1 || true
+    wait "${HOLDER_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${HOME_DIR}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+)
+
+# Fresh home
+rm -rf "${HOME_DIR}"
+mkdir -p "${HOME_DIR}"
+
+ciphera() {
+  "${CIPHERA_BIN}" --home "${HOME_DIR}" --passphrase "${PASS}" "$@"
+}
+
+# Without the flag nothing is measured or printed.
+ciphera init >/dev/null
+if grep -q "Store telemetry" <<<"$(ciphera conversations mute bob 2>&1)"; then
+  echo "[-] Store telemetry was printed without --store-telemetry"
+  exit 1
+fi
+
+# With it, the summary lists each file's reads, writes and lock waits.
+out="$(ciphera --store-telemetry conversations mute carol 2>&1 >/dev/null)"
+for op in read write lock; do
+  if ! grep -Eq "^  ${op} +[0-9]+ .* preferences\.json$" <<<"${out}"; then
+    echo "[-] No ${op} of preferences.json in the store telemetry:"
+    echo "${out}"
+    exit 1
+  fi
+done
+if ! grep -Eq "^  write +1 +[1-9][0-9]* " <<<"${out}"; then
+  echo "[-] The write did not report the file size:"
+  echo "${out}"
+  exit 1
+fi
+
+# With --verbose, each operation is logged as it happens.
+if ! grep -q 'msg="store write" file=preferences.json bytes=' <<<"$(ciphera -v --store-telemetry conversations mute dave 2>&1)"; then
+  echo "[-] Store writes were not logged with --verbose"
+  exit 1
+fi
+
+# Time spent waiting for another process's lock is reported as such.
+if command -v flock >/dev/null 2>&1; then
+  flock "${HOME_DIR}/preferences.json.lock" sleep 1 & HOLDER_PID=$!
+  sleep 0.2
+  out="$(ciphera --store-telemetry conversations mute erin 2>&1 >/dev/null)"
+  wait "${HOLDER_PID}"
+  HOLDER_PID=""
+  if ! grep -Eq "^  lock +[0-9]+ +- +[0-9.]+(ms|s) .* preferences\.json$" <<<"${out}"; then
+    echo "[-] A lock wait behind another process was not reported:"
+    echo "${out}"
+    exit 1
+  fi
+fi
+
+echo "[+] Store telemetry reported reads, writes, sizes and lock waits."
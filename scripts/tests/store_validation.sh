@@ -0,0 +1,97 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-validation-alice"
+BOB_HOME="/tmp/bob-ciphera-validation-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-store-validation.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+
+# A root key one byte short must be refused, not padded with zeros.
+SESSIONS="${ALICE_HOME}/sessions.json"
+cp "${SESSIONS}" "${SESSIONS}.good"
+jq --arg u "${BOB_USER}" '.data[$u].root_key = (.data[$u].root_key[0:40] + "AA==")' \
+  "${SESSIONS}.good" >"${SESSIONS}"
+BEFORE="$(cat "${SESSIONS}")"
+if OUT="$(alice send --username "${ALICE_USER}" "${BOB_USER}" "hi" 2>&1)"; then
+  echo "[-] send worked with a truncated root key"
+  exit 1
+fi
+if ! grep -q "sessions.json: ${BOB_USER}: root_key: must be 32 bytes, got" <<<"${OUT}"; then
+  echo "[-] unexpected error for a truncated root key: ${OUT}"
+  exit 1
+fi
+if [[ "$(cat "${SESSIONS}")" != "${BEFORE}" ]]; then
+  echo "[-] the malformed sessions file was rewritten"
+  exit 1
+fi
+mv "${SESSIONS}.good" "${SESSIONS}"
+
+# A one-time prekey whose public half was swapped is refused too.
+OPKS="${BOB_HOME}/opk_pairs.json"
+jq '(.data | keys[0]) as $id | .data[$id].pub = (.data[$id].pub | map(0))' "${OPKS}" >"${OPKS}.bad"
+mv "${OPKS}.bad" "${OPKS}"
+if OUT="$(bob register "${BOB_USER}" 2>&1)"; then
+  echo "[-] register worked with a corrupt one-time prekey"
+  exit 1
+fi
+if ! grep -q "opk_pairs.json: .*: pub: does not match priv" <<<"${OUT}"; then
+  echo "[-] unexpected error for a corrupt one-time prekey: ${OUT}"
+  exit 1
+fi
+
+# Intact state still works.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello bob" >/dev/null
+echo "[+] Malformed sessions and prekeys were refused with the field at fault."
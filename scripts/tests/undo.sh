@@ -0,0 +1,124 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-undo-alice"
+BOB_HOME="/tmp/bob-ciphera-undo-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-undo.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+spk() {
+  curl -s "${RELAY_URL}/prekey/${ALICE_USER}" | sed -n 's/.*"spk_id":"\([^"]*\)".*/\1/p'
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null 2>&1
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null 2>&1
+
+# A first registration replaced no signed prekey, so there is nothing to undo.
+if alice undo >/dev/null 2>&1; then
+  echo "[-] undo reverted the first registration"
+  exit 1
+fi
+
+# Undoing a register republishes the signed prekey it replaced.
+FIRST_SPK="$(spk)"
+HINT="$(alice register "${ALICE_USER}" 2>&1 >/dev/null)"
+if ! grep -q "ciphera undo" <<<"${HINT}"; then
+  echo "[-] register gave no undo hint"
+  exit 1
+fi
+SECOND_SPK="$(spk)"
+if [[ -z "${FIRST_SPK}" || "${FIRST_SPK}" == "${SECOND_SPK}" ]]; then
+  echo "[-] register did not publish a new signed prekey"
+  exit 1
+fi
+alice undo >/dev/null
+if [[ "$(spk)" != "${FIRST_SPK}" ]]; then
+  echo "[-] undo did not republish the earlier signed prekey"
+  exit 1
+fi
+
+# Archive, then change the filters, then rotate the signing key.
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello bob" >/dev/null
+alice conversations archive "${BOB_USER}" >/dev/null 2>&1
+alice conversations filters --max-size 100 >/dev/null 2>&1
+alice rotate-signing-key >/dev/null 2>&1
+
+LIST="$(alice undo --list)"
+if ! grep -q "rotate-signing-key.*not undoable" <<<"${LIST}"; then
+  echo "[-] undo --list does not mark the signing key rotation irreversible"
+  echo "${LIST}"
+  exit 1
+fi
+
+# The rotation is passed over; the filters come back first.
+alice undo | grep -q "receive filters" || { echo "[-] undo did not restore the filters"; exit 1; }
+if ! alice conversations filters | grep -q "off"; then
+  echo "[-] receive filters still set after undo"
+  exit 1
+fi
+
+# Then the archive.
+alice undo | grep -q "archive conversation with ${BOB_USER}" || { echo "[-] undo did not unarchive"; exit 1; }
+if alice conversations archived | grep -q "${BOB_USER}"; then
+  echo "[-] conversation still archived after undo"
+  exit 1
+fi
+alice send --username "${ALICE_USER}" "${BOB_USER}" "back again" >/dev/null
+
+# The rotation replaced the undone register's prekey, so nothing is left.
+if alice undo >/dev/null 2>&1; then
+  echo "[-] undo found something left to revert"
+  exit 1
+fi
+
+echo "[+] Undo test passed"
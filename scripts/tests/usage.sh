@@ -0,0 +1,98 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-usage-alice"
+BOB_HOME="/tmp/bob-ciphera-usage-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+ADMIN_TOKEN="admin-token-for-tests"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-usage.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+RELAY_ADMIN_TOKEN="${ADMIN_TOKEN}" "${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "hello bob" >/dev/null
+alice send --username "${ALICE_USER}" "${BOB_USER}" "again" >/dev/null
+bob recv --username "${BOB_USER}" >/dev/null
+
+MONTH="$(date -u +%Y-%m)"
+OUT="$(alice usage --username "${ALICE_USER}")"
+if ! grep -q "^${MONTH}.*(2 envelopes).*out=0 bytes (0 envelopes)" <<<"${OUT}"; then
+  echo "[-] Alice's usage should count two envelopes in and none out: ${OUT}"
+  exit 1
+fi
+OUT="$(bob usage --username "${BOB_USER}")"
+if ! grep -q "^${MONTH}.*out=[1-9][0-9]* bytes (2 envelopes)" <<<"${OUT}"; then
+  echo "[-] Bob's usage should count two envelopes out: ${OUT}"
+  exit 1
+fi
+
+# Only the account's own signing key can read its usage.
+STATUS="$(curl -s -o /dev/null -w '%{http_code}' "${RELAY_URL}/account/${ALICE_USER}/usage")"
+if [[ "${STATUS}" != "401" ]]; then
+  echo "[-] unsigned usage request got ${STATUS}, want 401"
+  exit 1
+fi
+if "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" \
+  usage --username "${ALICE_USER}" >/dev/null 2>&1; then
+  echo "[-] Bob read Alice's usage"
+  exit 1
+fi
+
+OUT="$(curl -s -H "Authorization: Bearer ${ADMIN_TOKEN}" "${RELAY_URL}/admin/usage?month=${MONTH}")"
+for user in "${ALICE_USER}" "${BOB_USER}"; do
+  if ! grep -q "\"user\":\"${user}\"" <<<"${OUT}"; then
+    echo "[-] admin usage is missing ${user}: ${OUT}"
+    exit 1
+  fi
+done
+
+echo "[+] Relay usage was counted per account and reported only to its owner and the operator."
@@ -0,0 +1,76 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-version-alice"
+ALICE_PASS="Alice-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-version.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/pversion/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}"
+mkdir -p "${ALICE_HOME}"
+
+# Run ciphera as Alice
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+
+# Both binaries report their build and the protocols they speak.
+OUT="$("${RELAY_BIN}" --version)"
+if ! grep -q "^relay " <<<"${OUT}" || ! grep -q "relay-api v1" <<<"${OUT}"; then
+  echo "[-] relay --version is missing build or protocol information"
+  echo "${OUT}"
+  exit 1
+fi
+OUT="$(alice version)"
+if ! grep -q "^ciphera " <<<"${OUT}" || ! grep -q "x3dh v1" <<<"${OUT}" || ! grep -q "double-ratchet v1" <<<"${OUT}"; then
+  echo "[-] ciphera version is missing build or protocol information"
+  echo "${OUT}"
+  exit 1
+fi
+
+# The relay serves the same information for compatibility checks.
+if ! grep -q '"relay-api":1' <<<"$(curl -s "${RELAY_URL}/server-info")"; then
+  echo "[-] GET /server-info does not list the relay API version"
+  exit 1
+fi
+OUT="$(alice version --server 2>&1)"
+if ! grep -q "^relay " <<<"${OUT}" || grep -q "^warning:" <<<"${OUT}"; then
+  echo "[-] ciphera version --server did not show a compatible relay"
+  echo "${OUT}"
+  exit 1
+fi
+
+echo "[+] Client and relay report their versions and agree on protocols."
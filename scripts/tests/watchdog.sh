@@ -0,0 +1,192 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+# With a watchdog policy set, recv --follow warns about a conversation whose
+# peer has not taken a ratchet step for too many of our messages, and stops
+# once the peer answers. Sends the relay never accepted do not count.
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-watchdog-alice"
+BOB_HOME="/tmp/bob-ciphera-watchdog-bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+ALICE_OUT="/tmp/ciphera-watchdog-alice.out"
+ALICE_ERR="/tmp/ciphera-watchdog-alice.err"
+RELAY_DATA="/tmp/ciphera-watchdog-relay"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-watchdog.log"
+
+cleanup() {
+  if [[ -n "${FOLLOW_PID:-}" ]]; then
+    kill "${FOLLOW_PID}" >/dev/null 2>&1 || true
+    wait "${FOLLOW_PID}" >/dev/null 2>&1 || true
+  fi
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${ALICE_OUT}" "${ALICE_ERR}" "${RELAY_DATA}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# The relay keeps its state on disk so it can be stopped and started again.
+start_relay() {
+  "${RELAY_BIN}" --data-dir "${RELAY_DATA}" >>"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+  for _ in {1..50}; do
+    curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+    sleep 0.1
+  done
+}
+stop_relay() {
+  kill "${RELAY_PID}"
+  wait "${RELAY_PID}" || true
+  RELAY_PID=""
+}
+
+rm -rf "${RELAY_DATA}"
+: >"${RELAY_LOG}"
+start_relay
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# follow runs Alice's recv --follow in the background until stop_follow.
+follow() {
+  : >"${ALICE_OUT}"
+  : >"${ALICE_ERR}"
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" \
+    --verbose recv --username alice --follow --max-interval 1s \
+    >"${ALICE_OUT}" 2>"${ALICE_ERR}" & FOLLOW_PID=$!
+}
+stop_follow() {
+  kill -INT "${FOLLOW_PID}"
+  wait "${FOLLOW_PID}" || true
+  FOLLOW_PID=""
+}
+wait_for() {
+  for _ in {1..100}; do
+    grep -q "$1" "${ALICE_ERR}" && return 0
+    sleep 0.1
+  done
+  return 1
+}
+
+alice init >/dev/null
+alice register alice >/dev/null
+bob init >/dev/null
+bob register bob >/dev/null
+
+# The watchdog is off by default and takes its limits from flags.
+if [[ "$(alice conversations watchdog)" != "Watchdog: off" ]]; then
+  echo "[-] The watchdog is not off by default"
+  exit 1
+fi
+if alice conversations watchdog off --messages 3 >/dev/null 2>&1; then
+  echo "[-] off was accepted together with --messages"
+  exit 1
+fi
+if alice conversations watchdog --messages -1 >/dev/null 2>&1; then
+  echo "[-] A negative limit was accepted"
+  exit 1
+fi
+if [[ "$(alice conversations watchdog --messages 3)" != "Watchdog: days=0 messages=3" ]]; then
+  echo "[-] The watchdog policy was not set"
+  exit 1
+fi
+
+# Bob does not read, so Alice's messages go unanswered. The third fails
+# while the relay is down and is not counted.
+alice start-session bob >/dev/null
+for i in $(seq 1 2); do
+  alice send -u alice bob "hello ${i}" >/dev/null
+done
+stop_relay
+if alice send -u alice bob "lost" >/dev/null 2>&1; then
+  echo "[-] A send succeeded with the relay down"
+  exit 1
+fi
+start_relay
+
+follow
+if ! wait_for "follow pacing"; then
+  echo "[-] recv --follow did not run a round"
+  cat "${ALICE_ERR}"
+  exit 1
+fi
+stop_follow
+if grep -q "has not answered" "${ALICE_ERR}"; then
+  echo "[-] The watchdog counted a send the relay never accepted"
+  cat "${ALICE_ERR}"
+  exit 1
+fi
+
+alice send -u alice bob "hello 3" >/dev/null
+follow
+if ! wait_for "Warning: bob has not answered your last 3 message(s)"; then
+  echo "[-] recv --follow did not warn about the unanswered conversation"
+  cat "${ALICE_ERR}"
+  exit 1
+fi
+if ! grep -q "start-session --reset bob" "${ALICE_ERR}"; then
+  echo "[-] The warning did not suggest resetting the session"
+  cat "${ALICE_ERR}"
+  exit 1
+fi
+if grep -q "conversations rekey" "${ALICE_ERR}"; then
+  echo "[-] The warning suggested changing the global rekey policy"
+  cat "${ALICE_ERR}"
+  exit 1
+fi
+if ! grep -q "conversation may be stuck.*peer=bob.*unanswered=3" "${ALICE_ERR}"; then
+  echo "[-] The warning was not logged"
+  cat "${ALICE_ERR}"
+  exit 1
+fi
+stop_follow
+
+# Bob reads and replies, which takes a ratchet step; the warning stops.
+bob recv -u bob >/dev/null
+bob start-session alice >/dev/null
+bob send -u bob alice "sorry, was away" >/dev/null
+follow
+if ! wait_for "follow pacing.*processed=0"; then
+  echo "[-] recv --follow did not run a round after Bob's reply"
+  cat "${ALICE_ERR}"
+  exit 1
+fi
+stop_follow
+if ! grep -q "sorry, was away" "${ALICE_OUT}"; then
+  echo "[-] Alice did not receive Bob's reply"
+  exit 1
+fi
+if grep -q "has not answered" "${ALICE_ERR}"; then
+  echo "[-] The watchdog still warned after Bob answered"
+  cat "${ALICE_ERR}"
+  exit 1
+fi
+
+echo "[+] recv --follow warned about an unanswered conversation until the peer replied."
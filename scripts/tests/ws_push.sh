@@ -0,0 +1,130 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+RELAY_URL="http://127.0.0.1:8080"
+ALICE_HOME="/tmp/alice-ciphera-ws-push-alice"
+BOB_HOME="/tmp/bob-ciphera-ws-push-bob"
+ALICE_USER="alice"
+BOB_USER="bob"
+ALICE_PASS="Alice-pass1234"
+BOB_PASS="Bob-pass1234"
+
+ROOT_DIR="$(
+  git -C "$(dirname "${BASH_SOURCE[0]}")" rev-parse --show-toplevel 2>/dev/null \
+    || (cd "$(dirname "${BASH_SOURCE[0]}")/../.." && pwd -P)
+)"
+BIN_DIR="${ROOT_DIR}/bin"
+CIPHERA_BIN="${BIN_DIR}/ciphera"
+RELAY_BIN="${BIN_DIR}/relay"
+RELAY_LOG="/tmp/ciphera-relay-test-ws-push.log"
+
+cleanup() {
+  if [[ -n "${RELAY_PID:-}" ]]; then
+    kill "${RELAY_PID}" >/dev/null 2>&1 || true
+    wait "${RELAY_PID}" >/dev/null 2>&1 || true
+  fi
+  if [[ -n "${FOLLOW_PID:-}" ]]; then
+    kill "${FOLLOW_PID}" >/dev/null 2>&1 || true
+  fi
+  rm -rf "${ALICE_HOME}" "${BOB_HOME}" "${BOB_OUT}" "${BOB_ERR}"
+}
+trap cleanup EXIT
+
+# Build
+mkdir -p "${BIN_DIR}"
+(
+  cd "${ROOT_DIR}"
+  go build -o "${CIPHERA_BIN}" ./cmd/ciphera
+  go build -o "${RELAY_BIN}"   ./cmd/relay
+)
+
+# Start relay
+"${RELAY_BIN}" --log >"${RELAY_LOG}" 2>&1 & RELAY_PID=$!
+for _ in {1..50}; do
+  curl -s "${RELAY_URL}/prekey/does-not-exist" >/dev/null 2>&1 && break
+  sleep 0.1
+done
+
+# Fresh homes
+rm -rf "${ALICE_HOME}" "${BOB_HOME}"
+mkdir -p "${ALICE_HOME}" "${BOB_HOME}"
+
+# Run ciphera as Alice or Bob
+alice() {
+  "${CIPHERA_BIN}" --home "${ALICE_HOME}" --relay "${RELAY_URL}" --passphrase "${ALICE_PASS}" "$@"
+}
+bob() {
+  "${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" "$@"
+}
+
+# Initialise and register both; Alice starts the session.
+alice init >/dev/null
+alice register "${ALICE_USER}" >/dev/null
+bob init >/dev/null
+bob register "${BOB_USER}" >/dev/null
+alice start-session "${BOB_USER}" >/dev/null
+
+BOB_OUT="/tmp/ciphera-ws-push-bob.out"
+BOB_ERR="/tmp/ciphera-ws-push-bob.err"
+
+# One message is queued before Bob starts following; push only carries what
+# arrives later, so this one must come from a fetch.
+alice send --username "${ALICE_USER}" "${BOB_USER}" "queued before" >/dev/null
+
+# Polls are 20 seconds apart, so anything arriving sooner was pushed.
+"${CIPHERA_BIN}" --home "${BOB_HOME}" --relay "${RELAY_URL}" --passphrase "${BOB_PASS}" \
+  --verbose recv --username "${BOB_USER}" --follow \
+  --min-interval 20s --max-interval 20s \
+  >"${BOB_OUT}" 2>"${BOB_ERR}" & FOLLOW_PID=$!
+
+wait_for() {
+  for _ in $(seq 1 "$2"); do
+    grep -q "$1" "${BOB_OUT}" && return 0
+    sleep 0.1
+  done
+  return 1
+}
+
+if ! wait_for "queued before" 50; then
+  echo "[-] Bob did not fetch the message queued before following"
+  cat "${BOB_OUT}" "${BOB_ERR}"
+  exit 1
+fi
+if ! grep -q "push connected" "${BOB_ERR}"; then
+  echo "[-] recv --follow did not open a push connection"
+  cat "${BOB_ERR}"
+  exit 1
+fi
+
+for i in 1 2 3; do
+  alice send --username "${ALICE_USER}" "${BOB_USER}" "pushed ${i}" >/dev/null
+done
+if ! wait_for "pushed 3" 50; then
+  echo "[-] Pushed messages did not arrive before the next poll"
+  cat "${BOB_OUT}" "${BOB_ERR}"
+  exit 1
+fi
+if [[ "$(grep -c "pushed\|queued before" "${BOB_OUT}")" != "4" ]]; then
+  echo "[-] Bob did not receive every message exactly once"
+  cat "${BOB_OUT}"
+  exit 1
+fi
+
+# Pushed envelopes were acked: nothing is left in the queue.
+if [[ "$(curl -s "${RELAY_URL}/msg/${BOB_USER}")" != "[]" ]]; then
+  echo "[-] Pushed envelopes were left queued"
+  exit 1
+fi
+if ! grep -q "push_open" "${RELAY_LOG}"; then
+  echo "[-] The relay did not log the push connection"
+  exit 1
+fi
+
+# Ctrl-C stops following cleanly.
+kill -INT "${FOLLOW_PID}"
+if ! wait "${FOLLOW_PID}"; then
+  echo "[-] recv --follow did not exit cleanly on interrupt"
+  exit 1
+fi
+
+echo "[+] recv --follow received pushed messages at once and acked them."